@@ -45,6 +45,12 @@ const config = {
         output: "make",
     },
     asarUnpack: ["bin/**/*"],
+    protocols: [
+        {
+            name: "Wave Deep Link",
+            schemes: ["waveterm"], // must match DeepLinkScheme in emain.ts
+        },
+    ],
     mac: {
         target: [
             {
@@ -50,6 +50,12 @@ export const DefaultSudoPwStore = "on";
 export const DefaultSudoPwTimeoutMs = 5 * 60 * 1000;
 
 export const MaxWebSocketSendSize = 64 * 1024 - 100;
+// the version/capability handshake (see wavesrv scws/compat.go)
+export const ProtocolVersion = 1;
+export const MinServerProtocolVersion = 0;
+export const ClientCapabilities = ["binarypty", "remotemetrics"];
+
+export const MaxRemoteMetricsSamples = 720; // same as the server (remote.MetricsRingSize)
 
 // @ts-ignore
 export const VERSION = __WAVETERM_VERSION__;
@@ -68,6 +74,7 @@ export enum StatusIndicatorLevel {
 
 // matches packet.go
 export const ErrorCode_InvalidCwd = "ERRCWD";
+export const ErrorCode_ReadOnly = "ERRREADONLY";
 
 export const InputAuxView_History = "history";
 export const InputAuxView_Info = "info";
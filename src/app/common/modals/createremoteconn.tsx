@@ -353,6 +353,8 @@ class CreateRemoteConnModal extends React.Component<{}, {}> {
                                 { value: "detect", label: "detect" },
                                 { value: "bash", label: "bash" },
                                 { value: "zsh", label: "zsh" },
+                                { value: "pwsh", label: "pwsh" },
+                                { value: "fish", label: "fish" },
                             ]}
                             value={this.tempShellPref.get()}
                             onChange={(val: string) => {
@@ -252,6 +252,8 @@ class EditRemoteConnModal extends React.Component<{}, {}> {
                         { value: "detect", label: "detect" },
                         { value: "bash", label: "bash" },
                         { value: "zsh", label: "zsh" },
+                        { value: "pwsh", label: "pwsh" },
+                        { value: "fish", label: "fish" },
                     ]}
                     value={this.tempShellPref.get()}
                     onChange={this.handleChangeShellPref}
@@ -260,7 +260,11 @@ class ViewRemoteConnDetailModal extends React.Component<{}, {}> {
         if (remote.status == "connected") {
             message = "Connected and ready to run commands.";
         } else if (remote.status == "connecting") {
-            message = remote.waitingforpassword ? "Connecting, waiting for user-input..." : "Connecting...";
+            if (remote.preconnectstatus) {
+                message = "Connecting, pre-connect hook: " + remote.preconnectstatus + "...";
+            } else {
+                message = remote.waitingforpassword ? "Connecting, waiting for user-input..." : "Connecting...";
+            }
             if (remote.countdownactive) {
                 let connectTimeout = remote.connecttimeout ?? 0;
                 message = message + " (" + connectTimeout + "s)";
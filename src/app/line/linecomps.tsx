@@ -133,6 +133,20 @@ class LineActions extends React.Component<{ screen: LineContainerType; line: Lin
         }
     }
 
+    @boundMethod
+    clickExplainError(e: any) {
+        e.stopPropagation();
+        const { line } = this.props;
+        GlobalCommandRunner.lineAIExplain(line.lineid, true);
+    }
+
+    @boundMethod
+    clickAttachToChat(e: any) {
+        e.stopPropagation();
+        const { line } = this.props;
+        GlobalCommandRunner.lineAIAttach(line.lineid, true);
+    }
+
     @boundMethod
     clickMinimize() {
         const { line } = this.props;
@@ -173,6 +187,9 @@ class LineActions extends React.Component<{ screen: LineContainerType; line: Lin
         const { line, screen } = this.props;
         const isMinimized = line.linestate["wave:min"];
         const containerType = screen.getContainerType();
+        const cmd = screen.getCmd(line);
+        const showExplainError =
+            GlobalModel.clientData.get()?.clientopts?.aiexplainerrors && cmd != null && cmdShouldMarkError(cmd);
         // console.log("******************", screen.getTermWrap(line.lineid));
         return (
             <div className="line-actions">
@@ -181,6 +198,24 @@ class LineActions extends React.Component<{ screen: LineContainerType; line: Lin
                         <div key="chat" title="Ask Wave AI" className="line-icon" onClick={this.clickChat}>
                             <i className="fa-sharp fa-regular fa-sparkles fa-fw" />
                         </div>
+                        <If condition={showExplainError}>
+                            <div
+                                key="explain"
+                                title="Explain this Error"
+                                className="line-icon"
+                                onClick={this.clickExplainError}
+                            >
+                                <i className="fa-sharp fa-regular fa-circle-question fa-fw" />
+                            </div>
+                        </If>
+                        <div
+                            key="attach"
+                            title="Attach Output to AI Chat"
+                            className="line-icon"
+                            onClick={this.clickAttachToChat}
+                        >
+                            <i className="fa-sharp fa-regular fa-paperclip fa-fw" />
+                        </div>
                         <div key="restart" title="Restart Command" className="line-icon" onClick={this.clickRestart}>
                             <i className="fa-sharp fa-regular fa-arrows-rotate fa-fw" />
                         </div>
@@ -264,6 +299,13 @@ class LineActions extends React.Component<{ screen: LineContainerType; line: Lin
 
 @mobxReact.observer
 class LineHeader extends React.Component<{ screen: LineContainerType; line: LineType; cmd: Cmd }, {}> {
+    @boundMethod
+    clickDownloadRaw(e: any): void {
+        const { line } = this.props;
+        e.stopPropagation();
+        GlobalModel.downloadRawOutput(line.screenid, line.lineid);
+    }
+
     renderCmdText(cmd: Cmd): any {
         if (cmd == null) {
             return (
@@ -303,6 +345,12 @@ class LineHeader extends React.Component<{ screen: LineContainerType; line: Line
         }
         const renderer = line.renderer;
         const durationMs = cmd.getDurationMs();
+        const usage = cmd.getUsage();
+        const idleKillTs: number = line.linestate["wave:idlekillts"];
+        const idleKilled: boolean = line.linestate["wave:idlekilled"];
+        const binaryOut: boolean = line.linestate["wave:binaryout"];
+        const binaryMime: string = line.linestate["wave:binarymime"];
+        const binaryThumb: boolean = line.linestate["wave:binarythumb"];
         return (
             <div key="meta1" className="meta meta-line1">
                 <SmallLineAvatar line={line} cmd={cmd} />
@@ -312,6 +360,15 @@ class LineHeader extends React.Component<{ screen: LineContainerType; line: Line
                 <div title={timeTitle} className="ts">
                     {formattedTime} <If condition={durationMs > 0}>({util.formatDuration(durationMs)})</If>
                 </div>
+                <If condition={usage != null}>
+                    <div className="meta-divider">|</div>
+                    <div
+                        className="usage"
+                        title={`cpu ${usage?.usertimems}ms user, ${usage?.systimems}ms sys, max rss ${usage?.maxrsskb}KB, block io ${usage?.inblock} in, ${usage?.outblock} out`}
+                    >
+                        cpu {util.formatDuration(usage?.usertimems + usage?.systimems)}, {util.formatKB(usage?.maxrsskb)}
+                    </div>
+                </If>
                 <If condition={!isBlank(renderer) && renderer != "terminal"}>
                     <div className="meta-divider">|</div>
                     <div className="renderer">
@@ -319,6 +376,25 @@ class LineHeader extends React.Component<{ screen: LineContainerType; line: Line
                         {renderer}
                     </div>
                 </If>
+                <If condition={idleKilled || (idleKillTs != null && cmd.isRunning())}>
+                    <div className="meta-divider">|</div>
+                    <div className="idlekill" title="idle-kill policy (screen or remote idlekill)">
+                        <i className="fa-sharp fa-regular fa-hourglass-end" />
+                        {idleKilled ? " killed (idle)" : " idle, will be killed @ " + lineutil.getLineDateTimeStr(idleKillTs)}
+                    </div>
+                </If>
+                <If condition={binaryOut}>
+                    <div className="meta-divider">|</div>
+                    <div className="binaryout" title="download the raw output" onClick={this.clickDownloadRaw}>
+                        <If condition={binaryThumb}>
+                            <RawOutThumbnail key={line.lineid} line={line} />
+                        </If>
+                        <i className="fa-sharp fa-regular fa-download" /> binary output
+                        <If condition={!isBlank(binaryMime) && binaryMime != "application/octet-stream"}>
+                            {" "}({binaryMime})
+                        </If>
+                    </div>
+                </If>
             </div>
         );
     }
@@ -335,6 +411,33 @@ class LineHeader extends React.Component<{ screen: LineContainerType; line: Line
     }
 }
 
+class RawOutThumbnail extends React.Component<{ line: LineType }, { thumbUrl: string }> {
+    constructor(props) {
+        super(props);
+        this.state = { thumbUrl: null };
+    }
+
+    componentDidMount(): void {
+        const { line } = this.props;
+        GlobalModel.getRawOutputThumbnailUrl(line.screenid, line.lineid)
+            .then((thumbUrl) => this.setState({ thumbUrl }))
+            .catch((e) => console.log("error loading raw output thumbnail", e));
+    }
+
+    componentWillUnmount(): void {
+        if (this.state.thumbUrl != null) {
+            URL.revokeObjectURL(this.state.thumbUrl);
+        }
+    }
+
+    render() {
+        if (this.state.thumbUrl == null) {
+            return null;
+        }
+        return <img className="binaryout-thumb" src={this.state.thumbUrl} />;
+    }
+}
+
 @mobxReact.observer
 class SmallLineAvatar extends React.Component<{ line: LineType; cmd: Cmd; onRightClick?: (e: any) => void }, {}> {
     render() {
@@ -941,7 +1044,7 @@ class Line extends React.Component<
         if (line.archived) {
             return null;
         }
-        if (line.linetype == "text") {
+        if (line.linetype == "text" || line.linetype == "system") {
             return <LineText {...this.props} />;
         }
         if (line.linetype == "cmd" || line.linetype == "openai") {
@@ -993,7 +1096,10 @@ class LineText extends React.Component<
                 name: "computed-isSelected",
             })
             .get();
-        const mainClass = clsx("line", "line-text", "focus-parent", { selected: isSelected });
+        const mainClass = clsx("line", "line-text", "focus-parent", {
+            selected: isSelected,
+            "line-system": line.linetype == "system",
+        });
         return (
             <div
                 className={mainClass}
@@ -454,8 +454,9 @@ class LinesView extends React.Component<
             }
         }
         let lineElements: any = [];
-        let todayStr = util.getTodayStr();
-        let yesterdayStr = util.getYesterdayStr();
+        let clientTz = GlobalModel.clientData.get()?.clientopts?.timezone;
+        let todayStr = util.getTodayStr(clientTz);
+        let yesterdayStr = util.getYesterdayStr(clientTz);
         let prevDateStr: string = null;
         let anchor = this.getAnchor();
         let startIdx = util.boundInt(anchor.anchorIndex - 50, 0, lines.length - 1);
@@ -465,7 +466,7 @@ class LinesView extends React.Component<
             let line = lines[idx];
             let lineNumStr = String(line.linenum);
             let dateSepStr = null;
-            let curDateStr = lineutil.getLineDateStr(todayStr, yesterdayStr, line.ts);
+            let curDateStr = lineutil.getLineDateStr(todayStr, yesterdayStr, line.ts, (line as LineType).daystr);
             if (curDateStr != prevDateStr) {
                 dateSepStr = curDateStr;
             }
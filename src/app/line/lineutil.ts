@@ -14,9 +14,12 @@ function getRendererType(line: LineType | WebLine): "terminal" | "plugin" {
     return "plugin";
 }
 
-function getLineDateStr(todayDate: string, yesterdayDate: string, ts: number): string {
-    let lineDate = new Date(ts);
-    let dateStr = getDateStr(lineDate);
+// dayStr is computed by the server in the client timezone (line.daystr), falls back to the local date
+function getLineDateStr(todayDate: string, yesterdayDate: string, ts: number, dayStr?: string): string {
+    let dateStr = dayStr;
+    if (isBlank(dateStr)) {
+        dateStr = getDateStr(new Date(ts));
+    }
     if (dateStr == todayDate) {
         return "today";
     }
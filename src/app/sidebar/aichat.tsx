@@ -159,6 +159,7 @@ class ChatWindow extends React.Component<
     render() {
         const { onSetCmdInputValue } = this.props;
         const chatMessageItems = GlobalModel.inputModel.AICmdInfoChatItems.slice();
+        const attachments = GlobalModel.inputModel.AICmdInfoAttachments.slice();
         const chitem: OpenAICmdInfoChatMessageType = null;
         let idx;
         return (
@@ -534,6 +535,24 @@ class ChatSidebar extends React.Component<{}, {}> {
                         onSetCmdInputValue={this.onSetCmdInputValue}
                     />
                 )}
+                <If condition={attachments.length > 0}>
+                    <div className="sidebarchat-attachments">
+                        <For each="attachment" of={attachments}>
+                            <div
+                                key={attachment.lineid}
+                                className="sidebarchat-attachment"
+                                title={`output of line ${attachment.linenum} is sent with the chat`}
+                            >
+                                <i className="fa-sharp fa-regular fa-paperclip" />
+                                <span className="attachment-cmd">{attachment.cmdstr}</span>
+                                <i
+                                    className="fa-sharp fa-regular fa-xmark attachment-remove"
+                                    onClick={() => GlobalCommandRunner.lineAIDetach(attachment.lineid)}
+                                />
+                            </div>
+                        </For>
+                    </div>
+                </If>
                 <div className="sidebarchat-input-wrapper">
                     <textarea
                         key="sidebarchat"
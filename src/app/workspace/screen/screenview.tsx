@@ -525,6 +525,10 @@ class ScreenWindowView extends React.Component<ScreenWindowViewProps, {}> {
         if (screen.filterRunning.get()) {
             return win.getRunningCmdLines();
         }
+        const activeFilter = screen.viewOpts.get()?.activefilter;
+        if (activeFilter != null) {
+            return win.getTagFilteredLines(activeFilter);
+        }
         return win.getNonArchivedLines();
     }
 
@@ -536,6 +540,11 @@ class ScreenWindowView extends React.Component<ScreenWindowViewProps, {}> {
         })();
     }
 
+    @boundMethod
+    clearTagFilter() {
+        GlobalCommandRunner.screenClearFilter();
+    }
+
     render() {
         const { session, screen, width } = this.props;
         const win = this.getScreenLines();
@@ -553,6 +562,7 @@ class ScreenWindowView extends React.Component<ScreenWindowViewProps, {}> {
             return this.renderError("loading client data", true);
         }
         const lines = this.determineVisibleLines(win);
+        const activeFilter = screen.viewOpts.get()?.activefilter;
         const renderMode = this.renderMode.get();
         return (
             <div className="window-view" ref={this.windowViewRef} style={{ width }}>
@@ -586,6 +596,15 @@ class ScreenWindowView extends React.Component<ScreenWindowViewProps, {}> {
                         </div>
                     </div>
                 </If>
+                <If condition={!screen.filterRunning.get() && activeFilter != null}>
+                    <div className="filter-running">
+                        <div className="filter-mask" />
+                        <div className="filter-content" onClick={this.clearTagFilter}>
+                            Showing {activeFilter?.name ? `"${activeFilter.name}"` : "Tagged Lines"} &nbsp;
+                            <i className="fa-sharp fa-solid fa-xmark-large" />
+                        </div>
+                    </div>
+                </If>
             </div>
         );
     }
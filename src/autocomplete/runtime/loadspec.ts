@@ -12,6 +12,7 @@ import speclist, {
 } from "@withfig/autocomplete/build/index";
 import log from "../utils/log";
 import { buildExecuteShellCommand, mergeSubcomands } from "./utils";
+import { getUserSpec } from "./userspecs";
 
 const specSet: Record<string, string> = {};
 
@@ -41,6 +42,11 @@ export const loadSpec = async (specName: string, entries: string[]): Promise<Fig
 
         let spec: any;
 
+        const userSpec = await getUserSpec(specName);
+        if (userSpec) {
+            log.debug("user spec found");
+            return userSpec;
+        }
         if (loadedSpecs[specName]) {
             log.debug("loaded spec found");
             return loadedSpecs[specName];
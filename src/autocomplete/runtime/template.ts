@@ -43,36 +43,67 @@ export const getFileCompletionSuggestions = async (
     }
 };
 
-const historyTemplate = (): Fig.TemplateSuggestion[] => {
-    const inputModel = GlobalModel.inputModel;
-    const cmdLine = inputModel.curLine;
-    inputModel.loadHistory(false, 0, "session");
-    const hitems = GlobalModel.inputModel.filteredHistoryItems;
-    if (hitems.length > 0) {
-        const hmap: Map<string, Fig.TemplateSuggestion> = new Map();
-        hitems.forEach((h) => {
-            const cmdstr = h.cmdstr.trim();
-            if (cmdstr.startsWith(cmdLine)) {
-                if (hmap.has(cmdstr)) {
-                    hmap.get(cmdstr).priority += 1;
-                } else {
-                    hmap.set(cmdstr, {
-                        name: cmdstr,
-                        priority: 90,
-                        context: {
-                            templateType: "history",
-                        },
-                        icon: "🕒",
-                        type: "special",
-                    });
-                }
-            }
-        });
-        const ret = Array.from(hmap.values());
-        log.debug("historyTemplate ret", ret);
-        return ret;
+/**
+ * Retrieves the most frecently visited directories on the active remote machine (best match first).
+ * @param prefix Only directories matching the prefix are returned (matches the start of any path component).
+ * @returns The directories formatted as folder suggestions.
+ */
+export const getFrecentDirSuggestions = async (prefix: string): Promise<Fig.TemplateSuggestion[]> => {
+    const crtn = await GlobalModel.submitCommand("_suggestdirs", null, [prefix ?? ""], null, false, false);
+    const comps: string[] = crtn?.update?.data?.[0]?.info?.infocomps;
+    if (comps == null || comps.length === 0 || comps[0] === "(no completions)") {
+        return [];
     }
-    return [];
+    return comps.map((dir: string, idx: number) => {
+        return {
+            name: dir,
+            displayName: dir,
+            priority: Math.max(70 - idx, 56),
+            context: { templateType: "folders" },
+            type: "folder",
+        };
+    });
+};
+
+/**
+ * Retrieves ranked suggestions for the command line from the wavesrv completion providers (see newton/newton.go).
+ * @param cmdLine The command line typed so far.
+ * @param providers The providers to ask (all providers if not set).
+ * @returns The suggestions, best first.
+ */
+export const getServerSuggestions = async (cmdLine: string, providers?: string[]): Promise<SuggestionType[]> => {
+    const kwargs = providers ? { providers: providers.join(",") } : null;
+    const crtn = await GlobalModel.submitCommand("_suggest", null, [cmdLine ?? ""], kwargs, false, false);
+    return crtn?.update?.data?.[0]?.suggestions?.suggestions ?? [];
+};
+
+/**
+ * Retrieves the best matching commands from the history of the active remote machine (the history completion
+ * provider, see history/suggest.go). Commands run in the current directory are ranked higher, and the prefix also
+ * matches as a fuzzy subsequence.
+ * @param prefix The command line typed so far.
+ * @returns The commands formatted as history suggestions, best match first.
+ */
+export const getHistorySuggestions = async (prefix: string): Promise<Fig.TemplateSuggestion[]> => {
+    const sugs = await getServerSuggestions(prefix, ["history"]);
+    return sugs.map((sug: SuggestionType) => {
+        return {
+            name: sug.name,
+            description: sug.description,
+            priority: sug.score,
+            context: {
+                templateType: "history",
+            },
+            icon: "🕒",
+            type: "special",
+        };
+    });
+};
+
+const historyTemplate = async (): Promise<Fig.TemplateSuggestion[]> => {
+    const ret = await getHistorySuggestions(GlobalModel.inputModel.curLine);
+    log.debug("historyTemplate ret", ret);
+    return ret;
 };
 
 // TODO: implement help template
@@ -94,9 +125,12 @@ export const runTemplates = async (
                         case "filepaths":
                             return await getFileCompletionSuggestions(cwd, "filepaths");
                         case "folders":
-                            return await getFileCompletionSuggestions(cwd, "folders");
+                            return [
+                                ...(await getFrecentDirSuggestions("")),
+                                ...(await getFileCompletionSuggestions(cwd, "folders")),
+                            ];
                         case "history":
-                            return historyTemplate();
+                            return await historyTemplate();
                         case "help":
                             return helpTemplate();
                     }
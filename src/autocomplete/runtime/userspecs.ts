@@ -0,0 +1,41 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+import { GlobalModel } from "@/models";
+import log from "../utils/log";
+
+// User completion specs, loaded by wavesrv from the completion spec dir (client opt completionspecdir, default
+// ~/.waveterm/config/completion-specs, see newton/spec.go). Spec files can be Fig or carapace specs, wavesrv
+// converts carapace specs to Fig specs. User specs take precedence over the bundled Fig specs.
+
+const UserSpecsReloadMs = 60 * 1000;
+
+type CachedUserSpec = {
+    spec: Fig.Subcommand | undefined;
+    loadTs: number;
+};
+
+const userSpecs: Record<string, CachedUserSpec> = {};
+
+/**
+ * Returns the user spec for the command, if there is one. Specs are fetched from wavesrv again after
+ * UserSpecsReloadMs, so new spec files are picked up.
+ * @param specName The name of the command.
+ * @returns The spec, or undefined if there is no user spec for the command.
+ */
+export const getUserSpec = async (specName: string): Promise<Fig.Spec | undefined> => {
+    const cached = userSpecs[specName];
+    if (cached != null && Date.now() - cached.loadTs < UserSpecsReloadMs) {
+        return cached.spec;
+    }
+    let spec: Fig.Subcommand | undefined;
+    try {
+        const crtn = await GlobalModel.submitCommand("_compspec", null, [specName], { user: "1" }, false, false);
+        spec = crtn?.update?.data?.[0]?.completionspec?.spec ?? undefined;
+        log.debug("loaded user completion spec", specName, spec != null);
+    } catch (e) {
+        console.warn("error loading user completion spec", specName, e);
+    }
+    userSpecs[specName] = { spec, loadTs: Date.now() };
+    return spec;
+};
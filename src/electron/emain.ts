@@ -509,6 +509,27 @@ electron.ipcMain.on("contextmenu-show", (event, menuDefArr: ElectronContextMenuI
     event.returnValue = true;
 });
 
+electron.ipcMain.on("show-notification", (event, notify: NotifyUpdateType) => {
+    if (!electron.Notification.isSupported()) {
+        return;
+    }
+    const window = getWindowForEvent(event);
+    const notification = new electron.Notification({
+        title: notify.title,
+        body: notify.body,
+        urgency: notify.urgency,
+    });
+    notification.on("click", () => {
+        if (window == null || window.isDestroyed()) {
+            return;
+        }
+        window.show();
+        window.focus();
+        window.webContents.send("notification-click", notify);
+    });
+    notification.show();
+});
+
 electron.ipcMain.on("hide-window", (event) => {
     const window = getWindowForEvent(event);
     if (window) {
@@ -817,6 +838,66 @@ function reregisterGlobalShortcut(shortcut: string) {
     currentGlobalShortcut = shortcut;
 }
 
+// ====== DEEP LINKS ====== //
+// waveterm:// links (from os integrations, see wavesrv/pkg/osintegration) are opened by wavesrv.
+// macos sends them with "open-url", windows/linux start a second instance with the link in argv.
+const DeepLinkScheme = "waveterm";
+let pendingDeepLink: string = null; // received before the main window was created
+
+function registerDeepLinkScheme() {
+    if (process.defaultApp) {
+        // dev mode, electron is started with the app path as an argument
+        if (process.argv.length >= 2) {
+            app.setAsDefaultProtocolClient(DeepLinkScheme, process.execPath, [path.resolve(process.argv[1])]);
+        }
+        return;
+    }
+    app.setAsDefaultProtocolClient(DeepLinkScheme);
+}
+
+function findDeepLinkArg(argv: string[]): string {
+    return argv.find((arg) => arg.startsWith(DeepLinkScheme + "://")) ?? null;
+}
+
+function openDeepLink(deepLink: string) {
+    const url = new URL(getBaseHostPort() + "/api/osintegration/open-deep-link");
+    const fetchHeaders = getFetchHeaders();
+    fetch(url, { method: "post", body: JSON.stringify({ url: deepLink }), headers: fetchHeaders })
+        .then((resp) => handleJsonFetchResponse(url, resp))
+        .catch((err) => {
+            console.log("error opening deep link", deepLink, err);
+        });
+    const win = electron.BrowserWindow.getAllWindows()[0];
+    if (win != null) {
+        if (win.isMinimized()) {
+            win.restore();
+        }
+        win.show();
+        win.focus();
+    }
+}
+
+function handleDeepLink(deepLink: string) {
+    if (!app.isReady() || electron.BrowserWindow.getAllWindows().length == 0) {
+        pendingDeepLink = deepLink;
+        return;
+    }
+    openDeepLink(deepLink);
+}
+
+app.on("open-url", (event, url) => {
+    event.preventDefault();
+    handleDeepLink(url);
+});
+
+app.on("second-instance", (_, argv) => {
+    const deepLink = findDeepLinkArg(argv);
+    if (deepLink != null) {
+        handleDeepLink(deepLink);
+    }
+});
+// ====== DEEP LINKS ====== //
+
 // ====== AUTO-UPDATER ====== //
 let autoUpdateLock = false;
 let autoUpdateEnabled = false;
@@ -989,6 +1070,8 @@ function configureAutoUpdater(enabled: boolean) {
         app.quit();
         return;
     }
+    registerDeepLinkScheme();
+    pendingDeepLink = findDeepLinkArg(process.argv) ?? pendingDeepLink;
     GlobalAuthKey = readAuthKey();
     try {
         await runWaveSrv();
@@ -998,6 +1081,10 @@ function configureAutoUpdater(enabled: boolean) {
     setTimeout(runActiveTimer, 5000); // start active timer, wait 5s just to be safe
     await app.whenReady();
     await createWindowWrap();
+    if (pendingDeepLink != null) {
+        openDeepLink(pendingDeepLink);
+        pendingDeepLink = null;
+    }
 
     app.on("activate", () => {
         if (electron.BrowserWindow.getAllWindows().length === 0) {
@@ -35,4 +35,6 @@ contextBridge.exposeInMainWorld("api", {
     pathSep: () => ipcRenderer.sendSync("path-sep"),
     showContextMenu: (menu, position) => ipcRenderer.send("contextmenu-show", menu, position),
     onContextMenuClick: (callback) => ipcRenderer.on("contextmenu-click", callback),
+    showNotification: (notify) => ipcRenderer.send("show-notification", notify),
+    onNotificationClick: (callback) => ipcRenderer.on("notification-click", (_, notify) => callback(notify)),
 });
@@ -41,6 +41,10 @@ class Cmd {
         return this.data.get().durationms;
     }
 
+    getUsage(): CmdUsageType {
+        return this.data.get().usage;
+    }
+
     getAsWebCmd(lineid: string): WebCmd {
         let cmd = this.data.get();
         let remote = this.model.getRemote(this.remote.remoteid);
@@ -86,6 +86,18 @@ class CommandRunner {
         return GlobalModel.submitCommand("line", "restart", [lineArg], { nohist: "1" }, interactive);
     }
 
+    lineAIExplain(lineArg: string, interactive: boolean): Promise<CommandRtnType> {
+        return GlobalModel.submitCommand("ai", "explain", [lineArg], { nohist: "1" }, interactive);
+    }
+
+    lineAIAttach(lineArg: string, interactive: boolean): Promise<CommandRtnType> {
+        return GlobalModel.submitCommand("ai", "attach", [lineArg], { nohist: "1" }, interactive);
+    }
+
+    lineAIDetach(lineArg: string): Promise<CommandRtnType> {
+        return GlobalModel.submitCommand("ai", "attach", [lineArg], { nohist: "1", remove: "1" }, false);
+    }
+
     lineSignal(lineArg: string, signal: string, interactive: boolean): Promise<CommandRtnType> {
         return GlobalModel.submitCommand("signal", null, [lineArg, signal], { nohist: "1" }, interactive);
     }
@@ -210,6 +222,34 @@ class CommandRunner {
         GlobalModel.submitCommand("remote", "archive", null, { remote: remoteid, nohist: "1" }, true);
     }
 
+    setRemoteGroup(remoteid: string, group: string) {
+        GlobalModel.submitCommand("remote", "group", [group], { remote: remoteid, nohist: "1" }, false);
+    }
+
+    remoteReorder(remoteid: string, index: string) {
+        GlobalModel.submitCommand("remote", "reorder", null, { remote: remoteid, index: index, nohist: "1" }, false);
+    }
+
+    showRemoteHealth(remoteid: string) {
+        GlobalModel.submitCommand("remote", "health", null, { remote: remoteid, nohist: "1" }, true);
+    }
+
+    createRemoteGroup(name: string) {
+        GlobalModel.submitCommand("remotegroup", "new", [name], { nohist: "1" }, false);
+    }
+
+    renameRemoteGroup(groupid: string, name: string) {
+        GlobalModel.submitCommand("remotegroup", "rename", [groupid], { name: name, nohist: "1" }, false);
+    }
+
+    deleteRemoteGroup(groupid: string) {
+        GlobalModel.submitCommand("remotegroup", "delete", [groupid], { nohist: "1" }, false);
+    }
+
+    remoteGroupReorder(groupid: string, index: string) {
+        GlobalModel.submitCommand("remotegroup", "reorder", [groupid], { index: index, nohist: "1" }, false);
+    }
+
     importSshConfig() {
         GlobalModel.submitCommand("remote", "parse", null, { nohist: "1", visual: "1" }, true);
     }
@@ -258,7 +298,7 @@ class CommandRunner {
 
     screenSetSettings(
         screenId: string,
-        settings: { tabcolor?: string; tabicon?: string; name?: string; sharename?: string },
+        settings: { tabcolor?: string; tabicon?: string; name?: string; sharename?: string; remotelock?: string },
         interactive: boolean
     ): Promise<CommandRtnType> {
         let kwargs: { [key: string]: any } = Object.assign({}, settings);
@@ -505,6 +545,27 @@ class CommandRunner {
         GlobalModel.submitCommand("bookmark", "delete", [bookmarkId], { nohist: "1" }, true);
     }
 
+    dirBookmarkGo(name: string): void {
+        GlobalModel.submitCommand("dirbookmark", "go", [name], { nohist: "1" }, true);
+    }
+
+    dirBookmarkAdd(name: string, dir?: string): void {
+        let args = dir == null ? [name] : [name, dir];
+        GlobalModel.submitCommand("dirbookmark", "add", args, { nohist: "1" }, true);
+    }
+
+    snippetRender(name: string, values: string[], kwargs?: Record<string, string>): Promise<CommandRtnType> {
+        return GlobalModel.submitCommand("snippet", "render", [name, ...values], { nohist: "1", ...kwargs }, false);
+    }
+
+    snippetAdd(name: string, cmdStr: string, kwargs?: Record<string, string>): Promise<CommandRtnType> {
+        return GlobalModel.submitCommand("snippet", "add", [name, cmdStr], { nohist: "1", ...kwargs }, false);
+    }
+
+    snippetDelete(name: string): Promise<CommandRtnType> {
+        return GlobalModel.submitCommand("snippet", "delete", [name], { nohist: "1" }, false);
+    }
+
     openSharedSession(): void {
         GlobalModel.submitCommand("session", "openshared", null, { nohist: "1" }, true);
     }
@@ -533,6 +594,50 @@ class CommandRunner {
         GlobalModel.submitCommand("sidebar", "remove", null, { nohist: "1" }, false);
     }
 
+    screenClearFilter(): void {
+        GlobalModel.submitCommand("screen", "filter", null, { nohist: "1" }, false);
+    }
+
+    screenSplit(split: PaneSplitType): void {
+        GlobalModel.submitCommand("screen", "split", null, { nohist: "1", split: split }, false);
+    }
+
+    screenClosePane(paneId: string): void {
+        GlobalModel.submitCommand("screen", "closepane", [paneId], { nohist: "1" }, false);
+    }
+
+    screenFocusPane(paneId: string): void {
+        GlobalModel.submitCommand("screen", "focuspane", [paneId], { nohist: "1" }, false);
+    }
+
+    screenResizePane(paneId: string, ratio: number): void {
+        GlobalModel.submitCommand("screen", "resizepane", [paneId], { nohist: "1", ratio: String(ratio) }, false);
+    }
+
+    windowNew(name: string, sessionId: string): void {
+        let kwargs: Record<string, string> = { nohist: "1" };
+        if (sessionId != null) {
+            kwargs.session = sessionId;
+        }
+        GlobalModel.submitCommand("window", "new", name == null ? null : [name], kwargs, false);
+    }
+
+    windowClose(windowId: string): void {
+        GlobalModel.submitCommand("window", "close", [windowId], { nohist: "1" }, false);
+    }
+
+    windowMoveScreen(screenId: string, windowId: string): void {
+        GlobalModel.submitCommand("window", "movescreen", [screenId], { nohist: "1", window: windowId }, false);
+    }
+
+    transferHistory(limit?: number): void {
+        let kwargs: Record<string, string> = { nohist: "1" };
+        if (limit != null) {
+            kwargs.limit = String(limit);
+        }
+        GlobalModel.submitCommand("transfer", "history", null, kwargs, false);
+    }
+
     screenSidebarClose(): void {
         GlobalModel.submitCommand("sidebar", "close", null, { nohist: "1" }, false);
     }
@@ -549,6 +654,11 @@ class CommandRunner {
         return GlobalModel.submitCommand("client", "setglobalshortcut", [shortcut], { nohist: "1" }, false);
     }
 
+    // target is "screen", "session", or "none"
+    setDropdown(target: string): Promise<CommandRtnType> {
+        return GlobalModel.submitCommand("client", "setdropdown", [target], { nohist: "1" }, false);
+    }
+
     setAutocompleteEnabled(enabled: boolean): Promise<CommandRtnType> {
         return GlobalModel.submitCommand("autocomplete", enabled ? "on" : "off", null, { nohist: "1" }, false);
     }
@@ -36,6 +36,10 @@ class InputModel {
     AICmdInfoChatItems: mobx.IObservableArray<OpenAICmdInfoChatMessageType> = mobx.observable.array([], {
         name: "aicmdinfo-chat",
     });
+    AICmdInfoAttachments: mobx.IObservableArray<OpenAICmdInfoAttachmentType> = mobx.observable.array([], {
+        name: "aicmdinfo-attachments",
+        deep: false,
+    });
     readonly codeSelectTop: number = -2;
     readonly codeSelectBottom: number = -1;
 
@@ -249,6 +253,11 @@ class InputModel {
         this.codeSelectBlockRefArray = [];
     }
 
+    @mobx.action
+    setOpenAICmdInfoAttachments(attachments: OpenAICmdInfoAttachmentType[]): void {
+        this.AICmdInfoAttachments.replace(attachments);
+    }
+
     isHistoryLoaded(): boolean {
         if (this.historyLoading.get()) {
             return false;
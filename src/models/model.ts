@@ -7,6 +7,7 @@ import {
     handleJsonFetchResponse,
     base64ToString,
     base64ToArray,
+    getPtyData,
     genMergeData,
     genMergeDataMap,
     genMergeSimpleData,
@@ -63,6 +64,28 @@ class Model {
         deep: false,
     });
     screenMap: OMap<string, Screen> = mobx.observable.map({}, { name: "ScreenMap", deep: false });
+    windowMap: OMap<string, WindowDataType> = mobx.observable.map({}, { name: "WindowMap", deep: false });
+    remoteGroupMap: OMap<string, RemoteGroupType> = mobx.observable.map({}, { name: "RemoteGroupMap", deep: false });
+    remoteHealthMap: OMap<string, RemoteHealthType> = mobx.observable.map({}, { name: "RemoteHealthMap", deep: false });
+    // set from the connect update, null for servers without the version/capability handshake
+    serverCompat: OV<CompatReportType> = mobx.observable.box(null, { name: "serverCompat", deep: false });
+    historyStats: OV<CommandStatsType> = mobx.observable.box(null, { name: "historyStats", deep: false });
+    // key = remoteid, the recent metrics samples (oldest first), see /client:set metricsinterval
+    remoteMetricsMap: OMap<string, RemoteMetricsType[]> = mobx.observable.map({}, { name: "RemoteMetricsMap", deep: false });
+    transferMap: OMap<string, TransferType> = mobx.observable.map({}, { name: "TransferMap", deep: false });
+    // key = screenid, only screens in reading mode (/screen:transcript)
+    // key = screenid, clients connected to the screen's lan shares (/screen:lanshare)
+    presenceMap: OMap<string, PresenceClientType[]> = mobx.observable.map({}, { name: "PresenceMap", deep: false });
+    envProfiles: OV<EnvProfilesUpdateType> = mobx.observable.box(
+        { profiles: [], attachments: [] },
+        { name: "EnvProfiles", deep: false }
+    );
+    // key = remoteid
+    dirBookmarks: OMap<string, DirBookmarkType[]> = mobx.observable.map({}, { name: "DirBookmarks", deep: false });
+    snippets: OArr<SnippetType> = mobx.observable.array([], { name: "Snippets", deep: false });
+    // key = screenid, commands submitted on the screen (oldest first), kept across server restarts
+    screenInputHistory: OMap<string, string[]> = mobx.observable.map({}, { name: "ScreenInputHistory", deep: false });
+    transcripts: OMap<string, TranscriptEntryType[]> = mobx.observable.map({}, { name: "Transcripts", deep: false });
     ws: WSControl;
     remotes: OArr<RemoteType> = mobx.observable.array([], {
         name: "remotes",
@@ -73,6 +96,7 @@ class Model {
     });
     screenLines: OMap<string, ScreenLines> = mobx.observable.map({}, { name: "screenLines", deep: false }); // key = "sessionid/screenid" (screenlines)
     termUsedRowsCache: Record<string, number> = {}; // key = "screenid/lineid"
+    screenLinesChunks: Record<string, ScreenLinesType[]> = {}; // key = chunkid (chunked screenlines updates)
     debugCmds: number = 0;
     debugScreen: OV<boolean> = mobx.observable.box(false);
     waveSrvRunning: OV<boolean>;
@@ -202,6 +226,7 @@ class Model {
         getApi().onWaveSrvStatusChange(this.onWaveSrvStatusChange.bind(this));
         getApi().onAppUpdateStatus(this.onAppUpdateStatus.bind(this));
         getApi().onNativeThemeUpdated(this.onNativeThemeUpdated.bind(this));
+        getApi().onNotificationClick(this.onNotificationClick.bind(this));
         document.addEventListener("keydown", this.docKeyDownHandler.bind(this));
         document.addEventListener("selectionchange", this.docSelectionChangeHandler.bind(this));
         window.addEventListener("focus", this.windowFocus.bind(this));
@@ -806,6 +831,13 @@ class Model {
         }
     }
 
+    onNotificationClick(notify: NotifyUpdateType): void {
+        if (notify.screenid == null) {
+            return;
+        }
+        GlobalCommandRunner.switchScreen(notify.screenid, notify.sessionid);
+    }
+
     onMenuItemAbout(): void {
         mobx.action(() => {
             this.modalsModel.pushModal(appconst.ABOUT);
@@ -911,6 +943,102 @@ class Model {
         }
     }
 
+    updateWindows(windows: WindowDataType[]): void {
+        mobx.action(() => {
+            for (const window of windows) {
+                if (window.remove) {
+                    this.windowMap.delete(window.windowid);
+                } else {
+                    this.windowMap.set(window.windowid, window);
+                }
+            }
+        })();
+    }
+
+    updateRemoteGroups(groups: RemoteGroupType[]): void {
+        mobx.action(() => {
+            for (const group of groups) {
+                if (group.remove) {
+                    this.remoteGroupMap.delete(group.groupid);
+                } else {
+                    this.remoteGroupMap.set(group.groupid, group);
+                }
+            }
+        })();
+    }
+
+    getRemoteGroups(): RemoteGroupType[] {
+        return Array.from(this.remoteGroupMap.values()).sort((a, b) => a.groupidx - b.groupidx);
+    }
+
+    updateRemoteHealth(healthArr: RemoteHealthType[]): void {
+        mobx.action(() => {
+            for (const health of healthArr) {
+                if (health.remove) {
+                    this.remoteHealthMap.delete(health.remoteid);
+                    this.remoteMetricsMap.delete(health.remoteid);
+                } else {
+                    this.remoteHealthMap.set(health.remoteid, health);
+                }
+            }
+        })();
+    }
+
+    getRemoteHealth(remoteId: string): RemoteHealthType {
+        return this.remoteHealthMap.get(remoteId);
+    }
+
+    updateServerCompat(compat: CompatReportType): void {
+        mobx.action(() => {
+            this.serverCompat.set(compat);
+        })();
+        let errMsg: string = null;
+        if (!compat.compatible) {
+            errMsg = compat.error ?? "this version of the app is not supported by the server";
+        } else if (compat.protocolversion < appconst.MinServerProtocolVersion) {
+            errMsg = sprintf(
+                "server version %s (protocol %d) is too old for this app, please upgrade it",
+                compat.serverversion,
+                compat.protocolversion
+            );
+        }
+        if (errMsg != null) {
+            console.log("[compat] incompatible server", compat);
+            this.inputModel.flashInfoMsg({ infotitle: "Version mismatch", infoerror: errMsg }, 0);
+        }
+    }
+
+    getServerProtocolVersion(): number {
+        return this.serverCompat.get()?.protocolversion ?? 0;
+    }
+
+    // true if the server and this app both have the (optional) capability
+    hasServerCap(capName: string): boolean {
+        return this.serverCompat.get()?.enabled?.includes(capName) ?? false;
+    }
+
+    addRemoteMetrics(sample: RemoteMetricsType): void {
+        mobx.action(() => {
+            const samples = [...(this.remoteMetricsMap.get(sample.remoteid) ?? []), sample];
+            if (samples.length > appconst.MaxRemoteMetricsSamples) {
+                samples.splice(0, samples.length - appconst.MaxRemoteMetricsSamples);
+            }
+            this.remoteMetricsMap.set(sample.remoteid, samples);
+        })();
+    }
+
+    getRemoteMetrics(remoteId: string): RemoteMetricsType[] {
+        return this.remoteMetricsMap.get(remoteId) ?? [];
+    }
+
+    updateTransfers(transfers: TransferType[]): void {
+        mobx.action(() => {
+            for (const transfer of transfers) {
+                this.transferMap.set(transfer.transferid, transfer);
+            }
+        })();
+    }
+
     updateScreenNumRunningCommands(numRunningCommandUpdates: ScreenNumRunningCommandsUpdateType[]) {
         for (const update of numRunningCommandUpdates) {
             this.getScreenById_single(update.screenid)?.setNumRunningCmds(update.num);
@@ -952,7 +1080,7 @@ class Model {
                 this.updatePtyData(ptyMsg);
             } else {
                 // remote update
-                const ptyData = base64ToArray(ptyMsg.ptydata64);
+                const ptyData = getPtyData(ptyMsg);
                 this.remotesModel.receiveData(ptyMsg.remoteid, ptyMsg.ptypos, ptyData);
             }
         } else if (genUpdate.type == "model") {
@@ -962,6 +1090,8 @@ class Model {
             const [oldActiveSessionId, oldActiveScreenId] = this.getActiveIds();
             modelUpdateItems.forEach((update) => {
                 if (update.connect != null) {
+                    // set again by the compat item of the same update (not sent by older servers)
+                    this.serverCompat.set(null);
                     if (update.connect.screens != null) {
                         this.screenMap.clear();
                         this.updateScreens(update.connect.screens);
@@ -978,12 +1108,23 @@ class Model {
                     if (update.connect.activesessionid != null) {
                         this.updateActiveSession(update.connect.activesessionid);
                     }
+                    this.windowMap.clear();
+                    this.updateWindows(update.connect.windows ?? []);
+                    this.remoteGroupMap.clear();
+                    this.updateRemoteGroups(update.connect.remotegroups ?? []);
+                    this.remoteHealthMap.clear();
+                    this.updateRemoteHealth(update.connect.remotehealth ?? []);
+                    this.remoteMetricsMap.clear();
+                    for (const [remoteId, samples] of Object.entries(update.connect.remotemetrics ?? {})) {
+                        this.remoteMetricsMap.set(remoteId, samples);
+                    }
                     if (update.connect.screennumrunningcommands != null) {
                         this.updateScreenNumRunningCommands(update.connect.screennumrunningcommands);
                     }
                     if (update.connect.screenstatusindicators != null) {
                         this.updateScreenStatusIndicators(update.connect.screenstatusindicators);
                     }
+                    this.updateScreenInputs(update.connect.screeninputs ?? []);
                     this.mergeTermThemes(update.connect.termthemes ?? {});
                     this.sessionListLoaded.set(true);
                     this.remotesLoaded.set(true);
@@ -993,12 +1134,41 @@ class Model {
                     this.updateSessions([update.session]);
                 } else if (update.activesessionid != null) {
                     this.updateActiveSession(update.activesessionid);
+                } else if (update.window != null) {
+                    this.updateWindows([update.window]);
+                } else if (update.remotegroup != null) {
+                    this.updateRemoteGroups([update.remotegroup]);
+                } else if (update.remotehealth != null) {
+                    this.updateRemoteHealth([update.remotehealth]);
+                } else if (update.compat != null) {
+                    this.updateServerCompat(update.compat);
+                } else if (update.historystats != null) {
+                    this.historyStats.set(update.historystats);
+                } else if (update.transfer != null) {
+                    this.updateTransfers([update.transfer]);
+                } else if (update.transferhistory != null) {
+                    this.updateTransfers(update.transferhistory.transfers ?? []);
+                } else if (update.playback != null) {
+                    this.handlePlaybackUpdate(update.playback);
+                } else if (update.transcript != null) {
+                    this.updateTranscript(update.transcript);
+                } else if (update.presence != null) {
+                    this.updatePresence(update.presence);
+                } else if (update.envprofiles != null) {
+                    this.updateEnvProfiles(update.envprofiles);
+                } else if (update.dirbookmarks != null) {
+                    this.updateDirBookmarks(update.dirbookmarks);
+                } else if (update.snippets != null) {
+                    this.updateSnippets(update.snippets);
                 } else if (update.line != null) {
                     this.addLineCmd(update.line.line, update.line.cmd, interactive);
                 } else if (update.cmd != null) {
                     this.updateCmd(update.cmd);
                 } else if (update.screenlines != null) {
-                    this.updateScreenLines(update.screenlines, false);
+                    const slines = this.reassembleScreenLines(update.screenlines);
+                    if (slines != null) {
+                        this.updateScreenLines(slines, false);
+                    }
                 } else if (update.remote != null) {
                     this.updateRemotes([update.remote]);
                     // This code's purpose is to show view remote connection modal when a new connection is added
@@ -1050,17 +1220,28 @@ class Model {
                     this.inputModel.updateCmdLine(update.cmdline);
                 } else if (update.openaicmdinfochat != null) {
                     this.inputModel.setOpenAICmdInfoChat(update.openaicmdinfochat);
+                } else if (update.openaicmdinfoattachments != null) {
+                    this.inputModel.setOpenAICmdInfoAttachments(update.openaicmdinfoattachments);
                 } else if (update.screenstatusindicator != null) {
                     this.updateScreenStatusIndicators([update.screenstatusindicator]);
                 } else if (update.screennumrunningcommands != null) {
                     this.updateScreenNumRunningCommands([update.screennumrunningcommands]);
+                } else if (update.notify != null) {
+                    getApi().showNotification(update.notify);
                 } else if (update.userinputrequest != null) {
                     const userInputRequest: UserInputRequest = update.userinputrequest;
                     this.modalsModel.pushModal(appconst.USER_INPUT, userInputRequest);
                 } else if (update.termthemes != null) {
                     this.mergeTermThemes(update.termthemes);
-                } else if (update.sessiontombstone != null || update.screentombstone != null) {
-                    // nothing (ignore)
+                } else if (
+                    update.sessiontombstone != null ||
+                    update.screentombstone != null ||
+                    update.suggestions != null ||
+                    update.completionspec != null ||
+                    update.currentcontext != null
+                ) {
+                    // nothing (ignore), suggestions and completionspec are only returned to the autocomplete,
+                    // currentcontext is for editor integrations
                 } else {
                     // interactive-only updates follow below
                     // we check interactive *inside* of the conditions because of isDev console.log message
@@ -1109,6 +1290,8 @@ class Model {
                     }, 100);
                 }
             }
+        } else if (genUpdate.type == "remotemetrics") {
+            this.addRemoteMetrics(genUpdate.data);
         } else {
             console.warn("unknown update", genUpdate);
         }
@@ -1178,6 +1361,31 @@ class Model {
         })();
     }
 
+    // large screenlines updates are sent in chunks (with the same chunkid), returns null until all of them are in
+    reassembleScreenLines(slines: ScreenLinesType): ScreenLinesType {
+        if (slines.chunkid == null || (slines.numchunks ?? 0) <= 1) {
+            return slines;
+        }
+        let chunks = this.screenLinesChunks[slines.chunkid];
+        if (chunks == null) {
+            chunks = [];
+            this.screenLinesChunks[slines.chunkid] = chunks;
+        }
+        chunks[slines.chunkidx ?? 0] = slines;
+        for (let i = 0; i < slines.numchunks; i++) {
+            if (chunks[i] == null) {
+                return null;
+            }
+        }
+        delete this.screenLinesChunks[slines.chunkid];
+        const rtn: ScreenLinesType = { screenid: slines.screenid, lines: [], cmds: [] };
+        for (const chunk of chunks) {
+            rtn.lines.push(...(chunk.lines ?? []));
+            rtn.cmds.push(...(chunk.cmds ?? []));
+        }
+        return rtn;
+    }
+
     removeScreenLinesByScreenId(screenId: string) {
         mobx.action(() => {
             this.screenLines.delete(screenId);
@@ -1240,6 +1448,113 @@ class Model {
         }
         slines.addLineCmd(line, cmd, interactive);
         this.handleCmdRestart(cmd);
+        this.handleLineEncoding(line);
+    }
+
+    // the output encoding was detected or set (/line:set encoding=...)
+    handleLineEncoding(line: LineType) {
+        const screen = this.screenMap.get(line.screenid);
+        const termWrap = screen?.getTermWrap(line.lineid);
+        if (termWrap == null) {
+            return;
+        }
+        termWrap.setEncoding(line.linestate?.["wave:encoding"]);
+    }
+
+    // /line:playback and /screen:playback, lines without a loaded terminal are skipped
+    handlePlaybackUpdate(pbUpdate: PlaybackUpdateType) {
+        const screen = this.screenMap.get(pbUpdate.screenid);
+        const termWrap = screen?.getTermWrap(pbUpdate.lineid);
+        if (termWrap == null) {
+            return;
+        }
+        if (pbUpdate.reset) {
+            termWrap.startPlayback(pbUpdate.playbackid);
+        }
+        if (pbUpdate.data64 != null) {
+            termWrap.playbackData(pbUpdate.playbackid, base64ToArray(pbUpdate.data64));
+        }
+        if (pbUpdate.done) {
+            termWrap.endPlayback(pbUpdate.playbackid);
+        }
+    }
+
+    updatePresence(pUpdate: PresenceUpdateType) {
+        mobx.action(() => {
+            if (pUpdate.clients == null || pUpdate.clients.length == 0) {
+                this.presenceMap.delete(pUpdate.screenid);
+                return;
+            }
+            this.presenceMap.set(pUpdate.screenid, pUpdate.clients);
+        })();
+    }
+
+    updateEnvProfiles(epUpdate: EnvProfilesUpdateType) {
+        mobx.action(() => {
+            this.envProfiles.set({
+                profiles: epUpdate.profiles ?? [],
+                attachments: epUpdate.attachments ?? [],
+            });
+        })();
+    }
+
+    updateDirBookmarks(dbUpdate: DirBookmarksUpdateType) {
+        mobx.action(() => {
+            this.dirBookmarks.set(dbUpdate.remoteid, dbUpdate.bookmarks ?? []);
+        })();
+    }
+
+    // restores the input draft of the active screen (unless something was typed already)
+    updateScreenInputs(inputs: ScreenInputType[]) {
+        mobx.action(() => {
+            this.screenInputHistory.clear();
+            for (const input of inputs) {
+                this.screenInputHistory.set(input.screenid, input.history ?? []);
+            }
+            const activeScreen = this.getActiveScreen();
+            if (activeScreen == null || this.inputModel.curLine != "") {
+                return;
+            }
+            const activeInput = inputs.find((input) => input.screenid == activeScreen.screenId);
+            if (activeInput?.inputtext?.str) {
+                this.inputModel.updateCmdLine(activeInput.inputtext);
+            }
+        })();
+    }
+
+    getScreenInputHistory(screenId: string): string[] {
+        return this.screenInputHistory.get(screenId) ?? [];
+    }
+
+    updateSnippets(sUpdate: SnippetsUpdateType) {
+        mobx.action(() => {
+            this.snippets.replace(sUpdate.snippets ?? []);
+        })();
+    }
+
+    // entries are kept in line order, updates replace the entries with the same lineid
+    updateTranscript(tUpdate: TranscriptUpdateType) {
+        mobx.action(() => {
+            if (!tUpdate.reset && !this.transcripts.has(tUpdate.screenid)) {
+                // reading mode is not on for this screen (in this window)
+                return;
+            }
+            const entryMap = new Map<string, TranscriptEntryType>();
+            if (!tUpdate.reset) {
+                for (const entry of this.transcripts.get(tUpdate.screenid)) {
+                    entryMap.set(entry.lineid, entry);
+                }
+            }
+            for (const entry of tUpdate.entries ?? []) {
+                entryMap.set(entry.lineid, entry);
+            }
+            for (const lineId of tUpdate.removelineids ?? []) {
+                entryMap.delete(lineId);
+            }
+            const entries = Array.from(entryMap.values());
+            entries.sort((a, b) => a.linenum - b.linenum);
+            this.transcripts.set(tUpdate.screenid, entries);
+        })();
     }
 
     updateCmd(cmd: CmdDataType) {
@@ -1592,6 +1907,75 @@ class Model {
         return newWin;
     }
 
+    // compares the output of two command lines (for the diff renderer)
+    getLineDiff(screenIdA: string, lineIdA: string, screenIdB: string, lineIdB: string): Promise<LineDiffType> {
+        const usp = new URLSearchParams({
+            screenida: screenIdA,
+            lineida: lineIdA,
+            screenidb: screenIdB,
+            lineidb: lineIdB,
+        });
+        const url = new URL(this.getBaseHostPort() + "/api/line-diff?" + usp.toString());
+        return fetch(url, { headers: this.getFetchHeaders() })
+            .then((resp) => handleJsonFetchResponse(url, resp))
+            .then((data) => data.data as LineDiffType);
+    }
+
+    // lineId null exports the whole screen, returns the .cast file
+    exportAsciinema(screenId: string, lineId?: string): Promise<Blob> {
+        const usp = new URLSearchParams({ screenid: screenId });
+        if (lineId != null) {
+            usp.set("lineid", lineId);
+        }
+        const url = new URL(this.getBaseHostPort() + "/api/export-asciinema?" + usp.toString());
+        return fetch(url, { headers: this.getFetchHeaders() }).then((resp) => {
+            if (!resp.ok) {
+                return resp.text().then((text) => {
+                    throw new Error(text);
+                });
+            }
+            return resp.blob();
+        });
+    }
+
+    // saves the raw output of a line with binary output (linestate "wave:binaryout")
+    downloadRawOutput(screenId: string, lineId: string): void {
+        const usp = new URLSearchParams({ screenid: screenId, lineid: lineId });
+        const url = new URL(this.getBaseHostPort() + "/api/rawout?" + usp.toString());
+        fetch(url, { headers: this.getFetchHeaders() })
+            .then((resp) => {
+                if (!resp.ok) {
+                    return resp.text().then((text) => {
+                        throw new Error(text);
+                    });
+                }
+                return resp.blob();
+            })
+            .then((blob) => {
+                const objUrl = URL.createObjectURL(blob);
+                const anchor = document.createElement("a");
+                anchor.href = objUrl;
+                anchor.download = "output.bin";
+                anchor.click();
+                URL.revokeObjectURL(objUrl);
+            })
+            .catch((e) => {
+                this.showAlert({ message: "Error downloading raw output: " + e.message });
+            });
+    }
+
+    // returns an object url for the thumbnail of the raw output (linestate "wave:binarythumb"), revoke it when done
+    getRawOutputThumbnailUrl(screenId: string, lineId: string): Promise<string> {
+        const usp = new URLSearchParams({ screenid: screenId, lineid: lineId, thumb: "1" });
+        const url = new URL(this.getBaseHostPort() + "/api/rawout?" + usp.toString());
+        return fetch(url, { headers: this.getFetchHeaders() }).then((resp) => {
+            if (!resp.ok) {
+                throw new Error(`error fetching thumbnail: ${resp.status}`);
+            }
+            return resp.blob().then((blob) => URL.createObjectURL(blob));
+        });
+    }
+
     getRemote(remoteId: string): RemoteType {
         if (remoteId == null) {
             return null;
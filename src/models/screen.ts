@@ -4,7 +4,7 @@
 import * as mobx from "mobx";
 import { sprintf } from "sprintf-js";
 import { debounce } from "throttle-debounce";
-import { base64ToArray, boundInt, isModKeyPress, isBlank } from "@/util/util";
+import { getPtyData, boundInt, isModKeyPress, isBlank } from "@/util/util";
 import { TermWrap } from "@/plugins/terminal/term";
 import { windowWidthToCols, windowHeightToRows, termWidthFromCols, termHeightFromRows } from "@/util/textmeasure";
 import { getRendererContext } from "@/app/line/lineutil";
@@ -41,6 +41,7 @@ class Screen {
     renderers: Record<string, RendererModel> = {}; // lineid => RendererModel
     shareMode: OV<string>;
     webShareOpts: OV<WebShareOpts>;
+    paneLayout: OV<ScreenPaneLayoutType>;
     filterRunning: OV<boolean>;
     statusIndicator: OV<appconst.StatusIndicatorLevel>;
     numRunningCmds: OV<number>;
@@ -83,6 +84,9 @@ class Screen {
         this.webShareOpts = mobx.observable.box(sdata.webshareopts, {
             name: "screen-webShareOpts",
         });
+        this.paneLayout = mobx.observable.box(sdata.panelayout, {
+            name: "screen-paneLayout",
+        });
         this.filterRunning = mobx.observable.box(false, {
             name: "screen-filter-running",
         });
@@ -101,6 +105,10 @@ class Screen {
         return this.shareMode.get() == "web" && this.webShareOpts.get() != null;
     }
 
+    isSplit(): boolean {
+        return this.paneLayout.get() != null;
+    }
+
     isSidebarOpen(): boolean {
         let viewOpts = this.viewOpts.get();
         if (viewOpts == null) {
@@ -138,6 +146,10 @@ class Screen {
     getWebShareUrl(): string {
         let viewKey: string = null;
         if (this.webShareOpts.get() != null) {
+            if (this.webShareOpts.get().viewurl != null) {
+                // set by the backend (hosted service or self-hosted share server)
+                return this.webShareOpts.get().viewurl;
+            }
             viewKey = this.webShareOpts.get().viewkey;
         }
         if (viewKey == null) {
@@ -172,6 +184,7 @@ class Screen {
             this.refocusLine(data, oldFocusType, oldSelectedLine);
             this.shareMode.set(data.sharemode);
             this.webShareOpts.set(data.webshareopts);
+            this.paneLayout.set(data.panelayout);
             // do not update anchorLine/anchorOffset (only stored)
         })();
     }
@@ -201,6 +214,10 @@ class Screen {
     }
 
     getTabColor(): string {
+        let policyMeta = this.getRemotePolicyMeta();
+        if (policyMeta != null && !isBlank(policyMeta.tabcolor)) {
+            return policyMeta.tabcolor;
+        }
         let tabColor = "default";
         let screenOpts = this.opts.get();
         if (screenOpts != null && !isBlank(screenOpts.tabcolor)) {
@@ -209,6 +226,19 @@ class Screen {
         return tabColor;
     }
 
+    // server-side policy metadata for the current remote (e.g. production remotes force a banner and tab color)
+    getRemotePolicyMeta(): RemotePolicyMetaType {
+        let rptr = this.curRemote.get();
+        if (rptr == null) {
+            return null;
+        }
+        let remote = this.globalModel.getRemote(rptr.remoteid);
+        if (remote == null) {
+            return null;
+        }
+        return remote.policymeta;
+    }
+
     getTabIcon(): string {
         let tabIcon = "default";
         let screenOpts = this.opts.get();
@@ -386,13 +416,13 @@ class Screen {
         let lineId = ptyMsg.lineid;
         let renderer = this.renderers[lineId];
         if (renderer != null) {
-            let data = base64ToArray(ptyMsg.ptydata64);
+            let data = getPtyData(ptyMsg);
             renderer.receiveData(ptyMsg.ptypos, data, "from-sw");
         }
         let term = this.terminals[lineId];
         if (term != null) {
-            let data = base64ToArray(ptyMsg.ptydata64);
-            term.receiveData(ptyMsg.ptypos, data, "from-sw");
+            let data = getPtyData(ptyMsg);
+            term.receiveData(ptyMsg.ptypos, data, "from-sw", ptyMsg.renderhint);
         }
     }
 
@@ -545,6 +575,7 @@ class Screen {
             fontSize: this.globalModel.getTermFontSize(),
             fontFamily: this.globalModel.getTermFontFamily(),
             ptyDataSource: getTermPtyData,
+            encoding: line.linestate?.["wave:encoding"],
             onUpdateContentHeight: (termContext: RendererContext, height: number) => {
                 this.globalModel.setContentHeight(termContext, height);
             },
@@ -32,6 +32,12 @@ class ScreenLines {
         return rtn;
     }
 
+    // returns non-archived lines that have any of the filter's tags
+    getTagFilteredLines(filter: ScreenFilterType): LineType[] {
+        const filterTags = new Set(filter.tags ?? []);
+        return this.getNonArchivedLines().filter((line) => (line.tags ?? []).some((tag) => filterTags.has(tag)));
+    }
+
     updateData(slines: ScreenLinesType, load: boolean) {
         mobx.action(() => {
             if (load) {
@@ -93,6 +93,7 @@ class SpecialLineContainer {
             fontSize: this.globalModel.getTermFontSize(),
             fontFamily: this.globalModel.getTermFontFamily(),
             ptyDataSource: getTermPtyData,
+            encoding: line.linestate?.["wave:encoding"],
             onUpdateContentHeight: null,
         });
         this.terminal = termWrap;
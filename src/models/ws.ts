@@ -17,6 +17,7 @@ class WSControl {
     messageCallback: (any) => void = null;
     watchSessionId: string = null;
     watchScreenId: string = null;
+    dropdown: boolean = false; // the dropdown window only needs the dropdown session/screen on connect
     wsLog: mobx.IObservableArray<string> = mobx.observable.array([], { name: "wsLog" });
     authKey: string;
     baseHostPort: string;
@@ -56,6 +57,7 @@ class WSControl {
         this.log(sprintf("try reconnect (%s)", desc));
         this.opening = true;
         this.wsConn = new WebSocket(this.baseHostPort + "/ws?clientid=" + this.clientId);
+        this.wsConn.binaryType = "arraybuffer";
         this.wsConn.onopen = this.onopen;
         this.wsConn.onmessage = this.onmessage;
         this.wsConn.onclose = this.onclose;
@@ -130,8 +132,34 @@ class WSControl {
         }, 100);
     }
 
+    // binary pty frame (see wavesrv scws/binpty.go):
+    // [4 byte big endian header length][header json (pty update without ptydata64)][raw pty data]
+    handleBinaryMessage(buf: ArrayBuffer) {
+        if (buf.byteLength < 4) {
+            return;
+        }
+        let headerLen = new DataView(buf).getUint32(0);
+        if (4 + headerLen > buf.byteLength) {
+            console.log("[error] invalid binary ws message, header length", headerLen);
+            return;
+        }
+        let ptyMsg: PtyDataUpdateType = JSON.parse(new TextDecoder().decode(new Uint8Array(buf, 4, headerLen)));
+        ptyMsg.ptydata = new Uint8Array(buf, 4 + headerLen);
+        if (this.messageCallback) {
+            try {
+                this.messageCallback({ type: "pty", data: ptyMsg });
+            } catch (e) {
+                console.log("[error] messageCallback", e);
+            }
+        }
+    }
+
     @boundMethod
     onmessage(event: any) {
+        if (event.data instanceof ArrayBuffer) {
+            this.handleBinaryMessage(event.data);
+            return;
+        }
         let eventData = null;
         if (event.data != null) {
             eventData = JSON.parse(event.data);
@@ -193,9 +221,13 @@ class WSControl {
         let pk: WatchScreenPacketType = {
             type: "watchscreen",
             connect: connect,
+            dropdown: connect && this.dropdown,
             sessionid: null,
             screenid: null,
             authkey: this.authKey,
+            clientversion: appconst.VERSION,
+            protocolversion: appconst.ProtocolVersion,
+            capabilities: appconst.ClientCapabilities,
         };
         if (this.watchSessionId != null) {
             pk.sessionid = this.watchSessionId;
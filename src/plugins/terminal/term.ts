@@ -49,8 +49,21 @@ type TermWrapOpts = {
     fontFamily: string;
     ptyDataSource: (termContext: TermContextUnion) => Promise<PtyDataType>;
     onUpdateContentHeight: (termContext: RendererContext, height: number) => void;
+    encoding?: string; // output encoding (linestate "wave:encoding"), null for utf-8
 };
 
+function makeDecoder(encoding: string): TextDecoder {
+    if (encoding == null || encoding == "utf-8") {
+        return null;
+    }
+    try {
+        return new TextDecoder(encoding);
+    } catch (e) {
+        console.log("unsupported terminal encoding", encoding, e);
+        return null;
+    }
+}
+
 function getThemeFromCSSVars(el: Element): ITheme {
     const theme: ITheme = {};
     const rootStyle = getComputedStyle(el);
@@ -103,6 +116,10 @@ class TermWrap {
     initializing: boolean;
     dataHandler?: (data: string, termWrap: TermWrap) => void;
     serializeAddon: SerializeAddon;
+    encoding: string;
+    decoder: TextDecoder;
+    playbackId: string = null; // set while a playback (/line:playback) is writing to the terminal
+    burstRowsTimeoutId: any = null; // deferred updateUsedRows while the command is in an output burst
 
     constructor(elem: Element, opts: TermWrapOpts) {
         opts = opts ?? ({} as any);
@@ -116,6 +133,8 @@ class TermWrap {
         this.fontFamily = opts.fontFamily;
         this.ptyDataSource = opts.ptyDataSource;
         this.onUpdateContentHeight = opts.onUpdateContentHeight;
+        this.encoding = opts.encoding;
+        this.decoder = makeDecoder(opts.encoding);
         this.initializing = true;
         if (this.flexRows) {
             this.atRowMax = false;
@@ -238,6 +257,10 @@ class TermWrap {
     }
 
     dispose() {
+        if (this.burstRowsTimeoutId != null) {
+            clearTimeout(this.burstRowsTimeoutId);
+            this.burstRowsTimeoutId = null;
+        }
         if (this.terminal != null) {
             this.terminal.dispose();
             this.terminal = null;
@@ -410,6 +433,17 @@ class TermWrap {
         this.updateUsedRows(true, "term-reset");
         this.dataUpdates = [];
         this.numParseErrors = 0;
+        this.decoder = makeDecoder(this.encoding);
+    }
+
+    // reloads the output with the new encoding
+    setEncoding(encoding: string): void {
+        if (encoding == this.encoding) {
+            return;
+        }
+        this.encoding = encoding;
+        this.decoder = makeDecoder(encoding);
+        this.reload(0);
     }
 
     reload(delayMs: number) {
@@ -441,7 +475,7 @@ class TermWrap {
         });
     }
 
-    receiveData(pos: number, data: Uint8Array, reason?: string) {
+    receiveData(pos: number, data: Uint8Array, reason?: string, renderHint?: PtyRenderHintType) {
         // console.log("update-pty-data", reason, "line:" + this.getLineNum(), pos, data.length, "=>", pos + data.length);
         if (this.initializing) {
             return;
@@ -452,6 +486,10 @@ class TermWrap {
         if (this.loadError.get()) {
             return;
         }
+        if (this.playbackId != null) {
+            // the output is reloaded when the playback ends
+            return;
+        }
         if (this.reloading) {
             this.dataUpdates.push({ data: data, pos: pos });
             return;
@@ -470,11 +508,54 @@ class TermWrap {
             pos += diff;
         }
         this.ptyPos += data.length;
-        this.terminal.write(data, () => {
+        const termData = this.decoder == null ? data : this.decoder.decode(data, { stream: true });
+        this.terminal.write(termData, () => {
+            if (renderHint?.burst) {
+                this.deferUsedRowsForBurst(renderHint.holdms);
+                return;
+            }
             this.updateUsedRows(false, "updatePtyData");
         });
     }
 
+    // while the server reports an output burst, the row count is updated once per holdMs instead of per chunk
+    deferUsedRowsForBurst(holdMs: number) {
+        if (this.burstRowsTimeoutId != null) {
+            return;
+        }
+        this.burstRowsTimeoutId = setTimeout(() => {
+            this.burstRowsTimeoutId = null;
+            this.updateUsedRows(false, "updatePtyData-burst");
+        }, holdMs);
+    }
+
+    startPlayback(playbackId: string): void {
+        if (this.terminal == null) {
+            return;
+        }
+        this.hardResetTerminal();
+        this.playbackId = playbackId;
+    }
+
+    playbackData(playbackId: string, data: Uint8Array): void {
+        if (this.terminal == null || playbackId != this.playbackId) {
+            return;
+        }
+        const termData = this.decoder == null ? data : this.decoder.decode(data, { stream: true });
+        this.terminal.write(termData, () => {
+            this.updateUsedRows(false, "playback");
+        });
+    }
+
+    // shows the full output again
+    endPlayback(playbackId: string): void {
+        if (playbackId != this.playbackId) {
+            return;
+        }
+        this.playbackId = null;
+        this.reload(0);
+    }
+
     cmdDone(): void {
         this.isRunning = false;
         this.updateUsedRows(true, "cmd-done");
@@ -56,21 +56,36 @@ declare global {
         pinned?: boolean;
         ephemeral?: boolean;
         remove?: boolean;
+        daystr?: string;
+        tags?: string[];
     };
 
     type ScreenOptsType = {
         tabcolor?: string;
         tabicon?: string;
         pterm?: string;
+        nonotify?: boolean;
+        remotelock?: boolean;
+        startupcmds?: string[];
+        idlekillhours?: number;
+        shellpref?: string;
     };
 
     type WebShareOpts = {
         sharename: string;
         viewkey: string;
+        viewurl?: string;
     };
 
     type ScreenViewOptsType = {
         sidebar: ScreenSidebarOptsType;
+        filters?: ScreenFilterType[];
+        activefilter?: ScreenFilterType;
+    };
+
+    type ScreenFilterType = {
+        name: string;
+        tags: string[];
     };
 
     type ScreenSidebarOptsType = {
@@ -94,13 +109,62 @@ declare global {
         selectedline: number;
         focustype: FocusTypeStrs;
         anchor: { anchorline: number; anchoroffset: number };
+        panelayout?: ScreenPaneLayoutType;
 
         // for updates
         remove?: boolean;
     };
 
+    type PaneSplitType = "horizontal" | "vertical";
+
+    type ScreenPaneType = {
+        paneid: string;
+        split?: PaneSplitType;
+        ratio?: number;
+        children?: ScreenPaneType[];
+        curremote?: RemotePtrType;
+        selectedline?: number;
+    };
+
+    type ScreenPaneLayoutType = {
+        root: ScreenPaneType;
+        activepaneid: string;
+    };
+
     type RemoteOptsType = {
         color: string;
+        cmdallow?: string[];
+        cmddeny?: string[];
+        tags?: string[];
+        preconnect?: PreConnectHookType[];
+        initscripts?: string[];
+        idlekillhours?: number;
+        sudocredminutes?: number;
+        serialopts?: SerialOptsType;
+    };
+
+    type SerialOptsType = {
+        baudrate: number;
+        parity: "none" | "even" | "odd";
+        databits: number;
+        stopbits: number;
+    };
+
+    type PreConnectHookType = {
+        hooktype: "wol" | "cmd" | "waitport";
+        macaddr?: string;
+        broadcastaddr?: string;
+        command?: string;
+        host?: string;
+        port?: number;
+        timeout?: number;
+    };
+
+    type RemotePolicyMetaType = {
+        tags?: string[];
+        bannertext?: string;
+        confirmdestructive?: boolean;
+        tabcolor?: string;
     };
 
     type RemoteType = {
@@ -132,6 +196,65 @@ declare global {
         remove?: boolean;
         shellpref: string;
         defaultshelltype: string;
+        policymeta?: RemotePolicyMetaType;
+        hosthealth?: RemoteHostHealthType;
+        preconnectstatus?: string;
+        groupid?: string;
+        sudoauth?: RemoteSudoAuthType;
+    };
+
+    type RemoteSudoAuthType = {
+        status: "none" | "active" | "expired" | "dropped";
+        authts?: number;
+        expirets?: number;
+        credminutes?: number;
+    };
+
+    type RemoteGroupType = {
+        groupid: string;
+        name: string;
+        groupidx: number;
+
+        // for updates
+        remove?: boolean;
+    };
+
+    type RemoteHealthType = {
+        remoteid: string;
+        lastpingts: number;
+        lastlatencyms: number;
+        avglatencyms: number;
+        numpings: number;
+        numfailedpings: number;
+        numdrops: number;
+        lastdropts: number;
+        numreconnects: number;
+        lastreconnectts: number;
+        reconnectts?: number;
+        reconnectattempt?: number;
+
+        // for updates
+        remove?: boolean;
+    };
+
+    type RemoteMetricsType = {
+        remoteid: string;
+        ts: number;
+        cpupct: number; // -1 if not available
+        numcpu: number;
+        memtotal: number;
+        memused: number;
+        loadavg?: number[];
+        diskfree: number;
+        disktotal: number;
+    };
+
+    type RemoteHostHealthType = {
+        diskfree: number;
+        disktotal: number;
+        loadavg?: number[];
+        uptime: number;
+        updatets: number;
     };
 
     type RemoteStateType = {
@@ -171,6 +294,8 @@ declare global {
         remote: RemotePtrType;
         ismetacmd: boolean;
         historynum: string;
+        usecount?: number;
+        repeatcount?: number;
         linenum: number;
     };
 
@@ -183,6 +308,7 @@ declare global {
     type UIContextType = {
         sessionid: string;
         screenid: string;
+        windowid?: string;
         remote: RemotePtrType;
         winsize: TermWinSize;
         linenum: number;
@@ -228,12 +354,107 @@ declare global {
         inputdata64: string;
     };
 
+    type LineDiffSideType = {
+        screenid: string;
+        lineid: string;
+        linenum: number;
+        ts: number;
+        cmdstr: string;
+        remote: RemotePtrType;
+        status: string;
+        exitcode: number;
+        numlines: number;
+        truncated?: boolean;
+    };
+
+    type DiffChunkType = {
+        op: "equal" | "delete" | "insert";
+        starta: number;
+        startb: number;
+        lines: string[];
+    };
+
+    type LineDiffType = {
+        a: LineDiffSideType;
+        b: LineDiffSideType;
+        chunks: DiffChunkType[];
+        numdeleted: number;
+        numinserted: number;
+        identical?: boolean;
+        approx?: boolean;
+    };
+
     type WatchScreenPacketType = {
         type: string;
         sessionid: string;
         screenid: string;
         connect: boolean;
+        dropdown?: boolean;
         authkey: string;
+        clientversion?: string;
+        protocolversion?: number;
+        capabilities?: string[];
+    };
+
+    // the version/capability handshake report (sent with the connect update)
+    type CompatReportType = {
+        serverversion: string;
+        protocolversion: number;
+        clientversion?: string;
+        clientprotocolversion: number;
+        compatible: boolean;
+        error?: string;
+        enabled: string[];
+        disabled?: string[];
+        unknown?: string[];
+    };
+
+    type CmdStatType = {
+        cmdstr: string;
+        numcmds: number;
+        numerrors: number;
+        failurerate: number;
+        numtimed: number;
+        avgdurationms: number;
+        maxdurationms: number;
+        lastts?: number;
+    };
+
+    type RemoteStatType = {
+        remoteid: string;
+        remotename: string;
+        numcmds: number;
+        numerrors: number;
+        failurerate: number;
+        numtimed: number;
+        avgdurationms: number;
+        maxdurationms: number;
+    };
+
+    type StatsBucketType = {
+        ts: number;
+        numcmds: number;
+        numerrors: number;
+        avgdurationms: number;
+    };
+
+    // exit code and duration statistics (/history:stats), buckets are sparse (bucketms apart)
+    type CommandStatsType = {
+        numcmds: number;
+        numerrors: number;
+        failurerate: number;
+        numtimed: number;
+        avgdurationms: number;
+        maxdurationms: number;
+        mints: number;
+        maxts: number;
+        topcmds: CmdStatType[];
+        failingcmds: CmdStatType[];
+        slowestcmds: CmdStatType[];
+        remotes: RemoteStatType[];
+        exitcodes: { exitcode: number; numcmds: number }[];
+        bucketms: number;
+        buckets: StatsBucketType[];
     };
 
     type CmdInputTextPacketType = {
@@ -273,10 +494,19 @@ declare global {
         durationms: number;
         runout: any[];
         rtnstate: boolean;
+        usage?: CmdUsageType;
         remove?: boolean;
         restarted?: boolean;
     };
 
+    type CmdUsageType = {
+        maxrsskb: number;
+        usertimems: number;
+        systimems: number;
+        inblock: number;
+        outblock: number;
+    };
+
     type LineUpdateType = {
         line: LineType;
         cmd: CmdDataType;
@@ -289,12 +519,25 @@ declare global {
         ptypos: number;
         ptydata64: string;
         ptydatalen: number;
+        renderhint?: PtyRenderHintType;
+        ptydata?: Uint8Array; // set (instead of ptydata64) for binary pty frames (see ws.ts)
+    };
+
+    type PtyRenderHintType = {
+        burst: boolean;
+        burstbytes: number;
+        ratebps: number;
+        expectbytes: number;
+        holdms: number;
     };
 
     type ScreenLinesType = {
         screenid: string;
         lines: LineType[];
         cmds: CmdDataType[];
+        chunkid?: string;
+        chunkidx?: number;
+        numchunks?: number;
     };
 
     type OpenAIPacketOutputType = {
@@ -311,6 +554,16 @@ declare global {
         userquery?: string;
     };
 
+    type OpenAICmdInfoAttachmentType = {
+        lineid: string;
+        linenum: number;
+        cmdstr: string;
+        exitcode: number;
+        output: string;
+        truncated?: boolean;
+        ts: number;
+    };
+
     type DropdownItem = {
         label: string;
         value?: string;
@@ -345,7 +598,153 @@ declare global {
         screenstatusindicators: ScreenStatusIndicatorUpdateType[];
         screennumrunningcommands: ScreenNumRunningCommandsUpdateType[];
         activesessionid: string;
+        windows?: WindowDataType[];
+        remotegroups?: RemoteGroupType[];
+        remotehealth?: RemoteHealthType[];
+        remotemetrics?: Record<string, RemoteMetricsType[]>;
         termthemes: TermThemesType;
+        dropdown?: boolean;
+        screeninputs?: ScreenInputType[];
+    };
+
+    type ScreenInputType = {
+        screenid: string;
+        inputtext: StrWithPos;
+        history: string[];
+        updatedts?: number;
+    };
+
+    type WindowDataType = {
+        windowid: string;
+        name: string;
+        activesessionid: string;
+        activescreenid?: string;
+        winsize: ClientWinSize;
+        createdts: number;
+
+        // for updates
+        remove?: boolean;
+    };
+
+    type TransferType = {
+        transferid: string;
+        srcremoteid: string;
+        srcpath: string;
+        dstremoteid: string;
+        dstpath: string;
+        status: "running" | "done" | "error" | "verifyfailed";
+        totalbytes: number;
+        bytesdone: number;
+        startoffset: number;
+        sha256?: string;
+        errorstr?: string;
+        startts: number;
+        endts?: number;
+    };
+
+    type PlaybackUpdateType = {
+        playbackid: string;
+        screenid: string;
+        lineid: string;
+        reset?: boolean;
+        data64?: string;
+        done?: boolean;
+    };
+
+    type TranscriptEntryType = {
+        lineid: string;
+        linenum: number;
+        linetype: string;
+        ts: number;
+        cmdstr?: string;
+        text?: string;
+        status?: string;
+        exitcode?: number;
+        durationms?: number;
+        annotation: string;
+        output?: string[];
+        outputlines: number;
+        omittedlines?: number;
+    };
+
+    type TranscriptUpdateType = {
+        screenid: string;
+        reset?: boolean;
+        entries?: TranscriptEntryType[];
+        removelineids?: string[];
+    };
+
+    type PresenceClientType = {
+        clientid: string;
+        name: string;
+        write: boolean;
+        cursorlineid?: string;
+        connectts: number;
+    };
+
+    type PresenceUpdateType = {
+        screenid: string;
+        clients: PresenceClientType[];
+    };
+
+    type EnvVarType = {
+        name: string;
+        value?: string;
+        secretfile?: string;
+    };
+
+    type EnvProfileType = {
+        profileid: string;
+        name: string;
+        vars: EnvVarType[];
+        createdts: number;
+    };
+
+    type EnvProfileAttachType = {
+        targettype: "screen" | "ri";
+        targetid: string;
+        profileid: string;
+        attachts: number;
+    };
+
+    type EnvProfilesUpdateType = {
+        profiles: EnvProfileType[];
+        attachments: EnvProfileAttachType[];
+    };
+
+    type DirBookmarkType = {
+        bookmarkid: string;
+        remoteid: string;
+        name: string;
+        cwd: string;
+        createdts: number;
+        lastusedts: number;
+        usecount: number;
+    };
+
+    type DirBookmarksUpdateType = {
+        remoteid: string;
+        bookmarks: DirBookmarkType[];
+    };
+
+    type SnippetType = {
+        snippetid: string;
+        name: string;
+        description: string;
+        cmdstr: string;
+        remoteid?: string;
+        remotetag?: string;
+        createdts: number;
+        lastusedts: number;
+        usecount: number;
+    };
+
+    type SnippetsUpdateType = {
+        snippets: SnippetType[];
+    };
+
+    type TransferHistoryType = {
+        transfers: TransferType[];
     };
 
     type BookmarksUpdateType = {
@@ -363,10 +762,30 @@ declare global {
         items?: ModelUpdateItemType[];
     };
 
+    type SuggestionType = {
+        name: string;
+        description?: string;
+        type: "command" | "subcommand" | "option" | "arg" | "history";
+        provider: string;
+        score: number;
+        replacelen: number;
+    };
+
+    type SuggestionsType = {
+        cmdline: string;
+        suggestions: SuggestionType[];
+    };
+
     type ModelUpdateItemType = {
         interactive: boolean;
         session?: SessionDataType;
         activesessionid?: string;
+        window?: WindowDataType;
+        remotegroup?: RemoteGroupType;
+        remotehealth?: RemoteHealthType;
+        compat?: CompatReportType;
+        clearinfo?: boolean;
+        historystats?: CommandStatsType;
         screen?: ScreenDataType;
         screenlines?: ScreenLinesType;
         line?: LineUpdateType;
@@ -381,13 +800,59 @@ declare global {
         clientdata?: ClientDataType;
         remoteview?: RemoteViewType;
         openaicmdinfochat?: OpenAICmdInfoChatMessageType[];
+        openaicmdinfoattachments?: OpenAICmdInfoAttachmentType[];
         alertmessage?: AlertMessageType;
         screenstatusindicator?: ScreenStatusIndicatorUpdateType;
         screennumrunningcommands?: ScreenNumRunningCommandsUpdateType;
+        notify?: NotifyUpdateType;
         userinputrequest?: UserInputRequest;
         screentombstone?: any;
         sessiontombstone?: any;
         termthemes?: TermThemesType;
+        transfer?: TransferType;
+        transferhistory?: TransferHistoryType;
+        playback?: PlaybackUpdateType;
+        transcript?: TranscriptUpdateType;
+        presence?: PresenceUpdateType;
+        envprofiles?: EnvProfilesUpdateType;
+        dirbookmarks?: DirBookmarksUpdateType;
+        snippets?: SnippetsUpdateType;
+        suggestions?: SuggestionsType;
+        completionspec?: { name: string; spec?: Fig.Subcommand };
+        bulkop?: BulkOpType;
+        currentcontext?: CurrentContextType;
+    };
+
+    type CurrentContextType = {
+        sessionid: string;
+        sessionname: string;
+        screenid: string;
+        screenname: string;
+        remote: RemotePtrType;
+        remotename: string;
+        cwd?: string;
+        gitbranch?: string;
+        lastcmd?: {
+            lineid: string;
+            linenum: number;
+            cmdstr: string;
+            status: string;
+            exitcode: number;
+            donets?: number;
+        };
+    };
+
+    type BulkOpType = {
+        opid: string;
+        optype: "archive" | "delete" | "sessiondelete";
+        sessionid: string;
+        status: "running" | "done" | "canceled" | "error";
+        numtotal: number;
+        numdone: number;
+        numskipped: number;
+        errorstr?: string;
+        startts: number;
+        endts?: number;
     };
 
     type TermThemesType = {
@@ -487,7 +952,12 @@ declare global {
         data: PtyDataUpdateType;
     };
 
-    type UpdatePacket = ModelUpdatePacket | PtyDataUpdatePacket;
+    type RemoteMetricsUpdatePacket = {
+        type: "remotemetrics";
+        data: RemoteMetricsType;
+    };
+
+    type UpdatePacket = ModelUpdatePacket | PtyDataUpdatePacket | RemoteMetricsUpdatePacket;
 
     type RendererContext = {
         screenId: string;
@@ -625,6 +1095,20 @@ declare global {
         globalshortcutenabled: boolean;
         webgl: boolean;
         autocompleteenabled: boolean = true;
+        ptyarchivedays?: number;
+        cmddonenotifysecs?: number;
+        maxlinestatesize?: number;
+        timezone?: string;
+        locale?: string;
+        dropdownsessionid?: string;
+        dropdownscreenid?: string;
+        webshareurl?: string;
+        websharetoken?: string;
+        demomode?: boolean;
+        blockflushms?: number;
+        blockdirtykb?: number;
+        blocksync?: string;
+        aiexplainerrors?: boolean;
     };
 
     type ReleaseInfoType = {
@@ -686,6 +1170,15 @@ declare global {
         remove: boolean;
     };
 
+    type NotifyUpdateType = {
+        screenid?: string;
+        sessionid?: string;
+        lineid?: string;
+        title: string;
+        body?: string;
+        urgency?: "low" | "normal" | "critical";
+    };
+
     type AlertMessageType = {
         title?: string;
         message: string;
@@ -970,6 +1463,8 @@ declare global {
         onToggleDevUI: (callback: () => void) => void;
         showContextMenu: (menu: ElectronContextMenuItem[], position: { x: number; y: number }) => void;
         onContextMenuClick: (callback: (id: string) => void) => void;
+        showNotification: (notify: NotifyUpdateType) => void;
+        onNotificationClick: (callback: (notify: NotifyUpdateType) => void) => void;
         pathBaseName: (path: string) => string;
         pathDirName: (path: string) => string;
         pathSep: () => string;
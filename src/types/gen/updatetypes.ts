@@ -0,0 +1,974 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// generated by wavesrv/cmd/gentypes (scripthaus run generate-updatetypes), do not edit.
+// the update types wavesrv sends to the frontend (see wavesrv/pkg/scbus/updateregistry.go).
+
+export {};
+
+declare global {
+    namespace GenUpdateTypes {
+        type AlertMessageType = {
+            title?: string;
+            message: string;
+            confirm?: boolean;
+            markdown?: boolean;
+        };
+
+        type BookmarkType = {
+            bookmarkid: string;
+            createdts: number;
+            cmdstr: string;
+            alias?: string;
+            tags: string[] | null;
+            description: string;
+            orderidx: number;
+            remove?: boolean;
+        };
+
+        type BookmarksUpdate = {
+            bookmarks: (BookmarkType | null)[] | null;
+            selectedbookmark?: string;
+        };
+
+        type BulkOpType = {
+            opid: string;
+            optype: string;
+            sessionid: string;
+            status: string;
+            numtotal: number;
+            numdone: number;
+            numskipped: number;
+            errorstr?: string;
+            startts: number;
+            endts?: number;
+        };
+
+        type ClientData = {
+            clientid: string;
+            userid: string;
+            activesessionid: string;
+            winsize: ClientWinSizeType;
+            clientopts: ClientOptsType;
+            feopts: FeOptsType;
+            cmdstoretype: string;
+            dbversion: number;
+            openaiopts?: OpenAIOptsType | null;
+            releaseinfo: ReleaseInfoType;
+        };
+
+        type ClientOptsType = {
+            notelemetry?: boolean;
+            noreleasecheck?: boolean;
+            acceptedtos?: number;
+            confirmflags?: { [key: string]: boolean } | null;
+            mainsidebar?: SidebarValueType | null;
+            rightsidebar?: SidebarValueType | null;
+            globalshortcut?: string;
+            globalshortcutenabled?: boolean;
+            webgl?: boolean;
+            autocompleteenabled?: boolean;
+            ptyarchivedays?: number;
+            cmddonenotifysecs?: number;
+            maxlinestatesize?: number;
+            timezone?: string;
+            locale?: string;
+            dropdownsessionid?: string;
+            dropdownscreenid?: string;
+            webshareurl?: string;
+            websharetoken?: string;
+            demomode?: boolean;
+            blockflushms?: number;
+            blockdirtykb?: number;
+            blocksync?: string;
+            aiexplainerrors?: boolean;
+            backupdir?: string;
+            backuphours?: number;
+            backupkeep?: number;
+            trashdays?: number;
+            tmuxcontrol?: boolean;
+            autoarchivedays?: number;
+            sshattachaddr?: string;
+            metricsinterval?: number;
+            metricsport?: number;
+            completionspecdir?: string;
+        };
+
+        type ClientWinSizeType = {
+            width: number;
+            height: number;
+            top: number;
+            left: number;
+            fullscreen?: boolean;
+        };
+
+        type CmdLineUpdate = {
+            str: string;
+            pos: number;
+        };
+
+        type CmdStatType = {
+            cmdstr: string;
+            numcmds: number;
+            numerrors: number;
+            failurerate: number;
+            numtimed: number;
+            avgdurationms: number;
+            maxdurationms: number;
+            lastts: number;
+        };
+
+        type CmdType = {
+            screenid: string;
+            lineid: string;
+            remote: RemotePtrType;
+            cmdstr: string;
+            rawcmdstr: string;
+            festate: { [key: string]: string } | null;
+            state: ShellStatePtr;
+            termopts: TermOpts;
+            origtermopts: TermOpts;
+            status: string;
+            cmdpid: number;
+            remotepid: number;
+            restartts?: number;
+            donets: number;
+            exitcode: number;
+            durationms: number;
+            runout?: any[] | null;
+            rtnstate?: boolean;
+            rtnstateptr: ShellStatePtr;
+            usage?: CmdUsageType | null;
+            remove?: boolean;
+            restarted?: boolean;
+        };
+
+        type CmdUsageType = {
+            maxrsskb: number;
+            usertimems: number;
+            systimems: number;
+            inblock: number;
+            outblock: number;
+        };
+
+        type CommandStatsType = {
+            numcmds: number;
+            numerrors: number;
+            failurerate: number;
+            numtimed: number;
+            avgdurationms: number;
+            maxdurationms: number;
+            mints: number;
+            maxts: number;
+            topcmds: (CmdStatType | null)[] | null;
+            failingcmds: (CmdStatType | null)[] | null;
+            slowestcmds: (CmdStatType | null)[] | null;
+            remotes: (RemoteStatType | null)[] | null;
+            exitcodes: (ExitCodeStatType | null)[] | null;
+            bucketms: number;
+            buckets: (StatsBucketType | null)[] | null;
+        };
+
+        type CompatReportType = {
+            serverversion: string;
+            protocolversion: number;
+            clientversion?: string;
+            clientprotocolversion: number;
+            compatible: boolean;
+            error?: string;
+            enabled: string[] | null;
+            disabled?: string[] | null;
+            unknown?: string[] | null;
+        };
+
+        type CompletionSpecType = {
+            name: string;
+            spec?: Spec | null;
+        };
+
+        type ConnectUpdate = {
+            sessions?: (SessionType | null)[] | null;
+            screens?: (ScreenType | null)[] | null;
+            remotes?: (RemoteRuntimeState | null)[] | null;
+            remotegroups?: (RemoteGroupType | null)[] | null;
+            remotehealth?: (RemoteHealthType | null)[] | null;
+            remotemetrics?: { [key: string]: (RemoteMetrics | null)[] | null } | null;
+            screenstatusindicators?: (ScreenStatusIndicatorType | null)[] | null;
+            screennumrunningcommands?: (ScreenNumRunningCommandsType | null)[] | null;
+            activesessionid?: string;
+            windows?: (WindowType | null)[] | null;
+            termthemes?: { [key: string]: { [key: string]: string } | null } | null;
+            dropdown?: boolean;
+            screeninputs?: (ScreenInputType | null)[] | null;
+        };
+
+        type CurrentContextCmdType = {
+            lineid: string;
+            linenum: number;
+            cmdstr: string;
+            status: string;
+            exitcode: number;
+            donets?: number;
+        };
+
+        type CurrentContextType = {
+            sessionid: string;
+            sessionname: string;
+            screenid: string;
+            screenname: string;
+            remote: RemotePtrType;
+            remotename: string;
+            cwd?: string;
+            gitbranch?: string;
+            lastcmd?: CurrentContextCmdType | null;
+        };
+
+        type DirBookmarkType = {
+            bookmarkid: string;
+            remoteid: string;
+            name: string;
+            cwd: string;
+            createdts: number;
+            lastusedts: number;
+            usecount: number;
+        };
+
+        type DirBookmarksUpdateType = {
+            remoteid: string;
+            bookmarks: (DirBookmarkType | null)[] | null;
+        };
+
+        type EnvProfileAttachType = {
+            targettype: string;
+            targetid: string;
+            profileid: string;
+            attachts: number;
+        };
+
+        type EnvProfileType = {
+            profileid: string;
+            name: string;
+            vars: EnvVarType[] | null;
+            createdts: number;
+        };
+
+        type EnvProfilesUpdateType = {
+            profiles: (EnvProfileType | null)[] | null;
+            attachments: (EnvProfileAttachType | null)[] | null;
+        };
+
+        type EnvVarType = {
+            name: string;
+            value?: string;
+            secretfile?: string;
+        };
+
+        type ExitCodeStatType = {
+            exitcode: number;
+            numcmds: number;
+        };
+
+        type FeOptsType = {
+            termfontsize?: number;
+            termfontfamily?: string;
+            theme?: string;
+            termthemesettings: { [key: string]: string } | null;
+            sudopwstore?: string;
+            sudopwtimeoutms?: number;
+            sudopwtimeout?: number;
+            nosudopwclearonsleep?: boolean;
+        };
+
+        type HistoryInfoType = {
+            historytype: string;
+            sessionid?: string;
+            screenid?: string;
+            items: (HistoryItemType | null)[] | null;
+            show: boolean;
+        };
+
+        type HistoryItemType = {
+            historyid: string;
+            ts: number;
+            userid: string;
+            sessionid: string;
+            screenid: string;
+            lineid: string;
+            haderror: boolean;
+            cmdstr: string;
+            remote: RemotePtrType;
+            ismetacmd: boolean;
+            exitcode?: number | null;
+            durationms?: number | null;
+            festate?: { [key: string]: string } | null;
+            tags?: { [key: string]: boolean } | null;
+            linenum: number;
+            status: string;
+            remove: boolean;
+            historynum: string;
+            usecount?: number;
+            repeatcount?: number;
+        };
+
+        type HistoryViewData = {
+            items: (HistoryItemType | null)[] | null;
+            offset: number;
+            rawoffset: number;
+            nextrawoffset: number;
+            hasmore: boolean;
+            lines: (LineType | null)[] | null;
+            cmds: (CmdType | null)[] | null;
+        };
+
+        type InfoMsgType = {
+            infotitle: string;
+            infoerror?: string;
+            infoerrorcode?: string;
+            infomsg?: string;
+            infomsghtml?: boolean;
+            websharelink?: boolean;
+            infocomps?: string[] | null;
+            infocompssmore?: boolean;
+            infolines?: string[] | null;
+            timeoutms?: number;
+        };
+
+        type LineType = {
+            screenid: string;
+            userid: string;
+            lineid: string;
+            ts: number;
+            linenum: number;
+            linenumtemp?: boolean;
+            linelocal: boolean;
+            linetype: string;
+            linestate: { [key: string]: any } | null;
+            renderer?: string;
+            text?: string;
+            ephemeral?: boolean;
+            contentheight?: number;
+            star?: boolean;
+            archived?: boolean;
+            remove?: boolean;
+            daystr?: string;
+            tags?: string[] | null;
+        };
+
+        type LineUpdate = {
+            line: LineType;
+            cmd: CmdType;
+        };
+
+        type MainViewUpdate = {
+            mainview: string;
+            historyview?: HistoryViewData | null;
+            bookmarksview?: BookmarksUpdate | null;
+        };
+
+        type ModelUpdatePacketType = {
+            type: "model";
+            data: ModelUpdateItemType[] | null;
+        };
+
+        type NotifyUpdateType = {
+            screenid?: string;
+            sessionid?: string;
+            lineid?: string;
+            title: string;
+            body?: string;
+            urgency?: string;
+        };
+
+        type OpenAICmdInfoAttachmentType = {
+            lineid: string;
+            linenum: number;
+            cmdstr: string;
+            exitcode: number;
+            output: string;
+            truncated?: boolean;
+            ts: number;
+        };
+
+        type OpenAICmdInfoChatMessage = {
+            messageid: number;
+            isassistantresponse?: boolean;
+            assistantresponse?: OpenAICmdInfoPacketOutputType | null;
+            userquery?: string;
+            userengineeredquery?: string;
+        };
+
+        type OpenAICmdInfoPacketOutputType = {
+            model?: string;
+            created?: number;
+            finish_reason?: string;
+            message?: string;
+            error?: string;
+        };
+
+        type OpenAIOptsType = {
+            model: string;
+            apitoken: string;
+            baseurl?: string;
+            maxtokens?: number;
+            maxchoices?: number;
+            timeout?: number;
+        };
+
+        type PlaybackUpdateType = {
+            playbackid: string;
+            screenid: string;
+            lineid: string;
+            reset?: boolean;
+            data64?: string;
+            done?: boolean;
+        };
+
+        type PreConnectHookType = {
+            hooktype: string;
+            macaddr?: string;
+            broadcastaddr?: string;
+            command?: string;
+            host?: string;
+            port?: number;
+            timeout?: number;
+        };
+
+        type PresenceClientType = {
+            clientid: string;
+            name: string;
+            write: boolean;
+            cursorlineid?: string;
+            connectts: number;
+        };
+
+        type PresenceUpdateType = {
+            screenid: string;
+            clients: PresenceClientType[] | null;
+        };
+
+        type PtyDataUpdate = {
+            screenid?: string;
+            lineid?: string;
+            remoteid?: string;
+            ptypos: number;
+            ptydata64: string;
+            ptydatalen: number;
+            renderhint?: PtyRenderHint | null;
+        };
+
+        type PtyDataUpdatePacketType = {
+            type: "pty";
+            data: PtyDataUpdate | null;
+        };
+
+        type PtyRenderHint = {
+            burst: boolean;
+            burstbytes: number;
+            ratebps: number;
+            expectbytes: number;
+            holdms: number;
+        };
+
+        type ReleaseInfoType = {
+            latestversion?: string;
+        };
+
+        type RemoteEditType = {
+            remoteedit: boolean;
+            remoteid?: string;
+            errorstr?: string;
+            infostr?: string;
+            keystr?: string;
+            haspassword?: boolean;
+        };
+
+        type RemoteGroupType = {
+            groupid: string;
+            name: string;
+            groupidx: number;
+            remove?: boolean;
+        };
+
+        type RemoteHealthType = {
+            remoteid: string;
+            lastpingts: number;
+            lastlatencyms: number;
+            avglatencyms: number;
+            numpings: number;
+            numfailedpings: number;
+            numdrops: number;
+            lastdropts: number;
+            numreconnects: number;
+            lastreconnectts: number;
+            reconnectts?: number;
+            reconnectattempt?: number;
+            remove?: boolean;
+        };
+
+        type RemoteHostHealthType = {
+            diskfree: number;
+            disktotal: number;
+            loadavg?: number[] | null;
+            uptime: number;
+            updatets: number;
+        };
+
+        type RemoteInstance = {
+            riid: string;
+            name: string;
+            sessionid: string;
+            screenid: string;
+            remoteownerid: string;
+            remoteid: string;
+            festate: { [key: string]: string } | null;
+            shelltype: string;
+            remove?: boolean;
+        };
+
+        type RemoteMetrics = {
+            remoteid: string;
+            ts: number;
+            cpupct: number;
+            numcpu: number;
+            memtotal: number;
+            memused: number;
+            loadavg?: number[] | null;
+            diskfree: number;
+            disktotal: number;
+        };
+
+        type RemoteMetricsUpdatePacketType = {
+            type: "remotemetrics";
+            data: RemoteMetrics | null;
+        };
+
+        type RemoteOptsType = {
+            color: string;
+            cmdallow?: string[] | null;
+            cmddeny?: string[] | null;
+            tags?: string[] | null;
+            preconnect?: (PreConnectHookType | null)[] | null;
+            initscripts?: string[] | null;
+            idlekillhours?: number;
+            sudocredminutes?: number;
+            serialopts?: SerialOptsType | null;
+        };
+
+        type RemotePolicyMetaType = {
+            tags?: string[] | null;
+            bannertext?: string;
+            confirmdestructive?: boolean;
+            tabcolor?: string;
+        };
+
+        type RemotePtrType = {
+            ownerid: string;
+            remoteid: string;
+            name: string;
+        };
+
+        type RemoteRuntimeState = {
+            remotetype: string;
+            remoteid: string;
+            remotealias?: string;
+            remotecanonicalname: string;
+            remotevars: { [key: string]: string } | null;
+            status: string;
+            connecttimeout?: number;
+            countdownactive: boolean;
+            errorstr?: string;
+            installstatus: string;
+            installerrorstr?: string;
+            needswaveshellupgrade?: boolean;
+            noinitpk?: boolean;
+            authtype?: string;
+            connectmode: string;
+            autoinstall: boolean;
+            archived?: boolean;
+            remoteidx: number;
+            groupid?: string;
+            sshconfigsrc: string;
+            uname: string;
+            waveshellversion: string;
+            waitingforpassword?: boolean;
+            local?: boolean;
+            issudo?: boolean;
+            remoteopts?: RemoteOptsType | null;
+            cancomplete?: boolean;
+            shellpref?: string;
+            defaultshelltype?: string;
+            policymeta?: RemotePolicyMetaType | null;
+            hosthealth?: RemoteHostHealthType | null;
+            preconnectstatus?: string;
+            sudoauth?: RemoteSudoAuthType | null;
+        };
+
+        type RemoteStatType = {
+            remoteid: string;
+            remotename: string;
+            numcmds: number;
+            numerrors: number;
+            failurerate: number;
+            numtimed: number;
+            avgdurationms: number;
+            maxdurationms: number;
+        };
+
+        type RemoteSudoAuthType = {
+            status: string;
+            authts?: number;
+            expirets?: number;
+            credminutes?: number;
+        };
+
+        type RemoteViewType = {
+            remoteshowall?: boolean;
+            ptyremoteid?: string;
+            remoteedit?: RemoteEditType | null;
+        };
+
+        type ScreenAnchorType = {
+            anchorline?: number;
+            anchoroffset?: number;
+        };
+
+        type ScreenFilterType = {
+            name: string;
+            tags: string[] | null;
+        };
+
+        type ScreenInputType = {
+            screenid: string;
+            inputtext: StrWithPos;
+            history: string[] | null;
+            updatedts: number;
+        };
+
+        type ScreenLinesType = {
+            screenid: string;
+            lines: (LineType | null)[] | null;
+            cmds: (CmdType | null)[] | null;
+            chunkid?: string;
+            chunkidx?: number;
+            numchunks?: number;
+        };
+
+        type ScreenNumRunningCommandsType = {
+            screenid: string;
+            num: number;
+        };
+
+        type ScreenOptsType = {
+            tabcolor?: string;
+            tabicon?: string;
+            pterm?: string;
+            nonotify?: boolean;
+            remotelock?: boolean;
+            startupcmds?: string[] | null;
+            idlekillhours?: number;
+            shellpref?: string;
+            pinned?: boolean;
+        };
+
+        type ScreenPaneLayout = {
+            root: ScreenPaneType | null;
+            activepaneid: string;
+        };
+
+        type ScreenPaneType = {
+            paneid: string;
+            split?: string;
+            ratio?: number;
+            children?: (ScreenPaneType | null)[] | null;
+            curremote?: RemotePtrType | null;
+            selectedline?: number;
+        };
+
+        type ScreenSidebarOptsType = {
+            open?: boolean;
+            width?: string;
+            sidebarlineid?: string;
+        };
+
+        type ScreenStatusIndicatorType = {
+            screenid: string;
+            status: number;
+        };
+
+        type ScreenTombstoneType = {
+            screenid: string;
+            sessionid: string;
+            name: string;
+            deletedts: number;
+            screenopts: ScreenOptsType;
+        };
+
+        type ScreenType = {
+            sessionid: string;
+            screenid: string;
+            name: string;
+            screenidx: number;
+            screenopts: ScreenOptsType;
+            screenviewopts: ScreenViewOptsType;
+            ownerid: string;
+            sharemode: string;
+            webshareopts?: ScreenWebShareOpts | null;
+            curremote: RemotePtrType;
+            nextlinenum: number;
+            selectedline: number;
+            anchor: ScreenAnchorType;
+            focustype: string;
+            archived?: boolean;
+            archivedts?: number;
+            panelayout?: ScreenPaneLayout | null;
+            lastactivets?: number;
+            remove?: boolean;
+        };
+
+        type ScreenViewOptsType = {
+            sidebar?: ScreenSidebarOptsType | null;
+            filters?: ScreenFilterType[] | null;
+            activefilter?: ScreenFilterType | null;
+        };
+
+        type ScreenWebShareOpts = {
+            sharename: string;
+            viewkey: string;
+            viewurl?: string;
+        };
+
+        type SerialOptsType = {
+            baudrate: number;
+            parity: string;
+            databits: number;
+            stopbits: number;
+        };
+
+        type SessionTombstoneType = {
+            sessionid: string;
+            name: string;
+            deletedts: number;
+        };
+
+        type SessionType = {
+            sessionid: string;
+            name: string;
+            sessionidx: number;
+            activescreenid: string;
+            sharemode: string;
+            notifynum: number;
+            archived?: boolean;
+            archivedts?: number;
+            remotes: (RemoteInstance | null)[] | null;
+            remove?: boolean;
+        };
+
+        type ShellStatePtr = {
+            basehash: string;
+            diffhasharr?: string[] | null;
+        };
+
+        type SidebarValueType = {
+            collapsed: boolean;
+            width: number;
+        };
+
+        type SnippetType = {
+            snippetid: string;
+            name: string;
+            description: string;
+            cmdstr: string;
+            remoteid?: string;
+            remotetag?: string;
+            createdts: number;
+            lastusedts: number;
+            usecount: number;
+        };
+
+        type SnippetsUpdateType = {
+            snippets: (SnippetType | null)[] | null;
+        };
+
+        type Spec = {
+            name: string[] | null;
+            description?: string;
+            subcommands?: (Spec | null)[] | null;
+            options?: (SpecOption | null)[] | null;
+            args?: (SpecArg | null)[] | null;
+        };
+
+        type SpecArg = {
+            name?: string;
+            description?: string;
+            suggestions?: SpecSuggestion[] | null;
+            template?: string[] | null;
+            isOptional?: boolean;
+            isVariadic?: boolean;
+        };
+
+        type SpecOption = {
+            name: string[] | null;
+            description?: string;
+            args?: (SpecArg | null)[] | null;
+            isPersistent?: boolean;
+            isRepeatable?: boolean;
+        };
+
+        type SpecSuggestion = {
+            name: string[] | null;
+            description?: string;
+        };
+
+        type StatsBucketType = {
+            ts: number;
+            numcmds: number;
+            numerrors: number;
+            avgdurationms: number;
+        };
+
+        type StrWithPos = {
+            str: string;
+            pos: number;
+        };
+
+        type SuggestionType = {
+            name: string;
+            description?: string;
+            type: string;
+            provider: string;
+            score: number;
+            replacelen: number;
+        };
+
+        type SuggestionsType = {
+            cmdline: string;
+            suggestions: (SuggestionType | null)[] | null;
+        };
+
+        type TermOpts = {
+            rows: number;
+            cols: number;
+            flexrows?: boolean;
+            maxptysize?: number;
+        };
+
+        type TranscriptEntryType = {
+            lineid: string;
+            linenum: number;
+            linetype: string;
+            ts: number;
+            cmdstr?: string;
+            text?: string;
+            status?: string;
+            exitcode?: number;
+            durationms?: number;
+            annotation: string;
+            output?: string[] | null;
+            outputlines: number;
+            omittedlines?: number;
+        };
+
+        type TranscriptUpdateType = {
+            screenid: string;
+            reset?: boolean;
+            entries?: (TranscriptEntryType | null)[] | null;
+            removelineids?: string[] | null;
+        };
+
+        type TransferHistoryType = {
+            transfers: (TransferType | null)[] | null;
+        };
+
+        type TransferType = {
+            transferid: string;
+            srcremoteid: string;
+            srcpath: string;
+            dstremoteid: string;
+            dstpath: string;
+            status: string;
+            totalbytes: number;
+            bytesdone: number;
+            startoffset: number;
+            sha256?: string;
+            errorstr?: string;
+            startts: number;
+            endts?: number;
+        };
+
+        type UserInputRequestType = {
+            requestid: string;
+            querytext: string;
+            responsetype: string;
+            title: string;
+            markdown: boolean;
+            timeoutms: number;
+            checkboxmsg: string;
+            publictext: boolean;
+        };
+
+        type WindowType = {
+            windowid: string;
+            name: string;
+            activesessionid: string;
+            activescreenid?: string;
+            winsize: ClientWinSizeType;
+            createdts: number;
+            remove?: boolean;
+        };
+
+        type ModelUpdateItemType = {
+            activesessionid?: string;
+            alertmessage?: AlertMessageType;
+            bookmarks?: BookmarksUpdate;
+            bulkop?: BulkOpType;
+            clearinfo?: boolean;
+            clientdata?: ClientData;
+            cmd?: CmdType;
+            cmdline?: CmdLineUpdate;
+            compat?: CompatReportType;
+            completionspec?: CompletionSpecType;
+            connect?: ConnectUpdate;
+            currentcontext?: CurrentContextType;
+            dirbookmarks?: DirBookmarksUpdateType;
+            envprofiles?: EnvProfilesUpdateType;
+            history?: HistoryInfoType;
+            historystats?: CommandStatsType;
+            info?: InfoMsgType;
+            interactive?: boolean;
+            line?: LineUpdate;
+            mainview?: MainViewUpdate;
+            notify?: NotifyUpdateType;
+            openaicmdinfoattachments?: (OpenAICmdInfoAttachmentType | null)[] | null;
+            openaicmdinfochat?: (OpenAICmdInfoChatMessage | null)[] | null;
+            playback?: PlaybackUpdateType;
+            presence?: PresenceUpdateType;
+            remote?: RemoteRuntimeState;
+            remotegroup?: RemoteGroupType;
+            remotehealth?: RemoteHealthType;
+            remoteview?: RemoteViewType;
+            screen?: ScreenType;
+            screenlines?: ScreenLinesType;
+            screennumrunningcommands?: ScreenNumRunningCommandsType;
+            screenstatusindicator?: ScreenStatusIndicatorType;
+            screentombstone?: ScreenTombstoneType;
+            session?: SessionType;
+            sessiontombstone?: SessionTombstoneType;
+            snippets?: SnippetsUpdateType;
+            suggestions?: SuggestionsType;
+            termthemes?: { [key: string]: { [key: string]: string } | null } | null;
+            transcript?: TranscriptUpdateType;
+            transfer?: TransferType;
+            transferhistory?: TransferHistoryType;
+            userinputrequest?: UserInputRequestType;
+            window?: WindowType;
+        };
+
+        type UpdatePacketType = ModelUpdatePacketType | PtyDataUpdatePacketType | RemoteMetricsUpdatePacketType;
+    }
+}
+
+// fails to typecheck (naming the keys) if the frontend's ModelUpdateItemType is missing a model update item key
+type AssertNoMissingKeys<T extends never> = T;
+export type MissingModelUpdateItemKeys = AssertNoMissingKeys<
+    Exclude<keyof GenUpdateTypes.ModelUpdateItemType, keyof ModelUpdateItemType>
+>;
@@ -233,6 +233,13 @@ function genMergeDataMap<ObjType extends IObjType<DataType>, DataType extends ID
     return rtn;
 }
 
+function getPtyData(ptyMsg: PtyDataUpdateType): Uint8Array {
+    if (ptyMsg.ptydata != null) {
+        return ptyMsg.ptydata;
+    }
+    return base64ToArray(ptyMsg.ptydata64);
+}
+
 function boundInt(ival: number, minVal: number, maxVal: number): number {
     if (ival < minVal) {
         return minVal;
@@ -255,14 +262,27 @@ function incObs(inum: mobx.IObservableValue<number>) {
 
 const DOW_STRS = ["Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"];
 
-function getTodayStr(): string {
-    return getDateStr(new Date());
+// if tz (IANA timezone, e.g. from clientopts) is set, the date is computed in that timezone
+function getTodayStr(tz?: string): string {
+    return getDateStrInTz(new Date(), tz);
 }
 
-function getYesterdayStr(): string {
+function getYesterdayStr(tz?: string): string {
     const d = new Date();
     d.setDate(d.getDate() - 1);
-    return getDateStr(d);
+    return getDateStrInTz(d, tz);
+}
+
+function getDateStrInTz(d: Date, tz?: string): string {
+    if (isBlank(tz)) {
+        return getDateStr(d);
+    }
+    try {
+        // en-CA formats as YYYY-MM-DD
+        return new Intl.DateTimeFormat("en-CA", { timeZone: tz }).format(d);
+    } catch (e) {
+        return getDateStr(d);
+    }
 }
 
 function getDateStr(d: Date): string {
@@ -299,6 +319,16 @@ function formatDuration(ms: number): string {
     return hours + "h" + mins + "m";
 }
 
+function formatKB(kb: number): string {
+    if (kb < 1024) {
+        return kb + "KB";
+    }
+    if (kb < 1024 * 1024) {
+        return (kb / 1024).toFixed(1) + "MB";
+    }
+    return (kb / (1024 * 1024)).toFixed(2) + "GB";
+}
+
 function getRemoteConnVal(r: RemoteType): number {
     switch (r.status) {
         case "connected":
@@ -412,6 +442,7 @@ export {
     base64ToString,
     stringToBase64,
     base64ToArray,
+    getPtyData,
     genMergeData,
     genMergeDataMap,
     genMergeSimpleData,
@@ -423,6 +454,7 @@ export {
     getTodayStr,
     getYesterdayStr,
     getDateStr,
+    getDateStrInTz,
     sortAndFilterRemotes,
     makeExternLink,
     isStrEq,
@@ -436,4 +468,5 @@ export {
     ces,
     fireAndForget,
     formatDuration,
+    formatKB,
 };
@@ -60,6 +60,13 @@ const (
 	WriteFilePacketStr      = "writefile"      // rpc
 	WriteFileReadyPacketStr = "writefileready" // rpc-response
 	WriteFileDonePacketStr  = "writefiledone"  // rpc-response
+	ListDirPacketStr        = "listdir"        // rpc
+	ListDirResponseStr      = "listdirresp"    // rpc-response
+	HostStatsPacketStr      = "hoststats"      // rpc
+	HostStatsResponseStr    = "hoststatsresp"  // rpc-response
+	MetricsPacketStr        = "metrics"        // rpc
+	MetricsResponseStr      = "metricsresp"    // rpc-response
+	KeepAlivePacketStr      = "keepalive"      // rpc
 	FileDataPacketStr       = "filedata"
 	FileStatPacketStr       = "filestat"
 	LogPacketStr            = "log" // logging packet (sent from waveshell back to server)
@@ -75,6 +82,8 @@ const (
 const (
 	ShellType_bash = "bash"
 	ShellType_zsh  = "zsh"
+	ShellType_pwsh = "pwsh"
+	ShellType_fish = "fish"
 )
 
 const (
@@ -118,6 +127,13 @@ func init() {
 	TypeStrToFactory[WriteFilePacketStr] = reflect.TypeOf(WriteFilePacketType{})
 	TypeStrToFactory[WriteFileReadyPacketStr] = reflect.TypeOf(WriteFileReadyPacketType{})
 	TypeStrToFactory[WriteFileDonePacketStr] = reflect.TypeOf(WriteFileDonePacketType{})
+	TypeStrToFactory[ListDirPacketStr] = reflect.TypeOf(ListDirPacketType{})
+	TypeStrToFactory[ListDirResponseStr] = reflect.TypeOf(ListDirResponseType{})
+	TypeStrToFactory[HostStatsPacketStr] = reflect.TypeOf(HostStatsPacketType{})
+	TypeStrToFactory[HostStatsResponseStr] = reflect.TypeOf(HostStatsResponseType{})
+	TypeStrToFactory[MetricsPacketStr] = reflect.TypeOf(MetricsPacketType{})
+	TypeStrToFactory[MetricsResponseStr] = reflect.TypeOf(MetricsResponseType{})
+	TypeStrToFactory[KeepAlivePacketStr] = reflect.TypeOf(KeepAlivePacketType{})
 	TypeStrToFactory[LogPacketStr] = reflect.TypeOf(LogPacketType{})
 	TypeStrToFactory[ShellStatePacketStr] = reflect.TypeOf(ShellStatePacketType{})
 	TypeStrToFactory[FileStatPacketStr] = reflect.TypeOf(FileStatPacketType{})
@@ -133,6 +149,10 @@ func init() {
 	var _ RpcPacketType = (*ReInitPacketType)(nil)
 	var _ RpcPacketType = (*StreamFilePacketType)(nil)
 	var _ RpcPacketType = (*WriteFilePacketType)(nil)
+	var _ RpcPacketType = (*ListDirPacketType)(nil)
+	var _ RpcPacketType = (*HostStatsPacketType)(nil)
+	var _ RpcPacketType = (*MetricsPacketType)(nil)
+	var _ RpcPacketType = (*KeepAlivePacketType)(nil)
 
 	var _ RpcResponsePacketType = (*CmdStartPacketType)(nil)
 	var _ RpcResponsePacketType = (*ResponsePacketType)(nil)
@@ -140,6 +160,9 @@ func init() {
 	var _ RpcResponsePacketType = (*FileDataPacketType)(nil)
 	var _ RpcResponsePacketType = (*WriteFileReadyPacketType)(nil)
 	var _ RpcResponsePacketType = (*WriteFileDonePacketType)(nil)
+	var _ RpcResponsePacketType = (*ListDirResponseType)(nil)
+	var _ RpcResponsePacketType = (*HostStatsResponseType)(nil)
+	var _ RpcResponsePacketType = (*MetricsResponseType)(nil)
 	var _ RpcResponsePacketType = (*ShellStatePacketType)(nil)
 
 	var _ RpcFollowUpPacketType = (*FileDataPacketType)(nil)
@@ -470,6 +493,7 @@ type StreamFilePacketType struct {
 	Path      string  `json:"path"`
 	ByteRange []int64 `json:"byterange"`          // works like the http "Range" header (multiple ranges are not allowed)
 	StatOnly  bool    `json:"statonly,omitempty"` // set if you just want the stat response (no data returned)
+	Checksum  bool    `json:"checksum,omitempty"` // with StatOnly, also computes the file's sha256 (FileInfo.Sha256)
 }
 
 func (*StreamFilePacketType) GetType() string {
@@ -492,6 +516,7 @@ type FileInfo struct {
 	Perm     int    `json:"perm"`
 	MimeType string `json:"mimetype,omitempty"`
 	NotFound bool   `json:"notfound,omitempty"` // when NotFound is set, Perm will be set to permission for directory
+	Sha256   string `json:"sha256,omitempty"`   // hex, only set for StreamFilePacketType.Checksum requests
 }
 
 type StreamFileResponseType struct {
@@ -757,6 +782,17 @@ type CmdDonePacketType struct {
 	FinalState        *ShellState     `json:"finalstate,omitempty"`
 	FinalStateDiff    *ShellStateDiff `json:"finalstatediff,omitempty"`
 	FinalStateBasePtr *ShellStatePtr  `json:"finalstatebaseptr,omitempty"`
+	Usage             *CmdUsageType   `json:"usage,omitempty"`
+}
+
+// resource usage of a finished command (from wait4), includes the command's (waited for) child processes.
+// inblock/outblock are filesystem block io operations.
+type CmdUsageType struct {
+	MaxRssKB   int64 `json:"maxrsskb"`
+	UserTimeMs int64 `json:"usertimems"`
+	SysTimeMs  int64 `json:"systimems"`
+	InBlock    int64 `json:"inblock"`
+	OutBlock   int64 `json:"outblock"`
 }
 
 func (*CmdDonePacketType) GetType() string {
@@ -821,7 +857,7 @@ type RunPacketType struct {
 	Type          string          `json:"type"`
 	ReqId         string          `json:"reqid"`
 	CK            base.CommandKey `json:"ck"`
-	ShellType     string          `json:"shelltype"` // added in Wave v0.6.0 (either "bash" or "zsh") (set by remote.go)
+	ShellType     string          `json:"shelltype"` // added in Wave v0.6.0 ("bash", "zsh", "pwsh", or "fish") (set by remote.go)
 	Command       string          `json:"command"`
 	State         *ShellState     `json:"state,omitempty"`
 	StatePtr      *ShellStatePtr  `json:"stateptr,omitempty"`      // added in Wave v0.7.2
@@ -889,6 +925,8 @@ type WriteFilePacketType struct {
 	Type    string `json:"type"`
 	ReqId   string `json:"reqid"`
 	UseTemp bool   `json:"usetemp,omitempty"`
+	Append  bool   `json:"append,omitempty"` // append to the file instead of truncating it (cannot be used with UseTemp)
+	MkDirs  bool   `json:"mkdirs,omitempty"` // create the file's parent directories if they do not exist
 	Path    string `json:"path"`
 }
 
@@ -907,6 +945,7 @@ func MakeWriteFilePacket() *WriteFilePacketType {
 type WriteFileReadyPacketType struct {
 	Type   string `json:"type"`
 	RespId string `json:"reqid"`
+	Offset int64  `json:"offset,omitempty"` // for Append, the size of the file when it was opened
 	Error  string `json:"error,omitempty"`
 }
 
@@ -954,6 +993,178 @@ func MakeWriteFileDonePacket(reqId string) *WriteFileDonePacketType {
 	}
 }
 
+// lists the files in a directory.  entries are returned in one or more ListDirResponse packets (the last one has Done set),
+// entry names are relative to Path (using "/" as the separator).
+type ListDirPacketType struct {
+	Type      string   `json:"type"`
+	ReqId     string   `json:"reqid"`
+	Path      string   `json:"path"`
+	Recursive bool     `json:"recursive,omitempty"`
+	Exclude   []string `json:"exclude,omitempty"`  // glob patterns, matched against the relative path and the base name (excluded dirs are not descended into)
+	Checksum  bool     `json:"checksum,omitempty"` // computes the sha256 of every regular file (FileInfo.Sha256)
+}
+
+func (*ListDirPacketType) GetType() string {
+	return ListDirPacketStr
+}
+
+func (p *ListDirPacketType) GetReqId() string {
+	return p.ReqId
+}
+
+func MakeListDirPacket() *ListDirPacketType {
+	return &ListDirPacketType{Type: ListDirPacketStr}
+}
+
+type ListDirResponseType struct {
+	Type    string      `json:"type"`
+	RespId  string      `json:"respid"`
+	Done    bool        `json:"done,omitempty"`
+	Entries []*FileInfo `json:"entries,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+func (*ListDirResponseType) GetType() string {
+	return ListDirResponseStr
+}
+
+func (p *ListDirResponseType) GetResponseId() string {
+	return p.RespId
+}
+
+func (p *ListDirResponseType) GetResponseDone() bool {
+	return p.Done
+}
+
+func MakeListDirResponse(respId string) *ListDirResponseType {
+	return &ListDirResponseType{
+		Type:   ListDirResponseStr,
+		RespId: respId,
+	}
+}
+
+// quick host health facts (disk space of DiskPath, load average, uptime)
+type HostStatsPacketType struct {
+	Type     string `json:"type"`
+	ReqId    string `json:"reqid"`
+	DiskPath string `json:"diskpath,omitempty"` // defaults to the home directory
+}
+
+func (*HostStatsPacketType) GetType() string {
+	return HostStatsPacketStr
+}
+
+func (p *HostStatsPacketType) GetReqId() string {
+	return p.ReqId
+}
+
+func MakeHostStatsPacket() *HostStatsPacketType {
+	return &HostStatsPacketType{Type: HostStatsPacketStr}
+}
+
+// stats that could not be read are left as zero
+type HostStatsResponseType struct {
+	Type      string    `json:"type"`
+	RespId    string    `json:"respid"`
+	DiskFree  int64     `json:"diskfree"`          // bytes available to unprivileged users
+	DiskTotal int64     `json:"disktotal"`         // bytes
+	LoadAvg   []float64 `json:"loadavg,omitempty"` // 1, 5, and 15 minute load averages
+	Uptime    int64     `json:"uptime"`            // seconds
+	Error     string    `json:"error,omitempty"`
+}
+
+func (*HostStatsResponseType) GetType() string {
+	return HostStatsResponseStr
+}
+
+func (p *HostStatsResponseType) GetResponseId() string {
+	return p.RespId
+}
+
+func (p *HostStatsResponseType) GetResponseDone() bool {
+	return true
+}
+
+func MakeHostStatsResponse(respId string) *HostStatsResponseType {
+	return &HostStatsResponseType{
+		Type:   HostStatsResponseStr,
+		RespId: respId,
+	}
+}
+
+// a system metrics sample (cpu, memory, load, disk), cheap enough to be requested every few seconds
+type MetricsPacketType struct {
+	Type     string `json:"type"`
+	ReqId    string `json:"reqid"`
+	DiskPath string `json:"diskpath,omitempty"` // defaults to the home directory
+}
+
+func (*MetricsPacketType) GetType() string {
+	return MetricsPacketStr
+}
+
+func (p *MetricsPacketType) GetReqId() string {
+	return p.ReqId
+}
+
+func MakeMetricsPacket() *MetricsPacketType {
+	return &MetricsPacketType{Type: MetricsPacketStr}
+}
+
+// the cpu times are cumulative counters (in clock ticks), the cpu usage is computed from the difference
+// between two samples.  metrics that could not be read are left as zero (cpu times are not available on darwin).
+type MetricsResponseType struct {
+	Type      string    `json:"type"`
+	RespId    string    `json:"respid"`
+	Ts        int64     `json:"ts"`
+	NumCpu    int       `json:"numcpu"`
+	CpuTotal  uint64    `json:"cputotal"`
+	CpuIdle   uint64    `json:"cpuidle"` // includes iowait
+	MemTotal  int64     `json:"memtotal"`
+	MemAvail  int64     `json:"memavail"`
+	LoadAvg   []float64 `json:"loadavg,omitempty"` // 1, 5, and 15 minute load averages
+	DiskFree  int64     `json:"diskfree"`          // bytes available to unprivileged users
+	DiskTotal int64     `json:"disktotal"`
+	Error     string    `json:"error,omitempty"`
+}
+
+func (*MetricsResponseType) GetType() string {
+	return MetricsResponseStr
+}
+
+func (p *MetricsResponseType) GetResponseId() string {
+	return p.RespId
+}
+
+func (p *MetricsResponseType) GetResponseDone() bool {
+	return true
+}
+
+func MakeMetricsResponse(respId string) *MetricsResponseType {
+	return &MetricsResponseType{
+		Type:   MetricsResponseStr,
+		RespId: respId,
+	}
+}
+
+// answered immediately with a (success) response, used by the server to measure round-trip latency
+type KeepAlivePacketType struct {
+	Type  string `json:"type"`
+	ReqId string `json:"reqid"`
+}
+
+func (*KeepAlivePacketType) GetType() string {
+	return KeepAlivePacketStr
+}
+
+func (p *KeepAlivePacketType) GetReqId() string {
+	return p.ReqId
+}
+
+func MakeKeepAlivePacket() *KeepAlivePacketType {
+	return &KeepAlivePacketType{Type: KeepAlivePacketStr}
+}
+
 type OpenAICmdInfoChatMessage struct {
 	MessageID           int                            `json:"messageid"`
 	IsAssistantResponse bool                           `json:"isassistantresponse,omitempty"`
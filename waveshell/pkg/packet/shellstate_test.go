@@ -39,7 +39,11 @@ func TestShellVersions(t *testing.T) {
 	if version != "v5.0.17" {
 		t.Errorf("version should be v5.0.17")
 	}
-	_, _, err = ParseShellStateVersion("fish v5.0.17")
+	shell, _, err = ParseShellStateVersion("fish v3.7.1")
+	if err != nil || shell != ShellType_fish {
+		t.Errorf("fish version should be valid, got shell %q error %v", shell, err)
+	}
+	_, _, err = ParseShellStateVersion("tcsh v6.24.10")
 	if err == nil {
 		t.Errorf("version should be invalid")
 	}
@@ -0,0 +1,109 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"golang.org/x/sys/unix"
+)
+
+const hostStatsCmdTimeout = 2 * time.Second
+
+var darwinBootTimeRe = regexp.MustCompile(`sec\s*=\s*(\d+)`)
+
+func parseLoadAvg(str string) []float64 {
+	fields := strings.Fields(strings.Trim(strings.TrimSpace(str), "{}"))
+	if len(fields) < 3 {
+		return nil
+	}
+	rtn := make([]float64, 0, 3)
+	for _, field := range fields[0:3] {
+		val, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil
+		}
+		rtn = append(rtn, val)
+	}
+	return rtn
+}
+
+func runSysctl(name string) (string, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), hostStatsCmdTimeout)
+	defer cancelFn()
+	output, err := exec.CommandContext(ctx, "sysctl", "-n", name).Output()
+	if err != nil {
+		return "", err
+	}
+	return string(output), nil
+}
+
+// returns (loadavg, uptime-seconds)
+func getLoadAndUptime() ([]float64, int64) {
+	if runtime.GOOS == "darwin" {
+		var loadAvg []float64
+		var uptime int64
+		if output, err := runSysctl("vm.loadavg"); err == nil {
+			loadAvg = parseLoadAvg(output)
+		}
+		if output, err := runSysctl("kern.boottime"); err == nil {
+			if match := darwinBootTimeRe.FindStringSubmatch(output); match != nil {
+				bootTs, _ := strconv.ParseInt(match[1], 10, 64)
+				if bootTs > 0 {
+					uptime = time.Now().Unix() - bootTs
+				}
+			}
+		}
+		return loadAvg, uptime
+	}
+	var loadAvg []float64
+	var uptime int64
+	if data, err := os.ReadFile("/proc/loadavg"); err == nil {
+		loadAvg = parseLoadAvg(string(data))
+	}
+	if data, err := os.ReadFile("/proc/uptime"); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) > 0 {
+			uptimeFloat, _ := strconv.ParseFloat(fields[0], 64)
+			uptime = int64(uptimeFloat)
+		}
+	}
+	return loadAvg, uptime
+}
+
+// returns (free, total) bytes of the filesystem of diskPath (defaults to the home directory)
+func statDisk(diskPath string) (int64, int64, error) {
+	if diskPath == "" {
+		diskPath, _ = os.UserHomeDir()
+	}
+	if diskPath == "" {
+		diskPath = "/"
+	}
+	var statfs unix.Statfs_t
+	err := unix.Statfs(diskPath, &statfs)
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot stat filesystem %q: %v", diskPath, err)
+	}
+	return int64(statfs.Bavail) * int64(statfs.Bsize), int64(statfs.Blocks) * int64(statfs.Bsize), nil
+}
+
+func (m *MServer) hostStats(pk *packet.HostStatsPacketType) {
+	resp := packet.MakeHostStatsResponse(pk.ReqId)
+	var err error
+	resp.DiskFree, resp.DiskTotal, err = statDisk(pk.DiskPath)
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	resp.LoadAvg, resp.Uptime = getLoadAndUptime()
+	m.Sender.SendPacket(resp)
+}
@@ -0,0 +1,136 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+)
+
+const vmStatPageSizePrefix = "page size of "
+
+// returns (total, idle) clock ticks from the aggregate cpu line of /proc/stat
+func parseProcStatCpu(data []byte) (uint64, uint64) {
+	line, _, _ := bytes.Cut(data, []byte{'\n'})
+	fields := strings.Fields(string(line))
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0
+	}
+	var total, idle uint64
+	for idx, field := range fields[1:] {
+		val, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0
+		}
+		if idx >= 8 {
+			// guest and guest_nice are already counted in user and nice
+			break
+		}
+		total += val
+		if idx == 3 || idx == 4 {
+			// idle, iowait
+			idle += val
+		}
+	}
+	return total, idle
+}
+
+// returns (MemTotal, MemAvailable) in bytes from /proc/meminfo
+func parseProcMemInfo(data []byte) (int64, int64) {
+	var memTotal, memAvail int64
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		val, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			memTotal = val * 1024
+		case "MemAvailable:":
+			memAvail = val * 1024
+		}
+	}
+	return memTotal, memAvail
+}
+
+// returns the available memory (free, inactive, and speculative pages) from the vm_stat output
+func parseVmStat(output []byte) int64 {
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	var pageSize int64 = 4096
+	var numPages int64
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.Index(line, vmStatPageSizePrefix); idx >= 0 {
+			fields := strings.Fields(line[idx+len(vmStatPageSizePrefix):])
+			if len(fields) > 0 {
+				if val, err := strconv.ParseInt(fields[0], 10, 64); err == nil && val > 0 {
+					pageSize = val
+				}
+			}
+			continue
+		}
+		name, valStr, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		if name != "Pages free" && name != "Pages inactive" && name != "Pages speculative" {
+			continue
+		}
+		val, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(valStr), "."), 10, 64)
+		if err == nil {
+			numPages += val
+		}
+	}
+	return numPages * pageSize
+}
+
+func getDarwinMem() (int64, int64) {
+	var memTotal, memAvail int64
+	if output, err := runSysctl("hw.memsize"); err == nil {
+		memTotal, _ = strconv.ParseInt(strings.TrimSpace(output), 10, 64)
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), hostStatsCmdTimeout)
+	defer cancelFn()
+	if output, err := exec.CommandContext(ctx, "vm_stat").Output(); err == nil {
+		memAvail = parseVmStat(output)
+	}
+	return memTotal, memAvail
+}
+
+func (m *MServer) metrics(pk *packet.MetricsPacketType) {
+	resp := packet.MakeMetricsResponse(pk.ReqId)
+	resp.Ts = time.Now().UnixMilli()
+	resp.NumCpu = runtime.NumCPU()
+	if runtime.GOOS == "darwin" {
+		resp.MemTotal, resp.MemAvail = getDarwinMem()
+	} else {
+		if data, err := os.ReadFile("/proc/stat"); err == nil {
+			resp.CpuTotal, resp.CpuIdle = parseProcStatCpu(data)
+		}
+		if data, err := os.ReadFile("/proc/meminfo"); err == nil {
+			resp.MemTotal, resp.MemAvail = parseProcMemInfo(data)
+		}
+	}
+	resp.LoadAvg, _ = getLoadAndUptime()
+	var err error
+	resp.DiskFree, resp.DiskTotal, err = statDisk(pk.DiskPath)
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	m.Sender.SendPacket(resp)
+}
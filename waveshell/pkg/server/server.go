@@ -5,12 +5,15 @@ package server
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -446,6 +449,15 @@ func (m *MServer) writeFile(pk *packet.WriteFilePacketType, wfc *WriteFileContex
 		m.Sender.SendPacket(resp)
 		return
 	}
+	if pk.MkDirs {
+		err := os.MkdirAll(filepath.Dir(pk.Path), 0o777)
+		if err != nil {
+			resp := packet.MakeWriteFileReadyPacket(pk.ReqId)
+			resp.Error = fmt.Sprintf("write-file could not create parent directories: %v", err)
+			m.Sender.SendPacket(resp)
+			return
+		}
+	}
 	err := checkFileWritable(pk.Path)
 	if err != nil {
 		resp := packet.MakeWriteFileReadyPacket(pk.ReqId)
@@ -453,7 +465,14 @@ func (m *MServer) writeFile(pk *packet.WriteFilePacketType, wfc *WriteFileContex
 		m.Sender.SendPacket(resp)
 		return
 	}
+	if pk.UseTemp && pk.Append {
+		resp := packet.MakeWriteFileReadyPacket(pk.ReqId)
+		resp.Error = "invalid write-file request, cannot use append with usetemp"
+		m.Sender.SendPacket(resp)
+		return
+	}
 	var writeFd *os.File
+	var appendOffset int64
 	if pk.UseTemp {
 		writeFd, err = os.CreateTemp("", "waveshell.writefile.*") // "" means make this file in standard TempDir
 		if err != nil {
@@ -462,6 +481,23 @@ func (m *MServer) writeFile(pk *packet.WriteFilePacketType, wfc *WriteFileContex
 			m.Sender.SendPacket(resp)
 			return
 		}
+	} else if pk.Append {
+		writeFd, err = os.OpenFile(pk.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o666)
+		if err == nil {
+			var finfo os.FileInfo
+			finfo, err = writeFd.Stat()
+			if err == nil {
+				appendOffset = finfo.Size()
+			} else {
+				writeFd.Close()
+			}
+		}
+		if err != nil {
+			resp := packet.MakeWriteFileReadyPacket(pk.ReqId)
+			resp.Error = fmt.Sprintf("write-file could not open file: %v", err)
+			m.Sender.SendPacket(resp)
+			return
+		}
 	} else {
 		writeFd, err = os.OpenFile(pk.Path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o666) // use 666 because OpenFile respects umask
 		if err != nil {
@@ -474,6 +510,7 @@ func (m *MServer) writeFile(pk *packet.WriteFilePacketType, wfc *WriteFileContex
 
 	// ok, so now writeFd is valid, send the "ready" response
 	resp := packet.MakeWriteFileReadyPacket(pk.ReqId)
+	resp.Offset = appendOffset
 	m.Sender.SendPacket(resp)
 
 	// now we wait for data (cond var)
@@ -585,6 +622,14 @@ func (m *MServer) streamFile(pk *packet.StreamFilePacketType) {
 		Perm:     int(finfo.Mode().Perm()),
 	}
 	if pk.StatOnly {
+		if pk.Checksum && !finfo.IsDir() {
+			resp.Info.Sha256, err = fileSha256(pk.Path)
+			if err != nil {
+				resp.Error = fmt.Sprintf("cannot checksum file %q: %v", pk.Path, err)
+				m.Sender.SendPacket(resp)
+				return
+			}
+		}
 		resp.Done = true
 		m.Sender.SendPacket(resp)
 		return
@@ -660,6 +705,110 @@ func (m *MServer) streamFile(pk *packet.StreamFilePacketType) {
 	return
 }
 
+func fileSha256(path string) (string, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, fd)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+const MaxListDirPacketEntries = 1000
+
+func matchesExcludePattern(relPath string, patterns []string) bool {
+	baseName := path.Base(relPath)
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, relPath); matched {
+			return true
+		}
+		if matched, _ := path.Match(pattern, baseName); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// only regular files and directories are returned (symlinks and special files are skipped)
+func (m *MServer) listDir(pk *packet.ListDirPacketType) {
+	finfo, err := os.Stat(pk.Path)
+	if err == nil && !finfo.IsDir() {
+		err = fmt.Errorf("not a directory")
+	}
+	if err != nil {
+		resp := packet.MakeListDirResponse(pk.ReqId)
+		resp.Error = fmt.Sprintf("cannot list directory %q: %v", pk.Path, err)
+		resp.Done = true
+		m.Sender.SendPacket(resp)
+		return
+	}
+	var entries []*packet.FileInfo
+	walkErr := filepath.WalkDir(pk.Path, func(fullPath string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if fullPath == pk.Path {
+			return nil
+		}
+		relPath, err := filepath.Rel(pk.Path, fullPath)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if matchesExcludePattern(relPath, pk.Exclude) {
+			if dirEntry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !dirEntry.IsDir() && !dirEntry.Type().IsRegular() {
+			return nil
+		}
+		entryInfo, err := dirEntry.Info()
+		if err != nil {
+			return err
+		}
+		entry := &packet.FileInfo{
+			Name:  relPath,
+			Size:  entryInfo.Size(),
+			ModTs: entryInfo.ModTime().UnixMilli(),
+			IsDir: entryInfo.IsDir(),
+			Perm:  int(entryInfo.Mode().Perm()),
+		}
+		if entry.IsDir {
+			entry.Size = 0
+		} else if pk.Checksum {
+			entry.Sha256, err = fileSha256(fullPath)
+			if err != nil {
+				return err
+			}
+		}
+		entries = append(entries, entry)
+		if len(entries) >= MaxListDirPacketEntries {
+			resp := packet.MakeListDirResponse(pk.ReqId)
+			resp.Entries = entries
+			m.Sender.SendPacket(resp)
+			entries = nil
+		}
+		if entry.IsDir && !pk.Recursive {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	resp := packet.MakeListDirResponse(pk.ReqId)
+	resp.Entries = entries
+	resp.Done = true
+	if walkErr != nil {
+		resp.Error = fmt.Sprintf("error listing directory %q: %v", pk.Path, walkErr)
+	}
+	m.Sender.SendPacket(resp)
+}
+
 func int64Min(v1 int64, v2 int64) int64 {
 	if v1 < v2 {
 		return v1
@@ -690,6 +839,22 @@ func (m *MServer) ProcessRpcPacket(pk packet.RpcPacketType) {
 		go m.streamFile(streamPk)
 		return
 	}
+	if listPk, ok := pk.(*packet.ListDirPacketType); ok {
+		go m.listDir(listPk)
+		return
+	}
+	if statsPk, ok := pk.(*packet.HostStatsPacketType); ok {
+		go m.hostStats(statsPk)
+		return
+	}
+	if metricsPk, ok := pk.(*packet.MetricsPacketType); ok {
+		go m.metrics(metricsPk)
+		return
+	}
+	if _, ok := pk.(*packet.KeepAlivePacketType); ok {
+		m.Sender.SendResponse(reqId, true)
+		return
+	}
 	if writePk, ok := pk.(*packet.WriteFilePacketType); ok {
 		wfc := &WriteFileContext{
 			CVar:       sync.NewCond(&sync.Mutex{}),
@@ -933,6 +1098,16 @@ func (sm *ShellStateMap) GetStateByHash(shellType string, hash string) *packet.S
 	return sm.StateMap[shellStateMapKey{ShellType: shellType, Hash: hash}]
 }
 
+func (sm *ShellStateMap) GetStateHashes() []string {
+	sm.Lock.Lock()
+	defer sm.Lock.Unlock()
+	var rtn []string
+	for key := range sm.StateMap {
+		rtn = append(rtn, key.Hash)
+	}
+	return rtn
+}
+
 func (sm *ShellStateMap) Clear() {
 	sm.Lock.Lock()
 	defer sm.Lock.Unlock()
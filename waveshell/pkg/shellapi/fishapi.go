@@ -0,0 +1,455 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/alessio/shellescape"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/shellenv"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/utilfn"
+)
+
+// fish (3.2+) support.
+//
+// global and exported fish variables are stored as decls.  exported variables are set through the command's
+// environment (fish splits PATH-like variables on ":"), non-exported variables are set in the rc file.  list
+// values are separated by \x1e.  abbreviations (stored as Aliases) and functions are stored as fish statements
+// separated by null bytes so they can be written directly into the rc file.  functions that can be autoloaded
+// from $fish_function_path are not stored (they are autoloaded again in the new shell).
+
+const BaseFishOpts = ``
+
+const FishShellVersionCmdStr = `printf 'fish v%s\n' (string match -r '^[0-9]+\.[0-9]+(\.[0-9]+)?' -- $version)[1]`
+const RemoteFishPath = "fish"
+
+// fish uses $status (not $?), the exit triggers the fish_exit event (our exit trap)
+const FishReturnStateCmdStr = "\nexit $status"
+
+const FishExitTrapFuncName = "_waveshell_exittrap"
+const FishListSep = "\x1e"
+
+const RunFishSudoCommandFmt = `sudo -n -C %d fish /dev/fd/%d`
+const RunFishSudoPasswordCommandFmt = `cat /dev/fd/%d | sudo -k -S -C %d sh -c "echo '[from-mshell]'; exec %d>&-; fish /dev/fd/%d < /dev/fd/%d"`
+
+// read-only, electric, or per-process variables
+var FishIgnoreVars = map[string]bool{
+	"_":                 true,
+	"argv":              true,
+	"CMD_DURATION":      true,
+	"COLUMNS":           true,
+	"LINES":             true,
+	"dirprev":           true,
+	"dirnext":           true,
+	"FISH_VERSION":      true,
+	"fish_kill_signal":  true,
+	"fish_pid":          true,
+	"fish_private_mode": true,
+	"history":           true,
+	"hostname":          true,
+	"last_pid":          true,
+	"OLDPWD":            true,
+	"pipestatus":        true,
+	"PWD":               true,
+	"SHLVL":             true,
+	"status":            true,
+	"status_generation": true,
+	"umask":             true,
+	"version":           true,
+}
+
+// do not use these directly, call GetLocalMajorVersion()
+var localFishMajorVersionOnce = &sync.Once{}
+var localFishMajorVersion = ""
+
+const (
+	FishSection_Ignored = iota
+	FishSection_Version
+	FishSection_Cwd
+	FishSection_Vars
+	FishSection_Abbrs
+	FishSection_Funcs
+	FishSection_PVars
+	FishSection_Prompt
+	FishSection_EndBytes
+
+	FishSection_Count // must be last
+)
+
+type fishShellApi struct{}
+
+func (f fishShellApi) GetShellType() string {
+	return packet.ShellType_fish
+}
+
+func (f fishShellApi) MakeExitTrap(fdNum int) (string, []byte) {
+	return MakeFishExitTrap(fdNum)
+}
+
+func (f fishShellApi) GetLocalMajorVersion() string {
+	return GetLocalFishMajorVersion()
+}
+
+func (f fishShellApi) GetLocalShellPath() string {
+	return GetLocalFishPath()
+}
+
+func (f fishShellApi) GetRemoteShellPath() string {
+	return RemoteFishPath
+}
+
+func (f fishShellApi) MakeRunCommand(cmdStr string, opts RunCommandOpts) string {
+	if !opts.Sudo {
+		return fmt.Sprintf(RunCommandFmt, cmdStr)
+	}
+	if opts.SudoWithPass {
+		return fmt.Sprintf(RunFishSudoPasswordCommandFmt, opts.PwFdNum, opts.MaxFdNum+1, opts.PwFdNum, opts.CommandFdNum, opts.CommandStdinFdNum)
+	} else {
+		return fmt.Sprintf(RunFishSudoCommandFmt, opts.MaxFdNum+1, opts.CommandFdNum)
+	}
+}
+
+// the user's config is not loaded (the rc file has the captured state)
+func (f fishShellApi) MakeShExecCommand(cmdStr string, rcFileName string, usePty bool) *exec.Cmd {
+	initCmd := "source " + fishQuote(rcFileName)
+	if usePty {
+		return exec.Command(GetLocalFishPath(), "--no-config", "-i", "-C", initCmd, "-c", cmdStr)
+	} else {
+		return exec.Command(GetLocalFishPath(), "--no-config", "-C", initCmd, "-c", cmdStr)
+	}
+}
+
+func (f fishShellApi) GetShellState(ctx context.Context, outCh chan ShellStateOutput, stdinDataCh chan []byte) {
+	GetFishShellState(ctx, outCh, stdinDataCh)
+}
+
+func (f fishShellApi) GetBaseShellOpts() string {
+	return BaseFishOpts
+}
+
+func (f fishShellApi) ParseShellStateOutput(output []byte) (*packet.ShellState, *packet.ShellStateStats, error) {
+	return parseFishShellStateOutput(output)
+}
+
+// exported variables are not set here, they are set in the command's environment
+func (f fishShellApi) MakeRcFileStr(pk *packet.RunPacketType) string {
+	var rcBuf bytes.Buffer
+	rcBuf.WriteString(f.GetBaseShellOpts() + "\n")
+	varDecls := shellenv.VarDeclsFromState(pk.State)
+	for _, varDecl := range varDecls {
+		if varDecl.IsExport() || varDecl.IsExtVar {
+			continue
+		}
+		rcBuf.WriteString(FishSetStmt(varDecl))
+		rcBuf.WriteString("\n")
+	}
+	if pk.State != nil {
+		for _, entry := range SplitFishEntries(pk.State.Funcs) {
+			rcBuf.WriteString(entry)
+			rcBuf.WriteString("\n")
+		}
+		for _, entry := range SplitFishEntries(pk.State.Aliases) {
+			rcBuf.WriteString(entry)
+			rcBuf.WriteString("\n")
+		}
+	}
+	return rcBuf.String()
+}
+
+// fish states are diffed like bash states (abbrs and funcs are split on null bytes, see GetLineDiffSplitString)
+func (f fishShellApi) MakeShellStateDiff(oldState *packet.ShellState, oldStateHash string, newState *packet.ShellState) (*packet.ShellStateDiff, error) {
+	return bashShellApi{}.MakeShellStateDiff(oldState, oldStateHash, newState)
+}
+
+func (f fishShellApi) ApplyShellStateDiff(oldState *packet.ShellState, diff *packet.ShellStateDiff) (*packet.ShellState, error) {
+	return bashShellApi{}.ApplyShellStateDiff(oldState, diff)
+}
+
+func (f fishShellApi) ValidateCommandSyntax(cmdStr string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), ValidateTimeout)
+	defer cancelFn()
+	cmd := exec.CommandContext(ctx, GetLocalFishPath(), "--no-config", "--no-execute", "-c", cmdStr)
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if len(output) == 0 {
+		return errors.New("fish syntax error")
+	}
+	return errors.New(utilfn.GetFirstLine(string(output)))
+}
+
+// quotes a string as a fish single quoted string (only \ and ' are escaped)
+func fishQuote(str string) string {
+	str = strings.ReplaceAll(str, `\`, `\\`)
+	str = strings.ReplaceAll(str, `'`, `\'`)
+	return "'" + str + "'"
+}
+
+func FishSetStmt(varDecl *DeclareDeclType) string {
+	var buf strings.Builder
+	buf.WriteString("set -g ")
+	buf.WriteString(varDecl.Name)
+	value := varDecl.UnescapedValue()
+	if value == "" {
+		// empty list
+		return buf.String()
+	}
+	for _, listVal := range strings.Split(value, FishListSep) {
+		buf.WriteString(" ")
+		buf.WriteString(fishQuote(listVal))
+	}
+	return buf.String()
+}
+
+// fish exports lists of PATH-like variables joined with ":", other lists are joined with spaces
+func fishExportValue(name string, listVals []string) string {
+	if strings.HasSuffix(name, "PATH") {
+		return strings.Join(listVals, ":")
+	}
+	return strings.Join(listVals, " ")
+}
+
+func SplitFishEntries(entriesStr string) []string {
+	if entriesStr == "" {
+		return nil
+	}
+	return strings.Split(entriesStr, "\x00")
+}
+
+func unquoteFishName(name string) string {
+	if len(name) >= 2 && name[0] == '\'' && name[len(name)-1] == '\'' {
+		name = name[1 : len(name)-1]
+		name = strings.ReplaceAll(name, `\'`, `'`)
+		name = strings.ReplaceAll(name, `\\`, `\`)
+	}
+	return name
+}
+
+// returns the name from a "function NAME ..." or "abbr ... -- NAME EXPANSION" entry
+func GetFishEntryName(entry string) string {
+	fields := strings.Fields(entry)
+	if len(fields) < 2 {
+		return ""
+	}
+	if fields[0] == "function" {
+		return unquoteFishName(fields[1])
+	}
+	if fields[0] == "abbr" {
+		for idx, field := range fields {
+			if field == "--" && idx+1 < len(fields) {
+				return unquoteFishName(fields[idx+1])
+			}
+		}
+	}
+	return ""
+}
+
+// returns name => entry
+func DecodeFishEntries(entriesStr string) map[string]string {
+	rtn := make(map[string]string)
+	for _, entry := range SplitFishEntries(entriesStr) {
+		name := GetFishEntryName(entry)
+		if name != "" {
+			rtn[name] = entry
+		}
+	}
+	return rtn
+}
+
+func GetFishShellStateCmd(fdNum int) (string, []byte) {
+	endBytes := utilfn.AppendNonZeroRandomBytes(nil, NumRandomEndBytes)
+	endBytes = append(endBytes, '\n')
+	var ignoreVars []string
+	for name := range FishIgnoreVars {
+		ignoreVars = append(ignoreVars, name)
+	}
+	sort.Strings(ignoreVars)
+	cmdStr := strings.TrimSpace(`
+begin
+printf '\x00\x00'
+[%FISHVERSIONCMD%]
+printf '\x00\x00'
+pwd
+printf '\x00\x00'
+set -l _wave_first 1
+for _wave_name in (begin; set --global --names; set --export --names; end | sort -u)
+    if string match -q -r '^(_wave|__fish_)' -- $_wave_name; or contains -- $_wave_name [%IGNOREVARS%]
+        continue
+    end
+    test -z "$_wave_first"; and printf '\x00'
+    set _wave_first
+    set -l _wave_flags -
+    set -q --export $_wave_name; and set _wave_flags x
+    printf '%s %s' $_wave_flags $_wave_name
+    for _wave_val in $$_wave_name
+        printf '\x1e%s' $_wave_val
+    end
+end
+printf '\x00\x00'
+set _wave_first 1
+for _wave_abbr in (abbr --show)
+    test -z "$_wave_first"; and printf '\x00'
+    set _wave_first
+    printf '%s' $_wave_abbr
+end
+printf '\x00\x00'
+set _wave_first 1
+for _wave_fn in (functions --all --names)
+    if string match -q -r '^(_wave|__fish_)' -- $_wave_fn
+        continue
+    end
+    set -l _wave_autoload 0
+    for _wave_dir in $fish_function_path
+        if test -f "$_wave_dir/$_wave_fn.fish"
+            set _wave_autoload 1
+            break
+        end
+    end
+    test $_wave_autoload = 1; and continue
+    test -z "$_wave_first"; and printf '\x00'
+    set _wave_first
+    functions --no-details $_wave_fn
+end
+printf '\x00\x00'
+printf 'GITBRANCH %s' (git rev-parse --abbrev-ref HEAD 2>/dev/null)
+printf '\x00\x00'
+functions -q fish_prompt; and fish_prompt
+printf '\x00\x00'
+printf '[%ENDBYTES%]'
+end 2>/dev/null >[%OUTPUTFD%]
+`)
+	cmdStr = strings.ReplaceAll(cmdStr, "[%OUTPUTFD%]", fmt.Sprintf("/dev/fd/%d", fdNum))
+	cmdStr = strings.ReplaceAll(cmdStr, "[%FISHVERSIONCMD%]", FishShellVersionCmdStr)
+	cmdStr = strings.ReplaceAll(cmdStr, "[%IGNOREVARS%]", strings.Join(ignoreVars, " "))
+	cmdStr = strings.ReplaceAll(cmdStr, "[%ENDBYTES%]", utilfn.ShellHexEscape(string(endBytes)))
+	return cmdStr, endBytes
+}
+
+func MakeFishExitTrap(fdNum int) (string, []byte) {
+	stateCmd, endBytes := GetFishShellStateCmd(fdNum)
+	fmtStr := `
+function %s --on-event fish_exit
+    %s
+end
+`
+	return fmt.Sprintf(fmtStr, FishExitTrapFuncName, stateCmd), endBytes
+}
+
+func execGetLocalFishShellVersion() string {
+	ctx, cancelFn := context.WithTimeout(context.Background(), GetVersionTimeout)
+	defer cancelFn()
+	ecmd := exec.CommandContext(ctx, GetLocalFishPath(), "--no-config", "-c", FishShellVersionCmdStr)
+	out, err := ecmd.Output()
+	if err != nil {
+		return ""
+	}
+	versionStr := strings.TrimSpace(string(out))
+	if !strings.HasPrefix(versionStr, "fish ") {
+		return ""
+	}
+	return versionStr
+}
+
+func GetLocalFishMajorVersion() string {
+	localFishMajorVersionOnce.Do(func() {
+		fullVersion := execGetLocalFishShellVersion()
+		localFishMajorVersion = packet.GetMajorVersion(fullVersion)
+	})
+	return localFishMajorVersion
+}
+
+func GetFishShellState(ctx context.Context, outCh chan ShellStateOutput, stdinDataCh chan []byte) {
+	defer close(outCh)
+	stateCmd, endBytes := GetFishShellStateCmd(StateOutputFdNum)
+	ecmd := exec.CommandContext(ctx, GetLocalFishPath(), "-l", "-i", "-c", stateCmd)
+	outputCh := make(chan []byte, 10)
+	var outputWg sync.WaitGroup
+	outputWg.Add(1)
+	go func() {
+		defer outputWg.Done()
+		for outputBytes := range outputCh {
+			outCh <- ShellStateOutput{Output: outputBytes}
+		}
+	}()
+	outputBytes, err := StreamCommandWithExtraFd(ctx, ecmd, outputCh, StateOutputFdNum, endBytes, stdinDataCh)
+	outputWg.Wait()
+	if err != nil {
+		outCh <- ShellStateOutput{Error: err.Error()}
+		return
+	}
+	rtn, stats, err := parseFishShellStateOutput(outputBytes)
+	if err != nil {
+		outCh <- ShellStateOutput{Error: err.Error()}
+		return
+	}
+	outCh <- ShellStateOutput{ShellState: rtn, Stats: stats}
+}
+
+func GetLocalFishPath() string {
+	if runtime.GOOS == "darwin" {
+		macShell := GetMacUserShell()
+		if strings.Index(macShell, "fish") != -1 {
+			return shellescape.Quote(macShell)
+		}
+	}
+	return "fish"
+}
+
+func parseFishShellStateOutput(outputBytes []byte) (*packet.ShellState, *packet.ShellStateStats, error) {
+	sections := bytes.Split(outputBytes, []byte{0, 0})
+	if len(sections) != FishSection_Count {
+		return nil, nil, fmt.Errorf("invalid fish shell state output, wrong number of fields, fields=%d", len(sections))
+	}
+	rtn := &packet.ShellState{}
+	rtn.Version = strings.TrimSpace(string(sections[FishSection_Version]))
+	if rtn.GetShellType() != packet.ShellType_fish {
+		return nil, nil, fmt.Errorf("invalid fish shell state output, wrong shell type: %q", rtn.Version)
+	}
+	if _, _, err := packet.ParseShellStateVersion(rtn.Version); err != nil {
+		return nil, nil, fmt.Errorf("invalid fish shell state output, invalid version: %v", err)
+	}
+	rtn.Cwd = strings.TrimRight(string(sections[FishSection_Cwd]), "\r\n")
+	declMap := make(map[string]*DeclareDeclType)
+	for _, varEntry := range SplitFishEntries(string(sections[FishSection_Vars])) {
+		header, valuesStr, _ := strings.Cut(varEntry, FishListSep)
+		flags, name, found := strings.Cut(header, " ")
+		if !found || name == "" || FishIgnoreVars[name] || strings.HasPrefix(name, "_wave") || strings.HasPrefix(name, "__fish_") {
+			continue
+		}
+		var listVals []string
+		if strings.Contains(varEntry, FishListSep) {
+			listVals = strings.Split(valuesStr, FishListSep)
+		}
+		if flags == "x" {
+			declMap[name] = &DeclareDeclType{Args: "x", Name: name, Value: bashDeclQuote(fishExportValue(name, listVals))}
+		} else {
+			declMap[name] = &DeclareDeclType{Args: "", Name: name, Value: bashDeclQuote(strings.Join(listVals, FishListSep))}
+		}
+	}
+	pvarMap := parseExtVarOutput(sections[FishSection_PVars], string(sections[FishSection_Prompt]), "")
+	utilfn.CombineMaps(declMap, pvarMap)
+	rtn.ShellVars = shellenv.SerializeDeclMap(declMap)
+	rtn.Aliases = strings.TrimRight(string(sections[FishSection_Abbrs]), "\n")
+	rtn.Funcs = trimFishEntries(string(sections[FishSection_Funcs]))
+	return rtn, nil, nil
+}
+
+// functions output ends with a newline
+func trimFishEntries(entriesStr string) string {
+	entries := SplitFishEntries(entriesStr)
+	for idx, entry := range entries {
+		entries[idx] = strings.TrimRight(entry, "\n")
+	}
+	return strings.Join(entries, "\x00")
+}
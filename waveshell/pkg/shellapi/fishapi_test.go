@@ -0,0 +1,89 @@
+package shellapi
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/shellenv"
+)
+
+func TestFishEntryName(t *testing.T) {
+	abbrEntry := `abbr -a --position command -- gco 'git checkout'`
+	if name := GetFishEntryName(abbrEntry); name != "gco" {
+		t.Errorf("abbr name=%q, expected gco", name)
+	}
+	funcEntry := "function hello --description 'say hi'\n    echo hi\nend"
+	if name := GetFishEntryName(funcEntry); name != "hello" {
+		t.Errorf("func name=%q, expected hello", name)
+	}
+	entryMap := DecodeFishEntries(abbrEntry + "\x00" + funcEntry)
+	if len(entryMap) != 2 || entryMap["gco"] != abbrEntry || entryMap["hello"] != funcEntry {
+		t.Errorf("invalid entry map: %#v", entryMap)
+	}
+}
+
+func TestParseFishShellStateOutput(t *testing.T) {
+	vars := []string{
+		"x HOME\x1e/home/user",
+		"x PATH\x1e/usr/bin\x1e/bin",
+		"- mylist\x1ea b\x1eit's",
+		"- emptylist",
+		"x PWD\x1e/home/user",
+		"- __fish_internal\x1e1",
+	}
+	sections := []string{
+		"",
+		"fish v3.7.1",
+		"/home/user\n",
+		strings.Join(vars, "\x00"),
+		"abbr -a -- gco 'git checkout'",
+		"function hello\n    echo hi\nend\n",
+		"GITBRANCH main",
+		"user@host ~> ",
+		"",
+	}
+	state, _, err := parseFishShellStateOutput([]byte(strings.Join(sections, "\x00\x00")))
+	if err != nil {
+		t.Fatalf("error parsing state: %v", err)
+	}
+	if state.Cwd != "/home/user" {
+		t.Errorf("cwd=%q", state.Cwd)
+	}
+	if state.GetLineDiffSplitString() != "\x00" {
+		t.Errorf("fish states should use null separated line diffs")
+	}
+	envMap := shellenv.EnvMapFromState(state)
+	if envMap["HOME"] != "/home/user" || envMap["PATH"] != "/usr/bin:/bin" {
+		t.Errorf("invalid env map: %#v", envMap)
+	}
+	if _, found := envMap["PWD"]; found {
+		t.Errorf("PWD should not be stored")
+	}
+	varMap := shellenv.ShellVarMapFromState(state)
+	if varMap["PROMPTVAR_GITBRANCH"] != "main" || varMap["PROMPTVAR_PS1"] != "user@host ~> " {
+		t.Errorf("invalid prompt vars: %q %q", varMap["PROMPTVAR_GITBRANCH"], varMap["PROMPTVAR_PS1"])
+	}
+	if _, found := varMap["__fish_internal"]; found {
+		t.Errorf("internal fish vars should not be stored")
+	}
+	rcStr := fishShellApi{}.MakeRcFileStr(&packet.RunPacketType{State: state})
+	expectedLines := []string{
+		`set -g mylist 'a b' 'it\'s'`,
+		"set -g emptylist\n",
+		"abbr -a -- gco 'git checkout'\n",
+		"function hello\n    echo hi\nend\n",
+	}
+	for _, line := range expectedLines {
+		if !strings.Contains(rcStr, line) {
+			t.Errorf("rc file missing %q: %q", line, rcStr)
+		}
+	}
+	if strings.Contains(rcStr, "HOME") {
+		t.Errorf("exported vars should not be set in the rc file: %q", rcStr)
+	}
+	_, _, err = parseFishShellStateOutput([]byte(strings.Join(sections[0:6], "\x00\x00")))
+	if err == nil {
+		t.Errorf("expected error for truncated state output")
+	}
+}
@@ -0,0 +1,378 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package shellapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/alessio/shellescape"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/shellenv"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/utilfn"
+)
+
+// PowerShell (pwsh 7+) support.  waveshell only runs on linux and macos, so this is pwsh on those platforms.
+//
+// the pwsh state uses the same layout as bash: environment variables are stored as exported decls (the values
+// are quoted so they can be expanded with simpleexpand), aliases and functions are stored as pwsh statements
+// (Set-Alias / Set-Item function:) separated by null bytes so they can be written directly into the rc file.
+// pwsh shell variables are not tracked (only environment variables).
+
+const BasePwshOpts = `$ProgressPreference = 'SilentlyContinue'`
+
+const PwshShellVersionCmdStr = `'pwsh v' + $PSVersionTable.PSVersion.ToString()`
+const RemotePwshPath = "pwsh"
+
+// run after the command (instead of an exit trap).  the exit status of a failed cmdlet is 1
+const PwshReturnStateCmdStr = `
+$_waveshell_ok = $?
+_waveshell_exittrap
+if ($_waveshell_ok) { exit 0 } elseif ($LASTEXITCODE) { exit $LASTEXITCODE } else { exit 1 }`
+
+const PwshExitTrapFuncName = "_waveshell_exittrap"
+
+var PwshNoStoreVarNames = map[string]bool{
+	"PWD":    true,
+	"OLDPWD": true,
+	"SHLVL":  true,
+	"_":      true,
+}
+
+// do not use these directly, call GetLocalMajorVersion()
+var localPwshMajorVersionOnce = &sync.Once{}
+var localPwshMajorVersion = ""
+
+const (
+	PwshSection_Ignored = iota
+	PwshSection_Version
+	PwshSection_Cwd
+	PwshSection_Vars
+	PwshSection_Aliases
+	PwshSection_Funcs
+	PwshSection_PVars
+	PwshSection_EndBytes
+
+	PwshSection_Count // must be last
+)
+
+type pwshShellApi struct{}
+
+func (p pwshShellApi) GetShellType() string {
+	return packet.ShellType_pwsh
+}
+
+func (p pwshShellApi) MakeExitTrap(fdNum int) (string, []byte) {
+	return MakePwshExitTrap(fdNum)
+}
+
+func (p pwshShellApi) GetLocalMajorVersion() string {
+	return GetLocalPwshMajorVersion()
+}
+
+func (p pwshShellApi) GetLocalShellPath() string {
+	return GetLocalPwshPath()
+}
+
+func (p pwshShellApi) GetRemoteShellPath() string {
+	return RemotePwshPath
+}
+
+func makePwshReadFileCmd(fileName string) string {
+	return fmt.Sprintf("([ScriptBlock]::Create([System.IO.File]::ReadAllText(%s)))", pwshQuote(fileName))
+}
+
+func (p pwshShellApi) MakeRunCommand(cmdStr string, opts RunCommandOpts) string {
+	if !opts.Sudo {
+		return fmt.Sprintf(RunCommandFmt, cmdStr)
+	}
+	return fmt.Sprintf("sudo -n -C %d pwsh -NoLogo -NoProfile -Command %s", opts.MaxFdNum+1, shellescape.Quote("& "+makePwshReadFileCmd(fmt.Sprintf("/dev/fd/%d", opts.CommandFdNum))))
+}
+
+// the rc file is dot sourced (it can be a /dev/fd file, so it cannot be run with -File)
+func (p pwshShellApi) MakeShExecCommand(cmdStr string, rcFileName string, usePty bool) *exec.Cmd {
+	fullCmdStr := ". " + makePwshReadFileCmd(rcFileName) + "\n" + cmdStr
+	if usePty {
+		return exec.Command(GetLocalPwshPath(), "-NoLogo", "-NoProfile", "-Command", fullCmdStr)
+	} else {
+		return exec.Command(GetLocalPwshPath(), "-NoLogo", "-NoProfile", "-NonInteractive", "-Command", fullCmdStr)
+	}
+}
+
+func (p pwshShellApi) GetShellState(ctx context.Context, outCh chan ShellStateOutput, stdinDataCh chan []byte) {
+	GetPwshShellState(ctx, outCh, stdinDataCh)
+}
+
+func (p pwshShellApi) GetBaseShellOpts() string {
+	return BasePwshOpts
+}
+
+func (p pwshShellApi) ParseShellStateOutput(output []byte) (*packet.ShellState, *packet.ShellStateStats, error) {
+	return parsePwshShellStateOutput(output)
+}
+
+// environment variables are not set here, they are set in the command's environment
+func (p pwshShellApi) MakeRcFileStr(pk *packet.RunPacketType) string {
+	var rcBuf bytes.Buffer
+	rcBuf.WriteString(p.GetBaseShellOpts() + "\n")
+	if pk.State != nil {
+		for _, entry := range SplitPwshEntries(pk.State.Funcs) {
+			rcBuf.WriteString(entry)
+			rcBuf.WriteString("\n")
+		}
+		for _, entry := range SplitPwshEntries(pk.State.Aliases) {
+			rcBuf.WriteString(entry)
+			rcBuf.WriteString("\n")
+		}
+	}
+	return rcBuf.String()
+}
+
+// pwsh states are diffed like bash states (aliases and funcs are split on null bytes, see GetLineDiffSplitString)
+func (p pwshShellApi) MakeShellStateDiff(oldState *packet.ShellState, oldStateHash string, newState *packet.ShellState) (*packet.ShellStateDiff, error) {
+	return bashShellApi{}.MakeShellStateDiff(oldState, oldStateHash, newState)
+}
+
+func (p pwshShellApi) ApplyShellStateDiff(oldState *packet.ShellState, diff *packet.ShellStateDiff) (*packet.ShellState, error) {
+	return bashShellApi{}.ApplyShellStateDiff(oldState, diff)
+}
+
+// parse errors are returned, if pwsh cannot be run (or is too slow to start) the command is not validated
+func (p pwshShellApi) ValidateCommandSyntax(cmdStr string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), GetVersionTimeout)
+	defer cancelFn()
+	parseCmdStr := `$errs = $null; $null = [System.Management.Automation.Language.Parser]::ParseInput($env:WAVESHELL_PARSECMD, [ref]$null, [ref]$errs); if ($errs) { [Console]::Out.WriteLine($errs[0].Message); exit 1 }`
+	ecmd := exec.CommandContext(ctx, GetLocalPwshPath(), "-NoLogo", "-NoProfile", "-NonInteractive", "-Command", parseCmdStr)
+	ecmd.Env = append(os.Environ(), "WAVESHELL_PARSECMD="+cmdStr)
+	output, err := ecmd.CombinedOutput()
+	if err == nil || ctx.Err() != nil {
+		return nil
+	}
+	if _, ok := err.(*exec.ExitError); !ok {
+		return nil
+	}
+	errStr := strings.TrimSpace(utilfn.GetFirstLine(string(output)))
+	if len(errStr) == 0 {
+		return errors.New("pwsh syntax error")
+	}
+	return errors.New(errStr)
+}
+
+// quotes a string as a pwsh single quoted string
+func pwshQuote(str string) string {
+	return "'" + strings.ReplaceAll(str, "'", "''") + "'"
+}
+
+// quotes an env value the way bash's "declare -p" does (so simpleexpand can decode it)
+func bashDeclQuote(str string) string {
+	var buf strings.Builder
+	buf.WriteByte('"')
+	for _, ch := range str {
+		if ch == '\\' || ch == '"' || ch == '$' || ch == '`' {
+			buf.WriteByte('\\')
+		}
+		buf.WriteRune(ch)
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+func SplitPwshEntries(entriesStr string) []string {
+	if entriesStr == "" {
+		return nil
+	}
+	return strings.Split(entriesStr, "\x00")
+}
+
+// returns the name from a Set-Alias or Set-Item function entry (the first single quoted string)
+func GetPwshEntryName(entry string) string {
+	startIdx := strings.Index(entry, "'")
+	if startIdx == -1 {
+		return ""
+	}
+	var nameBuf strings.Builder
+	for idx := startIdx + 1; idx < len(entry); idx++ {
+		if entry[idx] != '\'' {
+			nameBuf.WriteByte(entry[idx])
+			continue
+		}
+		if idx+1 < len(entry) && entry[idx+1] == '\'' {
+			nameBuf.WriteByte('\'')
+			idx++
+			continue
+		}
+		break
+	}
+	return strings.TrimPrefix(nameBuf.String(), "function:global:")
+}
+
+// returns name => entry
+func DecodePwshEntries(entriesStr string) map[string]string {
+	rtn := make(map[string]string)
+	for _, entry := range SplitPwshEntries(entriesStr) {
+		name := GetPwshEntryName(entry)
+		if name != "" {
+			rtn[name] = entry
+		}
+	}
+	return rtn
+}
+
+func GetPwshShellStateCmd(fdNum int) (string, []byte) {
+	endBytes := utilfn.AppendNonZeroRandomBytes(nil, NumRandomEndBytes)
+	endBytes = append(endBytes, '\n')
+	var endBytesStrs []string
+	for _, b := range endBytes {
+		endBytesStrs = append(endBytesStrs, fmt.Sprintf("%d", b))
+	}
+	cmdStr := strings.TrimSpace(`
+& {
+$ErrorActionPreference = 'SilentlyContinue';
+$fs = [System.IO.FileStream]::new('[%OUTPUTFD%]', [System.IO.FileMode]::Open, [System.IO.FileAccess]::Write);
+$w = [System.IO.StreamWriter]::new($fs, [System.Text.UTF8Encoding]::new($false));
+$nul = [string][char]0;
+$sep = $nul + $nul;
+$w.Write($sep);
+$w.Write([%PWSHVERSIONCMD%]);
+$w.Write($sep);
+$w.Write($PWD.ProviderPath);
+$w.Write($sep);
+$w.Write((Get-ChildItem env: | ForEach-Object { $_.Name + '=' + $_.Value }) -join $nul);
+$w.Write($sep);
+$w.Write((Get-Alias | Where-Object { -not $_.Source -and -not ($_.Options -band [System.Management.Automation.ScopedItemOptions]::ReadOnly) } | ForEach-Object { 'Set-Alias -Name ''' + $_.Name.Replace("'", "''") + ''' -Value ''' + $_.Definition.Replace("'", "''") + ''' -Scope Global -Force -ErrorAction SilentlyContinue' }) -join $nul);
+$w.Write($sep);
+$w.Write((Get-ChildItem function: | Where-Object { -not $_.ModuleName -and $_.Name -notmatch '^[A-Za-z]:$' -and $_.Name -ne '[%EXITTRAPFUNC%]' } | ForEach-Object { 'Set-Item -Path ''function:global:' + $_.Name.Replace("'", "''") + ''' -Value {' + $_.Definition + '}' }) -join $nul);
+$w.Write($sep);
+$gitBranch = '';
+try { $gitBranch = [string](git rev-parse --abbrev-ref HEAD 2>$null) } catch {};
+$w.Write('GITBRANCH ' + $gitBranch);
+$w.Write($sep);
+$w.Flush();
+$endBytes = [byte[]]([%ENDBYTES%]);
+$fs.Write($endBytes, 0, $endBytes.Length);
+$w.Dispose();
+}
+`)
+	cmdStr = strings.ReplaceAll(cmdStr, "[%OUTPUTFD%]", fmt.Sprintf("/dev/fd/%d", fdNum))
+	cmdStr = strings.ReplaceAll(cmdStr, "[%PWSHVERSIONCMD%]", PwshShellVersionCmdStr)
+	cmdStr = strings.ReplaceAll(cmdStr, "[%EXITTRAPFUNC%]", PwshExitTrapFuncName)
+	cmdStr = strings.ReplaceAll(cmdStr, "[%ENDBYTES%]", strings.Join(endBytesStrs, ","))
+	return cmdStr, endBytes
+}
+
+// pwsh has no exit trap.  the state function is called by PwshReturnStateCmdStr after the command, and from the
+// PowerShell.Exiting event (if the command exits).  LASTEXITCODE is preserved.
+func MakePwshExitTrap(fdNum int) (string, []byte) {
+	stateCmd, endBytes := GetPwshShellStateCmd(fdNum)
+	fmtStr := `
+function global:%s {
+    if ($global:_waveshell_trapdone) { return }
+    $global:_waveshell_trapdone = $true
+    $_waveshell_lec = $global:LASTEXITCODE
+    %s
+    $global:LASTEXITCODE = $_waveshell_lec
+}
+$null = Register-EngineEvent -SourceIdentifier PowerShell.Exiting -Action { %s }
+`
+	return fmt.Sprintf(fmtStr, PwshExitTrapFuncName, stateCmd, PwshExitTrapFuncName), endBytes
+}
+
+func execGetLocalPwshShellVersion() string {
+	ctx, cancelFn := context.WithTimeout(context.Background(), GetVersionTimeout)
+	defer cancelFn()
+	ecmd := exec.CommandContext(ctx, GetLocalPwshPath(), "-NoLogo", "-NoProfile", "-NonInteractive", "-Command", PwshShellVersionCmdStr)
+	out, err := ecmd.Output()
+	if err != nil {
+		return ""
+	}
+	versionStr := strings.TrimSpace(string(out))
+	if !strings.HasPrefix(versionStr, "pwsh ") {
+		return ""
+	}
+	return versionStr
+}
+
+func GetLocalPwshMajorVersion() string {
+	localPwshMajorVersionOnce.Do(func() {
+		fullVersion := execGetLocalPwshShellVersion()
+		localPwshMajorVersion = packet.GetMajorVersion(fullVersion)
+	})
+	return localPwshMajorVersion
+}
+
+func GetPwshShellState(ctx context.Context, outCh chan ShellStateOutput, stdinDataCh chan []byte) {
+	defer close(outCh)
+	stateCmd, endBytes := GetPwshShellStateCmd(StateOutputFdNum)
+	cmdStr := BasePwshOpts + "; " + stateCmd
+	// -Login must be the first argument, the user's profile is loaded (no -NoProfile)
+	ecmd := exec.CommandContext(ctx, GetLocalPwshPath(), "-Login", "-NoLogo", "-Command", cmdStr)
+	outputCh := make(chan []byte, 10)
+	var outputWg sync.WaitGroup
+	outputWg.Add(1)
+	go func() {
+		defer outputWg.Done()
+		for outputBytes := range outputCh {
+			outCh <- ShellStateOutput{Output: outputBytes}
+		}
+	}()
+	outputBytes, err := StreamCommandWithExtraFd(ctx, ecmd, outputCh, StateOutputFdNum, endBytes, stdinDataCh)
+	outputWg.Wait()
+	if err != nil {
+		outCh <- ShellStateOutput{Error: err.Error()}
+		return
+	}
+	rtn, stats, err := parsePwshShellStateOutput(outputBytes)
+	if err != nil {
+		outCh <- ShellStateOutput{Error: err.Error()}
+		return
+	}
+	outCh <- ShellStateOutput{ShellState: rtn, Stats: stats}
+}
+
+func GetLocalPwshPath() string {
+	if runtime.GOOS == "darwin" {
+		macShell := GetMacUserShell()
+		if strings.Index(macShell, "pwsh") != -1 {
+			return shellescape.Quote(macShell)
+		}
+	}
+	return "pwsh"
+}
+
+func parsePwshShellStateOutput(outputBytes []byte) (*packet.ShellState, *packet.ShellStateStats, error) {
+	sections := bytes.Split(outputBytes, []byte{0, 0})
+	if len(sections) != PwshSection_Count {
+		return nil, nil, fmt.Errorf("invalid pwsh shell state output, wrong number of fields, fields=%d", len(sections))
+	}
+	rtn := &packet.ShellState{}
+	rtn.Version = strings.TrimSpace(string(sections[PwshSection_Version]))
+	if rtn.GetShellType() != packet.ShellType_pwsh {
+		return nil, nil, fmt.Errorf("invalid pwsh shell state output, wrong shell type: %q", rtn.Version)
+	}
+	if _, _, err := packet.ParseShellStateVersion(rtn.Version); err != nil {
+		return nil, nil, fmt.Errorf("invalid pwsh shell state output, invalid version: %v", err)
+	}
+	rtn.Cwd = strings.TrimRight(string(sections[PwshSection_Cwd]), "\r\n")
+	declMap := make(map[string]*DeclareDeclType)
+	for _, envEntry := range SplitPwshEntries(string(sections[PwshSection_Vars])) {
+		name, value, found := strings.Cut(envEntry, "=")
+		if !found || name == "" || PwshNoStoreVarNames[name] || strings.HasPrefix(name, "_wavetemp_") {
+			continue
+		}
+		declMap[name] = &DeclareDeclType{Args: "x", Name: name, Value: bashDeclQuote(value)}
+	}
+	pvarMap := parseExtVarOutput(sections[PwshSection_PVars], "", "")
+	utilfn.CombineMaps(declMap, pvarMap)
+	rtn.ShellVars = shellenv.SerializeDeclMap(declMap)
+	rtn.Aliases = string(sections[PwshSection_Aliases])
+	rtn.Funcs = string(sections[PwshSection_Funcs])
+	return rtn, nil, nil
+}
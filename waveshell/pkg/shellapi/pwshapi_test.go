@@ -0,0 +1,66 @@
+package shellapi
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/shellenv"
+)
+
+func TestPwshEntryName(t *testing.T) {
+	aliasEntry := `Set-Alias -Name 'll' -Value 'Get-ChildItem' -Scope Global -Force -ErrorAction SilentlyContinue`
+	if name := GetPwshEntryName(aliasEntry); name != "ll" {
+		t.Errorf("alias name=%q, expected ll", name)
+	}
+	funcEntry := "Set-Item -Path 'function:global:it''s' -Value {\n'hello'\n}"
+	if name := GetPwshEntryName(funcEntry); name != "it's" {
+		t.Errorf("func name=%q, expected it's", name)
+	}
+	entryMap := DecodePwshEntries(aliasEntry + "\x00" + funcEntry)
+	if len(entryMap) != 2 || entryMap["ll"] != aliasEntry || entryMap["it's"] != funcEntry {
+		t.Errorf("invalid entry map: %#v", entryMap)
+	}
+}
+
+func TestParsePwshShellStateOutput(t *testing.T) {
+	sections := []string{
+		"",
+		"pwsh v7.4.1",
+		"/home/user\n",
+		"HOME=/home/user\x00PWD=/home/user\x00FOO=it's\x00BAR=a\"$b\\c`d",
+		"Set-Alias -Name 'll' -Value 'Get-ChildItem' -Scope Global -Force -ErrorAction SilentlyContinue",
+		"Set-Item -Path 'function:global:hello' -Value {\n'hello'\n}",
+		"GITBRANCH main",
+		"",
+	}
+	state, _, err := parsePwshShellStateOutput([]byte(strings.Join(sections, "\x00\x00")))
+	if err != nil {
+		t.Fatalf("error parsing state: %v", err)
+	}
+	if state.Cwd != "/home/user" {
+		t.Errorf("cwd=%q", state.Cwd)
+	}
+	if state.GetLineDiffSplitString() != "\x00" {
+		t.Errorf("pwsh states should use null separated line diffs")
+	}
+	envMap := shellenv.EnvMapFromState(state)
+	if envMap["FOO"] != "it's" || envMap["HOME"] != "/home/user" || envMap["BAR"] != "a\"$b\\c`d" {
+		t.Errorf("invalid env map: %#v", envMap)
+	}
+	if _, found := envMap["PWD"]; found {
+		t.Errorf("PWD should not be stored")
+	}
+	varMap := shellenv.ShellVarMapFromState(state)
+	if varMap["PROMPTVAR_GITBRANCH"] != "main" {
+		t.Errorf("gitbranch=%q", varMap["PROMPTVAR_GITBRANCH"])
+	}
+	rcStr := pwshShellApi{}.MakeRcFileStr(&packet.RunPacketType{State: state})
+	if !strings.Contains(rcStr, sections[4]+"\n") || !strings.Contains(rcStr, sections[5]+"\n") {
+		t.Errorf("rc file missing aliases/funcs: %q", rcStr)
+	}
+	_, _, err = parsePwshShellStateOutput([]byte(strings.Join(sections[0:5], "\x00\x00")))
+	if err == nil {
+		t.Errorf("expected error for truncated state output")
+	}
+}
@@ -86,6 +86,8 @@ type ShellApi interface {
 
 var _ ShellApi = &bashShellApi{}
 var _ ShellApi = &zshShellApi{}
+var _ ShellApi = &pwshShellApi{}
+var _ ShellApi = &fishShellApi{}
 
 func DetectLocalShellType() string {
 	shellPath := GetMacUserShell()
@@ -99,6 +101,12 @@ func DetectLocalShellType() string {
 	if strings.HasPrefix(file, "zsh") {
 		return packet.ShellType_zsh
 	}
+	if strings.HasPrefix(file, "pwsh") {
+		return packet.ShellType_pwsh
+	}
+	if strings.HasPrefix(file, "fish") {
+		return packet.ShellType_fish
+	}
 	return packet.ShellType_bash
 }
 
@@ -111,6 +119,14 @@ func HasShell(shellType string) bool {
 		_, err := exec.LookPath("zsh")
 		return err != nil
 	}
+	if shellType == packet.ShellType_pwsh {
+		_, err := exec.LookPath("pwsh")
+		return err != nil
+	}
+	if shellType == packet.ShellType_fish {
+		_, err := exec.LookPath("fish")
+		return err != nil
+	}
 	return false
 }
 
@@ -121,6 +137,12 @@ func MakeShellApi(shellType string) (ShellApi, error) {
 	if shellType == packet.ShellType_zsh {
 		return &zshShellApi{}, nil
 	}
+	if shellType == packet.ShellType_pwsh {
+		return &pwshShellApi{}, nil
+	}
+	if shellType == packet.ShellType_fish {
+		return &fishShellApi{}, nil
+	}
 	return nil, fmt.Errorf("shell type not supported: %s", shellType)
 }
 
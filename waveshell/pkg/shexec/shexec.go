@@ -908,13 +908,21 @@ func RunCommandSimple(pk *packet.RunPacketType, sender *packet.PacketSender, fro
 		})
 	}
 	fullCmdStr := pk.Command
-	if pk.ReturnState {
+	if pk.ReturnState && sapi.GetShellType() == packet.ShellType_pwsh {
+		// pwsh has no exit trap, the state is written after the command
+		fullCmdStr = fullCmdStr + shellapi.PwshReturnStateCmdStr
+	} else if pk.ReturnState && sapi.GetShellType() == packet.ShellType_fish {
+		fullCmdStr = fullCmdStr + shellapi.FishReturnStateCmdStr
+	} else if pk.ReturnState {
 		// this ensures that the last command is a shell buitin so we always get our exit trap to run
 		fullCmdStr = fullCmdStr + "\nexit $? 2> /dev/null"
 	}
 
 	var sudoKey uuid.UUID
 	var sudoErrKey uuid.UUID
+	if pk.IsSudo && (sapi.GetShellType() == packet.ShellType_pwsh || sapi.GetShellType() == packet.ShellType_fish) {
+		return nil, fmt.Errorf("sudo is not supported for %s commands", sapi.GetShellType())
+	}
 	if pk.IsSudo {
 		sudoKey = uuid.New()
 		sudoErrKey = uuid.New()
@@ -1208,12 +1216,36 @@ func (c *ShExecType) WaitForCommand() *packet.CmdDonePacketType {
 	donePacket.Ts = endTs.UnixMilli()
 	donePacket.ExitCode = utilfn.GetCmdExitCode(c.Cmd, exitErr)
 	donePacket.DurationMs = int64(cmdDuration / time.Millisecond)
+	donePacket.Usage = GetProcUsage(c.Cmd.ProcessState)
 	if c.FileNames != nil {
 		os.Remove(c.FileNames.StdinFifo) // best effort (no need to check error)
 	}
 	return donePacket
 }
 
+// returns nil if the usage is not available (process not waited for)
+func GetProcUsage(procState *os.ProcessState) *packet.CmdUsageType {
+	if procState == nil {
+		return nil
+	}
+	rusage, ok := procState.SysUsage().(*syscall.Rusage)
+	if !ok || rusage == nil {
+		return nil
+	}
+	maxRssKB := int64(rusage.Maxrss)
+	if runtime.GOOS == "darwin" {
+		// bytes on darwin, KB on linux
+		maxRssKB = maxRssKB / 1024
+	}
+	return &packet.CmdUsageType{
+		MaxRssKB:   maxRssKB,
+		UserTimeMs: time.Duration(rusage.Utime.Nano()).Milliseconds(),
+		SysTimeMs:  time.Duration(rusage.Stime.Nano()).Milliseconds(),
+		InBlock:    int64(rusage.Inblock),
+		OutBlock:   int64(rusage.Oublock),
+	}
+}
+
 func MakeInitPacket() *packet.InitPacketType {
 	initPacket := packet.MakeInitPacket()
 	initPacket.Version = base.WaveshellVersion
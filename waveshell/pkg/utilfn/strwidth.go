@@ -0,0 +1,236 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package utilfn
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// display width of text in a terminal (cells).  east asian wide/fullwidth characters and emoji are 2 cells,
+// combining marks and other zero-width characters are 0.  text is measured by grapheme clusters (a base
+// character with its combining marks, emoji zwj sequences, emoji with modifiers or VS16, flags), a cluster is
+// never split and is as wide as its widest character (2 for emoji sequences and flags).  the clustering is a
+// simplified version of the unicode rules (UAX #29), enough for terminal layout.  control characters
+// (including tabs) are 0 cells.
+
+type runeRange struct {
+	Lo rune
+	Hi rune
+}
+
+// east asian wide/fullwidth and emoji presentation ranges (sorted)
+var wideRanges = []runeRange{
+	{0x1100, 0x115F}, {0x231A, 0x231B}, {0x2329, 0x232A}, {0x23E9, 0x23EC}, {0x23F0, 0x23F0},
+	{0x23F3, 0x23F3}, {0x25FD, 0x25FE}, {0x2614, 0x2615}, {0x2648, 0x2653}, {0x267F, 0x267F},
+	{0x2693, 0x2693}, {0x26A1, 0x26A1}, {0x26AA, 0x26AB}, {0x26BD, 0x26BE}, {0x26C4, 0x26C5},
+	{0x26CE, 0x26CE}, {0x26D4, 0x26D4}, {0x26EA, 0x26EA}, {0x26F2, 0x26F3}, {0x26F5, 0x26F5},
+	{0x26FA, 0x26FA}, {0x26FD, 0x26FD}, {0x2705, 0x2705}, {0x270A, 0x270B}, {0x2728, 0x2728},
+	{0x274C, 0x274C}, {0x274E, 0x274E}, {0x2753, 0x2755}, {0x2757, 0x2757}, {0x2795, 0x2797},
+	{0x27B0, 0x27B0}, {0x27BF, 0x27BF}, {0x2B1B, 0x2B1C}, {0x2B50, 0x2B50}, {0x2B55, 0x2B55},
+	{0x2E80, 0x303E}, {0x3041, 0x33FF}, {0x3400, 0x4DBF}, {0x4E00, 0x9FFF}, {0xA000, 0xA4CF},
+	{0xA960, 0xA97F}, {0xAC00, 0xD7A3}, {0xF900, 0xFAFF}, {0xFE10, 0xFE19}, {0xFE30, 0xFE6F},
+	{0xFF00, 0xFF60}, {0xFFE0, 0xFFE6}, {0x16FE0, 0x16FE4}, {0x17000, 0x18AFF}, {0x1B000, 0x1B2FF},
+	{0x1F004, 0x1F004}, {0x1F0CF, 0x1F0CF}, {0x1F18E, 0x1F18E}, {0x1F191, 0x1F19A}, {0x1F200, 0x1F202},
+	{0x1F210, 0x1F23B}, {0x1F240, 0x1F248}, {0x1F250, 0x1F251}, {0x1F260, 0x1F265}, {0x1F300, 0x1F320},
+	{0x1F32D, 0x1F335}, {0x1F337, 0x1F37C}, {0x1F37E, 0x1F393}, {0x1F3A0, 0x1F3CA}, {0x1F3CF, 0x1F3D3},
+	{0x1F3E0, 0x1F3F0}, {0x1F3F4, 0x1F3F4}, {0x1F3F8, 0x1F43E}, {0x1F440, 0x1F440}, {0x1F442, 0x1F4FC},
+	{0x1F4FF, 0x1F53D}, {0x1F54B, 0x1F54E}, {0x1F550, 0x1F567}, {0x1F57A, 0x1F57A}, {0x1F595, 0x1F596},
+	{0x1F5A4, 0x1F5A4}, {0x1F5FB, 0x1F64F}, {0x1F680, 0x1F6C5}, {0x1F6CC, 0x1F6CC}, {0x1F6D0, 0x1F6D2},
+	{0x1F6D5, 0x1F6D7}, {0x1F6DC, 0x1F6DF}, {0x1F6EB, 0x1F6EC}, {0x1F6F4, 0x1F6FC}, {0x1F7E0, 0x1F7EB},
+	{0x1F7F0, 0x1F7F0}, {0x1F90C, 0x1F93A}, {0x1F93C, 0x1F945}, {0x1F947, 0x1F9FF}, {0x1FA70, 0x1FAFF},
+	{0x20000, 0x2FFFD}, {0x30000, 0x3FFFD},
+}
+
+const (
+	runeZWJ         = 0x200D
+	runeVS15        = 0xFE0E // text presentation
+	runeVS16        = 0xFE0F // emoji presentation
+	runeRegionalLo  = 0x1F1E6
+	runeRegionalHi  = 0x1F1FF
+	runeEmojiModLo  = 0x1F3FB // skin tone modifiers
+	runeEmojiModHi  = 0x1F3FF
+	runeTagLo       = 0xE0020 // emoji tag sequences (subdivision flags)
+	runeTagHi       = 0xE007F
+	runeHangulVTLo  = 0x1160 // hangul jungseong/jongseong (join the leading jamo)
+	runeHangulVTHi  = 0x11FF
+	runeHangulExtLo = 0xD7B0
+	runeHangulExtHi = 0xD7FF
+)
+
+func inRuneRanges(r rune, ranges []runeRange) bool {
+	lo, hi := 0, len(ranges)-1
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		switch {
+		case r < ranges[mid].Lo:
+			hi = mid - 1
+		case r > ranges[mid].Hi:
+			lo = mid + 1
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= runeRegionalLo && r <= runeRegionalHi
+}
+
+// characters that extend the previous grapheme cluster
+func isGraphemeExtend(r rune) bool {
+	switch {
+	case r == runeZWJ || r == runeVS15 || r == runeVS16:
+		return true
+	case r >= runeEmojiModLo && r <= runeEmojiModHi:
+		return true
+	case r >= runeTagLo && r <= runeTagHi:
+		return true
+	case r >= runeHangulVTLo && r <= runeHangulVTHi, r >= runeHangulExtLo && r <= runeHangulExtHi:
+		return true
+	}
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc, unicode.Variation_Selector)
+}
+
+// display width of a single rune (0, 1, or 2)
+func RuneWidth(r rune) int {
+	switch {
+	case r < 0x20 || (r >= 0x7F && r < 0xA0):
+		return 0
+	case r < 0x300:
+		// fast path for latin (U+00AD soft hyphen is shown by terminals)
+		return 1
+	case r == 0x200B || isGraphemeExtend(r) && !unicode.Is(unicode.Mc, r):
+		return 0
+	case unicode.Is(unicode.Cf, r):
+		return 0
+	case inRuneRanges(r, wideRanges):
+		return 2
+	}
+	return 1
+}
+
+// returns the first grapheme cluster of s and its display width
+func NextGrapheme(s string) (string, int) {
+	if s == "" {
+		return "", 0
+	}
+	if strings.HasPrefix(s, "\r\n") {
+		return "\r\n", 0
+	}
+	first, size := utf8.DecodeRuneInString(s)
+	width := RuneWidth(first)
+	if first < 0x20 || first == 0x7F {
+		return s[:size], 0
+	}
+	prev := first
+	numRegional := 0
+	if isRegionalIndicator(first) {
+		numRegional = 1
+	}
+	for size < len(s) {
+		r, rsize := utf8.DecodeRuneInString(s[size:])
+		switch {
+		case prev == runeZWJ && r >= 0x20:
+			// zwj sequence, the next character is part of the cluster
+			width = max(width, RuneWidth(r))
+		case isRegionalIndicator(r) && numRegional == 1:
+			// flags are pairs of regional indicators
+			numRegional++
+			width = 2
+		case isGraphemeExtend(r):
+			if r == runeVS16 && width > 0 {
+				width = 2
+			}
+			width = max(width, RuneWidth(r))
+		default:
+			return s[:size], width
+		}
+		prev = r
+		size += rsize
+	}
+	return s[:size], width
+}
+
+func Graphemes(s string) []string {
+	var rtn []string
+	for s != "" {
+		g, _ := NextGrapheme(s)
+		rtn = append(rtn, g)
+		s = s[len(g):]
+	}
+	return rtn
+}
+
+// display width of s (plain text, escape sequences are not interpreted)
+func StringWidth(s string) int {
+	totalWidth := 0
+	for s != "" {
+		g, width := NextGrapheme(s)
+		totalWidth += width
+		s = s[len(g):]
+	}
+	return totalWidth
+}
+
+// truncates s to maxWidth cells, ending with "..." if it was truncated (clusters are not split)
+func TruncateWidth(s string, maxWidth int) string {
+	if maxWidth < 4 {
+		maxWidth = 4
+	}
+	if StringWidth(s) <= maxWidth {
+		return s
+	}
+	var buf strings.Builder
+	curWidth := 0
+	for s != "" {
+		g, width := NextGrapheme(s)
+		if curWidth+width > maxWidth-3 {
+			break
+		}
+		buf.WriteString(g)
+		curWidth += width
+		s = s[len(g):]
+	}
+	buf.WriteString("...")
+	return buf.String()
+}
+
+// pads s with spaces (on the right) to width cells
+func PadWidth(s string, width int) string {
+	sWidth := StringWidth(s)
+	if sWidth >= width {
+		return s
+	}
+	return s + strings.Repeat(" ", width-sWidth)
+}
+
+// number of terminal rows the text takes with cols columns (lines wrap, a wide cluster that does not fit at the
+// end of a row moves to the next row).  a trailing newline does not add a row.
+func TextRows(s string, cols int) int {
+	if s == "" {
+		return 0
+	}
+	if cols <= 0 {
+		cols = 1
+	}
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "\n"), "\r")
+	numRows := 0
+	for _, line := range strings.Split(s, "\n") {
+		numRows++
+		col := 0
+		for line != "" {
+			g, width := NextGrapheme(line)
+			line = line[len(g):]
+			if col+width > cols {
+				numRows++
+				col = 0
+			}
+			col += width
+		}
+	}
+	return numRows
+}
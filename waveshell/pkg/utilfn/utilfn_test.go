@@ -173,3 +173,68 @@ func TestShellHexEscape(t *testing.T) {
 	testShellHexEscape(t, "a", `\x61`)
 	testShellHexEscape(t, "\x00\x01abc\x00", `\x00\x01\x61\x62\x63\x00`)
 }
+
+func TestStringWidth(t *testing.T) {
+	tests := []struct {
+		Str   string
+		Width int
+	}{
+		{"hello", 5},
+		{"", 0},
+		{"日本語", 6},
+		{"ｈｉ", 4},
+		{"e\u0301", 1},              // e + combining acute
+		{"\U0001F600", 2},           // grinning face
+		{"\u2764\uFE0F", 2},         // heart + VS16
+		{"\U0001F44D\U0001F3FD", 2}, // thumbs up + skin tone
+		{"\U0001F468\u200D\U0001F469\u200D\U0001F467", 2}, // family zwj sequence
+		{"\U0001F1FA\U0001F1F8", 2},                       // flag
+		{"a\tb", 2},
+		{"\u1100\u1161\u11A8", 2}, // hangul jamo
+	}
+	for _, test := range tests {
+		if width := StringWidth(test.Str); width != test.Width {
+			t.Errorf("StringWidth(%q) = %d, expected %d", test.Str, width, test.Width)
+		}
+	}
+	graphemes := Graphemes("a\U0001F468\u200D\U0001F469e\u0301\r\n")
+	if len(graphemes) != 4 || graphemes[1] != "\U0001F468\u200D\U0001F469" || graphemes[3] != "\r\n" {
+		t.Errorf("invalid graphemes: %q", graphemes)
+	}
+}
+
+func TestTruncateWidth(t *testing.T) {
+	if rtn := TruncateWidth("hello", 10); rtn != "hello" {
+		t.Errorf("invalid truncate: %q", rtn)
+	}
+	if rtn := TruncateWidth("日本語日本語", 8); rtn != "日本..." {
+		t.Errorf("invalid wide truncate: %q", rtn)
+	}
+	if rtn := TruncateWidth("ab\U0001F468\u200D\U0001F469cdefgh", 7); rtn != "ab\U0001F468\u200D\U0001F469..." {
+		t.Errorf("invalid zwj truncate: %q", rtn)
+	}
+	if rtn := PadWidth("日本", 6); rtn != "日本  " {
+		t.Errorf("invalid pad: %q", rtn)
+	}
+}
+
+func TestTextRows(t *testing.T) {
+	tests := []struct {
+		Str  string
+		Cols int
+		Rows int
+	}{
+		{"", 80, 0},
+		{"hello\n", 80, 1},
+		{"hello\nworld", 80, 2},
+		{"abcdef", 3, 2},
+		{"abcdefg", 3, 3},
+		{"ab日", 3, 2}, // the wide char does not fit at the end of the first row
+		{"a\n\nb", 80, 3},
+	}
+	for _, test := range tests {
+		if rows := TextRows(test.Str, test.Cols); rows != test.Rows {
+			t.Errorf("TextRows(%q, %d) = %d, expected %d", test.Str, test.Cols, rows, test.Rows)
+		}
+	}
+}
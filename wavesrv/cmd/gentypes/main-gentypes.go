@@ -0,0 +1,49 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// generates the typescript definitions (and optionally the json schema) of the update types wavesrv sends
+// to the frontend (see scbus/updateregistry.go).  run from the wavesrv directory:
+//
+//	go run ./cmd/gentypes [-ts ../src/types/gen/updatetypes.ts] [-schema file]
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+
+	// the packages that register update types
+	_ "github.com/wavetermdev/waveterm/wavesrv/pkg/bookmarks"
+	_ "github.com/wavetermdev/waveterm/wavesrv/pkg/cmdrunner"
+	_ "github.com/wavetermdev/waveterm/wavesrv/pkg/history"
+	_ "github.com/wavetermdev/waveterm/wavesrv/pkg/lanshare"
+	_ "github.com/wavetermdev/waveterm/wavesrv/pkg/newton"
+	_ "github.com/wavetermdev/waveterm/wavesrv/pkg/scws"
+	_ "github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+	_ "github.com/wavetermdev/waveterm/wavesrv/pkg/userinput"
+)
+
+func main() {
+	tsPath := flag.String("ts", "../src/types/gen/updatetypes.ts", "typescript output file")
+	schemaPath := flag.String("schema", "", "json schema output file (not written if empty)")
+	flag.Parse()
+	err := os.WriteFile(*tsPath, []byte(scbus.GenerateTsDefs()), 0644)
+	if err != nil {
+		log.Fatalf("cannot write typescript definitions: %v\n", err)
+	}
+	log.Printf("wrote %s (%d model update items)\n", *tsPath, len(scbus.GetModelUpdateItemKeys()))
+	if *schemaPath != "" {
+		barr, err := json.MarshalIndent(scbus.GetUpdateJsonSchema(), "", "  ")
+		if err != nil {
+			log.Fatalf("cannot marshal json schema: %v\n", err)
+		}
+		err = os.WriteFile(*schemaPath, append(barr, '\n'), 0644)
+		if err != nil {
+			log.Fatalf("cannot write json schema: %v\n", err)
+		}
+		log.Printf("wrote %s\n", *schemaPath)
+	}
+}
@@ -4,6 +4,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
@@ -34,18 +35,24 @@ import (
 	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
 	"github.com/wavetermdev/waveterm/waveshell/pkg/server"
 	"github.com/wavetermdev/waveterm/waveshell/pkg/wlog"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/blockstore"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/bufferedpipe"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/cmdrunner"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/configstore"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/ephemeral"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/history"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/historysync"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/osintegration"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/pcloud"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/releasechecker"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/rtnstate"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scheduler"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/scws"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/srvmetrics"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/telemetry"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/waveenc"
@@ -70,6 +77,33 @@ const InitialTelemetryWait = 30 * time.Second
 const TelemetryTick = 10 * time.Minute
 const TelemetryInterval = 4 * time.Hour
 
+const EphemeralLineCleanupTick = 30 * time.Second
+
+const InitialPtyArchiveWait = 5 * time.Minute
+const PtyArchiveTick = 6 * time.Hour
+
+const InitialStateCompactWait = 10 * time.Minute
+const StateCompactTick = 6 * time.Hour
+
+const IdleKillTick = 1 * time.Minute
+
+const CmdRunningNotifyTick = 5 * time.Second
+
+const InitialBlockRetentionWait = 15 * time.Minute
+const BlockRetentionTick = 6 * time.Hour
+
+const InitialBackupWait = 10 * time.Minute
+const BackupTick = 15 * time.Minute
+
+const InitialTrashPurgeWait = 5 * time.Minute
+const TrashPurgeTick = 1 * time.Hour
+
+const InitialAutoArchiveWait = 10 * time.Minute
+const AutoArchiveTick = 1 * time.Hour
+
+const InitialHistorySyncWait = 2 * time.Minute
+const HistorySyncTick = 15 * time.Minute
+
 const MaxWriteFileMemSize = 20 * (1024 * 1024) // 20M
 
 // these are set at build time
@@ -200,7 +234,9 @@ func HandleSetWinSize(w http.ResponseWriter, r *http.Request) {
 		WriteJsonError(w, fmt.Errorf(ErrorDecodingJson, err))
 		return
 	}
-	err = sstore.SetWinSize(r.Context(), winSize)
+	// windowid is "" for the main window
+	windowId := r.URL.Query().Get("windowid")
+	err = sstore.SetWindowWinSize(r.Context(), windowId, winSize)
 	if err != nil {
 		WriteJsonError(w, fmt.Errorf("error setting winsize: %w", err))
 		return
@@ -278,9 +314,178 @@ func HandleGetScreenLines(w http.ResponseWriter, r *http.Request) {
 		WriteJsonError(w, err)
 		return
 	}
+	if screenLines != nil && sstore.IsDemoMode() {
+		maskedLines := sstore.MaskScreenLinesForDemo(*screenLines)
+		screenLines = &maskedLines
+	}
 	WriteJsonSuccess(w, screenLines)
 }
 
+// params: screenid
+func HandleGetScreenTranscript(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(CacheControlHeaderKey, CacheControlHeaderNoCache)
+	qvals := r.URL.Query()
+	screenId := qvals.Get("screenid")
+	if _, err := uuid.Parse(screenId); err != nil {
+		WriteJsonError(w, fmt.Errorf("invalid screenid, err: %w", err))
+		return
+	}
+	transcript, err := sstore.GetScreenTranscript(r.Context(), screenId)
+	if err != nil {
+		WriteJsonError(w, err)
+		return
+	}
+	WriteJsonSuccess(w, transcript)
+}
+
+type FeedResponse struct {
+	FeedType string      `json:"feedtype"`
+	Items    interface{} `json:"items"`
+	Cursor   string      `json:"cursor"` // pass as the cursor of the next poll
+	HasMore  bool        `json:"hasmore,omitempty"`
+	Reset    bool        `json:"reset,omitempty"` // updates were missed, resync (poll from cursor 0)
+}
+
+// the active screen's remote, cwd, git branch, and last command, for editor integrations (null if there is no
+// active screen).  changes are sent as "currentcontext" updates on the websocket.
+func HandleCurrentContext(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(CacheControlHeaderKey, CacheControlHeaderNoCache)
+	curCtx, err := cmdrunner.GetCurrentContext(r.Context())
+	if err != nil {
+		WriteJsonError(w, err)
+		return
+	}
+	WriteJsonSuccess(w, curCtx)
+}
+
+// polling feed of line/history changes (see sstore/feed.go).  params: type (line or history), cursor, limit,
+// screenid.  the ETag/Last-Modified are the head of the feed's journal, so an unchanged feed answers
+// If-None-Match/If-Modified-Since with a 304.
+func HandleFeed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(CacheControlHeaderKey, CacheControlHeaderNoCache)
+	qvals := r.URL.Query()
+	feedType := qvals.Get("type")
+	if !sstore.IsValidFeedType(feedType) {
+		WriteJsonError(w, fmt.Errorf("invalid feed type %q (must be %q or %q)", feedType, sstore.FeedType_Line, sstore.FeedType_History))
+		return
+	}
+	screenId := qvals.Get("screenid")
+	if screenId != "" {
+		if _, err := uuid.Parse(screenId); err != nil {
+			WriteJsonError(w, fmt.Errorf(ErrorInvalidScreenId, err))
+			return
+		}
+	}
+	var cursor int64
+	if cursorStr := qvals.Get("cursor"); cursorStr != "" {
+		var err error
+		cursor, err = strconv.ParseInt(cursorStr, 10, 64)
+		if err != nil || cursor < 0 {
+			WriteJsonError(w, fmt.Errorf("invalid cursor %q", cursorStr))
+			return
+		}
+	}
+	var limit int
+	if limitStr := qvals.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil {
+			WriteJsonError(w, fmt.Errorf("invalid limit: %w", err))
+			return
+		}
+	}
+	headId, headTs, err := sstore.GetFeedHead(r.Context(), feedType)
+	if err != nil {
+		WriteJsonError(w, err)
+		return
+	}
+	etag := fmt.Sprintf("\"%s-%d\"", feedType, headId)
+	lastModified := time.UnixMilli(headTs).UTC()
+	w.Header().Set("ETag", etag)
+	if headTs > 0 {
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if inm == etag || inm == "W/"+etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && headTs > 0 {
+		imsTime, err := http.ParseTime(ims)
+		if err == nil && !lastModified.Truncate(time.Second).After(imsTime) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	page, err := sstore.GetFeedUpdates(r.Context(), feedType, cursor, limit, screenId)
+	if err != nil {
+		WriteJsonError(w, err)
+		return
+	}
+	rtn := FeedResponse{FeedType: feedType, Cursor: strconv.FormatInt(page.NextCursor, 10), HasMore: page.HasMore, Reset: page.Reset}
+	if feedType == sstore.FeedType_Line {
+		rtn.Items, err = sstore.GetFeedLineItems(r.Context(), page.Updates)
+	} else {
+		rtn.Items, err = history.GetHistoryFeedItems(r.Context(), page.Updates)
+	}
+	if err != nil {
+		WriteJsonError(w, err)
+		return
+	}
+	WriteJsonSuccess(w, rtn)
+}
+
+// params: provider, q, limit (omit provider to list the providers)
+func HandleOsIntegrationQuery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(CacheControlHeaderKey, CacheControlHeaderNoCache)
+	qvals := r.URL.Query()
+	providerName := qvals.Get("provider")
+	if providerName == "" {
+		WriteJsonSuccess(w, osintegration.GetProviders())
+		return
+	}
+	var limit int
+	if limitStr := qvals.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil {
+			WriteJsonError(w, fmt.Errorf("invalid limit: %w", err))
+			return
+		}
+	}
+	items, err := osintegration.Query(r.Context(), providerName, qvals.Get("q"), limit)
+	if err != nil {
+		WriteJsonError(w, err)
+		return
+	}
+	WriteJsonSuccess(w, items)
+}
+
+type OpenDeepLinkRequest struct {
+	Url string `json:"url"`
+}
+
+func HandleOpenDeepLink(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	var body OpenDeepLinkRequest
+	err := decoder.Decode(&body)
+	if err != nil {
+		WriteJsonError(w, fmt.Errorf(ErrorDecodingJson, err))
+		return
+	}
+	link, err := osintegration.ParseDeepLink(body.Url)
+	if err != nil {
+		WriteJsonError(w, err)
+		return
+	}
+	err = osintegration.OpenDeepLink(r.Context(), link)
+	if err != nil {
+		WriteJsonError(w, err)
+		return
+	}
+	WriteJsonSuccess(w, true)
+}
+
 func HandleRtnState(w http.ResponseWriter, r *http.Request) {
 	defer func() {
 		r := recover()
@@ -320,6 +525,121 @@ func HandleRtnState(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
+// compares the output of two command lines (see sstore.DiffLines)
+func HandleLineDiff(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(CacheControlHeaderKey, CacheControlHeaderNoCache)
+	qvals := r.URL.Query()
+	var ids []string
+	for _, key := range []string{"screenida", "lineida", "screenidb", "lineidb"} {
+		id := qvals.Get(key)
+		if _, err := uuid.Parse(id); err != nil {
+			WriteJsonError(w, fmt.Errorf("invalid %s: %w", key, err))
+			return
+		}
+		ids = append(ids, id)
+	}
+	diff, err := sstore.DiffLines(r.Context(), ids[0], ids[1], ids[2], ids[3])
+	if err != nil {
+		WriteJsonError(w, err)
+		return
+	}
+	WriteJsonSuccess(w, diff)
+}
+
+// lineid is optional, without it the whole screen is exported
+func HandleExportAsciinema(w http.ResponseWriter, r *http.Request) {
+	qvals := r.URL.Query()
+	screenId := qvals.Get("screenid")
+	lineId := qvals.Get("lineid")
+	if _, err := uuid.Parse(screenId); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(ErrorInvalidScreenId, err)))
+		return
+	}
+	if lineId != "" {
+		if _, err := uuid.Parse(lineId); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf(ErrorInvalidLineId, err)))
+			return
+		}
+	}
+	// exported to a buffer so an error can still be returned as an error response
+	var buf bytes.Buffer
+	var err error
+	fileName := "screen.cast"
+	if lineId != "" {
+		fileName = "line.cast"
+		err = sstore.ExportLineAsciinema(r.Context(), screenId, lineId, &buf)
+	} else {
+		_, err = sstore.ExportScreenAsciinema(r.Context(), screenId, &buf)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(html.EscapeString(fmt.Sprintf("error exporting recording: %v", err))))
+		return
+	}
+	w.Header().Set(CacheControlHeaderKey, CacheControlHeaderNoCache)
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// raw output of a line with binary output (see sstore/binaryout.go)
+func HandleGetRawOut(w http.ResponseWriter, r *http.Request) {
+	qvals := r.URL.Query()
+	screenId := qvals.Get("screenid")
+	lineId := qvals.Get("lineid")
+	if _, err := uuid.Parse(screenId); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(ErrorInvalidScreenId, err)))
+		return
+	}
+	if _, err := uuid.Parse(lineId); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(ErrorInvalidLineId, err)))
+		return
+	}
+	if qvals.Get("thumb") == "1" {
+		thumbData, err := sstore.ReadCmdRawOutThumbnail(r.Context(), screenId, lineId)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("line has no thumbnail"))
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(html.EscapeString(fmt.Sprintf("error reading thumbnail: %v", err))))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		w.Write(thumbData)
+		return
+	}
+	data, totalSize, err := sstore.ReadCmdRawOut(r.Context(), screenId, lineId)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("line has no binary output"))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(html.EscapeString(fmt.Sprintf("error reading raw output: %v", err))))
+		return
+	}
+	// download only, the detected mime type is informational (X-RawOutMimeType)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "output.bin"))
+	if mimeType := sstore.GetCmdRawOutMimeType(r.Context(), screenId, lineId); mimeType != "" {
+		w.Header().Set("X-RawOutMimeType", mimeType)
+	}
+	w.Header().Set("X-RawOutTotalSize", strconv.FormatInt(totalSize, 10))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
 func HandleRemotePty(w http.ResponseWriter, r *http.Request) {
 	qvals := r.URL.Query()
 	remoteId := qvals.Get("remoteid")
@@ -422,6 +742,10 @@ func HandleWriteFile(w http.ResponseWriter, r *http.Request) {
 		WriteJsonError(w, fmt.Errorf(ErrorPanic, r))
 	}()
 	w.Header().Set(CacheControlHeaderKey, CacheControlHeaderNoCache)
+	if err := scbase.CheckReadOnly("write-file"); err != nil {
+		WriteJsonError(w, err)
+		return
+	}
 	params, mpFile, err := parseWriteFileParams(r)
 	if err != nil {
 		WriteJsonError(w, fmt.Errorf("error parsing multipart form params: %w", err))
@@ -711,6 +1035,7 @@ func HandleRunCommand(w http.ResponseWriter, r *http.Request) {
 	}
 	if update != nil {
 		update.Clean()
+		scbus.CheckUpdate(update)
 	}
 	WriteJsonSuccess(w, update)
 }
@@ -726,6 +1051,10 @@ func HandleRunEphemeralCommand(w http.ResponseWriter, r *http.Request) {
 		WriteJsonError(w, fmt.Errorf(ErrorPanic, r))
 	}()
 	w.Header().Set(CacheControlHeaderKey, CacheControlHeaderNoCache)
+	if err := scbase.CheckReadOnly("run-ephemeral-command"); err != nil {
+		WriteJsonError(w, err)
+		return
+	}
 	decoder := json.NewDecoder(r.Body)
 	var commandPk scpacket.FeCommandPacketType
 	err := decoder.Decode(&commandPk)
@@ -966,6 +1295,312 @@ func telemetryLoop() {
 	}
 }
 
+// removes expired ephemeral lines
+func ephemeralLineCleanupLoop() {
+	for {
+		if scbase.IsReadOnly() {
+			time.Sleep(EphemeralLineCleanupTick)
+			continue
+		}
+		ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+		update, err := sstore.RemoveEphemeralLines(ctx, "", time.Now().UnixMilli())
+		cancelFn()
+		if err != nil {
+			log.Printf("[error] removing expired ephemeral lines: %v\n", err)
+		}
+		if update != nil {
+			scbus.MainUpdateBus.DoUpdate(update)
+		}
+		time.Sleep(EphemeralLineCleanupTick)
+	}
+}
+
+func ptyArchiveWrapper() {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		log.Printf("[error] in ptyArchiveWrapper: %v\n", r)
+		debug.PrintStack()
+	}()
+	if scbase.IsReadOnly() {
+		return
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancelFn()
+	clientData, err := sstore.EnsureClientData(ctx)
+	if err != nil {
+		log.Printf("[error] getting client data for ptyout archival: %v\n", err)
+		return
+	}
+	numArchived, reclaimed, err := sstore.ArchiveOldPtyOutFiles(ctx, clientData.ClientOpts.PtyArchiveDays)
+	if err != nil {
+		log.Printf("[error] archiving ptyout files: %v\n", err)
+	}
+	if numArchived > 0 {
+		log.Printf("archived %d ptyout files, reclaimed %d bytes\n", numArchived, reclaimed)
+	}
+}
+
+// compresses the blockstore data of old ptyout files (see client opt ptyarchivedays)
+func ptyArchiveLoop() {
+	time.Sleep(InitialPtyArchiveWait)
+	for {
+		ptyArchiveWrapper()
+		time.Sleep(PtyArchiveTick)
+	}
+}
+
+func stateCompactWrapper() {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		log.Printf("[error] in stateCompactWrapper: %v\n", r)
+		debug.PrintStack()
+	}()
+	if scbase.IsReadOnly() {
+		return
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancelFn()
+	stats, err := sstore.CompactStates(ctx, remote.GetActiveStateHashes())
+	if err != nil {
+		log.Printf("[error] compacting shell states: %v\n", err)
+	}
+	if stats.RIsRebased > 0 || stats.DiffsRemoved > 0 || stats.BasesRemoved > 0 {
+		log.Printf("compacted shell states, rebased %d remote instances, removed %d diffs and %d bases (%d bytes)\n", stats.RIsRebased, stats.DiffsRemoved, stats.BasesRemoved, stats.BytesRemoved)
+	}
+}
+
+// rebases long shell state diff chains and removes unreferenced states
+func stateCompactLoop() {
+	time.Sleep(InitialStateCompactWait)
+	for {
+		stateCompactWrapper()
+		time.Sleep(StateCompactTick)
+	}
+}
+
+func blockRetentionWrapper() {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		log.Printf("[error] in blockRetentionWrapper: %v\n", r)
+		debug.PrintStack()
+	}()
+	if scbase.IsReadOnly() {
+		return
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancelFn()
+	numDeleted, err := blockstore.ApplyRetentionRules(ctx)
+	if err != nil {
+		log.Printf("[error] applying blockstore retention rules: %v\n", err)
+	}
+	if numDeleted > 0 {
+		log.Printf("pruned %d blockstore files (retention rules)\n", numDeleted)
+	}
+}
+
+// deletes old blockstore files with the registered retention rules (see blockstore.RegisterRetentionRule)
+func blockRetentionLoop() {
+	time.Sleep(InitialBlockRetentionWait)
+	for {
+		blockRetentionWrapper()
+		time.Sleep(BlockRetentionTick)
+	}
+}
+
+func idleKillWrapper() {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		log.Printf("[error] in idleKillWrapper: %v\n", r)
+		debug.PrintStack()
+	}()
+	if scbase.IsReadOnly() {
+		return
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelFn()
+	remote.RunIdleKillCheck(ctx)
+}
+
+// enforces the idle-kill policies (screen and remote idlekill), see remote/idlekill.go
+func idleKillLoop() {
+	for {
+		time.Sleep(IdleKillTick)
+		idleKillWrapper()
+	}
+}
+
+func cmdRunningNotifyWrapper() {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		log.Printf("[error] in cmdRunningNotifyWrapper: %v\n", r)
+		debug.PrintStack()
+	}()
+	if scbase.IsReadOnly() {
+		return
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFn()
+	update, err := sstore.NotifyLongRunningCmds(ctx)
+	if err != nil {
+		log.Printf("[error] notifying long running commands: %v\n", err)
+	}
+	if update != nil {
+		scbus.MainUpdateBus.DoUpdate(update)
+	}
+}
+
+// notifies commands that are still running after cmddonenotifysecs (see sstore.NotifyLongRunningCmds)
+func cmdRunningNotifyLoop() {
+	for {
+		time.Sleep(CmdRunningNotifyTick)
+		cmdRunningNotifyWrapper()
+	}
+}
+
+func backupWrapper() {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		log.Printf("[error] in backupWrapper: %v\n", r)
+		debug.PrintStack()
+	}()
+	if scbase.IsReadOnly() {
+		return
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancelFn()
+	info, err := sstore.RunScheduledBackup(ctx)
+	if err != nil {
+		log.Printf("[error] creating scheduled backup: %v\n", err)
+		return
+	}
+	if info != nil {
+		log.Printf("created backup %s (%d bytes)\n", info.Dir, info.Size)
+	}
+}
+
+// scheduled backups (see client opts backuphours, backupdir, backupkeep)
+func backupLoop() {
+	time.Sleep(InitialBackupWait)
+	for {
+		backupWrapper()
+		time.Sleep(BackupTick)
+	}
+}
+
+func trashPurgeWrapper() {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		log.Printf("[error] in trashPurgeWrapper: %v\n", r)
+		debug.PrintStack()
+	}()
+	if scbase.IsReadOnly() {
+		return
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancelFn()
+	numPurged, err := sstore.PurgeExpiredTrash(ctx)
+	if err != nil {
+		log.Printf("[error] purging trash: %v\n", err)
+		return
+	}
+	if numPurged > 0 {
+		log.Printf("purged %d expired items from the trash\n", numPurged)
+	}
+}
+
+// deleted screens and sessions are purged after trashdays (client opt)
+func trashPurgeLoop() {
+	time.Sleep(InitialTrashPurgeWait)
+	for {
+		trashPurgeWrapper()
+		time.Sleep(TrashPurgeTick)
+	}
+}
+
+func autoArchiveWrapper() {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		log.Printf("[error] in autoArchiveWrapper: %v\n", r)
+		debug.PrintStack()
+	}()
+	if scbase.IsReadOnly() {
+		return
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancelFn()
+	archived, update, err := sstore.RunAutoArchive(ctx)
+	if err != nil {
+		log.Printf("[error] auto-archiving idle screens: %v\n", err)
+		return
+	}
+	if len(archived) > 0 {
+		log.Printf("auto-archived %d idle screens\n", len(archived))
+		scbus.MainUpdateBus.DoUpdate(update)
+	}
+}
+
+// screens idle for autoarchivedays (client opt) are archived
+func autoArchiveLoop() {
+	time.Sleep(InitialAutoArchiveWait)
+	for {
+		autoArchiveWrapper()
+		time.Sleep(AutoArchiveTick)
+	}
+}
+
+func historySyncWrapper() {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		log.Printf("[error] in historySyncWrapper: %v\n", r)
+		debug.PrintStack()
+	}()
+	if scbase.IsReadOnly() {
+		return
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancelFn()
+	err := historysync.RunSyncIfEnabled(ctx)
+	if err != nil {
+		log.Printf("[historysync] error syncing history: %v\n", err)
+	}
+}
+
+// syncs the history with the other installations (opt-in, see historysync)
+func historySyncLoop() {
+	time.Sleep(InitialHistorySyncWait)
+	for {
+		historySyncWrapper()
+		time.Sleep(HistorySyncTick)
+	}
+}
+
 // watch stdin, kill server if stdin is closed
 func stdinReadWatch() {
 	buf := make([]byte, 1024)
@@ -995,7 +1630,16 @@ func doShutdown(reason string) {
 		log.Printf("[wave] local server %v, start shutdown\n", reason)
 		shutdownActivityUpdate()
 		sendTelemetryWrapper()
+		err := sstore.FlushScreenInputs(context.Background())
+		if err != nil {
+			log.Printf("[wave] error saving screen inputs: %v\n", err)
+		}
+		err = blockstore.FlushCache(context.Background())
+		if err != nil {
+			log.Printf("[wave] error flushing blockstore cache: %v\n", err)
+		}
 		log.Printf("[wave] closing db connection\n")
+		blockstore.CloseDB()
 		sstore.CloseDB()
 		log.Printf("[wave] *** shutting down local server\n")
 		watcher := configstore.GetWatcher()
@@ -1054,6 +1698,36 @@ func configWatcher() {
 	}
 }
 
+// fixes the state left by the previous run (not run in read-only mode)
+func runStartupFixups() {
+	err := sstore.HangupAllRunningCmds(context.Background())
+	if err != nil {
+		log.Printf("[error] calling HUP on all running commands: %v\n", err)
+	}
+	err = sstore.FixInterruptedTransfers(context.Background())
+	if err != nil {
+		log.Printf("[error] fixing interrupted transfers: %v\n", err)
+	}
+	err = sstore.ReInitFocus(context.Background())
+	if err != nil {
+		log.Printf("[error] resetting screen focus: %v\n", err)
+	}
+	reconcileStats, err := sstore.ReconcileScreenDirs(context.Background())
+	if err != nil {
+		log.Printf("[error] reconciling screen dirs: %v\n", err)
+	}
+	if reconcileStats.Quarantined > 0 || reconcileStats.Removed > 0 {
+		log.Printf("reconciled screen dirs, quarantined %d orphaned dirs, removed %d expired dirs\n", reconcileStats.Quarantined, reconcileStats.Removed)
+	}
+}
+
+func sshConfigWatcher() {
+	err := cmdrunner.RunSshConfigWatcher()
+	if err != nil {
+		log.Printf("[error] ssh config watcher: %v\n", err)
+	}
+}
+
 func startupActivityUpdate() {
 	activity := telemetry.ActivityUpdate{
 		NumConns: remote.NumRemotes(),
@@ -1088,6 +1762,7 @@ func main() {
 
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
 
+	scbase.InitReadOnly(os.Args[1:])
 	if len(os.Args) >= 2 && os.Args[1] == "--test" {
 		log.Printf("running test fn\n")
 		err := test()
@@ -1123,22 +1798,47 @@ func main() {
 		log.Printf("[error] ensuring config directory: %v\n", err)
 		return
 	}
-	err = sstore.TryMigrateUp()
-	if err != nil {
-		log.Printf("[error] migrate up: %v\n", err)
-		return
+	if scbase.IsReadOnly() {
+		log.Printf("[wave] read-only mode, skipping db migrations and startup fixups\n")
+		err = sstore.CheckMigrationVersion()
+		if err != nil {
+			log.Printf("[error] cannot start in read-only mode: %v\n", err)
+			return
+		}
+	} else {
+		err = sstore.ApplyPendingRestore()
+		if err != nil {
+			log.Printf("[error] restoring backup: %v\n", err)
+			return
+		}
+		err = sstore.TryMigrateUp()
+		if err != nil {
+			log.Printf("[error] migrate up: %v\n", err)
+			return
+		}
+		err = blockstore.MigrateBlockstore()
+		if err != nil {
+			log.Printf("[error] migrate blockstore: %v\n", err)
+			return
+		}
+		numReplayed, err := blockstore.ReplayJournal(context.Background())
+		if err != nil {
+			log.Printf("[error] replaying blockstore journal: %v\n", err)
+		} else if numReplayed > 0 {
+			log.Printf("[blockstore] replayed %d journaled writes\n", numReplayed)
+		}
+		err = sstore.MigratePtyOutFiles(context.Background())
+		if err != nil {
+			log.Printf("[error] migrating ptyout files: %v\n", err)
+		}
 	}
-	// err = blockstore.MigrateBlockstore()
-	// if err != nil {
-	// 	log.Printf("[error] migrate blockstore: %v\n", err)
-	// 	return
-	// }
 	clientData, err := sstore.EnsureClientData(context.Background())
 	if err != nil {
 		log.Printf("[error] ensuring client data: %v\n", err)
 		return
 	}
 	log.Printf("userid = %s\n", clientData.UserId)
+	sstore.InitClientOpts(clientData)
 	err = sstore.EnsureLocalRemote(context.Background())
 	if err != nil {
 		log.Printf("[error] ensuring local remote: %v\n", err)
@@ -1150,20 +1850,47 @@ func main() {
 		return
 	}
 
-	err = sstore.HangupAllRunningCmds(context.Background())
-	if err != nil {
-		log.Printf("[error] calling HUP on all running commands: %v\n", err)
-	}
-	err = sstore.ReInitFocus(context.Background())
-	if err != nil {
-		log.Printf("[error] resetting screen focus: %v\n", err)
+	if !scbase.IsReadOnly() {
+		runStartupFixups()
 	}
 
 	log.Printf("PCLOUD_ENDPOINT=%s\n", pcloud.GetEndpoint())
 	startupActivityUpdate()
 	installSignalHandlers()
 	go telemetryLoop()
+	go ephemeralLineCleanupLoop()
+	go ptyArchiveLoop()
+	go stateCompactLoop()
+	go blockRetentionLoop()
+	go idleKillLoop()
+	go cmdRunningNotifyLoop()
+	go historySyncLoop()
+	go backupLoop()
+	go trashPurgeLoop()
+	go autoArchiveLoop()
+	go scheduler.RunDispatcherLoop(cmdrunner.RunScheduledCommand)
 	go configWatcher()
+	go sshConfigWatcher()
+	go cmdrunner.RunCurrentContextWatcher()
+	remote.SetMetricsInterval(clientData.ClientOpts.MetricsInterval)
+	if clientData.ClientOpts.TmuxControl {
+		err = cmdrunner.SetTmuxControl(true)
+		if err != nil {
+			log.Printf("[error] starting tmux control mode: %v\n", err)
+		}
+	}
+	if clientData.ClientOpts.SshAttachAddr != "" {
+		err = cmdrunner.SetSshAttach(clientData.ClientOpts.SshAttachAddr)
+		if err != nil {
+			log.Printf("[error] starting ssh attach server: %v\n", err)
+		}
+	}
+	if clientData.ClientOpts.MetricsPort > 0 {
+		err = srvmetrics.SetPort(clientData.ClientOpts.MetricsPort)
+		if err != nil {
+			log.Printf("[error] starting metrics endpoint: %v\n", err)
+		}
+	}
 	go stdinReadWatch()
 	go runWebSocketServer()
 	go func() {
@@ -1174,7 +1901,13 @@ func main() {
 	gr.HandleFunc("/api/ptyout", AuthKeyWrap(HandleGetPtyOut))
 	gr.HandleFunc("/api/remote-pty", AuthKeyWrap(HandleRemotePty))
 	gr.HandleFunc("/api/rtnstate", AuthKeyWrap(HandleRtnState))
+	gr.HandleFunc("/api/line-diff", AuthKeyWrap(HandleLineDiff))
+	gr.HandleFunc("/api/export-asciinema", AuthKeyWrap(HandleExportAsciinema))
+	gr.HandleFunc("/api/rawout", AuthKeyWrap(HandleGetRawOut))
 	gr.HandleFunc("/api/get-screen-lines", AuthKeyWrap(HandleGetScreenLines))
+	gr.HandleFunc("/api/screen-transcript", AuthKeyWrap(HandleGetScreenTranscript))
+	gr.HandleFunc("/api/feed", AuthKeyWrap(HandleFeed))
+	gr.HandleFunc("/api/current-context", AuthKeyWrap(HandleCurrentContext))
 	gr.HandleFunc("/api/run-command", AuthKeyWrap(HandleRunCommand)).Methods("POST")
 	gr.HandleFunc("/api/run-ephemeral-command", AuthKeyWrap(HandleRunEphemeralCommand)).Methods("POST")
 	gr.HandleFunc(bufferedpipe.BufferedPipeGetterUrl, AuthKeyWrapAllowHmac(bufferedpipe.HandleGetBufferedPipeOutput))
@@ -1184,6 +1917,8 @@ func main() {
 	gr.HandleFunc("/api/log-active-state", AuthKeyWrap(HandleLogActiveState))
 	gr.HandleFunc("/api/read-file", AuthKeyWrapAllowHmac(HandleReadFile))
 	gr.HandleFunc("/api/write-file", AuthKeyWrap(HandleWriteFile)).Methods("POST")
+	gr.HandleFunc("/api/osintegration/query", AuthKeyWrap(HandleOsIntegrationQuery))
+	gr.HandleFunc("/api/osintegration/open-deep-link", AuthKeyWrap(HandleOpenDeepLink)).Methods("POST")
 	configPath := filepath.Join(scbase.GetWaveHomeDir(), "config") + string(filepath.Separator)
 	log.Printf("[wave] config path: %q\n", configPath)
 	isFileHandler := http.StripPrefix("/config/", http.FileServer(http.Dir(configPath)))
@@ -0,0 +1,2 @@
+ALTER TABLE block_data DROP COLUMN codec;
+ALTER TABLE block_file DROP COLUMN compress;
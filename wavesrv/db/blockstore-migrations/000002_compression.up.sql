@@ -0,0 +1,2 @@
+ALTER TABLE block_file ADD COLUMN compress boolean NOT NULL DEFAULT 0;
+ALTER TABLE block_data ADD COLUMN codec varchar(20) NOT NULL DEFAULT '';
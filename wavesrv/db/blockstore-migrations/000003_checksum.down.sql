@@ -0,0 +1 @@
+ALTER TABLE block_data DROP COLUMN checksum;
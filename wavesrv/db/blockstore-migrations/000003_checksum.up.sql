@@ -0,0 +1 @@
+ALTER TABLE block_data ADD COLUMN checksum varchar(20) NOT NULL DEFAULT '';
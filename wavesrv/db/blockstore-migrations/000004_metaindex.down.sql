@@ -0,0 +1,3 @@
+DROP INDEX block_file_metaidx_value;
+DROP TABLE block_file_metaidx;
+ALTER TABLE block_file DROP COLUMN indexmeta;
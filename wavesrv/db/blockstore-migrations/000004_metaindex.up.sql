@@ -0,0 +1,11 @@
+ALTER TABLE block_file ADD COLUMN indexmeta json NOT NULL DEFAULT '[]';
+
+CREATE TABLE block_file_metaidx (
+    blockid varchar(36) NOT NULL,
+    name varchar(200) NOT NULL,
+    metakey varchar(200) NOT NULL,
+    metavalue text NOT NULL,
+    PRIMARY KEY (blockid, name, metakey)
+);
+
+CREATE INDEX block_file_metaidx_value ON block_file_metaidx (blockid, metakey, metavalue);
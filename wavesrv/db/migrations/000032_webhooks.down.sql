@@ -0,0 +1,3 @@
+DROP INDEX idx_notification_log_ts;
+DROP TABLE notification_log;
+DROP TABLE webhook;
@@ -0,0 +1,23 @@
+CREATE TABLE webhook (
+    webhookid varchar(36) PRIMARY KEY,
+    name varchar(50) NOT NULL,
+    url text NOT NULL,
+    template text NOT NULL,
+    filter text NOT NULL,
+    enabled boolean NOT NULL,
+    createdts bigint NOT NULL
+);
+
+CREATE TABLE notification_log (
+    logid varchar(36) PRIMARY KEY,
+    webhookid varchar(36) NOT NULL,
+    ts bigint NOT NULL,
+    screenid varchar(36) NOT NULL,
+    lineid varchar(36) NOT NULL,
+    status varchar(10) NOT NULL,
+    attempts int NOT NULL,
+    httpstatus int NOT NULL,
+    errorstr text NOT NULL
+);
+
+CREATE INDEX idx_notification_log_ts ON notification_log (ts);
@@ -0,0 +1,15 @@
+CREATE TABLE schedule (
+    scheduleid varchar(36) PRIMARY KEY,
+    screenid varchar(36) NOT NULL,
+    remoteownerid varchar(36) NOT NULL,
+    remoteid varchar(36) NOT NULL,
+    remotename varchar(50) NOT NULL,
+    cmdstr text NOT NULL,
+    cronexpr varchar(100) NOT NULL,
+    enabled boolean NOT NULL,
+    createdts bigint NOT NULL,
+    nextrunts bigint NOT NULL,
+    lastrunts bigint NOT NULL,
+    lastlineid varchar(36) NOT NULL,
+    lasterror text NOT NULL
+);
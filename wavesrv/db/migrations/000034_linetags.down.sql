@@ -0,0 +1,2 @@
+DROP INDEX idx_line_tag_tag;
+DROP TABLE line_tag;
@@ -0,0 +1,9 @@
+CREATE TABLE line_tag (
+    screenid varchar(36) NOT NULL,
+    lineid varchar(36) NOT NULL,
+    tag varchar(50) NOT NULL,
+    createdts bigint NOT NULL,
+    PRIMARY KEY (screenid, lineid, tag)
+);
+
+CREATE INDEX idx_line_tag_tag ON line_tag(tag);
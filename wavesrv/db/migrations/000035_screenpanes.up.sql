@@ -0,0 +1 @@
+ALTER TABLE screen ADD COLUMN panelayout json NOT NULL DEFAULT 'null';
@@ -0,0 +1,8 @@
+CREATE TABLE window (
+    windowid varchar(36) PRIMARY KEY,
+    name varchar(50) NOT NULL,
+    activesessionid varchar(36) NOT NULL,
+    activescreenid varchar(36) NOT NULL,
+    winsize json NOT NULL,
+    createdts bigint NOT NULL
+);
@@ -0,0 +1,16 @@
+CREATE TABLE transfer (
+    transferid varchar(36) PRIMARY KEY,
+    srcremoteid varchar(36) NOT NULL,
+    srcpath varchar(4096) NOT NULL,
+    dstremoteid varchar(36) NOT NULL,
+    dstpath varchar(4096) NOT NULL,
+    status varchar(20) NOT NULL,
+    totalbytes bigint NOT NULL,
+    bytesdone bigint NOT NULL,
+    startoffset bigint NOT NULL,
+    sha256 varchar(64) NOT NULL,
+    errorstr varchar(1000) NOT NULL,
+    startts bigint NOT NULL,
+    endts bigint NOT NULL
+);
+CREATE INDEX idx_transfer_startts ON transfer(startts);
@@ -0,0 +1,2 @@
+DROP TABLE remote_group;
+ALTER TABLE remote DROP COLUMN groupid;
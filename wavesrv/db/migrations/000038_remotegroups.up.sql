@@ -0,0 +1,6 @@
+CREATE TABLE remote_group (
+    groupid varchar(36) PRIMARY KEY,
+    name varchar(50) NOT NULL,
+    groupidx int NOT NULL
+);
+ALTER TABLE remote ADD COLUMN groupid varchar(36) NOT NULL DEFAULT '';
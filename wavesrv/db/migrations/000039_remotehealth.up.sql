@@ -0,0 +1,12 @@
+CREATE TABLE remote_health (
+    remoteid varchar(36) PRIMARY KEY,
+    lastpingts bigint NOT NULL,
+    lastlatencyms bigint NOT NULL,
+    avglatencyms bigint NOT NULL,
+    numpings int NOT NULL,
+    numfailedpings int NOT NULL,
+    numdrops int NOT NULL,
+    lastdropts bigint NOT NULL,
+    numreconnects int NOT NULL,
+    lastreconnectts bigint NOT NULL
+);
@@ -0,0 +1,9 @@
+CREATE TABLE macro (
+    macroid varchar(36) PRIMARY KEY,
+    name varchar(50) NOT NULL,
+    description text NOT NULL,
+    steps json NOT NULL,
+    createdts bigint NOT NULL,
+    lastrunts bigint NOT NULL
+);
+CREATE UNIQUE INDEX idx_macro_name ON macro (name);
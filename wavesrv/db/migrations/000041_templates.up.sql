@@ -0,0 +1,8 @@
+CREATE TABLE template (
+    templateid varchar(36) PRIMARY KEY,
+    name varchar(50) NOT NULL,
+    description text NOT NULL,
+    screens json NOT NULL,
+    createdts bigint NOT NULL
+);
+CREATE UNIQUE INDEX idx_template_name ON template (name);
@@ -0,0 +1,2 @@
+DROP TABLE envprofile_attach;
+DROP TABLE envprofile;
@@ -0,0 +1,14 @@
+CREATE TABLE envprofile (
+    profileid varchar(36) PRIMARY KEY,
+    name varchar(50) NOT NULL,
+    vars json NOT NULL,
+    createdts bigint NOT NULL
+);
+CREATE UNIQUE INDEX idx_envprofile_name ON envprofile (name);
+CREATE TABLE envprofile_attach (
+    targettype varchar(10) NOT NULL,
+    targetid varchar(36) NOT NULL,
+    profileid varchar(36) NOT NULL,
+    attachts bigint NOT NULL,
+    PRIMARY KEY (targettype, targetid, profileid)
+);
@@ -0,0 +1,10 @@
+CREATE TABLE dirbookmark (
+    bookmarkid varchar(36) PRIMARY KEY,
+    remoteid varchar(36) NOT NULL,
+    name varchar(50) NOT NULL,
+    cwd varchar(300) NOT NULL,
+    createdts bigint NOT NULL,
+    lastusedts bigint NOT NULL,
+    usecount int NOT NULL
+);
+CREATE UNIQUE INDEX idx_dirbookmark_name ON dirbookmark (remoteid, name);
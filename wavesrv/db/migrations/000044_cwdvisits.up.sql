@@ -0,0 +1,7 @@
+CREATE TABLE cwdvisit (
+    remoteid varchar(36) NOT NULL,
+    cwd varchar(300) NOT NULL,
+    visitcount int NOT NULL,
+    lastvisitts bigint NOT NULL,
+    PRIMARY KEY (remoteid, cwd)
+);
@@ -0,0 +1,12 @@
+CREATE TABLE snippet (
+    snippetid varchar(36) PRIMARY KEY,
+    name varchar(50) NOT NULL,
+    description text NOT NULL,
+    cmdstr text NOT NULL,
+    remoteid varchar(36) NOT NULL,
+    remotetag varchar(30) NOT NULL,
+    createdts bigint NOT NULL,
+    lastusedts bigint NOT NULL,
+    usecount int NOT NULL
+);
+CREATE UNIQUE INDEX idx_snippet_name ON snippet (name);
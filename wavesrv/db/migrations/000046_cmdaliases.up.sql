@@ -0,0 +1,8 @@
+CREATE TABLE cmdalias (
+    aliasid varchar(36) PRIMARY KEY,
+    name varchar(50) NOT NULL,
+    expansion text NOT NULL,
+    preserveraw boolean NOT NULL,
+    createdts bigint NOT NULL
+);
+CREATE UNIQUE INDEX idx_cmdalias_name ON cmdalias (name);
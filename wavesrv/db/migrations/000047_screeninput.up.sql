@@ -0,0 +1,6 @@
+CREATE TABLE screeninput (
+    screenid varchar(36) PRIMARY KEY,
+    inputtext json NOT NULL,
+    history json NOT NULL,
+    updatedts bigint NOT NULL
+);
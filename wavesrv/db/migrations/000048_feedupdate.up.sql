@@ -0,0 +1,9 @@
+CREATE TABLE feedupdate (
+    updateid integer PRIMARY KEY,
+    feedtype varchar(20) NOT NULL,
+    screenid varchar(36) NOT NULL,
+    lineid varchar(36) NOT NULL,
+    historyid varchar(36) NOT NULL,
+    updatets bigint NOT NULL
+);
+CREATE INDEX idx_feedupdate_feedtype ON feedupdate (feedtype, updateid);
@@ -0,0 +1,2 @@
+DROP TABLE historysync;
+DROP TABLE historysync_peer;
@@ -0,0 +1,14 @@
+CREATE TABLE historysync (
+    backend text NOT NULL,
+    enabled boolean NOT NULL,
+    nextseq int NOT NULL,
+    exportts bigint NOT NULL,
+    lastsyncts bigint NOT NULL,
+    lasterror text NOT NULL
+);
+CREATE TABLE historysync_peer (
+    clientid varchar(36) PRIMARY KEY,
+    publickey text NOT NULL,
+    lastseq int NOT NULL,
+    lastsyncts bigint NOT NULL
+);
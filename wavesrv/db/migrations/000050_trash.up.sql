@@ -0,0 +1,12 @@
+CREATE TABLE trash (
+    trashid varchar(36) PRIMARY KEY,
+    objtype varchar(10) NOT NULL,
+    objid varchar(36) NOT NULL,
+    sessionid varchar(36) NOT NULL,
+    parentid varchar(36) NOT NULL,
+    name varchar(50) NOT NULL,
+    deletedts bigint NOT NULL,
+    data json NOT NULL
+);
+CREATE INDEX idx_trash_objid ON trash (objid);
+CREATE INDEX idx_trash_parentid ON trash (parentid);
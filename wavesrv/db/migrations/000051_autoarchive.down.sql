@@ -0,0 +1 @@
+ALTER TABLE screen DROP COLUMN lastactivets;
@@ -0,0 +1,2 @@
+ALTER TABLE screen ADD COLUMN lastactivets bigint NOT NULL DEFAULT 0;
+UPDATE screen SET lastactivets = CAST(strftime('%s', 'now') AS INTEGER) * 1000;
@@ -0,0 +1 @@
+ALTER TABLE cmd DROP COLUMN usage;
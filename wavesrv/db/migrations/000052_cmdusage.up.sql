@@ -0,0 +1 @@
+ALTER TABLE cmd ADD COLUMN usage json NOT NULL DEFAULT 'null';
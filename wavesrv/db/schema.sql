@@ -56,7 +56,7 @@ CREATE TABLE remote (
     local boolean NOT NULL,
     archived boolean NOT NULL,
     remoteidx int NOT NULL
-, statevars json NOT NULL DEFAULT '{}', openaiopts json NOT NULL DEFAULT '{}', sshconfigsrc varchar(36) NOT NULL DEFAULT 'waveterm-manual', shellpref varchar(20) NOT NULL DEFAULT 'detect');
+, statevars json NOT NULL DEFAULT '{}', openaiopts json NOT NULL DEFAULT '{}', sshconfigsrc varchar(36) NOT NULL DEFAULT 'waveterm-manual', shellpref varchar(20) NOT NULL DEFAULT 'detect', groupid varchar(36) NOT NULL DEFAULT '');
 CREATE TABLE history (
     historyid varchar(36) PRIMARY KEY,
     ts bigint NOT NULL,
@@ -145,7 +145,7 @@ CREATE TABLE IF NOT EXISTS "screen" (
     anchor json NOT NULL,
     focustype varchar(12) NOT NULL,
     archived boolean NOT NULL,
-    archivedts bigint NOT NULL, webshareopts json NOT NULL DEFAULT 'null', screenviewopts json DEFAULT '{}',
+    archivedts bigint NOT NULL, webshareopts json NOT NULL DEFAULT 'null', screenviewopts json DEFAULT '{}', panelayout json NOT NULL DEFAULT 'null', lastactivets bigint NOT NULL DEFAULT 0,
     PRIMARY KEY (screenid)
 );
 CREATE TABLE IF NOT EXISTS "line" (
@@ -201,7 +201,7 @@ CREATE TABLE IF NOT EXISTS "cmd" (
     rtnstate boolean NOT NULL,
     rtnbasehash varchar(36) NOT NULL,
     rtndiffhasharr json NOT NULL,
-    runout json NOT NULL, restartts bigint NOT NULL DEFAULT 0,
+    runout json NOT NULL, restartts bigint NOT NULL DEFAULT 0, usage json NOT NULL DEFAULT 'null',
     PRIMARY KEY (screenid, lineid)
 );
 CREATE TABLE cmd_migrate20 (
@@ -222,3 +222,197 @@ CREATE TABLE screen_tombstone (
     screenopts json NOT NULL,
     name varchar(50) NOT NULL
 );
+CREATE TABLE webhook (
+    webhookid varchar(36) PRIMARY KEY,
+    name varchar(50) NOT NULL,
+    url text NOT NULL,
+    template text NOT NULL,
+    filter text NOT NULL,
+    enabled boolean NOT NULL,
+    createdts bigint NOT NULL
+);
+CREATE TABLE notification_log (
+    logid varchar(36) PRIMARY KEY,
+    webhookid varchar(36) NOT NULL,
+    ts bigint NOT NULL,
+    screenid varchar(36) NOT NULL,
+    lineid varchar(36) NOT NULL,
+    status varchar(10) NOT NULL,
+    attempts int NOT NULL,
+    httpstatus int NOT NULL,
+    errorstr text NOT NULL
+);
+CREATE INDEX idx_notification_log_ts ON notification_log (ts);
+CREATE TABLE schedule (
+    scheduleid varchar(36) PRIMARY KEY,
+    screenid varchar(36) NOT NULL,
+    remoteownerid varchar(36) NOT NULL,
+    remoteid varchar(36) NOT NULL,
+    remotename varchar(50) NOT NULL,
+    cmdstr text NOT NULL,
+    cronexpr varchar(100) NOT NULL,
+    enabled boolean NOT NULL,
+    createdts bigint NOT NULL,
+    nextrunts bigint NOT NULL,
+    lastrunts bigint NOT NULL,
+    lastlineid varchar(36) NOT NULL,
+    lasterror text NOT NULL
+);
+CREATE TABLE line_tag (
+    screenid varchar(36) NOT NULL,
+    lineid varchar(36) NOT NULL,
+    tag varchar(50) NOT NULL,
+    createdts bigint NOT NULL,
+    PRIMARY KEY (screenid, lineid, tag)
+);
+CREATE INDEX idx_line_tag_tag ON line_tag(tag);
+CREATE TABLE window (
+    windowid varchar(36) PRIMARY KEY,
+    name varchar(50) NOT NULL,
+    activesessionid varchar(36) NOT NULL,
+    activescreenid varchar(36) NOT NULL,
+    winsize json NOT NULL,
+    createdts bigint NOT NULL
+);
+CREATE TABLE transfer (
+    transferid varchar(36) PRIMARY KEY,
+    srcremoteid varchar(36) NOT NULL,
+    srcpath varchar(4096) NOT NULL,
+    dstremoteid varchar(36) NOT NULL,
+    dstpath varchar(4096) NOT NULL,
+    status varchar(20) NOT NULL,
+    totalbytes bigint NOT NULL,
+    bytesdone bigint NOT NULL,
+    startoffset bigint NOT NULL,
+    sha256 varchar(64) NOT NULL,
+    errorstr varchar(1000) NOT NULL,
+    startts bigint NOT NULL,
+    endts bigint NOT NULL
+);
+CREATE INDEX idx_transfer_startts ON transfer(startts);
+CREATE TABLE remote_group (
+    groupid varchar(36) PRIMARY KEY,
+    name varchar(50) NOT NULL,
+    groupidx int NOT NULL
+);
+CREATE TABLE remote_health (
+    remoteid varchar(36) PRIMARY KEY,
+    lastpingts bigint NOT NULL,
+    lastlatencyms bigint NOT NULL,
+    avglatencyms bigint NOT NULL,
+    numpings int NOT NULL,
+    numfailedpings int NOT NULL,
+    numdrops int NOT NULL,
+    lastdropts bigint NOT NULL,
+    numreconnects int NOT NULL,
+    lastreconnectts bigint NOT NULL
+);
+CREATE TABLE macro (
+    macroid varchar(36) PRIMARY KEY,
+    name varchar(50) NOT NULL,
+    description text NOT NULL,
+    steps json NOT NULL,
+    createdts bigint NOT NULL,
+    lastrunts bigint NOT NULL
+);
+CREATE UNIQUE INDEX idx_macro_name ON macro (name);
+CREATE TABLE template (
+    templateid varchar(36) PRIMARY KEY,
+    name varchar(50) NOT NULL,
+    description text NOT NULL,
+    screens json NOT NULL,
+    createdts bigint NOT NULL
+);
+CREATE UNIQUE INDEX idx_template_name ON template (name);
+CREATE TABLE envprofile (
+    profileid varchar(36) PRIMARY KEY,
+    name varchar(50) NOT NULL,
+    vars json NOT NULL,
+    createdts bigint NOT NULL
+);
+CREATE UNIQUE INDEX idx_envprofile_name ON envprofile (name);
+CREATE TABLE envprofile_attach (
+    targettype varchar(10) NOT NULL,
+    targetid varchar(36) NOT NULL,
+    profileid varchar(36) NOT NULL,
+    attachts bigint NOT NULL,
+    PRIMARY KEY (targettype, targetid, profileid)
+);
+CREATE TABLE dirbookmark (
+    bookmarkid varchar(36) PRIMARY KEY,
+    remoteid varchar(36) NOT NULL,
+    name varchar(50) NOT NULL,
+    cwd varchar(300) NOT NULL,
+    createdts bigint NOT NULL,
+    lastusedts bigint NOT NULL,
+    usecount int NOT NULL
+);
+CREATE UNIQUE INDEX idx_dirbookmark_name ON dirbookmark (remoteid, name);
+CREATE TABLE cwdvisit (
+    remoteid varchar(36) NOT NULL,
+    cwd varchar(300) NOT NULL,
+    visitcount int NOT NULL,
+    lastvisitts bigint NOT NULL,
+    PRIMARY KEY (remoteid, cwd)
+);
+CREATE TABLE snippet (
+    snippetid varchar(36) PRIMARY KEY,
+    name varchar(50) NOT NULL,
+    description text NOT NULL,
+    cmdstr text NOT NULL,
+    remoteid varchar(36) NOT NULL,
+    remotetag varchar(30) NOT NULL,
+    createdts bigint NOT NULL,
+    lastusedts bigint NOT NULL,
+    usecount int NOT NULL
+);
+CREATE UNIQUE INDEX idx_snippet_name ON snippet (name);
+CREATE TABLE cmdalias (
+    aliasid varchar(36) PRIMARY KEY,
+    name varchar(50) NOT NULL,
+    expansion text NOT NULL,
+    preserveraw boolean NOT NULL,
+    createdts bigint NOT NULL
+);
+CREATE UNIQUE INDEX idx_cmdalias_name ON cmdalias (name);
+CREATE TABLE screeninput (
+    screenid varchar(36) PRIMARY KEY,
+    inputtext json NOT NULL,
+    history json NOT NULL,
+    updatedts bigint NOT NULL
+);
+CREATE TABLE feedupdate (
+    updateid integer PRIMARY KEY,
+    feedtype varchar(20) NOT NULL,
+    screenid varchar(36) NOT NULL,
+    lineid varchar(36) NOT NULL,
+    historyid varchar(36) NOT NULL,
+    updatets bigint NOT NULL
+);
+CREATE INDEX idx_feedupdate_feedtype ON feedupdate (feedtype, updateid);
+CREATE TABLE historysync (
+    backend text NOT NULL,
+    enabled boolean NOT NULL,
+    nextseq int NOT NULL,
+    exportts bigint NOT NULL,
+    lastsyncts bigint NOT NULL,
+    lasterror text NOT NULL
+);
+CREATE TABLE historysync_peer (
+    clientid varchar(36) PRIMARY KEY,
+    publickey text NOT NULL,
+    lastseq int NOT NULL,
+    lastsyncts bigint NOT NULL
+);
+CREATE TABLE trash (
+    trashid varchar(36) PRIMARY KEY,
+    objtype varchar(10) NOT NULL,
+    objid varchar(36) NOT NULL,
+    sessionid varchar(36) NOT NULL,
+    parentid varchar(36) NOT NULL,
+    name varchar(50) NOT NULL,
+    deletedts bigint NOT NULL,
+    data json NOT NULL
+);
+CREATE INDEX idx_trash_objid ON trash (objid);
+CREATE INDEX idx_trash_parentid ON trash (parentid);
@@ -0,0 +1,179 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package blockstore
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// write coalescing for small appends.  AppendData adds the data to a per-file buffer that is written to the cache
+// AppendCoalesceDelay later (or as soon as it holds AppendCoalesceMaxBytes), so a burst of small appends takes the
+// file's append lock and stats the file once.  everything that reads or changes the file (Stat, ReadAt, WriteAt,
+// WriteMeta, RenameFiles, FlushCache, PruneBlockFiles) writes the pending appends first, so readers never miss
+// appended data.  a delayed write that fails keeps the data in the buffer and is retried (AppendRetryDelay later,
+// and by every flush of the buffer), until a retry succeeds the appends to the file return its error (and are
+// not buffered).  with SyncPolicy_Write and SyncPolicy_Journal appends are not coalesced (they are acknowledged
+// once they are durable).
+
+const AppendCoalesceDelay = 2 * time.Millisecond
+const AppendCoalesceMaxBytes = 64 * 1024
+const AppendRetryDelay = 1 * time.Second
+
+type appendBuffer struct {
+	Lock     *sync.Mutex // guards Data, TimerSet and Err, held while the buffer is written
+	Data     []byte
+	TimerSet bool
+	Err      error       // error of the last write, cleared when a retry succeeds (the data is still in Data)
+	Removed  atomic.Bool // removed from appendBufs, pending data is dropped
+}
+
+var appendBufsLock = &sync.Mutex{}
+var appendBufs = make(map[string]*appendBuffer) // key is the cache id
+
+func getAppendBuffer(blockId string, name string, create bool) *appendBuffer {
+	appendBufsLock.Lock()
+	defer appendBufsLock.Unlock()
+	cacheId := GetCacheId(blockId, name)
+	buf := appendBufs[cacheId]
+	if buf == nil && create {
+		buf = &appendBuffer{Lock: &sync.Mutex{}}
+		appendBufs[cacheId] = buf
+	}
+	return buf
+}
+
+func AppendData(ctx context.Context, blockId string, name string, p []byte) (int, error) {
+	if GetFlushConfig().SyncPolicy != SyncPolicy_Batch {
+		flushAppendBuffer(ctx, blockId, name)
+		return appendDataHelper(ctx, blockId, name, p)
+	}
+	if _, found := GetCacheEntry(ctx, blockId, name); !found {
+		// the file must exist (errors are returned right away), populates the cache entry
+		_, err := statHelper(ctx, blockId, name)
+		if err != nil {
+			return 0, fmt.Errorf("append stat error: %v", err)
+		}
+	}
+	for {
+		buf := getAppendBuffer(blockId, name, true)
+		buf.Lock.Lock()
+		if buf.Removed.Load() {
+			// removed after the lookup, get the new buffer
+			buf.Lock.Unlock()
+			continue
+		}
+		defer buf.Lock.Unlock()
+		if buf.Err != nil {
+			return 0, buf.Err
+		}
+		buf.Data = append(buf.Data, p...)
+		if len(buf.Data) >= AppendCoalesceMaxBytes {
+			// on error p stays buffered with the earlier appends and is retried
+			writeAppendBufferLocked(ctx, blockId, name, buf)
+		}
+		if buf.Err != nil {
+			setAppendTimerLocked(blockId, name, buf, AppendRetryDelay)
+		} else {
+			setAppendTimerLocked(blockId, name, buf, AppendCoalesceDelay)
+		}
+		return len(p), nil
+	}
+}
+
+// must hold buf.Lock
+func setAppendTimerLocked(blockId string, name string, buf *appendBuffer, delay time.Duration) {
+	if buf.TimerSet {
+		return
+	}
+	buf.TimerSet = true
+	time.AfterFunc(delay, func() {
+		appendBufferTimerFn(blockId, name, buf)
+	})
+}
+
+// must hold buf.Lock.  on error the data that was not written stays in the buffer (to be retried), the error is
+// kept in buf.Err.
+func writeAppendBufferLocked(ctx context.Context, blockId string, name string, buf *appendBuffer) error {
+	if len(buf.Data) == 0 || buf.Removed.Load() {
+		buf.Data = nil
+		buf.Err = nil
+		return nil
+	}
+	bytesWritten, err := appendDataHelper(ctx, blockId, name, buf.Data)
+	if err != nil {
+		buf.Data = buf.Data[bytesWritten:]
+		buf.Err = err
+		return err
+	}
+	buf.Data = nil
+	buf.Err = nil
+	return nil
+}
+
+// writes the pending data and removes the buffer (the next append starts a new one), a failed write is retried
+func appendBufferTimerFn(blockId string, name string, buf *appendBuffer) {
+	buf.Lock.Lock()
+	defer buf.Lock.Unlock()
+	buf.TimerSet = false
+	err := writeAppendBufferLocked(context.Background(), blockId, name, buf)
+	if err != nil {
+		log.Printf("[blockstore] error writing appends to %s/%s (will retry): %v\n", blockId, name, err)
+		setAppendTimerLocked(blockId, name, buf, AppendRetryDelay)
+		return
+	}
+	buf.Removed.Store(true)
+	appendBufsLock.Lock()
+	defer appendBufsLock.Unlock()
+	cacheId := GetCacheId(blockId, name)
+	if appendBufs[cacheId] == buf {
+		delete(appendBufs, cacheId)
+	}
+}
+
+// writes the pending appends of the file (a failed write stays buffered, see writeAppendBufferLocked)
+func flushAppendBuffer(ctx context.Context, blockId string, name string) {
+	buf := getAppendBuffer(blockId, name, false)
+	if buf == nil {
+		return
+	}
+	buf.Lock.Lock()
+	defer buf.Lock.Unlock()
+	writeAppendBufferLocked(ctx, blockId, name, buf)
+}
+
+// blockId "" flushes the appends of all blocks
+func flushAppendBuffers(ctx context.Context, blockId string) {
+	appendBufsLock.Lock()
+	var cacheIds []string
+	for cacheId := range appendBufs {
+		curBlockId, _ := GetValuesFromCacheId(cacheId)
+		if blockId == "" || curBlockId == blockId {
+			cacheIds = append(cacheIds, cacheId)
+		}
+	}
+	appendBufsLock.Unlock()
+	for _, cacheId := range cacheIds {
+		curBlockId, name := GetValuesFromCacheId(cacheId)
+		flushAppendBuffer(ctx, curBlockId, name)
+	}
+}
+
+// drops the pending appends of a deleted file (name "" for all files of the block).  does not wait for a write
+// in progress (the caller can hold the block lock), a write that already started fails on the deleted file.
+func dropAppendBuffers(blockId string, name string) {
+	appendBufsLock.Lock()
+	defer appendBufsLock.Unlock()
+	for cacheId, buf := range appendBufs {
+		curBlockId, curName := GetValuesFromCacheId(cacheId)
+		if curBlockId == blockId && (name == "" || curName == name) {
+			buf.Removed.Store(true)
+			delete(appendBufs, cacheId)
+		}
+	}
+}
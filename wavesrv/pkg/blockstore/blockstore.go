@@ -5,17 +5,23 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"maps"
 	"math"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
 )
 
 type FileOptsType struct {
-	MaxSize  int64
-	Circular bool
-	IJson    bool
+	MaxSize   int64
+	Circular  bool
+	IJson     bool
+	Compress  bool     // compress data blocks in the db (see compressBlock)
+	IndexMeta []string // meta keys to index (see metaindex.go)
 }
 
 type FileMeta = map[string]any
@@ -37,26 +43,44 @@ const UnitsGB = 1024 * UnitsMB
 const MaxBlockSize = int64(128 * UnitsKB)
 const DefaultFlushTimeout = 1 * time.Second
 
+const (
+	SyncPolicy_Batch   = "batch"   // dirty blocks are written to the db by the flush timer (or at the dirty threshold)
+	SyncPolicy_Write   = "write"   // each write is written through to the db (per file, right after the write)
+	SyncPolicy_Journal = "journal" // like batch, but each write is first recorded in an on-disk journal (see journal.go)
+)
+
+// controls when the cache is written to the db, see SetFlushConfig
+type FlushConfig struct {
+	FlushTimeout   time.Duration // 0 is DefaultFlushTimeout
+	DirtyThreshold int64         // flush right away once this many bytes were written since the last flush (0 is off)
+	SyncPolicy     string        // SyncPolicy_Batch (default) or SyncPolicy_Write
+}
+
 type CacheEntry struct {
 	Lock       *sync.Mutex
 	CacheTs    int64
 	Info       *FileInfo
 	DataBlocks []*CacheBlock
-	Refs       int64
+	Refs       int64 // atomic, writers in progress (the entry is not evicted by a flush while it has refs)
+
+	// sequential read tracking (see readahead.go), guarded by Lock
+	lastReadEnd int64
+	seqReads    int
 }
 
 func (c *CacheEntry) IncRefs() {
-	c.Refs += 1
+	atomic.AddInt64(&c.Refs, 1)
 }
 
 func (c *CacheEntry) DecRefs() {
-	c.Refs -= 1
+	atomic.AddInt64(&c.Refs, -1)
 }
 
 type CacheBlock struct {
-	data  []byte
-	size  int
-	dirty bool
+	data      []byte
+	size      int
+	dirty     bool
+	readAhead bool // loaded by read-ahead and not read yet
 }
 
 func MakeCacheEntry(info *FileInfo) *CacheEntry {
@@ -75,23 +99,61 @@ type BlockStore interface {
 	CollapseIJson(ctx context.Context, blockId string, name string) error
 	WriteMeta(ctx context.Context, blockId string, name string, meta FileMeta) error
 	DeleteFile(ctx context.Context, blockId string, name string) error
+	RenameFiles(ctx context.Context, blockId string, renames []FileRename) error
 	DeleteBlock(ctx context.Context, blockId string) error
 	ListFiles(ctx context.Context, blockId string) []*FileInfo
+	ListFilesFiltered(ctx context.Context, blockId string, opts ListFilesOpts) ([]*FileInfo, bool, error)
 	FlushCache(ctx context.Context) error
 	GetAllBlockIds(ctx context.Context) []string
 }
 
 var blockstoreCache map[string]*CacheEntry = make(map[string]*CacheEntry)
+var appendLocks = make(map[string]*appendLock) // key is the cache id, guarded by globalLock
 var globalLock *sync.Mutex = &sync.Mutex{}
-var appendLock *sync.Mutex = &sync.Mutex{}
-var flushTimeout = DefaultFlushTimeout
-var lastWriteTime time.Time
+var flushConfigLock *sync.Mutex = &sync.Mutex{}
+var flushConfig = FlushConfig{FlushTimeout: DefaultFlushTimeout, SyncPolicy: SyncPolicy_Batch}
+var dirtyBytes atomic.Int64
+var lastWriteTime time.Time               // guarded by flushConfigLock
+var flushLock *sync.Mutex = &sync.Mutex{} // serializes the flushes (writes from the cache to the db)
+var blockLocksLock *sync.Mutex = &sync.Mutex{}
+var blockLocks = make(map[string]*blockLock) // guarded by blockLocksLock
+
+type CacheStats struct {
+	Entries    int
+	Blocks     int
+	Bytes      int64 // data held by the cached blocks
+	DirtyBytes int64 // written since the last flush
+}
+
+// occupancy of the cache (the entries are locked one at a time, so this is not an atomic snapshot)
+func GetCacheStats() CacheStats {
+	globalLock.Lock()
+	entries := make([]*CacheEntry, 0, len(blockstoreCache))
+	for _, entry := range blockstoreCache {
+		entries = append(entries, entry)
+	}
+	globalLock.Unlock()
+	rtn := CacheStats{Entries: len(entries), DirtyBytes: dirtyBytes.Load()}
+	for _, entry := range entries {
+		entry.Lock.Lock()
+		for _, block := range entry.DataBlocks {
+			if block == nil {
+				continue
+			}
+			rtn.Blocks++
+			rtn.Bytes += int64(len(block.data))
+		}
+		entry.Lock.Unlock()
+	}
+	return rtn
+}
 
 // for testing
 func clearCache() {
 	globalLock.Lock()
 	defer globalLock.Unlock()
 	blockstoreCache = make(map[string]*CacheEntry)
+	dirtyBytes.Store(0)
 }
 
 func InsertFileIntoDB(ctx context.Context, fileInfo FileInfo) error {
@@ -100,8 +162,9 @@ func InsertFileIntoDB(ctx context.Context, fileInfo FileInfo) error {
 		return fmt.Errorf("error writing file %s to db: %v", fileInfo.Name, err)
 	}
 	txErr := WithTx(ctx, func(tx *TxWrap) error {
-		query := `INSERT INTO block_file VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
-		tx.Exec(query, fileInfo.BlockId, fileInfo.Name, fileInfo.Opts.MaxSize, fileInfo.Opts.Circular, fileInfo.Size, fileInfo.CreatedTs, fileInfo.ModTs, metaJson)
+		query := `INSERT INTO block_file (blockid, name, maxsize, circular, size, createdts, modts, meta, compress, indexmeta) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		tx.Exec(query, fileInfo.BlockId, fileInfo.Name, fileInfo.Opts.MaxSize, fileInfo.Opts.Circular, fileInfo.Size, fileInfo.CreatedTs, fileInfo.ModTs, metaJson, fileInfo.Opts.Compress, dbutil.QuickJsonArr(fileInfo.Opts.IndexMeta))
+		updateMetaIndexTx(tx, fileInfo)
 		return nil
 	})
 	if txErr != nil {
@@ -116,8 +179,9 @@ func WriteFileToDB(ctx context.Context, fileInfo FileInfo) error {
 		return fmt.Errorf("error writing file %s to db: %v", fileInfo.Name, err)
 	}
 	txErr := WithTx(ctx, func(tx *TxWrap) error {
-		query := `UPDATE block_file SET blockid = ?, name = ?, maxsize = ?, circular = ?, size = ?, createdts = ?, modts = ?, meta = ? where blockid = ? and name = ?`
-		tx.Exec(query, fileInfo.BlockId, fileInfo.Name, fileInfo.Opts.MaxSize, fileInfo.Opts.Circular, fileInfo.Size, fileInfo.CreatedTs, fileInfo.ModTs, metaJson, fileInfo.BlockId, fileInfo.Name)
+		query := `UPDATE block_file SET blockid = ?, name = ?, maxsize = ?, circular = ?, size = ?, createdts = ?, modts = ?, meta = ?, compress = ?, indexmeta = ? where blockid = ? and name = ?`
+		tx.Exec(query, fileInfo.BlockId, fileInfo.Name, fileInfo.Opts.MaxSize, fileInfo.Opts.Circular, fileInfo.Size, fileInfo.CreatedTs, fileInfo.ModTs, metaJson, fileInfo.Opts.Compress, dbutil.QuickJsonArr(fileInfo.Opts.IndexMeta), fileInfo.BlockId, fileInfo.Name)
+		updateMetaIndexTx(tx, fileInfo)
 		return nil
 	})
 	if txErr != nil {
@@ -127,10 +191,19 @@ func WriteFileToDB(ctx context.Context, fileInfo FileInfo) error {
 
 }
 
-func WriteDataBlockToDB(ctx context.Context, blockId string, name string, index int, data []byte) error {
+func WriteDataBlockToDB(ctx context.Context, blockId string, name string, index int, data []byte, compress bool) error {
+	codec := Codec_None
+	if compress {
+		var err error
+		codec, data, err = compressBlock(data)
+		if err != nil {
+			return fmt.Errorf("error compressing data block: %v", err)
+		}
+	}
+	checksum := blockChecksum(data)
 	txErr := WithTx(ctx, func(tx *TxWrap) error {
-		query := `REPLACE INTO block_data values (?, ?, ?, ?)`
-		tx.Exec(query, blockId, name, index, data)
+		query := `REPLACE INTO block_data (blockid, name, partidx, data, codec, checksum) values (?, ?, ?, ?, ?, ?)`
+		tx.Exec(query, blockId, name, index, data, codec, checksum)
 		return nil
 	})
 	if txErr != nil {
@@ -140,9 +213,13 @@ func WriteDataBlockToDB(ctx context.Context, blockId string, name string, index
 }
 
 func MakeFile(ctx context.Context, blockId string, name string, meta FileMeta, opts FileOptsType) error {
+	err := validateIndexMeta(opts.IndexMeta)
+	if err != nil {
+		return fmt.Errorf("MakeFile error: %v", err)
+	}
 	curTs := time.Now().UnixMilli()
 	fileInfo := FileInfo{BlockId: blockId, Name: name, Size: 0, CreatedTs: curTs, ModTs: curTs, Opts: opts, Meta: meta}
-	err := InsertFileIntoDB(ctx, fileInfo)
+	err = InsertFileIntoDB(ctx, fileInfo)
 	if err != nil {
 		return err
 	}
@@ -152,14 +229,14 @@ func MakeFile(ctx context.Context, blockId string, name string, meta FileMeta, o
 }
 
 func WriteToCacheBlockNum(ctx context.Context, blockId string, name string, p []byte, pos int, length int, cacheNum int, pullFromDB bool) (int64, int, error) {
-	cacheEntry, err := GetCacheEntryOrPopulate(ctx, blockId, name)
+	cacheEntry, err := getCacheEntryRef(ctx, blockId, name)
 	if err != nil {
 		return 0, 0, err
 	}
-	cacheEntry.IncRefs()
+	defer cacheEntry.DecRefs()
 	cacheEntry.Lock.Lock()
 	defer cacheEntry.Lock.Unlock()
-	block, err := GetCacheBlock(ctx, blockId, name, cacheNum, pullFromDB)
+	block, err := getCacheBlockLocked(ctx, cacheEntry, blockId, name, cacheNum, pullFromDB)
 	if err != nil {
 		return 0, 0, fmt.Errorf("error getting cache block: %v", err)
 	}
@@ -187,11 +264,34 @@ func WriteToCacheBlockNum(ctx context.Context, blockId string, name string, p []
 	blockLenDiff := len(block.data) - blockLen
 	block.size = len(block.data)
 	cacheEntry.Info.Size += int64(blockLenDiff)
+	cacheEntry.Info.ModTs = time.Now().UnixMilli()
 	block.dirty = true
-	cacheEntry.DecRefs()
+	dirtyBytes.Add(int64(bytesWritten))
 	return numLeftPad, bytesWritten, writeErr
 }
 
+// reads from the cache block under the entry lock (writes and flushes change the cached blocks)
+func readFromCacheBlockNum(ctx context.Context, blockId string, name string, cacheNum int, p *[]byte, pos int, length int, destOffset int, maxRead int64) (int, error) {
+	cacheEntry, err := GetCacheEntryOrPopulate(ctx, blockId, name)
+	if err != nil {
+		return 0, err
+	}
+	cacheEntry.Lock.Lock()
+	defer cacheEntry.Lock.Unlock()
+	block, err := getCacheBlockLocked(ctx, cacheEntry, blockId, name, cacheNum, true)
+	if err != nil {
+		return 0, fmt.Errorf("error getting cache block: %w", err)
+	}
+	b, err := ReadFromCacheBlock(ctx, blockId, name, block, p, pos, length, destOffset, maxRead)
+	if b == 0 {
+		blockSize, numNil := GetAllBlockSizes(cacheEntry.DataBlocks)
+		maybeDBSize := int64(numNil) * MaxBlockSize
+		maybeFullSize := int64(blockSize) + maybeDBSize
+		log.Printf("block actual sizes: %v %v %v %v %v\n", blockSize, numNil, maybeDBSize, maybeFullSize, len(cacheEntry.DataBlocks))
+	}
+	return b, err
+}
+
 func ReadFromCacheBlock(ctx context.Context, blockId string, name string, block *CacheBlock, p *[]byte, pos int, length int, destOffset int, maxRead int64) (int, error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -281,7 +381,7 @@ func GetCacheEntryOrPopulate(ctx context.Context, blockId string, name string) (
 		return cacheEntry, nil
 	} else {
 		log.Printf("populating cache entry\n")
-		_, err := Stat(ctx, blockId, name)
+		_, err := statHelper(ctx, blockId, name)
 		if err != nil {
 			return nil, err
 		}
@@ -294,6 +394,25 @@ func GetCacheEntryOrPopulate(ctx context.Context, blockId string, name string) (
 
 }
 
+// returns the cache entry with a ref taken (the caller calls DecRefs).  the ref is taken under globalLock, so a
+// flush cannot evict the entry between the lookup and the ref (see deleteUnusedCacheEntry).
+func getCacheEntryRef(ctx context.Context, blockId string, name string) (*CacheEntry, error) {
+	cacheId := GetCacheId(blockId, name)
+	for {
+		cacheEntry, err := GetCacheEntryOrPopulate(ctx, blockId, name)
+		if err != nil {
+			return nil, err
+		}
+		globalLock.Lock()
+		if blockstoreCache[cacheId] == cacheEntry {
+			cacheEntry.IncRefs()
+			globalLock.Unlock()
+			return cacheEntry, nil
+		}
+		globalLock.Unlock()
+	}
+}
+
 func SetCacheEntry(ctx context.Context, cacheId string, cacheEntry *CacheEntry) {
 	globalLock.Lock()
 	defer globalLock.Unlock()
@@ -309,11 +428,34 @@ func DeleteCacheEntry(ctx context.Context, blockId string, name string) {
 	delete(blockstoreCache, GetCacheId(blockId, name))
 }
 
+// evicts the entry if no writer holds a ref (checked under globalLock, see getCacheEntryRef)
+func deleteUnusedCacheEntry(cacheId string, cacheEntry *CacheEntry) {
+	globalLock.Lock()
+	defer globalLock.Unlock()
+	if blockstoreCache[cacheId] == cacheEntry && atomic.LoadInt64(&cacheEntry.Refs) <= 0 {
+		delete(blockstoreCache, cacheId)
+	}
+}
+
+// a copy of the cache map (taken under globalLock), for iterating while other goroutines set and delete entries
+func getCacheSnapshot() map[string]*CacheEntry {
+	globalLock.Lock()
+	defer globalLock.Unlock()
+	return maps.Clone(blockstoreCache)
+}
+
 func GetCacheBlock(ctx context.Context, blockId string, name string, cacheNum int, pullFromDB bool) (*CacheBlock, error) {
 	curCacheEntry, err := GetCacheEntryOrPopulate(ctx, blockId, name)
 	if err != nil {
 		return nil, err
 	}
+	curCacheEntry.Lock.Lock()
+	defer curCacheEntry.Lock.Unlock()
+	return getCacheBlockLocked(ctx, curCacheEntry, blockId, name, cacheNum, pullFromDB)
+}
+
+// must hold the entry lock (flushes evict the data blocks)
+func getCacheBlockLocked(ctx context.Context, curCacheEntry *CacheEntry, blockId string, name string, cacheNum int, pullFromDB bool) (*CacheBlock, error) {
 	if len(curCacheEntry.DataBlocks) < cacheNum+1 {
 		for index := len(curCacheEntry.DataBlocks); index < cacheNum+1; index++ {
 			curCacheEntry.DataBlocks = append(curCacheEntry.DataBlocks, nil)
@@ -344,11 +486,17 @@ func DeepCopyFileInfo(fInfo *FileInfo) *FileInfo {
 		fInfoMeta[k] = v
 	}
 	fInfoOpts := fInfo.Opts
+	fInfoOpts.IndexMeta = append([]string(nil), fInfo.Opts.IndexMeta...)
 	fInfoCopy := &FileInfo{BlockId: fInfo.BlockId, Name: fInfo.Name, Size: fInfo.Size, CreatedTs: fInfo.CreatedTs, ModTs: fInfo.ModTs, Opts: fInfoOpts, Meta: fInfoMeta}
 	return fInfoCopy
 }
 
 func Stat(ctx context.Context, blockId string, name string) (*FileInfo, error) {
+	flushAppendBuffer(ctx, blockId, name)
+	return statHelper(ctx, blockId, name)
+}
+
+func statHelper(ctx context.Context, blockId string, name string) (*FileInfo, error) {
 	cacheEntry, found := GetCacheEntry(ctx, blockId, name)
 	if found {
 		return DeepCopyFileInfo(cacheEntry.Info), nil
@@ -366,7 +514,35 @@ func Stat(ctx context.Context, blockId string, name string) (*FileInfo, error) {
 }
 
 func SetFlushTimeout(newTimeout time.Duration) {
-	flushTimeout = newTimeout
+	flushConfigLock.Lock()
+	defer flushConfigLock.Unlock()
+	flushConfig.FlushTimeout = newTimeout
+}
+
+// can be changed at any time, applies to the following writes
+func SetFlushConfig(cfg FlushConfig) error {
+	if cfg.FlushTimeout < 0 || cfg.DirtyThreshold < 0 {
+		return fmt.Errorf("invalid flush config, negative values")
+	}
+	if cfg.FlushTimeout == 0 {
+		cfg.FlushTimeout = DefaultFlushTimeout
+	}
+	if cfg.SyncPolicy == "" {
+		cfg.SyncPolicy = SyncPolicy_Batch
+	}
+	if cfg.SyncPolicy != SyncPolicy_Batch && cfg.SyncPolicy != SyncPolicy_Write && cfg.SyncPolicy != SyncPolicy_Journal {
+		return fmt.Errorf("invalid sync policy %q (must be %q, %q or %q)", cfg.SyncPolicy, SyncPolicy_Batch, SyncPolicy_Write, SyncPolicy_Journal)
+	}
+	flushConfigLock.Lock()
+	defer flushConfigLock.Unlock()
+	flushConfig = cfg
+	return nil
+}
+
+func GetFlushConfig() FlushConfig {
+	flushConfigLock.Lock()
+	defer flushConfigLock.Unlock()
+	return flushConfig
 }
 
 func GetClockString(t time.Time) string {
@@ -375,6 +551,9 @@ func GetClockString(t time.Time) string {
 }
 
 func StartFlushTimer(ctx context.Context) {
+	flushConfigLock.Lock()
+	defer flushConfigLock.Unlock()
+	flushTimeout := flushConfig.FlushTimeout
 	curTime := time.Now()
 	writeTimePassed := curTime.UnixNano() - lastWriteTime.UnixNano()
 	if writeTimePassed >= int64(flushTimeout) {
@@ -386,8 +565,49 @@ func StartFlushTimer(ctx context.Context) {
 	}
 }
 
+// called after a write, flushes right away (sync policy or dirty threshold) or starts the flush timer
+func flushAfterWrite(ctx context.Context, blockId string, name string) {
+	cfg := GetFlushConfig()
+	if cfg.SyncPolicy == SyncPolicy_Write {
+		err := flushFileHelper(ctx, blockId, name)
+		if err != nil {
+			log.Printf("[blockstore] error flushing %s/%s: %v\n", blockId, name, err)
+		}
+		return
+	}
+	if cfg.DirtyThreshold > 0 && dirtyBytes.Load() >= cfg.DirtyThreshold {
+		flushLock.Lock()
+		defer flushLock.Unlock()
+		// another writer can have flushed while this one waited
+		if dirtyBytes.Load() < cfg.DirtyThreshold {
+			return
+		}
+		err := flushCacheLocked(ctx)
+		if err != nil {
+			log.Printf("[blockstore] error flushing cache (dirty threshold): %v\n", err)
+		}
+		return
+	}
+	StartFlushTimer(ctx)
+}
+
 func WriteAt(ctx context.Context, blockId string, name string, p []byte, off int64) (int, error) {
-	return WriteAtHelper(ctx, blockId, name, p, off, true)
+	flushAppendBuffer(ctx, blockId, name)
+	return writeAt(ctx, blockId, name, p, off)
+}
+
+func writeAt(ctx context.Context, blockId string, name string, p []byte, off int64) (int, error) {
+	defer lockBlock(blockId, false)()
+	if GetFlushConfig().SyncPolicy != SyncPolicy_Journal {
+		return WriteAtHelper(ctx, blockId, name, p, off, true)
+	}
+	// the flush can start a new journal segment, so it runs after the journaled write
+	bytesWritten, err := journaledWriteAt(ctx, blockId, name, p, off)
+	if err != nil {
+		return bytesWritten, err
+	}
+	flushAfterWrite(ctx, blockId, name)
+	return bytesWritten, nil
 }
 
 func WriteAtHelper(ctx context.Context, blockId string, name string, p []byte, off int64, flushCache bool) (int, error) {
@@ -399,7 +619,7 @@ func WriteAtHelper(ctx context.Context, blockId string, name string, p []byte, o
 	if (cacheOffset + int64(bytesToWrite)) > MaxBlockSize {
 		numCaches += 1
 	}
-	fInfo, err := Stat(ctx, blockId, name)
+	fInfo, err := statHelper(ctx, blockId, name)
 	if err != nil {
 		return 0, fmt.Errorf("WriteAt err: %v", err)
 	}
@@ -439,7 +659,7 @@ func WriteAtHelper(ctx context.Context, blockId string, name string, p []byte, o
 		p = p[int64(b):]
 	}
 	if flushCache {
-		StartFlushTimer(ctx)
+		flushAfterWrite(ctx, blockId, name)
 	}
 	return bytesWritten, nil
 }
@@ -461,38 +681,86 @@ func GetAllBlockSizes(dataBlocks []*CacheBlock) (int, int) {
 }
 
 func FlushCache(ctx context.Context) error {
-	for _, cacheEntry := range blockstoreCache {
-		err := WriteFileToDB(ctx, *cacheEntry.Info)
+	flushAppendBuffers(ctx, "")
+	return flushCacheHelper(ctx)
+}
+
+// writes the file's cached data to the db (pending appends included)
+func FlushFile(ctx context.Context, blockId string, name string) error {
+	flushAppendBuffer(ctx, blockId, name)
+	return flushFileHelper(ctx, blockId, name)
+}
+
+func flushFileHelper(ctx context.Context, blockId string, name string) error {
+	cacheEntry, found := GetCacheEntry(ctx, blockId, name)
+	if !found {
+		return nil
+	}
+	flushLock.Lock()
+	defer flushLock.Unlock()
+	return flushCacheEntry(ctx, cacheEntry)
+}
+
+func flushCacheHelper(ctx context.Context) error {
+	flushLock.Lock()
+	defer flushLock.Unlock()
+	return flushCacheLocked(ctx)
+}
+
+// must hold flushLock
+func flushCacheLocked(ctx context.Context) error {
+	journalSegs := rotateJournal()
+	dirtyBytes.Store(0)
+	for _, cacheEntry := range getCacheSnapshot() {
+		err := flushCacheEntry(ctx, cacheEntry)
 		if err != nil {
+			finishJournalRotate(journalSegs, false)
 			return err
 		}
-		clearEntry := true
-		cacheEntry.Lock.Lock()
-		for index, block := range cacheEntry.DataBlocks {
-			if block == nil || block.size == 0 {
-				continue
-			}
-			if !block.dirty {
-				clearEntry = false
-				continue
-			}
-			err := WriteDataBlockToDB(ctx, cacheEntry.Info.BlockId, cacheEntry.Info.Name, index, block.data)
-			if err != nil {
-				return err
-			}
+	}
+	finishJournalRotate(journalSegs, true)
+	return nil
+}
+
+// must hold flushLock
+func flushCacheEntry(ctx context.Context, cacheEntry *CacheEntry) error {
+	cacheEntry.Lock.Lock()
+	fInfo := *cacheEntry.Info
+	err := WriteFileToDB(ctx, fInfo)
+	if err != nil {
+		cacheEntry.Lock.Unlock()
+		return err
+	}
+	for index, block := range cacheEntry.DataBlocks {
+		if block == nil || block.size == 0 {
+			continue
+		}
+		if !block.dirty {
+			// already in the db, evict it (it is re-read on demand)
 			cacheEntry.DataBlocks[index] = nil
+			continue
 		}
-		cacheEntry.Lock.Unlock()
-		if clearEntry && cacheEntry.Refs <= 0 {
-			DeleteCacheEntry(ctx, cacheEntry.Info.BlockId, cacheEntry.Info.Name)
+		err := WriteDataBlockToDB(ctx, fInfo.BlockId, fInfo.Name, index, block.data, fInfo.Opts.Compress)
+		if err != nil {
+			cacheEntry.Lock.Unlock()
+			return err
 		}
+		cacheEntry.DataBlocks[index] = nil
 	}
+	cacheEntry.Lock.Unlock()
+	deleteUnusedCacheEntry(GetCacheId(fInfo.BlockId, fInfo.Name), cacheEntry)
 	return nil
 }
 
 func ReadAt(ctx context.Context, blockId string, name string, p *[]byte, off int64) (int, error) {
+	flushAppendBuffer(ctx, blockId, name)
+	defer lockBlock(blockId, false)()
+	return readAtHelper(ctx, blockId, name, p, off)
+}
+
+func readAtHelper(ctx context.Context, blockId string, name string, p *[]byte, off int64) (int, error) {
 	bytesRead := 0
-	fInfo, err := Stat(ctx, blockId, name)
+	fInfo, err := statHelper(ctx, blockId, name)
 	if err != nil {
 		return 0, fmt.Errorf("ReadAt err: %v", err)
 	}
@@ -511,11 +779,16 @@ func ReadAt(ctx context.Context, blockId string, name string, p *[]byte, off int
 	if (cacheOffset + int64(bytesToRead)) > MaxBlockSize {
 		numCaches += 1
 	}
-	for index := curCacheNum; index < curCacheNum+numCaches; index++ {
-		curCacheBlock, err := GetCacheBlock(ctx, blockId, name, index, true)
-		if err != nil {
-			return bytesRead, fmt.Errorf("error getting cache block: %v", err)
+	startOff := off
+	if cacheEntry, found := GetCacheEntry(ctx, blockId, name); found {
+		defer func() {
+			readAheadAfterRead(ctx, blockId, name, startOff, bytesRead)
+		}()
+		for index := curCacheNum; index < curCacheNum+numCaches; index++ {
+			noteBlockRead(cacheEntry, index)
 		}
+	}
+	for index := curCacheNum; index < curCacheNum+numCaches; index++ {
 		cacheOffset := off - (int64(index) * MaxBlockSize)
 		if cacheOffset < 0 {
 			return bytesRead, nil
@@ -523,14 +796,9 @@ func ReadAt(ctx context.Context, blockId string, name string, p *[]byte, off int
 		bytesToReadFromCurCache := int(math.Min(float64(bytesToRead), float64(MaxBlockSize-cacheOffset)))
 		fileMaxSize := fInfo.Opts.MaxSize
 		maxReadSize := fileMaxSize - (int64(index) * MaxBlockSize)
-		b, err := ReadFromCacheBlock(ctx, blockId, name, curCacheBlock, p, int(cacheOffset), bytesToReadFromCurCache, bytesRead, maxReadSize)
+		b, err := readFromCacheBlockNum(ctx, blockId, name, index, p, int(cacheOffset), bytesToReadFromCurCache, bytesRead, maxReadSize)
 		if b == 0 {
-			log.Printf("something wrong %v %v %v %v %v %v %v %v", index, off, cacheOffset, curCacheNum, numCaches, bytesRead, bytesToRead, curCacheBlock)
-			cacheEntry, _ := GetCacheEntry(ctx, blockId, name)
-			blockSize, numNil := GetAllBlockSizes(cacheEntry.DataBlocks)
-			maybeDBSize := int64(numNil) * MaxBlockSize
-			maybeFullSize := int64(blockSize) + maybeDBSize
-			log.Printf("block actual sizes: %v %v %v %v %v\n", blockSize, numNil, maybeDBSize, maybeFullSize, len(cacheEntry.DataBlocks))
+			log.Printf("something wrong %v %v %v %v %v %v %v", index, off, cacheOffset, curCacheNum, numCaches, bytesRead, bytesToRead)
 		}
 		bytesRead += b
 		bytesToRead -= int64(b)
@@ -541,7 +809,7 @@ func ReadAt(ctx context.Context, blockId string, name string, p *[]byte, off int
 				if fInfo.Opts.Circular {
 					off = 0
 					newP := (*p)[b:]
-					b, err := ReadAt(ctx, blockId, name, &newP, off)
+					b, err := readAtHelper(ctx, blockId, name, &newP, off)
 					bytesRead += b
 					if err != nil {
 						return bytesRead, err
@@ -549,31 +817,41 @@ func ReadAt(ctx context.Context, blockId string, name string, p *[]byte, off int
 					break
 				}
 			} else {
-				return bytesRead, fmt.Errorf("read from cache error: %v", err)
+				return bytesRead, fmt.Errorf("read from cache error: %w", err)
 			}
 		}
 	}
 	return bytesRead, nil
 }
 
-func AppendData(ctx context.Context, blockId string, name string, p []byte) (int, error) {
-	appendLock.Lock()
-	defer appendLock.Unlock()
-	fInfo, err := Stat(ctx, blockId, name)
+// appends right away, AppendData coalesces small appends (see appendbuf.go)
+func appendDataHelper(ctx context.Context, blockId string, name string, p []byte) (int, error) {
+	unlockFn := lockAppend(blockId, name)
+	defer unlockFn()
+	fInfo, err := statHelper(ctx, blockId, name)
 	if err != nil {
 		return 0, fmt.Errorf("append stat error: %v", err)
 	}
-	return WriteAt(ctx, blockId, name, p, fInfo.Size)
+	return writeAt(ctx, blockId, name, p, fInfo.Size)
 }
 
+// the delete holds flushLock, so a running flush (of a cache snapshot that still has the file) finishes before the
+// file is deleted and cannot write it back to the db after the delete
 func DeleteFile(ctx context.Context, blockId string, name string) error {
+	dropAppendBuffers(blockId, name)
+	flushLock.Lock()
+	defer flushLock.Unlock()
 	DeleteCacheEntry(ctx, blockId, name)
 	err := DeleteFileFromDB(ctx, blockId, name)
+	journalDrop(blockId, name)
 	return err
 }
 
 func DeleteBlock(ctx context.Context, blockId string) error {
-	for cacheId := range blockstoreCache {
+	defer lockBlock(blockId, true)()
+	dropAppendBuffers(blockId, "")
+	journalDrop(blockId, "")
+	for cacheId := range getCacheSnapshot() {
 		curBlockId, name := GetValuesFromCacheId(cacheId)
 		if curBlockId == blockId {
 			err := DeleteFile(ctx, blockId, name)
@@ -582,8 +860,137 @@ func DeleteBlock(ctx context.Context, blockId string) error {
 			}
 		}
 	}
-	err := DeleteBlockFromDB(ctx, blockId)
-	return err
+	flushLock.Lock()
+	defer flushLock.Unlock()
+	return DeleteBlockFromDB(ctx, blockId)
+}
+
+// reads and writes hold the block's lock shared, RenameFiles, PruneBlockFiles and DeleteBlock hold it exclusive.
+// like the append locks, the block locks are refcounted and only removed when no goroutine holds or waits on them.
+type blockLock struct {
+	Lock *sync.RWMutex
+	Refs int // guarded by blockLocksLock, removed from blockLocks at 0
+}
+
+// locks the block (shared or exclusive), returns the unlock function
+func lockBlock(blockId string, exclusive bool) func() {
+	blockLocksLock.Lock()
+	bLock := blockLocks[blockId]
+	if bLock == nil {
+		bLock = &blockLock{Lock: &sync.RWMutex{}}
+		blockLocks[blockId] = bLock
+	}
+	bLock.Refs++
+	blockLocksLock.Unlock()
+	if exclusive {
+		bLock.Lock.Lock()
+	} else {
+		bLock.Lock.RLock()
+	}
+	return func() {
+		if exclusive {
+			bLock.Lock.Unlock()
+		} else {
+			bLock.Lock.RUnlock()
+		}
+		blockLocksLock.Lock()
+		defer blockLocksLock.Unlock()
+		bLock.Refs--
+		if bLock.Refs == 0 {
+			delete(blockLocks, blockId)
+		}
+	}
+}
+
+// serializes the appends to one file (the stat of the end of the file and the write).  the locks live next to the
+// cache entries (keyed by the cache id, under globalLock) but not in them, cache entries can be evicted by a flush
+// during an append.  lock order: append lock, block lock, cache entry lock.
+type appendLock struct {
+	Lock *sync.Mutex
+	Refs int // guarded by globalLock, removed from appendLocks at 0
+}
+
+// returns the unlock function
+func lockAppend(blockId string, name string) func() {
+	cacheId := GetCacheId(blockId, name)
+	globalLock.Lock()
+	aLock := appendLocks[cacheId]
+	if aLock == nil {
+		aLock = &appendLock{Lock: &sync.Mutex{}}
+		appendLocks[cacheId] = aLock
+	}
+	aLock.Refs++
+	globalLock.Unlock()
+	aLock.Lock.Lock()
+	return func() {
+		aLock.Lock.Unlock()
+		globalLock.Lock()
+		defer globalLock.Unlock()
+		aLock.Refs--
+		if aLock.Refs == 0 {
+			delete(appendLocks, cacheId)
+		}
+	}
+}
+
+type FileRename struct {
+	From string
+	To   string
+}
+
+// renames a set of files in a block atomically, an existing file with a destination name is replaced.  used to
+// swap in new versions of files: write them under temporary names, then rename them over the old ones.  readers
+// see either the old or the new set of files.
+func RenameFiles(ctx context.Context, blockId string, renames []FileRename) error {
+	if len(renames) == 0 {
+		return nil
+	}
+	fromNames := make(map[string]bool)
+	toNames := make(map[string]bool)
+	for _, r := range renames {
+		if r.From == "" || r.To == "" {
+			return fmt.Errorf("RenameFiles error: empty file name")
+		}
+		if fromNames[r.From] || toNames[r.To] {
+			return fmt.Errorf("RenameFiles error: duplicate file name in renames (%q -> %q)", r.From, r.To)
+		}
+		fromNames[r.From] = true
+		toNames[r.To] = true
+	}
+	for _, names := range []map[string]bool{fromNames, toNames} {
+		for name := range names {
+			flushAppendBuffer(ctx, blockId, name)
+		}
+	}
+	defer lockBlock(blockId, true)()
+	// the cached data must be in the db before the files are renamed, and the cache entries (under the old
+	// names) are dropped so they are re-read from the db
+	for _, names := range []map[string]bool{fromNames, toNames} {
+		for name := range names {
+			cacheEntry, found := GetCacheEntry(ctx, blockId, name)
+			if !found {
+				continue
+			}
+			flushLock.Lock()
+			err := flushCacheEntry(ctx, cacheEntry)
+			flushLock.Unlock()
+			if err != nil {
+				return fmt.Errorf("RenameFiles error flushing %q: %v", name, err)
+			}
+			DeleteCacheEntry(ctx, blockId, name)
+		}
+	}
+	err := RenameFilesInDB(ctx, blockId, renames)
+	if err != nil {
+		return fmt.Errorf("RenameFiles error: %w", err)
+	}
+	// the renamed files were flushed, their journaled writes must not be replayed under the new names
+	for _, names := range []map[string]bool{fromNames, toNames} {
+		for name := range names {
+			journalDrop(blockId, name)
+		}
+	}
+	return nil
 }
 
 func WriteFile(ctx context.Context, blockId string, name string, meta FileMeta, opts FileOptsType, data []byte) (int, error) {
@@ -592,7 +999,15 @@ func WriteFile(ctx context.Context, blockId string, name string, meta FileMeta,
 }
 
 func WriteMeta(ctx context.Context, blockId string, name string, meta FileMeta) error {
-	_, err := Stat(ctx, blockId, name)
+	flushAppendBuffer(ctx, blockId, name)
+	if GetFlushConfig().SyncPolicy == SyncPolicy_Journal {
+		return journaledWriteMeta(ctx, blockId, name, meta)
+	}
+	return writeMetaHelper(ctx, blockId, name, meta)
+}
+
+func writeMetaHelper(ctx context.Context, blockId string, name string, meta FileMeta) error {
+	_, err := statHelper(ctx, blockId, name)
 	// stat so that we can make sure cache entry is popuplated
 	if err != nil {
 		return err
@@ -615,6 +1030,24 @@ func ListFiles(ctx context.Context, blockId string) []*FileInfo {
 	return fInfoArr
 }
 
+type ListFilesOpts struct {
+	NameGlob      string         // sqlite glob (case-sensitive, "*", "?" and "[...]"), "" matches all
+	Meta          map[string]any // all keys must match (top-level meta keys, scalar values)
+	IndexedMeta   map[string]any // like Meta, using the meta index (only files that index the keys can match)
+	ModifiedSince int64          // modts >= ModifiedSince (unix millis), 0 for all
+	Offset        int
+	Limit         int // 0 for no limit
+}
+
+// like ListFiles, with filters and pagination (ordered by name).  returns whether there are more files after
+// the returned page.
+func ListFilesFiltered(ctx context.Context, blockId string, opts ListFilesOpts) ([]*FileInfo, bool, error) {
+	if opts.Offset < 0 || opts.Limit < 0 {
+		return nil, false, fmt.Errorf("ListFilesFiltered error: invalid offset/limit")
+	}
+	return GetFilesInDBForBlockId(ctx, blockId, opts)
+}
+
 func ListAllFiles(ctx context.Context) []*FileInfo {
 	fInfoArr, err := GetAllFilesInDB(ctx)
 	if err != nil {
@@ -4,9 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io/fs"
 	"log"
 	"path"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
@@ -171,6 +175,8 @@ func (fInfo *FileInfo) FromMap(m map[string]interface{}) bool {
 	fileOpts := FileOptsType{}
 	dbutil.QuickSetBool(&fileOpts.Circular, m, "circular")
 	dbutil.QuickSetInt64(&fileOpts.MaxSize, m, "maxsize")
+	dbutil.QuickSetBool(&fileOpts.Compress, m, "compress")
+	dbutil.QuickSetJsonArr(&fileOpts.IndexMeta, m, "indexmeta")
 
 	var metaJson []byte
 	dbutil.QuickSetBytes(&metaJson, m, "meta")
@@ -192,8 +198,8 @@ func (fInfo *FileInfo) FromMap(m map[string]interface{}) bool {
 func GetFileInfo(ctx context.Context, blockId string, name string) (*FileInfo, error) {
 	fInfoArr, txErr := WithTxRtn(ctx, func(tx *TxWrap) ([]*FileInfo, error) {
 		var rtn []*FileInfo
-		query := `SELECT * FROM block_file WHERE name = 'file-1'`
-		marr := tx.SelectMaps(query)
+		query := `SELECT * FROM block_file WHERE blockid = ? AND name = ?`
+		marr := tx.SelectMaps(query, blockId, name)
 		for _, m := range marr {
 			rtn = append(rtn, dbutil.FromMap[*FileInfo](m))
 		}
@@ -206,7 +212,7 @@ func GetFileInfo(ctx context.Context, blockId string, name string) (*FileInfo, e
 		return nil, fmt.Errorf("GetFileInfo duplicate files in database")
 	}
 	if len(fInfoArr) == 0 {
-		return nil, fmt.Errorf("GetFileInfo: File not found")
+		return nil, fmt.Errorf("GetFileInfo: %w", fs.ErrNotExist)
 	}
 	fInfo := fInfoArr[0]
 	return fInfo, nil
@@ -214,8 +220,28 @@ func GetFileInfo(ctx context.Context, blockId string, name string) (*FileInfo, e
 
 func GetCacheFromDB(ctx context.Context, blockId string, name string, off int64, length int64, cacheNum int64) (*[]byte, error) {
 	return WithTxRtn(ctx, func(tx *TxWrap) (*[]byte, error) {
+		query := `SELECT codec, checksum FROM block_data WHERE blockid = ? AND name = ? and partidx = ?`
+		m := tx.GetMap(query, blockId, name, cacheNum)
+		var codec, checksum string
+		dbutil.QuickSetStr(&codec, m, "codec")
+		dbutil.QuickSetStr(&checksum, m, "checksum")
+		if codec != Codec_None || checksum != "" {
+			// compressed and checksummed blocks must be read whole
+			var blockData []byte
+			query = `SELECT data FROM block_data WHERE blockid = ? AND name = ? and partidx = ?`
+			tx.Get(&blockData, query, blockId, name, cacheNum)
+			if checksum != "" && blockChecksum(blockData) != checksum {
+				return nil, fmt.Errorf("%w: file %s/%s block %d (run fsck to repair)", ErrCorruptBlock, blockId, name, cacheNum)
+			}
+			data, err := decompressBlock(codec, blockData)
+			if err != nil {
+				return nil, fmt.Errorf("%w: file %s/%s block %d: %v", ErrCorruptBlock, blockId, name, cacheNum, err)
+			}
+			cacheData := substrBytes(data, off, length+1)
+			return &cacheData, nil
+		}
 		var cacheData *[]byte
-		query := `SELECT substr(data,?,?) FROM block_data WHERE blockid = ? AND name = ? and partidx = ?`
+		query = `SELECT substr(data,?,?) FROM block_data WHERE blockid = ? AND name = ? and partidx = ?`
 		tx.Get(&cacheData, query, off, length+1, blockId, name, cacheNum)
 		if cacheData == nil {
 			cacheData = &[]byte{}
@@ -228,6 +254,8 @@ func DeleteFileFromDB(ctx context.Context, blockId string, name string) error {
 	txErr := WithTx(ctx, func(tx *TxWrap) error {
 		query := `DELETE from block_file where blockid = ? AND name = ?`
 		tx.Exec(query, blockId, name)
+		query = `DELETE from block_file_metaidx where blockid = ? AND name = ?`
+		tx.Exec(query, blockId, name)
 		return nil
 	})
 	if txErr != nil {
@@ -244,10 +272,50 @@ func DeleteFileFromDB(ctx context.Context, blockId string, name string) error {
 	return nil
 }
 
+// all renames are done in one transaction.  files are moved to temporary names first, so the set can swap
+// names (a->b, b->a).  an existing file with a destination name is replaced.
+func RenameFilesInDB(ctx context.Context, blockId string, renames []FileRename) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		for _, r := range renames {
+			query := `SELECT blockid FROM block_file WHERE blockid = ? AND name = ?`
+			if !tx.Exists(query, blockId, r.From) {
+				return fmt.Errorf("file %q: %w", r.From, fs.ErrNotExist)
+			}
+		}
+		for idx, r := range renames {
+			tmpName := fmt.Sprintf("~rename-%d~%s", idx, r.From)
+			query := `UPDATE block_file SET name = ? WHERE blockid = ? AND name = ?`
+			tx.Exec(query, tmpName, blockId, r.From)
+			query = `UPDATE block_data SET name = ? WHERE blockid = ? AND name = ?`
+			tx.Exec(query, tmpName, blockId, r.From)
+			query = `UPDATE block_file_metaidx SET name = ? WHERE blockid = ? AND name = ?`
+			tx.Exec(query, tmpName, blockId, r.From)
+		}
+		for idx, r := range renames {
+			tmpName := fmt.Sprintf("~rename-%d~%s", idx, r.From)
+			query := `DELETE FROM block_file WHERE blockid = ? AND name = ?`
+			tx.Exec(query, blockId, r.To)
+			query = `DELETE FROM block_data WHERE blockid = ? AND name = ?`
+			tx.Exec(query, blockId, r.To)
+			query = `DELETE FROM block_file_metaidx WHERE blockid = ? AND name = ?`
+			tx.Exec(query, blockId, r.To)
+			query = `UPDATE block_file SET name = ?, modts = ? WHERE blockid = ? AND name = ?`
+			tx.Exec(query, r.To, time.Now().UnixMilli(), blockId, tmpName)
+			query = `UPDATE block_data SET name = ? WHERE blockid = ? AND name = ?`
+			tx.Exec(query, r.To, blockId, tmpName)
+			query = `UPDATE block_file_metaidx SET name = ? WHERE blockid = ? AND name = ?`
+			tx.Exec(query, r.To, blockId, tmpName)
+		}
+		return nil
+	})
+}
+
 func DeleteBlockFromDB(ctx context.Context, blockId string) error {
 	txErr := WithTx(ctx, func(tx *TxWrap) error {
 		query := `DELETE from block_file where blockid = ?`
 		tx.Exec(query, blockId)
+		query = `DELETE from block_file_metaidx where blockid = ?`
+		tx.Exec(query, blockId)
 		return nil
 	})
 	if txErr != nil {
@@ -276,6 +344,65 @@ func GetAllFilesInDBForBlockId(ctx context.Context, blockId string) ([]*FileInfo
 	})
 }
 
+// returns the matching files ordered by name, and whether there are more files after the page
+func GetFilesInDBForBlockId(ctx context.Context, blockId string, opts ListFilesOpts) ([]*FileInfo, bool, error) {
+	var hasMore bool
+	rtn, txErr := WithTxRtn(ctx, func(tx *TxWrap) ([]*FileInfo, error) {
+		var rtn []*FileInfo
+		query := `SELECT * FROM block_file WHERE blockid = ?`
+		args := []interface{}{blockId}
+		if opts.NameGlob != "" {
+			query += ` AND name GLOB ?`
+			args = append(args, opts.NameGlob)
+		}
+		if opts.ModifiedSince > 0 {
+			query += ` AND modts >= ?`
+			args = append(args, opts.ModifiedSince)
+		}
+		metaKeys := make([]string, 0, len(opts.Meta))
+		for key := range opts.Meta {
+			metaKeys = append(metaKeys, key)
+		}
+		sort.Strings(metaKeys)
+		for _, key := range metaKeys {
+			query += ` AND json_extract(meta, ?) = ?`
+			args = append(args, metaJsonPath(key), opts.Meta[key])
+		}
+		idxCond, idxArgs, err := indexedMetaCond(blockId, opts.IndexedMeta)
+		if err != nil {
+			return nil, err
+		}
+		query += idxCond
+		args = append(args, idxArgs...)
+		query += ` ORDER BY name`
+		if opts.Limit > 0 {
+			query += ` LIMIT ? OFFSET ?`
+			args = append(args, opts.Limit+1, opts.Offset)
+		} else if opts.Offset > 0 {
+			query += ` LIMIT -1 OFFSET ?`
+			args = append(args, opts.Offset)
+		}
+		marr := tx.SelectMaps(query, args...)
+		for _, m := range marr {
+			rtn = append(rtn, dbutil.FromMap[*FileInfo](m))
+		}
+		if opts.Limit > 0 && len(rtn) > opts.Limit {
+			rtn = rtn[:opts.Limit]
+			hasMore = true
+		}
+		return rtn, nil
+	})
+	if txErr != nil {
+		return nil, false, txErr
+	}
+	return rtn, hasMore, nil
+}
+
+// json path for a top-level meta key (quoted, so keys can contain dots)
+func metaJsonPath(key string) string {
+	return `$."` + strings.ReplaceAll(key, `"`, `\"`) + `"`
+}
+
 func GetAllFilesInDB(ctx context.Context) ([]*FileInfo, error) {
 	return WithTxRtn(ctx, func(tx *TxWrap) ([]*FileInfo, error) {
 		var rtn []*FileInfo
@@ -288,6 +415,16 @@ func GetAllFilesInDB(ctx context.Context) ([]*FileInfo, error) {
 	})
 }
 
+// returns the number of bytes the (flushed) data blocks of the file use in the db (after compression)
+func GetFileStoredSize(ctx context.Context, blockId string, name string) (int64, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (int64, error) {
+		query := `SELECT COALESCE(SUM(length(data)), 0) FROM block_data WHERE blockid = ? AND name = ?`
+		var rtn int64
+		tx.Get(&rtn, query, blockId, name)
+		return rtn, nil
+	})
+}
+
 func GetAllBlockIdsInDB(ctx context.Context) ([]string, error) {
 	return WithTxRtn(ctx, func(tx *TxWrap) ([]string, error) {
 		var rtn []string
@@ -301,3 +438,37 @@ func GetAllBlockIdsInDB(ctx context.Context) ([]string, error) {
 		return rtn, nil
 	})
 }
+
+// copies the files of srcBlockId to dstBlockId, srcTx is a transaction on another blockstore db with the same schema.
+// the data is copied as stored (compressed blocks stay compressed).  returns the number of files copied.
+func CopyBlockFrom(ctx context.Context, srcTx *TxWrap, srcBlockId string, dstBlockId string) (int, error) {
+	fileMaps := srcTx.SelectMaps(`SELECT * FROM block_file WHERE blockid = ?`, srcBlockId)
+	if srcTx.Err != nil {
+		return 0, srcTx.Err
+	}
+	var numFiles int
+	for _, fileMap := range fileMaps {
+		var name string
+		dbutil.QuickSetStr(&name, fileMap, "name")
+		dataMaps := srcTx.SelectMaps(`SELECT * FROM block_data WHERE blockid = ? AND name = ?`, srcBlockId, name)
+		if srcTx.Err != nil {
+			return numFiles, srcTx.Err
+		}
+		txErr := WithTx(ctx, func(tx *TxWrap) error {
+			fileMap["blockid"] = dstBlockId
+			dbutil.InsertMap(tx, "block_file", fileMap)
+			// the source db may not have the meta index (or be at another version), rebuild it from the meta
+			updateMetaIndexTx(tx, *dbutil.FromMap[*FileInfo](fileMap))
+			for _, dataMap := range dataMaps {
+				dataMap["blockid"] = dstBlockId
+				dbutil.InsertMap(tx, "block_data", dataMap)
+			}
+			return nil
+		})
+		if txErr != nil {
+			return numFiles, fmt.Errorf("copying file %s/%s: %w", srcBlockId, name, txErr)
+		}
+		numFiles++
+	}
+	return numFiles, nil
+}
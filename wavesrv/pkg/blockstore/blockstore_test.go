@@ -5,9 +5,16 @@ import (
 	"context"
 	"crypto/md5"
 	"crypto/rand"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
 	"log"
 	"os"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -24,7 +31,7 @@ type TestBlockType struct {
 	Data    []byte
 }
 
-func initTestDb(t *testing.T) {
+func initTestDb(t testing.TB) {
 	log.Printf("initTestDb: %v", t.Name())
 	os.Remove(testOverrideDBName)
 	overrideDBName = testOverrideDBName
@@ -34,7 +41,7 @@ func initTestDb(t *testing.T) {
 	}
 }
 
-func cleanupTestDB(t *testing.T) {
+func cleanupTestDB(t testing.TB) {
 	clearCache()
 	CloseDB()
 	os.Remove(testOverrideDBName)
@@ -85,7 +92,7 @@ func SimpleFatalAssert(t *testing.T, condition bool, description string) {
 
 func InsertIntoBlockData(t *testing.T, ctx context.Context, blockId string, name string, partidx int, data []byte) {
 	txErr := WithTx(ctx, func(tx *TxWrap) error {
-		query := `INSERT into block_data values (?, ?, ?, ?)`
+		query := `INSERT into block_data (blockid, name, partidx, data) values (?, ?, ?, ?)`
 		tx.Exec(query, blockId, name, partidx, data)
 		return nil
 	})
@@ -101,7 +108,7 @@ func TestTx(t *testing.T) {
 	ctx := context.Background()
 	SetFlushTimeout(2 * time.Minute)
 	txErr := WithTx(ctx, func(tx *TxWrap) error {
-		query := `INSERT into block_data values ('test-block-id', 'test-file-name', 0, 256)`
+		query := `INSERT into block_data (blockid, name, partidx, data) values ('test-block-id', 'test-file-name', 0, 256)`
 		tx.Exec(query)
 		return nil
 	})
@@ -109,7 +116,7 @@ func TestTx(t *testing.T) {
 		t.Errorf("TestTx error inserting into block_data table: %v", txErr)
 	}
 	txErr = WithTx(ctx, func(tx *TxWrap) error {
-		query := `INSERT into block_data values (?, ?, ?, ?)`
+		query := `INSERT into block_data (blockid, name, partidx, data) values (?, ?, ?, ?)`
 		tx.Exec(query, "test-block-id", "test-file-name-2", 1, []byte{110, 200, 50, 45})
 		return nil
 	})
@@ -491,6 +498,36 @@ func TestFlushCache(t *testing.T) {
 	log.Printf("DB Data: %v", dbData)
 }
 
+func TestFlushCacheEvictsCleanBlocks(t *testing.T) {
+	initTestDb(t)
+	defer cleanupTestDB(t)
+
+	ctx := context.Background()
+	fileOpts := FileOptsType{MaxSize: bigFileSize, Circular: false, IJson: false}
+	_, err := WriteFile(ctx, "test-block-id", "file-1", nil, fileOpts, []byte("TESTMESSAGE"))
+	if err != nil {
+		t.Fatalf("WriteFile error: %v", err)
+	}
+	FlushCache(ctx)
+	read := make([]byte, 32)
+	bytesRead, err := ReadAt(ctx, "test-block-id", "file-1", &read, 0)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	SimpleAssert(t, string(read[0:bytesRead]) == "TESTMESSAGE", "Correct data read")
+	_, found := GetCacheEntry(ctx, "test-block-id", "file-1")
+	SimpleAssert(t, found, "Cache entry populated by read")
+	FlushCache(ctx)
+	_, found = GetCacheEntry(ctx, "test-block-id", "file-1")
+	SimpleAssert(t, !found, "Clean cache entry evicted by flush")
+	read = make([]byte, 32)
+	bytesRead, err = ReadAt(ctx, "test-block-id", "file-1", &read, 0)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	SimpleAssert(t, string(read[0:bytesRead]) == "TESTMESSAGE", "Correct data read after eviction")
+}
+
 var largeDataFlushFullWriteSize int64 = 64 * UnitsKB
 
 func WriteLargeDataFlush(t *testing.T, ctx context.Context) {
@@ -766,6 +803,199 @@ func TestAppendSync(t *testing.T) {
 	SimpleAssert(t, bytesRead == numWorkers, "Correct bytes read")
 }
 
+func TestAppendCoalesceInterleavedReaders(t *testing.T) {
+	initTestDb(t)
+	defer cleanupTestDB(t)
+
+	ctx := context.Background()
+	fileOpts := FileOptsType{MaxSize: bigFileSize, Circular: false, IJson: false}
+	err := MakeFile(ctx, "test-block-id", "file-1", make(FileMeta), fileOpts)
+	if err != nil {
+		t.Fatalf("MakeFile error: %v", err)
+	}
+	var expected bytes.Buffer
+	for i := 0; i < 2000; i++ {
+		expected.WriteString(fmt.Sprintf("line %d\n", i))
+	}
+	expectedData := expected.Bytes()
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var lastSize int64
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				fInfo, err := Stat(ctx, "test-block-id", "file-1")
+				if err != nil {
+					t.Errorf("Stat error: %v", err)
+					return
+				}
+				if fInfo.Size < lastSize {
+					t.Errorf("file size went back from %d to %d", lastSize, fInfo.Size)
+					return
+				}
+				lastSize = fInfo.Size
+				read := make([]byte, fInfo.Size)
+				bytesRead, err := ReadAt(ctx, "test-block-id", "file-1", &read, 0)
+				if err != nil {
+					t.Errorf("ReadAt error: %v", err)
+					return
+				}
+				if !bytes.Equal(read[:bytesRead], expectedData[:bytesRead]) {
+					t.Errorf("reader saw out of order data")
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 2000; i++ {
+		_, err := AppendData(ctx, "test-block-id", "file-1", []byte(fmt.Sprintf("line %d\n", i)))
+		if err != nil {
+			t.Fatalf("AppendData error: %v", err)
+		}
+		if i%500 == 0 {
+			// stat right after an append sees the pending appends
+			fInfo, err := Stat(ctx, "test-block-id", "file-1")
+			if err != nil {
+				t.Fatalf("Stat error: %v", err)
+			}
+			SimpleAssert(t, fInfo.Size == int64(bytes.Index(expectedData, []byte(fmt.Sprintf("line %d\n", i+1)))), "pending appends visible to stat")
+		}
+	}
+	close(done)
+	wg.Wait()
+	read := make([]byte, len(expectedData)+10)
+	bytesRead, err := ReadAt(ctx, "test-block-id", "file-1", &read, 0)
+	if err != nil {
+		t.Fatalf("ReadAt error: %v", err)
+	}
+	SimpleAssert(t, bytes.Equal(read[:bytesRead], expectedData), "all appends written in order")
+
+	_, err = AppendData(ctx, "test-block-id", "file-1", []byte("tail"))
+	if err != nil {
+		t.Fatalf("AppendData error: %v", err)
+	}
+	_, err = WriteAt(ctx, "test-block-id", "file-1", []byte("TAIL"), int64(len(expectedData)))
+	if err != nil {
+		t.Fatalf("WriteAt error: %v", err)
+	}
+	SimpleAssert(t, string(readWholeFile(t, ctx, "test-block-id", "file-1")[len(expectedData):]) == "TAIL", "write after append overwrites the append")
+	_, err = AppendData(ctx, "test-block-id", "missing", []byte("data"))
+	SimpleAssert(t, err != nil, "append to a missing file fails right away")
+}
+
+func TestParallelAppendsToDifferentFiles(t *testing.T) {
+	initTestDb(t)
+	defer cleanupTestDB(t)
+
+	ctx := context.Background()
+	fileOpts := FileOptsType{MaxSize: bigFileSize, Circular: false, IJson: false}
+	const numFiles = 8
+	const numWriters = 4
+	const numAppends = 200
+	for f := 0; f < numFiles; f++ {
+		err := MakeFile(ctx, "test-block-id", fmt.Sprintf("file-%d", f), make(FileMeta), fileOpts)
+		if err != nil {
+			t.Fatalf("MakeFile error: %v", err)
+		}
+	}
+	var wg sync.WaitGroup
+	for f := 0; f < numFiles; f++ {
+		for w := 0; w < numWriters; w++ {
+			wg.Add(1)
+			go func(f int, w int) {
+				defer wg.Done()
+				for i := 0; i < numAppends; i++ {
+					_, err := AppendData(ctx, "test-block-id", fmt.Sprintf("file-%d", f), []byte(fmt.Sprintf("w%d-%03d\n", w, i)))
+					if err != nil {
+						t.Errorf("AppendData error: %v", err)
+						return
+					}
+				}
+			}(f, w)
+		}
+	}
+	wg.Wait()
+	for f := 0; f < numFiles; f++ {
+		data := readWholeFile(t, ctx, "test-block-id", fmt.Sprintf("file-%d", f))
+		SimpleAssert(t, len(data) == numWriters*numAppends*len("w0-000\n"), fmt.Sprintf("file-%d has all appends (size %d)", f, len(data)))
+		nextIdx := make(map[string]int)
+		for _, line := range bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) {
+			var w, i int
+			_, err := fmt.Sscanf(string(line), "w%d-%d", &w, &i)
+			if err != nil {
+				t.Fatalf("file-%d has a corrupted append %q", f, line)
+			}
+			writer := fmt.Sprintf("w%d", w)
+			SimpleFatalAssert(t, nextIdx[writer] == i, fmt.Sprintf("file-%d appends of %s in order", f, writer))
+			nextIdx[writer]++
+		}
+	}
+
+	// appends to a file do not wait for the appends to other files
+	unlockFn := lockAppend("test-block-id", "file-0")
+	otherDone := make(chan error, 1)
+	go func() {
+		_, err := appendDataHelper(ctx, "test-block-id", "file-1", []byte("x"))
+		otherDone <- err
+	}()
+	sameDone := make(chan error, 1)
+	go func() {
+		_, err := appendDataHelper(ctx, "test-block-id", "file-0", []byte("x"))
+		sameDone <- err
+	}()
+	select {
+	case err := <-otherDone:
+		SimpleAssert(t, err == nil, "append to another file")
+	case <-time.After(5 * time.Second):
+		t.Fatalf("append to file-1 blocked by the append lock of file-0")
+	}
+	select {
+	case <-sameDone:
+		t.Fatalf("append to file-0 did not wait for its append lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlockFn()
+	SimpleAssert(t, <-sameDone == nil, "append to file-0 after unlock")
+	globalLock.Lock()
+	numLocks := len(appendLocks)
+	globalLock.Unlock()
+	SimpleAssert(t, numLocks == 0, "append locks are removed when released")
+}
+
+// compare -cpu 1,4,8: appends to different files run in parallel
+func BenchmarkParallelAppend(b *testing.B) {
+	initTestDb(b)
+	defer cleanupTestDB(b)
+
+	ctx := context.Background()
+	fileOpts := FileOptsType{MaxSize: bigFileSize, Circular: true, IJson: false}
+	var fileNum atomic.Int64
+	data := bytes.Repeat([]byte("x"), 256)
+	b.SetBytes(int64(len(data)))
+	b.RunParallel(func(pb *testing.PB) {
+		name := fmt.Sprintf("file-%d", fileNum.Add(1))
+		err := MakeFile(ctx, "test-block-id", name, make(FileMeta), fileOpts)
+		if err != nil {
+			b.Errorf("MakeFile error: %v", err)
+			return
+		}
+		for pb.Next() {
+			_, err := appendDataHelper(ctx, "test-block-id", name, data)
+			if err != nil {
+				b.Errorf("append error: %v", err)
+				return
+			}
+		}
+	})
+}
+
 func WriteAtSyncWorker(t *testing.T, ctx context.Context, wg *sync.WaitGroup, index int64) {
 	defer wg.Done()
 	writeBuf := make([]byte, 1)
@@ -920,6 +1150,129 @@ func TestListFiles(t *testing.T) {
 	}
 }
 
+func TestListFilesFiltered(t *testing.T) {
+	initTestDb(t)
+	defer cleanupTestDB(t)
+
+	ctx := context.Background()
+	fileOpts := FileOptsType{MaxSize: bigFileSize, Circular: false, IJson: false}
+	files := map[string]FileMeta{
+		"img-1.png":  {"mimetype": "image/png", "pinned": true},
+		"img-2.png":  {"mimetype": "image/png", "pinned": false},
+		"img-3.jpg":  {"mimetype": "image/jpeg", "width": 640},
+		"notes.txt":  {"mimetype": "text/plain"},
+		"other.name": {"my.key": "dotted"},
+	}
+	for name, meta := range files {
+		err := MakeFile(ctx, "test-block-id", name, meta, fileOpts)
+		if err != nil {
+			t.Fatalf("MakeFile error: %v", err)
+		}
+	}
+	listNames := func(opts ListFilesOpts) ([]string, bool) {
+		fInfos, hasMore, err := ListFilesFiltered(ctx, "test-block-id", opts)
+		if err != nil {
+			t.Fatalf("ListFilesFiltered error: %v", err)
+		}
+		var names []string
+		for _, fInfo := range fInfos {
+			names = append(names, fInfo.Name)
+		}
+		log.Printf("ListFilesFiltered %+v: %v %v", opts, names, hasMore)
+		return names, hasMore
+	}
+	names, _ := listNames(ListFilesOpts{NameGlob: "img-*"})
+	SimpleAssert(t, len(names) == 3 && names[0] == "img-1.png", "name glob")
+	names, _ = listNames(ListFilesOpts{Meta: map[string]any{"mimetype": "image/png"}})
+	SimpleAssert(t, len(names) == 2, "meta string match")
+	names, _ = listNames(ListFilesOpts{Meta: map[string]any{"mimetype": "image/png", "pinned": true}})
+	SimpleAssert(t, len(names) == 1 && names[0] == "img-1.png", "meta bool match")
+	names, _ = listNames(ListFilesOpts{Meta: map[string]any{"width": 640}})
+	SimpleAssert(t, len(names) == 1 && names[0] == "img-3.jpg", "meta number match")
+	names, _ = listNames(ListFilesOpts{Meta: map[string]any{"my.key": "dotted"}})
+	SimpleAssert(t, len(names) == 1 && names[0] == "other.name", "meta dotted key match")
+	names, _ = listNames(ListFilesOpts{ModifiedSince: time.Now().Add(time.Hour).UnixMilli()})
+	SimpleAssert(t, len(names) == 0, "modified since")
+	names, hasMore := listNames(ListFilesOpts{Limit: 2})
+	SimpleAssert(t, len(names) == 2 && hasMore, "first page")
+	names, hasMore = listNames(ListFilesOpts{Offset: 4, Limit: 2})
+	SimpleAssert(t, len(names) == 1 && names[0] == "other.name" && !hasMore, "last page")
+}
+
+func TestIndexedMeta(t *testing.T) {
+	initTestDb(t)
+	defer cleanupTestDB(t)
+
+	ctx := context.Background()
+	indexedOpts := FileOptsType{MaxSize: bigFileSize, IndexMeta: []string{"mimetype", "width"}}
+	for name, meta := range map[string]FileMeta{
+		"img-1.png": {"mimetype": "image/png", "width": 640},
+		"img-2.png": {"mimetype": "image/png", "width": 1024},
+		"img-3.jpg": {"mimetype": "image/jpeg", "tags": []string{"a"}},
+	} {
+		err := MakeFile(ctx, "test-block-id", name, meta, indexedOpts)
+		if err != nil {
+			t.Fatalf("MakeFile error: %v", err)
+		}
+	}
+	// not indexed, never matched by IndexedMeta
+	err := MakeFile(ctx, "test-block-id", "raw.png", FileMeta{"mimetype": "image/png"}, FileOptsType{MaxSize: bigFileSize})
+	if err != nil {
+		t.Fatalf("MakeFile error: %v", err)
+	}
+	err = MakeFile(ctx, "test-block-id", "bad", nil, FileOptsType{MaxSize: bigFileSize, IndexMeta: []string{"a", "a"}})
+	SimpleAssert(t, err != nil, "duplicate index keys")
+	listNames := func(indexedMeta map[string]any) []string {
+		fInfos, _, err := ListFilesFiltered(ctx, "test-block-id", ListFilesOpts{IndexedMeta: indexedMeta})
+		if err != nil {
+			t.Fatalf("ListFilesFiltered error: %v", err)
+		}
+		var names []string
+		for _, fInfo := range fInfos {
+			names = append(names, fInfo.Name)
+		}
+		return names
+	}
+	names := listNames(map[string]any{"mimetype": "image/png"})
+	SimpleAssert(t, len(names) == 2 && names[0] == "img-1.png" && names[1] == "img-2.png", "indexed string match")
+	names = listNames(map[string]any{"mimetype": "image/png", "width": 1024})
+	SimpleAssert(t, len(names) == 1 && names[0] == "img-2.png", "indexed number match")
+	names = listNames(map[string]any{"width": "640"})
+	SimpleAssert(t, len(names) == 0, "indexed values are typed")
+	_, _, err = ListFilesFiltered(ctx, "test-block-id", ListFilesOpts{IndexedMeta: map[string]any{"tags": []string{"a"}}})
+	SimpleAssert(t, err != nil, "non-scalar indexed value")
+
+	clearCache()
+	fInfo, err := Stat(ctx, "test-block-id", "img-3.jpg")
+	if err != nil {
+		t.Fatalf("Stat error: %v", err)
+	}
+	SimpleAssert(t, len(fInfo.Opts.IndexMeta) == 2 && fInfo.Opts.IndexMeta[0] == "mimetype", "index keys read from db")
+	err = WriteMeta(ctx, "test-block-id", "img-3.jpg", FileMeta{"mimetype": "image/png"})
+	if err != nil {
+		t.Fatalf("WriteMeta error: %v", err)
+	}
+	err = FlushCache(ctx)
+	if err != nil {
+		t.Fatalf("FlushCache error: %v", err)
+	}
+	names = listNames(map[string]any{"mimetype": "image/png"})
+	SimpleAssert(t, len(names) == 3, "index updated after meta change")
+
+	err = RenameFiles(ctx, "test-block-id", []FileRename{{From: "img-1.png", To: "renamed.png"}})
+	if err != nil {
+		t.Fatalf("RenameFiles error: %v", err)
+	}
+	names = listNames(map[string]any{"width": 640})
+	SimpleAssert(t, len(names) == 1 && names[0] == "renamed.png", "index follows rename")
+	err = DeleteFile(ctx, "test-block-id", "renamed.png")
+	if err != nil {
+		t.Fatalf("DeleteFile error: %v", err)
+	}
+	names = listNames(map[string]any{"width": 640})
+	SimpleAssert(t, len(names) == 0, "index removed with file")
+}
+
 func TestFlushTimer(t *testing.T) {
 	initTestDb(t)
 	defer cleanupTestDB(t)
@@ -984,6 +1337,381 @@ func TestFlushTimer(t *testing.T) {
 	log.Printf("DB Data: %v", dbData)
 }
 
+func countDataBlocksInDB(t *testing.T, ctx context.Context, blockId string, name string) int {
+	count, txErr := WithTxRtn(ctx, func(tx *TxWrap) (int, error) {
+		query := `SELECT count(*) FROM block_data WHERE blockid = ? AND name = ?`
+		return tx.GetInt(query, blockId, name), nil
+	})
+	if txErr != nil {
+		t.Errorf("error counting data blocks: %v", txErr)
+	}
+	return count
+}
+
+func TestFlushDirtyThreshold(t *testing.T) {
+	initTestDb(t)
+	defer cleanupTestDB(t)
+	defer SetFlushConfig(FlushConfig{})
+
+	ctx := context.Background()
+	err := SetFlushConfig(FlushConfig{FlushTimeout: 2 * time.Minute, DirtyThreshold: 64})
+	if err != nil {
+		t.Fatalf("SetFlushConfig error: %v", err)
+	}
+	fileOpts := FileOptsType{MaxSize: bigFileSize, Circular: false, IJson: false}
+	err = MakeFile(ctx, "test-block-id", "file-1", make(FileMeta), fileOpts)
+	if err != nil {
+		t.Fatalf("MakeFile error: %v", err)
+	}
+	_, err = WriteAt(ctx, "test-block-id", "file-1", make([]byte, 32), 0)
+	if err != nil {
+		t.Fatalf("WriteAt error: %v", err)
+	}
+	SimpleAssert(t, countDataBlocksInDB(t, ctx, "test-block-id", "file-1") == 0, "not flushed under the dirty threshold")
+	_, err = WriteAt(ctx, "test-block-id", "file-1", make([]byte, 32), 32)
+	if err != nil {
+		t.Fatalf("WriteAt error: %v", err)
+	}
+	SimpleAssert(t, countDataBlocksInDB(t, ctx, "test-block-id", "file-1") == 1, "flushed at the dirty threshold")
+	SimpleAssert(t, dirtyBytes.Load() == 0, "dirty bytes reset after flush")
+}
+
+func TestCacheStats(t *testing.T) {
+	initTestDb(t)
+	defer cleanupTestDB(t)
+	defer SetFlushConfig(FlushConfig{})
+
+	ctx := context.Background()
+	err := SetFlushConfig(FlushConfig{FlushTimeout: 2 * time.Minute})
+	if err != nil {
+		t.Fatalf("SetFlushConfig error: %v", err)
+	}
+	SimpleAssert(t, GetCacheStats() == CacheStats{}, "empty cache")
+	fileOpts := FileOptsType{MaxSize: bigFileSize, Circular: false, IJson: false}
+	for _, name := range []string{"file-1", "file-2"} {
+		err = MakeFile(ctx, "test-block-id", name, make(FileMeta), fileOpts)
+		if err != nil {
+			t.Fatalf("MakeFile error: %v", err)
+		}
+		_, err = WriteAt(ctx, "test-block-id", name, make([]byte, 100), 0)
+		if err != nil {
+			t.Fatalf("WriteAt error: %v", err)
+		}
+	}
+	stats := GetCacheStats()
+	log.Printf("cache stats: %+v", stats)
+	SimpleAssert(t, stats.Entries == 2, "an entry per file")
+	SimpleAssert(t, stats.Blocks == 2, "a block per file")
+	SimpleAssert(t, stats.Bytes >= 200, "cached bytes")
+	SimpleAssert(t, stats.DirtyBytes == 200, "dirty bytes before flush")
+	err = FlushCache(ctx)
+	if err != nil {
+		t.Fatalf("FlushCache error: %v", err)
+	}
+	SimpleAssert(t, GetCacheStats().DirtyBytes == 0, "no dirty bytes after flush")
+}
+
+func TestFlushSyncPolicyWrite(t *testing.T) {
+	initTestDb(t)
+	defer cleanupTestDB(t)
+	defer SetFlushConfig(FlushConfig{})
+
+	ctx := context.Background()
+	err := SetFlushConfig(FlushConfig{FlushTimeout: 2 * time.Minute, SyncPolicy: SyncPolicy_Write})
+	if err != nil {
+		t.Fatalf("SetFlushConfig error: %v", err)
+	}
+	fileOpts := FileOptsType{MaxSize: bigFileSize, Circular: false, IJson: false}
+	err = MakeFile(ctx, "test-block-id", "file-1", make(FileMeta), fileOpts)
+	if err != nil {
+		t.Fatalf("MakeFile error: %v", err)
+	}
+	testBytesToWrite := []byte("TESTMESSAGE")
+	_, err = WriteAt(ctx, "test-block-id", "file-1", testBytesToWrite, 0)
+	if err != nil {
+		t.Fatalf("WriteAt error: %v", err)
+	}
+	SimpleAssert(t, countDataBlocksInDB(t, ctx, "test-block-id", "file-1") == 1, "written through to the db")
+	read := make([]byte, len(testBytesToWrite))
+	_, err = ReadAt(ctx, "test-block-id", "file-1", &read, 0)
+	if err != nil {
+		t.Fatalf("ReadAt error: %v", err)
+	}
+	SimpleAssert(t, bytes.Equal(read, testBytesToWrite), "correct data read back")
+	err = SetFlushConfig(FlushConfig{SyncPolicy: "always"})
+	SimpleAssert(t, err != nil, "invalid sync policy rejected")
+	SimpleAssert(t, GetFlushConfig().SyncPolicy == SyncPolicy_Write, "config unchanged after invalid sync policy")
+}
+
+// drops the cache and the open journal without flushing (as if the server crashed)
+func simulateCrash() {
+	clearCache()
+	journal.Lock.Lock()
+	if journal.File != nil {
+		journal.File.Close()
+	}
+	journal.Lock.Unlock()
+	journal = &journalState{Lock: &sync.Mutex{}}
+}
+
+func TestJournalReplay(t *testing.T) {
+	initTestDb(t)
+	defer cleanupTestDB(t)
+	defer SetFlushConfig(FlushConfig{})
+
+	ctx := context.Background()
+	err := SetFlushConfig(FlushConfig{FlushTimeout: 2 * time.Minute, SyncPolicy: SyncPolicy_Journal})
+	if err != nil {
+		t.Fatalf("SetFlushConfig error: %v", err)
+	}
+	fileOpts := FileOptsType{MaxSize: bigFileSize, Circular: false, IJson: false}
+	for _, name := range []string{"file-1", "file-2"} {
+		err = MakeFile(ctx, "test-block-id", name, make(FileMeta), fileOpts)
+		if err != nil {
+			t.Fatalf("MakeFile error: %v", err)
+		}
+	}
+	_, err = WriteAt(ctx, "test-block-id", "file-1", []byte("hello world"), 0)
+	if err != nil {
+		t.Fatalf("WriteAt error: %v", err)
+	}
+	_, err = AppendData(ctx, "test-block-id", "file-1", []byte(", again"))
+	if err != nil {
+		t.Fatalf("AppendData error: %v", err)
+	}
+	_, err = WriteAt(ctx, "test-block-id", "file-1", []byte("HELLO"), 0)
+	if err != nil {
+		t.Fatalf("WriteAt error: %v", err)
+	}
+	_, err = AppendData(ctx, "test-block-id", "file-2", []byte("deleted data"))
+	if err != nil {
+		t.Fatalf("AppendData error: %v", err)
+	}
+	err = DeleteFile(ctx, "test-block-id", "file-2")
+	if err != nil {
+		t.Fatalf("DeleteFile error: %v", err)
+	}
+	err = MakeFile(ctx, "test-block-id", "file-2", make(FileMeta), fileOpts)
+	if err != nil {
+		t.Fatalf("MakeFile error: %v", err)
+	}
+	_, err = AppendData(ctx, "test-block-id", "file-2", []byte("new data"))
+	if err != nil {
+		t.Fatalf("AppendData error: %v", err)
+	}
+	for _, endPos := range []int64{11, 18} {
+		err = WriteMeta(ctx, "test-block-id", "file-1", FileMeta{"endpos": endPos})
+		if err != nil {
+			t.Fatalf("WriteMeta error: %v", err)
+		}
+	}
+	SimpleAssert(t, countDataBlocksInDB(t, ctx, "test-block-id", "file-1") == 0, "journaled writes are not in the db before the flush")
+	segs, _, err := listJournalSegments()
+	if err != nil || len(segs) != 1 {
+		t.Fatalf("expected one journal segment, got %v (err %v)", segs, err)
+	}
+	simulateCrash()
+	// a torn record (a crash during the journal write) is ignored
+	torn := encodeJournalRecord(journalRecord{Type: journalRec_Write, BlockId: "test-block-id", Name: "file-1", Off: 0, Data: []byte("torn")})
+	fd, err := os.OpenFile(segs[0], os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("error opening journal segment: %v", err)
+	}
+	fd.Write(torn[:len(torn)-2])
+	fd.Close()
+
+	numReplayed, err := ReplayJournal(ctx)
+	if err != nil {
+		t.Fatalf("ReplayJournal error: %v", err)
+	}
+	SimpleAssert(t, numReplayed == 6, fmt.Sprintf("replayed the writes (got %d)", numReplayed))
+	segs, _, _ = listJournalSegments()
+	SimpleAssert(t, len(segs) == 0, "journal segments removed after the replay")
+	simulateCrash()
+	SimpleAssert(t, string(readWholeFile(t, ctx, "test-block-id", "file-1")) == "HELLO world, again", "file-1 replayed")
+	SimpleAssert(t, string(readWholeFile(t, ctx, "test-block-id", "file-2")) == "new data", "writes before the delete skipped")
+	fInfo, err := Stat(ctx, "test-block-id", "file-1")
+	if err != nil {
+		t.Fatalf("Stat error: %v", err)
+	}
+	SimpleAssert(t, fInfo.Meta["endpos"] == float64(18), fmt.Sprintf("meta replayed (endpos %v)", fInfo.Meta["endpos"]))
+
+	// a flush removes the segments
+	_, err = AppendData(ctx, "test-block-id", "file-1", []byte("!"))
+	if err != nil {
+		t.Fatalf("AppendData error: %v", err)
+	}
+	segs, _, _ = listJournalSegments()
+	SimpleAssert(t, len(segs) == 1, "append journaled")
+	err = FlushCache(ctx)
+	if err != nil {
+		t.Fatalf("FlushCache error: %v", err)
+	}
+	segs, _, _ = listJournalSegments()
+	SimpleAssert(t, len(segs) == 0, "journal segments removed after the flush")
+}
+
+func TestReadAhead(t *testing.T) {
+	initTestDb(t)
+	defer cleanupTestDB(t)
+	resetReadAheadStats()
+	SetFlushTimeout(2 * time.Minute)
+	defer SetFlushTimeout(DefaultFlushTimeout)
+
+	ctx := context.Background()
+	fileOpts := FileOptsType{MaxSize: bigFileSize, Circular: false, IJson: false}
+	err := MakeFile(ctx, "test-block-id", "file-1", make(FileMeta), fileOpts)
+	if err != nil {
+		t.Fatalf("MakeFile error: %v", err)
+	}
+	testBytesToWrite := []byte("TESTMESSAGE")
+	_, err = WriteAt(ctx, "test-block-id", "file-1", testBytesToWrite, 0)
+	if err != nil {
+		t.Fatalf("WriteAt error: %v", err)
+	}
+	// blocks are too big to write whole in a test, put small blocks after the first one
+	InsertIntoBlockData(t, ctx, "test-block-id", "file-1", 1, []byte("block-1"))
+	InsertIntoBlockData(t, ctx, "test-block-id", "file-1", 2, []byte("block-2"))
+	cacheEntry, found := GetCacheEntry(ctx, "test-block-id", "file-1")
+	SimpleFatalAssert(t, found, "cache entry found")
+	cacheEntry.Info.Size = 3 * MaxBlockSize
+	for off := int64(0); off < 9; off += 3 {
+		read := make([]byte, 3)
+		_, err := ReadAt(ctx, "test-block-id", "file-1", &read, off)
+		if err != nil {
+			t.Fatalf("ReadAt error: %v", err)
+		}
+		SimpleAssert(t, bytes.Equal(read, testBytesToWrite[off:off+3]), "correct data read")
+	}
+	for i := 0; i < 100 && GetReadAheadStats().Loaded < 2; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	stats := GetReadAheadStats()
+	log.Printf("read-ahead stats: %+v", stats)
+	SimpleAssert(t, stats.Queued == 2, "next blocks queued after sequential reads")
+	SimpleAssert(t, stats.Loaded == 2, "next blocks loaded by read-ahead")
+	cacheEntry.Lock.Lock()
+	block := cacheEntry.DataBlocks[1]
+	cacheEntry.Lock.Unlock()
+	SimpleFatalAssert(t, block != nil && block.readAhead, "block 1 in the cache")
+	SimpleAssert(t, string(block.data) == "block-1", "block 1 loaded from the db")
+	noteBlockRead(cacheEntry, 1)
+	noteBlockRead(cacheEntry, 1)
+	SimpleAssert(t, GetReadAheadStats().Hits == 1, "read-ahead hit counted once")
+	SimpleAssert(t, GetReadAheadStats().HitRate() > 0, "read-ahead hit rate")
+}
+
+func readWholeFile(t *testing.T, ctx context.Context, blockId string, name string) []byte {
+	fInfo, err := Stat(ctx, blockId, name)
+	if err != nil {
+		t.Fatalf("Stat error %s: %v", name, err)
+	}
+	read := make([]byte, fInfo.Size)
+	_, err = ReadAt(ctx, blockId, name, &read, 0)
+	if err != nil {
+		t.Fatalf("ReadAt error %s: %v", name, err)
+	}
+	return read
+}
+
+func TestRenameFiles(t *testing.T) {
+	initTestDb(t)
+	defer cleanupTestDB(t)
+	SetFlushTimeout(2 * time.Minute)
+	defer SetFlushTimeout(DefaultFlushTimeout)
+
+	ctx := context.Background()
+	fileOpts := FileOptsType{MaxSize: bigFileSize, Circular: false, IJson: false}
+	for name, data := range map[string]string{"state": "old-state", "state.new": "new-state", "a": "file-a", "b": "file-b"} {
+		_, err := WriteFile(ctx, "test-block-id", name, make(FileMeta), fileOpts, []byte(data))
+		if err != nil {
+			t.Fatalf("WriteFile error: %v", err)
+		}
+	}
+	err := RenameFiles(ctx, "test-block-id", []FileRename{{From: "state.new", To: "state"}, {From: "a", To: "b"}, {From: "b", To: "a"}})
+	if err != nil {
+		t.Fatalf("RenameFiles error: %v", err)
+	}
+	SimpleAssert(t, string(readWholeFile(t, ctx, "test-block-id", "state")) == "new-state", "file replaced")
+	SimpleAssert(t, string(readWholeFile(t, ctx, "test-block-id", "a")) == "file-b", "files swapped (a)")
+	SimpleAssert(t, string(readWholeFile(t, ctx, "test-block-id", "b")) == "file-a", "files swapped (b)")
+	_, err = Stat(ctx, "test-block-id", "state.new")
+	SimpleAssert(t, errors.Is(err, fs.ErrNotExist), "renamed file removed")
+	SimpleAssert(t, len(ListFiles(ctx, "test-block-id")) == 3, "correct number of files")
+
+	err = RenameFiles(ctx, "test-block-id", []FileRename{{From: "a", To: "c"}, {From: "missing", To: "state"}})
+	SimpleAssert(t, errors.Is(err, fs.ErrNotExist), "missing file error")
+	SimpleAssert(t, string(readWholeFile(t, ctx, "test-block-id", "a")) == "file-b", "nothing renamed after an error")
+	SimpleAssert(t, string(readWholeFile(t, ctx, "test-block-id", "state")) == "new-state", "nothing replaced after an error")
+	err = RenameFiles(ctx, "test-block-id", []FileRename{{From: "a", To: "c"}, {From: "b", To: "c"}})
+	SimpleAssert(t, err != nil, "duplicate destination error")
+}
+
+func TestPruneBlockFiles(t *testing.T) {
+	initTestDb(t)
+	defer cleanupTestDB(t)
+	defer RemoveRetentionRule("test")
+
+	ctx := context.Background()
+	fileOpts := FileOptsType{MaxSize: bigFileSize}
+	for _, blockId := range []string{"block-1", "block-2"} {
+		for _, name := range []string{"ptyout:1", "ptytiming:1", "ptytiming:2"} {
+			_, err := WriteFile(ctx, blockId, name, make(FileMeta), fileOpts, []byte("data"))
+			if err != nil {
+				t.Fatalf("WriteFile error: %v", err)
+			}
+		}
+	}
+	err := FlushCache(ctx)
+	if err != nil {
+		t.Fatalf("FlushCache error: %v", err)
+	}
+	oldTs := time.Now().Add(-48 * time.Hour).UnixMilli()
+	err = WithTx(ctx, func(tx *TxWrap) error {
+		tx.Exec(`UPDATE block_file SET modts = ? WHERE name <> 'ptytiming:2'`, oldTs)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error setting modts: %v", err)
+	}
+	// a write after the flush makes the file new again (only in the cache)
+	_, err = AppendData(ctx, "block-2", "ptytiming:1", []byte("more"))
+	if err != nil {
+		t.Fatalf("AppendData error: %v", err)
+	}
+
+	deleted, err := PruneBlockFiles(ctx, "block-1", 24*time.Hour, "ptytiming:*")
+	if err != nil {
+		t.Fatalf("PruneBlockFiles error: %v", err)
+	}
+	SimpleAssert(t, len(deleted) == 1 && deleted[0] == "ptytiming:1", "old matching file pruned")
+	_, err = Stat(ctx, "block-1", "ptytiming:1")
+	SimpleAssert(t, errors.Is(err, fs.ErrNotExist), "pruned file removed")
+	SimpleAssert(t, len(ListFiles(ctx, "block-1")) == 2, "other files kept")
+
+	err = RegisterRetentionRule("test", RetentionRule{NameGlob: "ptytiming:*", MaxAge: 24 * time.Hour, Locker: &sync.Mutex{}})
+	if err != nil {
+		t.Fatalf("RegisterRetentionRule error: %v", err)
+	}
+	numDeleted, err := ApplyRetentionRules(ctx)
+	if err != nil {
+		t.Fatalf("ApplyRetentionRules error: %v", err)
+	}
+	SimpleAssert(t, numDeleted == 0, "recently written files kept")
+	SimpleAssert(t, len(ListFiles(ctx, "block-2")) == 3, "block-2 files kept")
+	err = RegisterRetentionRule("test", RetentionRule{NameGlob: "ptyout:*", MaxAge: 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("RegisterRetentionRule error: %v", err)
+	}
+	numDeleted, err = ApplyRetentionRules(ctx)
+	if err != nil {
+		t.Fatalf("ApplyRetentionRules error: %v", err)
+	}
+	SimpleAssert(t, numDeleted == 2, "rule applied to all blocks")
+	err = RegisterRetentionRule("test", RetentionRule{MaxAge: 0})
+	SimpleAssert(t, err != nil, "invalid rule error")
+}
+
 func TestWriteAtMiddle(t *testing.T) {
 	initTestDb(t)
 	defer cleanupTestDB(t)
@@ -1093,3 +1821,209 @@ func TestWriteLargeDataNoFlush(t *testing.T) {
 		return *cacheData, nil
 	})
 */
+
+func TestCompressedFile(t *testing.T) {
+	initTestDb(t)
+	defer cleanupTestDB(t)
+
+	ctx := context.Background()
+	fileOpts := FileOptsType{MaxSize: bigFileSize, Circular: false, IJson: false, Compress: true}
+	err := MakeFile(ctx, "test-block-id", "file-1", make(FileMeta), fileOpts)
+	if err != nil {
+		t.Fatalf("MakeFile error: %v", err)
+	}
+	testBytes := bytes.Repeat([]byte("compressible pty output\r\n"), 20000)
+	bytesWritten, err := WriteAt(ctx, "test-block-id", "file-1", testBytes, 0)
+	if err != nil {
+		t.Fatalf("WriteAt error: %v", err)
+	}
+	SimpleAssert(t, bytesWritten == len(testBytes), "Correct num bytes written")
+	FlushCache(ctx)
+	clearCache()
+
+	var blocks []*TestBlockType
+	WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT * FROM block_data WHERE blockid = ? AND name = ? AND codec = ?`
+		marr := tx.SelectMaps(query, "test-block-id", "file-1", Codec_Deflate)
+		for _, m := range marr {
+			blocks = append(blocks, dbutil.FromMap[*TestBlockType](m))
+		}
+		return nil
+	})
+	SimpleAssert(t, len(blocks) > 0, "Blocks stored compressed")
+	for _, block := range blocks {
+		SimpleAssert(t, int64(len(block.Data)) < MaxBlockSize, "Compressed block is smaller")
+	}
+	fInfo, err := Stat(ctx, "test-block-id", "file-1")
+	if err != nil {
+		t.Fatalf("Stat error: %v", err)
+	}
+	SimpleAssert(t, fInfo.Opts.Compress, "Compress opt persisted")
+	read := make([]byte, len(testBytes))
+	bytesRead, err := ReadAt(ctx, "test-block-id", "file-1", &read, 0)
+	if err != nil {
+		t.Fatalf("ReadAt error: %v", err)
+	}
+	SimpleAssert(t, bytesRead == len(testBytes), "Correct num bytes read")
+	SimpleAssert(t, bytes.Equal(read, testBytes), "Correct bytes read")
+}
+
+func TestFsck(t *testing.T) {
+	initTestDb(t)
+	defer cleanupTestDB(t)
+
+	ctx := context.Background()
+	fileOpts := FileOptsType{MaxSize: bigFileSize, Circular: false, IJson: false}
+	err := MakeFile(ctx, "test-block-id", "file-1", make(FileMeta), fileOpts)
+	if err != nil {
+		t.Fatalf("MakeFile error: %v", err)
+	}
+	testBytes := []byte("test-data-for-fsck")
+	_, err = WriteAt(ctx, "test-block-id", "file-1", testBytes, 0)
+	if err != nil {
+		t.Fatalf("WriteAt error: %v", err)
+	}
+	FlushCache(ctx)
+	clearCache()
+	corruptions, err := Verify(ctx, "test-block-id")
+	if err != nil {
+		t.Fatalf("Verify error: %v", err)
+	}
+	SimpleAssert(t, len(corruptions) == 0, "No corruption after write")
+
+	// corrupt the block and add an orphaned (legacy, no checksum) block
+	WithTx(ctx, func(tx *TxWrap) error {
+		query := `UPDATE block_data SET data = ? WHERE blockid = ? AND name = ?`
+		tx.Exec(query, []byte("test-data-for-fsXk"), "test-block-id", "file-1")
+		return nil
+	})
+	InsertIntoBlockData(t, ctx, "test-block-id", "file-2", 0, []byte("orphan"))
+	read := make([]byte, len(testBytes))
+	_, err = ReadAt(ctx, "test-block-id", "file-1", &read, 0)
+	SimpleAssert(t, errors.Is(err, ErrCorruptBlock), "ReadAt returns ErrCorruptBlock")
+	clearCache()
+
+	corruptions, err = Verify(ctx, "test-block-id")
+	if err != nil {
+		t.Fatalf("Verify error: %v", err)
+	}
+	SimpleFatalAssert(t, len(corruptions) == 2, "Verify finds corrupted and orphaned blocks")
+	SimpleAssert(t, corruptions[0].Reason == CorruptReason_Checksum, "Checksum mismatch reported")
+	SimpleAssert(t, corruptions[1].Reason == CorruptReason_Orphan, "Orphaned block reported")
+
+	report, err := Fsck(ctx, false)
+	if err != nil {
+		t.Fatalf("Fsck error: %v", err)
+	}
+	SimpleAssert(t, len(report.Corrupted) == 2 && len(report.Repairs) == 0, "Fsck without repair only reports")
+	report, err = Fsck(ctx, true)
+	if err != nil {
+		t.Fatalf("Fsck error: %v", err)
+	}
+	SimpleAssert(t, len(report.Repairs) == 2, "Fsck repairs both blocks")
+	fInfo, err := Stat(ctx, "test-block-id", "file-1")
+	if err != nil {
+		t.Fatalf("Stat error: %v", err)
+	}
+	SimpleAssert(t, fInfo.Size == 0, "Corrupted file truncated")
+	report, err = Fsck(ctx, false)
+	if err != nil {
+		t.Fatalf("Fsck error: %v", err)
+	}
+	SimpleAssert(t, len(report.Corrupted) == 0, "No corruption after repair")
+}
+
+func TestFsckAddsChecksums(t *testing.T) {
+	initTestDb(t)
+	defer cleanupTestDB(t)
+
+	ctx := context.Background()
+	fileOpts := FileOptsType{MaxSize: bigFileSize, Circular: false, IJson: false}
+	err := MakeFile(ctx, "test-block-id", "file-1", make(FileMeta), fileOpts)
+	if err != nil {
+		t.Fatalf("MakeFile error: %v", err)
+	}
+	WithTx(ctx, func(tx *TxWrap) error {
+		query := `UPDATE block_file SET size = ? WHERE blockid = ? AND name = ?`
+		tx.Exec(query, 6, "test-block-id", "file-1")
+		return nil
+	})
+	InsertIntoBlockData(t, ctx, "test-block-id", "file-1", 0, []byte("legacy"))
+	clearCache()
+	report, err := Fsck(ctx, true)
+	if err != nil {
+		t.Fatalf("Fsck error: %v", err)
+	}
+	SimpleAssert(t, len(report.Corrupted) == 0, "Legacy block is not corrupted")
+	SimpleAssert(t, report.ChecksumsAdded == 1, "Checksum added to legacy block")
+	var checksum string
+	WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT checksum FROM block_data WHERE blockid = ? AND name = ?`
+		checksum = tx.GetString(query, "test-block-id", "file-1")
+		return nil
+	})
+	SimpleAssert(t, checksum == blockChecksum([]byte("legacy")), "Correct checksum stored")
+}
+
+func TestGeneratePreview(t *testing.T) {
+	initTestDb(t)
+	defer cleanupTestDB(t)
+
+	ctx := context.Background()
+	img := image.NewNRGBA(image.Rect(0, 0, 600, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 600; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 0x80, A: 0xff})
+		}
+	}
+	var pngBuf bytes.Buffer
+	err := png.Encode(&pngBuf, img)
+	if err != nil {
+		t.Fatalf("png encode error: %v", err)
+	}
+	fileOpts := FileOptsType{MaxSize: bigFileSize, IndexMeta: []string{MetaKey_MimeType}}
+	_, err = WriteFile(ctx, "test-block-id", "output", nil, fileOpts, pngBuf.Bytes())
+	if err != nil {
+		t.Fatalf("WriteFile error: %v", err)
+	}
+	fInfo, err := GeneratePreview(ctx, "test-block-id", "output")
+	if err != nil {
+		t.Fatalf("GeneratePreview error: %v", err)
+	}
+	SimpleAssert(t, fInfo.Meta[MetaKey_MimeType] == "image/png", "image mime type")
+	SimpleAssert(t, fInfo.Meta[MetaKey_Width] == 600 && fInfo.Meta[MetaKey_Height] == 300, "image size")
+	SimpleAssert(t, fInfo.Meta[MetaKey_Thumbnail] == ThumbnailFileName("output"), "thumbnail name")
+	thumbData := readWholeFile(t, ctx, "test-block-id", ThumbnailFileName("output"))
+	thumbImg, err := png.Decode(bytes.NewReader(thumbData))
+	if err != nil {
+		t.Fatalf("thumbnail decode error: %v", err)
+	}
+	SimpleAssert(t, thumbImg.Bounds().Dx() == ThumbnailMaxDim && thumbImg.Bounds().Dy() == ThumbnailMaxDim/2, "thumbnail size")
+	_, _, _, alpha := thumbImg.At(10, 10).RGBA()
+	SimpleAssert(t, alpha == 0xffff, "thumbnail opaque")
+	_, err = GeneratePreview(ctx, "test-block-id", ThumbnailFileName("output"))
+	SimpleAssert(t, err != nil, "no thumbnails of thumbnails")
+
+	pdfData := []byte("%PDF-1.4\n1 0 obj << /Type /Pages /Count 2 >> endobj\n2 0 obj << /Type /Page >> endobj\n3 0 obj << /Type/Page >> endobj\n")
+	_, err = WriteFile(ctx, "test-block-id", "doc", nil, fileOpts, pdfData)
+	if err != nil {
+		t.Fatalf("WriteFile error: %v", err)
+	}
+	fInfo, err = GeneratePreview(ctx, "test-block-id", "doc")
+	if err != nil {
+		t.Fatalf("GeneratePreview error: %v", err)
+	}
+	SimpleAssert(t, fInfo.Meta[MetaKey_MimeType] == MimeType_Pdf && fInfo.Meta[MetaKey_PdfPages] == 2, "pdf preview")
+	SimpleAssert(t, fInfo.Meta[MetaKey_Thumbnail] == nil, "no pdf thumbnail")
+
+	err = FlushCache(ctx)
+	if err != nil {
+		t.Fatalf("FlushCache error: %v", err)
+	}
+	fInfos, _, err := ListFilesFiltered(ctx, "test-block-id", ListFilesOpts{IndexedMeta: map[string]any{MetaKey_MimeType: "image/png"}})
+	if err != nil {
+		t.Fatalf("ListFilesFiltered error: %v", err)
+	}
+	SimpleAssert(t, len(fInfos) == 1 && fInfos[0].Name == "output", "indexed mime type")
+	SimpleAssert(t, DetectMimeType("notes.json", []byte("{}")) == "application/json", "mime type from extension")
+}
@@ -0,0 +1,73 @@
+package blockstore
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+)
+
+// data blocks of files with Opts.Compress are compressed when they are flushed to the db.
+// the codec is stored per block (block_data.codec), so blocks written before compression was
+// enabled (codec "") can always be read back.  blocks that do not shrink are stored uncompressed.
+
+const (
+	Codec_None    = ""
+	Codec_Deflate = "deflate"
+)
+
+// returns the codec and the (possibly) compressed data
+func compressBlock(data []byte) (string, []byte, error) {
+	var buf bytes.Buffer
+	writer, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return Codec_None, nil, err
+	}
+	_, err = writer.Write(data)
+	if err != nil {
+		return Codec_None, nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return Codec_None, nil, err
+	}
+	if buf.Len() >= len(data) {
+		return Codec_None, data, nil
+	}
+	return Codec_Deflate, buf.Bytes(), nil
+}
+
+func decompressBlock(codec string, data []byte) ([]byte, error) {
+	switch codec {
+	case Codec_None:
+		return data, nil
+	case Codec_Deflate:
+		reader := flate.NewReader(bytes.NewReader(data))
+		defer reader.Close()
+		// blocks are at most MaxBlockSize uncompressed
+		rtn, err := io.ReadAll(io.LimitReader(reader, MaxBlockSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing block: %v", err)
+		}
+		return rtn, nil
+	default:
+		return nil, fmt.Errorf("unknown block codec %q", codec)
+	}
+}
+
+// matches sqlite substr(data, start, length) semantics (1-based, start 0 returns length-1 bytes)
+func substrBytes(data []byte, start int64, length int64) []byte {
+	if start == 0 {
+		length--
+	} else if start > 0 {
+		start--
+	}
+	if start < 0 || length <= 0 || start >= int64(len(data)) {
+		return []byte{}
+	}
+	end := start + length
+	if end > int64(len(data)) {
+		end = int64(len(data))
+	}
+	return data[start:end]
+}
@@ -0,0 +1,218 @@
+package blockstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+)
+
+// every data block is stored with a crc32 (castagnoli) checksum of its stored (possibly compressed) bytes.
+// blocks written before checksums were added have checksum "", they are not verified on read
+// (Fsck with repair adds their checksums).  reading a corrupted block returns ErrCorruptBlock.
+
+var ErrCorruptBlock = errors.New("corrupted blockstore data block")
+
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+const (
+	CorruptReason_Checksum   = "checksum mismatch"
+	CorruptReason_Decompress = "cannot decompress"
+	CorruptReason_Orphan     = "block has no file"
+	CorruptReason_OutOfRange = "block is past the end of the file"
+)
+
+type BlockCorruption struct {
+	BlockId string
+	Name    string
+	PartIdx int
+	Reason  string
+}
+
+func (c *BlockCorruption) String() string {
+	return fmt.Sprintf("%s/%s block %d: %s", c.BlockId, c.Name, c.PartIdx, c.Reason)
+}
+
+type FsckReport struct {
+	FilesChecked   int
+	BlocksChecked  int
+	Corrupted      []*BlockCorruption
+	Repairs        []string
+	ChecksumsAdded int
+}
+
+type blockDataRef struct {
+	BlockId string
+	Name    string
+	PartIdx int
+}
+
+func blockChecksum(data []byte) string {
+	return fmt.Sprintf("%08x", crc32.Checksum(data, checksumTable))
+}
+
+// number of block indexes a file can use (blocks with a greater partidx are stale)
+func fileMaxBlocks(fInfo *FileInfo) int64 {
+	size := fInfo.Size
+	if fInfo.Opts.Circular && fInfo.Opts.MaxSize > 0 {
+		size = fInfo.Opts.MaxSize
+	}
+	return (size + MaxBlockSize - 1) / MaxBlockSize
+}
+
+// returns the corruption reason for the block, or "" if the block is ok.  missingChecksum is true for
+// (legacy) blocks stored without a checksum.
+func checkDataBlock(tx *TxWrap, ref blockDataRef) (reason string, missingChecksum bool) {
+	query := `SELECT data, codec, checksum FROM block_data WHERE blockid = ? AND name = ? AND partidx = ?`
+	m := tx.GetMap(query, ref.BlockId, ref.Name, ref.PartIdx)
+	var data []byte
+	var codec, checksum string
+	dbutil.QuickSetBytes(&data, m, "data")
+	dbutil.QuickSetStr(&codec, m, "codec")
+	dbutil.QuickSetStr(&checksum, m, "checksum")
+	if checksum != "" && blockChecksum(data) != checksum {
+		return CorruptReason_Checksum, false
+	}
+	if _, err := decompressBlock(codec, data); err != nil {
+		return CorruptReason_Decompress, checksum == ""
+	}
+	return "", checksum == ""
+}
+
+func verifyBlocks(ctx context.Context, blockId string, report *FsckReport) (map[string]*FileInfo, map[blockDataRef]bool, error) {
+	var files []*FileInfo
+	var err error
+	if blockId == "" {
+		files, err = GetAllFilesInDB(ctx)
+	} else {
+		files, err = GetAllFilesInDBForBlockId(ctx, blockId)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	fileMap := make(map[string]*FileInfo)
+	for _, fInfo := range files {
+		fileMap[GetCacheId(fInfo.BlockId, fInfo.Name)] = fInfo
+	}
+	report.FilesChecked = len(files)
+	missingChecksums := make(map[blockDataRef]bool)
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		var refs []blockDataRef
+		query := `SELECT blockid, name, partidx FROM block_data WHERE ? = '' OR blockid = ? ORDER BY blockid, name, partidx`
+		for _, m := range tx.SelectMaps(query, blockId, blockId) {
+			var ref blockDataRef
+			dbutil.QuickSetStr(&ref.BlockId, m, "blockid")
+			dbutil.QuickSetStr(&ref.Name, m, "name")
+			dbutil.QuickSetInt(&ref.PartIdx, m, "partidx")
+			refs = append(refs, ref)
+		}
+		for _, ref := range refs {
+			report.BlocksChecked++
+			fInfo := fileMap[GetCacheId(ref.BlockId, ref.Name)]
+			var reason string
+			if fInfo == nil {
+				reason = CorruptReason_Orphan
+			} else if int64(ref.PartIdx) >= fileMaxBlocks(fInfo) {
+				reason = CorruptReason_OutOfRange
+			} else {
+				var missingChecksum bool
+				reason, missingChecksum = checkDataBlock(tx, ref)
+				if missingChecksum && reason == "" {
+					missingChecksums[ref] = true
+				}
+			}
+			if reason != "" {
+				report.Corrupted = append(report.Corrupted, &BlockCorruption{BlockId: ref.BlockId, Name: ref.Name, PartIdx: ref.PartIdx, Reason: reason})
+			}
+		}
+		return nil
+	})
+	if txErr != nil {
+		return nil, nil, txErr
+	}
+	return fileMap, missingChecksums, nil
+}
+
+// verifies the checksums and layout of all data blocks of blockId, returns the corrupted blocks
+func Verify(ctx context.Context, blockId string) ([]*BlockCorruption, error) {
+	if blockId == "" {
+		return nil, fmt.Errorf("blockid cannot be empty")
+	}
+	err := FlushCache(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &FsckReport{}
+	_, _, err = verifyBlocks(ctx, blockId, report)
+	if err != nil {
+		return nil, err
+	}
+	return report.Corrupted, nil
+}
+
+// checks all files in the blockstore.  with repair, orphaned and out of range blocks are removed,
+// files with a corrupted block are truncated to the start of the block (circular files lose only the
+// corrupted block), and checksums are added to blocks that do not have one.
+func Fsck(ctx context.Context, repair bool) (*FsckReport, error) {
+	err := FlushCache(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &FsckReport{}
+	fileMap, missingChecksums, err := verifyBlocks(ctx, "", report)
+	if err != nil {
+		return nil, err
+	}
+	if !repair {
+		return report, nil
+	}
+	repairedFiles := make(map[string]bool)
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		for _, corruption := range report.Corrupted {
+			cacheId := GetCacheId(corruption.BlockId, corruption.Name)
+			fInfo := fileMap[cacheId]
+			if fInfo == nil || fInfo.Opts.Circular || corruption.Reason == CorruptReason_OutOfRange {
+				query := `DELETE FROM block_data WHERE blockid = ? AND name = ? AND partidx = ?`
+				tx.Exec(query, corruption.BlockId, corruption.Name, corruption.PartIdx)
+				report.Repairs = append(report.Repairs, fmt.Sprintf("removed %s/%s block %d", corruption.BlockId, corruption.Name, corruption.PartIdx))
+				repairedFiles[cacheId] = true
+				continue
+			}
+			newSize := int64(corruption.PartIdx) * MaxBlockSize
+			if newSize >= fInfo.Size {
+				// already truncated by an earlier corrupted block
+				continue
+			}
+			query := `DELETE FROM block_data WHERE blockid = ? AND name = ? AND partidx >= ?`
+			tx.Exec(query, corruption.BlockId, corruption.Name, corruption.PartIdx)
+			query = `UPDATE block_file SET size = ?, modts = ? WHERE blockid = ? AND name = ?`
+			tx.Exec(query, newSize, time.Now().UnixMilli(), corruption.BlockId, corruption.Name)
+			report.Repairs = append(report.Repairs, fmt.Sprintf("truncated %s/%s from %d to %d bytes", corruption.BlockId, corruption.Name, fInfo.Size, newSize))
+			fInfo.Size = newSize
+			repairedFiles[cacheId] = true
+		}
+		for ref := range missingChecksums {
+			var data []byte
+			query := `SELECT data FROM block_data WHERE blockid = ? AND name = ? AND partidx = ?`
+			if !tx.Get(&data, query, ref.BlockId, ref.Name, ref.PartIdx) {
+				// removed by a truncation above
+				continue
+			}
+			query = `UPDATE block_data SET checksum = ? WHERE blockid = ? AND name = ? AND partidx = ?`
+			tx.Exec(query, blockChecksum(data), ref.BlockId, ref.Name, ref.PartIdx)
+			report.ChecksumsAdded++
+		}
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	for cacheId := range repairedFiles {
+		blockId, name := GetValuesFromCacheId(cacheId)
+		DeleteCacheEntry(ctx, blockId, name)
+	}
+	return report, nil
+}
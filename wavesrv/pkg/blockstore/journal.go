@@ -0,0 +1,367 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package blockstore
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// crash consistency for SyncPolicy_Journal.  each write is appended to a journal file next to the db (and synced)
+// before it goes to the cache, so writes that were not flushed to the db yet are replayed after a crash
+// (ReplayJournal, on startup).  a cache flush starts a new journal segment, the old segments are deleted once the
+// flush succeeded.  meta updates (WriteMeta) are journaled the same way, in order with the writes.  deleted and renamed files get a drop record, replay skips the earlier writes to them (their
+// data was deleted, or flushed before the rename).  a torn record at the end of a segment (a crash during the
+// journal write) is ignored, that write was never acknowledged.
+
+const JournalSuffix = ".journal"
+const journalHeaderLen = 8 // payload length + crc32 of the payload
+
+const (
+	journalRec_Write = 'W'
+	journalRec_Drop  = 'D' // name "" drops the whole block
+	journalRec_Meta  = 'M' // data is the json encoded file meta
+)
+
+type journalRecord struct {
+	Type    byte
+	BlockId string
+	Name    string
+	Off     int64
+	Data    []byte
+}
+
+type journalState struct {
+	Lock     *sync.Mutex // guards all fields
+	Inited   bool        // NextSeq is past the segments on disk
+	File     *os.File    // current segment, opened by the first record
+	NextSeq  int
+	Segments []string // segments that are not deleted yet (in order), the current one is last
+}
+
+var journal = &journalState{Lock: &sync.Mutex{}}
+
+// journaled writes hold it shared from the journal record until the data is in the cache, flushes take it
+// exclusive to start a new segment, so all the writes in the old segments are in the cache when the flush starts
+var journalRotateLock = &sync.RWMutex{}
+
+func journalSegmentPath(seq int) string {
+	return fmt.Sprintf("%s%s.%d", GetDBName(), JournalSuffix, seq)
+}
+
+// returns the segment files on disk and their seqs, in order
+func listJournalSegments() ([]string, []int, error) {
+	prefix := GetDBName() + JournalSuffix + "."
+	matches, err := filepath.Glob(prefix + "*")
+	if err != nil {
+		return nil, nil, err
+	}
+	var seqs []int
+	segMap := make(map[int]string)
+	for _, match := range matches {
+		seq, err := strconv.Atoi(strings.TrimPrefix(match, prefix))
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+		segMap[seq] = match
+	}
+	sort.Ints(seqs)
+	var segs []string
+	for _, seq := range seqs {
+		segs = append(segs, segMap[seq])
+	}
+	return segs, seqs, nil
+}
+
+func encodeJournalRecord(rec journalRecord) []byte {
+	payload := make([]byte, 0, 1+3*binary.MaxVarintLen64+len(rec.BlockId)+len(rec.Name)+len(rec.Data))
+	payload = append(payload, rec.Type)
+	payload = binary.AppendUvarint(payload, uint64(len(rec.BlockId)))
+	payload = append(payload, rec.BlockId...)
+	payload = binary.AppendUvarint(payload, uint64(len(rec.Name)))
+	payload = append(payload, rec.Name...)
+	payload = binary.AppendVarint(payload, rec.Off)
+	payload = append(payload, rec.Data...)
+	rtn := make([]byte, journalHeaderLen, journalHeaderLen+len(payload))
+	binary.LittleEndian.PutUint32(rtn[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(rtn[4:8], crc32.ChecksumIEEE(payload))
+	return append(rtn, payload...)
+}
+
+func decodeJournalPayload(payload []byte) (journalRecord, error) {
+	var rec journalRecord
+	if len(payload) == 0 {
+		return rec, fmt.Errorf("empty record")
+	}
+	rec.Type = payload[0]
+	pos := 1
+	readStr := func() (string, error) {
+		strLen, n := binary.Uvarint(payload[pos:])
+		if n <= 0 || uint64(len(payload)-pos-n) < strLen {
+			return "", fmt.Errorf("invalid string length")
+		}
+		pos += n
+		str := string(payload[pos : pos+int(strLen)])
+		pos += int(strLen)
+		return str, nil
+	}
+	var err error
+	if rec.BlockId, err = readStr(); err != nil {
+		return rec, err
+	}
+	if rec.Name, err = readStr(); err != nil {
+		return rec, err
+	}
+	off, n := binary.Varint(payload[pos:])
+	if n <= 0 {
+		return rec, fmt.Errorf("invalid offset")
+	}
+	rec.Off = off
+	rec.Data = payload[pos+n:]
+	return rec, nil
+}
+
+// decodes the records up to the first torn or corrupt record (returned as the error)
+func decodeJournalRecords(data []byte) ([]journalRecord, error) {
+	var rtn []journalRecord
+	for len(data) > 0 {
+		if len(data) < journalHeaderLen {
+			return rtn, fmt.Errorf("torn record header")
+		}
+		payloadLen := int(binary.LittleEndian.Uint32(data[0:4]))
+		checksum := binary.LittleEndian.Uint32(data[4:8])
+		if len(data)-journalHeaderLen < payloadLen {
+			return rtn, fmt.Errorf("torn record")
+		}
+		payload := data[journalHeaderLen : journalHeaderLen+payloadLen]
+		if crc32.ChecksumIEEE(payload) != checksum {
+			return rtn, fmt.Errorf("record checksum mismatch")
+		}
+		rec, err := decodeJournalPayload(payload)
+		if err != nil {
+			return rtn, err
+		}
+		rtn = append(rtn, rec)
+		data = data[journalHeaderLen+payloadLen:]
+	}
+	return rtn, nil
+}
+
+// must hold journal.Lock
+func (j *journalState) initLocked() {
+	if j.Inited {
+		return
+	}
+	j.Inited = true
+	segs, seqs, err := listJournalSegments()
+	if err != nil {
+		log.Printf("[blockstore] error listing journal segments: %v\n", err)
+		return
+	}
+	if len(seqs) > 0 {
+		j.NextSeq = seqs[len(seqs)-1] + 1
+	}
+	// left by a crash and not replayed, they stay until a flush succeeds
+	j.Segments = segs
+}
+
+// must hold journal.Lock, the record is synced to disk when this returns
+func (j *journalState) appendRecordLocked(rec journalRecord) error {
+	j.initLocked()
+	if j.File == nil {
+		segPath := journalSegmentPath(j.NextSeq)
+		fd, err := os.OpenFile(segPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("cannot open journal segment: %w", err)
+		}
+		j.NextSeq++
+		j.File = fd
+		j.Segments = append(j.Segments, segPath)
+	}
+	_, err := j.File.Write(encodeJournalRecord(rec))
+	if err != nil {
+		return fmt.Errorf("cannot write journal: %w", err)
+	}
+	err = j.File.Sync()
+	if err != nil {
+		return fmt.Errorf("cannot sync journal: %w", err)
+	}
+	return nil
+}
+
+func writeJournalRecord(rec journalRecord) error {
+	journal.Lock.Lock()
+	defer journal.Lock.Unlock()
+	return journal.appendRecordLocked(rec)
+}
+
+// records a deleted or renamed file (name "" for the whole block), only while there are journal segments
+func journalDrop(blockId string, name string) {
+	journal.Lock.Lock()
+	defer journal.Lock.Unlock()
+	journal.initLocked()
+	if len(journal.Segments) == 0 && GetFlushConfig().SyncPolicy != SyncPolicy_Journal {
+		return
+	}
+	err := journal.appendRecordLocked(journalRecord{Type: journalRec_Drop, BlockId: blockId, Name: name})
+	if err != nil {
+		log.Printf("[blockstore] error journaling drop of %s/%s: %v\n", blockId, name, err)
+	}
+}
+
+// writes the journal record, then writes to the cache
+func journaledWriteAt(ctx context.Context, blockId string, name string, p []byte, off int64) (int, error) {
+	journalRotateLock.RLock()
+	defer journalRotateLock.RUnlock()
+	err := writeJournalRecord(journalRecord{Type: journalRec_Write, BlockId: blockId, Name: name, Off: off, Data: p})
+	if err != nil {
+		return 0, fmt.Errorf("WriteAt journal error: %v", err)
+	}
+	return WriteAtHelper(ctx, blockId, name, p, off, false)
+}
+
+// writes the journal record, then sets the meta in the cache
+func journaledWriteMeta(ctx context.Context, blockId string, name string, meta FileMeta) error {
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("WriteMeta error encoding meta: %v", err)
+	}
+	journalRotateLock.RLock()
+	defer journalRotateLock.RUnlock()
+	// a missing file is an error, nothing is journaled
+	_, err = statHelper(ctx, blockId, name)
+	if err != nil {
+		return err
+	}
+	err = writeJournalRecord(journalRecord{Type: journalRec_Meta, BlockId: blockId, Name: name, Data: metaBytes})
+	if err != nil {
+		return fmt.Errorf("WriteMeta journal error: %v", err)
+	}
+	return writeMetaHelper(ctx, blockId, name, meta)
+}
+
+// closes the current segment (the next record starts a new one), returns the segments covered by a flush that
+// starts now
+func rotateJournal() []string {
+	journalRotateLock.Lock()
+	defer journalRotateLock.Unlock()
+	journal.Lock.Lock()
+	defer journal.Lock.Unlock()
+	if journal.File != nil {
+		journal.File.Close()
+		journal.File = nil
+	}
+	rtn := journal.Segments
+	journal.Segments = nil
+	return rtn
+}
+
+// after the flush: flushed (remove the segments) or not (keep them for the next flush)
+func finishJournalRotate(segs []string, flushed bool) {
+	if len(segs) == 0 {
+		return
+	}
+	journal.Lock.Lock()
+	defer journal.Lock.Unlock()
+	if !flushed {
+		journal.Segments = append(segs, journal.Segments...)
+		return
+	}
+	for _, segPath := range segs {
+		err := os.Remove(segPath)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("[blockstore] error removing journal segment %s: %v\n", segPath, err)
+		}
+	}
+}
+
+// skips the writes (and meta updates) that are followed by a drop of their file (or block)
+func filterDroppedJournalRecords(recs []journalRecord) []journalRecord {
+	droppedFiles := make(map[string]bool)
+	droppedBlocks := make(map[string]bool)
+	var rtn []journalRecord
+	for idx := len(recs) - 1; idx >= 0; idx-- {
+		rec := recs[idx]
+		if rec.Type == journalRec_Drop {
+			if rec.Name == "" {
+				droppedBlocks[rec.BlockId] = true
+			} else {
+				droppedFiles[GetCacheId(rec.BlockId, rec.Name)] = true
+			}
+			continue
+		}
+		if (rec.Type != journalRec_Write && rec.Type != journalRec_Meta) || droppedBlocks[rec.BlockId] || droppedFiles[GetCacheId(rec.BlockId, rec.Name)] {
+			continue
+		}
+		rtn = append(rtn, rec)
+	}
+	for i, j := 0, len(rtn)-1; i < j; i, j = i+1, j-1 {
+		rtn[i], rtn[j] = rtn[j], rtn[i]
+	}
+	return rtn
+}
+
+func replayJournalMeta(ctx context.Context, rec journalRecord) error {
+	var meta FileMeta
+	err := json.Unmarshal(rec.Data, &meta)
+	if err != nil {
+		return fmt.Errorf("invalid meta: %v", err)
+	}
+	return writeMetaHelper(ctx, rec.BlockId, rec.Name, meta)
+}
+
+// replays the writes and meta updates in the journal segments left by a crash and flushes them to the db (then the segments are
+// deleted).  called on startup (after MigrateBlockstore), before anything writes to the blockstore.  writes to
+// files that no longer exist are skipped.  returns the number of records replayed.
+func ReplayJournal(ctx context.Context) (int, error) {
+	journal.Lock.Lock()
+	journal.initLocked()
+	segs := journal.Segments
+	journal.Lock.Unlock()
+	if len(segs) == 0 {
+		return 0, nil
+	}
+	var recs []journalRecord
+	for _, segPath := range segs {
+		data, err := os.ReadFile(segPath)
+		if err != nil {
+			return 0, fmt.Errorf("ReplayJournal error reading %s: %w", segPath, err)
+		}
+		segRecs, err := decodeJournalRecords(data)
+		if err != nil {
+			log.Printf("[blockstore] journal %s: %v, skipping the rest of the segment\n", segPath, err)
+		}
+		recs = append(recs, segRecs...)
+	}
+	var numReplayed int
+	for _, rec := range filterDroppedJournalRecords(recs) {
+		var err error
+		if rec.Type == journalRec_Meta {
+			err = replayJournalMeta(ctx, rec)
+		} else {
+			_, err = WriteAtHelper(ctx, rec.BlockId, rec.Name, rec.Data, rec.Off, false)
+		}
+		if err != nil {
+			log.Printf("[blockstore] journal replay, skipping record for %s/%s: %v\n", rec.BlockId, rec.Name, err)
+			continue
+		}
+		numReplayed++
+	}
+	err := flushCacheHelper(ctx)
+	if err != nil {
+		return numReplayed, fmt.Errorf("ReplayJournal error flushing: %w", err)
+	}
+	return numReplayed, nil
+}
@@ -0,0 +1,80 @@
+package blockstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// secondary index of file meta.  the meta is stored as an opaque json blob, files declare the meta keys to index
+// (FileOptsType.IndexMeta) and the scalar values of those keys are kept in block_file_metaidx, so
+// ListFilesOpts.IndexedMeta can find the files in a block by value without decoding the meta of every file.
+
+const MaxIndexMetaKeys = 16
+const MaxIndexMetaKeyLen = 200
+
+func validateIndexMeta(keys []string) error {
+	if len(keys) > MaxIndexMetaKeys {
+		return fmt.Errorf("too many indexed meta keys (max %d)", MaxIndexMetaKeys)
+	}
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if key == "" || len(key) > MaxIndexMetaKeyLen {
+			return fmt.Errorf("invalid indexed meta key %q", key)
+		}
+		if seen[key] {
+			return fmt.Errorf("duplicate indexed meta key %q", key)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// values are stored json encoded, so "5" (string) and 5 (number) are different values.  only scalar values
+// (strings, numbers and bools) are indexed.
+func metaIndexValue(val any) (string, bool) {
+	switch val.(type) {
+	case string, bool, float64, float32, int, int64, int32, uint, uint64, uint32, json.Number:
+		barr, err := json.Marshal(val)
+		if err != nil {
+			return "", false
+		}
+		return string(barr), true
+	default:
+		return "", false
+	}
+}
+
+// replaces the index rows of the file (called in the same tx as the block_file write)
+func updateMetaIndexTx(tx *TxWrap, fileInfo FileInfo) {
+	query := `DELETE FROM block_file_metaidx WHERE blockid = ? AND name = ?`
+	tx.Exec(query, fileInfo.BlockId, fileInfo.Name)
+	for _, key := range fileInfo.Opts.IndexMeta {
+		val, ok := metaIndexValue(fileInfo.Meta[key])
+		if !ok {
+			continue
+		}
+		query = `INSERT INTO block_file_metaidx (blockid, name, metakey, metavalue) VALUES (?, ?, ?, ?)`
+		tx.Exec(query, fileInfo.BlockId, fileInfo.Name, key, val)
+	}
+}
+
+// returns the sql condition (and args) for ListFilesOpts.IndexedMeta, "" if there are no conditions
+func indexedMetaCond(blockId string, indexedMeta map[string]any) (string, []interface{}, error) {
+	keys := make([]string, 0, len(indexedMeta))
+	for key := range indexedMeta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var cond string
+	var args []interface{}
+	for _, key := range keys {
+		val, ok := metaIndexValue(indexedMeta[key])
+		if !ok {
+			return "", nil, fmt.Errorf("invalid value for indexed meta key %q (must be a string, number or bool)", key)
+		}
+		cond += ` AND name IN (SELECT name FROM block_file_metaidx WHERE blockid = ? AND metakey = ? AND metavalue = ?)`
+		args = append(args, blockId, key, val)
+	}
+	return cond, args, nil
+}
@@ -0,0 +1,180 @@
+package blockstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// mime type detection and previews for stored files (artifacts).  GeneratePreview detects the mime type of a file
+// (content sniffing, then the file extension) and stores it in the file meta.  images (png, jpeg, gif) get a
+// png thumbnail (at most ThumbnailMaxDim pixels wide/high) stored alongside as "thumb:[name]", pdfs get their
+// page count.  there is no pdf renderer, so pdfs have no thumbnail.
+
+const (
+	MetaKey_MimeType  = "mimetype"
+	MetaKey_Thumbnail = "thumbnail" // name of the thumbnail file
+	MetaKey_Width     = "width"     // image width/height in pixels
+	MetaKey_Height    = "height"
+	MetaKey_PdfPages  = "pdfpages"
+)
+
+const ThumbnailFilePrefix = "thumb:"
+const ThumbnailMaxDim = 256
+const MaxPreviewSourceSize = 32 * 1024 * 1024
+const MaxThumbnailPixels = 50 * 1000 * 1000 // larger images are not decoded
+
+const MimeType_Binary = "application/octet-stream"
+const MimeType_Pdf = "application/pdf"
+
+var pdfPageRe = regexp.MustCompile(`/Type\s*/Page[^s]`)
+
+func ThumbnailFileName(name string) string {
+	return ThumbnailFilePrefix + name
+}
+
+// content sniffing (http.DetectContentType) first, the extension is used when the content is not recognized
+func DetectMimeType(name string, data []byte) string {
+	mimeType := http.DetectContentType(data)
+	if mimeType == MimeType_Binary || strings.HasPrefix(mimeType, "text/plain") {
+		if extType := mime.TypeByExtension(filepath.Ext(name)); extType != "" {
+			return extType
+		}
+	}
+	return mimeType
+}
+
+// scales the image down (area averaging) to fit in maxDim x maxDim, images that fit are only converted
+func makeThumbnailImage(img image.Image, maxDim int) image.Image {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	dstW, dstH := srcW, srcH
+	if srcW > maxDim || srcH > maxDim {
+		if srcW >= srcH {
+			dstW, dstH = maxDim, max(1, srcH*maxDim/srcW)
+		} else {
+			dstW, dstH = max(1, srcW*maxDim/srcH), maxDim
+		}
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+	for dy := 0; dy < dstH; dy++ {
+		sy0, sy1 := bounds.Min.Y+dy*srcH/dstH, bounds.Min.Y+max((dy+1)*srcH/dstH, dy*srcH/dstH+1)
+		for dx := 0; dx < dstW; dx++ {
+			sx0, sx1 := bounds.Min.X+dx*srcW/dstW, bounds.Min.X+max((dx+1)*srcW/dstW, dx*srcW/dstW+1)
+			var r, g, b, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			if a == 0 {
+				continue
+			}
+			// average the premultiplied values, then un-premultiply
+			dst.SetNRGBA(dx, dy, color.NRGBA{
+				R: uint8(r * 0xff / a),
+				G: uint8(g * 0xff / a),
+				B: uint8(b * 0xff / a),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// returns the png thumbnail and the size of the image
+func makeImageThumbnail(data []byte) ([]byte, int, int, error) {
+	imgConfig, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if imgConfig.Width*imgConfig.Height > MaxThumbnailPixels {
+		return nil, imgConfig.Width, imgConfig.Height, fmt.Errorf("image too large (%dx%d)", imgConfig.Width, imgConfig.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	var buf bytes.Buffer
+	err = png.Encode(&buf, makeThumbnailImage(img, ThumbnailMaxDim))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return buf.Bytes(), imgConfig.Width, imgConfig.Height, nil
+}
+
+func countPdfPages(data []byte) int {
+	return len(pdfPageRe.FindAllIndex(data, -1))
+}
+
+// detects the mime type of the file and generates its preview (see above), returns the updated file info.
+// the file must not be written to while the preview is generated.  files larger than MaxPreviewSourceSize
+// only get the mime type (from the first bytes).
+func GeneratePreview(ctx context.Context, blockId string, name string) (*FileInfo, error) {
+	if strings.HasPrefix(name, ThumbnailFilePrefix) {
+		return nil, fmt.Errorf("GeneratePreview error: %q is a thumbnail", name)
+	}
+	fInfo, err := Stat(ctx, blockId, name)
+	if err != nil {
+		return nil, err
+	}
+	if fInfo.Opts.Circular {
+		return nil, fmt.Errorf("GeneratePreview error: cannot preview circular file %q", name)
+	}
+	readSize := min(fInfo.Size, MaxPreviewSourceSize)
+	if fInfo.Size > MaxPreviewSourceSize {
+		readSize = 512
+	}
+	data := make([]byte, readSize)
+	if readSize > 0 {
+		_, err = ReadAt(ctx, blockId, name, &data, 0)
+		if err != nil {
+			return nil, fmt.Errorf("GeneratePreview error reading %q: %w", name, err)
+		}
+	}
+	meta := DeepCopyFileInfo(fInfo).Meta
+	for _, key := range []string{MetaKey_Thumbnail, MetaKey_Width, MetaKey_Height, MetaKey_PdfPages} {
+		delete(meta, key)
+	}
+	thumbName := ThumbnailFileName(name)
+	DeleteFile(ctx, blockId, thumbName) // ignore error, may not exist
+	mimeType := DetectMimeType(name, data)
+	meta[MetaKey_MimeType] = mimeType
+	if fInfo.Size <= MaxPreviewSourceSize {
+		switch {
+		case strings.HasPrefix(mimeType, "image/"):
+			thumbData, width, height, err := makeImageThumbnail(data)
+			if width > 0 {
+				meta[MetaKey_Width] = width
+				meta[MetaKey_Height] = height
+			}
+			if err == nil {
+				thumbMeta := FileMeta{MetaKey_MimeType: "image/png"}
+				_, err = WriteFile(ctx, blockId, thumbName, thumbMeta, FileOptsType{MaxSize: int64(len(thumbData))}, thumbData)
+				if err != nil {
+					return nil, fmt.Errorf("GeneratePreview error writing thumbnail: %w", err)
+				}
+				meta[MetaKey_Thumbnail] = thumbName
+			}
+		case mimeType == MimeType_Pdf:
+			meta[MetaKey_PdfPages] = countPdfPages(data)
+		}
+	}
+	err = WriteMeta(ctx, blockId, name, meta)
+	if err != nil {
+		return nil, err
+	}
+	return Stat(ctx, blockId, name)
+}
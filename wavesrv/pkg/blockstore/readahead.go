@@ -0,0 +1,189 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package blockstore
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// read-ahead for sequential reads (pty playback, file downloads).  when a file is read sequentially (each ReadAt
+// starts where the last one ended), the following blocks are loaded into the cache in the background by a small
+// pool of workers.  requests are dropped (not queued) when the pool is busy, the read just falls back to the db.
+
+const ReadAheadBlocks = 2      // number of blocks to load ahead of the reader
+const ReadAheadMinSeqReads = 2 // number of sequential reads before read-ahead starts
+const readAheadWorkers = 2
+const readAheadQueueSize = 16
+
+type readAheadRequest struct {
+	BlockId  string
+	Name     string
+	CacheNum int
+}
+
+type ReadAheadStats struct {
+	Queued  int64 `json:"queued"`
+	Dropped int64 `json:"dropped"`
+	Loaded  int64 `json:"loaded"`
+	Hits    int64 `json:"hits"`   // reads served from a read-ahead block
+	Misses  int64 `json:"misses"` // reads that had to load the block from the db
+}
+
+func (s ReadAheadStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+var readAheadEnabled atomic.Bool
+var readAheadOnce = &sync.Once{}
+var readAheadCh = make(chan readAheadRequest, readAheadQueueSize)
+var readAheadPendingLock = &sync.Mutex{}
+var readAheadPending = make(map[readAheadRequest]bool) // queued or loading
+var readAheadQueued atomic.Int64
+var readAheadDropped atomic.Int64
+var readAheadLoaded atomic.Int64
+var readAheadHits atomic.Int64
+var readAheadMisses atomic.Int64
+
+func init() {
+	readAheadEnabled.Store(true)
+}
+
+func SetReadAheadEnabled(enabled bool) {
+	readAheadEnabled.Store(enabled)
+}
+
+func GetReadAheadStats() ReadAheadStats {
+	return ReadAheadStats{
+		Queued:  readAheadQueued.Load(),
+		Dropped: readAheadDropped.Load(),
+		Loaded:  readAheadLoaded.Load(),
+		Hits:    readAheadHits.Load(),
+		Misses:  readAheadMisses.Load(),
+	}
+}
+
+// for testing
+func resetReadAheadStats() {
+	readAheadQueued.Store(0)
+	readAheadDropped.Store(0)
+	readAheadLoaded.Store(0)
+	readAheadHits.Store(0)
+	readAheadMisses.Store(0)
+}
+
+func startReadAheadWorkers() {
+	for i := 0; i < readAheadWorkers; i++ {
+		go readAheadWorker()
+	}
+}
+
+func readAheadWorker() {
+	for req := range readAheadCh {
+		err := loadReadAheadBlock(context.Background(), req)
+		if err != nil {
+			log.Printf("[blockstore] read-ahead error %s/%s block %d: %v\n", req.BlockId, req.Name, req.CacheNum, err)
+		}
+		readAheadPendingLock.Lock()
+		delete(readAheadPending, req)
+		readAheadPendingLock.Unlock()
+	}
+}
+
+// the block is read from the db under the entry lock, so a concurrent write or flush cannot be overwritten
+// with stale data
+func loadReadAheadBlock(ctx context.Context, req readAheadRequest) error {
+	cacheEntry, found := GetCacheEntry(ctx, req.BlockId, req.Name)
+	if !found {
+		return nil
+	}
+	cacheEntry.Lock.Lock()
+	defer cacheEntry.Lock.Unlock()
+	if req.CacheNum < len(cacheEntry.DataBlocks) && cacheEntry.DataBlocks[req.CacheNum] != nil {
+		return nil
+	}
+	cacheData, err := GetCacheFromDB(ctx, req.BlockId, req.Name, 0, MaxBlockSize, int64(req.CacheNum))
+	if err != nil {
+		return err
+	}
+	for len(cacheEntry.DataBlocks) < req.CacheNum+1 {
+		cacheEntry.DataBlocks = append(cacheEntry.DataBlocks, nil)
+	}
+	cacheEntry.DataBlocks[req.CacheNum] = &CacheBlock{data: *cacheData, size: len(*cacheData), readAhead: true}
+	readAheadLoaded.Add(1)
+	return nil
+}
+
+func queueReadAhead(req readAheadRequest) {
+	readAheadOnce.Do(startReadAheadWorkers)
+	readAheadPendingLock.Lock()
+	defer readAheadPendingLock.Unlock()
+	if readAheadPending[req] {
+		return
+	}
+	select {
+	case readAheadCh <- req:
+		readAheadPending[req] = true
+		readAheadQueued.Add(1)
+	default:
+		readAheadDropped.Add(1)
+	}
+}
+
+// counts the hit or miss for a block about to be read (call before GetCacheBlock)
+func noteBlockRead(cacheEntry *CacheEntry, cacheNum int) {
+	cacheEntry.Lock.Lock()
+	defer cacheEntry.Lock.Unlock()
+	if cacheNum >= len(cacheEntry.DataBlocks) || cacheEntry.DataBlocks[cacheNum] == nil {
+		readAheadMisses.Add(1)
+		return
+	}
+	block := cacheEntry.DataBlocks[cacheNum]
+	if block.readAhead {
+		block.readAhead = false
+		readAheadHits.Add(1)
+	}
+}
+
+// called after a read of [off, off+numRead), tracks sequential access and queues the read-ahead of the
+// blocks after the read
+func readAheadAfterRead(ctx context.Context, blockId string, name string, off int64, numRead int) {
+	if !readAheadEnabled.Load() || numRead <= 0 {
+		return
+	}
+	cacheEntry, found := GetCacheEntry(ctx, blockId, name)
+	if !found {
+		return
+	}
+	endPos := off + int64(numRead)
+	var toLoad []int
+	cacheEntry.Lock.Lock()
+	if off == cacheEntry.lastReadEnd {
+		cacheEntry.seqReads++
+	} else {
+		cacheEntry.seqReads = 0
+	}
+	cacheEntry.lastReadEnd = endPos
+	if cacheEntry.seqReads >= ReadAheadMinSeqReads {
+		lastBlock := int((endPos - 1) / MaxBlockSize)
+		for cacheNum := lastBlock + 1; cacheNum <= lastBlock+ReadAheadBlocks; cacheNum++ {
+			if int64(cacheNum)*MaxBlockSize >= cacheEntry.Info.Size {
+				break
+			}
+			if cacheNum < len(cacheEntry.DataBlocks) && cacheEntry.DataBlocks[cacheNum] != nil {
+				continue
+			}
+			toLoad = append(toLoad, cacheNum)
+		}
+	}
+	cacheEntry.Lock.Unlock()
+	for _, cacheNum := range toLoad {
+		queueReadAhead(readAheadRequest{BlockId: blockId, Name: name, CacheNum: cacheNum})
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package blockstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+)
+
+// age based deletion of files.  the age of a file is the time since its last write (or rename).  PruneBlockFiles
+// prunes one block, the retention rules (RegisterRetentionRule) are global: the owners of files (renderers, the
+// pty capture) register a rule for their file names once, and ApplyRetentionRules (run periodically by the server)
+// prunes the matching files in all blocks.
+
+type RetentionRule struct {
+	NameGlob string        // sqlite glob (like ListFilesOpts.NameGlob), "" matches all
+	MaxAge   time.Duration // files not written for longer are deleted
+	Locker   sync.Locker   // optional, held while the rule's files are pruned (the owner's lock for the files)
+}
+
+var retentionLock = &sync.Mutex{}
+var retentionRules = make(map[string]RetentionRule)
+
+// key identifies the owner of the rule, registering a rule with the same key replaces it
+func RegisterRetentionRule(key string, rule RetentionRule) error {
+	if rule.MaxAge <= 0 {
+		return fmt.Errorf("invalid retention rule %q, maxage must be positive", key)
+	}
+	retentionLock.Lock()
+	defer retentionLock.Unlock()
+	retentionRules[key] = rule
+	return nil
+}
+
+func RemoveRetentionRule(key string) {
+	retentionLock.Lock()
+	defer retentionLock.Unlock()
+	delete(retentionRules, key)
+}
+
+func getRetentionRules() map[string]RetentionRule {
+	retentionLock.Lock()
+	defer retentionLock.Unlock()
+	rtn := make(map[string]RetentionRule, len(retentionRules))
+	for key, rule := range retentionRules {
+		rtn[key] = rule
+	}
+	return rtn
+}
+
+// deletes the files of the block that were not written in olderThan (and match namePattern, a sqlite glob, ""
+// for all files).  returns the names of the deleted files.
+func PruneBlockFiles(ctx context.Context, blockId string, olderThan time.Duration, namePattern string) ([]string, error) {
+	if olderThan < 0 {
+		return nil, fmt.Errorf("PruneBlockFiles error: negative age")
+	}
+	cutoffTs := time.Now().Add(-olderThan).UnixMilli()
+	flushAppendBuffers(ctx, blockId)
+	defer lockBlock(blockId, true)()
+	names, err := getFilesOlderThanInDB(ctx, blockId, cutoffTs, namePattern)
+	if err != nil {
+		return nil, fmt.Errorf("PruneBlockFiles error: %w", err)
+	}
+	var rtn []string
+	for _, name := range names {
+		// the db modts is only updated on flush, cached files can have newer writes
+		if cacheEntry, found := GetCacheEntry(ctx, blockId, name); found && cacheEntry.Info != nil {
+			cacheEntry.Lock.Lock()
+			modTs := cacheEntry.Info.ModTs
+			cacheEntry.Lock.Unlock()
+			if modTs >= cutoffTs {
+				continue
+			}
+		}
+		err = DeleteFile(ctx, blockId, name)
+		if err != nil {
+			return rtn, fmt.Errorf("PruneBlockFiles error deleting %q: %w", name, err)
+		}
+		rtn = append(rtn, name)
+	}
+	return rtn, nil
+}
+
+// prunes all blocks with the registered retention rules, returns the number of files deleted
+func ApplyRetentionRules(ctx context.Context) (int, error) {
+	rules := getRetentionRules()
+	if len(rules) == 0 {
+		return 0, nil
+	}
+	blockIds, err := GetAllBlockIdsInDB(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("ApplyRetentionRules error: %w", err)
+	}
+	keys := make([]string, 0, len(rules))
+	for key := range rules {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var numDeleted int
+	for _, key := range keys {
+		rule := rules[key]
+		if rule.Locker != nil {
+			rule.Locker.Lock()
+		}
+		for _, blockId := range blockIds {
+			var deleted []string
+			deleted, err = PruneBlockFiles(ctx, blockId, rule.MaxAge, rule.NameGlob)
+			numDeleted += len(deleted)
+			if err != nil {
+				err = fmt.Errorf("ApplyRetentionRules rule %q: %w", key, err)
+				break
+			}
+		}
+		if rule.Locker != nil {
+			rule.Locker.Unlock()
+		}
+		if err != nil {
+			return numDeleted, err
+		}
+	}
+	return numDeleted, nil
+}
+
+func getFilesOlderThanInDB(ctx context.Context, blockId string, cutoffTs int64, nameGlob string) ([]string, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]string, error) {
+		var rtn []string
+		query := `SELECT name FROM block_file WHERE blockid = ? AND modts < ?`
+		args := []interface{}{blockId, cutoffTs}
+		if nameGlob != "" {
+			query += ` AND name GLOB ?`
+			args = append(args, nameGlob)
+		}
+		query += ` ORDER BY name`
+		marr := tx.SelectMaps(query, args...)
+		for _, m := range marr {
+			var name string
+			dbutil.QuickSetStr(&name, m, "name")
+			rtn = append(rtn, name)
+		}
+		return rtn, nil
+	})
+}
@@ -8,7 +8,7 @@ CREATE TABLE block_file (
     size bigint NOT NULL,
     createdts bigint NOT NULL,
     modts bigint NOT NULL,
-    meta json NOT NULL,
+    meta json NOT NULL, compress boolean NOT NULL DEFAULT 0, indexmeta json NOT NULL DEFAULT '[]',
     PRIMARY KEY (blockid, name)
 );
 
@@ -16,6 +16,14 @@ CREATE TABLE block_data (
     blockid varchar(36) NOT NULL,
     name varchar(200) NOT NULL,
     partidx int NOT NULL,
-    data blob NOT NULL,
+    data blob NOT NULL, codec varchar(20) NOT NULL DEFAULT '', checksum varchar(20) NOT NULL DEFAULT '',
     PRIMARY KEY(blockid, name, partidx)
-);
\ No newline at end of file
+);
+CREATE TABLE block_file_metaidx (
+    blockid varchar(36) NOT NULL,
+    name varchar(200) NOT NULL,
+    metakey varchar(200) NOT NULL,
+    metavalue text NOT NULL,
+    PRIMARY KEY (blockid, name, metakey)
+);
+CREATE INDEX block_file_metaidx_value ON block_file_metaidx (blockid, metakey, metavalue);
@@ -0,0 +1,351 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package bookmarks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/alessio/shellescape"
+	"github.com/google/uuid"
+)
+
+// import/export of bookmarks as Warp workflows (yaml, one workflow per file) and VSCode tasks (tasks.json).
+// bookmark cmdstrs use Warp style argument placeholders ({{name}}).  VSCode input variables (${input:name})
+// are converted to and from this form.
+
+const (
+	ImportFormat_Warp   = "warp"
+	ImportFormat_VSCode = "vscode"
+)
+
+const VSCodeTasksVersion = "2.0.0"
+
+var templateArgRe = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_-]*)\s*\}\}`)
+var vscodeInputRe = regexp.MustCompile(`\$\{input:([A-Za-z_][A-Za-z0-9_-]*)\}`)
+var fileNameCleanRe = regexp.MustCompile(`[^a-z0-9]+`)
+
+type WarpArgumentType struct {
+	Name         string  `json:"name"`
+	Description  string  `json:"description,omitempty"`
+	DefaultValue *string `json:"default_value,omitempty"`
+}
+
+type WarpWorkflowType struct {
+	Name        string             `json:"name"`
+	Command     string             `json:"command"`
+	Tags        []string           `json:"tags,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Arguments   []WarpArgumentType `json:"arguments,omitempty"`
+	SourceUrl   string             `json:"source_url,omitempty"`
+	Author      string             `json:"author,omitempty"`
+	AuthorUrl   string             `json:"author_url,omitempty"`
+	Shells      []string           `json:"shells,omitempty"`
+}
+
+type VSCodeInputType struct {
+	Id          string `json:"id"`
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	Default     string `json:"default,omitempty"`
+}
+
+type VSCodeTaskType struct {
+	Label   string `json:"label"`
+	Type    string `json:"type,omitempty"`
+	Command string `json:"command,omitempty"`
+	Args    []any  `json:"args,omitempty"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+type VSCodeTasksFileType struct {
+	Version string            `json:"version"`
+	Tasks   []VSCodeTaskType  `json:"tasks"`
+	Inputs  []VSCodeInputType `json:"inputs,omitempty"`
+}
+
+// returns the unique argument names (in order) of a cmdstr with {{name}} placeholders
+func GetTemplateArgNames(cmdStr string) []string {
+	var rtn []string
+	seen := make(map[string]bool)
+	for _, m := range templateArgRe.FindAllStringSubmatch(cmdStr, -1) {
+		if seen[m[1]] {
+			continue
+		}
+		seen[m[1]] = true
+		rtn = append(rtn, m[1])
+	}
+	return rtn
+}
+
+// replaces the {{name}} placeholders with their values, returns the names that have no value (the
+// placeholders are left in place)
+func RenderTemplateArgs(cmdStr string, vals map[string]string) (string, []string) {
+	var missing []string
+	seen := make(map[string]bool)
+	rtn := templateArgRe.ReplaceAllStringFunc(cmdStr, func(match string) string {
+		name := templateArgRe.FindStringSubmatch(match)[1]
+		val, found := vals[name]
+		if !found {
+			if !seen[name] {
+				seen[name] = true
+				missing = append(missing, name)
+			}
+			return match
+		}
+		return val
+	})
+	return rtn, missing
+}
+
+func makeImportedBookmark(cmdStr string, name string, desc string, tags []string) *BookmarkType {
+	fullDesc := strings.TrimSpace(name)
+	desc = strings.TrimSpace(desc)
+	if fullDesc == "" {
+		fullDesc = desc
+	} else if desc != "" && desc != fullDesc {
+		fullDesc = fullDesc + ": " + desc
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+	return &BookmarkType{
+		BookmarkId:  uuid.New().String(),
+		CreatedTs:   time.Now().UnixMilli(),
+		CmdStr:      strings.TrimSpace(cmdStr),
+		Tags:        tags,
+		Description: fullDesc,
+	}
+}
+
+// accepts a single workflow or a list of workflows, in yaml or json
+func ImportWarpWorkflows(data []byte) ([]*BookmarkType, error) {
+	var workflowsVal any
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		err := json.Unmarshal(data, &workflowsVal)
+		if err != nil {
+			return nil, fmt.Errorf("invalid warp workflow json: %w", err)
+		}
+	} else {
+		var err error
+		workflowsVal, err = parseYamlSubset(trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("invalid warp workflow yaml: %w", err)
+		}
+	}
+	if _, isMap := workflowsVal.(map[string]any); isMap {
+		workflowsVal = []any{workflowsVal}
+	}
+	// round-trip through json to map into the typed struct
+	barr, err := json.Marshal(workflowsVal)
+	if err != nil {
+		return nil, err
+	}
+	var workflows []WarpWorkflowType
+	err = json.Unmarshal(barr, &workflows)
+	if err != nil {
+		return nil, fmt.Errorf("invalid warp workflow: %w", err)
+	}
+	var rtn []*BookmarkType
+	for _, wf := range workflows {
+		if strings.TrimSpace(wf.Command) == "" {
+			continue
+		}
+		rtn = append(rtn, makeImportedBookmark(wf.Command, wf.Name, wf.Description, wf.Tags))
+	}
+	return rtn, nil
+}
+
+// one workflow per bookmark, returns a map of filename => yaml
+func ExportWarpWorkflows(bms []*BookmarkType) map[string][]byte {
+	rtn := make(map[string][]byte)
+	for _, bm := range bms {
+		name := bm.Description
+		if name == "" {
+			name = bm.CmdStr
+		}
+		name, _, _ = strings.Cut(name, "\n")
+		var buf strings.Builder
+		buf.WriteString("---\n")
+		buf.WriteString(fmt.Sprintf("name: %s\n", yamlQuote(name)))
+		buf.WriteString(fmt.Sprintf("command: %s\n", yamlQuote(bm.CmdStr)))
+		if len(bm.Tags) > 0 {
+			buf.WriteString("tags:\n")
+			for _, tag := range bm.Tags {
+				buf.WriteString(fmt.Sprintf("  - %s\n", yamlQuote(tag)))
+			}
+		}
+		if bm.Description != "" && bm.Description != name {
+			buf.WriteString(fmt.Sprintf("description: %s\n", yamlQuote(bm.Description)))
+		}
+		argNames := GetTemplateArgNames(bm.CmdStr)
+		if len(argNames) > 0 {
+			buf.WriteString("arguments:\n")
+			for _, argName := range argNames {
+				buf.WriteString(fmt.Sprintf("  - name: %s\n", yamlQuote(argName)))
+			}
+		}
+		fileName := makeExportFileName(name, bm.BookmarkId) + ".yaml"
+		rtn[fileName] = []byte(buf.String())
+	}
+	return rtn
+}
+
+// json strings are valid yaml double-quoted scalars
+func yamlQuote(str string) string {
+	barr, _ := json.Marshal(str)
+	return string(barr)
+}
+
+func makeExportFileName(name string, bookmarkId string) string {
+	fileName := strings.Trim(fileNameCleanRe.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if len(fileName) > 50 {
+		fileName = strings.TrimRight(fileName[0:50], "_")
+	}
+	if fileName == "" {
+		fileName = "bookmark"
+	}
+	// bookmark ids keep the file names unique
+	return fileName + "_" + bookmarkId[0:8]
+}
+
+// tasks.json allows comments and trailing commas
+func stripJsonc(data []byte) []byte {
+	var rtn []byte
+	inStr := false
+	for idx := 0; idx < len(data); idx++ {
+		ch := data[idx]
+		if inStr {
+			rtn = append(rtn, ch)
+			if ch == '\\' && idx+1 < len(data) {
+				idx++
+				rtn = append(rtn, data[idx])
+			} else if ch == '"' {
+				inStr = false
+			}
+			continue
+		}
+		if ch == '"' {
+			inStr = true
+			rtn = append(rtn, ch)
+			continue
+		}
+		if ch == '/' && idx+1 < len(data) && data[idx+1] == '/' {
+			for idx < len(data) && data[idx] != '\n' {
+				idx++
+			}
+			rtn = append(rtn, '\n')
+			continue
+		}
+		if ch == '/' && idx+1 < len(data) && data[idx+1] == '*' {
+			endIdx := strings.Index(string(data[idx+2:]), "*/")
+			if endIdx == -1 {
+				break
+			}
+			idx += endIdx + 3
+			continue
+		}
+		if ch == ']' || ch == '}' {
+			// remove a trailing comma (skipping whitespace)
+			lastIdx := len(rtn) - 1
+			for lastIdx >= 0 && strings.ContainsRune(" \t\r\n", rune(rtn[lastIdx])) {
+				lastIdx--
+			}
+			if lastIdx >= 0 && rtn[lastIdx] == ',' {
+				rtn = append(rtn[:lastIdx], rtn[lastIdx+1:]...)
+			}
+		}
+		rtn = append(rtn, ch)
+	}
+	return rtn
+}
+
+func ImportVSCodeTasks(data []byte) ([]*BookmarkType, error) {
+	var tasksFile VSCodeTasksFileType
+	err := json.Unmarshal(stripJsonc(data), &tasksFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vscode tasks.json: %w", err)
+	}
+	var rtn []*BookmarkType
+	for _, task := range tasksFile.Tasks {
+		if strings.TrimSpace(task.Command) == "" {
+			// e.g. tasks that only have dependsOn
+			continue
+		}
+		cmdStr := task.Command
+		for _, arg := range task.Args {
+			// args can also be {"value": ..., "quoting": ...} objects
+			if argMap, ok := arg.(map[string]any); ok {
+				arg = argMap["value"]
+			}
+			argStr, ok := arg.(string)
+			if !ok {
+				continue
+			}
+			if vscodeInputRe.MatchString(argStr) {
+				cmdStr += " " + argStr
+			} else {
+				cmdStr += " " + shellescape.Quote(argStr)
+			}
+		}
+		cmdStr = vscodeInputRe.ReplaceAllString(cmdStr, "{{$1}}")
+		rtn = append(rtn, makeImportedBookmark(cmdStr, task.Label, task.Detail, nil))
+	}
+	return rtn, nil
+}
+
+func ExportVSCodeTasks(bms []*BookmarkType) ([]byte, error) {
+	tasksFile := VSCodeTasksFileType{Version: VSCodeTasksVersion, Tasks: make([]VSCodeTaskType, 0, len(bms))}
+	seenInputs := make(map[string]bool)
+	for _, bm := range bms {
+		label := bm.Description
+		if label == "" {
+			label = bm.CmdStr
+		}
+		label, _, _ = strings.Cut(label, "\n")
+		task := VSCodeTaskType{
+			Label:   label,
+			Type:    "shell",
+			Command: templateArgRe.ReplaceAllString(bm.CmdStr, "$${input:$1}"),
+		}
+		if bm.Description != "" && bm.Description != label {
+			task.Detail = bm.Description
+		}
+		tasksFile.Tasks = append(tasksFile.Tasks, task)
+		for _, argName := range GetTemplateArgNames(bm.CmdStr) {
+			if seenInputs[argName] {
+				continue
+			}
+			seenInputs[argName] = true
+			tasksFile.Inputs = append(tasksFile.Inputs, VSCodeInputType{Id: argName, Type: "promptString", Description: argName})
+		}
+	}
+	return json.MarshalIndent(tasksFile, "", "    ")
+}
+
+// bookmarks with a cmdstr that is already bookmarked are skipped.  returns (num added, num skipped)
+func InsertImportedBookmarks(ctx context.Context, bms []*BookmarkType) (int, int, error) {
+	var numAdded, numSkipped int
+	for _, bm := range bms {
+		existingIds, err := GetBookmarkIdsByCmdStr(ctx, bm.CmdStr)
+		if err != nil {
+			return numAdded, numSkipped, err
+		}
+		if len(existingIds) > 0 {
+			numSkipped++
+			continue
+		}
+		err = InsertBookmark(ctx, bm)
+		if err != nil {
+			return numAdded, numSkipped, err
+		}
+		numAdded++
+	}
+	return numAdded, numSkipped, nil
+}
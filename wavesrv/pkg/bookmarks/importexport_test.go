@@ -0,0 +1,105 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package bookmarks
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testWarpWorkflow = `---
+# example workflow
+name: Uninstall a Homebrew package
+command: |-
+    brew tap beeftornado/rmtree
+    brew rmtree {{package_name}}
+tags:
+  - homebrew
+  - "brew # not a comment"
+description: Removes a package and its dependencies
+arguments:
+  - name: package_name
+    description: The name of the package
+    default_value: ~
+source_url: "https://example.com/a#b"
+shells: []
+`
+
+func TestImportWarp(t *testing.T) {
+	bms, err := ImportWarpWorkflows([]byte(testWarpWorkflow))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(bms) != 1 {
+		t.Fatalf("expected 1 bookmark, got %d", len(bms))
+	}
+	bm := bms[0]
+	if bm.CmdStr != "brew tap beeftornado/rmtree\nbrew rmtree {{package_name}}" {
+		t.Errorf("bad cmdstr %q", bm.CmdStr)
+	}
+	if bm.Description != "Uninstall a Homebrew package: Removes a package and its dependencies" {
+		t.Errorf("bad description %q", bm.Description)
+	}
+	if !reflect.DeepEqual(bm.Tags, []string{"homebrew", "brew # not a comment"}) {
+		t.Errorf("bad tags %#v", bm.Tags)
+	}
+	if !reflect.DeepEqual(GetTemplateArgNames(bm.CmdStr), []string{"package_name"}) {
+		t.Errorf("bad args %#v", GetTemplateArgNames(bm.CmdStr))
+	}
+}
+
+func TestWarpRoundTrip(t *testing.T) {
+	bm := &BookmarkType{BookmarkId: "12345678-aaaa", CmdStr: "ls -l {{dir}} | grep \"x\"", Tags: []string{"files"}, Description: "List: dir"}
+	files := ExportWarpWorkflows([]*BookmarkType{bm})
+	data, ok := files["list_dir_12345678.yaml"]
+	if !ok {
+		t.Fatalf("unexpected file names %v", files)
+	}
+	bms, err := ImportWarpWorkflows(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(bms) != 1 || bms[0].CmdStr != bm.CmdStr || bms[0].Description != bm.Description || !reflect.DeepEqual(bms[0].Tags, bm.Tags) {
+		t.Errorf("round trip mismatch: %#v", bms[0])
+	}
+}
+
+const testVSCodeTasks = `{
+    // comment
+    "version": "2.0.0",
+    "tasks": [
+        {
+            "label": "build",
+            "type": "shell",
+            "command": "make",
+            "args": ["-C", "${input:dir}", "has space"], /* trailing comma */
+        },
+        {
+            "label": "all",
+            "dependsOn": ["build"]
+        },
+    ],
+    "inputs": [{"id": "dir", "type": "promptString", "description": "dir // here"}]
+}`
+
+func TestImportVSCode(t *testing.T) {
+	bms, err := ImportVSCodeTasks([]byte(testVSCodeTasks))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(bms) != 1 {
+		t.Fatalf("expected 1 bookmark, got %d", len(bms))
+	}
+	if bms[0].CmdStr != "make -C {{dir}} 'has space'" || bms[0].Description != "build" {
+		t.Errorf("bad bookmark %#v", bms[0])
+	}
+	data, err := ExportVSCodeTasks(bms)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(data), `"command": "make -C ${input:dir} 'has space'"`) || !strings.Contains(string(data), `"id": "dir"`) {
+		t.Errorf("bad export %s", data)
+	}
+}
@@ -14,6 +14,10 @@ func (BookmarksUpdate) GetType() string {
 	return "bookmarks"
 }
 
+func init() {
+	scbus.RegisterModelUpdateItem(BookmarksUpdate{})
+}
+
 func AddBookmarksUpdate(update *scbus.ModelUpdatePacketType, bookmarks []*BookmarkType, selectedBookmark *string) {
 	if selectedBookmark == nil {
 		update.AddUpdate(BookmarksUpdate{Bookmarks: bookmarks})
@@ -0,0 +1,380 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package bookmarks
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// a small YAML parser, supports the subset of YAML used by Warp workflow files:
+// block mappings and sequences, flow sequences of scalars, quoted/plain scalars,
+// block scalars (| and >), and comments.  anchors, tags, and multiple documents are not supported.
+// scalars are always returned as strings (or nil for ~/null).
+
+type yamlLine struct {
+	LineNum int
+	Raw     string
+	Indent  int
+	Content string // comments and surrounding whitespace removed
+}
+
+type yamlParser struct {
+	Lines []*yamlLine
+	Pos   int
+}
+
+func parseYamlSubset(data string) (any, error) {
+	p := &yamlParser{}
+	for idx, raw := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		raw = strings.TrimRight(raw, " \t")
+		if strings.HasPrefix(raw, "%") || raw == "---" || raw == "..." {
+			continue
+		}
+		content := stripYamlComment(raw)
+		indent := len(raw) - len(strings.TrimLeft(raw, " "))
+		p.Lines = append(p.Lines, &yamlLine{LineNum: idx + 1, Raw: raw, Indent: indent, Content: strings.TrimSpace(content)})
+	}
+	p.skipBlank()
+	if p.Pos >= len(p.Lines) {
+		return nil, nil
+	}
+	rtn, err := p.parseNode(p.Lines[p.Pos].Indent)
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlank()
+	if p.Pos < len(p.Lines) {
+		return nil, p.errorf("unexpected content")
+	}
+	return rtn, nil
+}
+
+func (p *yamlParser) errorf(format string, args ...any) error {
+	lineNum := 0
+	if p.Pos < len(p.Lines) {
+		lineNum = p.Lines[p.Pos].LineNum
+	}
+	return fmt.Errorf("yaml line %d: %s", lineNum, fmt.Sprintf(format, args...))
+}
+
+func (p *yamlParser) skipBlank() {
+	for p.Pos < len(p.Lines) && p.Lines[p.Pos].Content == "" {
+		p.Pos++
+	}
+}
+
+func isYamlSeqItem(content string) bool {
+	return content == "-" || strings.HasPrefix(content, "- ")
+}
+
+func (p *yamlParser) parseNode(indent int) (any, error) {
+	if isYamlSeqItem(p.Lines[p.Pos].Content) {
+		return p.parseSeq(indent)
+	}
+	return p.parseMap(indent)
+}
+
+func (p *yamlParser) parseSeq(indent int) ([]any, error) {
+	rtn := make([]any, 0)
+	for {
+		p.skipBlank()
+		if p.Pos >= len(p.Lines) {
+			return rtn, nil
+		}
+		line := p.Lines[p.Pos]
+		if line.Indent < indent {
+			return rtn, nil
+		}
+		if line.Indent > indent || !isYamlSeqItem(line.Content) {
+			return nil, p.errorf("bad indentation in sequence")
+		}
+		itemContent := strings.TrimSpace(line.Content[1:])
+		if itemContent == "" {
+			p.Pos++
+			val, err := p.parseChild(indent, true)
+			if err != nil {
+				return nil, err
+			}
+			rtn = append(rtn, val)
+			continue
+		}
+		if _, _, isKey := splitYamlKey(itemContent); isKey || isYamlSeqItem(itemContent) {
+			// "- key: val" starts a nested node, re-parse the rest of the line at its own column
+			itemIndent := line.Indent + (len(line.Content) - len(itemContent))
+			p.Lines[p.Pos] = &yamlLine{LineNum: line.LineNum, Raw: line.Raw, Indent: itemIndent, Content: itemContent}
+			val, err := p.parseNode(itemIndent)
+			if err != nil {
+				return nil, err
+			}
+			rtn = append(rtn, val)
+			continue
+		}
+		p.Pos++
+		val, err := parseYamlScalar(itemContent)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		rtn = append(rtn, val)
+	}
+}
+
+func (p *yamlParser) parseMap(indent int) (map[string]any, error) {
+	rtn := make(map[string]any)
+	for {
+		p.skipBlank()
+		if p.Pos >= len(p.Lines) {
+			return rtn, nil
+		}
+		line := p.Lines[p.Pos]
+		if line.Indent < indent || (line.Indent == indent && isYamlSeqItem(line.Content)) {
+			return rtn, nil
+		}
+		if line.Indent > indent {
+			return nil, p.errorf("bad indentation in mapping")
+		}
+		key, rest, isKey := splitYamlKey(line.Content)
+		if !isKey {
+			return nil, p.errorf("expected 'key: value'")
+		}
+		p.Pos++
+		var val any
+		var err error
+		if rest == "" {
+			// sequences are allowed at the same indent as their key
+			val, err = p.parseChild(indent, false)
+		} else if strings.HasPrefix(rest, "|") || strings.HasPrefix(rest, ">") {
+			val, err = p.parseBlockScalar(indent, rest)
+		} else {
+			val, err = parseYamlScalar(rest)
+			if err != nil {
+				p.Pos--
+				err = p.errorf("%v", err)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		rtn[key] = val
+	}
+}
+
+// parses the node after a "key:" or "-" line (or returns nil if there is none)
+func (p *yamlParser) parseChild(parentIndent int, inSeq bool) (any, error) {
+	p.skipBlank()
+	if p.Pos >= len(p.Lines) {
+		return nil, nil
+	}
+	line := p.Lines[p.Pos]
+	if line.Indent > parentIndent || (!inSeq && line.Indent == parentIndent && isYamlSeqItem(line.Content)) {
+		return p.parseNode(line.Indent)
+	}
+	return nil, nil
+}
+
+func (p *yamlParser) parseBlockScalar(parentIndent int, header string) (string, error) {
+	style := header[0]
+	chomp := strings.TrimSpace(header[1:])
+	if chomp != "" && chomp != "-" && chomp != "+" {
+		return "", p.errorf("unsupported block scalar header %q", header)
+	}
+	var blockLines []string
+	blockIndent := -1
+	for p.Pos < len(p.Lines) {
+		line := p.Lines[p.Pos]
+		if strings.TrimSpace(line.Raw) == "" {
+			blockLines = append(blockLines, "")
+			p.Pos++
+			continue
+		}
+		if line.Indent <= parentIndent {
+			break
+		}
+		if blockIndent == -1 {
+			blockIndent = line.Indent
+		}
+		if line.Indent < blockIndent {
+			break
+		}
+		blockLines = append(blockLines, line.Raw[blockIndent:])
+		p.Pos++
+	}
+	// trailing blank lines belong to the chomping indicator, not the block
+	numTrailing := 0
+	for len(blockLines) > 0 && blockLines[len(blockLines)-1] == "" {
+		blockLines = blockLines[:len(blockLines)-1]
+		numTrailing++
+	}
+	var rtn string
+	if style == '|' {
+		rtn = strings.Join(blockLines, "\n")
+	} else {
+		var sb strings.Builder
+		for idx, bl := range blockLines {
+			if idx > 0 {
+				if bl == "" || blockLines[idx-1] == "" {
+					sb.WriteString("\n")
+				} else {
+					sb.WriteString(" ")
+				}
+			}
+			sb.WriteString(bl)
+		}
+		rtn = sb.String()
+	}
+	if chomp == "+" {
+		rtn += strings.Repeat("\n", numTrailing+1)
+	} else if chomp == "" && len(blockLines) > 0 {
+		rtn += "\n"
+	}
+	return rtn, nil
+}
+
+// splits "key: rest" (key may be quoted), returns false if content is not a mapping entry
+func splitYamlKey(content string) (string, string, bool) {
+	if strings.HasPrefix(content, "\"") || strings.HasPrefix(content, "'") {
+		endIdx := findYamlQuoteEnd(content)
+		if endIdx == -1 {
+			return "", "", false
+		}
+		rest := content[endIdx+1:]
+		if rest != ":" && !strings.HasPrefix(rest, ": ") {
+			return "", "", false
+		}
+		key, err := parseYamlScalar(content[:endIdx+1])
+		if err != nil {
+			return "", "", false
+		}
+		keyStr, _ := key.(string)
+		return keyStr, strings.TrimSpace(rest[1:]), true
+	}
+	for idx := 0; idx < len(content); idx++ {
+		if content[idx] == ':' && (idx == len(content)-1 || content[idx+1] == ' ') {
+			return strings.TrimSpace(content[:idx]), strings.TrimSpace(content[idx+1:]), idx > 0
+		}
+	}
+	return "", "", false
+}
+
+// returns the index of the closing quote (content must start with a quote)
+func findYamlQuoteEnd(content string) int {
+	quote := content[0]
+	for idx := 1; idx < len(content); idx++ {
+		ch := content[idx]
+		if quote == '"' && ch == '\\' {
+			idx++
+			continue
+		}
+		if ch == quote {
+			if quote == '\'' && idx+1 < len(content) && content[idx+1] == '\'' {
+				idx++
+				continue
+			}
+			return idx
+		}
+	}
+	return -1
+}
+
+func stripYamlComment(raw string) string {
+	var quote byte
+	for idx := 0; idx < len(raw); idx++ {
+		ch := raw[idx]
+		if quote != 0 {
+			if quote == '"' && ch == '\\' {
+				idx++
+			} else if ch == quote {
+				quote = 0
+			}
+			continue
+		}
+		if ch == '"' || ch == '\'' {
+			// quotes only start a quoted scalar at the beginning of a value
+			if idx == 0 || raw[idx-1] == ' ' || raw[idx-1] == '[' || raw[idx-1] == ',' {
+				quote = ch
+			}
+			continue
+		}
+		if ch == '#' && (idx == 0 || raw[idx-1] == ' ' || raw[idx-1] == '\t') {
+			return raw[:idx]
+		}
+	}
+	return raw
+}
+
+func parseYamlScalar(valStr string) (any, error) {
+	valStr = strings.TrimSpace(valStr)
+	switch {
+	case valStr == "~" || valStr == "null" || valStr == "Null" || valStr == "NULL":
+		return nil, nil
+	case valStr == "{}":
+		return make(map[string]any), nil
+	case strings.HasPrefix(valStr, "["):
+		return parseYamlFlowSeq(valStr)
+	case strings.HasPrefix(valStr, "{"):
+		return nil, fmt.Errorf("flow mappings are not supported")
+	case strings.HasPrefix(valStr, "\"") || strings.HasPrefix(valStr, "'"):
+		endIdx := findYamlQuoteEnd(valStr)
+		if endIdx != len(valStr)-1 {
+			return nil, fmt.Errorf("invalid quoted string %s", valStr)
+		}
+		if valStr[0] == '\'' {
+			return strings.ReplaceAll(valStr[1:endIdx], "''", "'"), nil
+		}
+		rtn, err := strconv.Unquote(valStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quoted string %s", valStr)
+		}
+		return rtn, nil
+	}
+	return valStr, nil
+}
+
+func parseYamlFlowSeq(valStr string) ([]any, error) {
+	if !strings.HasSuffix(valStr, "]") {
+		return nil, fmt.Errorf("invalid flow sequence %s", valStr)
+	}
+	inner := strings.TrimSpace(valStr[1 : len(valStr)-1])
+	rtn := make([]any, 0)
+	if inner == "" {
+		return rtn, nil
+	}
+	var quote byte
+	start := 0
+	for idx := 0; idx <= len(inner); idx++ {
+		if idx < len(inner) {
+			ch := inner[idx]
+			if quote != 0 {
+				if quote == '"' && ch == '\\' {
+					idx++
+				} else if ch == quote {
+					quote = 0
+				}
+				continue
+			}
+			if ch == '"' || ch == '\'' {
+				quote = ch
+				continue
+			}
+			if ch == '[' || ch == '{' {
+				return nil, fmt.Errorf("nested flow collections are not supported")
+			}
+			if ch != ',' {
+				continue
+			}
+		}
+		itemStr := strings.TrimSpace(inner[start:idx])
+		start = idx + 1
+		if itemStr == "" {
+			continue
+		}
+		item, err := parseYamlScalar(itemStr)
+		if err != nil {
+			return nil, err
+		}
+		rtn = append(rtn, item)
+	}
+	return rtn, nil
+}
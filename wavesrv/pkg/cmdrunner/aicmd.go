@@ -0,0 +1,285 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/utilfn"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/history"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote/openai"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// natural language to command (/ai:cmd [prompt]).  unlike /chat (and the cmdinfo chat) there is no line or chat
+// state, the prompt (with the cwd, shell and the recent commands of the screen) is sent to the configured AI
+// provider and the returned command is put in the command input, where it can be edited before running it.
+
+const AICmdTimeout = 30 * time.Second
+const AICmdNumHistoryItems = 10
+
+type aiCmdContext struct {
+	Cwd        string
+	ShellType  string
+	OsType     string
+	RecentCmds []string // most recent first
+}
+
+func getAICmdPrompt(userQuery string, actx aiCmdContext) []packet.OpenAIPromptMessageType {
+	var sysBuf strings.Builder
+	sysBuf.WriteString("You translate natural language requests into shell commands.")
+	sysBuf.WriteString(fmt.Sprintf(" The user is using the %q shell on %s.", actx.ShellType, actx.OsType))
+	if actx.Cwd != "" {
+		sysBuf.WriteString(fmt.Sprintf(" The current directory is %q.", actx.Cwd))
+	}
+	if len(actx.RecentCmds) > 0 {
+		sysBuf.WriteString(" The user's most recent commands were (most recent first):\n")
+		for _, cmdStr := range actx.RecentCmds {
+			sysBuf.WriteString("  " + cmdStr + "\n")
+		}
+	}
+	sysBuf.WriteString(" Respond with only the command that does what the user asks, with no explanation and no markdown formatting.")
+	sysBuf.WriteString(" If the request needs multiple commands, combine them into one command line.")
+	return []packet.OpenAIPromptMessageType{
+		{Role: sstore.OpenAIRoleSystem, Content: sysBuf.String()},
+		{Role: sstore.OpenAIRoleUser, Content: userQuery},
+	}
+}
+
+// models do not always follow the instructions, takes the first code block (if any) and strips prompt chars
+func extractAICmdStr(respText string) string {
+	text := strings.TrimSpace(respText)
+	if startIdx := strings.Index(text, "```"); startIdx != -1 {
+		block := text[startIdx+3:]
+		if endIdx := strings.Index(block, "```"); endIdx != -1 {
+			block = block[:endIdx]
+		}
+		// drop the language tag of the code block
+		if nlIdx := strings.Index(block, "\n"); nlIdx != -1 && !strings.Contains(strings.TrimSpace(block[:nlIdx]), " ") {
+			block = block[nlIdx+1:]
+		}
+		text = strings.TrimSpace(block)
+	}
+	text = strings.Trim(text, "`")
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		line = strings.TrimPrefix(line, "$ ")
+		if line == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// returns the client id and a copy of the client's AI options (with defaults set) for a single completion
+func getAICmdOpts(ctx context.Context) (string, *sstore.OpenAIOptsType, error) {
+	clientData, err := sstore.EnsureClientData(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot retrieve client data: %v", err)
+	}
+	if clientData.OpenAIOpts == nil {
+		return "", nil, fmt.Errorf("error retrieving client open ai options")
+	}
+	opts := *clientData.OpenAIOpts
+	if opts.APIToken == "" && opts.BaseURL == "" && clientData.ClientOpts.NoTelemetry {
+		return "", nil, fmt.Errorf(OpenAICloudCompletionTelemetryOffErrorMsg)
+	}
+	if opts.Model == "" {
+		opts.Model = openai.DefaultModel
+	}
+	if opts.MaxTokens == 0 {
+		opts.MaxTokens = openai.DefaultMaxTokens
+	}
+	opts.MaxChoices = 1
+	return clientData.ClientId, &opts, nil
+}
+
+func getAICmdTimeout(opts *sstore.OpenAIOptsType) time.Duration {
+	if opts.Timeout > 0 {
+		return time.Duration(opts.Timeout) * time.Millisecond
+	}
+	return AICmdTimeout
+}
+
+// runs the completion (local api or the wave cloud) and returns the text of the first choice
+func runAICmdCompletion(ctx context.Context, clientId string, opts *sstore.OpenAIOptsType, prompt []packet.OpenAIPromptMessageType) (string, error) {
+	if opts.APIToken != "" {
+		respPks, err := openai.RunCompletion(ctx, opts, prompt)
+		if err != nil {
+			return "", err
+		}
+		for _, pk := range respPks {
+			if pk.Index == 0 && pk.Text != "" {
+				return pk.Text, nil
+			}
+		}
+		return "", fmt.Errorf("no response received")
+	}
+	var ch chan *packet.OpenAIPacketType
+	var err error
+	if opts.BaseURL == "" {
+		var conn *websocket.Conn
+		ch, conn, err = openai.RunCloudCompletionStream(ctx, clientId, opts, prompt)
+		if conn != nil {
+			defer conn.Close()
+		}
+	} else {
+		ch, err = openai.RunCompletionStream(ctx, opts, prompt)
+	}
+	if err != nil {
+		return "", err
+	}
+	var textBuf strings.Builder
+	for {
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("timeout waiting for AI response")
+		case pk, ok := <-ch:
+			if !ok {
+				return textBuf.String(), nil
+			}
+			if pk.Error != "" {
+				return "", fmt.Errorf("%s", pk.Error)
+			}
+			if pk.Index == 0 {
+				textBuf.WriteString(pk.Text)
+			}
+		}
+	}
+}
+
+func AICmdCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, fmt.Errorf("/ai:cmd error: %w", err)
+	}
+	userQuery := strings.TrimSpace(strings.Join(pk.Args, " "))
+	if userQuery == "" {
+		return nil, fmt.Errorf("usage: /ai:cmd [what the command should do]")
+	}
+	clientId, opts, err := getAICmdOpts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	actx := aiCmdContext{
+		Cwd:       ids.Remote.FeState["cwd"],
+		ShellType: ids.Remote.ShellType,
+		OsType:    GetOsTypeFromRuntime(),
+	}
+	hresult, err := history.GetHistoryItems(ctx, history.HistoryQueryOpts{MaxItems: AICmdNumHistoryItems, ScreenId: ids.ScreenId, NoMeta: true})
+	if err == nil {
+		for _, hitem := range hresult.Items {
+			actx.RecentCmds = append(actx.RecentCmds, hitem.CmdStr)
+		}
+	}
+	aiCtx, cancelFn := context.WithTimeout(context.Background(), getAICmdTimeout(opts))
+	defer cancelFn()
+	respText, err := runAICmdCompletion(aiCtx, clientId, opts, getAICmdPrompt(userQuery, actx))
+	if err != nil {
+		return nil, fmt.Errorf("/ai:cmd error calling AI provider: %v", err)
+	}
+	cmdStr := extractAICmdStr(respText)
+	if cmdStr == "" {
+		return nil, fmt.Errorf("/ai:cmd no command returned for %q", userQuery)
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.CmdLineUpdate(utilfn.StrWithPos{Str: cmdStr, Pos: utf8.RuneCountInString(cmdStr)}))
+	return update, nil
+}
+
+// "explain this error" (/ai:explain [line]), for commands that exited with a non-zero exit code.  the tail of the
+// output (see sstore/cmderror.go) is sent with the command, the explanation is added to the screen as a comment line.
+
+const MaxAIExplainLen = 2000
+
+func getAIExplainPrompt(cmd *sstore.CmdType, outputTail string, shellType string, osType string) []packet.OpenAIPromptMessageType {
+	var sysBuf strings.Builder
+	sysBuf.WriteString("You explain why shell commands failed.")
+	sysBuf.WriteString(fmt.Sprintf(" The user is using the %q shell on %s.", shellType, osType))
+	sysBuf.WriteString(" Explain the error in one to three short sentences and, if there is an obvious fix, give the corrected command.")
+	sysBuf.WriteString(" Do not use markdown formatting.")
+	var userBuf strings.Builder
+	userBuf.WriteString(fmt.Sprintf("The command %q exited with exit code %d.", cmd.CmdStr, cmd.ExitCode))
+	if outputTail != "" {
+		userBuf.WriteString(" The end of its output was:\n")
+		userBuf.WriteString(outputTail)
+	} else {
+		userBuf.WriteString(" It did not produce any output.")
+	}
+	return []packet.OpenAIPromptMessageType{
+		{Role: sstore.OpenAIRoleSystem, Content: sysBuf.String()},
+		{Role: sstore.OpenAIRoleUser, Content: userBuf.String()},
+	}
+}
+
+func AIExplainCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, fmt.Errorf("/ai:explain error: %w", err)
+	}
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf("usage: /ai:explain [line]")
+	}
+	lineId, err := sstore.FindLineIdByArg(ctx, ids.ScreenId, pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("/ai:explain error looking up lineid: %v", err)
+	}
+	if lineId == "" {
+		return nil, fmt.Errorf("/ai:explain line %q not found", pk.Args[0])
+	}
+	cmd, err := sstore.GetCmdByScreenId(ctx, ids.ScreenId, lineId)
+	if err != nil {
+		return nil, fmt.Errorf("/ai:explain error getting cmd: %v", err)
+	}
+	if cmd == nil {
+		return nil, fmt.Errorf("/ai:explain line %q is not a command", pk.Args[0])
+	}
+	if cmd.IsRunning() || (cmd.ExitCode == 0 && cmd.Status != sstore.CmdStatusError) {
+		return nil, fmt.Errorf("/ai:explain command did not fail")
+	}
+	outputTail, err := sstore.GetCmdErrorTail(ctx, cmd)
+	if err != nil {
+		// the output may be gone (archived or deleted), explain with the command and exit code only
+		log.Printf("/ai:explain cannot read output of %s/%s: %v\n", cmd.ScreenId, cmd.LineId, err)
+	}
+	clientId, opts, err := getAICmdOpts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var shellType string
+	if ids.Remote != nil {
+		shellType = ids.Remote.ShellType
+	}
+	aiCtx, cancelFn := context.WithTimeout(context.Background(), getAICmdTimeout(opts))
+	defer cancelFn()
+	respText, err := runAICmdCompletion(aiCtx, clientId, opts, getAIExplainPrompt(cmd, outputTail, shellType, GetOsTypeFromRuntime()))
+	if err != nil {
+		return nil, fmt.Errorf("/ai:explain error calling AI provider: %v", err)
+	}
+	explanation := strings.TrimSpace(respText)
+	if explanation == "" {
+		return nil, fmt.Errorf("/ai:explain no explanation returned")
+	}
+	if len(explanation) > MaxAIExplainLen {
+		explanation = explanation[:MaxAIExplainLen] + "..."
+	}
+	rtnLine, err := sstore.AddCommentLine(ctx, ids.ScreenId, DefaultUserId, fmt.Sprintf("[exit %d] %s", cmd.ExitCode, explanation))
+	if err != nil {
+		return nil, err
+	}
+	update := scbus.MakeUpdatePacket()
+	sstore.AddLineUpdate(update, rtnLine, nil)
+	return update, nil
+}
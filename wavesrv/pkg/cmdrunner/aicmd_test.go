@@ -0,0 +1,62 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func TestExtractAICmdStr(t *testing.T) {
+	tests := []struct {
+		resp string
+		want string
+	}{
+		{"ls -la", "ls -la"},
+		{"  `ls -la`\n", "ls -la"},
+		{"$ git status", "git status"},
+		{"```bash\nfind . -name '*.go'\n```", "find . -name '*.go'"},
+		{"Here is the command:\n```\n$ du -sh *\n```\nThis shows the sizes.", "du -sh *"},
+		{"```\n```", ""},
+	}
+	for _, test := range tests {
+		if got := extractAICmdStr(test.resp); got != test.want {
+			t.Errorf("extractAICmdStr(%q) = %q, want %q", test.resp, got, test.want)
+		}
+	}
+}
+
+func TestGetAICmdPrompt(t *testing.T) {
+	prompt := getAICmdPrompt("list large files", aiCmdContext{Cwd: "/tmp", ShellType: "zsh", OsType: "darwin", RecentCmds: []string{"cd /tmp"}})
+	if len(prompt) != 2 || prompt[0].Role != sstore.OpenAIRoleSystem || prompt[1].Role != sstore.OpenAIRoleUser {
+		t.Fatalf("invalid prompt messages: %v", prompt)
+	}
+	sysMsg := prompt[0].Content
+	for _, str := range []string{`"zsh"`, "darwin", `"/tmp"`, "cd /tmp"} {
+		if !strings.Contains(sysMsg, str) {
+			t.Errorf("system message missing %q: %q", str, sysMsg)
+		}
+	}
+	if prompt[1].Content != "list large files" {
+		t.Errorf("invalid user message: %q", prompt[1].Content)
+	}
+}
+
+func TestGetAIExplainPrompt(t *testing.T) {
+	cmd := &sstore.CmdType{CmdStr: "make build", ExitCode: 2}
+	prompt := getAIExplainPrompt(cmd, "make: *** No rule to make target 'build'.  Stop.", "bash", "linux")
+	if len(prompt) != 2 || prompt[0].Role != sstore.OpenAIRoleSystem {
+		t.Fatalf("invalid prompt messages: %v", prompt)
+	}
+	userMsg := prompt[1].Content
+	if !strings.Contains(userMsg, `"make build"`) || !strings.Contains(userMsg, "exit code 2") || !strings.Contains(userMsg, "No rule to make target") {
+		t.Errorf("invalid user message: %q", userMsg)
+	}
+	prompt = getAIExplainPrompt(cmd, "", "bash", "linux")
+	if !strings.Contains(prompt[1].Content, "did not produce any output") {
+		t.Errorf("invalid user message without output: %q", prompt[1].Content)
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// line output attachments for the cmdinfo chat (/ai:attach [line...]).  the (ansi stripped) end of the output
+// of the attached lines is sent with the chat as a system message.  the prompt is built within a token budget
+// (estimated, see estimateTokens): attachments with the same output are sent once, each attachment is trimmed
+// to AIAttachmentMaxTokens (keeping its first and last lines), then the oldest attachments and the oldest
+// chat messages are dropped until the prompt fits.  the last message (the user's question) is always sent.
+
+const AIAttachmentMaxBytes = 32 * 1024 // output read per attachment
+const AIAttachmentMaxTokens = 1500
+const AIAttachmentHeadLines = 10
+const AIContextWindowTokens = 8000 // prompt + response
+const MaxAIAttachments = 10
+
+// rough estimate for english text and command output (~4 bytes per token)
+func estimateTokens(str string) int {
+	return len(str)/4 + 1
+}
+
+// keeps the first AIAttachmentHeadLines lines (if they take less than half of maxTokens) and as many of the last
+// lines as fit in maxTokens.  a last line that does not fit is cut (its end is kept).
+func trimAttachmentOutput(output string, maxTokens int) (string, bool) {
+	if estimateTokens(output) <= maxTokens {
+		return output, false
+	}
+	lines := strings.Split(output, "\n")
+	headLines := lines[:min(AIAttachmentHeadLines, len(lines)-1)]
+	if estimateTokens(strings.Join(headLines, "\n")) > maxTokens/2 {
+		headLines = nil
+	}
+	budget := maxTokens - estimateTokens(strings.Join(headLines, "\n")) - 10
+	tailStart := len(lines)
+	for tailStart > len(headLines) {
+		lineTokens := estimateTokens(lines[tailStart-1])
+		if lineTokens > budget {
+			break
+		}
+		budget -= lineTokens
+		tailStart--
+	}
+	tailLines := lines[tailStart:]
+	if len(tailLines) == 0 {
+		lastLine := lines[len(lines)-1]
+		tailLines = []string{lastLine[len(lastLine)-min(len(lastLine), max(0, budget*4)):]}
+		tailStart = len(lines) - 1
+	}
+	var buf strings.Builder
+	if len(headLines) > 0 {
+		buf.WriteString(strings.Join(headLines, "\n") + "\n")
+	}
+	if numOmitted := tailStart - len(headLines); numOmitted > 0 {
+		buf.WriteString(fmt.Sprintf("... (%d lines omitted) ...\n", numOmitted))
+	} else {
+		buf.WriteString("...")
+	}
+	buf.WriteString(strings.Join(tailLines, "\n"))
+	return buf.String(), true
+}
+
+func formatAttachment(attachment *sstore.OpenAICmdInfoAttachmentType, output string) string {
+	var buf strings.Builder
+	buf.WriteString(fmt.Sprintf("Command (line %d, exit code %d): %s\n", attachment.LineNum, attachment.ExitCode, attachment.CmdStr))
+	if attachment.Truncated {
+		buf.WriteString("Output (only the end of the output was captured):\n")
+	} else {
+		buf.WriteString("Output:\n")
+	}
+	buf.WriteString("```\n" + output + "\n```\n")
+	return buf.String()
+}
+
+// builds the attachments system message (most recent attachments first, until the budget is used up).
+// returns "" if no attachment fits.
+func buildAttachmentsMessage(attachments []*sstore.OpenAICmdInfoAttachmentType, budget int) string {
+	header := "The user attached the output of these commands from their terminal:\n\n"
+	budget -= estimateTokens(header)
+	seenOutputs := make(map[string]bool)
+	var parts []string
+	for idx := len(attachments) - 1; idx >= 0; idx-- {
+		attachment := attachments[idx]
+		dedupKey := attachment.CmdStr + "\x00" + attachment.Output
+		if seenOutputs[dedupKey] {
+			continue
+		}
+		seenOutputs[dedupKey] = true
+		output, trimmed := trimAttachmentOutput(attachment.Output, min(AIAttachmentMaxTokens, budget))
+		if trimmed && estimateTokens(output) < 50 {
+			// not enough budget left for a useful part of the output
+			break
+		}
+		part := formatAttachment(attachment, output)
+		partTokens := estimateTokens(part)
+		if partTokens > budget {
+			break
+		}
+		budget -= partTokens
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	// back to attach order
+	for left, right := 0, len(parts)-1; left < right; left, right = left+1, right-1 {
+		parts[left], parts[right] = parts[right], parts[left]
+	}
+	return header + strings.Join(parts, "\n")
+}
+
+// the prompt for the cmdinfo chat: the chat messages (see BuildOpenAIPromptArrayWithContext) plus the attachments,
+// within the context window (less maxResponseTokens).  chat messages take precedence over attachments.
+func buildCmdInfoPromptWithAttachments(chat *sstore.OpenAICmdInfoChatStore, maxResponseTokens int) []packet.OpenAIPromptMessageType {
+	messages := BuildOpenAIPromptArrayWithContext(chat.Messages)
+	budget := AIContextWindowTokens - maxResponseTokens
+	if budget < AIAttachmentMaxTokens {
+		budget = AIAttachmentMaxTokens
+	}
+	// drop the oldest messages that do not fit (the last message is always sent)
+	startIdx := len(messages)
+	for startIdx > 0 {
+		msgTokens := estimateTokens(messages[startIdx-1].Content)
+		if msgTokens > budget && startIdx < len(messages) {
+			break
+		}
+		budget -= msgTokens
+		startIdx--
+	}
+	messages = messages[startIdx:]
+	attachmentsMsg := buildAttachmentsMessage(chat.Attachments, budget)
+	if attachmentsMsg == "" {
+		return messages
+	}
+	rtn := make([]packet.OpenAIPromptMessageType, 0, len(messages)+1)
+	rtn = append(rtn, packet.OpenAIPromptMessageType{Role: sstore.OpenAIRoleSystem, Content: attachmentsMsg})
+	return append(rtn, messages...)
+}
+
+func makeCmdInfoAttachment(ctx context.Context, screenId string, lineArg string) (*sstore.OpenAICmdInfoAttachmentType, error) {
+	lineId, err := sstore.FindLineIdByArg(ctx, screenId, lineArg)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up lineid: %v", err)
+	}
+	if lineId == "" {
+		return nil, fmt.Errorf("line %q not found", lineArg)
+	}
+	line, cmd, err := sstore.GetLineCmdByLineId(ctx, screenId, lineId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting line: %v", err)
+	}
+	if line == nil || cmd == nil {
+		return nil, fmt.Errorf("line %q is not a command", lineArg)
+	}
+	stat, err := sstore.StatCmdPtyFile(ctx, screenId, lineId)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read output of line %d: %v", line.LineNum, err)
+	}
+	endPos := stat.FileOffset + stat.DataSize
+	realOffset, data, err := sstore.ReadPtyOutFile(ctx, screenId, lineId, endPos-AIAttachmentMaxBytes, AIAttachmentMaxBytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read output of line %d: %v", line.LineNum, err)
+	}
+	return &sstore.OpenAICmdInfoAttachmentType{
+		LineId:    lineId,
+		LineNum:   line.LineNum,
+		CmdStr:    cmd.CmdStr,
+		ExitCode:  cmd.ExitCode,
+		Output:    strings.Join(sstore.StripAnsiLines(data), "\n"),
+		Truncated: realOffset > 0,
+		Ts:        time.Now().UnixMilli(),
+	}, nil
+}
+
+// /ai:attach [line...], with remove=1 the lines are detached, with clear=1 all attachments are removed
+func AIAttachCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, fmt.Errorf("/ai:attach error: %w", err)
+	}
+	if resolveBool(pk.Kwargs["clear"], false) {
+		sstore.ScreenMemClearCmdInfoChatAttachments(ids.ScreenId)
+		return sstore.UpdateWithCurrentOpenAICmdInfoChat(ids.ScreenId, nil), nil
+	}
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf("usage: /ai:attach [line...] (remove=1 to detach, clear=1 to remove all attachments)")
+	}
+	if resolveBool(pk.Kwargs["remove"], false) {
+		for _, lineArg := range pk.Args {
+			lineId, err := sstore.FindLineIdByArg(ctx, ids.ScreenId, lineArg)
+			if err != nil {
+				return nil, fmt.Errorf("/ai:attach error looking up lineid: %v", err)
+			}
+			if lineId == "" || !sstore.ScreenMemRemoveCmdInfoChatAttachment(ids.ScreenId, lineId) {
+				return nil, fmt.Errorf("/ai:attach line %q is not attached", lineArg)
+			}
+		}
+		return sstore.UpdateWithCurrentOpenAICmdInfoChat(ids.ScreenId, nil), nil
+	}
+	for _, lineArg := range pk.Args {
+		attachment, err := makeCmdInfoAttachment(ctx, ids.ScreenId, lineArg)
+		if err != nil {
+			return nil, fmt.Errorf("/ai:attach error: %v", err)
+		}
+		numOther := 0
+		for _, curAttachment := range sstore.ScreenMemGetCmdInfoChat(ids.ScreenId).Attachments {
+			if curAttachment.LineId != attachment.LineId {
+				numOther++
+			}
+		}
+		if numOther >= MaxAIAttachments {
+			return nil, fmt.Errorf("/ai:attach too many attachments (max %d)", MaxAIAttachments)
+		}
+		sstore.ScreenMemAddCmdInfoChatAttachment(ids.ScreenId, attachment)
+	}
+	return sstore.UpdateWithCurrentOpenAICmdInfoChat(ids.ScreenId, nil), nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func makeTestOutput(numLines int) string {
+	var lines []string
+	for i := 1; i <= numLines; i++ {
+		lines = append(lines, fmt.Sprintf("output line %d", i))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestTrimAttachmentOutput(t *testing.T) {
+	output := makeTestOutput(1000)
+	trimmed, wasTrimmed := trimAttachmentOutput(output, 200)
+	if !wasTrimmed || estimateTokens(trimmed) > 200 {
+		t.Fatalf("output not trimmed to budget: %d tokens", estimateTokens(trimmed))
+	}
+	if !strings.HasPrefix(trimmed, "output line 1\n") || !strings.HasSuffix(trimmed, "output line 1000") || !strings.Contains(trimmed, "lines omitted") {
+		t.Errorf("trimmed output must keep the first and last lines: %q", trimmed)
+	}
+	short := makeTestOutput(5)
+	if rtn, wasTrimmed := trimAttachmentOutput(short, 200); wasTrimmed || rtn != short {
+		t.Errorf("short output must not be trimmed")
+	}
+	longLine := strings.Repeat("x", 10000) + "END"
+	trimmed, _ = trimAttachmentOutput(longLine, 100)
+	if estimateTokens(trimmed) > 100 || !strings.HasSuffix(trimmed, "END") {
+		t.Errorf("long line not cut: %d tokens", estimateTokens(trimmed))
+	}
+}
+
+func TestBuildCmdInfoPromptWithAttachments(t *testing.T) {
+	chat := &sstore.OpenAICmdInfoChatStore{
+		Messages: []*packet.OpenAICmdInfoChatMessage{
+			{MessageID: 0, IsAssistantResponse: true, AssistantResponse: &packet.OpenAICmdInfoPacketOutputType{Message: "hello"}},
+			{MessageID: 1, UserQuery: "why did my build fail?"},
+		},
+		Attachments: []*sstore.OpenAICmdInfoAttachmentType{
+			{LineId: "l1", LineNum: 1, CmdStr: "make", ExitCode: 2, Output: "make: *** [all] Error 2"},
+			{LineId: "l2", LineNum: 2, CmdStr: "make", ExitCode: 2, Output: "make: *** [all] Error 2"},
+			{LineId: "l3", LineNum: 3, CmdStr: "cat big.log", Output: makeTestOutput(20000)},
+		},
+	}
+	prompt := buildCmdInfoPromptWithAttachments(chat, 1000)
+	if len(prompt) != 3 || prompt[0].Role != sstore.OpenAIRoleSystem || prompt[2].Content != "why did my build fail?" {
+		t.Fatalf("invalid prompt: %v", prompt)
+	}
+	attachMsg := prompt[0].Content
+	if strings.Count(attachMsg, "make: *** [all] Error 2") != 1 {
+		t.Errorf("duplicate attachments must be sent once: %q", attachMsg)
+	}
+	if !strings.Contains(attachMsg, "lines omitted") || !strings.Contains(attachMsg, "output line 20000") {
+		t.Errorf("large attachment must be trimmed")
+	}
+	totalTokens := 0
+	for _, msg := range prompt {
+		totalTokens += estimateTokens(msg.Content)
+	}
+	if totalTokens > AIContextWindowTokens-1000 {
+		t.Errorf("prompt over budget: %d tokens", totalTokens)
+	}
+
+	// the question is always sent, attachments are dropped when there is no budget left
+	chat.Messages[1].UserQuery = strings.Repeat("why? ", 10000)
+	prompt = buildCmdInfoPromptWithAttachments(chat, 1000)
+	if len(prompt) != 1 || prompt[0].Role != sstore.OpenAIRoleUser {
+		t.Errorf("expected only the question, got %d messages", len(prompt))
+	}
+}
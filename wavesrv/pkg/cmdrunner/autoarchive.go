@@ -0,0 +1,70 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// idle screen archiving (see sstore/autoarchive.go)
+
+func formatIdleScreens(idleScreens []*sstore.IdleScreenType) string {
+	var buf bytes.Buffer
+	for _, idleScreen := range idleScreens {
+		lastActive := time.UnixMilli(idleScreen.LastActiveTs).Format("2006-01-02 15:04")
+		buf.WriteString(fmt.Sprintf("  %-20s  %-20s  last active %s\n", idleScreen.SessionName, idleScreen.Name, lastActive))
+	}
+	return buf.String()
+}
+
+// /screen:archiveidle [days=N] [dryrun=1], archives the screens in all sessions idle for N days (defaults to
+// the autoarchivedays client opt)
+func ScreenArchiveIdleCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	clientData, err := sstore.EnsureClientData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	idleDays, err := resolvePosInt(pk.Kwargs["days"], clientData.ClientOpts.AutoArchiveDays)
+	if err != nil {
+		return nil, fmt.Errorf("/screen:archiveidle invalid days: %v", err)
+	}
+	if idleDays <= 0 {
+		return nil, fmt.Errorf("/screen:archiveidle requires days=N (autoarchivedays is not set)")
+	}
+	if resolveBool(pk.Kwargs["dryrun"], false) {
+		idleScreens, err := sstore.GetIdleScreens(ctx, idleDays)
+		if err != nil {
+			return nil, fmt.Errorf("/screen:archiveidle error: %v", err)
+		}
+		if len(idleScreens) == 0 {
+			return sstore.InfoMsgUpdate("no screens idle for %d days", idleDays), nil
+		}
+		update := scbus.MakeUpdatePacket()
+		update.AddUpdate(sstore.InfoMsgType{
+			InfoTitle: fmt.Sprintf("screens idle for %d days (would be archived)", idleDays),
+			InfoLines: splitLinesForInfo(formatIdleScreens(idleScreens)),
+		})
+		return update, nil
+	}
+	archived, update, err := sstore.ArchiveIdleScreens(ctx, idleDays)
+	if err != nil {
+		return nil, fmt.Errorf("/screen:archiveidle error: %v", err)
+	}
+	if len(archived) == 0 {
+		update.AddUpdate(sstore.InfoMsgType{InfoMsg: fmt.Sprintf("no screens idle for %d days", idleDays), TimeoutMs: 2000})
+		return update, nil
+	}
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: fmt.Sprintf("archived %d screens idle for %d days", len(archived), idleDays),
+		InfoLines: splitLinesForInfo(formatIdleScreens(archived)),
+	})
+	return update, nil
+}
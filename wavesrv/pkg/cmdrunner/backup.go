@@ -0,0 +1,88 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/base"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func resolveBackupDir(ctx context.Context, pk *scpacket.FeCommandPacketType) (string, sstore.ClientOptsType, error) {
+	clientData, err := sstore.EnsureClientData(ctx)
+	if err != nil {
+		return "", sstore.ClientOptsType{}, err
+	}
+	if pk.Kwargs["dir"] != "" {
+		return base.ExpandHomeDir(pk.Kwargs["dir"]), clientData.ClientOpts, nil
+	}
+	return sstore.GetBackupDir(clientData.ClientOpts), clientData.ClientOpts, nil
+}
+
+// /client:backup [dir=], backs up the db, blockstore, and screen dirs now (see sstore/backup.go)
+func ClientBackupCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	backupDir, clientOpts, err := resolveBackupDir(ctx, pk)
+	if err != nil {
+		return nil, err
+	}
+	info, err := sstore.CreateBackup(ctx, backupDir, sstore.GetBackupKeep(clientOpts))
+	if err != nil {
+		return nil, fmt.Errorf("/client:backup error: %v", err)
+	}
+	return sstore.InfoMsgUpdate("created backup %s (%s, %d screen files)", info.Dir, prettyPrintByteSize(info.Size), info.NumScreenFiles), nil
+}
+
+// /client:backups [dir=]
+func ClientBackupsCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	backupDir, _, err := resolveBackupDir(ctx, pk)
+	if err != nil {
+		return nil, err
+	}
+	backups, err := sstore.ListBackups(backupDir)
+	if err != nil {
+		return nil, fmt.Errorf("/client:backups error: %v", err)
+	}
+	if len(backups) == 0 {
+		return sstore.InfoMsgUpdate("no backups in %s", backupDir), nil
+	}
+	tsFmt := sstore.GetClientTsFormat(ctx)
+	var buf bytes.Buffer
+	for _, backup := range backups {
+		tsStr := tsFmt.Format(time.UnixMilli(backup.CreatedTs))
+		buf.WriteString(fmt.Sprintf("  %s  %s  %s, db v%d\n", backup.Name, tsStr, prettyPrintByteSize(backup.Size), backup.DBVersion))
+	}
+	if sstore.HasPendingRestore() {
+		buf.WriteString("a restore is pending (applied on the next start)\n")
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{InfoTitle: fmt.Sprintf("backups in %s", backupDir), InfoLines: splitLinesForInfo(buf.String())})
+	return update, nil
+}
+
+// /client:restore path, path is a backup dir (or the name of a backup in the backup dir)
+func ClientRestoreCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if len(pk.Args) == 0 || pk.Args[0] == "" {
+		return nil, fmt.Errorf("usage: /client:restore [backup dir or name]")
+	}
+	backupPath := base.ExpandHomeDir(pk.Args[0])
+	if !filepath.IsAbs(backupPath) {
+		backupDir, _, err := resolveBackupDir(ctx, pk)
+		if err != nil {
+			return nil, err
+		}
+		backupPath = filepath.Join(backupDir, backupPath)
+	}
+	info, err := sstore.RestoreFromBackup(ctx, backupPath)
+	if err != nil {
+		return nil, fmt.Errorf("/client:restore error: %v", err)
+	}
+	return sstore.InfoMsgUpdate("backup %s will be restored when wave is restarted", info.Name), nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// selects the screens for /screen:bulk, all=1 selects every screen, archived=1 the archived screens,
+// otherwise the screen args are resolved
+func resolveBulkScreenIds(ctx context.Context, pk *scpacket.FeCommandPacketType, sessionId string, curScreenId string) ([]string, error) {
+	allScreens := resolveBool(pk.Kwargs["all"], false)
+	archivedScreens := resolveBool(pk.Kwargs["archived"], false)
+	screenArgs := pk.Args[1:]
+	if !allScreens && !archivedScreens && len(screenArgs) == 0 {
+		return nil, fmt.Errorf("no screens given (pass screen args, all=1, or archived=1)")
+	}
+	if allScreens || archivedScreens {
+		screens, err := sstore.GetSessionScreens(ctx, sessionId)
+		if err != nil {
+			return nil, err
+		}
+		var rtn []string
+		for _, screen := range screens {
+			if allScreens || screen.Archived {
+				rtn = append(rtn, screen.ScreenId)
+			}
+		}
+		return rtn, nil
+	}
+	var rtn []string
+	seen := make(map[string]bool)
+	for _, screenArg := range screenArgs {
+		ri, err := resolveSessionScreen(ctx, sessionId, screenArg, curScreenId)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve screen %q: %v", screenArg, err)
+		}
+		if !seen[ri.Id] {
+			seen[ri.Id] = true
+			rtn = append(rtn, ri.Id)
+		}
+	}
+	return rtn, nil
+}
+
+// sends SIGHUP to the running commands of the screens (same as /screen:delete)
+func hangupScreenCmds(ctx context.Context, screenIds []string) error {
+	for _, screenId := range screenIds {
+		runningCmds, err := sstore.GetRunningScreenCmds(ctx, screenId)
+		if err != nil {
+			return fmt.Errorf("cannot get running cmds: %v", err)
+		}
+		for _, runningCmd := range runningCmds {
+			remote.SendSignalToCmd(ctx, runningCmd, "SIGHUP")
+		}
+	}
+	return nil
+}
+
+func formatBulkOp(op sstore.BulkOpType) string {
+	rtn := fmt.Sprintf("%s  %-13s %-8s %d/%d", op.OpId, op.OpType, op.Status, op.NumDone+op.NumSkipped, op.NumTotal)
+	if op.NumSkipped > 0 {
+		rtn += fmt.Sprintf(" (%d skipped)", op.NumSkipped)
+	}
+	if op.Status == sstore.BulkOpStatusRunning {
+		rtn += fmt.Sprintf("  %v", time.Since(time.UnixMilli(op.StartTs)).Round(time.Second))
+	}
+	return rtn
+}
+
+func bulkOpStartedUpdate(op sstore.BulkOpType, desc string) scbus.UpdatePacket {
+	update := sstore.InfoMsgUpdate("%s, cancel with /client:bulkops cancel=%s", desc, op.OpId)
+	update.AddUpdate(op)
+	return update
+}
+
+// /screen:bulk (archive|delete) [all=1] [archived=1] [screen ...]
+// runs in the background in chunks, progress is sent as bulkop updates
+func ScreenBulkCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session)
+	if err != nil {
+		return nil, fmt.Errorf("/screen:bulk %w", err)
+	}
+	if len(pk.Args) == 0 || (pk.Args[0] != sstore.BulkOpTypeArchive && pk.Args[0] != sstore.BulkOpTypeDelete) {
+		return nil, fmt.Errorf("usage: /screen:bulk (archive|delete) [all=1] [archived=1] [screen ...]")
+	}
+	opType := pk.Args[0]
+	screenIds, err := resolveBulkScreenIds(ctx, pk, ids.SessionId, ids.ScreenId)
+	if err != nil {
+		return nil, fmt.Errorf("/screen:bulk %v", err)
+	}
+	if len(screenIds) == 0 {
+		return sstore.InfoMsgUpdate("no screens to %s", opType), nil
+	}
+	if opType == sstore.BulkOpTypeDelete {
+		err = hangupScreenCmds(ctx, screenIds)
+		if err != nil {
+			return nil, fmt.Errorf("/screen:bulk %v", err)
+		}
+	}
+	op, err := sstore.StartBulkScreenOp(opType, ids.SessionId, screenIds)
+	if err != nil {
+		return nil, fmt.Errorf("/screen:bulk cannot start: %v", err)
+	}
+	verb := "archiving"
+	if opType == sstore.BulkOpTypeDelete {
+		verb = "deleting"
+	}
+	return bulkOpStartedUpdate(op, fmt.Sprintf("%s %d screens", verb, len(screenIds))), nil
+}
+
+// /client:bulkops [cancel=opid]
+func ClientBulkOpsCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if opId, found := pk.Kwargs["cancel"]; found {
+		if !sstore.CancelBulkOp(opId) {
+			return nil, fmt.Errorf("/client:bulkops no running operation %q", opId)
+		}
+		return sstore.InfoMsgUpdate("canceling bulk operation %s (stops after the current chunk)", opId), nil
+	}
+	var buf bytes.Buffer
+	ops := sstore.GetBulkOps()
+	if len(ops) == 0 {
+		buf.WriteString("no bulk operations running\n")
+	}
+	for _, op := range ops {
+		buf.WriteString(formatBulkOp(op) + "\n")
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: "bulk operations",
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
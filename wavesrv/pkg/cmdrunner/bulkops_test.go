@@ -0,0 +1,30 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func TestFormatBulkOp(t *testing.T) {
+	op := sstore.BulkOpType{OpId: "op1", OpType: sstore.BulkOpTypeArchive, Status: sstore.BulkOpStatusDone, NumTotal: 10, NumDone: 7, NumSkipped: 1}
+	output := formatBulkOp(op)
+	if !strings.Contains(output, "8/10") || !strings.Contains(output, "(1 skipped)") {
+		t.Errorf("invalid bulk op output: %q", output)
+	}
+}
+
+func TestResolveBulkScreenIdsNoScreens(t *testing.T) {
+	pk := scpacket.MakeFeCommandPacket()
+	pk.Args = []string{sstore.BulkOpTypeDelete}
+	_, err := resolveBulkScreenIds(context.Background(), pk, "session", "screen")
+	if err == nil {
+		t.Errorf("expected error with no screens selected")
+	}
+}
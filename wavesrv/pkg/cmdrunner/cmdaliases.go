@@ -0,0 +1,148 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// command aliases (see sstore/cmdaliases.go).  RunCommand expands the first word of the command (like a shell
+// alias) before the command policy checks and before it is sent to the remote.  an alias can expand to another
+// alias (but not to itself).  quoting or escaping the word ("ll" or \ll) skips the expansion, like in the shell.
+
+const MaxCmdAliasDepth = 10
+const MaxCmdAliasNameLen = 50
+
+// no "/" or "!" at the start (meta commands and history expansion)
+var cmdAliasNameRe = regexp.MustCompile(`^[A-Za-z0-9_.+-][A-Za-z0-9_.+:-]*$`)
+
+func validateCmdAliasName(name string) error {
+	if len(name) > MaxCmdAliasNameLen {
+		return fmt.Errorf("alias name too long, max length = %d", MaxCmdAliasNameLen)
+	}
+	if !cmdAliasNameRe.MatchString(name) {
+		return fmt.Errorf("invalid alias name %q (letters, numbers, and '_', '.', '+', '-', ':' are allowed)", name)
+	}
+	return nil
+}
+
+// splits off the first word of the command, prefix is the leading whitespace, rest starts at the character after
+// the word (whitespace or a shell operator)
+func splitCmdFirstWord(cmdStr string) (string, string, string) {
+	trimmed := strings.TrimLeft(cmdStr, " \t\n")
+	prefix := cmdStr[:len(cmdStr)-len(trimmed)]
+	wordEnd := strings.IndexAny(trimmed, " \t\n;&|<>()")
+	if wordEnd == -1 {
+		return prefix, trimmed, ""
+	}
+	return prefix, trimmed[:wordEnd], trimmed[wordEnd:]
+}
+
+// returns the expanded command and the alias of the typed word (nil if there was no expansion)
+func expandCmdAliases(cmdStr string, aliases map[string]*sstore.CmdAliasType) (string, *sstore.CmdAliasType) {
+	var firstAlias *sstore.CmdAliasType
+	expanded := make(map[string]bool)
+	for depth := 0; depth < MaxCmdAliasDepth; depth++ {
+		prefix, word, rest := splitCmdFirstWord(cmdStr)
+		alias := aliases[word]
+		if alias == nil || expanded[word] {
+			break
+		}
+		expanded[word] = true
+		if firstAlias == nil {
+			firstAlias = alias
+		}
+		cmdStr = prefix + alias.Expansion + rest
+	}
+	return cmdStr, firstAlias
+}
+
+// for aliases that do not preserve the raw command, history records the expanded command
+func setHistoryCmdStr(ctx context.Context, cmdStr string) {
+	ctxVal := ctx.Value(historyContextKey)
+	if ctxVal == nil {
+		return
+	}
+	ctxVal.(*historyContextType).CmdStr = cmdStr
+}
+
+// /alias:set [name] "[expansion]" preserveraw=0|1
+func CmdAliasSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if len(pk.Args) != 2 {
+		return nil, fmt.Errorf("usage: /alias:set [name] \"[expansion]\"")
+	}
+	name := pk.Args[0]
+	err := validateCmdAliasName(name)
+	if err != nil {
+		return nil, fmt.Errorf("/alias:set %v", err)
+	}
+	expansion := strings.TrimSpace(pk.Args[1])
+	if expansion == "" {
+		return nil, fmt.Errorf("/alias:set expansion cannot be empty")
+	}
+	alias := &sstore.CmdAliasType{
+		AliasId:     scbase.GenWaveUUID(),
+		Name:        name,
+		Expansion:   expansion,
+		PreserveRaw: resolveBool(pk.Kwargs["preserveraw"], true),
+		CreatedTs:   time.Now().UnixMilli(),
+	}
+	err = sstore.SaveCmdAlias(ctx, alias)
+	if err != nil {
+		return nil, fmt.Errorf("/alias:set error saving alias: %v", err)
+	}
+	if !alias.PreserveRaw {
+		return sstore.InfoMsgUpdate("abbreviation %q set, %q is recorded as %q", name, name, expansion), nil
+	}
+	return sstore.InfoMsgUpdate("alias %q set, %q runs %q", name, name, expansion), nil
+}
+
+func CmdAliasShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	aliases, err := sstore.GetCmdAliases(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("/alias:show error getting aliases: %v", err)
+	}
+	if len(aliases) == 0 {
+		return sstore.InfoMsgUpdate("no aliases, add one with /alias:set [name] \"[expansion]\""), nil
+	}
+	var buf bytes.Buffer
+	for _, alias := range aliases {
+		abbrStr := ""
+		if !alias.PreserveRaw {
+			abbrStr = " (abbr)"
+		}
+		buf.WriteString(fmt.Sprintf("  %-15s %s%s\n", alias.Name, alias.Expansion, abbrStr))
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{InfoTitle: "aliases", InfoLines: splitLinesForInfo(buf.String())})
+	return update, nil
+}
+
+func CmdAliasDeleteCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if len(pk.Args) != 1 {
+		return nil, fmt.Errorf("usage: /alias:delete [name]")
+	}
+	alias, err := sstore.GetCmdAliasByArg(ctx, pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("/alias:delete error getting alias: %v", err)
+	}
+	if alias == nil {
+		return nil, fmt.Errorf("/alias:delete alias %q not found", pk.Args[0])
+	}
+	err = sstore.DeleteCmdAlias(ctx, alias.AliasId)
+	if err != nil {
+		return nil, fmt.Errorf("/alias:delete error deleting alias: %v", err)
+	}
+	return sstore.InfoMsgUpdate("alias %q deleted", alias.Name), nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"testing"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func TestExpandCmdAliases(t *testing.T) {
+	aliases := map[string]*sstore.CmdAliasType{
+		"ll":    {Name: "ll", Expansion: "ls -la", PreserveRaw: true},
+		"lll":   {Name: "lll", Expansion: "ll -h"},
+		"ls":    {Name: "ls", Expansion: "ls --color=auto"},
+		"loop":  {Name: "loop", Expansion: "loop2 x"},
+		"loop2": {Name: "loop2", Expansion: "loop y"},
+	}
+	tests := []struct {
+		cmdStr   string
+		expected string
+		alias    string
+	}{
+		{"ll", "ls --color=auto -la", "ll"},
+		{"  ll -h /tmp", "  ls --color=auto -la -h /tmp", "ll"},
+		{"ll|grep x", "ls --color=auto -la|grep x", "ll"},
+		{"lll", "ls --color=auto -la -h", "lll"},
+		{"ls", "ls --color=auto", "ls"},
+		{"loop", "loop y x", "loop"},
+		{"echo ll", "echo ll", ""},
+		{"\\ll", "\\ll", ""},
+		{"'ll'", "'ll'", ""},
+		{"", "", ""},
+	}
+	for _, test := range tests {
+		cmdStr, alias := expandCmdAliases(test.cmdStr, aliases)
+		if cmdStr != test.expected {
+			t.Errorf("expand %q: got %q, expected %q", test.cmdStr, cmdStr, test.expected)
+		}
+		aliasName := ""
+		if alias != nil {
+			aliasName = alias.Name
+		}
+		if aliasName != test.alias {
+			t.Errorf("expand %q: got alias %q, expected %q", test.cmdStr, aliasName, test.alias)
+		}
+	}
+}
+
+func TestValidateCmdAliasName(t *testing.T) {
+	for _, name := range []string{"ll", "k", "git-st", "g.co", "x+y"} {
+		if err := validateCmdAliasName(name); err != nil {
+			t.Errorf("expected %q to be valid, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "/ll", "!x", "a b", "a|b"} {
+		if err := validateCmdAliasName(name); err == nil {
+			t.Errorf("expected %q to be invalid", name)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/utilfn"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// per-remote command policies (remoteopts cmddeny/cmdallow), guard rails for production connections.
+// a command is blocked if any of its simple commands matches a deny pattern and no allow pattern.
+// blocked commands can still be run with policyoverride=1.
+//
+// patterns match the leading words of a simple command ("kubectl delete" matches "kubectl delete pod x").
+// words may contain glob wildcards ("rm -*r*"), and patterns starting with "re:" are regular expressions
+// matched against the full command string.
+
+const KwArgPolicyOverride = "policyoverride"
+const KwArgConfirmDestructive = "confirmdestructive"
+
+const MaxCmdPolicyPatterns = 50
+const MaxCmdPolicyPatternLen = 200
+const CmdPolicyRegexPrefix = "re:"
+
+// wrapper commands that are skipped when matching (e.g. "sudo rm -rf /" matches "rm -rf")
+var cmdPolicyWrappers = map[string]bool{"sudo": true, "command": true, "exec": true, "nohup": true, "time": true}
+
+// commands that require confirmation on remotes whose policy sets ConfirmDestructive (see sstore.RemoteTagRules)
+var destructiveCmdPatterns = []string{
+	"rm -*[rRf]*",
+	"rmdir",
+	"dd",
+	"mkfs*",
+	"shred",
+	"truncate",
+	"shutdown",
+	"reboot",
+	"halt",
+	"poweroff",
+	"kill -9",
+	"killall",
+	"pkill",
+	"systemctl stop",
+	"systemctl restart",
+	"kubectl delete",
+	"docker rm",
+	"docker rmi",
+	"git push -f",
+	"git push --force",
+	"git reset --hard",
+	"git clean",
+	"re:(?i)\\b(drop|truncate)\\s+(table|database|schema)\\b",
+}
+
+func validateCmdPolicyPattern(pattern string) error {
+	if strings.TrimSpace(pattern) == "" {
+		return fmt.Errorf("pattern cannot be empty")
+	}
+	if len(pattern) > MaxCmdPolicyPatternLen {
+		return fmt.Errorf("pattern too long (max %d characters)", MaxCmdPolicyPatternLen)
+	}
+	if strings.HasPrefix(pattern, CmdPolicyRegexPrefix) {
+		_, err := regexp.Compile(pattern[len(CmdPolicyRegexPrefix):])
+		if err != nil {
+			return fmt.Errorf("invalid regular expression: %v", err)
+		}
+		return nil
+	}
+	for _, word := range strings.Fields(pattern) {
+		_, err := path.Match(word, "")
+		if err != nil {
+			return fmt.Errorf("invalid pattern word %q: %v", word, err)
+		}
+	}
+	return nil
+}
+
+func getPolicyWordStr(word *syntax.Word, cmdStr string) string {
+	var buf strings.Builder
+	for _, part := range word.Parts {
+		switch p := part.(type) {
+		case *syntax.Lit:
+			buf.WriteString(p.Value)
+		case *syntax.SglQuoted:
+			buf.WriteString(p.Value)
+		case *syntax.DblQuoted:
+			for _, dqPart := range p.Parts {
+				if lit, ok := dqPart.(*syntax.Lit); ok {
+					buf.WriteString(lit.Value)
+				} else {
+					buf.WriteString(cmdStr[dqPart.Pos().Offset():dqPart.End().Offset()])
+				}
+			}
+		default:
+			buf.WriteString(cmdStr[part.Pos().Offset():part.End().Offset()])
+		}
+	}
+	return buf.String()
+}
+
+// returns the words of each simple command in cmdStr (including commands in pipelines, lists, subshells, etc.)
+func getPolicySimpleCommands(cmdStr string) [][]string {
+	parser := syntax.NewParser(syntax.Variant(syntax.LangBash))
+	file, err := parser.Parse(strings.NewReader(cmdStr), "policy")
+	if err != nil {
+		// fall back to matching the command as one simple command
+		return [][]string{strings.Fields(cmdStr)}
+	}
+	var rtn [][]string
+	syntax.Walk(file, func(node syntax.Node) bool {
+		callExpr, ok := node.(*syntax.CallExpr)
+		if !ok || len(callExpr.Args) == 0 {
+			return true
+		}
+		var words []string
+		for _, arg := range callExpr.Args {
+			words = append(words, getPolicyWordStr(arg, cmdStr))
+		}
+		for len(words) > 1 && cmdPolicyWrappers[words[0]] {
+			words = words[1:]
+		}
+		rtn = append(rtn, words)
+		return true
+	})
+	return rtn
+}
+
+func matchCmdPolicyPattern(pattern string, cmdStr string, simpleCmds [][]string) bool {
+	if strings.HasPrefix(pattern, CmdPolicyRegexPrefix) {
+		re, err := regexp.Compile(pattern[len(CmdPolicyRegexPrefix):])
+		if err != nil {
+			return false
+		}
+		return re.MatchString(cmdStr)
+	}
+	patternWords := strings.Fields(pattern)
+	if len(patternWords) == 0 {
+		return false
+	}
+	for _, words := range simpleCmds {
+		if len(words) < len(patternWords) {
+			continue
+		}
+		matched := true
+		for idx, patternWord := range patternWords {
+			wordMatch, _ := path.Match(patternWord, words[idx])
+			if !wordMatch {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the deny pattern that blocks cmdStr, or "" if the command is allowed
+func CheckCmdPolicy(opts *sstore.RemoteOptsType, cmdStr string) string {
+	if opts == nil || len(opts.CmdDeny) == 0 {
+		return ""
+	}
+	simpleCmds := getPolicySimpleCommands(cmdStr)
+	for _, allowPattern := range opts.CmdAllow {
+		if matchCmdPolicyPattern(allowPattern, cmdStr, simpleCmds) {
+			return ""
+		}
+	}
+	for _, denyPattern := range opts.CmdDeny {
+		if matchCmdPolicyPattern(denyPattern, cmdStr, simpleCmds) {
+			return denyPattern
+		}
+	}
+	return ""
+}
+
+func checkRemoteCmdPolicy(pk *scpacket.FeCommandPacketType, remoteCopy *sstore.RemoteType, cmdStr string) error {
+	if remoteCopy == nil || resolveBool(pk.Kwargs[KwArgPolicyOverride], false) {
+		return nil
+	}
+	denyPattern := CheckCmdPolicy(remoteCopy.RemoteOpts, cmdStr)
+	if denyPattern == "" {
+		return nil
+	}
+	return fmt.Errorf("command blocked by the policy for remote %q (matches %q), add %s=1 to run it anyway", remoteCopy.GetName(), denyPattern, KwArgPolicyOverride)
+}
+
+// returns the destructive pattern matched by cmdStr, or "" if the command is not destructive
+func MatchDestructiveCmd(cmdStr string) string {
+	simpleCmds := getPolicySimpleCommands(cmdStr)
+	for _, pattern := range destructiveCmdPatterns {
+		if matchCmdPolicyPattern(pattern, cmdStr, simpleCmds) {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// remotes with a ConfirmDestructive policy (e.g. tagged "production") require confirmdestructive=1 to run destructive commands
+func checkRemoteDestructiveCmd(pk *scpacket.FeCommandPacketType, remoteCopy *sstore.RemoteType, cmdStr string) error {
+	if remoteCopy == nil || resolveBool(pk.Kwargs[KwArgConfirmDestructive], false) {
+		return nil
+	}
+	policyMeta := sstore.GetRemotePolicyMeta(remoteCopy.RemoteOpts, remoteCopy.GetName())
+	if policyMeta == nil || !policyMeta.ConfirmDestructive {
+		return nil
+	}
+	pattern := MatchDestructiveCmd(cmdStr)
+	if pattern == "" {
+		return nil
+	}
+	return fmt.Errorf("destructive command on remote %q (tags: %s) requires confirmation, add %s=1 to run it", remoteCopy.GetName(), strings.Join(policyMeta.Tags, ","), KwArgConfirmDestructive)
+}
+
+// allows "a,b" as well as "a b", "" clears the tags
+func resolveRemoteTags(tagsArg string) ([]string, error) {
+	rtn := []string{}
+	for _, tag := range strings.FieldsFunc(tagsArg, func(r rune) bool { return r == ',' || r == ' ' }) {
+		err := sstore.ValidateRemoteTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !utilfn.ContainsStr(rtn, tag) {
+			rtn = append(rtn, tag)
+		}
+	}
+	if len(rtn) > sstore.MaxRemoteTags {
+		return nil, fmt.Errorf("too many tags (max %d)", sstore.MaxRemoteTags)
+	}
+	return rtn, nil
+}
+
+func addCmdPolicyPattern(patterns []string, pattern string) ([]string, error) {
+	err := validateCmdPolicyPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	for _, existing := range patterns {
+		if existing == pattern {
+			return patterns, nil
+		}
+	}
+	if len(patterns) >= MaxCmdPolicyPatterns {
+		return nil, fmt.Errorf("too many patterns (max %d)", MaxCmdPolicyPatterns)
+	}
+	return append(append([]string{}, patterns...), pattern), nil
+}
+
+func removeCmdPolicyPattern(patterns []string, pattern string) []string {
+	rtn := []string{}
+	for _, existing := range patterns {
+		if existing != pattern {
+			rtn = append(rtn, existing)
+		}
+	}
+	return rtn
+}
+
+// idle-kill limits are in hours, 0 disables the policy
+func resolveIdleKillHours(arg string) (int, error) {
+	hours, err := resolveNonNegInt(arg, 0)
+	if err != nil {
+		return 0, err
+	}
+	if hours > remote.MaxIdleKillHours {
+		return 0, fmt.Errorf("limit too long (max %d hours)", remote.MaxIdleKillHours)
+	}
+	return hours, nil
+}
+
+// /remote:policy [deny=pattern] [allow=pattern] [remove=pattern] [clear=1] [idlekill=hours], no args shows the policy
+func RemotePolicyCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, err
+	}
+	var allowPatterns, denyPatterns []string
+	if ids.Remote.RemoteCopy.RemoteOpts != nil {
+		allowPatterns = ids.Remote.RemoteCopy.RemoteOpts.CmdAllow
+		denyPatterns = ids.Remote.RemoteCopy.RemoteOpts.CmdDeny
+	}
+	var idleKillHours int
+	if ids.Remote.RemoteCopy.RemoteOpts != nil {
+		idleKillHours = ids.Remote.RemoteCopy.RemoteOpts.IdleKillHours
+	}
+	var varsUpdated []string
+	if idleKillStr, found := pk.Kwargs["idlekill"]; found {
+		idleKillHours, err = resolveIdleKillHours(idleKillStr)
+		if err != nil {
+			return nil, fmt.Errorf("/remote:policy invalid idlekill: %v", err)
+		}
+		varsUpdated = append(varsUpdated, "idlekill")
+	}
+	if resolveBool(pk.Kwargs["clear"], false) {
+		allowPatterns, denyPatterns = []string{}, []string{}
+		varsUpdated = append(varsUpdated, "clear")
+	}
+	if pattern, found := pk.Kwargs["remove"]; found {
+		allowPatterns = removeCmdPolicyPattern(allowPatterns, pattern)
+		denyPatterns = removeCmdPolicyPattern(denyPatterns, pattern)
+		varsUpdated = append(varsUpdated, "remove")
+	}
+	if pattern, found := pk.Kwargs["deny"]; found {
+		denyPatterns, err = addCmdPolicyPattern(denyPatterns, pattern)
+		if err != nil {
+			return nil, fmt.Errorf("/remote:policy invalid deny pattern: %v", err)
+		}
+		varsUpdated = append(varsUpdated, "deny")
+	}
+	if pattern, found := pk.Kwargs["allow"]; found {
+		allowPatterns, err = addCmdPolicyPattern(allowPatterns, pattern)
+		if err != nil {
+			return nil, fmt.Errorf("/remote:policy invalid allow pattern: %v", err)
+		}
+		varsUpdated = append(varsUpdated, "allow")
+	}
+	if len(varsUpdated) > 0 {
+		editMap := map[string]interface{}{
+			sstore.RemoteField_CmdAllow: allowPatterns,
+			sstore.RemoteField_CmdDeny:  denyPatterns,
+			sstore.RemoteField_IdleKill: idleKillHours,
+		}
+		err = ids.Remote.Waveshell.UpdateRemote(ctx, editMap)
+		if err != nil {
+			return nil, fmt.Errorf("/remote:policy error updating remote: %v", err)
+		}
+	}
+	var buf bytes.Buffer
+	if len(denyPatterns) == 0 {
+		buf.WriteString("no commands are denied\n")
+	}
+	for _, pattern := range denyPatterns {
+		buf.WriteString(fmt.Sprintf("  deny   %s\n", pattern))
+	}
+	for _, pattern := range allowPatterns {
+		buf.WriteString(fmt.Sprintf("  allow  %s\n", pattern))
+	}
+	if idleKillHours > 0 {
+		buf.WriteString(fmt.Sprintf("idle commands are killed after %d hours\n", idleKillHours))
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: fmt.Sprintf("command policy for remote %q", ids.Remote.DisplayName),
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
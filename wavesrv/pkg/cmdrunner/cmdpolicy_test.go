@@ -0,0 +1,79 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func testCmdPolicy(t *testing.T, opts *sstore.RemoteOptsType, cmdStr string, expected string) {
+	rtn := CheckCmdPolicy(opts, cmdStr)
+	if rtn != expected {
+		t.Errorf("cmd %q, expected %q got %q", cmdStr, expected, rtn)
+	}
+}
+
+func TestCmdPolicy(t *testing.T) {
+	opts := &sstore.RemoteOptsType{
+		CmdDeny:  []string{"rm -*r*", "kubectl delete", "re:DROP\\s+TABLE"},
+		CmdAllow: []string{"kubectl delete pod"},
+	}
+	testCmdPolicy(t, opts, "rm -rf /tmp/x", "rm -*r*")
+	testCmdPolicy(t, opts, "rm -fr /tmp/x", "rm -*r*")
+	testCmdPolicy(t, opts, "rm foo.txt", "")
+	testCmdPolicy(t, opts, "sudo rm -rf /", "rm -*r*")
+	testCmdPolicy(t, opts, "ls; cd /tmp && 'rm' \"-rf\" x", "rm -*r*")
+	testCmdPolicy(t, opts, "echo rm -rf", "")
+	testCmdPolicy(t, opts, "kubectl get pods | grep x", "")
+	testCmdPolicy(t, opts, "kubectl delete deployment web", "kubectl delete")
+	testCmdPolicy(t, opts, "kubectl delete pod web-1", "")
+	testCmdPolicy(t, opts, "psql -c 'DROP  TABLE users'", "re:DROP\\s+TABLE")
+	testCmdPolicy(t, nil, "rm -rf /", "")
+}
+
+func TestValidateCmdPolicyPattern(t *testing.T) {
+	for _, pattern := range []string{"rm -rf", "kubectl delete *", "re:^git push --force"} {
+		if err := validateCmdPolicyPattern(pattern); err != nil {
+			t.Errorf("pattern %q, unexpected error: %v", pattern, err)
+		}
+	}
+	for _, pattern := range []string{"", "  ", "rm [", "re:(abc"} {
+		if err := validateCmdPolicyPattern(pattern); err == nil {
+			t.Errorf("pattern %q, expected error", pattern)
+		}
+	}
+}
+
+func TestMatchDestructiveCmd(t *testing.T) {
+	for _, cmdStr := range []string{"rm -rf /var/data", "sudo rm -f x", "kubectl delete ns prod", "git push --force origin main", "psql -c 'drop table users'"} {
+		if MatchDestructiveCmd(cmdStr) == "" {
+			t.Errorf("cmd %q, expected destructive match", cmdStr)
+		}
+	}
+	for _, cmdStr := range []string{"ls -l", "rm foo.txt", "git push origin main", "echo rm -rf /", "kubectl get pods"} {
+		if pattern := MatchDestructiveCmd(cmdStr); pattern != "" {
+			t.Errorf("cmd %q, unexpected destructive match %q", cmdStr, pattern)
+		}
+	}
+}
+
+func TestRemotePolicyMeta(t *testing.T) {
+	if meta := sstore.GetRemotePolicyMeta(&sstore.RemoteOptsType{Tags: []string{"staging"}}, "web"); meta != nil {
+		t.Errorf("expected no policy meta for untagged remote, got %v", meta)
+	}
+	meta := sstore.GetRemotePolicyMeta(&sstore.RemoteOptsType{Tags: []string{"staging", sstore.RemoteTag_Production}}, "web")
+	if meta == nil || !meta.ConfirmDestructive || meta.TabColor == "" || !strings.Contains(meta.BannerText, "web") {
+		t.Errorf("bad policy meta for production remote: %v", meta)
+	}
+	if _, err := resolveRemoteTags("production, Bad!"); err == nil {
+		t.Errorf("expected error for invalid tag")
+	}
+	tags, err := resolveRemoteTags("production,staging production")
+	if err != nil || len(tags) != 2 {
+		t.Errorf("bad tags %v (err %v)", tags, err)
+	}
+}
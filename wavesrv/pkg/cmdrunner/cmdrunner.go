@@ -6,6 +6,8 @@ package cmdrunner
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -34,11 +36,16 @@ import (
 	"github.com/wavetermdev/waveterm/waveshell/pkg/shellutil"
 	"github.com/wavetermdev/waveterm/waveshell/pkg/shexec"
 	"github.com/wavetermdev/waveterm/waveshell/pkg/utilfn"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/blockstore"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/bookmarks"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/comp"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/ephemeral"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/history"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/lanshare"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/macros"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/newton"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/notify"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/pcloud"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/releasechecker"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
@@ -46,9 +53,13 @@ import (
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/rtnstate"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scheduler"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/srvmetrics"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sshattach"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/telemetry"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/tmuxcc"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/waveenc"
 	"golang.org/x/mod/semver"
 )
@@ -79,6 +90,12 @@ const MaxOpenAIAPITokenLen = 100
 const MaxOpenAIModelLen = 100
 const MaxSidebarSections = 5
 
+// history items created by the scheduler
+const HistoryTag_Scheduled = "scheduled"
+
+const DefaultEphemeralLineTtlMin = 10
+const MaxEphemeralLineTtlMin = 24 * 60
+
 const TermFontSizeMin = 8
 const TermFontSizeMax = 24
 
@@ -95,21 +112,28 @@ const (
 	KwArgTemplate = "template"
 	KwArgLang     = "lang"
 	KwArgMinimap  = "minimap"
+	KwArgEncoding = "encoding"
 	KwArgNoHist   = "nohist"
 	KwArgSudo     = "sudo"
+
+	KwArgEphemeral    = "ephemeral"
+	KwArgEphemeralTtl = "ephemeralttl"
+	KwArgScheduleId   = "scheduleid"
+	KwArgInitScript   = "initscript"
+	KwArgStartupCmd   = "startupcmd"
 )
 
 var ColorNames = []string{"yellow", "blue", "pink", "mint", "cyan", "violet", "orange", "green", "red", "white"}
 var TabIcons = []string{"square", "sparkle", "fire", "ghost", "cloud", "compass", "crown", "droplet", "graduation-cap", "heart", "file"}
 var RemoteColorNames = []string{"red", "green", "yellow", "blue", "magenta", "cyan", "white", "orange"}
-var RemoteSetArgs = []string{"alias", "connectmode", "key", "password", "autoinstall", "color"}
+var RemoteSetArgs = []string{"alias", "connectmode", "key", "password", "autoinstall", "color", "tags"}
 var ConfirmFlags = []string{"hideshellprompt"}
 var SidebarNames = []string{"main"}
 var ThemeSources = []string{"light", "dark", "system"}
 
 var ScreenCmds = []string{"run", "comment", "cd", "cr", "clear", "sw", "reset", "signal", "chat"}
 var NoHistCmds = []string{"_compgen", "line", "history", "_killserver"}
-var GlobalCmds = []string{"session", "screen", "remote", "set", "client", "telemetry", "bookmark", "bookmarks"}
+var GlobalCmds = []string{"session", "screen", "window", "remote", "set", "client", "telemetry", "bookmark", "bookmarks", "transfer", "playback", "macro", "template", "envprofile", "dirbookmark", "snippet", "alias"}
 
 var SetVarNameMap map[string]string = map[string]string{
 	"tabcolor": "screen.tabcolor",
@@ -128,7 +152,7 @@ var SetVarScopes = []SetVarScope{
 	{ScopeName: "screen", VarNames: []string{"name", "tabcolor", "tabicon", "pos", "pterm", "anchor", "focus", "line", "index", "theme"}},
 	{ScopeName: "line", VarNames: []string{}},
 	// connection = remote, remote = remoteinstance
-	{ScopeName: "connection", VarNames: []string{"alias", "connectmode", "key", "password", "autoinstall", "color"}},
+	{ScopeName: "connection", VarNames: []string{"alias", "connectmode", "key", "password", "autoinstall", "color", "tags"}},
 	{ScopeName: "remote", VarNames: []string{}},
 }
 
@@ -156,6 +180,7 @@ type historyContextType struct {
 	RemotePtr     *sstore.RemotePtrType
 	FeState       sstore.FeStateType
 	InitialStatus string
+	CmdStr        string // replaces the typed command in history (set for expanded abbreviations)
 }
 
 type MetaCmdFnType = func(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error)
@@ -174,6 +199,9 @@ func init() {
 	registerCmdFn("connect", CrCommand)
 	registerCmdFn("_compgen", CompGenCommand)
 	registerCmdFn("_compfiledir", CompFileDirCommand)
+	registerCmdFn("_suggestdirs", SuggestDirsCommand)
+	registerCmdFn("_suggest", SuggestCommand)
+	registerCmdFn("_compspec", CompSpecCommand)
 	registerCmdFn("clear", ClearCommand)
 	registerCmdFn("reset", RemoteResetCommand)
 	registerCmdFn("reset:cwd", ResetCwdCommand)
@@ -197,16 +225,38 @@ func init() {
 
 	registerCmdFn("screen", ScreenCommand)
 	registerCmdFn("screen:archive", ScreenArchiveCommand)
+	registerCmdFn("screen:archiveidle", ScreenArchiveIdleCommand)
 	registerCmdFn("screen:delete", ScreenDeleteCommand)
+	registerCmdFn("screen:bulk", ScreenBulkCommand)
 	registerCmdFn("screen:open", ScreenOpenCommand)
 	registerCmdAlias("screen:new", ScreenOpenCommand)
 	registerCmdFn("screen:set", ScreenSetCommand)
 	registerCmdFn("screen:showall", ScreenShowAllCommand)
 	registerCmdFn("screen:reset", ScreenResetCommand)
 	registerCmdFn("screen:webshare", ScreenWebShareCommand)
+	registerCmdFn("screen:lanshare", ScreenLanShareCommand)
+	registerCmdFn("screen:transcript", ScreenTranscriptCommand)
 	registerCmdFn("screen:reorder", ScreenReorderCommand)
 	registerCmdFn("screen:show", ScreenShowCommand)
+	registerCmdFn("screen:filter", ScreenFilterCommand)
+	registerCmdFn("screen:savefilter", ScreenSaveFilterCommand)
+	registerCmdFn("screen:deletefilter", ScreenDeleteFilterCommand)
+	registerCmdFn("screen:split", ScreenSplitCommand)
+	registerCmdFn("screen:closepane", ScreenClosePaneCommand)
+	registerCmdFn("screen:focuspane", ScreenFocusPaneCommand)
+	registerCmdFn("screen:resizepane", ScreenResizePaneCommand)
+	registerCmdFn("screen:panes", ScreenPanesCommand)
 	registerCmdFn("screen:termtheme", TermSetThemeCommand)
+	registerCmdFn("screen:startup", ScreenStartupCommand)
+	registerCmdFn("screen:exportcast", ScreenExportCastCommand)
+	registerCmdFn("screen:playback", ScreenPlaybackCommand)
+
+	registerCmdFn("window:new", WindowNewCommand)
+	registerCmdFn("window:close", WindowCloseCommand)
+	registerCmdFn("window:movescreen", WindowMoveScreenCommand)
+	registerCmdFn("window:showall", WindowShowAllCommand)
+
+	registerCmdFn("transfer:history", TransferHistoryCommand)
 
 	registerCmdAlias("remote", RemoteCommand)
 	registerCmdFn("remote:show", RemoteShowCommand)
@@ -220,8 +270,24 @@ func init() {
 	registerCmdFn("remote:installcancel", RemoteInstallCancelCommand)
 	registerCmdFn("remote:reset", RemoteResetCommand)
 	registerCmdFn("remote:parse", RemoteConfigParseCommand)
+	registerCmdFn("remote:wsl", RemoteWSLCommand)
+	registerCmdFn("remote:serial", RemoteSerialCommand)
+	registerCmdFn("remote:policy", RemotePolicyCommand)
+	registerCmdFn("remote:preconnect", RemotePreConnectCommand)
+	registerCmdFn("remote:initscripts", RemoteInitScriptsCommand)
+	registerCmdFn("remote:group", RemoteGroupCommand)
+	registerCmdFn("remote:reorder", RemoteReorderCommand)
+	registerCmdFn("remote:health", RemoteHealthCommand)
+	registerCmdFn("remote:metrics", RemoteMetricsCommand)
+
+	registerCmdFn("remotegroup:showall", RemoteGroupShowAllCommand)
+	registerCmdFn("remotegroup:new", RemoteGroupNewCommand)
+	registerCmdFn("remotegroup:rename", RemoteGroupRenameCommand)
+	registerCmdFn("remotegroup:delete", RemoteGroupDeleteCommand)
+	registerCmdFn("remotegroup:reorder", RemoteGroupReorderCommand)
 
 	registerCmdFn("copyfile", CopyFileCommand)
+	registerCmdFn("dirsync", DirSyncCommand)
 
 	registerCmdFn("screen:resize", ScreenResizeCommand)
 
@@ -236,10 +302,28 @@ func init() {
 	registerCmdFn("line:view", LineViewCommand)
 	registerCmdFn("line:set", LineSetCommand)
 	registerCmdFn("line:restart", LineRestartCommand)
+	registerCmdFn("line:chain", LineChainCommand)
+	registerCmdFn("line:unchain", LineUnchainCommand)
+	registerCmdFn("line:runchain", LineRunChainCommand)
+	registerCmdFn("line:tag", LineTagCommand)
+	registerCmdFn("line:untag", LineUntagCommand)
+	registerCmdFn("line:tagged", LineTaggedCommand)
 	registerCmdFn("line:minimize", LineMinimizeCommand)
+	registerCmdFn("line:exportcast", LineExportCastCommand)
+	registerCmdFn("line:saveraw", LineSaveRawCommand)
+	registerCmdFn("line:playback", LinePlaybackCommand)
+
+	registerCmdFn("playback:stop", PlaybackStopCommand)
 
 	registerCmdFn("client", ClientCommand)
 	registerCmdFn("client:show", ClientShowCommand)
+	registerCmdFn("client:doctor", ClientDoctorCommand)
+	registerCmdFn("client:bulkops", ClientBulkOpsCommand)
+	registerCmdFn("client:exportvault", ClientExportVaultCommand)
+	registerCmdFn("client:backup", ClientBackupCommand)
+	registerCmdFn("client:backups", ClientBackupsCommand)
+	registerCmdFn("client:restore", ClientRestoreCommand)
+	registerCmdFn("client:readonly", ClientReadOnlyCommand)
 	registerCmdFn("client:set", ClientSetCommand)
 	registerCmdFn("client:notifyupdatewriter", ClientNotifyUpdateWriterCommand)
 	registerCmdFn("client:accepttos", ClientAcceptTosCommand)
@@ -247,6 +331,7 @@ func init() {
 	registerCmdFn("client:setmainsidebar", ClientSetMainSidebarCommand)
 	registerCmdFn("client:setrightsidebar", ClientSetRightSidebarCommand)
 	registerCmdFn("client:setglobalshortcut", ClientSetGlobalShortcut)
+	registerCmdFn("client:setdropdown", ClientSetDropdownCommand)
 
 	registerCmdFn("sidebar:open", SidebarOpenCommand)
 	registerCmdFn("sidebar:close", SidebarCloseCommand)
@@ -266,13 +351,91 @@ func init() {
 	registerCmdFn("history", HistoryCommand)
 	registerCmdFn("history:viewall", HistoryViewAllCommand)
 	registerCmdFn("history:purge", HistoryPurgeCommand)
+	registerCmdFn("history:screens", HistoryScreensCommand)
+	registerCmdFn("history:repair", HistoryRepairCommand)
+	registerCmdFn("history:top", HistoryTopCommand)
+	registerCmdFn("history:stats", HistoryStatsCommand)
+	registerCmdFn("history:sync", HistorySyncCommand)
+	registerCmdFn("history:sync:set", HistorySyncSetCommand)
+	registerCmdFn("history:sync:show", HistorySyncShowCommand)
+
+	registerCmdFn("trash:show", TrashShowCommand)
+	registerCmdFn("trash:restore", TrashRestoreCommand)
+	registerCmdFn("trash:purge", TrashPurgeCommand)
+
+	registerCmdFn("foreigndb:attach", ForeignDBAttachCommand)
+	registerCmdFn("foreigndb:detach", ForeignDBDetachCommand)
+	registerCmdFn("foreigndb:show", ForeignDBShowCommand)
+	registerCmdFn("foreigndb:lines", ForeignDBLinesCommand)
+	registerCmdFn("foreigndb:import", ForeignDBImportCommand)
 
 	registerCmdFn("bookmarks:show", BookmarksShowCommand)
+	registerCmdFn("bookmarks:import", BookmarksImportCommand)
+	registerCmdFn("bookmarks:export", BookmarksExportCommand)
 
 	registerCmdFn("bookmark:set", BookmarkSetCommand)
 	registerCmdFn("bookmark:delete", BookmarkDeleteCommand)
 
+	registerCmdFn("webhook", WebhookCommand)
+	registerCmdFn("webhook:add", WebhookAddCommand)
+	registerCmdFn("webhook:show", WebhookShowCommand)
+	registerCmdFn("webhook:set", WebhookSetCommand)
+	registerCmdFn("webhook:delete", WebhookDeleteCommand)
+	registerCmdFn("webhook:test", WebhookTestCommand)
+	registerCmdFn("webhook:log", WebhookLogCommand)
+
+	registerCmdFn("schedule", ScheduleCommand)
+	registerCmdFn("schedule:add", ScheduleAddCommand)
+	registerCmdFn("schedule:show", ScheduleShowCommand)
+	registerCmdFn("schedule:pause", SchedulePauseCommand)
+	registerCmdFn("schedule:resume", ScheduleResumeCommand)
+	registerCmdFn("schedule:delete", ScheduleDeleteCommand)
+
+	registerCmdAlias("macro", MacroShowCommand)
+	registerCmdFn("macro:show", MacroShowCommand)
+	registerCmdFn("macro:record", MacroRecordCommand)
+	registerCmdFn("macro:save", MacroSaveCommand)
+	registerCmdFn("macro:cancel", MacroCancelCommand)
+	registerCmdFn("macro:run", MacroRunCommand)
+	registerCmdFn("macro:stop", MacroStopCommand)
+	registerCmdFn("macro:delete", MacroDeleteCommand)
+
+	registerCmdAlias("template", TemplateShowCommand)
+	registerCmdFn("template:show", TemplateShowCommand)
+	registerCmdFn("template:save", TemplateSaveCommand)
+	registerCmdFn("template:open", TemplateOpenCommand)
+	registerCmdFn("template:delete", TemplateDeleteCommand)
+
+	registerCmdAlias("envprofile", EnvProfileShowCommand)
+	registerCmdFn("envprofile:show", EnvProfileShowCommand)
+	registerCmdFn("envprofile:set", EnvProfileSetCommand)
+	registerCmdFn("envprofile:secret", EnvProfileSecretCommand)
+	registerCmdFn("envprofile:unset", EnvProfileUnsetCommand)
+	registerCmdFn("envprofile:delete", EnvProfileDeleteCommand)
+	registerCmdFn("envprofile:attach", EnvProfileAttachCommand)
+	registerCmdFn("envprofile:detach", EnvProfileDetachCommand)
+
+	registerCmdAlias("dirbookmark", DirBookmarkShowCommand)
+	registerCmdFn("dirbookmark:show", DirBookmarkShowCommand)
+	registerCmdFn("dirbookmark:add", DirBookmarkAddCommand)
+	registerCmdFn("dirbookmark:delete", DirBookmarkDeleteCommand)
+	registerCmdFn("dirbookmark:go", DirBookmarkGoCommand)
+
+	registerCmdAlias("alias", CmdAliasShowCommand)
+	registerCmdFn("alias:show", CmdAliasShowCommand)
+	registerCmdFn("alias:set", CmdAliasSetCommand)
+	registerCmdFn("alias:delete", CmdAliasDeleteCommand)
+
+	registerCmdAlias("snippet", SnippetShowCommand)
+	registerCmdFn("snippet:show", SnippetShowCommand)
+	registerCmdFn("snippet:add", SnippetAddCommand)
+	registerCmdFn("snippet:delete", SnippetDeleteCommand)
+	registerCmdFn("snippet:render", SnippetRenderCommand)
+
 	registerCmdFn("chat", OpenAICommand)
+	registerCmdFn("ai:cmd", AICmdCommand)
+	registerCmdFn("ai:explain", AIExplainCommand)
+	registerCmdFn("ai:attach", AIAttachCommand)
 
 	registerCmdFn("_killserver", KillServerCommand)
 	registerCmdFn("_dumpstate", DumpStateCommand)
@@ -297,8 +460,12 @@ func init() {
 	registerCmdFn("csvview", CSVViewCommand)
 
 	registerCmdFn("_debug:ri", DebugRemoteInstanceCommand)
+	registerCmdFn("_debug:stategc", DebugStateGCCommand)
 
 	registerCmdFn("sudo:clear", ClearSudoCache)
+	registerCmdFn("sudo:status", SudoStatusCommand)
+	registerCmdFn("sudo:set", SudoSetCommand)
+	registerCmdFn("sudo:drop", SudoDropCommand)
 
 	registerCmdFn("autocomplete:on", AutocompleteOnCommand)
 	registerCmdFn("autocomplete:off", AutocompleteOffCommand)
@@ -345,6 +512,10 @@ func HandleCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus
 		}
 		return nil, fmt.Errorf("invalid command '/%s', no handler", cmdName)
 	}
+	err := checkReadOnlyCmd(cmdName)
+	if err != nil {
+		return nil, err
+	}
 	return entry.Fn(ctx, pk)
 }
 
@@ -384,7 +555,7 @@ func resolveShellType(shellArg string, defaultShell string) (string, error) {
 			shellArg = defaultShell
 		}
 	}
-	if shellArg != packet.ShellType_bash && shellArg != packet.ShellType_zsh {
+	if shellArg != packet.ShellType_bash && shellArg != packet.ShellType_zsh && shellArg != packet.ShellType_pwsh && shellArg != packet.ShellType_fish {
 		return "", fmt.Errorf("invalid shell type %q", shellArg)
 	}
 	return shellArg, nil
@@ -597,6 +768,21 @@ func getLangArg(pk *scpacket.FeCommandPacketType) (string, error) {
 	return pk.Kwargs[KwArgLang], nil
 }
 
+// returns 0 if the line is not ephemeral (ephemeral lines are auto-removed after the ttl, or on screen switch)
+func getEphemeralTtlArg(pk *scpacket.FeCommandPacketType) (time.Duration, error) {
+	if !resolveBool(pk.Kwargs[KwArgEphemeral], false) {
+		return 0, nil
+	}
+	ttlMin, err := resolvePosInt(pk.Kwargs[KwArgEphemeralTtl], DefaultEphemeralLineTtlMin)
+	if err != nil {
+		return 0, err
+	}
+	if ttlMin > MaxEphemeralLineTtlMin {
+		return 0, fmt.Errorf("ttl cannot be greater than %d minutes", MaxEphemeralLineTtlMin)
+	}
+	return time.Duration(ttlMin) * time.Minute, nil
+}
+
 func RunCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
 	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_RemoteConnected)
 	if err != nil {
@@ -614,6 +800,10 @@ func RunCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.Up
 	if err != nil {
 		return nil, fmt.Errorf("/run error, invalid lang: %w", err)
 	}
+	ephemeralTtl, err := getEphemeralTtlArg(pk)
+	if err != nil {
+		return nil, fmt.Errorf("/run error, invalid ephemeralttl: %w", err)
+	}
 
 	cmdStr := firstArg(pk)
 	expandedCmdStr, err := doCmdHistoryExpansion(ctx, ids, cmdStr)
@@ -633,6 +823,19 @@ func RunCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.Up
 		ctxWithDepth := context.WithValue(ctx, depthContextKey, evalDepth+1)
 		return EvalCommand(ctxWithDepth, newPk)
 	}
+	cmdAliases, err := sstore.GetCmdAliasMap(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("/run error getting aliases: %v", err)
+	}
+	cmdStr, cmdAlias := expandCmdAliases(cmdStr, cmdAliases)
+	err = checkRemoteCmdPolicy(pk, ids.Remote.RemoteCopy, cmdStr)
+	if err != nil {
+		return nil, err
+	}
+	err = checkRemoteDestructiveCmd(pk, ids.Remote.RemoteCopy, cmdStr)
+	if err != nil {
+		return nil, err
+	}
 	isRtnStateCmd := IsReturnStateCommand(cmdStr)
 	// runPacket.State is set in remote.RunCommand()
 	runPacket := packet.MakeRunPacket()
@@ -672,6 +875,10 @@ func RunCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.Up
 		return nil, err
 	}
 	cmd.RawCmdStr = pk.GetRawStr()
+	if cmdAlias != nil && !cmdAlias.PreserveRaw {
+		cmd.RawCmdStr = cmdStr
+		setHistoryCmdStr(ctx, cmdStr)
+	}
 	lineState := make(map[string]any)
 	if templateArg != "" {
 		lineState[sstore.LineState_Template] = templateArg
@@ -679,6 +886,19 @@ func RunCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.Up
 	if langArg != "" {
 		lineState[sstore.LineState_Lang] = langArg
 	}
+	if ephemeralTtl > 0 {
+		lineState[sstore.LineState_EphemeralExpireTs] = time.Now().Add(ephemeralTtl).UnixMilli()
+	}
+	if scheduleId := pk.Kwargs[KwArgScheduleId]; scheduleId != "" {
+		lineState[sstore.LineState_ScheduleId] = scheduleId
+	}
+	if remoteId := pk.Kwargs[KwArgInitScript]; remoteId != "" {
+		lineState[sstore.LineState_InitScript] = remoteId
+		lineState[sstore.LineState_Min] = true
+	}
+	if screenId := pk.Kwargs[KwArgStartupCmd]; screenId != "" {
+		lineState[sstore.LineState_StartupCmd] = screenId
+	}
 
 	// If we are running an ephemeral command, we don't want to add the line to the screen
 	if pk.EphemeralOpts == nil {
@@ -710,6 +930,9 @@ func implementRunInSidebar(ctx context.Context, screenId string, lineId string)
 
 func addToHistory(ctx context.Context, pk *scpacket.FeCommandPacketType, historyContext historyContextType, isMetaCmd bool, hadError bool) error {
 	cmdStr := firstArg(pk)
+	if historyContext.CmdStr != "" {
+		cmdStr = historyContext.CmdStr
+	}
 	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
 	if err != nil {
 		return err
@@ -738,10 +961,17 @@ func addToHistory(ctx context.Context, pk *scpacket.FeCommandPacketType, history
 	if !isMetaCmd && historyContext.RemotePtr != nil {
 		hitem.Remote = *historyContext.RemotePtr
 	}
+	if pk.Kwargs[KwArgScheduleId] != "" {
+		hitem.Tags = map[string]bool{HistoryTag_Scheduled: true}
+	}
 	err = history.InsertHistoryItem(ctx, hitem)
 	if err != nil {
 		return err
 	}
+	if pk.Kwargs[KwArgScheduleId] == "" {
+		// the command as typed (not the alias expansion)
+		sstore.ScreenMemPushInputHistory(ids.ScreenId, firstArg(pk))
+	}
 	return nil
 }
 
@@ -769,7 +999,13 @@ func EvalCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.U
 	} else {
 		return nil, fmt.Errorf("error in Eval Meta Command: %w", rtnErr)
 	}
-	if !resolveBool(pk.Kwargs[KwArgNoHist], false) && pk.EphemeralOpts == nil {
+	// typed commands are recorded by /macro:record (not the /macro commands themselves)
+	if rtnErr == nil && pk.Interactive && evalDepth == 0 && newPk.MetaCmd != "macro" && pk.UIContext != nil {
+		macros.RecordCmd(pk.UIContext.ScreenId, pk.Args[0])
+	}
+	// ephemeral lines are never added to history
+	noHist := resolveBool(pk.Kwargs[KwArgNoHist], false) || resolveBool(newPk.Kwargs[KwArgEphemeral], false)
+	if !noHist && pk.EphemeralOpts == nil {
 		// TODO should this be "pk" or "newPk" (2nd arg)
 		err := addToHistory(ctx, pk, historyContext, (newPk.MetaCmd != "run"), (rtnErr != nil))
 		if err != nil {
@@ -871,10 +1107,12 @@ func ScreenDeleteCommand(ctx context.Context, pk *scpacket.FeCommandPacketType)
 		// send SIGHUP to all running commands in this screen
 		remote.SendSignalToCmd(ctx, runningCmd, "SIGHUP")
 	}
-	update, err := sstore.DeleteScreen(ctx, screenId, false, nil)
+	update, err := sstore.DeleteScreen(ctx, screenId, false, "", nil)
 	if err != nil {
 		return nil, err
 	}
+	lanshare.StopScreenShares(screenId)
+	stopTranscriptWatcher(screenId)
 	return update, nil
 }
 
@@ -1013,6 +1251,39 @@ func ScreenSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sc
 		varsUpdated = append(varsUpdated, "pos")
 		setNonAnchor = true
 	}
+	if noNotifyStr, found := pk.Kwargs["nonotify"]; found {
+		updateMap[sstore.ScreenField_NoNotify] = resolveBool(noNotifyStr, true)
+		varsUpdated = append(varsUpdated, "nonotify")
+		setNonAnchor = true
+	}
+	if remoteLockStr, found := pk.Kwargs["remotelock"]; found {
+		updateMap[sstore.ScreenField_RemoteLock] = resolveBool(remoteLockStr, true)
+		varsUpdated = append(varsUpdated, "remotelock")
+		setNonAnchor = true
+	}
+	if pinnedStr, found := pk.Kwargs["pinned"]; found {
+		updateMap[sstore.ScreenField_Pinned] = resolveBool(pinnedStr, true)
+		varsUpdated = append(varsUpdated, "pinned")
+		setNonAnchor = true
+	}
+	if idleKillStr, found := pk.Kwargs["idlekill"]; found {
+		idleKillHours, err := resolveIdleKillHours(idleKillStr)
+		if err != nil {
+			return nil, fmt.Errorf("/screen:set invalid idlekill: %v", err)
+		}
+		updateMap[sstore.ScreenField_IdleKill] = idleKillHours
+		varsUpdated = append(varsUpdated, "idlekill")
+		setNonAnchor = true
+	}
+	if shellPrefStr, found := pk.Kwargs["shellpref"]; found {
+		shellPref, err := resolveScreenShellPref(shellPrefStr)
+		if err != nil {
+			return nil, fmt.Errorf("/screen:set invalid shellpref: %v", err)
+		}
+		updateMap[sstore.ScreenField_ShellPref] = shellPref
+		varsUpdated = append(varsUpdated, "shellpref")
+		setNonAnchor = true
+	}
 	if pk.Kwargs["focus"] != "" {
 		focusVal := pk.Kwargs["focus"]
 		if focusVal != sstore.ScreenFocusInput && focusVal != sstore.ScreenFocusCmd {
@@ -1058,7 +1329,7 @@ func ScreenSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sc
 		}
 	}
 	if len(varsUpdated) == 0 {
-		return nil, fmt.Errorf("/screen:set no updates, can set %s", formatStrs([]string{"name", "pos", "tabcolor", "tabicon", "focus", "anchor", "line", "sharename"}, "or", false))
+		return nil, fmt.Errorf("/screen:set no updates, can set %s", formatStrs([]string{"name", "pos", "tabcolor", "tabicon", "nonotify", "remotelock", "pinned", "idlekill", "shellpref", "focus", "anchor", "line", "sharename"}, "or", false))
 	}
 	screen, err := sstore.UpdateScreen(ctx, ids.ScreenId, updateMap)
 	if err != nil {
@@ -1068,11 +1339,19 @@ func ScreenSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sc
 		return nil, nil
 	}
 
-	update := scbus.MakeUpdatePacket()
-	update.AddUpdate(*screen, sstore.InfoMsgType{
+	infoMsg := sstore.InfoMsgType{
 		InfoMsg:   fmt.Sprintf("screen updated %s", formatStrs(varsUpdated, "and", false)),
 		TimeoutMs: 2000,
-	})
+	}
+	if _, found := updateMap[sstore.ScreenField_ShellPref]; found {
+		if resetMsg := getScreenShellResetMsg(ctx, screen); resetMsg != "" {
+			// no timeout, the shell only changes after a /reset
+			infoMsg.InfoMsg = fmt.Sprintf("%s, %s", infoMsg.InfoMsg, resetMsg)
+			infoMsg.TimeoutMs = 0
+		}
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(*screen, infoMsg)
 	return update, nil
 }
 
@@ -1089,6 +1368,9 @@ func ScreenCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus
 	if err != nil {
 		return nil, err
 	}
+	if windowId := getUIWindowId(pk); windowId != sstore.MainWindowId {
+		return sstore.MoveScreenToWindow(ctx, ritem.Id, windowId)
+	}
 	update, err := sstore.SwitchScreenById(ctx, ids.SessionId, ritem.Id)
 	if err != nil {
 		return nil, err
@@ -1409,105 +1691,68 @@ func getStatusBarString(filePercentageInt int) string {
 	return statusBarString
 }
 
-func doCopyRemoteFileToRemote(ctx context.Context, cmd *sstore.CmdType, sourceWsh *remote.WaveshellProc, destWsh *remote.WaveshellProc, sourcePath string, destPath string, outputPos int64) {
+func doCopyRemoteFileToRemote(ctx context.Context, cmd *sstore.CmdType, sourceWsh *remote.WaveshellProc, destWsh *remote.WaveshellProc, sourcePath string, destPath string, resume bool, verify bool, outputPos int64) {
 	var exitSuccess bool
 	startTime := time.Now()
 	defer func() {
 		deferWriteCmdStatus(ctx, cmd, startTime, exitSuccess, outputPos)
 	}()
-	streamPk := packet.MakeStreamFilePacket()
-	streamPk.ReqId = uuid.New().String()
-	streamPk.Path = sourcePath
-	sourceStreamIter, err := sourceWsh.StreamFile(ctx, streamPk)
-	if err != nil {
-		writeStringToPty(ctx, cmd, fmt.Sprintf("Error getting file data packet: %v\r\n", err), &outputPos)
-		return
-	}
-	defer sourceStreamIter.Close()
-	respIf, err := sourceStreamIter.Next(ctx)
+	transfer, err := startTransfer(ctx, sourceWsh, sourcePath, destWsh, destPath, resume)
 	if err != nil {
-		writeStringToPty(ctx, cmd, fmt.Sprintf("Error getting next packet: %v\r\n", err), &outputPos)
-		return
-	}
-	resp, ok := respIf.(*packet.StreamFileResponseType)
-	if !ok {
-		writeStringToPty(ctx, cmd, fmt.Sprintf("Error in getting packet response: %v\r\n", err), &outputPos)
-		return
-	}
-	if resp == nil || resp.Error != "" {
-		writeStringToPty(ctx, cmd, fmt.Sprintf("Response packet has error: %v\r\n", err), &outputPos)
-		return
-	}
-	fileSizeBytes := resp.Info.Size
-	if fileSizeBytes == 0 {
-		writeStringToPty(ctx, cmd, "Source file does not exist or is empty - exiting\r\n", &outputPos)
-		return
-	}
-	writeStringToPty(ctx, cmd, fmt.Sprintf("Source File Size: %v\r\n", prettyPrintByteSize(fileSizeBytes)), &outputPos)
-	writePk := packet.MakeWriteFilePacket()
-	writePk.ReqId = uuid.New().String()
-	writePk.Path = destPath
-	destWriteIter, err := destWsh.WriteFile(ctx, writePk)
-	if err != nil {
-		writeStringToPty(ctx, cmd, fmt.Sprintf("Error starting file write: %v\r\n", err), &outputPos)
-		return
-	}
-	defer destWriteIter.Close()
-	_, err = checkForWriteReady(ctx, destWriteIter)
-	if err != nil {
-		writeStringToPty(ctx, cmd, fmt.Sprintf("Write ready packet error: %v\r\n", err), &outputPos)
+		writeStringToPty(ctx, cmd, fmt.Sprintf("Error creating transfer record: %v\r\n", err), &outputPos)
 		return
 	}
-	bytesWritten := int64(0)
 	lastFilePercentageInt := int(0)
-	fileTransferPercentage := float64(0)
-	writeStringToPty(ctx, cmd, "[", &outputPos)
-	for {
-		dataPkIf, err := sourceStreamIter.Next(ctx)
-		if err != nil {
-			log.Printf("error in read-file while getting data: %v\n", err)
-			return
-		}
-		if dataPkIf == nil {
-			break
-		}
-		dataPk, ok := dataPkIf.(*packet.FileDataPacketType)
-		if !ok {
-			writeStringToPty(ctx, cmd, fmt.Sprintf("error in read-file, invalid data packet type: %T\r\n", dataPkIf), &outputPos)
-			return
-		}
-		if dataPk.Error != "" {
-			writeStringToPty(ctx, cmd, fmt.Sprintf("in read-file, data packet error: %s\r\n", dataPk.Error), &outputPos)
-			return
+	progressStarted := false
+	progressFn := func(bytesDone int64, totalBytes int64) {
+		if !progressStarted {
+			writeStringToPty(ctx, cmd, fmt.Sprintf("Source File Size: %v\r\n", prettyPrintByteSize(totalBytes)), &outputPos)
+			progressStarted = true
 		}
-		writeDataPk := packet.MakeFileDataPacket(writePk.ReqId)
-		writeDataPk.Eof = dataPk.Eof
-		writeDataPk.Error = dataPk.Error
-		writeDataPk.Type = dataPk.Type
-		writeDataPk.Data = make([]byte, int64(len(dataPk.Data)))
-		copy(writeDataPk.Data, dataPk.Data)
-		err = destWsh.SendFileData(writeDataPk)
-		if err != nil {
-			writeStringToPty(ctx, cmd, fmt.Sprintf("error sending file to dest: %v\r\n", err), &outputPos)
+		if totalBytes == 0 {
 			return
 		}
-		bytesWritten += int64(len(dataPk.Data))
-		fileTransferPercentage = float64(bytesWritten) / float64(fileSizeBytes)
-		filePercentageInt := int(fileTransferPercentage * 100)
+		filePercentageInt := int(float64(bytesDone) / float64(totalBytes) * 100)
 		if filePercentageInt-lastFilePercentageInt > 5 {
-			statusBarString := getStatusBarString(filePercentageInt)
-			writeStringToPty(ctx, cmd, statusBarString, &outputPos)
+			writeStringToPty(ctx, cmd, getStatusBarString(filePercentageInt), &outputPos)
 			lastFilePercentageInt = filePercentageInt
+			err := sstore.UpdateTransferProgress(ctx, transfer.TransferId, totalBytes, bytesDone)
+			if err != nil {
+				log.Printf("error updating transfer progress %s: %v\n", transfer.TransferId, err)
+			}
 		}
 	}
-	err = checkForWriteFinished(ctx, destWriteIter)
+	copyOpts := remote.CopyOpts{
+		Resume:     resume,
+		Verify:     verify,
+		StagingId:  transfer.TransferId,
+		ProgressFn: progressFn,
+	}
+	result, err := remote.CopyBetweenRemotes(ctx, sourceWsh, sourcePath, destWsh, destPath, copyOpts)
+	finishTransfer(ctx, transfer, result, err)
 	if err != nil {
-		writeStringToPty(ctx, cmd, fmt.Sprintf("\r\nWrite finished packet error %v", err), &outputPos)
+		errStr := fmt.Sprintf("Error copying file: %v\r\n", err)
+		if progressStarted {
+			errStr = "\r\n" + errStr
+		}
+		if transfer.Status == sstore.TransferStatus_Error && result != nil && result.BytesCopied > 0 {
+			errStr += "Partial file was written, rerun with resume=1 to continue the copy\r\n"
+		}
+		writeStringToPty(ctx, cmd, errStr, &outputPos)
 		return
 	}
+	if result.StartOffset > 0 {
+		writeStringToPty(ctx, cmd, fmt.Sprintf("Resumed copy at %s\r\n", prettyPrintByteSize(result.StartOffset)), &outputPos)
+	}
+	if result.StagedBytes > 0 {
+		writeStringToPty(ctx, cmd, fmt.Sprintf("Sent %s from staged data\r\n", prettyPrintByteSize(result.StagedBytes)), &outputPos)
+	}
 	writeStringToPty(ctx, cmd, getStatusBarString(100), &outputPos)
 	writeStringToPty(ctx, cmd, " done. \r\n", &outputPos)
-	writeStringToPty(ctx, cmd, fmt.Sprintf("Finished transferring. Transferred %v bytes\r\n", bytesWritten), &outputPos)
+	writeStringToPty(ctx, cmd, fmt.Sprintf("Finished transferring. Transferred %v bytes\r\n", result.BytesCopied), &outputPos)
+	if result.Sha256 != "" {
+		writeStringToPty(ctx, cmd, fmt.Sprintf("Verified sha256 %s\r\n", result.Sha256), &outputPos)
+	}
 	exitSuccess = true
 }
 
@@ -1761,7 +2006,7 @@ func CopyFileCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scb
 	update.AddUpdate(sstore.InteractiveUpdate(pk.Interactive))
 	if destRemote != ConnectedRemote && destRemoteId != nil && !destRemoteId.RState.IsConnected() {
 		writeStringToPty(ctx, cmd, fmt.Sprintf("Attempting to autoconnect to remote %v\r\n", destRemote), &outputPos)
-		err = destRemoteId.Waveshell.TryAutoConnect()
+		err = destRemoteId.Waveshell.TryAutoConnect(ids.ScreenId)
 		if err != nil {
 			writeStringToPty(ctx, cmd, fmt.Sprintf("Couldn't connect to remote %v\r\n", sourceRemote), &outputPos)
 		} else {
@@ -1770,7 +2015,7 @@ func CopyFileCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scb
 	}
 	if sourceRemote != LocalRemote && sourceRemoteId != nil && !sourceRemoteId.RState.IsConnected() {
 		writeStringToPty(ctx, cmd, fmt.Sprintf("Attempting to autoconnect to remote %v\r\n", sourceRemote), &outputPos)
-		err = sourceRemoteId.Waveshell.TryAutoConnect()
+		err = sourceRemoteId.Waveshell.TryAutoConnect(ids.ScreenId)
 		if err != nil {
 			writeStringToPty(ctx, cmd, fmt.Sprintf("Couldn't connect to remote %v\r\n", sourceRemote), &outputPos)
 		} else {
@@ -1786,7 +2031,7 @@ func CopyFileCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scb
 	} else if destRemote != LocalRemote && sourceRemote == LocalRemote {
 		go doCopyLocalFileToRemote(context.Background(), cmd, destWsh, sourceFullPath, destFullPath, outputPos)
 	} else if destRemote != LocalRemote && sourceRemote != LocalRemote {
-		go doCopyRemoteFileToRemote(context.Background(), cmd, sourceWsh, destWsh, sourceFullPath, destFullPath, outputPos)
+		go doCopyRemoteFileToRemote(context.Background(), cmd, sourceWsh, destWsh, sourceFullPath, destFullPath, resolveBool(pk.Kwargs["resume"], false), resolveBool(pk.Kwargs["verify"], true), outputPos)
 	}
 	return update, nil
 }
@@ -1816,7 +2061,7 @@ func RemoteConnectCommand(ctx context.Context, pk *scpacket.FeCommandPacketType)
 	if err != nil {
 		return nil, err
 	}
-	go ids.Remote.Waveshell.Launch(true)
+	go ids.Remote.Waveshell.LaunchFromScreen(true, ids.ScreenId)
 	return createRemoteViewRemoteIdUpdate(ids.Remote.RemotePtr.RemoteId), nil
 }
 
@@ -1876,6 +2121,7 @@ type RemoteEditArgs struct {
 	Alias         string
 	AutoInstall   bool
 	Color         string
+	Tags          []string
 	ShellPref     string
 	EditMap       map[string]interface{}
 }
@@ -1972,8 +2218,8 @@ func parseRemoteEditArgs(isNew bool, pk *scpacket.FeCommandPacketType, isLocal b
 	if pk.Kwargs["shellpref"] != "" {
 		shellPref = pk.Kwargs["shellpref"]
 	}
-	if shellPref != "" && shellPref != packet.ShellType_bash && shellPref != packet.ShellType_zsh && shellPref != sstore.ShellTypePref_Detect {
-		return nil, fmt.Errorf("invalid shellpref %q, must be %s", shellPref, formatStrs([]string{packet.ShellType_bash, packet.ShellType_zsh, sstore.ShellTypePref_Detect}, "or", false))
+	if shellPref != "" && shellPref != packet.ShellType_bash && shellPref != packet.ShellType_zsh && shellPref != packet.ShellType_pwsh && shellPref != packet.ShellType_fish && shellPref != sstore.ShellTypePref_Detect {
+		return nil, fmt.Errorf("invalid shellpref %q, must be %s", shellPref, formatStrs([]string{packet.ShellType_bash, packet.ShellType_zsh, packet.ShellType_pwsh, packet.ShellType_fish, sstore.ShellTypePref_Detect}, "or", false))
 	}
 	var connectMode string
 	if isNew {
@@ -1997,6 +2243,10 @@ func parseRemoteEditArgs(isNew bool, pk *scpacket.FeCommandPacketType, isLocal b
 			return nil, err
 		}
 	}
+	tags, err := resolveRemoteTags(pk.Kwargs["tags"])
+	if err != nil {
+		return nil, err
+	}
 	sshPassword := pk.Kwargs["password"]
 	if sshOpts != nil {
 		sshOpts.SSHIdentity = keyFile
@@ -2023,6 +2273,9 @@ func parseRemoteEditArgs(isNew bool, pk *scpacket.FeCommandPacketType, isLocal b
 	if _, found := pk.Kwargs[sstore.RemoteField_Color]; found {
 		editMap[sstore.RemoteField_Color] = color
 	}
+	if _, found := pk.Kwargs[sstore.RemoteField_Tags]; found {
+		editMap[sstore.RemoteField_Tags] = tags
+	}
 	if _, found := pk.Kwargs["password"]; found && pk.Kwargs["password"] != PasswordUnchangedSentinel {
 		if isLocal {
 			return nil, fmt.Errorf("Cannot edit ssh password for 'local' remote")
@@ -2040,6 +2293,7 @@ func parseRemoteEditArgs(isNew bool, pk *scpacket.FeCommandPacketType, isLocal b
 		AutoInstall:   true,
 		CanonicalName: canonicalName,
 		Color:         color,
+		Tags:          tags,
 		EditMap:       editMap,
 		ShellPref:     shellPref,
 	}, nil
@@ -2068,8 +2322,8 @@ func RemoteNewCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sc
 		SSHConfigSrc:        sstore.SSHConfigSrcTypeManual,
 		ShellPref:           editArgs.ShellPref,
 	}
-	if editArgs.Color != "" {
-		r.RemoteOpts = &sstore.RemoteOptsType{Color: editArgs.Color}
+	if editArgs.Color != "" || len(editArgs.Tags) > 0 {
+		r.RemoteOpts = &sstore.RemoteOptsType{Color: editArgs.Color, Tags: editArgs.Tags}
 	}
 	err = remote.AddRemote(ctx, r, true)
 	if err != nil {
@@ -2090,6 +2344,13 @@ func RemoteSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sc
 	if err != nil {
 		return makeRemoteEditErrorReturn_edit(ids, visualEdit, fmt.Errorf("/remote:new %v", err))
 	}
+	if ids.Remote.RemoteCopy.IsWSL() {
+		_, keyFound := editArgs.EditMap[sstore.RemoteField_SSHKey]
+		_, passwordFound := editArgs.EditMap[sstore.RemoteField_SSHPassword]
+		if keyFound || passwordFound {
+			return makeRemoteEditErrorReturn_edit(ids, visualEdit, fmt.Errorf("/remote:set cannot set an ssh key or password for a WSL remote"))
+		}
+	}
 	if visualEdit && !isSubmitted && len(editArgs.EditMap) == 0 {
 		return makeRemoteEditUpdate_edit(ids, nil), nil
 	}
@@ -2309,13 +2570,20 @@ func NewHostInfo(hostName string) (*HostInfoType, error) {
 	return outHostInfo, nil
 }
 
-func RemoteConfigParseCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+func getSshConfigFiles() []string {
 	home := base.GetHomeDir()
 	localConfig := filepath.Join(home, ".ssh", "config")
 	systemConfig := filepath.Join("/etc", "ssh", "config")
-	sshConfigFiles := []string{localConfig, systemConfig}
+	return []string{localConfig, systemConfig}
+}
+
+// imports the hosts from the ssh config files: new hosts are created as remotes, previously imported
+// remotes are updated, or archived if their host was removed.  returns the changes (see createSshImportSummary)
+func importSshConfigRemotes(ctx context.Context) (map[string][]string, error) {
+	sshConfigImportLock.Lock()
+	defer sshConfigImportLock.Unlock()
 	ssh_config.ReloadConfigs()
-	hostPatterns, hostPatternsErr := resolveSshConfigPatterns(sshConfigFiles)
+	hostPatterns, hostPatternsErr := resolveSshConfigPatterns(getSshConfigFiles())
 	if hostPatternsErr != nil {
 		return nil, hostPatternsErr
 	}
@@ -2427,7 +2695,14 @@ func RemoteConfigParseCommand(ctx context.Context, pk *scpacket.FeCommandPacketT
 			log.Printf("sshconfig-import: created remote \"%s\" (%s)\n", hostInfo.Host, hostInfo.CanonicalName)
 		}
 	}
+	return remoteChangeList, nil
+}
 
+func RemoteConfigParseCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	remoteChangeList, err := importSshConfigRemotes(ctx)
+	if err != nil {
+		return nil, err
+	}
 	outMsg := createSshImportSummary(remoteChangeList)
 	visualEdit := resolveBool(pk.Kwargs["visual"], false)
 	if visualEdit {
@@ -2490,11 +2765,20 @@ func ScreenResetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (
 		return nil, fmt.Errorf("error resetting screen: %v", err)
 	}
 	sessionUpdate.Remotes = append(sessionUpdate.Remotes, ris...)
-	err = sstore.UpdateCurRemote(ctx, ids.ScreenId, rptr)
+	outputStr := "reset screen state (all remote state reset)"
+	screen, err := sstore.GetScreenById(ctx, ids.ScreenId)
 	if err != nil {
-		return nil, fmt.Errorf("cannot reset screen remote back to local: %w", err)
+		return nil, fmt.Errorf("cannot get screen: %w", err)
+	}
+	if screen != nil && screen.ScreenOpts.RemoteLock {
+		// locked screens stay on their remote
+		outputStr = "reset screen state (all remote state reset, screen is locked to its current remote)"
+	} else {
+		err = sstore.UpdateCurRemote(ctx, ids.ScreenId, rptr)
+		if err != nil {
+			return nil, fmt.Errorf("cannot reset screen remote back to local: %w", err)
+		}
 	}
-	outputStr := "reset screen state (all remote state reset)"
 	cmd, err := makeStaticCmd(ctx, "screen:reset", ids, pk.GetRawStr(), []byte(outputStr))
 	if err != nil {
 		// TODO tricky error since the command was a success, but we can't show the output
@@ -2521,6 +2805,11 @@ func RemoteArchiveCommand(ctx context.Context, pk *scpacket.FeCommandPacketType)
 	update := sstore.InfoMsgUpdate("remote [%s] archived", ids.Remote.DisplayName)
 	localRemote := remote.GetLocalRemote()
 	rptr := sstore.RemotePtrType{RemoteId: localRemote.GetRemoteId()}
+	// the lock cannot be kept on an archived remote
+	_, err = sstore.UpdateScreen(ctx, ids.ScreenId, map[string]interface{}{sstore.ScreenField_RemoteLock: false})
+	if err != nil {
+		return nil, fmt.Errorf("cannot unlock screen remote: %w", err)
+	}
 	err = sstore.UpdateCurRemote(ctx, ids.ScreenId, rptr)
 	if err != nil {
 		return nil, fmt.Errorf("cannot switch remote back to local: %w", err)
@@ -2946,7 +3235,7 @@ func OpenAICommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus
 		engineeredQuery := getCmdInfoEngineeredPrompt(promptStr, curLineStr, ids.Remote.ShellType, osType)
 		userQueryPk.UserEngineeredQuery = engineeredQuery
 		writePacketToUpdateBus(ctx, cmd, userQueryPk)
-		prompt := BuildOpenAIPromptArrayWithContext(sstore.ScreenMemGetCmdInfoChat(cmd.ScreenId).Messages)
+		prompt := buildCmdInfoPromptWithAttachments(sstore.ScreenMemGetCmdInfoChat(cmd.ScreenId), opts.MaxTokens)
 		go doOpenAICmdInfoCompletion(cmd, clientData.ClientId, opts, prompt, curLineStr)
 		update := scbus.MakeUpdatePacket()
 		return update, nil
@@ -3015,7 +3304,7 @@ func CrCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.Upd
 		return nil, fmt.Errorf("/%s error: remote %q not found (wsh)", GetCmdStr(pk), newRemote)
 	}
 	if !newWsh.IsConnected() {
-		err := newWsh.TryAutoConnect()
+		err := newWsh.TryAutoConnect(ids.ScreenId)
 		if err != nil {
 			return nil, fmt.Errorf("%q is disconnected, auto-connect failed: %w", rstate.GetBaseDisplayName(), err)
 		}
@@ -3037,7 +3326,7 @@ func CrCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.Upd
 	if ri == nil {
 		// ok, if ri is nil we need to do a reinit
 		verbose := resolveBool(pk.Kwargs["verbose"], false)
-		shellType, err := resolveShellType(pk.Kwargs["shell"], rstate.DefaultShellType)
+		shellType, err := resolveShellType(pk.Kwargs["shell"], defaultStr(getScreenShellPref(ctx, ids.ScreenId, *rptr), rstate.DefaultShellType))
 		if err != nil {
 			return nil, err
 		}
@@ -3348,6 +3637,72 @@ func CompFileDirCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (
 	return makeInfoFromComps(crtn.CompType, compStrs, crtn.HasMore), nil
 }
 
+// frecent directories on the current remote for the autocomplete (see sstore/cwdvisits.go).  unlike the other
+// completions these are not sorted, the best match is first.
+func SuggestDirsCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Remote)
+	if err != nil {
+		return nil, fmt.Errorf("/_suggestdirs error: %w", err)
+	}
+	dirs, err := sstore.SuggestDirs(ctx, ids.Remote.RemotePtr.RemoteId, firstArg(pk))
+	if err != nil {
+		return nil, fmt.Errorf("/_suggestdirs error: %w", err)
+	}
+	if len(dirs) == 0 {
+		dirs = []string{"(no completions)"}
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: "directory suggestions",
+		InfoComps: dirs,
+	})
+	return update, nil
+}
+
+// the completion spec of a command (arg 1) for the frontend autocomplete, user=1 only returns user specs (the
+// frontend has its own bundled specs)
+func CompSpecCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	name := firstArg(pk)
+	if name == "" {
+		return nil, fmt.Errorf("/_compspec requires a command name")
+	}
+	var spec *newton.Spec
+	if resolveBool(pk.Kwargs["user"], false) {
+		spec = newton.GetUserSpec(name)
+	} else {
+		spec = newton.GetSpec(name)
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(newton.CompletionSpecType{Name: name, Spec: spec})
+	return update, nil
+}
+
+// ranked completions for the command line (arg 1, up to the cursor) from the completion providers (see newton),
+// providers=[name,...] limits the providers (default all)
+func SuggestCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, 0) // best-effort, only some providers use the remote
+	if err != nil {
+		return nil, fmt.Errorf("/_suggest error: %w", err)
+	}
+	req := newton.SuggestionRequest{CmdLine: firstArg(pk)}
+	if ids.Remote != nil {
+		req.RemoteId = ids.Remote.RemotePtr.RemoteId
+		req.Cwd = ids.Remote.FeState["cwd"]
+	}
+	var providerNames []string
+	for name := range resolveCommaSepListToMap(pk.Kwargs["providers"]) {
+		providerNames = append(providerNames, name)
+	}
+	sort.Strings(providerNames)
+	suggestions, err := newton.GetSuggestions(ctx, req, providerNames)
+	if err != nil {
+		return nil, fmt.Errorf("/_suggest error: %w", err)
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(newton.SuggestionsType{CmdLine: req.CmdLine, Suggestions: suggestions})
+	return update, nil
+}
+
 func CompGenCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
 	ids, err := resolveUiIds(ctx, pk, 0) // best-effort
 	if err != nil {
@@ -3562,71 +3917,326 @@ func makeExternLink(urlStr string) string {
 	return fmt.Sprintf(`https://extern?%s`, url.QueryEscape(urlStr))
 }
 
-func ScreenWebShareCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
-	return nil, fmt.Errorf("websharing is no longer available")
+// the screen/line limits only apply to the hosted service
+func canScreenWebShare(ctx context.Context, screen *sstore.ScreenType, upstream *pcloud.WebShareUpstream) error {
+	if screen == nil {
+		return fmt.Errorf("cannot share screen, not found")
+	}
+	if screen.ShareMode == sstore.ShareModeWeb {
+		return fmt.Errorf("screen is already shared to web")
+	}
+	if screen.ShareMode != sstore.ShareModeLocal {
+		return fmt.Errorf("screen cannot be shared, invalid current share mode %q (must be local)", screen.ShareMode)
+	}
+	if screen.Archived {
+		return fmt.Errorf("screen cannot be shared, must un-archive before sharing")
+	}
+	if upstream.SelfHosted {
+		return nil
+	}
+	webShareCount, err := sstore.CountScreenWebShares(ctx)
+	if err != nil {
+		return fmt.Errorf("screen cannot be shared: error getting webshare count: %v", err)
+	}
+	if webShareCount >= sstore.MaxWebShareScreenCount {
+		return fmt.Errorf("screen cannot be shared, limited to a maximum of %d shared screen(s)", sstore.MaxWebShareScreenCount)
+	}
+	lineCount, err := sstore.CountScreenLines(ctx, screen.ScreenId)
+	if err != nil {
+		return fmt.Errorf("screen cannot be shared: error getting screen line count: %v", err)
+	}
+	if lineCount > sstore.MaxWebShareLineCount {
+		return fmt.Errorf("screen cannot be shared, limited to a maximum of %d lines", sstore.MaxWebShareLineCount)
+	}
+	return nil
 }
 
-func SessionDeleteCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
-	ids, err := resolveUiIds(ctx, pk, 0) // don't force R_Session
+// /screen:webshare [0|1] [sharename=...], shares to the hosted service or the self-hosted share server (clientopts webshareurl)
+func ScreenWebShareCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
 	if err != nil {
 		return nil, err
 	}
-	sessionId := ""
-	if len(pk.Args) >= 1 {
-		ritem, err := resolveSession(ctx, pk.Args[0], ids.SessionId)
+	shouldShare := true
+	if len(pk.Args) > 0 {
+		shouldShare = resolveBool(pk.Args[0], true)
+	}
+	shareName := pk.Kwargs["sharename"]
+	if err := validateShareName(shareName); err != nil {
+		return nil, err
+	}
+	screen, err := sstore.GetScreenById(ctx, ids.ScreenId)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get screen: %v", err)
+	}
+	upstream, err := pcloud.GetWebShareUpstream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var infoMsg string
+	if shouldShare {
+		err = canScreenWebShare(ctx, screen, upstream)
 		if err != nil {
-			return nil, fmt.Errorf("/session:delete error resolving session %q: %w", pk.Args[0], err)
+			return nil, err
 		}
-		if ritem == nil {
-			return nil, fmt.Errorf("/session:delete session %q not found", pk.Args[0])
+		viewKeyBytes := make([]byte, 9)
+		_, err = rand.Read(viewKeyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create viewkey: %v", err)
 		}
-		sessionId = ritem.Id
+		viewKey := base64.RawURLEncoding.EncodeToString(viewKeyBytes)
+		webShareOpts := sstore.ScreenWebShareOpts{ShareName: shareName, ViewKey: viewKey, ViewUrl: upstream.MakeViewUrl(ids.ScreenId, viewKey)}
+		webUpdate := pcloud.MakeScreenNewUpdate(screen, webShareOpts)
+		err = pcloud.DoSyncWebUpdate(webUpdate)
+		if err != nil {
+			return nil, fmt.Errorf("error starting webshare, error contacting share server: %v", err)
+		}
+		err = sstore.ScreenWebShareStart(ctx, ids.ScreenId, webShareOpts)
+		if err != nil {
+			return nil, fmt.Errorf("cannot web-share screen: %v", err)
+		}
+		// the lines and pty output are sent by the update writer (screenupdate table)
+		pcloud.StartUpdateWriter()
+		infoMsg = fmt.Sprintf("screen is now shared to the web at %s", webShareOpts.ViewUrl)
 	} else {
-		sessionId = ids.SessionId
-	}
-	if sessionId == "" {
-		return nil, fmt.Errorf("/session:delete no sessionid found")
+		webUpdate := pcloud.MakeScreenDelUpdate(screen, ids.ScreenId)
+		err = pcloud.DoSyncWebUpdate(webUpdate)
+		if err != nil {
+			return nil, fmt.Errorf("error stopping webshare, error contacting share server: %v", err)
+		}
+		err = sstore.ScreenWebShareStop(ctx, ids.ScreenId)
+		if err != nil {
+			return nil, fmt.Errorf("cannot stop web-sharing screen: %v", err)
+		}
+		infoMsg = "screen is no longer web shared"
 	}
-	update, err := sstore.DeleteSession(ctx, sessionId)
+	screen, err = sstore.GetScreenById(ctx, ids.ScreenId)
 	if err != nil {
-		return nil, fmt.Errorf("cannot delete session: %v", err)
+		return nil, fmt.Errorf("cannot get updated screen: %v", err)
 	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(*screen, sstore.InfoMsgType{InfoMsg: infoMsg, TimeoutMs: 2000})
 	return update, nil
 }
 
-func SessionArchiveCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
-	ids, err := resolveUiIds(ctx, pk, 0) // don't force R_Session
+// /screen:lanshare [0|1] [port=1629] [bind=127.0.0.1|lan|ip] [write=0], shares the screen, each share url can
+// be used by one client.  the share server listens on loopback unless bind is set (bind=lan is the first
+// local network address).  with write=1 the client can also run shell commands and send input to running
+// commands.  /screen:lanshare 0 stops all the shares of the screen.
+func ScreenLanShareCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
 	if err != nil {
 		return nil, err
 	}
-	sessionId := ""
-	if len(pk.Args) >= 1 {
-		ritem, err := resolveSession(ctx, pk.Args[0], ids.SessionId)
-		if err != nil {
-			return nil, fmt.Errorf("/session:archive error resolving session %q: %w", pk.Args[0], err)
-		}
-		if ritem == nil {
-			return nil, fmt.Errorf("/session:archive session %q not found", pk.Args[0])
+	shouldShare := true
+	if len(pk.Args) > 0 {
+		shouldShare = resolveBool(pk.Args[0], true)
+	}
+	if !shouldShare {
+		numStopped := lanshare.StopScreenShares(ids.ScreenId)
+		if numStopped == 0 {
+			return nil, fmt.Errorf("/screen:lanshare screen is not shared on the local network")
 		}
-		sessionId = ritem.Id
-	} else {
-		sessionId = ids.SessionId
+		return sstore.InfoMsgUpdate("stopped %d local network share(s)", numStopped), nil
 	}
-	if sessionId == "" {
-		return nil, fmt.Errorf("/session:archive no sessionid found")
+	port, err := resolvePosInt(pk.Kwargs["port"], lanshare.LanShareDefaultPort)
+	if err != nil {
+		return nil, fmt.Errorf("/screen:lanshare invalid port: %v", err)
 	}
-	archiveVal := true
-	if len(pk.Args) >= 2 {
-		archiveVal = resolveBool(pk.Args[1], true)
+	if port > 65535 {
+		return nil, fmt.Errorf("/screen:lanshare invalid port %d", port)
 	}
-	if archiveVal {
-		update, err := sstore.ArchiveSession(ctx, sessionId)
-		if err != nil {
-			return nil, fmt.Errorf("cannot archive session: %v", err)
+	shareOpts := lanshare.ShareOpts{Write: resolveBool(pk.Kwargs["write"], false), BindAddr: pk.Kwargs["bind"]}
+	if shareOpts.BindAddr == "lan" {
+		shareOpts.BindAddr = lanshare.GetLanAddress()
+		if shareOpts.BindAddr == "localhost" {
+			return nil, fmt.Errorf("/screen:lanshare no local network address found")
 		}
-		update.AddUpdate(sstore.InfoMsgType{
-			InfoMsg: "session archived",
-		})
+	}
+	if shareOpts.Write {
+		if scbase.IsReadOnly() {
+			return nil, fmt.Errorf("/screen:lanshare cannot create a write share in read-only mode")
+		}
+		shareOpts.RunCmdFn = func(ctx context.Context, screenId string, cmdStr string) error {
+			_, err := EvalScreenShellCommand(ctx, screenId, nil, cmdStr, nil)
+			return err
+		}
+	}
+	shareInfo, err := lanshare.StartScreenShare(ids.ScreenId, port, shareOpts)
+	if err != nil {
+		return nil, fmt.Errorf("/screen:lanshare %v", err)
+	}
+	accessStr := "read-only"
+	if shareOpts.Write {
+		accessStr = "with write access"
+	}
+	var lines []string
+	lines = append(lines, fmt.Sprintf("screen is shared (%s) on %s, the url can be used once:", accessStr, shareInfo.BindAddr))
+	lines = append(lines, "  "+shareInfo.Url)
+	for _, share := range lanshare.GetScreenShares(ids.ScreenId) {
+		if share.ViewerAddr == "" {
+			continue
+		}
+		writeStr := ""
+		if share.Write {
+			writeStr = " (write)"
+		}
+		lines = append(lines, fmt.Sprintf("  %q connected from %s%s", share.ViewerName, share.ViewerAddr, writeStr))
+	}
+	lines = append(lines, "unused urls expire after 30 minutes, stop sharing with /screen:lanshare 0")
+	if shareInfo.BindAddr != lanshare.LanShareDefaultBindAddr {
+		lines = append(lines, "the connection is not encrypted, only share on a trusted network")
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{InfoTitle: "local network share", InfoLines: lines})
+	return update, nil
+}
+
+// evals cmdStr on the screen's current remote as if it was typed (macros, tmux control), returns the
+// lineid of the new line ("" for commands that do not create a line)
+func EvalScreenCommand(ctx context.Context, screenId string, cmdStr string) (string, error) {
+	return evalScreenCommand(ctx, screenId, nil, cmdStr, nil)
+}
+
+// true if cmdStr evals to a shell command run (no metacommands, no bracket args)
+func isShellRunCmdStr(cmdStr string) bool {
+	bracketArgs, rest, err := EvalBracketArgs(cmdStr)
+	if err != nil {
+		return false
+	}
+	for key := range bracketArgs {
+		if key != KwArgNoHist {
+			return false
+		}
+	}
+	metaCmd, metaSubCmd, _ := parseMetaCmd(rest)
+	return metaCmd == "run" && metaSubCmd == ""
+}
+
+// like EvalScreenCommand, but only runs shell commands (metacommands are rejected).  used for commands that are
+// not typed by the local user (lan share and ssh attach clients, scheduled commands).  remotePtr nil runs on the
+// screen's current remote, kwargs are added to the command.
+func EvalScreenShellCommand(ctx context.Context, screenId string, remotePtr *sstore.RemotePtrType, cmdStr string, kwargs map[string]string) (string, error) {
+	if !isShellRunCmdStr(cmdStr) {
+		return "", fmt.Errorf("only shell commands can be run here (not metacommands)")
+	}
+	return evalScreenCommand(ctx, screenId, remotePtr, cmdStr, kwargs)
+}
+
+func evalScreenCommand(ctx context.Context, screenId string, remotePtr *sstore.RemotePtrType, cmdStr string, kwargs map[string]string) (string, error) {
+	screen, err := sstore.GetScreenById(ctx, screenId)
+	if err != nil {
+		return "", err
+	}
+	if screen == nil {
+		return "", fmt.Errorf("screen not found")
+	}
+	if remotePtr == nil {
+		remotePtr = &screen.CurRemote
+	}
+	pk := scpacket.MakeFeCommandPacket()
+	pk.MetaCmd = "eval"
+	pk.Args = []string{cmdStr}
+	pk.Kwargs = map[string]string{}
+	for key, val := range kwargs {
+		pk.Kwargs[key] = val
+	}
+	pk.UIContext = &scpacket.UIContextType{
+		SessionId: screen.SessionId,
+		ScreenId:  screen.ScreenId,
+		Remote:    remotePtr,
+	}
+	update, err := HandleCommand(ctx, pk)
+	if err != nil {
+		return "", err
+	}
+	if update == nil {
+		return "", nil
+	}
+	var lineId string
+	if modelUpdate, ok := update.(*scbus.ModelUpdatePacketType); ok {
+		lineUpdates := scbus.GetUpdateItems[sstore.LineUpdate](modelUpdate)
+		if len(lineUpdates) > 0 {
+			lineId = lineUpdates[0].Line.LineId
+		}
+	}
+	scbus.MainUpdateBus.DoUpdate(update)
+	return lineId, nil
+}
+
+func SessionDeleteCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, 0) // don't force R_Session
+	if err != nil {
+		return nil, err
+	}
+	sessionId := ""
+	if len(pk.Args) >= 1 {
+		ritem, err := resolveSession(ctx, pk.Args[0], ids.SessionId)
+		if err != nil {
+			return nil, fmt.Errorf("/session:delete error resolving session %q: %w", pk.Args[0], err)
+		}
+		if ritem == nil {
+			return nil, fmt.Errorf("/session:delete session %q not found", pk.Args[0])
+		}
+		sessionId = ritem.Id
+	} else {
+		sessionId = ids.SessionId
+	}
+	if sessionId == "" {
+		return nil, fmt.Errorf("/session:delete no sessionid found")
+	}
+	screens, err := sstore.GetSessionScreens(ctx, sessionId)
+	if err != nil {
+		return nil, fmt.Errorf("/session:delete cannot get screens: %v", err)
+	}
+	if len(screens) > sstore.BulkOpChunkSize {
+		// large sessions are deleted in the background (with progress updates)
+		op, err := sstore.StartBulkSessionDelete(ctx, sessionId)
+		if err != nil {
+			return nil, fmt.Errorf("cannot delete session: %v", err)
+		}
+		return bulkOpStartedUpdate(op, fmt.Sprintf("deleting session (%d screens)", len(screens))), nil
+	}
+	update, err := sstore.DeleteSession(ctx, sessionId)
+	if err != nil {
+		return nil, fmt.Errorf("cannot delete session: %v", err)
+	}
+	return update, nil
+}
+
+func SessionArchiveCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, 0) // don't force R_Session
+	if err != nil {
+		return nil, err
+	}
+	sessionId := ""
+	if len(pk.Args) >= 1 {
+		ritem, err := resolveSession(ctx, pk.Args[0], ids.SessionId)
+		if err != nil {
+			return nil, fmt.Errorf("/session:archive error resolving session %q: %w", pk.Args[0], err)
+		}
+		if ritem == nil {
+			return nil, fmt.Errorf("/session:archive session %q not found", pk.Args[0])
+		}
+		sessionId = ritem.Id
+	} else {
+		sessionId = ids.SessionId
+	}
+	if sessionId == "" {
+		return nil, fmt.Errorf("/session:archive no sessionid found")
+	}
+	archiveVal := true
+	if len(pk.Args) >= 2 {
+		archiveVal = resolveBool(pk.Args[1], true)
+	}
+	if archiveVal {
+		update, err := sstore.ArchiveSession(ctx, sessionId)
+		if err != nil {
+			return nil, fmt.Errorf("cannot archive session: %v", err)
+		}
+		update.AddUpdate(sstore.InfoMsgType{
+			InfoMsg: "session archived",
+		})
 		return update, nil
 	} else {
 		activate := resolveBool(pk.Kwargs["activate"], false)
@@ -3665,6 +4275,15 @@ func ScreenShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (s
 	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "tabicon", screen.ScreenOpts.TabIcon))
 	buf.WriteString(fmt.Sprintf("  %-15s %d\n", "selectedline", screen.SelectedLine))
 	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "curremote", GetFullRemoteDisplayName(&screen.CurRemote, &ids.Remote.RState)))
+	if screen.ScreenOpts.RemoteLock {
+		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "remotelock", "true"))
+	}
+	if screen.ScreenOpts.IdleKillHours > 0 {
+		buf.WriteString(fmt.Sprintf("  %-15s %dh\n", "idlekill", screen.ScreenOpts.IdleKillHours))
+	}
+	if len(screen.ScreenOpts.StartupCmds) > 0 {
+		buf.WriteString(fmt.Sprintf("  %-15s %d\n", "startupcmds", len(screen.ScreenOpts.StartupCmds)))
+	}
 	if statePtr != nil {
 		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "stateptr-base", statePtr.BaseHash))
 		buf.WriteString(fmt.Sprintf("  %-15s %v\n", "stateptr-diff", statePtr.DiffHashArr))
@@ -3730,7 +4349,7 @@ func SessionShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (
 	if session.Archived {
 		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "archived", "true"))
 		ts := time.UnixMilli(session.ArchivedTs)
-		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "archivedts", ts.Format(TsFormatStr)))
+		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "archivedts", formatTs(ctx, ts)))
 	}
 	stats, err := sstore.GetSessionStats(ctx, ids.SessionId)
 	if err != nil {
@@ -3862,12 +4481,16 @@ func SessionCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbu
 	if err != nil {
 		return nil, err
 	}
-	err = sstore.SetActiveSessionId(ctx, ritem.Id)
+	windowId := getUIWindowId(pk)
+	err = sstore.SetWindowActiveSessionId(ctx, windowId, ritem.Id)
 	if err != nil {
 		return nil, err
 	}
 	update := scbus.MakeUpdatePacket()
-	update.AddUpdate(sstore.ActiveSessionIdUpdate(ritem.Id))
+	err = addActiveSessionUpdate(ctx, update, windowId, ritem.Id)
+	if err != nil {
+		return nil, err
+	}
 	update.AddUpdate(sstore.InfoMsgType{
 		InfoMsg:   fmt.Sprintf("switched to session %q", ritem.Name),
 		TimeoutMs: 2000,
@@ -3975,6 +4598,29 @@ func DebugRemoteInstanceCommand(ctx context.Context, pk *scpacket.FeCommandPacke
 	return update, nil
 }
 
+// removes unreferenced shell states (with dryrun=1, only reports what would be removed)
+func DebugStateGCCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	dryRun := resolveBool(pk.Kwargs["dryrun"], false)
+	report, err := sstore.GCStates(ctx, sstore.StateGCMinAge, remote.GetActiveStateHashes(), dryRun)
+	if err != nil {
+		return nil, fmt.Errorf("/_debug:stategc error: %v", err)
+	}
+	verb := "removed"
+	if dryRun {
+		verb = "reclaimable"
+	}
+	outputLines := []string{
+		fmt.Sprintf("%s %d state diffs (%s)", verb, report.NumDiffs, prettyPrintByteSize(report.DiffBytes)),
+		fmt.Sprintf("%s %d state bases (%s)", verb, report.NumBases, prettyPrintByteSize(report.BaseBytes)),
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: "shell state gc",
+		InfoLines: outputLines,
+	})
+	return update, nil
+}
+
 func ClearSudoCache(ctx context.Context, pk *scpacket.FeCommandPacketType) (rtnUpdate scbus.UpdatePacket, rtnErr error) {
 	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
 	if err != nil {
@@ -4008,7 +4654,7 @@ func RemoteResetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (
 		return nil, fmt.Errorf("cannot reinit, remote is not connected")
 	}
 	verbose := resolveBool(pk.Kwargs["verbose"], false)
-	shellType, err := resolveShellType(pk.Kwargs["shell"], ids.Remote.ShellType)
+	shellType, err := resolveShellType(pk.Kwargs["shell"], defaultStr(getScreenShellPref(ctx, ids.ScreenId, ids.Remote.RemotePtr), ids.Remote.ShellType))
 	if err != nil {
 		return nil, err
 	}
@@ -4097,6 +4743,17 @@ func doAsyncResetCommand(wsh *remote.WaveshellProc, opts connectOptsType, cmd *s
 	update := scbus.MakeUpdatePacket()
 	update.AddUpdate(sstore.MakeSessionUpdateForRemote(opts.SessionId, remoteInst))
 	scbus.MainUpdateBus.DoUpdate(update)
+	err = runRemoteInitScripts(ctx, wsh, opts)
+	if err != nil {
+		// the new state is still valid, so the reset itself does not fail
+		writeStringToPty(ctx, cmd, fmt.Sprintf("\r\nerror running init scripts: %v", err), &outputPos)
+	}
+	if origStatePtr == nil {
+		err = runScreenStartupCmds(ctx, wsh, opts, remoteInst)
+		if err != nil {
+			writeStringToPty(ctx, cmd, fmt.Sprintf("\r\nerror running screen startup commands: %v", err), &outputPos)
+		}
+	}
 }
 
 func ResetCwdCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
@@ -4177,6 +4834,75 @@ func HistoryPurgeCommand(ctx context.Context, pk *scpacket.FeCommandPacketType)
 	return sstore.InfoMsgUpdate("removed history items"), nil
 }
 
+// lists the screens that have history but were deleted
+func HistoryScreensCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	hscreens, err := history.GetHistoricalScreens(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("/history:screens error getting screens: %v", err)
+	}
+	var buf bytes.Buffer
+	if len(hscreens) == 0 {
+		buf.WriteString("no history from deleted tabs\n")
+	}
+	for _, hscreen := range hscreens {
+		name := hscreen.Name
+		if name == "" {
+			name = "(unknown)"
+		}
+		deletedStr := "-"
+		if hscreen.DeletedTs > 0 {
+			deletedStr = time.UnixMilli(hscreen.DeletedTs).Format("2006-01-02")
+		}
+		buf.WriteString(fmt.Sprintf("  %s %s  deleted:%s  items:%d  archived:%d\n", utilfn.PadWidth(utilfn.TruncateWidth(name, 20), 20), hscreen.ScreenId, deletedStr, hscreen.NumItems, hscreen.NumArchived))
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: "history from deleted tabs (search with /history:viewall searchscreen=[screenid])",
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
+
+// /history:repair [fix=1] [archive=1]
+// fix=1 clears the line references of deleted lines, archive=1 tags the history of deleted tabs as archived
+func HistoryRepairCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	fix := resolveBool(pk.Kwargs["fix"], false)
+	archive := resolveBool(pk.Kwargs["archive"], false)
+	if fix || archive {
+		err := scbase.CheckReadOnly("/history:repair fix=1 or archive=1")
+		if err != nil {
+			return nil, err
+		}
+	}
+	report, err := history.RepairHistory(ctx, fix, archive)
+	if err != nil {
+		return nil, fmt.Errorf("/history:repair error: %v", err)
+	}
+	var buf bytes.Buffer
+	staleStr := fmt.Sprintf("%d", report.StaleLineRefs)
+	if fix && report.StaleLineRefs > 0 {
+		staleStr += " (fixed)"
+	}
+	buf.WriteString(fmt.Sprintf("  %-20s %s\n", "stale line refs", staleStr))
+	buf.WriteString(fmt.Sprintf("  %-20s %d\n", "deleted tabs", report.HistoricalScreens))
+	buf.WriteString(fmt.Sprintf("  %-20s %d\n", "deleted tab items", report.HistoricalItems))
+	if archive {
+		buf.WriteString(fmt.Sprintf("  %-20s %d\n", "newly archived", report.NumArchived))
+	}
+	if !fix && report.StaleLineRefs > 0 {
+		buf.WriteString("\nrun /history:repair fix=1 to clear the stale line refs\n")
+	}
+	if !archive && report.HistoricalItems > 0 {
+		buf.WriteString("\nrun /history:repair archive=1 to make the history of deleted tabs searchable with /history:viewall archive=1\n")
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: "history repair",
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
+
 const HistoryViewPageSize = 50
 
 var cmdFilterLs = regexp.MustCompile(`^ls(\s|$)`)
@@ -4220,6 +4946,14 @@ func HistoryViewAllCommand(ctx context.Context, pk *scpacket.FeCommandPacketType
 		}
 		opts.SessionId = sessionId
 	}
+	if pk.Kwargs["searchscreen"] != "" {
+		_, err := uuid.Parse(pk.Kwargs["searchscreen"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid searchscreen (must be a screenid)")
+		}
+		opts.ScreenId = pk.Kwargs["searchscreen"]
+	}
+	opts.Archived = resolveBool(pk.Kwargs["archive"], false)
 	if pk.Kwargs["searchremote"] != "" {
 		rptr, err := resolveRemoteArg(pk.Kwargs["searchremote"])
 		if err != nil {
@@ -4239,12 +4973,36 @@ func HistoryViewAllCommand(ctx context.Context, pk *scpacket.FeCommandPacketType
 			opts.FromTs = int64(fromTs)
 		}
 	}
+	if pk.Kwargs["date"] != "" {
+		// day boundaries are computed in the client timezone
+		loc := sstore.GetClientLocation(ctx)
+		dayStr, err := telemetry.GetCustomDayStrInLoc(pk.Kwargs["date"], loc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date arg: %v", err)
+		}
+		dayStartTs, dayEndTs, err := sstore.GetDayRange(dayStr, loc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date arg: %v", err)
+		}
+		opts.MinTs = dayStartTs
+		if opts.FromTs == 0 || opts.FromTs >= dayEndTs {
+			opts.FromTs = dayEndTs - 1
+		}
+	}
 	if pk.Kwargs["meta"] != "" {
 		opts.NoMeta = !resolveBool(pk.Kwargs["meta"], true)
 	}
 	if resolveBool(pk.Kwargs["filter"], false) {
 		opts.FilterFn = historyCmdFilter
 	}
+	err = resolveHistoryFilterKwargs(pk, &opts)
+	if err != nil {
+		return nil, err
+	}
+	if resolveBool(pk.Kwargs["dedup"], false) {
+		opts.Dedup = true
+		opts.UseCounts = true
+	}
 	if err != nil {
 		return nil, fmt.Errorf("invalid meta arg (must be boolean): %v", err)
 	}
@@ -4270,6 +5028,105 @@ func HistoryViewAllCommand(ctx context.Context, pk *scpacket.FeCommandPacketType
 	return update, nil
 }
 
+// status=, exitcode=, and cwd= (shared by /history:viewall, /history:top, and /history:stats)
+func resolveHistoryFilterKwargs(pk *scpacket.FeCommandPacketType, opts *history.HistoryQueryOpts) error {
+	if pk.Kwargs["status"] != "" {
+		if !history.IsValidHistoryStatus(pk.Kwargs["status"]) {
+			return fmt.Errorf("invalid status %q", pk.Kwargs["status"])
+		}
+		opts.Status = pk.Kwargs["status"]
+	}
+	if pk.Kwargs["exitcode"] != "" {
+		exitCode, err := strconv.ParseInt(pk.Kwargs["exitcode"], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid exitcode %q (must be a number)", pk.Kwargs["exitcode"])
+		}
+		opts.ExitCode = &exitCode
+	}
+	if pk.Kwargs["cwd"] != "" {
+		opts.Cwd = pk.Kwargs["cwd"]
+	}
+	return nil
+}
+
+const (
+	HistoryTopScope_Remote  = "remote"
+	HistoryTopScope_Cwd     = "cwd"
+	HistoryTopScope_Screen  = "screen"
+	HistoryTopScope_Session = "session"
+	HistoryTopScope_Global  = "global"
+)
+
+const DefaultHistoryTopItems = 20
+
+// sets the remote/cwd/screen/session filters for scope (shared by /history:top and /history:stats)
+func resolveHistoryScope(ids resolvedIds, scope string, opts *history.HistoryQueryOpts) error {
+	switch scope {
+	case HistoryTopScope_Remote:
+		opts.RemoteId = ids.Remote.RemotePtr.RemoteId
+	case HistoryTopScope_Cwd:
+		opts.RemoteId = ids.Remote.RemotePtr.RemoteId
+		opts.Cwd = ids.Remote.FeState["cwd"]
+	case HistoryTopScope_Screen:
+		opts.SessionId = ids.SessionId
+		opts.ScreenId = ids.ScreenId
+	case HistoryTopScope_Session:
+		opts.SessionId = ids.SessionId
+	case HistoryTopScope_Global:
+	default:
+		scopes := []string{HistoryTopScope_Remote, HistoryTopScope_Cwd, HistoryTopScope_Screen, HistoryTopScope_Session, HistoryTopScope_Global}
+		return fmt.Errorf("invalid scope '%s', valid scopes: %s", scope, formatStrs(scopes, "or", false))
+	}
+	return nil
+}
+
+// /history:top [search] scope=remote|cwd|screen|session|global, the most used commands (see history/rank.go)
+func HistoryTopCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, err
+	}
+	maxItems, err := resolvePosInt(pk.Kwargs["maxitems"], DefaultHistoryTopItems)
+	if err != nil {
+		return nil, fmt.Errorf("invalid maxitems value '%s' (must be a number): %v", pk.Kwargs["maxitems"], err)
+	}
+	offset, err := resolveNonNegInt(pk.Kwargs["offset"], 0)
+	if err != nil {
+		return nil, err
+	}
+	opts := history.HistoryQueryOpts{MaxItems: maxItems, Offset: offset, SearchText: firstArg(pk), NoMeta: true}
+	scope := defaultStr(pk.Kwargs["scope"], HistoryTopScope_Remote)
+	err = resolveHistoryScope(ids, scope, &opts)
+	if err != nil {
+		return nil, err
+	}
+	err = resolveHistoryFilterKwargs(pk, &opts)
+	if err != nil {
+		return nil, err
+	}
+	ranked, hasMore, err := history.GetRankedHistoryCmds(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("/history:top error: %v", err)
+	}
+	if len(ranked) == 0 {
+		return sstore.InfoMsgUpdate("no commands found (scope %s)", scope), nil
+	}
+	var buf bytes.Buffer
+	for _, cmd := range ranked {
+		errStr := ""
+		if cmd.NumErrors > 0 {
+			errStr = fmt.Sprintf(" (%d failed)", cmd.NumErrors)
+		}
+		buf.WriteString(fmt.Sprintf("  %5dx  %s%s\n", cmd.UseCount, utilfn.EllipsisStr(strings.ReplaceAll(cmd.CmdStr, "\n", " "), 80), errStr))
+	}
+	if hasMore {
+		buf.WriteString(fmt.Sprintf("  (more with offset=%d)\n", offset+len(ranked)))
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{InfoTitle: fmt.Sprintf("most used commands (scope %s)", scope), InfoLines: splitLinesForInfo(buf.String())})
+	return update, nil
+}
+
 const DefaultMaxHistoryItems = 10000
 
 func HistoryCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
@@ -4423,6 +5280,52 @@ func LineSetHeightCommand(ctx context.Context, pk *scpacket.FeCommandPacketType)
 	return nil, nil
 }
 
+// re-runs cmd (in place) on wsh, killing it first if it is still running
+func restartCmd(ctx context.Context, sessionId string, wsh *remote.WaveshellProc, remotePtr sstore.RemotePtrType, cmd *sstore.CmdType, termOpts *packet.TermOpts) error {
+	ck := base.MakeCommandKey(cmd.ScreenId, cmd.LineId)
+	if cmd.Status == sstore.CmdStatusRunning || cmd.Status == sstore.CmdStatusDetached {
+		killCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		err := wsh.KillRunningCommandAndWait(killCtx, ck)
+		if err != nil {
+			return err
+		}
+	}
+	wsh.ResetDataPos(ck)
+	err := sstore.ClearCmdPtyFile(ctx, cmd.ScreenId, cmd.LineId)
+	if err != nil {
+		return fmt.Errorf("error clearing existing pty file: %v", err)
+	}
+	runPacket := packet.MakeRunPacket()
+	runPacket.ReqId = uuid.New().String()
+	runPacket.CK = ck
+	runPacket.UsePty = true
+	runPacket.TermOpts = termOpts
+	runPacket.Command = cmd.CmdStr
+	runPacket.ReturnState = false
+	rcOpts := remote.RunCommandOpts{
+		SessionId:          sessionId,
+		ScreenId:           cmd.ScreenId,
+		RemotePtr:          remotePtr,
+		StatePtr:           &cmd.StatePtr,
+		NoCreateCmdPtyFile: true,
+	}
+	newCmd, callback, err := remote.RunCommand(ctx, rcOpts, runPacket)
+	if callback != nil {
+		defer callback()
+	}
+	if err != nil {
+		return err
+	}
+	sstore.IncrementNumRunningCmds(newCmd.ScreenId, 1)
+	newTs := time.Now().UnixMilli()
+	err = sstore.UpdateCmdForRestart(ctx, runPacket.CK, newTs, newCmd.CmdPid, newCmd.RemotePid, convertTermOpts(runPacket.TermOpts))
+	if err != nil {
+		return fmt.Errorf("error updating cmd for restart: %w", err)
+	}
+	return nil
+}
+
 func LineRestartCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
 	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_RemoteConnected)
 	if err != nil {
@@ -4456,50 +5359,23 @@ func LineRestartCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (
 	if cmd == nil {
 		return nil, fmt.Errorf("cannot restart line (no cmd found)")
 	}
-	if cmd.Status == sstore.CmdStatusRunning || cmd.Status == sstore.CmdStatusDetached {
-		killCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
-		defer cancel()
-		err = ids.Remote.Waveshell.KillRunningCommandAndWait(killCtx, base.MakeCommandKey(ids.ScreenId, lineId))
-		if err != nil {
-			return nil, err
-		}
+	err = checkRemoteCmdPolicy(pk, ids.Remote.RemoteCopy, cmd.CmdStr)
+	if err != nil {
+		return nil, err
 	}
-	ids.Remote.Waveshell.ResetDataPos(base.MakeCommandKey(ids.ScreenId, lineId))
-	err = sstore.ClearCmdPtyFile(ctx, ids.ScreenId, lineId)
+	err = checkRemoteDestructiveCmd(pk, ids.Remote.RemoteCopy, cmd.CmdStr)
 	if err != nil {
-		return nil, fmt.Errorf("error clearing existing pty file: %v", err)
+		return nil, err
 	}
-	runPacket := packet.MakeRunPacket()
-	runPacket.ReqId = uuid.New().String()
-	runPacket.CK = base.MakeCommandKey(ids.ScreenId, lineId)
-	runPacket.UsePty = true
 	// TODO how can we preseve the original termopts?
-	runPacket.TermOpts, err = GetUITermOpts(pk.UIContext.WinSize, DefaultPTERM)
+	termOpts, err := GetUITermOpts(pk.UIContext.WinSize, DefaultPTERM)
 	if err != nil {
 		return nil, fmt.Errorf("error getting creating termopts for command: %w", err)
 	}
-	runPacket.Command = cmd.CmdStr
-	runPacket.ReturnState = false
-	rcOpts := remote.RunCommandOpts{
-		SessionId:          ids.SessionId,
-		ScreenId:           ids.ScreenId,
-		RemotePtr:          ids.Remote.RemotePtr,
-		StatePtr:           &cmd.StatePtr,
-		NoCreateCmdPtyFile: true,
-	}
-	cmd, callback, err := remote.RunCommand(ctx, rcOpts, runPacket)
-	if callback != nil {
-		defer callback()
-	}
+	err = restartCmd(ctx, ids.SessionId, ids.Remote.Waveshell, ids.Remote.RemotePtr, cmd, termOpts)
 	if err != nil {
 		return nil, err
 	}
-	sstore.IncrementNumRunningCmds(cmd.ScreenId, 1)
-	newTs := time.Now().UnixMilli()
-	err = sstore.UpdateCmdForRestart(ctx, runPacket.CK, newTs, cmd.CmdPid, cmd.RemotePid, convertTermOpts(runPacket.TermOpts))
-	if err != nil {
-		return nil, fmt.Errorf("error updating cmd for restart: %w", err)
-	}
 	line, cmd, err = sstore.GetLineCmdByLineId(ctx, ids.ScreenId, lineId)
 	if err != nil {
 		return nil, fmt.Errorf("error getting updated line/cmd: %w", err)
@@ -4583,8 +5459,9 @@ func LineSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbu
 		varsUpdated = append(varsUpdated, KwArgView)
 	}
 	if stateJson, found := pk.Kwargs[KwArgState]; found {
-		if len(stateJson) > sstore.MaxLineStateSize {
-			return nil, fmt.Errorf("invalid state value (too large), size[%d], max[%d]", len(stateJson), sstore.MaxLineStateSize)
+		maxStateSize := sstore.GetMaxLineStateSize(ctx)
+		if len(stateJson) > maxStateSize {
+			return nil, fmt.Errorf("invalid state value (too large), size[%d], max[%d]", len(stateJson), maxStateSize)
 		}
 		var stateMap map[string]any
 		err = json.Unmarshal([]byte(stateJson), &stateMap)
@@ -4597,8 +5474,15 @@ func LineSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbu
 		}
 		varsUpdated = append(varsUpdated, KwArgState)
 	}
+	if encodingArg, found := pk.Kwargs[KwArgEncoding]; found {
+		err = setLineEncoding(ctx, ids.ScreenId, lineId, encodingArg)
+		if err != nil {
+			return nil, err
+		}
+		varsUpdated = append(varsUpdated, KwArgEncoding)
+	}
 	if len(varsUpdated) == 0 {
-		return nil, fmt.Errorf("/line:set requires a value to set: %s", formatStrs([]string{KwArgView, KwArgState}, "or", false))
+		return nil, fmt.Errorf("/line:set requires a value to set: %s", formatStrs([]string{KwArgView, KwArgState, KwArgEncoding}, "or", false))
 	}
 	updatedLine, err := sstore.GetLineById(ctx, ids.ScreenId, lineId)
 	if err != nil {
@@ -4613,6 +5497,38 @@ func LineSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbu
 	return update, nil
 }
 
+// encoding "auto" removes the encoding (it is detected again if the cmd is still running)
+func setLineEncoding(ctx context.Context, screenId string, lineId string, encodingArg string) error {
+	encoding := ""
+	if encodingArg != "auto" {
+		encoding = sstore.NormalizeEncoding(strings.ToLower(encodingArg))
+		if encoding == "" {
+			return fmt.Errorf("invalid encoding %q, must be \"auto\" or one of: %s", encodingArg, strings.Join(sstore.SupportedEncodings, ", "))
+		}
+	}
+	line, err := sstore.GetLineById(ctx, screenId, lineId)
+	if err != nil {
+		return fmt.Errorf("error getting line: %v", err)
+	}
+	if line == nil {
+		return fmt.Errorf("line not found")
+	}
+	lineState := make(map[string]any)
+	for key, val := range line.LineState {
+		lineState[key] = val
+	}
+	if encoding == "" {
+		delete(lineState, sstore.LineState_Encoding)
+	} else {
+		lineState[sstore.LineState_Encoding] = encoding
+	}
+	err = sstore.UpdateLineState(ctx, screenId, lineId, lineState)
+	if err != nil {
+		return fmt.Errorf("cannot update linestate: %v", err)
+	}
+	return nil
+}
+
 func LineViewCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
 	if len(pk.Args) != 3 {
 		return nil, fmt.Errorf("usage /line:view [session] [screen] [line]")
@@ -4680,6 +5596,117 @@ func BookmarksShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType)
 	return update, nil
 }
 
+func resolveBookmarkFormat(pk *scpacket.FeCommandPacketType) (string, error) {
+	format := strings.ToLower(pk.Kwargs["format"])
+	if format != bookmarks.ImportFormat_Warp && format != bookmarks.ImportFormat_VSCode {
+		return "", fmt.Errorf("%s requires format=%s or format=%s", GetCmdStr(pk), bookmarks.ImportFormat_Warp, bookmarks.ImportFormat_VSCode)
+	}
+	return format, nil
+}
+
+// warp: path can be a workflow file or a directory of workflow files.  vscode: path is a tasks.json file
+func BookmarksImportCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	format, err := resolveBookmarkFormat(pk)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf("/bookmarks:import requires a path argument")
+	}
+	fileName := base.ExpandHomeDir(pk.Args[0])
+	var fileNames []string
+	finfo, err := os.Stat(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot stat %q: %v", fileName, err)
+	}
+	if finfo.IsDir() {
+		if format != bookmarks.ImportFormat_Warp {
+			return nil, fmt.Errorf("/bookmarks:import format=%s requires a file", format)
+		}
+		for _, pattern := range []string{"*.yaml", "*.yml"} {
+			matches, _ := filepath.Glob(filepath.Join(fileName, pattern))
+			fileNames = append(fileNames, matches...)
+		}
+	} else {
+		fileNames = []string{fileName}
+	}
+	var bms []*bookmarks.BookmarkType
+	for _, fileName := range fileNames {
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read %q: %v", fileName, err)
+		}
+		var fileBms []*bookmarks.BookmarkType
+		if format == bookmarks.ImportFormat_Warp {
+			fileBms, err = bookmarks.ImportWarpWorkflows(data)
+		} else {
+			fileBms, err = bookmarks.ImportVSCodeTasks(data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filepath.Base(fileName), err)
+		}
+		bms = append(bms, fileBms...)
+	}
+	if tag := pk.Kwargs["tag"]; tag != "" {
+		for _, bm := range bms {
+			bm.Tags = append(bm.Tags, tag)
+		}
+	}
+	numAdded, numSkipped, err := bookmarks.InsertImportedBookmarks(ctx, bms)
+	if err != nil {
+		return nil, fmt.Errorf("error importing bookmarks (%d imported): %v", numAdded, err)
+	}
+	allBms, err := bookmarks.GetBookmarks(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("cannot retrieve bookmarks: %v", err)
+	}
+	update := scbus.MakeUpdatePacket()
+	bookmarks.AddBookmarksUpdate(update, allBms, nil)
+	update.AddUpdate(sstore.InfoMsgUpdate("imported %d bookmark(s), skipped %d already bookmarked", numAdded, numSkipped))
+	return update, nil
+}
+
+// warp: path is a directory (one workflow file per bookmark).  vscode: path is a tasks.json file
+func BookmarksExportCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	format, err := resolveBookmarkFormat(pk)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf("/bookmarks:export requires a path argument")
+	}
+	outPath := base.ExpandHomeDir(pk.Args[0])
+	bms, err := bookmarks.GetBookmarks(ctx, pk.Kwargs["tag"])
+	if err != nil {
+		return nil, fmt.Errorf("cannot retrieve bookmarks: %v", err)
+	}
+	if len(bms) == 0 {
+		return nil, fmt.Errorf("no bookmarks to export")
+	}
+	if format == bookmarks.ImportFormat_Warp {
+		err = os.MkdirAll(outPath, 0755)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create directory %q: %v", outPath, err)
+		}
+		for name, data := range bookmarks.ExportWarpWorkflows(bms) {
+			err = os.WriteFile(filepath.Join(outPath, name), data, 0644)
+			if err != nil {
+				return nil, fmt.Errorf("cannot write %q: %v", name, err)
+			}
+		}
+	} else {
+		data, err := bookmarks.ExportVSCodeTasks(bms)
+		if err != nil {
+			return nil, fmt.Errorf("cannot export bookmarks: %v", err)
+		}
+		err = os.WriteFile(outPath, data, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("cannot write %q: %v", outPath, err)
+		}
+	}
+	return sstore.InfoMsgUpdate("exported %d bookmark(s) to %s", len(bms), outPath), nil
+}
+
 func BookmarkSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
 	if len(pk.Args) == 0 {
 		return nil, fmt.Errorf("/bookmark:set requires one argument (bookmark id)")
@@ -4696,48 +5723,358 @@ func BookmarkSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (
 	if descStr, found := pk.Kwargs["desc"]; found {
 		editMap[bookmarks.BookmarkField_Desc] = descStr
 	}
-	if cmdStr, found := pk.Kwargs["cmdstr"]; found {
-		editMap[bookmarks.BookmarkField_CmdStr] = cmdStr
+	if cmdStr, found := pk.Kwargs["cmdstr"]; found {
+		editMap[bookmarks.BookmarkField_CmdStr] = cmdStr
+	}
+	if len(editMap) == 0 {
+		return nil, fmt.Errorf("no fields set, can set %s", formatStrs([]string{"desc", "cmdstr"}, "or", false))
+	}
+	err = bookmarks.EditBookmark(ctx, bookmarkId, editMap)
+	if err != nil {
+		return nil, fmt.Errorf("error trying to edit bookmark: %v", err)
+	}
+	bm, err := bookmarks.GetBookmarkById(ctx, bookmarkId, "")
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving edited bookmark: %v", err)
+	}
+	bms := []*bookmarks.BookmarkType{bm}
+	update := scbus.MakeUpdatePacket()
+	bookmarks.AddBookmarksUpdate(update, bms, nil)
+	update.AddUpdate(sstore.InfoMsgUpdate("bookmark edited"))
+	return update, nil
+}
+
+func BookmarkDeleteCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf("/bookmark:delete requires one argument (bookmark id)")
+	}
+	bookmarkArg := pk.Args[0]
+	bookmarkId, err := bookmarks.GetBookmarkIdByArg(ctx, bookmarkArg)
+	if err != nil {
+		return nil, fmt.Errorf("error trying to resolve bookmark: %v", err)
+	}
+	if bookmarkId == "" {
+		return nil, fmt.Errorf("bookmark not found")
+	}
+	err = bookmarks.DeleteBookmark(ctx, bookmarkId)
+	if err != nil {
+		return nil, fmt.Errorf("error deleting bookmark: %v", err)
+	}
+	update := scbus.MakeUpdatePacket()
+	bms := []*bookmarks.BookmarkType{{BookmarkId: bookmarkId, Remove: true}}
+	bookmarks.AddBookmarksUpdate(update, bms, nil)
+	update.AddUpdate(sstore.InfoMsgUpdate("bookmark deleted"))
+	return update, nil
+}
+
+const MaxWebhookLogItems = 20
+
+func WebhookCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	return nil, fmt.Errorf("/webhook requires a subcommand: %s", formatStrs([]string{"add", "show", "set", "delete", "test", "log"}, "or", false))
+}
+
+func resolveWebhookArg(ctx context.Context, cmdStr string, pk *scpacket.FeCommandPacketType) (*notify.WebhookType, error) {
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf("/%s requires one argument (webhook name or id)", cmdStr)
+	}
+	webhook, err := notify.GetWebhookByArg(ctx, pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("/%s error trying to resolve webhook: %v", cmdStr, err)
+	}
+	if webhook == nil {
+		return nil, fmt.Errorf("/%s webhook %q not found", cmdStr, pk.Args[0])
+	}
+	return webhook, nil
+}
+
+func WebhookAddCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	urlStr := pk.Kwargs["url"]
+	if urlStr == "" {
+		return nil, fmt.Errorf("/webhook:add requires a url (url=[url])")
+	}
+	parsedUrl, err := url.Parse(urlStr)
+	if err != nil || (parsedUrl.Scheme != "http" && parsedUrl.Scheme != "https") || parsedUrl.Host == "" {
+		return nil, fmt.Errorf("/webhook:add invalid url, must be an absolute http or https url")
+	}
+	name := pk.Kwargs["name"]
+	if name != "" {
+		err = validateName(name, "webhook")
+		if err != nil {
+			return nil, err
+		}
+	}
+	filterStr := pk.Kwargs["filter"]
+	_, err = notify.ParseFilter(filterStr)
+	if err != nil {
+		return nil, fmt.Errorf("/webhook:add invalid filter: %v", err)
+	}
+	templateStr := pk.Kwargs["template"]
+	_, err = notify.MakePayload(templateStr, notify.CmdDoneEventType{})
+	if err != nil {
+		return nil, fmt.Errorf("/webhook:add %v", err)
+	}
+	webhook := &notify.WebhookType{
+		WebhookId: scbase.GenWaveUUID(),
+		Name:      name,
+		Url:       urlStr,
+		Template:  templateStr,
+		Filter:    filterStr,
+		Enabled:   true,
+		CreatedTs: time.Now().UnixMilli(),
+	}
+	err = notify.InsertWebhook(ctx, webhook)
+	if err != nil {
+		return nil, fmt.Errorf("/webhook:add error adding webhook: %v", err)
+	}
+	return sstore.InfoMsgUpdate("webhook %s added", webhookDisplayName(webhook)), nil
+}
+
+func webhookDisplayName(webhook *notify.WebhookType) string {
+	if webhook.Name != "" {
+		return webhook.Name
+	}
+	return webhook.WebhookId[0:8]
+}
+
+func WebhookShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	webhooks, err := notify.GetWebhooks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("/webhook:show error getting webhooks: %v", err)
+	}
+	var buf bytes.Buffer
+	for _, webhook := range webhooks {
+		filterStr := webhook.Filter
+		if filterStr == "" {
+			filterStr = "(all commands)"
+		}
+		buf.WriteString(fmt.Sprintf("  %-10s %-15s %s\n", webhook.WebhookId[0:8], webhookDisplayName(webhook), webhook.Url))
+		buf.WriteString(fmt.Sprintf("  %-10s %-15s %s\n", "", "filter", filterStr))
+		if webhook.Template != "" {
+			buf.WriteString(fmt.Sprintf("  %-10s %-15s %s\n", "", "template", webhook.Template))
+		}
+		if !webhook.Enabled {
+			buf.WriteString(fmt.Sprintf("  %-10s %-15s %s\n", "", "enabled", "false"))
+		}
+	}
+	if len(webhooks) == 0 {
+		buf.WriteString("  (no webhooks)\n")
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: "webhooks",
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
+
+func WebhookSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	webhook, err := resolveWebhookArg(ctx, "webhook:set", pk)
+	if err != nil {
+		return nil, err
+	}
+	if _, found := pk.Kwargs["enabled"]; !found {
+		return nil, fmt.Errorf("/webhook:set requires a value to set (enabled=[0|1])")
+	}
+	enabled := resolveBool(pk.Kwargs["enabled"], true)
+	err = notify.SetWebhookEnabled(ctx, webhook.WebhookId, enabled)
+	if err != nil {
+		return nil, fmt.Errorf("/webhook:set error updating webhook: %v", err)
+	}
+	return sstore.InfoMsgUpdate("webhook %s enabled=%s", webhookDisplayName(webhook), boolToStr(enabled, "1", "0")), nil
+}
+
+func WebhookDeleteCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	webhook, err := resolveWebhookArg(ctx, "webhook:delete", pk)
+	if err != nil {
+		return nil, err
+	}
+	err = notify.DeleteWebhook(ctx, webhook.WebhookId)
+	if err != nil {
+		return nil, fmt.Errorf("/webhook:delete error deleting webhook: %v", err)
+	}
+	return sstore.InfoMsgUpdate("webhook %s deleted", webhookDisplayName(webhook)), nil
+}
+
+// sends a synthetic event (does not check the webhook's filter)
+func WebhookTestCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	webhook, err := resolveWebhookArg(ctx, "webhook:test", pk)
+	if err != nil {
+		return nil, err
+	}
+	event := notify.CmdDoneEventType{
+		CmdStr:     "echo webhook test",
+		Remote:     sstore.LocalRemoteAlias,
+		Status:     sstore.CmdStatusDone,
+		ExitCode:   0,
+		DurationMs: 0,
+		DoneTs:     time.Now().UnixMilli(),
+	}
+	go notify.DeliverWebhook(webhook, event)
+	return sstore.InfoMsgUpdate("test notification queued for webhook %s (see /webhook:log)", webhookDisplayName(webhook)), nil
+}
+
+func WebhookLogCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	logItems, err := notify.GetNotificationLog(ctx, MaxWebhookLogItems)
+	if err != nil {
+		return nil, fmt.Errorf("/webhook:log error getting notification log: %v", err)
+	}
+	var buf bytes.Buffer
+	for _, logItem := range logItems {
+		ts := time.UnixMilli(logItem.Ts)
+		buf.WriteString(fmt.Sprintf("  %s  %-10s %-8s attempts=%d http=%d", formatTs(ctx, ts), logItem.WebhookId[0:8], logItem.Status, logItem.Attempts, logItem.HttpStatus))
+		if logItem.ErrorStr != "" {
+			buf.WriteString(fmt.Sprintf(" error=%s", logItem.ErrorStr))
+		}
+		buf.WriteString("\n")
+	}
+	if len(logItems) == 0 {
+		buf.WriteString("  (no notifications)\n")
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: "webhook notification log",
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
+
+func ScheduleCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	return nil, fmt.Errorf("/schedule requires a subcommand: %s", formatStrs([]string{"add", "show", "pause", "resume", "delete"}, "or", false))
+}
+
+func resolveScheduleArg(ctx context.Context, cmdStr string, pk *scpacket.FeCommandPacketType) (*scheduler.ScheduleType, error) {
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf("/%s requires one argument (schedule id)", cmdStr)
+	}
+	sched, err := scheduler.GetScheduleByArg(ctx, pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("/%s error trying to resolve schedule: %v", cmdStr, err)
+	}
+	if sched == nil {
+		return nil, fmt.Errorf("/%s schedule %q not found", cmdStr, pk.Args[0])
+	}
+	return sched, nil
+}
+
+// runs on the current screen and remote, e.g. /schedule:add cron="*/15 * * * *" "make test"
+func ScheduleAddCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, fmt.Errorf("/schedule:add error: %w", err)
+	}
+	cronStr := pk.Kwargs["cron"]
+	if cronStr == "" {
+		return nil, fmt.Errorf("/schedule:add requires a cron expression (cron=[expr])")
+	}
+	_, err = scheduler.ParseCron(cronStr)
+	if err != nil {
+		return nil, fmt.Errorf("/schedule:add %v", err)
+	}
+	if len(pk.Args) != 1 || strings.TrimSpace(pk.Args[0]) == "" {
+		return nil, fmt.Errorf("/schedule:add requires one argument (the command to run, quoted)")
+	}
+	cmdStr := pk.Args[0]
+	if len(cmdStr) > MaxCommandLen {
+		return nil, fmt.Errorf("/schedule:add command length too long len:%d, max:%d", len(cmdStr), MaxCommandLen)
+	}
+	sched := &scheduler.ScheduleType{
+		ScheduleId: scbase.GenWaveUUID(),
+		ScreenId:   ids.ScreenId,
+		Remote:     ids.Remote.RemotePtr,
+		CmdStr:     cmdStr,
+		CronExpr:   cronStr,
+		Enabled:    true,
+		CreatedTs:  time.Now().UnixMilli(),
+	}
+	err = scheduler.InsertSchedule(ctx, sched)
+	if err != nil {
+		return nil, fmt.Errorf("/schedule:add error adding schedule: %v", err)
+	}
+	nextRunStr := formatTs(ctx, time.UnixMilli(sched.NextRunTs))
+	return sstore.InfoMsgUpdate("schedule %s added, next run at %s", sched.ScheduleId[0:8], nextRunStr), nil
+}
+
+// shows schedules for the current screen (all=1 to show schedules for all screens)
+func ScheduleShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	var screenId string
+	if !resolveBool(pk.Kwargs["all"], false) {
+		ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+		if err != nil {
+			return nil, fmt.Errorf("/schedule:show error: %w", err)
+		}
+		screenId = ids.ScreenId
+	}
+	schedules, err := scheduler.GetSchedules(ctx, screenId)
+	if err != nil {
+		return nil, fmt.Errorf("/schedule:show error getting schedules: %v", err)
+	}
+	var buf bytes.Buffer
+	for _, sched := range schedules {
+		buf.WriteString(fmt.Sprintf("  %-10s %-20s %s\n", sched.ScheduleId[0:8], sched.CronExpr, sched.CmdStr))
+		if !sched.Enabled {
+			buf.WriteString(fmt.Sprintf("  %-10s %-20s %s\n", "", "status", "paused"))
+		} else if sched.NextRunTs > 0 {
+			buf.WriteString(fmt.Sprintf("  %-10s %-20s %s\n", "", "nextrun", formatTs(ctx, time.UnixMilli(sched.NextRunTs))))
+		}
+		if sched.LastRunTs > 0 {
+			buf.WriteString(fmt.Sprintf("  %-10s %-20s %s\n", "", "lastrun", formatTs(ctx, time.UnixMilli(sched.LastRunTs))))
+		}
+		if sched.LastError != "" {
+			buf.WriteString(fmt.Sprintf("  %-10s %-20s %s\n", "", "lasterror", sched.LastError))
+		}
 	}
-	if len(editMap) == 0 {
-		return nil, fmt.Errorf("no fields set, can set %s", formatStrs([]string{"desc", "cmdstr"}, "or", false))
+	if len(schedules) == 0 {
+		buf.WriteString("  (no schedules)\n")
 	}
-	err = bookmarks.EditBookmark(ctx, bookmarkId, editMap)
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: "schedules",
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
+
+func SchedulePauseCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	sched, err := resolveScheduleArg(ctx, "schedule:pause", pk)
 	if err != nil {
-		return nil, fmt.Errorf("error trying to edit bookmark: %v", err)
+		return nil, err
 	}
-	bm, err := bookmarks.GetBookmarkById(ctx, bookmarkId, "")
+	err = scheduler.SetScheduleEnabled(ctx, sched.ScheduleId, false)
 	if err != nil {
-		return nil, fmt.Errorf("error retrieving edited bookmark: %v", err)
+		return nil, fmt.Errorf("/schedule:pause error updating schedule: %v", err)
 	}
-	bms := []*bookmarks.BookmarkType{bm}
-	update := scbus.MakeUpdatePacket()
-	bookmarks.AddBookmarksUpdate(update, bms, nil)
-	update.AddUpdate(sstore.InfoMsgUpdate("bookmark edited"))
-	return update, nil
+	return sstore.InfoMsgUpdate("schedule %s paused", sched.ScheduleId[0:8]), nil
 }
 
-func BookmarkDeleteCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
-	if len(pk.Args) == 0 {
-		return nil, fmt.Errorf("/bookmark:delete requires one argument (bookmark id)")
+func ScheduleResumeCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	sched, err := resolveScheduleArg(ctx, "schedule:resume", pk)
+	if err != nil {
+		return nil, err
 	}
-	bookmarkArg := pk.Args[0]
-	bookmarkId, err := bookmarks.GetBookmarkIdByArg(ctx, bookmarkArg)
+	err = scheduler.SetScheduleEnabled(ctx, sched.ScheduleId, true)
 	if err != nil {
-		return nil, fmt.Errorf("error trying to resolve bookmark: %v", err)
+		return nil, fmt.Errorf("/schedule:resume error updating schedule: %v", err)
 	}
-	if bookmarkId == "" {
-		return nil, fmt.Errorf("bookmark not found")
+	return sstore.InfoMsgUpdate("schedule %s resumed", sched.ScheduleId[0:8]), nil
+}
+
+func ScheduleDeleteCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	sched, err := resolveScheduleArg(ctx, "schedule:delete", pk)
+	if err != nil {
+		return nil, err
 	}
-	err = bookmarks.DeleteBookmark(ctx, bookmarkId)
+	err = scheduler.DeleteSchedule(ctx, sched.ScheduleId)
 	if err != nil {
-		return nil, fmt.Errorf("error deleting bookmark: %v", err)
+		return nil, fmt.Errorf("/schedule:delete error deleting schedule: %v", err)
 	}
-	update := scbus.MakeUpdatePacket()
-	bms := []*bookmarks.BookmarkType{{BookmarkId: bookmarkId, Remove: true}}
-	bookmarks.AddBookmarksUpdate(update, bms, nil)
-	update.AddUpdate(sstore.InfoMsgUpdate("bookmark deleted"))
-	return update, nil
+	return sstore.InfoMsgUpdate("schedule %s deleted", sched.ScheduleId[0:8]), nil
+}
+
+// scheduler.RunScheduleFnType, evals the scheduled command as if it was typed on the schedule's screen.
+// the new line (and its history item) are tagged with the scheduleid.
+func RunScheduledCommand(ctx context.Context, sched *scheduler.ScheduleType) (string, error) {
+	remotePtr := sched.Remote
+	return EvalScreenShellCommand(ctx, sched.ScreenId, &remotePtr, sched.CmdStr, map[string]string{KwArgScheduleId: sched.ScheduleId})
 }
 
 func LineBookmarkCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
@@ -4994,9 +6331,21 @@ func LineShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scb
 	}
 	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "linenum", lineNumStr))
 	ts := time.UnixMilli(line.Ts)
-	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "ts", ts.Format(TsFormatStr)))
+	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "ts", formatTs(ctx, ts)))
 	if line.Ephemeral {
 		buf.WriteString(fmt.Sprintf("  %-15s %v\n", "ephemeral", true))
+		if expireTs, ok := line.LineState[sstore.LineState_EphemeralExpireTs].(float64); ok {
+			buf.WriteString(fmt.Sprintf("  %-15s %s\n", "expires", formatTs(ctx, time.UnixMilli(int64(expireTs)))))
+		}
+	}
+	if scheduleId, ok := line.LineState[sstore.LineState_ScheduleId].(string); ok {
+		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "schedule", scheduleId))
+	}
+	if chainId, ok := line.LineState[sstore.LineState_ChainId].(string); ok {
+		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "chain", chainId))
+	}
+	if len(line.Tags) > 0 {
+		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "tags", strings.Join(line.Tags, ", ")))
 	}
 	if line.Renderer != "" {
 		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "renderer", line.Renderer))
@@ -5020,25 +6369,26 @@ func LineShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scb
 		if stat == nil {
 			buf.WriteString(fmt.Sprintf("  %-15s %s\n", "file", "-"))
 		} else {
-			fileDataStr := fmt.Sprintf("v%d data=%d offset=%d max=%s", stat.Version, stat.DataSize, stat.FileOffset, scbase.NumFormatB2(stat.MaxSize))
+			fileDataStr := fmt.Sprintf("data=%d offset=%d max=%s", stat.DataSize, stat.FileOffset, scbase.NumFormatB2(stat.MaxSize))
+			if stat.Compressed {
+				fileDataStr += " (archived)"
+			}
 			buf.WriteString(fmt.Sprintf("  %-15s %s\n", "file", stat.Location))
 			buf.WriteString(fmt.Sprintf("  %-15s %s\n", "file-data", fileDataStr))
 		}
 		if cmd.RestartTs > 0 {
 			restartTs := time.UnixMilli(cmd.RestartTs)
-			buf.WriteString(fmt.Sprintf("  %-15s %s\n", "restartts", restartTs.Format(TsFormatStr)))
+			buf.WriteString(fmt.Sprintf("  %-15s %s\n", "restartts", formatTs(ctx, restartTs)))
 		}
 		if cmd.DoneTs != 0 {
 			doneTs := time.UnixMilli(cmd.DoneTs)
-			buf.WriteString(fmt.Sprintf("  %-15s %s\n", "donets", doneTs.Format(TsFormatStr)))
+			buf.WriteString(fmt.Sprintf("  %-15s %s\n", "donets", formatTs(ctx, doneTs)))
 			buf.WriteString(fmt.Sprintf("  %-15s %d\n", "exitcode", cmd.ExitCode))
 			buf.WriteString(fmt.Sprintf("  %-15s %dms\n", "duration", cmd.DurationMs))
 		}
 	}
 	stateStr := dbutil.QuickJson(line.LineState)
-	if len(stateStr) > 80 {
-		stateStr = stateStr[0:77] + "..."
-	}
+	stateStr = utilfn.TruncateWidth(stateStr, 80)
 	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "state", stateStr))
 	update := scbus.MakeUpdatePacket()
 	update.AddUpdate(sstore.InfoMsgType{
@@ -5127,7 +6477,7 @@ func ViewStatCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scb
 	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "path", resp.Info.Name))
 	buf.WriteString(fmt.Sprintf("  %-15s %d\n", "size", resp.Info.Size))
 	modTs := time.UnixMilli(resp.Info.ModTs)
-	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "modts", modTs.Format(TsFormatStr)))
+	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "modts", formatTs(ctx, modTs)))
 	buf.WriteString(fmt.Sprintf("  %-15s %v\n", "isdir", resp.Info.IsDir))
 	modeStr := fs.FileMode(resp.Info.Perm).String()
 	if len(modeStr) > 9 {
@@ -5599,6 +6949,11 @@ func boolToStr(v bool, trueStr string, falseStr string) string {
 	return falseStr
 }
 
+// formats in the client timezone and locale
+func formatTs(ctx context.Context, ts time.Time) string {
+	return sstore.FormatClientTs(ctx, ts)
+}
+
 func ClientAcceptTosCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
 	clientData, err := sstore.EnsureClientData(ctx)
 	if err != nil {
@@ -5691,6 +7046,48 @@ func ClientSetGlobalShortcut(ctx context.Context, pk *scpacket.FeCommandPacketTy
 	return update, nil
 }
 
+const (
+	DropdownTarget_Screen  = "screen"
+	DropdownTarget_Session = "session"
+	DropdownTarget_None    = "none"
+)
+
+// /client:setdropdown [screen|session|none]
+// designates the current screen (default) or session as the dropdown window's target
+func ClientSetDropdownCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	target := DropdownTarget_Screen
+	if len(pk.Args) > 0 {
+		target = pk.Args[0]
+	}
+	var sessionId, screenId, infoMsg string
+	switch target {
+	case DropdownTarget_Screen, DropdownTarget_Session:
+		ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+		if err != nil {
+			return nil, err
+		}
+		sessionId = ids.SessionId
+		if target == DropdownTarget_Screen {
+			screenId = ids.ScreenId
+			infoMsg = "current tab set as the dropdown"
+		} else {
+			infoMsg = "current workspace set as the dropdown"
+		}
+	case DropdownTarget_None:
+		infoMsg = "dropdown cleared"
+	default:
+		return nil, fmt.Errorf("usage: /client:setdropdown [%s|%s|%s]", DropdownTarget_Screen, DropdownTarget_Session, DropdownTarget_None)
+	}
+	clientData, err := sstore.SetDropdownTarget(ctx, sessionId, screenId)
+	if err != nil {
+		return nil, fmt.Errorf("error updating client data: %v", err)
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(*clientData)
+	update.AddUpdate(sstore.InfoMsgType{InfoMsg: infoMsg, TimeoutMs: 2000})
+	return update, nil
+}
+
 func ClientSetMainSidebarCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
 	clientData, err := sstore.EnsureClientData(ctx)
 	if err != nil {
@@ -6048,6 +7445,301 @@ func ClientSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sc
 		}
 		varsUpdated = append(varsUpdated, "webgl")
 	}
+	if archiveDaysStr, found := pk.Kwargs["ptyarchivedays"]; found {
+		archiveDays, err := resolveNonNegInt(archiveDaysStr, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ptyarchivedays, must be a number of days (0 to disable): %v", err)
+		}
+		clientOpts := clientData.ClientOpts
+		clientOpts.PtyArchiveDays = archiveDays
+		err = sstore.SetClientOpts(ctx, clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error updating client ptyarchivedays: %v", err)
+		}
+		varsUpdated = append(varsUpdated, "ptyarchivedays")
+	}
+	if notifySecsStr, found := pk.Kwargs["cmddonenotifysecs"]; found {
+		notifySecs, err := resolveNonNegInt(notifySecsStr, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cmddonenotifysecs, must be a number of seconds (0 to disable): %v", err)
+		}
+		clientOpts := clientData.ClientOpts
+		clientOpts.CmdDoneNotifySecs = notifySecs
+		err = sstore.SetClientOpts(ctx, clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error updating client cmddonenotifysecs: %v", err)
+		}
+		varsUpdated = append(varsUpdated, "cmddonenotifysecs")
+	}
+	if maxStateSizeStr, found := pk.Kwargs["maxlinestatesize"]; found {
+		maxStateSize, err := resolveNonNegInt(maxStateSizeStr, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maxlinestatesize, must be a number of bytes (0 for default): %v", err)
+		}
+		if maxStateSize > sstore.MaxLineStateOverflowSizeLimit {
+			return nil, fmt.Errorf("invalid maxlinestatesize, max value is %d", sstore.MaxLineStateOverflowSizeLimit)
+		}
+		clientOpts := clientData.ClientOpts
+		clientOpts.MaxLineStateSize = maxStateSize
+		err = sstore.SetClientOpts(ctx, clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error updating client maxlinestatesize: %v", err)
+		}
+		varsUpdated = append(varsUpdated, "maxlinestatesize")
+	}
+	if tz, found := pk.Kwargs["timezone"]; found {
+		err = sstore.ValidateTimezone(tz)
+		if err != nil {
+			return nil, err
+		}
+		clientOpts := clientData.ClientOpts
+		clientOpts.Timezone = tz
+		err = sstore.SetClientOpts(ctx, clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error updating client timezone: %v", err)
+		}
+		varsUpdated = append(varsUpdated, "timezone")
+	}
+	if locale, found := pk.Kwargs["locale"]; found {
+		err = sstore.ValidateLocale(locale)
+		if err != nil {
+			return nil, err
+		}
+		clientOpts := clientData.ClientOpts
+		clientOpts.Locale = locale
+		err = sstore.SetClientOpts(ctx, clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error updating client locale: %v", err)
+		}
+		varsUpdated = append(varsUpdated, "locale")
+	}
+	_, urlFound := pk.Kwargs["webshareurl"]
+	_, tokenFound := pk.Kwargs["websharetoken"]
+	if urlFound || tokenFound {
+		numShared, err := sstore.CountScreenWebShares(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get webshare count: %v", err)
+		}
+		if numShared > 0 {
+			return nil, fmt.Errorf("cannot change the share server while screens are web-shared (%d shared screen(s))", numShared)
+		}
+		clientOpts := clientData.ClientOpts
+		if urlFound {
+			shareUrl, err := pcloud.ValidateWebShareUrl(pk.Kwargs["webshareurl"])
+			if err != nil {
+				return nil, err
+			}
+			clientOpts.WebShareUrl = shareUrl
+			varsUpdated = append(varsUpdated, "webshareurl")
+		}
+		if tokenFound {
+			clientOpts.WebShareToken = pk.Kwargs["websharetoken"]
+			varsUpdated = append(varsUpdated, "websharetoken")
+		}
+		err = sstore.SetClientOpts(ctx, clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error updating client webshare options: %v", err)
+		}
+	}
+	demoModeChanged := false
+	if demoModeStr, found := pk.Kwargs["demomode"]; found {
+		clientOpts := clientData.ClientOpts
+		clientOpts.DemoMode = resolveBool(demoModeStr, false)
+		err = sstore.SetClientOpts(ctx, clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error updating client demomode: %v", err)
+		}
+		demoModeChanged = clientOpts.DemoMode != clientData.ClientOpts.DemoMode
+		varsUpdated = append(varsUpdated, "demomode")
+	}
+	if aiExplainStr, found := pk.Kwargs["aiexplainerrors"]; found {
+		clientOpts := clientData.ClientOpts
+		clientOpts.AIExplainErrors = resolveBool(aiExplainStr, false)
+		err = sstore.SetClientOpts(ctx, clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error updating client aiexplainerrors: %v", err)
+		}
+		varsUpdated = append(varsUpdated, "aiexplainerrors")
+	}
+	_, flushMsFound := pk.Kwargs["blockflushms"]
+	_, dirtyKBFound := pk.Kwargs["blockdirtykb"]
+	_, blockSyncFound := pk.Kwargs["blocksync"]
+	if flushMsFound || dirtyKBFound || blockSyncFound {
+		clientOpts := clientData.ClientOpts
+		if flushMsFound {
+			flushMs, err := resolveNonNegInt(pk.Kwargs["blockflushms"], 0)
+			if err != nil {
+				return nil, fmt.Errorf("invalid blockflushms, must be a number of milliseconds (0 for default): %v", err)
+			}
+			if flushMs != 0 && (flushMs < sstore.MinBlockFlushMs || flushMs > sstore.MaxBlockFlushMs) {
+				return nil, fmt.Errorf("invalid blockflushms, must be between %d and %d (0 for default)", sstore.MinBlockFlushMs, sstore.MaxBlockFlushMs)
+			}
+			clientOpts.BlockFlushMs = flushMs
+			varsUpdated = append(varsUpdated, "blockflushms")
+		}
+		if dirtyKBFound {
+			dirtyKB, err := resolveNonNegInt(pk.Kwargs["blockdirtykb"], 0)
+			if err != nil {
+				return nil, fmt.Errorf("invalid blockdirtykb, must be a number of KB (0 to disable): %v", err)
+			}
+			if dirtyKB > sstore.MaxBlockDirtyKB {
+				return nil, fmt.Errorf("invalid blockdirtykb, max value is %d", sstore.MaxBlockDirtyKB)
+			}
+			clientOpts.BlockDirtyKB = dirtyKB
+			varsUpdated = append(varsUpdated, "blockdirtykb")
+		}
+		if blockSyncFound {
+			blockSync := strings.ToLower(pk.Kwargs["blocksync"])
+			if blockSync != "" && blockSync != blockstore.SyncPolicy_Batch && blockSync != blockstore.SyncPolicy_Write && blockSync != blockstore.SyncPolicy_Journal {
+				return nil, fmt.Errorf("invalid blocksync, must be %q, %q or %q", blockstore.SyncPolicy_Batch, blockstore.SyncPolicy_Write, blockstore.SyncPolicy_Journal)
+			}
+			clientOpts.BlockSync = blockSync
+			varsUpdated = append(varsUpdated, "blocksync")
+		}
+		err = sstore.SetClientOpts(ctx, clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error updating client blockstore flush options: %v", err)
+		}
+	}
+	_, backupDirFound := pk.Kwargs["backupdir"]
+	_, backupHoursFound := pk.Kwargs["backuphours"]
+	_, backupKeepFound := pk.Kwargs["backupkeep"]
+	if backupDirFound || backupHoursFound || backupKeepFound {
+		clientOpts := clientData.ClientOpts
+		if backupDirFound {
+			backupDir := pk.Kwargs["backupdir"]
+			if backupDir != "" {
+				backupDir = base.ExpandHomeDir(backupDir)
+				if !filepath.IsAbs(backupDir) {
+					return nil, fmt.Errorf("invalid backupdir, must be an absolute path (empty for the default)")
+				}
+			}
+			clientOpts.BackupDir = backupDir
+			varsUpdated = append(varsUpdated, "backupdir")
+		}
+		if backupHoursFound {
+			backupHours, err := resolveNonNegInt(pk.Kwargs["backuphours"], 0)
+			if err != nil {
+				return nil, fmt.Errorf("invalid backuphours, must be a number of hours (0 to disable): %v", err)
+			}
+			clientOpts.BackupHours = backupHours
+			varsUpdated = append(varsUpdated, "backuphours")
+		}
+		if backupKeepFound {
+			backupKeep, err := resolveNonNegInt(pk.Kwargs["backupkeep"], 0)
+			if err != nil {
+				return nil, fmt.Errorf("invalid backupkeep, must be a number of backups (0 for default): %v", err)
+			}
+			clientOpts.BackupKeep = backupKeep
+			varsUpdated = append(varsUpdated, "backupkeep")
+		}
+		err = sstore.SetClientOpts(ctx, clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error updating client backup options: %v", err)
+		}
+	}
+	if trashDaysStr, found := pk.Kwargs["trashdays"]; found {
+		trashDays, err := resolveNonNegInt(trashDaysStr, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trashdays, must be a number of days (0 for default): %v", err)
+		}
+		clientOpts := clientData.ClientOpts
+		clientOpts.TrashDays = trashDays
+		err = sstore.SetClientOpts(ctx, clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error updating client trashdays: %v", err)
+		}
+		varsUpdated = append(varsUpdated, "trashdays")
+	}
+	if tmuxControlStr, found := pk.Kwargs["tmuxcontrol"]; found {
+		clientOpts := clientData.ClientOpts
+		clientOpts.TmuxControl = resolveBool(tmuxControlStr, false)
+		err = SetTmuxControl(clientOpts.TmuxControl)
+		if err != nil {
+			return nil, fmt.Errorf("error setting tmuxcontrol: %v", err)
+		}
+		err = sstore.SetClientOpts(ctx, clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error updating client tmuxcontrol: %v", err)
+		}
+		varsUpdated = append(varsUpdated, "tmuxcontrol")
+	}
+	if autoArchiveStr, found := pk.Kwargs["autoarchivedays"]; found {
+		autoArchiveDays, err := resolveNonNegInt(autoArchiveStr, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid autoarchivedays, must be a number of days (0 to disable): %v", err)
+		}
+		clientOpts := clientData.ClientOpts
+		clientOpts.AutoArchiveDays = autoArchiveDays
+		err = sstore.SetClientOpts(ctx, clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error updating client autoarchivedays: %v", err)
+		}
+		varsUpdated = append(varsUpdated, "autoarchivedays")
+	}
+	if sshAttachStr, found := pk.Kwargs["sshattach"]; found {
+		sshAttachAddr, err := resolveSshAttachAddr(sshAttachStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sshattach, %v", err)
+		}
+		clientOpts := clientData.ClientOpts
+		clientOpts.SshAttachAddr = sshAttachAddr
+		err = SetSshAttach(sshAttachAddr)
+		if err != nil {
+			return nil, fmt.Errorf("error setting sshattach: %v", err)
+		}
+		err = sstore.SetClientOpts(ctx, clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error updating client sshattach: %v", err)
+		}
+		varsUpdated = append(varsUpdated, "sshattach")
+	}
+	if metricsIntervalStr, found := pk.Kwargs["metricsinterval"]; found {
+		metricsInterval, err := resolveNonNegInt(metricsIntervalStr, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metricsinterval, must be a number of seconds (0 to disable): %v", err)
+		}
+		clientOpts := clientData.ClientOpts
+		clientOpts.MetricsInterval = metricsInterval
+		err = sstore.SetClientOpts(ctx, clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error updating client metricsinterval: %v", err)
+		}
+		remote.SetMetricsInterval(metricsInterval)
+		varsUpdated = append(varsUpdated, "metricsinterval")
+	}
+	if metricsPortStr, found := pk.Kwargs["metricsport"]; found {
+		metricsPort, err := resolveMetricsPort(metricsPortStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metricsport, must be a port number (0 to disable): %v", err)
+		}
+		clientOpts := clientData.ClientOpts
+		clientOpts.MetricsPort = metricsPort
+		err = srvmetrics.SetPort(metricsPort)
+		if err != nil {
+			return nil, fmt.Errorf("error setting metricsport: %v", err)
+		}
+		err = sstore.SetClientOpts(ctx, clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error updating client metricsport: %v", err)
+		}
+		varsUpdated = append(varsUpdated, "metricsport")
+	}
+	if specDir, found := pk.Kwargs["completionspecdir"]; found {
+		if specDir != "" {
+			specDir = base.ExpandHomeDir(specDir)
+			if !filepath.IsAbs(specDir) {
+				return nil, fmt.Errorf("invalid completionspecdir, must be an absolute path (empty for the default)")
+			}
+		}
+		clientOpts := clientData.ClientOpts
+		clientOpts.CompletionSpecDir = specDir
+		err = sstore.SetClientOpts(ctx, clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("error updating client completionspecdir: %v", err)
+		}
+		varsUpdated = append(varsUpdated, "completionspecdir")
+	}
 	if sudoPwStoreStr, found := pk.Kwargs["sudopwstore"]; found {
 		err := validateSudoPwStore(sudoPwStoreStr)
 		if err != nil {
@@ -6101,7 +7793,7 @@ func ClientSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sc
 		varsUpdated = append(varsUpdated, "sudopwclearonsleep")
 	}
 	if len(varsUpdated) == 0 {
-		return nil, fmt.Errorf("/client:set requires a value to set: %s", formatStrs([]string{"termfontsize", "termfontfamily", "openaiapitoken", "openaimodel", "openaibaseurl", "openaimaxtokens", "openaimaxchoices", "openaitimeout", "webgl"}, "or", false))
+		return nil, fmt.Errorf("/client:set requires a value to set: %s", formatStrs([]string{"termfontsize", "termfontfamily", "openaiapitoken", "openaimodel", "openaibaseurl", "openaimaxtokens", "openaimaxchoices", "openaitimeout", "webgl", "ptyarchivedays", "cmddonenotifysecs", "maxlinestatesize", "timezone", "locale", "webshareurl", "websharetoken", "demomode", "aiexplainerrors", "blockflushms", "blockdirtykb", "blocksync", "backupdir", "backuphours", "backupkeep", "trashdays", "tmuxcontrol", "autoarchivedays", "sshattach", "metricsinterval", "metricsport", "completionspecdir"}, "or", false))
 	}
 	clientData, err = sstore.EnsureClientData(ctx)
 	if err != nil {
@@ -6109,6 +7801,12 @@ func ClientSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sc
 	}
 	update := scbus.MakeUpdatePacket()
 	update.AddUpdate(*clientData)
+	if demoModeChanged {
+		err = addDemoModeRefreshUpdates(ctx, update)
+		if err != nil {
+			return nil, err
+		}
+	}
 	update.AddUpdate(sstore.InfoMsgType{
 		InfoMsg:   fmt.Sprintf("client updated %s", formatStrs(varsUpdated, "and", false)),
 		TimeoutMs: 2000,
@@ -6116,6 +7814,22 @@ func ClientSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (sc
 	return update, nil
 }
 
+// resends the sessions (remote instances) and remotes, so they are masked (or unmasked) right away when demo mode
+// is toggled.  lines that are already loaded keep their festate until the screen is reloaded.
+func addDemoModeRefreshUpdates(ctx context.Context, update *scbus.ModelUpdatePacketType) error {
+	connectUpdate, err := sstore.GetConnectUpdate(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot retrieve sessions: %v", err)
+	}
+	for _, session := range connectUpdate.Sessions {
+		update.AddUpdate(*session)
+	}
+	for _, state := range remote.GetAllRemoteRuntimeState() {
+		update.AddUpdate(*state)
+	}
+	return nil
+}
+
 func ClientShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
 	clientData, err := sstore.EnsureClientData(ctx)
 	if err != nil {
@@ -6161,12 +7875,82 @@ func ClientShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (s
 	buf.WriteString(fmt.Sprintf("  %-15s %d\n", "termfontsize", clientData.FeOpts.TermFontSize))
 	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "termfontfamily", clientData.FeOpts.TermFontFamily))
 	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "termfontfamily", clientData.FeOpts.Theme))
-	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "aiapitoken", clientData.OpenAIOpts.APIToken))
+	aiApiToken := clientData.OpenAIOpts.APIToken
+	if aiApiToken != "" && sstore.IsDemoMode() {
+		aiApiToken = sstore.DemoModeMask
+	}
+	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "aiapitoken", aiApiToken))
 	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "aimodel", aiModel))
 	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "aimaxtokens", aiMaxTokens))
 	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "aimaxchoices", aiMaxChoices))
 	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "aibaseurl", aiBaseUrl))
 	buf.WriteString(fmt.Sprintf("  %-15s %ss\n", "aitimeout", aiTimeout))
+	if clientData.ClientOpts.PtyArchiveDays > 0 {
+		buf.WriteString(fmt.Sprintf("  %-15s %d days\n", "ptyarchive", clientData.ClientOpts.PtyArchiveDays))
+	} else {
+		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "ptyarchive", "off"))
+	}
+	if clientData.ClientOpts.CmdDoneNotifySecs > 0 {
+		buf.WriteString(fmt.Sprintf("  %-15s %ds\n", "cmddonenotify", clientData.ClientOpts.CmdDoneNotifySecs))
+	} else {
+		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "cmddonenotify", "off"))
+	}
+	buf.WriteString(fmt.Sprintf("  %-15s %d\n", "maxlinestate", sstore.GetMaxLineStateSize(ctx)))
+	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "timezone", sstore.GetClientLocation(ctx).String()))
+	if clientData.ClientOpts.Locale != "" {
+		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "locale", clientData.ClientOpts.Locale))
+	}
+	if clientData.ClientOpts.WebShareUrl != "" {
+		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "webshareurl", clientData.ClientOpts.WebShareUrl))
+		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "websharetoken", boolToStr(clientData.ClientOpts.WebShareToken != "", "(set)", "(not set)")))
+	}
+	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "demomode", boolToStr(clientData.ClientOpts.DemoMode, "on", "off")))
+	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "aiexplainerrors", boolToStr(clientData.ClientOpts.AIExplainErrors, "on", "off")))
+	flushCfg := blockstore.GetFlushConfig()
+	buf.WriteString(fmt.Sprintf("  %-15s %dms\n", "blockflush", flushCfg.FlushTimeout.Milliseconds()))
+	if flushCfg.DirtyThreshold > 0 {
+		buf.WriteString(fmt.Sprintf("  %-15s %dKB\n", "blockdirty", flushCfg.DirtyThreshold/1024))
+	} else {
+		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "blockdirty", "off"))
+	}
+	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "blocksync", flushCfg.SyncPolicy))
+	if clientData.ClientOpts.BackupHours > 0 {
+		buf.WriteString(fmt.Sprintf("  %-15s every %dh, keep %d, %s\n", "backup", clientData.ClientOpts.BackupHours, sstore.GetBackupKeep(clientData.ClientOpts), sstore.GetBackupDir(clientData.ClientOpts)))
+	} else {
+		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "backup", "off"))
+	}
+	buf.WriteString(fmt.Sprintf("  %-15s %d days\n", "trash", sstore.GetTrashDays(clientData.ClientOpts)))
+	if clientData.ClientOpts.AutoArchiveDays > 0 {
+		buf.WriteString(fmt.Sprintf("  %-15s after %d idle days\n", "autoarchive", clientData.ClientOpts.AutoArchiveDays))
+	} else {
+		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "autoarchive", "off"))
+	}
+	if tmuxStatus := tmuxcc.GetStatus(); tmuxStatus.Running {
+		buf.WriteString(fmt.Sprintf("  %-15s %s (%d clients)\n", "tmuxcontrol", tmuxStatus.SocketPath, tmuxStatus.NumClients))
+	} else {
+		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "tmuxcontrol", "off"))
+	}
+	if sshStatus := sshattach.GetStatus(); sshStatus.Running {
+		buf.WriteString(fmt.Sprintf("  %-15s %s (%d connections, keys from %s)\n", "sshattach", sshStatus.Addr, sshStatus.NumClients, sshattach.GetAuthorizedKeysPath()))
+	} else {
+		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "sshattach", "off"))
+	}
+	if clientData.ClientOpts.MetricsInterval > 0 {
+		buf.WriteString(fmt.Sprintf("  %-15s every %ds\n", "metrics", clientData.ClientOpts.MetricsInterval))
+	} else {
+		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "metrics", "off"))
+	}
+	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "metricsport", formatMetricsPortStatus()))
+	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "completionspecs", newton.GetUserSpecDir()))
+	raStats := blockstore.GetReadAheadStats()
+	buf.WriteString(fmt.Sprintf("  %-15s %d hits, %d misses (%.0f%%)\n", "blockreadahead", raStats.Hits, raStats.Misses, raStats.HitRate()*100))
+	if dropdownSessionId, dropdownScreenId, _ := sstore.GetDropdownTarget(ctx); dropdownSessionId != "" {
+		if dropdownScreenId != "" {
+			buf.WriteString(fmt.Sprintf("  %-15s screen %s\n", "dropdown", dropdownScreenId))
+		} else {
+			buf.WriteString(fmt.Sprintf("  %-15s session %s\n", "dropdown", dropdownSessionId))
+		}
+	}
 	update := scbus.MakeUpdatePacket()
 	update.AddUpdate(sstore.InfoMsgType{
 		InfoTitle: fmt.Sprintf("client info"),
@@ -6455,14 +8239,6 @@ type ColMeta struct {
 	MaxCols int
 }
 
-func toInterfaceArr(sarr []string) []interface{} {
-	rtn := make([]interface{}, len(sarr))
-	for idx, s := range sarr {
-		rtn[idx] = s
-	}
-	return rtn
-}
-
 func formatTextTable(totalCols int, data [][]string, colMeta []ColMeta) []string {
 	numCols := len(colMeta)
 	maxColLen := make([]int, len(colMeta))
@@ -6471,23 +8247,24 @@ func formatTextTable(totalCols int, data [][]string, colMeta []ColMeta) []string
 	}
 	for _, row := range data {
 		for i := 0; i < numCols && i < len(row); i++ {
-			dlen := len(row[i])
+			dlen := utilfn.StringWidth(row[i])
 			if dlen > maxColLen[i] {
 				maxColLen[i] = dlen
 			}
 		}
 	}
-	fmtStr := ""
-	for idx, clen := range maxColLen {
-		if idx != 0 {
-			fmtStr += " "
-		}
-		fmtStr += fmt.Sprintf("%%%ds", clen)
-	}
+	// padded by display width (fmt widths count runes, wide chars and emoji would break the alignment)
 	var rtn []string
 	for _, row := range data {
-		sval := fmt.Sprintf(fmtStr, toInterfaceArr(row)...)
-		rtn = append(rtn, sval)
+		var buf strings.Builder
+		for i := 0; i < numCols && i < len(row); i++ {
+			if i != 0 {
+				buf.WriteByte(' ')
+			}
+			buf.WriteString(strings.Repeat(" ", max(0, maxColLen[i]-utilfn.StringWidth(row[i]))))
+			buf.WriteString(row[i])
+		}
+		rtn = append(rtn, buf.String())
 	}
 	return rtn
 }
@@ -0,0 +1,139 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// the current context for editor integrations (see sstore/currentctx.go).  a watcher follows the main update bus,
+// after an update that can change the context (active session/screen, cmds, lines, remotes) it rebuilds the
+// context and sends a "currentcontext" update to all clients if it changed.
+
+const CurrentContextDebounce = 250 * time.Millisecond
+const currentContextChannelKey = "currentcontext"
+const currentContextTimeout = 5 * time.Second
+
+// update items that can change the current context
+var currentContextItemTypes = map[string]bool{
+	"activesessionid": true,
+	"session":         true,
+	"screen":          true,
+	"line":            true,
+	"cmd":             true,
+	"remote":          true,
+}
+
+// receives all updates (not only the ones for a screen)
+type currentContextChannel struct {
+	ch chan scbus.UpdatePacket
+}
+
+func (c *currentContextChannel) GetChannel() chan scbus.UpdatePacket {
+	return c.ch
+}
+
+func (c *currentContextChannel) SetChannel(ch chan scbus.UpdatePacket) {
+	c.ch = ch
+}
+
+func (c *currentContextChannel) Match(screenId string) bool {
+	return true
+}
+
+var currentContextLock = &sync.Mutex{}
+var lastCurrentContext *sstore.CurrentContextType
+
+// returns nil (no error) if there is no active screen
+func GetCurrentContext(ctx context.Context) (*sstore.CurrentContextType, error) {
+	sessionId, err := sstore.GetActiveSessionId(ctx)
+	if err != nil || sessionId == "" {
+		return nil, err
+	}
+	session, err := sstore.GetBareSessionById(ctx, sessionId)
+	if err != nil || session == nil || session.ActiveScreenId == "" {
+		return nil, err
+	}
+	screen, err := sstore.GetScreenById(ctx, session.ActiveScreenId)
+	if err != nil || screen == nil {
+		return nil, err
+	}
+	rtn := &sstore.CurrentContextType{
+		SessionId:   session.SessionId,
+		SessionName: session.Name,
+		ScreenId:    screen.ScreenId,
+		ScreenName:  screen.Name,
+		Remote:      screen.CurRemote,
+	}
+	rr, err := ResolveRemoteFromPtr(ctx, &screen.CurRemote, session.SessionId, screen.ScreenId)
+	if err != nil {
+		// the remote was deleted, the rest of the context is still valid
+		log.Printf("[currentcontext] cannot resolve remote: %v\n", err)
+	} else if rr != nil {
+		rtn.RemoteName = rr.DisplayName
+		rtn.Cwd = rr.FeState["cwd"]
+		rtn.GitBranch = rr.FeState["PROMPTVAR_GITBRANCH"]
+	}
+	rtn.LastCmd, err = sstore.GetLastScreenCmd(ctx, screen.ScreenId)
+	if err != nil {
+		return nil, err
+	}
+	return rtn, nil
+}
+
+func isCurrentContextUpdate(update scbus.UpdatePacket) bool {
+	upk, ok := update.(*scbus.ModelUpdatePacketType)
+	if !ok || upk.IsEmpty() {
+		return false
+	}
+	for _, item := range *upk.Data {
+		if currentContextItemTypes[item.GetType()] {
+			return true
+		}
+	}
+	return false
+}
+
+func sendCurrentContextIfChanged() {
+	ctx, cancelFn := context.WithTimeout(context.Background(), currentContextTimeout)
+	defer cancelFn()
+	curCtx, err := GetCurrentContext(ctx)
+	if err != nil {
+		log.Printf("[currentcontext] error getting current context: %v\n", err)
+		return
+	}
+	currentContextLock.Lock()
+	changed := !reflect.DeepEqual(curCtx, lastCurrentContext)
+	lastCurrentContext = curCtx
+	currentContextLock.Unlock()
+	if !changed || curCtx == nil {
+		return
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(*curCtx)
+	scbus.MainUpdateBus.DoUpdate(update)
+}
+
+// runs forever (started from main)
+func RunCurrentContextWatcher() {
+	updateCh := scbus.MainUpdateBus.RegisterChannel(currentContextChannelKey, &currentContextChannel{})
+	var timer *time.Timer
+	for update := range updateCh {
+		if !isCurrentContextUpdate(update) {
+			continue
+		}
+		if timer == nil {
+			timer = time.AfterFunc(CurrentContextDebounce, sendCurrentContextIfChanged)
+		} else {
+			timer.Reset(CurrentContextDebounce)
+		}
+	}
+}
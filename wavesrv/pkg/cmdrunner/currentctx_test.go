@@ -0,0 +1,29 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"testing"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func TestIsCurrentContextUpdate(t *testing.T) {
+	update := scbus.MakeUpdatePacket()
+	if isCurrentContextUpdate(update) {
+		t.Errorf("empty update should not change the context")
+	}
+	update.AddUpdate(sstore.InfoMsgType{InfoMsg: "hello"}, sstore.CurrentContextType{ScreenId: "s1"})
+	if isCurrentContextUpdate(update) {
+		t.Errorf("info/currentcontext update should not change the context")
+	}
+	update.AddUpdate(sstore.ActiveSessionIdUpdate("session1"))
+	if !isCurrentContextUpdate(update) {
+		t.Errorf("activesessionid update should change the context")
+	}
+	if isCurrentContextUpdate(&scbus.PtyDataUpdatePacketType{}) {
+		t.Errorf("pty data should not change the context")
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// directory bookmarks (see sstore/dirbookmarks.go).  the bookmarks are per remote, the commands use the screen's
+// current remote (or remote=...).  /dirbookmark:go (JumpToDirBookmark) runs a cd to the bookmarked directory in
+// the screen, as if it was typed.
+
+func makeDirBookmarksUpdate(ctx context.Context, remoteId string, infoFmt string, args ...interface{}) (scbus.UpdatePacket, error) {
+	bmUpdate, err := sstore.MakeDirBookmarksUpdate(ctx, remoteId)
+	if err != nil {
+		return nil, err
+	}
+	update := sstore.InfoMsgUpdate(infoFmt, args...)
+	update.AddUpdate(*bmUpdate)
+	return update, nil
+}
+
+// finds the bookmark by name (or bookmarkid).  if there is no exact match, a search that matches exactly one
+// bookmark is also accepted (so quick-jumps can use part of the name).
+func findDirBookmark(ctx context.Context, remoteId string, arg string) (*sstore.DirBookmarkType, error) {
+	bm, err := sstore.GetDirBookmarkByArg(ctx, remoteId, arg)
+	if err != nil {
+		return nil, err
+	}
+	if bm != nil {
+		return bm, nil
+	}
+	bms, err := sstore.GetDirBookmarks(ctx, remoteId, arg)
+	if err != nil {
+		return nil, err
+	}
+	if len(bms) == 0 {
+		return nil, fmt.Errorf("directory bookmark %q not found", arg)
+	}
+	if len(bms) > 1 {
+		var names []string
+		for _, bm := range bms {
+			names = append(names, bm.Name)
+		}
+		return nil, fmt.Errorf("%q matches multiple directory bookmarks: %s", arg, formatStrs(names, "and", false))
+	}
+	return bms[0], nil
+}
+
+// runs a cd to the bookmark's directory in the screen (which must be on the bookmark's remote), returns the
+// lineid of the cd command
+func JumpToDirBookmark(ctx context.Context, screenId string, bm *sstore.DirBookmarkType) (string, error) {
+	screen, err := sstore.GetScreenById(ctx, screenId)
+	if err != nil {
+		return "", err
+	}
+	if screen == nil {
+		return "", fmt.Errorf("screen not found")
+	}
+	if screen.CurRemote.RemoteId != bm.RemoteId {
+		return "", fmt.Errorf("directory bookmark %q is for a different remote", bm.Name)
+	}
+	lineId, err := EvalScreenCommand(ctx, screenId, makeCdCmd(bm.Cwd))
+	if err != nil {
+		return "", err
+	}
+	err = sstore.MarkDirBookmarkUsed(ctx, bm.BookmarkId)
+	if err != nil {
+		return "", err
+	}
+	return lineId, nil
+}
+
+// /dirbookmark:add [name] [dir], dir defaults to the current directory
+func DirBookmarkAddCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) == 0 || len(pk.Args) > 2 {
+		return nil, fmt.Errorf("usage: /dirbookmark:add [name] [dir]")
+	}
+	name := pk.Args[0]
+	err = validateName(name, "bookmark")
+	if err != nil {
+		return nil, fmt.Errorf("/dirbookmark:add %v", err)
+	}
+	cwd := ids.Remote.FeState["cwd"]
+	if len(pk.Args) > 1 {
+		cwd = pk.Args[1]
+	}
+	if cwd == "" {
+		return nil, fmt.Errorf("/dirbookmark:add current directory is not known, pass the directory to bookmark")
+	}
+	now := time.Now().UnixMilli()
+	bm := &sstore.DirBookmarkType{
+		BookmarkId: scbase.GenWaveUUID(),
+		RemoteId:   ids.Remote.RemotePtr.RemoteId,
+		Name:       name,
+		Cwd:        cwd,
+		CreatedTs:  now,
+		LastUsedTs: now,
+	}
+	err = sstore.SaveDirBookmark(ctx, bm)
+	if err != nil {
+		return nil, fmt.Errorf("/dirbookmark:add error saving bookmark: %v", err)
+	}
+	return makeDirBookmarksUpdate(ctx, bm.RemoteId, "directory bookmark %q saved (%s), jump to it with /dirbookmark:go %s", name, cwd, name)
+}
+
+// /dirbookmark:show [search]
+func DirBookmarkShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, err
+	}
+	remoteId := ids.Remote.RemotePtr.RemoteId
+	bms, err := sstore.GetDirBookmarks(ctx, remoteId, firstArg(pk))
+	if err != nil {
+		return nil, fmt.Errorf("/dirbookmark:show error getting bookmarks: %v", err)
+	}
+	if len(bms) == 0 {
+		if firstArg(pk) != "" {
+			return sstore.InfoMsgUpdate("no directory bookmarks on %s match %q", ids.Remote.DisplayName, firstArg(pk)), nil
+		}
+		return sstore.InfoMsgUpdate("no directory bookmarks on %s, bookmark the current directory with /dirbookmark:add [name]", ids.Remote.DisplayName), nil
+	}
+	var buf bytes.Buffer
+	for _, bm := range bms {
+		buf.WriteString(fmt.Sprintf("  %-20s %s\n", bm.Name, bm.Cwd))
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{InfoTitle: fmt.Sprintf("directory bookmarks on %s", ids.Remote.DisplayName), InfoLines: splitLinesForInfo(buf.String())})
+	if firstArg(pk) == "" {
+		update.AddUpdate(sstore.DirBookmarksUpdateType{RemoteId: remoteId, Bookmarks: bms})
+	}
+	return update, nil
+}
+
+func DirBookmarkDeleteCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) != 1 {
+		return nil, fmt.Errorf("usage: /dirbookmark:delete [name]")
+	}
+	remoteId := ids.Remote.RemotePtr.RemoteId
+	bm, err := sstore.GetDirBookmarkByArg(ctx, remoteId, pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("/dirbookmark:delete error getting bookmark: %v", err)
+	}
+	if bm == nil {
+		return nil, fmt.Errorf("/dirbookmark:delete directory bookmark %q not found", pk.Args[0])
+	}
+	err = sstore.DeleteDirBookmark(ctx, bm.BookmarkId)
+	if err != nil {
+		return nil, fmt.Errorf("/dirbookmark:delete error deleting bookmark: %v", err)
+	}
+	return makeDirBookmarksUpdate(ctx, remoteId, "directory bookmark %q deleted", bm.Name)
+}
+
+// /dirbookmark:go [name]
+func DirBookmarkGoCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_RemoteConnected)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) != 1 {
+		return nil, fmt.Errorf("usage: /dirbookmark:go [name]")
+	}
+	remoteId := ids.Remote.RemotePtr.RemoteId
+	bm, err := findDirBookmark(ctx, remoteId, pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("/dirbookmark:go %v", err)
+	}
+	_, err = JumpToDirBookmark(ctx, ids.ScreenId, bm)
+	if err != nil {
+		return nil, fmt.Errorf("/dirbookmark:go %v", err)
+	}
+	bmUpdate, err := sstore.MakeDirBookmarksUpdate(ctx, remoteId)
+	if err != nil {
+		return nil, err
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(*bmUpdate)
+	return update, nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+const DirSyncUsageStr = "usage: /dirsync [remote]:srcdir [remote]:dstdir [dryrun=1] [checksum=1] [exclude=pattern,...]"
+
+// resolves a [remote]:path argument (no remote means the current remote), relative paths are resolved
+// against the current cwd when using the current (or local) remote
+func resolveSyncDirParam(ctx context.Context, pk *scpacket.FeCommandPacketType, ids resolvedIds, info string) (*ResolvedRemote, string, error) {
+	remoteName, dirPath, err := parseCopyFileParam(info)
+	if err != nil || dirPath == "" {
+		return nil, "", fmt.Errorf("malformed argument %q, %s", info, DirSyncUsageStr)
+	}
+	rremote := ids.Remote
+	useCwd := true
+	if remoteName != "" && remoteName != ConnectedRemote {
+		kwargs := map[string]string{"remote": remoteName}
+		remotePk := &scpacket.FeCommandPacketType{MetaCmd: pk.MetaCmd, Kwargs: kwargs, UIContext: pk.UIContext}
+		remoteIds, err := resolveUiIds(ctx, remotePk, R_RemoteConnected)
+		if err != nil {
+			return nil, "", fmt.Errorf("error resolving remote %q: %v", remoteName, err)
+		}
+		rremote = remoteIds.Remote
+		useCwd = (remoteName == LocalRemote && ids.Remote.RemoteCopy.IsLocal())
+	}
+	if rremote.Waveshell == nil {
+		return nil, "", fmt.Errorf("cannot get waveshell for remote %q", remoteName)
+	}
+	fullPath, err := rremote.Waveshell.GetRemoteRuntimeState().ExpandHomeDir(dirPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("expand home dir err: %v", err)
+	}
+	if useCwd && !filepath.IsAbs(fullPath) && ids.Remote.FeState != nil && ids.Remote.FeState["cwd"] != "" {
+		fullPath = filepath.Join(ids.Remote.FeState["cwd"], fullPath)
+	}
+	if !filepath.IsAbs(fullPath) {
+		return nil, "", fmt.Errorf("cannot resolve relative path %q on remote %q, use an absolute path", dirPath, rremote.DisplayName)
+	}
+	return rremote, filepath.Clean(fullPath), nil
+}
+
+func resolveSyncExclude(arg string) []string {
+	var rtn []string
+	for _, pattern := range strings.Split(arg, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern != "" {
+			rtn = append(rtn, pattern)
+		}
+	}
+	return rtn
+}
+
+func DirSyncCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if len(pk.Args) != 2 {
+		return nil, fmt.Errorf(DirSyncUsageStr)
+	}
+	ids, err := resolveUiIds(ctx, pk, R_Screen|R_Session|R_RemoteConnected)
+	if err != nil {
+		return nil, fmt.Errorf("/dirsync cannot resolve current remote: %v", err)
+	}
+	srcRemote, srcDir, err := resolveSyncDirParam(ctx, pk, ids, pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("/dirsync source: %v", err)
+	}
+	dstRemote, dstDir, err := resolveSyncDirParam(ctx, pk, ids, pk.Args[1])
+	if err != nil {
+		return nil, fmt.Errorf("/dirsync destination: %v", err)
+	}
+	if srcRemote.RemotePtr.RemoteId == dstRemote.RemotePtr.RemoteId && srcDir == dstDir {
+		return nil, fmt.Errorf("/dirsync source and destination are the same directory")
+	}
+	opts := remote.SyncOpts{
+		DryRun:   resolveBool(pk.Kwargs["dryrun"], false),
+		Exclude:  resolveSyncExclude(pk.Kwargs["exclude"]),
+		Checksum: resolveBool(pk.Kwargs["checksum"], false),
+	}
+	termOpts, err := GetUITermOpts(pk.UIContext.WinSize, DefaultPTERM)
+	if err != nil {
+		return nil, fmt.Errorf("cannot make termopts: %w", err)
+	}
+	pkTermOpts := convertTermOpts(termOpts)
+	cmd, err := makeDynCmd(ctx, "dirsync", ids, pk.GetRawStr(), *pkTermOpts, nil)
+	if err != nil {
+		return nil, err
+	}
+	var outputPos int64
+	modeStr := ""
+	if opts.DryRun {
+		modeStr = " (dry run)"
+	}
+	writeStringToPty(ctx, cmd, fmt.Sprintf("Syncing [%v]:%v to [%v]:%v%s\r\n", srcRemote.DisplayName, srcDir, dstRemote.DisplayName, dstDir, modeStr), &outputPos)
+	update, err := addLineForCmd(ctx, "/dirsync", false, ids, cmd, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	update.AddUpdate(sstore.InteractiveUpdate(pk.Interactive))
+	scbus.MainUpdateBus.DoScreenUpdate(cmd.ScreenId, update)
+	go doSyncDirs(context.Background(), cmd, srcRemote.Waveshell, srcDir, dstRemote.Waveshell, dstDir, opts, outputPos)
+	return scbus.MakeUpdatePacket(), nil
+}
+
+func doSyncDirs(ctx context.Context, cmd *sstore.CmdType, srcWsh *remote.WaveshellProc, srcDir string, dstWsh *remote.WaveshellProc, dstDir string, opts remote.SyncOpts, outputPos int64) {
+	var exitSuccess bool
+	startTime := time.Now()
+	defer func() {
+		deferWriteCmdStatus(ctx, cmd, startTime, exitSuccess, outputPos)
+	}()
+	opts.ProgressFn = func(action *remote.SyncAction, actionNum int, numActions int) {
+		writeStringToPty(ctx, cmd, fmt.Sprintf("[%d/%d] %s (%s)\r\n", actionNum+1, numActions, action.Path, prettyPrintByteSize(action.Size)), &outputPos)
+	}
+	report, err := remote.SyncDirs(ctx, srcWsh, srcDir, dstWsh, dstDir, opts)
+	if err != nil {
+		writeStringToPty(ctx, cmd, fmt.Sprintf("Error syncing directories: %v\r\n", err), &outputPos)
+		return
+	}
+	if opts.DryRun {
+		for _, action := range report.Actions {
+			line := fmt.Sprintf("  %-8s %10s  %s", action.Reason, prettyPrintByteSize(action.Size), action.Path)
+			if action.Error != "" {
+				line += fmt.Sprintf("  (error: %s)", action.Error)
+			}
+			writeStringToPty(ctx, cmd, line+"\r\n", &outputPos)
+		}
+		writeStringToPty(ctx, cmd, fmt.Sprintf("Compared %d files, %d would be copied (%s)\r\n", report.NumFiles, len(report.Actions), prettyPrintByteSize(report.TotalBytes())), &outputPos)
+		exitSuccess = true
+		return
+	}
+	for _, action := range report.Actions {
+		if action.Error != "" {
+			writeStringToPty(ctx, cmd, fmt.Sprintf("Error copying %s: %s\r\n", action.Path, action.Error), &outputPos)
+		}
+	}
+	writeStringToPty(ctx, cmd, fmt.Sprintf("Compared %d files, copied %d (%s)", report.NumFiles, report.NumCopied, prettyPrintByteSize(report.BytesCopied)), &outputPos)
+	if report.NumErrors > 0 {
+		writeStringToPty(ctx, cmd, fmt.Sprintf(", %d errors", report.NumErrors), &outputPos)
+	}
+	writeStringToPty(ctx, cmd, "\r\n", &outputPos)
+	exitSuccess = (report.NumErrors == 0)
+}
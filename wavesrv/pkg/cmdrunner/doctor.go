@@ -0,0 +1,152 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func sortedRemoteRuntimeStates() []remote.RemoteRuntimeState {
+	var rtn []remote.RemoteRuntimeState
+	for _, wsh := range remote.GetRemoteMap() {
+		state := wsh.GetRemoteRuntimeState()
+		if !state.Archived {
+			rtn = append(rtn, state)
+		}
+	}
+	sort.Slice(rtn, func(i, j int) bool {
+		return rtn[i].RemoteIdx < rtn[j].RemoteIdx
+	})
+	return rtn
+}
+
+func getRemoteStateName(state remote.RemoteRuntimeState) string {
+	if state.RemoteAlias != "" {
+		return state.RemoteAlias
+	}
+	return state.RemoteCanonicalName
+}
+
+// only remotes that tried to connect are checked (a disconnected remote was never reached or was disconnected on purpose)
+func doctorCheckRemotes(states []remote.RemoteRuntimeState) *sstore.DoctorCheckType {
+	check := &sstore.DoctorCheckType{Name: "remotes", Status: sstore.DoctorStatus_Ok}
+	var numErrors int
+	for _, state := range states {
+		if state.Status != remote.StatusError {
+			continue
+		}
+		numErrors++
+		check.AddDetail("%s: %s", getRemoteStateName(state), state.ErrorStr)
+	}
+	check.Message = fmt.Sprintf("%d remotes, none unreachable", len(states))
+	if numErrors > 0 {
+		check.Status = sstore.DoctorStatus_Error
+		check.Message = fmt.Sprintf("%d of %d remotes are unreachable", numErrors, len(states))
+	}
+	return check
+}
+
+func doctorCheckWaveshellVersions(states []remote.RemoteRuntimeState) *sstore.DoctorCheckType {
+	check := &sstore.DoctorCheckType{Name: "waveshell-versions", Status: sstore.DoctorStatus_Ok}
+	var numUpgrade int
+	for _, state := range states {
+		if !state.NeedsWaveshellUpgrade {
+			continue
+		}
+		numUpgrade++
+		version := state.WaveshellVersion
+		if version == "" {
+			version = "not installed"
+		}
+		check.AddDetail("%s: %s (run /remote:install)", getRemoteStateName(state), version)
+	}
+	check.Message = fmt.Sprintf("connected remotes are running waveshell %s", scbase.WaveshellVersion)
+	if numUpgrade > 0 {
+		check.Status = sstore.DoctorStatus_Warn
+		check.Message = fmt.Sprintf("%d remotes need a waveshell upgrade to %s", numUpgrade, scbase.WaveshellVersion)
+	}
+	return check
+}
+
+// runs all the checks, with fix the safe fixes are applied (removed remote instances are sent to the frontend)
+func RunDoctor(ctx context.Context, fix bool) *sstore.DoctorReportType {
+	report := &sstore.DoctorReportType{Ts: time.Now().UnixMilli(), Fix: fix}
+	report.Checks = append(report.Checks, sstore.DoctorCheckDBIntegrity(ctx))
+	report.Checks = append(report.Checks, sstore.DoctorCheckMigrations(ctx))
+	report.Checks = append(report.Checks, sstore.DoctorCheckScreenDirs(ctx, fix))
+	report.Checks = append(report.Checks, sstore.DoctorCheckDanglingCmds(ctx, fix))
+	stateCheck, removedRIs := sstore.DoctorCheckStateChains(ctx, fix)
+	report.Checks = append(report.Checks, stateCheck)
+	remoteStates := sortedRemoteRuntimeStates()
+	report.Checks = append(report.Checks, doctorCheckRemotes(remoteStates))
+	report.Checks = append(report.Checks, doctorCheckWaveshellVersions(remoteStates))
+	if len(removedRIs) > 0 {
+		sessionUpdates := make(map[string]*sstore.SessionType)
+		for _, ri := range removedRIs {
+			if sessionUpdates[ri.SessionId] == nil {
+				sessionUpdates[ri.SessionId] = &sstore.SessionType{SessionId: ri.SessionId}
+			}
+			sessionUpdates[ri.SessionId].Remotes = append(sessionUpdates[ri.SessionId].Remotes, ri)
+		}
+		update := scbus.MakeUpdatePacket()
+		for _, sessionUpdate := range sessionUpdates {
+			update.AddUpdate(*sessionUpdate)
+		}
+		scbus.MainUpdateBus.DoUpdate(update)
+	}
+	return report
+}
+
+func formatDoctorReport(report *sstore.DoctorReportType) string {
+	var buf bytes.Buffer
+	for _, check := range report.Checks {
+		status := check.Status
+		if check.Fixed {
+			status = "fixed"
+		}
+		buf.WriteString(fmt.Sprintf("  %-6s %-20s %s\n", status, check.Name, check.Message))
+		for _, detail := range check.Details {
+			buf.WriteString(fmt.Sprintf("           %s\n", detail))
+		}
+	}
+	buf.WriteString("\n")
+	numProblems := report.NumProblems()
+	if numProblems == 0 {
+		buf.WriteString("no problems found\n")
+	} else {
+		buf.WriteString(fmt.Sprintf("%d problems found\n", numProblems))
+	}
+	if numFixable := report.NumFixable(); numFixable > 0 {
+		buf.WriteString(fmt.Sprintf("%d can be fixed automatically, run /client:doctor fix=1\n", numFixable))
+	}
+	return buf.String()
+}
+
+// /client:doctor [fix=1]
+func ClientDoctorCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	fix := resolveBool(pk.Kwargs["fix"], false)
+	if fix {
+		err := scbase.CheckReadOnly("/client:doctor fix=1")
+		if err != nil {
+			return nil, err
+		}
+	}
+	report := RunDoctor(ctx, fix)
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: "wave doctor",
+		InfoLines: splitLinesForInfo(formatDoctorReport(report)),
+	})
+	return update, nil
+}
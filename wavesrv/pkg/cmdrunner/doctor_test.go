@@ -0,0 +1,55 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func TestDoctorRemoteChecks(t *testing.T) {
+	states := []remote.RemoteRuntimeState{
+		{RemoteCanonicalName: "local", Status: remote.StatusConnected},
+		{RemoteCanonicalName: "mike@host", RemoteAlias: "dev", Status: remote.StatusError, ErrorStr: "connection refused"},
+		{RemoteCanonicalName: "mike@other", Status: remote.StatusConnected, NeedsWaveshellUpgrade: true, WaveshellVersion: "v0.5.0"},
+	}
+	check := doctorCheckRemotes(states)
+	if check.Status != sstore.DoctorStatus_Error || len(check.Details) != 1 || !strings.HasPrefix(check.Details[0], "dev:") {
+		t.Errorf("invalid remotes check: %#v", check)
+	}
+	check = doctorCheckWaveshellVersions(states)
+	if check.Status != sstore.DoctorStatus_Warn || len(check.Details) != 1 || !strings.HasPrefix(check.Details[0], "mike@other: v0.5.0") {
+		t.Errorf("invalid waveshell-versions check: %#v", check)
+	}
+	check = doctorCheckRemotes(states[0:1])
+	if !check.IsOk() {
+		t.Errorf("expected ok remotes check: %#v", check)
+	}
+}
+
+func TestDoctorReport(t *testing.T) {
+	report := &sstore.DoctorReportType{Checks: []*sstore.DoctorCheckType{
+		{Name: "db-integrity", Status: sstore.DoctorStatus_Ok},
+		{Name: "screen-dirs", Status: sstore.DoctorStatus_Warn, Fixable: true},
+		{Name: "dangling-cmds", Status: sstore.DoctorStatus_Warn, Fixable: true, Fixed: true},
+		{Name: "remotes", Status: sstore.DoctorStatus_Error},
+	}}
+	if report.NumProblems() != 2 || report.NumFixable() != 1 {
+		t.Errorf("invalid report counts problems:%d fixable:%d", report.NumProblems(), report.NumFixable())
+	}
+	output := formatDoctorReport(report)
+	if !strings.Contains(output, "2 problems found") || !strings.Contains(output, "fixed  dangling-cmds") {
+		t.Errorf("invalid report output:\n%s", output)
+	}
+	check := &sstore.DoctorCheckType{}
+	for i := 0; i < sstore.DoctorMaxDetails+5; i++ {
+		check.AddDetail("detail %d", i)
+	}
+	if len(check.Details) != sstore.DoctorMaxDetails+1 || check.Details[sstore.DoctorMaxDetails] != "..." {
+		t.Errorf("details not truncated: %v", check.Details)
+	}
+}
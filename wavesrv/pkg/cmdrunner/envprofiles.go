@@ -0,0 +1,260 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/utilfn"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// env profiles (see sstore/envprofiles.go and remote/envprofiles.go).  all of the /envprofile commands that
+// change profiles or attachments return an envprofiles update with the full list of profiles and attachments.
+
+// returns an update with the env profiles update and an info message
+func makeEnvProfilesUpdate(ctx context.Context, infoFmt string, args ...interface{}) (scbus.UpdatePacket, error) {
+	envUpdate, err := sstore.MakeEnvProfilesUpdate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	update := sstore.InfoMsgUpdate(infoFmt, args...)
+	update.AddUpdate(*envUpdate)
+	return update, nil
+}
+
+func resolveEnvProfile(ctx context.Context, pk *scpacket.FeCommandPacketType) (*sstore.EnvProfileType, error) {
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf("%s requires an env profile name", GetCmdStr(pk))
+	}
+	profile, err := sstore.GetEnvProfileByArg(ctx, pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("%s error getting env profile: %v", GetCmdStr(pk), err)
+	}
+	if profile == nil {
+		return nil, fmt.Errorf("%s env profile %q not found", GetCmdStr(pk), pk.Args[0])
+	}
+	return profile, nil
+}
+
+// returns the existing profile, or a new (unsaved) profile if it does not exist
+func getOrMakeEnvProfile(ctx context.Context, pk *scpacket.FeCommandPacketType) (*sstore.EnvProfileType, error) {
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf("%s requires an env profile name", GetCmdStr(pk))
+	}
+	name := pk.Args[0]
+	profile, err := sstore.GetEnvProfileByArg(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("%s error getting env profile: %v", GetCmdStr(pk), err)
+	}
+	if profile != nil {
+		return profile, nil
+	}
+	err = validateName(name, "env profile")
+	if err != nil {
+		return nil, fmt.Errorf("%s %v", GetCmdStr(pk), err)
+	}
+	return &sstore.EnvProfileType{ProfileId: scbase.GenWaveUUID(), Name: name, CreatedTs: time.Now().UnixMilli()}, nil
+}
+
+// returns the attach target for the "to" kwarg ("screen", the default, or "remote" for the screen's current
+// remote instance)
+func resolveEnvAttachTarget(ctx context.Context, pk *scpacket.FeCommandPacketType) (string, string, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return "", "", err
+	}
+	toArg := defaultStr(pk.Kwargs["to"], sstore.EnvAttach_Screen)
+	if toArg == sstore.EnvAttach_Screen {
+		return sstore.EnvAttach_Screen, ids.ScreenId, nil
+	}
+	if toArg != "remote" {
+		return "", "", fmt.Errorf("%s invalid 'to' value %q (must be 'screen' or 'remote')", GetCmdStr(pk), toArg)
+	}
+	screen, err := sstore.GetScreenById(ctx, ids.ScreenId)
+	if err != nil {
+		return "", "", fmt.Errorf("%s error getting screen: %v", GetCmdStr(pk), err)
+	}
+	ri, err := sstore.GetRemoteInstance(ctx, ids.SessionId, ids.ScreenId, screen.CurRemote)
+	if err != nil {
+		return "", "", fmt.Errorf("%s error getting remote instance: %v", GetCmdStr(pk), err)
+	}
+	if ri == nil {
+		return "", "", fmt.Errorf("%s the current remote has no state on this screen yet (run a command first)", GetCmdStr(pk))
+	}
+	return sstore.EnvAttach_RI, ri.RIId, nil
+}
+
+// /envprofile:set [name] KEY=VALUE..., creates the profile if it does not exist
+func EnvProfileSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if len(pk.Args) != 1 || len(pk.Kwargs) == 0 {
+		return nil, fmt.Errorf("usage: /envprofile:set [name] KEY=VALUE...")
+	}
+	profile, err := getOrMakeEnvProfile(ctx, pk)
+	if err != nil {
+		return nil, err
+	}
+	var varNames []string
+	for name, val := range pk.Kwargs {
+		envVar := sstore.EnvVarType{Name: name, Value: val}
+		err = sstore.ValidateEnvVar(envVar)
+		if err != nil {
+			return nil, fmt.Errorf("/envprofile:set %v", err)
+		}
+		profile.SetVar(envVar)
+		varNames = append(varNames, name)
+	}
+	sort.Strings(varNames)
+	err = sstore.SaveEnvProfile(ctx, profile)
+	if err != nil {
+		return nil, fmt.Errorf("/envprofile:set error saving env profile: %v", err)
+	}
+	return makeEnvProfilesUpdate(ctx, "env profile %q, set %s", profile.Name, formatStrs(varNames, "and", false))
+}
+
+// /envprofile:secret [name] [KEY] [file], the var's value is read from file (on the wavesrv host) for each run
+func EnvProfileSecretCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if len(pk.Args) != 3 {
+		return nil, fmt.Errorf("usage: /envprofile:secret [name] [KEY] [file]")
+	}
+	profile, err := getOrMakeEnvProfile(ctx, pk)
+	if err != nil {
+		return nil, err
+	}
+	envVar := sstore.EnvVarType{Name: pk.Args[1], SecretFile: pk.Args[2]}
+	err = sstore.ValidateEnvVar(envVar)
+	if err != nil {
+		return nil, fmt.Errorf("/envprofile:secret %v", err)
+	}
+	profile.SetVar(envVar)
+	err = sstore.SaveEnvProfile(ctx, profile)
+	if err != nil {
+		return nil, fmt.Errorf("/envprofile:secret error saving env profile: %v", err)
+	}
+	return makeEnvProfilesUpdate(ctx, "env profile %q, %s will be read from %s", profile.Name, envVar.Name, envVar.SecretFile)
+}
+
+// /envprofile:unset [name] [KEY]...
+func EnvProfileUnsetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if len(pk.Args) < 2 {
+		return nil, fmt.Errorf("usage: /envprofile:unset [name] [KEY]...")
+	}
+	profile, err := resolveEnvProfile(ctx, pk)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range pk.Args[1:] {
+		if !profile.RemoveVar(name) {
+			return nil, fmt.Errorf("/envprofile:unset env profile %q has no var %s", profile.Name, name)
+		}
+	}
+	err = sstore.SaveEnvProfile(ctx, profile)
+	if err != nil {
+		return nil, fmt.Errorf("/envprofile:unset error saving env profile: %v", err)
+	}
+	return makeEnvProfilesUpdate(ctx, "env profile %q, unset %s", profile.Name, formatStrs(pk.Args[1:], "and", false))
+}
+
+func EnvProfileDeleteCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	profile, err := resolveEnvProfile(ctx, pk)
+	if err != nil {
+		return nil, err
+	}
+	err = sstore.DeleteEnvProfile(ctx, profile.ProfileId)
+	if err != nil {
+		return nil, fmt.Errorf("/envprofile:delete error deleting env profile: %v", err)
+	}
+	return makeEnvProfilesUpdate(ctx, "env profile %q deleted", profile.Name)
+}
+
+// /envprofile:attach [name] [to=screen|remote]
+func EnvProfileAttachCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	profile, err := resolveEnvProfile(ctx, pk)
+	if err != nil {
+		return nil, err
+	}
+	targetType, targetId, err := resolveEnvAttachTarget(ctx, pk)
+	if err != nil {
+		return nil, err
+	}
+	attach := &sstore.EnvProfileAttachType{
+		TargetType: targetType,
+		TargetId:   targetId,
+		ProfileId:  profile.ProfileId,
+		AttachTs:   time.Now().UnixMilli(),
+	}
+	err = sstore.AttachEnvProfile(ctx, attach)
+	if err != nil {
+		return nil, fmt.Errorf("/envprofile:attach error attaching env profile: %v", err)
+	}
+	return makeEnvProfilesUpdate(ctx, "env profile %q attached to the %s, its vars will be set for new commands", profile.Name, defaultStr(pk.Kwargs["to"], "screen"))
+}
+
+// /envprofile:detach [name] [to=screen|remote]
+func EnvProfileDetachCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	profile, err := resolveEnvProfile(ctx, pk)
+	if err != nil {
+		return nil, err
+	}
+	targetType, targetId, err := resolveEnvAttachTarget(ctx, pk)
+	if err != nil {
+		return nil, err
+	}
+	found, err := sstore.DetachEnvProfile(ctx, targetType, targetId, profile.ProfileId)
+	if err != nil {
+		return nil, fmt.Errorf("/envprofile:detach error detaching env profile: %v", err)
+	}
+	if !found {
+		return nil, fmt.Errorf("/envprofile:detach env profile %q is not attached to the %s", profile.Name, defaultStr(pk.Kwargs["to"], "screen"))
+	}
+	return makeEnvProfilesUpdate(ctx, "env profile %q detached from the %s", profile.Name, defaultStr(pk.Kwargs["to"], "screen"))
+}
+
+// /envprofile:show [name], lists the profiles or shows the vars of one profile (secret values are never shown)
+func EnvProfileShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	var buf bytes.Buffer
+	if len(pk.Args) > 0 {
+		profile, err := resolveEnvProfile(ctx, pk)
+		if err != nil {
+			return nil, err
+		}
+		for _, envVar := range profile.Vars {
+			if envVar.SecretFile != "" {
+				buf.WriteString(fmt.Sprintf("  %-20s (secret, from %s)\n", envVar.Name, envVar.SecretFile))
+				continue
+			}
+			buf.WriteString(fmt.Sprintf("  %-20s %s\n", envVar.Name, utilfn.EllipsisStr(envVar.Value, 80)))
+		}
+		if len(profile.Vars) == 0 {
+			buf.WriteString("  (no vars)\n")
+		}
+		update := scbus.MakeUpdatePacket()
+		update.AddUpdate(sstore.InfoMsgType{InfoTitle: fmt.Sprintf("env profile %s", profile.Name), InfoLines: splitLinesForInfo(buf.String())})
+		return update, nil
+	}
+	envUpdate, err := sstore.MakeEnvProfilesUpdate(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("/envprofile:show error getting env profiles: %v", err)
+	}
+	if len(envUpdate.Profiles) == 0 {
+		return sstore.InfoMsgUpdate("no env profiles, create one with /envprofile:set [name] KEY=VALUE..."), nil
+	}
+	numAttached := make(map[string]int)
+	for _, attach := range envUpdate.Attachments {
+		numAttached[attach.ProfileId]++
+	}
+	for _, profile := range envUpdate.Profiles {
+		buf.WriteString(fmt.Sprintf("  %-20s %3d vars  attached:%d\n", profile.Name, len(profile.Vars), numAttached[profile.ProfileId]))
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{InfoTitle: "env profiles", InfoLines: splitLinesForInfo(buf.String())}, *envUpdate)
+	return update, nil
+}
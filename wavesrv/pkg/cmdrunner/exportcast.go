@@ -0,0 +1,144 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/base"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// writes a .cast file with exportFn, the file is removed if the export fails
+func writeCastFile(outPath string, exportFn func(f *os.File) error) error {
+	f, err := os.Create(outPath)
+	if err != nil {
+		return fmt.Errorf("cannot create %q: %v", outPath, err)
+	}
+	err = exportFn(f)
+	closeErr := f.Close()
+	if err == nil && closeErr != nil {
+		err = fmt.Errorf("cannot write %q: %v", outPath, closeErr)
+	}
+	if err != nil {
+		os.Remove(outPath)
+		return err
+	}
+	return nil
+}
+
+// for [line] path commands, the line defaults to the selected line.  returns (lineid, path)
+func resolveLinePathArgs(ctx context.Context, pk *scpacket.FeCommandPacketType, screenId string) (string, string, error) {
+	if len(pk.Args) == 0 || len(pk.Args) > 2 {
+		return "", "", fmt.Errorf("usage: %s [line] path", GetCmdStr(pk))
+	}
+	var lineId string
+	var err error
+	if len(pk.Args) == 2 {
+		lineId, err = sstore.FindLineIdByArg(ctx, screenId, pk.Args[0])
+		if err != nil {
+			return "", "", fmt.Errorf("error looking up lineid: %v", err)
+		}
+	} else {
+		lineId, err = sstore.GetScreenSelectedLineId(ctx, screenId)
+		if err != nil {
+			return "", "", fmt.Errorf("error getting selected lineid: %v", err)
+		}
+	}
+	if lineId == "" {
+		return "", "", fmt.Errorf("%s requires a line", GetCmdStr(pk))
+	}
+	return lineId, base.ExpandHomeDir(pk.Args[len(pk.Args)-1]), nil
+}
+
+// /line:exportcast [line] path
+func LineExportCastCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	lineId, outPath, err := resolveLinePathArgs(ctx, pk, ids.ScreenId)
+	if err != nil {
+		return nil, err
+	}
+	err = writeCastFile(outPath, func(f *os.File) error {
+		return sstore.ExportLineAsciinema(ctx, ids.ScreenId, lineId, f)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("/line:exportcast %v", err)
+	}
+	return sstore.InfoMsgUpdate("exported recording to %s", outPath), nil
+}
+
+// /line:saveraw [line] path (binary output, see sstore/binaryout.go)
+func LineSaveRawCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	lineId, outPath, err := resolveLinePathArgs(ctx, pk, ids.ScreenId)
+	if err != nil {
+		return nil, err
+	}
+	data, totalSize, err := sstore.ReadCmdRawOut(ctx, ids.ScreenId, lineId)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("/line:saveraw line has no binary output")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("/line:saveraw cannot read raw output: %v", err)
+	}
+	err = os.WriteFile(outPath, data, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("/line:saveraw cannot write %q: %v", outPath, err)
+	}
+	if totalSize > int64(len(data)) {
+		return sstore.InfoMsgUpdate("saved the first %d of %d bytes to %s", len(data), totalSize, outPath), nil
+	}
+	return sstore.InfoMsgUpdate("saved %d bytes to %s", len(data), outPath), nil
+}
+
+// /screen:exportcast path
+func ScreenExportCastCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) != 1 {
+		return nil, fmt.Errorf("usage: /screen:exportcast path")
+	}
+	outPath := base.ExpandHomeDir(pk.Args[0])
+	var numCmds int
+	err = writeCastFile(outPath, func(f *os.File) error {
+		var exportErr error
+		numCmds, exportErr = sstore.ExportScreenAsciinema(ctx, ids.ScreenId, f)
+		return exportErr
+	})
+	if err != nil {
+		return nil, fmt.Errorf("/screen:exportcast %v", err)
+	}
+	return sstore.InfoMsgUpdate("exported %d command(s) to %s", numCmds, outPath), nil
+}
+
+// /client:exportvault dir [full=1], exports all sessions as a markdown vault (see sstore/vaultexport.go)
+func ClientExportVaultCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if len(pk.Args) != 1 {
+		return nil, fmt.Errorf("usage: /client:exportvault dir")
+	}
+	outDir := base.ExpandHomeDir(pk.Args[0])
+	stats, err := sstore.ExportVault(ctx, outDir, sstore.VaultExportOpts{Full: resolveBool(pk.Kwargs["full"], false)})
+	if err != nil {
+		return nil, fmt.Errorf("/client:exportvault %v", err)
+	}
+	msg := fmt.Sprintf("exported %d screen(s) to %s (%d updated, %d unchanged", stats.NumScreens, outDir, stats.NumWritten, stats.NumUnchanged)
+	if stats.NumRemoved > 0 {
+		msg += fmt.Sprintf(", %d old file(s) removed", stats.NumRemoved)
+	}
+	return sstore.InfoMsgUpdate("%s)", msg), nil
+}
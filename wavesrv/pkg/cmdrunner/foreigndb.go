@@ -0,0 +1,150 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/utilfn"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+const foreignLineCmdMaxLen = 80
+
+func formatForeignDBInfo(info *sstore.ForeignDBInfoType) string {
+	rtn := fmt.Sprintf("%s (%d sessions, %d screens)", info.DBName, info.NumSessions, info.NumScreens)
+	if !info.HasBlockstore {
+		rtn += ", no blockstore db (screens are imported without output)"
+	}
+	return rtn
+}
+
+func formatForeignSessions(sessions []*sstore.ForeignSessionType) string {
+	var buf bytes.Buffer
+	for _, session := range sessions {
+		archivedStr := ""
+		if session.Archived {
+			archivedStr = " (archived)"
+		}
+		buf.WriteString(fmt.Sprintf("%s%s\n", session.Name, archivedStr))
+		for _, screen := range session.Screens {
+			archivedStr = ""
+			if screen.Archived {
+				archivedStr = " (archived)"
+			}
+			buf.WriteString(fmt.Sprintf("  %s  %-20s %4d lines%s\n", screen.ScreenId, screen.Name, screen.NumLines, archivedStr))
+		}
+	}
+	if len(sessions) == 0 {
+		buf.WriteString("no sessions\n")
+	}
+	return buf.String()
+}
+
+func formatForeignLine(line *sstore.ForeignLineType, tsFmt sstore.ClientTsFormat) string {
+	tsStr := tsFmt.Format(time.UnixMilli(line.Ts))
+	if line.LineType == sstore.LineTypeCmd {
+		cmdStr := utilfn.TruncateWidth(line.CmdStr, foreignLineCmdMaxLen+3)
+		return fmt.Sprintf("%4d  %s  [%s] %s  (%s, exit %d)", line.LineNum, tsStr, line.RemoteName, cmdStr, line.Status, line.ExitCode)
+	}
+	return fmt.Sprintf("%4d  %s  %s", line.LineNum, tsStr, line.Text)
+}
+
+// /foreigndb:attach path=<wave home dir or waveterm.db>
+func ForeignDBAttachCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	dbPath := pk.Kwargs["path"]
+	if dbPath == "" && len(pk.Args) > 0 {
+		dbPath = pk.Args[0]
+	}
+	if dbPath == "" {
+		return nil, fmt.Errorf("usage: /foreigndb:attach path=<wave home dir or waveterm.db>")
+	}
+	info, err := sstore.AttachForeignDB(ctx, dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("/foreigndb:attach %v", err)
+	}
+	return sstore.InfoMsgUpdate("attached foreign db %s", formatForeignDBInfo(info)), nil
+}
+
+// /foreigndb:detach
+func ForeignDBDetachCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if !sstore.DetachForeignDB() {
+		return nil, fmt.Errorf("/foreigndb:detach no foreign db attached")
+	}
+	return sstore.InfoMsgUpdate("foreign db detached"), nil
+}
+
+// /foreigndb:show
+func ForeignDBShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	info, err := sstore.GetForeignDBInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("/foreigndb:show %v", err)
+	}
+	sessions, err := sstore.GetForeignSessions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("/foreigndb:show %v", err)
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: "foreign db " + formatForeignDBInfo(info),
+		InfoLines: splitLinesForInfo(formatForeignSessions(sessions)),
+	})
+	return update, nil
+}
+
+// /foreigndb:lines screen=<screenid>
+func ForeignDBLinesCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	screenId := pk.Kwargs["screen"]
+	if screenId == "" {
+		return nil, fmt.Errorf("usage: /foreigndb:lines screen=<screenid> (screenids are listed by /foreigndb:show)")
+	}
+	lines, err := sstore.GetForeignScreenLines(ctx, screenId)
+	if err != nil {
+		return nil, fmt.Errorf("/foreigndb:lines %v", err)
+	}
+	tsFmt := sstore.GetClientTsFormat(ctx)
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(formatForeignLine(line, tsFmt) + "\n")
+	}
+	if len(lines) == 0 {
+		buf.WriteString("no lines\n")
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: fmt.Sprintf("foreign screen %s", screenId),
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
+
+// /foreigndb:import screen=<screenid> [name=<name>]
+// copies the foreign screen into the current session
+func ForeignDBImportCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session)
+	if err != nil {
+		return nil, fmt.Errorf("/foreigndb:import %w", err)
+	}
+	screenId := pk.Kwargs["screen"]
+	if screenId == "" {
+		return nil, fmt.Errorf("usage: /foreigndb:import screen=<screenid> [name=<name>]")
+	}
+	name := pk.Kwargs["name"]
+	if name != "" {
+		err = validateName(name, "screen")
+		if err != nil {
+			return nil, err
+		}
+	}
+	update, err := sstore.ImportForeignScreen(ctx, screenId, ids.SessionId, name)
+	if err != nil {
+		return nil, fmt.Errorf("/foreigndb:import %v", err)
+	}
+	return update, nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func TestFormatForeignSessions(t *testing.T) {
+	sessions := []*sstore.ForeignSessionType{
+		{SessionId: "s1", Name: "workspace-1", Screens: []*sstore.ForeignScreenType{
+			{ScreenId: "screen1", Name: "build", NumLines: 12},
+			{ScreenId: "screen2", Name: "old", Archived: true},
+		}},
+	}
+	output := formatForeignSessions(sessions)
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %q", output)
+	}
+	if !strings.Contains(lines[1], "screen1") || !strings.Contains(lines[1], "12 lines") {
+		t.Errorf("invalid screen line: %q", lines[1])
+	}
+	if !strings.HasSuffix(lines[2], "(archived)") {
+		t.Errorf("archived screen not marked: %q", lines[2])
+	}
+	if formatForeignSessions(nil) != "no sessions\n" {
+		t.Errorf("invalid output for no sessions")
+	}
+}
+
+func TestFormatForeignLine(t *testing.T) {
+	line := &sstore.ForeignLineType{LineNum: 3, LineType: sstore.LineTypeCmd, CmdStr: strings.Repeat("x", 100), RemoteName: "local", Status: "done", ExitCode: 1}
+	output := formatForeignLine(line, sstore.ClientTsFormat{})
+	if !strings.Contains(output, "[local]") || !strings.Contains(output, "...") || !strings.Contains(output, "exit 1") {
+		t.Errorf("invalid cmd line output: %q", output)
+	}
+	line = &sstore.ForeignLineType{LineNum: 4, LineType: sstore.LineTypeText, Text: "a comment"}
+	if !strings.HasSuffix(formatForeignLine(line, sstore.ClientTsFormat{}), "a comment") {
+		t.Errorf("invalid text line output: %q", formatForeignLine(line, sstore.ClientTsFormat{}))
+	}
+}
+
+func TestAttachForeignDBMissing(t *testing.T) {
+	_, err := sstore.AttachForeignDB(context.Background(), t.TempDir())
+	if err == nil {
+		t.Errorf("expected error attaching a dir without a db")
+	}
+}
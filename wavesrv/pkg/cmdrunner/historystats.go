@@ -0,0 +1,125 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/utilfn"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/history"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// exit code and duration statistics (see history/stats.go)
+
+var historyStatsBuckets = map[string]time.Duration{
+	"5m":   5 * time.Minute,
+	"15m":  15 * time.Minute,
+	"hour": time.Hour,
+	"6h":   6 * time.Hour,
+	"day":  24 * time.Hour,
+	"week": 7 * 24 * time.Hour,
+}
+
+func formatStatsDuration(durationMs int64) string {
+	return (time.Duration(durationMs) * time.Millisecond).Round(time.Millisecond).String()
+}
+
+func formatStatsCmd(cmdStr string) string {
+	return utilfn.EllipsisStr(strings.ReplaceAll(cmdStr, "\n", " "), 60)
+}
+
+func formatCommandStats(ctx context.Context, stats *history.CommandStatsType) string {
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("  %-15s %d, %d failed (%.1f%%)\n", "commands", stats.NumCmds, stats.NumErrors, stats.FailureRate*100))
+	if stats.NumTimed > 0 {
+		buf.WriteString(fmt.Sprintf("  %-15s avg %s, max %s\n", "duration", formatStatsDuration(stats.AvgDurationMs), formatStatsDuration(stats.MaxDurationMs)))
+	}
+	buf.WriteString(fmt.Sprintf("  %-15s %s to %s\n", "range", formatTs(ctx, time.UnixMilli(stats.MinTs)), formatTs(ctx, time.UnixMilli(stats.MaxTs))))
+	buf.WriteString("\nmost used\n")
+	for _, cmd := range stats.TopCmds {
+		buf.WriteString(fmt.Sprintf("  %5dx  %5.1f%% failed  %s\n", cmd.NumCmds, cmd.FailureRate*100, formatStatsCmd(cmd.CmdStr)))
+	}
+	if len(stats.FailingCmds) > 0 {
+		buf.WriteString("\nmost failed\n")
+		for _, cmd := range stats.FailingCmds {
+			buf.WriteString(fmt.Sprintf("  %5d/%-5d  %s\n", cmd.NumErrors, cmd.NumCmds, formatStatsCmd(cmd.CmdStr)))
+		}
+	}
+	if len(stats.SlowestCmds) > 0 {
+		buf.WriteString("\nslowest (avg)\n")
+		for _, cmd := range stats.SlowestCmds {
+			buf.WriteString(fmt.Sprintf("  %10s  %s\n", formatStatsDuration(cmd.AvgDurationMs), formatStatsCmd(cmd.CmdStr)))
+		}
+	}
+	if len(stats.ExitCodes) > 0 {
+		var codeStrs []string
+		for _, code := range stats.ExitCodes {
+			codeStrs = append(codeStrs, fmt.Sprintf("%d (%dx)", code.ExitCode, code.NumCmds))
+		}
+		buf.WriteString(fmt.Sprintf("\nexit codes\n  %s\n", strings.Join(codeStrs, ", ")))
+	}
+	if len(stats.Remotes) > 1 {
+		buf.WriteString("\nby remote\n")
+		for _, rstat := range stats.Remotes {
+			buf.WriteString(fmt.Sprintf("  %-20s %5d cmds  %5.1f%% failed  avg %s\n", rstat.RemoteName, rstat.NumCmds, rstat.FailureRate*100, formatStatsDuration(rstat.AvgDurationMs)))
+		}
+	}
+	return buf.String()
+}
+
+// /history:stats [search] scope=remote|cwd|screen|session|global days=N bucket=5m|15m|hour|6h|day|week,
+// exit code and duration statistics (the full stats with the time buckets are sent as a historystats update)
+func HistoryStatsCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, err
+	}
+	maxItems, err := resolvePosInt(pk.Kwargs["maxitems"], history.DefaultStatsItems)
+	if err != nil {
+		return nil, fmt.Errorf("invalid maxitems value '%s' (must be a number): %v", pk.Kwargs["maxitems"], err)
+	}
+	days, err := resolveNonNegInt(pk.Kwargs["days"], 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid days: %v", err)
+	}
+	var bucketMs int64
+	if pk.Kwargs["bucket"] != "" {
+		bucketDur, ok := historyStatsBuckets[pk.Kwargs["bucket"]]
+		if !ok {
+			return nil, fmt.Errorf("invalid bucket '%s', valid buckets: %s", pk.Kwargs["bucket"], formatStrs([]string{"5m", "15m", "hour", "6h", "day", "week"}, "or", false))
+		}
+		bucketMs = bucketDur.Milliseconds()
+	}
+	opts := history.HistoryQueryOpts{MaxItems: maxItems, SearchText: firstArg(pk), NoMeta: true}
+	scope := defaultStr(pk.Kwargs["scope"], HistoryTopScope_Global)
+	err = resolveHistoryScope(ids, scope, &opts)
+	if err != nil {
+		return nil, err
+	}
+	err = resolveHistoryFilterKwargs(pk, &opts)
+	if err != nil {
+		return nil, err
+	}
+	if days > 0 {
+		opts.MinTs = time.Now().Add(-time.Duration(days) * 24 * time.Hour).UnixMilli()
+	}
+	stats, err := history.GetCommandStats(ctx, opts, bucketMs)
+	if err != nil {
+		return nil, fmt.Errorf("/history:stats error: %v", err)
+	}
+	if stats.NumCmds == 0 {
+		return sstore.InfoMsgUpdate("no commands found (scope %s)", scope), nil
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(*stats)
+	update.AddUpdate(sstore.InfoMsgType{InfoTitle: fmt.Sprintf("command stats (scope %s)", scope), InfoLines: splitLinesForInfo(formatCommandStats(ctx, stats))})
+	return update, nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/historysync"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func formatSyncTs(tsFmt sstore.ClientTsFormat, ts int64) string {
+	if ts == 0 {
+		return "never"
+	}
+	return tsFmt.Format(time.UnixMilli(ts))
+}
+
+// /history:sync:set backend=<dir|file://|http(s)://webdav|s3://bucket/prefix> [enabled=0|1]
+func HistorySyncSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	backend := pk.Kwargs["backend"]
+	if backend == "" {
+		cfg, err := historysync.GetSyncConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if cfg == nil {
+			return nil, fmt.Errorf("/history:sync:set requires backend=[dir|webdav url|s3://bucket/prefix]")
+		}
+		backend = cfg.Backend
+	}
+	enabled := resolveBool(pk.Kwargs["enabled"], true)
+	err := historysync.SetSyncConfig(ctx, backend, enabled)
+	if err != nil {
+		return nil, fmt.Errorf("/history:sync:set error: %v", err)
+	}
+	if !enabled {
+		return sstore.InfoMsgUpdate("history sync disabled (%s)", historysync.BackendDisplayStr(backend)), nil
+	}
+	return sstore.InfoMsgUpdate("history sync enabled (%s), run /history:sync to sync now", historysync.BackendDisplayStr(backend)), nil
+}
+
+// /history:sync:show
+func HistorySyncShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	cfg, err := historysync.GetSyncConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return sstore.InfoMsgUpdate("history sync is not set up (see /history:sync:set)"), nil
+	}
+	peers, err := historysync.GetSyncPeers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tsFmt := sstore.GetClientTsFormat(ctx)
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("  %-12s %s\n", "backend", historysync.BackendDisplayStr(cfg.Backend)))
+	buf.WriteString(fmt.Sprintf("  %-12s %v\n", "enabled", cfg.Enabled))
+	buf.WriteString(fmt.Sprintf("  %-12s %s\n", "last sync", formatSyncTs(tsFmt, cfg.LastSyncTs)))
+	if cfg.LastError != "" {
+		buf.WriteString(fmt.Sprintf("  %-12s %s\n", "last error", cfg.LastError))
+	}
+	buf.WriteString(fmt.Sprintf("  %-12s %d\n", "deltas", cfg.NextSeq-1))
+	for _, peer := range peers {
+		buf.WriteString(fmt.Sprintf("  peer %s  delta %d, last merged %s\n", peer.ClientId, peer.LastSeq, formatSyncTs(tsFmt, peer.LastSyncTs)))
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{InfoTitle: "history sync", InfoLines: splitLinesForInfo(buf.String())})
+	return update, nil
+}
+
+// /history:sync, exports the new history items and merges the deltas of the other installations now
+func HistorySyncCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	result, err := historysync.RunSync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("/history:sync error: %v", err)
+	}
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("exported %d, imported %d history items (%d peers)\n", result.NumExported, result.NumImported, result.NumPeers))
+	for _, peerId := range result.NewPeers {
+		buf.WriteString(fmt.Sprintf("new peer %s\n", peerId))
+	}
+	for _, errStr := range result.Errors {
+		buf.WriteString(fmt.Sprintf("skipped: %s\n", errStr))
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{InfoTitle: "history sync", InfoLines: splitLinesForInfo(buf.String())})
+	return update, nil
+}
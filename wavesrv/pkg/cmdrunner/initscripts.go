@@ -0,0 +1,158 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/utilfn"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// init scripts (remoteopts initscripts) run once each time a new remote instance is created (connect or /reset),
+// after its initial state has been captured.  all scripts run together as a single (minimized) line with
+// rtnstate, so any environment changes they make are kept in the new remote instance.
+
+const MaxInitScripts = 20
+
+// max time to wait for the init scripts to finish before running the screen's startup commands
+const MaxStartupCmdWait = 5 * time.Minute
+
+// joined with newlines into a single command, so the commands run sequentially in the same shell
+func makeCmdListStr(cmds []string) string {
+	return strings.Join(cmds, "\n")
+}
+
+// desc is used in error messages, e.g. "init script"
+func validateCmdList(cmds []string, maxCmds int, desc string) error {
+	if len(cmds) > maxCmds {
+		return fmt.Errorf("too many %ss (max %d)", desc, maxCmds)
+	}
+	for _, cmdStr := range cmds {
+		if strings.TrimSpace(cmdStr) == "" {
+			return fmt.Errorf("%s cannot be empty", desc)
+		}
+	}
+	cmdLen := len(makeCmdListStr(cmds))
+	if cmdLen > MaxCommandLen {
+		return fmt.Errorf("%ss too long len:%d, max:%d", desc, cmdLen, MaxCommandLen)
+	}
+	return nil
+}
+
+// evals cmdStr on the screen and remote from opts, as if it was typed.  if wait is set and the command can
+// update the state, blocks until it finishes so that later commands see its state changes.
+func evalStartupCmd(ctx context.Context, wsh *remote.WaveshellProc, opts connectOptsType, cmdStr string, kwargs map[string]string, wait bool) error {
+	rptr := opts.RPtr
+	pk := scpacket.MakeFeCommandPacket()
+	pk.MetaCmd = "eval"
+	pk.Args = []string{cmdStr}
+	pk.Kwargs = kwargs
+	pk.UIContext = &scpacket.UIContextType{
+		SessionId: opts.SessionId,
+		ScreenId:  opts.ScreenId,
+		Remote:    &rptr,
+	}
+	update, err := HandleCommand(ctx, pk)
+	if err != nil {
+		return err
+	}
+	if update != nil {
+		scbus.MainUpdateBus.DoUpdate(update)
+	}
+	if !wait {
+		return nil
+	}
+	ck := wsh.GetPendingStateCmd(opts.ScreenId, opts.RPtr)
+	if ck == nil {
+		return nil
+	}
+	doneCh := registerChainWaiter(*ck)
+	defer unregisterChainWaiter(*ck)
+	waitCtx, cancelFn := context.WithTimeout(ctx, MaxStartupCmdWait)
+	defer cancelFn()
+	doneCmd, err := waitForChainCmd(waitCtx, *ck, doneCh)
+	if err != nil {
+		return err
+	}
+	if doneCmd.Status != sstore.CmdStatusDone || doneCmd.ExitCode != 0 {
+		return fmt.Errorf("command failed (status=%s exitcode=%d)", doneCmd.Status, doneCmd.ExitCode)
+	}
+	return nil
+}
+
+func runRemoteInitScripts(ctx context.Context, wsh *remote.WaveshellProc, opts connectOptsType) error {
+	remoteCopy := wsh.GetRemoteCopy()
+	if remoteCopy.RemoteOpts == nil || len(remoteCopy.RemoteOpts.InitScripts) == 0 {
+		return nil
+	}
+	kwargs := map[string]string{
+		KwArgInitScript: remoteCopy.RemoteId,
+		KwArgNoHist:     "1",
+		"rtnstate":      "1",
+	}
+	return evalStartupCmd(ctx, wsh, opts, makeCmdListStr(remoteCopy.RemoteOpts.InitScripts), kwargs, true)
+}
+
+// /remote:initscripts [add=script] [remove=n] [clear=1]
+// no args shows the init scripts
+func RemoteInitScriptsCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, err
+	}
+	remoteCopy := ids.Remote.RemoteCopy
+	var scripts []string
+	if remoteCopy.RemoteOpts != nil {
+		scripts = remoteCopy.RemoteOpts.InitScripts
+	}
+	var updated bool
+	if resolveBool(pk.Kwargs["clear"], false) {
+		scripts = nil
+		updated = true
+	}
+	if removeStr, found := pk.Kwargs["remove"]; found {
+		removeIdx, err := resolvePosInt(removeStr, 0)
+		if err != nil || removeIdx < 1 || removeIdx > len(scripts) {
+			return nil, fmt.Errorf("/remote:initscripts invalid remove index %q (remote has %d init scripts)", removeStr, len(scripts))
+		}
+		scripts = append(append([]string{}, scripts[0:removeIdx-1]...), scripts[removeIdx:]...)
+		updated = true
+	}
+	if addStr, found := pk.Kwargs["add"]; found {
+		scripts = append(append([]string{}, scripts...), addStr)
+		updated = true
+	}
+	if updated {
+		err = validateCmdList(scripts, MaxInitScripts, "init script")
+		if err != nil {
+			return nil, fmt.Errorf("/remote:initscripts %v", err)
+		}
+		editMap := map[string]interface{}{sstore.RemoteField_InitScripts: scripts}
+		err = ids.Remote.Waveshell.UpdateRemote(ctx, editMap)
+		if err != nil {
+			return nil, fmt.Errorf("/remote:initscripts error updating remote: %v", err)
+		}
+	}
+	var buf bytes.Buffer
+	if len(scripts) == 0 {
+		buf.WriteString("no init scripts\n")
+	}
+	for idx, script := range scripts {
+		buf.WriteString(fmt.Sprintf("  %d. %s\n", idx+1, utilfn.EllipsisStr(script, 80)))
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: fmt.Sprintf("init scripts for remote %q (run on connect and /reset)", ids.Remote.DisplayName),
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
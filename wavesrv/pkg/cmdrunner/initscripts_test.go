@@ -0,0 +1,27 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateCmdList(t *testing.T) {
+	if err := validateCmdList([]string{"cd ~/project", "source .env"}, MaxInitScripts, "init script"); err != nil {
+		t.Errorf("expected valid scripts, got %v", err)
+	}
+	if err := validateCmdList([]string{"cd ~/project", "  "}, MaxInitScripts, "init script"); err == nil {
+		t.Errorf("expected error for empty script")
+	}
+	if err := validateCmdList([]string{strings.Repeat("x", MaxCommandLen), "y"}, MaxInitScripts, "init script"); err == nil {
+		t.Errorf("expected error for scripts that are too long")
+	}
+	if err := validateCmdList([]string{"a", "b", "c"}, 2, "startup command"); err == nil {
+		t.Errorf("expected error for too many commands")
+	}
+	if cmdStr := makeCmdListStr([]string{"a", "b"}); cmdStr != "a\nb" {
+		t.Errorf("invalid init script cmd %q", cmdStr)
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/base"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// a chain is a set of cmd lines on a screen (grouped by sstore.LineState_ChainId) that are
+// re-run in linenum order.  the chain stops at the first cmd that does not exit with 0.
+
+const MaxChainIdLen = 50
+
+// backup for the cmd done hook (cmds can also end with a hangup/disconnect)
+const ChainCmdPollInterval = 2 * time.Second
+
+var chainIdRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]*$`)
+
+var chainLock = &sync.Mutex{}
+var chainDoneWaiters = make(map[base.CommandKey]chan bool)
+var runningChains = make(map[string]bool) // key is screenid + "/" + chainid
+
+func init() {
+	sstore.RegisterCmdDoneHook(chainCmdDone)
+}
+
+func chainCmdDone(cmd sstore.CmdType) {
+	chainLock.Lock()
+	defer chainLock.Unlock()
+	ck := base.MakeCommandKey(cmd.ScreenId, cmd.LineId)
+	doneCh := chainDoneWaiters[ck]
+	if doneCh == nil {
+		return
+	}
+	delete(chainDoneWaiters, ck)
+	close(doneCh)
+}
+
+func validateChainId(chainId string) error {
+	if chainId == "" {
+		return fmt.Errorf("chain id cannot be empty")
+	}
+	if len(chainId) > MaxChainIdLen {
+		return fmt.Errorf("chain id too long (max %d characters)", MaxChainIdLen)
+	}
+	if !chainIdRe.MatchString(chainId) {
+		return fmt.Errorf("invalid chain id %q, must start with a letter and contain only letters, numbers, '_', '-', and '.'", chainId)
+	}
+	return nil
+}
+
+func registerChainWaiter(ck base.CommandKey) chan bool {
+	chainLock.Lock()
+	defer chainLock.Unlock()
+	doneCh := make(chan bool)
+	chainDoneWaiters[ck] = doneCh
+	return doneCh
+}
+
+func unregisterChainWaiter(ck base.CommandKey) {
+	chainLock.Lock()
+	defer chainLock.Unlock()
+	delete(chainDoneWaiters, ck)
+}
+
+// returns false if the chain is already running
+func startChainRun(screenId string, chainId string) bool {
+	chainLock.Lock()
+	defer chainLock.Unlock()
+	key := screenId + "/" + chainId
+	if runningChains[key] {
+		return false
+	}
+	runningChains[key] = true
+	return true
+}
+
+func endChainRun(screenId string, chainId string) {
+	chainLock.Lock()
+	defer chainLock.Unlock()
+	delete(runningChains, screenId+"/"+chainId)
+}
+
+// waits for the cmd to finish, returns the finished cmd
+func waitForChainCmd(ctx context.Context, ck base.CommandKey, doneCh chan bool) (*sstore.CmdType, error) {
+	ticker := time.NewTicker(ChainCmdPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-doneCh:
+		case <-ticker.C:
+		}
+		cmd, err := sstore.GetCmdByScreenId(ctx, ck.GetGroupId(), ck.GetCmdId())
+		if err != nil {
+			return nil, err
+		}
+		if cmd == nil {
+			return nil, fmt.Errorf("cmd not found (line deleted)")
+		}
+		if cmd.Status != sstore.CmdStatusRunning && cmd.Status != sstore.CmdStatusDetached {
+			return cmd, nil
+		}
+	}
+}
+
+func runChainLine(ctx context.Context, sessionId string, line *sstore.LineType) error {
+	_, cmd, err := sstore.GetLineCmdByLineId(ctx, line.ScreenId, line.LineId)
+	if err != nil {
+		return err
+	}
+	if cmd == nil {
+		return fmt.Errorf("line %d is not a cmd line", line.LineNum)
+	}
+	wsh := remote.GetRemoteById(cmd.Remote.RemoteId)
+	if wsh == nil || !wsh.IsConnected() {
+		return fmt.Errorf("line %d, remote is not connected", line.LineNum)
+	}
+	remoteCopy := wsh.GetRemoteCopy()
+	if denyPattern := CheckCmdPolicy(remoteCopy.RemoteOpts, cmd.CmdStr); denyPattern != "" {
+		return fmt.Errorf("line %d, command blocked by the policy for remote %q (matches %q)", line.LineNum, remoteCopy.GetName(), denyPattern)
+	}
+	termOpts := convertToPacketTermOpts(cmd.TermOpts)
+	termOpts.Term = remote.DefaultTerm
+	ck := base.MakeCommandKey(line.ScreenId, line.LineId)
+	doneCh := registerChainWaiter(ck)
+	defer unregisterChainWaiter(ck)
+	err = restartCmd(ctx, sessionId, wsh, cmd.Remote, cmd, termOpts)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", line.LineNum, err)
+	}
+	updatedLine, updatedCmd, err := sstore.GetLineCmdByLineId(ctx, line.ScreenId, line.LineId)
+	if err == nil && updatedLine != nil && updatedCmd != nil {
+		updatedCmd.Restarted = true
+		update := scbus.MakeUpdatePacket()
+		sstore.AddLineUpdate(update, updatedLine, updatedCmd)
+		scbus.MainUpdateBus.DoScreenUpdate(line.ScreenId, update)
+	}
+	doneCmd, err := waitForChainCmd(ctx, ck, doneCh)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", line.LineNum, err)
+	}
+	if doneCmd.Status != sstore.CmdStatusDone || doneCmd.ExitCode != 0 {
+		return fmt.Errorf("line %d failed (status=%s exitcode=%d)", line.LineNum, doneCmd.Status, doneCmd.ExitCode)
+	}
+	return nil
+}
+
+// re-runs the lines in the chain sequentially (in linenum order), stopping at the first failure.
+// blocks until the chain finishes.
+func RunChain(ctx context.Context, screenId string, chainId string) error {
+	screen, err := sstore.GetScreenById(ctx, screenId)
+	if err != nil {
+		return err
+	}
+	if screen == nil {
+		return fmt.Errorf("screen not found")
+	}
+	lines, err := sstore.GetChainLines(ctx, screenId, chainId)
+	if err != nil {
+		return err
+	}
+	if len(lines) == 0 {
+		return fmt.Errorf("chain %q has no lines", chainId)
+	}
+	if !startChainRun(screenId, chainId) {
+		return fmt.Errorf("chain %q is already running", chainId)
+	}
+	defer endChainRun(screenId, chainId)
+	for _, line := range lines {
+		err = runChainLine(ctx, screen.SessionId, line)
+		if err != nil {
+			return fmt.Errorf("chain %q stopped: %w", chainId, err)
+		}
+	}
+	return nil
+}
+
+func resolveLineIdArgs(ctx context.Context, screenId string, lineArgs []string) ([]string, error) {
+	var lineIds []string
+	for _, lineArg := range lineArgs {
+		lineId, err := sstore.FindLineIdByArg(ctx, screenId, lineArg)
+		if err != nil {
+			return nil, fmt.Errorf("error looking up lineid: %v", err)
+		}
+		if lineId == "" {
+			return nil, fmt.Errorf("line %q not found", lineArg)
+		}
+		lineIds = append(lineIds, lineId)
+	}
+	return lineIds, nil
+}
+
+func LineChainCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) < 2 {
+		return nil, fmt.Errorf("usage: /line:chain [chainid] [line1] [line2] ...")
+	}
+	chainId := pk.Args[0]
+	err = validateChainId(chainId)
+	if err != nil {
+		return nil, err
+	}
+	lineIds, err := resolveLineIdArgs(ctx, ids.ScreenId, pk.Args[1:])
+	if err != nil {
+		return nil, err
+	}
+	for _, lineId := range lineIds {
+		_, cmd, err := sstore.GetLineCmdByLineId(ctx, ids.ScreenId, lineId)
+		if err != nil {
+			return nil, err
+		}
+		if cmd == nil {
+			return nil, fmt.Errorf("cannot add non-cmd line to a chain")
+		}
+	}
+	err = sstore.SetLinesChainId(ctx, ids.ScreenId, lineIds, chainId)
+	if err != nil {
+		return nil, fmt.Errorf("/line:chain error updating lines: %v", err)
+	}
+	return sstore.InfoMsgUpdate("added %d line(s) to chain %q", len(lineIds), chainId), nil
+}
+
+func LineUnchainCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf("/line:unchain requires at least one argument (line number or id)")
+	}
+	lineIds, err := resolveLineIdArgs(ctx, ids.ScreenId, pk.Args)
+	if err != nil {
+		return nil, err
+	}
+	err = sstore.SetLinesChainId(ctx, ids.ScreenId, lineIds, "")
+	if err != nil {
+		return nil, fmt.Errorf("/line:unchain error updating lines: %v", err)
+	}
+	return sstore.InfoMsgUpdate("removed %d line(s) from their chain", len(lineIds)), nil
+}
+
+func LineRunChainCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf("/line:runchain requires an argument (chain id)")
+	}
+	chainId := pk.Args[0]
+	lines, err := sstore.GetChainLines(ctx, ids.ScreenId, chainId)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("chain %q has no lines", chainId)
+	}
+	go func() {
+		// chains can run for a long time, so this cannot use the command's ctx
+		err := RunChain(context.Background(), ids.ScreenId, chainId)
+		if err != nil {
+			log.Printf("error running chain %s/%s: %v\n", ids.ScreenId, chainId, err)
+			scbus.MainUpdateBus.DoScreenUpdate(ids.ScreenId, sstore.InfoMsgUpdate("%v", err))
+			return
+		}
+		scbus.MainUpdateBus.DoScreenUpdate(ids.ScreenId, sstore.InfoMsgUpdate("chain %q finished", chainId))
+	}()
+	return sstore.InfoMsgUpdate("running chain %q (%d lines)", chainId, len(lines)), nil
+}
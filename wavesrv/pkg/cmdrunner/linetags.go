@@ -0,0 +1,286 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+const MaxScreenFilters = 20
+const MaxScreenFilterNameLen = 50
+
+func resolveLineTags(tagArgs []string) ([]string, error) {
+	var rtn []string
+	for _, tagArg := range tagArgs {
+		// allow "a,b" as well as "a b"
+		for _, tagStr := range strings.Split(tagArg, ",") {
+			if strings.TrimSpace(tagStr) == "" {
+				continue
+			}
+			tag, err := sstore.NormalizeLineTag(tagStr)
+			if err != nil {
+				return nil, err
+			}
+			rtn = append(rtn, tag)
+		}
+	}
+	return rtn, nil
+}
+
+func lineTagUpdate(ctx context.Context, screenId string, lineId string, infoMsg string) (scbus.UpdatePacket, error) {
+	lineObj, err := sstore.GetLineById(ctx, screenId, lineId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting line: %v", err)
+	}
+	update := scbus.MakeUpdatePacket()
+	if lineObj != nil {
+		sstore.AddLineUpdate(update, lineObj, nil)
+	}
+	update.AddUpdate(sstore.InfoMsgType{InfoMsg: infoMsg, TimeoutMs: 2000})
+	return update, nil
+}
+
+func LineTagCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) < 2 {
+		return nil, fmt.Errorf("usage: /line:tag [line] [tag1] [tag2] ...")
+	}
+	lineId, err := sstore.FindLineIdByArg(ctx, ids.ScreenId, pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("error looking up lineid: %v", err)
+	}
+	if lineId == "" {
+		return nil, fmt.Errorf("line %q not found", pk.Args[0])
+	}
+	tags, err := resolveLineTags(pk.Args[1:])
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("/line:tag requires at least one tag")
+	}
+	for _, tag := range tags {
+		err = sstore.TagLine(ctx, ids.ScreenId, lineId, tag)
+		if err != nil {
+			return nil, fmt.Errorf("/line:tag error: %v", err)
+		}
+	}
+	return lineTagUpdate(ctx, ids.ScreenId, lineId, fmt.Sprintf("line tagged %s", formatStrs(tags, "and", false)))
+}
+
+func LineUntagCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) < 1 {
+		return nil, fmt.Errorf("usage: /line:untag [line] [tag1] [tag2] ... (no tags removes all tags)")
+	}
+	lineId, err := sstore.FindLineIdByArg(ctx, ids.ScreenId, pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("error looking up lineid: %v", err)
+	}
+	if lineId == "" {
+		return nil, fmt.Errorf("line %q not found", pk.Args[0])
+	}
+	tags, err := resolveLineTags(pk.Args[1:])
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) == 0 {
+		tags, err = sstore.GetLineTags(ctx, ids.ScreenId, lineId)
+		if err != nil {
+			return nil, fmt.Errorf("/line:untag error getting tags: %v", err)
+		}
+	}
+	for _, tag := range tags {
+		err = sstore.UntagLine(ctx, ids.ScreenId, lineId, tag)
+		if err != nil {
+			return nil, fmt.Errorf("/line:untag error: %v", err)
+		}
+	}
+	return lineTagUpdate(ctx, ids.ScreenId, lineId, fmt.Sprintf("removed %d tag(s) from line", len(tags)))
+}
+
+// shows the lines with the given tag (or all tags on the screen if no tag is given)
+func LineTaggedCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if len(pk.Args) == 0 {
+		tags, err := sstore.GetScreenLineTags(ctx, ids.ScreenId)
+		if err != nil {
+			return nil, err
+		}
+		if len(tags) == 0 {
+			buf.WriteString("no tagged lines\n")
+		}
+		for _, tag := range tags {
+			buf.WriteString(fmt.Sprintf("  %s\n", tag))
+		}
+		update := scbus.MakeUpdatePacket()
+		update.AddUpdate(sstore.InfoMsgType{
+			InfoTitle: "line tags for screen",
+			InfoLines: splitLinesForInfo(buf.String()),
+		})
+		return update, nil
+	}
+	tag, err := sstore.NormalizeLineTag(pk.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	lines, err := sstore.GetLinesByTag(ctx, ids.ScreenId, tag)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		buf.WriteString("no lines found\n")
+	}
+	for _, line := range lines {
+		_, cmd, err := sstore.GetLineCmdByLineId(ctx, ids.ScreenId, line.LineId)
+		if err != nil {
+			return nil, err
+		}
+		text := line.Text
+		if cmd != nil {
+			text = cmd.CmdStr
+		}
+		text, _, _ = strings.Cut(text, "\n")
+		buf.WriteString(fmt.Sprintf("  %-5d %s\n", line.LineNum, text))
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: fmt.Sprintf("lines tagged %q", tag),
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
+
+func screenViewOptsUpdate(ctx context.Context, screen *sstore.ScreenType, infoMsg string) (scbus.UpdatePacket, error) {
+	err := sstore.ScreenUpdateViewOpts(ctx, screen.ScreenId, screen.ScreenViewOpts)
+	if err != nil {
+		return nil, fmt.Errorf("error updating screenviewopts: %v", err)
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(*screen)
+	update.AddUpdate(sstore.InfoMsgType{InfoMsg: infoMsg, TimeoutMs: 2000})
+	return update, nil
+}
+
+// /screen:filter [name] or /screen:filter tags=a,b.  no args clears the filter
+func ScreenFilterCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	screen, err := sstore.GetScreenById(ctx, ids.ScreenId)
+	if err != nil {
+		return nil, fmt.Errorf("/screen:filter cannot get screen: %v", err)
+	}
+	if screen == nil {
+		return nil, fmt.Errorf("screen not found")
+	}
+	if tagsStr, found := pk.Kwargs["tags"]; found {
+		tags, err := resolveLineTags([]string{tagsStr})
+		if err != nil {
+			return nil, err
+		}
+		if len(tags) == 0 {
+			return nil, fmt.Errorf("/screen:filter tags= requires at least one tag")
+		}
+		screen.ScreenViewOpts.ActiveFilter = &sstore.ScreenFilterType{Tags: tags}
+		return screenViewOptsUpdate(ctx, screen, fmt.Sprintf("showing lines tagged %s", formatStrs(tags, "or", false)))
+	}
+	if len(pk.Args) == 0 {
+		screen.ScreenViewOpts.ActiveFilter = nil
+		return screenViewOptsUpdate(ctx, screen, "filter cleared")
+	}
+	filter := screen.ScreenViewOpts.GetFilter(pk.Args[0])
+	if filter == nil {
+		return nil, fmt.Errorf("filter %q not found", pk.Args[0])
+	}
+	filterCopy := *filter
+	screen.ScreenViewOpts.ActiveFilter = &filterCopy
+	return screenViewOptsUpdate(ctx, screen, fmt.Sprintf("filter %q applied", filter.Name))
+}
+
+// /screen:savefilter [name] tags=a,b (replaces an existing filter with the same name)
+func ScreenSaveFilterCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) == 0 || pk.Kwargs["tags"] == "" {
+		return nil, fmt.Errorf("usage: /screen:savefilter [name] tags=[tag1,tag2,...]")
+	}
+	name := strings.TrimSpace(pk.Args[0])
+	if name == "" || len(name) > MaxScreenFilterNameLen {
+		return nil, fmt.Errorf("invalid filter name (max %d characters)", MaxScreenFilterNameLen)
+	}
+	tags, err := resolveLineTags([]string{pk.Kwargs["tags"]})
+	if err != nil {
+		return nil, err
+	}
+	screen, err := sstore.GetScreenById(ctx, ids.ScreenId)
+	if err != nil {
+		return nil, fmt.Errorf("/screen:savefilter cannot get screen: %v", err)
+	}
+	if screen == nil {
+		return nil, fmt.Errorf("screen not found")
+	}
+	newFilter := sstore.ScreenFilterType{Name: name, Tags: tags}
+	if existing := screen.ScreenViewOpts.GetFilter(name); existing != nil {
+		*existing = newFilter
+	} else {
+		if len(screen.ScreenViewOpts.Filters) >= MaxScreenFilters {
+			return nil, fmt.Errorf("cannot save more than %d filters per screen", MaxScreenFilters)
+		}
+		screen.ScreenViewOpts.Filters = append(screen.ScreenViewOpts.Filters, newFilter)
+	}
+	return screenViewOptsUpdate(ctx, screen, fmt.Sprintf("filter %q saved", name))
+}
+
+func ScreenDeleteFilterCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf("/screen:deletefilter requires an argument (filter name)")
+	}
+	screen, err := sstore.GetScreenById(ctx, ids.ScreenId)
+	if err != nil {
+		return nil, fmt.Errorf("/screen:deletefilter cannot get screen: %v", err)
+	}
+	if screen == nil {
+		return nil, fmt.Errorf("screen not found")
+	}
+	name := pk.Args[0]
+	if screen.ScreenViewOpts.GetFilter(name) == nil {
+		return nil, fmt.Errorf("filter %q not found", name)
+	}
+	var newFilters []sstore.ScreenFilterType
+	for _, filter := range screen.ScreenViewOpts.Filters {
+		if filter.Name != name {
+			newFilters = append(newFilters, filter)
+		}
+	}
+	screen.ScreenViewOpts.Filters = newFilters
+	if screen.ScreenViewOpts.ActiveFilter != nil && screen.ScreenViewOpts.ActiveFilter.Name == name {
+		screen.ScreenViewOpts.ActiveFilter = nil
+	}
+	return screenViewOptsUpdate(ctx, screen, fmt.Sprintf("filter %q deleted", name))
+}
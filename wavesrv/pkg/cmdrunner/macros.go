@@ -0,0 +1,369 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/base"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/macros"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scws"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// running macros (see pkg/macros).  a macro runs in the background on a screen, step delays are scaled by
+// speed.  input steps go to the command started by the macro's last command step (or to the screen's only
+// running command).  a failed step stops the macro and shows an error on the screen.
+
+const MacroPromptPollTime = 200 * time.Millisecond
+const MacroAwaitTimeout = 10 * time.Minute
+const MacroStepTimeout = 30 * time.Second
+
+type runningMacro struct {
+	ScreenId  string
+	MacroName string
+	CancelFn  context.CancelFunc
+}
+
+var macroRunLock = &sync.Mutex{}
+var macroRunMap = make(map[string]*runningMacro) // runid -> macro
+
+// waits until no commands are running on the screen
+func awaitScreenPrompt(ctx context.Context, screenId string) error {
+	timeoutCtx, cancelFn := context.WithTimeout(ctx, MacroAwaitTimeout)
+	defer cancelFn()
+	for {
+		runningCmds, err := sstore.GetRunningScreenCmds(timeoutCtx, screenId)
+		if err != nil {
+			return err
+		}
+		if len(runningCmds) == 0 {
+			return nil
+		}
+		select {
+		case <-timeoutCtx.Done():
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("timeout waiting for the prompt")
+		case <-time.After(MacroPromptPollTime):
+		}
+	}
+}
+
+// lineId is the line started by the macro's last command step ("" if there was none)
+func sendMacroInput(ctx context.Context, screenId string, lineId string, step macros.MacroStepType) error {
+	var cmd *sstore.CmdType
+	if lineId != "" {
+		lineCmd, err := sstore.GetCmdByScreenId(ctx, screenId, lineId)
+		if err != nil {
+			return err
+		}
+		cmd = lineCmd
+	} else {
+		runningCmds, err := sstore.GetRunningScreenCmds(ctx, screenId)
+		if err != nil {
+			return err
+		}
+		if len(runningCmds) > 1 {
+			return fmt.Errorf("cannot send input, more than one command is running")
+		}
+		if len(runningCmds) == 1 {
+			cmd = runningCmds[0]
+		}
+	}
+	if cmd == nil || !cmd.IsRunning() {
+		return fmt.Errorf("cannot send input, no running command")
+	}
+	inputPk := scpacket.MakeFeInputPacket()
+	inputPk.CK = base.MakeCommandKey(screenId, cmd.LineId)
+	inputPk.Remote = scpacket.RemotePtrType{OwnerId: cmd.Remote.OwnerId, RemoteId: cmd.Remote.RemoteId, Name: cmd.Remote.Name}
+	inputPk.InputData64 = step.InputData64
+	inputPk.SigName = step.SigName
+	return scws.EnqueueCmdInput(inputPk)
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// the delay of a step counts from the previous step, time spent waiting for the prompt is part of the delay
+func runMacroSteps(ctx context.Context, screenId string, macro *macros.MacroType, speed float64) error {
+	var lastLineId string
+	lastStepTime := time.Now()
+	for idx, step := range macro.Steps {
+		if step.AwaitPrompt {
+			err := awaitScreenPrompt(ctx, screenId)
+			if err != nil {
+				return fmt.Errorf("step %d: %w", idx+1, err)
+			}
+		}
+		delay := time.Duration(float64(step.DelayMs)/speed) * time.Millisecond
+		err := sleepCtx(ctx, time.Until(lastStepTime.Add(delay)))
+		if err != nil {
+			return err
+		}
+		lastStepTime = time.Now()
+		stepCtx, cancelFn := context.WithTimeout(ctx, MacroStepTimeout)
+		switch step.Kind {
+		case macros.StepKind_Cmd:
+			var lineId string
+			lineId, err = EvalScreenCommand(stepCtx, screenId, step.CmdStr)
+			if lineId != "" {
+				lastLineId = lineId
+			}
+		case macros.StepKind_Input:
+			err = sendMacroInput(stepCtx, screenId, lastLineId, step)
+		default:
+			err = fmt.Errorf("invalid step kind %q", step.Kind)
+		}
+		cancelFn()
+		if err != nil {
+			return fmt.Errorf("step %d: %w", idx+1, err)
+		}
+	}
+	return nil
+}
+
+// returns the runid
+func startMacro(screenId string, macro *macros.MacroType, speed float64) string {
+	runId := scbase.GenWaveUUID()
+	ctx, cancelFn := context.WithCancel(context.Background())
+	macroRunLock.Lock()
+	macroRunMap[runId] = &runningMacro{ScreenId: screenId, MacroName: macro.Name, CancelFn: cancelFn}
+	macroRunLock.Unlock()
+	go func() {
+		defer func() {
+			macroRunLock.Lock()
+			delete(macroRunMap, runId)
+			macroRunLock.Unlock()
+			cancelFn()
+		}()
+		err := runMacroSteps(ctx, screenId, macro, speed)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("[macro] error running macro %q: %v\n", macro.Name, err)
+			update := scbus.MakeUpdatePacket()
+			update.AddUpdate(sstore.InfoMsgType{InfoError: fmt.Sprintf("macro %q stopped, %v", macro.Name, err)})
+			scbus.MainUpdateBus.DoScreenUpdate(screenId, update)
+		}
+	}()
+	return runId
+}
+
+// stops the running macro (or all the running macros of the screen if runId is ""), returns the number stopped
+func stopMacros(screenId string, runId string) int {
+	macroRunLock.Lock()
+	defer macroRunLock.Unlock()
+	numStopped := 0
+	for id, rm := range macroRunMap {
+		if rm.ScreenId != screenId || (runId != "" && id != runId) {
+			continue
+		}
+		rm.CancelFn()
+		delete(macroRunMap, id)
+		numStopped++
+	}
+	return numStopped
+}
+
+func formatMacroStep(step macros.MacroStepType) string {
+	switch step.Kind {
+	case macros.StepKind_Cmd:
+		return fmt.Sprintf("cmd %s", step.CmdStr)
+	case macros.StepKind_Input:
+		if step.SigName != "" {
+			return fmt.Sprintf("signal %s", step.SigName)
+		}
+		return fmt.Sprintf("input %s", step.InputData64)
+	}
+	return step.Kind
+}
+
+func resolveMacro(ctx context.Context, pk *scpacket.FeCommandPacketType) (*macros.MacroType, error) {
+	if len(pk.Args) != 1 {
+		return nil, fmt.Errorf("%s requires a macro name", GetCmdStr(pk))
+	}
+	macro, err := macros.GetMacroByArg(ctx, pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("%s error getting macro: %v", GetCmdStr(pk), err)
+	}
+	if macro == nil {
+		return nil, fmt.Errorf("%s macro %q not found", GetCmdStr(pk), pk.Args[0])
+	}
+	return macro, nil
+}
+
+// /macro:record [name], records the commands and input on this screen until /macro:save (or /macro:cancel)
+func MacroRecordCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) != 1 {
+		return nil, fmt.Errorf("usage: /macro:record [name]")
+	}
+	err = macros.StartRecording(ids.ScreenId, pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("/macro:record %v", err)
+	}
+	return sstore.InfoMsgUpdate("recording macro %q, save it with /macro:save", pk.Args[0]), nil
+}
+
+// /macro:save [description=...]
+func MacroSaveCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	name, steps, ok := macros.StopRecording(ids.ScreenId)
+	if !ok {
+		return nil, fmt.Errorf("/macro:save not recording a macro on this screen")
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("/macro:save nothing was recorded, macro %q not saved", name)
+	}
+	// the first step runs right away
+	steps[0].DelayMs = 0
+	macro := &macros.MacroType{
+		MacroId:     scbase.GenWaveUUID(),
+		Name:        name,
+		Description: pk.Kwargs["description"],
+		Steps:       steps,
+		CreatedTs:   time.Now().UnixMilli(),
+	}
+	err = macros.SaveMacro(ctx, macro)
+	if err != nil {
+		return nil, fmt.Errorf("/macro:save error saving macro: %v", err)
+	}
+	return sstore.InfoMsgUpdate("macro %q saved (%d steps), run it with /macro:run %s", name, len(steps), name), nil
+}
+
+// /macro:cancel, stops recording without saving
+func MacroCancelCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	name, _, ok := macros.StopRecording(ids.ScreenId)
+	if !ok {
+		return nil, fmt.Errorf("/macro:cancel not recording a macro on this screen")
+	}
+	return sstore.InfoMsgUpdate("recording of macro %q canceled", name), nil
+}
+
+// /macro:run [name] [speed=1]
+func MacroRunCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	macro, err := resolveMacro(ctx, pk)
+	if err != nil {
+		return nil, err
+	}
+	speed, err := resolvePlaybackSpeed(pk.Kwargs["speed"])
+	if err != nil {
+		return nil, fmt.Errorf("/macro:run %v", err)
+	}
+	if macros.IsRecording(ids.ScreenId) {
+		return nil, fmt.Errorf("/macro:run cannot run a macro while recording one on this screen")
+	}
+	runId := startMacro(ids.ScreenId, macro, speed)
+	err = macros.SetMacroLastRun(ctx, macro.MacroId, time.Now().UnixMilli())
+	if err != nil {
+		log.Printf("[macro] error setting lastrunts: %v\n", err)
+	}
+	return sstore.InfoMsgUpdate("running macro %q (runid %s)", macro.Name, runId), nil
+}
+
+// /macro:stop [runid], stops all the running macros of the screen if no runid is given
+func MacroStopCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) > 1 {
+		return nil, fmt.Errorf("usage: /macro:stop [runid]")
+	}
+	var runId string
+	if len(pk.Args) == 1 {
+		runId = pk.Args[0]
+	}
+	numStopped := stopMacros(ids.ScreenId, runId)
+	if numStopped == 0 {
+		return nil, fmt.Errorf("/macro:stop no running macro")
+	}
+	return sstore.InfoMsgUpdate("stopped %d macro(s)", numStopped), nil
+}
+
+// /macro:show [name], lists the macros or shows the steps of one macro
+func MacroShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	var buf bytes.Buffer
+	if len(pk.Args) > 0 {
+		macro, err := resolveMacro(ctx, pk)
+		if err != nil {
+			return nil, err
+		}
+		if macro.Description != "" {
+			buf.WriteString(fmt.Sprintf("  %s\n", macro.Description))
+		}
+		for idx, step := range macro.Steps {
+			awaitStr := ""
+			if step.AwaitPrompt {
+				awaitStr = " (await prompt)"
+			}
+			buf.WriteString(fmt.Sprintf("  %3d  +%-8s %s%s\n", idx+1, fmt.Sprintf("%dms", step.DelayMs), formatMacroStep(step), awaitStr))
+		}
+		update := scbus.MakeUpdatePacket()
+		update.AddUpdate(sstore.InfoMsgType{InfoTitle: fmt.Sprintf("macro %s", macro.Name), InfoLines: splitLinesForInfo(buf.String())})
+		return update, nil
+	}
+	allMacros, err := macros.GetMacros(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("/macro:show error getting macros: %v", err)
+	}
+	if len(allMacros) == 0 {
+		return sstore.InfoMsgUpdate("no macros, record one with /macro:record [name]"), nil
+	}
+	for _, macro := range allMacros {
+		lastRunStr := "never"
+		if macro.LastRunTs > 0 {
+			lastRunStr = formatTs(ctx, time.UnixMilli(macro.LastRunTs))
+		}
+		buf.WriteString(fmt.Sprintf("  %-20s %3d steps  lastrun %-20s %s\n", macro.Name, len(macro.Steps), lastRunStr, macro.Description))
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{InfoTitle: "macros", InfoLines: splitLinesForInfo(buf.String())})
+	return update, nil
+}
+
+func MacroDeleteCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	macro, err := resolveMacro(ctx, pk)
+	if err != nil {
+		return nil, err
+	}
+	err = macros.DeleteMacro(ctx, macro.MacroId)
+	if err != nil {
+		return nil, fmt.Errorf("/macro:delete error deleting macro: %v", err)
+	}
+	return sstore.InfoMsgUpdate("macro %q deleted", macro.Name), nil
+}
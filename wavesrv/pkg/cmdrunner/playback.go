@@ -0,0 +1,246 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// playback streams the recorded output of lines (see sstore/playback.go) to the frontend as "playback"
+// updates, with the original timing scaled by speed.  idle time between events is capped at maxidle (ms,
+// 0 for no limit).  playbacks run until they finish or are stopped with /playback:stop.
+
+const MinPlaybackSpeed = 0.1
+const MaxPlaybackSpeed = 100
+
+type playbackItem struct {
+	Line *sstore.LineType
+	Cmd  *sstore.CmdType
+}
+
+type runningPlayback struct {
+	ScreenId string
+	CancelFn context.CancelFunc
+}
+
+var playbackLock = &sync.Mutex{}
+var playbackMap = make(map[string]*runningPlayback) // playbackid -> playback
+
+func resolvePlaybackSpeed(arg string) (float64, error) {
+	if arg == "" {
+		return 1, nil
+	}
+	speed, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid speed %q: %v", arg, err)
+	}
+	if speed < MinPlaybackSpeed || speed > MaxPlaybackSpeed {
+		return 0, fmt.Errorf("speed must be between %v and %v", MinPlaybackSpeed, MaxPlaybackSpeed)
+	}
+	return speed, nil
+}
+
+func sendPlaybackUpdate(pbUpdate sstore.PlaybackUpdateType) {
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(pbUpdate)
+	scbus.MainUpdateBus.DoScreenUpdate(pbUpdate.ScreenId, update)
+}
+
+// returns the playbackid
+func startPlayback(screenId string, items []playbackItem, speed float64, maxIdleMs int64) string {
+	playbackId := scbase.GenWaveUUID()
+	ctx, cancelFn := context.WithCancel(context.Background())
+	playbackLock.Lock()
+	playbackMap[playbackId] = &runningPlayback{ScreenId: screenId, CancelFn: cancelFn}
+	playbackLock.Unlock()
+	go func() {
+		defer func() {
+			playbackLock.Lock()
+			delete(playbackMap, playbackId)
+			playbackLock.Unlock()
+			cancelFn()
+		}()
+		for _, item := range items {
+			err := playbackLine(ctx, playbackId, item, speed, maxIdleMs)
+			if err != nil {
+				log.Printf("[playback] error playing back line %s: %v\n", item.Line.LineId, err)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+	return playbackId
+}
+
+// stops the playback (or all the playbacks of the screen if playbackId is ""), returns the number stopped
+func stopPlaybacks(screenId string, playbackId string) int {
+	playbackLock.Lock()
+	defer playbackLock.Unlock()
+	numStopped := 0
+	for id, pb := range playbackMap {
+		if pb.ScreenId != screenId || (playbackId != "" && id != playbackId) {
+			continue
+		}
+		pb.CancelFn()
+		delete(playbackMap, id)
+		numStopped++
+	}
+	return numStopped
+}
+
+// the terminal is reset at the start and shows the full output again at the end (also if the playback is stopped)
+func playbackLine(ctx context.Context, playbackId string, item playbackItem, speed float64, maxIdleMs int64) error {
+	startTs := sstore.CmdStartTs(item.Line, item.Cmd)
+	events, err := sstore.GetCmdPtyEvents(ctx, item.Cmd, startTs)
+	if err != nil {
+		return err
+	}
+	baseUpdate := sstore.PlaybackUpdateType{PlaybackId: playbackId, ScreenId: item.Line.ScreenId, LineId: item.Line.LineId}
+	resetUpdate := baseUpdate
+	resetUpdate.Reset = true
+	sendPlaybackUpdate(resetUpdate)
+	defer func() {
+		doneUpdate := baseUpdate
+		doneUpdate.Done = true
+		sendPlaybackUpdate(doneUpdate)
+	}()
+	lastTs := startTs
+	for _, event := range events {
+		deltaMs := event.Ts - lastTs
+		if maxIdleMs > 0 && deltaMs > maxIdleMs {
+			deltaMs = maxIdleMs
+		}
+		lastTs = event.Ts
+		if deltaMs > 0 {
+			timer := time.NewTimer(time.Duration(float64(deltaMs)/speed) * time.Millisecond)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			case <-timer.C:
+			}
+		} else if ctx.Err() != nil {
+			return nil
+		}
+		dataUpdate := baseUpdate
+		dataUpdate.Data64 = base64.StdEncoding.EncodeToString(event.Data)
+		sendPlaybackUpdate(dataUpdate)
+	}
+	return nil
+}
+
+// /line:playback [line] [speed=1] [maxidle=0], the line defaults to the selected line
+func LinePlaybackCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) > 1 {
+		return nil, fmt.Errorf("usage: /line:playback [line] [speed=1] [maxidle=ms]")
+	}
+	speed, err := resolvePlaybackSpeed(pk.Kwargs["speed"])
+	if err != nil {
+		return nil, fmt.Errorf("/line:playback %v", err)
+	}
+	maxIdleMs, err := resolveNonNegInt(pk.Kwargs["maxidle"], 0)
+	if err != nil {
+		return nil, fmt.Errorf("/line:playback invalid maxidle: %v", err)
+	}
+	var lineId string
+	if len(pk.Args) == 1 {
+		lineId, err = sstore.FindLineIdByArg(ctx, ids.ScreenId, pk.Args[0])
+		if err != nil {
+			return nil, fmt.Errorf("error looking up lineid: %v", err)
+		}
+	} else {
+		lineId, err = sstore.GetScreenSelectedLineId(ctx, ids.ScreenId)
+		if err != nil {
+			return nil, fmt.Errorf("error getting selected lineid: %v", err)
+		}
+	}
+	if lineId == "" {
+		return nil, fmt.Errorf("/line:playback requires a line")
+	}
+	line, cmd, err := sstore.GetLineCmdByLineId(ctx, ids.ScreenId, lineId)
+	if err != nil {
+		return nil, fmt.Errorf("/line:playback error getting line: %v", err)
+	}
+	if line == nil {
+		return nil, fmt.Errorf("/line:playback line not found")
+	}
+	if cmd == nil {
+		return nil, fmt.Errorf("/line:playback line %d is not a command", line.LineNum)
+	}
+	playbackId := startPlayback(ids.ScreenId, []playbackItem{{Line: line, Cmd: cmd}}, speed, int64(maxIdleMs))
+	return sstore.InfoMsgUpdate("playing back line %d (playbackid %s)", line.LineNum, playbackId), nil
+}
+
+// /screen:playback [speed=1] [maxidle=2000], plays back the (non-archived) cmd lines in order
+func ScreenPlaybackCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	speed, err := resolvePlaybackSpeed(pk.Kwargs["speed"])
+	if err != nil {
+		return nil, fmt.Errorf("/screen:playback %v", err)
+	}
+	maxIdleMs, err := resolveNonNegInt(pk.Kwargs["maxidle"], sstore.AsciinemaScreenMaxIdleMs)
+	if err != nil {
+		return nil, fmt.Errorf("/screen:playback invalid maxidle: %v", err)
+	}
+	screenLines, err := sstore.GetScreenLinesById(ctx, ids.ScreenId)
+	if err != nil {
+		return nil, fmt.Errorf("/screen:playback error getting lines: %v", err)
+	}
+	cmdMap := make(map[string]*sstore.CmdType)
+	for _, cmd := range screenLines.Cmds {
+		cmdMap[cmd.LineId] = cmd
+	}
+	var items []playbackItem
+	for _, line := range screenLines.Lines {
+		cmd := cmdMap[line.LineId]
+		if line.Archived || cmd == nil {
+			continue
+		}
+		items = append(items, playbackItem{Line: line, Cmd: cmd})
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("/screen:playback screen has no commands to play back")
+	}
+	playbackId := startPlayback(ids.ScreenId, items, speed, int64(maxIdleMs))
+	return sstore.InfoMsgUpdate("playing back %d command(s) (playbackid %s)", len(items), playbackId), nil
+}
+
+// /playback:stop [playbackid], stops all the playbacks of the screen if no playbackid is given
+func PlaybackStopCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) > 1 {
+		return nil, fmt.Errorf("usage: /playback:stop [playbackid]")
+	}
+	var playbackId string
+	if len(pk.Args) == 1 {
+		playbackId = pk.Args[0]
+	}
+	numStopped := stopPlaybacks(ids.ScreenId, playbackId)
+	if numStopped == 0 {
+		return nil, fmt.Errorf("/playback:stop no running playback")
+	}
+	return sstore.InfoMsgUpdate("stopped %d playback(s)", numStopped), nil
+}
@@ -0,0 +1,24 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"testing"
+)
+
+func TestResolvePlaybackSpeed(t *testing.T) {
+	speed, err := resolvePlaybackSpeed("")
+	if err != nil || speed != 1 {
+		t.Errorf("default speed should be 1: %v %v", speed, err)
+	}
+	speed, err = resolvePlaybackSpeed("2.5")
+	if err != nil || speed != 2.5 {
+		t.Errorf("invalid speed 2.5: %v %v", speed, err)
+	}
+	for _, arg := range []string{"0", "0.05", "101", "fast"} {
+		if _, err := resolvePlaybackSpeed(arg); err == nil {
+			t.Errorf("speed %q should be invalid", arg)
+		}
+	}
+}
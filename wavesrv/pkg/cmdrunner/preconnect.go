@@ -0,0 +1,162 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+const MaxPreConnectHooks = 10
+const MaxPreConnectCmdLen = 1000
+
+// parses a waitport argument, "[host:]port", "host", or "" (all parts default to the remote's ssh host/port)
+func parseWaitPortArg(arg string) (string, int, error) {
+	if arg == "" {
+		return "", 0, nil
+	}
+	host, portStr, err := net.SplitHostPort(arg)
+	if err != nil {
+		if _, convErr := strconv.Atoi(arg); convErr == nil {
+			host, portStr = "", arg
+		} else {
+			host, portStr = arg, ""
+		}
+	}
+	var port int
+	if portStr != "" {
+		port, err = strconv.Atoi(portStr)
+		if err != nil || port <= 0 || port > 65535 {
+			return "", 0, fmt.Errorf("invalid port %q", portStr)
+		}
+	}
+	return host, port, nil
+}
+
+// returns nil if no hook was specified
+func parsePreConnectHookArgs(pk *scpacket.FeCommandPacketType) (*sstore.PreConnectHookType, error) {
+	var hook *sstore.PreConnectHookType
+	numHooks := 0
+	if macStr, found := pk.Kwargs["wol"]; found {
+		numHooks++
+		_, err := remote.ParseMacAddr(macStr)
+		if err != nil {
+			return nil, err
+		}
+		hook = &sstore.PreConnectHookType{HookType: sstore.PreConnectHook_WakeOnLan, MacAddr: macStr, BroadcastAddr: pk.Kwargs["broadcast"]}
+	}
+	if cmdStr, found := pk.Kwargs["cmd"]; found {
+		numHooks++
+		if strings.TrimSpace(cmdStr) == "" {
+			return nil, fmt.Errorf("cmd cannot be empty")
+		}
+		if len(cmdStr) > MaxPreConnectCmdLen {
+			return nil, fmt.Errorf("cmd too long (max %d characters)", MaxPreConnectCmdLen)
+		}
+		hook = &sstore.PreConnectHookType{HookType: sstore.PreConnectHook_Cmd, Command: cmdStr}
+	}
+	if waitPortStr, found := pk.Kwargs["waitport"]; found {
+		numHooks++
+		host, port, err := parseWaitPortArg(waitPortStr)
+		if err != nil {
+			return nil, err
+		}
+		hook = &sstore.PreConnectHookType{HookType: sstore.PreConnectHook_WaitPort, Host: host, Port: port}
+	}
+	if numHooks > 1 {
+		return nil, fmt.Errorf("only one of wol, cmd, or waitport can be added at a time")
+	}
+	if hook == nil {
+		if pk.Kwargs["broadcast"] != "" || pk.Kwargs["timeout"] != "" {
+			return nil, fmt.Errorf("broadcast and timeout must be specified with a hook (wol, cmd, or waitport)")
+		}
+		return nil, nil
+	}
+	if pk.Kwargs["broadcast"] != "" && hook.HookType != sstore.PreConnectHook_WakeOnLan {
+		return nil, fmt.Errorf("broadcast can only be specified with wol")
+	}
+	timeout, err := resolvePosInt(pk.Kwargs["timeout"], 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timeout: %v", err)
+	}
+	if timeout > int(remote.MaxPreConnectTimeout.Seconds()) {
+		return nil, fmt.Errorf("timeout too long (max %ds)", int(remote.MaxPreConnectTimeout.Seconds()))
+	}
+	hook.Timeout = timeout
+	return hook, nil
+}
+
+// /remote:preconnect [wol=mac [broadcast=addr]] [cmd=command] [waitport=[host:]port] [timeout=secs] [remove=n] [clear=1]
+// no args shows the hooks
+func RemotePreConnectCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, err
+	}
+	remoteCopy := ids.Remote.RemoteCopy
+	var hooks []*sstore.PreConnectHookType
+	if remoteCopy.RemoteOpts != nil {
+		hooks = remoteCopy.RemoteOpts.PreConnect
+	}
+	newHook, err := parsePreConnectHookArgs(pk)
+	if err != nil {
+		return nil, fmt.Errorf("/remote:preconnect %v", err)
+	}
+	var updated bool
+	if resolveBool(pk.Kwargs["clear"], false) {
+		hooks = nil
+		updated = true
+	}
+	if removeStr, found := pk.Kwargs["remove"]; found {
+		removeIdx, err := resolvePosInt(removeStr, 0)
+		if err != nil || removeIdx < 1 || removeIdx > len(hooks) {
+			return nil, fmt.Errorf("/remote:preconnect invalid remove index %q (remote has %d hooks)", removeStr, len(hooks))
+		}
+		hooks = append(append([]*sstore.PreConnectHookType{}, hooks[0:removeIdx-1]...), hooks[removeIdx:]...)
+		updated = true
+	}
+	if newHook != nil {
+		if remoteCopy.IsLocal() {
+			return nil, fmt.Errorf("/remote:preconnect cannot add pre-connect hooks to a local remote")
+		}
+		if len(hooks) >= MaxPreConnectHooks {
+			return nil, fmt.Errorf("/remote:preconnect too many hooks (max %d)", MaxPreConnectHooks)
+		}
+		hooks = append(append([]*sstore.PreConnectHookType{}, hooks...), newHook)
+		updated = true
+	}
+	if updated {
+		editMap := map[string]interface{}{sstore.RemoteField_PreConnect: hooks}
+		err = ids.Remote.Waveshell.UpdateRemote(ctx, editMap)
+		if err != nil {
+			return nil, fmt.Errorf("/remote:preconnect error updating remote: %v", err)
+		}
+	}
+	var buf bytes.Buffer
+	if len(hooks) == 0 {
+		buf.WriteString("no pre-connect hooks\n")
+	}
+	for idx, hook := range hooks {
+		timeoutStr := "default"
+		if hook.Timeout > 0 {
+			timeoutStr = fmt.Sprintf("%ds", hook.Timeout)
+		}
+		buf.WriteString(fmt.Sprintf("  %d. %s (timeout %s)\n", idx+1, remote.DescribePreConnectHook(hook, remoteCopy.SSHOpts), timeoutStr))
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: fmt.Sprintf("pre-connect hooks for remote %q", ids.Remote.DisplayName),
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
@@ -0,0 +1,31 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"testing"
+)
+
+func TestParseWaitPortArg(t *testing.T) {
+	tests := []struct {
+		arg  string
+		host string
+		port int
+	}{
+		{"", "", 0},
+		{"2222", "", 2222},
+		{"myhost", "myhost", 0},
+		{"myhost:2222", "myhost", 2222},
+		{"[::1]:22", "::1", 22},
+	}
+	for _, test := range tests {
+		host, port, err := parseWaitPortArg(test.arg)
+		if err != nil || host != test.host || port != test.port {
+			t.Errorf("parseWaitPortArg(%q) = %q, %d, %v", test.arg, host, port, err)
+		}
+	}
+	if _, _, err := parseWaitPortArg("myhost:99999"); err == nil {
+		t.Errorf("expected error for invalid port")
+	}
+}
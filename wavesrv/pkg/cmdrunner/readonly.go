@@ -0,0 +1,120 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// commands that can run in read-only mode (see scbase.IsReadOnly), all others return a read-only error.
+// switching sessions/screens is allowed (it only changes which one is active) so the data can be browsed.
+// commands with optional writes (client:doctor fix=1, history:repair fix=1) check read-only mode themselves.
+var readOnlyCmds = map[string]bool{
+	"_compgen":            true,
+	"_compfiledir":        true,
+	"_suggestdirs":        true,
+	"_suggest":            true,
+	"_compspec":           true,
+	"_dumpstate":          true,
+	"_killserver":         true,
+	"mainview":            true,
+	"session":             true,
+	"session:show":        true,
+	"session:showall":     true,
+	"screen":              true,
+	"screen:show":         true,
+	"screen:showall":      true,
+	"screen:panes":        true,
+	"screen:exportcast":   true,
+	"screen:playback":     true,
+	"screen:lanshare":     true,
+	"screen:transcript":   true,
+	"window:showall":      true,
+	"remote":              true,
+	"remote:show":         true,
+	"remote:showall":      true,
+	"remote:health":       true,
+	"remote:metrics":      true,
+	"remotegroup:showall": true,
+	"line":                true,
+	"line:show":           true,
+	"line:tagged":         true,
+	"line:exportcast":     true,
+	"line:saveraw":        true,
+	"line:playback":       true,
+	"playback:stop":       true,
+	"client":              true,
+	"client:show":         true,
+	"client:doctor":       true,
+	"client:bulkops":      true,
+	"client:exportvault":  true,
+	"client:backup":       true,
+	"client:backups":      true,
+	"client:readonly":     true,
+	"history":             true,
+	"history:viewall":     true,
+	"history:screens":     true,
+	"history:repair":      true,
+	"history:top":         true,
+	"history:stats":       true,
+	"history:sync:show":   true,
+	"trash:show":          true,
+	"transfer:history":    true,
+	"foreigndb:attach":    true,
+	"foreigndb:detach":    true,
+	"foreigndb:show":      true,
+	"foreigndb:lines":     true,
+	"bookmarks:show":      true,
+	"bookmarks:export":    true,
+	"webhook":             true,
+	"webhook:show":        true,
+	"webhook:log":         true,
+	"schedule":            true,
+	"schedule:show":       true,
+	"macro":               true,
+	"macro:show":          true,
+	"template":            true,
+	"template:show":       true,
+	"envprofile":          true,
+	"envprofile:show":     true,
+	"dirbookmark":         true,
+	"dirbookmark:show":    true,
+	"snippet":             true,
+	"snippet:show":        true,
+	"alias":               true,
+	"alias:show":          true,
+	"sudo:status":         true,
+	"telemetry:show":      true,
+}
+
+func checkReadOnlyCmd(cmdName string) error {
+	if readOnlyCmds[cmdName] {
+		return nil
+	}
+	return scbase.CheckReadOnly("/" + cmdName)
+}
+
+// /client:readonly [on|off]
+func ClientReadOnlyCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if len(pk.Args) > 0 {
+		switch pk.Args[0] {
+		case "on", "1":
+			scbase.SetReadOnly(true)
+		case "off", "0":
+			scbase.SetReadOnly(false)
+		default:
+			return nil, fmt.Errorf("usage: /client:readonly [on|off]")
+		}
+	}
+	if scbase.IsReadOnly() {
+		return sstore.InfoMsgUpdate("read-only mode is on, commands that change data are disabled"), nil
+	}
+	return sstore.InfoMsgUpdate("read-only mode is off"), nil
+}
@@ -0,0 +1,31 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"testing"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/base"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+)
+
+func TestCheckReadOnlyCmd(t *testing.T) {
+	defer scbase.SetReadOnly(false)
+	if err := checkReadOnlyCmd("screen:delete"); err != nil {
+		t.Errorf("screen:delete should be allowed when not read-only: %v", err)
+	}
+	scbase.SetReadOnly(true)
+	err := checkReadOnlyCmd("screen:delete")
+	if err == nil || !scbase.IsReadOnlyError(err) || base.GetErrorCode(err) != scbase.ErrorCode_ReadOnly {
+		t.Errorf("screen:delete should return a read-only error: %v", err)
+	}
+	for cmdName := range readOnlyCmds {
+		if MetaCmdFnMap[cmdName].Fn == nil {
+			t.Errorf("read-only command %q is not registered", cmdName)
+		}
+		if err := checkReadOnlyCmd(cmdName); err != nil {
+			t.Errorf("%s should be allowed in read-only mode: %v", cmdName, err)
+		}
+	}
+}
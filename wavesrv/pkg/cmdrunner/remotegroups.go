@@ -0,0 +1,201 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+const RemoteGroupNone = "none"
+
+func resolveRemoteGroupArg(ctx context.Context, pk *scpacket.FeCommandPacketType, cmdStr string) (*sstore.RemoteGroupType, error) {
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf("%s requires a group argument", cmdStr)
+	}
+	group, err := sstore.ResolveRemoteGroup(ctx, pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("%s %v", cmdStr, err)
+	}
+	if group == nil {
+		return nil, fmt.Errorf("%s remote group %q not found", cmdStr, pk.Args[0])
+	}
+	return group, nil
+}
+
+// groups are listed in groupidx order followed by the ungrouped remotes.  rstates must be sorted
+// by remoteidx (see sortedRemoteRuntimeStates)
+func formatRemoteGroups(groups []*sstore.RemoteGroupType, rstates []remote.RemoteRuntimeState) string {
+	groupRemotes := make(map[string][]remote.RemoteRuntimeState)
+	for _, rstate := range rstates {
+		groupRemotes[rstate.GroupId] = append(groupRemotes[rstate.GroupId], rstate)
+	}
+	var buf bytes.Buffer
+	writeRemotes := func(rstates []remote.RemoteRuntimeState) {
+		for _, rstate := range rstates {
+			buf.WriteString(fmt.Sprintf("  %-12s %s\n", rstate.Status, getRemoteStateName(rstate)))
+		}
+	}
+	for _, group := range groups {
+		buf.WriteString(fmt.Sprintf("%s (%d)\n", group.Name, len(groupRemotes[group.GroupId])))
+		writeRemotes(groupRemotes[group.GroupId])
+	}
+	if len(groupRemotes[""]) > 0 {
+		buf.WriteString(fmt.Sprintf("[ungrouped] (%d)\n", len(groupRemotes[""])))
+		writeRemotes(groupRemotes[""])
+	}
+	return buf.String()
+}
+
+// /remotegroup:showall
+func RemoteGroupShowAllCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	groups, err := sstore.GetAllRemoteGroups(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("/remotegroup:showall %v", err)
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: "remote groups",
+		InfoLines: splitLinesForInfo(formatRemoteGroups(groups, sortedRemoteRuntimeStates())),
+	})
+	return update, nil
+}
+
+// /remotegroup:new [name]
+func RemoteGroupNewCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf("usage: /remotegroup:new [name]")
+	}
+	name := pk.Args[0]
+	err := validateName(name, "remote group")
+	if err != nil {
+		return nil, err
+	}
+	if name == RemoteGroupNone {
+		return nil, fmt.Errorf("/remotegroup:new invalid name %q", name)
+	}
+	group, err := sstore.CreateRemoteGroup(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("/remotegroup:new %v", err)
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(*group)
+	return update, nil
+}
+
+// /remotegroup:rename [group] name=[name]
+func RemoteGroupRenameCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	group, err := resolveRemoteGroupArg(ctx, pk, "/remotegroup:rename")
+	if err != nil {
+		return nil, err
+	}
+	name := pk.Kwargs["name"]
+	err = validateName(name, "remote group")
+	if err != nil {
+		return nil, err
+	}
+	if name == RemoteGroupNone {
+		return nil, fmt.Errorf("/remotegroup:rename invalid name %q", name)
+	}
+	group, err = sstore.RenameRemoteGroup(ctx, group.GroupId, name)
+	if err != nil {
+		return nil, fmt.Errorf("/remotegroup:rename %v", err)
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(*group)
+	return update, nil
+}
+
+// /remotegroup:delete [group]
+// the remotes in the group are not deleted, they become ungrouped
+func RemoteGroupDeleteCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	group, err := resolveRemoteGroupArg(ctx, pk, "/remotegroup:delete")
+	if err != nil {
+		return nil, err
+	}
+	err = remote.DeleteRemoteGroup(ctx, group.GroupId)
+	if err != nil {
+		return nil, fmt.Errorf("/remotegroup:delete %v", err)
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.RemoteGroupType{GroupId: group.GroupId, Remove: true})
+	return update, nil
+}
+
+// /remotegroup:reorder [group] index=[n]
+func RemoteGroupReorderCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	group, err := resolveRemoteGroupArg(ctx, pk, "/remotegroup:reorder")
+	if err != nil {
+		return nil, err
+	}
+	newIdx, err := resolvePosInt(pk.Kwargs["index"], 1)
+	if err != nil {
+		return nil, fmt.Errorf("/remotegroup:reorder invalid index: %v", err)
+	}
+	groups, err := sstore.SetRemoteGroupIdx(ctx, group.GroupId, newIdx)
+	if err != nil {
+		return nil, fmt.Errorf("/remotegroup:reorder %v", err)
+	}
+	update := scbus.MakeUpdatePacket()
+	for _, group := range groups {
+		update.AddUpdate(*group)
+	}
+	return update, nil
+}
+
+// /remote:group [group|none]
+// moves the remote into the group (at the end), "none" removes it from its group
+func RemoteGroupCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf("usage: /remote:group [group|%s]", RemoteGroupNone)
+	}
+	var groupId string
+	groupName := RemoteGroupNone
+	if pk.Args[0] != RemoteGroupNone {
+		group, err := resolveRemoteGroupArg(ctx, pk, "/remote:group")
+		if err != nil {
+			return nil, err
+		}
+		groupId = group.GroupId
+		groupName = group.Name
+	}
+	err = remote.SetRemoteGroup(ctx, ids.Remote.RemoteCopy.RemoteId, groupId)
+	if err != nil {
+		return nil, fmt.Errorf("/remote:group %v", err)
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoMsg:   fmt.Sprintf("remote %q moved to group %q", ids.Remote.DisplayName, groupName),
+		TimeoutMs: 2000,
+	})
+	return update, nil
+}
+
+// /remote:reorder index=[n]
+// the index is the position within the remote's group
+func RemoteReorderCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, err
+	}
+	newIdx, err := resolvePosInt(pk.Kwargs["index"], 1)
+	if err != nil {
+		return nil, fmt.Errorf("/remote:reorder invalid index: %v", err)
+	}
+	err = remote.ReorderRemote(ctx, ids.Remote.RemoteCopy.RemoteId, newIdx)
+	if err != nil {
+		return nil, fmt.Errorf("/remote:reorder %v", err)
+	}
+	return nil, nil
+}
@@ -0,0 +1,34 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func TestFormatRemoteGroups(t *testing.T) {
+	groups := []*sstore.RemoteGroupType{
+		{GroupId: "g1", Name: "prod", GroupIdx: 1},
+		{GroupId: "g2", Name: "staging", GroupIdx: 2},
+	}
+	rstates := []remote.RemoteRuntimeState{
+		{RemoteCanonicalName: "local", Status: "connected", RemoteIdx: 1},
+		{RemoteCanonicalName: "web1", Status: "disconnected", RemoteIdx: 2, GroupId: "g1"},
+		{RemoteCanonicalName: "web2", Status: "disconnected", RemoteIdx: 3, GroupId: "g1"},
+	}
+	lines := strings.Split(strings.TrimSpace(formatRemoteGroups(groups, rstates)), "\n")
+	expected := []string{"prod (2)", "web1", "web2", "staging (0)", "[ungrouped] (1)", "local"}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got %q", len(expected), lines)
+	}
+	for idx, line := range lines {
+		if !strings.Contains(line, expected[idx]) {
+			t.Errorf("line %d: expected %q in %q", idx, expected[idx], line)
+		}
+	}
+}
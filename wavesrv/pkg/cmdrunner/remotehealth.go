@@ -0,0 +1,60 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func formatHealthTs(tsFmt sstore.ClientTsFormat, ts int64) string {
+	if ts == 0 {
+		return "-"
+	}
+	return tsFmt.Format(time.UnixMilli(ts))
+}
+
+func formatRemoteHealth(health *sstore.RemoteHealthType, tsFmt sstore.ClientTsFormat) string {
+	var buf bytes.Buffer
+	if health.NumPings == 0 {
+		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "latency", "-"))
+	} else if health.LastLatencyMs < 0 {
+		buf.WriteString(fmt.Sprintf("  %-15s %s (avg %dms)\n", "latency", "no response", health.AvgLatencyMs))
+	} else {
+		buf.WriteString(fmt.Sprintf("  %-15s %dms (avg %dms)\n", "latency", health.LastLatencyMs, health.AvgLatencyMs))
+	}
+	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "last ping", formatHealthTs(tsFmt, health.LastPingTs)))
+	buf.WriteString(fmt.Sprintf("  %-15s %d (%d failed)\n", "pings", health.NumPings, health.NumFailedPings))
+	buf.WriteString(fmt.Sprintf("  %-15s %d (last %s)\n", "drops", health.NumDrops, formatHealthTs(tsFmt, health.LastDropTs)))
+	buf.WriteString(fmt.Sprintf("  %-15s %d (last %s)\n", "reconnects", health.NumReconnects, formatHealthTs(tsFmt, health.LastReconnectTs)))
+	if health.ReconnectTs != 0 {
+		buf.WriteString(fmt.Sprintf("  %-15s attempt %d at %s\n", "reconnecting", health.ReconnectAttempt, formatHealthTs(tsFmt, health.ReconnectTs)))
+	}
+	return buf.String()
+}
+
+// /remote:health
+func RemoteHealthCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, err
+	}
+	health, err := remote.GetRemoteHealth(ctx, ids.Remote.RemotePtr.RemoteId)
+	if err != nil {
+		return nil, fmt.Errorf("/remote:health %v", err)
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: fmt.Sprintf("connection health for %s", ids.Remote.DisplayName),
+		InfoLines: splitLinesForInfo(formatRemoteHealth(health, sstore.GetClientTsFormat(ctx))),
+	})
+	return update, nil
+}
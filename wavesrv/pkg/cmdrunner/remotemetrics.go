@@ -0,0 +1,80 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func formatMetricsBytes(numBytes int64) string {
+	const gb = 1024 * 1024 * 1024
+	if numBytes >= gb {
+		return fmt.Sprintf("%.1fG", float64(numBytes)/gb)
+	}
+	return fmt.Sprintf("%dM", numBytes/(1024*1024))
+}
+
+func formatRemoteMetrics(samples []*scbus.RemoteMetrics, tsFmt sstore.ClientTsFormat) string {
+	var buf bytes.Buffer
+	last := samples[len(samples)-1]
+	var cpuSum, cpuMax float64
+	var numCpuSamples int
+	var memMax int64
+	for _, sample := range samples {
+		if sample.CpuPct >= 0 {
+			cpuSum += sample.CpuPct
+			cpuMax = max(cpuMax, sample.CpuPct)
+			numCpuSamples++
+		}
+		memMax = max(memMax, sample.MemUsed)
+	}
+	if last.CpuPct >= 0 {
+		buf.WriteString(fmt.Sprintf("  %-15s %.1f%% of %d cpus (avg %.1f%%, max %.1f%%)\n", "cpu", last.CpuPct, last.NumCpu, cpuSum/float64(numCpuSamples), cpuMax))
+	} else if numCpuSamples > 0 {
+		buf.WriteString(fmt.Sprintf("  %-15s - (avg %.1f%%, max %.1f%%)\n", "cpu", cpuSum/float64(numCpuSamples), cpuMax))
+	} else {
+		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "cpu", "-"))
+	}
+	if last.MemTotal > 0 {
+		buf.WriteString(fmt.Sprintf("  %-15s %s / %s (max %s)\n", "memory", formatMetricsBytes(last.MemUsed), formatMetricsBytes(last.MemTotal), formatMetricsBytes(memMax)))
+	}
+	if len(last.LoadAvg) > 0 {
+		buf.WriteString(fmt.Sprintf("  %-15s %v\n", "load", last.LoadAvg))
+	}
+	if last.DiskTotal > 0 {
+		buf.WriteString(fmt.Sprintf("  %-15s %s free of %s\n", "disk", formatMetricsBytes(last.DiskFree), formatMetricsBytes(last.DiskTotal)))
+	}
+	first := samples[0]
+	buf.WriteString(fmt.Sprintf("  %-15s %d since %s, last %s\n", "samples", len(samples), formatHealthTs(tsFmt, first.Ts), formatHealthTs(tsFmt, last.Ts)))
+	return buf.String()
+}
+
+// /remote:metrics, the latest metrics sample of the remote (with averages over the retained samples)
+func RemoteMetricsCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, err
+	}
+	samples := remote.GetRemoteMetrics(ids.Remote.RemotePtr.RemoteId)
+	if len(samples) == 0 {
+		if remote.GetMetricsInterval() <= 0 {
+			return nil, fmt.Errorf("/remote:metrics no metrics, sampling is off (/client:set metricsinterval=N)")
+		}
+		return nil, fmt.Errorf("/remote:metrics no metrics collected for %s (sampled every %v while connected)", ids.Remote.DisplayName, remote.GetMetricsInterval().Round(time.Second))
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: fmt.Sprintf("metrics for %s", ids.Remote.DisplayName),
+		InfoLines: splitLinesForInfo(formatRemoteMetrics(samples, sstore.GetClientTsFormat(ctx))),
+	})
+	return update, nil
+}
@@ -269,7 +269,7 @@ func resolveUiIds(ctx context.Context, pk *scpacket.FeCommandPacketType, rtype i
 	}
 	if rtype&R_RemoteConnected > 0 {
 		if !rtn.Remote.RState.IsConnected() {
-			err = rtn.Remote.Waveshell.TryAutoConnect()
+			err = rtn.Remote.Waveshell.TryAutoConnect(rtn.ScreenId)
 			if err != nil {
 				return rtn, fmt.Errorf("error trying to auto-connect remote [%s]: %w", rtn.Remote.DisplayName, err)
 			}
@@ -488,7 +488,7 @@ func ResolveRemoteFromPtr(ctx context.Context, rptr *sstore.RemotePtrType, sessi
 			// continue with state set to nil
 		} else {
 			if ri == nil {
-				rtn.ShellType = wsh.GetShellPref()
+				rtn.ShellType = defaultStr(getScreenShellPref(ctx, screenId, *rptr), wsh.GetShellPref())
 				rtn.StatePtr = nil
 				rtn.FeState = nil
 			} else {
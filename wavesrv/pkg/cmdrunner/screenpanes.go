@@ -0,0 +1,137 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func resolvePaneRatio(arg string, def float64) (float64, error) {
+	if arg == "" {
+		return def, nil
+	}
+	ratio, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ratio %q: %v", arg, err)
+	}
+	return ratio, nil
+}
+
+func screenPaneUpdate(screen *sstore.ScreenType) scbus.UpdatePacket {
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(*screen)
+	return update
+}
+
+// /screen:split [pane] [split=horizontal|vertical] [ratio=0.5], pane defaults to the active pane
+func ScreenSplitCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	split := defaultStr(pk.Kwargs["split"], sstore.PaneSplit_Horizontal)
+	ratio, err := resolvePaneRatio(pk.Kwargs["ratio"], sstore.DefaultPaneRatio)
+	if err != nil {
+		return nil, fmt.Errorf("/screen:split %v", err)
+	}
+	screen, err := sstore.SplitScreenPane(ctx, ids.ScreenId, firstArg(pk), split, ratio)
+	if err != nil {
+		return nil, fmt.Errorf("/screen:split error: %v", err)
+	}
+	return screenPaneUpdate(screen), nil
+}
+
+// /screen:closepane [pane]
+func ScreenClosePaneCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	screen, err := sstore.CloseScreenPane(ctx, ids.ScreenId, firstArg(pk))
+	if err != nil {
+		return nil, fmt.Errorf("/screen:closepane error: %v", err)
+	}
+	return screenPaneUpdate(screen), nil
+}
+
+// /screen:focuspane [pane]
+func ScreenFocusPaneCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf("/screen:focuspane requires an argument (pane number or id)")
+	}
+	screen, err := sstore.SetActiveScreenPane(ctx, ids.ScreenId, pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("/screen:focuspane error: %v", err)
+	}
+	return screenPaneUpdate(screen), nil
+}
+
+// /screen:resizepane [pane] ratio=[0.1-0.9], sets the ratio of the split containing the pane
+func ScreenResizePaneCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	if pk.Kwargs["ratio"] == "" {
+		return nil, fmt.Errorf("/screen:resizepane requires a 'ratio' argument")
+	}
+	ratio, err := resolvePaneRatio(pk.Kwargs["ratio"], sstore.DefaultPaneRatio)
+	if err != nil {
+		return nil, fmt.Errorf("/screen:resizepane %v", err)
+	}
+	screen, err := sstore.ResizeScreenPane(ctx, ids.ScreenId, firstArg(pk), ratio)
+	if err != nil {
+		return nil, fmt.Errorf("/screen:resizepane error: %v", err)
+	}
+	return screenPaneUpdate(screen), nil
+}
+
+func ScreenPanesCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	screen, err := sstore.GetScreenById(ctx, ids.ScreenId)
+	if err != nil {
+		return nil, fmt.Errorf("/screen:panes cannot get screen: %v", err)
+	}
+	if screen == nil {
+		return nil, fmt.Errorf("screen not found")
+	}
+	var buf bytes.Buffer
+	if screen.PaneLayout == nil {
+		buf.WriteString("screen has no split panes\n")
+	} else {
+		for idx, pane := range screen.PaneLayout.GetLeafPanes() {
+			activeStr := " "
+			remotePtr := pane.CurRemote
+			if pane.PaneId == screen.PaneLayout.ActivePaneId {
+				activeStr = "*"
+				remotePtr = &screen.CurRemote
+			}
+			remoteStr := "-"
+			if remotePtr != nil {
+				remoteStr = remotePtr.MakeFullRemoteRef()
+			}
+			buf.WriteString(fmt.Sprintf("%s %-3d %s  %s\n", activeStr, idx+1, pane.PaneId[0:8], remoteStr))
+		}
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: "screen panes",
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
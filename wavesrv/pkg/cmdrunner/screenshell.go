@@ -0,0 +1,60 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// per-screen shells for the local remote.  screenopts shellpref overrides the local remote's shellpref on that
+// screen, new remote instances for the screen (/connect, /reset) start that shell.  remote instances are
+// screen-scoped and keep their own state and shell type (ri.ShellType), so screens running different shells on
+// the same local remote never share state.  session-scoped remote instances are shared by all the screens of
+// the session, so the override does not apply to them.
+
+// returns the screen's shell override for rptr, "" if there is none
+func getScreenShellPref(ctx context.Context, screenId string, rptr sstore.RemotePtrType) string {
+	if screenId == "" || rptr.IsSessionScope() {
+		return ""
+	}
+	wsh := remote.GetRemoteById(rptr.RemoteId)
+	if wsh == nil || !wsh.GetRemoteCopy().Local {
+		return ""
+	}
+	screen, err := sstore.GetScreenById(ctx, screenId)
+	if err != nil {
+		log.Printf("error getting screen %s shellpref: %v\n", screenId, err)
+		return ""
+	}
+	if screen == nil {
+		return ""
+	}
+	return screen.ScreenOpts.ShellPref
+}
+
+// "" or "default" clears the override
+func resolveScreenShellPref(shellPrefArg string) (string, error) {
+	if shellPrefArg == "" || shellPrefArg == "default" {
+		return "", nil
+	}
+	return resolveShellType(shellPrefArg, "")
+}
+
+// returns a message if the screen's current remote instance runs a different shell than its shellpref
+func getScreenShellResetMsg(ctx context.Context, screen *sstore.ScreenType) string {
+	shellPref := getScreenShellPref(ctx, screen.ScreenId, screen.CurRemote)
+	if shellPref == "" {
+		return ""
+	}
+	ri, err := sstore.GetRemoteInstance(ctx, screen.SessionId, screen.ScreenId, screen.CurRemote)
+	if err != nil || ri == nil || ri.ShellType == "" || ri.ShellType == shellPref {
+		return ""
+	}
+	return fmt.Sprintf("this tab is running %s, run /reset to switch it to %s", ri.ShellType, shellPref)
+}
@@ -0,0 +1,209 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// returns the serial opts from the kwargs (defaults for the ones that are not set)
+func resolveSerialOpts(pk *scpacket.FeCommandPacketType) (*sstore.SerialOptsType, error) {
+	opts := remote.MakeDefaultSerialOpts()
+	var err error
+	opts.BaudRate, err = resolvePosInt(pk.Kwargs["baud"], opts.BaudRate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid baud: %v", err)
+	}
+	if pk.Kwargs["parity"] != "" {
+		opts.Parity = strings.ToLower(pk.Kwargs["parity"])
+	}
+	opts.DataBits, err = resolvePosInt(pk.Kwargs["databits"], opts.DataBits)
+	if err != nil {
+		return nil, fmt.Errorf("invalid databits: %v", err)
+	}
+	opts.StopBits, err = resolvePosInt(pk.Kwargs["stopbits"], opts.StopBits)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stopbits: %v", err)
+	}
+	err = remote.ValidateSerialOpts(opts)
+	if err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// e.g. "115200 8N1"
+func formatSerialOpts(opts *sstore.SerialOptsType) string {
+	if opts == nil {
+		opts = remote.MakeDefaultSerialOpts()
+	}
+	parity := "N"
+	if opts.Parity != "" {
+		parity = strings.ToUpper(opts.Parity[0:1])
+	}
+	return fmt.Sprintf("%d %d%s%d", opts.BaudRate, opts.DataBits, parity, opts.StopBits)
+}
+
+func getSerialRemotes() []*remote.WaveshellProc {
+	var rtn []*remote.WaveshellProc
+	for _, wsh := range remote.GetRemoteMap() {
+		rcopy := wsh.GetRemoteCopy()
+		if rcopy.IsSerial() && !rcopy.Archived {
+			rtn = append(rtn, wsh)
+		}
+	}
+	return rtn
+}
+
+func getSerialRemoteByArg(remoteArg string) *remote.WaveshellProc {
+	wsh := remote.GetRemoteByArg(remoteArg)
+	if wsh == nil {
+		return nil
+	}
+	rcopy := wsh.GetRemoteCopy()
+	if !rcopy.IsSerial() {
+		return nil
+	}
+	return wsh
+}
+
+func addSerialRemote(ctx context.Context, pk *scpacket.FeCommandPacketType, device string) (*sstore.RemoteType, error) {
+	if !strings.HasPrefix(device, "/") {
+		return nil, fmt.Errorf("device must be an absolute path (e.g. /dev/ttyUSB0)")
+	}
+	for _, wsh := range getSerialRemotes() {
+		if wsh.GetRemoteCopy().RemoteHost == device {
+			return nil, fmt.Errorf("serial remote for %s already exists", device)
+		}
+	}
+	opts, err := resolveSerialOpts(pk)
+	if err != nil {
+		return nil, err
+	}
+	r := remote.MakeSerialRemote(device, opts)
+	r.RemoteId = scbase.GenWaveUUID()
+	if alias, found := pk.Kwargs["alias"]; found {
+		if len(alias) > MaxRemoteAliasLen {
+			return nil, fmt.Errorf("alias too long, max length = %d", MaxRemoteAliasLen)
+		}
+		if alias != "" && !remoteAliasRe.MatchString(alias) {
+			return nil, fmt.Errorf("invalid alias format")
+		}
+		r.RemoteAlias = alias
+	}
+	err = remote.AddRemote(ctx, r, false)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// opens the serial console as a running line on the current screen
+func openSerialConsole(ctx context.Context, pk *scpacket.FeCommandPacketType, wsh *remote.WaveshellProc) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	remoteCopy := wsh.GetRemoteCopy()
+	termOpts, err := GetUITermOpts(pk.UIContext.WinSize, DefaultPTERM)
+	if err != nil {
+		return nil, fmt.Errorf("cannot make termopts: %w", err)
+	}
+	pkTermOpts := convertTermOpts(termOpts)
+	rptr := sstore.RemotePtrType{RemoteId: remoteCopy.RemoteId}
+	cmd, err := makeDynCmd(ctx, "serial", ids, pk.GetRawStr(), *pkTermOpts, &makeDynCmdOpts{OverrideRPtr: &rptr})
+	if err != nil {
+		return nil, err
+	}
+	var outputPos int64
+	var serialOpts *sstore.SerialOptsType
+	if remoteCopy.RemoteOpts != nil {
+		serialOpts = remoteCopy.RemoteOpts.SerialOpts
+	}
+	writeStringToPty(ctx, cmd, fmt.Sprintf("opening %s (%s)\r\n", remoteCopy.RemoteHost, formatSerialOpts(serialOpts)), &outputPos)
+	update, err := addLineForCmd(ctx, "/remote:serial", true, ids, cmd, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	update.AddUpdate(sstore.InteractiveUpdate(pk.Interactive))
+	err = wsh.OpenSerialConsole(cmd, outputPos)
+	if err != nil {
+		writeStringToPty(ctx, cmd, fmt.Sprintf("error: %v\r\n", err), &outputPos)
+		scbus.MainUpdateBus.DoScreenUpdate(cmd.ScreenId, update)
+		deferWriteCmdStatus(ctx, cmd, time.Now(), false, outputPos)
+		return scbus.MakeUpdatePacket(), nil
+	}
+	return update, nil
+}
+
+// /remote:serial [add=device [baud=n] [parity=none|even|odd] [databits=n] [stopbits=n] [alias=alias]] [open=remote] [close=remote]
+// no args lists the serial devices and the serial remotes
+func RemoteSerialCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if device, found := pk.Kwargs["add"]; found {
+		r, err := addSerialRemote(ctx, pk, device)
+		if err != nil {
+			return nil, fmt.Errorf("/remote:serial cannot create remote: %v", err)
+		}
+		return createRemoteViewRemoteIdUpdate(r.RemoteId), nil
+	}
+	if remoteArg, found := pk.Kwargs["open"]; found {
+		wsh := getSerialRemoteByArg(remoteArg)
+		if wsh == nil {
+			return nil, fmt.Errorf("/remote:serial serial remote %q not found", remoteArg)
+		}
+		return openSerialConsole(ctx, pk, wsh)
+	}
+	if remoteArg, found := pk.Kwargs["close"]; found {
+		wsh := getSerialRemoteByArg(remoteArg)
+		if wsh == nil {
+			return nil, fmt.Errorf("/remote:serial serial remote %q not found", remoteArg)
+		}
+		if !wsh.CloseSerialConsole() {
+			return nil, fmt.Errorf("/remote:serial no console open for %q", remoteArg)
+		}
+		return sstore.InfoMsgUpdate("closed serial console for %s", wsh.GetRemoteCopy().RemoteHost), nil
+	}
+	var buf bytes.Buffer
+	buf.WriteString("serial remotes:\n")
+	serialRemotes := getSerialRemotes()
+	if len(serialRemotes) == 0 {
+		buf.WriteString("  (none)\n")
+	}
+	for _, wsh := range serialRemotes {
+		rcopy := wsh.GetRemoteCopy()
+		var serialOpts *sstore.SerialOptsType
+		if rcopy.RemoteOpts != nil {
+			serialOpts = rcopy.RemoteOpts.SerialOpts
+		}
+		status := "closed"
+		if wsh.IsSerialConsoleOpen() {
+			status = "open"
+		}
+		buf.WriteString(fmt.Sprintf("  %-30s %-14s %s\n", rcopy.GetName(), formatSerialOpts(serialOpts), status))
+	}
+	buf.WriteString("\ndevices:\n")
+	devices := remote.ListSerialDevices()
+	if len(devices) == 0 {
+		buf.WriteString("  (no serial devices found)\n")
+	}
+	for _, device := range devices {
+		buf.WriteString(fmt.Sprintf("  %s\n", device))
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: "serial consoles",
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
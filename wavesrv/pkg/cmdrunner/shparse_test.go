@@ -66,3 +66,16 @@ func TestIsReturnStateCommand(t *testing.T) {
 	testRSC(t, "cd work; conda activate myenv", true)
 	testRSC(t, "asdf foo", true)
 }
+
+func TestIsShellRunCmdStr(t *testing.T) {
+	for _, cmdStr := range []string{"ls -l", " ls", "git status && make", "cd /tmp", "/run ls"} {
+		if !isShellRunCmdStr(cmdStr) {
+			t.Errorf("cmd [%s] should be a shell run", cmdStr)
+		}
+	}
+	for _, cmdStr := range []string{"/screen:delete", "/run:foo ls", "clear", "cr local", "[ret=1] ls", "/eval ls"} {
+		if isShellRunCmdStr(cmdStr) {
+			t.Errorf("cmd [%s] should not be a shell run", cmdStr)
+		}
+	}
+}
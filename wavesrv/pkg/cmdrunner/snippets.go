@@ -0,0 +1,219 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/utilfn"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/bookmarks"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// command snippets (see sstore/snippets.go).  /snippet:render fills in the snippet's {{var}} placeholders and
+// puts the command into the command input (it is not run, so it can be checked and edited first).  values are
+// passed positionally (in the order the vars first appear) or as var=value.  the frontend palette uses the
+// snippets update and renders with the same command.
+
+// positional values fill the vars in order, kwargs (by var name) override them
+func renderSnippet(snippet *sstore.SnippetType, posArgs []string, kwargs map[string]string) (string, error) {
+	varNames := bookmarks.GetTemplateArgNames(snippet.CmdStr)
+	if len(posArgs) > len(varNames) {
+		return "", fmt.Errorf("snippet %q takes %d values, got %d", snippet.Name, len(varNames), len(posArgs))
+	}
+	vals := make(map[string]string)
+	for idx, val := range posArgs {
+		vals[varNames[idx]] = val
+	}
+	for _, varName := range varNames {
+		if val, found := kwargs[varName]; found {
+			vals[varName] = val
+		}
+	}
+	cmdStr, missing := bookmarks.RenderTemplateArgs(snippet.CmdStr, vals)
+	if len(missing) > 0 {
+		return "", fmt.Errorf("snippet %q missing values for %s", snippet.Name, formatStrs(missing, "and", true))
+	}
+	return cmdStr, nil
+}
+
+func makeSnippetsUpdate(ctx context.Context, infoFmt string, args ...interface{}) (scbus.UpdatePacket, error) {
+	snippetsUpdate, err := sstore.MakeSnippetsUpdate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	update := sstore.InfoMsgUpdate(infoFmt, args...)
+	update.AddUpdate(*snippetsUpdate)
+	return update, nil
+}
+
+func formatSnippetScope(snippet *sstore.SnippetType) string {
+	var scopes []string
+	if snippet.RemoteId != "" {
+		remoteName := snippet.RemoteId
+		if wsh := remote.GetRemoteById(snippet.RemoteId); wsh != nil {
+			remoteName = wsh.GetDisplayName()
+		}
+		scopes = append(scopes, "remote:"+remoteName)
+	}
+	if snippet.RemoteTag != "" {
+		scopes = append(scopes, "tag:"+snippet.RemoteTag)
+	}
+	return strings.Join(scopes, ",")
+}
+
+// /snippet:add [name] "[cmdstr]" desc=... scope=remote tag=...
+func SnippetAddCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) != 2 {
+		return nil, fmt.Errorf("usage: /snippet:add [name] \"[command]\" (placeholders are written as {{var}})")
+	}
+	name := pk.Args[0]
+	err = validateName(name, "snippet")
+	if err != nil {
+		return nil, fmt.Errorf("/snippet:add %v", err)
+	}
+	cmdStr := strings.TrimSpace(pk.Args[1])
+	if cmdStr == "" {
+		return nil, fmt.Errorf("/snippet:add command cannot be empty")
+	}
+	now := time.Now().UnixMilli()
+	snippet := &sstore.SnippetType{
+		SnippetId:   scbase.GenWaveUUID(),
+		Name:        name,
+		Description: pk.Kwargs["desc"],
+		CmdStr:      cmdStr,
+		CreatedTs:   now,
+		LastUsedTs:  now,
+	}
+	switch pk.Kwargs["scope"] {
+	case "", "all":
+	case "remote":
+		snippet.RemoteId = ids.Remote.RemotePtr.RemoteId
+	default:
+		return nil, fmt.Errorf("/snippet:add invalid scope %q (use 'remote' or 'all')", pk.Kwargs["scope"])
+	}
+	if tag := pk.Kwargs["tag"]; tag != "" {
+		err = sstore.ValidateRemoteTag(tag)
+		if err != nil {
+			return nil, fmt.Errorf("/snippet:add %v", err)
+		}
+		snippet.RemoteTag = tag
+	}
+	err = sstore.SaveSnippet(ctx, snippet)
+	if err != nil {
+		return nil, fmt.Errorf("/snippet:add error saving snippet: %v", err)
+	}
+	varNames := bookmarks.GetTemplateArgNames(cmdStr)
+	if len(varNames) == 0 {
+		return makeSnippetsUpdate(ctx, "snippet %q saved, insert it with /snippet:render %s", name, name)
+	}
+	return makeSnippetsUpdate(ctx, "snippet %q saved (vars %s), insert it with /snippet:render %s [values...]", name, formatStrs(varNames, "and", false), name)
+}
+
+// /snippet:show [search], only shows the snippets in scope for the current remote (all=1 for all)
+func SnippetShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, err
+	}
+	showAll := resolveBool(pk.Kwargs["all"], false)
+	search := firstArg(pk)
+	snippets, err := sstore.GetSnippets(ctx, search)
+	if err != nil {
+		return nil, fmt.Errorf("/snippet:show error getting snippets: %v", err)
+	}
+	var buf bytes.Buffer
+	for _, snippet := range snippets {
+		if !showAll && !snippet.InScope(ids.Remote.RemotePtr.RemoteId, ids.Remote.RemoteCopy.RemoteOpts) {
+			continue
+		}
+		buf.WriteString(fmt.Sprintf("  %-20s %s\n", snippet.Name, snippet.CmdStr))
+		if snippet.Description != "" {
+			buf.WriteString(fmt.Sprintf("  %-20s # %s\n", "", snippet.Description))
+		}
+		if scope := formatSnippetScope(snippet); scope != "" {
+			buf.WriteString(fmt.Sprintf("  %-20s [%s]\n", "", scope))
+		}
+	}
+	if buf.Len() == 0 {
+		if search != "" {
+			return sstore.InfoMsgUpdate("no snippets match %q", search), nil
+		}
+		return sstore.InfoMsgUpdate("no snippets for %s, add one with /snippet:add [name] \"[command]\"", ids.Remote.DisplayName), nil
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{InfoTitle: "snippets", InfoLines: splitLinesForInfo(buf.String())})
+	if search == "" {
+		update.AddUpdate(sstore.SnippetsUpdateType{Snippets: snippets})
+	}
+	return update, nil
+}
+
+func SnippetDeleteCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if len(pk.Args) != 1 {
+		return nil, fmt.Errorf("usage: /snippet:delete [name]")
+	}
+	snippet, err := sstore.GetSnippetByArg(ctx, pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("/snippet:delete error getting snippet: %v", err)
+	}
+	if snippet == nil {
+		return nil, fmt.Errorf("/snippet:delete snippet %q not found", pk.Args[0])
+	}
+	err = sstore.DeleteSnippet(ctx, snippet.SnippetId)
+	if err != nil {
+		return nil, fmt.Errorf("/snippet:delete error deleting snippet: %v", err)
+	}
+	return makeSnippetsUpdate(ctx, "snippet %q deleted", snippet.Name)
+}
+
+// /snippet:render [name] [values...] var=value
+func SnippetRenderCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen|R_Remote)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) == 0 {
+		return nil, fmt.Errorf("usage: /snippet:render [name] [values...]")
+	}
+	snippet, err := sstore.GetSnippetByArg(ctx, pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("/snippet:render error getting snippet: %v", err)
+	}
+	if snippet == nil {
+		return nil, fmt.Errorf("/snippet:render snippet %q not found", pk.Args[0])
+	}
+	if !snippet.InScope(ids.Remote.RemotePtr.RemoteId, ids.Remote.RemoteCopy.RemoteOpts) {
+		return nil, fmt.Errorf("/snippet:render snippet %q is not available on %s [%s]", snippet.Name, ids.Remote.DisplayName, formatSnippetScope(snippet))
+	}
+	cmdStr, err := renderSnippet(snippet, pk.Args[1:], pk.Kwargs)
+	if err != nil {
+		return nil, fmt.Errorf("/snippet:render %v", err)
+	}
+	err = sstore.MarkSnippetUsed(ctx, snippet.SnippetId)
+	if err != nil {
+		return nil, fmt.Errorf("/snippet:render error updating snippet: %v", err)
+	}
+	snippetsUpdate, err := sstore.MakeSnippetsUpdate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.CmdLineUpdate(utilfn.StrWithPos{Str: cmdStr, Pos: utf8.RuneCountInString(cmdStr)}))
+	update.AddUpdate(*snippetsUpdate)
+	return update, nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"testing"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func TestRenderSnippet(t *testing.T) {
+	snippet := &sstore.SnippetType{Name: "logs", CmdStr: "kubectl logs -n {{ns}} {{pod}} | grep {{ns}}"}
+	cmdStr, err := renderSnippet(snippet, []string{"prod", "api-1"}, nil)
+	if err != nil {
+		t.Fatalf("render error: %v", err)
+	}
+	if cmdStr != "kubectl logs -n prod api-1 | grep prod" {
+		t.Errorf("invalid render %q", cmdStr)
+	}
+	cmdStr, err = renderSnippet(snippet, []string{"prod"}, map[string]string{"pod": "web-2", "other": "x"})
+	if err != nil {
+		t.Fatalf("render error: %v", err)
+	}
+	if cmdStr != "kubectl logs -n prod web-2 | grep prod" {
+		t.Errorf("invalid render with kwargs %q", cmdStr)
+	}
+	cmdStr, err = renderSnippet(snippet, []string{"prod", "api-1"}, map[string]string{"ns": "dev"})
+	if err != nil || cmdStr != "kubectl logs -n dev api-1 | grep dev" {
+		t.Errorf("kwargs should override positional values, got %q (err %v)", cmdStr, err)
+	}
+	if _, err = renderSnippet(snippet, []string{"prod"}, nil); err == nil {
+		t.Errorf("expected error for missing value")
+	}
+	if _, err = renderSnippet(snippet, []string{"a", "b", "c"}, nil); err == nil {
+		t.Errorf("expected error for too many values")
+	}
+	plain := &sstore.SnippetType{Name: "plain", CmdStr: "ls -la"}
+	if cmdStr, err = renderSnippet(plain, nil, nil); err != nil || cmdStr != "ls -la" {
+		t.Errorf("invalid render of snippet without vars %q (err %v)", cmdStr, err)
+	}
+}
+
+func TestSnippetInScope(t *testing.T) {
+	global := &sstore.SnippetType{Name: "a"}
+	byRemote := &sstore.SnippetType{Name: "b", RemoteId: "r1"}
+	byTag := &sstore.SnippetType{Name: "c", RemoteTag: "prod"}
+	prodOpts := &sstore.RemoteOptsType{Tags: []string{"prod"}}
+	if !global.InScope("r2", nil) {
+		t.Errorf("unscoped snippet should be in scope")
+	}
+	if !byRemote.InScope("r1", nil) || byRemote.InScope("r2", prodOpts) {
+		t.Errorf("invalid remote scope")
+	}
+	if !byTag.InScope("r2", prodOpts) || byTag.InScope("r1", nil) {
+		t.Errorf("invalid tag scope")
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/blockstore"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/srvmetrics"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+const srvMetricsGaugeTimeout = 2 * time.Second
+
+// the cache gauges of one scrape share a snapshot (GetCacheStats walks every cached block)
+const srvMetricsCacheStatsMaxAge = 1 * time.Second
+
+var remoteMetricsStatuses = []string{sstore.RemoteStatus_Connected, sstore.RemoteStatus_Connecting, sstore.RemoteStatus_Disconnected, sstore.RemoteStatus_Error}
+
+var cacheStatsLock = &sync.Mutex{}
+var cacheStatsSnapshot blockstore.CacheStats
+var cacheStatsTs time.Time
+
+func getMetricsCacheStats() blockstore.CacheStats {
+	cacheStatsLock.Lock()
+	defer cacheStatsLock.Unlock()
+	if time.Since(cacheStatsTs) >= srvMetricsCacheStatsMaxAge {
+		cacheStatsSnapshot = blockstore.GetCacheStats()
+		cacheStatsTs = time.Now()
+	}
+	return cacheStatsSnapshot
+}
+
+// gauges collected when the metrics endpoint is scraped (the counters live in the instrumented packages)
+func init() {
+	srvmetrics.RegisterGaugeFunc("wavesrv_update_writer_backlog", "Screen updates waiting for the cloud update writer.", func() []srvmetrics.GaugeSample {
+		ctx, cancelFn := context.WithTimeout(context.Background(), srvMetricsGaugeTimeout)
+		defer cancelFn()
+		numUpdates, err := sstore.CountScreenUpdates(ctx)
+		if err != nil {
+			log.Printf("[srvmetrics] error counting screen updates: %v\n", err)
+			return nil
+		}
+		return []srvmetrics.GaugeSample{{Value: float64(numUpdates)}}
+	})
+	srvmetrics.RegisterGaugeFunc("wavesrv_blockstore_cache_entries", "Files in the blockstore cache.", func() []srvmetrics.GaugeSample {
+		return []srvmetrics.GaugeSample{{Value: float64(getMetricsCacheStats().Entries)}}
+	})
+	srvmetrics.RegisterGaugeFunc("wavesrv_blockstore_cache_blocks", "Data blocks in the blockstore cache.", func() []srvmetrics.GaugeSample {
+		return []srvmetrics.GaugeSample{{Value: float64(getMetricsCacheStats().Blocks)}}
+	})
+	srvmetrics.RegisterGaugeFunc("wavesrv_blockstore_cache_bytes", "Bytes held by the blockstore cache.", func() []srvmetrics.GaugeSample {
+		return []srvmetrics.GaugeSample{{Value: float64(getMetricsCacheStats().Bytes)}}
+	})
+	srvmetrics.RegisterGaugeFunc("wavesrv_blockstore_dirty_bytes", "Bytes written to the blockstore cache since the last flush.", func() []srvmetrics.GaugeSample {
+		return []srvmetrics.GaugeSample{{Value: float64(getMetricsCacheStats().DirtyBytes)}}
+	})
+	srvmetrics.RegisterGaugeFunc("wavesrv_remotes", "Remote connections (not archived) by status.", func() []srvmetrics.GaugeSample {
+		counts := make(map[string]int)
+		for _, rstate := range remote.GetAllRemoteRuntimeState() {
+			if rstate.Archived {
+				continue
+			}
+			counts[rstate.Status]++
+		}
+		var rtn []srvmetrics.GaugeSample
+		for _, status := range remoteMetricsStatuses {
+			rtn = append(rtn, srvmetrics.GaugeSample{LabelValues: []string{status}, Value: float64(counts[status])})
+		}
+		return rtn
+	}, "status")
+}
+
+func resolveMetricsPort(arg string) (int, error) {
+	port, err := resolveNonNegInt(arg, 0)
+	if err != nil {
+		return 0, err
+	}
+	if port > 65535 {
+		return 0, fmt.Errorf("port must be at most 65535")
+	}
+	return port, nil
+}
+
+func formatMetricsPortStatus() string {
+	status := srvmetrics.GetStatus()
+	if !status.Running {
+		return "off"
+	}
+	return fmt.Sprintf("http://%s%s (%d scrapes)", status.Addr, srvmetrics.MetricsPath, srvmetrics.GetNumScrapes())
+}
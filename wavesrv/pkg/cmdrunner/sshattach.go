@@ -0,0 +1,49 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sshattach"
+)
+
+// the sshattach client opt: "on" (loopback, default port), a port (loopback), or host:port (to listen on other
+// interfaces).  returns "" for off.
+func resolveSshAttachAddr(arg string) (string, error) {
+	if arg == "" || arg == "off" || !resolveBool(arg, true) {
+		return "", nil
+	}
+	if arg == "on" || arg == "1" || arg == "true" {
+		return net.JoinHostPort(sshattach.SshAttachDefaultHost, strconv.Itoa(sshattach.SshAttachDefaultPort)), nil
+	}
+	if port, err := strconv.Atoi(arg); err == nil {
+		arg = net.JoinHostPort(sshattach.SshAttachDefaultHost, strconv.Itoa(port))
+	}
+	_, portStr, err := net.SplitHostPort(arg)
+	if err != nil {
+		return "", fmt.Errorf("must be on, off, a port, or host:port")
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", fmt.Errorf("invalid port %q", portStr)
+	}
+	return arg, nil
+}
+
+// starts (or restarts on a new address) or stops the ssh attach server (see sshattach), addr "" stops it
+func SetSshAttach(addr string) error {
+	if addr == "" {
+		sshattach.Stop()
+		return nil
+	}
+	// an ssh client only runs shell commands, metacommands could change the client settings
+	return sshattach.Start(addr, func(ctx context.Context, screenId string, cmdStr string) error {
+		_, err := EvalScreenShellCommand(ctx, screenId, nil, cmdStr, nil)
+		return err
+	})
+}
@@ -0,0 +1,31 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import "testing"
+
+func TestResolveSshAttachAddr(t *testing.T) {
+	tests := map[string]string{
+		"":               "",
+		"off":            "",
+		"0":              "",
+		"on":             "127.0.0.1:1639",
+		"2222":           "127.0.0.1:2222",
+		"127.0.0.1:2222": "127.0.0.1:2222",
+		"0.0.0.0:1639":   "0.0.0.0:1639",
+		":1639":          ":1639",
+	}
+	for arg, want := range tests {
+		addr, err := resolveSshAttachAddr(arg)
+		if err != nil || addr != want {
+			t.Errorf("%q got %q %v", arg, addr, err)
+		}
+	}
+	for _, arg := range []string{"localhost", "70000", "host:port"} {
+		_, err := resolveSshAttachAddr(arg)
+		if err == nil {
+			t.Errorf("%q expected an error", arg)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// the ssh config files are watched and the ssh config import is re-run when one changes, so imported remotes
+// follow the config without running /remote:parse again.  the dirs are watched (not the files) because editors
+// usually replace the file.  nothing is imported until the user has run the import once (there are imported remotes).
+
+const sshConfigReloadDelay = time.Second // editors write files in several steps
+const sshConfigReloadTimeout = 30 * time.Second
+
+// the import can run from /remote:parse and from the watcher
+var sshConfigImportLock = &sync.Mutex{}
+
+func isSshConfigEvent(configFiles map[string]bool, event fsnotify.Event) bool {
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+	return configFiles[filepath.Clean(event.Name)]
+}
+
+func reloadSshConfigRemotes() {
+	if scbase.IsReadOnly() {
+		return
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), sshConfigReloadTimeout)
+	defer cancelFn()
+	importedRemotes, err := sstore.GetAllImportedRemotes(ctx)
+	if err != nil {
+		log.Printf("sshconfig-watch: cannot get imported remotes: %v\n", err)
+		return
+	}
+	if len(importedRemotes) == 0 {
+		return
+	}
+	remoteChangeList, err := importSshConfigRemotes(ctx)
+	if err != nil {
+		log.Printf("sshconfig-watch: reload failed: %v\n", err)
+		return
+	}
+	for _, changes := range remoteChangeList {
+		if len(changes) > 0 {
+			// the remote updates are sent by the remote package (NotifyRemoteUpdate)
+			log.Printf("sshconfig-watch: reloaded ssh config, %s\n", createSshImportSummary(remoteChangeList))
+			return
+		}
+	}
+}
+
+// runs until the watcher is closed, returns an error if none of the ssh config dirs can be watched
+func RunSshConfigWatcher() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("cannot create watcher: %w", err)
+	}
+	defer watcher.Close()
+	configFiles := make(map[string]bool)
+	var numWatched int
+	for _, configFile := range getSshConfigFiles() {
+		configFiles[filepath.Clean(configFile)] = true
+		configDir := filepath.Dir(configFile)
+		err = watcher.Add(configDir)
+		if err != nil {
+			log.Printf("sshconfig-watch: cannot watch %s: %v\n", configDir, err)
+			continue
+		}
+		numWatched++
+	}
+	if numWatched == 0 {
+		return fmt.Errorf("no ssh config dirs could be watched")
+	}
+	var reloadCh <-chan time.Time
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if isSshConfigEvent(configFiles, event) {
+				reloadCh = time.After(sshConfigReloadDelay)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("sshconfig-watch: watcher error: %v\n", err)
+		case <-reloadCh:
+			reloadCh = nil
+			reloadSshConfigRemotes()
+		}
+	}
+}
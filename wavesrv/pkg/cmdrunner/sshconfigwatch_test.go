@@ -0,0 +1,26 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestIsSshConfigEvent(t *testing.T) {
+	configFiles := map[string]bool{"/home/user/.ssh/config": true}
+	if !isSshConfigEvent(configFiles, fsnotify.Event{Name: "/home/user/.ssh/config", Op: fsnotify.Write}) {
+		t.Errorf("write to config should be an ssh config event")
+	}
+	if !isSshConfigEvent(configFiles, fsnotify.Event{Name: "/home/user/.ssh//config", Op: fsnotify.Create}) {
+		t.Errorf("create of config (unclean path) should be an ssh config event")
+	}
+	if isSshConfigEvent(configFiles, fsnotify.Event{Name: "/home/user/.ssh/config", Op: fsnotify.Chmod}) {
+		t.Errorf("chmod should not be an ssh config event")
+	}
+	if isSshConfigEvent(configFiles, fsnotify.Event{Name: "/home/user/.ssh/known_hosts", Op: fsnotify.Write}) {
+		t.Errorf("known_hosts should not be an ssh config event")
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/utilfn"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// startup commands (screenopts startupcmds) run when the screen's shell first initializes, that is when the
+// first remote instance for the screen is created (after the remote's init scripts).  they run together as a
+// single line, so "cd ~/project", "source .env", "npm run dev" work as expected.
+
+const MaxStartupCmds = 20
+
+// returns true if ri is the only screen-scoped remote instance for the screen
+func isFirstScreenRI(ctx context.Context, opts connectOptsType, ri *sstore.RemoteInstance) (bool, error) {
+	if ri == nil || ri.ScreenId == "" {
+		return false, nil
+	}
+	ris, err := sstore.GetRIsForScreen(ctx, opts.SessionId, opts.ScreenId)
+	if err != nil {
+		return false, err
+	}
+	for _, otherRI := range ris {
+		if otherRI.ScreenId == opts.ScreenId && otherRI.RIId != ri.RIId {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func runScreenStartupCmds(ctx context.Context, wsh *remote.WaveshellProc, opts connectOptsType, ri *sstore.RemoteInstance) error {
+	screen, err := sstore.GetScreenById(ctx, opts.ScreenId)
+	if err != nil {
+		return err
+	}
+	if screen == nil || len(screen.ScreenOpts.StartupCmds) == 0 {
+		return nil
+	}
+	isFirst, err := isFirstScreenRI(ctx, opts, ri)
+	if err != nil || !isFirst {
+		return err
+	}
+	kwargs := map[string]string{
+		KwArgStartupCmd: screen.ScreenId,
+		KwArgNoHist:     "1",
+	}
+	return evalStartupCmd(ctx, wsh, opts, makeCmdListStr(screen.ScreenOpts.StartupCmds), kwargs, false)
+}
+
+// /screen:startup [add=cmd] [remove=n] [clear=1]
+// no args shows the startup commands
+func ScreenStartupCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	screen, err := sstore.GetScreenById(ctx, ids.ScreenId)
+	if err != nil {
+		return nil, fmt.Errorf("/screen:startup cannot get screen: %v", err)
+	}
+	if screen == nil {
+		return nil, fmt.Errorf("/screen:startup screen not found")
+	}
+	cmds := screen.ScreenOpts.StartupCmds
+	var updated bool
+	if resolveBool(pk.Kwargs["clear"], false) {
+		cmds = nil
+		updated = true
+	}
+	if removeStr, found := pk.Kwargs["remove"]; found {
+		removeIdx, err := resolvePosInt(removeStr, 0)
+		if err != nil || removeIdx < 1 || removeIdx > len(cmds) {
+			return nil, fmt.Errorf("/screen:startup invalid remove index %q (screen has %d startup commands)", removeStr, len(cmds))
+		}
+		cmds = append(append([]string{}, cmds[0:removeIdx-1]...), cmds[removeIdx:]...)
+		updated = true
+	}
+	if addStr, found := pk.Kwargs["add"]; found {
+		cmds = append(append([]string{}, cmds...), addStr)
+		updated = true
+	}
+	update := scbus.MakeUpdatePacket()
+	if updated {
+		err = validateCmdList(cmds, MaxStartupCmds, "startup command")
+		if err != nil {
+			return nil, fmt.Errorf("/screen:startup %v", err)
+		}
+		editMap := map[string]interface{}{sstore.ScreenField_StartupCmds: cmds}
+		screen, err = sstore.UpdateScreen(ctx, ids.ScreenId, editMap)
+		if err != nil {
+			return nil, fmt.Errorf("/screen:startup error updating screen: %v", err)
+		}
+		update.AddUpdate(*screen)
+	}
+	var buf bytes.Buffer
+	if len(cmds) == 0 {
+		buf.WriteString("no startup commands\n")
+	}
+	for idx, cmdStr := range cmds {
+		buf.WriteString(fmt.Sprintf("  %d. %s\n", idx+1, utilfn.EllipsisStr(cmdStr, 80)))
+	}
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: fmt.Sprintf("startup commands for tab %q (run when the tab's shell first starts)", screen.Name),
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// sudo remote credentials (see remote/sudoauth.go).  the commands act on the local sudo remote, or on the
+// remote given with remote=..., which must be a sudo remote.
+
+func resolveSudoRemote(ctx context.Context, pk *scpacket.FeCommandPacketType) (*remote.WaveshellProc, error) {
+	if pk.Kwargs["remote"] == "" {
+		wsh := remote.GetLocalSudoRemote()
+		if wsh == nil {
+			return nil, fmt.Errorf("%s no local sudo remote found", GetCmdStr(pk))
+		}
+		return wsh, nil
+	}
+	ids, err := resolveUiIds(ctx, pk, R_Remote)
+	if err != nil {
+		return nil, err
+	}
+	if !ids.Remote.RemoteCopy.IsSudo() {
+		return nil, fmt.Errorf("%s %q is not a sudo remote", GetCmdStr(pk), ids.Remote.DisplayName)
+	}
+	return ids.Remote.Waveshell, nil
+}
+
+// sudo credential lifetimes are in minutes, 0 is no limit
+func resolveSudoCredMinutes(arg string) (int, error) {
+	minutes, err := resolveNonNegInt(arg, 0)
+	if err != nil {
+		return 0, err
+	}
+	if minutes > remote.MaxSudoCredMinutes {
+		return 0, fmt.Errorf("lifetime too long (max %d minutes)", remote.MaxSudoCredMinutes)
+	}
+	return minutes, nil
+}
+
+func formatSudoCredMinutes(minutes int) string {
+	if minutes <= 0 {
+		return "no limit"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
+
+// /sudo:status [remote=...]
+func SudoStatusCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	wsh, err := resolveSudoRemote(ctx, pk)
+	if err != nil {
+		return nil, err
+	}
+	authState := wsh.GetSudoAuthState()
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "status", authState.Status))
+	buf.WriteString(fmt.Sprintf("  %-15s %s\n", "lifetime", formatSudoCredMinutes(authState.CredMinutes)))
+	if authState.AuthTs > 0 {
+		buf.WriteString(fmt.Sprintf("  %-15s %s\n", "authenticated", formatTs(ctx, time.UnixMilli(authState.AuthTs))))
+	}
+	if authState.ExpireTs > 0 {
+		expiresIn := time.Until(time.UnixMilli(authState.ExpireTs)).Round(time.Second)
+		if expiresIn < 0 {
+			// past the lifetime, but commands are still running
+			buf.WriteString(fmt.Sprintf("  %-15s %s\n", "expires", "when the running commands are done"))
+		} else {
+			buf.WriteString(fmt.Sprintf("  %-15s in %s\n", "expires", expiresIn))
+		}
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{InfoTitle: fmt.Sprintf("sudo credentials for %s", wsh.GetDisplayName()), InfoLines: splitLinesForInfo(buf.String())})
+	return update, nil
+}
+
+// /sudo:set cred=[minutes] [remote=...]
+func SudoSetCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	wsh, err := resolveSudoRemote(ctx, pk)
+	if err != nil {
+		return nil, err
+	}
+	credStr, found := pk.Kwargs["cred"]
+	if !found {
+		return nil, fmt.Errorf("usage: /sudo:set cred=[minutes] (0 for no limit)")
+	}
+	minutes, err := resolveSudoCredMinutes(credStr)
+	if err != nil {
+		return nil, fmt.Errorf("/sudo:set invalid cred: %v", err)
+	}
+	err = wsh.UpdateRemote(ctx, map[string]interface{}{sstore.RemoteField_SudoCred: minutes})
+	if err != nil {
+		return nil, fmt.Errorf("/sudo:set error updating remote: %v", err)
+	}
+	return sstore.InfoMsgUpdate("sudo credential lifetime for %s set to %s", wsh.GetDisplayName(), formatSudoCredMinutes(minutes)), nil
+}
+
+// /sudo:drop [force=1] [remote=...], disconnects the sudo remote (ending its root shell)
+func SudoDropCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	wsh, err := resolveSudoRemote(ctx, pk)
+	if err != nil {
+		return nil, err
+	}
+	force := resolveBool(pk.Kwargs["force"], false)
+	numCmds := wsh.GetNumRunningCommands()
+	if numCmds > 0 && !force {
+		return nil, fmt.Errorf("/sudo:drop %s has %d running command(s), use force=1 to end them", wsh.GetDisplayName(), numCmds)
+	}
+	err = wsh.DropSudoPrivileges(sstore.SudoAuthStatus_Dropped)
+	if err != nil {
+		return nil, fmt.Errorf("/sudo:drop %v", err)
+	}
+	return sstore.InfoMsgUpdate("sudo privileges dropped for %s, reconnect with /connect %s", wsh.GetDisplayName(), wsh.GetRemoteName()), nil
+}
@@ -0,0 +1,299 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alessio/shellescape"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/utilfn"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// workspace templates (see sstore/templates.go).  /template:save captures the current session's screens,
+// /template:open (CreateSessionFromTemplate) creates a new session with a screen for each template screen.
+// the template's cwd and startup commands become the screen's startup commands, so they run when the screen
+// connects to its remote (see startupcmds.go).
+
+// "~" and "~/..." are left unquoted so the shell expands them
+func makeCdCmd(cwd string) string {
+	if cwd == "~" {
+		return "cd ~"
+	}
+	if strings.HasPrefix(cwd, "~/") {
+		return "cd ~/" + shellescape.Quote(cwd[2:])
+	}
+	return "cd " + shellescape.Quote(cwd)
+}
+
+func getTemplateScreenStartupCmds(tscreen sstore.TemplateScreenType) []string {
+	var rtn []string
+	if tscreen.Cwd != "" {
+		rtn = append(rtn, makeCdCmd(tscreen.Cwd))
+	}
+	return append(rtn, tscreen.StartupCmds...)
+}
+
+// returns the remote reference for rptr (as used by /connect), "" for the local remote
+func getTemplateRemoteRef(rptr sstore.RemotePtrType) string {
+	wsh := remote.GetRemoteById(rptr.RemoteId)
+	if wsh == nil {
+		return ""
+	}
+	rcopy := wsh.GetRemoteCopy()
+	remoteRef := rcopy.RemoteCanonicalName
+	if rcopy.RemoteAlias != "" {
+		remoteRef = rcopy.RemoteAlias
+	}
+	if remoteRef == sstore.LocalRemoteAlias && rptr.Name == "" {
+		return ""
+	}
+	if rptr.Name != "" {
+		remoteRef = remoteRef + "#" + rptr.Name
+	}
+	return remoteRef
+}
+
+func makeTemplateScreen(ctx context.Context, screen *sstore.ScreenType) (sstore.TemplateScreenType, error) {
+	tscreen := sstore.TemplateScreenType{
+		Name:        screen.Name,
+		TabColor:    screen.ScreenOpts.TabColor,
+		TabIcon:     screen.ScreenOpts.TabIcon,
+		Remote:      getTemplateRemoteRef(screen.CurRemote),
+		StartupCmds: screen.ScreenOpts.StartupCmds,
+	}
+	ri, err := sstore.GetRemoteInstance(ctx, screen.SessionId, screen.ScreenId, screen.CurRemote)
+	if err != nil {
+		return tscreen, err
+	}
+	if ri != nil {
+		tscreen.Cwd = ri.FeState["cwd"]
+	}
+	return tscreen, nil
+}
+
+// sets up (and connects) a screen created for a template screen
+func setupTemplateScreen(ctx context.Context, sessionId string, screenId string, tscreen sstore.TemplateScreenType, update *scbus.ModelUpdatePacketType) error {
+	editMap := make(map[string]interface{})
+	if tscreen.Name != "" {
+		editMap[sstore.ScreenField_Name] = tscreen.Name
+	}
+	if tscreen.TabColor != "" {
+		editMap[sstore.ScreenField_TabColor] = tscreen.TabColor
+	}
+	if tscreen.TabIcon != "" {
+		editMap[sstore.ScreenField_TabIcon] = tscreen.TabIcon
+	}
+	remoteRef := tscreen.Remote
+	if remoteRef == "" {
+		remoteRef = sstore.LocalRemoteAlias
+	}
+	// startup commands are only set if the remote exists, they must not run on a different remote
+	_, rptr, _, err := resolveRemote(ctx, remoteRef, sessionId, screenId)
+	if err == nil && rptr == nil {
+		err = fmt.Errorf("remote %q not found", remoteRef)
+	}
+	if err == nil {
+		editMap[sstore.ScreenField_StartupCmds] = getTemplateScreenStartupCmds(tscreen)
+	}
+	screen, editErr := sstore.UpdateScreen(ctx, screenId, editMap)
+	if editErr != nil {
+		return editErr
+	}
+	update.AddUpdate(*screen)
+	if err != nil {
+		return err
+	}
+	crPk := scpacket.MakeFeCommandPacket()
+	crPk.MetaCmd = "connect"
+	crPk.Args = []string{remoteRef}
+	crPk.RawStr = "/connect " + remoteRef
+	crPk.UIContext = &scpacket.UIContextType{SessionId: sessionId, ScreenId: screenId}
+	crUpdate, err := CrCommand(ctx, crPk)
+	if err != nil {
+		return err
+	}
+	update.Merge(crUpdate)
+	return nil
+}
+
+// creates (and activates) a new session with the screens of the template.  screens that cannot connect to their
+// remote are still created, the errors are returned in an info message.
+func CreateSessionFromTemplate(ctx context.Context, templateId string) (scbus.UpdatePacket, error) {
+	template, err := sstore.GetTemplateByArg(ctx, templateId)
+	if err != nil {
+		return nil, fmt.Errorf("error getting template: %v", err)
+	}
+	if template == nil {
+		return nil, fmt.Errorf("template %q not found", templateId)
+	}
+	if len(template.Screens) == 0 {
+		return nil, fmt.Errorf("template %q has no screens", template.Name)
+	}
+	update, sessionId, firstScreenId, err := sstore.InsertSessionWithName(ctx, template.Name, true)
+	if err != nil {
+		return nil, err
+	}
+	var errLines []string
+	for idx, tscreen := range template.Screens {
+		screenId := firstScreenId
+		if idx > 0 {
+			sco := sstore.ScreenCreateOpts{RtnScreenId: new(string)}
+			screenUpdate, err := sstore.InsertScreen(ctx, sessionId, tscreen.Name, sco, false)
+			if err != nil {
+				errLines = append(errLines, fmt.Sprintf("screen %d (%s): cannot create screen: %v", idx+1, tscreen.Name, err))
+				continue
+			}
+			update.Merge(screenUpdate)
+			screenId = *sco.RtnScreenId
+		}
+		err = setupTemplateScreen(ctx, sessionId, screenId, tscreen, update)
+		if err != nil {
+			errLines = append(errLines, fmt.Sprintf("screen %d (%s): %v", idx+1, tscreen.Name, err))
+		}
+	}
+	if len(errLines) > 0 {
+		update.AddUpdate(sstore.InfoMsgType{
+			InfoTitle: fmt.Sprintf("workspace created from template %q with errors", template.Name),
+			InfoError: fmt.Sprintf("%d screen(s) could not be set up", len(errLines)),
+			InfoLines: errLines,
+		})
+	}
+	return update, nil
+}
+
+func resolveTemplate(ctx context.Context, pk *scpacket.FeCommandPacketType) (*sstore.WorkspaceTemplateType, error) {
+	if len(pk.Args) != 1 {
+		return nil, fmt.Errorf("%s requires a template name", GetCmdStr(pk))
+	}
+	template, err := sstore.GetTemplateByArg(ctx, pk.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("%s error getting template: %v", GetCmdStr(pk), err)
+	}
+	if template == nil {
+		return nil, fmt.Errorf("%s template %q not found", GetCmdStr(pk), pk.Args[0])
+	}
+	return template, nil
+}
+
+// /template:save [name] [description=...], saves the current session's screens as a template
+func TemplateSaveCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) != 1 {
+		return nil, fmt.Errorf("usage: /template:save [name] [description=...]")
+	}
+	name := pk.Args[0]
+	err = validateName(name, "template")
+	if err != nil {
+		return nil, fmt.Errorf("/template:save %v", err)
+	}
+	screens, err := sstore.GetSessionScreens(ctx, ids.SessionId)
+	if err != nil {
+		return nil, fmt.Errorf("/template:save error getting screens: %v", err)
+	}
+	template := &sstore.WorkspaceTemplateType{
+		TemplateId:  scbase.GenWaveUUID(),
+		Name:        name,
+		Description: pk.Kwargs["description"],
+		CreatedTs:   time.Now().UnixMilli(),
+	}
+	for _, screen := range screens {
+		if screen.Archived {
+			continue
+		}
+		tscreen, err := makeTemplateScreen(ctx, screen)
+		if err != nil {
+			return nil, fmt.Errorf("/template:save error getting screen %q state: %v", screen.Name, err)
+		}
+		template.Screens = append(template.Screens, tscreen)
+	}
+	err = sstore.SaveTemplate(ctx, template)
+	if err != nil {
+		return nil, fmt.Errorf("/template:save error saving template: %v", err)
+	}
+	return sstore.InfoMsgUpdate("template %q saved (%d screens), create a workspace from it with /template:open %s", name, len(template.Screens), name), nil
+}
+
+// /template:open [name]
+func TemplateOpenCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	template, err := resolveTemplate(ctx, pk)
+	if err != nil {
+		return nil, err
+	}
+	update, err := CreateSessionFromTemplate(ctx, template.TemplateId)
+	if err != nil {
+		return nil, fmt.Errorf("/template:open %v", err)
+	}
+	return update, nil
+}
+
+// /template:show [name], lists the templates or shows the screens of one template
+func TemplateShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	var buf bytes.Buffer
+	if len(pk.Args) > 0 {
+		template, err := resolveTemplate(ctx, pk)
+		if err != nil {
+			return nil, err
+		}
+		if template.Description != "" {
+			buf.WriteString(fmt.Sprintf("  %s\n", template.Description))
+		}
+		for idx, tscreen := range template.Screens {
+			remoteRef := tscreen.Remote
+			if remoteRef == "" {
+				remoteRef = sstore.LocalRemoteAlias
+			}
+			buf.WriteString(fmt.Sprintf("  %2d. %-20s remote:%s", idx+1, tscreen.Name, remoteRef))
+			if tscreen.TabColor != "" {
+				buf.WriteString(fmt.Sprintf(" color:%s", tscreen.TabColor))
+			}
+			if tscreen.Cwd != "" {
+				buf.WriteString(fmt.Sprintf(" cwd:%s", tscreen.Cwd))
+			}
+			buf.WriteString("\n")
+			for _, cmdStr := range tscreen.StartupCmds {
+				buf.WriteString(fmt.Sprintf("        > %s\n", utilfn.EllipsisStr(cmdStr, 80)))
+			}
+		}
+		update := scbus.MakeUpdatePacket()
+		update.AddUpdate(sstore.InfoMsgType{InfoTitle: fmt.Sprintf("template %s", template.Name), InfoLines: splitLinesForInfo(buf.String())})
+		return update, nil
+	}
+	templates, err := sstore.GetTemplates(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("/template:show error getting templates: %v", err)
+	}
+	if len(templates) == 0 {
+		return sstore.InfoMsgUpdate("no templates, save the current workspace with /template:save [name]"), nil
+	}
+	for _, template := range templates {
+		buf.WriteString(fmt.Sprintf("  %-20s %2d screens  %s\n", template.Name, len(template.Screens), template.Description))
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{InfoTitle: "workspace templates", InfoLines: splitLinesForInfo(buf.String())})
+	return update, nil
+}
+
+func TemplateDeleteCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	template, err := resolveTemplate(ctx, pk)
+	if err != nil {
+		return nil, err
+	}
+	err = sstore.DeleteTemplate(ctx, template.TemplateId)
+	if err != nil {
+		return nil, fmt.Errorf("/template:delete error deleting template: %v", err)
+	}
+	return sstore.InfoMsgUpdate("template %q deleted", template.Name), nil
+}
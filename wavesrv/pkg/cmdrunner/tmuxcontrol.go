@@ -0,0 +1,22 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/tmuxcc"
+)
+
+// starts or stops the tmux control mode socket (see tmuxcc), set by the tmuxcontrol client opt
+func SetTmuxControl(enabled bool) error {
+	if !enabled {
+		tmuxcc.Stop()
+		return nil
+	}
+	return tmuxcc.Start(func(ctx context.Context, screenId string, cmdStr string) error {
+		_, err := EvalScreenCommand(ctx, screenId, cmdStr)
+		return err
+	})
+}
@@ -0,0 +1,161 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// reading mode (screen transcripts, see sstore/transcript.go).  while reading mode is on for a screen, a
+// watcher follows the screen's updates on the bus and sends "transcript" updates for the lines that changed.
+// output of running commands is batched, changed lines are re-sent at most every TranscriptFlushTime.
+
+const TranscriptFlushTime = 2 * time.Second
+const transcriptChannelPrefix = "transcript:"
+
+var transcriptLock = &sync.Mutex{}
+var transcriptWatchers = make(map[string]bool) // screenid -> running
+
+func sendTranscriptUpdate(tUpdate sstore.TranscriptUpdateType) {
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(tUpdate)
+	scbus.MainUpdateBus.DoScreenUpdate(tUpdate.ScreenId, update)
+}
+
+// returns false if the watcher was already running
+func startTranscriptWatcher(screenId string) bool {
+	transcriptLock.Lock()
+	defer transcriptLock.Unlock()
+	if transcriptWatchers[screenId] {
+		return false
+	}
+	transcriptWatchers[screenId] = true
+	updateCh := scbus.MainUpdateBus.RegisterChannel(transcriptChannelPrefix+screenId, &scbus.UpdateChannel{ScreenId: screenId})
+	go runTranscriptWatcher(screenId, updateCh)
+	return true
+}
+
+func stopTranscriptWatcher(screenId string) bool {
+	transcriptLock.Lock()
+	defer transcriptLock.Unlock()
+	if !transcriptWatchers[screenId] {
+		return false
+	}
+	delete(transcriptWatchers, screenId)
+	scbus.MainUpdateBus.UnregisterChannel(transcriptChannelPrefix + screenId)
+	return true
+}
+
+// adds the lineids changed by the update to dirtyLines, removed lines are sent right away
+func collectTranscriptChanges(screenId string, update scbus.UpdatePacket, dirtyLines map[string]bool) {
+	switch upk := update.(type) {
+	case *scbus.PtyDataUpdatePacketType:
+		if upk.Data != nil && upk.Data.ScreenId == screenId && upk.Data.LineId != "" {
+			dirtyLines[upk.Data.LineId] = true
+		}
+	case *scbus.ModelUpdatePacketType:
+		if upk.IsEmpty() {
+			return
+		}
+		var removedIds []string
+		for _, item := range *upk.Data {
+			switch uitem := item.(type) {
+			case sstore.LineUpdate:
+				if uitem.Line.ScreenId != screenId {
+					continue
+				}
+				if uitem.Line.Remove || uitem.Line.Archived {
+					removedIds = append(removedIds, uitem.Line.LineId)
+					delete(dirtyLines, uitem.Line.LineId)
+				} else {
+					dirtyLines[uitem.Line.LineId] = true
+				}
+			case sstore.CmdType:
+				if uitem.ScreenId == screenId {
+					dirtyLines[uitem.LineId] = true
+				}
+			}
+		}
+		if len(removedIds) > 0 {
+			sendTranscriptUpdate(sstore.TranscriptUpdateType{ScreenId: screenId, RemoveLineIds: removedIds})
+		}
+	}
+}
+
+func flushTranscriptChanges(screenId string, dirtyLines map[string]bool) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFn()
+	tUpdate := sstore.TranscriptUpdateType{ScreenId: screenId}
+	for lineId := range dirtyLines {
+		entry, err := sstore.GetLineTranscriptEntry(ctx, screenId, lineId)
+		if err != nil {
+			log.Printf("[transcript] error getting transcript entry for line %s: %v\n", lineId, err)
+			continue
+		}
+		if entry == nil {
+			tUpdate.RemoveLineIds = append(tUpdate.RemoveLineIds, lineId)
+		} else {
+			tUpdate.Entries = append(tUpdate.Entries, entry)
+		}
+	}
+	if len(tUpdate.Entries) > 0 || len(tUpdate.RemoveLineIds) > 0 {
+		sendTranscriptUpdate(tUpdate)
+	}
+}
+
+func runTranscriptWatcher(screenId string, updateCh chan scbus.UpdatePacket) {
+	ticker := time.NewTicker(TranscriptFlushTime)
+	defer ticker.Stop()
+	dirtyLines := make(map[string]bool)
+	for {
+		select {
+		case update, ok := <-updateCh:
+			if !ok {
+				return
+			}
+			collectTranscriptChanges(screenId, update, dirtyLines)
+
+		case <-ticker.C:
+			if len(dirtyLines) > 0 {
+				flushTranscriptChanges(screenId, dirtyLines)
+				dirtyLines = make(map[string]bool)
+			}
+		}
+	}
+}
+
+// /screen:transcript [1|0], turns reading mode on (sends the full transcript, then incremental updates) or off
+func ScreenTranscriptCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk.Args) > 1 {
+		return nil, fmt.Errorf("usage: /screen:transcript [1|0]")
+	}
+	readingMode := true
+	if len(pk.Args) == 1 {
+		readingMode = resolveBool(pk.Args[0], true)
+	}
+	if !readingMode {
+		stopTranscriptWatcher(ids.ScreenId)
+		return sstore.InfoMsgUpdate("reading mode off"), nil
+	}
+	transcript, err := sstore.GetScreenTranscript(ctx, ids.ScreenId)
+	if err != nil {
+		return nil, fmt.Errorf("/screen:transcript error getting transcript: %v", err)
+	}
+	startTranscriptWatcher(ids.ScreenId)
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.TranscriptUpdateType{ScreenId: ids.ScreenId, Reset: true, Entries: transcript.Entries})
+	return update, nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/blockstore"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+const MaxTransferHistoryLimit = 500
+
+func sendTransferUpdate(transfer *sstore.TransferType) {
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(*transfer)
+	scbus.MainUpdateBus.DoUpdate(update)
+}
+
+// with resume, continues the most recent failed transfer between the same files (if there is one)
+func startTransfer(ctx context.Context, srcWsh *remote.WaveshellProc, srcPath string, dstWsh *remote.WaveshellProc, dstPath string, resume bool) (*sstore.TransferType, error) {
+	if resume {
+		transfer, err := sstore.FindResumableTransfer(ctx, srcWsh.GetRemoteId(), srcPath, dstWsh.GetRemoteId(), dstPath)
+		if err != nil {
+			return nil, err
+		}
+		if transfer != nil {
+			err = sstore.RestartTransfer(ctx, transfer)
+			if err != nil {
+				return nil, err
+			}
+			sendTransferUpdate(transfer)
+			return transfer, nil
+		}
+	}
+	transfer := &sstore.TransferType{
+		TransferId:  scbase.GenWaveUUID(),
+		SrcRemoteId: srcWsh.GetRemoteId(),
+		SrcPath:     srcPath,
+		DstRemoteId: dstWsh.GetRemoteId(),
+		DstPath:     dstPath,
+		Status:      sstore.TransferStatus_Running,
+		StartTs:     time.Now().UnixMilli(),
+	}
+	err := sstore.InsertTransfer(ctx, transfer)
+	if err != nil {
+		return nil, err
+	}
+	sendTransferUpdate(transfer)
+	return transfer, nil
+}
+
+// the staging data is kept for failed transfers so they can be resumed
+func finishTransfer(ctx context.Context, transfer *sstore.TransferType, result *remote.CopyResult, copyErr error) {
+	if result != nil {
+		transfer.TotalBytes = result.TotalBytes
+		transfer.StartOffset = result.StartOffset
+		transfer.BytesDone = result.StartOffset + result.BytesCopied
+		transfer.Sha256 = result.Sha256
+	}
+	keepStaging := false
+	if copyErr == nil {
+		transfer.Status = sstore.TransferStatus_Done
+	} else if errors.Is(copyErr, remote.ErrChecksumMismatch) {
+		transfer.Status = sstore.TransferStatus_VerifyFailed
+		transfer.ErrorStr = copyErr.Error()
+	} else {
+		transfer.Status = sstore.TransferStatus_Error
+		transfer.ErrorStr = copyErr.Error()
+		keepStaging = true
+	}
+	if !keepStaging {
+		err := blockstore.DeleteBlock(ctx, transfer.TransferId)
+		if err != nil {
+			log.Printf("error removing transfer staging data %s: %v\n", transfer.TransferId, err)
+		}
+	}
+	err := sstore.FinishTransfer(ctx, transfer)
+	if err != nil {
+		log.Printf("error updating transfer %s: %v\n", transfer.TransferId, err)
+		return
+	}
+	sendTransferUpdate(transfer)
+}
+
+func formatTransferRemote(remoteId string) string {
+	wsh := remote.GetRemoteById(remoteId)
+	if wsh == nil {
+		return remoteId[0:8]
+	}
+	return wsh.GetRemoteName()
+}
+
+// /transfer:history [limit=n]
+func TransferHistoryCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	limit := sstore.DefaultTransferHistoryLimit
+	if pk.Kwargs["limit"] != "" {
+		var err error
+		limit, err = strconv.Atoi(pk.Kwargs["limit"])
+		if err != nil || limit <= 0 || limit > MaxTransferHistoryLimit {
+			return nil, fmt.Errorf("/transfer:history invalid limit %q, must be a number between 1 and %d", pk.Kwargs["limit"], MaxTransferHistoryLimit)
+		}
+	}
+	transfers, err := sstore.GetTransferHistory(ctx, limit)
+	if err != nil {
+		return nil, fmt.Errorf("/transfer:history error: %v", err)
+	}
+	tsFmt := sstore.GetClientTsFormat(ctx)
+	var buf bytes.Buffer
+	if len(transfers) == 0 {
+		buf.WriteString("no file transfers\n")
+	}
+	for _, transfer := range transfers {
+		tsStr := tsFmt.Format(time.UnixMilli(transfer.StartTs))
+		src := fmt.Sprintf("[%s]:%s", formatTransferRemote(transfer.SrcRemoteId), transfer.SrcPath)
+		dst := fmt.Sprintf("[%s]:%s", formatTransferRemote(transfer.DstRemoteId), transfer.DstPath)
+		buf.WriteString(fmt.Sprintf("%s  %-12s %10s  %s -> %s\n", tsStr, transfer.Status, prettyPrintByteSize(transfer.BytesDone), src, dst))
+		if transfer.ErrorStr != "" {
+			buf.WriteString(fmt.Sprintf("    error: %s\n", transfer.ErrorStr))
+		}
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.TransferHistoryType{Transfers: transfers})
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: "file transfers",
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// deleted screens and sessions (see sstore/trash.go)
+
+const trashIdShortLen = 8
+
+// arg is a trashid (or a unique trashid prefix), or the name of a trashed screen or session (the most recently
+// deleted one if there is more than one).  items must be sorted newest first.
+func resolveTrashItem(items []*sstore.TrashItemType, arg string) (*sstore.TrashItemType, error) {
+	var prefixMatches []*sstore.TrashItemType
+	for _, item := range items {
+		if item.TrashId == arg {
+			return item, nil
+		}
+		if strings.HasPrefix(item.TrashId, arg) {
+			prefixMatches = append(prefixMatches, item)
+		}
+	}
+	if len(prefixMatches) == 1 {
+		return prefixMatches[0], nil
+	}
+	if len(prefixMatches) > 1 {
+		return nil, fmt.Errorf("trashid prefix %q is ambiguous", arg)
+	}
+	for _, item := range items {
+		if item.Name == arg {
+			return item, nil
+		}
+	}
+	return nil, fmt.Errorf("%q not found in trash", arg)
+}
+
+func resolveTrashArg(ctx context.Context, pk *scpacket.FeCommandPacketType, cmdName string) (*sstore.TrashItemType, error) {
+	if len(pk.Args) == 0 || pk.Args[0] == "" {
+		return nil, fmt.Errorf("usage: /%s [trashid or name], see /trash:show", cmdName)
+	}
+	items, err := sstore.GetTrashItems(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resolveTrashItem(items, pk.Args[0])
+}
+
+func TrashShowCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	clientData, err := sstore.EnsureClientData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	items, err := sstore.GetTrashItems(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("/trash:show error: %v", err)
+	}
+	trashDays := sstore.GetTrashDays(clientData.ClientOpts)
+	if len(items) == 0 {
+		return sstore.InfoMsgUpdate("trash is empty (deleted screens and sessions are kept for %d days)", trashDays), nil
+	}
+	tsFmt := sstore.GetClientTsFormat(ctx)
+	var buf bytes.Buffer
+	for _, item := range items {
+		name := item.Name
+		if item.ObjType == sstore.TrashObjType_Session {
+			numScreens, err := sstore.GetTrashSessionNumScreens(ctx, item.TrashId)
+			if err != nil {
+				return nil, err
+			}
+			name = fmt.Sprintf("%s (%d screens)", item.Name, numScreens)
+		}
+		tsStr := tsFmt.Format(time.UnixMilli(item.DeletedTs))
+		buf.WriteString(fmt.Sprintf("  %-8s  %-7s  %-30s  deleted %s\n", item.TrashId[:trashIdShortLen], item.ObjType, name, tsStr))
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: fmt.Sprintf("trash (kept for %d days, /trash:restore [trashid or name] to restore)", trashDays),
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
+
+// /trash:restore [trashid or name]
+func TrashRestoreCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	item, err := resolveTrashArg(ctx, pk, "trash:restore")
+	if err != nil {
+		return nil, err
+	}
+	var update *scbus.ModelUpdatePacketType
+	if item.ObjType == sstore.TrashObjType_Session {
+		update, err = sstore.RestoreSession(ctx, item.TrashId)
+	} else {
+		update, err = sstore.RestoreScreen(ctx, item.TrashId)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("/trash:restore error: %v", err)
+	}
+	update.AddUpdate(sstore.InfoMsgType{InfoMsg: fmt.Sprintf("restored %s %q", item.ObjType, item.Name), TimeoutMs: 2000})
+	return update, nil
+}
+
+// /trash:purge [trashid or name | all], permanently deletes the item (or everything in the trash)
+func TrashPurgeCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	if len(pk.Args) > 0 && pk.Args[0] == "all" {
+		numPurged, err := sstore.PurgeTrash(ctx, 0)
+		if err != nil {
+			return nil, fmt.Errorf("/trash:purge error: %v", err)
+		}
+		return sstore.InfoMsgUpdate("purged %d items from the trash", numPurged), nil
+	}
+	item, err := resolveTrashArg(ctx, pk, "trash:purge")
+	if err != nil {
+		return nil, err
+	}
+	err = sstore.PurgeTrashItem(ctx, item.TrashId)
+	if err != nil {
+		return nil, fmt.Errorf("/trash:purge error: %v", err)
+	}
+	return sstore.InfoMsgUpdate("purged %s %q from the trash", item.ObjType, item.Name), nil
+}
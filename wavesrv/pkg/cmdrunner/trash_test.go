@@ -0,0 +1,39 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"testing"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func TestResolveTrashItem(t *testing.T) {
+	items := []*sstore.TrashItemType{
+		{TrashId: "aaaa1111-0000", ObjType: sstore.TrashObjType_Screen, Name: "s1"},
+		{TrashId: "aaaa2222-0000", ObjType: sstore.TrashObjType_Session, Name: "work"},
+		{TrashId: "bbbb1111-0000", ObjType: sstore.TrashObjType_Screen, Name: "s1"},
+	}
+	item, err := resolveTrashItem(items, "bbbb1111-0000")
+	if err != nil || item != items[2] {
+		t.Errorf("trashid got %v %v", item, err)
+	}
+	item, err = resolveTrashItem(items, "aaaa2")
+	if err != nil || item != items[1] {
+		t.Errorf("prefix got %v %v", item, err)
+	}
+	_, err = resolveTrashItem(items, "aaaa")
+	if err == nil {
+		t.Errorf("expected an error for an ambiguous prefix")
+	}
+	// newest (first) match by name
+	item, err = resolveTrashItem(items, "s1")
+	if err != nil || item != items[0] {
+		t.Errorf("name got %v %v", item, err)
+	}
+	_, err = resolveTrashItem(items, "missing")
+	if err == nil {
+		t.Errorf("expected a not found error")
+	}
+}
@@ -6,8 +6,13 @@ package cmdrunner
 import (
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/bookmarks"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/history"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
 )
 
+func init() {
+	scbus.RegisterModelUpdateItem(MainViewUpdate{})
+}
+
 type MainViewUpdate struct {
 	MainView      string                     `json:"mainview"`
 	HistoryView   *history.HistoryViewData   `json:"historyview,omitempty"`
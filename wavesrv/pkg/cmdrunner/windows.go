@@ -0,0 +1,165 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func getUIWindowId(pk *scpacket.FeCommandPacketType) string {
+	if pk.UIContext == nil {
+		return sstore.MainWindowId
+	}
+	return pk.UIContext.WindowId
+}
+
+// the main window uses the (global) activesessionid update, other windows get a window update
+func addActiveSessionUpdate(ctx context.Context, update *scbus.ModelUpdatePacketType, windowId string, sessionId string) error {
+	if windowId == sstore.MainWindowId {
+		update.AddUpdate(sstore.ActiveSessionIdUpdate(sessionId))
+		return nil
+	}
+	window, err := sstore.GetWindowById(ctx, windowId)
+	if err != nil {
+		return fmt.Errorf("cannot get window: %w", err)
+	}
+	if window == nil {
+		return fmt.Errorf("window not found")
+	}
+	update.AddUpdate(*window)
+	return nil
+}
+
+// resolves a window by id or id prefix ("main" for the main window)
+func resolveWindowId(ctx context.Context, windowArg string) (string, error) {
+	if windowArg == "" || windowArg == "main" {
+		return sstore.MainWindowId, nil
+	}
+	windows, err := sstore.GetAllWindows(ctx)
+	if err != nil {
+		return "", err
+	}
+	var rtn string
+	for _, window := range windows {
+		if window.WindowId == windowArg || window.Name == windowArg {
+			return window.WindowId, nil
+		}
+		if len(windowArg) >= 4 && strings.HasPrefix(window.WindowId, windowArg) {
+			if rtn != "" {
+				return "", fmt.Errorf("ambiguous window id %q", windowArg)
+			}
+			rtn = window.WindowId
+		}
+	}
+	if rtn == "" {
+		return "", fmt.Errorf("window %q not found", windowArg)
+	}
+	return rtn, nil
+}
+
+// /window:new [name] [session=session], creates a window showing the session (defaults to the current session)
+func WindowNewCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, 0)
+	if err != nil {
+		return nil, err
+	}
+	name := firstArg(pk)
+	if len(name) > MaxNameLen {
+		return nil, fmt.Errorf("/window:new name too long, max length is %d", MaxNameLen)
+	}
+	sessionId := ids.SessionId
+	if sessionArg, found := pk.Kwargs["session"]; found {
+		ritem, err := resolveSession(ctx, sessionArg, ids.SessionId)
+		if err != nil {
+			return nil, fmt.Errorf("/window:new invalid session: %w", err)
+		}
+		sessionId = ritem.Id
+	}
+	window, err := sstore.CreateWindow(ctx, name, sessionId)
+	if err != nil {
+		return nil, fmt.Errorf("/window:new error: %w", err)
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(*window)
+	return update, nil
+}
+
+// /window:close [window], defaults to the current window
+func WindowCloseCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	windowId := getUIWindowId(pk)
+	if len(pk.Args) > 0 {
+		var err error
+		windowId, err = resolveWindowId(ctx, pk.Args[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	update, err := sstore.CloseWindow(ctx, windowId)
+	if err != nil {
+		return nil, fmt.Errorf("/window:close error: %w", err)
+	}
+	return update, nil
+}
+
+// /window:movescreen [screen] window=[window], shows the screen in the window
+func WindowMoveScreenCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	ids, err := resolveUiIds(ctx, pk, R_Session|R_Screen)
+	if err != nil {
+		return nil, err
+	}
+	if _, found := pk.Kwargs["window"]; !found {
+		return nil, fmt.Errorf("usage: /window:movescreen [screen] window=[window]")
+	}
+	windowId, err := resolveWindowId(ctx, pk.Kwargs["window"])
+	if err != nil {
+		return nil, err
+	}
+	screenId := ids.ScreenId
+	if len(pk.Args) > 0 {
+		ritem, err := resolveSessionScreen(ctx, ids.SessionId, pk.Args[0], ids.ScreenId)
+		if err != nil {
+			return nil, err
+		}
+		screenId = ritem.Id
+	}
+	update, err := sstore.MoveScreenToWindow(ctx, screenId, windowId)
+	if err != nil {
+		return nil, fmt.Errorf("/window:movescreen error: %w", err)
+	}
+	return update, nil
+}
+
+func WindowShowAllCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	windows, err := sstore.GetAllWindows(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("/window:showall error: %w", err)
+	}
+	curWindowId := getUIWindowId(pk)
+	var buf bytes.Buffer
+	curStr := " "
+	if curWindowId == sstore.MainWindowId {
+		curStr = "*"
+	}
+	buf.WriteString(fmt.Sprintf("%s %-8s %s\n", curStr, "main", "(main window)"))
+	for _, window := range windows {
+		curStr = " "
+		if window.WindowId == curWindowId {
+			curStr = "*"
+		}
+		buf.WriteString(fmt.Sprintf("%s %-8s %s\n", curStr, window.WindowId[0:8], window.Name))
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: "all windows",
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cmdrunner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/utilfn"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+const WSLListTimeout = 10 * time.Second
+
+// returns the distros (RemoteHost) of the non-archived WSL remotes
+func getWSLRemoteDistros() map[string]bool {
+	rtn := make(map[string]bool)
+	for _, wsh := range remote.GetRemoteMap() {
+		rcopy := wsh.GetRemoteCopy()
+		if rcopy.IsWSL() && !rcopy.Archived {
+			rtn[rcopy.RemoteHost] = true
+		}
+	}
+	return rtn
+}
+
+func addWSLRemote(ctx context.Context, pk *scpacket.FeCommandPacketType, distro string, shouldStart bool) (*sstore.RemoteType, error) {
+	r := remote.MakeWSLRemote(distro, pk.Kwargs["user"])
+	r.RemoteId = scbase.GenWaveUUID()
+	if alias, found := pk.Kwargs["alias"]; found {
+		if len(alias) > MaxRemoteAliasLen {
+			return nil, fmt.Errorf("alias too long, max length = %d", MaxRemoteAliasLen)
+		}
+		if alias != "" && !remoteAliasRe.MatchString(alias) {
+			return nil, fmt.Errorf("invalid alias format")
+		}
+		r.RemoteAlias = alias
+	}
+	if connectMode := pk.Kwargs["connectmode"]; connectMode != "" {
+		if !sstore.IsValidConnectMode(connectMode) {
+			return nil, fmt.Errorf("invalid connectmode %q: valid modes are %s", connectMode, formatStrs([]string{sstore.ConnectModeStartup, sstore.ConnectModeAuto, sstore.ConnectModeManual}, "or", false))
+		}
+		r.ConnectMode = connectMode
+	}
+	err := remote.AddRemote(ctx, r, shouldStart)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// /remote:wsl [add=distro [user=user] [alias=alias] [connectmode=mode]] [import=1]
+// no args lists the installed WSL distributions, import=1 creates remotes for all distributions without one
+func RemoteWSLCommand(ctx context.Context, pk *scpacket.FeCommandPacketType) (scbus.UpdatePacket, error) {
+	listCtx, cancelFn := context.WithTimeout(ctx, WSLListTimeout)
+	defer cancelFn()
+	distros, err := remote.ListWSLDistros(listCtx)
+	if err != nil {
+		return nil, fmt.Errorf("/remote:wsl %v", err)
+	}
+	if distro, found := pk.Kwargs["add"]; found {
+		if !utilfn.ContainsStr(distros, distro) {
+			return nil, fmt.Errorf("/remote:wsl WSL distribution %q not found (installed: %s)", distro, formatStrs(distros, "and", false))
+		}
+		r, err := addWSLRemote(ctx, pk, distro, true)
+		if err != nil {
+			return nil, fmt.Errorf("/remote:wsl cannot create remote: %v", err)
+		}
+		return createRemoteViewRemoteIdUpdate(r.RemoteId), nil
+	}
+	var buf bytes.Buffer
+	existing := getWSLRemoteDistros()
+	if resolveBool(pk.Kwargs["import"], false) {
+		if pk.Kwargs["user"] != "" || pk.Kwargs["alias"] != "" {
+			return nil, fmt.Errorf("/remote:wsl user and alias can only be set when adding a single distribution")
+		}
+		for _, distro := range distros {
+			if existing[distro] {
+				continue
+			}
+			r, err := addWSLRemote(ctx, pk, distro, false)
+			if err != nil {
+				buf.WriteString(fmt.Sprintf("error importing %q: %v\n", distro, err))
+				continue
+			}
+			existing[distro] = true
+			buf.WriteString(fmt.Sprintf("created remote %s\n", r.RemoteCanonicalName))
+		}
+	}
+	if len(distros) == 0 {
+		buf.WriteString("no WSL distributions installed\n")
+	}
+	for _, distro := range distros {
+		status := "-"
+		if existing[distro] {
+			status = "remote"
+		}
+		buf.WriteString(fmt.Sprintf("  %-30s %s\n", distro, status))
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(sstore.InfoMsgType{
+		InfoTitle: "WSL distributions",
+		InfoLines: splitLinesForInfo(buf.String()),
+	})
+	return update, nil
+}
@@ -6,6 +6,7 @@ package dbutil
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/sawka/txwrap"
@@ -96,6 +97,17 @@ func SelectMapsGen[PT MapConverterPtr[T], T any](tx *txwrap.TxWrap, query string
 	return rtn
 }
 
+// inserts m as a row of table (the keys are the column names), used to copy rows between dbs with the same schema
+func InsertMap(tx *txwrap.TxWrap, table string, m map[string]interface{}) {
+	var cols []string
+	for col := range m {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	query := fmt.Sprintf(`INSERT INTO %s (%s) VALUES (:%s)`, table, strings.Join(cols, ", "), strings.Join(cols, ", :"))
+	tx.NamedExec(query, m)
+}
+
 func SelectSimpleMap[T any](tx *txwrap.TxWrap, query string, args ...interface{}) map[string]T {
 	var rtn []MapEntry[T]
 	tx.Select(&rtn, query, args...)
@@ -0,0 +1,45 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package history
+
+import (
+	"context"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// the history items of the history feed updates (see sstore/feed.go), purged items are returned as Removed
+
+type HistoryFeedItemType struct {
+	UpdateId  int64            `json:"updateid"`
+	HistoryId string           `json:"historyid"`
+	Removed   bool             `json:"removed,omitempty"`
+	Item      *HistoryItemType `json:"item,omitempty"`
+}
+
+func GetHistoryFeedItems(ctx context.Context, updates []*sstore.FeedUpdateType) ([]*HistoryFeedItemType, error) {
+	var historyIds []string
+	for _, update := range updates {
+		historyIds = append(historyIds, update.HistoryId)
+	}
+	hitems, err := sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) ([]*HistoryItemType, error) {
+		query := `SELECT * FROM history WHERE historyid IN (SELECT value FROM json_each(?))`
+		return dbutil.SelectMapsGen[*HistoryItemType](tx, query, dbutil.QuickJsonArr(historyIds)), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	hitemMap := make(map[string]*HistoryItemType)
+	for _, hitem := range hitems {
+		hitemMap[hitem.HistoryId] = hitem
+	}
+	var rtn []*HistoryFeedItemType
+	for _, update := range updates {
+		item := &HistoryFeedItemType{UpdateId: update.UpdateId, HistoryId: update.HistoryId, Item: hitemMap[update.HistoryId]}
+		item.Removed = (item.Item == nil)
+		rtn = append(rtn, item)
+	}
+	return rtn, nil
+}
@@ -36,6 +36,10 @@ type HistoryItemType struct {
 
 	// transient (string because of different history orderings)
 	HistoryNum string `json:"historynum" dbmap:"-"`
+
+	// transient, set by queries with UseCounts / Dedup
+	UseCount    int `json:"usecount,omitempty" dbmap:"-"`    // matching items with the same command
+	RepeatCount int `json:"repeatcount,omitempty" dbmap:"-"` // identical consecutive items that were skipped
 }
 
 func (h *HistoryItemType) ToMap() map[string]interface{} {
@@ -81,6 +85,9 @@ func (h *HistoryItemType) FromMap(m map[string]interface{}) bool {
 	dbutil.QuickSetJson(&h.FeState, m, "festate")
 	dbutil.QuickSetJson(&h.Tags, m, "tags")
 	dbutil.QuickSetStr(&h.Status, m, "status")
+	var useCount int64
+	dbutil.QuickSetInt64(&useCount, m, "usecount")
+	h.UseCount = int(useCount)
 	return true
 }
 
@@ -88,15 +95,35 @@ type HistoryQueryOpts struct {
 	Offset     int
 	MaxItems   int
 	FromTs     int64
+	MinTs      int64
 	SearchText string
 	SessionId  string
 	RemoteId   string
 	ScreenId   string
 	NoMeta     bool
+	Archived   bool   // only items tagged as archived (see RepairHistory)
+	Cwd        string // the directory the command was run in (festate cwd)
+	Status     string // sstore.CmdStatus*
+	ExitCode   *int64
+	Dedup      bool // skips identical consecutive commands (counted in RepeatCount of the item that is kept)
+	UseCounts  bool // sets UseCount (the number of items matching the query with the same command)
 	RawOffset  int
 	FilterFn   func(*HistoryItemType) bool
 }
 
+var validHistoryStatuses = map[string]bool{
+	sstore.CmdStatusRunning:  true,
+	sstore.CmdStatusDetached: true,
+	sstore.CmdStatusError:    true,
+	sstore.CmdStatusDone:     true,
+	sstore.CmdStatusHangup:   true,
+	sstore.CmdStatusUnknown:  true,
+}
+
+func IsValidHistoryStatus(status string) bool {
+	return validHistoryStatuses[status]
+}
+
 type HistoryQueryResult struct {
 	MaxItems      int
 	Items         []*HistoryItemType
@@ -105,7 +132,9 @@ type HistoryQueryResult struct {
 	HasMore       bool
 	NextRawOffset int // internal offset used by pager for next query
 
-	prevItems int // holds number of items skipped by RawOffset
+	prevItems  int // holds number of items skipped by RawOffset
+	prevCmdStr string
+	hasPrev    bool
 }
 
 type HistoryViewData struct {
@@ -130,11 +159,45 @@ func InsertHistoryItem(ctx context.Context, hitem *HistoryItemType) error {
                   ( historyid, ts, userid, sessionid, screenid, lineid, haderror, cmdstr, remoteownerid, remoteid, remotename, ismetacmd, linenum, exitcode, durationms, festate, tags, status) VALUES
                   (:historyid,:ts,:userid,:sessionid,:screenid,:lineid,:haderror,:cmdstr,:remoteownerid,:remoteid,:remotename,:ismetacmd,:linenum,:exitcode,:durationms,:festate,:tags,:status)`
 		tx.NamedExec(query, hitem.ToMap())
+		sstore.InsertFeedHistoryUpdate(tx, hitem.ScreenId, hitem.LineId, hitem.HistoryId)
 		return nil
 	})
 	return txErr
 }
 
+// inserts the items that are not in the history yet (same historyid, or same screenid/lineid/ts), used to merge
+// the history synced from other installations.  returns the number of items inserted.
+func MergeHistoryItems(ctx context.Context, hitems []*HistoryItemType) (int, error) {
+	if len(hitems) == 0 {
+		return 0, nil
+	}
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) (int, error) {
+		var numInserted int
+		for _, hitem := range hitems {
+			if hitem == nil || hitem.HistoryId == "" {
+				continue
+			}
+			query := `SELECT historyid FROM history WHERE historyid = ?`
+			if tx.Exists(query, hitem.HistoryId) {
+				continue
+			}
+			if hitem.LineId != "" {
+				query = `SELECT historyid FROM history WHERE screenid = ? AND lineid = ? AND ts = ?`
+				if tx.Exists(query, hitem.ScreenId, hitem.LineId, hitem.Ts) {
+					continue
+				}
+			}
+			query = `INSERT INTO history 
+                  ( historyid, ts, userid, sessionid, screenid, lineid, haderror, cmdstr, remoteownerid, remoteid, remotename, ismetacmd, linenum, exitcode, durationms, festate, tags, status) VALUES
+                  (:historyid,:ts,:userid,:sessionid,:screenid,:lineid,:haderror,:cmdstr,:remoteownerid,:remoteid,:remotename,:ismetacmd,:linenum,:exitcode,:durationms,:festate,:tags,:status)`
+			tx.NamedExec(query, hitem.ToMap())
+			sstore.InsertFeedHistoryUpdate(tx, hitem.ScreenId, hitem.LineId, hitem.HistoryId)
+			numInserted++
+		}
+		return numInserted, nil
+	})
+}
+
 const HistoryQueryChunkSize = 1000
 
 func _getNextHistoryItem(items []*HistoryItemType, index int, filterFn func(*HistoryItemType) bool) (*HistoryItemType, int) {
@@ -147,6 +210,17 @@ func _getNextHistoryItem(items []*HistoryItemType, index int, filterFn func(*His
 	return nil, index
 }
 
+// returns true if the item repeats the previous command (the repeat is counted on the item in the result)
+func (result *HistoryQueryResult) dedupItem(item *HistoryItemType) bool {
+	isRepeat := result.hasPrev && item.CmdStr == result.prevCmdStr
+	result.prevCmdStr = item.CmdStr
+	result.hasPrev = true
+	if isRepeat && len(result.Items) > 0 && !result.HasMore {
+		result.Items[len(result.Items)-1].RepeatCount++
+	}
+	return isRepeat
+}
+
 // returns true if done, false if we still need to process more items
 func (result *HistoryQueryResult) processItem(item *HistoryItemType, rawOffset int) bool {
 	if result.prevItems < result.Offset {
@@ -177,6 +251,17 @@ func runHistoryQueryWithFilter(tx *sstore.TxWrap, opts HistoryQueryOpts) (*Histo
 	} else {
 		rawOffset = 0
 	}
+	if opts.Dedup && rawOffset > 0 {
+		// the last item of the previous page, so a page does not start with a repeat
+		prevItems, err := runHistoryQuery(tx, opts, rawOffset-1, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(prevItems) > 0 && (opts.FilterFn == nil || opts.FilterFn(prevItems[0])) {
+			rtn.prevCmdStr = prevItems[0].CmdStr
+			rtn.hasPrev = true
+		}
+	}
 	for {
 		resultItems, err := runHistoryQuery(tx, opts, rawOffset, HistoryQueryChunkSize)
 		if err != nil {
@@ -187,6 +272,9 @@ func runHistoryQueryWithFilter(tx *sstore.TxWrap, opts HistoryQueryOpts) (*Histo
 			if opts.FilterFn != nil && !opts.FilterFn(resultItems[resultIdx]) {
 				continue
 			}
+			if opts.Dedup && rtn.dedupItem(resultItems[resultIdx]) {
+				continue
+			}
 			isDone = rtn.processItem(resultItems[resultIdx], rawOffset+resultIdx)
 			if isDone {
 				break
@@ -203,26 +291,30 @@ func runHistoryQueryWithFilter(tx *sstore.TxWrap, opts HistoryQueryOpts) (*Histo
 	return rtn, nil
 }
 
-func runHistoryQuery(tx *sstore.TxWrap, opts HistoryQueryOpts, realOffset int, itemLimit int) ([]*HistoryItemType, error) {
+// builds the WHERE clause of the query (without the paging), hNumStr is the prefix of the historynum
+func historyWhereClause(opts HistoryQueryOpts) (string, []interface{}, string, error) {
 	// check sessionid/screenid format because we are directly inserting them into the SQL
 	if opts.SessionId != "" {
 		_, err := uuid.Parse(opts.SessionId)
 		if err != nil {
-			return nil, fmt.Errorf("malformed sessionid")
+			return "", nil, "", fmt.Errorf("malformed sessionid")
 		}
 	}
 	if opts.ScreenId != "" {
 		_, err := uuid.Parse(opts.ScreenId)
 		if err != nil {
-			return nil, fmt.Errorf("malformed screenid")
+			return "", nil, "", fmt.Errorf("malformed screenid")
 		}
 	}
 	if opts.RemoteId != "" {
 		_, err := uuid.Parse(opts.RemoteId)
 		if err != nil {
-			return nil, fmt.Errorf("malformed remoteid")
+			return "", nil, "", fmt.Errorf("malformed remoteid")
 		}
 	}
+	if opts.Status != "" && !IsValidHistoryStatus(opts.Status) {
+		return "", nil, "", fmt.Errorf("invalid status %q", opts.Status)
+	}
 	whereClause := "WHERE 1"
 	var queryArgs []interface{}
 	hNumStr := ""
@@ -232,6 +324,10 @@ func runHistoryQuery(tx *sstore.TxWrap, opts HistoryQueryOpts, realOffset int, i
 	} else if opts.SessionId != "" {
 		whereClause += fmt.Sprintf(" AND h.sessionid = '%s'", opts.SessionId)
 		hNumStr = "s"
+	} else if opts.ScreenId != "" {
+		// historical screens (the session may no longer exist)
+		whereClause += fmt.Sprintf(" AND h.screenid = '%s'", opts.ScreenId)
+		hNumStr = ""
 	} else {
 		hNumStr = "g"
 	}
@@ -245,13 +341,42 @@ func runHistoryQuery(tx *sstore.TxWrap, opts HistoryQueryOpts, realOffset int, i
 	if opts.FromTs > 0 {
 		whereClause += fmt.Sprintf(" AND h.ts <= %d", opts.FromTs)
 	}
+	if opts.MinTs > 0 {
+		whereClause += fmt.Sprintf(" AND h.ts >= %d", opts.MinTs)
+	}
 	if opts.RemoteId != "" {
 		whereClause += fmt.Sprintf(" AND h.remoteid = '%s'", opts.RemoteId)
 	}
+	if opts.Cwd != "" {
+		whereClause += " AND json_extract(h.festate, '$.cwd') = ?"
+		queryArgs = append(queryArgs, opts.Cwd)
+	}
+	if opts.Status != "" {
+		whereClause += " AND h.status = ?"
+		queryArgs = append(queryArgs, opts.Status)
+	}
+	if opts.ExitCode != nil {
+		whereClause += fmt.Sprintf(" AND h.exitcode = %d", *opts.ExitCode)
+	}
 	if opts.NoMeta {
 		whereClause += " AND NOT h.ismetacmd"
 	}
-	query := fmt.Sprintf("SELECT %s, ('%s' || CAST((row_number() OVER win) as text)) historynum FROM history h %s WINDOW win AS (ORDER BY h.ts, h.historyid) ORDER BY h.ts DESC, h.historyid DESC LIMIT %d OFFSET %d", HistoryCols, hNumStr, whereClause, itemLimit, realOffset)
+	if opts.Archived {
+		whereClause += " AND json_extract(h.tags, '$." + HistoryTag_Archived + "')"
+	}
+	return whereClause, queryArgs, hNumStr, nil
+}
+
+func runHistoryQuery(tx *sstore.TxWrap, opts HistoryQueryOpts, realOffset int, itemLimit int) ([]*HistoryItemType, error) {
+	whereClause, queryArgs, hNumStr, err := historyWhereClause(opts)
+	if err != nil {
+		return nil, err
+	}
+	useCountStr := ""
+	if opts.UseCounts {
+		useCountStr = ", (count(*) OVER (PARTITION BY h.cmdstr)) usecount"
+	}
+	query := fmt.Sprintf("SELECT %s, ('%s' || CAST((row_number() OVER win) as text)) historynum%s FROM history h %s WINDOW win AS (ORDER BY h.ts, h.historyid) ORDER BY h.ts DESC, h.historyid DESC LIMIT %d OFFSET %d", HistoryCols, hNumStr, useCountStr, whereClause, itemLimit, realOffset)
 	marr := tx.SelectMaps(query, queryArgs...)
 	rtn := make([]*HistoryItemType, len(marr))
 	for idx, m := range marr {
@@ -307,7 +432,7 @@ func GetLineCmdsFromHistoryItems(ctx context.Context, historyItems []*HistoryIte
 	if len(historyItems) == 0 {
 		return nil, nil, nil
 	}
-	return sstore.WithTxRtn3(ctx, func(tx *sstore.TxWrap) ([]*sstore.LineType, []*sstore.CmdType, error) {
+	lineArr, cmdArr, err := sstore.WithTxRtn3(ctx, func(tx *sstore.TxWrap) ([]*sstore.LineType, []*sstore.CmdType, error) {
 		lineIdsJsonArr := dbutil.QuickJsonArr(getLineIdsFromHistoryItems(historyItems))
 		query := `SELECT * FROM line WHERE lineid IN (SELECT value FROM json_each(?))`
 		lineArr := dbutil.SelectMappable[*sstore.LineType](tx, query, lineIdsJsonArr)
@@ -315,11 +440,20 @@ func GetLineCmdsFromHistoryItems(ctx context.Context, historyItems []*HistoryIte
 		cmdArr := dbutil.SelectMapsGen[*sstore.CmdType](tx, query, lineIdsJsonArr)
 		return lineArr, cmdArr, nil
 	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sstore.HydrateLineStates(ctx, lineArr)
+	return lineArr, cmdArr, nil
 }
 
 func PurgeHistoryByIds(ctx context.Context, historyIds []string) error {
 	return sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
-		query := `DELETE FROM history WHERE historyid IN (SELECT value FROM json_each(?))`
+		query := `SELECT * FROM history WHERE historyid IN (SELECT value FROM json_each(?))`
+		for _, hitem := range dbutil.SelectMapsGen[*HistoryItemType](tx, query, dbutil.QuickJsonArr(historyIds)) {
+			sstore.InsertFeedHistoryUpdate(tx, hitem.ScreenId, hitem.LineId, hitem.HistoryId)
+		}
+		query = `DELETE FROM history WHERE historyid IN (SELECT value FROM json_each(?))`
 		tx.Exec(query, dbutil.QuickJsonArr(historyIds))
 		return nil
 	})
@@ -0,0 +1,79 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package history
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// the distinct commands matching a history query, ranked by usage (frecency, same score as the suggestions).
+// the query options scope the ranking (remote, cwd, screen, session, time range, status...), Offset/MaxItems page
+// through the ranked commands.
+
+type HistoryCmdRankType struct {
+	CmdStr    string  `json:"cmdstr"`
+	UseCount  int     `json:"usecount"`
+	NumErrors int     `json:"numerrors"`
+	LastTs    int64   `json:"lastts"`
+	Score     float64 `json:"score"`
+}
+
+type historyRankRow struct {
+	CmdStr    string `db:"cmdstr"`
+	UseCount  int    `db:"usecount"`
+	NumErrors int    `db:"numerrors"`
+	LastTs    int64  `db:"lastts"`
+}
+
+// returns the page of ranked commands and whether there are more
+func GetRankedHistoryCmds(ctx context.Context, opts HistoryQueryOpts) ([]*HistoryCmdRankType, bool, error) {
+	if opts.MaxItems <= 0 {
+		return nil, false, fmt.Errorf("invalid query, maxitems is 0")
+	}
+	whereClause, queryArgs, _, err := historyWhereClause(opts)
+	if err != nil {
+		return nil, false, err
+	}
+	var rows []*historyRankRow
+	txErr := sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		query := fmt.Sprintf(`SELECT h.cmdstr, count(*) AS usecount, sum(h.haderror) AS numerrors, max(h.ts) AS lastts
+		                      FROM history h %s AND h.cmdstr != ''
+		                      GROUP BY h.cmdstr`, whereClause)
+		tx.Select(&rows, query, queryArgs...)
+		return nil
+	})
+	if txErr != nil {
+		return nil, false, txErr
+	}
+	now := time.Now().UnixMilli()
+	ranked := make([]*HistoryCmdRankType, 0, len(rows))
+	for _, row := range rows {
+		ranked = append(ranked, &HistoryCmdRankType{
+			CmdStr:    row.CmdStr,
+			UseCount:  row.UseCount,
+			NumErrors: row.NumErrors,
+			LastTs:    row.LastTs,
+			Score:     historyFrecencyScore(row.UseCount, row.LastTs, now),
+		})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].LastTs > ranked[j].LastTs
+	})
+	if opts.Offset >= len(ranked) {
+		return nil, false, nil
+	}
+	ranked = ranked[opts.Offset:]
+	if len(ranked) > opts.MaxItems {
+		return ranked[:opts.MaxItems], true, nil
+	}
+	return ranked, false, nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package history
+
+import (
+	"context"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// history items are kept when their line (or screen) is deleted, the lineid is blanked but the screenid is
+// kept.  items whose screen no longer exists are "historical", they can be tagged as archived (HistoryTag_Archived)
+// which makes them searchable as a group (HistoryQueryOpts.Archived).  items synced from other installations
+// (HistoryTag_Synced, see historysync) reference their screens and lines, they are never repaired or archived.
+
+const HistoryTag_Archived = "archived"
+const HistoryTag_Synced = "synced"
+
+type HistoricalScreenType struct {
+	ScreenId    string `json:"screenid"`
+	SessionId   string `json:"sessionid"`
+	Name        string `json:"name"`      // from the screen tombstone, empty if not known
+	DeletedTs   int64  `json:"deletedts"` // from the screen tombstone
+	NumItems    int    `json:"numitems"`
+	NumArchived int    `json:"numarchived"`
+	LastTs      int64  `json:"lastts"`
+}
+
+type HistoryRepairReport struct {
+	StaleLineRefs     int
+	HistoricalScreens int
+	HistoricalItems   int
+	NumArchived       int // items newly tagged as archived
+}
+
+const notSyncedWhereClause = `NOT coalesce(json_extract(h.tags, '$.` + HistoryTag_Synced + `'), 0)`
+const historicalWhereClause = `h.screenid <> '' AND ` + notSyncedWhereClause + ` AND NOT EXISTS (SELECT 1 FROM screen s WHERE s.screenid = h.screenid)`
+const staleLineRefWhereClause = `h.lineid <> '' AND ` + notSyncedWhereClause + ` AND NOT EXISTS (SELECT 1 FROM line l WHERE l.screenid = h.screenid AND l.lineid = h.lineid)`
+
+// returns the screens referenced by history that no longer exist (most recent first)
+func GetHistoricalScreens(ctx context.Context) ([]*HistoricalScreenType, error) {
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) ([]*HistoricalScreenType, error) {
+		query := `SELECT h.screenid, max(h.sessionid) AS sessionid, count(*) AS numitems, max(h.ts) AS lastts,
+		                 sum(CASE WHEN json_extract(h.tags, '$.archived') THEN 1 ELSE 0 END) AS numarchived,
+		                 COALESCE(max(st.name), '') AS name, COALESCE(max(st.deletedts), 0) AS deletedts
+		          FROM history h LEFT JOIN screen_tombstone st ON st.screenid = h.screenid
+		          WHERE ` + historicalWhereClause + `
+		          GROUP BY h.screenid
+		          ORDER BY lastts DESC`
+		var rtn []*HistoricalScreenType
+		for _, m := range tx.SelectMaps(query) {
+			hscreen := &HistoricalScreenType{}
+			var numItems, numArchived int64
+			dbutil.QuickSetStr(&hscreen.ScreenId, m, "screenid")
+			dbutil.QuickSetStr(&hscreen.SessionId, m, "sessionid")
+			dbutil.QuickSetStr(&hscreen.Name, m, "name")
+			dbutil.QuickSetInt64(&hscreen.DeletedTs, m, "deletedts")
+			dbutil.QuickSetInt64(&numItems, m, "numitems")
+			dbutil.QuickSetInt64(&numArchived, m, "numarchived")
+			dbutil.QuickSetInt64(&hscreen.LastTs, m, "lastts")
+			hscreen.NumItems = int(numItems)
+			hscreen.NumArchived = int(numArchived)
+			rtn = append(rtn, hscreen)
+		}
+		return rtn, nil
+	})
+}
+
+// reports history items with stale references.  with fix, lineids of deleted lines are blanked.  with
+// archive, the items of deleted screens are tagged as archived.
+func RepairHistory(ctx context.Context, fix bool, archive bool) (*HistoryRepairReport, error) {
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) (*HistoryRepairReport, error) {
+		rtn := &HistoryRepairReport{}
+		query := `SELECT count(*) FROM history h WHERE ` + staleLineRefWhereClause
+		rtn.StaleLineRefs = tx.GetInt(query)
+		query = `SELECT count(DISTINCT h.screenid) FROM history h WHERE ` + historicalWhereClause
+		rtn.HistoricalScreens = tx.GetInt(query)
+		query = `SELECT count(*) FROM history h WHERE ` + historicalWhereClause
+		rtn.HistoricalItems = tx.GetInt(query)
+		if fix && rtn.StaleLineRefs > 0 {
+			query = `UPDATE history AS h SET lineid = '', linenum = 0 WHERE ` + staleLineRefWhereClause
+			tx.Exec(query)
+		}
+		if archive {
+			query = `UPDATE history AS h SET tags = json_set(h.tags, '$.archived', json('true'))
+			         WHERE ` + historicalWhereClause + ` AND json_extract(h.tags, '$.archived') IS NOT 1`
+			result := tx.Exec(query)
+			numRows, _ := result.RowsAffected()
+			rtn.NumArchived = int(numRows)
+		}
+		return rtn, nil
+	})
+}
@@ -0,0 +1,268 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package history
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// exit code and duration statistics over the history table (for the stats view).  the query options are the
+// filters (remote, cwd, screen, session, time range, status...), MaxItems limits the per-command lists.
+// durations only count the commands that recorded one (durationms is null for older and running commands).
+// the time buckets are aligned to the client timezone (empty buckets are omitted), the bucket size is picked
+// from the time range unless set.
+
+const DefaultStatsItems = 10
+const MaxStatsBuckets = 100
+
+var StatsBucketSizes = []int64{
+	int64(5 * time.Minute / time.Millisecond),
+	int64(15 * time.Minute / time.Millisecond),
+	int64(time.Hour / time.Millisecond),
+	int64(6 * time.Hour / time.Millisecond),
+	int64(24 * time.Hour / time.Millisecond),
+	int64(7 * 24 * time.Hour / time.Millisecond),
+}
+
+type CmdStatType struct {
+	CmdStr        string  `json:"cmdstr"`
+	NumCmds       int     `json:"numcmds"`
+	NumErrors     int     `json:"numerrors"`
+	FailureRate   float64 `json:"failurerate"`
+	NumTimed      int     `json:"numtimed"`
+	AvgDurationMs int64   `json:"avgdurationms"`
+	MaxDurationMs int64   `json:"maxdurationms"`
+	LastTs        int64   `json:"lastts"`
+}
+
+type RemoteStatType struct {
+	RemoteId      string  `json:"remoteid"`
+	RemoteName    string  `json:"remotename"`
+	NumCmds       int     `json:"numcmds"`
+	NumErrors     int     `json:"numerrors"`
+	FailureRate   float64 `json:"failurerate"`
+	NumTimed      int     `json:"numtimed"`
+	AvgDurationMs int64   `json:"avgdurationms"`
+	MaxDurationMs int64   `json:"maxdurationms"`
+}
+
+type ExitCodeStatType struct {
+	ExitCode int64 `json:"exitcode" db:"exitcode"`
+	NumCmds  int   `json:"numcmds" db:"numcmds"`
+}
+
+type StatsBucketType struct {
+	Ts            int64 `json:"ts"`
+	NumCmds       int   `json:"numcmds"`
+	NumErrors     int   `json:"numerrors"`
+	AvgDurationMs int64 `json:"avgdurationms"`
+}
+
+type CommandStatsType struct {
+	NumCmds       int                 `json:"numcmds"`
+	NumErrors     int                 `json:"numerrors"`
+	FailureRate   float64             `json:"failurerate"`
+	NumTimed      int                 `json:"numtimed"`
+	AvgDurationMs int64               `json:"avgdurationms"`
+	MaxDurationMs int64               `json:"maxdurationms"`
+	MinTs         int64               `json:"mints"`
+	MaxTs         int64               `json:"maxts"`
+	TopCmds       []*CmdStatType      `json:"topcmds"`
+	FailingCmds   []*CmdStatType      `json:"failingcmds"`
+	SlowestCmds   []*CmdStatType      `json:"slowestcmds"`
+	Remotes       []*RemoteStatType   `json:"remotes"`
+	ExitCodes     []*ExitCodeStatType `json:"exitcodes"`
+	BucketMs      int64               `json:"bucketms"`
+	Buckets       []*StatsBucketType  `json:"buckets"`
+}
+
+func (CommandStatsType) GetType() string {
+	return "historystats"
+}
+
+type cmdStatRow struct {
+	CmdStr        string  `db:"cmdstr"`
+	NumCmds       int     `db:"numcmds"`
+	NumErrors     int     `db:"numerrors"`
+	NumTimed      int     `db:"numtimed"`
+	AvgDurationMs float64 `db:"avgdurationms"`
+	MaxDurationMs int64   `db:"maxdurationms"`
+	LastTs        int64   `db:"lastts"`
+}
+
+type remoteStatRow struct {
+	RemoteId      string  `db:"remoteid"`
+	RemoteName    string  `db:"remotename"`
+	NumCmds       int     `db:"numcmds"`
+	NumErrors     int     `db:"numerrors"`
+	NumTimed      int     `db:"numtimed"`
+	AvgDurationMs float64 `db:"avgdurationms"`
+	MaxDurationMs int64   `db:"maxdurationms"`
+}
+
+type statsTotalsRow struct {
+	NumCmds       int     `db:"numcmds"`
+	NumErrors     int     `db:"numerrors"`
+	NumTimed      int     `db:"numtimed"`
+	AvgDurationMs float64 `db:"avgdurationms"`
+	MaxDurationMs int64   `db:"maxdurationms"`
+	MinTs         int64   `db:"mints"`
+	MaxTs         int64   `db:"maxts"`
+}
+
+type statsBucketRow struct {
+	Ts            int64   `db:"ts"`
+	NumCmds       int     `db:"numcmds"`
+	NumErrors     int     `db:"numerrors"`
+	AvgDurationMs float64 `db:"avgdurationms"`
+}
+
+// the aggregate columns shared by the totals, per-command, and per-remote queries
+const statsAggCols = `count(*) AS numcmds, COALESCE(sum(h.haderror), 0) AS numerrors, count(h.durationms) AS numtimed,
+                      COALESCE(avg(h.durationms), 0) AS avgdurationms, COALESCE(max(h.durationms), 0) AS maxdurationms`
+
+func failureRate(numErrors int, numCmds int) float64 {
+	if numCmds == 0 {
+		return 0
+	}
+	return float64(numErrors) / float64(numCmds)
+}
+
+// the smallest bucket size that covers rangeMs in at most MaxStatsBuckets buckets
+func pickStatsBucketMs(rangeMs int64) int64 {
+	for _, bucketMs := range StatsBucketSizes {
+		if rangeMs/bucketMs < MaxStatsBuckets {
+			return bucketMs
+		}
+	}
+	return StatsBucketSizes[len(StatsBucketSizes)-1]
+}
+
+func (row *cmdStatRow) toCmdStat() *CmdStatType {
+	return &CmdStatType{
+		CmdStr:        row.CmdStr,
+		NumCmds:       row.NumCmds,
+		NumErrors:     row.NumErrors,
+		FailureRate:   failureRate(row.NumErrors, row.NumCmds),
+		NumTimed:      row.NumTimed,
+		AvgDurationMs: int64(row.AvgDurationMs),
+		MaxDurationMs: row.MaxDurationMs,
+		LastTs:        row.LastTs,
+	}
+}
+
+func toCmdStats(rows []*cmdStatRow) []*CmdStatType {
+	rtn := make([]*CmdStatType, 0, len(rows))
+	for _, row := range rows {
+		rtn = append(rtn, row.toCmdStat())
+	}
+	return rtn
+}
+
+// bucketMs 0 picks the bucket size from the time range (the MinTs/FromTs filters, or the matching history)
+func GetCommandStats(ctx context.Context, opts HistoryQueryOpts, bucketMs int64) (*CommandStatsType, error) {
+	if bucketMs < 0 {
+		return nil, fmt.Errorf("invalid bucket size %d", bucketMs)
+	}
+	maxItems := opts.MaxItems
+	if maxItems <= 0 {
+		maxItems = DefaultStatsItems
+	}
+	whereClause, queryArgs, _, err := historyWhereClause(opts)
+	if err != nil {
+		return nil, err
+	}
+	whereClause += " AND h.cmdstr != ''"
+	// buckets are aligned to local midnight (using the current offset of the client timezone)
+	_, tzOffsetSecs := time.Now().In(sstore.GetClientLocation(ctx)).Zone()
+	tzOffsetMs := int64(tzOffsetSecs) * 1000
+	rtn := &CommandStatsType{}
+	txErr := sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		var totals statsTotalsRow
+		query := fmt.Sprintf(`SELECT %s, COALESCE(min(h.ts), 0) AS mints, COALESCE(max(h.ts), 0) AS maxts
+		                      FROM history h %s`, statsAggCols, whereClause)
+		tx.Get(&totals, query, queryArgs...)
+		rtn.NumCmds = totals.NumCmds
+		rtn.NumErrors = totals.NumErrors
+		rtn.FailureRate = failureRate(totals.NumErrors, totals.NumCmds)
+		rtn.NumTimed = totals.NumTimed
+		rtn.AvgDurationMs = int64(totals.AvgDurationMs)
+		rtn.MaxDurationMs = totals.MaxDurationMs
+		rtn.MinTs = totals.MinTs
+		rtn.MaxTs = totals.MaxTs
+		if totals.NumCmds == 0 {
+			return nil
+		}
+		cmdQuery := fmt.Sprintf(`SELECT h.cmdstr, %s, max(h.ts) AS lastts
+		                         FROM history h %s
+		                         GROUP BY h.cmdstr`, statsAggCols, whereClause)
+		var topRows []*cmdStatRow
+		tx.Select(&topRows, cmdQuery+fmt.Sprintf(` ORDER BY numcmds DESC, lastts DESC LIMIT %d`, maxItems), queryArgs...)
+		rtn.TopCmds = toCmdStats(topRows)
+		var failingRows []*cmdStatRow
+		tx.Select(&failingRows, cmdQuery+fmt.Sprintf(` HAVING numerrors > 0 ORDER BY numerrors DESC, numcmds DESC LIMIT %d`, maxItems), queryArgs...)
+		rtn.FailingCmds = toCmdStats(failingRows)
+		var slowRows []*cmdStatRow
+		tx.Select(&slowRows, cmdQuery+fmt.Sprintf(` HAVING numtimed > 0 ORDER BY avgdurationms DESC LIMIT %d`, maxItems), queryArgs...)
+		rtn.SlowestCmds = toCmdStats(slowRows)
+		var remoteRows []*remoteStatRow
+		query = fmt.Sprintf(`SELECT h.remoteid, max(h.remotename) AS remotename, %s
+		                     FROM history h %s
+		                     GROUP BY h.remoteid
+		                     ORDER BY numcmds DESC`, statsAggCols, whereClause)
+		tx.Select(&remoteRows, query, queryArgs...)
+		for _, row := range remoteRows {
+			rtn.Remotes = append(rtn.Remotes, &RemoteStatType{
+				RemoteId:      row.RemoteId,
+				RemoteName:    row.RemoteName,
+				NumCmds:       row.NumCmds,
+				NumErrors:     row.NumErrors,
+				FailureRate:   failureRate(row.NumErrors, row.NumCmds),
+				NumTimed:      row.NumTimed,
+				AvgDurationMs: int64(row.AvgDurationMs),
+				MaxDurationMs: row.MaxDurationMs,
+			})
+		}
+		query = fmt.Sprintf(`SELECT h.exitcode, count(*) AS numcmds
+		                     FROM history h %s AND h.exitcode IS NOT NULL
+		                     GROUP BY h.exitcode
+		                     ORDER BY numcmds DESC`, whereClause)
+		tx.Select(&rtn.ExitCodes, query, queryArgs...)
+		if bucketMs == 0 {
+			minTs, maxTs := totals.MinTs, totals.MaxTs
+			if opts.MinTs > 0 {
+				minTs = opts.MinTs
+			}
+			if opts.FromTs > 0 {
+				maxTs = opts.FromTs
+			}
+			bucketMs = pickStatsBucketMs(maxTs - minTs)
+		}
+		rtn.BucketMs = bucketMs
+		var bucketRows []*statsBucketRow
+		query = fmt.Sprintf(`SELECT ((h.ts + %d) / %d) * %d - %d AS ts, count(*) AS numcmds, COALESCE(sum(h.haderror), 0) AS numerrors,
+		                            COALESCE(avg(h.durationms), 0) AS avgdurationms
+		                     FROM history h %s
+		                     GROUP BY 1
+		                     ORDER BY 1`, tzOffsetMs, bucketMs, bucketMs, tzOffsetMs, whereClause)
+		tx.Select(&bucketRows, query, queryArgs...)
+		for _, row := range bucketRows {
+			rtn.Buckets = append(rtn.Buckets, &StatsBucketType{
+				Ts:            row.Ts,
+				NumCmds:       row.NumCmds,
+				NumErrors:     row.NumErrors,
+				AvgDurationMs: int64(row.AvgDurationMs),
+			})
+		}
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	return rtn, nil
+}
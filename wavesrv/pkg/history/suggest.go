@@ -0,0 +1,191 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package history
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/newton"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// command suggestions from the user's own history, the newton "history" completion provider (/_suggest).  the
+// recent history of the remote is grouped by command, ranked by frecency, and boosted for commands run in the
+// current directory.  the prefix matches the start of the command, or (ranked lower) as a fuzzy subsequence.
+
+const HistoryProviderName = "history"
+const MaxHistorySuggestions = 20
+const historySuggestScanLimit = 5000
+
+const (
+	HistoryMatch_Prefix = "prefix"
+	HistoryMatch_Fuzzy  = "fuzzy"
+)
+
+type HistorySuggestionType struct {
+	CmdStr    string  `json:"cmdstr"`
+	MatchType string  `json:"matchtype"`
+	Score     float64 `json:"score"`
+	NumUses   int     `json:"numuses"`
+	NumErrors int     `json:"numerrors"`
+	InCwd     bool    `json:"incwd"` // run in the current directory
+	LastTs    int64   `json:"lastts"`
+}
+
+type historySuggestRow struct {
+	CmdStr   string `db:"cmdstr"`
+	Ts       int64  `db:"ts"`
+	HadError bool   `db:"haderror"`
+	Cwd      string `db:"cwd"`
+}
+
+// visits in the last hour count 4x, last day 2x, last week 0.5x, older 0.25x (same weights as the cwd frecency)
+func historyFrecencyScore(numUses int, lastTs int64, now int64) float64 {
+	age := time.Duration(now-lastTs) * time.Millisecond
+	count := float64(numUses)
+	switch {
+	case age < time.Hour:
+		return count * 4
+	case age < 24*time.Hour:
+		return count * 2
+	case age < 7*24*time.Hour:
+		return count / 2
+	default:
+		return count / 4
+	}
+}
+
+// returns a score in (0, 1] if the chars of pattern appear in order in str (case-insensitive), tighter matches
+// score higher
+func fuzzyMatchScore(str string, pattern string) (float64, bool) {
+	str = strings.ToLower(str)
+	pattern = strings.ToLower(pattern)
+	if pattern == "" {
+		return 1, true
+	}
+	firstIdx := -1
+	pos := 0
+	for _, ch := range pattern {
+		idx := strings.IndexRune(str[pos:], ch)
+		if idx == -1 {
+			return 0, false
+		}
+		if firstIdx == -1 {
+			firstIdx = pos + idx
+		}
+		pos = pos + idx + len(string(ch))
+	}
+	return float64(len(pattern)) / float64(pos-firstIdx), true
+}
+
+// returns the best matching commands from the remote's history (at most MaxHistorySuggestions, best first).
+// cwd can be "" (no boost).  commands that are exactly the prefix are skipped.
+func SuggestHistoryCmds(ctx context.Context, remoteId string, cwd string, prefix string) ([]*HistorySuggestionType, error) {
+	var rows []*historySuggestRow
+	txErr := sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		query := `SELECT cmdstr, ts, haderror, coalesce(json_extract(festate, '$.cwd'), '') AS cwd
+		          FROM history
+		          WHERE remoteid = ? AND NOT coalesce(ismetacmd, 0)
+		          ORDER BY ts DESC
+		          LIMIT ?`
+		tx.Select(&rows, query, remoteId, historySuggestScanLimit)
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	prefix = strings.TrimLeft(prefix, " ")
+	sugMap := make(map[string]*HistorySuggestionType)
+	var suggestions []*HistorySuggestionType
+	for _, row := range rows {
+		cmdStr := strings.TrimSpace(row.CmdStr)
+		if cmdStr == "" || cmdStr == prefix {
+			continue
+		}
+		sug := sugMap[cmdStr]
+		if sug == nil {
+			matchType := HistoryMatch_Prefix
+			if !strings.HasPrefix(cmdStr, prefix) {
+				if _, ok := fuzzyMatchScore(cmdStr, prefix); !ok {
+					continue
+				}
+				matchType = HistoryMatch_Fuzzy
+			}
+			sug = &HistorySuggestionType{CmdStr: cmdStr, MatchType: matchType, LastTs: row.Ts}
+			sugMap[cmdStr] = sug
+			suggestions = append(suggestions, sug)
+		}
+		sug.NumUses++
+		if row.HadError {
+			sug.NumErrors++
+		}
+		if cwd != "" && row.Cwd == cwd {
+			sug.InCwd = true
+		}
+	}
+	now := time.Now().UnixMilli()
+	for _, sug := range suggestions {
+		score := historyFrecencyScore(sug.NumUses, sug.LastTs, now)
+		if sug.MatchType == HistoryMatch_Fuzzy {
+			fuzzyScore, _ := fuzzyMatchScore(sug.CmdStr, prefix)
+			score = score * fuzzyScore / 2
+		}
+		if sug.InCwd {
+			score *= 2
+		}
+		if sug.NumErrors == sug.NumUses {
+			score /= 2
+		}
+		sug.Score = score
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		return suggestions[i].LastTs > suggestions[j].LastTs
+	})
+	if len(suggestions) > MaxHistorySuggestions {
+		suggestions = suggestions[:MaxHistorySuggestions]
+	}
+	return suggestions, nil
+}
+
+type historyProvider struct{}
+
+func init() {
+	newton.RegisterProvider(HistoryProviderName, historyProvider{})
+}
+
+// the best match scores 90 down to 71, so history ranks above the spec suggestions (the fig history priorities)
+func (historyProvider) GetSuggestions(ctx context.Context, req newton.SuggestionRequest) ([]*newton.SuggestionType, error) {
+	if req.RemoteId == "" {
+		return nil, nil
+	}
+	hsugs, err := SuggestHistoryCmds(ctx, req.RemoteId, req.Cwd, req.CmdLine)
+	if err != nil {
+		return nil, err
+	}
+	rtn := make([]*newton.SuggestionType, 0, len(hsugs))
+	for idx, hsug := range hsugs {
+		description := fmt.Sprintf("used %d times", hsug.NumUses)
+		if hsug.NumUses == 1 {
+			description = "used once"
+		}
+		if hsug.InCwd {
+			description += " in this directory"
+		}
+		rtn = append(rtn, &newton.SuggestionType{
+			Name:        hsug.CmdStr,
+			Description: description,
+			Type:        newton.SuggestionType_History,
+			Score:       float64(max(90-idx, 71)),
+			ReplaceLen:  len(req.CmdLine),
+		})
+	}
+	return rtn, nil
+}
@@ -3,6 +3,11 @@
 
 package history
 
+import (
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
 type HistoryInfoType struct {
 	HistoryType string             `json:"historytype"`
 	SessionId   string             `json:"sessionid,omitempty"`
@@ -14,3 +19,27 @@ type HistoryInfoType struct {
 func (HistoryInfoType) GetType() string {
 	return "history"
 }
+
+func init() {
+	scbus.RegisterUpdateItemFilter(demoModeFilter)
+	scbus.RegisterModelUpdateItem(HistoryInfoType{}, CommandStatsType{})
+}
+
+// masks the festate of the history items in demo mode (see sstore/demomode.go)
+func demoModeFilter(item scbus.ModelUpdateItem) scbus.ModelUpdateItem {
+	hinfo, ok := item.(HistoryInfoType)
+	if !ok || !sstore.IsDemoMode() {
+		return item
+	}
+	items := make([]*HistoryItemType, len(hinfo.Items))
+	for idx, hitem := range hinfo.Items {
+		if hitem == nil {
+			continue
+		}
+		maskedItem := *hitem
+		maskedItem.FeState = sstore.MaskFeStateForDemo(hitem.FeState)
+		items[idx] = &maskedItem
+	}
+	hinfo.Items = items
+	return hinfo
+}
@@ -0,0 +1,407 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package historysync
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/base"
+)
+
+// sync backends, a flat store of named files (peer and delta files, see historysync.go).  the backend string is
+// a local directory (/path, ~/path, or file:///path), a WebDAV directory url (http:// or https://, credentials in
+// the url), or an S3 prefix (s3://bucket/prefix, credentials from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and
+// AWS_SESSION_TOKEN, region from AWS_REGION, AWS_ENDPOINT_URL for S3 compatible stores).
+
+const MaxSyncFileSize = 64 * 1024 * 1024
+const backendHttpTimeout = 60 * time.Second
+
+type SyncBackend interface {
+	List(ctx context.Context) ([]string, error)
+	Get(ctx context.Context, name string) ([]byte, error)
+	Put(ctx context.Context, name string, data []byte) error
+}
+
+func MakeBackend(backendStr string) (SyncBackend, error) {
+	backendStr = strings.TrimSpace(backendStr)
+	switch {
+	case backendStr == "":
+		return nil, fmt.Errorf("no sync backend set")
+	case strings.HasPrefix(backendStr, "s3://"):
+		return makeS3Backend(backendStr)
+	case strings.HasPrefix(backendStr, "http://") || strings.HasPrefix(backendStr, "https://"):
+		return makeWebDavBackend(backendStr)
+	case strings.HasPrefix(backendStr, "file://"):
+		return &fileBackend{Dir: strings.TrimPrefix(backendStr, "file://")}, nil
+	case strings.HasPrefix(backendStr, "/") || strings.HasPrefix(backendStr, "~"):
+		return &fileBackend{Dir: base.ExpandHomeDir(backendStr)}, nil
+	default:
+		return nil, fmt.Errorf("invalid sync backend %q (must be a directory, an http(s) WebDAV url, or an s3:// url)", backendStr)
+	}
+}
+
+// hides the credentials of a WebDAV url
+func BackendDisplayStr(backendStr string) string {
+	u, err := url.Parse(backendStr)
+	if err != nil || u.User == nil {
+		return backendStr
+	}
+	u.User = url.User(u.User.Username())
+	return u.String()
+}
+
+type fileBackend struct {
+	Dir string
+}
+
+func (b *fileBackend) List(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(b.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var rtn []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			rtn = append(rtn, entry.Name())
+		}
+	}
+	return rtn, nil
+}
+
+func (b *fileBackend) Get(ctx context.Context, name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(b.Dir, name))
+}
+
+// the file is written under a temp name and renamed, so other machines never read a partial file
+func (b *fileBackend) Put(ctx context.Context, name string, data []byte) error {
+	err := os.MkdirAll(b.Dir, 0700)
+	if err != nil {
+		return err
+	}
+	tmpName := filepath.Join(b.Dir, "."+name+".tmp")
+	err = os.WriteFile(tmpName, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filepath.Join(b.Dir, name))
+}
+
+func readHttpBody(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxSyncFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > MaxSyncFileSize {
+		return nil, fmt.Errorf("response too large (max %d bytes)", MaxSyncFileSize)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+type webDavBackend struct {
+	BaseUrl  *url.URL // ends with "/", without the credentials
+	User     string
+	Password string
+	Client   *http.Client
+}
+
+func makeWebDavBackend(backendStr string) (*webDavBackend, error) {
+	u, err := url.Parse(backendStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebDAV url: %w", err)
+	}
+	rtn := &webDavBackend{Client: &http.Client{Timeout: backendHttpTimeout}}
+	if u.User != nil {
+		rtn.User = u.User.Username()
+		rtn.Password, _ = u.User.Password()
+		u.User = nil
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+		u.RawPath = ""
+	}
+	rtn.BaseUrl = u
+	return rtn, nil
+}
+
+func (b *webDavBackend) do(ctx context.Context, method string, name string, body []byte, headers map[string]string) ([]byte, error) {
+	reqUrl := b.BaseUrl.JoinPath(name)
+	req, err := http.NewRequestWithContext(ctx, method, reqUrl.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if b.User != "" {
+		req.SetBasicAuth(b.User, b.Password)
+	}
+	for key, val := range headers {
+		req.Header.Set(key, val)
+	}
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return readHttpBody(resp)
+}
+
+type webDavMultiStatus struct {
+	Responses []struct {
+		Href string `xml:"href"`
+	} `xml:"response"`
+}
+
+const webDavPropFindBody = `<?xml version="1.0" encoding="utf-8"?><propfind xmlns="DAV:"><prop><resourcetype/></prop></propfind>`
+
+func (b *webDavBackend) List(ctx context.Context) ([]string, error) {
+	body, err := b.do(ctx, "PROPFIND", "", []byte(webDavPropFindBody), map[string]string{"Depth": "1", "Content-Type": "application/xml"})
+	if err != nil {
+		return nil, fmt.Errorf("WebDAV list: %w", err)
+	}
+	var ms webDavMultiStatus
+	err = xml.Unmarshal(body, &ms)
+	if err != nil {
+		return nil, fmt.Errorf("WebDAV list, invalid response: %w", err)
+	}
+	var rtn []string
+	for _, resp := range ms.Responses {
+		href, err := url.PathUnescape(resp.Href)
+		if err != nil || strings.HasSuffix(href, "/") {
+			// the directory itself (and subdirectories)
+			continue
+		}
+		rtn = append(rtn, href[strings.LastIndex(href, "/")+1:])
+	}
+	return rtn, nil
+}
+
+func (b *webDavBackend) Get(ctx context.Context, name string) ([]byte, error) {
+	body, err := b.do(ctx, http.MethodGet, name, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("WebDAV get %s: %w", name, err)
+	}
+	return body, nil
+}
+
+func (b *webDavBackend) Put(ctx context.Context, name string, data []byte) error {
+	_, err := b.do(ctx, http.MethodPut, name, data, map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		return fmt.Errorf("WebDAV put %s: %w", name, err)
+	}
+	return nil
+}
+
+type s3Backend struct {
+	Endpoint     *url.URL // path style requests, {endpoint}/{bucket}/{key}
+	Bucket       string
+	Prefix       string // "" or ends with "/"
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Client       *http.Client
+}
+
+func makeS3Backend(backendStr string) (*s3Backend, error) {
+	bucketPath := strings.TrimPrefix(backendStr, "s3://")
+	bucket, prefix, _ := strings.Cut(bucketPath, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("invalid s3 url %q (no bucket)", backendStr)
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	rtn := &s3Backend{
+		Bucket:       bucket,
+		Prefix:       prefix,
+		Region:       os.Getenv("AWS_REGION"),
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		Client:       &http.Client{Timeout: backendHttpTimeout},
+	}
+	if rtn.AccessKey == "" || rtn.SecretKey == "" {
+		return nil, fmt.Errorf("s3 backend needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY set in the environment")
+	}
+	if rtn.Region == "" {
+		rtn.Region = "us-east-1"
+	}
+	endpointStr := os.Getenv("AWS_ENDPOINT_URL")
+	if endpointStr == "" {
+		endpointStr = fmt.Sprintf("https://s3.%s.amazonaws.com", rtn.Region)
+	}
+	var err error
+	rtn.Endpoint, err = url.Parse(endpointStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AWS_ENDPOINT_URL: %w", err)
+	}
+	return rtn, nil
+}
+
+// uri encoding for the sigv4 canonical request (only the unreserved chars are not encoded)
+func awsUriEncode(str string, encodeSlash bool) string {
+	var buf strings.Builder
+	for _, ch := range []byte(str) {
+		if (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') || ch == '-' || ch == '_' || ch == '.' || ch == '~' || (ch == '/' && !encodeSlash) {
+			buf.WriteByte(ch)
+		} else {
+			buf.WriteString(fmt.Sprintf("%%%02X", ch))
+		}
+	}
+	return buf.String()
+}
+
+func awsCanonicalQuery(params map[string]string) string {
+	var keys []string
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		parts = append(parts, awsUriEncode(key, true)+"="+awsUriEncode(params[key], true))
+	}
+	return strings.Join(parts, "&")
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// signs the request with aws signature v4 (signed headers: host, x-amz-content-sha256, x-amz-date, and
+// x-amz-security-token)
+func (b *s3Backend) signRequest(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	dateStr := amzDate[:8]
+	payloadHash := sha256Hex(body)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	if b.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", b.SessionToken)
+		headers["x-amz-security-token"] = b.SessionToken
+	}
+	var headerNames []string
+	for name := range headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+	var canonicalHeaders strings.Builder
+	for _, name := range headerNames {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsUriEncode(req.URL.Path, false),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := dateStr + "/" + b.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	signingKey := hmacSha256([]byte("AWS4"+b.SecretKey), dateStr)
+	signingKey = hmacSha256(signingKey, b.Region)
+	signingKey = hmacSha256(signingKey, "s3")
+	signingKey = hmacSha256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(signingKey, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", b.AccessKey, scope, signedHeaders, signature))
+}
+
+func (b *s3Backend) do(ctx context.Context, method string, key string, params map[string]string, body []byte) ([]byte, error) {
+	reqUrl := *b.Endpoint
+	reqUrl.Path = strings.TrimSuffix(reqUrl.Path, "/") + "/" + b.Bucket
+	if key != "" {
+		reqUrl.Path += "/" + key
+	}
+	reqUrl.RawPath = ""
+	reqUrl.RawQuery = awsCanonicalQuery(params)
+	req, err := http.NewRequestWithContext(ctx, method, reqUrl.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	b.signRequest(req, body, time.Now())
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return readHttpBody(resp)
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (b *s3Backend) List(ctx context.Context) ([]string, error) {
+	var rtn []string
+	params := map[string]string{"list-type": "2", "prefix": b.Prefix}
+	for {
+		body, err := b.do(ctx, http.MethodGet, "", params, nil)
+		if err != nil {
+			return nil, fmt.Errorf("s3 list: %w", err)
+		}
+		var result s3ListResult
+		err = xml.Unmarshal(body, &result)
+		if err != nil {
+			return nil, fmt.Errorf("s3 list, invalid response: %w", err)
+		}
+		for _, obj := range result.Contents {
+			name := strings.TrimPrefix(obj.Key, b.Prefix)
+			if name != "" && !strings.Contains(name, "/") {
+				rtn = append(rtn, name)
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return rtn, nil
+		}
+		params["continuation-token"] = result.NextContinuationToken
+	}
+}
+
+func (b *s3Backend) Get(ctx context.Context, name string) ([]byte, error) {
+	body, err := b.do(ctx, http.MethodGet, b.Prefix+name, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("s3 get %s: %w", name, err)
+	}
+	return body, nil
+}
+
+func (b *s3Backend) Put(ctx context.Context, name string, data []byte) error {
+	_, err := b.do(ctx, http.MethodPut, b.Prefix+name, nil, data)
+	if err != nil {
+		return fmt.Errorf("s3 put %s: %w", name, err)
+	}
+	return nil
+}
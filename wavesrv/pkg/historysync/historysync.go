@@ -0,0 +1,541 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package historysync
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/history"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/waveenc"
+)
+
+// opt-in history sync between wave installations through a shared backend (see backend.go).  each installation
+// (peer) publishes its public key as peer-[clientid].json, and exports its new history items as numbered delta
+// files (delta-[clientid]-[seq].json).  a delta is encrypted with a random data key, the data key is encrypted
+// for every peer known when the delta was written (with an ecdh key from the client keypairs), so only the peers
+// can read it.  a new peer can not read the older deltas, so finding a new peer re-exports the whole history.
+//
+// a peer's key is pinned the first time it is seen (a changed key is an error).  only the finished commands of
+// this installation are exported (not the items synced from other peers), merging inserts the items that are not
+// in the history yet (by historyid, or screenid/lineid/ts), so re-exported and repeated deltas are harmless.
+
+const HistoryTag_Synced = history.HistoryTag_Synced
+const MaxDeltaItems = 5000
+const peerFilePrefix = "peer-"
+const deltaFilePrefix = "delta-"
+
+var deltaFileRe = regexp.MustCompile(`^delta-([0-9a-f-]{36})-([0-9]+)\.json$`)
+var peerFileRe = regexp.MustCompile(`^peer-([0-9a-f-]{36})\.json$`)
+
+type SyncConfigType struct {
+	Backend    string `json:"backend"`
+	Enabled    bool   `json:"enabled"`
+	NextSeq    int64  `json:"nextseq"`
+	ExportTs   int64  `json:"exportts"`
+	LastSyncTs int64  `json:"lastsyncts"`
+	LastError  string `json:"lasterror"`
+}
+
+func (SyncConfigType) UseDBMap() {}
+
+type SyncPeerType struct {
+	ClientId   string `json:"clientid"`
+	PublicKey  string `json:"publickey"` // base64 pkix
+	LastSeq    int64  `json:"lastseq"`   // the last delta that was merged
+	LastSyncTs int64  `json:"lastsyncts"`
+}
+
+func (SyncPeerType) UseDBMap() {}
+
+type SyncResultType struct {
+	NumExported int
+	NumImported int
+	NumPeers    int
+	NewPeers    []string
+	Errors      []string // peers or deltas that were skipped
+}
+
+type peerFileType struct {
+	ClientId  string `json:"clientid"`
+	PublicKey string `json:"publickey"`
+}
+
+type deltaFileType struct {
+	ClientId  string            `json:"clientid"`
+	Seq       int64             `json:"seq"`
+	CreatedTs int64             `json:"createdts"`
+	Keys      map[string]string `json:"keys"` // peer clientid => data key (encrypted with the writer/peer ecdh key)
+	Data      string            `json:"data"` // history items (json), encrypted with the data key
+}
+
+var syncLock = &sync.Mutex{}
+
+func GetSyncConfig(ctx context.Context) (*SyncConfigType, error) {
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) (*SyncConfigType, error) {
+		query := `SELECT * FROM historysync`
+		return dbutil.GetMappable[*SyncConfigType](tx, query), nil
+	})
+}
+
+func GetSyncPeers(ctx context.Context) ([]*SyncPeerType, error) {
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) ([]*SyncPeerType, error) {
+		query := `SELECT * FROM historysync_peer ORDER BY clientid`
+		return dbutil.SelectMappable[*SyncPeerType](tx, query), nil
+	})
+}
+
+// a new backend starts over (no peers, everything is exported again).  the delta seqs continue after the deltas
+// this client already wrote to the backend (see runSync).
+func SetSyncConfig(ctx context.Context, backend string, enabled bool) error {
+	if _, err := MakeBackend(backend); err != nil {
+		return err
+	}
+	syncLock.Lock()
+	defer syncLock.Unlock()
+	return sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		query := `SELECT * FROM historysync`
+		cfg := dbutil.GetMappable[*SyncConfigType](tx, query)
+		if cfg != nil && cfg.Backend == backend {
+			query = `UPDATE historysync SET enabled = ?`
+			tx.Exec(query, enabled)
+			return nil
+		}
+		query = `DELETE FROM historysync`
+		tx.Exec(query)
+		query = `DELETE FROM historysync_peer`
+		tx.Exec(query)
+		cfg = &SyncConfigType{Backend: backend, Enabled: enabled, NextSeq: 1}
+		query = `INSERT INTO historysync ( backend, enabled, nextseq, exportts, lastsyncts, lasterror)
+		                          VALUES (:backend,:enabled,:nextseq,:exportts,:lastsyncts,:lasterror)`
+		tx.NamedExec(query, dbutil.ToDBMap(cfg, false))
+		return nil
+	})
+}
+
+// the sha256 of the ecdh secret of the two keys (the same for both sides), the p-384 secret is too long to be
+// used as a key directly
+func peerKey(privKey *ecdsa.PrivateKey, peerPubKey string) ([]byte, error) {
+	pubBytes, err := base64.StdEncoding.DecodeString(peerPubKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	pubKey, err := x509.ParsePKIXPublicKey(pubBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	ecdsaPubKey, ok := pubKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid public key type %T", pubKey)
+	}
+	ecdhPubKey, err := ecdsaPubKey.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	ecdhPrivKey, err := privKey.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := ecdhPrivKey.ECDH(ecdhPubKey)
+	if err != nil {
+		return nil, err
+	}
+	key := sha256.Sum256(append([]byte("waveterm-historysync:"), secret...))
+	return key[:], nil
+}
+
+func deltaOData(clientId string, seq int64) string {
+	return fmt.Sprintf("historysync:%s:%d", clientId, seq)
+}
+
+// peerPubKeys includes this client (so it can read its own deltas)
+func encryptDelta(privKey *ecdsa.PrivateKey, clientId string, seq int64, peerPubKeys map[string]string, items []*history.HistoryItemType) (*deltaFileType, error) {
+	dataEnc, err := waveenc.MakeRandomEncryptor()
+	if err != nil {
+		return nil, err
+	}
+	itemsJson, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	odata := deltaOData(clientId, seq)
+	encData, err := dataEnc.EncryptData(itemsJson, odata)
+	if err != nil {
+		return nil, err
+	}
+	rtn := &deltaFileType{ClientId: clientId, Seq: seq, CreatedTs: time.Now().UnixMilli(), Keys: make(map[string]string), Data: base64.StdEncoding.EncodeToString(encData)}
+	for peerId, pubKey := range peerPubKeys {
+		key, err := peerKey(privKey, pubKey)
+		if err != nil {
+			return nil, fmt.Errorf("peer %s: %w", peerId, err)
+		}
+		keyEnc, err := waveenc.MakeEncryptor(key)
+		if err != nil {
+			return nil, err
+		}
+		encDataKey, err := keyEnc.EncryptData(dataEnc.Key, odata+":"+peerId)
+		if err != nil {
+			return nil, err
+		}
+		rtn.Keys[peerId] = base64.StdEncoding.EncodeToString(encDataKey)
+	}
+	return rtn, nil
+}
+
+// returns nil items (no error) if the delta was not encrypted for this client
+func decryptDelta(privKey *ecdsa.PrivateKey, clientId string, writerPubKey string, delta *deltaFileType) ([]*history.HistoryItemType, error) {
+	encDataKey64, ok := delta.Keys[clientId]
+	if !ok {
+		return nil, nil
+	}
+	key, err := peerKey(privKey, writerPubKey)
+	if err != nil {
+		return nil, err
+	}
+	keyEnc, err := waveenc.MakeEncryptor(key)
+	if err != nil {
+		return nil, err
+	}
+	odata := deltaOData(delta.ClientId, delta.Seq)
+	encDataKey, err := base64.StdEncoding.DecodeString(encDataKey64)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := keyEnc.DecryptData(encDataKey, odata+":"+clientId)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt data key: %w", err)
+	}
+	dataEnc, err := waveenc.MakeEncryptor(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	encData, err := base64.StdEncoding.DecodeString(delta.Data)
+	if err != nil {
+		return nil, err
+	}
+	itemsJson, err := dataEnc.DecryptData(encData, odata)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt delta: %w", err)
+	}
+	var items []*history.HistoryItemType
+	err = json.Unmarshal(itemsJson, &items)
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// pins the peer keys, returns the known peers (by clientid) and the new ones
+func syncPeerFiles(ctx context.Context, backend SyncBackend, clientId string, names []string, result *SyncResultType) (map[string]*SyncPeerType, error) {
+	peers, err := GetSyncPeers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	peerMap := make(map[string]*SyncPeerType)
+	for _, peer := range peers {
+		peerMap[peer.ClientId] = peer
+	}
+	for _, name := range names {
+		match := peerFileRe.FindStringSubmatch(name)
+		if match == nil || match[1] == clientId {
+			continue
+		}
+		barr, err := backend.Get(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		var peerFile peerFileType
+		err = json.Unmarshal(barr, &peerFile)
+		if err != nil || peerFile.ClientId != match[1] || peerFile.PublicKey == "" {
+			result.Errors = append(result.Errors, fmt.Sprintf("invalid peer file %s", name))
+			continue
+		}
+		knownPeer := peerMap[peerFile.ClientId]
+		if knownPeer != nil {
+			if knownPeer.PublicKey != peerFile.PublicKey {
+				result.Errors = append(result.Errors, fmt.Sprintf("peer %s changed its key, skipped", peerFile.ClientId))
+				delete(peerMap, peerFile.ClientId)
+			}
+			continue
+		}
+		newPeer := &SyncPeerType{ClientId: peerFile.ClientId, PublicKey: peerFile.PublicKey}
+		err = sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+			query := `INSERT INTO historysync_peer ( clientid, publickey, lastseq, lastsyncts)
+			                               VALUES (:clientid,:publickey,:lastseq,:lastsyncts)`
+			tx.NamedExec(query, dbutil.ToDBMap(newPeer, false))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		peerMap[newPeer.ClientId] = newPeer
+		result.NewPeers = append(result.NewPeers, newPeer.ClientId)
+	}
+	return peerMap, nil
+}
+
+type deltaName struct {
+	Name     string
+	ClientId string
+	Seq      int64
+}
+
+func parseDeltaNames(names []string) []deltaName {
+	var rtn []deltaName
+	for _, name := range names {
+		match := deltaFileRe.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+		seq, err := strconv.ParseInt(match[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		rtn = append(rtn, deltaName{Name: name, ClientId: match[1], Seq: seq})
+	}
+	sort.Slice(rtn, func(i, j int) bool {
+		if rtn[i].ClientId != rtn[j].ClientId {
+			return rtn[i].ClientId < rtn[j].ClientId
+		}
+		return rtn[i].Seq < rtn[j].Seq
+	})
+	return rtn
+}
+
+func importDeltas(ctx context.Context, backend SyncBackend, clientData *sstore.ClientData, peerMap map[string]*SyncPeerType, names []string, result *SyncResultType) error {
+	failedPeers := make(map[string]bool)
+	for _, dname := range parseDeltaNames(names) {
+		peer := peerMap[dname.ClientId]
+		if peer == nil || failedPeers[dname.ClientId] || dname.Seq <= peer.LastSeq {
+			continue
+		}
+		barr, err := backend.Get(ctx, dname.Name)
+		if err != nil {
+			return err
+		}
+		var delta deltaFileType
+		err = json.Unmarshal(barr, &delta)
+		var items []*history.HistoryItemType
+		if err == nil && (delta.ClientId != dname.ClientId || delta.Seq != dname.Seq) {
+			err = fmt.Errorf("name does not match the contents")
+		}
+		if err == nil {
+			items, err = decryptDelta(clientData.UserPrivateKey, clientData.ClientId, peer.PublicKey, &delta)
+		}
+		if err != nil {
+			// lastseq stays before the delta, it (and the peer's later deltas) are read again by the next sync
+			result.Errors = append(result.Errors, fmt.Sprintf("delta %s: %v", dname.Name, err))
+			failedPeers[dname.ClientId] = true
+			continue
+		}
+		for _, item := range items {
+			if item.Tags == nil {
+				item.Tags = make(map[string]bool)
+			}
+			item.Tags[HistoryTag_Synced] = true
+		}
+		numMerged, err := history.MergeHistoryItems(ctx, items)
+		if err != nil {
+			return err
+		}
+		result.NumImported += numMerged
+		peer.LastSeq = dname.Seq
+		peer.LastSyncTs = time.Now().UnixMilli()
+		err = sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+			query := `UPDATE historysync_peer SET lastseq = ?, lastsyncts = ? WHERE clientid = ?`
+			tx.Exec(query, peer.LastSeq, peer.LastSyncTs, peer.ClientId)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the finished commands of this installation after exportTs (oldest first), and the exportts for the next
+// export (the commands that are still running are exported later)
+func getExportItems(ctx context.Context, exportTs int64) ([]*history.HistoryItemType, int64, error) {
+	var items []*history.HistoryItemType
+	nextExportTs := exportTs
+	txErr := sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		query := `SELECT * FROM history
+		          WHERE ts > ? AND NOT coalesce(ismetacmd, 0) AND NOT coalesce(json_extract(tags, '$.` + HistoryTag_Synced + `'), 0)
+		            AND status NOT IN (?, ?)
+		          ORDER BY ts, historyid
+		          LIMIT ?`
+		items = dbutil.SelectMapsGen[*history.HistoryItemType](tx, query, exportTs, sstore.CmdStatusRunning, sstore.CmdStatusDetached, MaxDeltaItems)
+		if len(items) == 0 {
+			return nil
+		}
+		nextExportTs = items[len(items)-1].Ts
+		query = `SELECT COALESCE(min(ts), 0) FROM history WHERE ts > ? AND status IN (?, ?)`
+		runningTs := int64(tx.GetInt(query, exportTs, sstore.CmdStatusRunning, sstore.CmdStatusDetached))
+		if runningTs > 0 && runningTs <= nextExportTs {
+			nextExportTs = runningTs - 1
+		}
+		return nil
+	})
+	if txErr != nil {
+		return nil, 0, txErr
+	}
+	return items, nextExportTs, nil
+}
+
+func exportDeltas(ctx context.Context, backend SyncBackend, clientData *sstore.ClientData, cfg *SyncConfigType, peerMap map[string]*SyncPeerType, result *SyncResultType) error {
+	pubKeys := map[string]string{clientData.ClientId: base64.StdEncoding.EncodeToString(clientData.UserPublicKeyBytes)}
+	for _, peer := range peerMap {
+		pubKeys[peer.ClientId] = peer.PublicKey
+	}
+	for {
+		items, nextExportTs, err := getExportItems(ctx, cfg.ExportTs)
+		if err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		for _, item := range items {
+			// the history numbers are local
+			item.HistoryNum = ""
+		}
+		delta, err := encryptDelta(clientData.UserPrivateKey, clientData.ClientId, cfg.NextSeq, pubKeys, items)
+		if err != nil {
+			return err
+		}
+		barr, err := json.Marshal(delta)
+		if err != nil {
+			return err
+		}
+		err = backend.Put(ctx, fmt.Sprintf("%s%s-%d.json", deltaFilePrefix, clientData.ClientId, cfg.NextSeq), barr)
+		if err != nil {
+			return err
+		}
+		result.NumExported += len(items)
+		cfg.NextSeq++
+		cfg.ExportTs = nextExportTs
+		err = sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+			query := `UPDATE historysync SET nextseq = ?, exportts = ?`
+			tx.Exec(query, cfg.NextSeq, cfg.ExportTs)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if nextExportTs < items[len(items)-1].Ts {
+			// held back by a running command
+			return nil
+		}
+	}
+}
+
+func runSync(ctx context.Context, backend SyncBackend, cfg *SyncConfigType) (*SyncResultType, error) {
+	clientData, err := sstore.EnsureClientData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := &SyncResultType{}
+	peerFile := peerFileType{ClientId: clientData.ClientId, PublicKey: base64.StdEncoding.EncodeToString(clientData.UserPublicKeyBytes)}
+	names, err := backend.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	peerFileName := peerFilePrefix + clientData.ClientId + ".json"
+	hasPeerFile := false
+	for _, name := range names {
+		hasPeerFile = hasPeerFile || (name == peerFileName)
+	}
+	if !hasPeerFile {
+		barr, _ := json.Marshal(peerFile)
+		err = backend.Put(ctx, peerFileName, barr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// the backend can have deltas of this client that the config does not know about (the backend was used
+	// before, or the config was reset), they must not be overwritten
+	for _, dname := range parseDeltaNames(names) {
+		if dname.ClientId == clientData.ClientId && dname.Seq >= cfg.NextSeq {
+			cfg.NextSeq = dname.Seq + 1
+		}
+	}
+	peerMap, err := syncPeerFiles(ctx, backend, clientData.ClientId, names, result)
+	if err != nil {
+		return nil, err
+	}
+	result.NumPeers = len(peerMap)
+	err = importDeltas(ctx, backend, clientData, peerMap, names, result)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.NewPeers) > 0 {
+		// the new peers can not read the earlier deltas
+		cfg.ExportTs = 0
+	}
+	err = exportDeltas(ctx, backend, clientData, cfg, peerMap, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// syncs with the configured backend (import, then export), the error (or "") is saved as the lasterror
+func RunSync(ctx context.Context) (*SyncResultType, error) {
+	syncLock.Lock()
+	defer syncLock.Unlock()
+	cfg, err := GetSyncConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("history sync is not set up")
+	}
+	backend, err := MakeBackend(cfg.Backend)
+	if err != nil {
+		return nil, err
+	}
+	result, syncErr := runSync(ctx, backend, cfg)
+	errStr := ""
+	if syncErr != nil {
+		errStr = syncErr.Error()
+	}
+	err = sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		query := `UPDATE historysync SET lastsyncts = ?, lasterror = ?`
+		tx.Exec(query, time.Now().UnixMilli(), errStr)
+		return nil
+	})
+	if err != nil {
+		log.Printf("[historysync] error saving sync status: %v\n", err)
+	}
+	return result, syncErr
+}
+
+// for the background loop, syncs if sync is enabled
+func RunSyncIfEnabled(ctx context.Context) error {
+	cfg, err := GetSyncConfig(ctx)
+	if err != nil || cfg == nil || !cfg.Enabled {
+		return err
+	}
+	result, err := RunSync(ctx)
+	if err != nil {
+		return err
+	}
+	if result.NumExported > 0 || result.NumImported > 0 {
+		log.Printf("[historysync] exported %d, imported %d history items (%d peers)\n", result.NumExported, result.NumImported, result.NumPeers)
+	}
+	return nil
+}
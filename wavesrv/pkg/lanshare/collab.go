@@ -0,0 +1,216 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package lanshare
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/mapqueue"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/screenattach"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// collaborative screens.  clients of write shares can run commands on the screen and send input to its
+// running commands.  commands from all the clients of a screen are run one at a time, in the order they were
+// received (collabQueue), as if they were typed on the screen.  terminal input goes through the same per-remote
+// queue as the local client's input (scws.EnqueueCmdInput).  presence (the connected clients and the line each
+// one is looking at) is kept in memory and sent as "presence" updates on the bus, to the frontend and to all
+// the clients of the screen.
+
+const CollabQueueSize = 20
+const CollabCmdTimeout = 30 * time.Second
+const MaxViewerNameLen = 40
+
+var collabQueue = mapqueue.MakeMapQueue(CollabQueueSize) // screenid -> queued commands
+
+type PresenceClientType struct {
+	ClientId     string `json:"clientid"`
+	Name         string `json:"name"`
+	Write        bool   `json:"write"`
+	CursorLineId string `json:"cursorlineid,omitempty"`
+	ConnectTs    int64  `json:"connectts"`
+}
+
+type PresenceUpdateType struct {
+	ScreenId string               `json:"screenid"`
+	Clients  []PresenceClientType `json:"clients"`
+}
+
+func (PresenceUpdateType) GetType() string {
+	return "presence"
+}
+
+func init() {
+	scbus.RegisterModelUpdateItem(PresenceUpdateType{})
+}
+
+type presenceMsg struct {
+	Type    string               `json:"type"`
+	Clients []PresenceClientType `json:"clients"`
+}
+
+type errorMsg struct {
+	Type  string `json:"type"`
+	Error string `json:"error"`
+}
+
+type viewerMsg struct {
+	Type        string `json:"type"`
+	LineId      string `json:"lineid,omitempty"`
+	CmdStr      string `json:"cmdstr,omitempty"`
+	InputData64 string `json:"inputdata64,omitempty"`
+	SigName     string `json:"signame,omitempty"`
+}
+
+var presenceLock = &sync.Mutex{}
+var presenceMap = make(map[string]map[string]*PresenceClientType) // screenid -> viewerkey -> client
+
+func cleanViewerName(name string, remoteAddr string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if len(name) > MaxViewerNameLen {
+		name = strings.ToValidUTF8(name[:MaxViewerNameLen], "")
+	}
+	if name == "" {
+		return remoteAddr
+	}
+	return name
+}
+
+// must hold presenceLock
+func makePresenceUpdate_nolock(screenId string) PresenceUpdateType {
+	rtn := PresenceUpdateType{ScreenId: screenId, Clients: []PresenceClientType{}}
+	for _, client := range presenceMap[screenId] {
+		rtn.Clients = append(rtn.Clients, *client)
+	}
+	sort.Slice(rtn.Clients, func(i, j int) bool { return rtn.Clients[i].ConnectTs < rtn.Clients[j].ConnectTs })
+	return rtn
+}
+
+// changeFn is called with presenceLock held
+func updatePresence(screenId string, changeFn func(clients map[string]*PresenceClientType)) {
+	presenceLock.Lock()
+	clients := presenceMap[screenId]
+	if clients == nil {
+		clients = make(map[string]*PresenceClientType)
+		presenceMap[screenId] = clients
+	}
+	changeFn(clients)
+	if len(clients) == 0 {
+		delete(presenceMap, screenId)
+	}
+	pUpdate := makePresenceUpdate_nolock(screenId)
+	presenceLock.Unlock()
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(pUpdate)
+	scbus.MainUpdateBus.DoScreenUpdate(screenId, update)
+}
+
+func addPresence(screenId string, viewerKey string, name string, write bool) {
+	updatePresence(screenId, func(clients map[string]*PresenceClientType) {
+		clients[viewerKey] = &PresenceClientType{
+			ClientId:  scbase.GenWaveUUID(),
+			Name:      name,
+			Write:     write,
+			ConnectTs: time.Now().UnixMilli(),
+		}
+	})
+}
+
+func removePresence(screenId string, viewerKey string) {
+	updatePresence(screenId, func(clients map[string]*PresenceClientType) {
+		delete(clients, viewerKey)
+	})
+}
+
+func GetScreenPresence(screenId string) PresenceUpdateType {
+	presenceLock.Lock()
+	defer presenceLock.Unlock()
+	return makePresenceUpdate_nolock(screenId)
+}
+
+func setCursorLine(screenId string, viewerKey string, lineId string) {
+	updatePresence(screenId, func(clients map[string]*PresenceClientType) {
+		if client := clients[viewerKey]; client != nil {
+			client.CursorLineId = lineId
+		}
+	})
+}
+
+func enqueueCollabCmd(share *lanShare, sendErrorFn func(error), cmdStr string) error {
+	if strings.TrimSpace(cmdStr) == "" {
+		return fmt.Errorf("empty command")
+	}
+	screenId := share.ScreenId
+	return collabQueue.Enqueue(screenId, func() {
+		ctx, cancelFn := context.WithTimeout(context.Background(), CollabCmdTimeout)
+		defer cancelFn()
+		err := share.Opts.RunCmdFn(ctx, screenId, cmdStr)
+		if err != nil {
+			log.Printf("[lanshare] error running command from %q: %v\n", share.ViewerName, err)
+			sendErrorFn(fmt.Errorf("error running command: %v", err))
+		}
+	})
+}
+
+func sendCollabInput(screenId string, msg *viewerMsg) error {
+	input, err := base64.StdEncoding.DecodeString(msg.InputData64)
+	if err != nil {
+		return fmt.Errorf("invalid inputdata64: %v", err)
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+	cmd, err := sstore.GetCmdByScreenId(ctx, screenId, msg.LineId)
+	if err != nil {
+		return fmt.Errorf("cannot get command: %v", err)
+	}
+	if cmd == nil || !cmd.IsRunning() {
+		return fmt.Errorf("line is not a running command")
+	}
+	return screenattach.SendCmdInput(cmd, input, msg.SigName)
+}
+
+func handleViewerMsg(share *lanShare, viewerKey string, sendErrorFn func(error), msgBytes []byte) error {
+	var msg viewerMsg
+	err := json.Unmarshal(msgBytes, &msg)
+	if err != nil {
+		return fmt.Errorf("invalid message: %v", err)
+	}
+	switch msg.Type {
+	case MsgType_Cursor:
+		if len(msg.LineId) > 36 {
+			return fmt.Errorf("invalid lineid")
+		}
+		setCursorLine(share.ScreenId, viewerKey, msg.LineId)
+		return nil
+
+	case MsgType_RunCmd:
+		if !share.Opts.Write {
+			return fmt.Errorf("cannot run commands, share is read-only")
+		}
+		return enqueueCollabCmd(share, sendErrorFn, msg.CmdStr)
+
+	case MsgType_Input:
+		if !share.Opts.Write {
+			return fmt.Errorf("cannot send input, share is read-only")
+		}
+		return sendCollabInput(share.ScreenId, &msg)
+	}
+	return fmt.Errorf("invalid message type %q", msg.Type)
+}
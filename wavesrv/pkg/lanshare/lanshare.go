@@ -0,0 +1,527 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// read-only live screen sharing over the local network.  /screen:lanshare creates a share with a one-time
+// token and starts a websocket server (LanShareDefaultPort).  the server listens on loopback unless an
+// interface address is chosen (the websocket is not encrypted, so the token is only exposed on the chosen
+// network).  the first connection with the token consumes it and receives a snapshot of the screen (lines +
+// the tail of each command's output), followed by live line/pty updates.  the stream only contains what a
+// viewer needs (no env, state, or remote details).  shares created with write=1 also accept shell commands
+// and terminal input from the client (see collab.go).  a share ends when it is stopped, when its client
+// disconnects, or when its token expires unused.  the server is stopped when there are no shares left.
+//
+// protocol (json messages, server -> client):
+//
+//	{"type": "lanshare:screen", "screen": {...}, "lines": [...], "write": ...}  snapshot, sent first
+//	{"type": "lanshare:line", "line": {...}}                                   new or updated line (remove=true for deleted lines)
+//	{"type": "lanshare:pty", "lineid": ..., "ptypos": ..., "data64": ...}
+//	{"type": "lanshare:presence", "clients": [...]}                            the connected clients and their cursor lines
+//	{"type": "lanshare:error", "error": ...}                                   a client message was rejected or failed
+//
+// client -> server (the client's name is set with the "name" url param):
+//
+//	{"type": "lanshare:cursor", "lineid": ...}                     all clients
+//	{"type": "lanshare:runcmd", "cmdstr": ...}                     write access only
+//	{"type": "lanshare:input", "lineid": ..., "inputdata64": ..., "signame": ...}  write access only
+package lanshare
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/screenattach"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/wsshell"
+)
+
+const LanShareDefaultPort = 1629 // P=16, L=29 (see MainServerAddr)
+const LanShareDefaultBindAddr = "127.0.0.1"
+const LanSharePath = "/lanshare"
+const LanShareTokenTimeout = 30 * time.Minute // unused tokens expire
+const LanSharePtyTailSize = 64 * 1024
+const lanShareTokenBytes = 18
+
+const (
+	MsgType_Screen   = "lanshare:screen"
+	MsgType_Line     = "lanshare:line"
+	MsgType_Pty      = "lanshare:pty"
+	MsgType_Presence = "lanshare:presence"
+	MsgType_Error    = "lanshare:error"
+	MsgType_Cursor   = "lanshare:cursor"
+	MsgType_RunCmd   = "lanshare:runcmd"
+	MsgType_Input    = "lanshare:input"
+)
+
+type ShareOpts struct {
+	Write    bool
+	RunCmdFn screenattach.RunCmdFnType // required for write shares, only runs shell commands
+	BindAddr string                    // interface address of the server, "" for LanShareDefaultBindAddr
+}
+
+type ShareInfo struct {
+	ScreenId   string
+	Url        string
+	BindAddr   string
+	CreateTs   time.Time
+	Write      bool
+	ViewerAddr string // "" if the token has not been used
+	ViewerName string
+}
+
+type lanShare struct {
+	ScreenId   string
+	Token      string
+	CreateTs   time.Time
+	Opts       ShareOpts
+	ViewerAddr string
+	ViewerName string
+	ViewerKey  string // scbus channel key of the viewer
+}
+
+type shareServer struct {
+	Server   *http.Server
+	Port     int
+	BindAddr string
+	Shares   map[string]*lanShare // token -> share
+	Stopped  bool
+}
+
+var shareLock = &sync.Mutex{}
+var curServer *shareServer
+
+type ViewerScreen struct {
+	ScreenId string `json:"screenid"`
+	Name     string `json:"name"`
+}
+
+type ViewerLine struct {
+	LineId     string `json:"lineid"`
+	LineNum    int64  `json:"linenum"`
+	LineType   string `json:"linetype"`
+	Ts         int64  `json:"ts"`
+	Text       string `json:"text,omitempty"`
+	CmdStr     string `json:"cmdstr,omitempty"`
+	Status     string `json:"status,omitempty"`
+	ExitCode   int    `json:"exitcode,omitempty"`
+	DurationMs int    `json:"durationms,omitempty"`
+	Rows       int64  `json:"rows,omitempty"`
+	Cols       int64  `json:"cols,omitempty"`
+	Remove     bool   `json:"remove,omitempty"`
+}
+
+type screenMsg struct {
+	Type   string        `json:"type"`
+	Screen ViewerScreen  `json:"screen"`
+	Lines  []*ViewerLine `json:"lines"`
+	Write  bool          `json:"write"`
+}
+
+type lineMsg struct {
+	Type string      `json:"type"`
+	Line *ViewerLine `json:"line"`
+}
+
+type ptyMsg struct {
+	Type   string `json:"type"`
+	LineId string `json:"lineid"`
+	PtyPos int64  `json:"ptypos"`
+	Data64 string `json:"data64"`
+}
+
+func makeViewerLine(line *sstore.LineType, cmd *sstore.CmdType) *ViewerLine {
+	rtn := &ViewerLine{
+		LineId:   line.LineId,
+		LineNum:  line.LineNum,
+		LineType: line.LineType,
+		Ts:       line.Ts,
+		Text:     line.Text,
+		Remove:   line.Remove || line.Archived,
+	}
+	if cmd != nil && cmd.LineId == line.LineId {
+		rtn.CmdStr = cmd.CmdStr
+		rtn.Status = cmd.Status
+		rtn.ExitCode = cmd.ExitCode
+		rtn.DurationMs = cmd.DurationMs
+		rtn.Rows = cmd.TermOpts.Rows
+		rtn.Cols = cmd.TermOpts.Cols
+	}
+	return rtn
+}
+
+func makeToken() (string, error) {
+	barr := make([]byte, lanShareTokenBytes)
+	_, err := rand.Read(barr)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(barr), nil
+}
+
+// the first non-loopback ipv4 address (the address teammates use to connect), "localhost" if there is none
+func GetLanAddress() string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "localhost"
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if ok && ipNet.IP.To4() != nil && !ipNet.IP.IsLinkLocalUnicast() {
+				return ipNet.IP.String()
+			}
+		}
+	}
+	return "localhost"
+}
+
+func makeShareUrl(bindAddr string, port int, token string) string {
+	host := bindAddr
+	if net.ParseIP(bindAddr).IsUnspecified() {
+		host = GetLanAddress()
+	}
+	return fmt.Sprintf("ws://%s%s?token=%s", net.JoinHostPort(host, strconv.Itoa(port)), LanSharePath, token)
+}
+
+func makeShareInfo(server *shareServer, share *lanShare) ShareInfo {
+	return ShareInfo{
+		ScreenId:   share.ScreenId,
+		Url:        makeShareUrl(server.BindAddr, server.Port, share.Token),
+		BindAddr:   server.BindAddr,
+		CreateTs:   share.CreateTs,
+		Write:      share.Opts.Write,
+		ViewerAddr: share.ViewerAddr,
+		ViewerName: share.ViewerName,
+	}
+}
+
+// must hold shareLock
+func startServer_nolock(bindAddr string, port int) error {
+	if curServer != nil {
+		if curServer.Port != port || curServer.BindAddr != bindAddr {
+			return fmt.Errorf("lan sharing is already running on %s", net.JoinHostPort(curServer.BindAddr, strconv.Itoa(curServer.Port)))
+		}
+		return nil
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(bindAddr, strconv.Itoa(port)))
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s: %v", net.JoinHostPort(bindAddr, strconv.Itoa(port)), err)
+	}
+	server := &shareServer{Port: port, BindAddr: bindAddr, Shares: make(map[string]*lanShare)}
+	mux := http.NewServeMux()
+	mux.HandleFunc(LanSharePath, server.handleViewer)
+	server.Server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		MaxHeaderBytes:    16 * 1024,
+	}
+	curServer = server
+	log.Printf("[lanshare] running share server on %s\n", listener.Addr())
+	go func() {
+		err := server.Server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("[lanshare] error running share server: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// must hold shareLock
+func stopServerIfIdle_nolock() {
+	if curServer == nil || len(curServer.Shares) > 0 {
+		return
+	}
+	log.Printf("[lanshare] stopping share server (no shares)\n")
+	curServer.Stopped = true
+	// viewer connections are hijacked (not closed by Close), they are stopped through their shares
+	curServer.Server.Close()
+	curServer = nil
+}
+
+// creates a share for the screen (starts the server if needed), returns the share info with the viewer url
+func StartScreenShare(screenId string, port int, opts ShareOpts) (ShareInfo, error) {
+	if opts.Write && opts.RunCmdFn == nil {
+		return ShareInfo{}, fmt.Errorf("write shares require a command runner")
+	}
+	if opts.BindAddr == "" {
+		opts.BindAddr = LanShareDefaultBindAddr
+	}
+	if net.ParseIP(opts.BindAddr) == nil {
+		return ShareInfo{}, fmt.Errorf("invalid bind address %q (must be an ip address)", opts.BindAddr)
+	}
+	token, err := makeToken()
+	if err != nil {
+		return ShareInfo{}, fmt.Errorf("cannot create share token: %v", err)
+	}
+	shareLock.Lock()
+	defer shareLock.Unlock()
+	err = startServer_nolock(opts.BindAddr, port)
+	if err != nil {
+		return ShareInfo{}, err
+	}
+	share := &lanShare{ScreenId: screenId, Token: token, CreateTs: time.Now(), Opts: opts}
+	curServer.Shares[token] = share
+	server := curServer
+	time.AfterFunc(LanShareTokenTimeout, func() { server.expireShare(share) })
+	return makeShareInfo(curServer, share), nil
+}
+
+// removes the share if its token was not used (stops the server if it was the last share)
+func (server *shareServer) expireShare(share *lanShare) {
+	shareLock.Lock()
+	defer shareLock.Unlock()
+	if server.Shares[share.Token] != share || share.ViewerKey != "" {
+		return
+	}
+	delete(server.Shares, share.Token)
+	if curServer == server {
+		stopServerIfIdle_nolock()
+	}
+}
+
+// stops all the shares of the screen (closing the viewer connections), returns the number of shares stopped
+func StopScreenShares(screenId string) int {
+	shareLock.Lock()
+	defer shareLock.Unlock()
+	if curServer == nil {
+		return 0
+	}
+	numStopped := 0
+	for token, share := range curServer.Shares {
+		if share.ScreenId != screenId {
+			continue
+		}
+		delete(curServer.Shares, token)
+		if share.ViewerKey != "" {
+			// closes the viewer's update channel, which ends the connection
+			scbus.MainUpdateBus.UnregisterChannel(share.ViewerKey)
+		}
+		numStopped++
+	}
+	stopServerIfIdle_nolock()
+	return numStopped
+}
+
+func GetScreenShares(screenId string) []ShareInfo {
+	shareLock.Lock()
+	defer shareLock.Unlock()
+	if curServer == nil {
+		return nil
+	}
+	var rtn []ShareInfo
+	for _, share := range curServer.Shares {
+		if share.ScreenId == screenId {
+			rtn = append(rtn, makeShareInfo(curServer, share))
+		}
+	}
+	sort.Slice(rtn, func(i, j int) bool { return rtn[i].CreateTs.Before(rtn[j].CreateTs) })
+	return rtn
+}
+
+// consumes the token (one-time), returns nil if it is invalid, used, or expired
+func (server *shareServer) useToken(token string, viewerAddr string, viewerName string, viewerKey string) *lanShare {
+	shareLock.Lock()
+	defer shareLock.Unlock()
+	if server.Stopped {
+		return nil
+	}
+	share := server.Shares[token]
+	if share == nil || share.ViewerKey != "" {
+		return nil
+	}
+	if time.Since(share.CreateTs) > LanShareTokenTimeout {
+		delete(server.Shares, token)
+		if curServer == server {
+			stopServerIfIdle_nolock()
+		}
+		return nil
+	}
+	share.ViewerAddr = viewerAddr
+	share.ViewerName = viewerName
+	share.ViewerKey = viewerKey
+	return share
+}
+
+// the token was used, so the share ends with its client (stops the server if it was the last share)
+func (server *shareServer) viewerDone(share *lanShare) {
+	shareLock.Lock()
+	defer shareLock.Unlock()
+	if server.Shares[share.Token] != share {
+		return
+	}
+	delete(server.Shares, share.Token)
+	if curServer == server {
+		stopServerIfIdle_nolock()
+	}
+}
+
+func (server *shareServer) handleViewer(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	viewerKey := "lanshare:" + token
+	viewerName := cleanViewerName(r.URL.Query().Get("name"), r.RemoteAddr)
+	share := server.useToken(token, r.RemoteAddr, viewerName, viewerKey)
+	if share == nil {
+		http.Error(w, "invalid or expired share token", http.StatusForbidden)
+		return
+	}
+	shell, err := wsshell.StartWS(w, r)
+	if err != nil {
+		log.Printf("[lanshare] websocket upgrade failed: %v\n", err)
+		server.viewerDone(share)
+		return
+	}
+	defer shell.Conn.Close()
+	defer server.viewerDone(share)
+	log.Printf("[lanshare] viewer connected screen=%s addr=%s name=%q write=%v\n", share.ScreenId, r.RemoteAddr, viewerName, share.Opts.Write)
+	runViewer(share, viewerKey, shell)
+	log.Printf("[lanshare] viewer disconnected screen=%s addr=%s\n", share.ScreenId, r.RemoteAddr)
+}
+
+func writeSnapshot(ctx context.Context, screenId string, write bool, shell *wsshell.WSShell) error {
+	screen, err := sstore.GetScreenById(ctx, screenId)
+	if err != nil {
+		return err
+	}
+	if screen == nil {
+		return fmt.Errorf("screen not found")
+	}
+	screenLines, err := sstore.GetScreenLinesById(ctx, screenId)
+	if err != nil {
+		return err
+	}
+	cmdMap := make(map[string]*sstore.CmdType)
+	for _, cmd := range screenLines.Cmds {
+		cmdMap[cmd.LineId] = cmd
+	}
+	msg := screenMsg{Type: MsgType_Screen, Screen: ViewerScreen{ScreenId: screenId, Name: screen.Name}, Lines: []*ViewerLine{}, Write: write}
+	var ptyMsgs []*ptyMsg
+	for _, line := range screenLines.Lines {
+		if line.Archived {
+			continue
+		}
+		cmd := cmdMap[line.LineId]
+		msg.Lines = append(msg.Lines, makeViewerLine(line, cmd))
+		if cmd == nil {
+			continue
+		}
+		realOffset, data, err := sstore.ReadFullPtyOutFile(ctx, screenId, line.LineId)
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		tailStart := max(0, len(data)-LanSharePtyTailSize)
+		ptyMsgs = append(ptyMsgs, &ptyMsg{
+			Type:   MsgType_Pty,
+			LineId: line.LineId,
+			PtyPos: realOffset + int64(tailStart),
+			Data64: base64.StdEncoding.EncodeToString(data[tailStart:]),
+		})
+	}
+	err = shell.WriteJson(msg)
+	if err != nil {
+		return err
+	}
+	for _, pmsg := range ptyMsgs {
+		err = shell.WriteJson(pmsg)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// converts a bus update to viewer messages (only lines, pty output, and presence of the shared screen)
+func makeViewerMsgs(screenId string, update scbus.UpdatePacket) []any {
+	var rtn []any
+	switch upk := update.(type) {
+	case *scbus.PtyDataUpdatePacketType:
+		if upk.Data != nil && upk.Data.ScreenId == screenId && upk.Data.LineId != "" {
+			rtn = append(rtn, &ptyMsg{Type: MsgType_Pty, LineId: upk.Data.LineId, PtyPos: upk.Data.PtyPos, Data64: upk.Data.GetPtyData64()})
+		}
+	case *scbus.ModelUpdatePacketType:
+		if upk.IsEmpty() {
+			return nil
+		}
+		for _, item := range *upk.Data {
+			switch uitem := item.(type) {
+			case sstore.LineUpdate:
+				if uitem.Line.ScreenId == screenId {
+					rtn = append(rtn, &lineMsg{Type: MsgType_Line, Line: makeViewerLine(&uitem.Line, &uitem.Cmd)})
+				}
+			case sstore.CmdType:
+				if uitem.ScreenId == screenId {
+					// cmd status updates have no line (the line fields are only used for the line id)
+					line := &sstore.LineType{ScreenId: uitem.ScreenId, LineId: uitem.LineId}
+					rtn = append(rtn, &lineMsg{Type: MsgType_Line, Line: makeViewerLine(line, &uitem)})
+				}
+			case PresenceUpdateType:
+				if uitem.ScreenId == screenId {
+					rtn = append(rtn, &presenceMsg{Type: MsgType_Presence, Clients: uitem.Clients})
+				}
+			}
+		}
+	}
+	return rtn
+}
+
+func runViewer(share *lanShare, viewerKey string, shell *wsshell.WSShell) {
+	screenId := share.ScreenId
+	// registered before the snapshot so no updates are missed (the viewer de-dups pty output by ptypos)
+	updateCh := scbus.MainUpdateBus.RegisterChannel(viewerKey, &scbus.UpdateChannel{ScreenId: screenId})
+	defer scbus.MainUpdateBus.UnregisterChannel(viewerKey)
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+	err := writeSnapshot(ctx, screenId, share.Opts.Write, shell)
+	cancelFn()
+	if err != nil {
+		log.Printf("[lanshare] error writing screen snapshot: %v\n", err)
+		return
+	}
+	sendErrorFn := func(err error) {
+		shell.WriteJson(&errorMsg{Type: MsgType_Error, Error: err.Error()})
+	}
+	addPresence(screenId, viewerKey, share.ViewerName, share.Opts.Write)
+	defer removePresence(screenId, viewerKey)
+	for {
+		select {
+		case update, ok := <-updateCh:
+			if !ok {
+				// share stopped
+				closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "share stopped")
+				shell.Conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+				return
+			}
+			for _, msg := range makeViewerMsgs(screenId, update) {
+				err := shell.WriteJson(msg)
+				if err != nil {
+					return
+				}
+			}
+
+		case msgBytes, ok := <-shell.ReadChan:
+			if !ok {
+				return
+			}
+			err := handleViewerMsg(share, viewerKey, sendErrorFn, msgBytes)
+			if err != nil {
+				sendErrorFn(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// keyboard macros.  a macro is a named sequence of steps: commands (run as if typed at the prompt) and input
+// sent to the running command (keystrokes, signals).  macros are recorded on a screen (/macro:record, the
+// commands typed and the input sent on that screen until /macro:save) and can be run on any screen (see
+// cmdrunner/macros.go).  each step keeps the delay since the previous step.  command steps wait for the
+// prompt (no running commands on the screen) before they run.
+package macros
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+const (
+	StepKind_Cmd   = "cmd"
+	StepKind_Input = "input"
+)
+
+const MaxMacroSteps = 200
+const MaxStepDelayMs = 10 * 1000 // recorded delays are capped (time spent away from the keyboard)
+const MaxNameLen = 50
+const InputMergeMs = 1000 // input sent within this time of the previous input step is added to that step
+
+var macroNameRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]*$`)
+
+type MacroStepType struct {
+	Kind        string `json:"kind"`
+	CmdStr      string `json:"cmdstr,omitempty"`
+	InputData64 string `json:"inputdata64,omitempty"`
+	SigName     string `json:"signame,omitempty"`
+	DelayMs     int64  `json:"delayms"`               // delay after the previous step
+	AwaitPrompt bool   `json:"awaitprompt,omitempty"` // wait until no commands are running on the screen
+}
+
+type MacroType struct {
+	MacroId     string          `json:"macroid"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Steps       []MacroStepType `json:"steps"`
+	CreatedTs   int64           `json:"createdts"`
+	LastRunTs   int64           `json:"lastrunts"`
+}
+
+func (m *MacroType) ToMap() map[string]interface{} {
+	rtn := make(map[string]interface{})
+	rtn["macroid"] = m.MacroId
+	rtn["name"] = m.Name
+	rtn["description"] = m.Description
+	rtn["steps"] = dbutil.QuickJsonArr(m.Steps)
+	rtn["createdts"] = m.CreatedTs
+	rtn["lastrunts"] = m.LastRunTs
+	return rtn
+}
+
+func (m *MacroType) FromMap(mval map[string]interface{}) bool {
+	dbutil.QuickSetStr(&m.MacroId, mval, "macroid")
+	dbutil.QuickSetStr(&m.Name, mval, "name")
+	dbutil.QuickSetStr(&m.Description, mval, "description")
+	dbutil.QuickSetJsonArr(&m.Steps, mval, "steps")
+	dbutil.QuickSetInt64(&m.CreatedTs, mval, "createdts")
+	dbutil.QuickSetInt64(&m.LastRunTs, mval, "lastrunts")
+	return true
+}
+
+func ValidateMacroName(name string) error {
+	if name == "" {
+		return fmt.Errorf("macro name cannot be empty")
+	}
+	if len(name) > MaxNameLen {
+		return fmt.Errorf("macro name too long, max %d characters", MaxNameLen)
+	}
+	if !macroNameRe.MatchString(name) {
+		return fmt.Errorf("invalid macro name %q, must start with a letter and only contain letters, numbers, '_', '.', and '-'", name)
+	}
+	return nil
+}
+
+func GetMacros(ctx context.Context) ([]*MacroType, error) {
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) ([]*MacroType, error) {
+		query := `SELECT * FROM macro ORDER BY name`
+		return dbutil.SelectMapsGen[*MacroType](tx, query), nil
+	})
+}
+
+// arg is a macro name or macroid, returns nil if not found
+func GetMacroByArg(ctx context.Context, arg string) (*MacroType, error) {
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) (*MacroType, error) {
+		query := `SELECT * FROM macro WHERE name = ? OR macroid = ?`
+		return dbutil.GetMapGen[*MacroType](tx, query, arg, arg), nil
+	})
+}
+
+// replaces an existing macro with the same name (keeps its macroid)
+func SaveMacro(ctx context.Context, macro *MacroType) error {
+	if len(macro.Steps) == 0 {
+		return fmt.Errorf("macro has no steps")
+	}
+	return sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		query := `SELECT macroid FROM macro WHERE name = ?`
+		existingId := tx.GetString(query, macro.Name)
+		if existingId != "" {
+			macro.MacroId = existingId
+			query = `UPDATE macro SET description = ?, steps = ?, createdts = ? WHERE macroid = ?`
+			tx.Exec(query, macro.Description, dbutil.QuickJsonArr(macro.Steps), macro.CreatedTs, existingId)
+			return nil
+		}
+		query = `INSERT INTO macro ( macroid, name, description, steps, createdts, lastrunts)
+		                    VALUES (:macroid,:name,:description,:steps,:createdts,:lastrunts)`
+		tx.NamedExec(query, macro.ToMap())
+		return nil
+	})
+}
+
+func DeleteMacro(ctx context.Context, macroId string) error {
+	return sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		query := `DELETE FROM macro WHERE macroid = ?`
+		tx.Exec(query, macroId)
+		return nil
+	})
+}
+
+func SetMacroLastRun(ctx context.Context, macroId string, ts int64) error {
+	return sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		query := `UPDATE macro SET lastrunts = ? WHERE macroid = ?`
+		tx.Exec(query, ts, macroId)
+		return nil
+	})
+}
+
+// recording (in memory until saved)
+
+type macroRecorder struct {
+	Name       string
+	Steps      []MacroStepType
+	LastStepTs time.Time
+}
+
+var recordLock = &sync.Mutex{}
+var recorderMap = make(map[string]*macroRecorder) // screenid -> recorder
+
+func StartRecording(screenId string, name string) error {
+	err := ValidateMacroName(name)
+	if err != nil {
+		return err
+	}
+	recordLock.Lock()
+	defer recordLock.Unlock()
+	if recorderMap[screenId] != nil {
+		return fmt.Errorf("already recording macro %q on this screen", recorderMap[screenId].Name)
+	}
+	recorderMap[screenId] = &macroRecorder{Name: name, LastStepTs: time.Now()}
+	return nil
+}
+
+// returns the macro name and the recorded steps, ok is false if the screen was not recording
+func StopRecording(screenId string) (string, []MacroStepType, bool) {
+	recordLock.Lock()
+	defer recordLock.Unlock()
+	rec := recorderMap[screenId]
+	if rec == nil {
+		return "", nil, false
+	}
+	delete(recorderMap, screenId)
+	return rec.Name, rec.Steps, true
+}
+
+func IsRecording(screenId string) bool {
+	recordLock.Lock()
+	defer recordLock.Unlock()
+	return recorderMap[screenId] != nil
+}
+
+func recordStep(screenId string, step MacroStepType) {
+	recordLock.Lock()
+	defer recordLock.Unlock()
+	rec := recorderMap[screenId]
+	if rec == nil || len(rec.Steps) >= MaxMacroSteps {
+		return
+	}
+	now := time.Now()
+	sinceLastMs := now.Sub(rec.LastStepTs).Milliseconds()
+	rec.LastStepTs = now
+	if len(rec.Steps) > 0 && sinceLastMs < InputMergeMs && mergeInputStep(&rec.Steps[len(rec.Steps)-1], step) {
+		return
+	}
+	step.DelayMs = min(sinceLastMs, MaxStepDelayMs)
+	rec.Steps = append(rec.Steps, step)
+}
+
+// typed input is recorded as one step per burst instead of one step per keystroke
+func mergeInputStep(lastStep *MacroStepType, step MacroStepType) bool {
+	if lastStep.Kind != StepKind_Input || step.Kind != StepKind_Input || lastStep.SigName != "" || step.SigName != "" {
+		return false
+	}
+	lastData, err := base64.StdEncoding.DecodeString(lastStep.InputData64)
+	if err != nil {
+		return false
+	}
+	data, err := base64.StdEncoding.DecodeString(step.InputData64)
+	if err != nil {
+		return false
+	}
+	lastStep.InputData64 = base64.StdEncoding.EncodeToString(append(lastData, data...))
+	return true
+}
+
+// called for commands typed on the screen
+func RecordCmd(screenId string, cmdStr string) {
+	recordStep(screenId, MacroStepType{Kind: StepKind_Cmd, CmdStr: cmdStr, AwaitPrompt: true})
+}
+
+// called for input sent to a running command on the screen
+func RecordInput(screenId string, inputData64 string, sigName string) {
+	if inputData64 == "" && sigName == "" {
+		return
+	}
+	recordStep(screenId, MacroStepType{Kind: StepKind_Input, InputData64: inputData64, SigName: sigName})
+}
@@ -0,0 +1,64 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package macros
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestValidateMacroName(t *testing.T) {
+	for _, name := range []string{"deploy", "build-and-test", "k8s.logs", "a_1"} {
+		if err := ValidateMacroName(name); err != nil {
+			t.Errorf("name %q unexpected error: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "1abc", "has space", "-x", "semi;colon", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"} {
+		if err := ValidateMacroName(name); err == nil {
+			t.Errorf("name %q expected an error", name)
+		}
+	}
+}
+
+func TestRecordSteps(t *testing.T) {
+	screenId := "test-screen"
+	err := StartRecording(screenId, "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if StartRecording(screenId, "other") == nil {
+		t.Errorf("expected an error starting a second recording on the same screen")
+	}
+	RecordCmd(screenId, "vim notes.txt")
+	RecordInput(screenId, base64.StdEncoding.EncodeToString([]byte("i")), "")
+	RecordInput(screenId, base64.StdEncoding.EncodeToString([]byte("hello")), "")
+	RecordInput(screenId, "", "SIGINT")
+	RecordInput(screenId, "", "")
+	RecordCmd("other-screen", "ls")
+	name, steps, ok := StopRecording(screenId)
+	if !ok || name != "test" {
+		t.Fatalf("expected recording %q, got %q ok:%v", "test", name, ok)
+	}
+	if len(steps) != 3 {
+		t.Fatalf("expected 3 steps, got %d: %#v", len(steps), steps)
+	}
+	if steps[0].Kind != StepKind_Cmd || steps[0].CmdStr != "vim notes.txt" || !steps[0].AwaitPrompt {
+		t.Errorf("bad cmd step: %#v", steps[0])
+	}
+	inputData, _ := base64.StdEncoding.DecodeString(steps[1].InputData64)
+	if steps[1].Kind != StepKind_Input || string(inputData) != "ihello" {
+		t.Errorf("expected merged input step %q, got %#v (%q)", "ihello", steps[1], inputData)
+	}
+	if steps[2].SigName != "SIGINT" || steps[2].InputData64 != "" {
+		t.Errorf("bad signal step: %#v", steps[2])
+	}
+	for _, step := range steps {
+		if step.DelayMs < 0 || step.DelayMs > MaxStepDelayMs {
+			t.Errorf("bad step delay: %d", step.DelayMs)
+		}
+	}
+	if _, _, ok := StopRecording(screenId); ok {
+		t.Errorf("expected recording to be stopped")
+	}
+}
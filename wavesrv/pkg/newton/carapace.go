@@ -0,0 +1,133 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package newton
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// carapace specs (carapace-spec, as json) are converted to fig specs when they are loaded.  flags are
+// "-v, --verbose" keys, with "=" for flags that take a value, "?" for an optional value, "*" for repeatable and
+// "&" for hidden flags.  completion values can have a tab separated description, "$files" and "$directories" map
+// to the fig templates, other carapace macros are not supported and are skipped.
+
+type CarapaceSpec struct {
+	Name            string            `json:"name"`
+	Aliases         []string          `json:"aliases,omitempty"`
+	Description     string            `json:"description,omitempty"`
+	Flags           map[string]string `json:"flags,omitempty"`
+	PersistentFlags map[string]string `json:"persistentflags,omitempty"`
+	Completion      *struct {
+		Flag          map[string][]string `json:"flag,omitempty"`
+		Positional    [][]string          `json:"positional,omitempty"`
+		PositionalAny []string            `json:"positionalany,omitempty"`
+	} `json:"completion,omitempty"`
+	Commands []*CarapaceSpec `json:"commands,omitempty"`
+}
+
+var carapaceFlagModsRe = regexp.MustCompile(`[=?*&]+$`)
+
+// true if the json spec object has carapace fields
+func isCarapaceSpec(data json.RawMessage) bool {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(data, &fields) != nil {
+		return false
+	}
+	for _, key := range []string{"flags", "persistentflags", "commands", "completion"} {
+		if _, found := fields[key]; found {
+			return true
+		}
+	}
+	return false
+}
+
+func convertCarapaceValues(arg *SpecArg, values []string) {
+	for _, value := range values {
+		switch {
+		case value == "$files":
+			arg.Template = append(arg.Template, "filepaths")
+		case value == "$directories":
+			arg.Template = append(arg.Template, "folders")
+		case !strings.HasPrefix(value, "$"):
+			name, description, _ := strings.Cut(value, "\t")
+			arg.Suggestions = append(arg.Suggestions, SpecSuggestion{Name: StringList{name}, Description: description})
+		}
+	}
+}
+
+func convertCarapaceFlags(flags map[string]string, flagCompletions map[string][]string, isPersistent bool) []*SpecOption {
+	flagKeys := make([]string, 0, len(flags))
+	for flagKey := range flags {
+		flagKeys = append(flagKeys, flagKey)
+	}
+	sort.Strings(flagKeys)
+	var rtn []*SpecOption
+	for _, flagKey := range flagKeys {
+		mods := carapaceFlagModsRe.FindString(flagKey)
+		if strings.Contains(mods, "&") {
+			continue
+		}
+		var names StringList
+		for _, name := range strings.Split(strings.TrimSuffix(flagKey, mods), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		opt := &SpecOption{
+			Name:         names,
+			Description:  flags[flagKey],
+			IsPersistent: isPersistent,
+			IsRepeatable: strings.Contains(mods, "*"),
+		}
+		if strings.ContainsAny(mods, "=?") {
+			arg := &SpecArg{Name: "value", IsOptional: strings.Contains(mods, "?")}
+			for _, name := range names {
+				if values, found := flagCompletions[strings.TrimLeft(name, "-")]; found {
+					convertCarapaceValues(arg, values)
+					break
+				}
+			}
+			opt.Args = SpecArgList{arg}
+		}
+		rtn = append(rtn, opt)
+	}
+	return rtn
+}
+
+func ConvertCarapaceSpec(cspec *CarapaceSpec) *Spec {
+	spec := &Spec{
+		Name:        append(StringList{cspec.Name}, cspec.Aliases...),
+		Description: cspec.Description,
+	}
+	var flagCompletions map[string][]string
+	if cspec.Completion != nil {
+		flagCompletions = cspec.Completion.Flag
+	}
+	spec.Options = append(convertCarapaceFlags(cspec.Flags, flagCompletions, false), convertCarapaceFlags(cspec.PersistentFlags, flagCompletions, true)...)
+	if cspec.Completion != nil {
+		for idx, values := range cspec.Completion.Positional {
+			arg := &SpecArg{Name: fmt.Sprintf("arg%d", idx+1)}
+			convertCarapaceValues(arg, values)
+			spec.Args = append(spec.Args, arg)
+		}
+		if cspec.Completion.PositionalAny != nil {
+			arg := &SpecArg{Name: "args", IsVariadic: true, IsOptional: true}
+			convertCarapaceValues(arg, cspec.Completion.PositionalAny)
+			spec.Args = append(spec.Args, arg)
+		}
+	}
+	for _, subCSpec := range cspec.Commands {
+		if subCSpec != nil {
+			spec.Subcommands = append(spec.Subcommands, ConvertCarapaceSpec(subCSpec))
+		}
+	}
+	return spec
+}
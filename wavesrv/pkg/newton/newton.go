@@ -0,0 +1,175 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// newton is the command completion engine.  completion providers return suggestions for the command line being
+// typed, GetSuggestions merges them into one ranked list (best first) for the frontend autocomplete (/_suggest).
+// the spec provider (specprovider.go) completes commands, subcommands, options and args from the completion specs
+// (spec.go), other packages register providers for their own data (see RegisterProvider).
+package newton
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+)
+
+const MaxSuggestions = 50
+
+// suggestion scores are on the fig priority scale (0-100, 50 is the default), higher is better
+const DefaultSuggestionScore = 50
+
+const (
+	SuggestionType_Command    = "command"
+	SuggestionType_Subcommand = "subcommand"
+	SuggestionType_Option     = "option"
+	SuggestionType_Arg        = "arg"
+	SuggestionType_History    = "history"
+)
+
+type SuggestionRequest struct {
+	CmdLine  string // the command line up to the cursor
+	RemoteId string
+	Cwd      string // "" if not known
+}
+
+type SuggestionType struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Type        string  `json:"type"`
+	Provider    string  `json:"provider"`
+	Score       float64 `json:"score"`
+	ReplaceLen  int     `json:"replacelen"` // number of chars before the cursor that are replaced with name
+}
+
+type SuggestionsType struct {
+	CmdLine     string            `json:"cmdline"`
+	Suggestions []*SuggestionType `json:"suggestions"`
+}
+
+func (SuggestionsType) GetType() string {
+	return "suggestions"
+}
+
+// a completion spec for the frontend autocomplete, spec is nil if there is no spec for the command
+type CompletionSpecType struct {
+	Name string `json:"name"`
+	Spec *Spec  `json:"spec,omitempty"`
+}
+
+func (CompletionSpecType) GetType() string {
+	return "completionspec"
+}
+
+func init() {
+	scbus.RegisterModelUpdateItem(SuggestionsType{}, CompletionSpecType{})
+}
+
+// returns the suggestions for the request (any order, GetSuggestions ranks them).  providers are called
+// concurrently and must be safe for concurrent use.
+type CompletionProvider interface {
+	GetSuggestions(ctx context.Context, req SuggestionRequest) ([]*SuggestionType, error)
+}
+
+var providersLock = &sync.Mutex{}
+var providers = make(map[string]CompletionProvider)
+
+// name identifies the provider (SuggestionType.Provider, the providers arg of GetSuggestions), registering a
+// provider with the same name replaces it
+func RegisterProvider(name string, provider CompletionProvider) error {
+	if name == "" || provider == nil {
+		return fmt.Errorf("invalid completion provider %q", name)
+	}
+	providersLock.Lock()
+	defer providersLock.Unlock()
+	providers[name] = provider
+	return nil
+}
+
+// returns the names of the registered providers (sorted)
+func GetProviderNames() []string {
+	providersLock.Lock()
+	defer providersLock.Unlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func getProvider(name string) CompletionProvider {
+	providersLock.Lock()
+	defer providersLock.Unlock()
+	return providers[name]
+}
+
+// returns the suggestions of the providers (nil for all providers), best first (at most MaxSuggestions).  a
+// provider that fails is logged and skipped, so the other providers still complete.
+func GetSuggestions(ctx context.Context, req SuggestionRequest, providerNames []string) ([]*SuggestionType, error) {
+	if providerNames == nil {
+		providerNames = GetProviderNames()
+	}
+	providerSugs := make([][]*SuggestionType, len(providerNames))
+	var wg sync.WaitGroup
+	for idx, name := range providerNames {
+		provider := getProvider(name)
+		if provider == nil {
+			return nil, fmt.Errorf("unknown completion provider %q", name)
+		}
+		wg.Add(1)
+		go func(idx int, name string, provider CompletionProvider) {
+			defer wg.Done()
+			sugs, err := provider.GetSuggestions(ctx, req)
+			if err != nil {
+				log.Printf("[newton] error getting %s suggestions: %v\n", name, err)
+				return
+			}
+			for _, sug := range sugs {
+				sug.Provider = name
+			}
+			providerSugs[idx] = sugs
+		}(idx, name, provider)
+	}
+	wg.Wait()
+	return rankSuggestions(providerSugs), nil
+}
+
+// merges the suggestions, a suggestion returned more than once keeps its best score
+func rankSuggestions(providerSugs [][]*SuggestionType) []*SuggestionType {
+	type sugKey struct {
+		Name       string
+		ReplaceLen int
+	}
+	sugMap := make(map[sugKey]*SuggestionType)
+	var rtn []*SuggestionType
+	for _, sugs := range providerSugs {
+		for _, sug := range sugs {
+			if sug == nil || sug.Name == "" {
+				continue
+			}
+			key := sugKey{Name: sug.Name, ReplaceLen: sug.ReplaceLen}
+			if prevSug := sugMap[key]; prevSug != nil {
+				if sug.Score > prevSug.Score {
+					*prevSug = *sug
+				}
+				continue
+			}
+			sugMap[key] = sug
+			rtn = append(rtn, sug)
+		}
+	}
+	sort.SliceStable(rtn, func(i, j int) bool {
+		if rtn[i].Score != rtn[j].Score {
+			return rtn[i].Score > rtn[j].Score
+		}
+		return rtn[i].Name < rtn[j].Name
+	})
+	if len(rtn) > MaxSuggestions {
+		rtn = rtn[:MaxSuggestions]
+	}
+	return rtn
+}
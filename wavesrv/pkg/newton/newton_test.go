@@ -0,0 +1,124 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package newton
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testProvider struct {
+	Sugs []*SuggestionType
+	Err  error
+}
+
+func (p testProvider) GetSuggestions(ctx context.Context, req SuggestionRequest) ([]*SuggestionType, error) {
+	return p.Sugs, p.Err
+}
+
+func sugNames(sugs []*SuggestionType) []string {
+	var rtn []string
+	for _, sug := range sugs {
+		rtn = append(rtn, sug.Name)
+	}
+	return rtn
+}
+
+func checkSugNames(t *testing.T, cmdLine string, expected ...string) {
+	t.Helper()
+	names := sugNames(rankSuggestions([][]*SuggestionType{getSpecSuggestions(cmdLine)}))
+	if fmt.Sprint(names) != fmt.Sprint(expected) {
+		t.Errorf("suggestions for %q: %v, expected %v", cmdLine, names, expected)
+	}
+}
+
+func TestSpecSuggestions(t *testing.T) {
+	SetUserSpecDir(t.TempDir())
+	defer SetUserSpecDir("")
+	checkSugNames(t, "gi", "git")
+	checkSugNames(t, "git sta", "stash", "status")
+	checkSugNames(t, "ls && git stash p", "pop", "push")
+	checkSugNames(t, "git status --s", "--short")
+	checkSugNames(t, "git -C /tmp sw", "switch")
+	checkSugNames(t, "git commit -m sta")
+	checkSugNames(t, "git remote -v re", "remove", "rename")
+	checkSugNames(t, "git stash pop ")
+	checkSugNames(t, "nospec ")
+	sugs := getSpecSuggestions("git sta")
+	if len(sugs) == 0 || sugs[0].ReplaceLen != 3 || sugs[0].Type != SuggestionType_Subcommand {
+		t.Errorf("bad subcommand suggestion %#v", sugs)
+	}
+}
+
+func TestUserSpecs(t *testing.T) {
+	specDir := t.TempDir()
+	SetUserSpecDir(specDir)
+	defer SetUserSpecDir("")
+	userSpecs := `[{"name": ["mytool", "mt"], "subcommands": [{"name": "deploy", "args": {"suggestions": ["prod", {"name": "staging", "description": "test env"}]}}]},
+	               {"name": "git", "subcommands": [{"name": "mine"}]}]`
+	err := os.WriteFile(filepath.Join(specDir, "user.json"), []byte(userSpecs), 0644)
+	if err != nil {
+		t.Fatalf("error writing spec file: %v", err)
+	}
+	os.WriteFile(filepath.Join(specDir, "bad.json"), []byte("{"), 0644)
+	SetUserSpecDir("")
+	SetUserSpecDir(specDir)
+	checkSugNames(t, "mt dep", "deploy")
+	checkSugNames(t, "mytool deploy ", "prod", "staging")
+	checkSugNames(t, "git m", "mine")
+}
+
+func TestGetSuggestions(t *testing.T) {
+	RegisterProvider("test-a", testProvider{Sugs: []*SuggestionType{{Name: "a", Score: 10}, {Name: "b", Score: 60}}})
+	RegisterProvider("test-b", testProvider{Sugs: []*SuggestionType{{Name: "a", Score: 70}, {Name: "c", Score: 10}}})
+	RegisterProvider("test-err", testProvider{Err: fmt.Errorf("test error")})
+	defer func() {
+		providersLock.Lock()
+		defer providersLock.Unlock()
+		delete(providers, "test-a")
+		delete(providers, "test-b")
+		delete(providers, "test-err")
+	}()
+	sugs, err := GetSuggestions(context.Background(), SuggestionRequest{}, []string{"test-a", "test-b", "test-err"})
+	if err != nil {
+		t.Fatalf("GetSuggestions error: %v", err)
+	}
+	if fmt.Sprint(sugNames(sugs)) != "[a b c]" || sugs[0].Provider != "test-b" || sugs[0].Score != 70 {
+		t.Errorf("bad ranked suggestions %v", sugNames(sugs))
+	}
+	_, err = GetSuggestions(context.Background(), SuggestionRequest{}, []string{"nosuchprovider"})
+	if err == nil {
+		t.Errorf("unknown provider should return an error")
+	}
+}
+
+func TestCarapaceSpecs(t *testing.T) {
+	carapaceSpec := `{"name": "ctool", "aliases": ["ct"], "description": "carapace tool",
+		"flags": {"-v, --verbose": "verbose output", "-o, --output=": "output format", "--hidden&": "hidden flag"},
+		"persistentflags": {"--config=": "config file"},
+		"completion": {"flag": {"output": ["json\tjson output", "yaml"], "config": ["$files"]}, "positional": [["start", "stop"]]},
+		"commands": [{"name": "sub", "description": "a subcommand", "flags": {"-f": "force"}}]}`
+	fileSpecs, err := ParseSpecFile([]byte(carapaceSpec))
+	if err != nil || len(fileSpecs) != 1 {
+		t.Fatalf("ParseSpecFile error: %v", err)
+	}
+	spec := fileSpecs[0]
+	if fmt.Sprint(spec.Name) != "[ctool ct]" || len(spec.Options) != 3 || len(spec.Subcommands) != 1 {
+		t.Fatalf("bad converted spec %#v", spec)
+	}
+	specDir := t.TempDir()
+	os.WriteFile(filepath.Join(specDir, "ctool.json"), []byte(carapaceSpec), 0644)
+	SetUserSpecDir(specDir)
+	defer SetUserSpecDir("")
+	checkSugNames(t, "ct --", "--config", "--output", "--verbose")
+	checkSugNames(t, "ctool -o ", "json", "yaml")
+	checkSugNames(t, "ctool st", "start", "stop")
+	checkSugNames(t, "ctool sub --", "--config")
+	if GetUserSpec("ctool") == nil || GetUserSpec("git") != nil {
+		t.Errorf("bad user specs")
+	}
+}
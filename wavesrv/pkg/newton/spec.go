@@ -0,0 +1,270 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package newton
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+)
+
+// completion specs are json files in the fig spec format (the static part: name, description, subcommands,
+// options and args with suggestions and templates, no generators or scripts) or in the carapace format
+// (converted to fig specs, see carapace.go).  a file holds a spec or an array of specs.  the bundled specs are embedded (specs/*.json), the user specs are loaded from the spec dir (client
+// opt completionspecdir, default [config]/completion-specs) and replace the bundled specs with the same name.
+// the spec dir is read again when it is used more than UserSpecReloadInterval after the last read, so new
+// spec files are picked up without a restart.
+
+const UserSpecDirName = "completion-specs"
+const UserSpecReloadInterval = time.Minute
+const MaxSpecFileSize = 5 * 1024 * 1024
+
+//go:embed specs/*.json
+var bundledSpecsFS embed.FS
+
+// a fig name (or template) is a string or an array of strings
+type StringList []string
+
+func (sl *StringList) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return json.Unmarshal(data, (*[]string)(sl))
+	}
+	var str string
+	err := json.Unmarshal(data, &str)
+	if err != nil {
+		return err
+	}
+	*sl = StringList{str}
+	return nil
+}
+
+// a fig suggestion is a string or an object
+type SpecSuggestion struct {
+	Name        StringList `json:"name"`
+	Description string     `json:"description,omitempty"`
+}
+
+func (sug *SpecSuggestion) UnmarshalJSON(data []byte) error {
+	var str string
+	if json.Unmarshal(data, &str) == nil {
+		*sug = SpecSuggestion{Name: StringList{str}}
+		return nil
+	}
+	type specSuggestionJson SpecSuggestion
+	return json.Unmarshal(data, (*specSuggestionJson)(sug))
+}
+
+type SpecArg struct {
+	Name        string           `json:"name,omitempty"`
+	Description string           `json:"description,omitempty"`
+	Suggestions []SpecSuggestion `json:"suggestions,omitempty"`
+	Template    StringList       `json:"template,omitempty"` // completed by the frontend (filepaths, folders, ...)
+	IsOptional  bool             `json:"isOptional,omitempty"`
+	IsVariadic  bool             `json:"isVariadic,omitempty"`
+}
+
+// fig args are an arg or an array of args
+type SpecArgList []*SpecArg
+
+func (al *SpecArgList) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return json.Unmarshal(data, (*[]*SpecArg)(al))
+	}
+	var arg SpecArg
+	err := json.Unmarshal(data, &arg)
+	if err != nil {
+		return err
+	}
+	*al = SpecArgList{&arg}
+	return nil
+}
+
+type SpecOption struct {
+	Name         StringList  `json:"name"`
+	Description  string      `json:"description,omitempty"`
+	Args         SpecArgList `json:"args,omitempty"`
+	IsPersistent bool        `json:"isPersistent,omitempty"` // also an option of the subcommands
+	IsRepeatable bool        `json:"isRepeatable,omitempty"`
+}
+
+type Spec struct {
+	Name        StringList    `json:"name"`
+	Description string        `json:"description,omitempty"`
+	Subcommands []*Spec       `json:"subcommands,omitempty"`
+	Options     []*SpecOption `json:"options,omitempty"`
+	Args        SpecArgList   `json:"args,omitempty"`
+}
+
+type specIndex struct {
+	Lock         *sync.Mutex
+	Bundled      map[string]*Spec
+	UserSpecDir  string // "" for the default dir
+	UserSpecs    map[string]*Spec
+	UserLoadTime time.Time
+}
+
+var specs = &specIndex{Lock: &sync.Mutex{}}
+
+func GetDefaultUserSpecDir() string {
+	return filepath.Join(scbase.GetWaveHomeDir(), "config", UserSpecDirName)
+}
+
+// sets the user spec dir ("" for the default dir), the specs are read again on the next use
+func SetUserSpecDir(dir string) {
+	specs.Lock.Lock()
+	defer specs.Lock.Unlock()
+	if dir == specs.UserSpecDir {
+		return
+	}
+	specs.UserSpecDir = dir
+	specs.UserSpecs = nil
+}
+
+func GetUserSpecDir() string {
+	specs.Lock.Lock()
+	defer specs.Lock.Unlock()
+	return getUserSpecDir_nolock()
+}
+
+func getUserSpecDir_nolock() string {
+	if specs.UserSpecDir != "" {
+		return specs.UserSpecDir
+	}
+	return GetDefaultUserSpecDir()
+}
+
+// parses a spec file (a spec or an array of specs, fig or carapace specs, see carapace.go)
+func ParseSpecFile(data []byte) ([]*Spec, error) {
+	var rawSpecs []json.RawMessage
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err := json.Unmarshal(data, &rawSpecs)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		rawSpecs = []json.RawMessage{data}
+	}
+	var fileSpecs []*Spec
+	for _, rawSpec := range rawSpecs {
+		var spec *Spec
+		if isCarapaceSpec(rawSpec) {
+			var cspec CarapaceSpec
+			err := json.Unmarshal(rawSpec, &cspec)
+			if err != nil {
+				return nil, fmt.Errorf("invalid carapace spec: %w", err)
+			}
+			spec = ConvertCarapaceSpec(&cspec)
+		} else {
+			err := json.Unmarshal(rawSpec, &spec)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if spec == nil || len(spec.Name) == 0 || spec.Name[0] == "" {
+			return nil, fmt.Errorf("invalid spec, no name")
+		}
+		fileSpecs = append(fileSpecs, spec)
+	}
+	return fileSpecs, nil
+}
+
+// reads the .json spec files in the dir, files that cannot be read or parsed are logged and skipped
+func loadSpecDir(fsys fs.FS, dir string) map[string]*Spec {
+	rtn := make(map[string]*Spec)
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("[newton] error reading spec dir %q: %v\n", dir, err)
+		}
+		return rtn
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		if finfo, err := entry.Info(); err == nil && finfo.Size() > MaxSpecFileSize {
+			log.Printf("[newton] spec file %q is too large, skipping\n", entry.Name())
+			continue
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			log.Printf("[newton] error reading spec file %q: %v\n", entry.Name(), err)
+			continue
+		}
+		fileSpecs, err := ParseSpecFile(data)
+		if err != nil {
+			log.Printf("[newton] error parsing spec file %q: %v\n", entry.Name(), err)
+			continue
+		}
+		for _, spec := range fileSpecs {
+			for _, name := range spec.Name {
+				rtn[name] = spec
+			}
+		}
+	}
+	return rtn
+}
+
+func loadSpecs_nolock() {
+	if specs.Bundled == nil {
+		specs.Bundled = loadSpecDir(bundledSpecsFS, "specs")
+	}
+	if specs.UserSpecs == nil || time.Since(specs.UserLoadTime) > UserSpecReloadInterval {
+		specs.UserSpecs = loadSpecDir(os.DirFS(getUserSpecDir_nolock()), ".")
+		specs.UserLoadTime = time.Now()
+	}
+}
+
+// returns the spec for the command (nil if there is no spec), user specs take precedence over bundled specs.
+// the returned spec is shared and must not be modified.
+func GetSpec(name string) *Spec {
+	specs.Lock.Lock()
+	defer specs.Lock.Unlock()
+	loadSpecs_nolock()
+	if spec := specs.UserSpecs[name]; spec != nil {
+		return spec
+	}
+	return specs.Bundled[name]
+}
+
+// returns the user spec for the command (nil if there is no user spec)
+func GetUserSpec(name string) *Spec {
+	specs.Lock.Lock()
+	defer specs.Lock.Unlock()
+	loadSpecs_nolock()
+	return specs.UserSpecs[name]
+}
+
+// returns the names of the commands that have a spec (sorted)
+func GetSpecNames() []string {
+	specs.Lock.Lock()
+	defer specs.Lock.Unlock()
+	loadSpecs_nolock()
+	nameMap := make(map[string]bool)
+	for name := range specs.Bundled {
+		nameMap[name] = true
+	}
+	for name := range specs.UserSpecs {
+		nameMap[name] = true
+	}
+	names := make([]string, 0, len(nameMap))
+	for name := range nameMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,193 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package newton
+
+import (
+	"context"
+	"strings"
+	"unicode"
+)
+
+// the spec provider completes the last word of the last statement of the command line: the command name, or
+// the subcommands, options and arg suggestions of the command's spec.  words are split on whitespace (quotes are
+// not parsed).  arg templates (filepaths, folders, ...) are completed by the frontend, they need the remote.
+
+const SpecProviderName = "spec"
+
+var controlOperators = map[string]bool{
+	"||": true, "&&": true, ";;": true, "|&": true, "<(": true, ">>": true, ">&": true,
+	"&": true, ";": true, "(": true, ")": true, "|": true, "<": true, ">": true,
+}
+
+type specProvider struct{}
+
+func init() {
+	RegisterProvider(SpecProviderName, specProvider{})
+}
+
+// returns the words of the last statement of the command line, the last word is the word being completed
+// ("" if the command line ends with whitespace)
+func lastStmtWords(cmdLine string) []string {
+	fields := strings.Fields(cmdLine)
+	startIdx := 0
+	for idx, field := range fields {
+		if controlOperators[field] {
+			startIdx = idx + 1
+		}
+	}
+	words := fields[startIdx:]
+	if cmdLine == "" || unicode.IsSpace(rune(cmdLine[len(cmdLine)-1])) {
+		words = append(words, "")
+	}
+	return words
+}
+
+func findSubcommand(spec *Spec, word string) *Spec {
+	for _, subSpec := range spec.Subcommands {
+		for _, name := range subSpec.Name {
+			if name == word {
+				return subSpec
+			}
+		}
+	}
+	return nil
+}
+
+func findOption(options []*SpecOption, word string) *SpecOption {
+	for _, opt := range options {
+		for _, name := range opt.Name {
+			if name == word {
+				return opt
+			}
+		}
+	}
+	return nil
+}
+
+func addPersistentOptions(persistentOpts []*SpecOption, spec *Spec) []*SpecOption {
+	for _, opt := range spec.Options {
+		if opt.IsPersistent {
+			persistentOpts = append(persistentOpts, opt)
+		}
+	}
+	return persistentOpts
+}
+
+func makeSpecSuggestions(names []string, description string, sugType string, word string) []*SuggestionType {
+	var rtn []*SuggestionType
+	for _, name := range names {
+		if name == "" || name == word || !strings.HasPrefix(name, word) {
+			continue
+		}
+		rtn = append(rtn, &SuggestionType{
+			Name:        name,
+			Description: description,
+			Type:        sugType,
+			Score:       DefaultSuggestionScore,
+			ReplaceLen:  len(word),
+		})
+	}
+	return rtn
+}
+
+func makeArgSuggestions(arg *SpecArg, word string) []*SuggestionType {
+	if arg == nil {
+		return nil
+	}
+	var rtn []*SuggestionType
+	for _, sug := range arg.Suggestions {
+		rtn = append(rtn, makeSpecSuggestions(sug.Name, sug.Description, SuggestionType_Arg, word)...)
+	}
+	return rtn
+}
+
+// returns the arg at argIdx (the last arg if it is variadic), nil if the spec has no more args
+func getSpecArg(args SpecArgList, argIdx int) *SpecArg {
+	if argIdx < len(args) {
+		return args[argIdx]
+	}
+	if len(args) > 0 && args[len(args)-1].IsVariadic {
+		return args[len(args)-1]
+	}
+	return nil
+}
+
+func getSpecSuggestions(cmdLine string) []*SuggestionType {
+	words := lastStmtWords(cmdLine)
+	word := words[len(words)-1]
+	if len(words) == 1 {
+		if word == "" {
+			return nil
+		}
+		var rtn []*SuggestionType
+		for _, name := range GetSpecNames() {
+			if !strings.HasPrefix(name, word) {
+				continue
+			}
+			if spec := GetSpec(name); spec != nil {
+				rtn = append(rtn, makeSpecSuggestions([]string{name}, spec.Description, SuggestionType_Command, word)...)
+			}
+		}
+		return rtn
+	}
+	spec := GetSpec(words[0])
+	if spec == nil {
+		return nil
+	}
+	var persistentOpts []*SpecOption // persistent options of the parent commands
+	var optArg *SpecArg              // the option arg the next word is for
+	var stopOptions bool
+	argIdx := 0
+	for _, prevWord := range words[1 : len(words)-1] {
+		if optArg != nil {
+			optArg = nil
+			continue
+		}
+		if prevWord == "--" {
+			stopOptions = true
+			continue
+		}
+		if !stopOptions && strings.HasPrefix(prevWord, "-") {
+			optName, _, hasValue := strings.Cut(prevWord, "=")
+			opt := findOption(spec.Options, optName)
+			if opt == nil {
+				opt = findOption(persistentOpts, optName)
+			}
+			if opt != nil && len(opt.Args) > 0 && !hasValue {
+				optArg = opt.Args[0]
+			}
+			continue
+		}
+		if argIdx == 0 {
+			if subSpec := findSubcommand(spec, prevWord); subSpec != nil {
+				persistentOpts = addPersistentOptions(persistentOpts, spec)
+				spec = subSpec
+				continue
+			}
+		}
+		argIdx++
+	}
+	if optArg != nil {
+		return makeArgSuggestions(optArg, word)
+	}
+	var rtn []*SuggestionType
+	if !stopOptions && strings.HasPrefix(word, "-") {
+		for _, opts := range [][]*SpecOption{spec.Options, persistentOpts} {
+			for _, opt := range opts {
+				rtn = append(rtn, makeSpecSuggestions(opt.Name, opt.Description, SuggestionType_Option, word)...)
+			}
+		}
+		return rtn
+	}
+	if argIdx == 0 {
+		for _, subSpec := range spec.Subcommands {
+			rtn = append(rtn, makeSpecSuggestions(subSpec.Name, subSpec.Description, SuggestionType_Subcommand, word)...)
+		}
+	}
+	return append(rtn, makeArgSuggestions(getSpecArg(spec.Args, argIdx), word)...)
+}
+
+func (specProvider) GetSuggestions(ctx context.Context, req SuggestionRequest) ([]*SuggestionType, error) {
+	return getSpecSuggestions(req.CmdLine), nil
+}
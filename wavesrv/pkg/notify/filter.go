@@ -0,0 +1,226 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package notify
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// filters are simple boolean expressions over the done command, e.g. "exitcode != 0 or durationms > 60000".
+// "and" binds tighter than "or", there are no parens.
+
+const (
+	FilterField_ExitCode   = "exitcode"
+	FilterField_DurationMs = "durationms"
+	FilterField_Status     = "status"
+	FilterField_CmdStr     = "cmdstr"
+	FilterField_Remote     = "remote"
+)
+
+var filterIntFields = map[string]bool{FilterField_ExitCode: true, FilterField_DurationMs: true}
+var filterStrFields = map[string]bool{FilterField_Status: true, FilterField_CmdStr: true, FilterField_Remote: true}
+var filterOps = []string{"==", "!=", ">=", "<=", ">", "<", "~"}
+
+// values a filter is evaluated against
+type FilterData struct {
+	ExitCode   int64
+	DurationMs int64
+	Status     string
+	CmdStr     string
+	Remote     string
+}
+
+type filterCond struct {
+	Field  string
+	Op     string
+	IntVal int64
+	StrVal string
+}
+
+// disjunction of conjunctions
+type CmdFilter struct {
+	Clauses [][]filterCond
+}
+
+func tokenizeFilter(filterStr string) ([]string, error) {
+	var rtn []string
+	runes := []rune(filterStr)
+	for idx := 0; idx < len(runes); {
+		ch := runes[idx]
+		if unicode.IsSpace(ch) {
+			idx++
+			continue
+		}
+		if ch == '"' || ch == '\'' {
+			endIdx := idx + 1
+			for endIdx < len(runes) && runes[endIdx] != ch {
+				endIdx++
+			}
+			if endIdx >= len(runes) {
+				return nil, fmt.Errorf("unterminated string in filter")
+			}
+			rtn = append(rtn, string(runes[idx:endIdx+1]))
+			idx = endIdx + 1
+			continue
+		}
+		if strings.ContainsRune("=!<>~&|", ch) {
+			endIdx := idx + 1
+			if endIdx < len(runes) && strings.ContainsRune("=&|", runes[endIdx]) {
+				endIdx++
+			}
+			rtn = append(rtn, string(runes[idx:endIdx]))
+			idx = endIdx
+			continue
+		}
+		endIdx := idx
+		for endIdx < len(runes) && !unicode.IsSpace(runes[endIdx]) && !strings.ContainsRune("=!<>~&|\"'", runes[endIdx]) {
+			endIdx++
+		}
+		rtn = append(rtn, string(runes[idx:endIdx]))
+		idx = endIdx
+	}
+	return rtn, nil
+}
+
+func isFilterOp(tok string) bool {
+	for _, op := range filterOps {
+		if tok == op {
+			return true
+		}
+	}
+	return false
+}
+
+func unquoteFilterStr(tok string) string {
+	if len(tok) >= 2 && (tok[0] == '"' || tok[0] == '\'') {
+		return tok[1 : len(tok)-1]
+	}
+	return tok
+}
+
+func ParseFilter(filterStr string) (*CmdFilter, error) {
+	rtn := &CmdFilter{}
+	toks, err := tokenizeFilter(filterStr)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return rtn, nil
+	}
+	var curClause []filterCond
+	for idx := 0; idx < len(toks); {
+		if idx+2 >= len(toks) {
+			return nil, fmt.Errorf("incomplete filter condition near %q", strings.Join(toks[idx:], " "))
+		}
+		field, op, valStr := strings.ToLower(toks[idx]), toks[idx+1], toks[idx+2]
+		if op == "=" {
+			op = "=="
+		}
+		if !isFilterOp(op) {
+			return nil, fmt.Errorf("invalid filter operator %q", op)
+		}
+		cond := filterCond{Field: field, Op: op}
+		if filterIntFields[field] {
+			if op == "~" {
+				return nil, fmt.Errorf("operator '~' is not valid for field %q", field)
+			}
+			cond.IntVal, err = strconv.ParseInt(valStr, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid integer value %q for field %q", valStr, field)
+			}
+		} else if filterStrFields[field] {
+			if op != "==" && op != "!=" && op != "~" {
+				return nil, fmt.Errorf("operator %q is not valid for field %q", op, field)
+			}
+			cond.StrVal = unquoteFilterStr(valStr)
+		} else {
+			return nil, fmt.Errorf("invalid filter field %q", field)
+		}
+		curClause = append(curClause, cond)
+		idx += 3
+		if idx == len(toks) {
+			break
+		}
+		conj := strings.ToLower(toks[idx])
+		if conj == "or" || conj == "||" {
+			rtn.Clauses = append(rtn.Clauses, curClause)
+			curClause = nil
+		} else if conj != "and" && conj != "&&" {
+			return nil, fmt.Errorf("expected 'and' or 'or' in filter, got %q", toks[idx])
+		}
+		idx++
+		if idx == len(toks) {
+			return nil, fmt.Errorf("filter cannot end with %q", conj)
+		}
+	}
+	rtn.Clauses = append(rtn.Clauses, curClause)
+	return rtn, nil
+}
+
+func (cond filterCond) match(data FilterData) bool {
+	if filterIntFields[cond.Field] {
+		var val int64
+		if cond.Field == FilterField_ExitCode {
+			val = data.ExitCode
+		} else {
+			val = data.DurationMs
+		}
+		switch cond.Op {
+		case "==":
+			return val == cond.IntVal
+		case "!=":
+			return val != cond.IntVal
+		case ">":
+			return val > cond.IntVal
+		case ">=":
+			return val >= cond.IntVal
+		case "<":
+			return val < cond.IntVal
+		case "<=":
+			return val <= cond.IntVal
+		}
+		return false
+	}
+	var val string
+	switch cond.Field {
+	case FilterField_Status:
+		val = data.Status
+	case FilterField_CmdStr:
+		val = data.CmdStr
+	case FilterField_Remote:
+		val = data.Remote
+	}
+	switch cond.Op {
+	case "==":
+		return val == cond.StrVal
+	case "!=":
+		return val != cond.StrVal
+	case "~":
+		return strings.Contains(val, cond.StrVal)
+	}
+	return false
+}
+
+// an empty filter matches everything
+func (f *CmdFilter) Match(data FilterData) bool {
+	if f == nil || len(f.Clauses) == 0 {
+		return true
+	}
+	for _, clause := range f.Clauses {
+		clauseMatch := true
+		for _, cond := range clause {
+			if !cond.match(data) {
+				clauseMatch = false
+				break
+			}
+		}
+		if clauseMatch {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package notify
+
+import (
+	"testing"
+)
+
+func testFilter(t *testing.T, filterStr string, data FilterData, expected bool) {
+	filter, err := ParseFilter(filterStr)
+	if err != nil {
+		t.Errorf("filter %q, unexpected error: %v", filterStr, err)
+		return
+	}
+	rtn := filter.Match(data)
+	if rtn != expected {
+		t.Errorf("filter %q, data %+v, rtn=%v, expected=%v", filterStr, data, rtn, expected)
+	}
+}
+
+func testFilterErr(t *testing.T, filterStr string) {
+	_, err := ParseFilter(filterStr)
+	if err == nil {
+		t.Errorf("filter %q, expected error", filterStr)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	okData := FilterData{ExitCode: 0, DurationMs: 100, Status: "done", CmdStr: "make build", Remote: "local"}
+	errData := FilterData{ExitCode: 2, DurationMs: 100, Status: "done", CmdStr: "make test", Remote: "local"}
+	slowData := FilterData{ExitCode: 0, DurationMs: 90000, Status: "done", CmdStr: "sleep 90", Remote: "prod"}
+	testFilter(t, "", okData, true)
+	testFilter(t, "exitcode != 0 or durationms > 60000", okData, false)
+	testFilter(t, "exitcode != 0 or durationms > 60000", errData, true)
+	testFilter(t, "exitcode != 0 or durationms > 60000", slowData, true)
+	testFilter(t, "exitcode!=0", errData, true)
+	testFilter(t, "exitcode == 0 and remote == prod", slowData, true)
+	testFilter(t, "exitcode == 0 && remote == 'prod'", okData, false)
+	testFilter(t, "cmdstr ~ \"make\" and exitcode = 2", errData, true)
+	testFilter(t, "status == done and exitcode > 0 || durationms >= 90000", slowData, true)
+	testFilterErr(t, "exitcode")
+	testFilterErr(t, "exitcode != abc")
+	testFilterErr(t, "foo == 1")
+	testFilterErr(t, "status > done")
+	testFilterErr(t, "exitcode == 1 or")
+	testFilterErr(t, "exitcode == 1 xor exitcode == 2")
+	testFilterErr(t, "cmdstr == \"unterminated")
+}
@@ -0,0 +1,326 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// webhook notifications for completed commands
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+const MaxDeliveryAttempts = 3
+const DeliveryTimeout = 10 * time.Second
+const RetryBaseDelay = 2 * time.Second
+const MaxNotificationLogItems = 1000
+const MaxErrorStrLen = 500
+
+const (
+	DeliveryStatus_Pending = "pending"
+	DeliveryStatus_Sent    = "sent"
+	DeliveryStatus_Failed  = "failed"
+)
+
+type WebhookType struct {
+	WebhookId string `json:"webhookid"`
+	Name      string `json:"name"`
+	Url       string `json:"url"`
+	Template  string `json:"template"`
+	Filter    string `json:"filter"`
+	Enabled   bool   `json:"enabled"`
+	CreatedTs int64  `json:"createdts"`
+}
+
+func (WebhookType) UseDBMap() {}
+
+type NotificationLogType struct {
+	LogId      string `json:"logid"`
+	WebhookId  string `json:"webhookid"`
+	Ts         int64  `json:"ts"`
+	ScreenId   string `json:"screenid"`
+	LineId     string `json:"lineid"`
+	Status     string `json:"status"`
+	Attempts   int    `json:"attempts"`
+	HttpStatus int    `json:"httpstatus"`
+	ErrorStr   string `json:"errorstr"`
+}
+
+func (NotificationLogType) UseDBMap() {}
+
+// the data passed to webhook templates (and marshaled as the default payload)
+type CmdDoneEventType struct {
+	ScreenId   string `json:"screenid"`
+	LineId     string `json:"lineid"`
+	CmdStr     string `json:"cmdstr"`
+	Remote     string `json:"remote"`
+	Status     string `json:"status"`
+	ExitCode   int    `json:"exitcode"`
+	DurationMs int    `json:"durationms"`
+	DoneTs     int64  `json:"donets"`
+}
+
+func init() {
+	sstore.RegisterCmdDoneHook(handleCmdDone)
+}
+
+func InsertWebhook(ctx context.Context, webhook *WebhookType) error {
+	if webhook == nil {
+		return fmt.Errorf("cannot insert nil webhook")
+	}
+	return sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		query := `SELECT webhookid FROM webhook WHERE name = ?`
+		if webhook.Name != "" && tx.Exists(query, webhook.Name) {
+			return fmt.Errorf("webhook %q already exists", webhook.Name)
+		}
+		query = `INSERT INTO webhook ( webhookid, name, url, template, filter, enabled, createdts)
+		                      VALUES (:webhookid,:name,:url,:template,:filter,:enabled,:createdts)`
+		tx.NamedExec(query, dbutil.ToDBMap(webhook, false))
+		return nil
+	})
+}
+
+func GetWebhooks(ctx context.Context) ([]*WebhookType, error) {
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) ([]*WebhookType, error) {
+		query := `SELECT * FROM webhook ORDER BY createdts`
+		return dbutil.SelectMappable[*WebhookType](tx, query), nil
+	})
+}
+
+// arg can be a name, a webhookid, or an 8 character webhookid prefix
+func GetWebhookByArg(ctx context.Context, arg string) (*WebhookType, error) {
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) (*WebhookType, error) {
+		query := `SELECT * FROM webhook WHERE name = ? OR webhookid = ?`
+		webhook := dbutil.GetMappable[*WebhookType](tx, query, arg, arg)
+		if webhook == nil && len(arg) == 8 {
+			query = `SELECT * FROM webhook WHERE substr(webhookid, 1, 8) = ?`
+			webhook = dbutil.GetMappable[*WebhookType](tx, query, arg)
+		}
+		return webhook, nil
+	})
+}
+
+func SetWebhookEnabled(ctx context.Context, webhookId string, enabled bool) error {
+	return sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		query := `UPDATE webhook SET enabled = ? WHERE webhookid = ?`
+		tx.Exec(query, enabled, webhookId)
+		return nil
+	})
+}
+
+func DeleteWebhook(ctx context.Context, webhookId string) error {
+	return sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		query := `DELETE FROM webhook WHERE webhookid = ?`
+		tx.Exec(query, webhookId)
+		return nil
+	})
+}
+
+// returns the most recent log entries (newest first)
+func GetNotificationLog(ctx context.Context, maxItems int) ([]*NotificationLogType, error) {
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) ([]*NotificationLogType, error) {
+		query := `SELECT * FROM notification_log ORDER BY ts DESC LIMIT ?`
+		return dbutil.SelectMappable[*NotificationLogType](tx, query, maxItems), nil
+	})
+}
+
+func insertNotificationLog(ctx context.Context, logItem *NotificationLogType) error {
+	return sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		query := `INSERT INTO notification_log ( logid, webhookid, ts, screenid, lineid, status, attempts, httpstatus, errorstr)
+		                               VALUES (:logid,:webhookid,:ts,:screenid,:lineid,:status,:attempts,:httpstatus,:errorstr)`
+		tx.NamedExec(query, dbutil.ToDBMap(logItem, false))
+		query = `DELETE FROM notification_log WHERE logid NOT IN (SELECT logid FROM notification_log ORDER BY ts DESC LIMIT ?)`
+		tx.Exec(query, MaxNotificationLogItems)
+		return nil
+	})
+}
+
+func updateNotificationLog(ctx context.Context, logItem *NotificationLogType) error {
+	return sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		query := `UPDATE notification_log SET status = ?, attempts = ?, httpstatus = ?, errorstr = ? WHERE logid = ?`
+		tx.Exec(query, logItem.Status, logItem.Attempts, logItem.HttpStatus, logItem.ErrorStr, logItem.LogId)
+		return nil
+	})
+}
+
+func makeCmdDoneEvent(cmd sstore.CmdType) CmdDoneEventType {
+	return CmdDoneEventType{
+		ScreenId:   cmd.ScreenId,
+		LineId:     cmd.LineId,
+		CmdStr:     cmd.CmdStr,
+		Remote:     cmd.Remote.MakeFullRemoteRef(),
+		Status:     cmd.Status,
+		ExitCode:   cmd.ExitCode,
+		DurationMs: cmd.DurationMs,
+		DoneTs:     cmd.DoneTs,
+	}
+}
+
+func (event CmdDoneEventType) filterData() FilterData {
+	return FilterData{
+		ExitCode:   int64(event.ExitCode),
+		DurationMs: int64(event.DurationMs),
+		Status:     event.Status,
+		CmdStr:     event.CmdStr,
+		Remote:     event.Remote,
+	}
+}
+
+// escapes s for use inside a json string (without the quotes)
+func jsonEscapeStr(s string) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	escaped := bytes.TrimSpace(buf.Bytes())
+	return string(escaped[1 : len(escaped)-1])
+}
+
+// an empty template sends the event as json.  the payload is sent as json, so the string fields passed to the
+// template are json escaped (to be used inside quotes, e.g. {"text": "{{.CmdStr}}"}) and the output must be valid json.
+func MakePayload(templateStr string, event CmdDoneEventType) ([]byte, error) {
+	if templateStr == "" {
+		return json.Marshal(event)
+	}
+	tmpl, err := template.New("webhook").Parse(templateStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	tmplEvent := event
+	tmplEvent.ScreenId = jsonEscapeStr(event.ScreenId)
+	tmplEvent.LineId = jsonEscapeStr(event.LineId)
+	tmplEvent.CmdStr = jsonEscapeStr(event.CmdStr)
+	tmplEvent.Remote = jsonEscapeStr(event.Remote)
+	tmplEvent.Status = jsonEscapeStr(event.Status)
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, tmplEvent)
+	if err != nil {
+		return nil, fmt.Errorf("error executing template: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("template output is not valid json")
+	}
+	return buf.Bytes(), nil
+}
+
+// returns (httpstatus, error)
+func postPayload(ctx context.Context, url string, payload []byte) (int, error) {
+	ctx, cancelFn := context.WithTimeout(ctx, DeliveryTimeout)
+	defer cancelFn()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "waveterm/"+scbase.WaveVersion)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func truncateErrorStr(err error) string {
+	if err == nil {
+		return ""
+	}
+	errStr := err.Error()
+	if len(errStr) > MaxErrorStrLen {
+		errStr = errStr[:MaxErrorStrLen]
+	}
+	return errStr
+}
+
+// delivers with retries (exponential backoff), blocks until done
+func DeliverWebhook(webhook *WebhookType, event CmdDoneEventType) *NotificationLogType {
+	logItem := &NotificationLogType{
+		LogId:     scbase.GenWaveUUID(),
+		WebhookId: webhook.WebhookId,
+		Ts:        time.Now().UnixMilli(),
+		ScreenId:  event.ScreenId,
+		LineId:    event.LineId,
+		Status:    DeliveryStatus_Pending,
+	}
+	ctx := context.Background()
+	err := insertNotificationLog(ctx, logItem)
+	if err != nil {
+		log.Printf("[error] inserting notification log: %v\n", err)
+	}
+	payload, err := MakePayload(webhook.Template, event)
+	if err != nil {
+		logItem.Status = DeliveryStatus_Failed
+		logItem.ErrorStr = truncateErrorStr(err)
+		updateNotificationLog(ctx, logItem)
+		return logItem
+	}
+	for attempt := 1; attempt <= MaxDeliveryAttempts; attempt++ {
+		logItem.Attempts = attempt
+		logItem.HttpStatus, err = postPayload(ctx, webhook.Url, payload)
+		if err == nil {
+			logItem.Status = DeliveryStatus_Sent
+			logItem.ErrorStr = ""
+			break
+		}
+		logItem.Status = DeliveryStatus_Failed
+		logItem.ErrorStr = truncateErrorStr(err)
+		if logItem.HttpStatus >= 400 && logItem.HttpStatus < 500 {
+			// client errors will not succeed on retry
+			break
+		}
+		if attempt < MaxDeliveryAttempts {
+			time.Sleep(RetryBaseDelay << (attempt - 1))
+		}
+	}
+	err = updateNotificationLog(ctx, logItem)
+	if err != nil {
+		log.Printf("[error] updating notification log: %v\n", err)
+	}
+	if logItem.Status == DeliveryStatus_Failed {
+		log.Printf("[notify] webhook %q failed after %d attempt(s): %s\n", webhook.Name, logItem.Attempts, logItem.ErrorStr)
+	}
+	return logItem
+}
+
+func handleCmdDone(cmd sstore.CmdType) {
+	if sstore.IsDemoMode() {
+		// webhooks are paused in demo mode
+		return
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	webhooks, err := GetWebhooks(ctx)
+	cancelFn()
+	if err != nil {
+		log.Printf("[error] getting webhooks: %v\n", err)
+		return
+	}
+	event := makeCmdDoneEvent(cmd)
+	for _, webhook := range webhooks {
+		if !webhook.Enabled {
+			continue
+		}
+		filter, err := ParseFilter(webhook.Filter)
+		if err != nil {
+			log.Printf("[error] webhook %q has an invalid filter: %v\n", webhook.Name, err)
+			continue
+		}
+		if !filter.Match(event.filterData()) {
+			continue
+		}
+		go DeliverWebhook(webhook, event)
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package notify
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMakePayload(t *testing.T) {
+	event := CmdDoneEventType{CmdStr: "echo \"a\\b\"\n<tab>\t", Status: "done", ExitCode: 1}
+	payload, err := MakePayload(`{"text": "{{.CmdStr}} ({{.Status}}, exit {{.ExitCode}})"}`, event)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var rtn map[string]string
+	err = json.Unmarshal(payload, &rtn)
+	if err != nil {
+		t.Fatalf("payload %q is not valid json: %v", payload, err)
+	}
+	if rtn["text"] != event.CmdStr+" (done, exit 1)" {
+		t.Errorf("got text %q", rtn["text"])
+	}
+	_, err = MakePayload(`text: {{.CmdStr}}`, event)
+	if err == nil {
+		t.Errorf("expected error for a template that is not json")
+	}
+}
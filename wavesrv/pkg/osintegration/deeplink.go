@@ -0,0 +1,122 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package osintegration
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/cmdrunner"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// deep links (registered by emain as the waveterm:// uri scheme):
+//   waveterm://session/[sessionid]
+//   waveterm://screen/[screenid]?line=[linenum]
+// opening a link switches the main window to the session/screen (and selects the line).
+
+const DeepLinkScheme = "waveterm"
+
+const (
+	DeepLinkType_Session = "session"
+	DeepLinkType_Screen  = "screen"
+)
+
+type DeepLinkType struct {
+	LinkType string
+	Id       string // sessionid or screenid
+	LineNum  int64  // screen links only, 0 for no line
+}
+
+func MakeSessionLink(sessionId string) string {
+	return fmt.Sprintf("%s://%s/%s", DeepLinkScheme, DeepLinkType_Session, sessionId)
+}
+
+func MakeScreenLink(screenId string, lineNum int64) string {
+	rtn := fmt.Sprintf("%s://%s/%s", DeepLinkScheme, DeepLinkType_Screen, screenId)
+	if lineNum > 0 {
+		rtn += fmt.Sprintf("?line=%d", lineNum)
+	}
+	return rtn
+}
+
+func ParseDeepLink(linkStr string) (*DeepLinkType, error) {
+	u, err := url.Parse(linkStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deep link: %w", err)
+	}
+	if u.Scheme != DeepLinkScheme {
+		return nil, fmt.Errorf("invalid deep link, scheme must be %s://", DeepLinkScheme)
+	}
+	if u.Host != DeepLinkType_Session && u.Host != DeepLinkType_Screen {
+		return nil, fmt.Errorf("invalid deep link type %q", u.Host)
+	}
+	rtn := &DeepLinkType{LinkType: u.Host, Id: strings.Trim(u.Path, "/")}
+	if _, err := uuid.Parse(rtn.Id); err != nil {
+		return nil, fmt.Errorf("invalid deep link, malformed %s id", rtn.LinkType)
+	}
+	if lineStr := u.Query().Get("line"); lineStr != "" {
+		if rtn.LinkType != DeepLinkType_Screen {
+			return nil, fmt.Errorf("invalid deep link, line is only allowed for screen links")
+		}
+		rtn.LineNum, err = strconv.ParseInt(lineStr, 10, 64)
+		if err != nil || rtn.LineNum <= 0 {
+			return nil, fmt.Errorf("invalid deep link, bad line number %q", lineStr)
+		}
+	}
+	return rtn, nil
+}
+
+func makeLinkCommand(metaCmd string, metaSubCmd string, sessionId string, args []string, kwargs map[string]string) *scpacket.FeCommandPacketType {
+	pk := scpacket.MakeFeCommandPacket()
+	pk.MetaCmd = metaCmd
+	pk.MetaSubCmd = metaSubCmd
+	pk.Args = args
+	pk.Kwargs = map[string]string{"nohist": "1"}
+	for key, val := range kwargs {
+		pk.Kwargs[key] = val
+	}
+	pk.UIContext = &scpacket.UIContextType{SessionId: sessionId}
+	return pk
+}
+
+// the link is opened with the same commands the ui runs (/session, /screen, /screen:set line=),
+// the updates are sent on the main update bus
+func OpenDeepLink(ctx context.Context, link *DeepLinkType) error {
+	sessionId := link.Id
+	if link.LinkType == DeepLinkType_Screen {
+		screen, err := sstore.GetScreenById(ctx, link.Id)
+		if err != nil {
+			return err
+		}
+		if screen == nil {
+			return fmt.Errorf("screen not found")
+		}
+		sessionId = screen.SessionId
+	}
+	cmds := []*scpacket.FeCommandPacketType{makeLinkCommand("session", "", sessionId, []string{sessionId}, nil)}
+	if link.LinkType == DeepLinkType_Screen {
+		cmds = append(cmds, makeLinkCommand("screen", "", sessionId, []string{link.Id}, nil))
+		if link.LineNum > 0 {
+			kwargs := map[string]string{"screen": link.Id, "line": strconv.FormatInt(link.LineNum, 10)}
+			cmds = append(cmds, makeLinkCommand("screen", "set", sessionId, nil, kwargs))
+		}
+	}
+	for _, pk := range cmds {
+		update, err := cmdrunner.HandleCommand(ctx, pk)
+		if err != nil {
+			return err
+		}
+		if update != nil {
+			scbus.MainUpdateBus.DoUpdate(update)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package osintegration
+
+import (
+	"context"
+	"testing"
+)
+
+const testScreenId = "6a7c8e4f-1b2d-4c3e-9f0a-5b6c7d8e9f01"
+
+func TestDeepLinkRoundTrip(t *testing.T) {
+	link, err := ParseDeepLink(MakeScreenLink(testScreenId, 12))
+	if err != nil {
+		t.Fatalf("error parsing screen link: %v", err)
+	}
+	if link.LinkType != DeepLinkType_Screen || link.Id != testScreenId || link.LineNum != 12 {
+		t.Errorf("invalid screen link: %+v", link)
+	}
+	link, err = ParseDeepLink(MakeSessionLink(testScreenId))
+	if err != nil {
+		t.Fatalf("error parsing session link: %v", err)
+	}
+	if link.LinkType != DeepLinkType_Session || link.Id != testScreenId || link.LineNum != 0 {
+		t.Errorf("invalid session link: %+v", link)
+	}
+}
+
+func TestParseDeepLinkErrors(t *testing.T) {
+	badLinks := []string{
+		"https://screen/" + testScreenId,
+		"waveterm://line/" + testScreenId,
+		"waveterm://screen/not-a-uuid",
+		"waveterm://screen/" + testScreenId + "?line=-1",
+		"waveterm://session/" + testScreenId + "?line=3",
+	}
+	for _, badLink := range badLinks {
+		if _, err := ParseDeepLink(badLink); err == nil {
+			t.Errorf("expected error parsing %q", badLink)
+		}
+	}
+}
+
+func TestQueryProviders(t *testing.T) {
+	var names []string
+	for _, info := range GetProviders() {
+		names = append(names, info.Name)
+	}
+	if len(names) != 3 || names[0] != Provider_History || names[1] != Provider_Screens || names[2] != Provider_Sessions {
+		t.Errorf("invalid providers: %v", names)
+	}
+	if _, err := Query(context.Background(), "bad-provider", "", 0); err == nil {
+		t.Errorf("expected error for an invalid provider")
+	}
+}
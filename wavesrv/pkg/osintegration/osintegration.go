@@ -0,0 +1,83 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package osintegration serves os-level integrations (spotlight/alfred search providers, macos services,
+// windows jump lists).  the os-specific glue lives outside of wavesrv, it queries the registered providers
+// (/api/osintegration/query) and opens the results with waveterm:// deep links (see deeplink.go).
+package osintegration
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const DefaultQueryLimit = 20
+const MaxQueryLimit = 200
+
+type ResultItem struct {
+	Title    string `json:"title"`
+	Subtitle string `json:"subtitle,omitempty"`
+	Url      string `json:"url,omitempty"` // deep link, "" if the item cannot be opened (e.g. the screen was deleted)
+	Ts       int64  `json:"ts,omitempty"`
+}
+
+// searchText is free text (may be empty), limit is between 1 and MaxQueryLimit
+type ProviderFn func(ctx context.Context, searchText string, limit int) ([]*ResultItem, error)
+
+type ProviderInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type providerEntry struct {
+	Info ProviderInfo
+	Fn   ProviderFn
+}
+
+var providerLock = &sync.Mutex{}
+var providerMap = make(map[string]providerEntry)
+
+func RegisterProvider(name string, description string, fn ProviderFn) {
+	providerLock.Lock()
+	defer providerLock.Unlock()
+	providerMap[name] = providerEntry{Info: ProviderInfo{Name: name, Description: description}, Fn: fn}
+}
+
+func GetProviders() []ProviderInfo {
+	providerLock.Lock()
+	defer providerLock.Unlock()
+	var rtn []ProviderInfo
+	for _, entry := range providerMap {
+		rtn = append(rtn, entry.Info)
+	}
+	sort.Slice(rtn, func(i, j int) bool {
+		return rtn[i].Name < rtn[j].Name
+	})
+	return rtn
+}
+
+// limit <= 0 uses DefaultQueryLimit
+func Query(ctx context.Context, providerName string, searchText string, limit int) ([]*ResultItem, error) {
+	providerLock.Lock()
+	entry, found := providerMap[providerName]
+	providerLock.Unlock()
+	if !found {
+		return nil, fmt.Errorf("invalid provider %q", providerName)
+	}
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	if limit > MaxQueryLimit {
+		limit = MaxQueryLimit
+	}
+	rtn, err := entry.Fn(ctx, searchText, limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(rtn) > limit {
+		rtn = rtn[:limit]
+	}
+	return rtn, nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package osintegration
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/history"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+const (
+	Provider_History  = "history"
+	Provider_Sessions = "sessions"
+	Provider_Screens  = "screens"
+)
+
+// the history is searched for more items than requested, repeated commands are only returned once
+const historyQueryFactor = 4
+
+func init() {
+	RegisterProvider(Provider_History, "commands from the history (most recent first, repeated commands returned once)", queryHistory)
+	RegisterProvider(Provider_Sessions, "workspaces (sessions) matching the query, in sidebar order", querySessions)
+	RegisterProvider(Provider_Screens, "tabs (screens) matching the query, in sidebar order", queryScreens)
+}
+
+type screenNameType struct {
+	ScreenId    string
+	ScreenName  string
+	SessionId   string
+	SessionName string
+}
+
+// all (non-archived) screens in sidebar order
+func getScreenNames(ctx context.Context) ([]*screenNameType, error) {
+	var rtn []*screenNameType
+	txErr := sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		query := `SELECT s.screenid, s.name AS screenname, ss.sessionid, ss.name AS sessionname
+		          FROM screen s JOIN session ss ON ss.sessionid = s.sessionid
+		          WHERE NOT s.archived AND NOT ss.archived
+		          ORDER BY ss.sessionidx, s.screenidx`
+		for _, m := range tx.SelectMaps(query) {
+			sname := &screenNameType{}
+			dbutil.QuickSetStr(&sname.ScreenId, m, "screenid")
+			dbutil.QuickSetStr(&sname.ScreenName, m, "screenname")
+			dbutil.QuickSetStr(&sname.SessionId, m, "sessionid")
+			dbutil.QuickSetStr(&sname.SessionName, m, "sessionname")
+			rtn = append(rtn, sname)
+		}
+		return nil
+	})
+	return rtn, txErr
+}
+
+func matchesQuery(searchText string, strs ...string) bool {
+	if searchText == "" {
+		return true
+	}
+	searchText = strings.ToLower(searchText)
+	for _, str := range strs {
+		if strings.Contains(strings.ToLower(str), searchText) {
+			return true
+		}
+	}
+	return false
+}
+
+func queryHistory(ctx context.Context, searchText string, limit int) ([]*ResultItem, error) {
+	opts := history.HistoryQueryOpts{MaxItems: limit * historyQueryFactor, SearchText: searchText, NoMeta: true}
+	hresult, err := history.GetHistoryItems(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	screenNames, err := getScreenNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	screenMap := make(map[string]*screenNameType)
+	for _, sname := range screenNames {
+		screenMap[sname.ScreenId] = sname
+	}
+	var rtn []*ResultItem
+	seen := make(map[string]bool)
+	for _, hitem := range hresult.Items {
+		if seen[hitem.CmdStr] {
+			continue
+		}
+		seen[hitem.CmdStr] = true
+		item := &ResultItem{Title: hitem.CmdStr, Ts: hitem.Ts}
+		tsStr := time.UnixMilli(hitem.Ts).Format("2006-01-02 15:04")
+		if sname := screenMap[hitem.ScreenId]; sname != nil {
+			item.Subtitle = fmt.Sprintf("%s / %s  [%s]  %s", sname.SessionName, sname.ScreenName, hitem.Remote.Name, tsStr)
+			item.Url = MakeScreenLink(hitem.ScreenId, hitem.LineNum)
+		} else {
+			item.Subtitle = fmt.Sprintf("(tab deleted)  [%s]  %s", hitem.Remote.Name, tsStr)
+		}
+		rtn = append(rtn, item)
+		if len(rtn) >= limit {
+			break
+		}
+	}
+	return rtn, nil
+}
+
+func querySessions(ctx context.Context, searchText string, limit int) ([]*ResultItem, error) {
+	var rtn []*ResultItem
+	txErr := sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		query := `SELECT ss.sessionid, ss.name, (SELECT count(*) FROM screen s WHERE s.sessionid = ss.sessionid AND NOT s.archived) AS numscreens
+		          FROM session ss
+		          WHERE NOT ss.archived
+		          ORDER BY ss.sessionidx`
+		for _, m := range tx.SelectMaps(query) {
+			var sessionId, name string
+			var numScreens int64
+			dbutil.QuickSetStr(&sessionId, m, "sessionid")
+			dbutil.QuickSetStr(&name, m, "name")
+			dbutil.QuickSetInt64(&numScreens, m, "numscreens")
+			rtn = append(rtn, &ResultItem{Title: name, Subtitle: fmt.Sprintf("%d tabs", numScreens), Url: MakeSessionLink(sessionId)})
+		}
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	var filtered []*ResultItem
+	for _, item := range rtn {
+		if matchesQuery(searchText, item.Title) {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered, nil
+}
+
+func queryScreens(ctx context.Context, searchText string, limit int) ([]*ResultItem, error) {
+	screenNames, err := getScreenNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var rtn []*ResultItem
+	for _, sname := range screenNames {
+		if !matchesQuery(searchText, sname.ScreenName, sname.SessionName) {
+			continue
+		}
+		rtn = append(rtn, &ResultItem{Title: sname.ScreenName, Subtitle: sname.SessionName, Url: MakeScreenLink(sname.ScreenId, 0)})
+	}
+	return rtn, nil
+}
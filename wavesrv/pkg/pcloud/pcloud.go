@@ -80,7 +80,7 @@ func GetWSEndpoint() string {
 	}
 }
 
-func makeAuthPostReq(ctx context.Context, apiUrl string, authInfo AuthInfo, data interface{}) (*http.Request, error) {
+func makeAuthPostReq(ctx context.Context, upstream *WebShareUpstream, apiUrl string, authInfo AuthInfo, data interface{}) (*http.Request, error) {
 	var dataReader io.Reader
 	if data != nil {
 		byteArr, err := json.Marshal(data)
@@ -89,7 +89,7 @@ func makeAuthPostReq(ctx context.Context, apiUrl string, authInfo AuthInfo, data
 		}
 		dataReader = bytes.NewReader(byteArr)
 	}
-	fullUrl := GetEndpoint() + apiUrl
+	fullUrl := upstream.ApiEndpoint + apiUrl
 	req, err := http.NewRequestWithContext(ctx, "POST", fullUrl, dataReader)
 	if err != nil {
 		return nil, fmt.Errorf("error creating %s request: %v", apiUrl, err)
@@ -97,6 +97,14 @@ func makeAuthPostReq(ctx context.Context, apiUrl string, authInfo AuthInfo, data
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-PromptAPIVersion", strconv.Itoa(APIVersion))
 	req.Header.Set("X-PromptAPIUrl", apiUrl)
+	if upstream.SelfHosted {
+		// the client credentials are only for the hosted service, a self-hosted server only gets its token
+		if upstream.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+upstream.Token)
+		}
+		req.Close = true
+		return req, nil
+	}
 	req.Header.Set("X-PromptUserId", authInfo.UserId)
 	req.Header.Set("X-PromptClientId", authInfo.ClientId)
 	req.Header.Set("X-PromptAuthKey", authInfo.AuthKey)
@@ -418,9 +426,13 @@ func DoSyncWebUpdate(webUpdate *WebShareUpdateType) error {
 	if err != nil {
 		return fmt.Errorf("could not get authinfo for request: %v", err)
 	}
+	upstream, err := GetWebShareUpstream(context.Background())
+	if err != nil {
+		return err
+	}
 	ctx, cancelFn := context.WithTimeout(context.Background(), PCloudDefaultTimeout)
 	defer cancelFn()
-	req, err := makeAuthPostReq(ctx, WebShareUpdateUrl, authInfo, []*WebShareUpdateType{webUpdate})
+	req, err := makeAuthPostReq(ctx, upstream, WebShareUpdateUrl, authInfo, []*WebShareUpdateType{webUpdate})
 	if err != nil {
 		return fmt.Errorf("cannot create auth-post-req for %s: %v", WebShareUpdateUrl, err)
 	}
@@ -447,9 +459,13 @@ func DoWebUpdates(webUpdates []*WebShareUpdateType) error {
 	if err != nil {
 		return fmt.Errorf("could not get authinfo for request: %v", err)
 	}
+	upstream, err := GetWebShareUpstream(context.Background())
+	if err != nil {
+		return err
+	}
 	ctx, cancelFn := context.WithTimeout(context.Background(), PCloudWebShareUpdateTimeout)
 	defer cancelFn()
-	req, err := makeAuthPostReq(ctx, WebShareUpdateUrl, authInfo, webUpdates)
+	req, err := makeAuthPostReq(ctx, upstream, WebShareUpdateUrl, authInfo, webUpdates)
 	if err != nil {
 		return fmt.Errorf("cannot create auth-post-req for %s: %v", WebShareUpdateUrl, err)
 	}
@@ -0,0 +1,71 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pcloud
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// web sharing upstream.  screens are shared through the hosted service unless a self-hosted share server
+// is set (clientopts webshareurl/websharetoken, /client:set).  a share server implements the same api as
+// the hosted service (POST [url]/auth/web-share-update with the screenupdate based updates) and serves the
+// shared screens at [url]/share/[screenid]?viewkey=[viewkey].  the token is sent as a bearer token, the client's
+// hosted service credentials are not sent to a share server.
+
+const WebShareViewEndpoint = "https://share.getprompt.dev"
+const WebShareViewPath = "/share/"
+
+type WebShareUpstream struct {
+	ApiEndpoint  string
+	ViewEndpoint string
+	Token        string
+	SelfHosted   bool
+}
+
+func GetWebShareUpstream(ctx context.Context) (*WebShareUpstream, error) {
+	clientData, err := sstore.EnsureClientData(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot retrieve client data: %v", err)
+	}
+	shareUrl := clientData.ClientOpts.WebShareUrl
+	if shareUrl == "" {
+		return &WebShareUpstream{ApiEndpoint: GetEndpoint(), ViewEndpoint: WebShareViewEndpoint}, nil
+	}
+	return &WebShareUpstream{
+		ApiEndpoint:  shareUrl,
+		ViewEndpoint: shareUrl,
+		Token:        clientData.ClientOpts.WebShareToken,
+		SelfHosted:   true,
+	}, nil
+}
+
+func (u *WebShareUpstream) MakeViewUrl(screenId string, viewKey string) string {
+	return fmt.Sprintf("%s%s%s?viewkey=%s", u.ViewEndpoint, WebShareViewPath, screenId, url.QueryEscape(viewKey))
+}
+
+// returns the normalized url (no trailing slash)
+func ValidateWebShareUrl(shareUrl string) (string, error) {
+	if shareUrl == "" {
+		return "", nil
+	}
+	parsedUrl, err := url.Parse(shareUrl)
+	if err != nil {
+		return "", fmt.Errorf("invalid webshareurl: %v", err)
+	}
+	if parsedUrl.Scheme != "http" && parsedUrl.Scheme != "https" {
+		return "", fmt.Errorf("invalid webshareurl, must be an http or https url")
+	}
+	if parsedUrl.Host == "" {
+		return "", fmt.Errorf("invalid webshareurl, no host")
+	}
+	if parsedUrl.RawQuery != "" || parsedUrl.Fragment != "" {
+		return "", fmt.Errorf("invalid webshareurl, cannot have a query or fragment")
+	}
+	return strings.TrimRight(shareUrl, "/"), nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/base"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// binary output (see sstore/binaryout.go).  the output of a running cmd is checked for binary data until the
+// first binary chunk, from then on the output goes to the raw output file and the terminal gets a hexdump
+// preview (and a summary when the cmd is done).  when the cmd is done the preview (mime type and thumbnail) of
+// the raw output is generated.
+
+// data packets of a cmd are handled in order (see runCmdUpdateFn).  returns the data to write to the
+// terminal (nil if nothing should be written)
+func (rct *RunCmdType) handleBinaryOutput(data []byte) []byte {
+	if !rct.binaryOut.Load() && !sstore.IsBinaryOutput(data) {
+		return data
+	}
+	ctx := context.Background()
+	screenId, lineId := rct.CK.GetGroupId(), rct.CK.GetCmdId()
+	var termData []byte
+	if !rct.binaryOut.Load() {
+		err := sstore.CreateCmdRawOutFile(ctx, screenId, lineId)
+		if err != nil {
+			log.Printf("[error] creating raw output file for %s: %v\n", rct.CK, err)
+			return data
+		}
+		rct.binaryOut.Store(true)
+		go func() {
+			err := updateCmdLineState(context.Background(), rct.CK, func(lineState map[string]any) {
+				lineState[sstore.LineState_BinaryOut] = true
+			})
+			if err != nil {
+				log.Printf("[error] setting binary output linestate for %s: %v\n", rct.CK, err)
+			}
+		}()
+		header := fmt.Sprintf("\r\n\x1b[0m[binary output, showing the first %d bytes]\r\n", sstore.BinaryPreviewSize)
+		termData = append(termData, header...)
+	}
+	totalSize, err := sstore.AppendToCmdRawOut(ctx, screenId, lineId, data)
+	if err != nil {
+		log.Printf("[error] writing raw output for %s: %v\n", rct.CK, err)
+		return termData
+	}
+	rct.binaryOutSize.Store(totalSize)
+	if previewLeft := sstore.BinaryPreviewSize - rct.binaryPreviewSize; previewLeft > 0 {
+		previewData := data[:min(int64(len(data)), previewLeft)]
+		termData = append(termData, sstore.HexDump(previewData, totalSize-int64(len(data)))...)
+		rct.binaryPreviewSize += int64(len(previewData))
+	}
+	return termData
+}
+
+func (wsh *WaveshellProc) writeBinaryOutputSummary(ctx context.Context, rct *RunCmdType) {
+	if !rct.binaryOut.Load() {
+		return
+	}
+	totalSize := rct.binaryOutSize.Load()
+	msg := fmt.Sprintf("[%d bytes of binary output", totalSize)
+	if totalSize > sstore.MaxRawOutSize {
+		msg += fmt.Sprintf(", the first %d bytes were saved", sstore.MaxRawOutSize)
+	}
+	msg += ", use /line:saveraw to save the raw output]\r\n"
+	err := wsh.writeToCmdPtyOut(ctx, rct.CK.GetGroupId(), rct.CK.GetCmdId(), []byte(msg))
+	if err != nil {
+		log.Printf("[error] writing binary output summary for %s: %v\n", rct.CK, err)
+	}
+	go generateBinaryOutputPreview(rct.CK)
+}
+
+// decoding large images takes a while, runs in its own goroutine (after the output is written)
+func generateBinaryOutputPreview(ck base.CommandKey) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelFn()
+	mimeType, hasThumb, err := sstore.GenerateCmdRawOutPreview(ctx, ck.GetGroupId(), ck.GetCmdId())
+	if err != nil {
+		log.Printf("[error] generating raw output preview for %s: %v\n", ck, err)
+		return
+	}
+	err = updateCmdLineState(ctx, ck, func(lineState map[string]any) {
+		lineState[sstore.LineState_BinaryMime] = mimeType
+		if hasThumb {
+			lineState[sstore.LineState_BinaryThumb] = true
+		}
+	})
+	if err != nil {
+		log.Printf("[error] setting binary output preview linestate for %s: %v\n", ck, err)
+	}
+}
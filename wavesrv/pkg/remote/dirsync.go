@@ -0,0 +1,228 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+)
+
+// one-shot (rsync-like) sync of a source directory to a destination directory.  both sides are listed
+// with the listdir rpc, files that are missing on the destination, have a different size, or are newer
+// on the source are copied with CopyBetweenRemotes.  with Checksum, files that have the same size are
+// compared by sha256 instead of mtime.  files that only exist on the destination are never removed.
+
+const (
+	SyncReason_Missing  = "missing"
+	SyncReason_Size     = "size"
+	SyncReason_ModTime  = "mtime"
+	SyncReason_Checksum = "checksum"
+	SyncReason_Invalid  = "invalid" // unsafe path from the source remote, never copied
+)
+
+type SyncProgressFn func(action *SyncAction, actionNum int, numActions int)
+
+type SyncOpts struct {
+	DryRun     bool
+	Exclude    []string // glob patterns, matched against the relative path and the base name
+	Checksum   bool
+	ProgressFn SyncProgressFn // called before every copy
+}
+
+type SyncAction struct {
+	Path   string // relative to the source/destination dir ("/" separated)
+	Reason string
+	Size   int64
+	Error  string
+}
+
+type SyncReport struct {
+	Actions     []*SyncAction
+	NumFiles    int // number of source files compared
+	NumCopied   int
+	NumErrors   int
+	BytesCopied int64
+}
+
+func (r *SyncReport) TotalBytes() int64 {
+	var rtn int64
+	for _, action := range r.Actions {
+		rtn += action.Size
+	}
+	return rtn
+}
+
+// lists dirPath on the remote (entry names are relative to dirPath)
+func (wsh *WaveshellProc) ListDir(ctx context.Context, dirPath string, recursive bool, exclude []string, checksum bool) ([]*packet.FileInfo, error) {
+	listPk := packet.MakeListDirPacket()
+	listPk.ReqId = uuid.New().String()
+	listPk.Path = dirPath
+	listPk.Recursive = recursive
+	listPk.Exclude = exclude
+	listPk.Checksum = checksum
+	iter, err := wsh.PacketRpcIter(ctx, listPk)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	var rtn []*packet.FileInfo
+	for {
+		respIf, err := iter.Next(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error getting listdir response: %v", err)
+		}
+		if respIf == nil {
+			return nil, fmt.Errorf("listdir response ended unexpectedly")
+		}
+		if errResp, ok := respIf.(*packet.ResponsePacketType); ok && !errResp.Success {
+			if strings.Contains(errResp.Error, "invalid rpc type") {
+				return nil, fmt.Errorf("remote %q does not support listing directories (waveshell upgrade required)", wsh.GetRemoteName())
+			}
+			return nil, fmt.Errorf("listdir error: %s", errResp.Error)
+		}
+		resp, ok := respIf.(*packet.ListDirResponseType)
+		if !ok {
+			return nil, fmt.Errorf("invalid listdir response packet: %T", respIf)
+		}
+		if resp.Error != "" {
+			return nil, fmt.Errorf("listdir error: %s", resp.Error)
+		}
+		rtn = append(rtn, resp.Entries...)
+		if resp.Done {
+			return rtn, nil
+		}
+	}
+}
+
+func makeFileInfoMap(entries []*packet.FileInfo) map[string]*packet.FileInfo {
+	rtn := make(map[string]*packet.FileInfo)
+	for _, entry := range entries {
+		rtn[entry.Name] = entry
+	}
+	return rtn
+}
+
+// entry names come from the source waveshell, a name that is absolute or has a ".." component would be
+// copied outside of the destination dir
+func isSafeSyncPath(name string) bool {
+	if name == "" || path.IsAbs(name) {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// returns the files that need to be copied from src to dst (sorted by path).  the entries must have
+// Sha256 set to compare by checksum.  a source file that is a directory on the destination, or that has an
+// unsafe path (see isSafeSyncPath), is an error.
+func CompareDirs(srcEntries []*packet.FileInfo, dstEntries []*packet.FileInfo, checksum bool) []*SyncAction {
+	dstMap := makeFileInfoMap(dstEntries)
+	var rtn []*SyncAction
+	for _, srcEntry := range srcEntries {
+		if srcEntry.IsDir {
+			continue
+		}
+		action := &SyncAction{Path: srcEntry.Name, Size: srcEntry.Size}
+		if !isSafeSyncPath(srcEntry.Name) {
+			action.Reason = SyncReason_Invalid
+			action.Error = "invalid path (absolute or outside the source dir)"
+			rtn = append(rtn, action)
+			continue
+		}
+		dstEntry := dstMap[srcEntry.Name]
+		if dstEntry == nil {
+			action.Reason = SyncReason_Missing
+		} else if dstEntry.IsDir {
+			action.Reason = SyncReason_Missing
+			action.Error = "destination is a directory"
+		} else if srcEntry.Size != dstEntry.Size {
+			action.Reason = SyncReason_Size
+		} else if checksum {
+			if srcEntry.Sha256 != dstEntry.Sha256 {
+				action.Reason = SyncReason_Checksum
+			}
+		} else if srcEntry.ModTs > dstEntry.ModTs {
+			action.Reason = SyncReason_ModTime
+		}
+		if action.Reason != "" {
+			rtn = append(rtn, action)
+		}
+	}
+	sort.Slice(rtn, func(i, j int) bool {
+		return rtn[i].Path < rtn[j].Path
+	})
+	return rtn
+}
+
+// an error is only returned if the directories could not be compared, errors copying individual
+// files are set in the actions (and counted in NumErrors).
+func SyncDirs(ctx context.Context, srcWsh *WaveshellProc, srcDir string, dstWsh *WaveshellProc, dstDir string, opts SyncOpts) (*SyncReport, error) {
+	if !srcWsh.IsConnected() {
+		return nil, fmt.Errorf("source remote %q is not connected", srcWsh.GetRemoteName())
+	}
+	if !dstWsh.IsConnected() {
+		return nil, fmt.Errorf("destination remote %q is not connected", dstWsh.GetRemoteName())
+	}
+	srcEntries, err := srcWsh.ListDir(ctx, srcDir, true, opts.Exclude, opts.Checksum)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list source: %v", err)
+	}
+	dstInfo, err := dstWsh.StatFile(ctx, dstDir, false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot stat destination: %v", err)
+	}
+	var dstEntries []*packet.FileInfo
+	if !dstInfo.NotFound {
+		if !dstInfo.IsDir {
+			return nil, fmt.Errorf("destination %q is not a directory", dstDir)
+		}
+		dstEntries, err = dstWsh.ListDir(ctx, dstDir, true, opts.Exclude, opts.Checksum)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list destination: %v", err)
+		}
+	}
+	report := &SyncReport{Actions: CompareDirs(srcEntries, dstEntries, opts.Checksum)}
+	for _, entry := range srcEntries {
+		if !entry.IsDir {
+			report.NumFiles++
+		}
+	}
+	if opts.DryRun {
+		return report, nil
+	}
+	for idx, action := range report.Actions {
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+		if action.Error != "" {
+			report.NumErrors++
+			continue
+		}
+		if opts.ProgressFn != nil {
+			opts.ProgressFn(action, idx, len(report.Actions))
+		}
+		copyOpts := CopyOpts{MkDirs: true}
+		result, err := CopyBetweenRemotes(ctx, srcWsh, path.Join(srcDir, action.Path), dstWsh, path.Join(dstDir, action.Path), copyOpts)
+		if result != nil {
+			report.BytesCopied += result.BytesCopied
+		}
+		if err != nil {
+			action.Error = err.Error()
+			report.NumErrors++
+			continue
+		}
+		report.NumCopied++
+	}
+	return report, nil
+}
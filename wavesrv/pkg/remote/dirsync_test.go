@@ -0,0 +1,90 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"testing"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+)
+
+func testCompareDirs(t *testing.T, src []*packet.FileInfo, dst []*packet.FileInfo, checksum bool, expected map[string]string) {
+	actions := CompareDirs(src, dst, checksum)
+	if len(actions) != len(expected) {
+		t.Errorf("checksum=%v, got %d actions, expected %d", checksum, len(actions), len(expected))
+	}
+	for idx, action := range actions {
+		if idx > 0 && actions[idx-1].Path >= action.Path {
+			t.Errorf("actions not sorted: %q before %q", actions[idx-1].Path, action.Path)
+		}
+		if expected[action.Path] != action.Reason {
+			t.Errorf("checksum=%v, path %q, reason=%q, expected=%q", checksum, action.Path, action.Reason, expected[action.Path])
+		}
+	}
+}
+
+func TestCompareDirs(t *testing.T) {
+	src := []*packet.FileInfo{
+		{Name: "same", Size: 10, ModTs: 1000, Sha256: "aa"},
+		{Name: "sub", IsDir: true},
+		{Name: "sub/new", Size: 5, ModTs: 1000},
+		{Name: "grown", Size: 20, ModTs: 1000},
+		{Name: "touched", Size: 10, ModTs: 2000, Sha256: "bb"},
+		{Name: "changed", Size: 10, ModTs: 500, Sha256: "cc"},
+		{Name: "isdir", Size: 1, ModTs: 1000},
+	}
+	dst := []*packet.FileInfo{
+		{Name: "same", Size: 10, ModTs: 1000, Sha256: "aa"},
+		{Name: "sub", IsDir: true},
+		{Name: "grown", Size: 10, ModTs: 1000},
+		{Name: "touched", Size: 10, ModTs: 1000, Sha256: "bb"},
+		{Name: "changed", Size: 10, ModTs: 1000, Sha256: "dd"},
+		{Name: "isdir", IsDir: true},
+		{Name: "extra", Size: 10, ModTs: 1000},
+	}
+	testCompareDirs(t, src, dst, false, map[string]string{
+		"sub/new": SyncReason_Missing,
+		"grown":   SyncReason_Size,
+		"touched": SyncReason_ModTime,
+		"isdir":   SyncReason_Missing,
+	})
+	testCompareDirs(t, src, dst, true, map[string]string{
+		"sub/new": SyncReason_Missing,
+		"grown":   SyncReason_Size,
+		"changed": SyncReason_Checksum,
+		"isdir":   SyncReason_Missing,
+	})
+	actions := CompareDirs(src, dst, false)
+	for _, action := range actions {
+		if (action.Path == "isdir") != (action.Error != "") {
+			t.Errorf("path %q, unexpected error %q", action.Path, action.Error)
+		}
+	}
+	testCompareDirs(t, src, nil, false, map[string]string{
+		"same": SyncReason_Missing, "sub/new": SyncReason_Missing, "grown": SyncReason_Missing,
+		"touched": SyncReason_Missing, "changed": SyncReason_Missing, "isdir": SyncReason_Missing,
+	})
+}
+
+func TestCompareDirsUnsafePaths(t *testing.T) {
+	src := []*packet.FileInfo{
+		{Name: "ok/file", Size: 1, ModTs: 1000},
+		{Name: "../../.ssh/authorized_keys", Size: 1, ModTs: 1000},
+		{Name: "sub/../../escape", Size: 1, ModTs: 1000},
+		{Name: "/etc/passwd", Size: 1, ModTs: 1000},
+		{Name: "", Size: 1, ModTs: 1000},
+		{Name: "dots..name", Size: 1, ModTs: 1000},
+	}
+	actions := CompareDirs(src, nil, false)
+	testCompareDirs(t, src, nil, false, map[string]string{
+		"ok/file": SyncReason_Missing, "dots..name": SyncReason_Missing,
+		"../../.ssh/authorized_keys": SyncReason_Invalid, "sub/../../escape": SyncReason_Invalid,
+		"/etc/passwd": SyncReason_Invalid, "": SyncReason_Invalid,
+	})
+	for _, action := range actions {
+		if (action.Reason == SyncReason_Invalid) != (action.Error != "") {
+			t.Errorf("path %q, reason %q, unexpected error %q", action.Path, action.Reason, action.Error)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"log"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/base"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// output encoding detection (see sstore/encoding.go).  the output of a running cmd is checked until the
+// first chunk that decides the encoding (ascii-only chunks are undecided).  a non-utf-8 encoding is stored
+// in the line state, unless the user already set one.
+
+// data packets of a cmd are handled in order (see runCmdUpdateFn)
+func (rct *RunCmdType) checkOutputEncoding(data []byte) {
+	if rct.encodingDetected.Load() {
+		return
+	}
+	encoding := sstore.DetectEncoding(data)
+	if encoding == "" {
+		return
+	}
+	rct.encodingDetected.Store(true)
+	if encoding == sstore.Encoding_Utf8 {
+		return
+	}
+	go func() {
+		err := setDetectedLineEncoding(context.Background(), rct.CK, encoding)
+		if err != nil {
+			log.Printf("[error] setting output encoding for %s: %v\n", rct.CK, err)
+		}
+	}()
+}
+
+func setDetectedLineEncoding(ctx context.Context, ck base.CommandKey, encoding string) error {
+	return updateCmdLineState(ctx, ck, func(lineState map[string]any) {
+		if _, found := lineState[sstore.LineState_Encoding]; !found {
+			lineState[sstore.LineState_Encoding] = encoding
+		}
+	})
+}
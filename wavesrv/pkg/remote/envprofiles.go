@@ -0,0 +1,90 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"strings"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/shellenv"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// env profiles (see sstore/envprofiles.go).  the vars of the profiles attached to the command's screen and
+// remote instance are added to the state sent with the run packet, and removed from the state the command
+// returns (unless the command changed them), so they never become part of the remote instance's state.
+
+var declValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "`", "\\`")
+
+// DeclareDeclType values are quoted for bash (as in "declare -p" output)
+func quoteDeclValue(val string) string {
+	return `"` + declValueEscaper.Replace(val) + `"`
+}
+
+// returns nil if no profiles are attached
+func getEnvProfileVars(ctx context.Context, sessionId string, screenId string, remotePtr sstore.RemotePtrType) (map[string]string, error) {
+	ri, err := sstore.GetRemoteInstance(ctx, sessionId, screenId, remotePtr)
+	if err != nil {
+		return nil, err
+	}
+	var riId string
+	if ri != nil {
+		riId = ri.RIId
+	}
+	profiles, err := sstore.GetRunEnvProfiles(ctx, screenId, riId)
+	if err != nil {
+		return nil, err
+	}
+	if len(profiles) == 0 {
+		return nil, nil
+	}
+	return sstore.ResolveEnvProfileVars(profiles)
+}
+
+// the profile vars added to a command's state, and the declarations they replaced
+type envProfileRunVars struct {
+	Vars      map[string]string
+	OrigDecls map[string]*shellenv.DeclareDeclType
+}
+
+func addEnvProfileVarsToState(state *packet.ShellState, envVars map[string]string) (*packet.ShellState, *envProfileRunVars) {
+	if state == nil || len(envVars) == 0 {
+		return state, nil
+	}
+	rtn := *state
+	envMap := shellenv.DeclMapFromState(&rtn)
+	runVars := &envProfileRunVars{Vars: envVars, OrigDecls: make(map[string]*shellenv.DeclareDeclType)}
+	for name, val := range envVars {
+		if origDecl := envMap[name]; origDecl != nil {
+			runVars.OrigDecls[name] = origDecl
+		}
+		envMap[name] = &shellenv.DeclareDeclType{Name: name, Value: quoteDeclValue(val), Args: "x"}
+	}
+	rtn.ShellVars = shellenv.SerializeDeclMap(envMap)
+	return &rtn, runVars
+}
+
+// the profile vars that still have their profile value are removed (or set back to their original declaration)
+func stripEnvProfileVarsFromState(state *packet.ShellState, runVars *envProfileRunVars) *packet.ShellState {
+	if state == nil || runVars == nil {
+		return state
+	}
+	rtn := *state
+	rtn.HashVal = ""
+	envMap := shellenv.DeclMapFromState(&rtn)
+	for name, val := range runVars.Vars {
+		decl := envMap[name]
+		if decl == nil || !decl.IsExport() || decl.UnescapedValue() != val {
+			continue
+		}
+		if origDecl := runVars.OrigDecls[name]; origDecl != nil {
+			envMap[name] = origDecl
+		} else {
+			delete(envMap, name)
+		}
+	}
+	rtn.ShellVars = shellenv.SerializeDeclMap(envMap)
+	return &rtn
+}
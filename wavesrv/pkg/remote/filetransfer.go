@@ -0,0 +1,368 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/server"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/blockstore"
+)
+
+// copies files between remotes.  waveshells have no connection to each other, so the data is always
+// streamed through wavesrv (streamfile on the source, writefile on the destination).  with Resume,
+// an existing destination file that is smaller than the source is treated as a partial copy and is
+// continued from its current size (the rest of the source is appended).
+//
+// with a StagingId, the source data is also staged in the blockstore (blockid StagingId).  when a copy
+// is resumed, staged data past the destination's size is sent from the staging file instead of being
+// read from the source again.  the caller removes the staging file once the copy is done.
+// with Verify, the sha256 of the source and destination files are compared after the copy.
+
+const StagingFileName = "data"
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+type CopyProgressFn func(bytesDone int64, totalBytes int64)
+
+type CopyOpts struct {
+	Resume     bool
+	Verify     bool
+	StagingId  string
+	MkDirs     bool           // creates the destination's parent directories
+	ProgressFn CopyProgressFn // called after every data packet
+}
+
+type CopyResult struct {
+	TotalBytes  int64  // size of the source file
+	StartOffset int64  // > 0 if the copy was resumed
+	BytesCopied int64  // bytes transferred by this copy (including bytes sent from staging)
+	StagedBytes int64  // bytes sent from the staging file
+	Sha256      string // set if the copy was verified
+}
+
+// returns the file info for path (Info.NotFound is set if the file does not exist).
+// with checksum, Info.Sha256 is set (the remote reads the whole file).
+func (wsh *WaveshellProc) StatFile(ctx context.Context, path string, checksum bool) (*packet.FileInfo, error) {
+	streamPk := packet.MakeStreamFilePacket()
+	streamPk.ReqId = uuid.New().String()
+	streamPk.Path = path
+	streamPk.StatOnly = true
+	streamPk.Checksum = checksum
+	iter, err := wsh.StreamFile(ctx, streamPk)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	resp, err := getStreamFileResponse(ctx, iter)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Info, nil
+}
+
+func getStreamFileResponse(ctx context.Context, iter *packet.RpcResponseIter) (*packet.StreamFileResponseType, error) {
+	respIf, err := iter.Next(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting stream response: %v", err)
+	}
+	resp, ok := respIf.(*packet.StreamFileResponseType)
+	if !ok || resp == nil {
+		return nil, fmt.Errorf("invalid stream response packet: %T", respIf)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("stream error: %s", resp.Error)
+	}
+	if resp.Info == nil {
+		return nil, fmt.Errorf("invalid stream response, no file info")
+	}
+	return resp, nil
+}
+
+func getCopyStartOffset(ctx context.Context, dstWsh *WaveshellProc, dstPath string, srcInfo *packet.FileInfo, opts CopyOpts) (int64, error) {
+	if !opts.Resume {
+		return 0, nil
+	}
+	dstInfo, err := dstWsh.StatFile(ctx, dstPath, false)
+	if err != nil {
+		return 0, fmt.Errorf("cannot stat destination: %v", err)
+	}
+	if dstInfo.NotFound {
+		return 0, nil
+	}
+	if dstInfo.IsDir {
+		return 0, fmt.Errorf("destination %q is a directory", dstPath)
+	}
+	if dstInfo.Size > srcInfo.Size {
+		return 0, fmt.Errorf("cannot resume, destination is larger than the source (%d > %d bytes)", dstInfo.Size, srcInfo.Size)
+	}
+	return dstInfo.Size, nil
+}
+
+// returns the number of bytes staged for the copy, resets the staging file if it cannot be used
+// (staged data must start at 0 and reach the start offset)
+func prepareCopyStaging(ctx context.Context, stagingId string, srcSize int64, startOffset int64) (int64, error) {
+	fileOpts := blockstore.FileOptsType{MaxSize: srcSize, Compress: true}
+	fInfo, err := blockstore.Stat(ctx, stagingId, StagingFileName)
+	if err == nil && fInfo.Opts.MaxSize == srcSize && fInfo.Size >= startOffset {
+		return fInfo.Size, nil
+	}
+	if err == nil {
+		err = blockstore.DeleteBlock(ctx, stagingId)
+		if err != nil {
+			return 0, fmt.Errorf("cannot reset staging file: %v", err)
+		}
+	}
+	if startOffset > 0 {
+		// cannot stage a copy that does not start at 0
+		return -1, nil
+	}
+	err = blockstore.MakeFile(ctx, stagingId, StagingFileName, nil, fileOpts)
+	if err != nil {
+		return 0, fmt.Errorf("cannot create staging file: %v", err)
+	}
+	return 0, nil
+}
+
+type copyWriter struct {
+	Ctx        context.Context
+	DstWsh     *WaveshellProc
+	ReqId      string
+	Result     *CopyResult
+	ProgressFn CopyProgressFn
+	SentEof    bool
+}
+
+func (w *copyWriter) sendData(data []byte, eof bool) error {
+	dataPk := packet.MakeFileDataPacket(w.ReqId)
+	dataPk.Eof = eof
+	dataPk.Data = make([]byte, len(data))
+	copy(dataPk.Data, data)
+	err := w.DstWsh.SendFileData(dataPk)
+	if err != nil {
+		return fmt.Errorf("error sending data to destination: %v", err)
+	}
+	w.SentEof = eof
+	w.Result.BytesCopied += int64(len(data))
+	if w.ProgressFn != nil {
+		w.ProgressFn(w.Result.StartOffset+w.Result.BytesCopied, w.Result.TotalBytes)
+	}
+	return nil
+}
+
+func (w *copyWriter) sendError(errStr string) {
+	errPk := packet.MakeFileDataPacket(w.ReqId)
+	errPk.Error = errStr
+	w.DstWsh.SendFileData(errPk)
+}
+
+// sends the staged data in [w.Result.StartOffset, stagedSize) to the destination
+func (w *copyWriter) sendStagedData(stagingId string, stagedSize int64) error {
+	offset := w.Result.StartOffset
+	for offset < stagedSize {
+		readLen := stagedSize - offset
+		if readLen > server.MaxFileDataPacketSize {
+			readLen = server.MaxFileDataPacketSize
+		}
+		buf := make([]byte, readLen)
+		nr, err := blockstore.ReadAt(w.Ctx, stagingId, StagingFileName, &buf, offset)
+		if err != nil {
+			return fmt.Errorf("error reading staging file: %v", err)
+		}
+		if nr == 0 {
+			return fmt.Errorf("error reading staging file: no data at offset %d", offset)
+		}
+		err = w.sendData(buf[0:nr], false)
+		if err != nil {
+			return err
+		}
+		w.Result.StagedBytes += int64(nr)
+		offset += int64(nr)
+	}
+	return nil
+}
+
+// copies srcPath on srcWsh to dstPath on dstWsh (the wsh can be the same remote).  on error, the
+// returned result (if not nil) has the number of bytes that were written to the destination.
+func CopyBetweenRemotes(ctx context.Context, srcWsh *WaveshellProc, srcPath string, dstWsh *WaveshellProc, dstPath string, opts CopyOpts) (*CopyResult, error) {
+	if !srcWsh.IsConnected() {
+		return nil, fmt.Errorf("source remote %q is not connected", srcWsh.GetRemoteName())
+	}
+	if !dstWsh.IsConnected() {
+		return nil, fmt.Errorf("destination remote %q is not connected", dstWsh.GetRemoteName())
+	}
+	srcInfo, err := srcWsh.StatFile(ctx, srcPath, false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot stat source: %v", err)
+	}
+	if srcInfo.NotFound {
+		return nil, fmt.Errorf("source file %q not found", srcPath)
+	}
+	if srcInfo.IsDir {
+		return nil, fmt.Errorf("source %q is a directory", srcPath)
+	}
+	startOffset, err := getCopyStartOffset(ctx, dstWsh, dstPath, srcInfo, opts)
+	if err != nil {
+		return nil, err
+	}
+	rtn := &CopyResult{TotalBytes: srcInfo.Size, StartOffset: startOffset}
+	stagedSize := int64(-1) // -1 means no staging
+	if opts.StagingId != "" {
+		stagedSize, err = prepareCopyStaging(ctx, opts.StagingId, srcInfo.Size, startOffset)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !opts.Resume || startOffset < srcInfo.Size || startOffset == 0 {
+		err = copyFileData(ctx, srcWsh, srcPath, dstWsh, dstPath, opts, rtn, stagedSize)
+		if err != nil {
+			return rtn, err
+		}
+	}
+	if opts.Verify {
+		err = verifyCopy(ctx, srcWsh, srcPath, dstWsh, dstPath, rtn)
+		if err != nil {
+			return rtn, err
+		}
+	}
+	return rtn, nil
+}
+
+func verifyCopy(ctx context.Context, srcWsh *WaveshellProc, srcPath string, dstWsh *WaveshellProc, dstPath string, result *CopyResult) error {
+	srcInfo, err := srcWsh.StatFile(ctx, srcPath, true)
+	if err != nil {
+		return fmt.Errorf("cannot checksum source: %v", err)
+	}
+	dstInfo, err := dstWsh.StatFile(ctx, dstPath, true)
+	if err != nil {
+		return fmt.Errorf("cannot checksum destination: %v", err)
+	}
+	if srcInfo.Sha256 == "" || dstInfo.Sha256 == "" {
+		return fmt.Errorf("cannot verify copy, remote did not return a checksum (waveshell upgrade required)")
+	}
+	if srcInfo.Sha256 != dstInfo.Sha256 {
+		return fmt.Errorf("%w: source sha256 %s, destination sha256 %s", ErrChecksumMismatch, srcInfo.Sha256, dstInfo.Sha256)
+	}
+	result.Sha256 = srcInfo.Sha256
+	return nil
+}
+
+func copyFileData(ctx context.Context, srcWsh *WaveshellProc, srcPath string, dstWsh *WaveshellProc, dstPath string, opts CopyOpts, rtn *CopyResult, stagedSize int64) error {
+	startOffset := rtn.StartOffset
+	writePk := packet.MakeWriteFilePacket()
+	writePk.ReqId = uuid.New().String()
+	writePk.Path = dstPath
+	writePk.Append = (startOffset > 0)
+	writePk.MkDirs = opts.MkDirs
+	writeIter, err := dstWsh.WriteFile(ctx, writePk)
+	if err != nil {
+		return fmt.Errorf("cannot start write: %v", err)
+	}
+	defer writeIter.Close()
+	readyIf, err := writeIter.Next(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting write ready response: %v", err)
+	}
+	readyPk, ok := readyIf.(*packet.WriteFileReadyPacketType)
+	if !ok {
+		return fmt.Errorf("invalid write ready packet: %T", readyIf)
+	}
+	if readyPk.Error != "" {
+		return fmt.Errorf("write error: %s", readyPk.Error)
+	}
+	writer := &copyWriter{Ctx: ctx, DstWsh: dstWsh, ReqId: writePk.ReqId, Result: rtn, ProgressFn: opts.ProgressFn}
+	if readyPk.Offset != startOffset {
+		// destination changed since it was checked (or the waveshell does not support append)
+		writer.sendError("resume offset mismatch")
+		return fmt.Errorf("cannot resume, destination size changed (expected %d bytes, got %d)", startOffset, readyPk.Offset)
+	}
+	srcOffset := startOffset
+	if stagedSize > startOffset {
+		err = writer.sendStagedData(opts.StagingId, stagedSize)
+		if err != nil {
+			writer.sendError("staging error")
+			return err
+		}
+		srcOffset = stagedSize
+	}
+	if srcOffset < rtn.TotalBytes {
+		err = streamSourceData(ctx, srcWsh, srcPath, srcOffset, writer, opts.StagingId, stagedSize >= 0)
+		if err != nil {
+			return err
+		}
+	}
+	if !writer.SentEof {
+		err = writer.sendData(nil, true)
+		if err != nil {
+			return err
+		}
+	}
+	doneIf, err := writeIter.Next(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting write done response: %v", err)
+	}
+	donePk, ok := doneIf.(*packet.WriteFileDonePacketType)
+	if !ok {
+		return fmt.Errorf("invalid write done packet: %T", doneIf)
+	}
+	if donePk.Error != "" {
+		return fmt.Errorf("write error: %s", donePk.Error)
+	}
+	return nil
+}
+
+func streamSourceData(ctx context.Context, srcWsh *WaveshellProc, srcPath string, srcOffset int64, writer *copyWriter, stagingId string, useStaging bool) error {
+	streamPk := packet.MakeStreamFilePacket()
+	streamPk.ReqId = uuid.New().String()
+	streamPk.Path = srcPath
+	if srcOffset > 0 {
+		streamPk.ByteRange = []int64{srcOffset}
+	}
+	streamIter, err := srcWsh.StreamFile(ctx, streamPk)
+	if err != nil {
+		writer.sendError("source stream error")
+		return fmt.Errorf("cannot start source stream: %v", err)
+	}
+	defer streamIter.Close()
+	_, err = getStreamFileResponse(ctx, streamIter)
+	if err != nil {
+		writer.sendError("source stream error")
+		return err
+	}
+	for !writer.SentEof {
+		dataPkIf, err := streamIter.Next(ctx)
+		if err != nil {
+			writer.sendError("source stream error")
+			return fmt.Errorf("error reading source: %v", err)
+		}
+		if dataPkIf == nil {
+			break
+		}
+		dataPk, ok := dataPkIf.(*packet.FileDataPacketType)
+		if !ok {
+			writer.sendError("source stream error")
+			return fmt.Errorf("invalid source data packet type: %T", dataPkIf)
+		}
+		if dataPk.Error != "" {
+			writer.sendError(dataPk.Error)
+			return fmt.Errorf("source data error: %s", dataPk.Error)
+		}
+		if useStaging && len(dataPk.Data) > 0 {
+			_, err = blockstore.AppendData(ctx, stagingId, StagingFileName, dataPk.Data)
+			if err != nil {
+				writer.sendError("staging error")
+				return fmt.Errorf("error staging data: %v", err)
+			}
+		}
+		err = writer.sendData(dataPk.Data, dataPk.Eof)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
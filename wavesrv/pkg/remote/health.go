@@ -0,0 +1,247 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/shexec"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// connection health.  while a remote is connected it is pinged (keepalive rpc) every KeepAliveInterval, the
+// latency is recorded in sstore.RemoteHealthType.  after KeepAliveMaxFailures consecutive failed pings the
+// connection is considered dead and closed.  a connection that ends without the user disconnecting it is a
+// drop, dropped remotes (that are not connectmode=manual) are reconnected with an exponential backoff.
+// every change is sent as a "remotehealth" update.
+
+const KeepAliveInterval = 30 * time.Second
+const KeepAliveTimeout = 15 * time.Second
+const KeepAliveMaxFailures = 3
+
+const ReconnectInitialBackoff = 5 * time.Second
+const ReconnectMaxBackoff = 5 * time.Minute
+const MaxReconnectAttempts = 10
+
+// attempt is 1-indexed
+func reconnectBackoff(attempt int) time.Duration {
+	backoff := ReconnectInitialBackoff
+	for i := 1; i < attempt; i++ {
+		backoff *= 2
+		if backoff >= ReconnectMaxBackoff {
+			return ReconnectMaxBackoff
+		}
+	}
+	return backoff
+}
+
+// returns the round-trip time
+func (wsh *WaveshellProc) KeepAlive(ctx context.Context) (time.Duration, error) {
+	keepAlivePk := packet.MakeKeepAlivePacket()
+	keepAlivePk.ReqId = uuid.New().String()
+	startTime := time.Now()
+	respIf, err := wsh.PacketRpcRaw(ctx, keepAlivePk)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid rpc type") {
+			// older waveshell without keepalive, the error response is still a round trip
+			return time.Since(startTime), nil
+		}
+		return 0, err
+	}
+	rtt := time.Since(startTime)
+	if errResp, ok := respIf.(*packet.ResponsePacketType); ok && !errResp.Success && !strings.Contains(errResp.Error, "invalid rpc type") {
+		return 0, fmt.Errorf("keepalive error: %s", errResp.Error)
+	}
+	return rtt, nil
+}
+
+// health nil reads the current stats from the db
+func (wsh *WaveshellProc) notifyHealthUpdate(health *sstore.RemoteHealthType) {
+	if health == nil {
+		health, _ = sstore.GetRemoteHealth(context.Background(), wsh.RemoteId)
+	}
+	if health == nil {
+		health = &sstore.RemoteHealthType{RemoteId: wsh.RemoteId}
+	}
+	hcopy := *health
+	wsh.WithLock(func() {
+		hcopy.ReconnectTs = wsh.reconnectTs
+		hcopy.ReconnectAttempt = wsh.reconnectAttempt
+	})
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(hcopy)
+	scbus.MainUpdateBus.DoUpdate(update)
+}
+
+func setReconnectFields(health *sstore.RemoteHealthType) {
+	wsh := GetRemoteById(health.RemoteId)
+	if wsh == nil {
+		return
+	}
+	wsh.WithLock(func() {
+		health.ReconnectTs = wsh.reconnectTs
+		health.ReconnectAttempt = wsh.reconnectAttempt
+	})
+}
+
+// never returns nil (empty stats if the remote has never been pinged)
+func GetRemoteHealth(ctx context.Context, remoteId string) (*sstore.RemoteHealthType, error) {
+	health, err := sstore.GetRemoteHealth(ctx, remoteId)
+	if err != nil {
+		return nil, err
+	}
+	if health == nil {
+		health = &sstore.RemoteHealthType{RemoteId: remoteId}
+	}
+	setReconnectFields(health)
+	return health, nil
+}
+
+// the health of all remotes that have health stats (with the runtime reconnect state)
+func GetAllRemoteHealth(ctx context.Context) ([]*sstore.RemoteHealthType, error) {
+	healthArr, err := sstore.GetAllRemoteHealth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, health := range healthArr {
+		setReconnectFields(health)
+	}
+	return healthArr, nil
+}
+
+// runs until cproc is no longer the remote's connected server process
+func (wsh *WaveshellProc) keepAliveLoop(cproc *shexec.ClientProc) {
+	isCurrent := func() bool {
+		var rtn bool
+		wsh.WithLock(func() {
+			rtn = (wsh.ServerProc == cproc && wsh.Status == StatusConnected)
+		})
+		return rtn
+	}
+	numFailures := 0
+	time.Sleep(KeepAliveInterval)
+	for isCurrent() {
+		ctx, cancelFn := context.WithTimeout(context.Background(), KeepAliveTimeout)
+		latency, pingErr := wsh.KeepAlive(ctx)
+		cancelFn()
+		if !isCurrent() {
+			// disconnected while waiting for the response, not a failed ping
+			return
+		}
+		health, err := sstore.RecordRemotePing(context.Background(), wsh.RemoteId, pingErr == nil, latency.Milliseconds())
+		if err != nil {
+			log.Printf("[%s] error recording keepalive: %v\n", wsh.GetRemoteName(), err)
+		} else {
+			wsh.notifyHealthUpdate(health)
+		}
+		if pingErr == nil {
+			numFailures = 0
+		} else {
+			numFailures++
+			log.Printf("[%s] keepalive failed (%d/%d): %v\n", wsh.GetRemoteName(), numFailures, KeepAliveMaxFailures, pingErr)
+			if numFailures >= KeepAliveMaxFailures {
+				wsh.WriteToPtyBuffer("*no response to %d keepalives, closing connection\n", numFailures)
+				wsh.WithLock(func() {
+					if wsh.ServerProc == cproc {
+						cproc.Close()
+					}
+				})
+				return
+			}
+		}
+		time.Sleep(KeepAliveInterval)
+	}
+}
+
+func (wsh *WaveshellProc) shouldAutoReconnect() bool {
+	if scbase.IsReadOnly() || GetRemoteById(wsh.RemoteId) != wsh {
+		return false
+	}
+	rcopy := wsh.GetRemoteCopy()
+	return !rcopy.Archived && !rcopy.IsSerial() && rcopy.ConnectMode != sstore.ConnectModeManual
+}
+
+// called when the connection ends without the user disconnecting the remote
+func (wsh *WaveshellProc) handleConnectionDrop() {
+	wsh.WriteToPtyBuffer("*connection dropped\n")
+	health, err := sstore.RecordRemoteDrop(context.Background(), wsh.RemoteId)
+	if err != nil {
+		log.Printf("[%s] error recording connection drop: %v\n", wsh.GetRemoteName(), err)
+	}
+	if wsh.shouldAutoReconnect() {
+		var gen int
+		wsh.WithLock(func() {
+			wsh.reconnectGen++
+			gen = wsh.reconnectGen
+		})
+		go wsh.reconnectLoop(gen)
+	}
+	wsh.notifyHealthUpdate(health)
+}
+
+// returns true if a scheduled reconnect was canceled
+func (wsh *WaveshellProc) cancelReconnect() bool {
+	var canceled bool
+	wsh.WithLock(func() {
+		canceled = (wsh.reconnectTs != 0)
+		wsh.reconnectGen++
+		wsh.reconnectTs = 0
+		wsh.reconnectAttempt = 0
+	})
+	return canceled
+}
+
+// returns false if the loop was canceled (or replaced by a newer loop)
+func (wsh *WaveshellProc) setReconnectState(gen int, reconnectTs int64, attempt int) bool {
+	var current bool
+	wsh.WithLock(func() {
+		current = (wsh.reconnectGen == gen)
+		if current {
+			wsh.reconnectTs = reconnectTs
+			wsh.reconnectAttempt = attempt
+		}
+	})
+	return current
+}
+
+func (wsh *WaveshellProc) reconnectLoop(gen int) {
+	defer func() {
+		if wsh.setReconnectState(gen, 0, 0) {
+			wsh.notifyHealthUpdate(nil)
+		}
+	}()
+	for attempt := 1; attempt <= MaxReconnectAttempts; attempt++ {
+		backoff := reconnectBackoff(attempt)
+		if !wsh.setReconnectState(gen, time.Now().Add(backoff).UnixMilli(), attempt) {
+			return
+		}
+		wsh.notifyHealthUpdate(nil)
+		time.Sleep(backoff)
+		if !wsh.setReconnectState(gen, 0, attempt) || !wsh.shouldAutoReconnect() {
+			return
+		}
+		if wsh.GetStatus() != StatusDisconnected && wsh.GetStatus() != StatusError {
+			// connected (or connecting) by the user in the meantime
+			return
+		}
+		wsh.WriteToPtyBuffer("*reconnecting (attempt %d/%d)\n", attempt, MaxReconnectAttempts)
+		wsh.Launch(false)
+		if wsh.IsConnected() {
+			_, err := sstore.RecordRemoteReconnect(context.Background(), wsh.RemoteId)
+			if err != nil {
+				log.Printf("[%s] error recording reconnect: %v\n", wsh.GetRemoteName(), err)
+			}
+			return
+		}
+	}
+	wsh.WriteToPtyBuffer("*could not reconnect after %d attempts, use /remote:connect to connect\n", MaxReconnectAttempts)
+}
@@ -0,0 +1,45 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReconnectBackoff(t *testing.T) {
+	expected := []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 40 * time.Second, 80 * time.Second, 160 * time.Second}
+	for idx, backoff := range expected {
+		if rtn := reconnectBackoff(idx + 1); rtn != backoff {
+			t.Errorf("attempt %d: expected %v, got %v", idx+1, backoff, rtn)
+		}
+	}
+	if rtn := reconnectBackoff(7); rtn != ReconnectMaxBackoff {
+		t.Errorf("expected max backoff, got %v", rtn)
+	}
+	if rtn := reconnectBackoff(MaxReconnectAttempts); rtn != ReconnectMaxBackoff {
+		t.Errorf("expected max backoff, got %v", rtn)
+	}
+}
+
+func TestCancelReconnect(t *testing.T) {
+	wsh := &WaveshellProc{Lock: &sync.Mutex{}}
+	if wsh.cancelReconnect() {
+		t.Errorf("no reconnect was scheduled")
+	}
+	gen := wsh.reconnectGen
+	if !wsh.setReconnectState(gen, time.Now().UnixMilli(), 1) {
+		t.Fatalf("loop should be current")
+	}
+	if !wsh.cancelReconnect() {
+		t.Errorf("scheduled reconnect should be canceled")
+	}
+	if wsh.setReconnectState(gen, time.Now().UnixMilli(), 2) {
+		t.Errorf("canceled loop should not be current")
+	}
+	if wsh.reconnectTs != 0 || wsh.reconnectAttempt != 0 {
+		t.Errorf("reconnect state should be cleared, got %d/%d", wsh.reconnectTs, wsh.reconnectAttempt)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/shexec"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// while a remote is connected, its disk free space, load average, and uptime are polled (hoststats rpc)
+// and stored in the remote's statevars.  they are surfaced as RemoteRuntimeState.HostHealth.
+
+const InitialHostStatsWait = 5 * time.Second
+const HostStatsInterval = 5 * time.Minute
+const HostStatsTimeout = 10 * time.Second
+
+const (
+	StateVar_DiskFree    = "diskfree"
+	StateVar_DiskTotal   = "disktotal"
+	StateVar_LoadAvg     = "loadavg"
+	StateVar_Uptime      = "uptime"
+	StateVar_HostStatsTs = "hoststatsts"
+)
+
+func (wsh *WaveshellProc) GetHostStats(ctx context.Context) (*packet.HostStatsResponseType, error) {
+	statsPk := packet.MakeHostStatsPacket()
+	statsPk.ReqId = uuid.New().String()
+	respIf, err := wsh.PacketRpcRaw(ctx, statsPk)
+	if err != nil {
+		return nil, err
+	}
+	if errResp, ok := respIf.(*packet.ResponsePacketType); ok && !errResp.Success {
+		return nil, fmt.Errorf("hoststats error: %s", errResp.Error)
+	}
+	resp, ok := respIf.(*packet.HostStatsResponseType)
+	if !ok {
+		return nil, fmt.Errorf("invalid hoststats response packet: %T", respIf)
+	}
+	return resp, nil
+}
+
+func formatLoadAvg(loadAvg []float64) string {
+	var parts []string
+	for _, val := range loadAvg {
+		parts = append(parts, strconv.FormatFloat(val, 'f', 2, 64))
+	}
+	return strings.Join(parts, " ")
+}
+
+// returns nil if the statevars have no host stats
+func getHostHealthFromStateVars(vars map[string]string) *sstore.RemoteHostHealthType {
+	if vars[StateVar_HostStatsTs] == "" {
+		return nil
+	}
+	rtn := &sstore.RemoteHostHealthType{}
+	rtn.UpdateTs, _ = strconv.ParseInt(vars[StateVar_HostStatsTs], 10, 64)
+	rtn.DiskFree, _ = strconv.ParseInt(vars[StateVar_DiskFree], 10, 64)
+	rtn.DiskTotal, _ = strconv.ParseInt(vars[StateVar_DiskTotal], 10, 64)
+	rtn.Uptime, _ = strconv.ParseInt(vars[StateVar_Uptime], 10, 64)
+	for _, field := range strings.Fields(vars[StateVar_LoadAvg]) {
+		val, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			break
+		}
+		rtn.LoadAvg = append(rtn.LoadAvg, val)
+	}
+	return rtn
+}
+
+func (wsh *WaveshellProc) updateHostStats() error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), HostStatsTimeout)
+	defer cancelFn()
+	stats, err := wsh.GetHostStats(ctx)
+	if err != nil {
+		return err
+	}
+	var stateVars map[string]string
+	wsh.WithLock(func() {
+		stateVars = make(map[string]string)
+		for key, val := range wsh.Remote.StateVars {
+			stateVars[key] = val
+		}
+		stateVars[StateVar_DiskFree] = strconv.FormatInt(stats.DiskFree, 10)
+		stateVars[StateVar_DiskTotal] = strconv.FormatInt(stats.DiskTotal, 10)
+		stateVars[StateVar_LoadAvg] = formatLoadAvg(stats.LoadAvg)
+		stateVars[StateVar_Uptime] = strconv.FormatInt(stats.Uptime, 10)
+		stateVars[StateVar_HostStatsTs] = strconv.FormatInt(time.Now().UnixMilli(), 10)
+		wsh.Remote.StateVars = stateVars
+	})
+	err = sstore.UpdateRemoteStateVars(ctx, wsh.RemoteId, stateVars)
+	if err != nil {
+		log.Printf("error updating remote statevars: %v\n", err)
+	}
+	go wsh.NotifyRemoteUpdate()
+	return nil
+}
+
+// runs until cproc is no longer the remote's connected server process
+func (wsh *WaveshellProc) hostStatsLoop(cproc *shexec.ClientProc) {
+	isCurrent := func() bool {
+		var rtn bool
+		wsh.WithLock(func() {
+			rtn = (wsh.ServerProc == cproc && wsh.Status == StatusConnected)
+		})
+		return rtn
+	}
+	time.Sleep(InitialHostStatsWait)
+	for isCurrent() {
+		err := wsh.updateHostStats()
+		if err != nil {
+			if strings.Contains(err.Error(), "invalid rpc type") {
+				// waveshell does not support hoststats
+				return
+			}
+			log.Printf("[%s] error getting host stats: %v\n", wsh.GetRemoteName(), err)
+		}
+		time.Sleep(HostStatsInterval)
+	}
+}
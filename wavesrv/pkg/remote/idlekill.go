@@ -0,0 +1,211 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/base"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// idle-kill policy (screenopts/remoteopts idlekillhours).  running commands that have produced no output and
+// received no input for the limit are killed, the smaller of the screen and remote limits applies.
+// the policy escalates in stages:
+//   - warn: before the limit, the line gets LineState_IdleKillTs (cleared again if the cmd becomes active).
+//     cmds are always warned for at least the warn time, even if they are already past the limit.
+//   - term: at the kill ts, the cmd is sent SIGTERM
+//   - kill: if it is still running after IdleKillTermGrace, the cmd is sent SIGKILL
+
+const MaxIdleKillHours = 24 * 30
+const IdleKillWarnTime = 30 * time.Minute
+const IdleKillTermGrace = 1 * time.Minute
+
+const (
+	IdleKillStage_None = iota
+	IdleKillStage_Warn
+	IdleKillStage_Term
+	IdleKillStage_Kill
+)
+
+type idleKillStateType struct {
+	Stage  int
+	KillTs time.Time
+}
+
+var idleKillLock = &sync.Mutex{}
+var idleKillStates = make(map[base.CommandKey]*idleKillStateType) // only cmds that have been warned
+
+// returns the effective limit (the smaller of the non-zero limits), 0 means no limit
+func GetIdleKillLimit(screenHours int, remoteHours int) time.Duration {
+	hours := screenHours
+	if hours <= 0 || (remoteHours > 0 && remoteHours < hours) {
+		hours = remoteHours
+	}
+	if hours <= 0 {
+		return 0
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// the warning is shown for IdleKillWarnTime (or half the limit if that is shorter) before the cmd is killed
+func getIdleKillWarnTime(limit time.Duration) time.Duration {
+	if limit/2 < IdleKillWarnTime {
+		return limit / 2
+	}
+	return IdleKillWarnTime
+}
+
+// returns the new state, nil if the cmd is not (or no longer) idle
+func getNextIdleKillState(now time.Time, lastActivity time.Time, limit time.Duration, state *idleKillStateType) *idleKillStateType {
+	if limit <= 0 {
+		return nil
+	}
+	warnTime := getIdleKillWarnTime(limit)
+	if now.Sub(lastActivity) < limit-warnTime {
+		return nil
+	}
+	if state == nil {
+		// always warn first, even if the cmd is already past the limit (e.g. the policy was just set)
+		killTs := lastActivity.Add(limit)
+		if minKillTs := now.Add(warnTime); killTs.Before(minKillTs) {
+			killTs = minKillTs
+		}
+		return &idleKillStateType{Stage: IdleKillStage_Warn, KillTs: killTs}
+	}
+	rtn := *state
+	if !now.Before(state.KillTs.Add(IdleKillTermGrace)) {
+		rtn.Stage = IdleKillStage_Kill
+	} else if !now.Before(state.KillTs) {
+		rtn.Stage = IdleKillStage_Term
+	}
+	return &rtn
+}
+
+func (wsh *WaveshellProc) getRunningCmdsCopy() []*RunCmdType {
+	wsh.Lock.Lock()
+	defer wsh.Lock.Unlock()
+	var rtn []*RunCmdType
+	for _, rct := range wsh.RunningCmds {
+		if rct.EphemeralOpts == nil {
+			rtn = append(rtn, rct)
+		}
+	}
+	return rtn
+}
+
+func runIdleKillStage(ctx context.Context, ck base.CommandKey, oldStage int, newStage int, killTs int64) {
+	if newStage == IdleKillStage_None {
+		err := updateCmdLineState(ctx, ck, func(lineState map[string]any) {
+			delete(lineState, sstore.LineState_IdleKillTs)
+		})
+		if err != nil {
+			log.Printf("[error] clearing idle-kill warning for %s: %v\n", ck, err)
+		}
+		return
+	}
+	if oldStage == IdleKillStage_None {
+		err := updateCmdLineState(ctx, ck, func(lineState map[string]any) {
+			lineState[sstore.LineState_IdleKillTs] = killTs
+		})
+		if err != nil {
+			log.Printf("[error] setting idle-kill warning for %s: %v\n", ck, err)
+		}
+	}
+	sig := ""
+	if newStage == IdleKillStage_Term {
+		sig = "SIGTERM"
+	} else if newStage == IdleKillStage_Kill {
+		sig = "SIGKILL"
+	}
+	if sig == "" {
+		return
+	}
+	log.Printf("idle-kill policy, sending %s to cmd %s\n", sig, ck)
+	cmd, err := sstore.GetCmdByScreenId(ctx, ck.GetGroupId(), ck.GetCmdId())
+	if err != nil || cmd == nil {
+		return
+	}
+	err = SendSignalToCmd(ctx, cmd, sig)
+	if err != nil {
+		log.Printf("[error] sending %s to idle cmd %s: %v\n", sig, ck, err)
+		return
+	}
+	err = updateCmdLineState(ctx, ck, func(lineState map[string]any) {
+		lineState[sstore.LineState_IdleKilled] = true
+	})
+	if err != nil {
+		log.Printf("[error] marking idle cmd %s as killed: %v\n", ck, err)
+	}
+}
+
+// checks all running cmds against their idle-kill policy, and escalates the ones that are idle
+func RunIdleKillCheck(ctx context.Context) {
+	now := time.Now()
+	screenCache := make(map[string]*sstore.ScreenType)
+	runningCks := make(map[base.CommandKey]bool)
+	for _, wsh := range GetRemoteMap() {
+		remoteCopy := wsh.GetRemoteCopy()
+		var remoteHours int
+		if remoteCopy.RemoteOpts != nil {
+			remoteHours = remoteCopy.RemoteOpts.IdleKillHours
+		}
+		for _, rct := range wsh.getRunningCmdsCopy() {
+			runningCks[rct.CK] = true
+			screen, found := screenCache[rct.ScreenId]
+			if !found {
+				screen, _ = sstore.GetScreenById(ctx, rct.ScreenId)
+				screenCache[rct.ScreenId] = screen
+			}
+			var screenHours int
+			if screen != nil {
+				screenHours = screen.ScreenOpts.IdleKillHours
+			}
+			limit := GetIdleKillLimit(screenHours, remoteHours)
+			lastActivity := time.UnixMilli(rct.LastActivityTs.Load())
+			idleKillLock.Lock()
+			oldState := idleKillStates[rct.CK]
+			newState := getNextIdleKillState(now, lastActivity, limit, oldState)
+			if newState == nil {
+				delete(idleKillStates, rct.CK)
+			} else {
+				idleKillStates[rct.CK] = newState
+			}
+			idleKillLock.Unlock()
+			oldStage, newStage := IdleKillStage_None, IdleKillStage_None
+			var killTs int64
+			if oldState != nil {
+				oldStage = oldState.Stage
+			}
+			if newState != nil {
+				newStage = newState.Stage
+				killTs = newState.KillTs.UnixMilli()
+			}
+			if newStage != oldStage {
+				runIdleKillStage(ctx, rct.CK, oldStage, newStage, killTs)
+			}
+		}
+	}
+	// cmds that finished after being warned
+	idleKillLock.Lock()
+	var doneCks []base.CommandKey
+	for ck := range idleKillStates {
+		if !runningCks[ck] {
+			doneCks = append(doneCks, ck)
+			delete(idleKillStates, ck)
+		}
+	}
+	idleKillLock.Unlock()
+	for _, ck := range doneCks {
+		err := updateCmdLineState(ctx, ck, func(lineState map[string]any) {
+			delete(lineState, sstore.LineState_IdleKillTs)
+		})
+		if err != nil {
+			log.Printf("[error] clearing idle-kill warning for %s: %v\n", ck, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetIdleKillLimit(t *testing.T) {
+	if limit := GetIdleKillLimit(0, 0); limit != 0 {
+		t.Errorf("expected no limit, got %v", limit)
+	}
+	if limit := GetIdleKillLimit(4, 0); limit != 4*time.Hour {
+		t.Errorf("expected screen limit, got %v", limit)
+	}
+	if limit := GetIdleKillLimit(0, 8); limit != 8*time.Hour {
+		t.Errorf("expected remote limit, got %v", limit)
+	}
+	if limit := GetIdleKillLimit(4, 2); limit != 2*time.Hour {
+		t.Errorf("expected smaller limit, got %v", limit)
+	}
+}
+
+func TestIdleKillEscalation(t *testing.T) {
+	limit := 2 * time.Hour
+	start := time.Now()
+	if state := getNextIdleKillState(start.Add(time.Hour), start, limit, nil); state != nil {
+		t.Errorf("cmd should not be idle yet, got %#v", state)
+	}
+	warnTs := start.Add(limit - IdleKillWarnTime)
+	state := getNextIdleKillState(warnTs, start, limit, nil)
+	if state == nil || state.Stage != IdleKillStage_Warn || !state.KillTs.Equal(start.Add(limit)) {
+		t.Fatalf("expected warn stage, got %#v", state)
+	}
+	state = getNextIdleKillState(start.Add(limit), start, limit, state)
+	if state.Stage != IdleKillStage_Term {
+		t.Errorf("expected term stage, got %d", state.Stage)
+	}
+	state = getNextIdleKillState(start.Add(limit+IdleKillTermGrace), start, limit, state)
+	if state.Stage != IdleKillStage_Kill {
+		t.Errorf("expected kill stage, got %d", state.Stage)
+	}
+	// activity resets the escalation
+	if state := getNextIdleKillState(start.Add(limit), start.Add(limit-time.Minute), limit, state); state != nil {
+		t.Errorf("active cmd should not be idle, got %#v", state)
+	}
+	// cmds already past the limit are still warned first
+	now := start.Add(10 * time.Hour)
+	state = getNextIdleKillState(now, start, limit, nil)
+	if state == nil || state.Stage != IdleKillStage_Warn || !state.KillTs.Equal(now.Add(IdleKillWarnTime)) {
+		t.Errorf("expected warn stage with a full warning period, got %#v", state)
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/shexec"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+)
+
+// live system metrics (cpu, memory, load, disk) of connected remotes.  while the metricsinterval client opt is
+// set, each connected remote is sampled (metrics rpc) every metricsinterval seconds.  samples are sent as
+// "remotemetrics" updates and the last MetricsRingSize samples of each remote are kept in memory (for the
+// remote status dashboard, sent with the connect update).  nothing is persisted.
+
+const MetricsRingSize = 720
+const MetricsTimeout = 10 * time.Second
+const MetricsDisabledPollTime = 10 * time.Second
+
+var metricsIntervalSecs atomic.Int64
+
+var metricsLock = &sync.Mutex{}
+var metricsRings = make(map[string]*metricsRing)
+
+type metricsRing struct {
+	Samples []*scbus.RemoteMetrics
+	Next    int
+}
+
+func (ring *metricsRing) add(sample *scbus.RemoteMetrics) {
+	if len(ring.Samples) < MetricsRingSize {
+		ring.Samples = append(ring.Samples, sample)
+		return
+	}
+	ring.Samples[ring.Next] = sample
+	ring.Next = (ring.Next + 1) % MetricsRingSize
+}
+
+// oldest first
+func (ring *metricsRing) getAll() []*scbus.RemoteMetrics {
+	rtn := make([]*scbus.RemoteMetrics, 0, len(ring.Samples))
+	rtn = append(rtn, ring.Samples[ring.Next:]...)
+	rtn = append(rtn, ring.Samples[:ring.Next]...)
+	return rtn
+}
+
+// secs <= 0 disables sampling (the collected samples are kept)
+func SetMetricsInterval(secs int) {
+	metricsIntervalSecs.Store(int64(max(secs, 0)))
+}
+
+func GetMetricsInterval() time.Duration {
+	return time.Duration(metricsIntervalSecs.Load()) * time.Second
+}
+
+func addMetricsSample(sample *scbus.RemoteMetrics) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	ring := metricsRings[sample.RemoteId]
+	if ring == nil {
+		ring = &metricsRing{}
+		metricsRings[sample.RemoteId] = ring
+	}
+	ring.add(sample)
+}
+
+// the retained samples of the remote, oldest first
+func GetRemoteMetrics(remoteId string) []*scbus.RemoteMetrics {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	ring := metricsRings[remoteId]
+	if ring == nil {
+		return nil
+	}
+	return ring.getAll()
+}
+
+// remoteid => retained samples
+func GetAllRemoteMetrics() map[string][]*scbus.RemoteMetrics {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	if len(metricsRings) == 0 {
+		return nil
+	}
+	rtn := make(map[string][]*scbus.RemoteMetrics)
+	for remoteId, ring := range metricsRings {
+		rtn[remoteId] = ring.getAll()
+	}
+	return rtn
+}
+
+func clearRemoteMetrics(remoteId string) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	delete(metricsRings, remoteId)
+}
+
+func (wsh *WaveshellProc) GetMetrics(ctx context.Context) (*packet.MetricsResponseType, error) {
+	metricsPk := packet.MakeMetricsPacket()
+	metricsPk.ReqId = uuid.New().String()
+	respIf, err := wsh.PacketRpcRaw(ctx, metricsPk)
+	if err != nil {
+		return nil, err
+	}
+	if errResp, ok := respIf.(*packet.ResponsePacketType); ok && !errResp.Success {
+		return nil, fmt.Errorf("metrics error: %s", errResp.Error)
+	}
+	resp, ok := respIf.(*packet.MetricsResponseType)
+	if !ok {
+		return nil, fmt.Errorf("invalid metrics response packet: %T", respIf)
+	}
+	return resp, nil
+}
+
+// the cpu usage is computed from the cpu times of the previous sample (prev can be nil)
+func makeMetricsSample(remoteId string, resp *packet.MetricsResponseType, prev *packet.MetricsResponseType) *scbus.RemoteMetrics {
+	rtn := &scbus.RemoteMetrics{
+		RemoteId:  remoteId,
+		Ts:        resp.Ts,
+		CpuPct:    -1,
+		NumCpu:    resp.NumCpu,
+		MemTotal:  resp.MemTotal,
+		LoadAvg:   resp.LoadAvg,
+		DiskFree:  resp.DiskFree,
+		DiskTotal: resp.DiskTotal,
+	}
+	if rtn.Ts == 0 {
+		rtn.Ts = time.Now().UnixMilli()
+	}
+	if resp.MemTotal > 0 && resp.MemAvail <= resp.MemTotal {
+		rtn.MemUsed = resp.MemTotal - resp.MemAvail
+	}
+	if prev != nil && resp.CpuTotal > prev.CpuTotal && resp.CpuIdle >= prev.CpuIdle {
+		totalDelta := resp.CpuTotal - prev.CpuTotal
+		idleDelta := min(resp.CpuIdle-prev.CpuIdle, totalDelta)
+		rtn.CpuPct = float64(totalDelta-idleDelta) * 100 / float64(totalDelta)
+	}
+	return rtn
+}
+
+// runs until cproc is no longer the remote's connected server process
+func (wsh *WaveshellProc) metricsLoop(cproc *shexec.ClientProc) {
+	isCurrent := func() bool {
+		var rtn bool
+		wsh.WithLock(func() {
+			rtn = (wsh.ServerProc == cproc && wsh.Status == StatusConnected)
+		})
+		return rtn
+	}
+	var prev *packet.MetricsResponseType
+	for isCurrent() {
+		interval := GetMetricsInterval()
+		if interval <= 0 {
+			prev = nil
+			time.Sleep(MetricsDisabledPollTime)
+			continue
+		}
+		ctx, cancelFn := context.WithTimeout(context.Background(), MetricsTimeout)
+		resp, err := wsh.GetMetrics(ctx)
+		cancelFn()
+		if err != nil {
+			if strings.Contains(err.Error(), "invalid rpc type") {
+				// waveshell does not support metrics
+				return
+			}
+			log.Printf("[%s] error getting metrics: %v\n", wsh.GetRemoteName(), err)
+		} else if isCurrent() {
+			sample := makeMetricsSample(wsh.RemoteId, resp, prev)
+			prev = resp
+			addMetricsSample(sample)
+			scbus.MainUpdateBus.DoUpdate(scbus.MakeRemoteMetricsUpdate(sample))
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"testing"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+)
+
+func TestMetricsRing(t *testing.T) {
+	ring := &metricsRing{}
+	for i := 0; i < MetricsRingSize+5; i++ {
+		ring.add(&scbus.RemoteMetrics{Ts: int64(i)})
+	}
+	samples := ring.getAll()
+	if len(samples) != MetricsRingSize {
+		t.Fatalf("expected %d samples, got %d", MetricsRingSize, len(samples))
+	}
+	for idx, sample := range samples {
+		if sample.Ts != int64(idx+5) {
+			t.Fatalf("sample %d: expected ts %d, got %d (not oldest first)", idx, idx+5, sample.Ts)
+		}
+	}
+}
+
+func TestMakeMetricsSample(t *testing.T) {
+	first := &packet.MetricsResponseType{Ts: 1000, NumCpu: 4, CpuTotal: 1000, CpuIdle: 800, MemTotal: 1000, MemAvail: 250}
+	sample := makeMetricsSample("r1", first, nil)
+	if sample.CpuPct != -1 {
+		t.Errorf("first sample should not have a cpu usage, got %v", sample.CpuPct)
+	}
+	if sample.MemUsed != 750 {
+		t.Errorf("expected memused 750, got %d", sample.MemUsed)
+	}
+	second := &packet.MetricsResponseType{Ts: 2000, NumCpu: 4, CpuTotal: 1400, CpuIdle: 900}
+	sample = makeMetricsSample("r1", second, first)
+	if sample.CpuPct != 75 {
+		t.Errorf("expected cpu 75%%, got %v", sample.CpuPct)
+	}
+	if sample.MemUsed != 0 {
+		t.Errorf("memused should be 0 without memtotal, got %d", sample.MemUsed)
+	}
+	// counters reset (remote rebooted), no cpu usage
+	sample = makeMetricsSample("r1", first, second)
+	if sample.CpuPct != -1 {
+		t.Errorf("expected no cpu usage after a counter reset, got %v", sample.CpuPct)
+	}
+}
@@ -15,6 +15,7 @@ import (
 	openaiapi "github.com/sashabaranov/go-openai"
 	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/pcloud"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/srvmetrics"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
 )
 
@@ -26,6 +27,22 @@ const DefaultStreamChanSize = 10
 
 const CloudWebsocketConnectTimeout = 1 * time.Minute
 
+const (
+	AIBackend_OpenAI = "openai"
+	AIBackend_Cloud  = "cloud"
+)
+
+var aiRequestsMetric = srvmetrics.NewCounter("wavesrv_ai_requests_total", "AI requests by backend and result (error if the request could not be started).", "backend", "result")
+var aiStreamErrorsMetric = srvmetrics.NewCounter("wavesrv_ai_stream_errors_total", "AI response streams that ended with an error.", "backend")
+
+func countAIRequest(backend string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	aiRequestsMetric.Inc(backend, result)
+}
+
 func convertUsage(resp openaiapi.ChatCompletionResponse) *packet.OpenAIUsageType {
 	if resp.Usage.TotalTokens == 0 {
 		return nil
@@ -46,7 +63,10 @@ func ConvertPrompt(prompt []packet.OpenAIPromptMessageType) []openaiapi.ChatComp
 	return rtn
 }
 
-func RunCompletion(ctx context.Context, opts *sstore.OpenAIOptsType, prompt []packet.OpenAIPromptMessageType) ([]*packet.OpenAIPacketType, error) {
+func RunCompletion(ctx context.Context, opts *sstore.OpenAIOptsType, prompt []packet.OpenAIPromptMessageType) (rtnPks []*packet.OpenAIPacketType, rtnErr error) {
+	defer func() {
+		countAIRequest(AIBackend_OpenAI, rtnErr)
+	}()
 	if opts == nil {
 		return nil, fmt.Errorf("no openai opts found")
 	}
@@ -79,7 +99,10 @@ func RunCompletion(ctx context.Context, opts *sstore.OpenAIOptsType, prompt []pa
 	return marshalResponse(apiResp), nil
 }
 
-func RunCloudCompletionStream(ctx context.Context, clientId string, opts *sstore.OpenAIOptsType, prompt []packet.OpenAIPromptMessageType) (chan *packet.OpenAIPacketType, *websocket.Conn, error) {
+func RunCloudCompletionStream(ctx context.Context, clientId string, opts *sstore.OpenAIOptsType, prompt []packet.OpenAIPromptMessageType) (rtnCh chan *packet.OpenAIPacketType, rtnConn *websocket.Conn, rtnErr error) {
+	defer func() {
+		countAIRequest(AIBackend_Cloud, rtnErr)
+	}()
 	if opts == nil {
 		return nil, nil, fmt.Errorf("no openai opts found")
 	}
@@ -111,6 +134,7 @@ func RunCloudCompletionStream(ctx context.Context, clientId string, opts *sstore
 				break
 			}
 			if err != nil {
+				aiStreamErrorsMetric.Inc(AIBackend_Cloud)
 				errPk := CreateErrorPacket(fmt.Sprintf("OpenAI request, websocket error reading message: %v", err))
 				rtn <- errPk
 				break
@@ -118,6 +142,7 @@ func RunCloudCompletionStream(ctx context.Context, clientId string, opts *sstore
 			var streamResp *packet.OpenAIPacketType
 			err = json.Unmarshal(socketMessage, &streamResp)
 			if err != nil {
+				aiStreamErrorsMetric.Inc(AIBackend_Cloud)
 				errPk := CreateErrorPacket(fmt.Sprintf("OpenAI request, websocket response json decode error: %v", err))
 				rtn <- errPk
 				break
@@ -127,6 +152,7 @@ func RunCloudCompletionStream(ctx context.Context, clientId string, opts *sstore
 				break
 			} else if streamResp.Error != "" {
 				// use error from server directly
+				aiStreamErrorsMetric.Inc(AIBackend_Cloud)
 				errPk := CreateErrorPacket(streamResp.Error)
 				rtn <- errPk
 				break
@@ -137,7 +163,10 @@ func RunCloudCompletionStream(ctx context.Context, clientId string, opts *sstore
 	return rtn, conn, err
 }
 
-func RunCompletionStream(ctx context.Context, opts *sstore.OpenAIOptsType, prompt []packet.OpenAIPromptMessageType) (chan *packet.OpenAIPacketType, error) {
+func RunCompletionStream(ctx context.Context, opts *sstore.OpenAIOptsType, prompt []packet.OpenAIPromptMessageType) (rtnCh chan *packet.OpenAIPacketType, rtnErr error) {
+	defer func() {
+		countAIRequest(AIBackend_OpenAI, rtnErr)
+	}()
 	if opts == nil {
 		return nil, fmt.Errorf("no openai opts found")
 	}
@@ -175,6 +204,7 @@ func RunCompletionStream(ctx context.Context, opts *sstore.OpenAIOptsType, promp
 				break
 			}
 			if err != nil {
+				aiStreamErrorsMetric.Inc(AIBackend_OpenAI)
 				errPk := CreateErrorPacket(fmt.Sprintf("error in recv of streaming data: %v", err))
 				rtn <- errPk
 				break
@@ -0,0 +1,227 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/utilfn"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// pre-connect hooks (remoteopts preconnect) run in order before an ssh remote is connected.
+// they can wake the host (wake-on-lan), start it with a local command (e.g. a cloud cli), and wait for
+// its ssh port to open.  while a hook is running, it is reported as RemoteRuntimeState.PreConnectStatus
+// and its deadline drives the connecting countdown.
+
+const DefaultPreConnectTimeout = 60 * time.Second
+const MaxPreConnectTimeout = 15 * time.Minute
+const DefaultWakeOnLanAddr = "255.255.255.255"
+const WakeOnLanPort = 9
+const waitPortDialTimeout = 2 * time.Second
+const waitPortRetryInterval = 2 * time.Second
+const maxPreConnectOutputLen = 2000
+
+func ParseMacAddr(macStr string) (net.HardwareAddr, error) {
+	mac, err := net.ParseMAC(macStr)
+	if err != nil {
+		return nil, err
+	}
+	if len(mac) != 6 {
+		return nil, fmt.Errorf("invalid mac address %q, wake-on-lan requires a 6 byte (EUI-48) address", macStr)
+	}
+	return mac, nil
+}
+
+// magic packet is 6 bytes of 0xff followed by the mac address repeated 16 times
+func MakeWakeOnLanPacket(mac net.HardwareAddr) []byte {
+	var buf bytes.Buffer
+	buf.Write(bytes.Repeat([]byte{0xff}, 6))
+	for i := 0; i < 16; i++ {
+		buf.Write(mac)
+	}
+	return buf.Bytes()
+}
+
+func getWakeOnLanAddr(broadcastAddr string) string {
+	if broadcastAddr == "" {
+		broadcastAddr = DefaultWakeOnLanAddr
+	}
+	if _, _, err := net.SplitHostPort(broadcastAddr); err == nil {
+		return broadcastAddr
+	}
+	return net.JoinHostPort(broadcastAddr, strconv.Itoa(WakeOnLanPort))
+}
+
+func SendWakeOnLan(ctx context.Context, macStr string, broadcastAddr string) error {
+	mac, err := ParseMacAddr(macStr)
+	if err != nil {
+		return err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", getWakeOnLanAddr(broadcastAddr))
+	if err != nil {
+		return fmt.Errorf("cannot open udp socket: %w", err)
+	}
+	defer conn.Close()
+	_, err = conn.Write(MakeWakeOnLanPacket(mac))
+	if err != nil {
+		return fmt.Errorf("cannot send magic packet: %w", err)
+	}
+	return nil
+}
+
+func WaitForPort(ctx context.Context, host string, port int) error {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	for {
+		dialer := net.Dialer{Timeout: waitPortDialTimeout}
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(waitPortRetryInterval):
+		}
+	}
+}
+
+func getPreConnectHookTimeout(hook *sstore.PreConnectHookType) time.Duration {
+	if hook.Timeout <= 0 {
+		return DefaultPreConnectTimeout
+	}
+	timeout := time.Duration(hook.Timeout) * time.Second
+	if timeout > MaxPreConnectTimeout {
+		return MaxPreConnectTimeout
+	}
+	return timeout
+}
+
+// returns (host, port) for a waitport hook, defaulting to the remote's ssh host and port
+func getWaitPortTarget(hook *sstore.PreConnectHookType, sshOpts *sstore.SSHOpts) (string, int) {
+	host, port := hook.Host, hook.Port
+	if host == "" && sshOpts != nil {
+		host = sshOpts.SSHHost
+	}
+	if port == 0 && sshOpts != nil {
+		port = sshOpts.SSHPort
+	}
+	if port == 0 {
+		port = 22
+	}
+	return host, port
+}
+
+func DescribePreConnectHook(hook *sstore.PreConnectHookType, sshOpts *sstore.SSHOpts) string {
+	switch hook.HookType {
+	case sstore.PreConnectHook_WakeOnLan:
+		return fmt.Sprintf("wake-on-lan %s (%s)", hook.MacAddr, getWakeOnLanAddr(hook.BroadcastAddr))
+	case sstore.PreConnectHook_Cmd:
+		return fmt.Sprintf("run %s", utilfn.EllipsisStr(hook.Command, 60))
+	case sstore.PreConnectHook_WaitPort:
+		host, port := getWaitPortTarget(hook, sshOpts)
+		return fmt.Sprintf("wait for port %s", net.JoinHostPort(host, strconv.Itoa(port)))
+	default:
+		return fmt.Sprintf("unknown hook %q", hook.HookType)
+	}
+}
+
+// runs the command with "sh -c" on the local machine, output is written to the remote's pty buffer
+func (wsh *WaveshellProc) runPreConnectCmd(ctx context.Context, cmdStr string) error {
+	ecmd := exec.CommandContext(ctx, "sh", "-c", cmdStr)
+	output, err := ecmd.CombinedOutput()
+	outputStr := strings.TrimSpace(string(output))
+	if outputStr != "" {
+		wsh.WriteToPtyBuffer("%s\n", utilfn.EllipsisStr(outputStr, maxPreConnectOutputLen))
+	}
+	if err != nil {
+		return fmt.Errorf("command failed: %w", err)
+	}
+	return nil
+}
+
+func (wsh *WaveshellProc) runPreConnectHook(ctx context.Context, hook *sstore.PreConnectHookType, sshOpts *sstore.SSHOpts) error {
+	switch hook.HookType {
+	case sstore.PreConnectHook_WakeOnLan:
+		return SendWakeOnLan(ctx, hook.MacAddr, hook.BroadcastAddr)
+	case sstore.PreConnectHook_Cmd:
+		return wsh.runPreConnectCmd(ctx, hook.Command)
+	case sstore.PreConnectHook_WaitPort:
+		host, port := getWaitPortTarget(hook, sshOpts)
+		if host == "" {
+			return fmt.Errorf("no host to wait for")
+		}
+		return WaitForPort(ctx, host, port)
+	default:
+		return fmt.Errorf("unknown hook type %q", hook.HookType)
+	}
+}
+
+func (wsh *WaveshellProc) setPreConnectStatus(status string, deadline *time.Time) {
+	wsh.WithLock(func() {
+		wsh.PreConnectStatus = status
+		wsh.MakeClientDeadline = deadline
+		go wsh.NotifyRemoteUpdate()
+	})
+}
+
+// status updates once per second so the frontend countdown stays current
+func (wsh *WaveshellProc) notifyPreConnectCountdown(doneCh chan bool) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-doneCh:
+			return
+		case <-ticker.C:
+			go wsh.NotifyRemoteUpdate()
+		}
+	}
+}
+
+// returns context.Canceled if ctx is canceled (remote was disconnected)
+func (wsh *WaveshellProc) runPreConnectHooks(ctx context.Context, remoteCopy sstore.RemoteType) error {
+	if remoteCopy.RemoteOpts == nil || len(remoteCopy.RemoteOpts.PreConnect) == 0 {
+		return nil
+	}
+	hooks := remoteCopy.RemoteOpts.PreConnect
+	wsh.WithLock(func() {
+		wsh.Err = nil
+		wsh.Status = StatusConnecting
+	})
+	defer wsh.setPreConnectStatus("", nil)
+	for idx, hook := range hooks {
+		desc := DescribePreConnectHook(hook, remoteCopy.SSHOpts)
+		timeout := getPreConnectHookTimeout(hook)
+		wsh.WriteToPtyBuffer("pre-connect hook %d/%d: %s\n", idx+1, len(hooks), desc)
+		deadline := time.Now().Add(timeout)
+		wsh.setPreConnectStatus(desc, &deadline)
+		hookCtx, cancelFn := context.WithDeadline(ctx, deadline)
+		doneCh := make(chan bool)
+		go wsh.notifyPreConnectCountdown(doneCh)
+		err := wsh.runPreConnectHook(hookCtx, hook, remoteCopy.SSHOpts)
+		close(doneCh)
+		cancelFn()
+		if ctx.Err() != nil {
+			return context.Canceled
+		}
+		if err != nil {
+			if errors.Is(hookCtx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("pre-connect hook %q timed out after %v", desc, timeout)
+			}
+			return fmt.Errorf("pre-connect hook %q failed: %w", desc, err)
+		}
+	}
+	return nil
+}
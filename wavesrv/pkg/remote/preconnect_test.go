@@ -0,0 +1,74 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func TestWakeOnLanPacket(t *testing.T) {
+	mac, err := ParseMacAddr("01:23:45:67:89:ab")
+	if err != nil {
+		t.Fatalf("error parsing mac: %v", err)
+	}
+	pk := MakeWakeOnLanPacket(mac)
+	if len(pk) != 102 {
+		t.Fatalf("magic packet len=%d, expected 102", len(pk))
+	}
+	if !bytes.Equal(pk[0:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("invalid magic packet header: %x", pk[0:6])
+	}
+	for i := 0; i < 16; i++ {
+		if !bytes.Equal(pk[6+i*6:12+i*6], mac) {
+			t.Errorf("invalid mac repetition %d: %x", i, pk[6+i*6:12+i*6])
+		}
+	}
+	if _, err := ParseMacAddr("01:23:45:67:89:ab:cd:ef"); err == nil {
+		t.Errorf("expected error for 8 byte mac")
+	}
+	if addr := getWakeOnLanAddr(""); addr != "255.255.255.255:9" {
+		t.Errorf("default wol addr=%q", addr)
+	}
+	if addr := getWakeOnLanAddr("192.168.1.255:7"); addr != "192.168.1.255:7" {
+		t.Errorf("wol addr=%q", addr)
+	}
+}
+
+func TestWaitPortTarget(t *testing.T) {
+	sshOpts := &sstore.SSHOpts{SSHHost: "myhost"}
+	host, port := getWaitPortTarget(&sstore.PreConnectHookType{HookType: sstore.PreConnectHook_WaitPort}, sshOpts)
+	if host != "myhost" || port != 22 {
+		t.Errorf("target=%s:%d, expected myhost:22", host, port)
+	}
+	sshOpts.SSHPort = 2222
+	host, port = getWaitPortTarget(&sstore.PreConnectHookType{HookType: sstore.PreConnectHook_WaitPort, Host: "bastion"}, sshOpts)
+	if host != "bastion" || port != 2222 {
+		t.Errorf("target=%s:%d, expected bastion:2222", host, port)
+	}
+}
+
+func TestWaitForPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+	if err := WaitForPort(ctx, "127.0.0.1", port); err != nil {
+		t.Errorf("error waiting for open port: %v", err)
+	}
+	listener.Close()
+	shortCtx, shortCancelFn := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer shortCancelFn()
+	if err := WaitForPort(shortCtx, "127.0.0.1", port); err == nil {
+		t.Errorf("expected error waiting for closed port")
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -151,6 +152,7 @@ type WaveshellProc struct {
 	PtyBuffer          *circbuf.Buffer
 	MakeClientCancelFn context.CancelFunc
 	MakeClientDeadline *time.Time
+	PreConnectStatus   string
 	StateMap           *server.ShellStateMap
 	NumTryConnect      int
 	InitPkShellType    string
@@ -166,12 +168,29 @@ type WaveshellProc struct {
 	// to register to receive input events from the frontend (e.g. ReInit)
 	CommandInputMap map[base.CommandKey]CommandInputSink
 
+	SerialConsole *serialConsole // only for serial remotes, the open console (see serial.go)
+
 	RunningCmds      map[base.CommandKey]*RunCmdType
 	PendingStateCmds map[pendingStateKey]base.CommandKey // key=[remoteinstance name] (in progress commands that might update the state)
 
 	Client            *ssh.Client
 	sudoPw            []byte
 	sudoClearDeadline int64
+
+	// sudo remote credentials (see sudoauth.go)
+	sudoAuthStatus string
+	sudoAuthTs     int64 // ms
+	sudoAuthGen    int   // incremented to start/cancel the credential loop
+
+	// the screen that started the connection and the ssh banner received while connecting (see sshbanner.go)
+	connectScreenId string
+	sshBanner       string
+
+	// connection health (see health.go)
+	disconnectRequested bool  // the user disconnected the remote (no automatic reconnect)
+	reconnectGen        int   // incremented to start/cancel a reconnect loop
+	reconnectTs         int64 // next automatic reconnect attempt, 0 if none is scheduled
+	reconnectAttempt    int
 }
 
 type CommandInputSink interface {
@@ -185,6 +204,24 @@ type RunCmdType struct {
 	RemotePtr     sstore.RemotePtrType
 	RunPacket     *packet.RunPacketType
 	EphemeralOpts *ephemeral.EphemeralRunOpts
+
+	// env profile vars added to RunPacket.State (see envprofiles.go)
+	envProfileVars *envProfileRunVars
+
+	// ms, last time the cmd produced output or received input (see idlekill.go)
+	LastActivityTs atomic.Int64
+
+	// the output encoding has been detected (see encoding.go)
+	encodingDetected atomic.Bool
+
+	// binary output (see binaryout.go)
+	binaryOut         atomic.Bool
+	binaryOutSize     atomic.Int64
+	binaryPreviewSize int64
+}
+
+func (rct *RunCmdType) MarkActivity() {
+	rct.LastActivityTs.Store(time.Now().UnixMilli())
 }
 
 type ReinitCommandSink struct {
@@ -207,7 +244,7 @@ type RemoteRuntimeState = sstore.RemoteRuntimeState
 
 func CanComplete(remoteType string) bool {
 	switch remoteType {
-	case sstore.RemoteTypeSsh:
+	case sstore.RemoteTypeSsh, sstore.RemoteTypeWSL:
 		return true
 	default:
 		return false
@@ -247,7 +284,7 @@ func LoadRemotes(ctx context.Context) error {
 	for _, remote := range allRemotes {
 		wsh := MakeWaveshell(remote)
 		GlobalStore.Map[remote.RemoteId] = wsh
-		if remote.ConnectMode == sstore.ConnectModeStartup {
+		if remote.ConnectMode == sstore.ConnectModeStartup && !scbase.IsReadOnly() {
 			go wsh.Launch(false)
 		}
 		if remote.Local {
@@ -361,9 +398,32 @@ func ArchiveRemote(ctx context.Context, remoteId string) error {
 	if err != nil {
 		return err
 	}
+	err = sstore.DeleteRemoteHealth(ctx, remoteId)
+	if err != nil {
+		return err
+	}
+	clearRemoteMetrics(remoteId)
+	err = sstore.DeleteRemoteDirBookmarks(ctx, remoteId)
+	if err != nil {
+		return err
+	}
+	err = sstore.DeleteRemoteCwdVisits(ctx, remoteId)
+	if err != nil {
+		return err
+	}
+	err = sstore.DeleteRemoteSnippets(ctx, remoteId)
+	if err != nil {
+		return err
+	}
+	wsh.cancelReconnect()
 	newWsh := MakeWaveshell(archivedRemote)
 	GlobalStore.Map[remoteId] = newWsh
 	go newWsh.NotifyRemoteUpdate()
+	go func() {
+		update := scbus.MakeUpdatePacket()
+		update.AddUpdate(sstore.RemoteHealthType{RemoteId: remoteId, Remove: true})
+		scbus.MainUpdateBus.DoUpdate(update)
+	}()
 	return nil
 }
 
@@ -411,6 +471,17 @@ func GetRemoteById(remoteId string) *WaveshellProc {
 	return GlobalStore.Map[remoteId]
 }
 
+// returns the hashes of the shell states held by the remotes (these must not be garbage collected from the db)
+func GetActiveStateHashes() []string {
+	GlobalStore.Lock.Lock()
+	defer GlobalStore.Lock.Unlock()
+	var rtn []string
+	for _, wsh := range GlobalStore.Map {
+		rtn = append(rtn, wsh.StateMap.GetStateHashes()...)
+	}
+	return rtn
+}
+
 func GetRemoteCopyById(remoteId string) *sstore.RemoteType {
 	wsh := GetRemoteById(remoteId)
 	if wsh == nil {
@@ -441,6 +512,17 @@ func GetLocalRemote() *WaveshellProc {
 	return nil
 }
 
+func GetLocalSudoRemote() *WaveshellProc {
+	GlobalStore.Lock.Lock()
+	defer GlobalStore.Lock.Unlock()
+	for _, wsh := range GlobalStore.Map {
+		if wsh.IsLocal() && wsh.IsSudo() {
+			return wsh
+		}
+	}
+	return nil
+}
+
 func ResolveRemoteRef(remoteRef string) *RemoteRuntimeState {
 	GlobalStore.Lock.Lock()
 	defer GlobalStore.Lock.Unlock()
@@ -468,10 +550,14 @@ func SendSignalToCmd(ctx context.Context, cmd *sstore.CmdType, sig string) error
 	if wsh == nil {
 		return fmt.Errorf("no connection found")
 	}
+	cmdCk := base.MakeCommandKey(cmd.ScreenId, cmd.LineId)
+	if sc := wsh.getSerialConsole(); sc != nil && sc.CK == cmdCk {
+		sc.close(true)
+		return nil
+	}
 	if !wsh.IsConnected() {
 		return fmt.Errorf("not connected")
 	}
-	cmdCk := base.MakeCommandKey(cmd.ScreenId, cmd.LineId)
 	if !wsh.IsCmdRunning(cmdCk) {
 		// this could also return nil (depends on use case)
 		// settled on coded error so we can check for this error
@@ -577,6 +663,7 @@ func (wsh *WaveshellProc) GetRemoteRuntimeState() RemoteRuntimeState {
 		AutoInstall:           wsh.Remote.AutoInstall,
 		Archived:              wsh.Remote.Archived,
 		RemoteIdx:             wsh.Remote.RemoteIdx,
+		GroupId:               wsh.Remote.GroupId,
 		SSHConfigSrc:          wsh.Remote.SSHConfigSrc,
 		UName:                 wsh.UName,
 		InstallStatus:         wsh.InstallStatus,
@@ -594,6 +681,11 @@ func (wsh *WaveshellProc) GetRemoteRuntimeState() RemoteRuntimeState {
 	if wsh.Remote.RemoteOpts != nil {
 		optsCopy := *wsh.Remote.RemoteOpts
 		state.RemoteOpts = &optsCopy
+		state.PolicyMeta = sstore.GetRemotePolicyMeta(&optsCopy, wsh.Remote.GetName())
+	}
+	if wsh.Remote.IsSerial() && wsh.SerialConsole != nil {
+		// serial remotes never connect, show an open console as connected
+		state.Status = StatusConnected
 	}
 	if wsh.Err != nil {
 		state.ErrorStr = wsh.Err.Error()
@@ -603,6 +695,7 @@ func (wsh *WaveshellProc) GetRemoteRuntimeState() RemoteRuntimeState {
 	}
 	if wsh.Status == StatusConnecting {
 		state.WaitingForPassword = wsh.isWaitingForPassword_nolock()
+		state.PreConnectStatus = wsh.PreConnectStatus
 		if wsh.MakeClientDeadline != nil {
 			state.ConnectTimeout = int(time.Until(*wsh.MakeClientDeadline) / time.Second)
 			if state.ConnectTimeout < 0 {
@@ -613,6 +706,10 @@ func (wsh *WaveshellProc) GetRemoteRuntimeState() RemoteRuntimeState {
 			state.CountdownActive = false
 		}
 	}
+	if wsh.Status == StatusConnected {
+		state.HostHealth = getHostHealthFromStateVars(wsh.Remote.StateVars)
+	}
+	state.SudoAuth = wsh.getSudoAuthState_nolock()
 	vars := wsh.Remote.StateVars
 	if vars == nil {
 		vars = make(map[string]string)
@@ -869,6 +966,11 @@ func (wsh *WaveshellProc) UpdateRemote(ctx context.Context, editMap map[string]i
 }
 
 func (wsh *WaveshellProc) Disconnect(force bool) {
+	if wsh.cancelReconnect() {
+		wsh.WriteToPtyBuffer("automatic reconnect canceled\n")
+		go wsh.notifyHealthUpdate(nil)
+		return
+	}
 	status := wsh.GetStatus()
 	if status != StatusConnected && status != StatusConnecting {
 		wsh.WriteToPtyBuffer("remote already disconnected (no action taken)\n")
@@ -881,6 +983,7 @@ func (wsh *WaveshellProc) Disconnect(force bool) {
 	}
 	wsh.Lock.Lock()
 	defer wsh.Lock.Unlock()
+	wsh.disconnectRequested = true
 	if wsh.ServerProc != nil {
 		wsh.ServerProc.Close()
 		wsh.Client = nil
@@ -938,13 +1041,13 @@ func (wsh *WaveshellProc) writeToPtyBuffer_nolock(strFmt string, args ...interfa
 	sendRemotePtyUpdate(wsh.Remote.RemoteId, curOffset, data)
 }
 
+// data is copied (the pty read buffer is reused)
 func sendRemotePtyUpdate(remoteId string, dataOffset int64, data []byte) {
-	data64 := base64.StdEncoding.EncodeToString(data)
 	update := scbus.MakePtyDataUpdate(&scbus.PtyDataUpdate{
 		RemoteId:   remoteId,
 		PtyPos:     dataOffset,
-		PtyData64:  data64,
 		PtyDataLen: int64(len(data)),
+		PtyData:    bytes.Clone(data),
 	})
 	scbus.MainUpdateBus.DoUpdate(update)
 }
@@ -1213,6 +1316,10 @@ func (wsh *WaveshellProc) RunInstall(autoInstall bool) {
 		wsh.WriteToPtyBuffer("*error: cannot install on archived remote\n")
 		return
 	}
+	if remoteCopy.IsSerial() {
+		wsh.WriteToPtyBuffer("*error: cannot install waveshell on a serial remote\n")
+		return
+	}
 
 	var makeClientCtx context.Context
 	var makeClientCancelFn context.CancelFunc
@@ -1319,25 +1426,30 @@ func (wsh *WaveshellProc) RunInstall(autoInstall bool) {
 		wsh.WriteToPtyBuffer("*error: %v\n", err)
 		return
 	}
-	if wsh.Client == nil {
-		remoteDisplayName := fmt.Sprintf("%s [%s]", remoteCopy.RemoteAlias, remoteCopy.RemoteCanonicalName)
-		client, err := ConnectToClient(makeClientCtx, remoteCopy.SSHOpts, remoteDisplayName)
+	var installSession shexec.ConnInterface
+	if remoteCopy.IsWSL() {
+		installSession = shexec.CmdWrap{Cmd: MakeWSLExecCmd(remoteCopy, "sh", shexec.MakeInstallCommandStr())}
+	} else {
+		if wsh.Client == nil {
+			remoteDisplayName := fmt.Sprintf("%s [%s]", remoteCopy.RemoteAlias, remoteCopy.RemoteCanonicalName)
+			client, err := ConnectToClient(makeClientCtx, remoteCopy.SSHOpts, remoteDisplayName, wsh.addSshBanner)
+			if err != nil {
+				statusErr := fmt.Errorf("ssh cannot connect to client: %w", err)
+				wsh.setInstallErrorStatus(statusErr)
+				return
+			}
+			wsh.WithLock(func() {
+				wsh.Client = client
+			})
+		}
+		session, err := wsh.Client.NewSession()
 		if err != nil {
 			statusErr := fmt.Errorf("ssh cannot connect to client: %w", err)
 			wsh.setInstallErrorStatus(statusErr)
 			return
 		}
-		wsh.WithLock(func() {
-			wsh.Client = client
-		})
-	}
-	session, err := wsh.Client.NewSession()
-	if err != nil {
-		statusErr := fmt.Errorf("ssh cannot connect to client: %w", err)
-		wsh.setInstallErrorStatus(statusErr)
-		return
+		installSession = shexec.SessionWrap{Session: session, StartCmd: shexec.MakeInstallCommandStr()}
 	}
-	installSession := shexec.SessionWrap{Session: session, StartCmd: shexec.MakeInstallCommandStr()}
 	wsh.WriteToPtyBuffer("installing waveshell %s to %s...\n", scbase.WaveshellVersion, remoteCopy.RemoteCanonicalName)
 	clientCtx, clientCancelFn := context.WithCancel(context.Background())
 	defer clientCancelFn()
@@ -1423,7 +1535,7 @@ func (wsh *WaveshellProc) ReInit(ctx context.Context, ck base.CommandKey, shellT
 	if !wsh.IsConnected() {
 		return nil, fmt.Errorf("cannot reinit, remote is not connected")
 	}
-	if shellType != packet.ShellType_bash && shellType != packet.ShellType_zsh {
+	if shellType != packet.ShellType_bash && shellType != packet.ShellType_zsh && shellType != packet.ShellType_pwsh && shellType != packet.ShellType_fish {
 		return nil, fmt.Errorf("invalid shell type %q", shellType)
 	}
 	if dataFn == nil {
@@ -1593,7 +1705,12 @@ func (wsh *WaveshellProc) createWaveshellSession(clientCtx context.Context, remo
 		wsh.MakeClientDeadline = nil
 		go wsh.NotifyRemoteUpdate()
 	})
-	sapi, err := shellapi.MakeShellApi(wsh.GetShellType())
+	launchShellType := wsh.GetShellType()
+	if launchShellType == packet.ShellType_pwsh || launchShellType == packet.ShellType_fish {
+		// the waveshell launch command is posix shell syntax
+		launchShellType = packet.ShellType_bash
+	}
+	sapi, err := shellapi.MakeShellApi(launchShellType)
 	if err != nil {
 		return nil, err
 	}
@@ -1612,9 +1729,12 @@ func (wsh *WaveshellProc) createWaveshellSession(clientCtx context.Context, remo
 		go wsh.RunPtyReadLoop(cmdPty)
 		go wsh.WaitAndSendPasswordNew(remoteCopy.SSHOpts.SSHPassword)
 		wsSession = shexec.CmdWrap{Cmd: ecmd}
+	} else if remoteCopy.IsWSL() {
+		ecmd := MakeWSLExecCmd(remoteCopy, sapi.GetRemoteShellPath(), MakeServerCommandStr())
+		wsSession = shexec.CmdWrap{Cmd: ecmd}
 	} else if wsh.Client == nil {
 		remoteDisplayName := fmt.Sprintf("%s [%s]", remoteCopy.RemoteAlias, remoteCopy.RemoteCanonicalName)
-		client, err := ConnectToClient(clientCtx, remoteCopy.SSHOpts, remoteDisplayName)
+		client, err := ConnectToClient(clientCtx, remoteCopy.SSHOpts, remoteDisplayName, wsh.addSshBanner)
 		if err != nil {
 			return nil, fmt.Errorf("ssh cannot connect to client: %w", err)
 		}
@@ -1653,6 +1773,10 @@ func (wsh *WaveshellProc) Launch(interactive bool) {
 		wsh.WriteToPtyBuffer("cannot launch archived remote\n")
 		return
 	}
+	if remoteCopy.IsSerial() {
+		wsh.WriteToPtyBuffer("serial remotes do not connect, open a console with /remote:serial open=%s\n", remoteCopy.GetName())
+		return
+	}
 	curStatus := wsh.GetStatus()
 	if curStatus == StatusConnected {
 		wsh.WriteToPtyBuffer("remote is already connected (no action taken)\n")
@@ -1677,6 +1801,25 @@ func (wsh *WaveshellProc) Launch(interactive bool) {
 	})
 	defer makeClientCancelFn()
 	wsh.WriteToPtyBuffer("connecting to %s...\n", remoteCopy.RemoteCanonicalName)
+	var hasClient bool
+	wsh.WithLock(func() {
+		hasClient = (wsh.Client != nil)
+	})
+	if !remoteCopy.Local && !hasClient {
+		err := wsh.runPreConnectHooks(makeClientCtx, remoteCopy)
+		if err == context.Canceled {
+			wsh.WriteToPtyBuffer("*forced disconnection\n")
+			wsh.WithLock(func() {
+				wsh.Status = StatusDisconnected
+				go wsh.NotifyRemoteUpdate()
+			})
+			return
+		} else if err != nil {
+			wsh.WriteToPtyBuffer("*error, %s\n", err.Error())
+			wsh.setErrorStatus(err)
+			return
+		}
+	}
 	wsSession, err := wsh.createWaveshellSession(makeClientCtx, remoteCopy)
 	if err != nil {
 		wsh.WriteToPtyBuffer("*error, %s\n", err.Error())
@@ -1738,6 +1881,8 @@ func (wsh *WaveshellProc) Launch(interactive bool) {
 	wsh.WithLock(func() {
 		wsh.ServerProc = cproc
 		wsh.Status = StatusConnected
+		wsh.disconnectRequested = false
+		wsh.startSudoAuth_nolock()
 	})
 	wsh.WriteToPtyBuffer("connected to %s\n", remoteCopy.RemoteCanonicalName)
 	go func() {
@@ -1752,6 +1897,11 @@ func (wsh *WaveshellProc) Launch(interactive bool) {
 		wsh.WriteToPtyBuffer("*disconnected exitcode=%d\n", exitCode)
 	}()
 	go wsh.ProcessPackets()
+	go wsh.hostStatsLoop(cproc)
+	go wsh.metricsLoop(cproc)
+	go wsh.keepAliveLoop(cproc)
+	bannerScreenId, banner := wsh.takeConnectBanner()
+	go wsh.writeConnectBannerLine(bannerScreenId, banner)
 	// wsh.initActiveShells()
 	go wsh.NotifyRemoteUpdate()
 }
@@ -1871,6 +2021,18 @@ func (wsh *WaveshellProc) testAndSetPendingStateCmd(screenId string, rptr sstore
 	return true, nil
 }
 
+// returns the in-progress command (if any) that can update the state of the screen's remote instance
+func (wsh *WaveshellProc) GetPendingStateCmd(screenId string, rptr sstore.RemotePtrType) *base.CommandKey {
+	key := pendingStateKey{ScreenId: screenId, RemotePtr: rptr}
+	wsh.Lock.Lock()
+	defer wsh.Lock.Unlock()
+	ck, found := wsh.PendingStateCmds[key]
+	if !found {
+		return nil
+	}
+	return &ck
+}
+
 func (wsh *WaveshellProc) removePendingStateCmd(screenId string, rptr sstore.RemotePtrType, ck base.CommandKey) {
 	key := pendingStateKey{ScreenId: screenId, RemotePtr: rptr}
 	wsh.Lock.Lock()
@@ -2014,7 +2176,12 @@ func RunCommand(ctx context.Context, rcOpts RunCommandOpts, runPacket *packet.Ru
 	if err != nil || currentState == nil {
 		return nil, nil, fmt.Errorf("cannot load current remote state: %w", err)
 	}
-	runPacket.State = addScVarsToState(currentState)
+	envVars, err := getEnvProfileVars(ctx, sessionId, screenId, remotePtr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot run command: %w", err)
+	}
+	var envProfileVars *envProfileRunVars
+	runPacket.State, envProfileVars = addEnvProfileVarsToState(addScVarsToState(currentState), envVars)
 	runPacket.StateComplete = true
 	runPacket.ShellType = currentState.GetShellType()
 
@@ -2035,6 +2202,8 @@ func RunCommand(ctx context.Context, rcOpts RunCommandOpts, runPacket *packet.Ru
 		RemotePtr:     remotePtr,
 		RunPacket:     runPacket,
 		EphemeralOpts: rcOpts.EphemeralOpts,
+
+		envProfileVars: envProfileVars,
 	}
 	// RegisterRpc + WaitForResponse is used to get any waveshell side errors
 	// waveshell will either return an error (in a ResponsePacketType) or a CmdStartPacketType
@@ -2139,10 +2308,17 @@ func (wsh *WaveshellProc) HandleFeInput(inputPk *scpacket.FeInputPacketType) err
 	if inputPk == nil {
 		return nil
 	}
+	if sc := wsh.getSerialConsole(); sc != nil && sc.CK == inputPk.CK {
+		// serial remotes are never connected
+		return sc.HandleInput(inputPk)
+	}
 	if !wsh.IsConnected() {
 		return fmt.Errorf("connection is not connected, cannot send input")
 	}
-	if wsh.IsCmdRunning(inputPk.CK) {
+	if rct := wsh.GetRunningCmd(inputPk.CK); rct != nil {
+		if len(inputPk.InputData64) > 0 || inputPk.SigName != "" {
+			rct.MarkActivity()
+		}
 		if len(inputPk.InputData64) > 0 {
 			inputLen := packet.B64DecodedLen(inputPk.InputData64)
 			if inputLen > MaxInputDataSize {
@@ -2180,6 +2356,7 @@ func (wsh *WaveshellProc) HandleFeInput(inputPk *scpacket.FeInputPacketType) err
 }
 
 func (wsh *WaveshellProc) AddRunningCmd(rct *RunCmdType) {
+	rct.MarkActivity()
 	wsh.Lock.Lock()
 	defer wsh.Lock.Unlock()
 	wsh.RunningCmds[rct.RunPacket.CK] = rct
@@ -2456,11 +2633,13 @@ func (wsh *WaveshellProc) handleCmdDonePacket(rct *RunCmdType, donePk *packet.Cm
 	defer cancelFn()
 	update := scbus.MakeUpdatePacket()
 	if rct.EphemeralOpts == nil {
+		wsh.writeBinaryOutputSummary(ctx, rct)
 		// only update DB for non-ephemeral commands
 		cmdDoneInfo := sstore.CmdDoneDataValues{
 			Ts:         donePk.Ts,
 			ExitCode:   donePk.ExitCode,
 			DurationMs: donePk.DurationMs,
+			Usage:      donePk.Usage,
 		}
 		err := sstore.UpdateCmdDoneInfo(ctx, update, donePk.CK, cmdDoneInfo, sstore.CmdStatusDone)
 		if err != nil {
@@ -2497,6 +2676,7 @@ func (wsh *WaveshellProc) handleCmdDonePacket(rct *RunCmdType, donePk *packet.Cm
 		log.Printf("error resolving final state for cmd: %v\n", err)
 		// fallthrough
 	}
+	finalState = stripEnvProfileVarsFromState(finalState, rct.envProfileVars)
 	if finalState != nil {
 		newRI, err := wsh.updateRIWithFinalState(ctx, rct, finalState)
 		if err != nil {
@@ -2574,6 +2754,29 @@ func (wsh *WaveshellProc) writeToCmdPtyOut(ctx context.Context, screenId string,
 	return nil
 }
 
+// updates the line's linestate with fn and sends the line update to the frontend
+func updateCmdLineState(ctx context.Context, ck base.CommandKey, fn func(lineState map[string]any)) error {
+	screenId, lineId := ck.GetGroupId(), ck.GetCmdId()
+	line, err := sstore.GetLineById(ctx, screenId, lineId)
+	if err != nil || line == nil {
+		return err
+	}
+	lineState := make(map[string]any)
+	for key, val := range line.LineState {
+		lineState[key] = val
+	}
+	fn(lineState)
+	err = sstore.UpdateLineState(ctx, screenId, lineId, lineState)
+	if err != nil {
+		return err
+	}
+	line.LineState = lineState
+	update := scbus.MakeUpdatePacket()
+	sstore.AddLineUpdate(update, line, nil)
+	scbus.MainUpdateBus.DoScreenUpdate(screenId, update)
+	return nil
+}
+
 func (wsh *WaveshellProc) handleDataPacket(rct *RunCmdType, dataPk *packet.DataPacketType, dataPosMap *utilfn.SyncMap[base.CommandKey, int64]) {
 	if rct == nil {
 		log.Printf("error handling data packet: no running cmd found %s\n", dataPk.CK)
@@ -2581,6 +2784,7 @@ func (wsh *WaveshellProc) handleDataPacket(rct *RunCmdType, dataPk *packet.DataP
 		wsh.ServerProc.Input.SendPacket(ack)
 		return
 	}
+	rct.MarkActivity()
 	realData, err := base64.StdEncoding.DecodeString(dataPk.Data64)
 	if err != nil {
 		log.Printf("error decoding data packet: %v\n", err)
@@ -2616,16 +2820,24 @@ func (wsh *WaveshellProc) handleDataPacket(rct *RunCmdType, dataPk *packet.DataP
 
 	var ack *packet.DataAckPacketType
 	if len(realData) > 0 {
-		dataPos := dataPosMap.Get(dataPk.CK)
-		update, err := sstore.AppendToCmdPtyBlob(context.Background(), rct.ScreenId, dataPk.CK.GetCmdId(), realData, dataPos)
-		if err != nil {
-			ack = makeDataAckPacket(dataPk.CK, dataPk.FdNum, 0, err)
-		} else {
+		termData := rct.handleBinaryOutput(realData)
+		if len(termData) == 0 {
 			ack = makeDataAckPacket(dataPk.CK, dataPk.FdNum, len(realData), nil)
-		}
-		utilfn.IncSyncMap(dataPosMap, dataPk.CK, int64(len(realData)))
-		if update != nil {
-			scbus.MainUpdateBus.DoScreenUpdate(dataPk.CK.GetGroupId(), update)
+		} else {
+			dataPos := dataPosMap.Get(dataPk.CK)
+			update, err := sstore.AppendToCmdPtyBlob(context.Background(), rct.ScreenId, dataPk.CK.GetCmdId(), termData, dataPos)
+			if err != nil {
+				ack = makeDataAckPacket(dataPk.CK, dataPk.FdNum, 0, err)
+			} else {
+				ack = makeDataAckPacket(dataPk.CK, dataPk.FdNum, len(realData), nil)
+				if !rct.binaryOut.Load() {
+					rct.checkOutputEncoding(termData)
+				}
+			}
+			utilfn.IncSyncMap(dataPosMap, dataPk.CK, int64(len(termData)))
+			if update != nil {
+				scbus.MainUpdateBus.DoScreenUpdate(dataPk.CK.GetGroupId(), update)
+			}
 		}
 	}
 	if ack != nil {
@@ -2818,6 +3030,9 @@ func (wsh *WaveshellProc) ProcessPackets() {
 		if wsh.Status == StatusConnected {
 			wsh.Status = StatusDisconnected
 		}
+		if !wsh.disconnectRequested {
+			go wsh.handleConnectionDrop()
+		}
 		screens, err := sstore.HangupRunningCmdsByRemoteId(context.Background(), wsh.Remote.RemoteId)
 		if err != nil {
 			wsh.writeToPtyBuffer_nolock("error calling HUP on cmds %v\n", err)
@@ -3033,12 +3248,19 @@ func (wsh *WaveshellProc) getFeStateFromDiff(stateDiff *packet.ShellStateDiff) (
 	}
 }
 
-func (wsh *WaveshellProc) TryAutoConnect() error {
+// screenId (can be "") is the screen the ssh banner is written to (see sshbanner.go)
+func (wsh *WaveshellProc) TryAutoConnect(screenId string) error {
 	if wsh.IsConnected() {
 		return nil
 	}
 	rcopy := wsh.GetRemoteCopy()
-	if rcopy.ConnectMode == sstore.ConnectModeManual {
+	// sudo remotes with expired credentials reconnect (re-prompting for the password), dropped ones never do
+	// (see sudoauth.go)
+	sudoStatus := wsh.getSudoAuthStatus()
+	if sudoStatus == sstore.SudoAuthStatus_Dropped {
+		return nil
+	}
+	if rcopy.ConnectMode == sstore.ConnectModeManual && sudoStatus != sstore.SudoAuthStatus_Expired {
 		return nil
 	}
 	var err error
@@ -3052,7 +3274,7 @@ func (wsh *WaveshellProc) TryAutoConnect() error {
 	if err != nil {
 		return err
 	}
-	wsh.Launch(false)
+	wsh.LaunchFromScreen(false, screenId)
 	if !wsh.IsConnected() {
 		return fmt.Errorf("error connecting")
 	}
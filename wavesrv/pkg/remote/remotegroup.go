@@ -0,0 +1,71 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// group membership and order are stored in the db (see sstore.RemoteGroupType).  after a change the
+// groupid/remoteidx of the in-memory remotes are reloaded and the remotes that changed are sent as updates.
+
+func reloadRemoteGroupFields(ctx context.Context) error {
+	dbRemotes, err := sstore.GetAllRemotes(ctx)
+	if err != nil {
+		return err
+	}
+	GlobalStore.Lock.Lock()
+	defer GlobalStore.Lock.Unlock()
+	for _, dbRemote := range dbRemotes {
+		wsh := GlobalStore.Map[dbRemote.RemoteId]
+		if wsh == nil {
+			continue
+		}
+		wsh.Lock.Lock()
+		changed := wsh.Remote.GroupId != dbRemote.GroupId || wsh.Remote.RemoteIdx != dbRemote.RemoteIdx
+		if changed {
+			rcopy := *wsh.Remote
+			rcopy.GroupId = dbRemote.GroupId
+			rcopy.RemoteIdx = dbRemote.RemoteIdx
+			wsh.Remote = &rcopy
+		}
+		wsh.Lock.Unlock()
+		if changed {
+			go wsh.NotifyRemoteUpdate()
+		}
+	}
+	return nil
+}
+
+// groupId "" removes the remote from its group
+func SetRemoteGroup(ctx context.Context, remoteId string, groupId string) error {
+	if GetRemoteById(remoteId) == nil {
+		return fmt.Errorf("remote not found")
+	}
+	err := sstore.SetRemoteGroupId(ctx, remoteId, groupId)
+	if err != nil {
+		return err
+	}
+	return reloadRemoteGroupFields(ctx)
+}
+
+// newIdx is 1-indexed, the position within the remote's group
+func ReorderRemote(ctx context.Context, remoteId string, newIdx int) error {
+	err := sstore.SetRemoteIdxInGroup(ctx, remoteId, newIdx)
+	if err != nil {
+		return err
+	}
+	return reloadRemoteGroupFields(ctx)
+}
+
+func DeleteRemoteGroup(ctx context.Context, groupId string) error {
+	_, err := sstore.DeleteRemoteGroup(ctx, groupId)
+	if err != nil {
+		return err
+	}
+	return reloadRemoteGroupFields(ctx)
+}
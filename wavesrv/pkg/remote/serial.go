@@ -0,0 +1,292 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/base"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+	"golang.org/x/sys/unix"
+)
+
+// serial remotes (RemoteTypeSerial) are consoles on a serial device, the device path is stored as the remote's
+// host and the line settings in remoteopts.serialopts.  they do not run waveshell (and never connect), instead
+// a console is opened as a running line (OpenSerialConsole).  the device output is written to the line's ptyout
+// and the line's input is written to the device.  only one console can be open per remote.
+
+const SerialCanonicalPrefix = "serial://"
+const DefaultSerialBaudRate = 115200
+
+const (
+	SerialParityNone = "none"
+	SerialParityEven = "even"
+	SerialParityOdd  = "odd"
+)
+
+const serialReadBufSize = 4096
+
+var serialDevicePatterns = []string{"/dev/ttyUSB*", "/dev/ttyACM*", "/dev/cu.usbserial*", "/dev/cu.usbmodem*", "/dev/serial/by-id/*"}
+
+type serialConsole struct {
+	CK        base.CommandKey
+	Port      *os.File
+	CloseOnce sync.Once
+	Closed    bool // set when closed by the user (or a signal), not by a device error
+}
+
+func (sc *serialConsole) close(byUser bool) {
+	sc.CloseOnce.Do(func() {
+		sc.Closed = byUser
+		sc.Port.Close()
+	})
+}
+
+// writes the line's input to the device, any signal closes the console
+func (sc *serialConsole) HandleInput(feInput *scpacket.FeInputPacketType) error {
+	if feInput.SigName != "" {
+		sc.close(true)
+		return nil
+	}
+	if len(feInput.InputData64) == 0 {
+		return nil
+	}
+	data, err := base64.StdEncoding.DecodeString(feInput.InputData64)
+	if err != nil {
+		return fmt.Errorf("error decoding input data: %v", err)
+	}
+	_, err = sc.Port.Write(data)
+	return err
+}
+
+func MakeSerialCanonicalName(device string) string {
+	return SerialCanonicalPrefix + device
+}
+
+func MakeDefaultSerialOpts() *sstore.SerialOptsType {
+	return &sstore.SerialOptsType{BaudRate: DefaultSerialBaudRate, Parity: SerialParityNone, DataBits: 8, StopBits: 1}
+}
+
+func ValidateSerialOpts(opts *sstore.SerialOptsType) error {
+	if _, ok := getSerialSpeed(opts.BaudRate); !ok {
+		return fmt.Errorf("unsupported baud rate %d", opts.BaudRate)
+	}
+	if opts.Parity != SerialParityNone && opts.Parity != SerialParityEven && opts.Parity != SerialParityOdd {
+		return fmt.Errorf("invalid parity %q (must be %s, %s, or %s)", opts.Parity, SerialParityNone, SerialParityEven, SerialParityOdd)
+	}
+	if opts.DataBits < 5 || opts.DataBits > 8 {
+		return fmt.Errorf("invalid databits %d (must be 5-8)", opts.DataBits)
+	}
+	if opts.StopBits != 1 && opts.StopBits != 2 {
+		return fmt.Errorf("invalid stopbits %d (must be 1 or 2)", opts.StopBits)
+	}
+	return nil
+}
+
+func MakeSerialRemote(device string, opts *sstore.SerialOptsType) *sstore.RemoteType {
+	return &sstore.RemoteType{
+		RemoteType:          sstore.RemoteTypeSerial,
+		RemoteCanonicalName: MakeSerialCanonicalName(device),
+		RemoteHost:          device,
+		ConnectMode:         sstore.ConnectModeManual,
+		SSHOpts:             &sstore.SSHOpts{},
+		SSHConfigSrc:        sstore.SSHConfigSrcTypeManual,
+		RemoteOpts:          &sstore.RemoteOptsType{SerialOpts: opts},
+	}
+}
+
+// returns the serial devices that are present (usb serial adapters)
+func ListSerialDevices() []string {
+	var rtn []string
+	for _, pattern := range serialDevicePatterns {
+		matches, _ := filepath.Glob(pattern)
+		rtn = append(rtn, matches...)
+	}
+	sort.Strings(rtn)
+	return rtn
+}
+
+// sets the port to raw mode with the line settings from opts
+func configureSerialPort(port *os.File, opts *sstore.SerialOptsType) error {
+	fd := int(port.Fd())
+	termios, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+	if err != nil {
+		return fmt.Errorf("cannot get terminal attributes: %w", err)
+	}
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON | unix.IXOFF
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB | unix.PARODD | unix.CSTOPB | unix.CRTSCTS
+	termios.Cflag |= unix.CREAD | unix.CLOCAL
+	switch opts.DataBits {
+	case 5:
+		termios.Cflag |= unix.CS5
+	case 6:
+		termios.Cflag |= unix.CS6
+	case 7:
+		termios.Cflag |= unix.CS7
+	default:
+		termios.Cflag |= unix.CS8
+	}
+	if opts.Parity == SerialParityEven || opts.Parity == SerialParityOdd {
+		termios.Cflag |= unix.PARENB
+		termios.Iflag |= unix.INPCK
+	}
+	if opts.Parity == SerialParityOdd {
+		termios.Cflag |= unix.PARODD
+	}
+	if opts.StopBits == 2 {
+		termios.Cflag |= unix.CSTOPB
+	}
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	speed, ok := getSerialSpeed(opts.BaudRate)
+	if !ok {
+		return fmt.Errorf("unsupported baud rate %d", opts.BaudRate)
+	}
+	setTermiosSpeed(termios, speed)
+	err = unix.IoctlSetTermios(fd, ioctlWriteTermios, termios)
+	if err != nil {
+		return fmt.Errorf("cannot set terminal attributes: %w", err)
+	}
+	return nil
+}
+
+func openSerialPort(device string, opts *sstore.SerialOptsType) (*os.File, error) {
+	// O_NONBLOCK so the open does not wait for carrier detect (and reads go through the poller, so Close unblocks them)
+	port, err := os.OpenFile(device, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	err = configureSerialPort(port, opts)
+	if err != nil {
+		port.Close()
+		return nil, err
+	}
+	return port, nil
+}
+
+func (wsh *WaveshellProc) getSerialConsole() *serialConsole {
+	wsh.Lock.Lock()
+	defer wsh.Lock.Unlock()
+	return wsh.SerialConsole
+}
+
+func (wsh *WaveshellProc) IsSerialConsoleOpen() bool {
+	return wsh.getSerialConsole() != nil
+}
+
+// opens the remote's serial device as the console for cmd (a running cmd with its line already added).
+// returns once the device is open, the console runs until it is closed (signal, /remote:serial close=1)
+// or the device goes away, then cmd is marked as done.
+func (wsh *WaveshellProc) OpenSerialConsole(cmd *sstore.CmdType, outputPos int64) error {
+	remoteCopy := wsh.GetRemoteCopy()
+	if !remoteCopy.IsSerial() {
+		return fmt.Errorf("remote %q is not a serial remote", remoteCopy.GetName())
+	}
+	opts := MakeDefaultSerialOpts()
+	if remoteCopy.RemoteOpts != nil && remoteCopy.RemoteOpts.SerialOpts != nil {
+		opts = remoteCopy.RemoteOpts.SerialOpts
+	}
+	ck := base.MakeCommandKey(cmd.ScreenId, cmd.LineId)
+	sc := &serialConsole{CK: ck}
+	var err error
+	wsh.WithLock(func() {
+		if wsh.SerialConsole != nil {
+			err = fmt.Errorf("serial console for %s is already open (line %s)", remoteCopy.RemoteHost, wsh.SerialConsole.CK.GetCmdId())
+			return
+		}
+		// the device is opened non-blocking, so this does not hold the lock for long
+		sc.Port, err = openSerialPort(remoteCopy.RemoteHost, opts)
+		if err != nil {
+			err = fmt.Errorf("cannot open %s: %w", remoteCopy.RemoteHost, err)
+			return
+		}
+		wsh.SerialConsole = sc
+	})
+	if err != nil {
+		return err
+	}
+	go wsh.NotifyRemoteUpdate()
+	go wsh.runSerialConsole(sc, cmd, outputPos)
+	return nil
+}
+
+// closes the open serial console (if any), returns false if no console was open
+func (wsh *WaveshellProc) CloseSerialConsole() bool {
+	sc := wsh.getSerialConsole()
+	if sc == nil {
+		return false
+	}
+	sc.close(true)
+	return true
+}
+
+func (wsh *WaveshellProc) runSerialConsole(sc *serialConsole, cmd *sstore.CmdType, outputPos int64) {
+	startTime := time.Now()
+	ctx := context.Background()
+	buf := make([]byte, serialReadBufSize)
+	var readErr error
+	for {
+		n, err := sc.Port.Read(buf)
+		if n > 0 {
+			update, appendErr := sstore.AppendToCmdPtyBlob(ctx, cmd.ScreenId, cmd.LineId, buf[:n], outputPos)
+			outputPos += int64(n)
+			if appendErr != nil {
+				log.Printf("[error] writing serial output to ptyout: %v\n", appendErr)
+			} else if update != nil {
+				scbus.MainUpdateBus.DoScreenUpdate(cmd.ScreenId, update)
+			}
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+	}
+	sc.close(false)
+	wsh.WithLock(func() {
+		wsh.SerialConsole = nil
+	})
+	go wsh.NotifyRemoteUpdate()
+	exitCode := 0
+	closeMsg := "\r\n[serial console closed]\r\n"
+	if !sc.Closed {
+		exitCode = 1
+		closeMsg = fmt.Sprintf("\r\n[serial console error: %v]\r\n", readErr)
+	}
+	update, err := sstore.AppendToCmdPtyBlob(ctx, cmd.ScreenId, cmd.LineId, []byte(closeMsg), outputPos)
+	if err == nil && update != nil {
+		scbus.MainUpdateBus.DoScreenUpdate(cmd.ScreenId, update)
+	}
+	doneUpdate := scbus.MakeUpdatePacket()
+	doneInfo := sstore.CmdDoneDataValues{
+		Ts:         time.Now().UnixMilli(),
+		ExitCode:   exitCode,
+		DurationMs: time.Since(startTime).Milliseconds(),
+	}
+	err = sstore.UpdateCmdDoneInfo(ctx, doneUpdate, sc.CK, doneInfo, sstore.CmdStatusDone)
+	if err != nil {
+		log.Printf("[error] updating serial console cmd done info: %v\n", err)
+		return
+	}
+	screen, err := sstore.UpdateScreenFocusForDoneCmd(ctx, cmd.ScreenId, cmd.LineId)
+	if err != nil {
+		log.Printf("[error] updating screen focus for serial console: %v\n", err)
+	}
+	if screen != nil {
+		doneUpdate.AddUpdate(*screen)
+	}
+	scbus.MainUpdateBus.DoScreenUpdate(cmd.ScreenId, doneUpdate)
+}
@@ -0,0 +1,23 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import "golang.org/x/sys/unix"
+
+const ioctlReadTermios = unix.TIOCGETA
+const ioctlWriteTermios = unix.TIOCSETA
+
+// macos takes the baud rate as the speed
+func getSerialSpeed(baudRate int) (uint64, bool) {
+	switch baudRate {
+	case 1200, 2400, 4800, 9600, 19200, 38400, 57600, 115200, 230400:
+		return uint64(baudRate), true
+	}
+	return 0, false
+}
+
+func setTermiosSpeed(termios *unix.Termios, speed uint64) {
+	termios.Ispeed = speed
+	termios.Ospeed = speed
+}
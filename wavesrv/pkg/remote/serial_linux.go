@@ -0,0 +1,38 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import "golang.org/x/sys/unix"
+
+const ioctlReadTermios = unix.TCGETS
+const ioctlWriteTermios = unix.TCSETS
+
+var serialSpeeds = map[int]uint32{
+	1200:    unix.B1200,
+	2400:    unix.B2400,
+	4800:    unix.B4800,
+	9600:    unix.B9600,
+	19200:   unix.B19200,
+	38400:   unix.B38400,
+	57600:   unix.B57600,
+	115200:  unix.B115200,
+	230400:  unix.B230400,
+	460800:  unix.B460800,
+	921600:  unix.B921600,
+	1000000: unix.B1000000,
+	2000000: unix.B2000000,
+}
+
+func getSerialSpeed(baudRate int) (uint32, bool) {
+	speed, ok := serialSpeeds[baudRate]
+	return speed, ok
+}
+
+// linux encodes the speed in the cflag (Ispeed/Ospeed are informational)
+func setTermiosSpeed(termios *unix.Termios, speed uint32) {
+	termios.Cflag &^= unix.CBAUD
+	termios.Cflag |= speed
+	termios.Ispeed = speed
+	termios.Ospeed = speed
+}
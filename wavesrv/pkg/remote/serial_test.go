@@ -0,0 +1,60 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"testing"
+
+	"github.com/creack/pty"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func TestValidateSerialOpts(t *testing.T) {
+	if err := ValidateSerialOpts(MakeDefaultSerialOpts()); err != nil {
+		t.Errorf("default serial opts should be valid: %v", err)
+	}
+	invalidOpts := []sstore.SerialOptsType{
+		{BaudRate: 12345, Parity: SerialParityNone, DataBits: 8, StopBits: 1},
+		{BaudRate: 9600, Parity: "mark", DataBits: 8, StopBits: 1},
+		{BaudRate: 9600, Parity: SerialParityEven, DataBits: 9, StopBits: 1},
+		{BaudRate: 9600, Parity: SerialParityOdd, DataBits: 7, StopBits: 3},
+	}
+	for _, opts := range invalidOpts {
+		if err := ValidateSerialOpts(&opts); err == nil {
+			t.Errorf("expected error for %#v", opts)
+		}
+	}
+	r := MakeSerialRemote("/dev/ttyUSB0", MakeDefaultSerialOpts())
+	if r.RemoteCanonicalName != "serial:///dev/ttyUSB0" || !r.IsSerial() || r.ConnectMode != sstore.ConnectModeManual {
+		t.Errorf("invalid serial remote: %#v", r)
+	}
+}
+
+// a pty stands in for the serial device
+func TestOpenSerialPort(t *testing.T) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		t.Skipf("cannot open pty: %v", err)
+	}
+	defer ptmx.Close()
+	defer tty.Close()
+	port, err := openSerialPort(tty.Name(), &sstore.SerialOptsType{BaudRate: 9600, Parity: SerialParityEven, DataBits: 7, StopBits: 2})
+	if err != nil {
+		t.Fatalf("error opening serial port: %v", err)
+	}
+	defer port.Close()
+	_, err = ptmx.Write([]byte("hello\r"))
+	if err != nil {
+		t.Fatalf("error writing to pty: %v", err)
+	}
+	buf := make([]byte, 100)
+	n, err := port.Read(buf)
+	if err != nil {
+		t.Fatalf("error reading serial port: %v", err)
+	}
+	// raw mode, the \r is not translated
+	if string(buf[:n]) != "hello\r" {
+		t.Errorf("invalid serial data %q", buf[:n])
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+	"golang.org/x/crypto/ssh"
+)
+
+// the ssh banner (sent by the server before authentication, usually a compliance notice) and the motd are
+// saved as a system line in the screen that started the connection (/remote:connect, or the auto-connect when
+// running a command), so they stay visible and searchable.  connections that were not started from a screen
+// (startup, automatic reconnects) only write the banner to the remote's pty buffer.
+
+const SystemLine_SshBanner = "sshbanner"
+
+const MaxSshBannerSize = 16 * 1024
+const MotdTimeout = 5 * time.Second
+
+// pam_motd shows these on login (waveshell does not run a login shell, so they are read separately)
+const motdCmd = `cat /run/motd.dynamic /etc/motd 2>/dev/null`
+
+func (wsh *WaveshellProc) addSshBanner(message string) {
+	if len(message) > MaxSshBannerSize {
+		message = message[:MaxSshBannerSize]
+	}
+	wsh.WithLock(func() {
+		wsh.sshBanner = message
+	})
+}
+
+// returns and clears the connect screenid and ssh banner
+func (wsh *WaveshellProc) takeConnectBanner() (string, string) {
+	var screenId, banner string
+	wsh.WithLock(func() {
+		screenId, banner = wsh.connectScreenId, wsh.sshBanner
+		wsh.connectScreenId = ""
+		wsh.sshBanner = ""
+	})
+	return screenId, banner
+}
+
+// screenId is the screen the banner/motd line is written to ("" for none)
+func (wsh *WaveshellProc) LaunchFromScreen(interactive bool, screenId string) {
+	wsh.WithLock(func() {
+		wsh.connectScreenId = screenId
+	})
+	defer wsh.WithLock(func() {
+		wsh.connectScreenId = ""
+	})
+	wsh.Launch(interactive)
+}
+
+// only for ssh remotes connected with the internal ssh client
+func (wsh *WaveshellProc) readMotd() string {
+	var client *ssh.Client
+	wsh.WithLock(func() {
+		client = wsh.Client
+	})
+	if client == nil {
+		return ""
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		return ""
+	}
+	defer session.Close()
+	var outBuf bytes.Buffer
+	session.Stdout = &outBuf
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- session.Run(motdCmd)
+	}()
+	select {
+	case <-doneCh:
+	case <-time.After(MotdTimeout):
+		return ""
+	}
+	motd := outBuf.String()
+	if len(motd) > MaxSshBannerSize {
+		motd = motd[:MaxSshBannerSize]
+	}
+	return motd
+}
+
+func formatConnectBanner(remoteName string, banner string, motd string) string {
+	banner = strings.TrimSpace(strings.ReplaceAll(banner, "\r\n", "\n"))
+	motd = strings.TrimSpace(strings.ReplaceAll(motd, "\r\n", "\n"))
+	var parts []string
+	if banner != "" {
+		parts = append(parts, fmt.Sprintf("ssh banner from %s:\n%s", remoteName, banner))
+	}
+	if motd != "" {
+		parts = append(parts, fmt.Sprintf("message of the day from %s:\n%s", remoteName, motd))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func (wsh *WaveshellProc) writeConnectBannerLine(screenId string, banner string) {
+	if strings.TrimSpace(banner) != "" {
+		wsh.WriteToPtyBuffer("%s\n", strings.TrimRight(banner, "\r\n"))
+	}
+	if screenId == "" {
+		return
+	}
+	remoteName := wsh.GetRemoteName()
+	text := formatConnectBanner(remoteName, banner, wsh.readMotd())
+	if text == "" {
+		return
+	}
+	lineState := map[string]any{sstore.LineState_System: SystemLine_SshBanner}
+	line, err := sstore.AddSystemLine(context.Background(), screenId, "user", text, lineState)
+	if err != nil {
+		log.Printf("[%s] error adding ssh banner line: %v\n", remoteName, err)
+		return
+	}
+	update := scbus.MakeUpdatePacket()
+	sstore.AddLineUpdate(update, line, nil)
+	scbus.MainUpdateBus.DoUpdate(update)
+}
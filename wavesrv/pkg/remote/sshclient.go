@@ -526,7 +526,8 @@ func DialContext(ctx context.Context, network string, addr string, config *ssh.C
 	return ssh.NewClient(c, chans, reqs), nil
 }
 
-func ConnectToClient(connCtx context.Context, opts *sstore.SSHOpts, remoteDisplayName string) (*ssh.Client, error) {
+// bannerFn (can be nil) receives the ssh banner (sent by the server before authentication)
+func ConnectToClient(connCtx context.Context, opts *sstore.SSHOpts, remoteDisplayName string, bannerFn func(string)) (*ssh.Client, error) {
 	sshConfigKeywords, err := findSshConfigKeywords(opts.SSHHost)
 	if err != nil {
 		return nil, err
@@ -586,6 +587,12 @@ func ConnectToClient(connCtx context.Context, opts *sstore.SSHOpts, remoteDispla
 		Auth:            authMethods,
 		HostKeyCallback: hostKeyCallback,
 	}
+	if bannerFn != nil {
+		clientConfig.BannerCallback = func(message string) error {
+			bannerFn(message)
+			return nil
+		}
+	}
 	networkAddr := sshKeywords.HostName + ":" + sshKeywords.Port
 	return DialContext(connCtx, "tcp", networkAddr, clientConfig)
 }
@@ -0,0 +1,132 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// sudo remote credentials.  the local sudo remote runs waveshell as root, the sudo password is prompted for when
+// it connects (see WaitAndSendPasswordNew).  the credential lifetime (remoteopts sudocredminutes, 0 is no limit)
+// starts when the remote connects.  once it is up (and no commands are running) the remote is disconnected, which
+// drops the root privileges, and the next command run on it reconnects, prompting for the password again.
+// /sudo:drop drops the privileges right away, the remote then has to be connected manually.
+
+const MaxSudoCredMinutes = 24 * 60
+const SudoCredCheckInterval = 5 * time.Second
+
+func getSudoCredMinutes(r *sstore.RemoteType) int {
+	if r.RemoteOpts == nil {
+		return 0
+	}
+	return r.RemoteOpts.SudoCredMinutes
+}
+
+// returns 0 if the credentials do not expire
+func getSudoCredExpireTs(authTs int64, credMinutes int) int64 {
+	if authTs == 0 || credMinutes <= 0 {
+		return 0
+	}
+	return authTs + int64(credMinutes)*60*1000
+}
+
+// returns nil for remotes that are not sudo remotes
+func (wsh *WaveshellProc) getSudoAuthState_nolock() *sstore.RemoteSudoAuthType {
+	if !wsh.Remote.IsSudo() {
+		return nil
+	}
+	rtn := &sstore.RemoteSudoAuthType{Status: wsh.sudoAuthStatus, CredMinutes: getSudoCredMinutes(wsh.Remote)}
+	if rtn.Status == "" || (rtn.Status == sstore.SudoAuthStatus_Active && wsh.Status != StatusConnected) {
+		rtn.Status = sstore.SudoAuthStatus_None
+	}
+	if rtn.Status == sstore.SudoAuthStatus_Active {
+		rtn.AuthTs = wsh.sudoAuthTs
+		rtn.ExpireTs = getSudoCredExpireTs(wsh.sudoAuthTs, rtn.CredMinutes)
+	}
+	return rtn
+}
+
+func (wsh *WaveshellProc) GetSudoAuthState() *sstore.RemoteSudoAuthType {
+	wsh.Lock.Lock()
+	defer wsh.Lock.Unlock()
+	return wsh.getSudoAuthState_nolock()
+}
+
+// called when the remote connects, starts the credential lifetime
+func (wsh *WaveshellProc) startSudoAuth_nolock() {
+	if !wsh.Remote.IsSudo() {
+		return
+	}
+	wsh.sudoAuthStatus = sstore.SudoAuthStatus_Active
+	wsh.sudoAuthTs = time.Now().UnixMilli()
+	wsh.sudoAuthGen++
+	go wsh.sudoCredLoop(wsh.sudoAuthGen)
+}
+
+// the lifetime is read from the remote on every check, so changes apply to the current connection
+func (wsh *WaveshellProc) sudoCredLoop(gen int) {
+	for {
+		time.Sleep(SudoCredCheckInterval)
+		numCmds := wsh.GetNumRunningCommands()
+		var done, expired bool
+		wsh.WithLock(func() {
+			if wsh.sudoAuthGen != gen || wsh.sudoAuthStatus != sstore.SudoAuthStatus_Active || wsh.Status != StatusConnected {
+				done = true
+				return
+			}
+			expireTs := getSudoCredExpireTs(wsh.sudoAuthTs, getSudoCredMinutes(wsh.Remote))
+			// running commands are not killed, the privileges are dropped once they are done
+			expired = expireTs > 0 && time.Now().UnixMilli() >= expireTs && numCmds == 0
+		})
+		if done {
+			return
+		}
+		if expired {
+			wsh.DropSudoPrivileges(sstore.SudoAuthStatus_Expired)
+			return
+		}
+	}
+}
+
+// returns "" for remotes that are not sudo remotes
+func (wsh *WaveshellProc) getSudoAuthStatus() string {
+	wsh.Lock.Lock()
+	defer wsh.Lock.Unlock()
+	if !wsh.Remote.IsSudo() {
+		return ""
+	}
+	return wsh.sudoAuthStatus
+}
+
+// disconnects the sudo remote (force, running commands are ended) and clears its cached sudo password.
+// status is SudoAuthStatus_Expired (reconnects on the next command) or SudoAuthStatus_Dropped.
+func (wsh *WaveshellProc) DropSudoPrivileges(status string) error {
+	if !wsh.IsSudo() {
+		return fmt.Errorf("%s is not a sudo remote", wsh.GetRemoteName())
+	}
+	wsh.WithLock(func() {
+		wsh.sudoAuthStatus = status
+		wsh.sudoAuthGen++
+		wsh.sudoPw = nil
+		wsh.sudoClearDeadline = 0
+		if status == sstore.SudoAuthStatus_Expired {
+			// an expiry is not a failed connection attempt (see TryAutoConnect)
+			wsh.NumTryConnect = 0
+		}
+	})
+	curStatus := wsh.GetStatus()
+	if curStatus == StatusConnected || curStatus == StatusConnecting {
+		wsh.Disconnect(true)
+	}
+	if status == sstore.SudoAuthStatus_Expired {
+		wsh.WriteToPtyBuffer("sudo credentials expired, privileges dropped (the password will be asked for again on the next command)\n")
+	} else {
+		wsh.WriteToPtyBuffer("sudo privileges dropped\n")
+	}
+	go wsh.NotifyRemoteUpdate()
+	return nil
+}
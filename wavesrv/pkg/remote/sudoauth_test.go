@@ -0,0 +1,48 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+func TestSudoCredExpireTs(t *testing.T) {
+	if ts := getSudoCredExpireTs(1000, 0); ts != 0 {
+		t.Errorf("no lifetime should not expire, got %d", ts)
+	}
+	if ts := getSudoCredExpireTs(0, 5); ts != 0 {
+		t.Errorf("unauthenticated should not expire, got %d", ts)
+	}
+	if ts := getSudoCredExpireTs(1000, 5); ts != 1000+5*60*1000 {
+		t.Errorf("bad expire ts %d", ts)
+	}
+}
+
+func TestSudoAuthState(t *testing.T) {
+	remote := &sstore.RemoteType{SSHOpts: &sstore.SSHOpts{Local: true, IsSudo: true}, RemoteOpts: &sstore.RemoteOptsType{SudoCredMinutes: 10}}
+	wsh := &WaveshellProc{Lock: &sync.Mutex{}, Remote: remote, Status: StatusConnected}
+	if state := wsh.GetSudoAuthState(); state.Status != sstore.SudoAuthStatus_None || state.CredMinutes != 10 {
+		t.Errorf("expected none, got %#v", state)
+	}
+	wsh.sudoAuthStatus = sstore.SudoAuthStatus_Active
+	wsh.sudoAuthTs = 1000
+	if state := wsh.GetSudoAuthState(); state.Status != sstore.SudoAuthStatus_Active || state.ExpireTs != 1000+10*60*1000 {
+		t.Errorf("expected active, got %#v", state)
+	}
+	wsh.Status = StatusDisconnected
+	if state := wsh.GetSudoAuthState(); state.Status != sstore.SudoAuthStatus_None {
+		t.Errorf("disconnected remote should be none, got %#v", state)
+	}
+	wsh.sudoAuthStatus = sstore.SudoAuthStatus_Expired
+	if state := wsh.GetSudoAuthState(); state.Status != sstore.SudoAuthStatus_Expired || state.ExpireTs != 0 {
+		t.Errorf("expected expired, got %#v", state)
+	}
+	wsh.Remote = &sstore.RemoteType{Local: true}
+	if state := wsh.GetSudoAuthState(); state != nil {
+		t.Errorf("non-sudo remote should have no sudo state, got %#v", state)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// WSL remotes (RemoteTypeWSL) run waveshell inside a WSL distribution by exec'ing wsl.exe instead of ssh.
+// the distro is stored as the remote's host (and the optional user as the remote's user), everything
+// else (state tracking, install, connect modes) works the same as for ssh remotes.
+
+const WSLExe = "wsl.exe"
+const WSLCanonicalPrefix = "wsl://"
+
+func MakeWSLCanonicalName(distro string, user string) string {
+	if user == "" {
+		return WSLCanonicalPrefix + distro
+	}
+	return WSLCanonicalPrefix + user + "@" + distro
+}
+
+func MakeWSLRemote(distro string, user string) *sstore.RemoteType {
+	return &sstore.RemoteType{
+		RemoteType:          sstore.RemoteTypeWSL,
+		RemoteCanonicalName: MakeWSLCanonicalName(distro, user),
+		RemoteUser:          user,
+		RemoteHost:          distro,
+		ConnectMode:         sstore.ConnectModeAuto,
+		AutoInstall:         true,
+		SSHOpts:             &sstore.SSHOpts{SSHUser: user},
+		SSHConfigSrc:        sstore.SSHConfigSrcTypeManual,
+		ShellPref:           sstore.ShellTypePref_Detect,
+	}
+}
+
+// runs cmdStr with shellPath inside the remote's distro
+func MakeWSLExecCmd(remoteCopy sstore.RemoteType, shellPath string, cmdStr string) *exec.Cmd {
+	args := []string{"-d", remoteCopy.RemoteHost}
+	if remoteCopy.RemoteUser != "" {
+		args = append(args, "-u", remoteCopy.RemoteUser)
+	}
+	args = append(args, "--", shellPath, "-c", cmdStr)
+	ecmd := exec.Command(WSLExe, args...)
+	ecmd.Env = append(os.Environ(), "WSL_UTF8=1")
+	return ecmd
+}
+
+// wsl.exe writes utf-16le unless WSL_UTF8 is set (only supported by newer versions), so handle both
+func decodeWSLOutput(output []byte) string {
+	if len(output) >= 2 && len(output)%2 == 0 && (output[1] == 0 || (output[0] == 0xff && output[1] == 0xfe)) {
+		u16 := make([]uint16, 0, len(output)/2)
+		for i := 0; i+1 < len(output); i += 2 {
+			u16 = append(u16, uint16(output[i])|uint16(output[i+1])<<8)
+		}
+		return strings.TrimPrefix(string(utf16.Decode(u16)), "\ufeff")
+	}
+	return string(output)
+}
+
+func ParseWSLDistroList(output []byte) []string {
+	var rtn []string
+	for _, line := range strings.Split(decodeWSLOutput(output), "\n") {
+		distro := strings.TrimSpace(strings.ReplaceAll(line, "\x00", ""))
+		if distro != "" {
+			rtn = append(rtn, distro)
+		}
+	}
+	return rtn
+}
+
+// returns the installed WSL distributions (wsl.exe --list --quiet)
+func ListWSLDistros(ctx context.Context) ([]string, error) {
+	if _, err := exec.LookPath(WSLExe); err != nil {
+		return nil, fmt.Errorf("%s not found, WSL is not available on this machine", WSLExe)
+	}
+	ecmd := exec.CommandContext(ctx, WSLExe, "--list", "--quiet")
+	ecmd.Env = append(os.Environ(), "WSL_UTF8=1")
+	output, err := ecmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing WSL distributions: %w", err)
+	}
+	return ParseWSLDistroList(output), nil
+}
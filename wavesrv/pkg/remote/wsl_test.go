@@ -0,0 +1,43 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package remote
+
+import (
+	"reflect"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestParseWSLDistroList(t *testing.T) {
+	expected := []string{"Ubuntu-22.04", "docker-desktop"}
+	utf8Output := []byte("Ubuntu-22.04\r\ndocker-desktop\r\n\r\n")
+	if distros := ParseWSLDistroList(utf8Output); !reflect.DeepEqual(distros, expected) {
+		t.Errorf("utf-8 distros=%#v", distros)
+	}
+	var utf16Output []byte
+	for _, ch := range utf16.Encode([]rune("\ufeffUbuntu-22.04\r\ndocker-desktop\r\n")) {
+		utf16Output = append(utf16Output, byte(ch), byte(ch>>8))
+	}
+	if distros := ParseWSLDistroList(utf16Output); !reflect.DeepEqual(distros, expected) {
+		t.Errorf("utf-16 distros=%#v", distros)
+	}
+	if distros := ParseWSLDistroList(nil); len(distros) != 0 {
+		t.Errorf("expected no distros, got %#v", distros)
+	}
+}
+
+func TestWSLRemote(t *testing.T) {
+	r := MakeWSLRemote("Ubuntu", "mike")
+	if !r.IsWSL() || r.RemoteCanonicalName != "wsl://mike@Ubuntu" || r.RemoteHost != "Ubuntu" {
+		t.Errorf("invalid wsl remote: %#v", r)
+	}
+	ecmd := MakeWSLExecCmd(*r, "bash", "echo hi")
+	expectedArgs := []string{WSLExe, "-d", "Ubuntu", "-u", "mike", "--", "bash", "-c", "echo hi"}
+	if !reflect.DeepEqual(ecmd.Args, expectedArgs) {
+		t.Errorf("wsl args=%#v", ecmd.Args)
+	}
+	if name := MakeWSLCanonicalName("Debian", ""); name != "wsl://Debian" {
+		t.Errorf("canonical name=%q", name)
+	}
+}
@@ -184,6 +184,22 @@ func makeZshFuncsDiff(buf *bytes.Buffer, oldFuncs string, newFuncs string) {
 	}
 }
 
+// for pwsh and fish aliases/abbrs/functions (maps are name => entry)
+func makeShellEntriesDiff(buf *bytes.Buffer, entryType string, oldEntryMap map[string]string, newEntryMap map[string]string) {
+	for name, newVal := range newEntryMap {
+		oldVal, found := oldEntryMap[name]
+		if !found || newVal != oldVal {
+			buf.WriteString(fmt.Sprintf("%s %s\n", entryType, utilfn.EllipsisStr(name, MaxDiffKeyLen)))
+		}
+	}
+	for name := range oldEntryMap {
+		_, found := newEntryMap[name]
+		if !found {
+			buf.WriteString(fmt.Sprintf("remove %s %s\n", entryType, utilfn.EllipsisStr(name, MaxDiffKeyLen)))
+		}
+	}
+}
+
 func DisplayStateUpdateDiff(buf *bytes.Buffer, oldState packet.ShellState, newState packet.ShellState) {
 	if newState.Cwd != oldState.Cwd {
 		buf.WriteString(fmt.Sprintf("cwd %s\n", newState.Cwd))
@@ -217,6 +233,12 @@ func DisplayStateUpdateDiff(buf *bytes.Buffer, oldState packet.ShellState, newSt
 	if newState.GetShellType() == packet.ShellType_zsh {
 		makeZshAlisesDiff(buf, oldState.Aliases, newState.Aliases)
 		makeZshFuncsDiff(buf, oldState.Funcs, newState.Funcs)
+	} else if newState.GetShellType() == packet.ShellType_pwsh {
+		makeShellEntriesDiff(buf, "alias", shellapi.DecodePwshEntries(oldState.Aliases), shellapi.DecodePwshEntries(newState.Aliases))
+		makeShellEntriesDiff(buf, "function", shellapi.DecodePwshEntries(oldState.Funcs), shellapi.DecodePwshEntries(newState.Funcs))
+	} else if newState.GetShellType() == packet.ShellType_fish {
+		makeShellEntriesDiff(buf, "abbr", shellapi.DecodeFishEntries(oldState.Aliases), shellapi.DecodeFishEntries(newState.Aliases))
+		makeShellEntriesDiff(buf, "function", shellapi.DecodeFishEntries(oldState.Funcs), shellapi.DecodeFishEntries(newState.Funcs))
 	} else {
 		makeBashAliasesDiff(buf, oldState.Aliases, newState.Aliases)
 		makeBashFuncsDiff(newState, oldState, buf)
@@ -0,0 +1,62 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package scbase
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/base"
+)
+
+// read-only (maintenance/browse) mode.  commands and apis that change data return a read-only error
+// (ErrorCode_ReadOnly), and the db migrations, startup fixups and background jobs that write do not run.
+// enabled at startup with WAVETERM_READONLY=1 or --readonly (e.g. to inspect a copy of a wave home dir),
+// or toggled at runtime with /client:readonly (e.g. during a backup).
+
+const WaveReadOnlyVarName = "WAVETERM_READONLY"
+const ReadOnlyArg = "--readonly"
+const ErrorCode_ReadOnly = "ERRREADONLY" // must match appconst.ts
+
+var readOnly atomic.Bool
+
+// sets read-only mode from the environment and the command line args
+func InitReadOnly(args []string) {
+	envVal, _ := strconv.ParseBool(os.Getenv(WaveReadOnlyVarName))
+	if envVal {
+		readOnly.Store(true)
+	}
+	for _, arg := range args {
+		if arg == ReadOnlyArg {
+			readOnly.Store(true)
+		}
+	}
+}
+
+func IsReadOnly() bool {
+	return readOnly.Load()
+}
+
+func SetReadOnly(val bool) {
+	readOnly.Store(val)
+}
+
+func MakeReadOnlyError(op string) error {
+	return base.CodedErrorf(ErrorCode_ReadOnly, "%s is not allowed in read-only mode", op)
+}
+
+// returns a read-only error for op if in read-only mode
+func CheckReadOnly(op string) error {
+	if IsReadOnly() {
+		return MakeReadOnlyError(op)
+	}
+	return nil
+}
+
+func IsReadOnlyError(err error) bool {
+	var codedErr *base.CodedError
+	return errors.As(err, &codedErr) && codedErr.ErrorCode == ErrorCode_ReadOnly
+}
@@ -255,6 +255,12 @@ func EnsureConfigDirs() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	// default user completion spec dir (fig or carapace json), see newton/spec.go
+	completionSpecsDir := filepath.Join(configDir, "completion-specs")
+	err = ensureDir(completionSpecsDir)
+	if err != nil {
+		return "", err
+	}
 	return configDir, nil
 }
 
@@ -313,6 +319,7 @@ func PtyOutFile_Sessions(sessionId string, cmdId string) (string, error) {
 	return fmt.Sprintf("%s/%s.ptyout.cf", sdir, cmdId), nil
 }
 
+// deprecated, ptyout data is stored in the blockstore (only used to migrate the legacy files)
 func PtyOutFile(screenId string, lineId string) (string, error) {
 	sdir, err := EnsureScreenDir(screenId)
 	if err != nil {
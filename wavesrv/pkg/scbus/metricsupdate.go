@@ -0,0 +1,52 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package scbus
+
+import (
+	"reflect"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+)
+
+const RemoteMetricsUpdateStr = "remotemetrics"
+
+// A system metrics sample of a connected remote (see remote/metrics.go)
+type RemoteMetrics struct {
+	RemoteId  string    `json:"remoteid"`
+	Ts        int64     `json:"ts"`
+	CpuPct    float64   `json:"cpupct"` // -1 if not available (first sample, or not supported by the remote)
+	NumCpu    int       `json:"numcpu"`
+	MemTotal  int64     `json:"memtotal"`
+	MemUsed   int64     `json:"memused"`
+	LoadAvg   []float64 `json:"loadavg,omitempty"`
+	DiskFree  int64     `json:"diskfree"`
+	DiskTotal int64     `json:"disktotal"`
+}
+
+// An UpdatePacket for streaming remote metrics samples to the client
+type RemoteMetricsUpdatePacketType struct {
+	Type string         `json:"type"`
+	Data *RemoteMetrics `json:"data"`
+}
+
+func (*RemoteMetricsUpdatePacketType) GetType() string {
+	return RemoteMetricsUpdateStr
+}
+
+func (rmu *RemoteMetricsUpdatePacketType) Clean() {
+	// no-op, required to satisfy the UpdatePacket interface
+}
+
+func (rmu *RemoteMetricsUpdatePacketType) IsEmpty() bool {
+	return rmu == nil || rmu.Data == nil
+}
+
+func MakeRemoteMetricsUpdate(metrics *RemoteMetrics) *RemoteMetricsUpdatePacketType {
+	return &RemoteMetricsUpdatePacketType{Type: RemoteMetricsUpdateStr, Data: metrics}
+}
+
+func init() {
+	packet.RegisterPacketType(RemoteMetricsUpdateStr, reflect.TypeOf(RemoteMetricsUpdatePacketType{}))
+	RegisterUpdatePacket((*RemoteMetricsUpdatePacketType)(nil))
+}
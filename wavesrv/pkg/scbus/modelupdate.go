@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"sync"
 
 	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
 )
@@ -80,16 +81,29 @@ func (upk *ModelUpdatePacketType) IsEmpty() bool {
 	return upk.Data.IsEmpty()
 }
 
-// Clean the ClientData in an update, if present
+// Clean the ClientData in an update, if present.  Also runs the registered update item filters.
 func (upk *ModelUpdatePacketType) Clean() {
 	if upk.IsEmpty() {
 		return
 	}
+	filters := getUpdateItemFilters()
+	newItems := make(ModelUpdate, 0, len(*(upk.Data)))
 	for _, item := range *(upk.Data) {
+		for _, filterFn := range filters {
+			if item == nil {
+				break
+			}
+			item = filterFn(item)
+		}
+		if item == nil {
+			continue
+		}
 		if i, ok := (item).(CleanableUpdateItem); ok {
 			i.Clean()
 		}
+		newItems = append(newItems, item)
 	}
+	*(upk.Data) = newItems
 }
 
 // Add a collection of model updates to the update
@@ -134,7 +148,28 @@ type CleanableUpdateItem interface {
 	Clean()
 }
 
+// A filter that runs (in Clean) on every item of a model update before it is sent to the client.  It returns the
+// item to send (nil drops the item).  Items can be shared with other updates, so a filter must return a modified
+// copy instead of changing the item.
+type UpdateItemFilter func(item ModelUpdateItem) ModelUpdateItem
+
+var updateItemFiltersLock = &sync.Mutex{}
+var updateItemFilters []UpdateItemFilter
+
+func RegisterUpdateItemFilter(fn UpdateItemFilter) {
+	updateItemFiltersLock.Lock()
+	defer updateItemFiltersLock.Unlock()
+	updateItemFilters = append(updateItemFilters, fn)
+}
+
+func getUpdateItemFilters() []UpdateItemFilter {
+	updateItemFiltersLock.Lock()
+	defer updateItemFiltersLock.Unlock()
+	return updateItemFilters
+}
+
 func init() {
 	// Register the model update packet type
 	packet.RegisterPacketType(ModelUpdateStr, reflect.TypeOf(ModelUpdatePacketType{}))
+	RegisterUpdatePacket((*ModelUpdatePacketType)(nil))
 }
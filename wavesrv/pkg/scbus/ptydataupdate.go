@@ -4,6 +4,7 @@
 package scbus
 
 import (
+	"encoding/base64"
 	"reflect"
 
 	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
@@ -19,6 +20,38 @@ type PtyDataUpdate struct {
 	PtyPos     int64  `json:"ptypos"`
 	PtyData64  string `json:"ptydata64"`
 	PtyDataLen int64  `json:"ptydatalen"`
+
+	RenderHint *PtyRenderHint `json:"renderhint,omitempty"`
+
+	// the raw pty data.  producers set PtyData (owned by the update, never modified after it is sent) and leave
+	// PtyData64 empty, it is only encoded for the json clients (see WithPtyData64), binary clients get the raw bytes.
+	PtyData []byte `json:"-"`
+}
+
+// returns the raw pty data (decodes PtyData64 if PtyData is not set)
+func (pdu *PtyDataUpdate) GetPtyData() ([]byte, error) {
+	if pdu.PtyData != nil {
+		return pdu.PtyData, nil
+	}
+	return base64.StdEncoding.DecodeString(pdu.PtyData64)
+}
+
+// returns the base64 pty data (encodes PtyData if PtyData64 is not set)
+func (pdu *PtyDataUpdate) GetPtyData64() string {
+	if pdu.PtyData64 != "" || pdu.PtyData == nil {
+		return pdu.PtyData64
+	}
+	return base64.StdEncoding.EncodeToString(pdu.PtyData)
+}
+
+// Sent with the pty data of commands in an output burst (see sstore/ptyflow.go).  Clients can hold off on
+// rendering work (up to HoldMs) while more output is expected.
+type PtyRenderHint struct {
+	Burst       bool  `json:"burst"`
+	BurstBytes  int64 `json:"burstbytes"`  // output so far in this burst
+	RateBps     int64 `json:"ratebps"`     // bytes per second over the burst
+	ExpectBytes int64 `json:"expectbytes"` // estimated output still to come
+	HoldMs      int64 `json:"holdms"`
 }
 
 // An UpdatePacket for sending pty data to the client
@@ -44,7 +77,19 @@ func MakePtyDataUpdate(update *PtyDataUpdate) *PtyDataUpdatePacketType {
 	return &PtyDataUpdatePacketType{Type: PtyDataUpdateStr, Data: update}
 }
 
+// returns the update to marshal as json (a copy with PtyData64 set).  the update itself is shared by all the
+// update channels, so it is not modified.
+func (pdu *PtyDataUpdatePacketType) WithPtyData64() *PtyDataUpdatePacketType {
+	if pdu.Data == nil || pdu.Data.PtyData64 != "" {
+		return pdu
+	}
+	dataCopy := *pdu.Data
+	dataCopy.PtyData64 = pdu.Data.GetPtyData64()
+	return &PtyDataUpdatePacketType{Type: pdu.Type, Data: &dataCopy}
+}
+
 func init() {
 	// Register the PtyDataUpdatePacketType with the packet package
 	packet.RegisterPacketType(PtyDataUpdateStr, reflect.TypeOf(PtyDataUpdatePacketType{}))
+	RegisterUpdatePacket((*PtyDataUpdatePacketType)(nil))
 }
@@ -114,6 +114,7 @@ func (bus *UpdateBus) DoUpdate(update UpdatePacket) {
 		return
 	}
 	update.Clean()
+	CheckUpdate(update)
 	bus.Lock.Lock()
 	defer bus.Lock.Unlock()
 	for key, uch := range bus.Channels {
@@ -132,6 +133,7 @@ func (bus *UpdateBus) DoScreenUpdate(screenId string, update UpdatePacket) {
 		return
 	}
 	update.Clean()
+	CheckUpdate(update)
 	bus.Lock.Lock()
 	defer bus.Lock.Unlock()
 	for id, uch := range bus.Channels {
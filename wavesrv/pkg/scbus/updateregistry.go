@@ -0,0 +1,139 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package scbus
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// A registry of the update types sent to the frontend, keyed by GetType.  Packages register their model update
+// items and update packets in init().  The registry is used to validate the json of the updates against the
+// schema of their types (in debug builds, built with -tags debug, see CheckUpdate) and to generate the
+// typescript definitions of the updates (see GenerateTsDefs and cmd/gentypes), so drift between the backend
+// and the frontend is caught before the renderer gets an update it cannot handle.
+
+var updateRegistryLock = &sync.Mutex{}
+var modelUpdateItemTypes = make(map[string]reflect.Type)
+var updatePacketTypes = make(map[string]reflect.Type)
+var updateSchemaCache *updateSchema
+
+func registerUpdateType(registry map[string]reflect.Type, key string, rtype reflect.Type) {
+	updateRegistryLock.Lock()
+	defer updateRegistryLock.Unlock()
+	if rtype.Kind() == reflect.Pointer {
+		rtype = rtype.Elem()
+	}
+	if oldType, found := registry[key]; found && oldType != rtype {
+		panic(fmt.Sprintf("update type %q registered twice (%v and %v)", key, oldType, rtype))
+	}
+	registry[key] = rtype
+	updateSchemaCache = nil
+}
+
+// Registers the types of model update items (samples can be zero values, or nil pointers for pointer receivers)
+func RegisterModelUpdateItem(items ...ModelUpdateItem) {
+	for _, item := range items {
+		registerUpdateType(modelUpdateItemTypes, item.GetType(), reflect.TypeOf(item))
+	}
+}
+
+// Registers the types of update packets (the top level updates sent over an UpdateChannel)
+func RegisterUpdatePacket(pks ...UpdatePacket) {
+	for _, pk := range pks {
+		registerUpdateType(updatePacketTypes, pk.GetType(), reflect.TypeOf(pk))
+	}
+}
+
+func sortedTypeKeys(registry map[string]reflect.Type) []string {
+	keys := make([]string, 0, len(registry))
+	for key := range registry {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Returns the model update item keys (sorted)
+func GetModelUpdateItemKeys() []string {
+	updateRegistryLock.Lock()
+	defer updateRegistryLock.Unlock()
+	return sortedTypeKeys(modelUpdateItemTypes)
+}
+
+func getUpdateSchema() *updateSchema {
+	updateRegistryLock.Lock()
+	defer updateRegistryLock.Unlock()
+	if updateSchemaCache == nil {
+		updateSchemaCache = makeUpdateSchema(modelUpdateItemTypes, updatePacketTypes)
+	}
+	return updateSchemaCache
+}
+
+// Returns the json schema of the registered update packets (a def per struct type in $defs)
+func GetUpdateJsonSchema() map[string]any {
+	return getUpdateSchema().jsonSchema()
+}
+
+func checkModelUpdateItems(upk *ModelUpdatePacketType) error {
+	updateRegistryLock.Lock()
+	defer updateRegistryLock.Unlock()
+	for idx, item := range *upk.Data {
+		if item == nil {
+			return fmt.Errorf("data[%d]: nil model update item", idx)
+		}
+		rtype, found := modelUpdateItemTypes[item.GetType()]
+		if !found {
+			return fmt.Errorf("data[%d]: unregistered model update item %q (%T)", idx, item.GetType(), item)
+		}
+		itemType := reflect.TypeOf(item)
+		if itemType.Kind() == reflect.Pointer {
+			itemType = itemType.Elem()
+		}
+		if itemType != rtype {
+			return fmt.Errorf("data[%d]: model update item %q is a %v (registered as %v)", idx, item.GetType(), itemType, rtype)
+		}
+	}
+	return nil
+}
+
+// Validates the json of the update against the schema of its registered type
+func ValidateUpdate(update UpdatePacket) error {
+	if upk, ok := update.(*ModelUpdatePacketType); ok && !upk.IsEmpty() {
+		err := checkModelUpdateItems(upk)
+		if err != nil {
+			return err
+		}
+	}
+	schema := getUpdateSchema()
+	defName, found := schema.PacketDefs[update.GetType()]
+	if !found {
+		return fmt.Errorf("unregistered update packet %q (%T)", update.GetType(), update)
+	}
+	barr, err := json.Marshal(update)
+	if err != nil {
+		return fmt.Errorf("cannot marshal: %v", err)
+	}
+	var val any
+	err = json.Unmarshal(barr, &val)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal: %v", err)
+	}
+	return schema.validate(schemaRef(defName), val, "")
+}
+
+// Logs the validation errors of the update (only in debug builds, a no-op otherwise)
+func CheckUpdate(update UpdatePacket) {
+	if !ValidateUpdates || update == nil {
+		return
+	}
+	err := ValidateUpdate(update)
+	if err != nil {
+		log.Printf("[scbus] invalid %q update: %v\n", update.GetType(), err)
+	}
+}
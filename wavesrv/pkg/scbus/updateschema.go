@@ -0,0 +1,372 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package scbus
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// json schemas of the registered update types (generated from the go types with the encoding/json rules).
+// struct types get a def (named by the go type, prefixed with the package name if the name is taken), other
+// types are inlined.  types with a custom MarshalJSON accept any json.  the validator only supports the subset
+// of json schema the generator uses.
+
+const ModelUpdateItemDefName = "ModelUpdateItemType"
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+var timeType = reflect.TypeOf(time.Time{})
+var modelUpdateType = reflect.TypeOf(ModelUpdate{})
+
+type updateSchema struct {
+	Defs        map[string]map[string]any
+	PacketDefs  map[string]string       // packet key => def name
+	StructTypes map[string]reflect.Type // def name => struct type
+	TypeNames   map[reflect.Type]string
+	ItemTypes   map[string]reflect.Type // model update item key => type
+}
+
+// a json field of a struct (embedded structs are flattened)
+type jsonField struct {
+	Name     string
+	Type     reflect.Type
+	Optional bool // omitempty (or promoted from an embedded pointer)
+	AsString bool // ",string" option
+}
+
+func schemaRef(defName string) map[string]any {
+	return map[string]any{"$ref": "#/$defs/" + defName}
+}
+
+func nullableSchema(schema map[string]any) map[string]any {
+	switch typeVal := schema["type"].(type) {
+	case string:
+		rtn := make(map[string]any, len(schema))
+		for key, val := range schema {
+			rtn[key] = val
+		}
+		rtn["type"] = []string{typeVal, "null"}
+		return rtn
+	case []string:
+		return schema
+	}
+	if len(schema) == 0 {
+		// any
+		return schema
+	}
+	return map[string]any{"anyOf": []any{schema, map[string]any{"type": "null"}}}
+}
+
+func getJsonFields(rtype reflect.Type) []jsonField {
+	var rtn []jsonField
+	addStructJsonFields(rtype, false, &rtn)
+	return rtn
+}
+
+func addStructJsonFields(rtype reflect.Type, optional bool, fields *[]jsonField) {
+	for i := 0; i < rtype.NumField(); i++ {
+		field := rtype.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			fieldType := field.Type
+			embeddedPtr := fieldType.Kind() == reflect.Pointer
+			if embeddedPtr {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				addStructJsonFields(fieldType, optional || embeddedPtr, fields)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		omitEmpty := strings.Contains(","+opts+",", ",omitempty,")
+		asString := strings.Contains(","+opts+",", ",string,")
+		// omitempty does not omit structs
+		fieldOptional := optional || (omitEmpty && field.Type.Kind() != reflect.Struct)
+		*fields = append(*fields, jsonField{Name: name, Type: field.Type, Optional: fieldOptional, AsString: asString})
+	}
+}
+
+func makeUpdateSchema(itemTypes map[string]reflect.Type, packetTypes map[string]reflect.Type) *updateSchema {
+	schema := &updateSchema{
+		Defs:        make(map[string]map[string]any),
+		PacketDefs:  make(map[string]string),
+		StructTypes: make(map[string]reflect.Type),
+		TypeNames:   make(map[reflect.Type]string),
+		ItemTypes:   make(map[string]reflect.Type),
+	}
+	itemProps := make(map[string]any)
+	for _, key := range sortedTypeKeys(itemTypes) {
+		schema.ItemTypes[key] = itemTypes[key]
+		itemProps[key] = schema.typeSchema(itemTypes[key])
+	}
+	schema.Defs[ModelUpdateItemDefName] = map[string]any{
+		"type":                 "object",
+		"properties":           itemProps,
+		"additionalProperties": false,
+		"minProperties":        1,
+		"maxProperties":        1,
+	}
+	for _, key := range sortedTypeKeys(packetTypes) {
+		schema.typeSchema(packetTypes[key])
+		schema.PacketDefs[key] = schema.TypeNames[packetTypes[key]]
+	}
+	return schema
+}
+
+func (schema *updateSchema) jsonSchema() map[string]any {
+	packetKeys := make([]string, 0, len(schema.PacketDefs))
+	for key := range schema.PacketDefs {
+		packetKeys = append(packetKeys, key)
+	}
+	sort.Strings(packetKeys)
+	var packetRefs []any
+	for _, key := range packetKeys {
+		packetRefs = append(packetRefs, schemaRef(schema.PacketDefs[key]))
+	}
+	return map[string]any{
+		"$schema": jsonSchemaDraft,
+		"$defs":   schema.Defs,
+		"oneOf":   packetRefs,
+	}
+}
+
+// names the struct type (the go name, prefixed with the package name if another type has the name)
+func (schema *updateSchema) defName(rtype reflect.Type) string {
+	if name, found := schema.TypeNames[rtype]; found {
+		return name
+	}
+	name := rtype.Name()
+	if _, taken := schema.StructTypes[name]; taken || name == ModelUpdateItemDefName {
+		pkgName := path.Base(rtype.PkgPath())
+		name = strings.ToUpper(pkgName[:1]) + pkgName[1:] + name
+	}
+	schema.TypeNames[rtype] = name
+	schema.StructTypes[name] = rtype
+	return name
+}
+
+func (schema *updateSchema) typeSchema(rtype reflect.Type) map[string]any {
+	if rtype.Kind() == reflect.Pointer {
+		return nullableSchema(schema.typeSchema(rtype.Elem()))
+	}
+	if rtype == modelUpdateType {
+		return map[string]any{"type": "array", "items": schemaRef(ModelUpdateItemDefName)}
+	}
+	if rtype == timeType {
+		return map[string]any{"type": "string"}
+	}
+	if rtype.Implements(jsonMarshalerType) || reflect.PointerTo(rtype).Implements(jsonMarshalerType) {
+		return map[string]any{}
+	}
+	switch rtype.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice:
+		if rtype.Elem().Kind() == reflect.Uint8 {
+			// base64
+			return map[string]any{"type": []string{"string", "null"}}
+		}
+		return map[string]any{"type": []string{"array", "null"}, "items": schema.typeSchema(rtype.Elem())}
+	case reflect.Array:
+		return map[string]any{"type": "array", "items": schema.typeSchema(rtype.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": []string{"object", "null"}, "additionalProperties": schema.typeSchema(rtype.Elem())}
+	case reflect.Struct:
+		if rtype.Name() == "" {
+			return schema.structSchema(rtype)
+		}
+		name := schema.defName(rtype)
+		if _, found := schema.Defs[name]; !found {
+			// set first for recursive types
+			schema.Defs[name] = nil
+			schema.Defs[name] = schema.structSchema(rtype)
+		}
+		return schemaRef(name)
+	}
+	// interfaces (and types encoding/json cannot marshal)
+	return map[string]any{}
+}
+
+func (schema *updateSchema) structSchema(rtype reflect.Type) map[string]any {
+	props := make(map[string]any)
+	var required []string
+	for _, field := range getJsonFields(rtype) {
+		if field.AsString {
+			props[field.Name] = map[string]any{"type": "string"}
+		} else {
+			props[field.Name] = schema.typeSchema(field.Type)
+		}
+		if !field.Optional {
+			required = append(required, field.Name)
+		}
+	}
+	rtn := map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+	if len(required) > 0 {
+		sort.Strings(required)
+		rtn["required"] = required
+	}
+	return rtn
+}
+
+func jsonTypeOf(val any) string {
+	switch typedVal := val.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if typedVal == math.Trunc(typedVal) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", val)
+}
+
+func typeMatches(schemaType string, jsonType string) bool {
+	return schemaType == jsonType || (schemaType == "number" && jsonType == "integer")
+}
+
+func displayPath(valPath string) string {
+	if valPath == "" {
+		return "(root)"
+	}
+	return valPath
+}
+
+func joinSchemaPath(parent string, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+// validates the (unmarshaled) json value against the schema, valPath is for the error messages
+func (schema *updateSchema) validate(subSchema map[string]any, val any, valPath string) error {
+	if ref, ok := subSchema["$ref"].(string); ok {
+		defName := strings.TrimPrefix(ref, "#/$defs/")
+		def, found := schema.Defs[defName]
+		if !found {
+			return fmt.Errorf("%s: unknown schema ref %q", displayPath(valPath), ref)
+		}
+		return schema.validate(def, val, valPath)
+	}
+	if anyOf, ok := subSchema["anyOf"].([]any); ok {
+		var firstErr error
+		for _, option := range anyOf {
+			err := schema.validate(option.(map[string]any), val, valPath)
+			if err == nil {
+				return nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	}
+	jsonType := jsonTypeOf(val)
+	switch schemaType := subSchema["type"].(type) {
+	case string:
+		if !typeMatches(schemaType, jsonType) {
+			return fmt.Errorf("%s: expected %s, got %s", displayPath(valPath), schemaType, jsonType)
+		}
+	case []string:
+		matched := false
+		for _, option := range schemaType {
+			matched = matched || typeMatches(option, jsonType)
+		}
+		if !matched {
+			return fmt.Errorf("%s: expected %s, got %s", displayPath(valPath), strings.Join(schemaType, " or "), jsonType)
+		}
+	}
+	switch typedVal := val.(type) {
+	case []any:
+		items, ok := subSchema["items"].(map[string]any)
+		if !ok {
+			return nil
+		}
+		for idx, elem := range typedVal {
+			err := schema.validate(items, elem, fmt.Sprintf("%s[%d]", valPath, idx))
+			if err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		return schema.validateObject(subSchema, typedVal, valPath)
+	}
+	return nil
+}
+
+func (schema *updateSchema) validateObject(subSchema map[string]any, obj map[string]any, valPath string) error {
+	if minProps, ok := subSchema["minProperties"].(int); ok && len(obj) < minProps {
+		return fmt.Errorf("%s: expected at least %d properties, got %d", displayPath(valPath), minProps, len(obj))
+	}
+	if maxProps, ok := subSchema["maxProperties"].(int); ok && len(obj) > maxProps {
+		return fmt.Errorf("%s: expected at most %d properties, got %d", displayPath(valPath), maxProps, len(obj))
+	}
+	if required, ok := subSchema["required"].([]string); ok {
+		for _, key := range required {
+			if _, found := obj[key]; !found {
+				return fmt.Errorf("%s: missing property %q", displayPath(valPath), key)
+			}
+		}
+	}
+	props, _ := subSchema["properties"].(map[string]any)
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if propSchema, found := props[key]; found {
+			err := schema.validate(propSchema.(map[string]any), obj[key], joinSchemaPath(valPath, key))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		switch addlProps := subSchema["additionalProperties"].(type) {
+		case bool:
+			if !addlProps {
+				return fmt.Errorf("%s: unknown property %q", displayPath(valPath), key)
+			}
+		case map[string]any:
+			err := schema.validate(addlProps, obj[key], joinSchemaPath(valPath, key))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package scbus
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// A model update is written to the client as a single websocket message.  Updates over MaxUpdatePayloadSize are
+// split into multiple packets (see SplitModelUpdate).  Items that implement ChunkableUpdateItem (screenlines) are
+// split into chunks, which the client reassembles.
+const MaxUpdatePayloadSize = 4 * 1024 * 1024
+
+// json overhead of an empty model update packet, and of an item in the update's data array
+const modelUpdatePacketOverhead = 32
+const modelUpdateItemOverhead = 8
+
+// An interface for model update items that can be split into multiple items
+type ChunkableUpdateItem interface {
+	// Splits the item into chunks of (at most, if possible) maxSize bytes of json.  The chunks must carry the
+	// metadata the client needs to put them back together.
+	SplitItem(maxSize int) []ModelUpdateItem
+}
+
+// Returns the size of the json payload of the update (-1 if it cannot be marshaled)
+func GetUpdatePayloadSize(update UpdatePacket) int {
+	barr, err := json.Marshal(update)
+	if err != nil {
+		return -1
+	}
+	return len(barr)
+}
+
+func getUpdateItemSize(item ModelUpdateItem) int {
+	barr, err := json.Marshal(item)
+	if err != nil {
+		return -1
+	}
+	return len(barr) + len(item.GetType()) + modelUpdateItemOverhead
+}
+
+// Splits the update into packets of at most maxSize bytes (returns the update as is if it is small enough).
+// The order of the items is kept.  An item that is too big on its own (and cannot be split) is sent in its own
+// packet.
+func SplitModelUpdate(upk *ModelUpdatePacketType, maxSize int) []*ModelUpdatePacketType {
+	if upk.IsEmpty() {
+		return []*ModelUpdatePacketType{upk}
+	}
+	totalSize := GetUpdatePayloadSize(upk)
+	if totalSize <= maxSize {
+		return []*ModelUpdatePacketType{upk}
+	}
+	log.Printf("[scbus] model update too large (%d bytes, %d items), splitting\n", totalSize, len(*upk.Data))
+	itemMaxSize := maxSize - modelUpdatePacketOverhead
+	var rtn []*ModelUpdatePacketType
+	curPk := MakeUpdatePacket()
+	curSize := modelUpdatePacketOverhead
+	addItem := func(item ModelUpdateItem, itemSize int) {
+		if !curPk.IsEmpty() && curSize+itemSize > maxSize {
+			rtn = append(rtn, curPk)
+			curPk = MakeUpdatePacket()
+			curSize = modelUpdatePacketOverhead
+		}
+		curPk.AddUpdate(item)
+		curSize += itemSize
+	}
+	for _, item := range *upk.Data {
+		itemSize := getUpdateItemSize(item)
+		if itemSize <= itemMaxSize {
+			addItem(item, itemSize)
+			continue
+		}
+		chunkable, ok := item.(ChunkableUpdateItem)
+		if !ok {
+			log.Printf("[scbus] model update item %q too large (%d bytes), cannot split\n", item.GetType(), itemSize)
+			addItem(item, itemSize)
+			continue
+		}
+		for _, chunk := range chunkable.SplitItem(itemMaxSize) {
+			addItem(chunk, getUpdateItemSize(chunk))
+		}
+	}
+	if !curPk.IsEmpty() {
+		rtn = append(rtn, curPk)
+	}
+	return rtn
+}
@@ -0,0 +1,164 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package scbus
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// typescript definitions of the registered update types (written to src/types/gen/updatetypes.ts by
+// cmd/gentypes).  the types are declared in the GenUpdateTypes namespace with the same names as the schema
+// defs.  the file also fails to typecheck if the frontend's ModelUpdateItemType (custom.d.ts) is missing a
+// model update item key (it is a .ts file because skipLibCheck skips the .d.ts files).
+
+const TsNamespace = "GenUpdateTypes"
+const TsIndent = "    "
+
+var tsIdentRe = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+const tsFileHeader = `// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// generated by wavesrv/cmd/gentypes (scripthaus run generate-updatetypes), do not edit.
+// the update types wavesrv sends to the frontend (see wavesrv/pkg/scbus/updateregistry.go).
+
+`
+
+func tsNullable(tsType string) string {
+	if tsType == "any" || strings.HasSuffix(tsType, " | null") {
+		return tsType
+	}
+	return tsType + " | null"
+}
+
+func tsArray(elemType string) string {
+	if strings.Contains(elemType, " | ") {
+		return "(" + elemType + ")[]"
+	}
+	return elemType + "[]"
+}
+
+func tsPropName(name string) string {
+	if tsIdentRe.MatchString(name) {
+		return name
+	}
+	return fmt.Sprintf("%q", name)
+}
+
+func (schema *updateSchema) tsType(rtype reflect.Type) string {
+	if rtype.Kind() == reflect.Pointer {
+		return tsNullable(schema.tsType(rtype.Elem()))
+	}
+	if rtype == modelUpdateType {
+		return ModelUpdateItemDefName + "[]"
+	}
+	if rtype == timeType {
+		return "string"
+	}
+	if rtype.Implements(jsonMarshalerType) || reflect.PointerTo(rtype).Implements(jsonMarshalerType) {
+		return "any"
+	}
+	switch rtype.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice:
+		if rtype.Elem().Kind() == reflect.Uint8 {
+			return tsNullable("string")
+		}
+		return tsNullable(tsArray(schema.tsType(rtype.Elem())))
+	case reflect.Array:
+		return tsArray(schema.tsType(rtype.Elem()))
+	case reflect.Map:
+		return tsNullable(fmt.Sprintf("{ [key: string]: %s }", schema.tsType(rtype.Elem())))
+	case reflect.Struct:
+		if name, found := schema.TypeNames[rtype]; found {
+			return name
+		}
+		// anonymous struct
+		var fieldStrs []string
+		for _, field := range getJsonFields(rtype) {
+			fieldStrs = append(fieldStrs, schema.tsField(field, ""))
+		}
+		return "{ " + strings.Join(fieldStrs, "; ") + " }"
+	}
+	return "any"
+}
+
+// packetKey is set for the "type" field of an update packet (typed as the packet's key)
+func (schema *updateSchema) tsField(field jsonField, packetKey string) string {
+	optStr := ""
+	if field.Optional {
+		optStr = "?"
+	}
+	var tsType string
+	if packetKey != "" && field.Name == "type" {
+		tsType = fmt.Sprintf("%q", packetKey)
+	} else if field.AsString {
+		tsType = "string"
+	} else {
+		tsType = schema.tsType(field.Type)
+	}
+	return fmt.Sprintf("%s%s: %s", tsPropName(field.Name), optStr, tsType)
+}
+
+func writeTsType(buf *bytes.Buffer, name string, fieldStrs []string) {
+	indent := TsIndent + TsIndent
+	buf.WriteString(fmt.Sprintf("%stype %s = {\n", indent, name))
+	for _, fieldStr := range fieldStrs {
+		buf.WriteString(fmt.Sprintf("%s%s%s;\n", indent, TsIndent, fieldStr))
+	}
+	buf.WriteString(indent + "};\n\n")
+}
+
+// Generates the typescript definitions of the registered update types
+func GenerateTsDefs() string {
+	schema := getUpdateSchema()
+	packetKeys := make(map[string]string) // def name => packet key
+	var packetNames []string
+	for key, defName := range schema.PacketDefs {
+		packetKeys[defName] = key
+		packetNames = append(packetNames, defName)
+	}
+	sort.Strings(packetNames)
+	defNames := make([]string, 0, len(schema.StructTypes))
+	for name := range schema.StructTypes {
+		defNames = append(defNames, name)
+	}
+	sort.Strings(defNames)
+	var buf bytes.Buffer
+	buf.WriteString(tsFileHeader)
+	buf.WriteString("export {};\n\n")
+	buf.WriteString("declare global {\n")
+	buf.WriteString(fmt.Sprintf("%snamespace %s {\n", TsIndent, TsNamespace))
+	for _, name := range defNames {
+		var fieldStrs []string
+		for _, field := range getJsonFields(schema.StructTypes[name]) {
+			fieldStrs = append(fieldStrs, schema.tsField(field, packetKeys[name]))
+		}
+		writeTsType(&buf, name, fieldStrs)
+	}
+	var itemStrs []string
+	for _, key := range sortedTypeKeys(schema.ItemTypes) {
+		itemStrs = append(itemStrs, fmt.Sprintf("%s?: %s", tsPropName(key), schema.tsType(schema.ItemTypes[key])))
+	}
+	writeTsType(&buf, ModelUpdateItemDefName, itemStrs)
+	buf.WriteString(fmt.Sprintf("%s%stype UpdatePacketType = %s;\n", TsIndent, TsIndent, strings.Join(packetNames, " | ")))
+	buf.WriteString(fmt.Sprintf("%s}\n", TsIndent))
+	buf.WriteString("}\n\n")
+	buf.WriteString("// fails to typecheck (naming the keys) if the frontend's ModelUpdateItemType is missing a model update item key\n")
+	buf.WriteString("type AssertNoMissingKeys<T extends never> = T;\n")
+	buf.WriteString(fmt.Sprintf("export type MissingModelUpdateItemKeys = AssertNoMissingKeys<\n%sExclude<keyof %s.%s, keyof %s>\n>;\n", TsIndent, TsNamespace, ModelUpdateItemDefName, ModelUpdateItemDefName))
+	return buf.String()
+}
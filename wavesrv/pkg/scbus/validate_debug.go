@@ -0,0 +1,9 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build debug
+
+package scbus
+
+// debug builds validate every update sent to the frontend (see CheckUpdate)
+const ValidateUpdates = true
@@ -0,0 +1,9 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !debug
+
+package scbus
+
+// updates are only validated in debug builds (-tags debug)
+const ValidateUpdates = false
@@ -0,0 +1,173 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// max time to search forward for the next matching time (covers leap days)
+const MaxCronSearchYears = 5
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type cronField struct {
+	Name string
+	Min  int
+	Max  int
+}
+
+var cronFields = []cronField{
+	{Name: "minute", Min: 0, Max: 59},
+	{Name: "hour", Min: 0, Max: 23},
+	{Name: "day-of-month", Min: 1, Max: 31},
+	{Name: "month", Min: 1, Max: 12},
+	{Name: "day-of-week", Min: 0, Max: 7}, // 0 and 7 are both sunday
+}
+
+// standard 5-field cron expression (minute hour day-of-month month day-of-week)
+type CronExpr struct {
+	Minutes    [60]bool
+	Hours      [24]bool
+	DaysOfMon  [32]bool
+	Months     [13]bool
+	DaysOfWeek [7]bool
+	DomStar    bool
+	DowStar    bool
+}
+
+func ParseCron(exprStr string) (*CronExpr, error) {
+	exprStr = strings.TrimSpace(exprStr)
+	if macro, found := cronMacros[strings.ToLower(exprStr)]; found {
+		exprStr = macro
+	}
+	parts := strings.Fields(exprStr)
+	if len(parts) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression %q, must have 5 fields (minute hour day-of-month month day-of-week)", exprStr)
+	}
+	rtn := &CronExpr{}
+	for idx, part := range parts {
+		vals, err := parseCronField(part, cronFields[idx])
+		if err != nil {
+			return nil, err
+		}
+		for _, val := range vals {
+			switch idx {
+			case 0:
+				rtn.Minutes[val] = true
+			case 1:
+				rtn.Hours[val] = true
+			case 2:
+				rtn.DaysOfMon[val] = true
+			case 3:
+				rtn.Months[val] = true
+			case 4:
+				rtn.DaysOfWeek[val%7] = true
+			}
+		}
+	}
+	rtn.DomStar = parts[2] == "*"
+	rtn.DowStar = parts[4] == "*"
+	return rtn, nil
+}
+
+func parseCronField(fieldStr string, field cronField) ([]int, error) {
+	var rtn []int
+	for _, item := range strings.Split(fieldStr, ",") {
+		rangeStr, stepStr, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step %q in %s field", stepStr, field.Name)
+			}
+		}
+		start, end := field.Min, field.Max
+		if rangeStr != "*" {
+			startStr, endStr, isRange := strings.Cut(rangeStr, "-")
+			var err error
+			start, err = parseCronVal(startStr, field)
+			if err != nil {
+				return nil, err
+			}
+			end = start
+			if isRange {
+				end, err = parseCronVal(endStr, field)
+				if err != nil {
+					return nil, err
+				}
+				if end < start {
+					return nil, fmt.Errorf("invalid range %q in %s field", rangeStr, field.Name)
+				}
+			} else if hasStep {
+				// "5/10" means starting at 5, every 10
+				end = field.Max
+			}
+		}
+		for val := start; val <= end; val += step {
+			rtn = append(rtn, val)
+		}
+	}
+	return rtn, nil
+}
+
+func parseCronVal(valStr string, field cronField) (int, error) {
+	val, err := strconv.Atoi(valStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q in %s field", valStr, field.Name)
+	}
+	if val < field.Min || val > field.Max {
+		return 0, fmt.Errorf("value %d out of range in %s field (%d-%d)", val, field.Name, field.Min, field.Max)
+	}
+	return val, nil
+}
+
+// follows standard cron semantics: if both day-of-month and day-of-week are restricted, either may match
+func (c *CronExpr) matchDay(t time.Time) bool {
+	domMatch := c.DaysOfMon[t.Day()]
+	dowMatch := c.DaysOfWeek[int(t.Weekday())]
+	if c.DomStar || c.DowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// returns the first matching time strictly after t (truncated to the minute).
+// returns the zero time if there is no match (e.g. "0 0 31 2 *")
+func (c *CronExpr) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(MaxCronSearchYears, 0, 0)
+	for t.Before(limit) {
+		if !c.Months[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.Hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.Minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
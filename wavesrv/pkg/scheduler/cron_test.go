@@ -0,0 +1,53 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+const testTimeFmt = "2006-01-02 15:04"
+
+func testCronNext(t *testing.T, exprStr string, fromStr string, expectedStr string) {
+	expr, err := ParseCron(exprStr)
+	if err != nil {
+		t.Errorf("cron %q unexpected error: %v", exprStr, err)
+		return
+	}
+	from, _ := time.ParseInLocation(testTimeFmt, fromStr, time.UTC)
+	next := expr.Next(from)
+	var nextStr string
+	if !next.IsZero() {
+		nextStr = next.Format(testTimeFmt)
+	}
+	if nextStr != expectedStr {
+		t.Errorf("cron %q from %s expected %q, got %q", exprStr, fromStr, expectedStr, nextStr)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	testCronNext(t, "* * * * *", "2024-01-01 10:00", "2024-01-01 10:01")
+	testCronNext(t, "*/15 * * * *", "2024-01-01 10:01", "2024-01-01 10:15")
+	testCronNext(t, "*/15 * * * *", "2024-01-01 10:45", "2024-01-01 11:00")
+	testCronNext(t, "30 9 * * 1-5", "2024-01-05 10:00", "2024-01-08 09:30") // fri -> mon
+	testCronNext(t, "0 0 1 * *", "2024-01-15 00:00", "2024-02-01 00:00")
+	testCronNext(t, "0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00")
+	testCronNext(t, "0 12 1 * 0", "2024-01-02 00:00", "2024-01-07 12:00") // dom or dow
+	testCronNext(t, "0 0 * * 7", "2024-01-01 00:00", "2024-01-07 00:00")
+	testCronNext(t, "5/20 8,20 * * *", "2024-01-01 08:30", "2024-01-01 08:45")
+	testCronNext(t, "@daily", "2024-01-01 08:30", "2024-01-02 00:00")
+	testCronNext(t, "@hourly", "2024-12-31 23:59", "2025-01-01 00:00")
+	testCronNext(t, "0 0 31 2 *", "2024-01-01 00:00", "")
+}
+
+func TestCronParseErrors(t *testing.T) {
+	badExprs := []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@often"}
+	for _, exprStr := range badExprs {
+		_, err := ParseCron(exprStr)
+		if err == nil {
+			t.Errorf("cron %q expected error", exprStr)
+		}
+	}
+}
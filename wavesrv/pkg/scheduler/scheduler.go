@@ -0,0 +1,251 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// cron-like recurring commands.  scheduled runs are created as normal cmd lines (and history items) on their screen.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+const DispatchTimeout = 30 * time.Second
+
+// if the server was not running at a schedule's run time, runs later than this are skipped (like cron)
+const MaxRunLateness = 2 * time.Minute
+
+const MaxErrorStrLen = 500
+
+type ScheduleType struct {
+	ScheduleId string               `json:"scheduleid"`
+	ScreenId   string               `json:"screenid"`
+	Remote     sstore.RemotePtrType `json:"remote"`
+	CmdStr     string               `json:"cmdstr"`
+	CronExpr   string               `json:"cronexpr"`
+	Enabled    bool                 `json:"enabled"`
+	CreatedTs  int64                `json:"createdts"`
+	NextRunTs  int64                `json:"nextrunts"`
+	LastRunTs  int64                `json:"lastrunts"`
+	LastLineId string               `json:"lastlineid"`
+	LastError  string               `json:"lasterror"`
+}
+
+func (s *ScheduleType) ToMap() map[string]interface{} {
+	rtn := make(map[string]interface{})
+	rtn["scheduleid"] = s.ScheduleId
+	rtn["screenid"] = s.ScreenId
+	rtn["remoteownerid"] = s.Remote.OwnerId
+	rtn["remoteid"] = s.Remote.RemoteId
+	rtn["remotename"] = s.Remote.Name
+	rtn["cmdstr"] = s.CmdStr
+	rtn["cronexpr"] = s.CronExpr
+	rtn["enabled"] = s.Enabled
+	rtn["createdts"] = s.CreatedTs
+	rtn["nextrunts"] = s.NextRunTs
+	rtn["lastrunts"] = s.LastRunTs
+	rtn["lastlineid"] = s.LastLineId
+	rtn["lasterror"] = s.LastError
+	return rtn
+}
+
+func (s *ScheduleType) FromMap(m map[string]interface{}) bool {
+	dbutil.QuickSetStr(&s.ScheduleId, m, "scheduleid")
+	dbutil.QuickSetStr(&s.ScreenId, m, "screenid")
+	dbutil.QuickSetStr(&s.Remote.OwnerId, m, "remoteownerid")
+	dbutil.QuickSetStr(&s.Remote.RemoteId, m, "remoteid")
+	dbutil.QuickSetStr(&s.Remote.Name, m, "remotename")
+	dbutil.QuickSetStr(&s.CmdStr, m, "cmdstr")
+	dbutil.QuickSetStr(&s.CronExpr, m, "cronexpr")
+	dbutil.QuickSetBool(&s.Enabled, m, "enabled")
+	dbutil.QuickSetInt64(&s.CreatedTs, m, "createdts")
+	dbutil.QuickSetInt64(&s.NextRunTs, m, "nextrunts")
+	dbutil.QuickSetInt64(&s.LastRunTs, m, "lastrunts")
+	dbutil.QuickSetStr(&s.LastLineId, m, "lastlineid")
+	dbutil.QuickSetStr(&s.LastError, m, "lasterror")
+	return true
+}
+
+// runs the schedule's cmdstr as a normal cmd line, returns the new lineid.
+// set by cmdrunner (scheduler cannot import cmdrunner)
+type RunScheduleFnType func(ctx context.Context, sched *ScheduleType) (string, error)
+
+// cron expressions are evaluated in the client timezone (see sstore.GetClientLocation).
+// returns 0 if the cron expression never matches again
+func computeNextRunTs(cronExprStr string, from time.Time) (int64, error) {
+	expr, err := ParseCron(cronExprStr)
+	if err != nil {
+		return 0, err
+	}
+	next := expr.Next(from)
+	if next.IsZero() {
+		return 0, nil
+	}
+	return next.UnixMilli(), nil
+}
+
+func InsertSchedule(ctx context.Context, sched *ScheduleType) error {
+	if sched == nil {
+		return fmt.Errorf("cannot insert nil schedule")
+	}
+	nextRunTs, err := computeNextRunTs(sched.CronExpr, time.Now().In(sstore.GetClientLocation(ctx)))
+	if err != nil {
+		return err
+	}
+	if nextRunTs == 0 {
+		return fmt.Errorf("cron expression %q never runs", sched.CronExpr)
+	}
+	sched.NextRunTs = nextRunTs
+	return sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		query := `SELECT screenid FROM screen WHERE screenid = ?`
+		if !tx.Exists(query, sched.ScreenId) {
+			return fmt.Errorf("screen not found")
+		}
+		query = `INSERT INTO schedule ( scheduleid, screenid, remoteownerid, remoteid, remotename, cmdstr, cronexpr, enabled, createdts, nextrunts, lastrunts, lastlineid, lasterror)
+		                       VALUES (:scheduleid,:screenid,:remoteownerid,:remoteid,:remotename,:cmdstr,:cronexpr,:enabled,:createdts,:nextrunts,:lastrunts,:lastlineid,:lasterror)`
+		tx.NamedExec(query, sched.ToMap())
+		return nil
+	})
+}
+
+// if screenId is "", returns all schedules
+func GetSchedules(ctx context.Context, screenId string) ([]*ScheduleType, error) {
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) ([]*ScheduleType, error) {
+		if screenId == "" {
+			query := `SELECT * FROM schedule ORDER BY createdts`
+			return dbutil.SelectMapsGen[*ScheduleType](tx, query), nil
+		}
+		query := `SELECT * FROM schedule WHERE screenid = ? ORDER BY createdts`
+		return dbutil.SelectMapsGen[*ScheduleType](tx, query, screenId), nil
+	})
+}
+
+// arg can be a scheduleid or an 8 character scheduleid prefix
+func GetScheduleByArg(ctx context.Context, arg string) (*ScheduleType, error) {
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) (*ScheduleType, error) {
+		query := `SELECT * FROM schedule WHERE scheduleid = ?`
+		sched := dbutil.GetMapGen[*ScheduleType](tx, query, arg)
+		if sched == nil && len(arg) == 8 {
+			query = `SELECT * FROM schedule WHERE substr(scheduleid, 1, 8) = ?`
+			sched = dbutil.GetMapGen[*ScheduleType](tx, query, arg)
+		}
+		return sched, nil
+	})
+}
+
+// re-enabling a schedule computes a fresh nextrunts (runs missed while paused are skipped)
+func SetScheduleEnabled(ctx context.Context, scheduleId string, enabled bool) error {
+	loc := sstore.GetClientLocation(ctx)
+	return sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		query := `SELECT cronexpr FROM schedule WHERE scheduleid = ?`
+		cronExprStr := tx.GetString(query, scheduleId)
+		if cronExprStr == "" {
+			return fmt.Errorf("schedule not found")
+		}
+		if !enabled {
+			query = `UPDATE schedule SET enabled = 0 WHERE scheduleid = ?`
+			tx.Exec(query, scheduleId)
+			return nil
+		}
+		nextRunTs, err := computeNextRunTs(cronExprStr, time.Now().In(loc))
+		if err != nil {
+			return err
+		}
+		query = `UPDATE schedule SET enabled = 1, nextrunts = ? WHERE scheduleid = ?`
+		tx.Exec(query, nextRunTs, scheduleId)
+		return nil
+	})
+}
+
+func DeleteSchedule(ctx context.Context, scheduleId string) error {
+	return sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		query := `DELETE FROM schedule WHERE scheduleid = ?`
+		tx.Exec(query, scheduleId)
+		return nil
+	})
+}
+
+// also removes schedules for screens that no longer exist
+func getDueSchedules(ctx context.Context, nowTs int64) ([]*ScheduleType, error) {
+	return sstore.WithTxRtn(ctx, func(tx *sstore.TxWrap) ([]*ScheduleType, error) {
+		query := `DELETE FROM schedule WHERE screenid NOT IN (SELECT screenid FROM screen)`
+		tx.Exec(query)
+		query = `SELECT * FROM schedule WHERE enabled AND nextrunts > 0 AND nextrunts <= ? ORDER BY nextrunts`
+		return dbutil.SelectMapsGen[*ScheduleType](tx, query, nowTs), nil
+	})
+}
+
+func updateScheduleRun(ctx context.Context, sched *ScheduleType) error {
+	return sstore.WithTx(ctx, func(tx *sstore.TxWrap) error {
+		query := `UPDATE schedule SET nextrunts = ?, lastrunts = ?, lastlineid = ?, lasterror = ? WHERE scheduleid = ?`
+		tx.Exec(query, sched.NextRunTs, sched.LastRunTs, sched.LastLineId, sched.LastError, sched.ScheduleId)
+		return nil
+	})
+}
+
+func dispatchSchedule(ctx context.Context, sched *ScheduleType, now time.Time, runFn RunScheduleFnType) {
+	lateness := now.Sub(time.UnixMilli(sched.NextRunTs))
+	if lateness <= MaxRunLateness {
+		lineId, err := runFn(ctx, sched)
+		sched.LastRunTs = now.UnixMilli()
+		sched.LastLineId = lineId
+		sched.LastError = ""
+		if err != nil {
+			sched.LastError = err.Error()
+			if len(sched.LastError) > MaxErrorStrLen {
+				sched.LastError = sched.LastError[0:MaxErrorStrLen]
+			}
+			log.Printf("[scheduler] error running schedule %s: %v\n", sched.ScheduleId, err)
+		}
+	}
+	nextRunTs, err := computeNextRunTs(sched.CronExpr, now.In(sstore.GetClientLocation(ctx)))
+	if err != nil {
+		// should not happen (validated on insert), stop running this schedule
+		log.Printf("[scheduler] invalid cron expression for schedule %s: %v\n", sched.ScheduleId, err)
+	}
+	sched.NextRunTs = nextRunTs
+	err = updateScheduleRun(ctx, sched)
+	if err != nil {
+		log.Printf("[scheduler] error updating schedule %s: %v\n", sched.ScheduleId, err)
+	}
+}
+
+func dispatchDueSchedules(runFn RunScheduleFnType) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		log.Printf("[error] in scheduler dispatch: %v\n", r)
+		debug.PrintStack()
+	}()
+	ctx, cancelFn := context.WithTimeout(context.Background(), DispatchTimeout)
+	defer cancelFn()
+	now := time.Now()
+	schedules, err := getDueSchedules(ctx, now.UnixMilli())
+	if err != nil {
+		log.Printf("[scheduler] error getting due schedules: %v\n", err)
+		return
+	}
+	for _, sched := range schedules {
+		dispatchSchedule(ctx, sched, now, runFn)
+	}
+}
+
+// checks for due schedules at the start of every minute
+func RunDispatcherLoop(runFn RunScheduleFnType) {
+	for {
+		now := time.Now()
+		time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+		if scbase.IsReadOnly() {
+			continue
+		}
+		dispatchDueSchedules(runFn)
+	}
+}
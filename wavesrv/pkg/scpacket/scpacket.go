@@ -143,6 +143,7 @@ var _ PacketType = &EphemeralCommandResponsePacketType{}
 type UIContextType struct {
 	SessionId string          `json:"sessionid"`
 	ScreenId  string          `json:"screenid"`
+	WindowId  string          `json:"windowid,omitempty"` // "" for the main window
 	Remote    *RemotePtrType  `json:"remote,omitempty"`
 	WinSize   *packet.WinSize `json:"winsize,omitempty"`
 	Build     string          `json:"build,omitempty"`
@@ -164,11 +165,15 @@ type RemoteInputPacketType struct {
 }
 
 type WatchScreenPacketType struct {
-	Type      string `json:"type"`
-	SessionId string `json:"sessionid"`
-	ScreenId  string `json:"screenid"`
-	Connect   bool   `json:"connect"`
-	AuthKey   string `json:"authkey"`
+	Type            string   `json:"type"`
+	SessionId       string   `json:"sessionid"`
+	ScreenId        string   `json:"screenid"`
+	Connect         bool     `json:"connect"`
+	Dropdown        bool     `json:"dropdown,omitempty"` // connect update scoped to the dropdown session/screen
+	AuthKey         string   `json:"authkey"`
+	ClientVersion   string   `json:"clientversion,omitempty"`   // the handshake fields (see scws/compat.go)
+	ProtocolVersion int      `json:"protocolversion,omitempty"` // 0 for legacy frontends
+	Capabilities    []string `json:"capabilities,omitempty"`
 }
 
 type CmdInputTextPacketType struct {
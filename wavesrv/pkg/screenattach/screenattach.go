@@ -0,0 +1,113 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// helpers shared by the servers that attach outside clients to screens (sshattach, tmuxcc, lanshare): running
+// a command on a screen, sending terminal input to the screen's running command, and editing the command line
+// typed into a screen that has nothing running.
+package screenattach
+
+import (
+	"context"
+	"encoding/base64"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/base"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scws"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// runs cmdStr on the screen as if it was typed.  set by cmdrunner (which the servers cannot import), each server
+// documents which commands its runner accepts.
+type RunCmdFnType func(ctx context.Context, screenId string, cmdStr string) error
+
+// the most recently started running command of the screen (nil if nothing is running)
+func GetRunningCmd(ctx context.Context, screenId string) (*sstore.CmdType, error) {
+	cmds, err := sstore.GetRunningScreenCmds(ctx, screenId)
+	if err != nil || len(cmds) == 0 {
+		return nil, err
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].RestartTs < cmds[j].RestartTs })
+	return cmds[len(cmds)-1], nil
+}
+
+// sends terminal input (and the signal, if sigName is set) to the command through the same queue as the local
+// client's input
+func SendCmdInput(cmd *sstore.CmdType, input []byte, sigName string) error {
+	inputPk := scpacket.MakeFeInputPacket()
+	inputPk.CK = base.MakeCommandKey(cmd.ScreenId, cmd.LineId)
+	inputPk.Remote = scpacket.RemotePtrType{OwnerId: cmd.Remote.OwnerId, RemoteId: cmd.Remote.RemoteId, Name: cmd.Remote.Name}
+	if len(input) > 0 {
+		inputPk.InputData64 = base64.StdEncoding.EncodeToString(input)
+	}
+	inputPk.SigName = sigName
+	return scws.EnqueueCmdInput(inputPk)
+}
+
+// the command line being typed into a screen that has nothing running.  escape sequences (arrow keys, ...) are
+// dropped, also when they are split across inputs.
+type LineEditor struct {
+	Buf      []byte
+	escState int // 0 = none, 1 = after ESC, 2 = in a CSI sequence
+}
+
+func (ed *LineEditor) Reset() {
+	ed.Buf = nil
+	ed.escState = 0
+}
+
+// applies one input byte, returns the echo and the line if enter (or C-c, which returns "") was pressed
+func (ed *LineEditor) Edit(ch byte) (string, string, bool) {
+	switch ed.escState {
+	case 1:
+		ed.escState = 0
+		if ch == '[' {
+			ed.escState = 2
+		}
+		return "", "", false
+	case 2:
+		// CSI sequences end with a byte in 0x40-0x7e
+		if ch >= 0x40 && ch <= 0x7e {
+			ed.escState = 0
+		}
+		return "", "", false
+	}
+	switch {
+	case ch == '\r' || ch == '\n':
+		line := string(ed.Buf)
+		ed.Buf = nil
+		return "\r\n", line, true
+	case ch == 0x7f || ch == '\b':
+		if len(ed.Buf) == 0 {
+			return "", "", false
+		}
+		_, size := utf8.DecodeLastRune(ed.Buf)
+		ed.Buf = ed.Buf[:len(ed.Buf)-size]
+		return "\b \b", "", false
+	case ch == 0x03:
+		// C-c
+		ed.Buf = nil
+		return "^C\r\n", "", true
+	case ch == 0x15:
+		// C-u
+		var echo []byte
+		for range utf8.RuneCount(ed.Buf) {
+			echo = append(echo, "\b \b"...)
+		}
+		ed.Buf = nil
+		return string(echo), "", false
+	case ch == 0x1b:
+		ed.escState = 1
+		return "", "", false
+	case ch == '\t':
+		ed.Buf = append(ed.Buf, ' ')
+		return " ", "", false
+	case ch < ' ':
+		// other control characters are ignored
+		return "", "", false
+	default:
+		ed.Buf = append(ed.Buf, ch)
+		return string([]byte{ch}), "", false
+	}
+}
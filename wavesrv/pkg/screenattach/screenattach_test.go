@@ -0,0 +1,51 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package screenattach
+
+import (
+	"reflect"
+	"testing"
+)
+
+func editAll(ed *LineEditor, input string) (string, []string) {
+	var echo string
+	var lines []string
+	for _, ch := range []byte(input) {
+		chEcho, line, entered := ed.Edit(ch)
+		echo += chEcho
+		if entered {
+			lines = append(lines, line)
+		}
+	}
+	return echo, lines
+}
+
+func TestLineEditor(t *testing.T) {
+	ed := &LineEditor{}
+	echo, lines := editAll(ed, "lx\x7fs\x1b[A -l\r")
+	if !reflect.DeepEqual(lines, []string{"ls -l"}) || len(ed.Buf) != 0 {
+		t.Errorf("got lines %q buf %q", lines, ed.Buf)
+	}
+	if echo != "lx\b \bs -l\r\n" {
+		t.Errorf("got echo %q", echo)
+	}
+	// an escape sequence split across inputs
+	editAll(ed, "pw\x1b")
+	editAll(ed, "[Bd")
+	if string(ed.Buf) != "pwd" {
+		t.Errorf("got buf %q", ed.Buf)
+	}
+	echo, _ = editAll(ed, "\x15")
+	if echo != "\b \b\b \b\b \b" || len(ed.Buf) != 0 {
+		t.Errorf("C-u got echo %q buf %q", echo, ed.Buf)
+	}
+	_, lines = editAll(ed, "a\x03")
+	if !reflect.DeepEqual(lines, []string{""}) || len(ed.Buf) != 0 {
+		t.Errorf("C-c got lines %q buf %q", lines, ed.Buf)
+	}
+	editAll(ed, "é\x7f")
+	if len(ed.Buf) != 0 {
+		t.Errorf("backspace got buf %q", ed.Buf)
+	}
+}
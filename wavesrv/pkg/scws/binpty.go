@@ -0,0 +1,38 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package scws
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+)
+
+// binary pty frames, used for clients with the binarypty capability (the local frontend, see compat.go).
+// pty data is written as a binary websocket message instead of a json update with base64 data (so the bulk
+// bytes are never encoded or parsed as json), everything else still goes through the json updates.
+// frame: [4 byte big endian header length][header json (the pty update without ptydata64)][raw pty data]
+
+const BinaryPtyHeaderLenSize = 4
+
+func MakeBinaryPtyFrame(update *scbus.PtyDataUpdate) ([]byte, error) {
+	data, err := update.GetPtyData()
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode pty data: %w", err)
+	}
+	header := *update
+	header.PtyData64 = ""
+	header.PtyData = nil
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, BinaryPtyHeaderLenSize, BinaryPtyHeaderLenSize+len(headerBytes)+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(headerBytes)))
+	frame = append(frame, headerBytes...)
+	frame = append(frame, data...)
+	return frame, nil
+}
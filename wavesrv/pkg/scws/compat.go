@@ -0,0 +1,112 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package scws
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scpacket"
+)
+
+// version/capability handshake.  the frontend sends its protocol version and capabilities in its watchscreen
+// packet, the connect update includes a "compat" report.  a frontend that sends no protocol version is a
+// legacy frontend (protocol 0, only the model and pty updates).  optional features are only used when both
+// sides have the capability, update types the frontend does not know are not sent to it (see acceptsUpdate).
+//
+// ProtocolVersion is bumped on incompatible changes to the connect flow or the base update types, frontends
+// older than MinClientProtocolVersion get a report with Compatible=false (and the reason in Error).
+
+const ProtocolVersion = 1
+const MinClientProtocolVersion = 0
+
+const (
+	Cap_BinaryPty     = "binarypty"     // pty data as binary frames (see binpty.go)
+	Cap_RemoteMetrics = "remotemetrics" // "remotemetrics" updates (see remote/metrics.go)
+)
+
+// the optional capabilities of this server
+var ServerCapabilities = []string{Cap_BinaryPty, Cap_RemoteMetrics}
+
+// update types every frontend handles (no capability needed)
+var baseUpdateTypes = map[string]bool{
+	scbus.ModelUpdateStr:   true,
+	scbus.PtyDataUpdateStr: true,
+}
+
+// update types that require a capability
+var capUpdateTypes = map[string]string{
+	scbus.RemoteMetricsUpdateStr: Cap_RemoteMetrics,
+}
+
+type CompatReportType struct {
+	ServerVersion         string   `json:"serverversion"`
+	ProtocolVersion       int      `json:"protocolversion"`
+	ClientVersion         string   `json:"clientversion,omitempty"`
+	ClientProtocolVersion int      `json:"clientprotocolversion"`
+	Compatible            bool     `json:"compatible"`
+	Error                 string   `json:"error,omitempty"`
+	Enabled               []string `json:"enabled"`            // capabilities in use (both sides)
+	Disabled              []string `json:"disabled,omitempty"` // server capabilities the client does not have
+	Unknown               []string `json:"unknown,omitempty"`  // client capabilities this server does not have
+}
+
+func (CompatReportType) GetType() string {
+	return "compat"
+}
+
+func init() {
+	scbus.RegisterModelUpdateItem(CompatReportType{})
+}
+
+// the capabilities in use for a client (nil clientCaps is a legacy client)
+func negotiateCaps(clientCaps []string) map[string]bool {
+	rtn := make(map[string]bool)
+	for _, capName := range clientCaps {
+		if slices.Contains(ServerCapabilities, capName) {
+			rtn[capName] = true
+		}
+	}
+	return rtn
+}
+
+func MakeCompatReport(wsPk *scpacket.WatchScreenPacketType) CompatReportType {
+	rtn := CompatReportType{
+		ServerVersion:         scbase.WaveVersion,
+		ProtocolVersion:       ProtocolVersion,
+		ClientVersion:         wsPk.ClientVersion,
+		ClientProtocolVersion: wsPk.ProtocolVersion,
+		Compatible:            true,
+	}
+	if wsPk.ProtocolVersion < MinClientProtocolVersion {
+		rtn.Compatible = false
+		rtn.Error = fmt.Sprintf("frontend protocol version %d is not supported (requires %d or newer), please upgrade", wsPk.ProtocolVersion, MinClientProtocolVersion)
+	}
+	caps := negotiateCaps(wsPk.Capabilities)
+	rtn.Enabled = []string{}
+	for _, capName := range ServerCapabilities {
+		if caps[capName] {
+			rtn.Enabled = append(rtn.Enabled, capName)
+		} else {
+			rtn.Disabled = append(rtn.Disabled, capName)
+		}
+	}
+	for _, capName := range wsPk.Capabilities {
+		if !caps[capName] {
+			rtn.Unknown = append(rtn.Unknown, capName)
+		}
+	}
+	return rtn
+}
+
+// false for update types the client does not know (they are dropped)
+func acceptsUpdate(caps map[string]bool, updateType string) bool {
+	if baseUpdateTypes[updateType] {
+		return true
+	}
+	capName, found := capUpdateTypes[updateType]
+	return found && caps[capName]
+}
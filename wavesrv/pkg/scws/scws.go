@@ -14,6 +14,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/configstore"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/macros"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/mapqueue"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/remote"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
@@ -42,6 +43,7 @@ type WSState struct {
 	UpdateQueue   []any
 	Authenticated bool
 	AuthKey       string
+	Caps          map[string]bool // negotiated at connect (see compat.go)
 
 	SessionId string
 	ScreenId  string
@@ -68,6 +70,18 @@ func (ws *WSState) IsAuthenticated() bool {
 	return ws.Authenticated
 }
 
+func (ws *WSState) SetCaps(caps map[string]bool) {
+	ws.Lock.Lock()
+	defer ws.Lock.Unlock()
+	ws.Caps = caps
+}
+
+func (ws *WSState) GetCaps() map[string]bool {
+	ws.Lock.Lock()
+	defer ws.Lock.Unlock()
+	return ws.Caps
+}
+
 func (ws *WSState) GetShell() *wsshell.WSShell {
 	ws.Lock.Lock()
 	defer ws.Lock.Unlock()
@@ -126,8 +140,29 @@ func (ws *WSState) RunUpdates(updateCh chan scbus.UpdatePacket) {
 	}
 	for update := range updateCh {
 		shell := ws.GetShell()
-		if shell != nil {
+		if shell == nil {
+			continue
+		}
+		caps := ws.GetCaps()
+		if !acceptsUpdate(caps, update.GetType()) {
+			continue
+		}
+		if ptyUpdate, ok := update.(*scbus.PtyDataUpdatePacketType); ok {
+			if caps[Cap_BinaryPty] {
+				writeBinaryPty(shell, ptyUpdate)
+			} else {
+				writeJsonProtected(shell, ptyUpdate.WithPtyData64())
+			}
+			continue
+		}
+		modelUpdate, ok := update.(*scbus.ModelUpdatePacketType)
+		if !ok {
 			writeJsonProtected(shell, update)
+			continue
+		}
+		// large updates are split, so they stay under the message size limits
+		for _, splitUpdate := range scbus.SplitModelUpdate(modelUpdate, scbus.MaxUpdatePayloadSize) {
+			writeJsonProtected(shell, splitUpdate)
 		}
 	}
 }
@@ -143,6 +178,27 @@ func writeJsonProtected(shell *wsshell.WSShell, update any) {
 	shell.WriteJson(update)
 }
 
+// falls back to the json update if the frame cannot be made
+func writeBinaryPty(shell *wsshell.WSShell, update *scbus.PtyDataUpdatePacketType) {
+	if update.Data == nil {
+		return
+	}
+	frame, err := MakeBinaryPtyFrame(update.Data)
+	if err != nil {
+		log.Printf("[error] in scws binary pty frame: %v\n", err)
+		writeJsonProtected(shell, update.WithPtyData64())
+		return
+	}
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		log.Printf("[error] in scws RunUpdates WriteBinary: %v\n", r)
+	}()
+	shell.WriteBinary(frame)
+}
+
 func (ws *WSState) ReplaceShell(shell *wsshell.WSShell) {
 	ws.Lock.Lock()
 	defer ws.Lock.Unlock()
@@ -155,15 +211,30 @@ func (ws *WSState) ReplaceShell(shell *wsshell.WSShell) {
 }
 
 // returns all state required to display current UI
-func (ws *WSState) handleConnection() error {
+func (ws *WSState) handleConnection(dropdown bool, compatReport CompatReportType) error {
 	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancelFn()
+	if dropdown {
+		connectUpdate, err := sstore.GetDropdownConnectUpdate(ctx)
+		if err != nil {
+			return fmt.Errorf("getting dropdown session: %w", err)
+		}
+		if connectUpdate != nil {
+			return ws.writeDropdownConnectUpdate(connectUpdate, compatReport)
+		}
+		// no dropdown designated, the dropdown window gets the full connect update
+	}
 	connectUpdate, err := sstore.GetConnectUpdate(ctx)
 	if err != nil {
 		return fmt.Errorf("getting sessions: %w", err)
 	}
 	remotes := remote.GetAllRemoteRuntimeState()
 	connectUpdate.Remotes = remotes
+	connectUpdate.RemoteHealth, err = remote.GetAllRemoteHealth(ctx)
+	if err != nil {
+		return fmt.Errorf("getting remote health: %w", err)
+	}
+	connectUpdate.RemoteMetrics = remote.GetAllRemoteMetrics()
 	// restore status indicators
 	connectUpdate.ScreenStatusIndicators, connectUpdate.ScreenNumRunningCommands = sstore.GetCurrentIndicatorState()
 	configs, err := configstore.ScanConfigs()
@@ -173,6 +244,9 @@ func (ws *WSState) handleConnection() error {
 	connectUpdate.TermThemes = &configs
 	mu := scbus.MakeUpdatePacket()
 	mu.AddUpdate(*connectUpdate)
+	mu.AddUpdate(compatReport)
+	mu.Clean()
+	scbus.CheckUpdate(mu)
 	err = ws.Shell.WriteJson(mu)
 	if err != nil {
 		return err
@@ -180,6 +254,38 @@ func (ws *WSState) handleConnection() error {
 	return nil
 }
 
+// fast path for the dropdown window, only the indicators of the included screens are restored
+// (no remote health, the dropdown has no connections view)
+func (ws *WSState) writeDropdownConnectUpdate(connectUpdate *sstore.ConnectUpdate, compatReport CompatReportType) error {
+	connectUpdate.Remotes = remote.GetAllRemoteRuntimeState()
+	screenIds := make(map[string]bool)
+	for _, screen := range connectUpdate.Screens {
+		screenIds[screen.ScreenId] = true
+	}
+	indicators, numRunningCommands := sstore.GetCurrentIndicatorState()
+	for _, indicator := range indicators {
+		if screenIds[indicator.ScreenId] {
+			connectUpdate.ScreenStatusIndicators = append(connectUpdate.ScreenStatusIndicators, indicator)
+		}
+	}
+	for _, numRunning := range numRunningCommands {
+		if screenIds[numRunning.ScreenId] {
+			connectUpdate.ScreenNumRunningCommands = append(connectUpdate.ScreenNumRunningCommands, numRunning)
+		}
+	}
+	configs, err := configstore.ScanConfigs()
+	if err != nil {
+		return fmt.Errorf("getting configs: %w", err)
+	}
+	connectUpdate.TermThemes = &configs
+	mu := scbus.MakeUpdatePacket()
+	mu.AddUpdate(*connectUpdate)
+	mu.AddUpdate(compatReport)
+	mu.Clean()
+	scbus.CheckUpdate(mu)
+	return ws.Shell.WriteJson(mu)
+}
+
 func (ws *WSState) handleWatchScreen(wsPk *scpacket.WatchScreenPacketType) error {
 	if wsPk.SessionId != "" {
 		if _, err := uuid.Parse(wsPk.SessionId); err != nil {
@@ -200,6 +306,9 @@ func (ws *WSState) handleWatchScreen(wsPk *scpacket.WatchScreenPacketType) error
 		return fmt.Errorf("invalid watchscreen, invalid authkey")
 	}
 	ws.SetAuthenticated(true)
+	if wsPk.Connect {
+		ws.SetCaps(negotiateCaps(wsPk.Capabilities))
+	}
 	if wsPk.SessionId == "" || wsPk.ScreenId == "" {
 		ws.UnWatchScreen()
 	} else {
@@ -208,7 +317,11 @@ func (ws *WSState) handleWatchScreen(wsPk *scpacket.WatchScreenPacketType) error
 	}
 	if wsPk.Connect {
 		// log.Printf("[ws %s] watchscreen connect\n", ws.ClientId)
-		err := ws.handleConnection()
+		compatReport := MakeCompatReport(wsPk)
+		if !compatReport.Compatible {
+			log.Printf("[ws %s] incompatible frontend: %s\n", ws.ClientId, compatReport.Error)
+		}
+		err := ws.handleConnection(wsPk.Dropdown, compatReport)
 		if err != nil {
 			return fmt.Errorf("connect: %w", err)
 		}
@@ -244,22 +357,8 @@ func (ws *WSState) processMessage(msgBytes []byte) error {
 	}
 	if pk.GetType() == scpacket.FeInputPacketStr {
 		feInputPk := pk.(*scpacket.FeInputPacketType)
-		if feInputPk.Remote.OwnerId != "" {
-			return fmt.Errorf("error cannot send input to remote with ownerid")
-		}
-		if feInputPk.Remote.RemoteId == "" {
-			return fmt.Errorf("error invalid input packet, remoteid is not set")
-		}
-		err := RemoteInputMapQueue.Enqueue(feInputPk.Remote.RemoteId, func() {
-			sendErr := sendCmdInput(feInputPk)
-			if sendErr != nil {
-				log.Printf("[scws] sending command input: %v\n", sendErr)
-			}
-		})
-		if err != nil {
-			return fmt.Errorf("[error] could not queue sendCmdInput: %w", err)
-		}
-		return nil
+		macros.RecordInput(feInputPk.CK.GetGroupId(), feInputPk.InputData64, feInputPk.SigName)
+		return EnqueueCmdInput(feInputPk)
 	}
 	if pk.GetType() == scpacket.RemoteInputPacketStr {
 		inputPk := pk.(*scpacket.RemoteInputPacketType)
@@ -318,6 +417,26 @@ func (ws *WSState) RunWSRead() {
 	}
 }
 
+// input is queued per remote so that input from all clients (including lan share writers) is sent in order
+func EnqueueCmdInput(feInputPk *scpacket.FeInputPacketType) error {
+	if feInputPk.Remote.OwnerId != "" {
+		return fmt.Errorf("error cannot send input to remote with ownerid")
+	}
+	if feInputPk.Remote.RemoteId == "" {
+		return fmt.Errorf("error invalid input packet, remoteid is not set")
+	}
+	err := RemoteInputMapQueue.Enqueue(feInputPk.Remote.RemoteId, func() {
+		sendErr := sendCmdInput(feInputPk)
+		if sendErr != nil {
+			log.Printf("[scws] sending command input: %v\n", sendErr)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("[error] could not queue sendCmdInput: %w", err)
+	}
+	return nil
+}
+
 func sendCmdInput(pk *scpacket.FeInputPacketType) error {
 	err := pk.CK.Validate("input packet")
 	if err != nil {
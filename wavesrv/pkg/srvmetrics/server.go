@@ -0,0 +1,104 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package srvmetrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const MetricsPath = "/metrics"
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+const ShutdownTimeout = 2 * time.Second
+
+type StatusType struct {
+	Running bool
+	Addr    string
+}
+
+var serverLock = &sync.Mutex{}
+var curServer *http.Server
+var curAddr string
+
+// the endpoint only listens on localhost
+func GetListenAddr(port int) string {
+	return fmt.Sprintf("127.0.0.1:%d", port)
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	err := WriteMetrics(w)
+	if err != nil {
+		log.Printf("[srvmetrics] error writing metrics: %v\n", err)
+	}
+}
+
+// starts the metrics endpoint on localhost:port (moves it if it is running on a different port), port 0 stops it.
+// if the new port cannot be listened on, the running endpoint is kept.
+func SetPort(port int) error {
+	if port < 0 || port > 65535 {
+		return fmt.Errorf("invalid port %d", port)
+	}
+	serverLock.Lock()
+	defer serverLock.Unlock()
+	if port == 0 {
+		if curServer != nil {
+			stopServer_nolock()
+		}
+		return nil
+	}
+	addr := GetListenAddr(port)
+	if curServer != nil && curAddr == addr {
+		return nil
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s: %v", addr, err)
+	}
+	if curServer != nil {
+		stopServer_nolock()
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(MetricsPath, handleMetrics)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	curServer = server
+	curAddr = addr
+	log.Printf("[srvmetrics] serving metrics on http://%s%s\n", addr, MetricsPath)
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("[srvmetrics] server error: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+func stopServer_nolock() {
+	log.Printf("[srvmetrics] stopping server\n")
+	ctx, cancelFn := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancelFn()
+	curServer.Shutdown(ctx)
+	curServer = nil
+	curAddr = ""
+}
+
+func GetStatus() StatusType {
+	serverLock.Lock()
+	defer serverLock.Unlock()
+	if curServer == nil {
+		return StatusType{}
+	}
+	return StatusType{Running: true, Addr: curAddr}
+}
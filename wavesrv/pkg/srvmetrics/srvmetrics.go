@@ -0,0 +1,267 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// prometheus-style metrics of the wavesrv internals (db transactions, update-writer backlog, blockstore cache,
+// remote connections, ai requests), for monitoring long-running daemons.  served in the prometheus text format
+// on an optional localhost endpoint (http://127.0.0.1:[port]/metrics), started with /client:set metricsport=N.
+//
+// counters and histograms are updated by the instrumented packages (they are cheap and always on), gauges are
+// collected by the registered gauge functions when the endpoint is scraped.
+package srvmetrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	MetricType_Counter   = "counter"
+	MetricType_Gauge     = "gauge"
+	MetricType_Histogram = "histogram"
+)
+
+// latency buckets (seconds)
+var DefaultLatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+type metric interface {
+	getName() string
+	writeTo(buf *bytes.Buffer)
+}
+
+var registryLock = &sync.Mutex{}
+var registry = make(map[string]metric)
+
+func register(m metric) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, found := registry[m.getName()]; found {
+		panic(fmt.Sprintf("metric %q registered twice", m.getName()))
+	}
+	registry[m.getName()] = m
+}
+
+func escapeLabelValue(val string) string {
+	val = strings.ReplaceAll(val, `\`, `\\`)
+	val = strings.ReplaceAll(val, "\n", `\n`)
+	return strings.ReplaceAll(val, `"`, `\"`)
+}
+
+// {name1="val1",name2="val2"}, or "" for no labels
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for idx, name := range names {
+		val := ""
+		if idx < len(values) {
+			val = values[idx]
+		}
+		pairs[idx] = fmt.Sprintf(`%s="%s"`, name, escapeLabelValue(val))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(val float64) string {
+	switch {
+	case math.IsInf(val, 1):
+		return "+Inf"
+	case math.IsInf(val, -1):
+		return "-Inf"
+	case math.IsNaN(val):
+		return "NaN"
+	}
+	return strconv.FormatFloat(val, 'g', -1, 64)
+}
+
+func writeHeader(buf *bytes.Buffer, name string, help string, metricType string) {
+	buf.WriteString(fmt.Sprintf("# HELP %s %s\n", name, strings.ReplaceAll(help, "\n", " ")))
+	buf.WriteString(fmt.Sprintf("# TYPE %s %s\n", name, metricType))
+}
+
+// a counter with optional labels (a value per combination of label values)
+type Counter struct {
+	Name       string
+	Help       string
+	LabelNames []string
+
+	lock   *sync.Mutex
+	values map[string]*counterValue // key is the joined label values
+}
+
+type counterValue struct {
+	LabelValues []string
+	Value       float64
+}
+
+func NewCounter(name string, help string, labelNames ...string) *Counter {
+	c := &Counter{Name: name, Help: help, LabelNames: labelNames, lock: &sync.Mutex{}, values: make(map[string]*counterValue)}
+	register(c)
+	return c
+}
+
+func (c *Counter) getName() string {
+	return c.Name
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	key := strings.Join(labelValues, "\x00")
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	cv := c.values[key]
+	if cv == nil {
+		cv = &counterValue{LabelValues: slices.Clone(labelValues)}
+		c.values[key] = cv
+	}
+	cv.Value += delta
+}
+
+func (c *Counter) Get(labelValues ...string) float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if cv := c.values[strings.Join(labelValues, "\x00")]; cv != nil {
+		return cv.Value
+	}
+	return 0
+}
+
+func (c *Counter) writeTo(buf *bytes.Buffer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	writeHeader(buf, c.Name, c.Help, MetricType_Counter)
+	if len(c.LabelNames) == 0 && len(c.values) == 0 {
+		buf.WriteString(fmt.Sprintf("%s 0\n", c.Name))
+		return
+	}
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		cv := c.values[key]
+		buf.WriteString(fmt.Sprintf("%s%s %s\n", c.Name, formatLabels(c.LabelNames, cv.LabelValues), formatValue(cv.Value)))
+	}
+}
+
+// a histogram with fixed buckets (upper bounds, ascending)
+type Histogram struct {
+	Name    string
+	Help    string
+	Buckets []float64
+
+	lock   *sync.Mutex
+	counts []uint64 // per bucket (not cumulative), the last is +Inf
+	sum    float64
+	count  uint64
+}
+
+func NewHistogram(name string, help string, buckets []float64) *Histogram {
+	h := &Histogram{Name: name, Help: help, Buckets: buckets, lock: &sync.Mutex{}, counts: make([]uint64, len(buckets)+1)}
+	register(h)
+	return h
+}
+
+func (h *Histogram) getName() string {
+	return h.Name
+}
+
+func (h *Histogram) Observe(val float64) {
+	idx := sort.SearchFloat64s(h.Buckets, val)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.counts[idx]++
+	h.sum += val
+	h.count++
+}
+
+func (h *Histogram) writeTo(buf *bytes.Buffer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	writeHeader(buf, h.Name, h.Help, MetricType_Histogram)
+	var cumulative uint64
+	for idx, bound := range h.Buckets {
+		cumulative += h.counts[idx]
+		buf.WriteString(fmt.Sprintf("%s_bucket{le=\"%s\"} %d\n", h.Name, formatValue(bound), cumulative))
+	}
+	buf.WriteString(fmt.Sprintf("%s_bucket{le=\"+Inf\"} %d\n", h.Name, h.count))
+	buf.WriteString(fmt.Sprintf("%s_sum %s\n", h.Name, formatValue(h.sum)))
+	buf.WriteString(fmt.Sprintf("%s_count %d\n", h.Name, h.count))
+}
+
+type GaugeSample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// returns the current values of a gauge (collected when the metrics are scraped)
+type GaugeFnType func() []GaugeSample
+
+type gaugeFunc struct {
+	Name       string
+	Help       string
+	LabelNames []string
+	Fn         GaugeFnType
+}
+
+// registers a gauge collected by fn (a gauge without labels returns one sample)
+func RegisterGaugeFunc(name string, help string, fn GaugeFnType, labelNames ...string) {
+	register(&gaugeFunc{Name: name, Help: help, LabelNames: labelNames, Fn: fn})
+}
+
+func (g *gaugeFunc) getName() string {
+	return g.Name
+}
+
+func (g *gaugeFunc) writeTo(buf *bytes.Buffer) {
+	samples := g.Fn()
+	if samples == nil {
+		// not available
+		return
+	}
+	writeHeader(buf, g.Name, g.Help, MetricType_Gauge)
+	for _, sample := range samples {
+		buf.WriteString(fmt.Sprintf("%s%s %s\n", g.Name, formatLabels(g.LabelNames, sample.LabelValues), formatValue(sample.Value)))
+	}
+}
+
+var numScrapes atomic.Int64
+
+// writes all the metrics (sorted by name) in the prometheus text format
+func WriteMetrics(w io.Writer) error {
+	registryLock.Lock()
+	metrics := make([]metric, 0, len(registry))
+	for _, m := range registry {
+		metrics = append(metrics, m)
+	}
+	registryLock.Unlock()
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].getName() < metrics[j].getName()
+	})
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		m.writeTo(&buf)
+	}
+	numScrapes.Add(1)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func GetNumScrapes() int64 {
+	return numScrapes.Load()
+}
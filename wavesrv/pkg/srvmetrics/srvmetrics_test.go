@@ -0,0 +1,144 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package srvmetrics
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func writeMetricsStr(t *testing.T) string {
+	var buf bytes.Buffer
+	err := WriteMetrics(&buf)
+	if err != nil {
+		t.Fatalf("WriteMetrics error: %v", err)
+	}
+	return buf.String()
+}
+
+func assertContains(t *testing.T, output string, expected string) {
+	t.Helper()
+	if !strings.Contains(output, expected) {
+		t.Errorf("metrics output missing %q:\n%s", expected, output)
+	}
+}
+
+func TestCounter(t *testing.T) {
+	c := NewCounter("test_counter_total", "A test counter.", "kind")
+	c.Inc("a")
+	c.Add(2, "a")
+	c.Inc(`quo"te\n`)
+	c.Add(-1, "a")
+	if c.Get("a") != 3 {
+		t.Errorf("wrong counter value: %v", c.Get("a"))
+	}
+	output := writeMetricsStr(t)
+	assertContains(t, output, "# HELP test_counter_total A test counter.\n# TYPE test_counter_total counter\n")
+	assertContains(t, output, "test_counter_total{kind=\"a\"} 3\n")
+	assertContains(t, output, `test_counter_total{kind="quo\"te\\n"} 1`+"\n")
+
+	NewCounter("test_empty_total", "An unused counter.")
+	assertContains(t, writeMetricsStr(t), "test_empty_total 0\n")
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_latency_seconds", "A test histogram.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.1)
+	h.Observe(0.5)
+	h.Observe(5)
+	output := writeMetricsStr(t)
+	assertContains(t, output, "# TYPE test_latency_seconds histogram\n")
+	assertContains(t, output, "test_latency_seconds_bucket{le=\"0.1\"} 2\n")
+	assertContains(t, output, "test_latency_seconds_bucket{le=\"1\"} 3\n")
+	assertContains(t, output, "test_latency_seconds_bucket{le=\"+Inf\"} 4\n")
+	assertContains(t, output, "test_latency_seconds_sum 5.65\n")
+	assertContains(t, output, "test_latency_seconds_count 4\n")
+}
+
+func TestGaugeFunc(t *testing.T) {
+	RegisterGaugeFunc("test_gauge", "A test gauge.", func() []GaugeSample {
+		return []GaugeSample{{LabelValues: []string{"x"}, Value: 1.5}, {LabelValues: []string{"y"}, Value: 2}}
+	}, "name")
+	RegisterGaugeFunc("test_unavailable_gauge", "A gauge without a value.", func() []GaugeSample {
+		return nil
+	})
+	output := writeMetricsStr(t)
+	assertContains(t, output, "# TYPE test_gauge gauge\ntest_gauge{name=\"x\"} 1.5\ntest_gauge{name=\"y\"} 2\n")
+	if strings.Contains(output, "test_unavailable_gauge") {
+		t.Errorf("unavailable gauge written:\n%s", output)
+	}
+	if strings.Index(output, "test_gauge") > strings.Index(output, "test_latency_seconds") {
+		t.Errorf("metrics not sorted by name:\n%s", output)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	NewCounter("test_dup_total", "A counter.")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("no panic registering a metric twice")
+		}
+	}()
+	NewCounter("test_dup_total", "A counter.")
+}
+
+func TestServer(t *testing.T) {
+	err := SetPort(-1)
+	if err == nil {
+		t.Errorf("no error for an invalid port")
+	}
+	// find a free port
+	err = SetPort(0)
+	if err != nil {
+		t.Fatalf("SetPort(0) error: %v", err)
+	}
+	if GetStatus().Running {
+		t.Fatalf("server running after SetPort(0)")
+	}
+	var port int
+	for port = 19300; port < 19400; port++ {
+		if SetPort(port) == nil {
+			break
+		}
+	}
+	defer SetPort(0)
+	status := GetStatus()
+	if !status.Running || status.Addr != GetListenAddr(port) {
+		t.Fatalf("server not running: %+v", status)
+	}
+	resp, err := http.Get("http://" + status.Addr + MetricsPath)
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != ContentType {
+		t.Errorf("bad response: %v %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), "# TYPE") {
+		t.Errorf("no metrics in the response:\n%s", body)
+	}
+	// a port in use does not stop the running endpoint
+	busyListener, err := net.Listen("tcp", GetListenAddr(0))
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+	defer busyListener.Close()
+	err = SetPort(busyListener.Addr().(*net.TCPAddr).Port)
+	if err == nil {
+		t.Errorf("no error for a port in use")
+	}
+	if status := GetStatus(); !status.Running || status.Addr != GetListenAddr(port) {
+		t.Errorf("server not kept after a failed port change: %+v", status)
+	}
+	err = SetPort(0)
+	if err != nil || GetStatus().Running {
+		t.Errorf("server not stopped: %v", err)
+	}
+}
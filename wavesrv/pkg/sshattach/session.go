@@ -0,0 +1,498 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sshattach
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/screenattach"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+	"golang.org/x/crypto/ssh"
+)
+
+const DetachKey = 0x1d // C-]
+const AttachTailSize = 16 * 1024
+const sessionTimeout = 10 * time.Second
+const promptStr = "$ "
+
+var errInputClosed = errors.New("input closed")
+var errServerStopped = errors.New("server stopped")
+
+type attachSession struct {
+	Server  *attachServer
+	Channel ssh.Channel
+	Key     string // scbus channel key
+	HasPty  bool
+	InputCh chan []byte
+	DoneCh  chan struct{} // closed when the session ends
+
+	// only used by the session's goroutine
+	LineEditor  screenattach.LineEditor
+	PromptShown bool
+	PrintedCmds map[string]bool // lineids of the command lines already shown
+	SentCmdStr  string          // the last command line run from this session (not shown again)
+}
+
+// one entry in the chooser
+type screenEntry struct {
+	SessionName string
+	ScreenId    string
+	ScreenName  string
+	RunningCmd  string
+}
+
+func (entry *screenEntry) fullName() string {
+	return entry.SessionName + "/" + entry.ScreenName
+}
+
+func (sess *attachSession) write(str string) {
+	sess.Channel.Write([]byte(str))
+}
+
+func (sess *attachSession) handleRequests(reqs <-chan *ssh.Request) {
+	started := false
+	for req := range reqs {
+		switch req.Type {
+		case "pty-req":
+			sess.HasPty = true
+			req.Reply(true, nil)
+
+		case "window-change":
+			// the size of the screen's commands is set by the app
+			req.Reply(true, nil)
+
+		case "shell", "exec":
+			if started {
+				req.Reply(false, nil)
+				continue
+			}
+			var execCmd string
+			if req.Type == "exec" {
+				var payload struct{ Command string }
+				err := ssh.Unmarshal(req.Payload, &payload)
+				if err != nil {
+					req.Reply(false, nil)
+					continue
+				}
+				execCmd = strings.TrimSpace(payload.Command)
+			}
+			started = true
+			req.Reply(true, nil)
+			go sess.run(execCmd)
+
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+func (sess *attachSession) readLoop() {
+	defer close(sess.InputCh)
+	buf := make([]byte, 4096)
+	for {
+		n, err := sess.Channel.Read(buf)
+		if n > 0 {
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			select {
+			case sess.InputCh <- data:
+			case <-sess.DoneCh:
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (sess *attachSession) run(execCmd string) {
+	defer sess.Channel.Close()
+	defer close(sess.DoneCh)
+	go sess.readLoop()
+	exitCode := 0
+	if execCmd != "" {
+		exitCode = sess.runExec(execCmd)
+	} else {
+		sess.runChooser()
+	}
+	sess.Channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(exitCode)}))
+}
+
+// the open screens of all open sessions (chooser order)
+func listScreens(ctx context.Context) ([]*screenEntry, error) {
+	sessions, err := sstore.GetBareSessions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var rtn []*screenEntry
+	for _, session := range sessions {
+		if session.Archived {
+			continue
+		}
+		screens, err := sstore.GetSessionScreens(ctx, session.SessionId)
+		if err != nil {
+			return nil, err
+		}
+		for _, screen := range screens {
+			if screen.Archived {
+				continue
+			}
+			entry := &screenEntry{SessionName: session.Name, ScreenId: screen.ScreenId, ScreenName: screen.Name}
+			cmd, err := screenattach.GetRunningCmd(ctx, screen.ScreenId)
+			if err != nil {
+				return nil, err
+			}
+			if cmd != nil {
+				entry.RunningCmd = cmd.CmdStr
+			}
+			rtn = append(rtn, entry)
+		}
+	}
+	return rtn, nil
+}
+
+func formatScreenList(entries []*screenEntry) string {
+	var buf strings.Builder
+	for idx, entry := range entries {
+		buf.WriteString(fmt.Sprintf("  %2d) %s", idx+1, entry.fullName()))
+		if entry.RunningCmd != "" {
+			buf.WriteString(fmt.Sprintf("  [running: %s]", firstLine(entry.RunningCmd)))
+		}
+		buf.WriteString("\r\n")
+	}
+	return buf.String()
+}
+
+func firstLine(str string) string {
+	str, _, _ = strings.Cut(str, "\n")
+	return str
+}
+
+// target is a chooser number, session/screen, or a screen name (must be unique)
+func resolveTarget(entries []*screenEntry, target string) (*screenEntry, error) {
+	if num, err := strconv.Atoi(target); err == nil {
+		if num < 1 || num > len(entries) {
+			return nil, fmt.Errorf("no screen %d", num)
+		}
+		return entries[num-1], nil
+	}
+	var nameMatches []*screenEntry
+	for _, entry := range entries {
+		if entry.fullName() == target {
+			return entry, nil
+		}
+		if entry.ScreenName == target {
+			nameMatches = append(nameMatches, entry)
+		}
+	}
+	if len(nameMatches) == 1 {
+		return nameMatches[0], nil
+	}
+	if len(nameMatches) > 1 {
+		return nil, fmt.Errorf("screen name %q is ambiguous, use session/screen", target)
+	}
+	return nil, fmt.Errorf("screen %q not found", target)
+}
+
+// `ls` or `[-r] target`, returns the exit code
+func (sess *attachSession) runExec(execCmd string) int {
+	ctx, cancelFn := context.WithTimeout(context.Background(), sessionTimeout)
+	entries, err := listScreens(ctx)
+	cancelFn()
+	if err != nil {
+		sess.write(fmt.Sprintf("error listing screens: %v\r\n", err))
+		return 1
+	}
+	if execCmd == "ls" {
+		sess.write(formatScreenList(entries))
+		return 0
+	}
+	readOnly := false
+	if target, found := strings.CutPrefix(execCmd, "-r "); found {
+		readOnly = true
+		execCmd = strings.TrimSpace(target)
+	}
+	entry, err := resolveTarget(entries, execCmd)
+	if err != nil {
+		sess.write(fmt.Sprintf("%v\r\n", err))
+		return 1
+	}
+	err = sess.attach(entry, readOnly)
+	if err != nil && err != errInputClosed {
+		sess.write(fmt.Sprintf("%v\r\n", err))
+		return 1
+	}
+	return 0
+}
+
+func (sess *attachSession) runChooser() {
+	for {
+		ctx, cancelFn := context.WithTimeout(context.Background(), sessionTimeout)
+		entries, err := listScreens(ctx)
+		cancelFn()
+		if err != nil {
+			sess.write(fmt.Sprintf("error listing screens: %v\r\n", err))
+			return
+		}
+		if len(entries) == 0 {
+			sess.write("no open screens\r\n")
+			return
+		}
+		sess.write("\r\nwave screens (number or name to attach, add \" r\" for read-only, q to quit):\r\n")
+		sess.write(formatScreenList(entries))
+		line, ok := sess.readLine("attach> ")
+		if !ok {
+			return
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if line == "q" || line == "quit" || line == "exit" {
+			return
+		}
+		readOnly := false
+		if target, found := strings.CutSuffix(line, " r"); found {
+			readOnly = true
+			line = strings.TrimSpace(target)
+		}
+		entry, err := resolveTarget(entries, line)
+		if err != nil {
+			sess.write(fmt.Sprintf("%v\r\n", err))
+			continue
+		}
+		err = sess.attach(entry, readOnly)
+		if err == errInputClosed || err == errServerStopped {
+			return
+		}
+		if err != nil {
+			sess.write(fmt.Sprintf("%v\r\n", err))
+		}
+	}
+}
+
+// returns false if the input is closed (or C-d on an empty line)
+func (sess *attachSession) readLine(prompt string) (string, bool) {
+	sess.LineEditor.Reset()
+	sess.write(prompt)
+	for input := range sess.InputCh {
+		for _, ch := range input {
+			if ch == 0x04 && len(sess.LineEditor.Buf) == 0 {
+				sess.write("\r\n")
+				return "", false
+			}
+			echo, line, entered := sess.LineEditor.Edit(ch)
+			if sess.HasPty {
+				sess.write(echo)
+			}
+			if entered {
+				return line, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (sess *attachSession) showPrompt() {
+	if sess.PromptShown {
+		return
+	}
+	sess.write("\r\n" + promptStr)
+	sess.PromptShown = true
+}
+
+// runs until detached (C-]), the screen is closed, or the input is closed
+func (sess *attachSession) attach(entry *screenEntry, readOnly bool) error {
+	if scbase.IsReadOnly() {
+		readOnly = true
+	}
+	screenId := entry.ScreenId
+	// registered before the snapshot so no output is missed
+	updateCh := scbus.MainUpdateBus.RegisterChannel(sess.Key, &scbus.UpdateChannel{ScreenId: screenId})
+	defer scbus.MainUpdateBus.UnregisterChannel(sess.Key)
+	sess.LineEditor.Reset()
+	sess.PromptShown = false
+	sess.PrintedCmds = make(map[string]bool)
+	sess.SentCmdStr = ""
+	mode := "interactive"
+	if readOnly {
+		mode = "read-only"
+	}
+	sess.write(fmt.Sprintf("\r\n[attached to %s (%s), C-] to detach]\r\n", entry.fullName(), mode))
+	ctx, cancelFn := context.WithTimeout(context.Background(), sessionTimeout)
+	err := sess.writeSnapshot(ctx, screenId)
+	var runningCmd *sstore.CmdType
+	if err == nil {
+		runningCmd, err = screenattach.GetRunningCmd(ctx, screenId)
+	}
+	cancelFn()
+	if err != nil {
+		return err
+	}
+	if runningCmd == nil && !readOnly {
+		sess.showPrompt()
+	}
+	for {
+		select {
+		case update, ok := <-updateCh:
+			if !ok {
+				sess.write("\r\n[server stopped]\r\n")
+				return errServerStopped
+			}
+			if sess.handleUpdate(screenId, update, readOnly) {
+				sess.write("\r\n[screen closed]\r\n")
+				return nil
+			}
+
+		case input, ok := <-sess.InputCh:
+			if !ok {
+				return errInputClosed
+			}
+			detachIdx := strings.IndexByte(string(input), DetachKey)
+			if detachIdx != -1 {
+				input = input[:detachIdx]
+			}
+			if !readOnly && len(input) > 0 {
+				err := sess.handleInput(screenId, input)
+				if err != nil {
+					sess.write(fmt.Sprintf("\r\n[error: %v]\r\n", err))
+				}
+			}
+			if detachIdx != -1 {
+				sess.write("\r\n[detached]\r\n")
+				return nil
+			}
+		}
+	}
+}
+
+// the tail of the screen's last command output
+func (sess *attachSession) writeSnapshot(ctx context.Context, screenId string) error {
+	lastCmd, err := sstore.GetLastScreenCmd(ctx, screenId)
+	if err != nil || lastCmd == nil {
+		return err
+	}
+	sess.PrintedCmds[lastCmd.LineId] = true
+	_, data, err := sstore.ReadFullPtyOutFile(ctx, screenId, lastCmd.LineId)
+	if err != nil {
+		// no output yet
+		return nil
+	}
+	sess.write(fmt.Sprintf("%s%s\r\n", promptStr, firstLine(lastCmd.CmdStr)))
+	tailStart := max(0, len(data)-AttachTailSize)
+	sess.Channel.Write(data[tailStart:])
+	return nil
+}
+
+// returns true if the screen was closed (archived or deleted)
+func (sess *attachSession) handleUpdate(screenId string, update scbus.UpdatePacket, readOnly bool) bool {
+	switch upk := update.(type) {
+	case *scbus.PtyDataUpdatePacketType:
+		if upk.Data == nil || upk.Data.ScreenId != screenId || upk.Data.LineId == "" {
+			return false
+		}
+		data, err := upk.Data.GetPtyData()
+		if err != nil || len(data) == 0 {
+			return false
+		}
+		sess.Channel.Write(data)
+		sess.PromptShown = false
+	case *scbus.ModelUpdatePacketType:
+		if upk.IsEmpty() {
+			return false
+		}
+		for _, item := range *upk.Data {
+			switch uitem := item.(type) {
+			case sstore.ScreenType:
+				if uitem.ScreenId == screenId && (uitem.Remove || uitem.Archived) {
+					return true
+				}
+			case sstore.LineUpdate:
+				if uitem.Line.ScreenId == screenId && uitem.Cmd.IsRunning() && !sess.PrintedCmds[uitem.Line.LineId] {
+					sess.showNewCmd(uitem.Line.LineId, uitem.Cmd.CmdStr)
+				}
+			case sstore.CmdType:
+				if uitem.ScreenId == screenId && uitem.Status != "" && !uitem.IsRunning() && !readOnly {
+					sess.showPromptIfIdle(screenId)
+				}
+			}
+		}
+	}
+	return false
+}
+
+// a command started on the screen (from here, the app, or another client)
+func (sess *attachSession) showNewCmd(lineId string, cmdStr string) {
+	sess.PrintedCmds[lineId] = true
+	if cmdStr == sess.SentCmdStr {
+		// already echoed
+		sess.SentCmdStr = ""
+		return
+	}
+	if !sess.PromptShown {
+		sess.write("\r\n" + promptStr)
+	}
+	sess.write(firstLine(cmdStr) + "\r\n")
+	sess.PromptShown = false
+}
+
+func (sess *attachSession) showPromptIfIdle(screenId string) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), sessionTimeout)
+	defer cancelFn()
+	cmd, err := screenattach.GetRunningCmd(ctx, screenId)
+	if err != nil {
+		log.Printf("[sshattach] error getting running command: %v\n", err)
+		return
+	}
+	if cmd == nil {
+		sess.showPrompt()
+	}
+}
+
+// input goes to the running command, when nothing is running it is edited as a command line
+func (sess *attachSession) handleInput(screenId string, input []byte) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), sessionTimeout)
+	defer cancelFn()
+	cmd, err := screenattach.GetRunningCmd(ctx, screenId)
+	if err != nil {
+		return err
+	}
+	if cmd != nil {
+		return screenattach.SendCmdInput(cmd, input, "")
+	}
+	for _, ch := range input {
+		echo, line, entered := sess.LineEditor.Edit(ch)
+		if sess.HasPty {
+			sess.write(echo)
+		}
+		if !entered {
+			continue
+		}
+		sess.PromptShown = false
+		if strings.TrimSpace(line) == "" {
+			sess.showPrompt()
+			continue
+		}
+		sess.SentCmdStr = line
+		err = sess.Server.RunCmdFn(ctx, screenId, line)
+		if err != nil {
+			sess.SentCmdStr = ""
+			sess.write(fmt.Sprintf("error: %v\r\n", err))
+		}
+		// a command that failed to start does not create a running command
+		sess.showPromptIfIdle(screenId)
+	}
+	return nil
+}
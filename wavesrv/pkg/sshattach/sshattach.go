@@ -0,0 +1,261 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// an embedded ssh server for attaching to wave screens from a machine without the app installed
+// (`ssh -p 1639 user@host`).  started with /client:set sshattach=on (or a port / host:port).  "on" and a port
+// listen on loopback (SshAttachDefaultHost, reachable through ssh port forwarding), other machines can only
+// connect when an address is given (e.g. 0.0.0.0:1639).  only public key auth is supported, the allowed keys are read from [wavehome]/authorized_keys (or ~/.ssh/authorized_keys if
+// it does not exist) on each connection.  the host key is generated on first start ([wavehome]/ssh_host_ed25519_key).
+//
+// a shell session shows a chooser with the open screens, a screen is attached interactively or read-only
+// ("r" suffix, always read-only when wavesrv is read-only).  an attached session shows the tail of the screen's
+// last command output followed by the live pty output.  input goes to the screen's running command, when
+// nothing is running it is edited as a command line and run as a shell command on enter (no metacommands).  C-] detaches (back to the chooser).
+// an exec request attaches directly: `ssh -t -p 1639 host [-r] [session/]screen` (or `ls` to list the screens).
+// the user name is ignored.
+package sshattach
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/base"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/screenattach"
+	"golang.org/x/crypto/ssh"
+)
+
+const SshAttachDefaultPort = 1639 // P=16, S=39 (see MainServerAddr)
+const SshAttachDefaultHost = "127.0.0.1"
+const HostKeyFileName = "ssh_host_ed25519_key"
+const AuthorizedKeysFileName = "authorized_keys"
+const HandshakeTimeout = 15 * time.Second
+
+type StatusType struct {
+	Running    bool
+	Addr       string
+	NumClients int
+}
+
+type attachServer struct {
+	Listener net.Listener
+	Addr     string
+	Config   *ssh.ServerConfig
+	RunCmdFn screenattach.RunCmdFnType // only runs shell commands
+	Conns    map[net.Conn]bool
+}
+
+var serverLock = &sync.Mutex{}
+var curServer *attachServer
+var sessionCounter int
+
+func GetHostKeyPath() string {
+	return filepath.Join(scbase.GetWaveHomeDir(), HostKeyFileName)
+}
+
+// [wavehome]/authorized_keys, or ~/.ssh/authorized_keys if it does not exist
+func GetAuthorizedKeysPath() string {
+	wavePath := filepath.Join(scbase.GetWaveHomeDir(), AuthorizedKeysFileName)
+	if _, err := os.Stat(wavePath); err == nil {
+		return wavePath
+	}
+	return base.ExpandHomeDir("~/.ssh/" + AuthorizedKeysFileName)
+}
+
+// loads the host key, or generates it on first start
+func loadHostKey() (ssh.Signer, error) {
+	keyPath := GetHostKeyPath()
+	keyBytes, err := os.ReadFile(keyPath)
+	if err == nil {
+		return ssh.ParsePrivateKey(keyBytes)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate host key: %v", err)
+	}
+	pemBlock, err := ssh.MarshalPrivateKey(privateKey, "wave ssh attach")
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(pemBlock), 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot write host key: %v", err)
+	}
+	log.Printf("[sshattach] generated host key %s\n", keyPath)
+	return ssh.NewSignerFromKey(privateKey)
+}
+
+func parseAuthorizedKeys(data []byte) map[string]bool {
+	rtn := make(map[string]bool)
+	for len(data) > 0 {
+		pubKey, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			// no more valid keys
+			break
+		}
+		rtn[string(pubKey.Marshal())] = true
+		data = rest
+	}
+	return rtn
+}
+
+func loadAuthorizedKeys() (map[string]bool, error) {
+	keysPath := GetAuthorizedKeysPath()
+	data, err := os.ReadFile(keysPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %v", keysPath, err)
+	}
+	keys := parseAuthorizedKeys(data)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys found in %s", keysPath)
+	}
+	return keys, nil
+}
+
+// the authorized keys are re-read on each connection (so edits apply without a restart)
+func checkPublicKey(conn ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
+	keys, err := loadAuthorizedKeys()
+	if err != nil {
+		log.Printf("[sshattach] %v\n", err)
+		return nil, fmt.Errorf("no authorized keys")
+	}
+	if !keys[string(pubKey.Marshal())] {
+		log.Printf("[sshattach] rejected key %s from %s\n", ssh.FingerprintSHA256(pubKey), conn.RemoteAddr())
+		return nil, fmt.Errorf("key not authorized")
+	}
+	return &ssh.Permissions{Extensions: map[string]string{"pubkey-fp": ssh.FingerprintSHA256(pubKey)}}, nil
+}
+
+// starts the ssh server on addr (host:port), restarts it if it is running on a different address
+func Start(addr string, runCmdFn screenattach.RunCmdFnType) error {
+	if runCmdFn == nil {
+		return fmt.Errorf("ssh attach requires a command runner")
+	}
+	serverLock.Lock()
+	defer serverLock.Unlock()
+	if curServer != nil {
+		if curServer.Addr == addr {
+			return nil
+		}
+		stopServer_nolock()
+	}
+	_, err := loadAuthorizedKeys()
+	if err != nil {
+		return err
+	}
+	hostKey, err := loadHostKey()
+	if err != nil {
+		return err
+	}
+	config := &ssh.ServerConfig{PublicKeyCallback: checkPublicKey}
+	config.AddHostKey(hostKey)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s: %v", addr, err)
+	}
+	server := &attachServer{Listener: listener, Addr: addr, Config: config, RunCmdFn: runCmdFn, Conns: make(map[net.Conn]bool)}
+	curServer = server
+	log.Printf("[sshattach] listening on %s (host key %s)\n", addr, ssh.FingerprintSHA256(hostKey.PublicKey()))
+	go server.acceptLoop()
+	return nil
+}
+
+func stopServer_nolock() {
+	log.Printf("[sshattach] stopping server\n")
+	curServer.Listener.Close()
+	for conn := range curServer.Conns {
+		conn.Close()
+	}
+	curServer = nil
+}
+
+// stops the server and closes all connections, returns false if it was not running
+func Stop() bool {
+	serverLock.Lock()
+	defer serverLock.Unlock()
+	if curServer == nil {
+		return false
+	}
+	stopServer_nolock()
+	return true
+}
+
+func GetStatus() StatusType {
+	serverLock.Lock()
+	defer serverLock.Unlock()
+	if curServer == nil {
+		return StatusType{}
+	}
+	return StatusType{Running: true, Addr: curServer.Addr, NumClients: len(curServer.Conns)}
+}
+
+func (server *attachServer) acceptLoop() {
+	for {
+		conn, err := server.Listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("[sshattach] accept error: %v\n", err)
+			}
+			return
+		}
+		serverLock.Lock()
+		if curServer != server {
+			// stopped
+			serverLock.Unlock()
+			conn.Close()
+			return
+		}
+		server.Conns[conn] = true
+		serverLock.Unlock()
+		go server.handleConn(conn)
+	}
+}
+
+func (server *attachServer) handleConn(conn net.Conn) {
+	defer func() {
+		serverLock.Lock()
+		delete(server.Conns, conn)
+		serverLock.Unlock()
+		conn.Close()
+	}()
+	conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, server.Config)
+	if err != nil {
+		log.Printf("[sshattach] handshake failed from %s: %v\n", conn.RemoteAddr(), err)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	defer sshConn.Close()
+	log.Printf("[sshattach] connection from %s (key %s)\n", sshConn.RemoteAddr(), sshConn.Permissions.Extensions["pubkey-fp"])
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		channel, chReqs, err := newChannel.Accept()
+		if err != nil {
+			log.Printf("[sshattach] cannot accept channel: %v\n", err)
+			continue
+		}
+		serverLock.Lock()
+		sessionCounter++
+		key := fmt.Sprintf("sshattach-%d", sessionCounter)
+		serverLock.Unlock()
+		sess := &attachSession{Server: server, Channel: channel, Key: key, InputCh: make(chan []byte, 16), DoneCh: make(chan struct{})}
+		go sess.handleRequests(chReqs)
+	}
+	log.Printf("[sshattach] connection closed %s\n", sshConn.RemoteAddr())
+}
@@ -0,0 +1,52 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sshattach
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseAuthorizedKeys(t *testing.T) {
+	pubKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	sshPubKey, err := ssh.NewPublicKey(pubKey)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	data := "# comment\n\n" + string(ssh.MarshalAuthorizedKey(sshPubKey))
+	keys := parseAuthorizedKeys([]byte(data))
+	if len(keys) != 1 || !keys[string(sshPubKey.Marshal())] {
+		t.Errorf("got %d keys", len(keys))
+	}
+	if len(parseAuthorizedKeys([]byte("not a key\n"))) != 0 {
+		t.Errorf("expected no keys")
+	}
+}
+
+func TestResolveTarget(t *testing.T) {
+	entries := []*screenEntry{
+		{SessionName: "work", ScreenName: "build"},
+		{SessionName: "work", ScreenName: "logs"},
+		{SessionName: "home", ScreenName: "logs"},
+	}
+	tests := map[string]*screenEntry{"2": entries[1], "build": entries[0], "home/logs": entries[2]}
+	for target, want := range tests {
+		entry, err := resolveTarget(entries, target)
+		if err != nil || entry != want {
+			t.Errorf("%q got %v %v", target, entry, err)
+		}
+	}
+	for _, target := range []string{"0", "4", "logs", "missing"} {
+		_, err := resolveTarget(entries, target)
+		if err == nil {
+			t.Errorf("%q expected an error", target)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/shellutil"
+)
+
+// asciinema v2 (.cast) export.  the file is a json header line followed by one json line per output event,
+// [time-in-seconds, "o", data].  the events are the same as for playback (see GetCmdPtyEvents).  a screen
+// export plays the commands one after another, each prefixed with a "$ cmdstr" prompt.
+
+const AsciinemaVersion = 2
+
+// idle time between (and within) commands is capped in screen exports
+const AsciinemaScreenMaxIdleMs = 2000
+
+type AsciinemaHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+type castWriter struct {
+	W         io.Writer
+	MaxIdleMs int64 // 0 for no limit
+	Started   bool
+	LastTs    int64  // unix millis of the last event
+	TimeMs    int64  // time since the start of the recording
+	Pending   []byte // incomplete utf-8 sequence from the previous event
+	LastByte  byte
+}
+
+// splits off an incomplete utf-8 sequence at the end of data (pty writes can split multibyte characters)
+func splitIncompleteUtf8(data []byte) ([]byte, []byte) {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return data[:i], data[i:]
+			}
+			break
+		}
+	}
+	return data, nil
+}
+
+func (cw *castWriter) writeHeader(header AsciinemaHeader) error {
+	header.Version = AsciinemaVersion
+	barr, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	_, err = cw.W.Write(append(barr, '\n'))
+	return err
+}
+
+// ts is the unix millis the data was written (events never go back in time)
+func (cw *castWriter) writeEvent(ts int64, data []byte) error {
+	if cw.Started && ts > cw.LastTs {
+		deltaMs := ts - cw.LastTs
+		if cw.MaxIdleMs > 0 && deltaMs > cw.MaxIdleMs {
+			deltaMs = cw.MaxIdleMs
+		}
+		cw.TimeMs += deltaMs
+	}
+	if !cw.Started || ts > cw.LastTs {
+		cw.LastTs = ts
+	}
+	cw.Started = true
+	data, pending := splitIncompleteUtf8(append(cw.Pending, data...))
+	cw.Pending = pending
+	if len(data) == 0 {
+		return nil
+	}
+	cw.LastByte = data[len(data)-1]
+	// invalid utf-8 is replaced with U+FFFD by json.Marshal
+	barr, err := json.Marshal([]any{float64(cw.TimeMs) / 1000, "o", string(data)})
+	if err != nil {
+		return err
+	}
+	_, err = cw.W.Write(append(barr, '\n'))
+	return err
+}
+
+// flushes an incomplete utf-8 sequence at the end of the output
+func (cw *castWriter) flush() error {
+	if len(cw.Pending) == 0 {
+		return nil
+	}
+	pending := cw.Pending
+	cw.Pending = nil
+	barr, err := json.Marshal([]any{float64(cw.TimeMs) / 1000, "o", string(pending)})
+	if err != nil {
+		return err
+	}
+	_, err = cw.W.Write(append(barr, '\n'))
+	return err
+}
+
+// writes the output of a command as events
+func (cw *castWriter) writeCmdOutput(ctx context.Context, line *LineType, cmd *CmdType, startTs int64) error {
+	events, err := GetCmdPtyEvents(ctx, cmd, startTs)
+	if err != nil {
+		return err
+	}
+	encoding := GetLineEncoding(line)
+	for _, event := range events {
+		// single-byte encodings, each event can be decoded on its own
+		data, _ := DecodeToUtf8(event.Data, encoding)
+		err = cw.writeEvent(event.Ts, data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the time the cmd started (or restarted), the start of its output
+func CmdStartTs(line *LineType, cmd *CmdType) int64 {
+	if cmd.RestartTs > 0 {
+		return cmd.RestartTs
+	}
+	return line.Ts
+}
+
+func ExportLineAsciinema(ctx context.Context, screenId string, lineId string, w io.Writer) error {
+	line, cmd, err := GetLineCmdByLineId(ctx, screenId, lineId)
+	if err != nil {
+		return err
+	}
+	if line == nil {
+		return fmt.Errorf("line not found")
+	}
+	if cmd == nil {
+		return fmt.Errorf("line %d is not a command", line.LineNum)
+	}
+	startTs := CmdStartTs(line, cmd)
+	cw := &castWriter{W: w}
+	err = cw.writeHeader(AsciinemaHeader{
+		Width:     int(cmd.TermOpts.Cols),
+		Height:    int(cmd.TermOpts.Rows),
+		Timestamp: startTs / 1000,
+		Command:   cmd.CmdStr,
+		Env:       map[string]string{"TERM": shellutil.DefaultTermType},
+	})
+	if err != nil {
+		return err
+	}
+	cw.Started = true
+	cw.LastTs = startTs
+	err = cw.writeCmdOutput(ctx, line, cmd, startTs)
+	if err != nil {
+		return err
+	}
+	return cw.flush()
+}
+
+// returns the number of commands exported
+func ExportScreenAsciinema(ctx context.Context, screenId string, w io.Writer) (int, error) {
+	screen, err := GetScreenById(ctx, screenId)
+	if err != nil {
+		return 0, err
+	}
+	if screen == nil {
+		return 0, fmt.Errorf("screen not found")
+	}
+	screenLines, err := GetScreenLinesById(ctx, screenId)
+	if err != nil {
+		return 0, err
+	}
+	cmdMap := make(map[string]*CmdType)
+	for _, cmd := range screenLines.Cmds {
+		cmdMap[cmd.LineId] = cmd
+	}
+	type lineCmd struct {
+		Line *LineType
+		Cmd  *CmdType
+	}
+	var lineCmds []lineCmd
+	header := AsciinemaHeader{Title: screen.Name, Env: map[string]string{"TERM": shellutil.DefaultTermType}}
+	for _, line := range screenLines.Lines {
+		cmd := cmdMap[line.LineId]
+		if line.Archived || cmd == nil {
+			continue
+		}
+		lineCmds = append(lineCmds, lineCmd{Line: line, Cmd: cmd})
+		header.Width = max(header.Width, int(cmd.TermOpts.Cols))
+		header.Height = max(header.Height, int(cmd.TermOpts.Rows))
+		if header.Timestamp == 0 {
+			header.Timestamp = CmdStartTs(line, cmd) / 1000
+		}
+	}
+	if len(lineCmds) == 0 {
+		return 0, fmt.Errorf("screen has no commands to export")
+	}
+	cw := &castWriter{W: w, MaxIdleMs: AsciinemaScreenMaxIdleMs}
+	err = cw.writeHeader(header)
+	if err != nil {
+		return 0, err
+	}
+	for _, lc := range lineCmds {
+		startTs := CmdStartTs(lc.Line, lc.Cmd)
+		prompt := "$ " + strings.ReplaceAll(lc.Cmd.CmdStr, "\n", "\r\n") + "\r\n"
+		if cw.Started && cw.LastByte != '\n' {
+			prompt = "\r\n" + prompt
+		}
+		err = cw.flush()
+		if err == nil {
+			err = cw.writeEvent(startTs, []byte(prompt))
+		}
+		if err == nil {
+			err = cw.writeCmdOutput(ctx, lc.Line, lc.Cmd, startTs)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(lineCmds), cw.flush()
+}
@@ -0,0 +1,90 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+)
+
+// screens with no activity for autoarchivedays days (client opt, 0 disables) are archived by a background job.
+// a screen's last activity is the latest of its lastactivets (created, switched to, un-archived, or restored
+// from the trash), its newest line, and its last command start/finish.  pinned screens (screenopts), a
+// session's active screen, web-shared screens, and screens with running commands are never archived.
+
+type IdleScreenType struct {
+	SessionId    string `json:"sessionid"`
+	SessionName  string `json:"sessionname"`
+	ScreenId     string `json:"screenid"`
+	Name         string `json:"name"`
+	LastActiveTs int64  `json:"lastactivets"`
+}
+
+func (IdleScreenType) UseDBMap() {}
+
+// the screens (in open sessions) idle for at least idleDays, oldest activity first
+func GetIdleScreens(ctx context.Context, idleDays int) ([]*IdleScreenType, error) {
+	cutoffTs := time.Now().Add(-time.Duration(idleDays) * 24 * time.Hour).UnixMilli()
+	var rtn []*IdleScreenType
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT * FROM (
+		            SELECT s.sessionid, se.name AS sessionname, s.screenid, s.name,
+		                   max(s.lastactivets,
+		                       COALESCE((SELECT max(ts) FROM line WHERE line.screenid = s.screenid), 0),
+		                       COALESCE((SELECT max(max(donets, restartts)) FROM cmd WHERE cmd.screenid = s.screenid), 0)) AS lastactivets
+		            FROM screen s
+		            JOIN session se ON se.sessionid = s.sessionid
+		            WHERE NOT s.archived AND NOT se.archived
+		              AND s.screenid <> se.activescreenid
+		              AND NOT COALESCE(json_extract(s.screenopts, '$.pinned'), 0)
+		              AND s.sharemode <> ?
+		              AND NOT EXISTS (SELECT 1 FROM cmd WHERE cmd.screenid = s.screenid AND cmd.status IN (?, ?))
+		          )
+		          WHERE lastactivets < ?
+		          ORDER BY lastactivets`
+		rtn = dbutil.SelectMappable[*IdleScreenType](tx, query, ShareModeWeb, CmdStatusRunning, CmdStatusDetached, cutoffTs)
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	return rtn, nil
+}
+
+// archives the screens idle for at least idleDays, returns the archived screens and the model update.
+// a screen that cannot be archived (e.g. it became active) is skipped.
+func ArchiveIdleScreens(ctx context.Context, idleDays int) ([]*IdleScreenType, *scbus.ModelUpdatePacketType, error) {
+	idleScreens, err := GetIdleScreens(ctx, idleDays)
+	if err != nil {
+		return nil, nil, err
+	}
+	update := scbus.MakeUpdatePacket()
+	var archived []*IdleScreenType
+	for _, idleScreen := range idleScreens {
+		screenUpdate, err := ArchiveScreen(ctx, idleScreen.SessionId, idleScreen.ScreenId)
+		if err != nil {
+			log.Printf("[autoarchive] cannot archive screen %s: %v\n", idleScreen.ScreenId, err)
+			continue
+		}
+		update.Merge(screenUpdate)
+		archived = append(archived, idleScreen)
+	}
+	return archived, update, nil
+}
+
+// the background job, does nothing if autoarchivedays is not set
+func RunAutoArchive(ctx context.Context) ([]*IdleScreenType, *scbus.ModelUpdatePacketType, error) {
+	clientData, err := EnsureClientData(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if clientData.ClientOpts.AutoArchiveDays <= 0 {
+		return nil, nil, nil
+	}
+	return ArchiveIdleScreens(ctx, clientData.ClientOpts.AutoArchiveDays)
+}
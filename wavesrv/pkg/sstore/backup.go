@@ -0,0 +1,465 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/blockstore"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+)
+
+// online backups of the wave db, the blockstore db, and the screen dirs.  the dbs are copied with the sqlite
+// backup api (a consistent snapshot while wave is running).  each backup is a directory in the backup dir
+// (client opt backupdir, default [wavehome]/backups) named waveterm-backup-[time], written under a temp name
+// and renamed when it is complete, its backup.json manifest marks it as a backup.  scheduled backups run
+// every backuphours hours (0 = off), the newest backupkeep backups are kept.
+//
+// a restore is staged (the backup is checked and copied to [wavehome]/restore-pending) and applied on the next
+// start, before the db is opened (ApplyPendingRestore).  the current files are moved to prerestore-[time] first,
+// so a restore can be undone by hand.
+
+const BackupNamePrefix = "waveterm-backup-"
+const BackupManifestName = "backup.json"
+const BackupDirBaseName = "backups"
+const DefaultBackupKeep = 5
+const RestorePendingDirName = "restore-pending"
+const PreRestoreDirPrefix = "prerestore-"
+const backupTimeFormat = "20060102-150405"
+
+type BackupInfoType struct {
+	Name           string `json:"name"`
+	Dir            string `json:"-"`
+	CreatedTs      int64  `json:"createdts"`
+	DBVersion      uint   `json:"dbversion"`
+	WaveVersion    string `json:"waveversion"`
+	HasBlockstore  bool   `json:"hasblockstore"`
+	NumScreenFiles int    `json:"numscreenfiles"`
+	Size           int64  `json:"size"`
+}
+
+var backupLock = &sync.Mutex{}
+
+func GetDefaultBackupDir() string {
+	return filepath.Join(scbase.GetWaveHomeDir(), BackupDirBaseName)
+}
+
+func GetBackupDir(opts ClientOptsType) string {
+	if opts.BackupDir != "" {
+		return opts.BackupDir
+	}
+	return GetDefaultBackupDir()
+}
+
+func GetBackupKeep(opts ClientOptsType) int {
+	if opts.BackupKeep > 0 {
+		return opts.BackupKeep
+	}
+	return DefaultBackupKeep
+}
+
+// copies a live db to dstFile with the sqlite backup api
+func backupSqliteDB(ctx context.Context, srcDB *sqlx.DB, dstFile string) error {
+	dstDB, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=rwc", dstFile))
+	if err != nil {
+		return err
+	}
+	defer dstDB.Close()
+	dstConn, err := dstDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+	srcConn, err := srcDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+	return dstConn.Raw(func(dstDriverConn any) error {
+		return srcConn.Raw(func(srcDriverConn any) error {
+			dstSqliteConn, ok := dstDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("invalid db connection type %T", dstDriverConn)
+			}
+			srcSqliteConn, ok := srcDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("invalid db connection type %T", srcDriverConn)
+			}
+			backup, err := dstSqliteConn.Backup("main", srcSqliteConn, "main")
+			if err != nil {
+				return err
+			}
+			_, err = backup.Step(-1)
+			if err != nil {
+				backup.Finish()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
+}
+
+// copies the regular files under srcDir, returns the number of files and bytes copied
+func copyDirFiles(srcDir string, dstDir string) (int, int64, error) {
+	var numFiles int
+	var size int64
+	err := filepath.WalkDir(srcDir, func(srcPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(srcDir, srcPath)
+		if err != nil {
+			return err
+		}
+		dstPath := filepath.Join(dstDir, relPath)
+		if entry.IsDir() {
+			return os.MkdirAll(dstPath, 0700)
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		err = copyFile(srcPath, dstPath, true)
+		if err != nil {
+			return err
+		}
+		if info, statErr := os.Stat(dstPath); statErr == nil {
+			size += info.Size()
+		}
+		numFiles++
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return numFiles, size, nil
+	}
+	return numFiles, size, err
+}
+
+func fileSize(fileName string) int64 {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func writeBackupManifest(dir string, info *BackupInfoType) error {
+	barr, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, BackupManifestName), barr, 0600)
+}
+
+func readBackupManifest(dir string) (*BackupInfoType, error) {
+	barr, err := os.ReadFile(filepath.Join(dir, BackupManifestName))
+	if err != nil {
+		return nil, err
+	}
+	var info BackupInfoType
+	err = json.Unmarshal(barr, &info)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup manifest: %w", err)
+	}
+	info.Dir = dir
+	return &info, nil
+}
+
+// creates a new backup in backupDir and removes the oldest backups (keeps the newest keep backups)
+func CreateBackup(ctx context.Context, backupDir string, keep int) (*BackupInfoType, error) {
+	backupLock.Lock()
+	defer backupLock.Unlock()
+	err := os.MkdirAll(backupDir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create backup dir: %w", err)
+	}
+	now := time.Now()
+	name := BackupNamePrefix + now.Format(backupTimeFormat)
+	finalDir := filepath.Join(backupDir, name)
+	if _, err := os.Stat(finalDir); err == nil {
+		return nil, fmt.Errorf("backup %s already exists", name)
+	}
+	tmpDir := filepath.Join(backupDir, "."+name+".tmp")
+	os.RemoveAll(tmpDir)
+	err = os.MkdirAll(tmpDir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	info, err := writeBackupFiles(ctx, tmpDir)
+	if err == nil {
+		info.Name = name
+		info.CreatedTs = now.UnixMilli()
+		err = writeBackupManifest(tmpDir, info)
+	}
+	if err == nil {
+		err = os.Rename(tmpDir, finalDir)
+	}
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, err
+	}
+	info.Dir = finalDir
+	err = rotateBackups(backupDir, keep)
+	if err != nil {
+		log.Printf("[db] error removing old backups: %v\n", err)
+	}
+	return info, nil
+}
+
+func writeBackupFiles(ctx context.Context, dir string) (*BackupInfoType, error) {
+	info := &BackupInfoType{WaveVersion: scbase.WaveVersion}
+	dbVersion, _, err := MigrateVersion(nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get db version: %w", err)
+	}
+	info.DBVersion = dbVersion
+	db, err := GetDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dbFile := filepath.Join(dir, DBFileName)
+	err = backupSqliteDB(ctx, db, dbFile)
+	if err != nil {
+		return nil, fmt.Errorf("error backing up %s: %w", DBFileName, err)
+	}
+	info.Size += fileSize(dbFile)
+	if _, err := os.Stat(blockstore.GetDBName()); err == nil {
+		// the cache holds the writes that are not flushed yet
+		err = blockstore.FlushCache(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error flushing blockstore: %w", err)
+		}
+		bsDB, err := blockstore.GetDB(ctx)
+		if err != nil {
+			return nil, err
+		}
+		bsFile := filepath.Join(dir, blockstore.DBFileName)
+		err = backupSqliteDB(ctx, bsDB, bsFile)
+		if err != nil {
+			return nil, fmt.Errorf("error backing up %s: %w", blockstore.DBFileName, err)
+		}
+		info.HasBlockstore = true
+		info.Size += fileSize(bsFile)
+	}
+	numFiles, size, err := copyDirFiles(filepath.Join(scbase.GetWaveHomeDir(), scbase.ScreensDirBaseName), filepath.Join(dir, scbase.ScreensDirBaseName))
+	if err != nil {
+		return nil, fmt.Errorf("error copying screen dirs: %w", err)
+	}
+	info.NumScreenFiles = numFiles
+	info.Size += size
+	return info, nil
+}
+
+// the backups in backupDir (newest first), dirs without a valid manifest are skipped
+func ListBackups(backupDir string) ([]*BackupInfoType, error) {
+	entries, err := os.ReadDir(backupDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rtn []*BackupInfoType
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), BackupNamePrefix) {
+			continue
+		}
+		info, err := readBackupManifest(filepath.Join(backupDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		rtn = append(rtn, info)
+	}
+	sort.Slice(rtn, func(i, j int) bool {
+		return rtn[i].CreatedTs > rtn[j].CreatedTs
+	})
+	return rtn, nil
+}
+
+func rotateBackups(backupDir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	backups, err := ListBackups(backupDir)
+	if err != nil {
+		return err
+	}
+	for idx := keep; idx < len(backups); idx++ {
+		err = os.RemoveAll(backups[idx].Dir)
+		if err != nil {
+			return err
+		}
+		log.Printf("[db] removed old backup %s\n", backups[idx].Name)
+	}
+	return nil
+}
+
+// for the background loop, creates a backup if scheduled backups are on and the last one is older than
+// backuphours.  returns nil if no backup was due.
+func RunScheduledBackup(ctx context.Context) (*BackupInfoType, error) {
+	clientData, err := EnsureClientData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	opts := clientData.ClientOpts
+	if opts.BackupHours <= 0 {
+		return nil, nil
+	}
+	backupDir := GetBackupDir(opts)
+	backups, err := ListBackups(backupDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(backups) > 0 && time.Since(time.UnixMilli(backups[0].CreatedTs)) < time.Duration(opts.BackupHours)*time.Hour {
+		return nil, nil
+	}
+	return CreateBackup(ctx, backupDir, GetBackupKeep(opts))
+}
+
+// returns the migration version of a backup db (read-only), fails if the db is dirty or corrupt
+func checkBackupDB(dbFile string, checkVersion bool) (uint, error) {
+	db, err := sqlx.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro&_busy_timeout=5000", dbFile))
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	var integrity string
+	err = db.Get(&integrity, `PRAGMA integrity_check`)
+	if err != nil {
+		return 0, fmt.Errorf("cannot check %s: %w", filepath.Base(dbFile), err)
+	}
+	if integrity != "ok" {
+		return 0, fmt.Errorf("%s failed integrity check: %s", filepath.Base(dbFile), integrity)
+	}
+	if !checkVersion {
+		return 0, nil
+	}
+	var versionRow struct {
+		Version uint `db:"version"`
+		Dirty   bool `db:"dirty"`
+	}
+	err = db.Get(&versionRow, `SELECT version, dirty FROM schema_migrations`)
+	if err != nil {
+		return 0, fmt.Errorf("cannot get the db version of %s: %w", filepath.Base(dbFile), err)
+	}
+	if versionRow.Dirty {
+		return 0, fmt.Errorf("%s is dirty (version %d)", filepath.Base(dbFile), versionRow.Version)
+	}
+	return versionRow.Version, nil
+}
+
+// checks a backup and stages it, it is restored on the next start (see ApplyPendingRestore).  the backup must
+// have a manifest, its dbs must pass an integrity check, and its db version must not be newer than this version
+// of wave (older backups are migrated up on start).
+func RestoreFromBackup(ctx context.Context, backupPath string) (*BackupInfoType, error) {
+	if err := scbase.CheckReadOnly("restore a backup"); err != nil {
+		return nil, err
+	}
+	backupLock.Lock()
+	defer backupLock.Unlock()
+	backupPath, err := filepath.Abs(backupPath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := readBackupManifest(backupPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a wave backup: %w", backupPath, err)
+	}
+	dbVersion, err := checkBackupDB(filepath.Join(backupPath, DBFileName), true)
+	if err != nil {
+		return nil, err
+	}
+	if dbVersion > MaxMigration {
+		return nil, fmt.Errorf("backup db version %d is newer than this version of wave (%d)", dbVersion, MaxMigration)
+	}
+	if info.HasBlockstore {
+		_, err = checkBackupDB(filepath.Join(backupPath, blockstore.DBFileName), false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	waveHome := scbase.GetWaveHomeDir()
+	stageDir := filepath.Join(waveHome, RestorePendingDirName)
+	tmpDir := stageDir + ".tmp"
+	os.RemoveAll(tmpDir)
+	_, _, err = copyDirFiles(backupPath, tmpDir)
+	if err == nil {
+		os.RemoveAll(stageDir)
+		err = os.Rename(tmpDir, stageDir)
+	}
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, fmt.Errorf("error staging backup: %w", err)
+	}
+	log.Printf("[db] staged backup %s for restore (applied on the next start)\n", info.Name)
+	return info, nil
+}
+
+func HasPendingRestore() bool {
+	_, err := readBackupManifest(filepath.Join(scbase.GetWaveHomeDir(), RestorePendingDirName))
+	return err == nil
+}
+
+func moveIfExists(srcPath string, dstPath string) error {
+	err := os.Rename(srcPath, dstPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// applies a staged restore, must be called on startup before the dbs are opened (before the migrations).  the
+// current dbs (with their wal files and the blockstore journal) and screen dirs are moved to prerestore-[time].
+func ApplyPendingRestore() error {
+	waveHome := scbase.GetWaveHomeDir()
+	stageDir := filepath.Join(waveHome, RestorePendingDirName)
+	info, err := readBackupManifest(stageDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	preDir := filepath.Join(waveHome, PreRestoreDirPrefix+time.Now().Format(backupTimeFormat))
+	err = os.MkdirAll(preDir, 0700)
+	if err != nil {
+		return err
+	}
+	curFiles := []string{scbase.ScreensDirBaseName}
+	for _, dbFileName := range []string{DBFileName, blockstore.DBFileName} {
+		curFiles = append(curFiles, dbFileName, dbFileName+"-wal", dbFileName+"-shm")
+	}
+	journalFiles, _ := filepath.Glob(filepath.Join(waveHome, blockstore.DBFileName+blockstore.JournalSuffix+".*"))
+	for _, journalFile := range journalFiles {
+		curFiles = append(curFiles, filepath.Base(journalFile))
+	}
+	for _, fileName := range curFiles {
+		err = moveIfExists(filepath.Join(waveHome, fileName), filepath.Join(preDir, fileName))
+		if err != nil {
+			return fmt.Errorf("error moving %s: %w", fileName, err)
+		}
+	}
+	for _, fileName := range []string{DBFileName, blockstore.DBFileName, scbase.ScreensDirBaseName} {
+		err = moveIfExists(filepath.Join(stageDir, fileName), filepath.Join(waveHome, fileName))
+		if err != nil {
+			return fmt.Errorf("error restoring %s: %w", fileName, err)
+		}
+	}
+	os.RemoveAll(stageDir)
+	log.Printf("[db] restored backup %s (the previous files are in %s)\n", info.Name, preDir)
+	return nil
+}
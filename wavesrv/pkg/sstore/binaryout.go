@@ -0,0 +1,185 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/blockstore"
+)
+
+// binary output.  once a running command writes binary (NUL-dense) output, the output is no longer written to
+// the terminal.  the raw output is stored in the blockstore (blockid=screenid, name=rawout:[lineid], up to
+// MaxRawOutSize bytes, starting with the output written before the switch) and the terminal shows a hexdump
+// preview of the first BinaryPreviewSize bytes.  the line state LineState_BinaryOut marks these lines, the
+// raw output can be downloaded with /api/rawout.  when the cmd is done the mime type of the raw output is
+// detected and images get a thumbnail (see blockstore.GeneratePreview), the line state LineState_BinaryMime
+// has the mime type and LineState_BinaryThumb marks lines with a thumbnail (/api/rawout?thumb=1).
+
+const RawOutFilePrefix = "rawout:"
+const RawOutMeta_TotalSize = "totalsize" // total bytes of output, can be larger than the stored size
+
+const MaxRawOutSize = 16 * 1024 * 1024
+const BinaryPreviewSize = 512
+const hexDumpLineSize = 16
+
+// a chunk is binary if at least 1/BinaryNulRatio of its bytes (and at least MinBinaryNuls bytes) are NULs
+const BinaryNulRatio = 20
+const MinBinaryNuls = 4
+
+func rawOutFileName(lineId string) string {
+	return RawOutFilePrefix + lineId
+}
+
+func IsBinaryOutput(data []byte) bool {
+	numNuls := 0
+	for _, b := range data {
+		if b == 0 {
+			numNuls++
+		}
+	}
+	return numNuls >= MinBinaryNuls && numNuls*BinaryNulRatio >= len(data)
+}
+
+// hexdump -C style lines (terminated with \r\n for the terminal), offset is the offset of data[0]
+func HexDump(data []byte, offset int64) string {
+	var buf strings.Builder
+	for lineStart := 0; lineStart < len(data); lineStart += hexDumpLineSize {
+		lineData := data[lineStart:min(lineStart+hexDumpLineSize, len(data))]
+		buf.WriteString(fmt.Sprintf("%08x  ", offset+int64(lineStart)))
+		for i := 0; i < hexDumpLineSize; i++ {
+			if i < len(lineData) {
+				buf.WriteString(fmt.Sprintf("%02x ", lineData[i]))
+			} else {
+				buf.WriteString("   ")
+			}
+			if i == hexDumpLineSize/2-1 {
+				buf.WriteByte(' ')
+			}
+		}
+		buf.WriteString(" |")
+		for _, b := range lineData {
+			if b >= 0x20 && b < 0x7F {
+				buf.WriteByte(b)
+			} else {
+				buf.WriteByte('.')
+			}
+		}
+		buf.WriteString("|\r\n")
+	}
+	return buf.String()
+}
+
+func getRawOutTotalSize(fInfo *blockstore.FileInfo) int64 {
+	switch totalSize := fInfo.Meta[RawOutMeta_TotalSize].(type) {
+	case int64:
+		return totalSize
+	case float64:
+		// meta read from the db
+		return int64(totalSize)
+	}
+	return fInfo.Size
+}
+
+// must hold the line's ptyout lock
+func deleteRawOutFile(ctx context.Context, screenId string, lineId string) {
+	blockstore.DeleteFile(ctx, screenId, rawOutFileName(lineId)) // ignore error, may not exist
+	blockstore.DeleteFile(ctx, screenId, blockstore.ThumbnailFileName(rawOutFileName(lineId)))
+}
+
+// creates the raw output file with the current pty output (the output before the switch to binary)
+func CreateCmdRawOutFile(ctx context.Context, screenId string, lineId string) error {
+	defer lockPtyOutLine(screenId, lineId)()
+	_, data, err := readPtyOutData(ctx, screenId, lineId, 0, MaxRawOutSize)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	name := rawOutFileName(lineId)
+	deleteRawOutFile(ctx, screenId, lineId)
+	meta := blockstore.FileMeta{RawOutMeta_TotalSize: int64(len(data))}
+	err = blockstore.MakeFile(ctx, screenId, name, meta, blockstore.FileOptsType{MaxSize: MaxRawOutSize, IndexMeta: []string{blockstore.MetaKey_MimeType}})
+	if err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		_, err = blockstore.WriteAt(ctx, screenId, name, data, 0)
+	}
+	return err
+}
+
+// data past MaxRawOutSize is only counted.  returns the total size of the output.
+func AppendToCmdRawOut(ctx context.Context, screenId string, lineId string, data []byte) (int64, error) {
+	defer lockPtyOutLine(screenId, lineId)()
+	name := rawOutFileName(lineId)
+	fInfo, err := blockstore.Stat(ctx, screenId, name)
+	if err != nil {
+		return 0, err
+	}
+	if storeLen := min(int64(len(data)), MaxRawOutSize-fInfo.Size); storeLen > 0 {
+		_, err = blockstore.WriteAt(ctx, screenId, name, data[:storeLen], fInfo.Size)
+		if err != nil {
+			return 0, err
+		}
+	}
+	totalSize := getRawOutTotalSize(fInfo) + int64(len(data))
+	fInfo.Meta[RawOutMeta_TotalSize] = totalSize
+	return totalSize, blockstore.WriteMeta(ctx, screenId, name, fInfo.Meta)
+}
+
+// returns (data, total-size, err), total-size is larger than len(data) if the output was truncated
+func ReadCmdRawOut(ctx context.Context, screenId string, lineId string) ([]byte, int64, error) {
+	defer lockPtyOutLine(screenId, lineId)()
+	name := rawOutFileName(lineId)
+	fInfo, err := blockstore.Stat(ctx, screenId, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	data := make([]byte, fInfo.Size)
+	_, err = blockstore.ReadAt(ctx, screenId, name, &data, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, getRawOutTotalSize(fInfo), nil
+}
+
+// returns (mime-type, has-thumbnail, err)
+func GenerateCmdRawOutPreview(ctx context.Context, screenId string, lineId string) (string, bool, error) {
+	defer lockPtyOutLine(screenId, lineId)()
+	fInfo, err := blockstore.GeneratePreview(ctx, screenId, rawOutFileName(lineId))
+	if err != nil {
+		return "", false, err
+	}
+	mimeType, _ := fInfo.Meta[blockstore.MetaKey_MimeType].(string)
+	return mimeType, fInfo.Meta[blockstore.MetaKey_Thumbnail] != nil, nil
+}
+
+// returns the png thumbnail of the raw output
+func ReadCmdRawOutThumbnail(ctx context.Context, screenId string, lineId string) ([]byte, error) {
+	defer lockPtyOutLine(screenId, lineId)()
+	name := blockstore.ThumbnailFileName(rawOutFileName(lineId))
+	fInfo, err := blockstore.Stat(ctx, screenId, name)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, fInfo.Size)
+	_, err = blockstore.ReadAt(ctx, screenId, name, &data, 0)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// returns the detected mime type of the raw output ("" if it was not detected yet)
+func GetCmdRawOutMimeType(ctx context.Context, screenId string, lineId string) string {
+	fInfo, err := blockstore.Stat(ctx, screenId, rawOutFileName(lineId))
+	if err != nil {
+		return ""
+	}
+	mimeType, _ := fInfo.Meta[blockstore.MetaKey_MimeType].(string)
+	return mimeType
+}
@@ -0,0 +1,37 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"log"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/blockstore"
+)
+
+// blockstore flush settings (clientopts blockflushms, blockdirtykb, blocksync).  applied to the blockstore on
+// startup and whenever the client opts change (see blockstore.SetFlushConfig).
+
+const MinBlockFlushMs = 100
+const MaxBlockFlushMs = 60 * 1000
+const MaxBlockDirtyKB = 1024 * 1024
+
+func init() {
+	RegisterClientOptsHook(applyBlockFlushOpts)
+}
+
+func GetBlockFlushConfig(clientOpts ClientOptsType) blockstore.FlushConfig {
+	return blockstore.FlushConfig{
+		FlushTimeout:   time.Duration(clientOpts.BlockFlushMs) * time.Millisecond,
+		DirtyThreshold: int64(clientOpts.BlockDirtyKB) * 1024,
+		SyncPolicy:     clientOpts.BlockSync,
+	}
+}
+
+func applyBlockFlushOpts(clientOpts ClientOptsType) {
+	err := blockstore.SetFlushConfig(GetBlockFlushConfig(clientOpts))
+	if err != nil {
+		log.Printf("[error] invalid blockstore flush settings: %v\n", err)
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+)
+
+// bulk operations (archive/delete of many screens, session delete) run in chunks of BulkOpChunkSize screens with
+// one transaction per chunk, so the db is not locked for the whole operation.  after every chunk the screen updates
+// and the op's progress (BulkOpType) are sent on the main update bus.  ops are canceled between chunks
+// (CancelBulkOp), chunks that already ran stay committed.  only one bulk op can run per session.
+
+const BulkOpChunkSize = 20
+
+const (
+	BulkOpTypeArchive       = "archive"
+	BulkOpTypeDelete        = "delete"
+	BulkOpTypeSessionDelete = "sessiondelete"
+)
+
+const (
+	BulkOpStatusRunning  = "running"
+	BulkOpStatusDone     = "done"
+	BulkOpStatusCanceled = "canceled"
+	BulkOpStatusError    = "error"
+)
+
+type BulkOpType struct {
+	OpId       string `json:"opid"`
+	OpType     string `json:"optype"`
+	SessionId  string `json:"sessionid"`
+	Status     string `json:"status"`
+	NumTotal   int    `json:"numtotal"`
+	NumDone    int    `json:"numdone"`
+	NumSkipped int    `json:"numskipped"` // screens that could not be archived (last screen, web-shared) or were already gone
+	ErrorStr   string `json:"errorstr,omitempty"`
+	StartTs    int64  `json:"startts"`
+	EndTs      int64  `json:"endts,omitempty"`
+}
+
+func (BulkOpType) GetType() string {
+	return "bulkop"
+}
+
+type bulkOpEntry struct {
+	Op       BulkOpType
+	CancelFn context.CancelFunc
+}
+
+// runs in a transaction, returns the number of skipped screens
+type bulkChunkFn func(tx *TxWrap, screenIds []string, update *scbus.ModelUpdatePacketType) (int, error)
+
+var bulkOpsLock = &sync.Mutex{}
+var bulkOps = make(map[string]*bulkOpEntry) // only running ops
+
+func registerBulkOp(opType string, sessionId string, numTotal int) (context.Context, BulkOpType, error) {
+	bulkOpsLock.Lock()
+	defer bulkOpsLock.Unlock()
+	for _, entry := range bulkOps {
+		if entry.Op.SessionId == sessionId {
+			return nil, BulkOpType{}, fmt.Errorf("bulk %s operation %s is already running for this session", entry.Op.OpType, entry.Op.OpId)
+		}
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+	op := BulkOpType{
+		OpId:      scbase.GenWaveUUID(),
+		OpType:    opType,
+		SessionId: sessionId,
+		Status:    BulkOpStatusRunning,
+		NumTotal:  numTotal,
+		StartTs:   time.Now().UnixMilli(),
+	}
+	bulkOps[op.OpId] = &bulkOpEntry{Op: op, CancelFn: cancelFn}
+	return ctx, op, nil
+}
+
+// returns the updated op, ok is false if opId is not a running op
+func updateBulkOpProgress(opId string, numDone int, numSkipped int) (BulkOpType, bool) {
+	bulkOpsLock.Lock()
+	defer bulkOpsLock.Unlock()
+	entry := bulkOps[opId]
+	if entry == nil {
+		return BulkOpType{}, false
+	}
+	entry.Op.NumDone += numDone
+	entry.Op.NumSkipped += numSkipped
+	return entry.Op, true
+}
+
+func finishBulkOp(opId string, err error) BulkOpType {
+	bulkOpsLock.Lock()
+	defer bulkOpsLock.Unlock()
+	entry := bulkOps[opId]
+	if entry == nil {
+		return BulkOpType{OpId: opId, Status: BulkOpStatusDone}
+	}
+	delete(bulkOps, opId)
+	entry.CancelFn()
+	entry.Op.EndTs = time.Now().UnixMilli()
+	if err == nil {
+		entry.Op.Status = BulkOpStatusDone
+	} else if errors.Is(err, context.Canceled) {
+		entry.Op.Status = BulkOpStatusCanceled
+	} else {
+		entry.Op.Status = BulkOpStatusError
+		entry.Op.ErrorStr = err.Error()
+	}
+	return entry.Op
+}
+
+// returns the running bulk ops (oldest first)
+func GetBulkOps() []BulkOpType {
+	bulkOpsLock.Lock()
+	defer bulkOpsLock.Unlock()
+	var rtn []BulkOpType
+	for _, entry := range bulkOps {
+		rtn = append(rtn, entry.Op)
+	}
+	sort.Slice(rtn, func(i, j int) bool {
+		return rtn[i].StartTs < rtn[j].StartTs
+	})
+	return rtn
+}
+
+// returns false if opId is not a running op.  the op stops before its next chunk.
+func CancelBulkOp(opId string) bool {
+	bulkOpsLock.Lock()
+	defer bulkOpsLock.Unlock()
+	entry := bulkOps[opId]
+	if entry == nil {
+		return false
+	}
+	entry.CancelFn()
+	return true
+}
+
+// runs chunkFn for each chunk of screenIds in its own transaction.  the chunk updates (with the op's progress
+// if opId is set) are sent on the main update bus.
+// returns ctx.Err() if canceled.
+func runBulkScreenOp(ctx context.Context, opId string, screenIds []string, chunkFn bulkChunkFn) error {
+	for start := 0; start < len(screenIds); start += BulkOpChunkSize {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		chunk := screenIds[start:min(start+BulkOpChunkSize, len(screenIds))]
+		update := scbus.MakeUpdatePacket()
+		var numSkipped int
+		txErr := WithTx(ctx, func(tx *TxWrap) error {
+			var err error
+			numSkipped, err = chunkFn(tx, chunk, update)
+			return err
+		})
+		if txErr != nil {
+			return txErr
+		}
+		if opId != "" {
+			if op, ok := updateBulkOpProgress(opId, len(chunk)-numSkipped, numSkipped); ok {
+				update.AddUpdate(op)
+			}
+		}
+		scbus.MainUpdateBus.DoUpdate(update)
+	}
+	return nil
+}
+
+// if the session's active screen was archived or deleted, the first open screen is made active (returns true)
+func fixSessionActiveScreen(tx *TxWrap, sessionId string) bool {
+	query := `SELECT s.screenid
+	          FROM screen s, session ss
+	          WHERE ss.sessionid = ? AND s.sessionid = ss.sessionid AND s.screenid = ss.activescreenid AND NOT s.archived`
+	if tx.Exists(query, sessionId) {
+		return false
+	}
+	query = `SELECT screenid FROM screen WHERE sessionid = ? AND NOT archived ORDER BY screenidx LIMIT 1`
+	nextId := tx.GetString(query, sessionId)
+	tx.Exec(`UPDATE session SET activescreenid = ? WHERE sessionid = ?`, nextId, sessionId)
+	return true
+}
+
+func addBareSessionUpdate(tx *TxWrap, sessionId string, update *scbus.ModelUpdatePacketType) error {
+	bareSession, err := GetBareSessionById(tx.Context(), sessionId)
+	if err != nil {
+		return err
+	}
+	if bareSession != nil {
+		update.AddUpdate(*bareSession)
+	}
+	return nil
+}
+
+// same rules as ArchiveScreen, except that screens that cannot be archived are skipped
+func bulkArchiveChunkFn(sessionId string) bulkChunkFn {
+	return func(tx *TxWrap, screenIds []string, update *scbus.ModelUpdatePacketType) (int, error) {
+		var numSkipped int
+		var archivedIds []string
+		now := time.Now().UnixMilli()
+		for _, screenId := range screenIds {
+			query := `SELECT screenid FROM screen WHERE sessionid = ? AND screenid = ? AND NOT archived`
+			if !tx.Exists(query, sessionId, screenId) || isWebShare(tx, screenId) {
+				numSkipped++
+				continue
+			}
+			query = `SELECT count(*) FROM screen WHERE sessionid = ? AND NOT archived`
+			if tx.GetInt(query, sessionId) <= 1 {
+				numSkipped++
+				continue
+			}
+			query = `UPDATE screen SET archived = 1, archivedts = ?, screenidx = 0 WHERE sessionid = ? AND screenid = ?`
+			tx.Exec(query, now, sessionId, screenId)
+			archivedIds = append(archivedIds, screenId)
+		}
+		for _, screenId := range archivedIds {
+			screen, err := GetScreenById(tx.Context(), screenId)
+			if err != nil {
+				return 0, fmt.Errorf("cannot retrieve archived screen: %w", err)
+			}
+			update.AddUpdate(*screen)
+		}
+		if fixSessionActiveScreen(tx, sessionId) {
+			err := addBareSessionUpdate(tx, sessionId, update)
+			if err != nil {
+				return 0, err
+			}
+		}
+		return numSkipped, nil
+	}
+}
+
+// the screens are moved to the trash, parentTrashId is set when the screens are deleted with their session
+func bulkDeleteChunkFn(sessionId string, parentTrashId string) bulkChunkFn {
+	return func(tx *TxWrap, screenIds []string, update *scbus.ModelUpdatePacketType) (int, error) {
+		var numSkipped int
+		for _, screenId := range screenIds {
+			query := `SELECT screenid FROM screen WHERE sessionid = ? AND screenid = ?`
+			if !tx.Exists(query, sessionId, screenId) {
+				numSkipped++
+				continue
+			}
+			_, err := DeleteScreen(tx.Context(), screenId, true, parentTrashId, update)
+			if err != nil {
+				return 0, fmt.Errorf("error deleting screen[%s]: %w", screenId, err)
+			}
+		}
+		if fixSessionActiveScreen(tx, sessionId) {
+			err := addBareSessionUpdate(tx, sessionId, update)
+			if err != nil {
+				return 0, err
+			}
+		}
+		return numSkipped, nil
+	}
+}
+
+// deletes (trashes) the screens in chunks
+func bulkDeleteScreens(ctx context.Context, opId string, sessionId string, parentTrashId string, screenIds []string) error {
+	return runBulkScreenOp(ctx, opId, screenIds, bulkDeleteChunkFn(sessionId, parentTrashId))
+}
+
+// starts archiving or deleting (opType) screenIds in the background, returns the op's initial state.
+// progress and the final state are sent as BulkOpType updates.
+func StartBulkScreenOp(opType string, sessionId string, screenIds []string) (BulkOpType, error) {
+	if opType != BulkOpTypeArchive && opType != BulkOpTypeDelete {
+		return BulkOpType{}, fmt.Errorf("invalid bulk operation %q", opType)
+	}
+	ctx, op, err := registerBulkOp(opType, sessionId, len(screenIds))
+	if err != nil {
+		return BulkOpType{}, err
+	}
+	go func() {
+		var err error
+		if opType == BulkOpTypeArchive {
+			err = runBulkScreenOp(ctx, op.OpId, screenIds, bulkArchiveChunkFn(sessionId))
+		} else {
+			err = bulkDeleteScreens(ctx, op.OpId, sessionId, "", screenIds)
+		}
+		update := scbus.MakeUpdatePacket()
+		update.AddUpdate(finishBulkOp(op.OpId, err))
+		scbus.MainUpdateBus.DoUpdate(update)
+	}()
+	return op, nil
+}
+
+// starts deleting the session in the background (see DeleteSession), returns the op's initial state
+func StartBulkSessionDelete(ctx context.Context, sessionId string) (BulkOpType, error) {
+	numScreens, err := WithTxRtn(ctx, func(tx *TxWrap) (int, error) {
+		if !tx.Exists(`SELECT sessionid FROM session WHERE sessionid = ?`, sessionId) {
+			return 0, fmt.Errorf("cannot delete session (not found)")
+		}
+		return tx.GetInt(`SELECT count(*) FROM screen WHERE sessionid = ?`, sessionId), nil
+	})
+	if err != nil {
+		return BulkOpType{}, err
+	}
+	opCtx, op, err := registerBulkOp(BulkOpTypeSessionDelete, sessionId, numScreens)
+	if err != nil {
+		return BulkOpType{}, err
+	}
+	go func() {
+		update, err := deleteSession(opCtx, op.OpId, sessionId)
+		if update == nil {
+			update = scbus.MakeUpdatePacket()
+		}
+		update.AddUpdate(finishBulkOp(op.OpId, err))
+		scbus.MainUpdateBus.DoUpdate(update)
+	}()
+	return op, nil
+}
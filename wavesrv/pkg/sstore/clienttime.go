@@ -0,0 +1,159 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// client timezone/locale (clientopts).  used when the server formats timestamps, computes
+// day boundaries (history date queries, line day separators), and evaluates schedules.
+// an empty timezone means the server's local timezone.  the locale sets the date order and the
+// clock of the formatted timestamps (see FormatClientTs), no locale means the iso layout.
+
+const DayStrFormat = "2006-01-02"
+const DefaultTsLayout = "2006-01-02 15:04:05"
+
+// keyed by language-region or language (lowercase language, uppercase region)
+var localeTsLayouts = map[string]string{
+	"en":    "01/02/2006 3:04:05 PM",
+	"en-GB": "02/01/2006 15:04:05",
+	"en-AU": "02/01/2006 15:04:05",
+	"en-NZ": "02/01/2006 15:04:05",
+	"en-IE": "02/01/2006 15:04:05",
+	"en-IN": "02/01/2006 3:04:05 PM",
+	"en-CA": DefaultTsLayout,
+	"fr":    "02/01/2006 15:04:05",
+	"fr-CA": DefaultTsLayout,
+	"es":    "02/01/2006 15:04:05",
+	"it":    "02/01/2006 15:04:05",
+	"pt":    "02/01/2006 15:04:05",
+	"el":    "02/01/2006 15:04:05",
+	"nl":    "02-01-2006 15:04:05",
+	"de":    "02.01.2006 15:04:05",
+	"ru":    "02.01.2006 15:04:05",
+	"uk":    "02.01.2006 15:04:05",
+	"pl":    "02.01.2006 15:04:05",
+	"cs":    "02.01.2006 15:04:05",
+	"fi":    "02.01.2006 15:04:05",
+	"nb":    "02.01.2006 15:04:05",
+	"da":    "02.01.2006 15:04:05",
+	"tr":    "02.01.2006 15:04:05",
+	"ja":    "2006/01/02 15:04:05",
+	"zh":    "2006/01/02 15:04:05",
+}
+
+// BCP 47 style tag, e.g. "en", "en-US", "zh-Hant-TW"
+var localeRe = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+func ValidateTimezone(tz string) error {
+	if tz == "" {
+		return nil
+	}
+	_, err := time.LoadLocation(tz)
+	if err != nil {
+		return fmt.Errorf("invalid timezone %q (must be an IANA timezone like 'America/New_York' or 'UTC')", tz)
+	}
+	return nil
+}
+
+func ValidateLocale(locale string) error {
+	if locale == "" {
+		return nil
+	}
+	if !localeRe.MatchString(locale) {
+		return fmt.Errorf("invalid locale %q (must be a language tag like 'en-US')", locale)
+	}
+	return nil
+}
+
+// returns the timestamp layout for the locale (DefaultTsLayout for unknown locales)
+func GetLocaleTsLayout(locale string) string {
+	if locale == "" {
+		return DefaultTsLayout
+	}
+	subtags := strings.Split(locale, "-")
+	lang := strings.ToLower(subtags[0])
+	// the region follows the optional script subtag ("zh-Hant-TW")
+	for _, subtag := range subtags[1:] {
+		if len(subtag) == 2 {
+			if layout, found := localeTsLayouts[lang+"-"+strings.ToUpper(subtag)]; found {
+				return layout
+			}
+			break
+		}
+	}
+	if layout, found := localeTsLayouts[lang]; found {
+		return layout
+	}
+	return DefaultTsLayout
+}
+
+// timestamp formatting for the client, the zero value formats in the server timezone with DefaultTsLayout
+type ClientTsFormat struct {
+	Loc    *time.Location
+	Layout string
+}
+
+func (tsFmt ClientTsFormat) Format(ts time.Time) string {
+	loc := tsFmt.Loc
+	if loc == nil {
+		loc = time.Local
+	}
+	layout := tsFmt.Layout
+	if layout == "" {
+		layout = DefaultTsLayout
+	}
+	return ts.In(loc).Format(layout)
+}
+
+// the client timezone and the client locale's layout (get it once to format many timestamps)
+func GetClientTsFormat(ctx context.Context) ClientTsFormat {
+	clientData, err := EnsureClientData(ctx)
+	if err != nil {
+		return ClientTsFormat{}
+	}
+	return ClientTsFormat{Loc: GetClientLocation(ctx), Layout: GetLocaleTsLayout(clientData.ClientOpts.Locale)}
+}
+
+// formats the timestamp in the client timezone with the client locale's layout
+func FormatClientTs(ctx context.Context, ts time.Time) string {
+	return GetClientTsFormat(ctx).Format(ts)
+}
+
+// returns time.Local if no (or an invalid) timezone is set
+func GetClientLocation(ctx context.Context) *time.Location {
+	clientData, err := EnsureClientData(ctx)
+	if err != nil || clientData.ClientOpts.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(clientData.ClientOpts.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// returns the [start, end) unix ms range for the given daystr (yyyy-mm-dd) in loc
+func GetDayRange(dayStr string, loc *time.Location) (int64, int64, error) {
+	dayStart, err := time.ParseInLocation(DayStrFormat, dayStr, loc)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid daystr %q (must be yyyy-mm-dd)", dayStr)
+	}
+	return dayStart.UnixMilli(), dayStart.AddDate(0, 0, 1).UnixMilli(), nil
+}
+
+// sets line.DayStr (used by the frontend for day separators)
+func setLineDayStrs(loc *time.Location, lines ...*LineType) {
+	for _, line := range lines {
+		if line == nil {
+			continue
+		}
+		line.DayStr = time.UnixMilli(line.Ts).In(loc).Format(DayStrFormat)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+)
+
+// command aliases.  expanded by wavesrv before a command is sent to the remote (see cmdrunner/cmdaliases.go), so
+// they work the same on every remote without changing the remote's rc files.  preserveraw keeps the typed command
+// as the line's rawcmdstr (and in history), otherwise the alias acts as an abbreviation and the expanded command
+// replaces it.
+
+const MaxCmdAliases = 200
+const MaxCmdAliasExpansionLen = 1024
+
+type CmdAliasType struct {
+	AliasId     string `json:"aliasid"`
+	Name        string `json:"name"`
+	Expansion   string `json:"expansion"`
+	PreserveRaw bool   `json:"preserveraw"`
+	CreatedTs   int64  `json:"createdts"`
+}
+
+func (CmdAliasType) UseDBMap() {}
+
+func GetCmdAliases(ctx context.Context) ([]*CmdAliasType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]*CmdAliasType, error) {
+		query := `SELECT * FROM cmdalias ORDER BY name`
+		return dbutil.SelectMappable[*CmdAliasType](tx, query), nil
+	})
+}
+
+// key is the alias name
+func GetCmdAliasMap(ctx context.Context) (map[string]*CmdAliasType, error) {
+	aliases, err := GetCmdAliases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rtn := make(map[string]*CmdAliasType, len(aliases))
+	for _, alias := range aliases {
+		rtn[alias.Name] = alias
+	}
+	return rtn, nil
+}
+
+// arg is an alias name or aliasid, returns nil if not found
+func GetCmdAliasByArg(ctx context.Context, arg string) (*CmdAliasType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*CmdAliasType, error) {
+		query := `SELECT * FROM cmdalias WHERE name = ? OR aliasid = ?`
+		return dbutil.GetMappable[*CmdAliasType](tx, query, arg, arg), nil
+	})
+}
+
+// replaces an existing alias with the same name (keeps its aliasid)
+func SaveCmdAlias(ctx context.Context, alias *CmdAliasType) error {
+	if len(alias.Expansion) > MaxCmdAliasExpansionLen {
+		return fmt.Errorf("alias expansion too long (max %d chars)", MaxCmdAliasExpansionLen)
+	}
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT aliasid FROM cmdalias WHERE name = ?`
+		existingId := tx.GetString(query, alias.Name)
+		if existingId != "" {
+			alias.AliasId = existingId
+			query = `UPDATE cmdalias SET expansion = ?, preserveraw = ? WHERE aliasid = ?`
+			tx.Exec(query, alias.Expansion, alias.PreserveRaw, existingId)
+			return nil
+		}
+		query = `SELECT count(*) FROM cmdalias`
+		if tx.GetInt(query) >= MaxCmdAliases {
+			return fmt.Errorf("too many aliases (max %d)", MaxCmdAliases)
+		}
+		query = `INSERT INTO cmdalias ( aliasid, name, expansion, preserveraw, createdts)
+		                       VALUES (:aliasid,:name,:expansion,:preserveraw,:createdts)`
+		tx.NamedExec(query, dbutil.ToDBMap(alias, false))
+		return nil
+	})
+}
+
+func DeleteCmdAlias(ctx context.Context, aliasId string) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `DELETE FROM cmdalias WHERE aliasid = ?`
+		tx.Exec(query, aliasId)
+		return nil
+	})
+}
@@ -0,0 +1,110 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// error explanations (clientopts aiexplainerrors).  when a command exits with a non-zero exit code the tail of
+// its output is captured (ansi escapes stripped), so the "explain this error" action (/ai:explain) can send it
+// to the AI provider.  only the most recent MaxCmdErrorTails tails are kept in memory, older commands fall
+// back to reading the pty output.
+
+const CmdErrorTailBytes = 4096
+const CmdErrorTailLines = 40
+const MaxCmdErrorTails = 50
+
+type CmdErrorTailType struct {
+	ScreenId string
+	LineId   string
+	CmdStr   string
+	ExitCode int
+	Tail     string
+	Ts       int64
+}
+
+var aiExplainErrors atomic.Bool
+var cmdErrorTailsLock = &sync.Mutex{}
+var cmdErrorTails = make(map[string]*CmdErrorTailType) // key is screenid/lineid
+
+func init() {
+	RegisterClientOptsHook(func(clientOpts ClientOptsType) {
+		aiExplainErrors.Store(clientOpts.AIExplainErrors)
+	})
+	RegisterCmdDoneHook(captureCmdErrorTail)
+}
+
+func IsAIExplainErrorsEnabled() bool {
+	return aiExplainErrors.Load()
+}
+
+func captureCmdErrorTail(cmd CmdType) {
+	if !IsAIExplainErrorsEnabled() || cmd.ExitCode == 0 {
+		return
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+	tail, err := ReadCmdOutputTail(ctx, cmd.ScreenId, cmd.LineId)
+	if err != nil {
+		log.Printf("[error] cannot capture output of failed cmd %s/%s: %v\n", cmd.ScreenId, cmd.LineId, err)
+		return
+	}
+	cmdErrorTailsLock.Lock()
+	defer cmdErrorTailsLock.Unlock()
+	cmdErrorTails[cmd.ScreenId+"/"+cmd.LineId] = &CmdErrorTailType{
+		ScreenId: cmd.ScreenId,
+		LineId:   cmd.LineId,
+		CmdStr:   cmd.CmdStr,
+		ExitCode: cmd.ExitCode,
+		Tail:     tail,
+		Ts:       time.Now().UnixMilli(),
+	}
+	if len(cmdErrorTails) > MaxCmdErrorTails {
+		var oldestKey string
+		var oldestTs int64
+		for key, errTail := range cmdErrorTails {
+			if oldestKey == "" || errTail.Ts < oldestTs {
+				oldestKey = key
+				oldestTs = errTail.Ts
+			}
+		}
+		delete(cmdErrorTails, oldestKey)
+	}
+}
+
+// returns the last CmdErrorTailLines lines (at most CmdErrorTailBytes) of the command output, without ansi escapes
+func ReadCmdOutputTail(ctx context.Context, screenId string, lineId string) (string, error) {
+	stat, err := StatCmdPtyFile(ctx, screenId, lineId)
+	if err != nil {
+		return "", fmt.Errorf("cannot stat output: %w", err)
+	}
+	endPos := stat.FileOffset + stat.DataSize
+	_, data, err := ReadPtyOutFile(ctx, screenId, lineId, endPos-CmdErrorTailBytes, CmdErrorTailBytes)
+	if err != nil {
+		return "", fmt.Errorf("cannot read output: %w", err)
+	}
+	lines := StripAnsiLines(data)
+	if len(lines) > CmdErrorTailLines {
+		lines = lines[len(lines)-CmdErrorTailLines:]
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// returns the captured tail of a failed command, or reads it from the pty output if it was not captured
+func GetCmdErrorTail(ctx context.Context, cmd *CmdType) (string, error) {
+	cmdErrorTailsLock.Lock()
+	errTail := cmdErrorTails[cmd.ScreenId+"/"+cmd.LineId]
+	cmdErrorTailsLock.Unlock()
+	if errTail != nil {
+		return errTail.Tail, nil
+	}
+	return ReadCmdOutputTail(ctx, cmd.ScreenId, cmd.LineId)
+}
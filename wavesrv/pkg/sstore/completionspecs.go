@@ -0,0 +1,19 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/newton"
+)
+
+// the user completion spec dir (clientopts completionspecdir, "" for the default dir).  applied to newton on
+// startup and whenever the client opts change (see newton.SetUserSpecDir).
+
+func init() {
+	RegisterClientOptsHook(applyCompletionSpecOpts)
+}
+
+func applyCompletionSpecOpts(clientOpts ClientOptsType) {
+	newton.SetUserSpecDir(clientOpts.CompletionSpecDir)
+}
@@ -0,0 +1,63 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+)
+
+// the "current context" for editor integrations (statuslines), the active screen's remote, cwd, git branch, and
+// last command.  built by cmdrunner.GetCurrentContext, served by /api/current-context and sent as a
+// "currentcontext" update on the websocket when it changes.
+
+type CurrentContextCmdType struct {
+	LineId   string `json:"lineid"`
+	LineNum  int64  `json:"linenum"`
+	CmdStr   string `json:"cmdstr"`
+	Status   string `json:"status"`
+	ExitCode int    `json:"exitcode"`
+	DoneTs   int64  `json:"donets,omitempty"`
+}
+
+type CurrentContextType struct {
+	SessionId   string                 `json:"sessionid"`
+	SessionName string                 `json:"sessionname"`
+	ScreenId    string                 `json:"screenid"`
+	ScreenName  string                 `json:"screenname"`
+	Remote      RemotePtrType          `json:"remote"`
+	RemoteName  string                 `json:"remotename"`
+	Cwd         string                 `json:"cwd,omitempty"`
+	GitBranch   string                 `json:"gitbranch,omitempty"`
+	LastCmd     *CurrentContextCmdType `json:"lastcmd,omitempty"`
+}
+
+func (CurrentContextType) GetType() string {
+	return "currentcontext"
+}
+
+// the last (unarchived) cmd line of the screen, nil if there is none
+func GetLastScreenCmd(ctx context.Context, screenId string) (*CurrentContextCmdType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*CurrentContextCmdType, error) {
+		query := `SELECT * FROM line WHERE screenid = ? AND linetype = ? AND NOT archived ORDER BY linenum DESC LIMIT 1`
+		line := dbutil.GetMappable[*LineType](tx, query, screenId, LineTypeCmd)
+		if line == nil {
+			return nil, nil
+		}
+		query = `SELECT * FROM cmd WHERE screenid = ? AND lineid = ?`
+		cmd := dbutil.GetMapGen[*CmdType](tx, query, screenId, line.LineId)
+		if cmd == nil {
+			return nil, nil
+		}
+		return &CurrentContextCmdType{
+			LineId:   line.LineId,
+			LineNum:  line.LineNum,
+			CmdStr:   cmd.CmdStr,
+			Status:   cmd.Status,
+			ExitCode: cmd.ExitCode,
+			DoneTs:   cmd.DoneTs,
+		}, nil
+	})
+}
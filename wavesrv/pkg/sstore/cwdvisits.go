@@ -0,0 +1,127 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+)
+
+// frecency (frequency + recency) of the directories visited on each remote, zoxide-style.  a visit is recorded
+// whenever the cwd of a remote instance changes (see UpdateRemoteState).  SuggestDirs returns the best matching
+// directories for the input autocomplete.
+
+const MaxCwdVisitsPerRemote = 1000
+const MaxCwdVisitLen = 300
+const MaxSuggestDirs = 20
+
+type cwdVisitType struct {
+	Cwd         string `db:"cwd"`
+	VisitCount  int64  `db:"visitcount"`
+	LastVisitTs int64  `db:"lastvisitts"`
+}
+
+func recordCwdVisit(tx *TxWrap, remoteId string, cwd string) {
+	if remoteId == "" || cwd == "" || len(cwd) > MaxCwdVisitLen {
+		return
+	}
+	now := time.Now().UnixMilli()
+	query := `SELECT remoteid FROM cwdvisit WHERE remoteid = ? AND cwd = ?`
+	if tx.Exists(query, remoteId, cwd) {
+		query = `UPDATE cwdvisit SET visitcount = visitcount + 1, lastvisitts = ? WHERE remoteid = ? AND cwd = ?`
+		tx.Exec(query, now, remoteId, cwd)
+		return
+	}
+	query = `INSERT INTO cwdvisit (remoteid, cwd, visitcount, lastvisitts) VALUES (?, ?, 1, ?)`
+	tx.Exec(query, remoteId, cwd, now)
+	query = `SELECT count(*) FROM cwdvisit WHERE remoteid = ?`
+	if tx.GetInt(query, remoteId) > MaxCwdVisitsPerRemote {
+		// drops the least recently visited directory
+		query = `DELETE FROM cwdvisit WHERE remoteid = ? AND cwd = (SELECT cwd FROM cwdvisit WHERE remoteid = ? ORDER BY lastvisitts LIMIT 1)`
+		tx.Exec(query, remoteId, remoteId)
+	}
+}
+
+// zoxide weights, visits in the last hour count 4x, last day 2x, last week 0.5x, older 0.25x
+func cwdFrecencyScore(visit *cwdVisitType, now int64) float64 {
+	age := time.Duration(now-visit.LastVisitTs) * time.Millisecond
+	count := float64(visit.VisitCount)
+	switch {
+	case age < time.Hour:
+		return count * 4
+	case age < 24*time.Hour:
+		return count * 2
+	case age < 7*24*time.Hour:
+		return count / 2
+	default:
+		return count / 4
+	}
+}
+
+// an empty prefix matches everything.  prefixes with a "/" match the start of the directory, otherwise the
+// prefix matches the start of any path component (so "proj" matches "/home/mike/src/project").  case-insensitive.
+func cwdMatchesPrefix(cwd string, prefix string) bool {
+	if prefix == "" {
+		return true
+	}
+	cwd = strings.ToLower(cwd)
+	prefix = strings.ToLower(prefix)
+	if strings.Contains(prefix, "/") {
+		return strings.HasPrefix(cwd, prefix)
+	}
+	for _, part := range strings.Split(cwd, "/") {
+		if strings.HasPrefix(part, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the matching directories, best first (at most MaxSuggestDirs)
+func SuggestDirs(ctx context.Context, remoteId string, prefix string) ([]string, error) {
+	var visits []*cwdVisitType
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT cwd, visitcount, lastvisitts FROM cwdvisit WHERE remoteid = ?`
+		tx.Select(&visits, query, remoteId)
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	now := time.Now().UnixMilli()
+	var matches []*cwdVisitType
+	scores := make(map[string]float64)
+	for _, visit := range visits {
+		if !cwdMatchesPrefix(visit.Cwd, prefix) {
+			continue
+		}
+		matches = append(matches, visit)
+		scores[visit.Cwd] = cwdFrecencyScore(visit, now)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		si, sj := scores[matches[i].Cwd], scores[matches[j].Cwd]
+		if si != sj {
+			return si > sj
+		}
+		return matches[i].Cwd < matches[j].Cwd
+	})
+	rtn := []string{}
+	for _, visit := range matches {
+		if len(rtn) >= MaxSuggestDirs {
+			break
+		}
+		rtn = append(rtn, visit.Cwd)
+	}
+	return rtn, nil
+}
+
+func DeleteRemoteCwdVisits(ctx context.Context, remoteId string) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `DELETE FROM cwdvisit WHERE remoteid = ?`
+		tx.Exec(query, remoteId)
+		return nil
+	})
+}
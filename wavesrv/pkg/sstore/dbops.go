@@ -22,6 +22,7 @@ import (
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/srvmetrics"
 )
 
 var updateWriterCVar = sync.NewCond(&sync.Mutex{})
@@ -55,8 +56,18 @@ func (dbg *SingleConnDBGetter) ReleaseDB(db *sqlx.DB) {
 	dbg.SingleConnLock.Unlock()
 }
 
+// includes the wait for the db connection (transactions are serialized by dbWrap)
+var dbTxSecondsMetric = srvmetrics.NewHistogram("wavesrv_db_tx_seconds", "Latency of the sqlite transactions (including the wait for the db connection).", srvmetrics.DefaultLatencyBuckets)
+var dbTxErrorsMetric = srvmetrics.NewCounter("wavesrv_db_tx_errors_total", "Sqlite transactions that returned an error.")
+
 func WithTx(ctx context.Context, fn func(tx *TxWrap) error) error {
-	return txwrap.DBGWithTx(ctx, dbWrap, fn)
+	startTs := time.Now()
+	err := txwrap.DBGWithTx(ctx, dbWrap, fn)
+	dbTxSecondsMetric.Observe(time.Since(startTs).Seconds())
+	if err != nil {
+		dbTxErrorsMetric.Inc()
+	}
+	return err
 }
 
 func NotifyUpdateWriter() {
@@ -226,8 +237,8 @@ func UpsertRemote(ctx context.Context, r *RemoteType) error {
 		maxRemoteIdx := tx.GetInt(query)
 		r.RemoteIdx = int64(maxRemoteIdx + 1)
 		query = `INSERT INTO remote
-            ( remoteid, remotetype, remotealias, remotecanonicalname, remoteuser, remotehost, connectmode, autoinstall, sshopts, remoteopts, lastconnectts, archived, remoteidx, local, statevars, sshconfigsrc, openaiopts, shellpref) VALUES
-            (:remoteid,:remotetype,:remotealias,:remotecanonicalname,:remoteuser,:remotehost,:connectmode,:autoinstall,:sshopts,:remoteopts,:lastconnectts,:archived,:remoteidx,:local,:statevars,:sshconfigsrc,:openaiopts,:shellpref)`
+            ( remoteid, remotetype, remotealias, remotecanonicalname, remoteuser, remotehost, connectmode, autoinstall, sshopts, remoteopts, lastconnectts, archived, remoteidx, local, statevars, sshconfigsrc, openaiopts, shellpref, groupid) VALUES
+            (:remoteid,:remotetype,:remotealias,:remotecanonicalname,:remoteuser,:remotehost,:connectmode,:autoinstall,:sshopts,:remoteopts,:lastconnectts,:archived,:remoteidx,:local,:statevars,:sshconfigsrc,:openaiopts,:shellpref,:groupid)`
 		tx.NamedExec(query, r.ToMap())
 		return nil
 	})
@@ -326,12 +337,25 @@ func GetConnectUpdate(ctx context.Context) (*ConnectUpdate, error) {
 		}
 		query = `SELECT activesessionid FROM client`
 		update.ActiveSessionId = tx.GetString(query)
+		query = `SELECT * FROM window ORDER BY createdts`
+		update.Windows = dbutil.SelectMappable[*WindowType](tx, query)
+		query = `SELECT * FROM remote_group ORDER BY groupidx`
+		update.RemoteGroups = dbutil.SelectMappable[*RemoteGroupType](tx, query)
+		update.ScreenInputs = restoreScreenInputs(getScreenInputs(tx), getScreenIds(update.Screens))
 		return update, nil
 	})
 }
 
+func getScreenIds(screens []*ScreenType) []string {
+	var rtn []string
+	for _, screen := range screens {
+		rtn = append(rtn, screen.ScreenId)
+	}
+	return rtn
+}
+
 func GetScreenLinesById(ctx context.Context, screenId string) (*ScreenLinesType, error) {
-	return WithTxRtn(ctx, func(tx *TxWrap) (*ScreenLinesType, error) {
+	screenLines, err := WithTxRtn(ctx, func(tx *TxWrap) (*ScreenLinesType, error) {
 		query := `SELECT screenid FROM screen WHERE screenid = ?`
 		screen := dbutil.GetMappable[*ScreenLinesType](tx, query, screenId)
 		if screen == nil {
@@ -343,6 +367,16 @@ func GetScreenLinesById(ctx context.Context, screenId string) (*ScreenLinesType,
 		screen.Cmds = dbutil.SelectMapsGen[*CmdType](tx, query, screen.ScreenId)
 		return screen, nil
 	})
+	if err != nil || screenLines == nil {
+		return screenLines, err
+	}
+	HydrateLineStates(ctx, screenLines.Lines)
+	err = setLineTags(ctx, screenId, screenLines.Lines...)
+	if err != nil {
+		return nil, err
+	}
+	setLineDayStrs(GetClientLocation(ctx), screenLines.Lines...)
+	return screenLines, nil
 }
 
 // includes archived screens
@@ -572,9 +606,10 @@ func InsertScreen(ctx context.Context, sessionId string, origScreenName string,
 			FocusType:    ScreenFocusInput,
 			Archived:     false,
 			ArchivedTs:   0,
+			LastActiveTs: time.Now().UnixMilli(),
 		}
-		query = `INSERT INTO screen ( sessionid, screenid, name, screenidx, screenopts, screenviewopts, ownerid, sharemode, webshareopts, curremoteownerid, curremoteid, curremotename, nextlinenum, selectedline, anchor, focustype, archived, archivedts)
-                             VALUES (:sessionid,:screenid,:name,:screenidx,:screenopts,:screenviewopts,:ownerid,:sharemode,:webshareopts,:curremoteownerid,:curremoteid,:curremotename,:nextlinenum,:selectedline,:anchor,:focustype,:archived,:archivedts)`
+		query = `INSERT INTO screen ( sessionid, screenid, name, screenidx, screenopts, screenviewopts, ownerid, sharemode, webshareopts, curremoteownerid, curremoteid, curremotename, nextlinenum, selectedline, anchor, focustype, archived, archivedts, panelayout, lastactivets)
+                             VALUES (:sessionid,:screenid,:name,:screenidx,:screenopts,:screenviewopts,:ownerid,:sharemode,:webshareopts,:curremoteownerid,:curremoteid,:curremotename,:nextlinenum,:selectedline,:anchor,:focustype,:archived,:archivedts,:panelayout,:lastactivets)`
 		tx.NamedExec(query, screen.ToMap())
 		if activate {
 			query = `UPDATE session SET activescreenid = ? WHERE sessionid = ?`
@@ -647,7 +682,7 @@ func FindLineIdByArg(ctx context.Context, screenId string, lineArg string) (stri
 }
 
 func GetLineCmdByLineId(ctx context.Context, screenId string, lineId string) (*LineType, *CmdType, error) {
-	return WithTxRtn3(ctx, func(tx *TxWrap) (*LineType, *CmdType, error) {
+	line, cmd, err := WithTxRtn3(ctx, func(tx *TxWrap) (*LineType, *CmdType, error) {
 		query := `SELECT * FROM line WHERE screenid = ? AND lineid = ?`
 		lineVal := dbutil.GetMappable[*LineType](tx, query, screenId, lineId)
 		if lineVal == nil {
@@ -658,6 +693,16 @@ func GetLineCmdByLineId(ctx context.Context, screenId string, lineId string) (*L
 		cmdRtn = dbutil.GetMapGen[*CmdType](tx, query, screenId, lineId)
 		return lineVal, cmdRtn, nil
 	})
+	if err != nil {
+		return nil, nil, err
+	}
+	hydrateLineState(ctx, line)
+	err = setLineTags(ctx, screenId, line)
+	if err != nil {
+		return nil, nil, err
+	}
+	setLineDayStrs(GetClientLocation(ctx), line)
+	return line, cmd, nil
 }
 
 func InsertLine(ctx context.Context, line *LineType, cmd *CmdType) error {
@@ -673,11 +718,12 @@ func InsertLine(ctx context.Context, line *LineType, cmd *CmdType) error {
 	if cmd != nil && cmd.ScreenId == "" {
 		return fmt.Errorf("cmd should have screenid set")
 	}
-	qjs := dbutil.QuickJson(line.LineState)
-	if len(qjs) > MaxLineStateSize {
-		return fmt.Errorf("linestate exceeds maxsize, size[%d] max[%d]", len(qjs), MaxLineStateSize)
+	dbLineState, overflowName, err := makeDBLineState(ctx, line.ScreenId, line.LineId, line.LineState)
+	if err != nil {
+		return err
 	}
-	return WithTx(ctx, func(tx *TxWrap) error {
+	setLineDayStrs(GetClientLocation(ctx), line)
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
 		query := `SELECT screenid FROM screen WHERE screenid = ?`
 		if !tx.Exists(query, line.ScreenId) {
 			return fmt.Errorf("screen not found, cannot insert line[%s]", line.ScreenId)
@@ -687,9 +733,12 @@ func InsertLine(ctx context.Context, line *LineType, cmd *CmdType) error {
 		line.LineNum = int64(nextLineNum)
 		query = `INSERT INTO line  ( screenid, userid, lineid, ts, linenum, linenumtemp, linelocal, linetype, linestate, text, renderer, ephemeral, contentheight, star, archived)
                             VALUES (:screenid,:userid,:lineid,:ts,:linenum,:linenumtemp,:linelocal,:linetype,:linestate,:text,:renderer,:ephemeral,:contentheight,:star,:archived)`
-		tx.NamedExec(query, dbutil.ToDBMap(line, false))
+		lineMap := dbutil.ToDBMap(line, false)
+		lineMap["linestate"] = dbLineState
+		tx.NamedExec(query, lineMap)
 		query = `UPDATE screen SET nextlinenum = ? WHERE screenid = ?`
 		tx.Exec(query, nextLineNum+1, line.ScreenId)
+		insertFeedUpdate(tx, FeedType_Line, line.ScreenId, line.LineId, "")
 		if cmd != nil {
 			cmd.OrigTermOpts = cmd.TermOpts
 			cmdMap := cmd.ToMap()
@@ -701,6 +750,10 @@ INSERT INTO cmd  ( screenid, lineid, remoteownerid, remoteid, remotename, cmdstr
 		}
 		return nil
 	})
+	if txErr != nil && overflowName != "" {
+		deleteLineStateOverflowFile(ctx, line.ScreenId, overflowName) // ignore error
+	}
+	return txErr
 }
 
 func GetCmdByScreenId(ctx context.Context, screenId string, lineId string) (*CmdType, error) {
@@ -725,7 +778,9 @@ func UpdateWithCurrentOpenAICmdInfoChat(screenId string, update *scbus.ModelUpda
 	if update == nil {
 		update = scbus.MakeUpdatePacket()
 	}
-	update.AddUpdate(OpenAICmdInfoChatUpdate(ScreenMemGetCmdInfoChat(screenId).Messages))
+	chat := ScreenMemGetCmdInfoChat(screenId)
+	update.AddUpdate(OpenAICmdInfoChatUpdate(chat.Messages))
+	update.AddUpdate(OpenAICmdInfoAttachmentsUpdate(chat.Attachments))
 	return update
 }
 
@@ -740,7 +795,7 @@ func UpdateWithUpdateOpenAICmdInfoPacket(ctx context.Context, screenId string, m
 func UpdateCmdForRestart(ctx context.Context, ck base.CommandKey, ts int64, cmdPid int, remotePid int, termOpts *TermOpts) error {
 	return WithTx(ctx, func(tx *TxWrap) error {
 		query := `UPDATE cmd
-		          SET restartts = ?, status = ?, exitcode = ?, cmdpid = ?, remotepid = ?, durationms = ?, termopts = ?, origtermopts = ?
+		          SET restartts = ?, status = ?, exitcode = ?, cmdpid = ?, remotepid = ?, durationms = ?, usage = 'null', termopts = ?, origtermopts = ?
 				  WHERE screenid = ? AND lineid = ?`
 		tx.Exec(query, ts, CmdStatusRunning, 0, cmdPid, remotePid, 0, quickJson(termOpts), quickJson(termOpts), ck.GetGroupId(), lineIdFromCK(ck))
 		query = `UPDATE history
@@ -759,10 +814,30 @@ func UpdateCmdStartInfo(ctx context.Context, ck base.CommandKey, cmdPid int, wav
 	})
 }
 
+var cmdDoneHooksLock = &sync.Mutex{}
+var cmdDoneHooks []func(cmd CmdType)
+
+// registers a fn to be called (in its own goroutine) after UpdateCmdDoneInfo successfully updates a cmd
+func RegisterCmdDoneHook(fn func(cmd CmdType)) {
+	cmdDoneHooksLock.Lock()
+	defer cmdDoneHooksLock.Unlock()
+	cmdDoneHooks = append(cmdDoneHooks, fn)
+}
+
+func runCmdDoneHooks(cmd CmdType) {
+	cmdDoneHooksLock.Lock()
+	hooks := cmdDoneHooks
+	cmdDoneHooksLock.Unlock()
+	for _, hookFn := range hooks {
+		go hookFn(cmd)
+	}
+}
+
 type CmdDoneDataValues struct {
 	Ts         int64
 	ExitCode   int
 	DurationMs int64
+	Usage      *packet.CmdUsageType
 }
 
 func UpdateCmdDoneInfo(ctx context.Context, update *scbus.ModelUpdatePacketType, ck base.CommandKey, donePk CmdDoneDataValues, status string) error {
@@ -773,10 +848,11 @@ func UpdateCmdDoneInfo(ctx context.Context, update *scbus.ModelUpdatePacketType,
 	var rtnCmd *CmdType
 	txErr := WithTx(ctx, func(tx *TxWrap) error {
 		lineId := lineIdFromCK(ck)
-		query := `UPDATE cmd SET status = ?, donets = ?, exitcode = ?, durationms = ? WHERE screenid = ? AND lineid = ?`
-		tx.Exec(query, status, donePk.Ts, donePk.ExitCode, donePk.DurationMs, screenId, lineId)
+		query := `UPDATE cmd SET status = ?, donets = ?, exitcode = ?, durationms = ?, usage = ? WHERE screenid = ? AND lineid = ?`
+		tx.Exec(query, status, donePk.Ts, donePk.ExitCode, donePk.DurationMs, quickNullableJson(donePk.Usage), screenId, lineId)
 		query = `UPDATE history SET status = ?, exitcode = ?, durationms = ? WHERE screenid = ? AND lineid = ?`
 		tx.Exec(query, status, donePk.ExitCode, donePk.DurationMs, screenId, lineId)
+		insertFeedLineUpdate(tx, screenId, lineId)
 		var err error
 		rtnCmd, err = GetCmdByScreenId(tx.Context(), screenId, lineId)
 		if err != nil {
@@ -809,9 +885,169 @@ func UpdateCmdDoneInfo(ctx context.Context, update *scbus.ModelUpdatePacketType,
 		log.Printf("error setting status indicator level after done packet: %v\n", err)
 	}
 	go IncrementNumRunningCmds(screenId, -1)
+	clearPtyFlow(screenId, rtnCmd.LineId)
+	runCmdDoneHooks(*rtnCmd)
+	err = addCmdDoneNotifyUpdate(ctx, update, rtnCmd)
+	if err != nil {
+		log.Printf("error creating cmd done notification: %v\n", err)
+	}
 	return nil
 }
 
+const MaxNotifyCmdStrLen = 80
+
+// desktop notifications for long running commands on non-active screens.  cmddonenotifysecs (client opt, 0 = off)
+// is the threshold: a command that is still running after it is notified once (NotifyLongRunningCmds, polled by
+// wavesrv), a command that ran at least that long is notified again when it finishes.  off for screens with the
+// screen opt nonotify, paused in demo mode.
+
+var cmdRunningNotifyLock = &sync.Mutex{}
+var cmdRunningNotified = make(map[CmdPtr]bool) // running cmds that were notified, guarded by cmdRunningNotifyLock
+
+// returns the notify threshold, 0 if notifications are off
+func getCmdNotifySecs(ctx context.Context) (int, error) {
+	if IsDemoMode() {
+		return 0, nil
+	}
+	clientData, err := EnsureClientData(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if clientData.ClientOpts.CmdDoneNotifySecs <= 0 {
+		return 0, nil
+	}
+	return clientData.ClientOpts.CmdDoneNotifySecs, nil
+}
+
+// returns the screen to notify for, nil if it is the active screen or it has nonotify set
+func getCmdNotifyScreen(ctx context.Context, screenId string) (*ScreenType, error) {
+	var screen *ScreenType
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT s.screenid FROM client c, session sess, screen s
+		          WHERE c.activesessionid = sess.sessionid AND sess.activescreenid = s.screenid AND s.screenid = ?`
+		if tx.Exists(query, screenId) {
+			return nil
+		}
+		screen = dbutil.GetMapGen[*ScreenType](tx, `SELECT * FROM screen WHERE screenid = ?`, screenId)
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	if screen == nil || screen.ScreenOpts.NoNotify {
+		return nil, nil
+	}
+	return screen, nil
+}
+
+func addCmdDoneNotifyUpdate(ctx context.Context, update *scbus.ModelUpdatePacketType, cmd *CmdType) error {
+	if cmd.Status != CmdStatusDone && cmd.Status != CmdStatusError {
+		return nil
+	}
+	cmdRunningNotifyLock.Lock()
+	delete(cmdRunningNotified, CmdPtr{ScreenId: cmd.ScreenId, LineId: cmd.LineId})
+	cmdRunningNotifyLock.Unlock()
+	notifySecs, err := getCmdNotifySecs(ctx)
+	if err != nil {
+		return err
+	}
+	if notifySecs <= 0 || int64(cmd.DurationMs) < int64(notifySecs)*1000 {
+		return nil
+	}
+	screen, err := getCmdNotifyScreen(ctx, cmd.ScreenId)
+	if err != nil || screen == nil {
+		return err
+	}
+	notify := NotifyUpdateType{
+		ScreenId:  screen.ScreenId,
+		SessionId: screen.SessionId,
+		LineId:    cmd.LineId,
+		Title:     fmt.Sprintf("Command finished in %q", screen.Name),
+		Urgency:   NotifyUrgency_Normal,
+	}
+	if cmd.ExitCode != 0 {
+		notify.Title = fmt.Sprintf("Command failed in %q (exit code %d)", screen.Name, cmd.ExitCode)
+		notify.Urgency = NotifyUrgency_Critical
+	}
+	cmdStr := utilfn.TruncateWidth(cmd.CmdStr, MaxNotifyCmdStrLen)
+	notify.Body = fmt.Sprintf("%s\nran for %v", cmdStr, (time.Duration(cmd.DurationMs) * time.Millisecond).Round(time.Second))
+	update.AddUpdate(notify)
+	return nil
+}
+
+type runningCmdNotifyInfo struct {
+	ScreenId  string
+	LineId    string
+	CmdStr    string
+	Ts        int64
+	RestartTs int64
+}
+
+// returns an update with a notification for each command that has been running for cmddonenotifysecs and was not
+// notified yet (nil if there are none).  a restarted command counts from its restart.
+func NotifyLongRunningCmds(ctx context.Context) (*scbus.ModelUpdatePacketType, error) {
+	notifySecs, err := getCmdNotifySecs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var runningCmds []runningCmdNotifyInfo
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT c.screenid, c.lineid, c.cmdstr, l.ts, c.restartts
+		          FROM cmd c, line l
+		          WHERE c.screenid = l.screenid AND c.lineid = l.lineid AND c.status = ?`
+		tx.Select(&runningCmds, query, CmdStatusRunning)
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	cmdRunningNotifyLock.Lock()
+	defer cmdRunningNotifyLock.Unlock()
+	running := make(map[CmdPtr]bool)
+	for _, rcmd := range runningCmds {
+		running[CmdPtr{ScreenId: rcmd.ScreenId, LineId: rcmd.LineId}] = true
+	}
+	for cmdPtr := range cmdRunningNotified {
+		if !running[cmdPtr] {
+			delete(cmdRunningNotified, cmdPtr)
+		}
+	}
+	if notifySecs <= 0 {
+		return nil, nil
+	}
+	nowTs := time.Now().UnixMilli()
+	var update *scbus.ModelUpdatePacketType
+	for _, rcmd := range runningCmds {
+		cmdPtr := CmdPtr{ScreenId: rcmd.ScreenId, LineId: rcmd.LineId}
+		startTs := max(rcmd.Ts, rcmd.RestartTs)
+		if cmdRunningNotified[cmdPtr] || nowTs-startTs < int64(notifySecs)*1000 {
+			continue
+		}
+		screen, err := getCmdNotifyScreen(ctx, rcmd.ScreenId)
+		if err != nil {
+			return update, err
+		}
+		// notified (or skipped) once, the screen can become active before the next poll
+		cmdRunningNotified[cmdPtr] = true
+		if screen == nil {
+			continue
+		}
+		if update == nil {
+			update = scbus.MakeUpdatePacket()
+		}
+		cmdStr := utilfn.TruncateWidth(rcmd.CmdStr, MaxNotifyCmdStrLen)
+		update.AddUpdate(NotifyUpdateType{
+			ScreenId:  screen.ScreenId,
+			SessionId: screen.SessionId,
+			LineId:    rcmd.LineId,
+			Title:     fmt.Sprintf("Command still running in %q", screen.Name),
+			Body:      fmt.Sprintf("%s\nrunning for %v", cmdStr, (time.Duration(nowTs-startTs) * time.Millisecond).Round(time.Second)),
+			Urgency:   NotifyUrgency_Normal,
+		})
+	}
+	return update, nil
+}
+
 func UpdateCmdRtnState(ctx context.Context, ck base.CommandKey, statePtr packet.ShellStatePtr) error {
 	if ck.IsEmpty() {
 		return fmt.Errorf("cannot update cmdrtnstate, empty ck")
@@ -853,6 +1089,7 @@ func HangupAllRunningCmds(ctx context.Context) error {
 			}
 			query = `UPDATE history SET status = ? WHERE screenid = ? AND lineid = ?`
 			tx.Exec(query, CmdStatusHangup, cmdPtr.ScreenId, cmdPtr.LineId)
+			insertFeedLineUpdate(tx, cmdPtr.ScreenId, cmdPtr.LineId)
 		}
 		return nil
 	})
@@ -873,6 +1110,7 @@ func HangupRunningCmdsByRemoteId(ctx context.Context, remoteId string) ([]*Scree
 			}
 			query = `UPDATE history SET status = ? WHERE screenid = ? AND lineid = ?`
 			tx.Exec(query, CmdStatusHangup, cmdPtr.ScreenId, cmdPtr.LineId)
+			insertFeedLineUpdate(tx, cmdPtr.ScreenId, cmdPtr.LineId)
 			screen, err := UpdateScreenFocusForDoneCmd(tx.Context(), cmdPtr.ScreenId, cmdPtr.LineId)
 			if err != nil {
 				return nil, err
@@ -893,6 +1131,7 @@ func HangupCmd(ctx context.Context, ck base.CommandKey) (*ScreenType, error) {
 		tx.Exec(query, CmdStatusHangup, ck.GetGroupId(), lineIdFromCK(ck))
 		query = `UPDATE history SET status = ? WHERE screenid = ? AND lineid = ?`
 		tx.Exec(query, CmdStatusHangup, ck.GetGroupId(), lineIdFromCK(ck))
+		insertFeedLineUpdate(tx, ck.GetGroupId(), lineIdFromCK(ck))
 		if isWebShare(tx, ck.GetGroupId()) {
 			insertScreenLineUpdate(tx, ck.GetGroupId(), lineIdFromCK(ck), UpdateType_CmdStatus)
 		}
@@ -930,13 +1169,18 @@ func getNextId(ids []string, delId string) string {
 
 func SwitchScreenById(ctx context.Context, sessionId string, screenId string) (*scbus.ModelUpdatePacketType, error) {
 	SetActiveSessionId(ctx, sessionId)
+	var prevScreenId string
 	txErr := WithTx(ctx, func(tx *TxWrap) error {
 		query := `SELECT screenid FROM screen WHERE sessionid = ? AND screenid = ?`
 		if !tx.Exists(query, sessionId, screenId) {
 			return fmt.Errorf("cannot switch to screen, screen=%s does not exist in session=%s", screenId, sessionId)
 		}
+		query = `SELECT activescreenid FROM session WHERE sessionid = ?`
+		prevScreenId = tx.GetString(query, sessionId)
 		query = `UPDATE session SET activescreenid = ? WHERE sessionid = ?`
 		tx.Exec(query, screenId, sessionId)
+		query = `UPDATE screen SET lastactivets = ? WHERE screenid = ?`
+		tx.Exec(query, time.Now().UnixMilli(), screenId)
 		return nil
 	})
 	if txErr != nil {
@@ -961,6 +1205,17 @@ func SwitchScreenById(ctx context.Context, sessionId string, screenId string) (*
 			log.Printf("error resetting status indicator when switching screens: %v\n", err)
 		}
 	}
+	if prevScreenId != "" && prevScreenId != screenId {
+		// ephemeral lines do not survive a screen switch
+		ephUpdate, err := RemoveEphemeralLines(ctx, prevScreenId, 0)
+		if err != nil {
+			// not a fatal error, so just log it
+			log.Printf("error removing ephemeral lines when switching screens: %v\n", err)
+		}
+		if ephUpdate != nil {
+			update.Merge(ephUpdate)
+		}
+	}
 	return update, nil
 }
 
@@ -1039,15 +1294,16 @@ func UnArchiveScreen(ctx context.Context, sessionId string, screenId string) err
 			return fmt.Errorf("cannot re-open screen (not found or not archived)")
 		}
 		maxScreenIdx := tx.GetInt(`SELECT COALESCE(max(screenidx), 0) FROM screen WHERE sessionid = ? AND NOT archived`, sessionId)
-		query = `UPDATE screen SET archived = 0, screenidx = ? WHERE sessionid = ? AND screenid = ?`
-		tx.Exec(query, maxScreenIdx+1, sessionId, screenId)
+		query = `UPDATE screen SET archived = 0, screenidx = ?, lastactivets = ? WHERE sessionid = ? AND screenid = ?`
+		tx.Exec(query, maxScreenIdx+1, time.Now().UnixMilli(), sessionId, screenId)
 		return nil
 	})
 	return txErr
 }
 
-// if sessionDel is passed, we do *not* delete the screen directory (session delete will handle that)
-func DeleteScreen(ctx context.Context, screenId string, sessionDel bool, update *scbus.ModelUpdatePacketType) (*scbus.ModelUpdatePacketType, error) {
+// the screen is moved to the trash (see trash.go), its screen directory is deleted when it is purged.
+// if sessionDel is passed, the session's active screen is not fixed, parentTrashId is the session's trashid.
+func DeleteScreen(ctx context.Context, screenId string, sessionDel bool, parentTrashId string, update *scbus.ModelUpdatePacketType) (*scbus.ModelUpdatePacketType, error) {
 	var sessionId string
 	var isActive bool
 	var screenTombstone *ScreenTombstoneType
@@ -1083,10 +1339,19 @@ func DeleteScreen(ctx context.Context, screenId string, sessionDel bool, update
 		query := `INSERT INTO screen_tombstone ( screenid, sessionid, name, deletedts, screenopts)
 		                                VALUES (:screenid,:sessionid,:name,:deletedts,:screenopts)`
 		tx.NamedExec(query, dbutil.ToDBMap(screenTombstone, false))
+		trashScreenTx(tx, screen, parentTrashId, screenTombstone.DeletedTs)
 		query = `DELETE FROM screen WHERE screenid = ?`
 		tx.Exec(query, screenId)
+		query = `UPDATE window SET activescreenid = '' WHERE activescreenid = ?`
+		tx.Exec(query, screenId)
 		query = `DELETE FROM line WHERE screenid = ?`
 		tx.Exec(query, screenId)
+		query = `DELETE FROM line_tag WHERE screenid = ?`
+		tx.Exec(query, screenId)
+		query = `DELETE FROM screeninput WHERE screenid = ?`
+		tx.Exec(query, screenId)
+		query = `DELETE FROM envprofile_attach WHERE targettype = ? AND targetid = ?`
+		tx.Exec(query, EnvAttach_Screen, screenId)
 		query = `DELETE FROM cmd WHERE screenid = ?`
 		tx.Exec(query, screenId)
 		query = `UPDATE history SET lineid = '', linenum = 0 WHERE screenid = ?`
@@ -1099,9 +1364,6 @@ func DeleteScreen(ctx context.Context, screenId string, sessionDel bool, update
 	if txErr != nil {
 		return nil, txErr
 	}
-	if !sessionDel {
-		GoDeleteScreenDirs(screenId)
-	}
 	if update == nil {
 		update = scbus.MakeUpdatePacket()
 	}
@@ -1237,12 +1499,16 @@ func UpdateRemoteState(ctx context.Context, sessionId string, screenId string, r
 			if err != nil {
 				return err
 			}
+			recordCwdVisit(tx, remotePtr.RemoteId, feState["cwd"])
 			query = `INSERT INTO remote_instance ( riid, name, sessionid, screenid, remoteownerid, remoteid, festate, statebasehash, statediffhasharr, shelltype)
                                           VALUES (:riid,:name,:sessionid,:screenid,:remoteownerid,:remoteid,:festate,:statebasehash,:statediffhasharr,:shelltype)`
 			tx.NamedExec(query, ri.ToMap())
 			return nil
 		} else {
 			query = `UPDATE remote_instance SET festate = ?, statebasehash = ?, statediffhasharr = ?, shelltype = ? WHERE riid = ?`
+			if feState["cwd"] != ri.FeState["cwd"] {
+				recordCwdVisit(tx, remotePtr.RemoteId, feState["cwd"])
+			}
 			ri.FeState = feState
 			err = updateRIWithState(tx.Context(), ri, stateBase, stateDiff)
 			if err != nil {
@@ -1255,12 +1521,17 @@ func UpdateRemoteState(ctx context.Context, sessionId string, screenId string, r
 	return ri, txErr
 }
 
+// returns an error if the screen is locked to a different remote (screenopts.remotelock)
 func UpdateCurRemote(ctx context.Context, screenId string, remotePtr RemotePtrType) error {
 	return WithTx(ctx, func(tx *TxWrap) error {
-		query := `SELECT screenid FROM screen WHERE screenid = ?`
-		if !tx.Exists(query, screenId) {
+		query := `SELECT * FROM screen WHERE screenid = ?`
+		screen := dbutil.GetMapGen[*ScreenType](tx, query, screenId)
+		if screen == nil {
 			return fmt.Errorf("cannot update curremote: no screen found")
 		}
+		if screen.ScreenOpts.RemoteLock && screen.CurRemote != remotePtr {
+			return fmt.Errorf("screen is locked to its current remote, unlock it with /screen:set remotelock=0")
+		}
 		query = `UPDATE screen SET curremoteownerid = ?, curremoteid = ?, curremotename = ? WHERE screenid = ?`
 		tx.Exec(query, remotePtr.OwnerId, remotePtr.RemoteId, remotePtr.Name, screenId)
 		return nil
@@ -1377,6 +1648,9 @@ func DeleteScreenLines(ctx context.Context, screenId string) (*scbus.ModelUpdate
 		query = `DELETE FROM line 
 				 WHERE screenid = ? AND lineid IN (SELECT value FROM json_each(?))`
 		tx.Exec(query, screenId, quickJsonArr(lineIds))
+		query = `DELETE FROM line_tag
+				 WHERE screenid = ? AND lineid IN (SELECT value FROM json_each(?))`
+		tx.Exec(query, screenId, quickJsonArr(lineIds))
 		query = `UPDATE history SET lineid = '', linenum = 0 
 		         WHERE screenid = ? AND lineid IN (SELECT value FROM json_each(?))`
 		tx.Exec(query, screenId, quickJsonArr(lineIds))
@@ -1389,6 +1663,9 @@ func DeleteScreenLines(ctx context.Context, screenId string) (*scbus.ModelUpdate
 		cleanCtx, cancelFn := context.WithTimeout(context.Background(), time.Minute)
 		defer cancelFn()
 		cleanScreenCmds(cleanCtx, screenId)
+		for _, lineId := range lineIds {
+			deleteLineStateOverflow(cleanCtx, screenId, lineId)
+		}
 	}()
 	screen, err := GetScreenById(ctx, screenId)
 	if err != nil {
@@ -1449,6 +1726,8 @@ func ScreenReset(ctx context.Context, screenId string) ([]*RemoteInstance, error
 		for _, riid := range riids {
 			ri := &RemoteInstance{SessionId: sessionId, ScreenId: screenId, RIId: riid, Remove: true}
 			delRis = append(delRis, ri)
+			query = `DELETE FROM envprofile_attach WHERE targettype = ? AND targetid = ?`
+			tx.Exec(query, EnvAttach_RI, riid)
 		}
 		query = `DELETE FROM remote_instance WHERE sessionid = ? AND screenid = ?`
 		tx.Exec(query, sessionId, screenId)
@@ -1457,11 +1736,30 @@ func ScreenReset(ctx context.Context, screenId string) ([]*RemoteInstance, error
 }
 
 func DeleteSession(ctx context.Context, sessionId string) (scbus.UpdatePacket, error) {
+	return deleteSession(ctx, "", sessionId)
+}
+
+// the screens are deleted in chunks (bulkDeleteScreens, progress is reported if opId is set), then the session.
+// if ctx is canceled the session is kept (with the screens that were not deleted yet).
+func deleteSession(ctx context.Context, opId string, sessionId string) (*scbus.ModelUpdatePacketType, error) {
 	var newActiveSessionId string
-	var screenIds []string
 	var sessionTombstone *SessionTombstoneType
+	screenIds, txErr := WithTxRtn(ctx, func(tx *TxWrap) ([]string, error) {
+		if !tx.Exists(`SELECT sessionid FROM session WHERE sessionid = ?`, sessionId) {
+			return nil, fmt.Errorf("cannot delete session (not found)")
+		}
+		return tx.SelectStrings(`SELECT screenid FROM screen WHERE sessionid = ? ORDER BY archived, screenidx`, sessionId), nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	trashId := scbase.GenWaveUUID()
+	err := bulkDeleteScreens(ctx, opId, sessionId, trashId, screenIds)
+	if err != nil {
+		return nil, err
+	}
 	update := scbus.MakeUpdatePacket()
-	txErr := WithTx(ctx, func(tx *TxWrap) error {
+	txErr = WithTx(ctx, func(tx *TxWrap) error {
 		bareSession, err := GetBareSessionById(tx.Context(), sessionId)
 		if err != nil {
 			return fmt.Errorf("cannot get session to delete: %w", err)
@@ -1469,22 +1767,24 @@ func DeleteSession(ctx context.Context, sessionId string) (scbus.UpdatePacket, e
 		if bareSession == nil {
 			return fmt.Errorf("cannot delete session (not found)")
 		}
+		// screens created while the chunks were running
 		query := `SELECT screenid FROM screen WHERE sessionid = ?`
 		screenIds = tx.SelectStrings(query, sessionId)
 		for _, screenId := range screenIds {
-			_, err := DeleteScreen(tx.Context(), screenId, true, update)
+			_, err := DeleteScreen(tx.Context(), screenId, true, trashId, update)
 			if err != nil {
 				return fmt.Errorf("error deleting screen[%s]: %v", screenId, err)
 			}
 		}
-		query = `DELETE FROM session WHERE sessionid = ?`
-		tx.Exec(query, sessionId)
-		newActiveSessionId, _ = fixActiveSessionId(tx.Context())
 		sessionTombstone = &SessionTombstoneType{
 			SessionId: sessionId,
 			Name:      bareSession.Name,
 			DeletedTs: time.Now().UnixMilli(),
 		}
+		trashSessionTx(tx, trashId, bareSession, sessionTombstone.DeletedTs)
+		query = `DELETE FROM session WHERE sessionid = ?`
+		tx.Exec(query, sessionId)
+		newActiveSessionId, _ = fixActiveSessionId(tx.Context())
 		query = `INSERT INTO session_tombstone ( sessionid, name, deletedts)
 		                                VALUES (:sessionid,:name,:deletedts)`
 		tx.NamedExec(query, dbutil.ToDBMap(sessionTombstone, false))
@@ -1493,7 +1793,6 @@ func DeleteSession(ctx context.Context, sessionId string) (scbus.UpdatePacket, e
 	if txErr != nil {
 		return nil, txErr
 	}
-	GoDeleteScreenDirs(screenIds...)
 	if newActiveSessionId != "" {
 		update.AddUpdate(ActiveSessionIdUpdate(newActiveSessionId))
 	}
@@ -1507,6 +1806,7 @@ func DeleteSession(ctx context.Context, sessionId string) (scbus.UpdatePacket, e
 func fixActiveSessionId(ctx context.Context) (string, error) {
 	var newActiveSessionId string
 	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		defer fixWindowSessions(tx)
 		curActiveSessionId := tx.GetString("SELECT activesessionid FROM client")
 		query := `SELECT sessionid FROM session WHERE sessionid = ? AND NOT archived`
 		if tx.Exists(query, curActiveSessionId) {
@@ -1632,9 +1932,16 @@ const (
 	RemoteField_SSHPassword = "sshpassword" // string
 	RemoteField_Color       = "color"       // string
 	RemoteField_ShellPref   = "shellpref"   // string
+	RemoteField_CmdAllow    = "cmdallow"    // []string
+	RemoteField_CmdDeny     = "cmddeny"     // []string
+	RemoteField_Tags        = "tags"        // []string
+	RemoteField_PreConnect  = "preconnect"  // []*PreConnectHookType
+	RemoteField_InitScripts = "initscripts" // []string
+	RemoteField_IdleKill    = "idlekill"    // int (hours)
+	RemoteField_SudoCred    = "sudocred"    // int (minutes)
 )
 
-// editMap: alias, connectmode, autoinstall, sshkey, color, sshpassword (from constants)
+// editMap: alias, connectmode, autoinstall, sshkey, color, sshpassword, cmdallow, cmddeny, tags, preconnect, initscripts, idlekill, sudocred (from constants)
 // note that all validation should have already happened outside of this function
 func UpdateRemote(ctx context.Context, remoteId string, editMap map[string]interface{}) (*RemoteType, error) {
 	var rtn *RemoteType
@@ -1671,6 +1978,46 @@ func UpdateRemote(ctx context.Context, remoteId string, editMap map[string]inter
 			query = `UPDATE remote SET remoteopts = json_set(remoteopts, '$.color', ?) WHERE remoteid = ?`
 			tx.Exec(query, color, remoteId)
 		}
+		_, allowFound := editMap[RemoteField_CmdAllow]
+		_, denyFound := editMap[RemoteField_CmdDeny]
+		_, tagsFound := editMap[RemoteField_Tags]
+		_, preConnectFound := editMap[RemoteField_PreConnect]
+		_, initScriptsFound := editMap[RemoteField_InitScripts]
+		_, idleKillFound := editMap[RemoteField_IdleKill]
+		_, sudoCredFound := editMap[RemoteField_SudoCred]
+		if allowFound || denyFound || tagsFound || preConnectFound || initScriptsFound || idleKillFound || sudoCredFound {
+			// remoteopts can be stored as json null
+			query = `UPDATE remote SET remoteopts = '{}' WHERE remoteid = ? AND json_type(remoteopts) <> 'object'`
+			tx.Exec(query, remoteId)
+		}
+		if cmdAllow, found := editMap[RemoteField_CmdAllow]; found {
+			query = `UPDATE remote SET remoteopts = json_set(remoteopts, '$.cmdallow', json(?)) WHERE remoteid = ?`
+			tx.Exec(query, quickJsonArr(cmdAllow), remoteId)
+		}
+		if cmdDeny, found := editMap[RemoteField_CmdDeny]; found {
+			query = `UPDATE remote SET remoteopts = json_set(remoteopts, '$.cmddeny', json(?)) WHERE remoteid = ?`
+			tx.Exec(query, quickJsonArr(cmdDeny), remoteId)
+		}
+		if tags, found := editMap[RemoteField_Tags]; found {
+			query = `UPDATE remote SET remoteopts = json_set(remoteopts, '$.tags', json(?)) WHERE remoteid = ?`
+			tx.Exec(query, quickJsonArr(tags), remoteId)
+		}
+		if hooks, found := editMap[RemoteField_PreConnect]; found {
+			query = `UPDATE remote SET remoteopts = json_set(remoteopts, '$.preconnect', json(?)) WHERE remoteid = ?`
+			tx.Exec(query, quickJsonArr(hooks), remoteId)
+		}
+		if initScripts, found := editMap[RemoteField_InitScripts]; found {
+			query = `UPDATE remote SET remoteopts = json_set(remoteopts, '$.initscripts', json(?)) WHERE remoteid = ?`
+			tx.Exec(query, quickJsonArr(initScripts), remoteId)
+		}
+		if idleKill, found := editMap[RemoteField_IdleKill]; found {
+			query = `UPDATE remote SET remoteopts = json_set(remoteopts, '$.idlekillhours', ?) WHERE remoteid = ?`
+			tx.Exec(query, idleKill, remoteId)
+		}
+		if sudoCred, found := editMap[RemoteField_SudoCred]; found {
+			query = `UPDATE remote SET remoteopts = json_set(remoteopts, '$.sudocredminutes', ?) WHERE remoteid = ?`
+			tx.Exec(query, sudoCred, remoteId)
+		}
 		var err error
 		rtn, err = GetRemoteById(tx.Context(), remoteId)
 		if err != nil {
@@ -1692,8 +2039,14 @@ const (
 	ScreenField_TabColor     = "tabcolor"     // string
 	ScreenField_TabIcon      = "tabicon"      // string
 	ScreenField_PTerm        = "pterm"        // string
+	ScreenField_NoNotify     = "nonotify"     // bool
+	ScreenField_RemoteLock   = "remotelock"   // bool
 	ScreenField_Name         = "name"         // string
 	ScreenField_ShareName    = "sharename"    // string
+	ScreenField_StartupCmds  = "startupcmds"  // []string
+	ScreenField_IdleKill     = "idlekill"     // int (hours)
+	ScreenField_ShellPref    = "shellpref"    // string
+	ScreenField_Pinned       = "pinned"       // bool
 )
 
 func UpdateScreen(ctx context.Context, screenId string, editMap map[string]interface{}) (*ScreenType, error) {
@@ -1733,6 +2086,30 @@ func UpdateScreen(ctx context.Context, screenId string, editMap map[string]inter
 			query = `UPDATE screen SET screenopts = json_set(screenopts, '$.pterm', ?) WHERE screenid = ?`
 			tx.Exec(query, pterm, screenId)
 		}
+		if noNotify, found := editMap[ScreenField_NoNotify]; found {
+			query = `UPDATE screen SET screenopts = json_set(screenopts, '$.nonotify', json(?)) WHERE screenid = ?`
+			tx.Exec(query, quickJson(noNotify), screenId)
+		}
+		if remoteLock, found := editMap[ScreenField_RemoteLock]; found {
+			query = `UPDATE screen SET screenopts = json_set(screenopts, '$.remotelock', json(?)) WHERE screenid = ?`
+			tx.Exec(query, quickJson(remoteLock), screenId)
+		}
+		if pinned, found := editMap[ScreenField_Pinned]; found {
+			query = `UPDATE screen SET screenopts = json_set(screenopts, '$.pinned', json(?)) WHERE screenid = ?`
+			tx.Exec(query, quickJson(pinned), screenId)
+		}
+		if startupCmds, found := editMap[ScreenField_StartupCmds]; found {
+			query = `UPDATE screen SET screenopts = json_set(screenopts, '$.startupcmds', json(?)) WHERE screenid = ?`
+			tx.Exec(query, quickJsonArr(startupCmds), screenId)
+		}
+		if idleKill, found := editMap[ScreenField_IdleKill]; found {
+			query = `UPDATE screen SET screenopts = json_set(screenopts, '$.idlekillhours', ?) WHERE screenid = ?`
+			tx.Exec(query, idleKill, screenId)
+		}
+		if shellPref, found := editMap[ScreenField_ShellPref]; found {
+			query = `UPDATE screen SET screenopts = json_set(screenopts, '$.shellpref', ?) WHERE screenid = ?`
+			tx.Exec(query, shellPref, screenId)
+		}
 		if name, found := editMap[ScreenField_Name]; found {
 			query = `UPDATE screen SET name = ? WHERE screenid = ?`
 			tx.Exec(query, name, screenId)
@@ -2001,11 +2378,13 @@ func UpdateLineRenderer(ctx context.Context, screenId string, lineId string, ren
 }
 
 func UpdateLineState(ctx context.Context, screenId string, lineId string, lineState map[string]any) error {
-	qjs := dbutil.QuickJson(lineState)
-	if len(qjs) > MaxLineStateSize {
-		return fmt.Errorf("linestate for line[%s:%s] exceeds maxsize, size[%d] max[%d]", screenId, lineId, len(qjs), MaxLineStateSize)
+	qjs, overflowName, err := makeDBLineState(ctx, screenId, lineId, lineState)
+	if err != nil {
+		return err
 	}
-	return WithTx(ctx, func(tx *TxWrap) error {
+	var oldOverflowName string
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		oldOverflowName = getLineStateOverflowRef(tx, screenId, lineId)
 		query := `UPDATE line SET linestate = ? WHERE screenid = ? AND lineid = ?`
 		tx.Exec(query, qjs, screenId, lineId)
 		if isWebShare(tx, screenId) {
@@ -2013,15 +2392,69 @@ func UpdateLineState(ctx context.Context, screenId string, lineId string, lineSt
 		}
 		return nil
 	})
+	if txErr != nil {
+		if overflowName != "" {
+			deleteLineStateOverflowFile(ctx, screenId, overflowName) // ignore error
+		}
+		return txErr
+	}
+	// the old overflow is referenced by the line row until the update is committed
+	if oldOverflowName != "" && oldOverflowName != overflowName {
+		deleteLineStateOverflowFile(ctx, screenId, oldOverflowName) // ignore error
+	}
+	return nil
 }
 
 // can return nil, nil if line is not found
 func GetLineById(ctx context.Context, screenId string, lineId string) (*LineType, error) {
-	return WithTxRtn(ctx, func(tx *TxWrap) (*LineType, error) {
+	line, err := WithTxRtn(ctx, func(tx *TxWrap) (*LineType, error) {
 		query := `SELECT * FROM line WHERE screenid = ? AND lineid = ?`
 		line := dbutil.GetMappable[*LineType](tx, query, screenId, lineId)
 		return line, nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	hydrateLineState(ctx, line)
+	err = setLineTags(ctx, screenId, line)
+	if err != nil {
+		return nil, err
+	}
+	setLineDayStrs(GetClientLocation(ctx), line)
+	return line, nil
+}
+
+// sets (or if chainId is "", removes) the chain membership of the given lines
+func SetLinesChainId(ctx context.Context, screenId string, lineIds []string, chainId string) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		for _, lineId := range lineIds {
+			if chainId == "" {
+				query := `UPDATE line SET linestate = json_remove(linestate, '$."` + LineState_ChainId + `"') WHERE screenid = ? AND lineid = ?`
+				tx.Exec(query, screenId, lineId)
+			} else {
+				query := `UPDATE line SET linestate = json_set(linestate, '$."` + LineState_ChainId + `"', ?) WHERE screenid = ? AND lineid = ?`
+				tx.Exec(query, chainId, screenId, lineId)
+			}
+			if isWebShare(tx, screenId) {
+				insertScreenLineUpdate(tx, screenId, lineId, UpdateType_LineState)
+			}
+		}
+		return nil
+	})
+}
+
+// returns the lines in the chain, ordered by linenum
+func GetChainLines(ctx context.Context, screenId string, chainId string) ([]*LineType, error) {
+	lines, err := WithTxRtn(ctx, func(tx *TxWrap) ([]*LineType, error) {
+		query := `SELECT * FROM line WHERE screenid = ? AND json_extract(linestate, '$."` + LineState_ChainId + `"') = ? ORDER BY linenum`
+		return dbutil.SelectMappable[*LineType](tx, query, screenId, chainId), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	HydrateLineStates(ctx, lines)
+	setLineDayStrs(GetClientLocation(ctx), lines...)
+	return lines, nil
 }
 
 func SetLineArchivedById(ctx context.Context, screenId string, lineId string, archived bool) error {
@@ -2087,8 +2520,11 @@ func DeleteLinesByIds(ctx context.Context, screenId string, lineIds []string) er
 			}
 			query = `DELETE FROM line WHERE screenid = ? AND lineid = ?`
 			tx.Exec(query, screenId, lineId)
+			query = `DELETE FROM line_tag WHERE screenid = ? AND lineid = ?`
+			tx.Exec(query, screenId, lineId)
 			query = `DELETE FROM cmd WHERE screenid = ? AND lineid = ?`
 			tx.Exec(query, screenId, lineId)
+			insertFeedLineUpdate(tx, screenId, lineId)
 			// don't delete history anymore, just remove lineid reference
 			query = `UPDATE history SET lineid = '', linenum = 0 WHERE screenid = ? AND lineid = ?`
 			tx.Exec(query, screenId, lineId)
@@ -2098,7 +2534,88 @@ func DeleteLinesByIds(ctx context.Context, screenId string, lineIds []string) er
 		}
 		return nil
 	})
-	return txErr
+	if txErr != nil {
+		return txErr
+	}
+	for _, lineId := range lineIds {
+		deleteLineStateOverflow(ctx, screenId, lineId) // ignore error
+	}
+	return nil
+}
+
+// returns the finished cmd lines created before cutoffTs (candidates for ptyout archival)
+func GetArchivablePtyOutLines(ctx context.Context, cutoffTs int64) ([]CmdPtr, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]CmdPtr, error) {
+		var rtn []CmdPtr
+		query := `SELECT l.screenid, l.lineid FROM line l, cmd c
+		          WHERE l.screenid = c.screenid AND l.lineid = c.lineid
+		            AND l.ts < ?
+		            AND c.status NOT IN ('running', 'detached')`
+		tx.Select(&rtn, query, cutoffTs)
+		return rtn, nil
+	})
+}
+
+// removes done ephemeral lines (and their ptyout files).  if screenId is "", all screens are checked.
+// if expireTs > 0, only lines that expire at or before expireTs are removed.
+// returns nil if no lines were removed
+func RemoveEphemeralLines(ctx context.Context, screenId string, expireTs int64) (*scbus.ModelUpdatePacketType, error) {
+	var removedPtrs []CmdPtr
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT screenid, lineid FROM line l
+		          WHERE ephemeral
+		            AND NOT EXISTS (SELECT 1 FROM cmd c WHERE c.screenid = l.screenid AND c.lineid = l.lineid AND c.status IN ('running', 'detached'))`
+		var queryArgs []interface{}
+		if screenId != "" {
+			query += ` AND screenid = ?`
+			queryArgs = append(queryArgs, screenId)
+		}
+		if expireTs > 0 {
+			query += ` AND COALESCE(json_extract(linestate, '$."` + LineState_EphemeralExpireTs + `"'), 0) <= ?`
+			queryArgs = append(queryArgs, expireTs)
+		}
+		tx.Select(&removedPtrs, query, queryArgs...)
+		for _, ptr := range removedPtrs {
+			query = `DELETE FROM line WHERE screenid = ? AND lineid = ?`
+			tx.Exec(query, ptr.ScreenId, ptr.LineId)
+			query = `DELETE FROM line_tag WHERE screenid = ? AND lineid = ?`
+			tx.Exec(query, ptr.ScreenId, ptr.LineId)
+			query = `DELETE FROM cmd WHERE screenid = ? AND lineid = ?`
+			tx.Exec(query, ptr.ScreenId, ptr.LineId)
+			insertFeedLineUpdate(tx, ptr.ScreenId, ptr.LineId)
+			if isWebShare(tx, ptr.ScreenId) {
+				insertScreenLineUpdate(tx, ptr.ScreenId, ptr.LineId, UpdateType_LineDel)
+			}
+		}
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	if len(removedPtrs) == 0 {
+		return nil, nil
+	}
+	update := scbus.MakeUpdatePacket()
+	screenIds := make(map[string]bool)
+	for _, ptr := range removedPtrs {
+		err := DeletePtyOutFile(ctx, ptr.ScreenId, ptr.LineId)
+		if err != nil {
+			log.Printf("error removing ptyout file for ephemeral line %s/%s: %v\n", ptr.ScreenId, ptr.LineId, err)
+		}
+		deleteLineStateOverflow(ctx, ptr.ScreenId, ptr.LineId) // ignore error
+		AddLineUpdate(update, &LineType{ScreenId: ptr.ScreenId, LineId: ptr.LineId, Remove: true}, nil)
+		screenIds[ptr.ScreenId] = true
+	}
+	for sid := range screenIds {
+		screen, err := FixupScreenSelectedLine(ctx, sid)
+		if err != nil {
+			return nil, err
+		}
+		if screen != nil {
+			update.AddUpdate(*screen)
+		}
+	}
+	return update, nil
 }
 
 func GetRIsForScreen(ctx context.Context, sessionId string, screenId string) ([]*RemoteInstance, error) {
@@ -2194,39 +2711,6 @@ func CountScreenLines(ctx context.Context, screenId string) (int, error) {
 	})
 }
 
-// Below is currently not used and is causing circular dependency due to moving telemetry code to a new package. It will likely be rewritten whenever we add back webshare and should be moved to a different package then.
-// func CanScreenWebShare(ctx context.Context, screen *ScreenType) error {
-// 	if screen == nil {
-// 		return fmt.Errorf("cannot share screen, not found")
-// 	}
-// 	if screen.ShareMode == ShareModeWeb {
-// 		return fmt.Errorf("screen is already shared to web")
-// 	}
-// 	if screen.ShareMode != ShareModeLocal {
-// 		return fmt.Errorf("screen cannot be shared, invalid current share mode %q (must be local)", screen.ShareMode)
-// 	}
-// 	if screen.Archived {
-// 		return fmt.Errorf("screen cannot be shared, must un-archive before sharing")
-// 	}
-// 	webShareCount, err := CountScreenWebShares(ctx)
-// 	if err != nil {
-// 		return fmt.Errorf("screen cannot be share: error getting webshare count: %v", err)
-// 	}
-// 	if webShareCount >= MaxWebShareScreenCount {
-// 		go UpdateCurrentActivity(context.Background(), ActivityUpdate{WebShareLimit: 1})
-// 		return fmt.Errorf("screen cannot be shared, limited to a maximum of %d shared screen(s)", MaxWebShareScreenCount)
-// 	}
-// 	lineCount, err := CountScreenLines(ctx, screen.ScreenId)
-// 	if err != nil {
-// 		return fmt.Errorf("screen cannot be share: error getting screen line count: %v", err)
-// 	}
-// 	if lineCount > MaxWebShareLineCount {
-// 		go UpdateCurrentActivity(context.Background(), ActivityUpdate{WebShareLimit: 1})
-// 		return fmt.Errorf("screen cannot be shared, limited to a maximum of %d lines", MaxWebShareLineCount)
-// 	}
-// 	return nil
-// }
-
 func ScreenWebShareStart(ctx context.Context, screenId string, shareOpts ScreenWebShareOpts) error {
 	return WithTx(ctx, func(tx *TxWrap) error {
 		query := `SELECT screenid FROM screen WHERE screenid = ?`
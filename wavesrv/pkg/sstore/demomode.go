@@ -0,0 +1,183 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+)
+
+// demo mode (clientopts demomode), for screen casting and presentations.  enforced on the server: model updates
+// are filtered before they are sent (see demoModeFilter), the festate env values (virtualenv, conda env,
+// prompt vars) are masked and the remote host and user names are replaced.  desktop notifications and webhooks
+// are paused.  only the updates are filtered, command output (and info messages) are sent as is.
+
+const DemoModeMask = "****"
+
+var demoMode atomic.Bool
+
+// festate keys that are sent as is in demo mode (the cwd is needed for the prompt and for completions)
+var demoModeFeStateKeys = map[string]bool{"cwd": true, "shell": true}
+
+// remotevars that identify the remote (user and host names)
+var demoModeRemoteVars = map[string]bool{
+	"user":            true,
+	"host":            true,
+	"shorthost":       true,
+	"alias":           true,
+	"cname":           true,
+	"remoteuser":      true,
+	"remotehost":      true,
+	"remoteshorthost": true,
+	"besthost":        true,
+	"bestshorthost":   true,
+}
+
+func init() {
+	scbus.RegisterUpdateItemFilter(demoModeFilter)
+	RegisterClientOptsHook(func(clientOpts ClientOptsType) {
+		demoMode.Store(clientOpts.DemoMode)
+	})
+}
+
+func IsDemoMode() bool {
+	return demoMode.Load()
+}
+
+func MaskFeStateForDemo(feState map[string]string) map[string]string {
+	if feState == nil {
+		return nil
+	}
+	rtn := make(map[string]string, len(feState))
+	for key, val := range feState {
+		if demoModeFeStateKeys[key] || val == "" {
+			rtn[key] = val
+			continue
+		}
+		rtn[key] = DemoModeMask
+	}
+	return rtn
+}
+
+// the canonical name is replaced with the start of the remoteid, which still resolves as a remote arg
+// (see remote.GetRemoteByArg).  the local remotes keep their fixed aliases ("local" and "sudo").
+func MaskRemoteStateForDemo(state RemoteRuntimeState) RemoteRuntimeState {
+	demoName := state.RemoteId
+	if len(demoName) > 8 {
+		demoName = demoName[0:8]
+	}
+	if !state.Local {
+		state.RemoteAlias = ""
+	}
+	state.RemoteCanonicalName = demoName
+	state.UName = ""
+	vars := make(map[string]string, len(state.RemoteVars))
+	for key, val := range state.RemoteVars {
+		if demoModeRemoteVars[key] {
+			continue
+		}
+		vars[key] = val
+	}
+	if bestUser, ok := vars["bestuser"]; ok && bestUser != "root" && bestUser != "sudo" {
+		if strings.HasPrefix(bestUser, "sudo@") {
+			vars["bestuser"] = "sudo@user"
+		} else {
+			vars["bestuser"] = "user"
+		}
+	}
+	if _, ok := vars["bestname"]; ok {
+		vars["bestname"] = vars["bestuser"] + "@" + demoName
+		vars["bestshortname"] = vars["bestname"]
+	}
+	state.RemoteVars = vars
+	return state
+}
+
+func maskRemoteInstanceForDemo(ri *RemoteInstance) *RemoteInstance {
+	if ri == nil {
+		return nil
+	}
+	rtn := *ri
+	rtn.FeState = MaskFeStateForDemo(ri.FeState)
+	return &rtn
+}
+
+func maskSessionForDemo(session SessionType) SessionType {
+	if session.Remotes == nil {
+		return session
+	}
+	remotes := make([]*RemoteInstance, len(session.Remotes))
+	for idx, ri := range session.Remotes {
+		remotes[idx] = maskRemoteInstanceForDemo(ri)
+	}
+	session.Remotes = remotes
+	return session
+}
+
+func maskCmdForDemo(cmd CmdType) CmdType {
+	cmd.FeState = MaskFeStateForDemo(cmd.FeState)
+	return cmd
+}
+
+func MaskScreenLinesForDemo(screenLines ScreenLinesType) ScreenLinesType {
+	if screenLines.Cmds == nil {
+		return screenLines
+	}
+	cmds := make([]*CmdType, len(screenLines.Cmds))
+	for idx, cmd := range screenLines.Cmds {
+		if cmd == nil {
+			continue
+		}
+		maskedCmd := maskCmdForDemo(*cmd)
+		cmds[idx] = &maskedCmd
+	}
+	screenLines.Cmds = cmds
+	return screenLines
+}
+
+func maskConnectUpdateForDemo(update ConnectUpdate) ConnectUpdate {
+	sessions := make([]*SessionType, len(update.Sessions))
+	for idx, session := range update.Sessions {
+		if session == nil {
+			continue
+		}
+		maskedSession := maskSessionForDemo(*session)
+		sessions[idx] = &maskedSession
+	}
+	update.Sessions = sessions
+	remotes := make([]*RemoteRuntimeState, len(update.Remotes))
+	for idx, state := range update.Remotes {
+		if state == nil {
+			continue
+		}
+		maskedState := MaskRemoteStateForDemo(*state)
+		remotes[idx] = &maskedState
+	}
+	update.Remotes = remotes
+	return update
+}
+
+func demoModeFilter(item scbus.ModelUpdateItem) scbus.ModelUpdateItem {
+	if !IsDemoMode() {
+		return item
+	}
+	switch v := item.(type) {
+	case RemoteRuntimeState:
+		return MaskRemoteStateForDemo(v)
+	case SessionType:
+		return maskSessionForDemo(v)
+	case CmdType:
+		return maskCmdForDemo(v)
+	case ScreenLinesType:
+		return MaskScreenLinesForDemo(v)
+	case ConnectUpdate:
+		return maskConnectUpdateForDemo(v)
+	case NotifyUpdateType:
+		// notifications are paused
+		return nil
+	}
+	return item
+}
@@ -0,0 +1,123 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+)
+
+// directory bookmarks.  named directories (per remote) that can be jumped to (a cd command is run in the
+// screen), see cmdrunner/dirbookmarks.go.  bookmarks are listed most used first.
+
+const MaxDirBookmarksPerRemote = 200
+const MaxDirBookmarkCwdLen = 300
+
+type DirBookmarkType struct {
+	BookmarkId string `json:"bookmarkid"`
+	RemoteId   string `json:"remoteid"`
+	Name       string `json:"name"`
+	Cwd        string `json:"cwd"`
+	CreatedTs  int64  `json:"createdts"`
+	LastUsedTs int64  `json:"lastusedts"`
+	UseCount   int64  `json:"usecount"`
+}
+
+func (DirBookmarkType) UseDBMap() {}
+
+// sent after any change to a remote's directory bookmarks (always the full list for the remote)
+type DirBookmarksUpdateType struct {
+	RemoteId  string             `json:"remoteid"`
+	Bookmarks []*DirBookmarkType `json:"bookmarks"`
+}
+
+func (DirBookmarksUpdateType) GetType() string {
+	return "dirbookmarks"
+}
+
+// search (can be "") matches the name or the cwd (case-insensitive substring)
+func GetDirBookmarks(ctx context.Context, remoteId string, search string) ([]*DirBookmarkType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]*DirBookmarkType, error) {
+		query := `SELECT * FROM dirbookmark WHERE remoteid = ? ORDER BY usecount DESC, name`
+		if search == "" {
+			return dbutil.SelectMappable[*DirBookmarkType](tx, query, remoteId), nil
+		}
+		likeArg := strings.ReplaceAll(search, "%", "\\%")
+		likeArg = strings.ReplaceAll(likeArg, "_", "\\_")
+		likeStr := "%" + likeArg + "%"
+		query = `SELECT * FROM dirbookmark
+		         WHERE remoteid = ? AND (name LIKE ? ESCAPE '\' OR cwd LIKE ? ESCAPE '\')
+		         ORDER BY usecount DESC, name`
+		return dbutil.SelectMappable[*DirBookmarkType](tx, query, remoteId, likeStr, likeStr), nil
+	})
+}
+
+// arg is a bookmark name or bookmarkid, returns nil if not found
+func GetDirBookmarkByArg(ctx context.Context, remoteId string, arg string) (*DirBookmarkType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*DirBookmarkType, error) {
+		query := `SELECT * FROM dirbookmark WHERE remoteid = ? AND (name = ? OR bookmarkid = ?)`
+		return dbutil.GetMappable[*DirBookmarkType](tx, query, remoteId, arg, arg), nil
+	})
+}
+
+// replaces the cwd of an existing bookmark with the same name (keeps its bookmarkid and use count)
+func SaveDirBookmark(ctx context.Context, bm *DirBookmarkType) error {
+	if len(bm.Cwd) > MaxDirBookmarkCwdLen {
+		return fmt.Errorf("directory too long (max %d chars)", MaxDirBookmarkCwdLen)
+	}
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT bookmarkid FROM dirbookmark WHERE remoteid = ? AND name = ?`
+		existingId := tx.GetString(query, bm.RemoteId, bm.Name)
+		if existingId != "" {
+			bm.BookmarkId = existingId
+			query = `UPDATE dirbookmark SET cwd = ? WHERE bookmarkid = ?`
+			tx.Exec(query, bm.Cwd, existingId)
+			return nil
+		}
+		query = `SELECT count(*) FROM dirbookmark WHERE remoteid = ?`
+		if tx.GetInt(query, bm.RemoteId) >= MaxDirBookmarksPerRemote {
+			return fmt.Errorf("too many directory bookmarks for remote (max %d)", MaxDirBookmarksPerRemote)
+		}
+		query = `INSERT INTO dirbookmark ( bookmarkid, remoteid, name, cwd, createdts, lastusedts, usecount)
+		                          VALUES (:bookmarkid,:remoteid,:name,:cwd,:createdts,:lastusedts,:usecount)`
+		tx.NamedExec(query, dbutil.ToDBMap(bm, false))
+		return nil
+	})
+}
+
+func DeleteDirBookmark(ctx context.Context, bookmarkId string) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `DELETE FROM dirbookmark WHERE bookmarkid = ?`
+		tx.Exec(query, bookmarkId)
+		return nil
+	})
+}
+
+func DeleteRemoteDirBookmarks(ctx context.Context, remoteId string) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `DELETE FROM dirbookmark WHERE remoteid = ?`
+		tx.Exec(query, remoteId)
+		return nil
+	})
+}
+
+func MarkDirBookmarkUsed(ctx context.Context, bookmarkId string) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `UPDATE dirbookmark SET usecount = usecount + 1, lastusedts = ? WHERE bookmarkid = ?`
+		tx.Exec(query, time.Now().UnixMilli(), bookmarkId)
+		return nil
+	})
+}
+
+func MakeDirBookmarksUpdate(ctx context.Context, remoteId string) (*DirBookmarksUpdateType, error) {
+	bms, err := GetDirBookmarks(ctx, remoteId, "")
+	if err != nil {
+		return nil, err
+	}
+	return &DirBookmarksUpdateType{RemoteId: remoteId, Bookmarks: bms}, nil
+}
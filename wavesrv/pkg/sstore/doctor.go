@@ -0,0 +1,331 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+)
+
+// self-diagnostic checks for /client:doctor.  each check returns a DoctorCheckType, checks that find
+// problems that are safe to repair (data that nothing references, or state that is rebuilt on the next
+// command) are marked as fixable and are repaired when fix is set.
+
+const (
+	DoctorStatus_Ok    = "ok"
+	DoctorStatus_Warn  = "warn"
+	DoctorStatus_Error = "error"
+)
+
+// max number of detail lines per check
+const DoctorMaxDetails = 10
+
+type DoctorCheckType struct {
+	Name    string   `json:"name"`
+	Status  string   `json:"status"`
+	Message string   `json:"message"`
+	Details []string `json:"details,omitempty"`
+	Fixable bool     `json:"fixable,omitempty"`
+	Fixed   bool     `json:"fixed,omitempty"`
+}
+
+func (c *DoctorCheckType) AddDetail(format string, args ...interface{}) {
+	if len(c.Details) == DoctorMaxDetails {
+		c.Details = append(c.Details, "...")
+	}
+	if len(c.Details) > DoctorMaxDetails {
+		return
+	}
+	c.Details = append(c.Details, fmt.Sprintf(format, args...))
+}
+
+func (c *DoctorCheckType) IsOk() bool {
+	return c.Status == DoctorStatus_Ok || c.Fixed
+}
+
+func makeDoctorCheck(name string) *DoctorCheckType {
+	return &DoctorCheckType{Name: name, Status: DoctorStatus_Ok}
+}
+
+func makeDoctorErrCheck(name string, err error) *DoctorCheckType {
+	return &DoctorCheckType{Name: name, Status: DoctorStatus_Error, Message: fmt.Sprintf("check failed: %v", err)}
+}
+
+type DoctorReportType struct {
+	Ts     int64              `json:"ts"`
+	Fix    bool               `json:"fix"`
+	Checks []*DoctorCheckType `json:"checks"`
+}
+
+func (r *DoctorReportType) NumProblems() int {
+	var rtn int
+	for _, check := range r.Checks {
+		if !check.IsOk() {
+			rtn++
+		}
+	}
+	return rtn
+}
+
+func (r *DoctorReportType) NumFixable() int {
+	var rtn int
+	for _, check := range r.Checks {
+		if check.Fixable && !check.Fixed {
+			rtn++
+		}
+	}
+	return rtn
+}
+
+// runs sqlite's quick_check (a faster integrity_check that skips index verification)
+func DoctorCheckDBIntegrity(ctx context.Context) *DoctorCheckType {
+	const name = "db-integrity"
+	results, err := WithTxRtn(ctx, func(tx *TxWrap) ([]string, error) {
+		return tx.SelectStrings(`PRAGMA quick_check`), nil
+	})
+	if err != nil {
+		return makeDoctorErrCheck(name, err)
+	}
+	check := makeDoctorCheck(name)
+	if len(results) == 1 && results[0] == "ok" {
+		check.Message = "database passed integrity check"
+		return check
+	}
+	check.Status = DoctorStatus_Error
+	check.Message = "database failed integrity check (restore from a backup)"
+	for _, result := range results {
+		check.AddDetail("%s", result)
+	}
+	return check
+}
+
+func DoctorCheckMigrations(ctx context.Context) *DoctorCheckType {
+	const name = "db-migrations"
+	m, err := WithTxRtn(ctx, func(tx *TxWrap) (map[string]interface{}, error) {
+		return tx.GetMap(`SELECT version, dirty FROM schema_migrations`), nil
+	})
+	if err != nil {
+		return makeDoctorErrCheck(name, err)
+	}
+	var version int64
+	var dirty bool
+	quickSetInt64(&version, m, "version")
+	quickSetBool(&dirty, m, "dirty")
+	check := makeDoctorCheck(name)
+	check.Message = fmt.Sprintf("database is at version %d", version)
+	if dirty {
+		check.Status = DoctorStatus_Error
+		check.Message = fmt.Sprintf("migration to version %d did not complete (db is dirty)", version)
+	} else if version != MaxMigration {
+		check.Status = DoctorStatus_Error
+		check.Message = fmt.Sprintf("database is at version %d, expected version %d", version, MaxMigration)
+	}
+	return check
+}
+
+// returns the screen dirs (by screenid) that do not have a screen, a screen that is created while this
+// runs can be returned (its dir can be created before the screen is committed), so callers that remove
+// the dirs should re-check them with IsOrphanedScreenDir.
+func FindOrphanedScreenDirs(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(scbase.GetScreensDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read screens dir: %w", err)
+	}
+	screenIds, err := WithTxRtn(ctx, func(tx *TxWrap) ([]string, error) {
+		// screens in the trash keep their dirs until they are purged
+		return tx.SelectStrings(`SELECT screenid FROM screen UNION SELECT objid FROM trash WHERE objtype = ?`, TrashObjType_Screen), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return getOrphanedScreenDirNames(entries, screenIds), nil
+}
+
+func getOrphanedScreenDirNames(entries []os.DirEntry, screenIds []string) []string {
+	screenMap := make(map[string]bool)
+	for _, screenId := range screenIds {
+		screenMap[screenId] = true
+	}
+	var rtn []string
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || screenMap[entry.Name()] {
+			continue
+		}
+		rtn = append(rtn, entry.Name())
+	}
+	return rtn
+}
+
+func IsOrphanedScreenDir(ctx context.Context, screenId string) (bool, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (bool, error) {
+		if tx.Exists(`SELECT trashid FROM trash WHERE objtype = ? AND objid = ?`, TrashObjType_Screen, screenId) {
+			return false, nil
+		}
+		return !tx.Exists(`SELECT screenid FROM screen WHERE screenid = ?`, screenId), nil
+	})
+}
+
+// removes the orphaned screen dir (and its blockstore data), returns false if the screen exists
+func RemoveOrphanedScreenDir(ctx context.Context, screenId string) (bool, error) {
+	isOrphaned, err := IsOrphanedScreenDir(ctx, screenId)
+	if err != nil || !isOrphaned {
+		return false, err
+	}
+	err = DeleteScreenDir(ctx, screenId)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func DoctorCheckScreenDirs(ctx context.Context, fix bool) *DoctorCheckType {
+	const name = "screen-dirs"
+	orphans, err := FindOrphanedScreenDirs(ctx)
+	if err != nil {
+		return makeDoctorErrCheck(name, err)
+	}
+	check := makeDoctorCheck(name)
+	if len(orphans) == 0 {
+		check.Message = "no orphaned screen directories"
+		return check
+	}
+	check.Status = DoctorStatus_Warn
+	check.Fixable = true
+	check.Message = fmt.Sprintf("%d orphaned screen directories (screen was deleted)", len(orphans))
+	for _, screenId := range orphans {
+		check.AddDetail("%s", screenId)
+	}
+	if !fix {
+		return check
+	}
+	for _, screenId := range orphans {
+		_, err = RemoveOrphanedScreenDir(ctx, screenId)
+		if err != nil {
+			check.AddDetail("error removing %s: %v", screenId, err)
+			return check
+		}
+	}
+	check.Fixed = true
+	return check
+}
+
+// cmd rows whose line was deleted
+func DoctorCheckDanglingCmds(ctx context.Context, fix bool) *DoctorCheckType {
+	const name = "dangling-cmds"
+	type cmdKey struct {
+		ScreenId string
+		LineId   string
+	}
+	danglingCmds, err := WithTxRtn(ctx, func(tx *TxWrap) ([]cmdKey, error) {
+		query := `SELECT c.screenid, c.lineid FROM cmd c
+		          WHERE NOT EXISTS (SELECT 1 FROM line l WHERE l.screenid = c.screenid AND l.lineid = c.lineid)`
+		var rtn []cmdKey
+		for _, m := range tx.SelectMaps(query) {
+			var key cmdKey
+			quickSetStr(&key.ScreenId, m, "screenid")
+			quickSetStr(&key.LineId, m, "lineid")
+			rtn = append(rtn, key)
+		}
+		if fix {
+			query = `DELETE FROM cmd WHERE screenid = ? AND lineid = ?`
+			for _, key := range rtn {
+				tx.Exec(query, key.ScreenId, key.LineId)
+			}
+		}
+		return rtn, nil
+	})
+	if err != nil {
+		return makeDoctorErrCheck(name, err)
+	}
+	check := makeDoctorCheck(name)
+	if len(danglingCmds) == 0 {
+		check.Message = "no dangling cmds"
+		return check
+	}
+	check.Status = DoctorStatus_Warn
+	check.Fixable = true
+	check.Fixed = fix
+	check.Message = fmt.Sprintf("%d cmds without a line", len(danglingCmds))
+	for _, key := range danglingCmds {
+		check.AddDetail("screen %s line %s", key.ScreenId, key.LineId)
+	}
+	return check
+}
+
+// returns the hashes in the state chain that do not exist
+func getMissingStateHashes(tx *TxWrap, baseHash string, diffHashArr []string) []string {
+	var rtn []string
+	if baseHash == "" || !tx.Exists(`SELECT basehash FROM state_base WHERE basehash = ?`, baseHash) {
+		rtn = append(rtn, "base:"+baseHash)
+	}
+	for _, diffHash := range diffHashArr {
+		if !tx.Exists(`SELECT diffhash FROM state_diff WHERE diffhash = ?`, diffHash) {
+			rtn = append(rtn, "diff:"+diffHash)
+		}
+	}
+	return rtn
+}
+
+// remote instances whose state chain is missing a state_base or state_diff.  with fix, the remote instances
+// are removed (the screen's shell state is re-initialized on its next command), the removed remote instances
+// are returned so the sessions can be updated.
+func DoctorCheckStateChains(ctx context.Context, fix bool) (*DoctorCheckType, []*RemoteInstance) {
+	const name = "state-chains"
+	type brokenRIType struct {
+		RI      *RemoteInstance
+		Missing []string
+	}
+	brokenRIs, err := WithTxRtn(ctx, func(tx *TxWrap) ([]brokenRIType, error) {
+		var rtn []brokenRIType
+		query := `SELECT riid, sessionid, screenid, statebasehash, statediffhasharr FROM remote_instance`
+		for _, m := range tx.SelectMaps(query) {
+			var ptr riStatePtr
+			ri := &RemoteInstance{Remove: true}
+			quickSetStr(&ri.RIId, m, "riid")
+			quickSetStr(&ri.SessionId, m, "sessionid")
+			quickSetStr(&ri.ScreenId, m, "screenid")
+			quickSetStr(&ptr.BaseHash, m, "statebasehash")
+			quickSetJsonArr(&ptr.DiffHashArr, m, "statediffhasharr")
+			missing := getMissingStateHashes(tx, ptr.BaseHash, ptr.DiffHashArr)
+			if len(missing) > 0 {
+				rtn = append(rtn, brokenRIType{RI: ri, Missing: missing})
+			}
+		}
+		if fix {
+			query = `DELETE FROM remote_instance WHERE riid = ?`
+			for _, broken := range rtn {
+				tx.Exec(query, broken.RI.RIId)
+			}
+		}
+		return rtn, nil
+	})
+	if err != nil {
+		return makeDoctorErrCheck(name, err), nil
+	}
+	check := makeDoctorCheck(name)
+	if len(brokenRIs) == 0 {
+		check.Message = "all remote instance states are complete"
+		return check, nil
+	}
+	check.Status = DoctorStatus_Error
+	check.Fixable = true
+	check.Fixed = fix
+	check.Message = fmt.Sprintf("%d remote instances have broken states (will be reset)", len(brokenRIs))
+	var removedRIs []*RemoteInstance
+	for _, broken := range brokenRIs {
+		check.AddDetail("ri %s (screen %s) missing %s", broken.RI.RIId, broken.RI.ScreenId, strings.Join(broken.Missing, ", "))
+		removedRIs = append(removedRIs, broken.RI)
+	}
+	if !fix {
+		return check, nil
+	}
+	return check, removedRIs
+}
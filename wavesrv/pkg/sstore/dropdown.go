@@ -0,0 +1,87 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+)
+
+// the dropdown (quake-style) window, opened with the global shortcut, shows one designated session or screen
+// (clientopts dropdownsessionid/dropdownscreenid).  when only a session is designated the dropdown follows the
+// session's active screen.  its connect update only includes the designated session and screen(s) so the window
+// can attach without loading every session.
+
+// returns "" for the sessionid if no dropdown is designated (or the designated session/screen was archived or
+// deleted), the screenid is "" when only a session is designated
+func GetDropdownTarget(ctx context.Context) (string, string, error) {
+	clientData, err := EnsureClientData(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	sessionId := clientData.ClientOpts.DropdownSessionId
+	screenId := clientData.ClientOpts.DropdownScreenId
+	if sessionId == "" {
+		return "", "", nil
+	}
+	return WithTxRtn3(ctx, func(tx *TxWrap) (string, string, error) {
+		query := `SELECT sessionid FROM session WHERE sessionid = ? AND NOT archived`
+		if !tx.Exists(query, sessionId) {
+			return "", "", nil
+		}
+		if screenId == "" {
+			return sessionId, "", nil
+		}
+		query = `SELECT screenid FROM screen WHERE sessionid = ? AND screenid = ? AND NOT archived`
+		if !tx.Exists(query, sessionId, screenId) {
+			return "", "", nil
+		}
+		return sessionId, screenId, nil
+	})
+}
+
+// screenId "" designates the session (sessionId "" clears the dropdown)
+func SetDropdownTarget(ctx context.Context, sessionId string, screenId string) (*ClientData, error) {
+	clientData, err := EnsureClientData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clientOpts := clientData.ClientOpts
+	clientOpts.DropdownSessionId = sessionId
+	clientOpts.DropdownScreenId = screenId
+	err = SetClientOpts(ctx, clientOpts)
+	if err != nil {
+		return nil, err
+	}
+	clientData.ClientOpts = clientOpts
+	return clientData, nil
+}
+
+// returns nil if no dropdown is designated.  remotes, indicators, and themes are filled in by the caller
+// (same as GetConnectUpdate)
+func GetDropdownConnectUpdate(ctx context.Context) (*ConnectUpdate, error) {
+	sessionId, screenId, err := GetDropdownTarget(ctx)
+	if err != nil || sessionId == "" {
+		return nil, err
+	}
+	return WithTxRtn(ctx, func(tx *TxWrap) (*ConnectUpdate, error) {
+		update := &ConnectUpdate{Dropdown: true, ActiveSessionId: sessionId}
+		session := &SessionType{}
+		query := `SELECT * FROM session WHERE sessionid = ?`
+		tx.Get(session, query, sessionId)
+		update.Sessions = []*SessionType{session}
+		if screenId != "" {
+			query = `SELECT * FROM screen WHERE screenid = ?`
+			update.Screens = dbutil.SelectMapsGen[*ScreenType](tx, query, screenId)
+		} else {
+			query = `SELECT * FROM screen WHERE sessionid = ? AND NOT archived ORDER BY screenidx`
+			update.Screens = dbutil.SelectMapsGen[*ScreenType](tx, query, sessionId)
+		}
+		query = `SELECT * FROM remote_instance WHERE sessionid = ?`
+		session.Remotes = dbutil.SelectMapsGen[*RemoteInstance](tx, query, sessionId)
+		update.ScreenInputs = restoreScreenInputs(getScreenInputs(tx), getScreenIds(update.Screens))
+		return update, nil
+	})
+}
@@ -0,0 +1,203 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"unicode/utf8"
+)
+
+// character encoding of command output.  the pty output is stored as received, the encoding of commands
+// that do not output utf-8 (legacy tools, latin-1 or shift-jis locales) is detected from the output and
+// stored in the line state (LineState_Encoding), the terminal renderer decodes the output with it.  the
+// encoding names are the WHATWG labels (so the frontend can use TextDecoder).  the backend only converts
+// the single-byte encodings (for diffs and exports), other encodings are passed through unchanged.
+
+const (
+	Encoding_Utf8        = "utf-8"
+	Encoding_Windows1252 = "windows-1252" // also used for iso-8859-1 (as in the WHATWG encoding spec)
+	Encoding_ShiftJis    = "shift_jis"
+	Encoding_EucJp       = "euc-jp"
+	Encoding_EucKr       = "euc-kr"
+	Encoding_Gbk         = "gbk"
+	Encoding_Gb18030     = "gb18030"
+	Encoding_Big5        = "big5"
+	Encoding_Koi8r       = "koi8-r"
+	Encoding_Windows1251 = "windows-1251"
+)
+
+// encodings that can be set with /line:set encoding=...
+var SupportedEncodings = []string{
+	Encoding_Utf8, Encoding_Windows1252, Encoding_ShiftJis, Encoding_EucJp, Encoding_EucKr,
+	Encoding_Gbk, Encoding_Gb18030, Encoding_Big5, Encoding_Koi8r, Encoding_Windows1251,
+}
+
+var encodingAliases = map[string]string{
+	"utf8":       Encoding_Utf8,
+	"latin1":     Encoding_Windows1252,
+	"latin-1":    Encoding_Windows1252,
+	"iso-8859-1": Encoding_Windows1252,
+	"cp1252":     Encoding_Windows1252,
+	"sjis":       Encoding_ShiftJis,
+	"shift-jis":  Encoding_ShiftJis,
+	"cp932":      Encoding_ShiftJis,
+	"eucjp":      Encoding_EucJp,
+	"euckr":      Encoding_EucKr,
+	"cp936":      Encoding_Gbk,
+	"cp1251":     Encoding_Windows1251,
+}
+
+// minimum number of valid double-byte characters to detect a cjk encoding
+const MinCjkDetectChars = 2
+
+// returns "" if the encoding is not supported
+func NormalizeEncoding(encoding string) string {
+	if alias, ok := encodingAliases[encoding]; ok {
+		return alias
+	}
+	for _, supported := range SupportedEncodings {
+		if encoding == supported {
+			return supported
+		}
+	}
+	return ""
+}
+
+// returns "" if the line has no (detected or set) encoding, i.e. the output is utf-8
+func GetLineEncoding(line *LineType) string {
+	if line == nil || line.LineState == nil {
+		return ""
+	}
+	encoding, _ := line.LineState[LineState_Encoding].(string)
+	return encoding
+}
+
+// trims incomplete utf-8 sequences at the start and end of data (pty writes can split multibyte characters)
+func trimPartialUtf8(data []byte) []byte {
+	for n := 0; n < utf8.UTFMax-1 && len(data) > 0 && !utf8.RuneStart(data[0]); n++ {
+		data = data[1:]
+	}
+	data, _ = splitIncompleteUtf8(data)
+	return data
+}
+
+func hasHighBytes(data []byte) bool {
+	for _, b := range data {
+		if b >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
+
+// returns (valid, number of double-byte characters)
+func checkShiftJis(data []byte) (bool, int) {
+	numChars := 0
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		if b < 0x80 || (b >= 0xA1 && b <= 0xDF) {
+			// ascii or half-width katakana
+			continue
+		}
+		if !((b >= 0x81 && b <= 0x9F) || (b >= 0xE0 && b <= 0xFC)) {
+			return false, 0
+		}
+		if i+1 == len(data) {
+			// lead byte split by the write
+			break
+		}
+		trail := data[i+1]
+		if trail < 0x40 || trail == 0x7F || trail > 0xFC {
+			return false, 0
+		}
+		numChars++
+		i++
+	}
+	return true, numChars
+}
+
+// returns (valid, number of double-byte characters)
+func checkEucJp(data []byte) (bool, int) {
+	numChars := 0
+	isEucByte := func(b byte) bool { return b >= 0xA1 && b <= 0xFE }
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		if b < 0x80 {
+			continue
+		}
+		var seqLen int
+		switch {
+		case b == 0x8E:
+			seqLen = 2 // half-width katakana
+		case b == 0x8F:
+			seqLen = 3 // jis x 0212
+		case isEucByte(b):
+			seqLen = 2
+		default:
+			return false, 0
+		}
+		if i+seqLen > len(data) {
+			break
+		}
+		for j := 1; j < seqLen; j++ {
+			if !isEucByte(data[i+j]) {
+				return false, 0
+			}
+		}
+		numChars++
+		i += seqLen - 1
+	}
+	return true, numChars
+}
+
+// returns "" if data is ascii (nothing to detect), Encoding_Utf8 if it is valid utf-8 (with at least one
+// complete multibyte character), otherwise the detected encoding.  only euc-jp, shift_jis and windows-1252
+// (the fallback, any byte sequence is valid) are detected, the others have to be set with /line:set.
+func DetectEncoding(data []byte) string {
+	if !hasHighBytes(data) {
+		return ""
+	}
+	if trimmed := trimPartialUtf8(data); utf8.Valid(trimmed) {
+		if !hasHighBytes(trimmed) {
+			// only a partial character, undecided
+			return ""
+		}
+		return Encoding_Utf8
+	}
+	// euc-jp is checked first, its byte ranges are narrower (shift_jis hiragana/katakana leads are invalid euc)
+	if valid, numChars := checkEucJp(data); valid && numChars >= MinCjkDetectChars {
+		return Encoding_EucJp
+	}
+	if valid, numChars := checkShiftJis(data); valid && numChars >= MinCjkDetectChars {
+		return Encoding_ShiftJis
+	}
+	return Encoding_Windows1252
+}
+
+// windows-1252 0x80-0x9F (the undefined bytes map to the c1 controls, as in the WHATWG spec)
+var windows1252High = [32]rune{
+	0x20AC, 0x0081, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008D, 0x017D, 0x008F,
+	0x0090, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0x009D, 0x017E, 0x0178,
+}
+
+// converts data to utf-8.  returns false (and data unchanged) if the encoding is utf-8 or cannot be
+// converted by the backend.
+func DecodeToUtf8(data []byte, encoding string) ([]byte, bool) {
+	if encoding != Encoding_Windows1252 || !hasHighBytes(data) {
+		return data, false
+	}
+	rtn := make([]byte, 0, len(data)+len(data)/4)
+	for _, b := range data {
+		switch {
+		case b < 0x80:
+			rtn = append(rtn, b)
+		case b < 0xA0:
+			rtn = utf8.AppendRune(rtn, windows1252High[b-0x80])
+		default:
+			rtn = utf8.AppendRune(rtn, rune(b))
+		}
+	}
+	return rtn, true
+}
@@ -0,0 +1,270 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+)
+
+// env profiles.  an env profile is a named set of environment variables that can be attached to screens and to
+// remote instances.  the vars of the attached profiles are added to the shell state of each command run on the
+// screen (see remote/envprofiles.go), they are not persisted in the remote instance state.  screen profiles are
+// applied first, then remote instance profiles, each in the order they were attached (later profiles win).
+// a secret-backed var has no stored value, its value is read from SecretFile (on the wavesrv host) for each run.
+
+const (
+	EnvAttach_Screen = "screen"
+	EnvAttach_RI     = "ri"
+)
+
+const MaxEnvProfileVars = 100
+const MaxEnvSecretFileSize = 64 * 1024
+
+var envVarNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+type EnvVarType struct {
+	Name       string `json:"name"`
+	Value      string `json:"value,omitempty"`
+	SecretFile string `json:"secretfile,omitempty"`
+}
+
+type EnvProfileType struct {
+	ProfileId string       `json:"profileid"`
+	Name      string       `json:"name"`
+	Vars      []EnvVarType `json:"vars"`
+	CreatedTs int64        `json:"createdts"`
+}
+
+func (p *EnvProfileType) ToMap() map[string]interface{} {
+	rtn := make(map[string]interface{})
+	rtn["profileid"] = p.ProfileId
+	rtn["name"] = p.Name
+	rtn["vars"] = quickJsonArr(p.Vars)
+	rtn["createdts"] = p.CreatedTs
+	return rtn
+}
+
+func (p *EnvProfileType) FromMap(m map[string]interface{}) bool {
+	quickSetStr(&p.ProfileId, m, "profileid")
+	quickSetStr(&p.Name, m, "name")
+	quickSetJsonArr(&p.Vars, m, "vars")
+	quickSetInt64(&p.CreatedTs, m, "createdts")
+	return true
+}
+
+// sets (or replaces) the var with the same name
+func (p *EnvProfileType) SetVar(envVar EnvVarType) {
+	for idx, v := range p.Vars {
+		if v.Name == envVar.Name {
+			p.Vars[idx] = envVar
+			return
+		}
+	}
+	p.Vars = append(p.Vars, envVar)
+}
+
+// returns false if the var was not found
+func (p *EnvProfileType) RemoveVar(name string) bool {
+	for idx, v := range p.Vars {
+		if v.Name == name {
+			p.Vars = append(p.Vars[:idx], p.Vars[idx+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type EnvProfileAttachType struct {
+	TargetType string `json:"targettype"`
+	TargetId   string `json:"targetid"` // screenid or riid
+	ProfileId  string `json:"profileid"`
+	AttachTs   int64  `json:"attachts"`
+}
+
+func (EnvProfileAttachType) UseDBMap() {}
+
+// sent (to all clients) after any change to the env profiles or their attachments
+type EnvProfilesUpdateType struct {
+	Profiles    []*EnvProfileType       `json:"profiles"`
+	Attachments []*EnvProfileAttachType `json:"attachments"`
+}
+
+func (EnvProfilesUpdateType) GetType() string {
+	return "envprofiles"
+}
+
+func ValidateEnvVar(envVar EnvVarType) error {
+	if !envVarNameRe.MatchString(envVar.Name) {
+		return fmt.Errorf("invalid env var name %q", envVar.Name)
+	}
+	if strings.IndexByte(envVar.Value, 0) != -1 {
+		return fmt.Errorf("env var %s value cannot contain NUL characters", envVar.Name)
+	}
+	if envVar.SecretFile != "" && envVar.Value != "" {
+		return fmt.Errorf("env var %s cannot have both a value and a secretfile", envVar.Name)
+	}
+	return nil
+}
+
+func GetEnvProfiles(ctx context.Context) ([]*EnvProfileType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]*EnvProfileType, error) {
+		query := `SELECT * FROM envprofile ORDER BY name`
+		return dbutil.SelectMapsGen[*EnvProfileType](tx, query), nil
+	})
+}
+
+// arg is a profile name or profileid, returns nil if not found
+func GetEnvProfileByArg(ctx context.Context, arg string) (*EnvProfileType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*EnvProfileType, error) {
+		query := `SELECT * FROM envprofile WHERE name = ? OR profileid = ?`
+		return dbutil.GetMapGen[*EnvProfileType](tx, query, arg, arg), nil
+	})
+}
+
+// inserts the profile, or updates the vars of the existing profile with the same profileid
+func SaveEnvProfile(ctx context.Context, profile *EnvProfileType) error {
+	if len(profile.Vars) > MaxEnvProfileVars {
+		return fmt.Errorf("too many vars in env profile (max %d)", MaxEnvProfileVars)
+	}
+	for _, envVar := range profile.Vars {
+		err := ValidateEnvVar(envVar)
+		if err != nil {
+			return err
+		}
+	}
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT profileid FROM envprofile WHERE profileid = ?`
+		if tx.Exists(query, profile.ProfileId) {
+			query = `UPDATE envprofile SET vars = ? WHERE profileid = ?`
+			tx.Exec(query, quickJsonArr(profile.Vars), profile.ProfileId)
+			return nil
+		}
+		query = `SELECT profileid FROM envprofile WHERE name = ?`
+		if tx.Exists(query, profile.Name) {
+			return fmt.Errorf("env profile %q already exists", profile.Name)
+		}
+		query = `INSERT INTO envprofile ( profileid, name, vars, createdts)
+		                         VALUES (:profileid,:name,:vars,:createdts)`
+		tx.NamedExec(query, profile.ToMap())
+		return nil
+	})
+}
+
+// also detaches the profile
+func DeleteEnvProfile(ctx context.Context, profileId string) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `DELETE FROM envprofile WHERE profileid = ?`
+		tx.Exec(query, profileId)
+		query = `DELETE FROM envprofile_attach WHERE profileid = ?`
+		tx.Exec(query, profileId)
+		return nil
+	})
+}
+
+// re-attaching a profile moves it to the end (it is applied last)
+func AttachEnvProfile(ctx context.Context, attach *EnvProfileAttachType) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT profileid FROM envprofile WHERE profileid = ?`
+		if !tx.Exists(query, attach.ProfileId) {
+			return fmt.Errorf("env profile not found")
+		}
+		query = `DELETE FROM envprofile_attach WHERE targettype = ? AND targetid = ? AND profileid = ?`
+		tx.Exec(query, attach.TargetType, attach.TargetId, attach.ProfileId)
+		query = `INSERT INTO envprofile_attach ( targettype, targetid, profileid, attachts)
+		                                VALUES (:targettype,:targetid,:profileid,:attachts)`
+		tx.NamedExec(query, dbutil.ToDBMap(attach, false))
+		return nil
+	})
+}
+
+// returns false if the profile was not attached to the target
+func DetachEnvProfile(ctx context.Context, targetType string, targetId string, profileId string) (bool, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (bool, error) {
+		query := `SELECT profileid FROM envprofile_attach WHERE targettype = ? AND targetid = ? AND profileid = ?`
+		if !tx.Exists(query, targetType, targetId, profileId) {
+			return false, nil
+		}
+		query = `DELETE FROM envprofile_attach WHERE targettype = ? AND targetid = ? AND profileid = ?`
+		tx.Exec(query, targetType, targetId, profileId)
+		return true, nil
+	})
+}
+
+func GetEnvProfileAttachments(ctx context.Context) ([]*EnvProfileAttachType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]*EnvProfileAttachType, error) {
+		query := `SELECT * FROM envprofile_attach ORDER BY attachts`
+		return dbutil.SelectMappable[*EnvProfileAttachType](tx, query), nil
+	})
+}
+
+func MakeEnvProfilesUpdate(ctx context.Context) (*EnvProfilesUpdateType, error) {
+	profiles, err := GetEnvProfiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := GetEnvProfileAttachments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &EnvProfilesUpdateType{Profiles: profiles, Attachments: attachments}, nil
+}
+
+// returns the profiles attached to the screen, then the ones attached to the remote instance (riId can be "")
+func GetRunEnvProfiles(ctx context.Context, screenId string, riId string) ([]*EnvProfileType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]*EnvProfileType, error) {
+		query := `SELECT p.*
+		          FROM envprofile_attach a, envprofile p
+		          WHERE a.profileid = p.profileid AND ((a.targettype = ? AND a.targetid = ?) OR (a.targettype = ? AND a.targetid = ?))
+		          ORDER BY CASE WHEN a.targettype = ? THEN 0 ELSE 1 END, a.attachts`
+		return dbutil.SelectMapsGen[*EnvProfileType](tx, query, EnvAttach_Screen, screenId, EnvAttach_RI, riId, EnvAttach_Screen), nil
+	})
+}
+
+func readEnvSecretFile(fileName string) (string, error) {
+	if fileName == "~" || strings.HasPrefix(fileName, "~/") {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		fileName = filepath.Join(homeDir, fileName[1:])
+	}
+	finfo, err := os.Stat(fileName)
+	if err != nil {
+		return "", err
+	}
+	if finfo.Size() > MaxEnvSecretFileSize {
+		return "", fmt.Errorf("secret file too large (max %d bytes)", MaxEnvSecretFileSize)
+	}
+	barr, err := os.ReadFile(fileName)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(barr), "\r\n"), nil
+}
+
+// merges the vars of the profiles (later profiles win), reads the secret files
+func ResolveEnvProfileVars(profiles []*EnvProfileType) (map[string]string, error) {
+	rtn := make(map[string]string)
+	for _, profile := range profiles {
+		for _, envVar := range profile.Vars {
+			if envVar.SecretFile == "" {
+				rtn[envVar.Name] = envVar.Value
+				continue
+			}
+			secretVal, err := readEnvSecretFile(envVar.SecretFile)
+			if err != nil {
+				return nil, fmt.Errorf("env profile %q, cannot read secret for %s: %w", profile.Name, envVar.Name, err)
+			}
+			rtn[envVar.Name] = secretVal
+		}
+	}
+	return rtn, nil
+}
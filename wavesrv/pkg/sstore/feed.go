@@ -0,0 +1,169 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+)
+
+// update sequence journal for the polling feeds (/api/feed, for integrations that cannot hold a websocket).
+// every new, changed (cmd status), or deleted cmd line gets a "line" entry, every new or changed history item a
+// "history" entry.  the updateid is the feed cursor, a client polls with the last updateid it has seen and gets
+// the objects changed since then (each object once, with its current value).  only the last MaxFeedUpdates
+// entries are kept, a cursor that is older than that gets Reset (the client has to resync).
+
+const (
+	FeedType_Line    = "line"
+	FeedType_History = "history"
+)
+
+const MaxFeedUpdates = 20000
+const DefaultFeedPageSize = 100
+const MaxFeedPageSize = 1000
+
+type FeedUpdateType struct {
+	UpdateId  int64  `json:"updateid"`
+	FeedType  string `json:"feedtype"`
+	ScreenId  string `json:"screenid"`
+	LineId    string `json:"lineid"`
+	HistoryId string `json:"historyid"`
+	UpdateTs  int64  `json:"updatets"`
+}
+
+func (FeedUpdateType) UseDBMap() {}
+
+type FeedPageType struct {
+	Updates    []*FeedUpdateType // oldest first, one per object (its last update)
+	NextCursor int64             // the cursor for the next poll (unchanged if there are no updates)
+	HasMore    bool
+	Reset      bool // the cursor is older than the journal, the client missed updates
+}
+
+type FeedLineItemType struct {
+	UpdateId int64     `json:"updateid"`
+	ScreenId string    `json:"screenid"`
+	LineId   string    `json:"lineid"`
+	Removed  bool      `json:"removed,omitempty"`
+	Line     *LineType `json:"line,omitempty"`
+	Cmd      *CmdType  `json:"cmd,omitempty"`
+}
+
+func IsValidFeedType(feedType string) bool {
+	return feedType == FeedType_Line || feedType == FeedType_History
+}
+
+func insertFeedUpdate(tx *TxWrap, feedType string, screenId string, lineId string, historyId string) {
+	query := `INSERT INTO feedupdate (feedtype, screenid, lineid, historyid, updatets) VALUES (?, ?, ?, ?, ?)`
+	tx.Exec(query, feedType, screenId, lineId, historyId, time.Now().UnixMilli())
+	query = `DELETE FROM feedupdate WHERE updateid <= (SELECT max(updateid) FROM feedupdate) - ?`
+	tx.Exec(query, MaxFeedUpdates)
+}
+
+// journals a change of a cmd line and of the history item of the line (if any).  must be called before the
+// history item is unlinked from the line.
+func insertFeedLineUpdate(tx *TxWrap, screenId string, lineId string) {
+	insertFeedUpdate(tx, FeedType_Line, screenId, lineId, "")
+	query := `SELECT historyid FROM history WHERE screenid = ? AND lineid = ?`
+	for _, historyId := range tx.SelectStrings(query, screenId, lineId) {
+		insertFeedUpdate(tx, FeedType_History, screenId, lineId, historyId)
+	}
+}
+
+// called by history.InsertHistoryItem
+func InsertFeedHistoryUpdate(tx *TxWrap, screenId string, lineId string, historyId string) {
+	insertFeedUpdate(tx, FeedType_History, screenId, lineId, historyId)
+}
+
+// returns the last updateid and its updatets (0, 0 if the journal is empty)
+func GetFeedHead(ctx context.Context, feedType string) (int64, int64, error) {
+	head, err := WithTxRtn(ctx, func(tx *TxWrap) (*FeedUpdateType, error) {
+		query := `SELECT * FROM feedupdate WHERE feedtype = ? ORDER BY updateid DESC LIMIT 1`
+		return dbutil.GetMappable[*FeedUpdateType](tx, query, feedType), nil
+	})
+	if err != nil || head == nil {
+		return 0, 0, err
+	}
+	return head.UpdateId, head.UpdateTs, nil
+}
+
+// screenId can be "" (all screens)
+func GetFeedUpdates(ctx context.Context, feedType string, cursor int64, pageSize int, screenId string) (*FeedPageType, error) {
+	if !IsValidFeedType(feedType) {
+		return nil, fmt.Errorf("invalid feed type %q", feedType)
+	}
+	if pageSize <= 0 || pageSize > MaxFeedPageSize {
+		pageSize = DefaultFeedPageSize
+	}
+	return WithTxRtn(ctx, func(tx *TxWrap) (*FeedPageType, error) {
+		rtn := &FeedPageType{NextCursor: cursor}
+		query := `SELECT COALESCE(min(updateid), 0) FROM feedupdate`
+		minId := int64(tx.GetInt(query))
+		if cursor > 0 && minId > cursor+1 {
+			rtn.Reset = true
+		}
+		query = `SELECT * FROM feedupdate WHERE feedtype = ? AND updateid > ?`
+		queryArgs := []interface{}{feedType, cursor}
+		if screenId != "" {
+			query += ` AND screenid = ?`
+			queryArgs = append(queryArgs, screenId)
+		}
+		query += fmt.Sprintf(` ORDER BY updateid LIMIT %d`, pageSize+1)
+		updates := dbutil.SelectMappable[*FeedUpdateType](tx, query, queryArgs...)
+		if len(updates) > pageSize {
+			rtn.HasMore = true
+			updates = updates[:pageSize]
+		}
+		if len(updates) > 0 {
+			rtn.NextCursor = updates[len(updates)-1].UpdateId
+		}
+		rtn.Updates = dedupFeedUpdates(updates)
+		return rtn, nil
+	})
+}
+
+func (update *FeedUpdateType) objKey() string {
+	if update.HistoryId != "" {
+		return update.HistoryId
+	}
+	return update.ScreenId + "/" + update.LineId
+}
+
+// keeps the last update of each object
+func dedupFeedUpdates(updates []*FeedUpdateType) []*FeedUpdateType {
+	lastIdx := make(map[string]int)
+	for idx, update := range updates {
+		lastIdx[update.objKey()] = idx
+	}
+	var rtn []*FeedUpdateType
+	for idx, update := range updates {
+		if lastIdx[update.objKey()] == idx {
+			rtn = append(rtn, update)
+		}
+	}
+	return rtn
+}
+
+// the current lines (and cmds) of the line updates, deleted lines are returned as Removed
+func GetFeedLineItems(ctx context.Context, updates []*FeedUpdateType) ([]*FeedLineItemType, error) {
+	var rtn []*FeedLineItemType
+	for _, update := range updates {
+		line, cmd, err := GetLineCmdByLineId(ctx, update.ScreenId, update.LineId)
+		if err != nil {
+			return nil, err
+		}
+		item := &FeedLineItemType{UpdateId: update.UpdateId, ScreenId: update.ScreenId, LineId: update.LineId}
+		if line == nil {
+			item.Removed = true
+		} else {
+			item.Line = line
+			item.Cmd = cmd
+		}
+		rtn = append(rtn, item)
+	}
+	return rtn, nil
+}
@@ -4,62 +4,231 @@
 package sstore
 
 import (
+	"bytes"
 	"context"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"io/fs"
 	"log"
 	"os"
 	"path"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/wavetermdev/waveterm/waveshell/pkg/cirfile"
 	"github.com/wavetermdev/waveterm/waveshell/pkg/shexec"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/blockstore"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
 )
 
-func CreateCmdPtyFile(ctx context.Context, screenId string, lineId string, maxSize int64) error {
-	ptyOutFileName, err := scbase.PtyOutFile(screenId, lineId)
+// pty output is stored in the blockstore (blockid=screenid, name=ptyout:[lineid]).  the file is used as a
+// ring buffer of maxsize bytes, output at (logical) position pos is stored at pos % maxsize.  the
+// logical end of the output is kept in the file meta (endpos), only the last maxsize bytes are readable.
+
+const PtyOutFilePrefix = "ptyout:"
+const PtyOutMeta_EndPos = "endpos"
+
+// ptyout reads, writes and archiving are serialized per line (the line's ptyout, timing and raw output files),
+// see lockPtyOutLine.  the line locks are held with ptyOutFilesLock shared, it is held exclusive to work on the
+// files of all the lines (retention pruning).
+var ptyOutFilesLock = &sync.RWMutex{}
+var ptyOutLineLocksLock = &sync.Mutex{}
+var ptyOutLineLocks = make(map[string]*ptyOutLineLock) // key is screenid/lineid
+
+type ptyOutLineLock struct {
+	Lock *sync.Mutex
+	Refs int // guarded by ptyOutLineLocksLock, removed from ptyOutLineLocks at 0
+}
+
+// locks the line's ptyout files, returns the unlock func
+func lockPtyOutLine(screenId string, lineId string) func() {
+	key := screenId + "/" + lineId
+	ptyOutLineLocksLock.Lock()
+	lineLock := ptyOutLineLocks[key]
+	if lineLock == nil {
+		lineLock = &ptyOutLineLock{Lock: &sync.Mutex{}}
+		ptyOutLineLocks[key] = lineLock
+	}
+	lineLock.Refs++
+	ptyOutLineLocksLock.Unlock()
+	ptyOutFilesLock.RLock()
+	lineLock.Lock.Lock()
+	return func() {
+		lineLock.Lock.Unlock()
+		ptyOutFilesLock.RUnlock()
+		ptyOutLineLocksLock.Lock()
+		defer ptyOutLineLocksLock.Unlock()
+		lineLock.Refs--
+		if lineLock.Refs == 0 {
+			delete(ptyOutLineLocks, key)
+		}
+	}
+}
+
+type PtyOutStat struct {
+	Location   string
+	MaxSize    int64
+	FileOffset int64 // logical offset of the first readable byte
+	DataSize   int64
+	Compressed bool
+}
+
+func ptyOutFileName(lineId string) string {
+	return PtyOutFilePrefix + lineId
+}
+
+func getPtyOutEndPos(fInfo *blockstore.FileInfo) int64 {
+	switch endPos := fInfo.Meta[PtyOutMeta_EndPos].(type) {
+	case int64:
+		return endPos
+	case float64:
+		// meta read from the db
+		return int64(endPos)
+	}
+	return fInfo.Size
+}
+
+// must hold the line's ptyout lock.  does not delete the line's timing and raw output files (archiving keeps
+// them), callers starting new output delete them.
+func createPtyOutFile(ctx context.Context, screenId string, lineId string, maxSize int64, compress bool) error {
+	if maxSize <= 0 {
+		maxSize = shexec.DefaultMaxPtySize
+	}
+	name := ptyOutFileName(lineId)
+	blockstore.DeleteFile(ctx, screenId, name) // ignore error, may not exist
+	meta := blockstore.FileMeta{PtyOutMeta_EndPos: int64(0)}
+	return blockstore.MakeFile(ctx, screenId, name, meta, blockstore.FileOptsType{MaxSize: maxSize, Compress: compress})
+}
+
+// must hold the line's ptyout lock.  data before the readable window is dropped, a write past the end of the
+// output fills the gap with zero bytes.
+func writePtyOutData(ctx context.Context, screenId string, lineId string, data []byte, pos int64) error {
+	name := ptyOutFileName(lineId)
+	fInfo, err := blockstore.Stat(ctx, screenId, name)
 	if err != nil {
 		return err
 	}
-	f, err := cirfile.CreateCirFile(ptyOutFileName, maxSize)
+	maxSize := fInfo.Opts.MaxSize
+	endPos := getPtyOutEndPos(fInfo)
+	if pos > endPos {
+		gapStart := endPos
+		if pos-gapStart > maxSize {
+			gapStart = pos - maxSize
+		}
+		data = append(make([]byte, pos-gapStart), data...)
+		pos = gapStart
+	}
+	newEndPos := pos + int64(len(data))
+	if newEndPos < endPos {
+		newEndPos = endPos
+	}
+	if firstPos := newEndPos - maxSize; pos < firstPos {
+		skip := firstPos - pos
+		if skip >= int64(len(data)) {
+			return nil
+		}
+		data = data[skip:]
+		pos = firstPos
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	physPos := pos % maxSize
+	headLen := int64(len(data))
+	if headLen > maxSize-physPos {
+		headLen = maxSize - physPos
+	}
+	if headLen < int64(len(data)) {
+		// wrapped part first, so the head is never written past the end of the stored data
+		_, err = blockstore.WriteAt(ctx, screenId, name, data[headLen:], 0)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = blockstore.WriteAt(ctx, screenId, name, data[0:headLen], physPos)
 	if err != nil {
 		return err
 	}
-	return f.Close()
+	fInfo.Meta[PtyOutMeta_EndPos] = newEndPos
+	return blockstore.WriteMeta(ctx, screenId, name, fInfo.Meta)
 }
 
-func StatCmdPtyFile(ctx context.Context, screenId string, lineId string) (*cirfile.Stat, error) {
-	ptyOutFileName, err := scbase.PtyOutFile(screenId, lineId)
+// must hold the line's ptyout lock.  maxSize < 0 reads all available data.  returns (real-offset, data, err)
+func readPtyOutData(ctx context.Context, screenId string, lineId string, offset int64, maxSize int64) (int64, []byte, error) {
+	name := ptyOutFileName(lineId)
+	fInfo, err := blockstore.Stat(ctx, screenId, name)
 	if err != nil {
-		return nil, err
+		return 0, nil, err
+	}
+	endPos := getPtyOutEndPos(fInfo)
+	if offset < endPos-fInfo.Size {
+		offset = endPos - fInfo.Size
+	}
+	if offset >= endPos {
+		return endPos, nil, nil
+	}
+	readLen := endPos - offset
+	if maxSize >= 0 && readLen > maxSize {
+		readLen = maxSize
+	}
+	buf := make([]byte, readLen)
+	physPos := offset % fInfo.Opts.MaxSize
+	headLen := readLen
+	if headLen > fInfo.Opts.MaxSize-physPos {
+		headLen = fInfo.Opts.MaxSize - physPos
 	}
-	return cirfile.StatCirFile(ctx, ptyOutFileName)
+	headBuf := buf[0:headLen]
+	_, err = blockstore.ReadAt(ctx, screenId, name, &headBuf, physPos)
+	if err != nil {
+		return 0, nil, err
+	}
+	if headLen < readLen {
+		tailBuf := buf[headLen:]
+		_, err = blockstore.ReadAt(ctx, screenId, name, &tailBuf, 0)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	return offset, buf, nil
 }
 
-func ClearCmdPtyFile(ctx context.Context, screenId string, lineId string) error {
-	ptyOutFileName, err := scbase.PtyOutFile(screenId, lineId)
+func CreateCmdPtyFile(ctx context.Context, screenId string, lineId string, maxSize int64) error {
+	defer lockPtyOutLine(screenId, lineId)()
+	deletePtyTimingFile(ctx, screenId, lineId)
+	deleteRawOutFile(ctx, screenId, lineId)
+	return createPtyOutFile(ctx, screenId, lineId, maxSize, false)
+}
+
+func StatCmdPtyFile(ctx context.Context, screenId string, lineId string) (*PtyOutStat, error) {
+	defer lockPtyOutLine(screenId, lineId)()
+	fInfo, err := blockstore.Stat(ctx, screenId, ptyOutFileName(lineId))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	stat, err := cirfile.StatCirFile(ctx, ptyOutFileName)
+	endPos := getPtyOutEndPos(fInfo)
+	return &PtyOutStat{
+		Location:   fmt.Sprintf("blockstore:%s/%s", screenId, ptyOutFileName(lineId)),
+		MaxSize:    fInfo.Opts.MaxSize,
+		FileOffset: endPos - fInfo.Size,
+		DataSize:   fInfo.Size,
+		Compressed: fInfo.Opts.Compress,
+	}, nil
+}
+
+func ClearCmdPtyFile(ctx context.Context, screenId string, lineId string) error {
+	defer lockPtyOutLine(screenId, lineId)()
+	var maxSize int64 = shexec.DefaultMaxPtySize
+	fInfo, err := blockstore.Stat(ctx, screenId, ptyOutFileName(lineId))
 	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return err
 	}
-	os.Remove(ptyOutFileName) // ignore error
-	var maxSize int64 = shexec.DefaultMaxPtySize
-	if stat != nil {
-		maxSize = stat.MaxSize
-	}
-	err = CreateCmdPtyFile(ctx, screenId, lineId, maxSize)
-	if err != nil {
-		return err
+	if fInfo != nil {
+		maxSize = fInfo.Opts.MaxSize
 	}
-	return nil
+	deletePtyTimingFile(ctx, screenId, lineId)
+	deleteRawOutFile(ctx, screenId, lineId)
+	return createPtyOutFile(ctx, screenId, lineId, maxSize, false)
 }
 
 func AppendToCmdPtyBlob(ctx context.Context, screenId string, lineId string, data []byte, pos int64) (*scbus.PtyDataUpdatePacketType, error) {
@@ -69,26 +238,27 @@ func AppendToCmdPtyBlob(ctx context.Context, screenId string, lineId string, dat
 	if pos < 0 {
 		return nil, fmt.Errorf("invalid seek pos '%d' in AppendToCmdPtyBlob", pos)
 	}
-	ptyOutFileName, err := scbase.PtyOutFile(screenId, lineId)
-	if err != nil {
-		return nil, err
-	}
-	f, err := cirfile.OpenCirFile(ptyOutFileName)
-	if err != nil {
-		return nil, err
+	unlockFn := lockPtyOutLine(screenId, lineId)
+	err := writePtyOutData(ctx, screenId, lineId, data, pos)
+	if err == nil {
+		timingErr := writePtyTimingEntry(ctx, screenId, lineId, PtyTimingEntry{Pos: pos, Ts: time.Now().UnixMilli()})
+		if timingErr != nil {
+			// just log
+			log.Printf("error writing pty timing %s/%s: %v\n", screenId, lineId, timingErr)
+		}
 	}
-	defer f.Close()
-	err = f.WriteAt(ctx, data, pos)
+	unlockFn()
 	if err != nil {
 		return nil, err
 	}
-	data64 := base64.StdEncoding.EncodeToString(data)
+	// the update is sent later (on the websocket goroutines), callers can reuse data (read buffers)
 	update := scbus.MakePtyDataUpdate(&scbus.PtyDataUpdate{
 		ScreenId:   screenId,
 		LineId:     lineId,
 		PtyPos:     pos,
-		PtyData64:  data64,
 		PtyDataLen: int64(len(data)),
+		RenderHint: trackPtyFlow(screenId, lineId, int64(len(data)), time.Now()),
+		PtyData:    bytes.Clone(data),
 	})
 	err = MaybeInsertPtyPosUpdate(ctx, screenId, lineId)
 	if err != nil {
@@ -100,30 +270,14 @@ func AppendToCmdPtyBlob(ctx context.Context, screenId string, lineId string, dat
 
 // returns (real-offset, data, err)
 func ReadFullPtyOutFile(ctx context.Context, screenId string, lineId string) (int64, []byte, error) {
-	ptyOutFileName, err := scbase.PtyOutFile(screenId, lineId)
-	if err != nil {
-		return 0, nil, err
-	}
-	f, err := cirfile.OpenCirFile(ptyOutFileName)
-	if err != nil {
-		return 0, nil, err
-	}
-	defer f.Close()
-	return f.ReadAll(ctx)
+	defer lockPtyOutLine(screenId, lineId)()
+	return readPtyOutData(ctx, screenId, lineId, 0, -1)
 }
 
 // returns (real-offset, data, err)
 func ReadPtyOutFile(ctx context.Context, screenId string, lineId string, offset int64, maxSize int64) (int64, []byte, error) {
-	ptyOutFileName, err := scbase.PtyOutFile(screenId, lineId)
-	if err != nil {
-		return 0, nil, err
-	}
-	f, err := cirfile.OpenCirFile(ptyOutFileName)
-	if err != nil {
-		return 0, nil, err
-	}
-	defer f.Close()
-	return f.ReadAtWithMax(ctx, offset, maxSize)
+	defer lockPtyOutLine(screenId, lineId)()
+	return readPtyOutData(ctx, screenId, lineId, offset, maxSize)
 }
 
 type SessionDiskSizeType struct {
@@ -190,21 +344,122 @@ func FullSessionDiskSize() (map[string]SessionDiskSizeType, error) {
 }
 
 func DeletePtyOutFile(ctx context.Context, screenId string, lineId string) error {
-	ptyOutFileName, err := scbase.PtyOutFile(screenId, lineId)
+	defer lockPtyOutLine(screenId, lineId)()
+	deletePtyTimingFile(ctx, screenId, lineId)
+	deleteRawOutFile(ctx, screenId, lineId)
+	return blockstore.DeleteFile(ctx, screenId, ptyOutFileName(lineId))
+}
+
+// rewrites the ptyout file with compressed data blocks.  an archived file keeps its name, size and meta (only
+// FileOpts.Compress changes), so it is read and appended to like any other ptyout file.  legacy gzipped
+// cirfile archives are converted by MigratePtyOutFiles.  returns (archived, bytes-reclaimed, err),
+// archived is false if the file does not exist or is already compressed.
+func ArchivePtyOutFile(ctx context.Context, screenId string, lineId string) (bool, int64, error) {
+	defer lockPtyOutLine(screenId, lineId)()
+	name := ptyOutFileName(lineId)
+	fInfo, err := blockstore.Stat(ctx, screenId, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, 0, nil
+	}
 	if err != nil {
-		return err
+		return false, 0, err
 	}
-	err = os.Remove(ptyOutFileName)
-	if errors.Is(err, fs.ErrNotExist) {
-		return nil
+	if fInfo.Opts.Compress || fInfo.Size == 0 {
+		return false, 0, nil
+	}
+	err = blockstore.FlushFile(ctx, screenId, name)
+	if err != nil {
+		return false, 0, err
+	}
+	oldSize, err := blockstore.GetFileStoredSize(ctx, screenId, name)
+	if err != nil {
+		return false, 0, err
+	}
+	data := make([]byte, fInfo.Size)
+	_, err = blockstore.ReadAt(ctx, screenId, name, &data, 0)
+	if err != nil {
+		return false, 0, err
+	}
+	err = createPtyOutFile(ctx, screenId, lineId, fInfo.Opts.MaxSize, true)
+	if err != nil {
+		return false, 0, err
+	}
+	_, err = blockstore.WriteAt(ctx, screenId, name, data, 0)
+	if err == nil {
+		err = blockstore.WriteMeta(ctx, screenId, name, fInfo.Meta)
+	}
+	if err == nil {
+		err = blockstore.FlushFile(ctx, screenId, name)
+	}
+	if err != nil {
+		return false, 0, fmt.Errorf("error archiving ptyout file: %w", err)
+	}
+	newSize, err := blockstore.GetFileStoredSize(ctx, screenId, name)
+	if err != nil {
+		return true, 0, err
 	}
-	return err
+	return true, oldSize - newSize, nil
 }
 
+// archives the ptyout files of finished commands whose lines are older than archiveDays.
+// returns (num-archived, bytes-reclaimed, err)
+func ArchiveOldPtyOutFiles(ctx context.Context, archiveDays int) (int, int64, error) {
+	if archiveDays <= 0 {
+		return 0, 0, nil
+	}
+	cutoffTime := time.Now().Add(-time.Duration(archiveDays) * 24 * time.Hour)
+	cmdPtrs, err := GetArchivablePtyOutLines(ctx, cutoffTime.UnixMilli())
+	if err != nil {
+		return 0, 0, err
+	}
+	var numArchived int
+	var totalReclaimed int64
+	for _, ptr := range cmdPtrs {
+		if ctx.Err() != nil {
+			return numArchived, totalReclaimed, ctx.Err()
+		}
+		archived, reclaimed, err := ArchivePtyOutFile(ctx, ptr.ScreenId, ptr.LineId)
+		if err != nil {
+			log.Printf("error archiving ptyout file %s/%s: %v\n", ptr.ScreenId, ptr.LineId, err)
+			continue
+		}
+		if archived {
+			numArchived++
+			totalReclaimed += reclaimed
+		}
+	}
+	return numArchived, totalReclaimed, nil
+}
+
+// screen dirs are deleted by a pool of ScreenDirDeleteWorkers goroutines (started on first use), so a large
+// delete (bulk ops, session delete) does not remove hundreds of dirs serially.
+const ScreenDirDeleteWorkers = 4
+const screenDirDeleteQueueSize = 256
+
+var screenDirDeleteCh = make(chan string, screenDirDeleteQueueSize)
+var screenDirDeleteOnce = &sync.Once{}
+
+func startScreenDirDeleteWorkers() {
+	screenDirDeleteOnce.Do(func() {
+		for i := 0; i < ScreenDirDeleteWorkers; i++ {
+			go func() {
+				for screenId := range screenDirDeleteCh {
+					deleteScreenDirMakeCtx(screenId)
+				}
+			}()
+		}
+	})
+}
+
+// queues the screen dirs for deletion, does not block
 func GoDeleteScreenDirs(screenIds ...string) {
+	if len(screenIds) == 0 {
+		return
+	}
+	startScreenDirDeleteWorkers()
 	go func() {
 		for _, screenId := range screenIds {
-			deleteScreenDirMakeCtx(screenId)
+			screenDirDeleteCh <- screenId
 		}
 	}()
 }
@@ -223,6 +478,99 @@ func DeleteScreenDir(ctx context.Context, screenId string) error {
 	if err != nil {
 		return fmt.Errorf("error getting screendir: %w", err)
 	}
+	err = blockstore.DeleteBlock(ctx, screenId)
+	if err != nil {
+		log.Printf("error deleting blockstore files (ptyout, linestate) for screen %s: %v\n", screenId, err)
+	}
 	log.Printf("delete screen dir, remove-all %s\n", screenDir)
 	return os.RemoveAll(screenDir)
 }
+
+// orphaned screen dirs (the screen is gone, e.g. the server exited before GoDeleteScreenDirs finished) are moved
+// to the quarantine dir by ReconcileScreenDirs, and removed (along with their blockstore data) after
+// ScreenDirQuarantineTime.  FindOrphanedScreenDirs skips the quarantine dir (dot prefix).
+const ScreenDirQuarantineName = ".orphaned"
+const ScreenDirQuarantineTime = 7 * 24 * time.Hour
+
+type ScreenDirReconcileStats struct {
+	Quarantined int
+	Removed     int
+}
+
+func quarantineScreenDir(quarantineDir string, screenId string) error {
+	qDir := path.Join(quarantineDir, screenId)
+	err := os.RemoveAll(qDir)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(path.Join(scbase.GetScreensDir(), screenId), qDir)
+	if err != nil {
+		return err
+	}
+	// the quarantine time is tracked with the dir's mtime
+	now := time.Now()
+	return os.Chtimes(qDir, now, now)
+}
+
+// removes quarantined dirs that are older than ScreenDirQuarantineTime
+func purgeQuarantinedScreenDirs(ctx context.Context, quarantineDir string) (int, error) {
+	entries, err := os.ReadDir(quarantineDir)
+	if err != nil {
+		return 0, err
+	}
+	cutoffTime := time.Now().Add(-ScreenDirQuarantineTime)
+	var numRemoved int
+	for _, entry := range entries {
+		fileInfo, err := entry.Info()
+		if err != nil || !entry.IsDir() || fileInfo.ModTime().After(cutoffTime) {
+			continue
+		}
+		screenId := entry.Name()
+		isOrphaned, err := IsOrphanedScreenDir(ctx, screenId)
+		if err != nil {
+			return numRemoved, err
+		}
+		if isOrphaned {
+			err = blockstore.DeleteBlock(ctx, screenId)
+			if err != nil {
+				log.Printf("error deleting blockstore files for orphaned screen %s: %v\n", screenId, err)
+			}
+		}
+		err = os.RemoveAll(path.Join(quarantineDir, screenId))
+		if err != nil {
+			return numRemoved, err
+		}
+		numRemoved++
+	}
+	return numRemoved, nil
+}
+
+// moves the orphaned screen dirs to the quarantine dir, and removes the expired quarantined dirs.
+// must run before screens can be created (on startup), see FindOrphanedScreenDirs.
+func ReconcileScreenDirs(ctx context.Context) (*ScreenDirReconcileStats, error) {
+	rtn := &ScreenDirReconcileStats{}
+	orphans, err := FindOrphanedScreenDirs(ctx)
+	if err != nil {
+		return rtn, err
+	}
+	quarantineDir := path.Join(scbase.GetScreensDir(), ScreenDirQuarantineName)
+	if len(orphans) > 0 {
+		err = os.MkdirAll(quarantineDir, 0700)
+		if err != nil {
+			return rtn, fmt.Errorf("cannot create screen quarantine dir: %w", err)
+		}
+	}
+	for _, screenId := range orphans {
+		err = quarantineScreenDir(quarantineDir, screenId)
+		if err != nil {
+			return rtn, fmt.Errorf("cannot quarantine screen dir %s: %w", screenId, err)
+		}
+		rtn.Quarantined++
+	}
+	numRemoved, err := purgeQuarantinedScreenDirs(ctx, quarantineDir)
+	rtn.Removed = numRemoved
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return rtn, fmt.Errorf("cannot purge quarantined screen dirs: %w", err)
+	}
+	return rtn, nil
+}
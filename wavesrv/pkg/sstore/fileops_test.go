@@ -0,0 +1,74 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/blockstore"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+)
+
+func initTestBlockstore(t *testing.T) {
+	t.Setenv(scbase.WaveHomeVarName, t.TempDir())
+	err := blockstore.MigrateBlockstore()
+	if err != nil {
+		t.Fatalf("MigrateBlockstore error: %v", err)
+	}
+	t.Cleanup(blockstore.CloseDB)
+}
+
+func TestArchivePtyOutFileKeepsLineFiles(t *testing.T) {
+	initTestBlockstore(t)
+	ctx := context.Background()
+	screenId := uuid.New().String()
+	lineId := uuid.New().String()
+	ptyData := []byte("hello world\r\n")
+	err := CreateCmdPtyFile(ctx, screenId, lineId, 0)
+	if err != nil {
+		t.Fatalf("CreateCmdPtyFile error: %v", err)
+	}
+	unlockFn := lockPtyOutLine(screenId, lineId)
+	err = writePtyOutData(ctx, screenId, lineId, ptyData, 0)
+	if err == nil {
+		err = writePtyTimingEntry(ctx, screenId, lineId, PtyTimingEntry{Pos: 0, Ts: 1000})
+	}
+	unlockFn()
+	if err != nil {
+		t.Fatalf("error writing ptyout: %v", err)
+	}
+	err = CreateCmdRawOutFile(ctx, screenId, lineId)
+	if err != nil {
+		t.Fatalf("CreateCmdRawOutFile error: %v", err)
+	}
+	archived, _, err := ArchivePtyOutFile(ctx, screenId, lineId)
+	if err != nil || !archived {
+		t.Fatalf("ArchivePtyOutFile archived=%v err=%v", archived, err)
+	}
+	_, data, err := ReadFullPtyOutFile(ctx, screenId, lineId)
+	if err != nil || !bytes.Equal(data, ptyData) {
+		t.Errorf("archived ptyout data %q err=%v, expected %q", data, err, ptyData)
+	}
+	rawData, totalSize, err := ReadCmdRawOut(ctx, screenId, lineId)
+	if err != nil || !bytes.Equal(rawData, ptyData) || totalSize != int64(len(ptyData)) {
+		t.Errorf("rawout after archive %q size=%d err=%v, expected %q", rawData, totalSize, err, ptyData)
+	}
+	unlockFn = lockPtyOutLine(screenId, lineId)
+	entries, err := readPtyTimingEntries(ctx, screenId, lineId)
+	unlockFn()
+	if err != nil || len(entries) != 1 || entries[0] != (PtyTimingEntry{Pos: 0, Ts: 1000}) {
+		t.Errorf("timing after archive %v err=%v, expected one entry", entries, err)
+	}
+	err = ClearCmdPtyFile(ctx, screenId, lineId)
+	if err != nil {
+		t.Fatalf("ClearCmdPtyFile error: %v", err)
+	}
+	_, _, err = ReadCmdRawOut(ctx, screenId, lineId)
+	if err == nil {
+		t.Errorf("rawout should be deleted when the output is cleared")
+	}
+}
@@ -0,0 +1,357 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sawka/txwrap"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/blockstore"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+)
+
+// a foreign db is another wave db (from a backup or another machine) attached read-only, so its sessions,
+// screens and lines can be browsed without merging them into the live db.  screens are copied into the live
+// db explicitly (ImportForeignScreen) with their lines, cmds, and files (pty output, line state) from the
+// blockstore db next to it.  only one foreign db can be attached, it must be at the same db version.
+
+type foreignDBType struct {
+	DBName           string
+	DB               *sqlx.DB
+	BlockstoreDBName string // empty if there is no blockstore db (screens are imported without output)
+	BlockstoreDB     *sqlx.DB
+}
+
+type ForeignDBInfoType struct {
+	DBName        string
+	HasBlockstore bool
+	NumSessions   int
+	NumScreens    int
+}
+
+type ForeignScreenType struct {
+	ScreenId string
+	Name     string
+	Archived bool
+	NumLines int
+}
+
+type ForeignSessionType struct {
+	SessionId string
+	Name      string
+	Archived  bool
+	Screens   []*ForeignScreenType
+}
+
+type ForeignLineType struct {
+	LineId     string
+	LineNum    int64
+	Ts         int64
+	LineType   string
+	Text       string
+	CmdStr     string
+	RemoteName string
+	Status     string
+	ExitCode   int64
+}
+
+// holds foreignDBLock for the whole transaction, so the db cannot be detached while it is used
+type foreignDBGetter struct {
+	blockstore bool
+}
+
+var foreignDBLock = &sync.Mutex{}
+var foreignDB *foreignDBType
+
+func (g foreignDBGetter) GetDB(ctx context.Context) (*sqlx.DB, error) {
+	foreignDBLock.Lock()
+	if foreignDB == nil {
+		foreignDBLock.Unlock()
+		return nil, fmt.Errorf("no foreign db attached")
+	}
+	if g.blockstore {
+		if foreignDB.BlockstoreDB == nil {
+			foreignDBLock.Unlock()
+			return nil, fmt.Errorf("foreign db has no blockstore db")
+		}
+		return foreignDB.BlockstoreDB, nil
+	}
+	return foreignDB.DB, nil
+}
+
+func (g foreignDBGetter) ReleaseDB(db *sqlx.DB) {
+	foreignDBLock.Unlock()
+}
+
+func foreignHasBlockstore() bool {
+	foreignDBLock.Lock()
+	defer foreignDBLock.Unlock()
+	return foreignDB != nil && foreignDB.BlockstoreDB != nil
+}
+
+func withForeignTx(ctx context.Context, blockstore bool, fn func(tx *TxWrap) error) error {
+	if txwrap.IsTxWrapContext(ctx) {
+		return fmt.Errorf("cannot use the foreign db from within a running transaction")
+	}
+	return txwrap.DBGWithTx(ctx, foreignDBGetter{blockstore: blockstore}, fn)
+}
+
+func openForeignDB(dbName string) (*sqlx.DB, error) {
+	db, err := sqlx.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro&_busy_timeout=5000", dbName))
+	if err != nil {
+		return nil, err
+	}
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func getForeignMigrateVersion(db *sqlx.DB) (int64, bool, error) {
+	var version int64
+	var dirty bool
+	err := db.QueryRow(`SELECT version, dirty FROM schema_migrations`).Scan(&version, &dirty)
+	return version, dirty, err
+}
+
+// dbPath is a waveterm.db file or a wave home dir
+func AttachForeignDB(ctx context.Context, dbPath string) (*ForeignDBInfoType, error) {
+	dbPath, err := filepath.Abs(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if finfo, err := os.Stat(dbPath); err == nil && finfo.IsDir() {
+		dbPath = filepath.Join(dbPath, DBFileName)
+	}
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, fmt.Errorf("cannot open foreign db: %w", err)
+	}
+	liveDBName, _ := filepath.Abs(GetDBName())
+	if dbPath == liveDBName {
+		return nil, fmt.Errorf("cannot attach the live db as a foreign db")
+	}
+	fdb := &foreignDBType{DBName: dbPath}
+	fdb.DB, err = openForeignDB(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open foreign db %s: %w", dbPath, err)
+	}
+	version, dirty, err := getForeignMigrateVersion(fdb.DB)
+	if err != nil || dirty || version != MaxMigration {
+		fdb.DB.Close()
+		return nil, fmt.Errorf("foreign db is at version %d (dirty=%v, err=%v), expected version %d (open it with this version of wave to migrate it)", version, dirty, err, MaxMigration)
+	}
+	bsDBName := filepath.Join(filepath.Dir(dbPath), blockstore.DBFileName)
+	if _, err := os.Stat(bsDBName); err == nil {
+		bsDB, err := openForeignDB(bsDBName)
+		if err == nil {
+			bsVersion, bsDirty, bsErr := getForeignMigrateVersion(bsDB)
+			liveBsVersion, _, _ := blockstore.GetMigrateVersion(nil)
+			if bsErr == nil && !bsDirty && uint(bsVersion) == liveBsVersion {
+				fdb.BlockstoreDBName = bsDBName
+				fdb.BlockstoreDB = bsDB
+			} else {
+				bsDB.Close()
+			}
+		}
+	}
+	DetachForeignDB()
+	foreignDBLock.Lock()
+	foreignDB = fdb
+	foreignDBLock.Unlock()
+	return GetForeignDBInfo(ctx)
+}
+
+// returns false if no foreign db was attached
+func DetachForeignDB() bool {
+	foreignDBLock.Lock()
+	defer foreignDBLock.Unlock()
+	if foreignDB == nil {
+		return false
+	}
+	foreignDB.DB.Close()
+	if foreignDB.BlockstoreDB != nil {
+		foreignDB.BlockstoreDB.Close()
+	}
+	foreignDB = nil
+	return true
+}
+
+func GetForeignDBInfo(ctx context.Context) (*ForeignDBInfoType, error) {
+	var rtn *ForeignDBInfoType
+	txErr := withForeignTx(ctx, false, func(tx *TxWrap) error {
+		rtn = &ForeignDBInfoType{
+			DBName:        foreignDB.DBName,
+			HasBlockstore: foreignDB.BlockstoreDB != nil,
+			NumSessions:   tx.GetInt(`SELECT count(*) FROM session`),
+			NumScreens:    tx.GetInt(`SELECT count(*) FROM screen`),
+		}
+		return nil
+	})
+	return rtn, txErr
+}
+
+func GetForeignSessions(ctx context.Context) ([]*ForeignSessionType, error) {
+	var rtn []*ForeignSessionType
+	txErr := withForeignTx(ctx, false, func(tx *TxWrap) error {
+		sessionMap := make(map[string]*ForeignSessionType)
+		for _, m := range tx.SelectMaps(`SELECT sessionid, name, archived FROM session ORDER BY archived, sessionidx`) {
+			session := &ForeignSessionType{}
+			dbutil.QuickSetStr(&session.SessionId, m, "sessionid")
+			dbutil.QuickSetStr(&session.Name, m, "name")
+			dbutil.QuickSetBool(&session.Archived, m, "archived")
+			sessionMap[session.SessionId] = session
+			rtn = append(rtn, session)
+		}
+		query := `SELECT s.sessionid, s.screenid, s.name, s.archived, (SELECT count(*) FROM line l WHERE l.screenid = s.screenid) AS numlines
+		          FROM screen s
+		          ORDER BY s.archived, s.screenidx`
+		for _, m := range tx.SelectMaps(query) {
+			var sessionId string
+			var numLines int64
+			screen := &ForeignScreenType{}
+			dbutil.QuickSetStr(&sessionId, m, "sessionid")
+			dbutil.QuickSetStr(&screen.ScreenId, m, "screenid")
+			dbutil.QuickSetStr(&screen.Name, m, "name")
+			dbutil.QuickSetBool(&screen.Archived, m, "archived")
+			dbutil.QuickSetInt64(&numLines, m, "numlines")
+			screen.NumLines = int(numLines)
+			if session := sessionMap[sessionId]; session != nil {
+				session.Screens = append(session.Screens, screen)
+			}
+		}
+		return nil
+	})
+	return rtn, txErr
+}
+
+// the lines of a foreign screen (with their cmds)
+func GetForeignScreenLines(ctx context.Context, screenId string) ([]*ForeignLineType, error) {
+	var rtn []*ForeignLineType
+	txErr := withForeignTx(ctx, false, func(tx *TxWrap) error {
+		if !tx.Exists(`SELECT screenid FROM screen WHERE screenid = ?`, screenId) {
+			return fmt.Errorf("screen %s not found in foreign db", screenId)
+		}
+		query := `SELECT l.lineid, l.linenum, l.ts, l.linetype, l.text,
+		                 COALESCE(c.cmdstr, '') AS cmdstr, COALESCE(c.remotename, '') AS remotename,
+		                 COALESCE(c.status, '') AS status, COALESCE(c.exitcode, 0) AS exitcode
+		          FROM line l LEFT JOIN cmd c ON c.screenid = l.screenid AND c.lineid = l.lineid
+		          WHERE l.screenid = ?
+		          ORDER BY l.linenum`
+		for _, m := range tx.SelectMaps(query, screenId) {
+			line := &ForeignLineType{}
+			dbutil.QuickSetStr(&line.LineId, m, "lineid")
+			dbutil.QuickSetInt64(&line.LineNum, m, "linenum")
+			dbutil.QuickSetInt64(&line.Ts, m, "ts")
+			dbutil.QuickSetStr(&line.LineType, m, "linetype")
+			dbutil.QuickSetStr(&line.Text, m, "text")
+			dbutil.QuickSetStr(&line.CmdStr, m, "cmdstr")
+			dbutil.QuickSetStr(&line.RemoteName, m, "remotename")
+			dbutil.QuickSetStr(&line.Status, m, "status")
+			dbutil.QuickSetInt64(&line.ExitCode, m, "exitcode")
+			rtn = append(rtn, line)
+		}
+		return nil
+	})
+	return rtn, txErr
+}
+
+// copies a foreign screen into sessionId (as a new, active screen).  the lines and cmds keep their ids,
+// cmds that were running are marked as hung up.  name overrides the screen's name if set.
+func ImportForeignScreen(ctx context.Context, foreignScreenId string, sessionId string, name string) (*scbus.ModelUpdatePacketType, error) {
+	var screenMap map[string]interface{}
+	var lineMaps, cmdMaps, lineTagMaps []map[string]interface{}
+	txErr := withForeignTx(ctx, false, func(tx *TxWrap) error {
+		screenMap = tx.GetMap(`SELECT * FROM screen WHERE screenid = ?`, foreignScreenId)
+		if screenMap == nil {
+			return fmt.Errorf("screen %s not found in foreign db", foreignScreenId)
+		}
+		lineMaps = tx.SelectMaps(`SELECT * FROM line WHERE screenid = ?`, foreignScreenId)
+		cmdMaps = tx.SelectMaps(`SELECT * FROM cmd WHERE screenid = ?`, foreignScreenId)
+		lineTagMaps = tx.SelectMaps(`SELECT * FROM line_tag WHERE screenid = ?`, foreignScreenId)
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	newScreenId := scbase.GenWaveUUID()
+	txErr = WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT sessionid FROM session WHERE sessionid = ? AND NOT archived`
+		if !tx.Exists(query, sessionId) {
+			return fmt.Errorf("cannot import screen, no session found (or session archived)")
+		}
+		localRemoteId := tx.GetString(`SELECT remoteid FROM remote WHERE remotealias = ?`, LocalRemoteAlias)
+		if localRemoteId == "" {
+			return fmt.Errorf("cannot import screen, no local remote found")
+		}
+		maxScreenIdx := tx.GetInt(`SELECT COALESCE(max(screenidx), 0) FROM screen WHERE sessionid = ? AND NOT archived`, sessionId)
+		screenMap["screenid"] = newScreenId
+		screenMap["sessionid"] = sessionId
+		screenMap["screenidx"] = maxScreenIdx + 1
+		if name != "" {
+			screenMap["name"] = name
+		}
+		screenMap["archived"] = false
+		screenMap["archivedts"] = 0
+		screenMap["sharemode"] = ShareModeLocal
+		screenMap["curremoteownerid"] = ""
+		screenMap["curremoteid"] = localRemoteId
+		screenMap["curremotename"] = ""
+		screenMap["focustype"] = ScreenFocusInput
+		dbutil.InsertMap(tx, "screen", screenMap)
+		for _, lineMap := range lineMaps {
+			lineMap["screenid"] = newScreenId
+			dbutil.InsertMap(tx, "line", lineMap)
+		}
+		for _, cmdMap := range cmdMaps {
+			cmdMap["screenid"] = newScreenId
+			if status, _ := cmdMap["status"].(string); status == CmdStatusRunning || status == CmdStatusDetached {
+				cmdMap["status"] = CmdStatusHangup
+			}
+			dbutil.InsertMap(tx, "cmd", cmdMap)
+		}
+		for _, lineTagMap := range lineTagMaps {
+			lineTagMap["screenid"] = newScreenId
+			dbutil.InsertMap(tx, "line_tag", lineTagMap)
+		}
+		tx.Exec(`UPDATE session SET activescreenid = ? WHERE sessionid = ?`, newScreenId, sessionId)
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	if foreignHasBlockstore() {
+		txErr = withForeignTx(ctx, true, func(bsTx *TxWrap) error {
+			_, err := blockstore.CopyBlockFrom(ctx, bsTx, foreignScreenId, newScreenId)
+			return err
+		})
+	}
+	if txErr != nil {
+		// the screen is kept (without the output that could not be copied)
+		log.Printf("[error] importing foreign screen %s, cannot copy blockstore files: %v\n", foreignScreenId, txErr)
+	}
+	newScreen, err := GetScreenById(ctx, newScreenId)
+	if err != nil {
+		return nil, err
+	}
+	bareSession, err := GetBareSessionById(ctx, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(*newScreen)
+	if bareSession != nil {
+		update.AddUpdate(*bareSession)
+	}
+	return update, nil
+}
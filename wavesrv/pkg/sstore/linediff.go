@@ -0,0 +1,206 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"strings"
+)
+
+// line diffs compare the (ansi stripped) output of two command lines, e.g. the same command run on two
+// hosts.  the diff is line based, the chunks are rendered by the frontend "diff" renderer.
+
+const (
+	DiffOp_Equal  = "equal"
+	DiffOp_Delete = "delete" // only in A
+	DiffOp_Insert = "insert" // only in B
+)
+
+// the output is trimmed to MaxDiffLines lines per side.  after removing the common prefix/suffix, a middle
+// section larger than MaxDiffCells (lines A * lines B) is shown as a single delete + insert
+const MaxDiffLines = 10000
+const MaxDiffCells = 4 * 1000 * 1000
+
+type LineDiffSideType struct {
+	ScreenId  string        `json:"screenid"`
+	LineId    string        `json:"lineid"`
+	LineNum   int64         `json:"linenum"`
+	Ts        int64         `json:"ts"`
+	CmdStr    string        `json:"cmdstr"`
+	Remote    RemotePtrType `json:"remote"`
+	Status    string        `json:"status"`
+	ExitCode  int           `json:"exitcode"`
+	NumLines  int           `json:"numlines"`
+	Truncated bool          `json:"truncated,omitempty"` // the start of the output was not available (or it had more than MaxDiffLines lines)
+}
+
+// StartA/StartB are 0-indexed line offsets into the A/B outputs
+type DiffChunkType struct {
+	Op     string   `json:"op"`
+	StartA int      `json:"starta"`
+	StartB int      `json:"startb"`
+	Lines  []string `json:"lines"`
+}
+
+type LineDiffType struct {
+	A           *LineDiffSideType `json:"a"`
+	B           *LineDiffSideType `json:"b"`
+	Chunks      []*DiffChunkType  `json:"chunks"`
+	NumDeleted  int               `json:"numdeleted"`
+	NumInserted int               `json:"numinserted"`
+	Identical   bool              `json:"identical,omitempty"`
+	Approx      bool              `json:"approx,omitempty"` // the output was too large for a minimal diff
+}
+
+// csi sequences, osc sequences (bel or st terminated), and other two-byte escapes
+var ansiRe = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// strips the ansi escapes and splits the output into lines.  a carriage return overwrites the line (progress
+// bars), only the text after the last \r is kept.
+func StripAnsiLines(data []byte) []string {
+	str := ansiRe.ReplaceAllString(string(data), "")
+	str = strings.ReplaceAll(str, "\r\n", "\n")
+	str = strings.TrimSuffix(str, "\n")
+	if str == "" {
+		return nil
+	}
+	lines := strings.Split(str, "\n")
+	for idx, line := range lines {
+		if crIdx := strings.LastIndex(line, "\r"); crIdx != -1 {
+			line = line[crIdx+1:]
+		}
+		lines[idx] = strings.TrimRight(line, " ")
+	}
+	return lines
+}
+
+func getLineDiffSide(ctx context.Context, screenId string, lineId string) (*LineDiffSideType, []string, error) {
+	line, cmd, err := GetLineCmdByLineId(ctx, screenId, lineId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if line == nil {
+		return nil, nil, fmt.Errorf("line not found")
+	}
+	if cmd == nil {
+		return nil, nil, fmt.Errorf("line %d is not a command", line.LineNum)
+	}
+	side := &LineDiffSideType{
+		ScreenId: screenId,
+		LineId:   lineId,
+		LineNum:  line.LineNum,
+		Ts:       line.Ts,
+		CmdStr:   cmd.CmdStr,
+		Remote:   cmd.Remote,
+		Status:   cmd.Status,
+		ExitCode: cmd.ExitCode,
+	}
+	realOffset, data, err := ReadFullPtyOutFile(ctx, screenId, lineId)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, fmt.Errorf("cannot read output of line %d: %w", line.LineNum, err)
+	}
+	data, _ = DecodeToUtf8(data, GetLineEncoding(line))
+	lines := StripAnsiLines(data)
+	side.Truncated = realOffset > 0
+	if len(lines) > MaxDiffLines {
+		lines = lines[len(lines)-MaxDiffLines:]
+		side.Truncated = true
+	}
+	side.NumLines = len(lines)
+	return side, lines, nil
+}
+
+func DiffLines(ctx context.Context, screenIdA string, lineIdA string, screenIdB string, lineIdB string) (*LineDiffType, error) {
+	sideA, linesA, err := getLineDiffSide(ctx, screenIdA, lineIdA)
+	if err != nil {
+		return nil, err
+	}
+	sideB, linesB, err := getLineDiffSide(ctx, screenIdB, lineIdB)
+	if err != nil {
+		return nil, err
+	}
+	rtn := &LineDiffType{A: sideA, B: sideB}
+	rtn.Chunks, rtn.Approx = DiffStrs(linesA, linesB)
+	for _, chunk := range rtn.Chunks {
+		switch chunk.Op {
+		case DiffOp_Delete:
+			rtn.NumDeleted += len(chunk.Lines)
+		case DiffOp_Insert:
+			rtn.NumInserted += len(chunk.Lines)
+		}
+	}
+	rtn.Identical = (rtn.NumDeleted == 0 && rtn.NumInserted == 0)
+	return rtn, nil
+}
+
+// returns the chunks (adjacent ops are merged) and true if the diff is not minimal (see MaxDiffCells)
+func DiffStrs(a []string, b []string) ([]*DiffChunkType, bool) {
+	var chunks []*DiffChunkType
+	addLine := func(op string, aIdx int, bIdx int, line string) {
+		if len(chunks) > 0 && chunks[len(chunks)-1].Op == op {
+			lastChunk := chunks[len(chunks)-1]
+			lastChunk.Lines = append(lastChunk.Lines, line)
+			return
+		}
+		chunks = append(chunks, &DiffChunkType{Op: op, StartA: aIdx, StartB: bIdx, Lines: []string{line}})
+	}
+	prefixLen := 0
+	for prefixLen < len(a) && prefixLen < len(b) && a[prefixLen] == b[prefixLen] {
+		addLine(DiffOp_Equal, prefixLen, prefixLen, a[prefixLen])
+		prefixLen++
+	}
+	suffixLen := 0
+	for suffixLen < len(a)-prefixLen && suffixLen < len(b)-prefixLen && a[len(a)-1-suffixLen] == b[len(b)-1-suffixLen] {
+		suffixLen++
+	}
+	midA := a[prefixLen : len(a)-suffixLen]
+	midB := b[prefixLen : len(b)-suffixLen]
+	approx := len(midA)*len(midB) > MaxDiffCells
+	if approx {
+		for idx, line := range midA {
+			addLine(DiffOp_Delete, prefixLen+idx, prefixLen, line)
+		}
+		for idx, line := range midB {
+			addLine(DiffOp_Insert, prefixLen+len(midA), prefixLen+idx, line)
+		}
+	} else {
+		// lcs[i][j] is the lcs length of midA[i:] and midB[j:]
+		lcs := make([][]int32, len(midA)+1)
+		for i := range lcs {
+			lcs[i] = make([]int32, len(midB)+1)
+		}
+		for i := len(midA) - 1; i >= 0; i-- {
+			for j := len(midB) - 1; j >= 0; j-- {
+				if midA[i] == midB[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(midA) || j < len(midB) {
+			switch {
+			case i < len(midA) && j < len(midB) && midA[i] == midB[j]:
+				addLine(DiffOp_Equal, prefixLen+i, prefixLen+j, midA[i])
+				i++
+				j++
+			case j == len(midB) || (i < len(midA) && lcs[i+1][j] >= lcs[i][j+1]):
+				addLine(DiffOp_Delete, prefixLen+i, prefixLen+j, midA[i])
+				i++
+			default:
+				addLine(DiffOp_Insert, prefixLen+i, prefixLen+j, midB[j])
+				j++
+			}
+		}
+	}
+	for idx := suffixLen; idx > 0; idx-- {
+		addLine(DiffOp_Equal, len(a)-idx, len(b)-idx, a[len(a)-idx])
+	}
+	return chunks, approx
+}
@@ -0,0 +1,166 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/blockstore"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+)
+
+// line state larger than MaxLineStateSize is stored in the blockstore (blockid=screenid,
+// name=linestate:[lineid].[uuid], older overflows are linestate:[lineid]).  the line row keeps a reference
+// (LineState_OverflowRef) plus any "wave:" keys, which are small and are queried and updated directly by the db
+// (e.g. ephemeral expiration, chain membership).  the row is the only copy of the "wave:" keys, they are not
+// written to the overflow.  each write goes to a new file, the file the row referenced before is deleted once
+// the row update is committed, so the committed row always references a complete overflow.
+
+const DefaultMaxLineStateOverflowSize = 1024 * 1024 // 1m
+const MaxLineStateOverflowSizeLimit = 16 * 1024 * 1024
+const LineStateOverflowPrefix = "linestate:"
+
+func lineStateOverflowName(lineId string) string {
+	return LineStateOverflowPrefix + lineId + "." + uuid.New().String()
+}
+
+// returns the configured max line state size (including overflow)
+func GetMaxLineStateSize(ctx context.Context) int {
+	clientData, err := EnsureClientData(ctx)
+	if err != nil || clientData.ClientOpts.MaxLineStateSize <= 0 {
+		return DefaultMaxLineStateOverflowSize
+	}
+	if clientData.ClientOpts.MaxLineStateSize > MaxLineStateOverflowSizeLimit {
+		return MaxLineStateOverflowSizeLimit
+	}
+	return clientData.ClientOpts.MaxLineStateSize
+}
+
+// returns (json to store in the line row, overflow name, error).  large line states are written to a new
+// overflow file (name is "" if the state fits in the row), the caller deletes it if the line row is not written.
+func makeDBLineState(ctx context.Context, screenId string, lineId string, lineState map[string]any) (string, string, error) {
+	qjs := dbutil.QuickJson(lineState)
+	if len(qjs) <= MaxLineStateSize {
+		return qjs, "", nil
+	}
+	maxSize := GetMaxLineStateSize(ctx)
+	if len(qjs) > maxSize {
+		return "", "", fmt.Errorf("linestate for line[%s:%s] exceeds maxsize, size[%d] max[%d]", screenId, lineId, len(qjs), maxSize)
+	}
+	name := lineStateOverflowName(lineId)
+	refState := map[string]any{LineState_OverflowRef: name}
+	overflowState := make(map[string]any)
+	for key, val := range lineState {
+		if strings.HasPrefix(key, "wave:") {
+			refState[key] = val
+		} else {
+			overflowState[key] = val
+		}
+	}
+	refJs := dbutil.QuickJson(refState)
+	if len(refJs) > MaxLineStateSize {
+		return "", "", fmt.Errorf("linestate for line[%s:%s] has too many wave: keys", screenId, lineId)
+	}
+	_, err := blockstore.WriteFile(ctx, screenId, name, nil, blockstore.FileOptsType{MaxSize: int64(maxSize), Compress: true}, []byte(dbutil.QuickJson(overflowState)))
+	if err != nil {
+		blockstore.DeleteFile(ctx, screenId, name) // ignore error
+		return "", "", fmt.Errorf("error writing linestate overflow: %w", err)
+	}
+	err = blockstore.FlushFile(ctx, screenId, name)
+	if err != nil {
+		blockstore.DeleteFile(ctx, screenId, name) // ignore error
+		return "", "", fmt.Errorf("error flushing linestate overflow: %w", err)
+	}
+	return refJs, name, nil
+}
+
+// returns the overflow the line row references ("" if none), must be called in the tx that updates the row
+func getLineStateOverflowRef(tx *TxWrap, screenId string, lineId string) string {
+	query := `SELECT COALESCE(json_extract(linestate, '$."` + LineState_OverflowRef + `"'), '') FROM line WHERE screenid = ? AND lineid = ?`
+	return tx.GetString(query, screenId, lineId)
+}
+
+// replaces an overflow reference with the full line state from the blockstore.  if the overflow cannot be read
+// the error is logged and the line keeps its inline state (the reference and the "wave:" keys).
+func hydrateLineState(ctx context.Context, line *LineType) {
+	if line == nil {
+		return
+	}
+	name, ok := line.LineState[LineState_OverflowRef].(string)
+	if !ok || name == "" {
+		return
+	}
+	lineState, err := readLineStateOverflow(ctx, line.ScreenId, name)
+	if err != nil {
+		log.Printf("error reading linestate overflow for line[%s:%s]: %v\n", line.ScreenId, line.LineId, err)
+		return
+	}
+	// the "wave:" keys come from the line row, they may have been updated (or removed) directly in the db.
+	// overflows written before the keys were split out of the overflow still have stale copies.
+	for key := range lineState {
+		if strings.HasPrefix(key, "wave:") {
+			delete(lineState, key)
+		}
+	}
+	for key, val := range line.LineState {
+		if key != LineState_OverflowRef && strings.HasPrefix(key, "wave:") {
+			lineState[key] = val
+		}
+	}
+	line.LineState = lineState
+}
+
+func readLineStateOverflow(ctx context.Context, screenId string, name string) (map[string]any, error) {
+	finfo, err := blockstore.Stat(ctx, screenId, name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot stat: %w", err)
+	}
+	buf := make([]byte, finfo.Size)
+	_, err = blockstore.ReadAt(ctx, screenId, name, &buf, 0)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read: %w", err)
+	}
+	var lineState map[string]any
+	err = json.Unmarshal(buf, &lineState)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse: %w", err)
+	}
+	return lineState, nil
+}
+
+// hydrates the line states of lines read from the db (see hydrateLineState)
+func HydrateLineStates(ctx context.Context, lines []*LineType) {
+	for _, line := range lines {
+		hydrateLineState(ctx, line)
+	}
+}
+
+// deletes all the overflows of the line (for deleted lines)
+func deleteLineStateOverflow(ctx context.Context, screenId string, lineId string) error {
+	prefix := LineStateOverflowPrefix + lineId
+	files, _, err := blockstore.ListFilesFiltered(ctx, screenId, blockstore.ListFilesOpts{NameGlob: prefix + "*"})
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.Name != prefix && !strings.HasPrefix(file.Name, prefix+".") {
+			continue
+		}
+		err = blockstore.DeleteFile(ctx, screenId, file.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteLineStateOverflowFile(ctx context.Context, screenId string, name string) error {
+	return blockstore.DeleteFile(ctx, screenId, name)
+}
@@ -0,0 +1,132 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+)
+
+// line tags (e.g. "deploy", "incident", "todo") are stored in the line_tag table and
+// set on LineType.Tags when lines are loaded.  screens can filter their lines by tag (see ScreenFilterType).
+
+const MaxLineTagLen = 50
+const MaxLineTagsPerLine = 20
+
+var lineTagRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.:-]*$`)
+
+// tags are case insensitive (stored lowercase)
+func NormalizeLineTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" {
+		return "", fmt.Errorf("tag cannot be empty")
+	}
+	if len(tag) > MaxLineTagLen {
+		return "", fmt.Errorf("tag %q too long (max %d characters)", tag, MaxLineTagLen)
+	}
+	if !lineTagRe.MatchString(tag) {
+		return "", fmt.Errorf("invalid tag %q, must start with a letter or number and contain only letters, numbers, '_', '.', ':', and '-'", tag)
+	}
+	return tag, nil
+}
+
+type lineTagRow struct {
+	LineId string
+	Tag    string
+}
+
+func getLineTagsMap(tx *TxWrap, screenId string) map[string][]string {
+	var rows []lineTagRow
+	query := `SELECT lineid, tag FROM line_tag WHERE screenid = ? ORDER BY createdts, tag`
+	tx.Select(&rows, query, screenId)
+	rtn := make(map[string][]string)
+	for _, row := range rows {
+		rtn[row.LineId] = append(rtn[row.LineId], row.Tag)
+	}
+	return rtn
+}
+
+// sets line.Tags for lines (all lines must be on screenId)
+func setLineTags(ctx context.Context, screenId string, lines ...*LineType) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		tagsMap := getLineTagsMap(tx, screenId)
+		for _, line := range lines {
+			if line == nil {
+				continue
+			}
+			line.Tags = tagsMap[line.LineId]
+		}
+		return nil
+	})
+}
+
+func GetLineTags(ctx context.Context, screenId string, lineId string) ([]string, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]string, error) {
+		query := `SELECT tag FROM line_tag WHERE screenid = ? AND lineid = ? ORDER BY createdts, tag`
+		return tx.SelectStrings(query, screenId, lineId), nil
+	})
+}
+
+// tag must already be normalized.  tagging a line twice with the same tag is a no-op.
+func TagLine(ctx context.Context, screenId string, lineId string, tag string) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT lineid FROM line WHERE screenid = ? AND lineid = ?`
+		if !tx.Exists(query, screenId, lineId) {
+			return fmt.Errorf("line not found")
+		}
+		query = `SELECT tag FROM line_tag WHERE screenid = ? AND lineid = ? AND tag = ?`
+		if tx.Exists(query, screenId, lineId, tag) {
+			return nil
+		}
+		query = `SELECT count(*) FROM line_tag WHERE screenid = ? AND lineid = ?`
+		if tx.GetInt(query, screenId, lineId) >= MaxLineTagsPerLine {
+			return fmt.Errorf("line already has the maximum number of tags (%d)", MaxLineTagsPerLine)
+		}
+		query = `INSERT INTO line_tag (screenid, lineid, tag, createdts) VALUES (?, ?, ?, ?)`
+		tx.Exec(query, screenId, lineId, tag, time.Now().UnixMilli())
+		return nil
+	})
+}
+
+func UntagLine(ctx context.Context, screenId string, lineId string, tag string) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `DELETE FROM line_tag WHERE screenid = ? AND lineid = ? AND tag = ?`
+		tx.Exec(query, screenId, lineId, tag)
+		return nil
+	})
+}
+
+// returns the lines on screenId with the given tag (ordered by linenum)
+func GetLinesByTag(ctx context.Context, screenId string, tag string) ([]*LineType, error) {
+	lines, err := WithTxRtn(ctx, func(tx *TxWrap) ([]*LineType, error) {
+		query := `SELECT * FROM line l
+		          WHERE l.screenid = ?
+		            AND EXISTS (SELECT 1 FROM line_tag t WHERE t.screenid = l.screenid AND t.lineid = l.lineid AND t.tag = ?)
+		          ORDER BY l.linenum`
+		return dbutil.SelectMappable[*LineType](tx, query, screenId, tag), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	HydrateLineStates(ctx, lines)
+	err = setLineTags(ctx, screenId, lines...)
+	if err != nil {
+		return nil, err
+	}
+	setLineDayStrs(GetClientLocation(ctx), lines...)
+	return lines, nil
+}
+
+// returns all tags used on the screen (sorted)
+func GetScreenLineTags(ctx context.Context, screenId string) ([]string, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]string, error) {
+		query := `SELECT DISTINCT tag FROM line_tag WHERE screenid = ? ORDER BY tag`
+		return tx.SelectStrings(query, screenId), nil
+	})
+}
@@ -7,6 +7,7 @@ package sstore
 import (
 	"fmt"
 	"log"
+	"strings"
 	"sync"
 
 	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
@@ -38,6 +39,18 @@ func dumpScreenMemStore() {
 type OpenAICmdInfoChatStore struct {
 	MessageCount int                                `json:"messagecount"`
 	Messages     []*packet.OpenAICmdInfoChatMessage `json:"messages"`
+	Attachments  []*OpenAICmdInfoAttachmentType     `json:"attachments,omitempty"`
+}
+
+// line output attached to the cmdinfo chat context (see cmdrunner/aicontext.go)
+type OpenAICmdInfoAttachmentType struct {
+	LineId    string `json:"lineid"`
+	LineNum   int64  `json:"linenum"`
+	CmdStr    string `json:"cmdstr"`
+	ExitCode  int    `json:"exitcode"`
+	Output    string `json:"output"`
+	Truncated bool   `json:"truncated,omitempty"` // only the end of the output was attached
+	Ts        int64  `json:"ts"`
 }
 
 type ScreenMemState struct {
@@ -45,6 +58,7 @@ type ScreenMemState struct {
 	StatusIndicator    StatusIndicatorLevel    `json:"statusindicator,omitempty"`
 	CmdInputText       utilfn.StrWithPos       `json:"cmdinputtext,omitempty"`
 	CmdInputSeqNum     int                     `json:"cmdinputseqnum,omitempty"`
+	InputHistory       []string                `json:"inputhistory,omitempty"` // oldest first, see screeninput.go
 	AICmdInfoChat      *OpenAICmdInfoChatStore `json:"aicmdinfochat,omitempty"`
 }
 
@@ -58,7 +72,12 @@ func ScreenMemDeepCopyCmdInfoChatStore(store *OpenAICmdInfoChatStore) *OpenAICmd
 		}
 		rtnMessages = append(rtnMessages, &messageToCopy)
 	}
-	rtn := &OpenAICmdInfoChatStore{MessageCount: store.MessageCount, Messages: rtnMessages}
+	rtnAttachments := []*OpenAICmdInfoAttachmentType{}
+	for _, attachment := range store.Attachments {
+		attachmentCopy := *attachment
+		rtnAttachments = append(rtnAttachments, &attachmentCopy)
+	}
+	rtn := &OpenAICmdInfoChatStore{MessageCount: store.MessageCount, Messages: rtnMessages, Attachments: rtnAttachments}
 	return rtn
 }
 
@@ -98,6 +117,52 @@ func ScreenMemAddCmdInfoChatMessage(screenId string, msg *packet.OpenAICmdInfoCh
 	CmdInfoChat.MessageCount++
 }
 
+// an attachment of the same line replaces the old one (moves it to the end)
+func ScreenMemAddCmdInfoChatAttachment(screenId string, attachment *OpenAICmdInfoAttachmentType) {
+	MemLock.Lock()
+	defer MemLock.Unlock()
+	if ScreenMemStore[screenId] == nil {
+		ScreenMemStore[screenId] = &ScreenMemState{}
+	}
+	if ScreenMemStore[screenId].AICmdInfoChat == nil {
+		ScreenMemInitCmdInfoChat(screenId)
+	}
+	CmdInfoChat := ScreenMemStore[screenId].AICmdInfoChat
+	attachments := make([]*OpenAICmdInfoAttachmentType, 0, len(CmdInfoChat.Attachments)+1)
+	for _, curAttachment := range CmdInfoChat.Attachments {
+		if curAttachment.LineId != attachment.LineId {
+			attachments = append(attachments, curAttachment)
+		}
+	}
+	CmdInfoChat.Attachments = append(attachments, attachment)
+}
+
+// returns false if the line was not attached
+func ScreenMemRemoveCmdInfoChatAttachment(screenId string, lineId string) bool {
+	MemLock.Lock()
+	defer MemLock.Unlock()
+	if ScreenMemStore[screenId] == nil || ScreenMemStore[screenId].AICmdInfoChat == nil {
+		return false
+	}
+	CmdInfoChat := ScreenMemStore[screenId].AICmdInfoChat
+	for idx, attachment := range CmdInfoChat.Attachments {
+		if attachment.LineId == lineId {
+			CmdInfoChat.Attachments = append(CmdInfoChat.Attachments[:idx:idx], CmdInfoChat.Attachments[idx+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func ScreenMemClearCmdInfoChatAttachments(screenId string) {
+	MemLock.Lock()
+	defer MemLock.Unlock()
+	if ScreenMemStore[screenId] == nil || ScreenMemStore[screenId].AICmdInfoChat == nil {
+		return
+	}
+	ScreenMemStore[screenId].AICmdInfoChat.Attachments = nil
+}
+
 func ScreenMemGetCmdInfoMessageCount(screenId string) int {
 	MemLock.Lock()
 	defer MemLock.Unlock()
@@ -141,16 +206,56 @@ func ScreenMemUpdateCmdInfoChatMessage(screenId string, messageID int, msg *pack
 }
 
 func ScreenMemSetCmdInputText(screenId string, sp utilfn.StrWithPos, seqNum int) {
+	if !screenMemSetCmdInputText(screenId, sp, seqNum) {
+		return
+	}
+	queueScreenInputSave(screenId)
+}
+
+func screenMemSetCmdInputText(screenId string, sp utilfn.StrWithPos, seqNum int) bool {
 	MemLock.Lock()
 	defer MemLock.Unlock()
 	if ScreenMemStore[screenId] == nil {
 		ScreenMemStore[screenId] = &ScreenMemState{}
 	}
 	if seqNum <= ScreenMemStore[screenId].CmdInputSeqNum {
-		return
+		return false
 	}
 	ScreenMemStore[screenId].CmdInputText = sp
 	ScreenMemStore[screenId].CmdInputSeqNum = seqNum
+	return true
+}
+
+// adds a submitted command to the screen's input history ring (a repeat of the last entry is skipped)
+func ScreenMemPushInputHistory(screenId string, cmdStr string) {
+	if strings.TrimSpace(cmdStr) == "" {
+		return
+	}
+	if !screenMemPushInputHistory(screenId, cmdStr) {
+		return
+	}
+	queueScreenInputSave(screenId)
+}
+
+func screenMemPushInputHistory(screenId string, cmdStr string) bool {
+	MemLock.Lock()
+	defer MemLock.Unlock()
+	if ScreenMemStore[screenId] == nil {
+		ScreenMemStore[screenId] = &ScreenMemState{}
+	}
+	memState := ScreenMemStore[screenId]
+	if len(memState.InputHistory) > 0 && memState.InputHistory[len(memState.InputHistory)-1] == cmdStr {
+		return false
+	}
+	// new slice, copies returned by GetScreenMemState share the old one
+	startIdx := 0
+	if len(memState.InputHistory) >= MaxScreenInputHistory {
+		startIdx = len(memState.InputHistory) - MaxScreenInputHistory + 1
+	}
+	newHistory := make([]string, 0, len(memState.InputHistory)-startIdx+1)
+	newHistory = append(newHistory, memState.InputHistory[startIdx:]...)
+	memState.InputHistory = append(newHistory, cmdStr)
+	return true
 }
 
 func ScreenMemIncrementNumRunningCommands(screenId string, delta int) int {
@@ -22,7 +22,7 @@ import (
 	"github.com/golang-migrate/migrate/v4"
 )
 
-const MaxMigration = 31
+const MaxMigration = 52
 const MigratePrimaryScreenVersion = 9
 const CmdScreenSpecialMigration = 13
 const CmdLineSpecialMigration = 20
@@ -192,6 +192,21 @@ func TryMigrateUp() error {
 	return MigratePrintVersion()
 }
 
+// used in read-only mode (where the db cannot be migrated), returns an error if the db is not at MaxMigration
+func CheckMigrationVersion() error {
+	curVersion, dirty, err := MigrateVersion(nil)
+	if err != nil {
+		return fmt.Errorf("error getting db version: %v", err)
+	}
+	if dirty {
+		return fmt.Errorf("db is dirty (version %d)", curVersion)
+	}
+	if curVersion != MaxMigration {
+		return fmt.Errorf("db is at version %d, expected version %d (it must be migrated first)", curVersion, MaxMigration)
+	}
+	return nil
+}
+
 func MigratePrintVersion() error {
 	version, dirty, err := MigrateVersion(nil)
 	if err != nil {
@@ -0,0 +1,74 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// playback replays the output of a line (or all the cmd lines of a screen) with the recorded pty timing (see
+// ptytiming.go).  the output is split into events, the playback streams them as "playback" updates to the
+// frontend, which writes them to the line's terminal (see cmdrunner/playback.go).  the asciinema export
+// uses the same events.
+
+type PtyEventType struct {
+	Ts   int64 // unix millis the data was written
+	Data []byte
+}
+
+type PlaybackUpdateType struct {
+	PlaybackId string `json:"playbackid"`
+	ScreenId   string `json:"screenid"`
+	LineId     string `json:"lineid"`
+	Reset      bool   `json:"reset,omitempty"` // start of the line's playback (the terminal is cleared)
+	Data64     string `json:"data64,omitempty"`
+	Done       bool   `json:"done,omitempty"` // end of the line's playback (the terminal shows the full output again)
+}
+
+func (PlaybackUpdateType) GetType() string {
+	return "playback"
+}
+
+// splits the output of a cmd into events.  startTs is used for output without timing (cmds run before timing
+// was recorded, or output older than the timing ring), event times never go backwards.
+func GetCmdPtyEvents(ctx context.Context, cmd *CmdType, startTs int64) ([]PtyEventType, error) {
+	realOffset, data, timing, err := ReadFullPtyOutFileWithTiming(ctx, cmd.ScreenId, cmd.LineId)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read output of command: %w", err)
+	}
+	var rtn []PtyEventType
+	lastTs := startTs
+	addEvent := func(ts int64, start int64, end int64) {
+		lastTs = max(lastTs, ts)
+		rtn = append(rtn, PtyEventType{Ts: lastTs, Data: data[start-realOffset : end-realOffset]})
+	}
+	endPos := realOffset + int64(len(data))
+	curPos := realOffset
+	for idx, entry := range timing {
+		segEnd := endPos
+		if idx+1 < len(timing) && timing[idx+1].Pos < segEnd {
+			segEnd = timing[idx+1].Pos
+		}
+		segStart := max(entry.Pos, curPos)
+		if segStart >= segEnd {
+			continue
+		}
+		if curPos < segStart {
+			// output before the first readable timing entry (the timing ring wrapped)
+			addEvent(startTs, curPos, segStart)
+		}
+		addEvent(entry.Ts, segStart, segEnd)
+		curPos = segEnd
+	}
+	if curPos < endPos {
+		addEvent(lastTs, curPos, endPos)
+	}
+	return rtn, nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+)
+
+// pty flow tracking.  AppendToCmdPtyBlob measures the output throughput of each running command.  when a command
+// is in an output burst (a lot of output arriving with no pauses) its pty updates carry a renderhint, so the
+// frontend can batch its rendering work (layout, resizing) into fewer frames instead of redoing it for every
+// chunk.  the hint estimates how much more output to expect, based on the current rate.
+
+const PtyBurstIdleGap = 100 * time.Millisecond // a pause this long ends a burst
+const PtyBurstMinBytes = 16 * 1024             // output needed (within one burst) before sending hints
+const PtyBurstLookahead = 250 * time.Millisecond
+const PtyBurstMinElapsed = 10 * time.Millisecond
+const PtyFlowStaleTime = 5 * time.Minute
+
+type ptyFlowType struct {
+	BurstStartTs time.Time
+	BurstBytes   int64
+	LastTs       time.Time
+}
+
+var ptyFlowLock = &sync.Mutex{}
+var ptyFlowMap = make(map[string]*ptyFlowType) // screenid/lineid -> flow
+var ptyFlowLastSweep time.Time
+
+func ptyFlowKey(screenId string, lineId string) string {
+	return screenId + "/" + lineId
+}
+
+// must hold ptyFlowLock, removes flows for commands that have not sent output in a while (commands that
+// finished are normally removed by clearPtyFlow)
+func sweepPtyFlows_nolock(now time.Time) {
+	if now.Sub(ptyFlowLastSweep) < PtyFlowStaleTime {
+		return
+	}
+	ptyFlowLastSweep = now
+	for key, flow := range ptyFlowMap {
+		if now.Sub(flow.LastTs) > PtyFlowStaleTime {
+			delete(ptyFlowMap, key)
+		}
+	}
+}
+
+// records numBytes of output for the command, returns a render hint if the command is in an output burst
+func trackPtyFlow(screenId string, lineId string, numBytes int64, now time.Time) *scbus.PtyRenderHint {
+	ptyFlowLock.Lock()
+	defer ptyFlowLock.Unlock()
+	sweepPtyFlows_nolock(now)
+	key := ptyFlowKey(screenId, lineId)
+	flow := ptyFlowMap[key]
+	if flow == nil {
+		flow = &ptyFlowType{BurstStartTs: now}
+		ptyFlowMap[key] = flow
+	} else if now.Sub(flow.LastTs) >= PtyBurstIdleGap {
+		flow.BurstStartTs = now
+		flow.BurstBytes = 0
+	}
+	flow.BurstBytes += numBytes
+	flow.LastTs = now
+	if flow.BurstBytes < PtyBurstMinBytes {
+		return nil
+	}
+	elapsed := max(now.Sub(flow.BurstStartTs), PtyBurstMinElapsed)
+	rateBps := int64(float64(flow.BurstBytes) / elapsed.Seconds())
+	return &scbus.PtyRenderHint{
+		Burst:       true,
+		BurstBytes:  flow.BurstBytes,
+		RateBps:     rateBps,
+		ExpectBytes: int64(float64(rateBps) * PtyBurstLookahead.Seconds()),
+		HoldMs:      PtyBurstIdleGap.Milliseconds(),
+	}
+}
+
+func clearPtyFlow(screenId string, lineId string) {
+	ptyFlowLock.Lock()
+	defer ptyFlowLock.Unlock()
+	delete(ptyFlowMap, ptyFlowKey(screenId, lineId))
+}
@@ -0,0 +1,136 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"log"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/blockstore"
+)
+
+// pty timing is stored next to the pty output (blockid=screenid, name=ptytiming:[lineid]).  every append to
+// the ptyout file records an entry of the (logical) output position and the time it was written.  like the
+// output, the entries are a ring buffer, only the last MaxPtyTimingEntries entries are kept (the meta
+// numentries is the total number of entries written).  commands run before timing was recorded have no file.
+// timing files are pruned after PtyTimingRetention (see blockstore.RegisterRetentionRule), older commands
+// play back without timing.
+
+const PtyTimingFilePrefix = "ptytiming:"
+const PtyTimingMeta_NumEntries = "numentries"
+const PtyTimingEntrySize = 16
+const MaxPtyTimingEntries = 64 * 1024
+const PtyTimingRetention = 30 * 24 * time.Hour
+
+func init() {
+	err := blockstore.RegisterRetentionRule("ptytiming", blockstore.RetentionRule{NameGlob: PtyTimingFilePrefix + "*", MaxAge: PtyTimingRetention, Locker: ptyOutFilesLock})
+	if err != nil {
+		log.Printf("[error] registering ptytiming retention: %v\n", err)
+	}
+}
+
+type PtyTimingEntry struct {
+	Pos int64 // logical ptyout position of the first byte of the write
+	Ts  int64 // unix millis
+}
+
+func ptyTimingFileName(lineId string) string {
+	return PtyTimingFilePrefix + lineId
+}
+
+func getPtyTimingNumEntries(fInfo *blockstore.FileInfo) int64 {
+	switch numEntries := fInfo.Meta[PtyTimingMeta_NumEntries].(type) {
+	case int64:
+		return numEntries
+	case float64:
+		// meta read from the db
+		return int64(numEntries)
+	}
+	return fInfo.Size / PtyTimingEntrySize
+}
+
+// must hold the line's ptyout lock
+func deletePtyTimingFile(ctx context.Context, screenId string, lineId string) {
+	blockstore.DeleteFile(ctx, screenId, ptyTimingFileName(lineId)) // ignore error, may not exist
+}
+
+// must hold the line's ptyout lock.  the file is created by the first entry.
+func writePtyTimingEntry(ctx context.Context, screenId string, lineId string, entry PtyTimingEntry) error {
+	name := ptyTimingFileName(lineId)
+	fInfo, err := blockstore.Stat(ctx, screenId, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		meta := blockstore.FileMeta{PtyTimingMeta_NumEntries: int64(0)}
+		err = blockstore.MakeFile(ctx, screenId, name, meta, blockstore.FileOptsType{MaxSize: MaxPtyTimingEntries * PtyTimingEntrySize})
+		if err == nil {
+			fInfo, err = blockstore.Stat(ctx, screenId, name)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	numEntries := getPtyTimingNumEntries(fInfo)
+	buf := make([]byte, PtyTimingEntrySize)
+	binary.LittleEndian.PutUint64(buf[0:8], uint64(entry.Pos))
+	binary.LittleEndian.PutUint64(buf[8:16], uint64(entry.Ts))
+	_, err = blockstore.WriteAt(ctx, screenId, name, buf, (numEntries%MaxPtyTimingEntries)*PtyTimingEntrySize)
+	if err != nil {
+		return err
+	}
+	fInfo.Meta[PtyTimingMeta_NumEntries] = numEntries + 1
+	return blockstore.WriteMeta(ctx, screenId, name, fInfo.Meta)
+}
+
+// must hold the line's ptyout lock.  returns the entries in write order (nil if the command has no timing)
+func readPtyTimingEntries(ctx context.Context, screenId string, lineId string) ([]PtyTimingEntry, error) {
+	name := ptyTimingFileName(lineId)
+	fInfo, err := blockstore.Stat(ctx, screenId, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	numEntries := getPtyTimingNumEntries(fInfo)
+	numStored := min(numEntries, MaxPtyTimingEntries, fInfo.Size/PtyTimingEntrySize)
+	if numStored == 0 {
+		return nil, nil
+	}
+	buf := make([]byte, numStored*PtyTimingEntrySize)
+	_, err = blockstore.ReadAt(ctx, screenId, name, &buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	// the oldest entry is at numentries % max once the ring has wrapped
+	startIdx := int64(0)
+	if numEntries > numStored {
+		startIdx = numEntries % numStored
+	}
+	rtn := make([]PtyTimingEntry, 0, numStored)
+	for i := int64(0); i < numStored; i++ {
+		off := ((startIdx + i) % numStored) * PtyTimingEntrySize
+		rtn = append(rtn, PtyTimingEntry{
+			Pos: int64(binary.LittleEndian.Uint64(buf[off : off+8])),
+			Ts:  int64(binary.LittleEndian.Uint64(buf[off+8 : off+16])),
+		})
+	}
+	return rtn, nil
+}
+
+// returns (real-offset, data, timing, err), see ReadFullPtyOutFile
+func ReadFullPtyOutFileWithTiming(ctx context.Context, screenId string, lineId string) (int64, []byte, []PtyTimingEntry, error) {
+	defer lockPtyOutLine(screenId, lineId)()
+	realOffset, data, err := readPtyOutData(ctx, screenId, lineId, 0, -1)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	timing, err := readPtyTimingEntries(ctx, screenId, lineId)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return realOffset, data, timing, nil
+}
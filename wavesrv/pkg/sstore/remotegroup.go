@@ -0,0 +1,181 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+)
+
+// remote groups (folders) organize the connections view.  a remote is in at most one group (remote.groupid,
+// "" for ungrouped remotes).  groups are ordered by groupidx, the remotes within a group keep using remoteidx
+// (reordering a remote within its group only swaps the remoteidx values of that group's remotes).
+// archiving a remote removes it from its group, deleting a group ungroups its remotes.
+
+const MaxRemoteGroups = 50
+
+type RemoteGroupType struct {
+	GroupId  string `json:"groupid"`
+	Name     string `json:"name"`
+	GroupIdx int64  `json:"groupidx"`
+
+	// only for updates
+	Remove bool `json:"remove,omitempty" dbmap:"-"`
+}
+
+func (RemoteGroupType) UseDBMap() {}
+
+func (RemoteGroupType) GetType() string {
+	return "remotegroup"
+}
+
+func GetAllRemoteGroups(ctx context.Context) ([]*RemoteGroupType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]*RemoteGroupType, error) {
+		query := `SELECT * FROM remote_group ORDER BY groupidx`
+		return dbutil.SelectMappable[*RemoteGroupType](tx, query), nil
+	})
+}
+
+// groupArg is a group name or groupid, returns nil if not found
+func ResolveRemoteGroup(ctx context.Context, groupArg string) (*RemoteGroupType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*RemoteGroupType, error) {
+		query := `SELECT * FROM remote_group WHERE groupid = ? OR name = ?`
+		return dbutil.GetMappable[*RemoteGroupType](tx, query, groupArg, groupArg), nil
+	})
+}
+
+func CreateRemoteGroup(ctx context.Context, name string) (*RemoteGroupType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*RemoteGroupType, error) {
+		query := `SELECT count(*) FROM remote_group`
+		if tx.GetInt(query) >= MaxRemoteGroups {
+			return nil, fmt.Errorf("cannot create more than %d remote groups", MaxRemoteGroups)
+		}
+		query = `SELECT groupid FROM remote_group WHERE name = ?`
+		if tx.Exists(query, name) {
+			return nil, fmt.Errorf("remote group %q already exists", name)
+		}
+		query = `SELECT COALESCE(max(groupidx), 0) FROM remote_group`
+		group := &RemoteGroupType{
+			GroupId:  scbase.GenWaveUUID(),
+			Name:     name,
+			GroupIdx: int64(tx.GetInt(query) + 1),
+		}
+		query = `INSERT INTO remote_group ( groupid, name, groupidx)
+		                           VALUES (:groupid,:name,:groupidx)`
+		tx.NamedExec(query, dbutil.ToDBMap(group, false))
+		return group, nil
+	})
+}
+
+func RenameRemoteGroup(ctx context.Context, groupId string, name string) (*RemoteGroupType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*RemoteGroupType, error) {
+		query := `SELECT groupid FROM remote_group WHERE name = ? AND groupid <> ?`
+		if tx.Exists(query, name, groupId) {
+			return nil, fmt.Errorf("remote group %q already exists", name)
+		}
+		query = `UPDATE remote_group SET name = ? WHERE groupid = ?`
+		tx.Exec(query, name, groupId)
+		query = `SELECT * FROM remote_group WHERE groupid = ?`
+		group := dbutil.GetMappable[*RemoteGroupType](tx, query, groupId)
+		if group == nil {
+			return nil, fmt.Errorf("remote group not found")
+		}
+		return group, nil
+	})
+}
+
+// deletes the group and ungroups its remotes, returns the remoteids that were in the group
+func DeleteRemoteGroup(ctx context.Context, groupId string) ([]string, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]string, error) {
+		query := `SELECT groupid FROM remote_group WHERE groupid = ?`
+		if !tx.Exists(query, groupId) {
+			return nil, fmt.Errorf("remote group not found")
+		}
+		query = `SELECT remoteid FROM remote WHERE groupid = ?`
+		remoteIds := tx.SelectStrings(query, groupId)
+		query = `UPDATE remote SET groupid = '' WHERE groupid = ?`
+		tx.Exec(query, groupId)
+		query = `DELETE FROM remote_group WHERE groupid = ?`
+		tx.Exec(query, groupId)
+		return remoteIds, nil
+	})
+}
+
+// newGroupIdx is 1-indexed
+func SetRemoteGroupIdx(ctx context.Context, groupId string, newGroupIdx int) ([]*RemoteGroupType, error) {
+	if newGroupIdx <= 0 {
+		return nil, fmt.Errorf("invalid groupidx/pos, must be greater than 0")
+	}
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]*RemoteGroupType, error) {
+		query := `SELECT groupid FROM remote_group WHERE groupid = ?`
+		if !tx.Exists(query, groupId) {
+			return nil, fmt.Errorf("remote group not found")
+		}
+		query = `SELECT groupid FROM remote_group ORDER BY groupidx`
+		groupIds := reorderStrs(tx.SelectStrings(query), groupId, newGroupIdx-1)
+		query = `UPDATE remote_group SET groupidx = ? WHERE groupid = ?`
+		for idx, gid := range groupIds {
+			tx.Exec(query, idx+1, gid)
+		}
+		query = `SELECT * FROM remote_group ORDER BY groupidx`
+		return dbutil.SelectMappable[*RemoteGroupType](tx, query), nil
+	})
+}
+
+// groupId "" removes the remote from its group.  the remote is moved to the end of the group.
+func SetRemoteGroupId(ctx context.Context, remoteId string, groupId string) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT remoteid FROM remote WHERE remoteid = ? AND NOT archived`
+		if !tx.Exists(query, remoteId) {
+			return fmt.Errorf("remote not found (or archived)")
+		}
+		if groupId != "" {
+			query = `SELECT groupid FROM remote_group WHERE groupid = ?`
+			if !tx.Exists(query, groupId) {
+				return fmt.Errorf("remote group not found")
+			}
+		}
+		query = `SELECT COALESCE(max(remoteidx), 0) FROM remote`
+		maxRemoteIdx := tx.GetInt(query)
+		query = `UPDATE remote SET groupid = ?, remoteidx = ? WHERE remoteid = ?`
+		tx.Exec(query, groupId, maxRemoteIdx+1, remoteId)
+		return nil
+	})
+}
+
+// moves the remote to newIdx (1-indexed) within its group (or within the ungrouped remotes).
+// the group's remotes are reassigned the remoteidx values they already had, so the order of the
+// other remotes does not change.
+func SetRemoteIdxInGroup(ctx context.Context, remoteId string, newIdx int) error {
+	if newIdx <= 0 {
+		return fmt.Errorf("invalid remoteidx/pos, must be greater than 0")
+	}
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT groupid FROM remote WHERE remoteid = ? AND NOT archived`
+		if !tx.Exists(query, remoteId) {
+			return fmt.Errorf("remote not found (or archived)")
+		}
+		groupId := tx.GetString(query, remoteId)
+		query = `SELECT remoteid, remoteidx FROM remote WHERE groupid = ? AND NOT archived ORDER BY remoteidx`
+		var remoteIds []string
+		var idxSlots []int64
+		for _, m := range tx.SelectMaps(query, groupId) {
+			var rid string
+			var ridx int64
+			dbutil.QuickSetStr(&rid, m, "remoteid")
+			dbutil.QuickSetInt64(&ridx, m, "remoteidx")
+			remoteIds = append(remoteIds, rid)
+			idxSlots = append(idxSlots, ridx)
+		}
+		newRemoteIds := reorderStrs(remoteIds, remoteId, newIdx-1)
+		query = `UPDATE remote SET remoteidx = ? WHERE remoteid = ?`
+		for idx, rid := range newRemoteIds {
+			tx.Exec(query, idxSlots[idx], rid)
+		}
+		return nil
+	})
+}
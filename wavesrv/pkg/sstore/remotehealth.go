@@ -0,0 +1,117 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+)
+
+// connection health of a remote (see remote/health.go).  connected remotes are pinged periodically, the
+// latency and the number of failed pings, dropped connections, and automatic reconnects are recorded here.
+// the row is created by the first ping and removed when the remote is archived (or the stats are reset).
+
+type RemoteHealthType struct {
+	RemoteId        string `json:"remoteid"`
+	LastPingTs      int64  `json:"lastpingts"`
+	LastLatencyMs   int64  `json:"lastlatencyms"` // -1 if the last ping failed
+	AvgLatencyMs    int64  `json:"avglatencyms"`  // over the successful pings
+	NumPings        int64  `json:"numpings"`
+	NumFailedPings  int64  `json:"numfailedpings"`
+	NumDrops        int64  `json:"numdrops"`
+	LastDropTs      int64  `json:"lastdropts"`
+	NumReconnects   int64  `json:"numreconnects"`
+	LastReconnectTs int64  `json:"lastreconnectts"`
+
+	// runtime only, set when an automatic reconnect is scheduled (0 otherwise)
+	ReconnectTs      int64 `json:"reconnectts,omitempty" dbmap:"-"`
+	ReconnectAttempt int   `json:"reconnectattempt,omitempty" dbmap:"-"`
+
+	// only for updates
+	Remove bool `json:"remove,omitempty" dbmap:"-"`
+}
+
+func (RemoteHealthType) UseDBMap() {}
+
+func (RemoteHealthType) GetType() string {
+	return "remotehealth"
+}
+
+func ensureRemoteHealth(tx *TxWrap, remoteId string) {
+	query := `SELECT remoteid FROM remote_health WHERE remoteid = ?`
+	if tx.Exists(query, remoteId) {
+		return
+	}
+	query = `INSERT INTO remote_health ( remoteid, lastpingts, lastlatencyms, avglatencyms, numpings, numfailedpings, numdrops, lastdropts, numreconnects, lastreconnectts)
+	                            VALUES (:remoteid,:lastpingts,:lastlatencyms,:avglatencyms,:numpings,:numfailedpings,:numdrops,:lastdropts,:numreconnects,:lastreconnectts)`
+	tx.NamedExec(query, dbutil.ToDBMap(&RemoteHealthType{RemoteId: remoteId}, false))
+}
+
+func getRemoteHealth(tx *TxWrap, remoteId string) *RemoteHealthType {
+	query := `SELECT * FROM remote_health WHERE remoteid = ?`
+	return dbutil.GetMappable[*RemoteHealthType](tx, query, remoteId)
+}
+
+// returns nil if the remote has no health stats
+func GetRemoteHealth(ctx context.Context, remoteId string) (*RemoteHealthType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*RemoteHealthType, error) {
+		return getRemoteHealth(tx, remoteId), nil
+	})
+}
+
+func GetAllRemoteHealth(ctx context.Context) ([]*RemoteHealthType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]*RemoteHealthType, error) {
+		query := `SELECT * FROM remote_health`
+		return dbutil.SelectMappable[*RemoteHealthType](tx, query), nil
+	})
+}
+
+// latencyMs is ignored for failed pings
+func RecordRemotePing(ctx context.Context, remoteId string, success bool, latencyMs int64) (*RemoteHealthType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*RemoteHealthType, error) {
+		ensureRemoteHealth(tx, remoteId)
+		health := getRemoteHealth(tx, remoteId)
+		health.LastPingTs = time.Now().UnixMilli()
+		health.NumPings++
+		if success {
+			numSuccess := health.NumPings - health.NumFailedPings
+			health.AvgLatencyMs = (health.AvgLatencyMs*(numSuccess-1) + latencyMs) / numSuccess
+			health.LastLatencyMs = latencyMs
+		} else {
+			health.NumFailedPings++
+			health.LastLatencyMs = -1
+		}
+		query := `UPDATE remote_health SET lastpingts = ?, lastlatencyms = ?, avglatencyms = ?, numpings = ?, numfailedpings = ? WHERE remoteid = ?`
+		tx.Exec(query, health.LastPingTs, health.LastLatencyMs, health.AvgLatencyMs, health.NumPings, health.NumFailedPings, remoteId)
+		return health, nil
+	})
+}
+
+func RecordRemoteDrop(ctx context.Context, remoteId string) (*RemoteHealthType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*RemoteHealthType, error) {
+		ensureRemoteHealth(tx, remoteId)
+		query := `UPDATE remote_health SET numdrops = numdrops + 1, lastdropts = ? WHERE remoteid = ?`
+		tx.Exec(query, time.Now().UnixMilli(), remoteId)
+		return getRemoteHealth(tx, remoteId), nil
+	})
+}
+
+func RecordRemoteReconnect(ctx context.Context, remoteId string) (*RemoteHealthType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*RemoteHealthType, error) {
+		ensureRemoteHealth(tx, remoteId)
+		query := `UPDATE remote_health SET numreconnects = numreconnects + 1, lastreconnectts = ? WHERE remoteid = ?`
+		tx.Exec(query, time.Now().UnixMilli(), remoteId)
+		return getRemoteHealth(tx, remoteId), nil
+	})
+}
+
+func DeleteRemoteHealth(ctx context.Context, remoteId string) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `DELETE FROM remote_health WHERE remoteid = ?`
+		tx.Exec(query, remoteId)
+		return nil
+	})
+}
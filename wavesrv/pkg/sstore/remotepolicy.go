@@ -0,0 +1,95 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// remote tag policies.  remotes can be tagged (remoteopts.tags), each tag with a rule in RemoteTagRules
+// contributes display metadata (banner, forced tab color) and confirmation requirements that are
+// sent to the frontend in RemoteRuntimeState.PolicyMeta.  the frontend only renders the metadata,
+// all decisions (including which commands need confirmation) are made here and in cmdrunner.
+
+const RemoteTag_Production = "production"
+
+const MaxRemoteTags = 10
+const MaxRemoteTagLen = 30
+
+var remoteTagRe = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+type RemoteTagRule struct {
+	Tag                string
+	BannerText         string // %s is replaced with the remote's display name
+	ConfirmDestructive bool
+	TabColor           string
+}
+
+var RemoteTagRules = []RemoteTagRule{
+	{
+		Tag:                RemoteTag_Production,
+		BannerText:         "PRODUCTION - you are connected to %s",
+		ConfirmDestructive: true,
+		TabColor:           "red",
+	},
+}
+
+type RemotePolicyMetaType struct {
+	Tags               []string `json:"tags,omitempty"`
+	BannerText         string   `json:"bannertext,omitempty"`
+	ConfirmDestructive bool     `json:"confirmdestructive,omitempty"`
+	TabColor           string   `json:"tabcolor,omitempty"`
+}
+
+func ValidateRemoteTag(tag string) error {
+	if len(tag) > MaxRemoteTagLen {
+		return fmt.Errorf("tag %q too long (max %d characters)", tag, MaxRemoteTagLen)
+	}
+	if !remoteTagRe.MatchString(tag) {
+		return fmt.Errorf("invalid tag %q, tags must be lowercase letters, numbers, '-' or '_'", tag)
+	}
+	return nil
+}
+
+func (opts *RemoteOptsType) HasTag(tag string) bool {
+	if opts == nil {
+		return false
+	}
+	for _, t := range opts.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// returns nil if no rules apply to the remote.  the first matching rule (in RemoteTagRules order)
+// sets the banner and tab color, ConfirmDestructive is set if any matching rule requires it.
+func GetRemotePolicyMeta(opts *RemoteOptsType, displayName string) *RemotePolicyMetaType {
+	if opts == nil || len(opts.Tags) == 0 {
+		return nil
+	}
+	var rtn *RemotePolicyMetaType
+	for _, rule := range RemoteTagRules {
+		if !opts.HasTag(rule.Tag) {
+			continue
+		}
+		if rtn == nil {
+			rtn = &RemotePolicyMetaType{}
+		}
+		if rtn.BannerText == "" && rule.BannerText != "" {
+			rtn.BannerText = strings.ReplaceAll(rule.BannerText, "%s", displayName)
+		}
+		if rtn.TabColor == "" {
+			rtn.TabColor = rule.TabColor
+		}
+		rtn.ConfirmDestructive = rtn.ConfirmDestructive || rule.ConfirmDestructive
+	}
+	if rtn != nil {
+		rtn.Tags = append([]string{}, opts.Tags...)
+	}
+	return rtn
+}
@@ -0,0 +1,79 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"encoding/json"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+)
+
+// screenlines updates for very large screens are split into chunks (see scbus.SplitModelUpdate).  every chunk has
+// the same chunkid, chunkidx (0-based) and numchunks, the client waits for all the chunks and merges them back into
+// one screenlines update.  a line's cmd is always in the same chunk as the line.
+
+const screenLinesChunkOverhead = 256 // screenid and the chunk metadata
+
+func jsonSize(v interface{}) int {
+	barr, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return len(barr) + 1
+}
+
+func (sl ScreenLinesType) SplitItem(maxSize int) []scbus.ModelUpdateItem {
+	cmdMap := make(map[string]*CmdType)
+	for _, cmd := range sl.Cmds {
+		if cmd != nil {
+			cmdMap[cmd.LineId] = cmd
+		}
+	}
+	var chunks []*ScreenLinesType
+	curChunk := &ScreenLinesType{ScreenId: sl.ScreenId}
+	curSize := screenLinesChunkOverhead
+	addToChunk := func(line *LineType, cmd *CmdType, size int) {
+		if (len(curChunk.Lines) > 0 || len(curChunk.Cmds) > 0) && curSize+size > maxSize {
+			chunks = append(chunks, curChunk)
+			curChunk = &ScreenLinesType{ScreenId: sl.ScreenId}
+			curSize = screenLinesChunkOverhead
+		}
+		if line != nil {
+			curChunk.Lines = append(curChunk.Lines, line)
+		}
+		if cmd != nil {
+			curChunk.Cmds = append(curChunk.Cmds, cmd)
+		}
+		curSize += size
+	}
+	for _, line := range sl.Lines {
+		if line == nil {
+			continue
+		}
+		cmd := cmdMap[line.LineId]
+		delete(cmdMap, line.LineId)
+		size := jsonSize(line)
+		if cmd != nil {
+			size += jsonSize(cmd)
+		}
+		addToChunk(line, cmd, size)
+	}
+	// cmds without a line (keeps their original order)
+	for _, cmd := range sl.Cmds {
+		if cmd != nil && cmdMap[cmd.LineId] == cmd {
+			addToChunk(nil, cmd, jsonSize(cmd))
+		}
+	}
+	chunks = append(chunks, curChunk)
+	chunkId := scbase.GenWaveUUID()
+	rtn := make([]scbus.ModelUpdateItem, 0, len(chunks))
+	for idx, chunk := range chunks {
+		chunk.ChunkId = chunkId
+		chunk.ChunkIdx = idx
+		chunk.NumChunks = len(chunks)
+		rtn = append(rtn, *chunk)
+	}
+	return rtn
+}
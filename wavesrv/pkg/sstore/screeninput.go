@@ -0,0 +1,155 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/utilfn"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+)
+
+// per-screen input drafts (CmdInputText) and input history rings, persisted so they survive a server restart.
+// the in-memory state (memops.go) stays the source of truth, changes are written to the db in the background
+// (debounced, the frontend sends the input text on every keystroke).  GetConnectUpdate restores the saved state
+// into memory (for screens that have no input yet) and sends it with the connect update.
+
+const MaxScreenInputHistory = 50
+const ScreenInputSaveDelay = 2 * time.Second
+const ScreenInputMaxSaveDelay = 10 * time.Second // continuous typing does not postpone the save forever
+const screenInputSaveTimeout = 5 * time.Second
+
+type ScreenInputType struct {
+	ScreenId  string            `json:"screenid"`
+	InputText utilfn.StrWithPos `json:"inputtext"`
+	History   []string          `json:"history"`
+	UpdatedTs int64             `json:"updatedts"`
+}
+
+func (ScreenInputType) UseDBMap() {}
+
+type screenInputSaverType struct {
+	Lock         *sync.Mutex
+	Dirty        map[string]bool
+	Timer        *time.Timer
+	FirstDirtyTs time.Time
+}
+
+var screenInputSaver = &screenInputSaverType{Lock: &sync.Mutex{}, Dirty: make(map[string]bool)}
+
+func queueScreenInputSave(screenId string) {
+	s := screenInputSaver
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+	s.Dirty[screenId] = true
+	if s.Timer == nil {
+		s.FirstDirtyTs = time.Now()
+		s.Timer = time.AfterFunc(ScreenInputSaveDelay, runScreenInputSave)
+		return
+	}
+	if time.Since(s.FirstDirtyTs) < ScreenInputMaxSaveDelay {
+		s.Timer.Reset(ScreenInputSaveDelay)
+	}
+}
+
+func takeDirtyScreenInputs() []string {
+	s := screenInputSaver
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+	if s.Timer != nil {
+		s.Timer.Stop()
+		s.Timer = nil
+	}
+	var rtn []string
+	for screenId := range s.Dirty {
+		rtn = append(rtn, screenId)
+	}
+	s.Dirty = make(map[string]bool)
+	return rtn
+}
+
+func runScreenInputSave() {
+	ctx, cancelFn := context.WithTimeout(context.Background(), screenInputSaveTimeout)
+	defer cancelFn()
+	err := FlushScreenInputs(ctx)
+	if err != nil {
+		log.Printf("[db] error saving screen inputs: %v\n", err)
+	}
+}
+
+// writes the pending input changes now (called on shutdown, before the db is closed)
+func FlushScreenInputs(ctx context.Context) error {
+	screenIds := takeDirtyScreenInputs()
+	if len(screenIds) == 0 {
+		return nil
+	}
+	var inputs []*ScreenInputType
+	for _, screenId := range screenIds {
+		memState := GetScreenMemState(screenId)
+		if memState == nil {
+			continue
+		}
+		inputs = append(inputs, &ScreenInputType{
+			ScreenId:  screenId,
+			InputText: memState.CmdInputText,
+			History:   memState.InputHistory,
+			UpdatedTs: time.Now().UnixMilli(),
+		})
+	}
+	return WithTx(ctx, func(tx *TxWrap) error {
+		for _, input := range inputs {
+			query := `DELETE FROM screeninput WHERE screenid = ?`
+			tx.Exec(query, input.ScreenId)
+			if input.InputText.Str == "" && len(input.History) == 0 {
+				continue
+			}
+			query = `SELECT screenid FROM screen WHERE screenid = ?`
+			if !tx.Exists(query, input.ScreenId) {
+				// deleted since the change was queued
+				continue
+			}
+			query = `INSERT INTO screeninput ( screenid, inputtext, history, updatedts)
+			                          VALUES (:screenid,:inputtext,:history,:updatedts)`
+			tx.NamedExec(query, dbutil.ToDBMap(input, false))
+		}
+		return nil
+	})
+}
+
+func getScreenInputs(tx *TxWrap) []*ScreenInputType {
+	query := `SELECT * FROM screeninput`
+	return dbutil.SelectMappable[*ScreenInputType](tx, query)
+}
+
+// restores the saved input of the screens that have no input in memory yet (after a restart), returns the
+// current input of the given screens
+func restoreScreenInputs(saved []*ScreenInputType, screenIds []string) []*ScreenInputType {
+	MemLock.Lock()
+	defer MemLock.Unlock()
+	for _, input := range saved {
+		memState := ScreenMemStore[input.ScreenId]
+		if memState == nil {
+			memState = &ScreenMemState{}
+			ScreenMemStore[input.ScreenId] = memState
+		}
+		if memState.CmdInputSeqNum == 0 && memState.CmdInputText.Str == "" {
+			memState.CmdInputText = input.InputText
+		}
+		if memState.InputHistory == nil {
+			memState.InputHistory = input.History
+		}
+	}
+	var rtn []*ScreenInputType
+	for _, screenId := range screenIds {
+		memState := ScreenMemStore[screenId]
+		if memState == nil || (memState.CmdInputText.Str == "" && len(memState.InputHistory) == 0) {
+			continue
+		}
+		rtn = append(rtn, &ScreenInputType{ScreenId: screenId, InputText: memState.CmdInputText, History: memState.InputHistory})
+	}
+	return rtn
+}
@@ -0,0 +1,299 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+)
+
+// split panes (tmux style).  a screen with a nil PaneLayout is a single (implicit) pane.  otherwise
+// PaneLayout.Root is a binary tree of splits with leaf panes.  the active pane always mirrors
+// the screen's CurRemote and SelectedLine (so all existing screen code operates on the active pane),
+// the CurRemote/SelectedLine stored in the tree are only authoritative for inactive panes.
+
+const (
+	PaneSplit_Horizontal = "horizontal" // children are side by side
+	PaneSplit_Vertical   = "vertical"   // children are stacked
+)
+
+const MaxScreenPanes = 8
+const DefaultPaneRatio = 0.5
+const MinPaneRatio = 0.1
+const MaxPaneRatio = 0.9
+
+type ScreenPaneType struct {
+	PaneId       string            `json:"paneid"`
+	Split        string            `json:"split,omitempty"`    // empty for leaf panes
+	Ratio        float64           `json:"ratio,omitempty"`    // size of the first child (splits only)
+	Children     []*ScreenPaneType `json:"children,omitempty"` // exactly 2 (splits only)
+	CurRemote    *RemotePtrType    `json:"curremote,omitempty"`
+	SelectedLine int64             `json:"selectedline,omitempty"`
+}
+
+func (p *ScreenPaneType) IsLeaf() bool {
+	return p.Split == ""
+}
+
+type ScreenPaneLayout struct {
+	Root         *ScreenPaneType `json:"root"`
+	ActivePaneId string          `json:"activepaneid"`
+}
+
+// returns the leaf panes in display order (left to right, top to bottom)
+func (l *ScreenPaneLayout) GetLeafPanes() []*ScreenPaneType {
+	var rtn []*ScreenPaneType
+	var walk func(pane *ScreenPaneType)
+	walk = func(pane *ScreenPaneType) {
+		if pane == nil {
+			return
+		}
+		if pane.IsLeaf() {
+			rtn = append(rtn, pane)
+			return
+		}
+		for _, child := range pane.Children {
+			walk(child)
+		}
+	}
+	walk(l.Root)
+	return rtn
+}
+
+// returns the pane and its parent (parent is nil for the root)
+func (l *ScreenPaneLayout) FindPane(paneId string) (*ScreenPaneType, *ScreenPaneType) {
+	var find func(pane *ScreenPaneType, parent *ScreenPaneType) (*ScreenPaneType, *ScreenPaneType)
+	find = func(pane *ScreenPaneType, parent *ScreenPaneType) (*ScreenPaneType, *ScreenPaneType) {
+		if pane == nil {
+			return nil, nil
+		}
+		if pane.PaneId == paneId {
+			return pane, parent
+		}
+		for _, child := range pane.Children {
+			if found, foundParent := find(child, pane); found != nil {
+				return found, foundParent
+			}
+		}
+		return nil, nil
+	}
+	return find(l.Root, nil)
+}
+
+// resolves a leaf pane by id, id prefix, or 1-based pane number (display order).  "" is the active pane
+func (l *ScreenPaneLayout) ResolveLeafPane(paneArg string) (*ScreenPaneType, error) {
+	if paneArg == "" {
+		paneArg = l.ActivePaneId
+	}
+	leaves := l.GetLeafPanes()
+	if paneNum, err := strconv.Atoi(paneArg); err == nil {
+		if paneNum < 1 || paneNum > len(leaves) {
+			return nil, fmt.Errorf("pane %d not found (screen has %d panes)", paneNum, len(leaves))
+		}
+		return leaves[paneNum-1], nil
+	}
+	var rtn *ScreenPaneType
+	for _, leaf := range leaves {
+		if leaf.PaneId == paneArg {
+			return leaf, nil
+		}
+		if len(paneArg) >= 4 && len(leaf.PaneId) > len(paneArg) && leaf.PaneId[0:len(paneArg)] == paneArg {
+			if rtn != nil {
+				return nil, fmt.Errorf("ambiguous pane id %q", paneArg)
+			}
+			rtn = leaf
+		}
+	}
+	if rtn == nil {
+		return nil, fmt.Errorf("pane %q not found", paneArg)
+	}
+	return rtn, nil
+}
+
+func ValidatePaneSplit(split string) error {
+	if split != PaneSplit_Horizontal && split != PaneSplit_Vertical {
+		return fmt.Errorf("invalid split %q, must be %q or %q", split, PaneSplit_Horizontal, PaneSplit_Vertical)
+	}
+	return nil
+}
+
+func ValidatePaneRatio(ratio float64) error {
+	if ratio < MinPaneRatio || ratio > MaxPaneRatio {
+		return fmt.Errorf("invalid ratio %v, must be between %v and %v", ratio, MinPaneRatio, MaxPaneRatio)
+	}
+	return nil
+}
+
+func getScreenForPaneUpdate(tx *TxWrap, screenId string) (*ScreenType, error) {
+	query := `SELECT * FROM screen WHERE screenid = ?`
+	screen := dbutil.GetMapGen[*ScreenType](tx, query, screenId)
+	if screen == nil {
+		return nil, fmt.Errorf("screen not found")
+	}
+	return screen, nil
+}
+
+// copies the screen's curremote/selectedline into the active pane
+func syncActivePane(screen *ScreenType) {
+	if screen.PaneLayout == nil {
+		return
+	}
+	activePane, _ := screen.PaneLayout.FindPane(screen.PaneLayout.ActivePaneId)
+	if activePane == nil {
+		return
+	}
+	remotePtr := screen.CurRemote
+	activePane.CurRemote = &remotePtr
+	activePane.SelectedLine = screen.SelectedLine
+}
+
+func updateScreenPaneLayout(tx *TxWrap, screen *ScreenType) {
+	query := `UPDATE screen SET panelayout = ?, curremoteownerid = ?, curremoteid = ?, curremotename = ?, selectedline = ? WHERE screenid = ?`
+	tx.Exec(query, quickNullableJson(screen.PaneLayout), screen.CurRemote.OwnerId, screen.CurRemote.RemoteId, screen.CurRemote.Name, screen.SelectedLine, screen.ScreenId)
+}
+
+// splits paneId ("" for the active pane) and makes the new pane active.  the new pane inherits the split pane's remote.
+func SplitScreenPane(ctx context.Context, screenId string, paneArg string, split string, ratio float64) (*ScreenType, error) {
+	if err := ValidatePaneSplit(split); err != nil {
+		return nil, err
+	}
+	if err := ValidatePaneRatio(ratio); err != nil {
+		return nil, err
+	}
+	return WithTxRtn(ctx, func(tx *TxWrap) (*ScreenType, error) {
+		screen, err := getScreenForPaneUpdate(tx, screenId)
+		if err != nil {
+			return nil, err
+		}
+		if screen.PaneLayout == nil {
+			rootId := scbase.GenWaveUUID()
+			screen.PaneLayout = &ScreenPaneLayout{Root: &ScreenPaneType{PaneId: rootId}, ActivePaneId: rootId}
+		}
+		syncActivePane(screen)
+		layout := screen.PaneLayout
+		if len(layout.GetLeafPanes()) >= MaxScreenPanes {
+			return nil, fmt.Errorf("screen already has the maximum number of panes (%d)", MaxScreenPanes)
+		}
+		pane, err := layout.ResolveLeafPane(paneArg)
+		if err != nil {
+			return nil, err
+		}
+		// the split pane moves down to be the first child, its node becomes the split
+		existingPane := *pane
+		newPane := &ScreenPaneType{PaneId: scbase.GenWaveUUID(), CurRemote: existingPane.CurRemote}
+		*pane = ScreenPaneType{
+			PaneId:   scbase.GenWaveUUID(),
+			Split:    split,
+			Ratio:    ratio,
+			Children: []*ScreenPaneType{&existingPane, newPane},
+		}
+		layout.ActivePaneId = newPane.PaneId
+		screen.SelectedLine = 0
+		updateScreenPaneLayout(tx, screen)
+		return screen, nil
+	})
+}
+
+// removes a leaf pane, its sibling takes its parent's place.  closing the last split removes the layout.
+func CloseScreenPane(ctx context.Context, screenId string, paneArg string) (*ScreenType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*ScreenType, error) {
+		screen, err := getScreenForPaneUpdate(tx, screenId)
+		if err != nil {
+			return nil, err
+		}
+		if screen.PaneLayout == nil {
+			return nil, fmt.Errorf("screen has no split panes")
+		}
+		syncActivePane(screen)
+		layout := screen.PaneLayout
+		pane, err := layout.ResolveLeafPane(paneArg)
+		if err != nil {
+			return nil, err
+		}
+		_, parent := layout.FindPane(pane.PaneId)
+		if parent == nil {
+			return nil, fmt.Errorf("cannot close the only pane")
+		}
+		sibling := parent.Children[0]
+		if sibling == pane {
+			sibling = parent.Children[1]
+		}
+		*parent = *sibling
+		if layout.ActivePaneId == pane.PaneId {
+			layout.ActivePaneId = layout.GetLeafPanes()[0].PaneId
+		}
+		setActivePaneFields(screen)
+		if layout.Root.IsLeaf() {
+			screen.PaneLayout = nil
+		}
+		updateScreenPaneLayout(tx, screen)
+		return screen, nil
+	})
+}
+
+// loads the active pane's curremote/selectedline into the screen
+func setActivePaneFields(screen *ScreenType) {
+	activePane, _ := screen.PaneLayout.FindPane(screen.PaneLayout.ActivePaneId)
+	if activePane == nil {
+		return
+	}
+	// locked screens keep their remote in every pane
+	if activePane.CurRemote != nil && !screen.ScreenOpts.RemoteLock {
+		screen.CurRemote = *activePane.CurRemote
+	}
+	screen.SelectedLine = activePane.SelectedLine
+}
+
+func SetActiveScreenPane(ctx context.Context, screenId string, paneArg string) (*ScreenType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*ScreenType, error) {
+		screen, err := getScreenForPaneUpdate(tx, screenId)
+		if err != nil {
+			return nil, err
+		}
+		if screen.PaneLayout == nil {
+			return nil, fmt.Errorf("screen has no split panes")
+		}
+		syncActivePane(screen)
+		pane, err := screen.PaneLayout.ResolveLeafPane(paneArg)
+		if err != nil {
+			return nil, err
+		}
+		screen.PaneLayout.ActivePaneId = pane.PaneId
+		setActivePaneFields(screen)
+		updateScreenPaneLayout(tx, screen)
+		return screen, nil
+	})
+}
+
+// sets the ratio of the split containing paneArg
+func ResizeScreenPane(ctx context.Context, screenId string, paneArg string, ratio float64) (*ScreenType, error) {
+	if err := ValidatePaneRatio(ratio); err != nil {
+		return nil, err
+	}
+	return WithTxRtn(ctx, func(tx *TxWrap) (*ScreenType, error) {
+		screen, err := getScreenForPaneUpdate(tx, screenId)
+		if err != nil {
+			return nil, err
+		}
+		if screen.PaneLayout == nil {
+			return nil, fmt.Errorf("screen has no split panes")
+		}
+		syncActivePane(screen)
+		pane, err := screen.PaneLayout.ResolveLeafPane(paneArg)
+		if err != nil {
+			return nil, err
+		}
+		_, parent := screen.PaneLayout.FindPane(pane.PaneId)
+		if parent == nil {
+			return nil, fmt.Errorf("cannot resize the only pane")
+		}
+		parent.Ratio = ratio
+		updateScreenPaneLayout(tx, screen)
+		return screen, nil
+	})
+}
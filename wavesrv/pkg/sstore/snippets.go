@@ -0,0 +1,141 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+)
+
+// snippets (prompt library).  named command templates with {{var}} placeholders, rendered into the command input
+// (see cmdrunner/snippets.go).  a snippet can be scoped to a remote (remoteid) and/or to remotes with a tag
+// (remotetag, see remotepolicy.go), unscoped snippets are available everywhere.  snippet names are unique.
+
+const MaxSnippets = 500
+const MaxSnippetCmdLen = 4096
+const MaxSnippetDescLen = 300
+
+type SnippetType struct {
+	SnippetId   string `json:"snippetid"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	CmdStr      string `json:"cmdstr"`
+	RemoteId    string `json:"remoteid,omitempty"`  // scope, "" for all remotes
+	RemoteTag   string `json:"remotetag,omitempty"` // scope, "" for all remotes
+	CreatedTs   int64  `json:"createdts"`
+	LastUsedTs  int64  `json:"lastusedts"`
+	UseCount    int64  `json:"usecount"`
+}
+
+func (SnippetType) UseDBMap() {}
+
+// remoteOpts can be nil (a remote without tags)
+func (s *SnippetType) InScope(remoteId string, remoteOpts *RemoteOptsType) bool {
+	if s.RemoteId != "" && s.RemoteId != remoteId {
+		return false
+	}
+	if s.RemoteTag != "" && !remoteOpts.HasTag(s.RemoteTag) {
+		return false
+	}
+	return true
+}
+
+// sent after any change to the snippets (always the full list, the frontend palette filters by scope)
+type SnippetsUpdateType struct {
+	Snippets []*SnippetType `json:"snippets"`
+}
+
+func (SnippetsUpdateType) GetType() string {
+	return "snippets"
+}
+
+// search (can be "") matches the name, description or cmdstr (case-insensitive substring)
+func GetSnippets(ctx context.Context, search string) ([]*SnippetType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]*SnippetType, error) {
+		query := `SELECT * FROM snippet ORDER BY usecount DESC, name`
+		if search == "" {
+			return dbutil.SelectMappable[*SnippetType](tx, query), nil
+		}
+		likeArg := strings.ReplaceAll(search, "%", "\\%")
+		likeArg = strings.ReplaceAll(likeArg, "_", "\\_")
+		likeStr := "%" + likeArg + "%"
+		query = `SELECT * FROM snippet
+		         WHERE name LIKE ? ESCAPE '\' OR description LIKE ? ESCAPE '\' OR cmdstr LIKE ? ESCAPE '\'
+		         ORDER BY usecount DESC, name`
+		return dbutil.SelectMappable[*SnippetType](tx, query, likeStr, likeStr, likeStr), nil
+	})
+}
+
+// arg is a snippet name or snippetid, returns nil if not found
+func GetSnippetByArg(ctx context.Context, arg string) (*SnippetType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*SnippetType, error) {
+		query := `SELECT * FROM snippet WHERE name = ? OR snippetid = ?`
+		return dbutil.GetMappable[*SnippetType](tx, query, arg, arg), nil
+	})
+}
+
+// replaces an existing snippet with the same name (keeps its snippetid and use count)
+func SaveSnippet(ctx context.Context, snippet *SnippetType) error {
+	if len(snippet.CmdStr) > MaxSnippetCmdLen {
+		return fmt.Errorf("snippet command too long (max %d chars)", MaxSnippetCmdLen)
+	}
+	if len(snippet.Description) > MaxSnippetDescLen {
+		return fmt.Errorf("snippet description too long (max %d chars)", MaxSnippetDescLen)
+	}
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT snippetid FROM snippet WHERE name = ?`
+		existingId := tx.GetString(query, snippet.Name)
+		if existingId != "" {
+			snippet.SnippetId = existingId
+			query = `UPDATE snippet SET description = ?, cmdstr = ?, remoteid = ?, remotetag = ? WHERE snippetid = ?`
+			tx.Exec(query, snippet.Description, snippet.CmdStr, snippet.RemoteId, snippet.RemoteTag, existingId)
+			return nil
+		}
+		query = `SELECT count(*) FROM snippet`
+		if tx.GetInt(query) >= MaxSnippets {
+			return fmt.Errorf("too many snippets (max %d)", MaxSnippets)
+		}
+		query = `INSERT INTO snippet ( snippetid, name, description, cmdstr, remoteid, remotetag, createdts, lastusedts, usecount)
+		                      VALUES (:snippetid,:name,:description,:cmdstr,:remoteid,:remotetag,:createdts,:lastusedts,:usecount)`
+		tx.NamedExec(query, dbutil.ToDBMap(snippet, false))
+		return nil
+	})
+}
+
+func DeleteSnippet(ctx context.Context, snippetId string) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `DELETE FROM snippet WHERE snippetid = ?`
+		tx.Exec(query, snippetId)
+		return nil
+	})
+}
+
+// snippets scoped to the remote are removed with it
+func DeleteRemoteSnippets(ctx context.Context, remoteId string) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `DELETE FROM snippet WHERE remoteid = ?`
+		tx.Exec(query, remoteId)
+		return nil
+	})
+}
+
+func MarkSnippetUsed(ctx context.Context, snippetId string) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `UPDATE snippet SET usecount = usecount + 1, lastusedts = ? WHERE snippetid = ?`
+		tx.Exec(query, time.Now().UnixMilli(), snippetId)
+		return nil
+	})
+}
+
+func MakeSnippetsUpdate(ctx context.Context) (*SnippetsUpdateType, error) {
+	snippets, err := GetSnippets(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return &SnippetsUpdateType{Snippets: snippets}, nil
+}
@@ -42,7 +42,7 @@ const DBFileNameBackup = "backup.waveterm.db"
 const DBWALFileNameBackup = "backup.waveterm.db-wal"
 const MaxWebShareLineCount = 50
 const MaxWebShareScreenCount = 3
-const MaxLineStateSize = 4 * 1024 // 4k for now, can raise if needed
+const MaxLineStateSize = 4 * 1024 // max size stored in the line row, larger states overflow to the blockstore
 const DefaultSudoTimeout = 5
 
 const DefaultSessionName = "default"
@@ -59,6 +59,7 @@ const (
 	LineTypeCmd    = "cmd"
 	LineTypeText   = "text"
 	LineTypeOpenAI = "openai"
+	LineTypeSystem = "system" // text generated by wave (e.g. ssh banners), not by the user
 )
 
 const (
@@ -68,8 +69,40 @@ const (
 	LineState_Min      = "wave:min"
 	LineState_Template = "template"
 	LineState_Mode     = "mode"
+	LineState_System   = "wave:system" // the kind of system line (e.g. "sshbanner")
 	LineState_Lang     = "lang"
 	LineState_Minimap  = "minimap"
+
+	// set for ephemeral lines, the line will be auto-removed after this ts (ms)
+	LineState_EphemeralExpireTs = "wave:ephemeralexpirets"
+
+	// set for lines created by the scheduler
+	LineState_ScheduleId = "wave:scheduleid"
+
+	// set (in the db row only) when the line state is stored in the blockstore, see linestate.go
+	LineState_OverflowRef = "wave:overflowref"
+
+	// lines with the same chainid (on the same screen) are re-run sequentially by /line:runchain
+	LineState_ChainId = "wave:chainid"
+
+	// set (to the remoteid) for lines that run a remote's init scripts after a new remote instance is created
+	LineState_InitScript = "wave:initscript"
+
+	// set (to the screenid) for lines that run a screen's startup commands
+	LineState_StartupCmd = "wave:startupcmd"
+
+	// set (to the kill ts in ms) while a running cmd is about to be killed by the idle-kill policy
+	LineState_IdleKillTs = "wave:idlekillts"
+	// set once a cmd has been killed by the idle-kill policy
+	LineState_IdleKilled = "wave:idlekilled"
+
+	// the (detected or user set) encoding of non-utf-8 output, see encoding.go
+	LineState_Encoding = "wave:encoding"
+
+	// set when the cmd wrote binary output (the raw output is in the blockstore), see binaryout.go
+	LineState_BinaryOut   = "wave:binaryout"
+	LineState_BinaryMime  = "wave:binarymime"
+	LineState_BinaryThumb = "wave:binarythumb"
 )
 
 const (
@@ -125,6 +158,8 @@ const (
 
 const (
 	RemoteTypeSsh    = "ssh"
+	RemoteTypeWSL    = "wsl"
+	RemoteTypeSerial = "serial"
 	RemoteTypeOpenAI = "openai"
 )
 
@@ -253,6 +288,30 @@ type ClientOptsType struct {
 	GlobalShortcutEnabled bool              `json:"globalshortcutenabled,omitempty"`
 	WebGL                 bool              `json:"webgl,omitempty"`
 	AutocompleteEnabled   bool              `json:"autocompleteenabled,omitempty"`
+	PtyArchiveDays        int               `json:"ptyarchivedays,omitempty"`
+	CmdDoneNotifySecs     int               `json:"cmddonenotifysecs,omitempty"` // notify when a command has been running this long, and when it finishes
+	MaxLineStateSize      int               `json:"maxlinestatesize,omitempty"`
+	Timezone              string            `json:"timezone,omitempty"`
+	Locale                string            `json:"locale,omitempty"`
+	DropdownSessionId     string            `json:"dropdownsessionid,omitempty"`
+	DropdownScreenId      string            `json:"dropdownscreenid,omitempty"`
+	WebShareUrl           string            `json:"webshareurl,omitempty"`   // self-hosted share server, "" for the hosted service
+	WebShareToken         string            `json:"websharetoken,omitempty"` // bearer token for the self-hosted share server
+	DemoMode              bool              `json:"demomode,omitempty"`      // see demomode.go
+	BlockFlushMs          int               `json:"blockflushms,omitempty"`  // see blockflush.go
+	BlockDirtyKB          int               `json:"blockdirtykb,omitempty"`
+	BlockSync             string            `json:"blocksync,omitempty"`
+	AIExplainErrors       bool              `json:"aiexplainerrors,omitempty"` // see cmderror.go
+	BackupDir             string            `json:"backupdir,omitempty"`       // see backup.go
+	BackupHours           int               `json:"backuphours,omitempty"`
+	BackupKeep            int               `json:"backupkeep,omitempty"`
+	TrashDays             int               `json:"trashdays,omitempty"`         // see trash.go
+	TmuxControl           bool              `json:"tmuxcontrol,omitempty"`       // see tmuxcc
+	AutoArchiveDays       int               `json:"autoarchivedays,omitempty"`   // see autoarchive.go
+	SshAttachAddr         string            `json:"sshattachaddr,omitempty"`     // see sshattach
+	MetricsInterval       int               `json:"metricsinterval,omitempty"`   // seconds, see remote/metrics.go
+	MetricsPort           int               `json:"metricsport,omitempty"`       // localhost /metrics endpoint, see srvmetrics
+	CompletionSpecDir     string            `json:"completionspecdir,omitempty"` // see newton/spec.go
 }
 
 type FeOptsType struct {
@@ -307,6 +366,9 @@ func (cdata *ClientData) Clean() *ClientData {
 			rtn.OpenAIOpts.APIToken = APITokenSentinel
 		}
 	}
+	if cdata.ClientOpts.WebShareToken != "" {
+		rtn.ClientOpts.WebShareToken = APITokenSentinel
+	}
 	return &rtn
 }
 
@@ -362,15 +424,35 @@ type SessionStatsType struct {
 }
 
 type ScreenOptsType struct {
-	TabColor string `json:"tabcolor,omitempty"`
-	TabIcon  string `json:"tabicon,omitempty"`
-	PTerm    string `json:"pterm,omitempty"`
+	TabColor   string `json:"tabcolor,omitempty"`
+	TabIcon    string `json:"tabicon,omitempty"`
+	PTerm      string `json:"pterm,omitempty"`
+	NoNotify   bool   `json:"nonotify,omitempty"`
+	RemoteLock bool   `json:"remotelock,omitempty"` // blocks changing curremote (see UpdateCurRemote)
+
+	// run (as a single line) when the screen's first remote instance is created, see cmdrunner/startupcmds.go
+	StartupCmds []string `json:"startupcmds,omitempty"`
+
+	// idle commands (no output or input) are killed after this many hours, see remote/idlekill.go
+	IdleKillHours int `json:"idlekillhours,omitempty"`
+
+	// overrides the local remote's shellpref for the screen's (screen-scoped) remote instances, see
+	// cmdrunner/screenshell.go
+	ShellPref string `json:"shellpref,omitempty"`
+
+	// pinned screens are never auto-archived, see autoarchive.go
+	Pinned bool `json:"pinned,omitempty"`
 }
 
 type ScreenLinesType struct {
 	ScreenId string      `json:"screenid"`
 	Lines    []*LineType `json:"lines" dbmap:"-"`
 	Cmds     []*CmdType  `json:"cmds" dbmap:"-"`
+
+	// set when a large update is split into chunks (see screenchunks.go)
+	ChunkId   string `json:"chunkid,omitempty" dbmap:"-"`
+	ChunkIdx  int    `json:"chunkidx,omitempty" dbmap:"-"`
+	NumChunks int    `json:"numchunks,omitempty" dbmap:"-"`
 }
 
 func (ScreenLinesType) UseDBMap() {}
@@ -382,6 +464,7 @@ func (ScreenLinesType) GetType() string {
 type ScreenWebShareOpts struct {
 	ShareName string `json:"sharename"`
 	ViewKey   string `json:"viewkey"`
+	ViewUrl   string `json:"viewurl,omitempty"` // set by the share upstream (see pcloud/webshare.go)
 }
 
 type ScreenCreateOpts struct {
@@ -404,8 +487,25 @@ type ScreenSidebarOptsType struct {
 	SidebarLineId string `json:"sidebarlineid,omitempty"`
 }
 
+// a saved line filter, matches lines that have any of the tags
+type ScreenFilterType struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
 type ScreenViewOptsType struct {
-	Sidebar *ScreenSidebarOptsType `json:"sidebar,omitempty"`
+	Sidebar      *ScreenSidebarOptsType `json:"sidebar,omitempty"`
+	Filters      []ScreenFilterType     `json:"filters,omitempty"`
+	ActiveFilter *ScreenFilterType      `json:"activefilter,omitempty"` // nil shows all lines
+}
+
+func (opts ScreenViewOptsType) GetFilter(name string) *ScreenFilterType {
+	for idx := range opts.Filters {
+		if opts.Filters[idx].Name == name {
+			return &opts.Filters[idx]
+		}
+	}
+	return nil
 }
 
 type ScreenType struct {
@@ -425,6 +525,8 @@ type ScreenType struct {
 	FocusType      string              `json:"focustype"`
 	Archived       bool                `json:"archived,omitempty"`
 	ArchivedTs     int64               `json:"archivedts,omitempty"`
+	PaneLayout     *ScreenPaneLayout   `json:"panelayout,omitempty"`
+	LastActiveTs   int64               `json:"lastactivets,omitempty"` // created, switched to, or restored (see autoarchive.go)
 
 	// only for updates
 	Remove bool `json:"remove,omitempty"`
@@ -450,6 +552,8 @@ func (s *ScreenType) ToMap() map[string]interface{} {
 	rtn["focustype"] = s.FocusType
 	rtn["archived"] = s.Archived
 	rtn["archivedts"] = s.ArchivedTs
+	rtn["panelayout"] = quickNullableJson(s.PaneLayout)
+	rtn["lastactivets"] = s.LastActiveTs
 	return rtn
 }
 
@@ -472,6 +576,8 @@ func (s *ScreenType) FromMap(m map[string]interface{}) bool {
 	quickSetStr(&s.FocusType, m, "focustype")
 	quickSetBool(&s.Archived, m, "archived")
 	quickSetInt64(&s.ArchivedTs, m, "archivedts")
+	quickSetNullableJson(&s.PaneLayout, m, "panelayout")
+	quickSetInt64(&s.LastActiveTs, m, "lastactivets")
 	return true
 }
 
@@ -684,6 +790,8 @@ type LineType struct {
 	Star          bool           `json:"star,omitempty"`
 	Archived      bool           `json:"archived,omitempty"`
 	Remove        bool           `json:"remove,omitempty"`
+	DayStr        string         `json:"daystr,omitempty" dbmap:"-"` // computed in the client timezone, not stored
+	Tags          []string       `json:"tags,omitempty" dbmap:"-"`   // from the line_tag table
 }
 
 func (LineType) UseDBMap() {}
@@ -739,7 +847,46 @@ func (opts SSHOpts) GetAuthType() string {
 }
 
 type RemoteOptsType struct {
-	Color string `json:"color"`
+	Color       string                `json:"color"`
+	CmdAllow    []string              `json:"cmdallow,omitempty"`
+	CmdDeny     []string              `json:"cmddeny,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	PreConnect  []*PreConnectHookType `json:"preconnect,omitempty"`
+	InitScripts []string              `json:"initscripts,omitempty"`
+
+	// idle commands (no output or input) are killed after this many hours, see remote/idlekill.go
+	IdleKillHours int `json:"idlekillhours,omitempty"`
+
+	// only for sudo remotes, credential lifetime (0 is no limit), see remote/sudoauth.go
+	SudoCredMinutes int `json:"sudocredminutes,omitempty"`
+
+	// only for serial remotes
+	SerialOpts *SerialOptsType `json:"serialopts,omitempty"`
+}
+
+// line settings for serial remotes (the device path is the remote's host), see remote/serial.go
+type SerialOptsType struct {
+	BaudRate int    `json:"baudrate"`
+	Parity   string `json:"parity"`
+	DataBits int    `json:"databits"`
+	StopBits int    `json:"stopbits"`
+}
+
+const (
+	PreConnectHook_WakeOnLan = "wol"
+	PreConnectHook_Cmd       = "cmd"
+	PreConnectHook_WaitPort  = "waitport"
+)
+
+// hooks run (in order) on the local machine before connecting to an ssh remote
+type PreConnectHookType struct {
+	HookType      string `json:"hooktype"`
+	MacAddr       string `json:"macaddr,omitempty"`       // wol
+	BroadcastAddr string `json:"broadcastaddr,omitempty"` // wol (defaults to 255.255.255.255:9)
+	Command       string `json:"command,omitempty"`       // cmd
+	Host          string `json:"host,omitempty"`          // waitport (defaults to the remote's ssh host)
+	Port          int    `json:"port,omitempty"`          // waitport (defaults to the remote's ssh port)
+	Timeout       int    `json:"timeout,omitempty"`       // in seconds
 }
 
 type OpenAIOptsType struct {
@@ -759,34 +906,63 @@ const (
 )
 
 type RemoteRuntimeState struct {
-	RemoteType            string            `json:"remotetype"`
-	RemoteId              string            `json:"remoteid"`
-	RemoteAlias           string            `json:"remotealias,omitempty"`
-	RemoteCanonicalName   string            `json:"remotecanonicalname"`
-	RemoteVars            map[string]string `json:"remotevars"`
-	Status                string            `json:"status"`
-	ConnectTimeout        int               `json:"connecttimeout,omitempty"`
-	CountdownActive       bool              `json:"countdownactive"`
-	ErrorStr              string            `json:"errorstr,omitempty"`
-	InstallStatus         string            `json:"installstatus"`
-	InstallErrorStr       string            `json:"installerrorstr,omitempty"`
-	NeedsWaveshellUpgrade bool              `json:"needswaveshellupgrade,omitempty"`
-	NoInitPk              bool              `json:"noinitpk,omitempty"`
-	AuthType              string            `json:"authtype,omitempty"`
-	ConnectMode           string            `json:"connectmode"`
-	AutoInstall           bool              `json:"autoinstall"`
-	Archived              bool              `json:"archived,omitempty"`
-	RemoteIdx             int64             `json:"remoteidx"`
-	SSHConfigSrc          string            `json:"sshconfigsrc"`
-	UName                 string            `json:"uname"`
-	WaveshellVersion      string            `json:"waveshellversion"`
-	WaitingForPassword    bool              `json:"waitingforpassword,omitempty"`
-	Local                 bool              `json:"local,omitempty"`
-	IsSudo                bool              `json:"issudo,omitempty"`
-	RemoteOpts            *RemoteOptsType   `json:"remoteopts,omitempty"`
-	CanComplete           bool              `json:"cancomplete,omitempty"`
-	ShellPref             string            `json:"shellpref,omitempty"`
-	DefaultShellType      string            `json:"defaultshelltype,omitempty"`
+	RemoteType            string                `json:"remotetype"`
+	RemoteId              string                `json:"remoteid"`
+	RemoteAlias           string                `json:"remotealias,omitempty"`
+	RemoteCanonicalName   string                `json:"remotecanonicalname"`
+	RemoteVars            map[string]string     `json:"remotevars"`
+	Status                string                `json:"status"`
+	ConnectTimeout        int                   `json:"connecttimeout,omitempty"`
+	CountdownActive       bool                  `json:"countdownactive"`
+	ErrorStr              string                `json:"errorstr,omitempty"`
+	InstallStatus         string                `json:"installstatus"`
+	InstallErrorStr       string                `json:"installerrorstr,omitempty"`
+	NeedsWaveshellUpgrade bool                  `json:"needswaveshellupgrade,omitempty"`
+	NoInitPk              bool                  `json:"noinitpk,omitempty"`
+	AuthType              string                `json:"authtype,omitempty"`
+	ConnectMode           string                `json:"connectmode"`
+	AutoInstall           bool                  `json:"autoinstall"`
+	Archived              bool                  `json:"archived,omitempty"`
+	RemoteIdx             int64                 `json:"remoteidx"`
+	GroupId               string                `json:"groupid,omitempty"`
+	SSHConfigSrc          string                `json:"sshconfigsrc"`
+	UName                 string                `json:"uname"`
+	WaveshellVersion      string                `json:"waveshellversion"`
+	WaitingForPassword    bool                  `json:"waitingforpassword,omitempty"`
+	Local                 bool                  `json:"local,omitempty"`
+	IsSudo                bool                  `json:"issudo,omitempty"`
+	RemoteOpts            *RemoteOptsType       `json:"remoteopts,omitempty"`
+	CanComplete           bool                  `json:"cancomplete,omitempty"`
+	ShellPref             string                `json:"shellpref,omitempty"`
+	DefaultShellType      string                `json:"defaultshelltype,omitempty"`
+	PolicyMeta            *RemotePolicyMetaType `json:"policymeta,omitempty"`
+	HostHealth            *RemoteHostHealthType `json:"hosthealth,omitempty"`
+	PreConnectStatus      string                `json:"preconnectstatus,omitempty"`
+	SudoAuth              *RemoteSudoAuthType   `json:"sudoauth,omitempty"`
+}
+
+const (
+	SudoAuthStatus_None    = "none"    // not connected (or never authenticated)
+	SudoAuthStatus_Active  = "active"  // connected, running as root
+	SudoAuthStatus_Expired = "expired" // the credential lifetime ran out, reconnects (re-prompts) on the next command
+	SudoAuthStatus_Dropped = "dropped" // privileges were dropped (/sudo:drop), must reconnect manually
+)
+
+// credential state of a sudo remote, see remote/sudoauth.go
+type RemoteSudoAuthType struct {
+	Status      string `json:"status"`
+	AuthTs      int64  `json:"authts,omitempty"`
+	ExpireTs    int64  `json:"expirets,omitempty"` // 0 if the credentials do not expire
+	CredMinutes int    `json:"credminutes,omitempty"`
+}
+
+// quick host facts for connected remotes (polled on a slow cadence, see remote.HostStatsInterval)
+type RemoteHostHealthType struct {
+	DiskFree  int64     `json:"diskfree"`
+	DiskTotal int64     `json:"disktotal"`
+	LoadAvg   []float64 `json:"loadavg,omitempty"`
+	Uptime    int64     `json:"uptime"` // seconds
+	UpdateTs  int64     `json:"updatets"`
 }
 
 func (state RemoteRuntimeState) IsConnected() bool {
@@ -835,6 +1011,7 @@ type RemoteType struct {
 	LastConnectTs       int64           `json:"lastconnectts"`
 	RemoteIdx           int64           `json:"remoteidx"`
 	Archived            bool            `json:"archived"`
+	GroupId             string          `json:"groupid,omitempty"` // remote_group, "" if not in a group
 
 	// SSH fields
 	Local        bool              `json:"local"`
@@ -859,6 +1036,15 @@ func (r *RemoteType) IsSudo() bool {
 	return r.SSHOpts != nil && r.SSHOpts.IsSudo
 }
 
+// WSL remotes exec wsl.exe (the distro is RemoteHost) instead of connecting over ssh
+func (r *RemoteType) IsWSL() bool {
+	return r.RemoteType == RemoteTypeWSL
+}
+
+func (r *RemoteType) IsSerial() bool {
+	return r.RemoteType == RemoteTypeSerial
+}
+
 func (r *RemoteType) GetName() string {
 	if r.RemoteAlias != "" {
 		return r.RemoteAlias
@@ -886,6 +1072,7 @@ func (r *RemoteType) ToMap() map[string]interface{} {
 	rtn["sshconfigsrc"] = r.SSHConfigSrc
 	rtn["openaiopts"] = quickJson(r.OpenAIOpts)
 	rtn["shellpref"] = r.ShellPref
+	rtn["groupid"] = r.GroupId
 	return rtn
 }
 
@@ -908,6 +1095,7 @@ func (r *RemoteType) FromMap(m map[string]interface{}) bool {
 	quickSetStr(&r.SSHConfigSrc, m, "sshconfigsrc")
 	quickSetJson(&r.OpenAIOpts, m, "openaiopts")
 	quickSetStr(&r.ShellPref, m, "shellpref")
+	quickSetStr(&r.GroupId, m, "groupid")
 	return true
 }
 
@@ -931,6 +1119,7 @@ type CmdType struct {
 	RunOut       []packet.PacketType  `json:"runout,omitempty"`
 	RtnState     bool                 `json:"rtnstate,omitempty"`
 	RtnStatePtr  packet.ShellStatePtr `json:"rtnstateptr,omitempty"`
+	Usage        *packet.CmdUsageType `json:"usage,omitempty"`     // nil if not reported (older waveshell)
 	Remove       bool                 `json:"remove,omitempty"`    // not persisted to DB
 	Restarted    bool                 `json:"restarted,omitempty"` // not persisted to DB
 }
@@ -964,6 +1153,7 @@ func (cmd *CmdType) ToMap() map[string]interface{} {
 	rtn["rtnstate"] = cmd.RtnState
 	rtn["rtnbasehash"] = cmd.RtnStatePtr.BaseHash
 	rtn["rtndiffhasharr"] = quickJsonArr(cmd.RtnStatePtr.DiffHashArr)
+	rtn["usage"] = quickNullableJson(cmd.Usage)
 	return rtn
 }
 
@@ -991,6 +1181,7 @@ func (cmd *CmdType) FromMap(m map[string]interface{}) bool {
 	quickSetBool(&cmd.RtnState, m, "rtnstate")
 	quickSetStr(&cmd.RtnStatePtr.BaseHash, m, "rtnbasehash")
 	quickSetJsonArr(&cmd.RtnStatePtr.DiffHashArr, m, "rtndiffhasharr")
+	quickSetNullableJson(&cmd.Usage, m, "usage")
 	return true
 }
 
@@ -1013,6 +1204,9 @@ func makeNewLineCmd(screenId string, userId string, lineId string, renderer stri
 		lineState = make(map[string]any)
 	}
 	rtn.LineState = lineState
+	if _, ok := lineState[LineState_EphemeralExpireTs]; ok {
+		rtn.Ephemeral = true
+	}
 	return rtn
 }
 
@@ -1030,6 +1224,15 @@ func makeNewLineText(screenId string, userId string, text string) *LineType {
 	return rtn
 }
 
+func makeNewLineSystem(screenId string, userId string, text string, lineState map[string]any) *LineType {
+	rtn := makeNewLineText(screenId, userId, text)
+	rtn.LineType = LineTypeSystem
+	if lineState != nil {
+		rtn.LineState = lineState
+	}
+	return rtn
+}
+
 func makeNewLineOpenAI(screenId string, userId string, lineId string) *LineType {
 	rtn := &LineType{}
 	rtn.ScreenId = screenId
@@ -1053,6 +1256,15 @@ func AddCommentLine(ctx context.Context, screenId string, userId string, comment
 	return rtnLine, nil
 }
 
+func AddSystemLine(ctx context.Context, screenId string, userId string, text string, lineState map[string]any) (*LineType, error) {
+	rtnLine := makeNewLineSystem(screenId, userId, text, lineState)
+	err := InsertLine(ctx, rtnLine, nil)
+	if err != nil {
+		return nil, err
+	}
+	return rtnLine, nil
+}
+
 func AddOpenAILine(ctx context.Context, screenId string, userId string, cmd *CmdType) (*LineType, error) {
 	rtnLine := makeNewLineOpenAI(screenId, userId, cmd.LineId)
 	err := InsertLine(ctx, rtnLine, cmd)
@@ -1213,9 +1425,37 @@ func SetClientOpts(ctx context.Context, clientOpts ClientOptsType) error {
 		tx.Exec(query, quickJson(clientOpts))
 		return nil
 	})
+	if txErr == nil {
+		runClientOptsHooks(clientOpts)
+	}
 	return txErr
 }
 
+var clientOptsHooksLock = &sync.Mutex{}
+var clientOptsHooks []func(clientOpts ClientOptsType)
+
+// registers a fn to be called with the client opts on startup (InitClientOpts) and after every SetClientOpts.
+// used to apply settings that live in memory (live reconfiguration).
+func RegisterClientOptsHook(fn func(clientOpts ClientOptsType)) {
+	clientOptsHooksLock.Lock()
+	defer clientOptsHooksLock.Unlock()
+	clientOptsHooks = append(clientOptsHooks, fn)
+}
+
+func runClientOptsHooks(clientOpts ClientOptsType) {
+	clientOptsHooksLock.Lock()
+	hooks := clientOptsHooks
+	clientOptsHooksLock.Unlock()
+	for _, hookFn := range hooks {
+		hookFn(clientOpts)
+	}
+}
+
+// called on startup, after that the hooks follow SetClientOpts
+func InitClientOpts(cdata *ClientData) {
+	runClientOptsHooks(cdata.ClientOpts)
+}
+
 func SetReleaseInfo(ctx context.Context, releaseInfo ReleaseInfoType) error {
 	txErr := WithTx(ctx, func(tx *TxWrap) error {
 		query := `UPDATE client SET releaseinfo = ?`
@@ -4,13 +4,22 @@
 package sstore
 
 import (
+	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/wavetermdev/waveterm/waveshell/pkg/cirfile"
 	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/blockstore"
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
 )
 
@@ -186,3 +195,149 @@ func processMigration13Chunk(ctx context.Context, mchunk []cmdMigration13Type) e
 	}
 	return nil
 }
+
+const legacyPtyOutSuffix = ".ptyout.cf"
+const legacyPtyOutArchiveSuffix = ".gz"
+
+// moves the legacy cirfile ptyout files (screens/[screenid]/[lineid].ptyout.cf, and their gzipped archives)
+// into the blockstore.  files are removed once they are copied, so after the first run this is just a scan.
+func MigratePtyOutFiles(ctx context.Context) error {
+	startTime := time.Now()
+	screensDir := scbase.GetScreensDir()
+	entries, err := os.ReadDir(screensDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading screens dir: %w", err)
+	}
+	var numMigrated, numErrors int
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		screenId := entry.Name()
+		if _, err := uuid.Parse(screenId); err != nil {
+			continue
+		}
+		nm, ne := migrateScreenPtyOutFiles(ctx, screenId, filepath.Join(screensDir, screenId))
+		numMigrated += nm
+		numErrors += ne
+	}
+	if numMigrated == 0 && numErrors == 0 {
+		return nil
+	}
+	err = blockstore.FlushCache(ctx)
+	if err != nil {
+		return fmt.Errorf("flushing migrated ptyout files: %w", err)
+	}
+	log.Printf("[db] ptyout migration done: %v (%d files, %d errors)\n", time.Since(startTime), numMigrated, numErrors)
+	return nil
+}
+
+// returns (num-migrated, num-errors)
+func migrateScreenPtyOutFiles(ctx context.Context, screenId string, screenDir string) (int, int) {
+	var numMigrated, numErrors int
+	// restore archived files first (the restored file is migrated below)
+	archives, _ := filepath.Glob(filepath.Join(screenDir, "*"+legacyPtyOutSuffix+legacyPtyOutArchiveSuffix))
+	for _, archiveFileName := range archives {
+		err := restoreLegacyPtyOutArchive(archiveFileName)
+		if err != nil {
+			log.Printf("error restoring archived ptyout file %s: %v\n", archiveFileName, err)
+			numErrors++
+		}
+	}
+	fileNames, _ := filepath.Glob(filepath.Join(screenDir, "*"+legacyPtyOutSuffix))
+	for _, fileName := range fileNames {
+		lineId := strings.TrimSuffix(filepath.Base(fileName), legacyPtyOutSuffix)
+		if _, err := uuid.Parse(lineId); err != nil {
+			continue
+		}
+		err := migratePtyOutFile(ctx, screenId, lineId, fileName)
+		if err != nil {
+			log.Printf("error migrating ptyout file %s: %v\n", fileName, err)
+			numErrors++
+			continue
+		}
+		numMigrated++
+	}
+	return numMigrated, numErrors
+}
+
+func migratePtyOutFile(ctx context.Context, screenId string, lineId string, fileName string) error {
+	stat, err := cirfile.StatCirFile(ctx, fileName)
+	if err != nil {
+		return err
+	}
+	f, err := cirfile.OpenCirFile(fileName)
+	if err != nil {
+		return err
+	}
+	offset, data, err := f.ReadAll(ctx)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	unlockFn := lockPtyOutLine(screenId, lineId)
+	err = createPtyOutFile(ctx, screenId, lineId, stat.MaxSize, false)
+	if err == nil {
+		err = writePtyOutData(ctx, screenId, lineId, data, offset)
+	}
+	unlockFn()
+	if err != nil {
+		return err
+	}
+	err = blockstore.FlushCache(ctx)
+	if err != nil {
+		return err
+	}
+	return os.Remove(fileName)
+}
+
+func restoreLegacyPtyOutArchive(archiveFileName string) error {
+	fileName := strings.TrimSuffix(archiveFileName, legacyPtyOutArchiveSuffix)
+	if _, err := os.Stat(fileName); err == nil {
+		// the archive was restored but not removed
+		return os.Remove(archiveFileName)
+	}
+	err := copyFileWithTemp(archiveFileName, fileName, func(w io.Writer, r io.Reader) error {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		_, err = io.Copy(w, zr)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return os.Remove(archiveFileName)
+}
+
+// writes dstFileName atomically (via a temp file + rename) using copyFn to transform the contents of srcFileName
+func copyFileWithTemp(srcFileName string, dstFileName string, copyFn func(io.Writer, io.Reader) error) error {
+	srcFd, err := os.Open(srcFileName)
+	if err != nil {
+		return err
+	}
+	defer srcFd.Close()
+	tempFd, err := os.CreateTemp(filepath.Dir(dstFileName), filepath.Base(dstFileName)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tempFileName := tempFd.Name()
+	err = copyFn(tempFd, srcFd)
+	closeErr := tempFd.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempFileName, dstFileName)
+	}
+	if err != nil {
+		os.Remove(tempFileName)
+		return err
+	}
+	return nil
+}
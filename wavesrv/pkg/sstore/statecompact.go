@@ -0,0 +1,199 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/wavetermdev/waveterm/waveshell/pkg/packet"
+)
+
+// remote instance states are stored as a state_base plus a chain of state_diffs (GetFullState replays
+// every diff).  CompactStates rebases long chains into new state_base rows, and removes the state_base
+// and state_diff rows that are no longer referenced by a remote instance or a cmd.
+
+const DefaultStateCompactMaxDiffs = 4
+
+// unreferenced states newer than this are kept, they can be held by in-flight commands
+// (or a remote's current state) before they are referenced in the db.
+const StateGCMinAge = 24 * time.Hour
+
+type StateCompactStats struct {
+	RIsRebased   int
+	DiffsRemoved int
+	BasesRemoved int
+	BytesRemoved int64
+}
+
+type riStatePtr struct {
+	RIId        string
+	BaseHash    string
+	DiffHashArr []string
+}
+
+// rebases remote instances whose state has more than maxDiffs diffs.  returns the number of rebased remote instances.
+func RebaseRemoteInstanceStates(ctx context.Context, maxDiffs int) (int, error) {
+	riPtrs, err := WithTxRtn(ctx, func(tx *TxWrap) ([]riStatePtr, error) {
+		var rtn []riStatePtr
+		query := `SELECT riid, statebasehash, statediffhasharr FROM remote_instance WHERE json_array_length(statediffhasharr) > ?`
+		for _, m := range tx.SelectMaps(query, maxDiffs) {
+			var ptr riStatePtr
+			quickSetStr(&ptr.RIId, m, "riid")
+			quickSetStr(&ptr.BaseHash, m, "statebasehash")
+			quickSetJsonArr(&ptr.DiffHashArr, m, "statediffhasharr")
+			rtn = append(rtn, ptr)
+		}
+		return rtn, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	var numRebased int
+	for _, ptr := range riPtrs {
+		if ctx.Err() != nil {
+			return numRebased, ctx.Err()
+		}
+		rebased, err := rebaseRemoteInstanceState(ctx, ptr)
+		if err != nil {
+			return numRebased, fmt.Errorf("rebasing remote instance %s: %w", ptr.RIId, err)
+		}
+		if rebased {
+			numRebased++
+		}
+	}
+	return numRebased, nil
+}
+
+// the remote instance is only updated if its state did not change while the new base was computed
+func rebaseRemoteInstanceState(ctx context.Context, ptr riStatePtr) (bool, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (bool, error) {
+		fullState, err := GetFullState(tx.Context(), packet.ShellStatePtr{BaseHash: ptr.BaseHash, DiffHashArr: ptr.DiffHashArr})
+		if err != nil {
+			return false, err
+		}
+		err = StoreStateBase(tx.Context(), fullState)
+		if err != nil {
+			return false, err
+		}
+		query := `UPDATE remote_instance SET statebasehash = ?, statediffhasharr = ?
+		          WHERE riid = ? AND statebasehash = ? AND statediffhasharr = ?`
+		result := tx.Exec(query, fullState.GetHashVal(false), quickJsonArr([]string{}), ptr.RIId, ptr.BaseHash, quickJsonArr(ptr.DiffHashArr))
+		numRows, _ := result.RowsAffected()
+		return numRows > 0, nil
+	})
+}
+
+type StateGCReport struct {
+	DryRun    bool
+	NumDiffs  int
+	NumBases  int
+	DiffBytes int64
+	BaseBytes int64
+}
+
+func (r *StateGCReport) ReclaimableBytes() int64 {
+	return r.DiffBytes + r.BaseBytes
+}
+
+// mark and sweep.  removes state_base and state_diff rows that are not referenced by a remote instance, a cmd,
+// a referenced state_diff, or keepHashes, and are older than minAge.  with dryRun, nothing is removed (the report
+// has the rows and bytes that would be removed).
+func GCStates(ctx context.Context, minAge time.Duration, keepHashes []string, dryRun bool) (*StateGCReport, error) {
+	cutoffTs := time.Now().Add(-minAge).UnixMilli()
+	return WithTxRtn(ctx, func(tx *TxWrap) (*StateGCReport, error) {
+		rtn := &StateGCReport{DryRun: dryRun}
+		refs := make(map[string]bool)
+		for _, hash := range keepHashes {
+			refs[hash] = true
+		}
+		refQueries := []string{
+			`SELECT statebasehash FROM remote_instance`,
+			`SELECT j.value FROM remote_instance ri, json_each(ri.statediffhasharr) j`,
+			`SELECT statebasehash FROM cmd`,
+			`SELECT j.value FROM cmd c, json_each(c.statediffhasharr) j`,
+			`SELECT rtnbasehash FROM cmd`,
+			`SELECT j.value FROM cmd c, json_each(c.rtndiffhasharr) j`,
+		}
+		for _, query := range refQueries {
+			for _, hash := range tx.SelectStrings(query) {
+				refs[hash] = true
+			}
+		}
+		// a kept diff needs its base and the diffs it was made against (diffhasharr is the full chain)
+		removeDiffs := make(map[string]int64)
+		query := `SELECT diffhash, ts, basehash, diffhasharr, length(data) AS datalen FROM state_diff`
+		for _, m := range tx.SelectMaps(query) {
+			var diffHash, baseHash string
+			var ts, dataLen int64
+			var diffHashArr []string
+			quickSetStr(&diffHash, m, "diffhash")
+			quickSetInt64(&ts, m, "ts")
+			quickSetStr(&baseHash, m, "basehash")
+			quickSetJsonArr(&diffHashArr, m, "diffhasharr")
+			quickSetInt64(&dataLen, m, "datalen")
+			if !refs[diffHash] && ts < cutoffTs {
+				removeDiffs[diffHash] = dataLen
+				continue
+			}
+			refs[baseHash] = true
+			for _, hash := range diffHashArr {
+				refs[hash] = true
+			}
+		}
+		for diffHash, dataLen := range removeDiffs {
+			if refs[diffHash] {
+				// referenced by a kept diff
+				continue
+			}
+			if !dryRun {
+				query = `DELETE FROM state_diff WHERE diffhash = ?`
+				tx.Exec(query, diffHash)
+			}
+			rtn.NumDiffs++
+			rtn.DiffBytes += dataLen
+		}
+		query = `SELECT basehash, length(data) AS datalen FROM state_base WHERE ts < ?`
+		for _, m := range tx.SelectMaps(query, cutoffTs) {
+			var baseHash string
+			var dataLen int64
+			quickSetStr(&baseHash, m, "basehash")
+			quickSetInt64(&dataLen, m, "datalen")
+			if refs[baseHash] {
+				continue
+			}
+			if !dryRun {
+				query = `DELETE FROM state_base WHERE basehash = ?`
+				tx.Exec(query, baseHash)
+			}
+			rtn.NumBases++
+			rtn.BaseBytes += dataLen
+		}
+		return rtn, nil
+	})
+}
+
+// reports the states that GCStates would remove (and the space they use)
+func GetReclaimableStates(ctx context.Context, keepHashes []string) (*StateGCReport, error) {
+	return GCStates(ctx, StateGCMinAge, keepHashes, true)
+}
+
+// rebases long diff chains and then garbage collects the unreferenced states
+func CompactStates(ctx context.Context, keepHashes []string) (*StateCompactStats, error) {
+	rtn := &StateCompactStats{}
+	var err error
+	rtn.RIsRebased, err = RebaseRemoteInstanceStates(ctx, DefaultStateCompactMaxDiffs)
+	if err != nil {
+		return rtn, err
+	}
+	report, err := GCStates(ctx, StateGCMinAge, keepHashes, false)
+	if err != nil {
+		return rtn, err
+	}
+	rtn.DiffsRemoved = report.NumDiffs
+	rtn.BasesRemoved = report.NumBases
+	rtn.BytesRemoved = report.ReclaimableBytes()
+	return rtn, nil
+}
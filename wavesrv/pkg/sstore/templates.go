@@ -0,0 +1,99 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+)
+
+// workspace templates.  a template is a named set of screens (name, tab color/icon, remote, cwd and startup
+// commands) that can be instantiated as a new session, see cmdrunner/templates.go.
+
+const MaxTemplateScreens = 20
+
+type TemplateScreenType struct {
+	Name        string   `json:"name"`
+	TabColor    string   `json:"tabcolor,omitempty"`
+	TabIcon     string   `json:"tabicon,omitempty"`
+	Remote      string   `json:"remote,omitempty"` // remote reference (as used by /connect), empty for local
+	Cwd         string   `json:"cwd,omitempty"`
+	StartupCmds []string `json:"startupcmds,omitempty"`
+}
+
+type WorkspaceTemplateType struct {
+	TemplateId  string               `json:"templateid"`
+	Name        string               `json:"name"`
+	Description string               `json:"description"`
+	Screens     []TemplateScreenType `json:"screens"`
+	CreatedTs   int64                `json:"createdts"`
+}
+
+func (t *WorkspaceTemplateType) ToMap() map[string]interface{} {
+	rtn := make(map[string]interface{})
+	rtn["templateid"] = t.TemplateId
+	rtn["name"] = t.Name
+	rtn["description"] = t.Description
+	rtn["screens"] = quickJsonArr(t.Screens)
+	rtn["createdts"] = t.CreatedTs
+	return rtn
+}
+
+func (t *WorkspaceTemplateType) FromMap(m map[string]interface{}) bool {
+	quickSetStr(&t.TemplateId, m, "templateid")
+	quickSetStr(&t.Name, m, "name")
+	quickSetStr(&t.Description, m, "description")
+	quickSetJsonArr(&t.Screens, m, "screens")
+	quickSetInt64(&t.CreatedTs, m, "createdts")
+	return true
+}
+
+func GetTemplates(ctx context.Context) ([]*WorkspaceTemplateType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]*WorkspaceTemplateType, error) {
+		query := `SELECT * FROM template ORDER BY name`
+		return dbutil.SelectMapsGen[*WorkspaceTemplateType](tx, query), nil
+	})
+}
+
+// arg is a template name or templateid, returns nil if not found
+func GetTemplateByArg(ctx context.Context, arg string) (*WorkspaceTemplateType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*WorkspaceTemplateType, error) {
+		query := `SELECT * FROM template WHERE name = ? OR templateid = ?`
+		return dbutil.GetMapGen[*WorkspaceTemplateType](tx, query, arg, arg), nil
+	})
+}
+
+// replaces an existing template with the same name (keeps its templateid)
+func SaveTemplate(ctx context.Context, template *WorkspaceTemplateType) error {
+	if len(template.Screens) == 0 {
+		return fmt.Errorf("template has no screens")
+	}
+	if len(template.Screens) > MaxTemplateScreens {
+		return fmt.Errorf("too many screens in template (max %d)", MaxTemplateScreens)
+	}
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT templateid FROM template WHERE name = ?`
+		existingId := tx.GetString(query, template.Name)
+		if existingId != "" {
+			template.TemplateId = existingId
+			query = `UPDATE template SET description = ?, screens = ?, createdts = ? WHERE templateid = ?`
+			tx.Exec(query, template.Description, quickJsonArr(template.Screens), template.CreatedTs, existingId)
+			return nil
+		}
+		query = `INSERT INTO template ( templateid, name, description, screens, createdts)
+		                       VALUES (:templateid,:name,:description,:screens,:createdts)`
+		tx.NamedExec(query, template.ToMap())
+		return nil
+	})
+}
+
+func DeleteTemplate(ctx context.Context, templateId string) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `DELETE FROM template WHERE templateid = ?`
+		tx.Exec(query, templateId)
+		return nil
+	})
+}
@@ -0,0 +1,204 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// screen transcripts are a linear, plain text version of a screen for assistive technologies (the frontend's
+// reading mode).  each line is an entry with a spoken-style annotation ("command 3, failed with exit code 1,
+// took 2.5 seconds") and a condensed output: ansi stripped, blank runs collapsed, and only the first and last
+// lines of long output.  the transcript is returned by /api/screen-transcript and kept up to date with
+// "transcript" updates while reading mode is on (see cmdrunner/transcript.go).
+
+const TranscriptHeadLines = 5
+const TranscriptTailLines = 15
+const TranscriptMaxLineLen = 500
+
+type TranscriptEntryType struct {
+	LineId       string   `json:"lineid"`
+	LineNum      int64    `json:"linenum"`
+	LineType     string   `json:"linetype"`
+	Ts           int64    `json:"ts"`
+	CmdStr       string   `json:"cmdstr,omitempty"`
+	Text         string   `json:"text,omitempty"`
+	Status       string   `json:"status,omitempty"`
+	ExitCode     int      `json:"exitcode,omitempty"`
+	DurationMs   int      `json:"durationms,omitempty"`
+	Annotation   string   `json:"annotation"`
+	Output       []string `json:"output,omitempty"`
+	OutputLines  int      `json:"outputlines"`            // total number of output lines (after condensing blank runs)
+	OmittedLines int      `json:"omittedlines,omitempty"` // lines left out of Output (between the head and tail)
+}
+
+type TranscriptType struct {
+	ScreenId   string                 `json:"screenid"`
+	ScreenName string                 `json:"screenname"`
+	Entries    []*TranscriptEntryType `json:"entries"`
+}
+
+// Reset replaces the whole transcript, otherwise the entries are added or replace the entries with the same lineid
+type TranscriptUpdateType struct {
+	ScreenId      string                 `json:"screenid"`
+	Reset         bool                   `json:"reset,omitempty"`
+	Entries       []*TranscriptEntryType `json:"entries,omitempty"`
+	RemoveLineIds []string               `json:"removelineids,omitempty"`
+}
+
+func (TranscriptUpdateType) GetType() string {
+	return "transcript"
+}
+
+func formatTranscriptDuration(durationMs int) string {
+	if durationMs < 1000 {
+		return fmt.Sprintf("%d milliseconds", durationMs)
+	}
+	if durationMs < 60*1000 {
+		return fmt.Sprintf("%.1f seconds", float64(durationMs)/1000)
+	}
+	return fmt.Sprintf("%d minutes %d seconds", durationMs/60000, (durationMs%60000)/1000)
+}
+
+func makeTranscriptAnnotation(entry *TranscriptEntryType, binaryOut bool) string {
+	if entry.LineType != LineTypeCmd {
+		return fmt.Sprintf("line %d, message", entry.LineNum)
+	}
+	var parts []string
+	parts = append(parts, fmt.Sprintf("command %d", entry.LineNum))
+	switch entry.Status {
+	case CmdStatusRunning, CmdStatusDetached:
+		parts = append(parts, "running")
+	case CmdStatusDone:
+		if entry.ExitCode == 0 {
+			parts = append(parts, "succeeded")
+		} else {
+			parts = append(parts, fmt.Sprintf("failed with exit code %d", entry.ExitCode))
+		}
+	case CmdStatusHangup:
+		parts = append(parts, "disconnected")
+	case CmdStatusError:
+		parts = append(parts, "error")
+	default:
+		parts = append(parts, "status unknown")
+	}
+	if entry.DurationMs > 0 && entry.Status == CmdStatusDone {
+		parts = append(parts, "took "+formatTranscriptDuration(entry.DurationMs))
+	}
+	switch {
+	case binaryOut:
+		parts = append(parts, "binary output")
+	case entry.OutputLines == 0:
+		parts = append(parts, "no output")
+	case entry.OutputLines == 1:
+		parts = append(parts, "1 line of output")
+	default:
+		parts = append(parts, fmt.Sprintf("%d lines of output", entry.OutputLines))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// collapses runs of blank lines to one, drops leading/trailing blank lines, and keeps the first
+// TranscriptHeadLines and last TranscriptTailLines lines.  returns the lines, the total, and the number omitted.
+func CondenseOutputLines(lines []string) ([]string, int, int) {
+	var condensed []string
+	lastBlank := true // drops leading blank lines
+	for _, line := range lines {
+		isBlank := strings.TrimSpace(line) == ""
+		if isBlank && lastBlank {
+			continue
+		}
+		lastBlank = isBlank
+		if len(line) > TranscriptMaxLineLen {
+			line = line[:TranscriptMaxLineLen] + "..."
+		}
+		condensed = append(condensed, line)
+	}
+	if len(condensed) > 0 && strings.TrimSpace(condensed[len(condensed)-1]) == "" {
+		condensed = condensed[:len(condensed)-1]
+	}
+	total := len(condensed)
+	if total <= TranscriptHeadLines+TranscriptTailLines {
+		return condensed, total, 0
+	}
+	rtn := make([]string, 0, TranscriptHeadLines+TranscriptTailLines)
+	rtn = append(rtn, condensed[:TranscriptHeadLines]...)
+	rtn = append(rtn, condensed[total-TranscriptTailLines:]...)
+	return rtn, total, total - TranscriptHeadLines - TranscriptTailLines
+}
+
+func makeTranscriptEntry(ctx context.Context, line *LineType, cmd *CmdType) (*TranscriptEntryType, error) {
+	entry := &TranscriptEntryType{
+		LineId:   line.LineId,
+		LineNum:  line.LineNum,
+		LineType: line.LineType,
+		Ts:       line.Ts,
+	}
+	if cmd == nil {
+		entry.Text = line.Text
+		entry.Annotation = makeTranscriptAnnotation(entry, false)
+		return entry, nil
+	}
+	entry.CmdStr = cmd.CmdStr
+	entry.Status = cmd.Status
+	entry.ExitCode = cmd.ExitCode
+	entry.DurationMs = cmd.DurationMs
+	binaryOut, _ := line.LineState[LineState_BinaryOut].(bool)
+	if !binaryOut {
+		_, data, err := ReadFullPtyOutFile(ctx, line.ScreenId, line.LineId)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("cannot read output of line %d: %w", line.LineNum, err)
+		}
+		data, _ = DecodeToUtf8(data, GetLineEncoding(line))
+		entry.Output, entry.OutputLines, entry.OmittedLines = CondenseOutputLines(StripAnsiLines(data))
+	}
+	entry.Annotation = makeTranscriptAnnotation(entry, binaryOut)
+	return entry, nil
+}
+
+// returns nil if the line does not exist (or is archived)
+func GetLineTranscriptEntry(ctx context.Context, screenId string, lineId string) (*TranscriptEntryType, error) {
+	line, cmd, err := GetLineCmdByLineId(ctx, screenId, lineId)
+	if err != nil {
+		return nil, err
+	}
+	if line == nil || line.Archived {
+		return nil, nil
+	}
+	return makeTranscriptEntry(ctx, line, cmd)
+}
+
+func GetScreenTranscript(ctx context.Context, screenId string) (*TranscriptType, error) {
+	screen, err := GetScreenById(ctx, screenId)
+	if err != nil {
+		return nil, err
+	}
+	if screen == nil {
+		return nil, fmt.Errorf("screen not found")
+	}
+	screenLines, err := GetScreenLinesById(ctx, screenId)
+	if err != nil {
+		return nil, err
+	}
+	cmdMap := make(map[string]*CmdType)
+	for _, cmd := range screenLines.Cmds {
+		cmdMap[cmd.LineId] = cmd
+	}
+	rtn := &TranscriptType{ScreenId: screenId, ScreenName: screen.Name, Entries: []*TranscriptEntryType{}}
+	for _, line := range screenLines.Lines {
+		if line.Archived {
+			continue
+		}
+		entry, err := makeTranscriptEntry(ctx, line, cmdMap[line.LineId])
+		if err != nil {
+			return nil, err
+		}
+		rtn.Entries = append(rtn.Entries, entry)
+	}
+	return rtn, nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+)
+
+// history of file transfers (/copyfile).  a transfer that failed (or was interrupted by a restart)
+// can be resumed, the data already read from the source is staged in the blockstore
+// (blockid = transferid, see remote.CopyBetweenRemotes).
+
+const (
+	TransferStatus_Running      = "running"
+	TransferStatus_Done         = "done"
+	TransferStatus_Error        = "error"
+	TransferStatus_VerifyFailed = "verifyfailed"
+)
+
+const MaxTransferErrorLen = 1000
+const DefaultTransferHistoryLimit = 50
+
+type TransferType struct {
+	TransferId  string `json:"transferid"`
+	SrcRemoteId string `json:"srcremoteid"`
+	SrcPath     string `json:"srcpath"`
+	DstRemoteId string `json:"dstremoteid"`
+	DstPath     string `json:"dstpath"`
+	Status      string `json:"status"`
+	TotalBytes  int64  `json:"totalbytes"`
+	BytesDone   int64  `json:"bytesdone"`
+	StartOffset int64  `json:"startoffset"`
+	Sha256      string `json:"sha256,omitempty"`
+	ErrorStr    string `json:"errorstr,omitempty"`
+	StartTs     int64  `json:"startts"`
+	EndTs       int64  `json:"endts,omitempty"`
+}
+
+func (TransferType) UseDBMap() {}
+
+func (TransferType) GetType() string {
+	return "transfer"
+}
+
+// sent in response to /transfer:history
+type TransferHistoryType struct {
+	Transfers []*TransferType `json:"transfers"`
+}
+
+func (TransferHistoryType) GetType() string {
+	return "transferhistory"
+}
+
+func (t *TransferType) IsResumable() bool {
+	return t.Status == TransferStatus_Error || t.Status == TransferStatus_Running
+}
+
+func InsertTransfer(ctx context.Context, transfer *TransferType) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `INSERT INTO transfer ( transferid, srcremoteid, srcpath, dstremoteid, dstpath, status, totalbytes, bytesdone, startoffset, sha256, errorstr, startts, endts)
+		                       VALUES (:transferid,:srcremoteid,:srcpath,:dstremoteid,:dstpath,:status,:totalbytes,:bytesdone,:startoffset,:sha256,:errorstr,:startts,:endts)`
+		tx.NamedExec(query, dbutil.ToDBMap(transfer, false))
+		return nil
+	})
+}
+
+func UpdateTransferProgress(ctx context.Context, transferId string, totalBytes int64, bytesDone int64) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `UPDATE transfer SET totalbytes = ?, bytesdone = ? WHERE transferid = ?`
+		tx.Exec(query, totalBytes, bytesDone, transferId)
+		return nil
+	})
+}
+
+// writes the final status, counts, sha256 and errorstr of the transfer (sets EndTs)
+func FinishTransfer(ctx context.Context, transfer *TransferType) error {
+	if len(transfer.ErrorStr) > MaxTransferErrorLen {
+		transfer.ErrorStr = transfer.ErrorStr[0:MaxTransferErrorLen]
+	}
+	transfer.EndTs = time.Now().UnixMilli()
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `UPDATE transfer SET status = ?, totalbytes = ?, bytesdone = ?, startoffset = ?, sha256 = ?, errorstr = ?, endts = ? WHERE transferid = ?`
+		tx.Exec(query, transfer.Status, transfer.TotalBytes, transfer.BytesDone, transfer.StartOffset, transfer.Sha256, transfer.ErrorStr, transfer.EndTs, transfer.TransferId)
+		return nil
+	})
+}
+
+func GetTransferById(ctx context.Context, transferId string) (*TransferType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*TransferType, error) {
+		query := `SELECT * FROM transfer WHERE transferid = ?`
+		return dbutil.GetMappable[*TransferType](tx, query, transferId), nil
+	})
+}
+
+// most recent first
+func GetTransferHistory(ctx context.Context, limit int) ([]*TransferType, error) {
+	if limit <= 0 {
+		limit = DefaultTransferHistoryLimit
+	}
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]*TransferType, error) {
+		query := `SELECT * FROM transfer ORDER BY startts DESC LIMIT ?`
+		return dbutil.SelectMappable[*TransferType](tx, query, limit), nil
+	})
+}
+
+// returns the most recent resumable transfer between the same source and destination (or nil)
+func FindResumableTransfer(ctx context.Context, srcRemoteId string, srcPath string, dstRemoteId string, dstPath string) (*TransferType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*TransferType, error) {
+		query := `SELECT * FROM transfer
+		          WHERE srcremoteid = ? AND srcpath = ? AND dstremoteid = ? AND dstpath = ? AND status IN (?, ?)
+		          ORDER BY startts DESC LIMIT 1`
+		return dbutil.GetMappable[*TransferType](tx, query, srcRemoteId, srcPath, dstRemoteId, dstPath, TransferStatus_Error, TransferStatus_Running), nil
+	})
+}
+
+// sets a resumed transfer back to running
+func RestartTransfer(ctx context.Context, transfer *TransferType) error {
+	transfer.Status = TransferStatus_Running
+	transfer.ErrorStr = ""
+	transfer.StartTs = time.Now().UnixMilli()
+	transfer.EndTs = 0
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `UPDATE transfer SET status = ?, errorstr = '', endts = 0, startts = ? WHERE transferid = ?`
+		tx.Exec(query, transfer.Status, transfer.StartTs, transfer.TransferId)
+		return nil
+	})
+}
+
+// transfers that were running when wavesrv exited can be resumed
+func FixInterruptedTransfers(ctx context.Context) error {
+	return WithTx(ctx, func(tx *TxWrap) error {
+		query := `UPDATE transfer SET status = ?, errorstr = 'interrupted', endts = ? WHERE status = ?`
+		tx.Exec(query, TransferStatus_Error, time.Now().UnixMilli(), TransferStatus_Running)
+		return nil
+	})
+}
@@ -0,0 +1,383 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+)
+
+// deleted screens and sessions are moved to the trash (and can be restored) for trashdays days (client opt,
+// default DefaultTrashDays) before they are purged.  a trashed screen keeps a copy of its rows (screen, lines,
+// cmds, line tags, input, env profile attachments, and its history links) in the trash row's data, its screen
+// dir (ptyout) is only deleted when it is purged.  the screens of a deleted session are trashed with parentid
+// set to the session's trashid, and are restored (or purged) along with the session.
+// the tombstones are still written on delete (and removed on restore).
+
+const TrashObjType_Screen = "screen"
+const TrashObjType_Session = "session"
+const DefaultTrashDays = 7
+
+type TrashItemType struct {
+	TrashId   string `json:"trashid"`
+	ObjType   string `json:"objtype"`
+	ObjId     string `json:"objid"`
+	SessionId string `json:"sessionid"`
+	ParentId  string `json:"parentid"`
+	Name      string `json:"name"`
+	DeletedTs int64  `json:"deletedts"`
+}
+
+func (TrashItemType) UseDBMap() {}
+
+// the rows of a trashed screen (raw db rows, restored as-is)
+type trashScreenData struct {
+	Screen       map[string]any   `json:"screen"`
+	Lines        []map[string]any `json:"lines"`
+	Cmds         []map[string]any `json:"cmds"`
+	LineTags     []map[string]any `json:"linetags"`
+	ScreenInput  map[string]any   `json:"screeninput,omitempty"`
+	EnvAttach    []map[string]any `json:"envattach"`
+	HistoryLinks []map[string]any `json:"historylinks"`
+}
+
+type trashSessionData struct {
+	Session map[string]any `json:"session"`
+}
+
+func GetTrashDays(opts ClientOptsType) int {
+	if opts.TrashDays > 0 {
+		return opts.TrashDays
+	}
+	return DefaultTrashDays
+}
+
+func insertTrashItem(tx *TxWrap, item *TrashItemType, data any) {
+	m := dbutil.ToDBMap(item, false)
+	m["data"] = dbutil.QuickJson(data)
+	query := `INSERT INTO trash ( trashid, objtype, objid, sessionid, parentid, name, deletedts, data)
+	                     VALUES (:trashid,:objtype,:objid,:sessionid,:parentid,:name,:deletedts,:data)`
+	tx.NamedExec(query, m)
+}
+
+// copies the screen's rows to the trash (called by DeleteScreen before the rows are deleted)
+func trashScreenTx(tx *TxWrap, screen *ScreenType, parentTrashId string, deletedTs int64) {
+	screenId := screen.ScreenId
+	data := trashScreenData{
+		Screen:       tx.GetMap(`SELECT * FROM screen WHERE screenid = ?`, screenId),
+		Lines:        tx.SelectMaps(`SELECT * FROM line WHERE screenid = ?`, screenId),
+		Cmds:         tx.SelectMaps(`SELECT * FROM cmd WHERE screenid = ?`, screenId),
+		LineTags:     tx.SelectMaps(`SELECT * FROM line_tag WHERE screenid = ?`, screenId),
+		ScreenInput:  tx.GetMap(`SELECT * FROM screeninput WHERE screenid = ?`, screenId),
+		EnvAttach:    tx.SelectMaps(`SELECT * FROM envprofile_attach WHERE targettype = ? AND targetid = ?`, EnvAttach_Screen, screenId),
+		HistoryLinks: tx.SelectMaps(`SELECT historyid, lineid, linenum FROM history WHERE screenid = ? AND lineid <> ''`, screenId),
+	}
+	item := &TrashItemType{
+		TrashId:   scbase.GenWaveUUID(),
+		ObjType:   TrashObjType_Screen,
+		ObjId:     screenId,
+		SessionId: screen.SessionId,
+		ParentId:  parentTrashId,
+		Name:      screen.Name,
+		DeletedTs: deletedTs,
+	}
+	insertTrashItem(tx, item, data)
+}
+
+// called by deleteSession (in the tx that deletes the session row)
+func trashSessionTx(tx *TxWrap, trashId string, session *SessionType, deletedTs int64) {
+	data := trashSessionData{
+		Session: tx.GetMap(`SELECT * FROM session WHERE sessionid = ?`, session.SessionId),
+	}
+	item := &TrashItemType{
+		TrashId:   trashId,
+		ObjType:   TrashObjType_Session,
+		ObjId:     session.SessionId,
+		SessionId: session.SessionId,
+		Name:      session.Name,
+		DeletedTs: deletedTs,
+	}
+	insertTrashItem(tx, item, data)
+}
+
+func decodeTrashData(tx *TxWrap, trashId string, v any) error {
+	dataStr := tx.GetString(`SELECT data FROM trash WHERE trashid = ?`, trashId)
+	// UseNumber so int64 values (timestamps) are not rounded through float64
+	decoder := json.NewDecoder(strings.NewReader(dataStr))
+	decoder.UseNumber()
+	err := decoder.Decode(v)
+	if err != nil {
+		return fmt.Errorf("cannot decode trash data: %w", err)
+	}
+	return nil
+}
+
+func fixTrashRow(m map[string]any) map[string]any {
+	for key, val := range m {
+		numVal, ok := val.(json.Number)
+		if !ok {
+			continue
+		}
+		if intVal, err := numVal.Int64(); err == nil {
+			m[key] = intVal
+		} else if floatVal, err := numVal.Float64(); err == nil {
+			m[key] = floatVal
+		}
+	}
+	return m
+}
+
+func insertTrashRows(tx *TxWrap, table string, rows []map[string]any) {
+	for _, row := range rows {
+		dbutil.InsertMap(tx, table, fixTrashRow(row))
+	}
+}
+
+// trashed screens and sessions (newest first), screens trashed with their session are not included
+func GetTrashItems(ctx context.Context) ([]*TrashItemType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]*TrashItemType, error) {
+		query := `SELECT trashid, objtype, objid, sessionid, parentid, name, deletedts
+		          FROM trash t
+		          WHERE NOT EXISTS (SELECT 1 FROM trash p WHERE p.trashid = t.parentid)
+		          ORDER BY deletedts DESC`
+		return dbutil.SelectMappable[*TrashItemType](tx, query), nil
+	})
+}
+
+func getTrashItemTx(tx *TxWrap, trashId string) *TrashItemType {
+	query := `SELECT trashid, objtype, objid, sessionid, parentid, name, deletedts FROM trash WHERE trashid = ?`
+	return dbutil.GetMappable[*TrashItemType](tx, query, trashId)
+}
+
+// the number of screens trashed with the session
+func GetTrashSessionNumScreens(ctx context.Context, trashId string) (int, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (int, error) {
+		return tx.GetInt(`SELECT count(*) FROM trash WHERE parentid = ?`, trashId), nil
+	})
+}
+
+func restoreScreenTx(tx *TxWrap, item *TrashItemType) error {
+	if !tx.Exists(`SELECT sessionid FROM session WHERE sessionid = ?`, item.SessionId) {
+		return fmt.Errorf("cannot restore screen %q, its session was deleted", item.Name)
+	}
+	if tx.Exists(`SELECT screenid FROM screen WHERE screenid = ?`, item.ObjId) {
+		return fmt.Errorf("cannot restore screen %q, screen already exists", item.Name)
+	}
+	var data trashScreenData
+	err := decodeTrashData(tx, item.TrashId, &data)
+	if err != nil {
+		return err
+	}
+	if data.Screen == nil {
+		return fmt.Errorf("cannot restore screen %q, no screen data", item.Name)
+	}
+	screenId := item.ObjId
+	maxScreenIdx := tx.GetInt(`SELECT COALESCE(max(screenidx), 0) FROM screen WHERE sessionid = ? AND NOT archived`, item.SessionId)
+	dbutil.InsertMap(tx, "screen", fixTrashRow(data.Screen))
+	// the screen goes at the end of the session's tabs, and is no longer web shared
+	tx.Exec(`UPDATE screen SET screenidx = ? WHERE screenid = ? AND NOT archived`, maxScreenIdx+1, screenId)
+	tx.Exec(`UPDATE screen SET sharemode = ?, webshareopts = 'null' WHERE screenid = ?`, ShareModeLocal, screenId)
+	tx.Exec(`UPDATE screen SET lastactivets = ? WHERE screenid = ?`, time.Now().UnixMilli(), screenId)
+	localRemoteId := tx.GetString(`SELECT remoteid FROM remote WHERE remotealias = ?`, LocalRemoteAlias)
+	query := `UPDATE screen SET curremoteownerid = '', curremoteid = ?, curremotename = ''
+	          WHERE screenid = ? AND curremoteid NOT IN (SELECT remoteid FROM remote)`
+	tx.Exec(query, localRemoteId, screenId)
+	insertTrashRows(tx, "line", data.Lines)
+	insertTrashRows(tx, "cmd", data.Cmds)
+	insertTrashRows(tx, "line_tag", data.LineTags)
+	insertTrashRows(tx, "envprofile_attach", data.EnvAttach)
+	if data.ScreenInput != nil {
+		dbutil.InsertMap(tx, "screeninput", fixTrashRow(data.ScreenInput))
+	}
+	// cmds that were running when the screen was deleted are gone
+	query = `UPDATE cmd SET status = ? WHERE screenid = ? AND status IN (?, ?)`
+	tx.Exec(query, CmdStatusHangup, screenId, CmdStatusRunning, CmdStatusDetached)
+	for _, link := range data.HistoryLinks {
+		link = fixTrashRow(link)
+		query = `UPDATE history SET lineid = ?, linenum = ? WHERE historyid = ? AND screenid = ? AND lineid = ''`
+		tx.Exec(query, link["lineid"], link["linenum"], link["historyid"], screenId)
+	}
+	tx.Exec(`DELETE FROM screen_tombstone WHERE screenid = ?`, screenId)
+	tx.Exec(`DELETE FROM trash WHERE trashid = ?`, item.TrashId)
+	return nil
+}
+
+// restores a trashed screen (and makes it the session's active screen), the screen's session must exist
+func RestoreScreen(ctx context.Context, trashId string) (*scbus.ModelUpdatePacketType, error) {
+	var item *TrashItemType
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		item = getTrashItemTx(tx, trashId)
+		if item == nil || item.ObjType != TrashObjType_Screen {
+			return fmt.Errorf("screen not found in trash")
+		}
+		if item.ParentId != "" && tx.Exists(`SELECT trashid FROM trash WHERE trashid = ?`, item.ParentId) {
+			return fmt.Errorf("screen %q was deleted with its session, restore the session", item.Name)
+		}
+		err := restoreScreenTx(tx, item)
+		if err != nil {
+			return err
+		}
+		query := `UPDATE session SET activescreenid = ?
+		          WHERE sessionid = ? AND EXISTS (SELECT 1 FROM screen WHERE screenid = ? AND NOT archived)`
+		tx.Exec(query, item.ObjId, item.SessionId, item.ObjId)
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	update := scbus.MakeUpdatePacket()
+	screen, err := GetScreenById(ctx, item.ObjId)
+	if err != nil {
+		return nil, err
+	}
+	if screen != nil {
+		update.AddUpdate(*screen)
+	}
+	bareSession, err := GetBareSessionById(ctx, item.SessionId)
+	if err != nil {
+		return nil, err
+	}
+	if bareSession != nil {
+		update.AddUpdate(*bareSession)
+	}
+	return update, nil
+}
+
+// restores a trashed session with its screens, and makes it the active session (if it is not archived)
+func RestoreSession(ctx context.Context, trashId string) (*scbus.ModelUpdatePacketType, error) {
+	var item *TrashItemType
+	var screenIds []string
+	var isActive bool
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		item = getTrashItemTx(tx, trashId)
+		if item == nil || item.ObjType != TrashObjType_Session {
+			return fmt.Errorf("session not found in trash")
+		}
+		if tx.Exists(`SELECT sessionid FROM session WHERE sessionid = ?`, item.ObjId) {
+			return fmt.Errorf("cannot restore session %q, session already exists", item.Name)
+		}
+		var data trashSessionData
+		err := decodeTrashData(tx, trashId, &data)
+		if err != nil {
+			return err
+		}
+		if data.Session == nil {
+			return fmt.Errorf("cannot restore session %q, no session data", item.Name)
+		}
+		maxSessionIdx := tx.GetInt(`SELECT COALESCE(max(sessionidx), 0) FROM session`)
+		dbutil.InsertMap(tx, "session", fixTrashRow(data.Session))
+		tx.Exec(`UPDATE session SET sessionidx = ?, sharemode = ? WHERE sessionid = ?`, maxSessionIdx+1, ShareModeLocal, item.ObjId)
+		query := `SELECT trashid, objtype, objid, sessionid, parentid, name, deletedts FROM trash WHERE parentid = ? ORDER BY deletedts`
+		screenItems := dbutil.SelectMappable[*TrashItemType](tx, query, trashId)
+		for _, screenItem := range screenItems {
+			err = restoreScreenTx(tx, screenItem)
+			if err != nil {
+				return err
+			}
+			screenIds = append(screenIds, screenItem.ObjId)
+		}
+		fixSessionActiveScreen(tx, item.ObjId)
+		tx.Exec(`DELETE FROM session_tombstone WHERE sessionid = ?`, item.ObjId)
+		tx.Exec(`DELETE FROM trash WHERE trashid = ?`, trashId)
+		isActive = tx.Exists(`SELECT sessionid FROM session WHERE sessionid = ? AND NOT archived`, item.ObjId)
+		if isActive {
+			tx.Exec(`UPDATE client SET activesessionid = ?`, item.ObjId)
+		}
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	update := scbus.MakeUpdatePacket()
+	session, err := GetSessionById(ctx, item.ObjId)
+	if err != nil {
+		return nil, err
+	}
+	if session != nil {
+		update.AddUpdate(*session)
+	}
+	for _, screenId := range screenIds {
+		screen, err := GetScreenById(ctx, screenId)
+		if err != nil {
+			return nil, err
+		}
+		if screen != nil {
+			update.AddUpdate(*screen)
+		}
+	}
+	if isActive {
+		update.AddUpdate(ActiveSessionIdUpdate(item.ObjId))
+	}
+	return update, nil
+}
+
+// removes the trash items (and the screens trashed with a purged session), the screen dirs are queued for deletion
+func purgeTrashItems(ctx context.Context, trashIdsFn func(tx *TxWrap) []string) (int, error) {
+	var numPurged int
+	var screenIds []string
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		trashIds := trashIdsFn(tx)
+		if len(trashIds) == 0 {
+			return nil
+		}
+		trashIdsArr := quickJsonArr(trashIds)
+		query := `SELECT objid FROM trash
+		          WHERE objtype = ? AND (trashid IN (SELECT value FROM json_each(?)) OR parentid IN (SELECT value FROM json_each(?)))`
+		screenIds = tx.SelectStrings(query, TrashObjType_Screen, trashIdsArr, trashIdsArr)
+		query = `DELETE FROM trash WHERE trashid IN (SELECT value FROM json_each(?)) OR parentid IN (SELECT value FROM json_each(?))`
+		tx.Exec(query, trashIdsArr, trashIdsArr)
+		numPurged = len(trashIds)
+		return nil
+	})
+	if txErr != nil {
+		return 0, txErr
+	}
+	GoDeleteScreenDirs(screenIds...)
+	return numPurged, nil
+}
+
+func PurgeTrashItem(ctx context.Context, trashId string) error {
+	numPurged, err := purgeTrashItems(ctx, func(tx *TxWrap) []string {
+		return tx.SelectStrings(`SELECT trashid FROM trash WHERE trashid = ?`, trashId)
+	})
+	if err != nil {
+		return err
+	}
+	if numPurged == 0 {
+		return fmt.Errorf("item not found in trash")
+	}
+	return nil
+}
+
+// purges the items deleted before olderThanTs (0 purges everything), returns the number of items purged
+func PurgeTrash(ctx context.Context, olderThanTs int64) (int, error) {
+	return purgeTrashItems(ctx, func(tx *TxWrap) []string {
+		if olderThanTs <= 0 {
+			olderThanTs = math.MaxInt64
+		}
+		// screens trashed with a session are purged with the session
+		query := `SELECT trashid FROM trash t
+		          WHERE deletedts < ? AND NOT EXISTS (SELECT 1 FROM trash p WHERE p.trashid = t.parentid)`
+		return tx.SelectStrings(query, olderThanTs)
+	})
+}
+
+// purges the items that have been in the trash for longer than trashdays (run periodically from main)
+func PurgeExpiredTrash(ctx context.Context) (int, error) {
+	clientData, err := EnsureClientData(ctx)
+	if err != nil {
+		return 0, err
+	}
+	trashDays := GetTrashDays(clientData.ClientOpts)
+	cutoffTs := time.Now().Add(-time.Duration(trashDays) * 24 * time.Hour).UnixMilli()
+	return PurgeTrash(ctx, cutoffTs)
+}
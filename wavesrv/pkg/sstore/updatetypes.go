@@ -12,6 +12,20 @@ import (
 	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
 )
 
+func init() {
+	// the sstore model update items (see scbus/updateregistry.go)
+	scbus.RegisterModelUpdateItem(
+		ActiveSessionIdUpdate(""), LineUpdate{}, CmdLineUpdate{}, InfoMsgType{}, ClearInfoUpdate(false),
+		InteractiveUpdate(false), ConnectUpdate{}, RemoteViewType{}, OpenAICmdInfoChatUpdate(nil),
+		OpenAICmdInfoAttachmentsUpdate(nil), AlertMessageType{}, NotifyUpdateType{}, ScreenStatusIndicatorType{},
+		ScreenNumRunningCommandsType{}, ClientData{}, SessionType{}, SessionTombstoneType{}, ScreenLinesType{},
+		ScreenType{}, ScreenTombstoneType{}, RemoteRuntimeState{}, CmdType{}, WindowType{}, RemoteGroupType{},
+		RemoteHealthType{}, CurrentContextType{}, TranscriptUpdateType{}, PlaybackUpdateType{}, TransferType{},
+		TransferHistoryType{}, EnvProfilesUpdateType{}, DirBookmarksUpdateType{}, SnippetsUpdateType{}, BulkOpType{},
+		configstore.ConfigReturn(nil),
+	)
+}
+
 type ActiveSessionIdUpdate string
 
 func (ActiveSessionIdUpdate) GetType() string {
@@ -99,13 +113,19 @@ func (InteractiveUpdate) GetType() string {
 }
 
 type ConnectUpdate struct {
-	Sessions                 []*SessionType                  `json:"sessions,omitempty"`
-	Screens                  []*ScreenType                   `json:"screens,omitempty"`
-	Remotes                  []*RemoteRuntimeState           `json:"remotes,omitempty"`
-	ScreenStatusIndicators   []*ScreenStatusIndicatorType    `json:"screenstatusindicators,omitempty"`
-	ScreenNumRunningCommands []*ScreenNumRunningCommandsType `json:"screennumrunningcommands,omitempty"`
-	ActiveSessionId          string                          `json:"activesessionid,omitempty"`
-	TermThemes               *configstore.ConfigReturn       `json:"termthemes,omitempty"`
+	Sessions                 []*SessionType                    `json:"sessions,omitempty"`
+	Screens                  []*ScreenType                     `json:"screens,omitempty"`
+	Remotes                  []*RemoteRuntimeState             `json:"remotes,omitempty"`
+	RemoteGroups             []*RemoteGroupType                `json:"remotegroups,omitempty"`
+	RemoteHealth             []*RemoteHealthType               `json:"remotehealth,omitempty"`
+	RemoteMetrics            map[string][]*scbus.RemoteMetrics `json:"remotemetrics,omitempty"` // remoteid => samples (see remote/metrics.go)
+	ScreenStatusIndicators   []*ScreenStatusIndicatorType      `json:"screenstatusindicators,omitempty"`
+	ScreenNumRunningCommands []*ScreenNumRunningCommandsType   `json:"screennumrunningcommands,omitempty"`
+	ActiveSessionId          string                            `json:"activesessionid,omitempty"`
+	Windows                  []*WindowType                     `json:"windows,omitempty"`
+	TermThemes               *configstore.ConfigReturn         `json:"termthemes,omitempty"`
+	Dropdown                 bool                              `json:"dropdown,omitempty"` // scoped to the dropdown session/screen (see dropdown.go)
+	ScreenInputs             []*ScreenInputType                `json:"screeninputs,omitempty"`
 }
 
 func (ConnectUpdate) GetType() string {
@@ -137,6 +157,12 @@ func (OpenAICmdInfoChatUpdate) GetType() string {
 	return "openaicmdinfochat"
 }
 
+type OpenAICmdInfoAttachmentsUpdate []*OpenAICmdInfoAttachmentType
+
+func (OpenAICmdInfoAttachmentsUpdate) GetType() string {
+	return "openaicmdinfoattachments"
+}
+
 type AlertMessageType struct {
 	Title    string `json:"title,omitempty"`
 	Message  string `json:"message"`
@@ -148,6 +174,26 @@ func (AlertMessageType) GetType() string {
 	return "alertmessage"
 }
 
+const (
+	NotifyUrgency_Low      = "low"
+	NotifyUrgency_Normal   = "normal"
+	NotifyUrgency_Critical = "critical"
+)
+
+// rendered as a native desktop notification by the electron shell
+type NotifyUpdateType struct {
+	ScreenId  string `json:"screenid,omitempty"`
+	SessionId string `json:"sessionid,omitempty"`
+	LineId    string `json:"lineid,omitempty"`
+	Title     string `json:"title"`
+	Body      string `json:"body,omitempty"`
+	Urgency   string `json:"urgency,omitempty"`
+}
+
+func (NotifyUpdateType) GetType() string {
+	return "notify"
+}
+
 type ScreenStatusIndicatorType struct {
 	ScreenId string               `json:"screenid"`
 	Status   StatusIndicatorLevel `json:"status"`
@@ -0,0 +1,360 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// markdown vault export (Obsidian compatible).  each session is a folder, each screen a markdown file with its
+// commands as code blocks (annotated with the line number, start time, exit code and duration) and their output.
+// output longer than VaultInlineOutputLines is written to an attachment (in the session's attachments folder) and
+// linked, with a preview of its first lines.  archived sessions, screens and lines are not exported.
+//
+// re-exports are incremental: the vault's manifest (VaultManifestName) has the path and a signature (of the lines
+// and the cmd statuses) of each exported screen, unchanged screens are not rendered again.  files of screens that
+// were renamed, archived or deleted are removed, other files in the vault are never touched.
+
+const VaultManifestName = ".waveterm-export.json"
+const VaultAttachmentsDir = "attachments"
+const VaultInlineOutputLines = 50
+const VaultPreviewLines = 10
+const vaultManifestVersion = 1
+
+type VaultExportOpts struct {
+	Full bool // ignore the manifest signatures, re-render every screen
+}
+
+type VaultExportStats struct {
+	NumScreens   int // screens in the vault
+	NumWritten   int // screens rendered and written
+	NumUnchanged int
+	NumRemoved   int // files removed (renamed or removed screens)
+}
+
+type vaultManifestScreen struct {
+	Path        string   `json:"path"`
+	Signature   string   `json:"signature"`
+	Attachments []string `json:"attachments,omitempty"`
+}
+
+type vaultManifest struct {
+	Version int                             `json:"version"`
+	Screens map[string]*vaultManifestScreen `json:"screens"` // key is the screenid
+}
+
+type vaultScreen struct {
+	Session *SessionType
+	Screen  *ScreenType
+	Lines   *ScreenLinesType
+	Path    string // relative to the vault
+}
+
+func readVaultManifest(outDir string) (*vaultManifest, error) {
+	rtn := &vaultManifest{Version: vaultManifestVersion, Screens: make(map[string]*vaultManifestScreen)}
+	data, err := os.ReadFile(filepath.Join(outDir, VaultManifestName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return rtn, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, rtn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid export manifest: %v", err)
+	}
+	if rtn.Screens == nil {
+		rtn.Screens = make(map[string]*vaultManifestScreen)
+	}
+	return rtn, nil
+}
+
+// removes characters that are not allowed in file names (on any platform, or in obsidian links)
+func VaultFileName(name string) string {
+	var buf strings.Builder
+	for _, ch := range name {
+		if ch < 32 || strings.ContainsRune(`/\:*?"<>|#^[]`, ch) {
+			buf.WriteRune('-')
+			continue
+		}
+		buf.WriteRune(ch)
+	}
+	rtn := strings.Trim(buf.String(), " .")
+	if rtn == "" {
+		return "untitled"
+	}
+	return rtn
+}
+
+// appends " (2)", " (3)", ... for names already used (compared case-insensitively)
+func uniqueVaultName(name string, used map[string]bool) string {
+	rtn := name
+	for idx := 2; used[strings.ToLower(rtn)]; idx++ {
+		rtn = fmt.Sprintf("%s (%d)", name, idx)
+	}
+	used[strings.ToLower(rtn)] = true
+	return rtn
+}
+
+// changes with the screen's name, lines and cmd statuses.  screens with running commands always change.
+func vaultScreenSignature(vs *vaultScreen) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d|%s|%s|%s\n", vaultManifestVersion, vs.Session.Name, vs.Screen.Name, vs.Path)
+	cmdMap := make(map[string]*CmdType)
+	for _, cmd := range vs.Lines.Cmds {
+		cmdMap[cmd.LineId] = cmd
+	}
+	for _, line := range vs.Lines.Lines {
+		if line.Archived {
+			continue
+		}
+		fmt.Fprintf(hash, "%s|%d|%s|%d\n", line.LineId, line.LineNum, line.Text, line.Ts)
+		if cmd := cmdMap[line.LineId]; cmd != nil {
+			if cmd.Status == CmdStatusRunning || cmd.Status == CmdStatusDetached {
+				fmt.Fprintf(hash, "running|%d\n", time.Now().UnixNano())
+			}
+			fmt.Fprintf(hash, "%s|%s|%d|%d|%d\n", cmd.CmdStr, cmd.Status, cmd.ExitCode, cmd.DoneTs, cmd.RestartTs)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// a fence longer than any backtick run in the content
+func markdownFence(content string) string {
+	maxRun, run := 0, 0
+	for _, ch := range content {
+		if ch == '`' {
+			run++
+			maxRun = max(maxRun, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, maxRun+1))
+}
+
+func writeCodeBlock(buf *bytes.Buffer, lang string, content string) {
+	fence := markdownFence(content)
+	buf.WriteString(fence + lang + "\n")
+	buf.WriteString(content)
+	if !strings.HasSuffix(content, "\n") {
+		buf.WriteString("\n")
+	}
+	buf.WriteString(fence + "\n\n")
+}
+
+func formatVaultDuration(durationMs int) string {
+	if durationMs < 1000 {
+		return fmt.Sprintf("%dms", durationMs)
+	}
+	return (time.Duration(durationMs) * time.Millisecond).Round(100 * time.Millisecond).String()
+}
+
+func vaultCmdHeading(line *LineType, cmd *CmdType, loc *time.Location) string {
+	parts := []string{strconv.FormatInt(line.LineNum, 10), time.UnixMilli(CmdStartTs(line, cmd)).In(loc).Format("2006-01-02 15:04:05")}
+	switch cmd.Status {
+	case CmdStatusDone, CmdStatusError:
+		parts = append(parts, fmt.Sprintf("exit %d", cmd.ExitCode))
+	default:
+		parts = append(parts, cmd.Status)
+	}
+	if cmd.DurationMs > 0 {
+		parts = append(parts, formatVaultDuration(cmd.DurationMs))
+	}
+	return strings.Join(parts, " · ")
+}
+
+// renders the screen's markdown, returns the attachments (relative path -> content)
+func renderVaultScreen(ctx context.Context, vs *vaultScreen) ([]byte, map[string][]byte, error) {
+	loc := GetClientLocation(ctx)
+	attachments := make(map[string][]byte)
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	fmt.Fprintf(&buf, "session: %s\n", strconv.Quote(vs.Session.Name))
+	fmt.Fprintf(&buf, "screen: %s\n", strconv.Quote(vs.Screen.Name))
+	fmt.Fprintf(&buf, "screenid: %s\n", vs.Screen.ScreenId)
+	buf.WriteString("---\n\n")
+	fmt.Fprintf(&buf, "# %s\n\n", vs.Screen.Name)
+	cmdMap := make(map[string]*CmdType)
+	for _, cmd := range vs.Lines.Cmds {
+		cmdMap[cmd.LineId] = cmd
+	}
+	screenFileName := strings.TrimSuffix(filepath.Base(vs.Path), ".md")
+	for _, line := range vs.Lines.Lines {
+		if line.Archived {
+			continue
+		}
+		cmd := cmdMap[line.LineId]
+		if cmd == nil {
+			if line.Text != "" {
+				fmt.Fprintf(&buf, "> %s\n\n", strings.ReplaceAll(line.Text, "\n", "\n> "))
+			}
+			continue
+		}
+		fmt.Fprintf(&buf, "### %s\n\n", vaultCmdHeading(line, cmd, loc))
+		writeCodeBlock(&buf, "bash", cmd.CmdStr)
+		if binaryOut, _ := line.LineState[LineState_BinaryOut].(bool); binaryOut {
+			buf.WriteString("*(binary output not exported)*\n\n")
+			continue
+		}
+		_, data, err := ReadFullPtyOutFile(ctx, line.ScreenId, line.LineId)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, fmt.Errorf("cannot read output of line %d: %w", line.LineNum, err)
+		}
+		data, _ = DecodeToUtf8(data, GetLineEncoding(line))
+		outLines := StripAnsiLines(data)
+		for len(outLines) > 0 && strings.TrimSpace(outLines[len(outLines)-1]) == "" {
+			outLines = outLines[:len(outLines)-1]
+		}
+		if len(outLines) == 0 {
+			continue
+		}
+		if len(outLines) <= VaultInlineOutputLines {
+			writeCodeBlock(&buf, "text", strings.Join(outLines, "\n"))
+			continue
+		}
+		attachPath := filepath.Join(filepath.Dir(vs.Path), VaultAttachmentsDir, fmt.Sprintf("%s - %d.txt", screenFileName, line.LineNum))
+		attachments[attachPath] = []byte(strings.Join(outLines, "\n") + "\n")
+		writeCodeBlock(&buf, "text", strings.Join(outLines[:VaultPreviewLines], "\n"))
+		linkPath := filepath.ToSlash(filepath.Join(VaultAttachmentsDir, filepath.Base(attachPath)))
+		fmt.Fprintf(&buf, "[full output (%d lines)](<%s>)\n\n", len(outLines), linkPath)
+	}
+	return buf.Bytes(), attachments, nil
+}
+
+func getVaultScreens(ctx context.Context) ([]*vaultScreen, error) {
+	sessions, err := GetBareSessions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var rtn []*vaultScreen
+	usedSessionNames := make(map[string]bool)
+	for _, session := range sessions {
+		if session.Archived {
+			continue
+		}
+		sessionDir := uniqueVaultName(VaultFileName(session.Name), usedSessionNames)
+		screens, err := GetSessionScreens(ctx, session.SessionId)
+		if err != nil {
+			return nil, err
+		}
+		usedScreenNames := make(map[string]bool)
+		for _, screen := range screens {
+			if screen.Archived {
+				continue
+			}
+			screenLines, err := GetScreenLinesById(ctx, screen.ScreenId)
+			if err != nil {
+				return nil, err
+			}
+			screenName := uniqueVaultName(VaultFileName(screen.Name), usedScreenNames)
+			rtn = append(rtn, &vaultScreen{
+				Session: session,
+				Screen:  screen,
+				Lines:   screenLines,
+				Path:    filepath.Join(sessionDir, screenName+".md"),
+			})
+		}
+	}
+	return rtn, nil
+}
+
+func writeVaultFile(outDir string, relPath string, data []byte) error {
+	fullPath := filepath.Join(outDir, relPath)
+	err := os.MkdirAll(filepath.Dir(fullPath), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fullPath, data, 0644)
+}
+
+// exports all (non-archived) sessions to outDir (created if it does not exist)
+func ExportVault(ctx context.Context, outDir string, opts VaultExportOpts) (*VaultExportStats, error) {
+	err := os.MkdirAll(outDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create %q: %v", outDir, err)
+	}
+	manifest, err := readVaultManifest(outDir)
+	if err != nil {
+		return nil, err
+	}
+	screens, err := getVaultScreens(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats := &VaultExportStats{NumScreens: len(screens)}
+	newManifest := &vaultManifest{Version: vaultManifestVersion, Screens: make(map[string]*vaultManifestScreen)}
+	newPaths := make(map[string]bool)
+	var toWrite []*vaultScreen
+	for _, vs := range screens {
+		signature := vaultScreenSignature(vs)
+		oldEntry := manifest.Screens[vs.Screen.ScreenId]
+		_, statErr := os.Stat(filepath.Join(outDir, vs.Path))
+		if !opts.Full && oldEntry != nil && oldEntry.Signature == signature && statErr == nil {
+			newManifest.Screens[vs.Screen.ScreenId] = oldEntry
+			stats.NumUnchanged++
+		} else {
+			newManifest.Screens[vs.Screen.ScreenId] = &vaultManifestScreen{Path: vs.Path, Signature: signature}
+			toWrite = append(toWrite, vs)
+		}
+		newPaths[vs.Path] = true
+	}
+	for _, vs := range toWrite {
+		mdData, attachments, err := renderVaultScreen(ctx, vs)
+		if err != nil {
+			return nil, fmt.Errorf("error exporting screen %q: %v", vs.Screen.Name, err)
+		}
+		err = writeVaultFile(outDir, vs.Path, mdData)
+		if err != nil {
+			return nil, fmt.Errorf("error writing %q: %v", vs.Path, err)
+		}
+		entry := newManifest.Screens[vs.Screen.ScreenId]
+		for attachPath, attachData := range attachments {
+			err = writeVaultFile(outDir, attachPath, attachData)
+			if err != nil {
+				return nil, fmt.Errorf("error writing %q: %v", attachPath, err)
+			}
+			entry.Attachments = append(entry.Attachments, attachPath)
+		}
+		stats.NumWritten++
+	}
+	for _, entry := range newManifest.Screens {
+		for _, attachPath := range entry.Attachments {
+			newPaths[attachPath] = true
+		}
+	}
+	// files of the previous export that are not part of this one
+	for _, oldEntry := range manifest.Screens {
+		for _, oldPath := range append([]string{oldEntry.Path}, oldEntry.Attachments...) {
+			if newPaths[oldPath] || !filepath.IsLocal(oldPath) {
+				continue
+			}
+			err = os.Remove(filepath.Join(outDir, oldPath))
+			if err == nil {
+				stats.NumRemoved++
+			}
+		}
+	}
+	manifestData, err := json.MarshalIndent(newManifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = writeVaultFile(outDir, VaultManifestName, manifestData)
+	if err != nil {
+		return nil, fmt.Errorf("error writing the export manifest: %v", err)
+	}
+	return stats, nil
+}
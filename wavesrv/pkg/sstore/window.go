@@ -0,0 +1,213 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package sstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/dbutil"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+)
+
+// multiple (electron) windows.  the main window is MainWindowId ("") and keeps using the client
+// table (activesessionid, winsize).  additional windows are stored in the window table, each with their
+// own active session and winsize.  a window can override its session's active screen with activescreenid
+// (set when a screen is moved to the window), "" means the session's activescreenid is used.
+// a screen is only shown as the activescreenid of one window at a time.
+
+const MainWindowId = ""
+const MaxWindows = 10
+
+type WindowType struct {
+	WindowId        string            `json:"windowid"`
+	Name            string            `json:"name"`
+	ActiveSessionId string            `json:"activesessionid"`
+	ActiveScreenId  string            `json:"activescreenid,omitempty"`
+	WinSize         ClientWinSizeType `json:"winsize"`
+	CreatedTs       int64             `json:"createdts"`
+
+	// only for updates
+	Remove bool `json:"remove,omitempty" dbmap:"-"`
+}
+
+func (WindowType) UseDBMap() {}
+
+func (WindowType) GetType() string {
+	return "window"
+}
+
+func GetAllWindows(ctx context.Context) ([]*WindowType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) ([]*WindowType, error) {
+		query := `SELECT * FROM window ORDER BY createdts`
+		return dbutil.SelectMappable[*WindowType](tx, query), nil
+	})
+}
+
+func GetWindowById(ctx context.Context, windowId string) (*WindowType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*WindowType, error) {
+		query := `SELECT * FROM window WHERE windowid = ?`
+		return dbutil.GetMappable[*WindowType](tx, query, windowId), nil
+	})
+}
+
+// sessionId "" uses the main window's active session
+func CreateWindow(ctx context.Context, name string, sessionId string) (*WindowType, error) {
+	return WithTxRtn(ctx, func(tx *TxWrap) (*WindowType, error) {
+		query := `SELECT count(*) FROM window`
+		if tx.GetInt(query) >= MaxWindows {
+			return nil, fmt.Errorf("cannot create more than %d windows", MaxWindows)
+		}
+		if sessionId == "" {
+			sessionId = tx.GetString(`SELECT activesessionid FROM client`)
+		}
+		query = `SELECT sessionid FROM session WHERE sessionid = ? AND NOT archived`
+		if !tx.Exists(query, sessionId) {
+			return nil, fmt.Errorf("session not found")
+		}
+		var winSize ClientWinSizeType
+		quickScanJson(&winSize, tx.GetString(`SELECT winsize FROM client`))
+		window := &WindowType{
+			WindowId:        scbase.GenWaveUUID(),
+			Name:            name,
+			ActiveSessionId: sessionId,
+			WinSize:         winSize,
+			CreatedTs:       time.Now().UnixMilli(),
+		}
+		query = `INSERT INTO window ( windowid, name, activesessionid, activescreenid, winsize, createdts)
+		                     VALUES (:windowid,:name,:activesessionid,:activescreenid,:winsize,:createdts)`
+		tx.NamedExec(query, dbutil.ToDBMap(window, false))
+		return window, nil
+	})
+}
+
+func CloseWindow(ctx context.Context, windowId string) (*scbus.ModelUpdatePacketType, error) {
+	if windowId == MainWindowId {
+		return nil, fmt.Errorf("cannot close the main window")
+	}
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		query := `SELECT windowid FROM window WHERE windowid = ?`
+		if !tx.Exists(query, windowId) {
+			return fmt.Errorf("window not found")
+		}
+		query = `DELETE FROM window WHERE windowid = ?`
+		tx.Exec(query, windowId)
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	update := scbus.MakeUpdatePacket()
+	update.AddUpdate(WindowType{WindowId: windowId, Remove: true})
+	return update, nil
+}
+
+func checkWindowExists(tx *TxWrap, windowId string) error {
+	if windowId == MainWindowId {
+		return nil
+	}
+	query := `SELECT windowid FROM window WHERE windowid = ?`
+	if !tx.Exists(query, windowId) {
+		return fmt.Errorf("window not found")
+	}
+	return nil
+}
+
+func SetWindowActiveSessionId(ctx context.Context, windowId string, sessionId string) error {
+	if windowId == MainWindowId {
+		return SetActiveSessionId(ctx, sessionId)
+	}
+	return WithTx(ctx, func(tx *TxWrap) error {
+		if err := checkWindowExists(tx, windowId); err != nil {
+			return err
+		}
+		query := `SELECT sessionid FROM session WHERE sessionid = ?`
+		if !tx.Exists(query, sessionId) {
+			return fmt.Errorf("cannot switch to session, not found")
+		}
+		query = `UPDATE window SET activesessionid = ?, activescreenid = '' WHERE windowid = ?`
+		tx.Exec(query, sessionId, windowId)
+		return nil
+	})
+}
+
+func SetWindowWinSize(ctx context.Context, windowId string, winSize ClientWinSizeType) error {
+	if windowId == MainWindowId {
+		return SetWinSize(ctx, winSize)
+	}
+	return WithTx(ctx, func(tx *TxWrap) error {
+		if err := checkWindowExists(tx, windowId); err != nil {
+			return err
+		}
+		query := `UPDATE window SET winsize = ? WHERE windowid = ?`
+		tx.Exec(query, quickJson(winSize), windowId)
+		return nil
+	})
+}
+
+// shows screenId in windowId (switching the window to the screen's session).  any other window showing
+// the screen falls back to its session's active screen.
+func MoveScreenToWindow(ctx context.Context, screenId string, windowId string) (*scbus.ModelUpdatePacketType, error) {
+	var updatedWindowIds []string
+	var sessionId string
+	txErr := WithTx(ctx, func(tx *TxWrap) error {
+		if err := checkWindowExists(tx, windowId); err != nil {
+			return err
+		}
+		query := `SELECT sessionid FROM screen WHERE screenid = ? AND NOT archived`
+		sessionId = tx.GetString(query, screenId)
+		if sessionId == "" {
+			return fmt.Errorf("screen not found")
+		}
+		query = `SELECT windowid FROM window WHERE activescreenid = ? AND windowid <> ?`
+		updatedWindowIds = tx.SelectStrings(query, screenId, windowId)
+		query = `UPDATE window SET activescreenid = '' WHERE activescreenid = ?`
+		tx.Exec(query, screenId)
+		if windowId == MainWindowId {
+			query = `UPDATE client SET activesessionid = ?`
+			tx.Exec(query, sessionId)
+			query = `UPDATE session SET activescreenid = ? WHERE sessionid = ?`
+			tx.Exec(query, screenId, sessionId)
+		} else {
+			query = `UPDATE window SET activesessionid = ?, activescreenid = ? WHERE windowid = ?`
+			tx.Exec(query, sessionId, screenId, windowId)
+			updatedWindowIds = append(updatedWindowIds, windowId)
+		}
+		return nil
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+	update := scbus.MakeUpdatePacket()
+	if windowId == MainWindowId {
+		bareSession, err := GetBareSessionById(ctx, sessionId)
+		if err != nil {
+			return nil, err
+		}
+		update.AddUpdate(ActiveSessionIdUpdate(sessionId))
+		if bareSession != nil {
+			update.AddUpdate(*bareSession)
+		}
+	}
+	for _, updatedWindowId := range updatedWindowIds {
+		window, err := GetWindowById(ctx, updatedWindowId)
+		if err != nil {
+			return nil, err
+		}
+		if window != nil {
+			update.AddUpdate(*window)
+		}
+	}
+	return update, nil
+}
+
+// windows showing a removed (deleted or archived) session switch to the main window's active session
+func fixWindowSessions(tx *TxWrap) {
+	activeSessionId := tx.GetString(`SELECT activesessionid FROM client`)
+	query := `UPDATE window SET activesessionid = ?, activescreenid = ''
+	          WHERE activesessionid NOT IN (SELECT sessionid FROM session WHERE NOT archived)`
+	tx.Exec(query, activeSessionId)
+}
@@ -146,6 +146,11 @@ func GetRelDayStr(relDays int) string {
 //	+[n]m, -[n]m (e.g. -1m)
 //	deltas can be combined e.g. +1w-2d
 func GetCustomDayStr(format string) (string, error) {
+	return GetCustomDayStrInLoc(format, time.Local)
+}
+
+// same as GetCustomDayStr, but "today" (and the other prefixes) are computed in loc
+func GetCustomDayStrInLoc(format string, loc *time.Location) (string, error) {
 	m := customDayStrRe.FindStringSubmatch(format)
 	if m == nil {
 		return "", fmt.Errorf("invalid daystr format")
@@ -155,7 +160,7 @@ func GetCustomDayStr(format string) (string, error) {
 		prefix = "today"
 	}
 	var rtnTime time.Time
-	now := time.Now()
+	now := time.Now().In(loc)
 	switch prefix {
 	case "today":
 		rtnTime = now
@@ -39,3 +39,19 @@ func TestDaystrCustom(t *testing.T) {
 	testCustomDaystr(t, "2024-01-01+1w", "2024-01-08", false)
 	testCustomDaystr(t, "2024-01-01+1m+1w-1d", "2024-02-07", false)
 }
+
+func TestDaystrCustomInLoc(t *testing.T) {
+	for _, tzName := range []string{"Pacific/Kiritimati", "Pacific/Pago_Pago"} {
+		loc, err := time.LoadLocation(tzName)
+		if err != nil {
+			t.Skipf("timezone data not available: %v", err)
+		}
+		expected := time.Now().In(loc).Format("2006-01-02")
+		rtn, err := GetCustomDayStrInLoc("today", loc)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		} else if rtn != expected {
+			t.Errorf("for %s expected %q, got %q", tzName, expected, rtn)
+		}
+	}
+}
@@ -0,0 +1,900 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package tmuxcc
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/screenattach"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+// the supported tmux commands (and their aliases).  only the flags listed in each spec are accepted, targets
+// (-t) can be $N, @N, %N, a session name, or [session]:[window] (window is @N, an index, or a name).
+//
+//	list-sessions (ls) [-F format]
+//	list-windows (lsw) [-a] [-F format] [-t session]
+//	list-panes (lsp) [-a] [-s] [-F format] [-t target]
+//	display-message (display) [-p] [-t target] [format]
+//	has-session (has) [-t session]
+//	new-session (new) [-d] [-P] [-F format] [-s name]
+//	switch-client (switchc) -t session
+//	rename-session (rename) [-t session] name
+//	new-window (neww) [-d] [-P] [-F format] [-n name] [-t session] [command]
+//	kill-window (killw) [-t window]
+//	rename-window (renamew) [-t window] name
+//	select-window (selectw) -t window
+//	send-keys (send) [-l] [-H] [-t pane] key...
+//	capture-pane (capturep) -p [-e] [-J] [-t pane] [-S start] [-E end]
+//	refresh-client (refresh) [-C width,height]
+//	detach-client (detach)
+
+const MaxNameLen = 50
+
+const defaultSessionsFormat = "#{session_name}: #{session_windows} windows#{?session_attached, (attached),}"
+const defaultWindowsFormat = "#{window_index}: #{window_name}#{?window_active,*,} (#{window_panes} panes) [#{window_width}x#{window_height}] [layout #{window_layout}] #{window_id}#{?window_active, (active),}"
+const defaultPanesFormat = "#{pane_index}: [#{pane_width}x#{pane_height}] #{pane_id} (active)"
+const defaultNewWindowFormat = "#{session_name}:#{window_index}"
+const defaultNewSessionFormat = "#{session_name}:"
+
+type cmdArgs struct {
+	Flags map[byte]string // boolean flags are set to ""
+	Args  []string
+}
+
+func (args *cmdArgs) has(flag byte) bool {
+	_, found := args.Flags[flag]
+	return found
+}
+
+type cmdFnType func(ctx context.Context, cc *ccConn, args *cmdArgs) (string, error)
+
+type cmdSpec struct {
+	Fn         cmdFnType
+	BoolFlags  string
+	ValueFlags string
+	Write      bool // not allowed in read-only mode
+}
+
+var cmdSpecs map[string]*cmdSpec
+
+var cmdAliases = map[string]string{
+	"ls":       "list-sessions",
+	"lsw":      "list-windows",
+	"lsp":      "list-panes",
+	"display":  "display-message",
+	"has":      "has-session",
+	"new":      "new-session",
+	"switchc":  "switch-client",
+	"rename":   "rename-session",
+	"neww":     "new-window",
+	"killw":    "kill-window",
+	"renamew":  "rename-window",
+	"selectw":  "select-window",
+	"send":     "send-keys",
+	"capturep": "capture-pane",
+	"refresh":  "refresh-client",
+	"detach":   "detach-client",
+}
+
+func init() {
+	cmdSpecs = map[string]*cmdSpec{
+		"list-sessions":   {Fn: listSessionsCmd, ValueFlags: "F"},
+		"list-windows":    {Fn: listWindowsCmd, BoolFlags: "a", ValueFlags: "Ft"},
+		"list-panes":      {Fn: listPanesCmd, BoolFlags: "as", ValueFlags: "Ft"},
+		"display-message": {Fn: displayMessageCmd, BoolFlags: "p", ValueFlags: "t"},
+		"has-session":     {Fn: hasSessionCmd, ValueFlags: "t"},
+		"new-session":     {Fn: newSessionCmd, BoolFlags: "dP", ValueFlags: "Fs", Write: true},
+		"switch-client":   {Fn: switchClientCmd, ValueFlags: "t"},
+		"rename-session":  {Fn: renameSessionCmd, ValueFlags: "t", Write: true},
+		"new-window":      {Fn: newWindowCmd, BoolFlags: "dP", ValueFlags: "Fnt", Write: true},
+		"kill-window":     {Fn: killWindowCmd, ValueFlags: "t", Write: true},
+		"rename-window":   {Fn: renameWindowCmd, ValueFlags: "t", Write: true},
+		"select-window":   {Fn: selectWindowCmd, ValueFlags: "t", Write: true},
+		"send-keys":       {Fn: sendKeysCmd, BoolFlags: "lH", ValueFlags: "t", Write: true},
+		"capture-pane":    {Fn: capturePaneCmd, BoolFlags: "peJ", ValueFlags: "tSE"},
+		"refresh-client":  {Fn: refreshClientCmd, ValueFlags: "C"},
+		"detach-client":   {Fn: nil},
+	}
+}
+
+// splits a command line into words (single and double quotes, backslash escapes)
+func splitCommandLine(cmdLine string) ([]string, error) {
+	var rtn []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, ch := range cmdLine {
+		if escaped {
+			word.WriteRune(ch)
+			escaped = false
+			continue
+		}
+		if ch == '\\' && quote != '\'' {
+			escaped = true
+			inWord = true
+			continue
+		}
+		if quote != 0 {
+			if ch == quote {
+				quote = 0
+			} else {
+				word.WriteRune(ch)
+			}
+			continue
+		}
+		if ch == '\'' || ch == '"' {
+			quote = ch
+			inWord = true
+			continue
+		}
+		if ch == ' ' || ch == '\t' {
+			if inWord {
+				rtn = append(rtn, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		}
+		word.WriteRune(ch)
+		inWord = true
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape")
+	}
+	if inWord {
+		rtn = append(rtn, word.String())
+	}
+	return rtn, nil
+}
+
+// getopt style flags ("-dP", "-t target", "-ttarget"), parsing stops at "--" or the first non-flag argument
+func parseCmdArgs(spec *cmdSpec, words []string) (*cmdArgs, error) {
+	rtn := &cmdArgs{Flags: make(map[byte]string)}
+	idx := 0
+	for ; idx < len(words); idx++ {
+		word := words[idx]
+		if word == "--" {
+			idx++
+			break
+		}
+		if len(word) < 2 || word[0] != '-' {
+			break
+		}
+		for pos := 1; pos < len(word); pos++ {
+			flag := word[pos]
+			if strings.IndexByte(spec.BoolFlags, flag) != -1 {
+				rtn.Flags[flag] = ""
+				continue
+			}
+			if strings.IndexByte(spec.ValueFlags, flag) == -1 {
+				return nil, fmt.Errorf("unknown flag -%c", flag)
+			}
+			if pos+1 < len(word) {
+				rtn.Flags[flag] = word[pos+1:]
+			} else {
+				if idx+1 >= len(words) {
+					return nil, fmt.Errorf("-%c expects an argument", flag)
+				}
+				idx++
+				rtn.Flags[flag] = words[idx]
+			}
+			break
+		}
+	}
+	rtn.Args = words[idx:]
+	return rtn, nil
+}
+
+// returns (output, exit, error)
+func (cc *ccConn) runCommand(cmdLine string) (string, bool, error) {
+	words, err := splitCommandLine(cmdLine)
+	if err != nil {
+		return "", false, err
+	}
+	if len(words) == 0 {
+		return "", false, nil
+	}
+	cmdName := words[0]
+	if fullName, found := cmdAliases[cmdName]; found {
+		cmdName = fullName
+	}
+	spec := cmdSpecs[cmdName]
+	if spec == nil {
+		return "", false, fmt.Errorf("unknown command: %s", words[0])
+	}
+	if spec.Fn == nil {
+		// detach-client
+		return "", true, nil
+	}
+	if spec.Write {
+		err = scbase.CheckReadOnly(cmdName)
+		if err != nil {
+			return "", false, err
+		}
+	}
+	args, err := parseCmdArgs(spec, words[1:])
+	if err != nil {
+		return "", false, fmt.Errorf("%s: %v", cmdName, err)
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), CommandTimeout)
+	defer cancelFn()
+	output, err := spec.Fn(ctx, cc, args)
+	if err != nil {
+		return "", false, err
+	}
+	return output, false, nil
+}
+
+// #{name}, #{?name,iftrue,iffalse} (true is not "" or "0"), and ## for #.  unknown names expand to "".
+func expandFormat(format string, vars map[string]string) string {
+	var buf strings.Builder
+	for idx := 0; idx < len(format); idx++ {
+		ch := format[idx]
+		if ch != '#' || idx+1 >= len(format) {
+			buf.WriteByte(ch)
+			continue
+		}
+		next := format[idx+1]
+		if next == '#' {
+			buf.WriteByte('#')
+			idx++
+			continue
+		}
+		if next != '{' {
+			buf.WriteByte(ch)
+			continue
+		}
+		endIdx := findFormatEnd(format, idx+2)
+		if endIdx == -1 {
+			buf.WriteString(format[idx:])
+			break
+		}
+		buf.WriteString(expandFormatItem(format[idx+2:endIdx], vars))
+		idx = endIdx
+	}
+	return buf.String()
+}
+
+// the index of the } that closes the #{ ending at start-1, -1 if it is not closed
+func findFormatEnd(format string, start int) int {
+	depth := 1
+	for idx := start; idx < len(format); idx++ {
+		switch format[idx] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return idx
+			}
+		}
+	}
+	return -1
+}
+
+// splits on the top-level commas (not inside a nested #{...})
+func splitFormatParts(item string) []string {
+	var rtn []string
+	depth := 0
+	start := 0
+	for idx := 0; idx < len(item); idx++ {
+		switch item[idx] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				rtn = append(rtn, item[start:idx])
+				start = idx + 1
+			}
+		}
+	}
+	return append(rtn, item[start:])
+}
+
+func expandFormatItem(item string, vars map[string]string) string {
+	if !strings.HasPrefix(item, "?") {
+		return vars[item]
+	}
+	parts := splitFormatParts(item[1:])
+	if len(parts) != 3 {
+		return ""
+	}
+	condVal := vars[parts[0]]
+	if condVal != "" && condVal != "0" {
+		return expandFormat(parts[1], vars)
+	}
+	return expandFormat(parts[2], vars)
+}
+
+func boolVar(val bool) string {
+	if val {
+		return "1"
+	}
+	return "0"
+}
+
+// tmux's layout checksum (layout_checksum in layout-custom.c)
+func layoutChecksum(layout string) uint16 {
+	var csum uint16
+	for idx := 0; idx < len(layout); idx++ {
+		csum = (csum >> 1) + ((csum & 1) << 15)
+		csum += uint16(layout[idx])
+	}
+	return csum
+}
+
+// a single pane layout
+func makeLayout(width int, height int, paneNum int) string {
+	layout := fmt.Sprintf("%dx%d,0,0,%d", width, height, paneNum)
+	return fmt.Sprintf("%04x,%s", layoutChecksum(layout), layout)
+}
+
+func getOpenSessions(ctx context.Context) ([]*sstore.SessionType, error) {
+	sessions, err := sstore.GetBareSessions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var rtn []*sstore.SessionType
+	for _, session := range sessions {
+		if !session.Archived {
+			rtn = append(rtn, session)
+		}
+	}
+	return rtn, nil
+}
+
+func getOpenScreens(ctx context.Context, sessionId string) ([]*sstore.ScreenType, error) {
+	screens, err := sstore.GetSessionScreens(ctx, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	var rtn []*sstore.ScreenType
+	for _, screen := range screens {
+		if !screen.Archived {
+			rtn = append(rtn, screen)
+		}
+	}
+	return rtn, nil
+}
+
+func (cc *ccConn) getSize() (int, int) {
+	cc.Lock.Lock()
+	defer cc.Lock.Unlock()
+	return cc.Width, cc.Height
+}
+
+func (cc *ccConn) makeSessionVars(session *sstore.SessionType, numWindows int) map[string]string {
+	attachedId, _ := cc.getAttached()
+	return map[string]string{
+		"session_id":       fmt.Sprintf("$%d", getNumId(session.SessionId)),
+		"session_name":     session.Name,
+		"session_windows":  strconv.Itoa(numWindows),
+		"session_attached": boolVar(session.SessionId == attachedId),
+	}
+}
+
+func (cc *ccConn) makeWindowVars(ctx context.Context, session *sstore.SessionType, numWindows int, screen *sstore.ScreenType) (map[string]string, error) {
+	vars := cc.makeSessionVars(session, numWindows)
+	width, height := cc.getSize()
+	numId := getNumId(screen.ScreenId)
+	isActive := session.ActiveScreenId == screen.ScreenId
+	vars["window_id"] = fmt.Sprintf("@%d", numId)
+	vars["window_index"] = strconv.FormatInt(screen.ScreenIdx, 10)
+	vars["window_name"] = screen.Name
+	vars["window_active"] = boolVar(isActive)
+	vars["window_panes"] = "1"
+	vars["window_width"] = strconv.Itoa(width)
+	vars["window_height"] = strconv.Itoa(height)
+	vars["window_layout"] = makeLayout(width, height, numId)
+	vars["pane_id"] = fmt.Sprintf("%%%d", numId)
+	vars["pane_index"] = "0"
+	vars["pane_active"] = "1"
+	vars["pane_width"] = strconv.Itoa(width)
+	vars["pane_height"] = strconv.Itoa(height)
+	vars["pane_dead"] = "0"
+	cmd, err := screenattach.GetRunningCmd(ctx, screen.ScreenId)
+	if err != nil {
+		return nil, err
+	}
+	if cmd != nil {
+		vars["pane_current_command"] = firstWord(cmd.CmdStr)
+	}
+	return vars, nil
+}
+
+func firstWord(str string) string {
+	fields := strings.Fields(str)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+func parseNumId(str string, prefix byte) (string, bool) {
+	if len(str) < 2 || str[0] != prefix {
+		return "", false
+	}
+	numId, err := strconv.Atoi(str[1:])
+	if err != nil {
+		return "", false
+	}
+	waveId := getWaveId(numId)
+	return waveId, waveId != ""
+}
+
+func (cc *ccConn) resolveSession(ctx context.Context, sessionArg string) (*sstore.SessionType, error) {
+	if sessionArg == "" {
+		attachedId, _ := cc.getAttached()
+		if attachedId == "" {
+			return nil, fmt.Errorf("no current session")
+		}
+		sessionArg = fmt.Sprintf("$%d", getNumId(attachedId))
+	}
+	sessions, err := getOpenSessions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sessionId, isNumId := parseNumId(sessionArg, '$')
+	for _, session := range sessions {
+		if (isNumId && session.SessionId == sessionId) || (!isNumId && session.Name == sessionArg) {
+			return session, nil
+		}
+	}
+	return nil, fmt.Errorf("can't find session: %s", sessionArg)
+}
+
+func (cc *ccConn) resolveWindow(ctx context.Context, session *sstore.SessionType, windowArg string) (*sstore.ScreenType, error) {
+	screens, err := getOpenScreens(ctx, session.SessionId)
+	if err != nil {
+		return nil, err
+	}
+	if windowArg == "" {
+		windowArg = fmt.Sprintf("@%d", getNumId(session.ActiveScreenId))
+	}
+	screenId, isNumId := parseNumId(windowArg, '@')
+	for _, screen := range screens {
+		if isNumId && screen.ScreenId == screenId {
+			return screen, nil
+		}
+		if !isNumId && (strconv.FormatInt(screen.ScreenIdx, 10) == windowArg || screen.Name == windowArg) {
+			return screen, nil
+		}
+	}
+	return nil, fmt.Errorf("can't find window: %s", windowArg)
+}
+
+// resolves a window or pane target
+func (cc *ccConn) resolveWindowTarget(ctx context.Context, target string) (*sstore.SessionType, *sstore.ScreenType, error) {
+	// @N and %N are global
+	if screenId, ok := parseNumId(target, '@'); ok {
+		return cc.resolveScreenId(ctx, screenId, target)
+	}
+	if screenId, ok := parseNumId(target, '%'); ok {
+		return cc.resolveScreenId(ctx, screenId, target)
+	}
+	sessionArg, windowArg := "", target
+	if colonIdx := strings.Index(target, ":"); colonIdx != -1 {
+		sessionArg, windowArg = target[:colonIdx], target[colonIdx+1:]
+	}
+	// panes are numbered 0 (one pane per window)
+	if dotIdx := strings.LastIndex(windowArg, "."); dotIdx != -1 {
+		windowArg = windowArg[:dotIdx]
+	}
+	session, err := cc.resolveSession(ctx, sessionArg)
+	if err != nil {
+		return nil, nil, err
+	}
+	screen, err := cc.resolveWindow(ctx, session, windowArg)
+	if err != nil {
+		if sessionArg != "" || windowArg == "" {
+			return nil, nil, err
+		}
+		// a plain session name (the session's active window)
+		session, sessionErr := cc.resolveSession(ctx, windowArg)
+		if sessionErr != nil {
+			return nil, nil, err
+		}
+		screen, err = cc.resolveWindow(ctx, session, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		return session, screen, nil
+	}
+	return session, screen, nil
+}
+
+func (cc *ccConn) resolveScreenId(ctx context.Context, screenId string, target string) (*sstore.SessionType, *sstore.ScreenType, error) {
+	screen, err := sstore.GetScreenById(ctx, screenId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if screen == nil || screen.Archived {
+		return nil, nil, fmt.Errorf("can't find window: %s", target)
+	}
+	session, err := sstore.GetBareSessionById(ctx, screen.SessionId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if session == nil {
+		return nil, nil, fmt.Errorf("can't find session for window: %s", target)
+	}
+	return session, screen, nil
+}
+
+func defaultStr(str string, def string) string {
+	if str == "" {
+		return def
+	}
+	return str
+}
+
+func listSessionsCmd(ctx context.Context, cc *ccConn, args *cmdArgs) (string, error) {
+	sessions, err := getOpenSessions(ctx)
+	if err != nil {
+		return "", err
+	}
+	format := defaultStr(args.Flags['F'], defaultSessionsFormat)
+	var lines []string
+	for _, session := range sessions {
+		screens, err := getOpenScreens(ctx, session.SessionId)
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, expandFormat(format, cc.makeSessionVars(session, len(screens))))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func (cc *ccConn) windowLines(ctx context.Context, session *sstore.SessionType, format string, onlyScreenId string) ([]string, error) {
+	screens, err := getOpenScreens(ctx, session.SessionId)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, screen := range screens {
+		if onlyScreenId != "" && screen.ScreenId != onlyScreenId {
+			continue
+		}
+		vars, err := cc.makeWindowVars(ctx, session, len(screens), screen)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, expandFormat(format, vars))
+	}
+	return lines, nil
+}
+
+// runs fn for the target session, or for all sessions (allSessions)
+func (cc *ccConn) forSessions(ctx context.Context, allSessions bool, sessionArg string, fn func(session *sstore.SessionType) error) error {
+	if !allSessions {
+		session, err := cc.resolveSession(ctx, sessionArg)
+		if err != nil {
+			return err
+		}
+		return fn(session)
+	}
+	sessions, err := getOpenSessions(ctx)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		err = fn(session)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func listWindowsCmd(ctx context.Context, cc *ccConn, args *cmdArgs) (string, error) {
+	format := defaultStr(args.Flags['F'], defaultWindowsFormat)
+	var lines []string
+	err := cc.forSessions(ctx, args.has('a'), args.Flags['t'], func(session *sstore.SessionType) error {
+		sessionLines, err := cc.windowLines(ctx, session, format, "")
+		lines = append(lines, sessionLines...)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func listPanesCmd(ctx context.Context, cc *ccConn, args *cmdArgs) (string, error) {
+	format := defaultStr(args.Flags['F'], defaultPanesFormat)
+	if !args.has('a') && !args.has('s') {
+		session, screen, err := cc.resolveWindowTarget(ctx, args.Flags['t'])
+		if err != nil {
+			return "", err
+		}
+		lines, err := cc.windowLines(ctx, session, format, screen.ScreenId)
+		return strings.Join(lines, "\n"), err
+	}
+	var lines []string
+	err := cc.forSessions(ctx, args.has('a'), args.Flags['t'], func(session *sstore.SessionType) error {
+		sessionLines, err := cc.windowLines(ctx, session, format, "")
+		lines = append(lines, sessionLines...)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func displayMessageCmd(ctx context.Context, cc *ccConn, args *cmdArgs) (string, error) {
+	if !args.has('p') {
+		// there is no status line to show the message on
+		return "", nil
+	}
+	session, screen, err := cc.resolveWindowTarget(ctx, args.Flags['t'])
+	if err != nil {
+		return "", err
+	}
+	lines, err := cc.windowLines(ctx, session, strings.Join(args.Args, " "), screen.ScreenId)
+	return strings.Join(lines, "\n"), err
+}
+
+func hasSessionCmd(ctx context.Context, cc *ccConn, args *cmdArgs) (string, error) {
+	_, err := cc.resolveSession(ctx, args.Flags['t'])
+	return "", err
+}
+
+func validateTmuxName(name string) error {
+	if len(name) > MaxNameLen {
+		return fmt.Errorf("name too long, max length is %d", MaxNameLen)
+	}
+	if strings.ContainsAny(name, "\r\n") {
+		return fmt.Errorf("invalid name")
+	}
+	return nil
+}
+
+func (cc *ccConn) attachAndNotify(ctx context.Context, sessionId string) error {
+	err := cc.attach(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+	cc.writeSessionChanged()
+	return nil
+}
+
+func newSessionCmd(ctx context.Context, cc *ccConn, args *cmdArgs) (string, error) {
+	name := args.Flags['s']
+	err := validateTmuxName(name)
+	if err != nil {
+		return "", err
+	}
+	update, sessionId, _, err := sstore.InsertSessionWithName(ctx, name, !args.has('d'))
+	if err != nil {
+		return "", err
+	}
+	scbus.MainUpdateBus.DoUpdate(update)
+	if !args.has('d') {
+		err = cc.attachAndNotify(ctx, sessionId)
+		if err != nil {
+			return "", err
+		}
+	}
+	if !args.has('P') {
+		return "", nil
+	}
+	session, err := cc.resolveSession(ctx, fmt.Sprintf("$%d", getNumId(sessionId)))
+	if err != nil {
+		return "", err
+	}
+	return expandFormat(defaultStr(args.Flags['F'], defaultNewSessionFormat), cc.makeSessionVars(session, 1)), nil
+}
+
+func switchClientCmd(ctx context.Context, cc *ccConn, args *cmdArgs) (string, error) {
+	session, err := cc.resolveSession(ctx, args.Flags['t'])
+	if err != nil {
+		return "", err
+	}
+	return "", cc.attachAndNotify(ctx, session.SessionId)
+}
+
+func renameSessionCmd(ctx context.Context, cc *ccConn, args *cmdArgs) (string, error) {
+	if len(args.Args) != 1 || args.Args[0] == "" {
+		return "", fmt.Errorf("usage: rename-session [-t target-session] new-name")
+	}
+	name := args.Args[0]
+	err := validateTmuxName(name)
+	if err != nil {
+		return "", err
+	}
+	session, err := cc.resolveSession(ctx, args.Flags['t'])
+	if err != nil {
+		return "", err
+	}
+	err = sstore.SetSessionName(ctx, session.SessionId, name)
+	if err != nil {
+		return "", err
+	}
+	bareSession, err := sstore.GetBareSessionById(ctx, session.SessionId)
+	if err != nil {
+		return "", err
+	}
+	if bareSession != nil {
+		update := scbus.MakeUpdatePacket()
+		update.AddUpdate(*bareSession)
+		scbus.MainUpdateBus.DoUpdate(update)
+	}
+	return "", nil
+}
+
+func newWindowCmd(ctx context.Context, cc *ccConn, args *cmdArgs) (string, error) {
+	name := args.Flags['n']
+	err := validateTmuxName(name)
+	if err != nil {
+		return "", err
+	}
+	session, err := cc.resolveSession(ctx, args.Flags['t'])
+	if err != nil {
+		return "", err
+	}
+	var screenId string
+	update, err := sstore.InsertScreen(ctx, session.SessionId, name, sstore.ScreenCreateOpts{RtnScreenId: &screenId}, !args.has('d'))
+	if err != nil {
+		return "", err
+	}
+	scbus.MainUpdateBus.DoUpdate(update)
+	if len(args.Args) > 0 {
+		err = cc.Server.RunCmdFn(ctx, screenId, strings.Join(args.Args, " "))
+		if err != nil {
+			return "", fmt.Errorf("error running command: %v", err)
+		}
+	}
+	if !args.has('P') {
+		return "", nil
+	}
+	session, screen, err := cc.resolveScreenId(ctx, screenId, "")
+	if err != nil {
+		return "", err
+	}
+	lines, err := cc.windowLines(ctx, session, defaultStr(args.Flags['F'], defaultNewWindowFormat), screen.ScreenId)
+	return strings.Join(lines, "\n"), err
+}
+
+func killWindowCmd(ctx context.Context, cc *ccConn, args *cmdArgs) (string, error) {
+	_, screen, err := cc.resolveWindowTarget(ctx, args.Flags['t'])
+	if err != nil {
+		return "", err
+	}
+	// through the wave command, so running commands are hung up
+	return "", cc.Server.RunCmdFn(ctx, screen.ScreenId, "/screen:delete")
+}
+
+func renameWindowCmd(ctx context.Context, cc *ccConn, args *cmdArgs) (string, error) {
+	if len(args.Args) != 1 || args.Args[0] == "" {
+		return "", fmt.Errorf("usage: rename-window [-t target-window] new-name")
+	}
+	name := args.Args[0]
+	err := validateTmuxName(name)
+	if err != nil {
+		return "", err
+	}
+	session, screen, err := cc.resolveWindowTarget(ctx, args.Flags['t'])
+	if err != nil {
+		return "", err
+	}
+	err = sstore.SetScreenName(ctx, session.SessionId, screen.ScreenId, name)
+	if err != nil {
+		return "", err
+	}
+	screen, err = sstore.GetScreenById(ctx, screen.ScreenId)
+	if err != nil {
+		return "", err
+	}
+	if screen != nil {
+		update := scbus.MakeUpdatePacket()
+		update.AddUpdate(*screen)
+		scbus.MainUpdateBus.DoUpdate(update)
+	}
+	return "", nil
+}
+
+func selectWindowCmd(ctx context.Context, cc *ccConn, args *cmdArgs) (string, error) {
+	session, screen, err := cc.resolveWindowTarget(ctx, args.Flags['t'])
+	if err != nil {
+		return "", err
+	}
+	update, err := sstore.SwitchScreenById(ctx, session.SessionId, screen.ScreenId)
+	if err != nil {
+		return "", err
+	}
+	scbus.MainUpdateBus.DoUpdate(update)
+	return "", nil
+}
+
+func capturePaneCmd(ctx context.Context, cc *ccConn, args *cmdArgs) (string, error) {
+	if !args.has('p') {
+		return "", fmt.Errorf("capture-pane: only -p (print) is supported")
+	}
+	_, screen, err := cc.resolveWindowTarget(ctx, args.Flags['t'])
+	if err != nil {
+		return "", err
+	}
+	cmd, err := sstore.GetLastScreenCmd(ctx, screen.ScreenId)
+	if err != nil || cmd == nil {
+		return "", err
+	}
+	_, data, err := sstore.ReadFullPtyOutFile(ctx, screen.ScreenId, cmd.LineId)
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	if args.has('e') {
+		lines = strings.Split(strings.TrimSuffix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n"), "\n")
+	} else {
+		lines = sstore.StripAnsiLines(data)
+	}
+	_, height := cc.getSize()
+	startIdx, endIdx, err := getCaptureRange(len(lines), height, args.Flags['S'], args.Flags['E'])
+	if err != nil {
+		return "", err
+	}
+	if startIdx > endIdx {
+		return "", nil
+	}
+	return strings.Join(lines[startIdx:endIdx+1], "\n"), nil
+}
+
+// line 0 is the first visible line (the last height lines), negative lines are in the history, "-" is the start
+// (-S) or end (-E) of the output.  returns the (clamped) indexes of the first and last line.
+func getCaptureRange(numLines int, height int, startArg string, endArg string) (int, int, error) {
+	visibleStart := max(0, numLines-height)
+	parseLine := func(arg string, def int, dashVal int) (int, error) {
+		if arg == "" {
+			return def, nil
+		}
+		if arg == "-" {
+			return dashVal, nil
+		}
+		lineNum, err := strconv.Atoi(arg)
+		if err != nil {
+			return 0, fmt.Errorf("invalid line %q", arg)
+		}
+		return visibleStart + lineNum, nil
+	}
+	startIdx, err := parseLine(startArg, visibleStart, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	endIdx, err := parseLine(endArg, numLines-1, numLines-1)
+	if err != nil {
+		return 0, 0, err
+	}
+	return max(0, startIdx), min(numLines-1, endIdx), nil
+}
+
+func refreshClientCmd(ctx context.Context, cc *ccConn, args *cmdArgs) (string, error) {
+	sizeArg, found := args.Flags['C']
+	if !found {
+		return "", nil
+	}
+	sizeParts := strings.FieldsFunc(sizeArg, func(r rune) bool { return r == ',' || r == 'x' })
+	if len(sizeParts) != 2 {
+		return "", fmt.Errorf("refresh-client: invalid size %q", sizeArg)
+	}
+	width, werr := strconv.Atoi(sizeParts[0])
+	height, herr := strconv.Atoi(sizeParts[1])
+	if werr != nil || herr != nil || width <= 0 || height <= 0 || width > 10000 || height > 10000 {
+		return "", fmt.Errorf("refresh-client: invalid size %q", sizeArg)
+	}
+	cc.Lock.Lock()
+	cc.Width = width
+	cc.Height = height
+	cc.Lock.Unlock()
+	return "", nil
+}
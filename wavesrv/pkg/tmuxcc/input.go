@@ -0,0 +1,123 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package tmuxcc
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/screenattach"
+)
+
+var keyNames = map[string]string{
+	"Enter":    "\r",
+	"Tab":      "\t",
+	"Space":    " ",
+	"BSpace":   "\x7f",
+	"Escape":   "\x1b",
+	"Up":       "\x1b[A",
+	"Down":     "\x1b[B",
+	"Right":    "\x1b[C",
+	"Left":     "\x1b[D",
+	"Home":     "\x1b[H",
+	"End":      "\x1b[F",
+	"DC":       "\x1b[3~",
+	"Delete":   "\x1b[3~",
+	"PageUp":   "\x1b[5~",
+	"PPage":    "\x1b[5~",
+	"PageDown": "\x1b[6~",
+	"NPage":    "\x1b[6~",
+}
+
+// a key name (Enter, C-c, ...), anything else is sent as is
+func keyToBytes(key string) string {
+	if keyStr, found := keyNames[key]; found {
+		return keyStr
+	}
+	if len(key) == 3 && (strings.HasPrefix(key, "C-") || strings.HasPrefix(key, "^")) {
+		ch := key[len(key)-1]
+		if ch >= 'A' && ch <= 'Z' {
+			ch += 'a' - 'A'
+		}
+		if ch >= 'a' && ch <= 'z' {
+			return string([]byte{ch - 'a' + 1})
+		}
+	}
+	if len(key) == 2 && key[0] == '^' {
+		return keyToBytes("C-" + key[1:])
+	}
+	return key
+}
+
+func sendKeysInput(args *cmdArgs) ([]byte, error) {
+	var buf []byte
+	for _, arg := range args.Args {
+		if args.has('H') {
+			byteVal, err := strconv.ParseUint(arg, 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("send-keys: invalid hex key %q", arg)
+			}
+			buf = append(buf, byte(byteVal))
+			continue
+		}
+		if args.has('l') {
+			buf = append(buf, arg...)
+			continue
+		}
+		buf = append(buf, keyToBytes(arg)...)
+	}
+	return buf, nil
+}
+
+func sendKeysCmd(ctx context.Context, cc *ccConn, args *cmdArgs) (string, error) {
+	_, screen, err := cc.resolveWindowTarget(ctx, args.Flags['t'])
+	if err != nil {
+		return "", err
+	}
+	input, err := sendKeysInput(args)
+	if err != nil || len(input) == 0 {
+		return "", err
+	}
+	cmd, err := screenattach.GetRunningCmd(ctx, screen.ScreenId)
+	if err != nil {
+		return "", err
+	}
+	if cmd != nil {
+		return "", screenattach.SendCmdInput(cmd, input, "")
+	}
+	return "", cc.editCommandLine(ctx, screen.ScreenId, input)
+}
+
+// nothing is running on the screen, input is edited as a command line and run on enter
+func (cc *ccConn) editCommandLine(ctx context.Context, screenId string, input []byte) error {
+	cc.Lock.Lock()
+	ed := cc.InputBufs[screenId]
+	if ed == nil {
+		ed = &screenattach.LineEditor{}
+		cc.InputBufs[screenId] = ed
+	}
+	var echo []byte
+	var cmdLines []string
+	for _, ch := range input {
+		chEcho, line, entered := ed.Edit(ch)
+		echo = append(echo, chEcho...)
+		if entered && strings.TrimSpace(line) != "" {
+			cmdLines = append(cmdLines, line)
+		}
+	}
+	cc.Lock.Unlock()
+	err := cc.writeOutput(screenId, echo)
+	if err != nil {
+		return err
+	}
+	for _, cmdLine := range cmdLines {
+		err = cc.Server.RunCmdFn(ctx, screenId, cmdLine)
+		if err != nil {
+			cc.writeOutput(screenId, []byte(fmt.Sprintf("error: %v\r\n", err)))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,456 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// a tmux control mode (tmux -CC) compatible adapter, so tools and muxer-aware clients that speak the control
+// mode protocol can drive wave.  the server listens on a unix socket ([wavehome]/tmux-cc.sock, owner only,
+// started with /client:set tmuxcontrol=1), each connection is a control mode client.
+//
+// mapping: tmux sessions are wave sessions ($N), windows are screens (@N), and each window has one pane (%N,
+// same number as the window).  the numeric ids are assigned on first use and are stable while wavesrv runs.
+// pane output is the pty output of the screen's commands.  input sent to a pane goes to the screen's running
+// command, when nothing is running it is edited as a command line (echoed back) and run on enter.
+//
+// protocol subset (one command per line, an empty line detaches):
+//
+//	%begin [time] [num] 1 / %end [time] [num] 1      command output (%error instead of %end on failure)
+//	%output %N [data]                                 pane output (non-printable bytes and \ as \ooo)
+//	%window-add @N, %window-close @N, %window-renamed @N [name]
+//	%session-changed $N [name], %session-renamed [name], %session-window-changed $N @N
+//	%sessions-changed, %exit
+//
+// see commands.go for the supported commands.
+package tmuxcc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbase"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/scbus"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/screenattach"
+	"github.com/wavetermdev/waveterm/wavesrv/pkg/sstore"
+)
+
+const SocketName = "tmux-cc.sock"
+const DefaultWidth = 80
+const DefaultHeight = 24
+const MaxCommandLen = 64 * 1024
+const CommandTimeout = 10 * time.Second
+
+type StatusType struct {
+	Running    bool
+	SocketPath string
+	NumClients int
+}
+
+type ccServer struct {
+	Listener   net.Listener
+	SocketPath string
+	RunCmdFn   screenattach.RunCmdFnType // shell commands and metacommands (kill-window runs /screen:delete)
+	Conns      map[*ccConn]bool
+	Stopped    bool
+}
+
+var serverLock = &sync.Mutex{}
+var curServer *ccServer
+var connCounter int
+
+// tmux ids are numbers, wave ids are uuids
+var idLock = &sync.Mutex{}
+var numIds = make(map[string]int)
+var waveIds = make(map[int]string)
+var nextNumId = 1
+
+func getNumId(waveId string) int {
+	idLock.Lock()
+	defer idLock.Unlock()
+	if numId, found := numIds[waveId]; found {
+		return numId
+	}
+	numId := nextNumId
+	nextNumId++
+	numIds[waveId] = numId
+	waveIds[numId] = waveId
+	return numId
+}
+
+// returns "" if the number was never assigned
+func getWaveId(numId int) string {
+	idLock.Lock()
+	defer idLock.Unlock()
+	return waveIds[numId]
+}
+
+func GetSocketPath() string {
+	return filepath.Join(scbase.GetWaveHomeDir(), SocketName)
+}
+
+// starts the control mode server (no-op if it is already running)
+func Start(runCmdFn screenattach.RunCmdFnType) error {
+	if runCmdFn == nil {
+		return fmt.Errorf("tmux control mode requires a command runner")
+	}
+	serverLock.Lock()
+	defer serverLock.Unlock()
+	if curServer != nil {
+		return nil
+	}
+	socketPath := GetSocketPath()
+	// a stale socket from a previous run
+	os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s: %v", socketPath, err)
+	}
+	err = os.Chmod(socketPath, 0600)
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("cannot set permissions on %s: %v", socketPath, err)
+	}
+	server := &ccServer{Listener: listener, SocketPath: socketPath, RunCmdFn: runCmdFn, Conns: make(map[*ccConn]bool)}
+	curServer = server
+	log.Printf("[tmuxcc] listening on %s\n", socketPath)
+	go server.acceptLoop()
+	return nil
+}
+
+// stops the server and closes all client connections, returns false if it was not running
+func Stop() bool {
+	serverLock.Lock()
+	defer serverLock.Unlock()
+	if curServer == nil {
+		return false
+	}
+	log.Printf("[tmuxcc] stopping server\n")
+	curServer.Stopped = true
+	curServer.Listener.Close()
+	for cc := range curServer.Conns {
+		cc.Conn.Close()
+	}
+	curServer = nil
+	return true
+}
+
+func GetStatus() StatusType {
+	serverLock.Lock()
+	defer serverLock.Unlock()
+	if curServer == nil {
+		return StatusType{}
+	}
+	return StatusType{Running: true, SocketPath: curServer.SocketPath, NumClients: len(curServer.Conns)}
+}
+
+func (server *ccServer) acceptLoop() {
+	for {
+		conn, err := server.Listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("[tmuxcc] accept error: %v\n", err)
+			}
+			return
+		}
+		cc := server.addConn(conn)
+		if cc == nil {
+			conn.Close()
+			return
+		}
+		go server.runConn(cc)
+	}
+}
+
+// returns nil if the server was stopped
+func (server *ccServer) addConn(conn net.Conn) *ccConn {
+	serverLock.Lock()
+	defer serverLock.Unlock()
+	if server.Stopped {
+		return nil
+	}
+	connCounter++
+	cc := &ccConn{
+		Server:    server,
+		Conn:      conn,
+		Key:       "tmuxcc:" + strconv.Itoa(connCounter),
+		Width:     DefaultWidth,
+		Height:    DefaultHeight,
+		Screens:   make(map[string]string),
+		InputBufs: make(map[string]*screenattach.LineEditor),
+	}
+	server.Conns[cc] = true
+	return cc
+}
+
+func (server *ccServer) removeConn(cc *ccConn) {
+	serverLock.Lock()
+	defer serverLock.Unlock()
+	delete(server.Conns, cc)
+}
+
+// receives all updates, filtered by the connection (updateLoop)
+type ccChannel struct {
+	ch chan scbus.UpdatePacket
+}
+
+func (c *ccChannel) GetChannel() chan scbus.UpdatePacket {
+	return c.ch
+}
+
+func (c *ccChannel) SetChannel(ch chan scbus.UpdatePacket) {
+	c.ch = ch
+}
+
+func (c *ccChannel) Match(screenId string) bool {
+	return true
+}
+
+type ccConn struct {
+	Server    *ccServer
+	Conn      net.Conn
+	Key       string // scbus channel key
+	WriteLock sync.Mutex
+
+	// protected by Lock
+	Lock           sync.Mutex
+	SessionId      string // attached session
+	SessionName    string
+	ActiveScreenId string
+	Screens        map[string]string // screenid -> name, the windows of the attached session
+	Width          int
+	Height         int
+	InputBufs      map[string]*screenattach.LineEditor // screenid -> command line being typed (no running command)
+	CmdNum         int
+}
+
+func (cc *ccConn) write(str string) error {
+	cc.WriteLock.Lock()
+	defer cc.WriteLock.Unlock()
+	_, err := cc.Conn.Write([]byte(str))
+	return err
+}
+
+// the output of one command, the block is written at once so notifications are never inside it
+func (cc *ccConn) writeBlock(cmdNum int, output string, cmdErr error) error {
+	ts := time.Now().Unix()
+	var buf strings.Builder
+	buf.WriteString(fmt.Sprintf("%%begin %d %d 1\n", ts, cmdNum))
+	if cmdErr != nil {
+		buf.WriteString(strings.ReplaceAll(cmdErr.Error(), "\n", " "))
+		buf.WriteString("\n")
+		buf.WriteString(fmt.Sprintf("%%error %d %d 1\n", ts, cmdNum))
+	} else {
+		if output != "" {
+			buf.WriteString(strings.TrimSuffix(output, "\n"))
+			buf.WriteString("\n")
+		}
+		buf.WriteString(fmt.Sprintf("%%end %d %d 1\n", ts, cmdNum))
+	}
+	return cc.write(buf.String())
+}
+
+func (server *ccServer) runConn(cc *ccConn) {
+	defer server.removeConn(cc)
+	defer cc.Conn.Close()
+	log.Printf("[tmuxcc] client connected %s\n", cc.Key)
+	// registered before attaching so no window changes are missed
+	updateCh := scbus.MainUpdateBus.RegisterChannel(cc.Key, &ccChannel{})
+	defer scbus.MainUpdateBus.UnregisterChannel(cc.Key)
+	go cc.updateLoop(updateCh)
+	ctx, cancelFn := context.WithTimeout(context.Background(), CommandTimeout)
+	sessionId, err := sstore.GetActiveSessionId(ctx)
+	if err == nil && sessionId != "" {
+		err = cc.attach(ctx, sessionId)
+	}
+	cancelFn()
+	// the reply to the implicit attach command
+	cc.writeBlock(0, "", nil)
+	if err != nil {
+		log.Printf("[tmuxcc] error attaching to the active session: %v\n", err)
+	} else {
+		cc.writeSessionChanged()
+	}
+	scanner := bufio.NewScanner(cc.Conn)
+	scanner.Buffer(make([]byte, 4096), MaxCommandLen)
+	for scanner.Scan() {
+		cmdLine := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.TrimSpace(cmdLine) == "" {
+			break
+		}
+		output, exit, err := cc.runCommand(cmdLine)
+		cc.Lock.Lock()
+		cc.CmdNum++
+		cmdNum := cc.CmdNum
+		cc.Lock.Unlock()
+		if cc.writeBlock(cmdNum, output, err) != nil || exit {
+			break
+		}
+	}
+	cc.write("%exit\n")
+	log.Printf("[tmuxcc] client disconnected %s\n", cc.Key)
+}
+
+// attaches the connection to the session (loads its windows)
+func (cc *ccConn) attach(ctx context.Context, sessionId string) error {
+	session, err := sstore.GetBareSessionById(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+	if session == nil {
+		return fmt.Errorf("session not found")
+	}
+	screens, err := sstore.GetSessionScreens(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+	cc.Lock.Lock()
+	defer cc.Lock.Unlock()
+	cc.SessionId = session.SessionId
+	cc.SessionName = session.Name
+	cc.ActiveScreenId = session.ActiveScreenId
+	cc.Screens = make(map[string]string)
+	for _, screen := range screens {
+		if !screen.Archived {
+			cc.Screens[screen.ScreenId] = screen.Name
+		}
+	}
+	return nil
+}
+
+func (cc *ccConn) getAttached() (string, string) {
+	cc.Lock.Lock()
+	defer cc.Lock.Unlock()
+	return cc.SessionId, cc.ActiveScreenId
+}
+
+func (cc *ccConn) writeSessionChanged() {
+	cc.Lock.Lock()
+	sessionId, sessionName := cc.SessionId, cc.SessionName
+	cc.Lock.Unlock()
+	if sessionId != "" {
+		cc.write(fmt.Sprintf("%%session-changed $%d %s\n", getNumId(sessionId), sessionName))
+	}
+}
+
+// tmux escapes non-printable characters and backslashes in %output as octal
+func escapeOutput(data []byte) string {
+	var buf strings.Builder
+	for _, ch := range data {
+		if ch < ' ' || ch == '\\' || ch == 0x7f {
+			buf.WriteString(fmt.Sprintf("\\%03o", ch))
+			continue
+		}
+		buf.WriteByte(ch)
+	}
+	return buf.String()
+}
+
+func (cc *ccConn) writeOutput(screenId string, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return cc.write(fmt.Sprintf("%%output %%%d %s\n", getNumId(screenId), escapeOutput(data)))
+}
+
+// converts a bus update to notifications for the attached session
+func (cc *ccConn) makeNotifications(update scbus.UpdatePacket) []string {
+	var rtn []string
+	cc.Lock.Lock()
+	defer cc.Lock.Unlock()
+	switch upk := update.(type) {
+	case *scbus.PtyDataUpdatePacketType:
+		if upk.Data == nil || upk.Data.LineId == "" {
+			return nil
+		}
+		if _, found := cc.Screens[upk.Data.ScreenId]; !found {
+			return nil
+		}
+		data, err := upk.Data.GetPtyData()
+		if err != nil || len(data) == 0 {
+			return nil
+		}
+		rtn = append(rtn, fmt.Sprintf("%%output %%%d %s", getNumId(upk.Data.ScreenId), escapeOutput(data)))
+	case *scbus.ModelUpdatePacketType:
+		if upk.IsEmpty() {
+			return nil
+		}
+		for _, item := range *upk.Data {
+			switch uitem := item.(type) {
+			case sstore.ScreenType:
+				rtn = append(rtn, cc.screenNotifications_nolock(&uitem)...)
+			case sstore.SessionType:
+				rtn = append(rtn, cc.sessionNotifications_nolock(&uitem)...)
+			}
+		}
+	}
+	return rtn
+}
+
+// must hold cc.Lock
+func (cc *ccConn) screenNotifications_nolock(screen *sstore.ScreenType) []string {
+	numId := getNumId(screen.ScreenId)
+	oldName, found := cc.Screens[screen.ScreenId]
+	if screen.Remove || screen.Archived {
+		if !found {
+			return nil
+		}
+		delete(cc.Screens, screen.ScreenId)
+		delete(cc.InputBufs, screen.ScreenId)
+		return []string{fmt.Sprintf("%%window-close @%d", numId)}
+	}
+	if screen.SessionId != cc.SessionId || cc.SessionId == "" {
+		return nil
+	}
+	cc.Screens[screen.ScreenId] = screen.Name
+	if !found {
+		return []string{fmt.Sprintf("%%window-add @%d", numId)}
+	}
+	if oldName != screen.Name {
+		return []string{fmt.Sprintf("%%window-renamed @%d %s", numId, screen.Name)}
+	}
+	return nil
+}
+
+// must hold cc.Lock
+func (cc *ccConn) sessionNotifications_nolock(session *sstore.SessionType) []string {
+	if session.Name == "" && !session.Remove {
+		// partial update (e.g. the session's remotes)
+		return nil
+	}
+	if session.SessionId != cc.SessionId {
+		return []string{"%sessions-changed"}
+	}
+	if session.Remove {
+		cc.SessionId = ""
+		cc.Screens = make(map[string]string)
+		return []string{"%sessions-changed"}
+	}
+	var rtn []string
+	if session.Name != "" && session.Name != cc.SessionName {
+		cc.SessionName = session.Name
+		rtn = append(rtn, "%session-renamed "+session.Name, "%sessions-changed")
+	}
+	if session.ActiveScreenId != "" && session.ActiveScreenId != cc.ActiveScreenId {
+		cc.ActiveScreenId = session.ActiveScreenId
+		rtn = append(rtn, fmt.Sprintf("%%session-window-changed $%d @%d", getNumId(cc.SessionId), getNumId(session.ActiveScreenId)))
+	}
+	return rtn
+}
+
+func (cc *ccConn) updateLoop(updateCh chan scbus.UpdatePacket) {
+	for update := range updateCh {
+		for _, notification := range cc.makeNotifications(update) {
+			err := cc.write(notification + "\n")
+			if err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2024, Command Line Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package tmuxcc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEscapeOutput(t *testing.T) {
+	got := escapeOutput([]byte("ls\r\na\\b\x1b[0m\x7f"))
+	want := "ls\\015\\012a\\134b\\033[0m\\177"
+	if got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
+
+func TestSplitCommandLine(t *testing.T) {
+	words, err := splitCommandLine(`send-keys -t %1 "echo hi" 'a "b"' c\ d  Enter`)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	want := []string{"send-keys", "-t", "%1", "echo hi", `a "b"`, "c d", "Enter"}
+	if !reflect.DeepEqual(words, want) {
+		t.Errorf("got %q want %q", words, want)
+	}
+	_, err = splitCommandLine(`rename-window "abc`)
+	if err == nil {
+		t.Errorf("expected an error for an unterminated quote")
+	}
+}
+
+func TestParseCmdArgs(t *testing.T) {
+	spec := &cmdSpec{BoolFlags: "dP", ValueFlags: "Fnt"}
+	args, err := parseCmdArgs(spec, []string{"-dP", "-t@2", "-F", "#{window_id}", "--", "-ls"})
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if !args.has('d') || !args.has('P') || args.has('n') {
+		t.Errorf("bad bool flags %v", args.Flags)
+	}
+	if args.Flags['t'] != "@2" || args.Flags['F'] != "#{window_id}" {
+		t.Errorf("bad value flags %v", args.Flags)
+	}
+	if !reflect.DeepEqual(args.Args, []string{"-ls"}) {
+		t.Errorf("bad args %q", args.Args)
+	}
+	_, err = parseCmdArgs(spec, []string{"-x"})
+	if err == nil {
+		t.Errorf("expected an error for an unknown flag")
+	}
+	_, err = parseCmdArgs(spec, []string{"-t"})
+	if err == nil {
+		t.Errorf("expected an error for a missing flag value")
+	}
+}
+
+func TestExpandFormat(t *testing.T) {
+	vars := map[string]string{"window_id": "@3", "window_name": "build", "window_active": "1", "session_attached": "0"}
+	tests := map[string]string{
+		"#{window_id} #{window_name}":                   "@3 build",
+		"#{?window_active,*,-}#{?session_attached,a,b}": "*b",
+		"#{?window_active,#{window_name},none}":         "build",
+		"## #{missing}x":                                "# x",
+		"#{unclosed":                                    "#{unclosed",
+	}
+	for format, want := range tests {
+		got := expandFormat(format, vars)
+		if got != want {
+			t.Errorf("%q got %q want %q", format, got, want)
+		}
+	}
+}
+
+func TestMakeLayout(t *testing.T) {
+	// matches tmux for a new 80x24 window with pane %0
+	got := makeLayout(80, 24, 0)
+	if got != "b25d,80x24,0,0,0" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestGetCaptureRange(t *testing.T) {
+	// 100 lines, 24 visible (lines 76-99)
+	tests := []struct {
+		start, end       string
+		wantStart, wantE int
+	}{
+		{"", "", 76, 99},
+		{"-", "", 0, 99},
+		{"-10", "-1", 66, 75},
+		{"0", "5", 76, 81},
+		{"-200", "500", 0, 99},
+	}
+	for _, test := range tests {
+		startIdx, endIdx, err := getCaptureRange(100, 24, test.start, test.end)
+		if err != nil || startIdx != test.wantStart || endIdx != test.wantE {
+			t.Errorf("-S %q -E %q got %d %d %v", test.start, test.end, startIdx, endIdx, err)
+		}
+	}
+}
+
+func TestKeyToBytes(t *testing.T) {
+	tests := map[string]string{"Enter": "\r", "C-c": "\x03", "^D": "\x04", "Up": "\x1b[A", "echo": "echo", "C-": "C-"}
+	for key, want := range tests {
+		got := keyToBytes(key)
+		if got != want {
+			t.Errorf("%q got %q want %q", key, got, want)
+		}
+	}
+}
@@ -79,4 +79,5 @@ func GetUserInput(ctx context.Context, bus *scbus.RpcBus, userInputRequest *User
 func init() {
 	// Register the user input request packet type
 	packet.RegisterPacketType(UserInputResponsePacketStr, reflect.TypeOf(UserInputResponsePacketType{}))
+	scbus.RegisterModelUpdateItem((*UserInputRequestType)(nil))
 }
@@ -28,6 +28,12 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin:      func(r *http.Request) bool { return true },
 }
 
+// a websocket message to write, text (json) unless Binary is set
+type WSMessage struct {
+	Binary bool
+	Data   []byte
+}
+
 type WSShell struct {
 	Conn       *websocket.Conn
 	RemoteAddr string
@@ -40,13 +46,13 @@ type WSShell struct {
 	Header     http.Header
 
 	CloseChan chan bool
-	WriteChan chan []byte
+	WriteChan chan WSMessage
 	ReadChan  chan []byte
 }
 
 func (ws *WSShell) NonBlockingWrite(data []byte) bool {
 	select {
-	case ws.WriteChan <- data:
+	case ws.WriteChan <- WSMessage{Data: data}:
 		return true
 
 	default:
@@ -76,7 +82,15 @@ func (ws *WSShell) WriteJson(val interface{}) error {
 	if err != nil {
 		return err
 	}
-	ws.WriteChan <- barr
+	ws.WriteChan <- WSMessage{Data: barr}
+	return nil
+}
+
+func (ws *WSShell) WriteBinary(data []byte) error {
+	if ws.IsClosed() {
+		return fmt.Errorf("cannot write binary message, empty or closed wsshell")
+	}
+	ws.WriteChan <- WSMessage{Binary: true, Data: data}
 	return nil
 }
 
@@ -100,12 +114,16 @@ func (ws *WSShell) WritePump() {
 				ticker.Reset(pingPeriodTickTime)
 			}
 
-		case msgBytes, ok := <-ws.WriteChan:
+		case msg, ok := <-ws.WriteChan:
 			if !ok {
 				return
 			}
+			msgType := websocket.TextMessage
+			if msg.Binary {
+				msgType = websocket.BinaryMessage
+			}
 			_ = ws.Conn.SetWriteDeadline(time.Now().Add(writeWaitTimeout)) // no error
-			err := ws.Conn.WriteMessage(websocket.TextMessage, msgBytes)
+			err := ws.Conn.WriteMessage(msgType, msg.Data)
 			if err != nil {
 				log.Printf("WritePump %s err: %v\n", ws.RemoteAddr, err)
 				return
@@ -143,7 +161,7 @@ func (ws *WSShell) ReadPump() {
 			now := time.Now()
 			pongMessage := map[string]interface{}{"type": "pong", "stime": now.Unix()}
 			jsonVal, _ := json.Marshal(pongMessage)
-			ws.WriteChan <- jsonVal
+			ws.WriteChan <- WSMessage{Data: jsonVal}
 			continue
 		}
 		ws.ReadChan <- message
@@ -167,7 +185,7 @@ func StartWS(w http.ResponseWriter, r *http.Request) (*WSShell, error) {
 	}
 	ws := WSShell{Conn: conn, ConnId: uuid.New().String(), OpenTime: time.Now()}
 	ws.CloseChan = make(chan bool)
-	ws.WriteChan = make(chan []byte, 10)
+	ws.WriteChan = make(chan WSMessage, 10)
 	ws.ReadChan = make(chan []byte, 10)
 	ws.RemoteAddr = r.RemoteAddr
 	ws.Query = r.URL.Query()